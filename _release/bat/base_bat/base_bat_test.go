@@ -30,6 +30,19 @@ import (
 
 const standardTimeout = time.Second * 300
 
+var traceThresholds bat.TraceThresholds
+
+func init() {
+	flag.Float64Var(&traceThresholds.AverageElapsed, "max-launch-time", 0,
+		"Maximum average instance launch time in seconds, 0 for no limit")
+	flag.Float64Var(&traceThresholds.AverageControllerElapsed, "max-controller-time", 0,
+		"Maximum average time spent in the controller per launch in seconds, 0 for no limit")
+	flag.Float64Var(&traceThresholds.AverageSchedulerElapsed, "max-scheduler-time", 0,
+		"Maximum average time spent in the scheduler per launch in seconds, 0 for no limit")
+	flag.Float64Var(&traceThresholds.AverageLauncherElapsed, "max-launcher-time", 0,
+		"Maximum average time spent in the launcher per launch in seconds, 0 for no limit")
+}
+
 // Verify that stopping and starting an instance affects a node's instance counts
 //
 // Retrieve information about all the nodes in the cluster.  Then start a new instance
@@ -625,6 +638,54 @@ func TestEvacuateRestore(t *testing.T) {
 	}
 }
 
+// Check the latencies of a traced batch of instance launches
+//
+// Launch a batch of instances of a random workload with a unique trace label,
+// wait for all their launches to be traced and retrieve the statistics of the
+// batch.  Delete the instances.
+//
+// The instances should be launched, their launches traced and the average
+// latencies of the controller, scheduler and launcher stages should not exceed
+// the thresholds given on the command line.
+func TestLaunchLatency(t *testing.T) {
+	const instanceCount = 5
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), standardTimeout)
+	defer cancelFunc()
+
+	workloads, err := bat.GetAllWorkloads(ctx, "")
+	if err != nil {
+		t.Fatalf("Unable to retrieve workloads %v", err)
+	}
+
+	if len(workloads) == 0 {
+		t.Fatal("No workloads defined")
+	}
+
+	label := "bat-" + uuid.Generate().String()
+	stats, instances, err := bat.RunTracedLaunch(ctx, "", workloads[0].ID, label, instanceCount)
+
+	defer func() {
+		_, err := bat.DeleteInstances(ctx, "", instances)
+		if err != nil {
+			t.Errorf("Failed to delete instances: %v", err)
+		}
+	}()
+
+	if err != nil {
+		t.Fatalf("Unable to trace instance launches: %v", err)
+	}
+
+	t.Logf("%d launches, average %.3fs: controller %.3fs, scheduler %.3fs, launcher %.3fs",
+		stats.NumInstances, stats.AverageElapsed, stats.AverageControllerElapsed,
+		stats.AverageSchedulerElapsed, stats.AverageLauncherElapsed)
+
+	err = bat.CheckTraceThresholds(stats, traceThresholds)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
 // TestMain ensures that all instances have been deleted when the tests finish.
 // The individual tests do try to clean up after themselves but there's always
 // the chance that a bug somewhere in ciao could lead to something not getting
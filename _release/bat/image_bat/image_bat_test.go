@@ -169,3 +169,34 @@ func TestImageList(t *testing.T) {
 		t.Fatalf("Unable to delete image %v", err)
 	}
 }
+
+// Check image content is preserved
+//
+// TestImageContent adds a new image containing random content, downloads
+// its content and then deletes it.
+//
+// The image is successfully uploaded and downloaded, the checksum of the
+// downloaded content matches the checksum of the uploaded content and the
+// image is destroyed without error.
+func TestImageContent(t *testing.T) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), standardTimeout)
+	defer cancelFunc()
+
+	options := bat.ImageOptions{
+		Name: "test-image-content",
+	}
+	img, err := bat.AddRandomImage(ctx, false, "", 10, &options)
+	if err != nil {
+		t.Fatalf("Unable to add image %v", err)
+	}
+
+	err = bat.VerifyImageContent(ctx, false, "", img.ID, img.Checksum)
+	if err != nil {
+		t.Errorf("Unable to verify image content: %v", err)
+	}
+
+	err = bat.DeleteImage(ctx, false, "", img.ID)
+	if err != nil {
+		t.Fatalf("Unable to delete image %v", err)
+	}
+}
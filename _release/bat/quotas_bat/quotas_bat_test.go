@@ -303,8 +303,8 @@ func TestInstanceUsageAfterDenial(t *testing.T) {
 	defer cleanupQuotas(ctx, t, tenantID, origQuotas)
 
 	instances, err := bat.LaunchInstances(ctx, "", wl.ID, 1)
-	if err == nil {
-		t.Error("Expected instance launch to be denied")
+	if !bat.IsErrorType(err, bat.ErrorQuota) {
+		t.Errorf("Expected instance launch to be denied by quota: %v", err)
 	}
 
 	scheduled, err := bat.WaitForInstancesLaunch(ctx, "", instances, false)
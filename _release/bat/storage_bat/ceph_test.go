@@ -113,7 +113,7 @@ func TestCopyBlockDevice(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	copy, err := driver.CopyBlockDevice(device.ID)
+	copy, err := driver.CopyBlockDevice(device.ID, "")
 	if err != nil {
 		t.Fatal(err)
 	}
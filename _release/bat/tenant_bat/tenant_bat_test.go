@@ -170,7 +170,7 @@ func TestUpdateTenantConfig(t *testing.T) {
 	config.Name = "Updated Tenant"
 	config.SubnetBits = 20
 
-	err = bat.UpdateTenant(ctx, tenant.ID, config)
+	err = bat.UpdateTenantConfig(ctx, tenant.ID, config)
 	if err != nil {
 		t.Fatalf("Failed to update tenant config: %v", err)
 	}
@@ -228,7 +228,7 @@ func TestTenantPermissionsUpdate(t *testing.T) {
 
 	config.Permissions.PrivilegedContainers = true
 
-	err = bat.UpdateTenant(ctx, tenant.ID, config)
+	err = bat.UpdateTenantConfig(ctx, tenant.ID, config)
 	if err != nil {
 		t.Fatalf("Failed to update tenant: %v", err)
 	}
@@ -244,7 +244,7 @@ func TestTenantPermissionsUpdate(t *testing.T) {
 
 	config.Permissions.PrivilegedContainers = false
 
-	err = bat.UpdateTenant(ctx, tenant.ID, config)
+	err = bat.UpdateTenantConfig(ctx, tenant.ID, config)
 	if err != nil {
 		t.Fatalf("Failed to update tenant: %v", err)
 	}
@@ -258,3 +258,45 @@ func TestTenantPermissionsUpdate(t *testing.T) {
 		t.Fatalf("Failed to retrieve tenant config")
 	}
 }
+
+// Create a tenant and list its quotas as the admin user.
+//
+// TestTenantQuotas creates a new tenant and calls ciao list quotas for it.
+//
+// The test passes if the quotas of the new tenant can be retrieved and
+// report no usage.
+func TestTenantQuotas(t *testing.T) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), standardTimeout)
+	defer cancelFunc()
+
+	config := bat.TenantConfig{
+		Name: "TestTenantQuotas",
+	}
+
+	tenant, err := bat.CreateTenant(ctx, config)
+	if err != nil {
+		t.Fatalf("Failed to create tenant : %v", err)
+	}
+
+	defer func() {
+		err = bat.DeleteTenant(ctx, tenant.ID)
+		if err != nil {
+			t.Fatalf("Failed to delete tenant: %v", err)
+		}
+	}()
+
+	qds, err := bat.ListTenantQuotas(ctx, tenant.ID)
+	if err != nil {
+		t.Fatalf("Failed to list quotas: %v", err)
+	}
+
+	if len(qds) == 0 {
+		t.Fatalf("No quotas returned for tenant %s", tenant.ID)
+	}
+
+	for _, qd := range qds {
+		if qd.Usage != "" && qd.Usage != "0" {
+			t.Errorf("Unexpected usage of %s by new tenant: %s", qd.Name, qd.Usage)
+		}
+	}
+}
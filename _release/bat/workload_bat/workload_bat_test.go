@@ -525,13 +525,13 @@ func TestPriviligedWorkload(t *testing.T) {
 	cfg := oldcfg
 	cfg.Permissions.PrivilegedContainers = true
 
-	err = bat.UpdateTenant(ctx, tenants[0].ID, cfg)
+	err = bat.UpdateTenantConfig(ctx, tenants[0].ID, cfg)
 	if err != nil {
 		t.Fatalf("Failed to update tenant: %v", err)
 	}
 
 	defer func() {
-		err := bat.UpdateTenant(ctx, tenants[0].ID, oldcfg)
+		err := bat.UpdateTenantConfig(ctx, tenants[0].ID, oldcfg)
 		if err != nil {
 			t.Fatalf("Failed to update tenant: %v", err)
 		}
@@ -17,8 +17,17 @@
 package bat
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 var instances = []string{
@@ -88,6 +97,174 @@ func TestMissingCheckStatuses(t *testing.T) {
 	}
 }
 
+func TestCheckInstancesStatus(t *testing.T) {
+	statuses := map[string]string{
+		"d258443c-72c7-4971-8c2b-cb9925522c3e": "exited",
+		"64a0cca9-85a2-4733-988b-b4fe9a72dd0e": "active",
+	}
+
+	finished, err := checkInstancesStatus(instances, statuses, "exited")
+	if finished || err != nil {
+		t.Errorf("Instances should not all be exited, finished=%v err=%v", finished, err)
+	}
+
+	statuses["64a0cca9-85a2-4733-988b-b4fe9a72dd0e"] = "exited"
+	finished, err = checkInstancesStatus(instances, statuses, "exited")
+	if !finished || err != nil {
+		t.Errorf("Instances should all be exited, finished=%v err=%v", finished, err)
+	}
+
+	statuses["64a0cca9-85a2-4733-988b-b4fe9a72dd0e"] = "hung"
+	_, err = checkInstancesStatus(instances, statuses, "exited")
+	if err == nil {
+		t.Errorf("Hung instance should fail the wait")
+	}
+
+	finished, err = checkInstancesStatus(instances[1:], statuses, "hung")
+	if !finished || err != nil {
+		t.Errorf("Waiting for hung instance failed, finished=%v err=%v", finished, err)
+	}
+
+	delete(statuses, "d258443c-72c7-4971-8c2b-cb9925522c3e")
+	_, err = checkInstancesStatus(instances, statuses, "exited")
+	if err == nil {
+		t.Errorf("Missing instance should fail the wait")
+	}
+}
+
+func TestCheckVolumeStatus(t *testing.T) {
+	vol := &Volume{
+		ID:     "7b2d2b5b-8f3c-4d5e-9a1b-2c3d4e5f6a7b",
+		Status: "attaching",
+	}
+
+	finished, err := checkVolumeStatus(vol, "in-use")
+	if finished || err != nil {
+		t.Errorf("Attaching volume should not be in use, finished=%v err=%v", finished, err)
+	}
+
+	vol.Status = "in-use"
+	finished, err = checkVolumeStatus(vol, "in-use")
+	if !finished || err != nil {
+		t.Errorf("Volume should be in use, finished=%v err=%v", finished, err)
+	}
+
+	vol.Status = "error"
+	_, err = checkVolumeStatus(vol, "available")
+	if err == nil {
+		t.Errorf("Volume in error should fail the wait")
+	}
+
+	finished, err = checkVolumeStatus(vol, "error")
+	if !finished || err != nil {
+		t.Errorf("Waiting for volume in error failed, finished=%v err=%v", finished, err)
+	}
+}
+
+func TestVolumeCreateArgs(t *testing.T) {
+	opts := &VolumeOptions{
+		Size: 10,
+		Name: "data",
+	}
+
+	computedArgs := computeVolumeCreateArgs("e6f7c4d3-6e1d-4a5b-8c9d-0a1b2c3d4e5f", "image", opts)
+	expectedArgs := []string{"create", "volume", "-f", "{{ tojson . }}",
+		"--source-type", "image",
+		"--source", "e6f7c4d3-6e1d-4a5b-8c9d-0a1b2c3d4e5f",
+		"--name", "data",
+		"--size", "10",
+	}
+
+	if !reflect.DeepEqual(computedArgs, expectedArgs) {
+		t.Fatalf("Expected %v, got %v", expectedArgs, computedArgs)
+	}
+}
+
+func TestFindExternalIPs(t *testing.T) {
+	pools := []*ExternalIPPool{
+		{ID: "5f9a2a4e-0d3b-4c1a-9a6e-6a3e0e1b2c3d", Name: "public", Free: 2, TotalIPs: 4},
+		{ID: "0b4e7c2d-1f3a-4e5b-8c6d-7e8f9a0b1c2d", Name: "private", Free: 1, TotalIPs: 1},
+	}
+
+	if p := findExternalIPPool(pools, "private"); p != pools[1] {
+		t.Errorf("Pool private not found by name, got %v", p)
+	}
+
+	if p := findExternalIPPool(pools, pools[0].ID); p != pools[0] {
+		t.Errorf("Pool public not found by ID, got %v", p)
+	}
+
+	if p := findExternalIPPool(pools, "missing"); p != nil {
+		t.Errorf("Unexpected pool found %v", p)
+	}
+
+	ips := []*ExternalIP{
+		{ExternalIP: "203.0.113.10", InstanceID: "instance-1", PoolName: "public"},
+		{ExternalIP: "203.0.113.11", InstanceID: "instance-2", PoolName: "public"},
+	}
+
+	if ip := findExternalIP(ips, "instance-2"); ip != ips[1] {
+		t.Errorf("External ip of instance-2 not found, got %v", ip)
+	}
+
+	if ip := findExternalIP(ips, "instance-3"); ip != nil {
+		t.Errorf("Unexpected external ip found %v", ip)
+	}
+}
+
+func TestLaunchParallel(t *testing.T) {
+	var lock sync.Mutex
+	running, maxRunning, calls := 0, 0, 0
+
+	launch := func(ctx context.Context) ([]string, error) {
+		lock.Lock()
+		calls++
+		call := calls
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		lock.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		lock.Lock()
+		running--
+		lock.Unlock()
+
+		if call%4 == 0 {
+			return nil, errors.New("Launch failed")
+		}
+		return []string{fmt.Sprintf("instance-%d", call)}, nil
+	}
+
+	launched, err := launchParallel(context.Background(), 12, 3, launch)
+	if err == nil {
+		t.Errorf("Failed launches not reported")
+	}
+
+	if len(launched) != 9 || calls != 12 {
+		t.Errorf("Expected 9 instances from 12 launches, got %d from %d", len(launched), calls)
+	}
+
+	if maxRunning > 3 {
+		t.Errorf("Expected at most 3 concurrent launches, got %d", maxRunning)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = launchParallel(ctx, 100, 1, func(ctx context.Context) ([]string, error) {
+		return nil, ctx.Err()
+	})
+	if err == nil {
+		t.Errorf("Cancelled launches not reported")
+	}
+
+	if _, err := launchParallel(context.Background(), 1, 0, launch); err == nil {
+		t.Errorf("Zero concurrency not rejected")
+	}
+}
+
 func TestImageOptions(t *testing.T) {
 	opts := &ImageOptions{
 		ID:         "test-id",
@@ -105,3 +282,202 @@ func TestImageOptions(t *testing.T) {
 		t.Fatalf("Compute image arguments are incorrect: %s vs %s", computedArgs, expectedArgs)
 	}
 }
+
+func TestFileChecksum(t *testing.T) {
+	f, err := ioutil.TempFile("", "bat-checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	_, err = f.WriteString("image data")
+	_ = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checksum, err := fileChecksum(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "sha256:b41b86dcfdc6219bc2fb987591ad9995bcf3a1e40c2bdd3fdbec622371e6e1af"
+	if checksum != expected {
+		t.Fatalf("Wrong checksum: expected %s got %s", expected, checksum)
+	}
+}
+
+func TestCommandErrors(t *testing.T) {
+	tests := []struct {
+		stderr  string
+		errType ErrorType
+		status  int
+		message string
+	}{
+		{
+			`Error: Error creating instances: HTTP Error [500] for [POST https://controller:8889/t/instances]: {"error":{"code":500,"name":"Internal Server Error","message":"Over quota"}}`,
+			ErrorQuota, 500, "Over quota",
+		},
+		{
+			`Error: Error creating tenant: HTTP Error [403] for [POST https://controller:8889/tenants]: {"error":{"code":403,"name":"Forbidden","message":"Invalid Request"}}`,
+			ErrorForbidden, 403, "Invalid Request",
+		},
+		{
+			`Error: HTTP Error [404] for [GET https://controller:8889/t/instances/x]: 404 page not found`,
+			ErrorNotFound, 404, "404 page not found",
+		},
+		{
+			`Error: Error deleting tenant: HTTP response code from https://controller:8889/tenants/x not as expected: 401 Unauthorized`,
+			ErrorPermission, 401, "",
+		},
+		{
+			`Error: Creating tenants is restricted to privileged users`,
+			ErrorPermission, 0, "Creating tenants is restricted to privileged users",
+		},
+		{
+			`Error: unknown flag: --bogus`,
+			ErrorInvalidInput, 0, "unknown flag: --bogus",
+		},
+		{
+			`Error: No instance named web found`,
+			ErrorNotFound, 0, "No instance named web found",
+		},
+		{
+			`Error: Invalid instance count`,
+			ErrorUnknown, 0, "Invalid instance count",
+		},
+	}
+
+	for _, tt := range tests {
+		err := newCommandError([]string{"test"}, nil, "", tt.stderr)
+		if err.Type != tt.errType {
+			t.Errorf("Expected type %s for %q, got %s", tt.errType, tt.stderr, err.Type)
+		}
+		if err.StatusCode != tt.status {
+			t.Errorf("Expected status %d for %q, got %d", tt.status, tt.stderr, err.StatusCode)
+		}
+		if tt.message != "" && err.Message != tt.message {
+			t.Errorf("Expected message %q for %q, got %q", tt.message, tt.stderr, err.Message)
+		}
+		if !IsErrorType(err, tt.errType) {
+			t.Errorf("IsErrorType failed for %q", tt.stderr)
+		}
+	}
+}
+
+func TestIsError(t *testing.T) {
+	tests := []struct {
+		stderr  string
+		matches []error
+	}{
+		{
+			`Error: Error creating instances: HTTP Error [500] for [POST https://controller:8889/t/instances]: {"error":{"code":500,"name":"Internal Server Error","message":"Over quota"}}`,
+			[]error{ErrQuotaExceeded},
+		},
+		{
+			`Error: Error getting instance: HTTP Error [404] for [GET https://controller:8889/t/instances/x]: {"error":{"code":404,"name":"Not Found","message":"Instance not found"}}`,
+			[]error{ErrNotFound, ErrInstanceNotFound},
+		},
+		{
+			`Error: No volume named data found`,
+			[]error{ErrNotFound, ErrVolumeNotFound},
+		},
+		{
+			`Error: Error attaching external IP: HTTP Error [404] for [POST https://controller:8889/t/external-ips]: {"error":{"code":404,"name":"Not Found","message":"Pool not found"}}`,
+			[]error{ErrNotFound, ErrPoolNotFound},
+		},
+		{
+			`Error: Creating tenants is restricted to privileged users`,
+			[]error{ErrPermissionDenied},
+		},
+	}
+
+	all := []error{ErrInvalidInput, ErrPermissionDenied, ErrQuotaExceeded, ErrForbidden,
+		ErrNotFound, ErrServer, ErrInstanceNotFound, ErrVolumeNotFound,
+		ErrWorkloadNotFound, ErrImageNotFound, ErrTenantNotFound, ErrPoolNotFound}
+
+	for _, tt := range tests {
+		err := errors.Wrap(newCommandError([]string{"test"}, nil, "", tt.stderr), "wrapped")
+
+		for _, target := range all {
+			expected := false
+			for _, m := range tt.matches {
+				expected = expected || m == target
+			}
+
+			if IsError(err, target) != expected {
+				t.Errorf("Expected IsError(%v) to be %t for %q", target, expected, tt.stderr)
+			}
+		}
+	}
+
+	if !IsError(errors.Wrap(ErrNotFound, "wrapped"), ErrNotFound) {
+		t.Errorf("IsError does not match the cause of the error")
+	}
+}
+
+func TestCommandErrorOutput(t *testing.T) {
+	args := []string{"-c", "echo output; echo failure >&2; exit 3"}
+	cmd := exec.Command("sh", args...)
+	data, err := cmd.Output()
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		t.Fatalf("Expected command to fail, got %v", err)
+	}
+
+	cmdErr := newCommandError(args, err, string(data), string(exitErr.Stderr))
+	if cmdErr.ExitCode != 3 || cmdErr.Stdout != "output\n" || cmdErr.Stderr != "failure\n" {
+		t.Errorf("Unexpected command error %+v", cmdErr)
+	}
+}
+
+func TestExpectFailure(t *testing.T) {
+	args := []string{"create", "instance"}
+	cmdErr := newCommandError(args, nil, "", "Error: Invalid instance count")
+
+	if _, err := expectFailure(args, nil, ""); err == nil {
+		t.Error("Expected failure of successful command to be reported")
+	}
+
+	if _, err := expectFailure(args, cmdErr, "Invalid instance count"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if _, err := expectFailure(args, cmdErr, "Invalid name"); err == nil {
+		t.Error("Expected missing error message to be reported")
+	}
+}
+
+func TestCheckTraceThresholds(t *testing.T) {
+	stats := &BatchFrameStat{
+		NumInstances:             2,
+		AverageElapsed:           3.5,
+		AverageControllerElapsed: 0.2,
+		AverageSchedulerElapsed:  0.01,
+		AverageLauncherElapsed:   3.2,
+	}
+
+	if err := CheckTraceThresholds(stats, TraceThresholds{}); err != nil {
+		t.Errorf("Unexpected error without thresholds: %v", err)
+	}
+
+	thresholds := TraceThresholds{
+		AverageElapsed:          5,
+		AverageSchedulerElapsed: 0.05,
+	}
+	if err := CheckTraceThresholds(stats, thresholds); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	thresholds.AverageControllerElapsed = 0.1
+	thresholds.AverageLauncherElapsed = 3
+	err := CheckTraceThresholds(stats, thresholds)
+	if err == nil {
+		t.Fatal("Expected exceeded thresholds to be reported")
+	}
+
+	expected := "Average latencies exceeded: controller 0.200s > 0.100s, launcher 3.200s > 3.000s"
+	if err.Error() != expected {
+		t.Errorf("Expected %q, got %q", expected, err.Error())
+	}
+}
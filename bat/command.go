@@ -40,7 +40,7 @@ func checkEnv(vars []string) error {
 // process will be killed if the context is Done. An error will be returned if
 // the following environment variables are not set; CIAO_CLIENT_CERT_FILE,
 // CIAO_CONTROLLER. On success the data written to ciao on stdout will be
-// returned.
+// returned.  If the ciao command fails a *CommandError is returned.
 func RunCIAOCmd(ctx context.Context, tenant string, args []string) ([]byte, error) {
 	vars := []string{"CIAO_CLIENT_CERT_FILE", "CIAO_CONTROLLER"}
 	if err := checkEnv(vars); err != nil {
@@ -58,12 +58,10 @@ func RunCIAOCmd(ctx context.Context, tenant string, args []string) ([]byte, erro
 
 	data, err := cmd.Output()
 	if err != nil {
-		var failureText string
-		if err, ok := err.(*exec.ExitError); ok {
-			failureText = string(err.Stderr)
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, newCommandError(args, err, string(data), string(exitErr.Stderr))
 		}
-		return nil, fmt.Errorf("failed to launch ciao %v : %v\n%s",
-			args, err, failureText)
+		return nil, fmt.Errorf("failed to launch ciao %v : %v", args, err)
 	}
 
 	return data, nil
@@ -91,7 +89,8 @@ func RunCIAOCmdJS(ctx context.Context, tenant string, args []string, jsdata inte
 // provided arguments. The ciao process will be killed if the context is
 // Done. An error will be returned if the following environment variables are
 // not set; CIAO_ADMIN_CLIENT_CERT_FILE, CIAO_CONTROLLER. On success the data
-// written to ciao on stdout will be returned.
+// written to ciao on stdout will be returned.  If the ciao command fails a
+// *CommandError is returned.
 func RunCIAOCmdAsAdmin(ctx context.Context, tenant string, args []string) ([]byte, error) {
 	vars := []string{"CIAO_ADMIN_CLIENT_CERT_FILE", "CIAO_CONTROLLER"}
 	if err := checkEnv(vars); err != nil {
@@ -116,12 +115,10 @@ func RunCIAOCmdAsAdmin(ctx context.Context, tenant string, args []string) ([]byt
 	cmd.Env = envCopy
 	data, err := cmd.Output()
 	if err != nil {
-		var failureText string
-		if err, ok := err.(*exec.ExitError); ok {
-			failureText = string(err.Stderr)
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, newCommandError(args, err, string(data), string(exitErr.Stderr))
 		}
-		return nil, fmt.Errorf("failed to launch ciao %v : %v\n%v",
-			args, err, failureText)
+		return nil, fmt.Errorf("failed to launch ciao %v : %v", args, err)
 	}
 
 	return data, nil
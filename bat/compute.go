@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"sync"
 	"time"
 )
 
@@ -409,6 +410,74 @@ func WaitForInstancesLaunch(ctx context.Context, tenant string, instances []stri
 	}
 }
 
+// instanceFailureStatuses are the statuses of the instances which are not
+// expected to change on their own.
+var instanceFailureStatuses = map[string]bool{
+	"exit_failed": true,
+	"hung":        true,
+	"missing":     true,
+	"deleted":     true,
+}
+
+func checkInstancesStatus(instances []string, statuses map[string]string,
+	status string) (bool, error) {
+
+	finished := true
+	for _, instance := range instances {
+		s, ok := statuses[instance]
+		if !ok {
+			return false, fmt.Errorf("Instance %s does not exist", instance)
+		}
+
+		if s == status {
+			continue
+		}
+
+		if instanceFailureStatuses[s] {
+			return false, fmt.Errorf("Instance %s is %s", instance, s)
+		}
+
+		finished = false
+	}
+
+	return finished, nil
+}
+
+// WaitForInstancesStatus polls the statuses of a slice of instances until
+// they all reach the given status, e.g., active or exited. The function
+// fails early if one of the instances does not exist or enters a status it
+// is not expected to leave on its own, such as hung or missing. It also
+// fails if the instances have not reached the status after timeout. An
+// error will be returned if the following environment variables are not
+// set; CIAO_CLIENT_CERT_FILE, CIAO_CONTROLLER.
+func WaitForInstancesStatus(ctx context.Context, tenant string, instances []string,
+	status string, timeout time.Duration) error {
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		statuses, err := RetrieveInstancesStatuses(ctx, tenant)
+		if err != nil {
+			return err
+		}
+
+		finished, err := checkInstancesStatus(instances, statuses, status)
+		if finished || err != nil {
+			return err
+		}
+
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return fmt.Errorf("Timed out waiting for instances to be %s", status)
+			}
+			return ctx.Err()
+		}
+	}
+}
+
 // LaunchInstances launches num instances of the specified workload. On success
 // the function returns a slice of UUIDs of the successfully launched instances.
 // If some instances failed to start then the error can be found in the event
@@ -435,6 +504,80 @@ func LaunchInstances(ctx context.Context, tenant string, workload string, num in
 	return instances, nil
 }
 
+// launchParallel calls launch count times from at most concurrency
+// goroutines and returns the instances launched. The launches not started
+// when the context is cancelled fail with the error of the context.
+func launchParallel(ctx context.Context, count int, concurrency int,
+	launch func(context.Context) ([]string, error)) ([]string, error) {
+
+	if count <= 0 || concurrency <= 0 {
+		return nil, fmt.Errorf("Invalid instance count %d or concurrency %d", count, concurrency)
+	}
+
+	if concurrency > count {
+		concurrency = count
+	}
+
+	results := make([][]string, count)
+	errs := make([]error, count)
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], errs[i] = launch(ctx)
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	var launched []string
+	var firstErr error
+	failed := 0
+	for i := range results {
+		launched = append(launched, results[i]...)
+		if errs[i] != nil {
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return launched, fmt.Errorf("%d of %d instance launches failed: %v", failed, count, firstErr)
+	}
+
+	return launched, nil
+}
+
+// LaunchInstancesParallel launches count instances of the specified workload
+// one at a time, from at most concurrency concurrent invocations of ciao
+// create instance. It returns the UUIDs of all the instances launched, even
+// when some of the launches failed, in which case an error reporting the
+// number of failures and the first of them is also returned, so that the
+// caller can clean up. An error will be returned if the following
+// environment variables are not set; CIAO_CLIENT_CERT_FILE, CIAO_CONTROLLER.
+func LaunchInstancesParallel(ctx context.Context, tenant string, workload string,
+	count int, concurrency int) ([]string, error) {
+
+	return launchParallel(ctx, count, concurrency, func(ctx context.Context) ([]string, error) {
+		return LaunchInstances(ctx, tenant, workload, 1)
+	})
+}
+
 // StartRandomInstances starts a specified number of instances using a random
 // workload. The UUIDs of the started instances are returned to the user. An
 // error will be returned if the following environment variables are not set;
@@ -0,0 +1,336 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package bat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// ErrorType categorises the reason a ciao command failed.  ErrorTypes are
+// errors themselves, so that the failures of ciao commands can be compared
+// to them with IsError.
+type ErrorType string
+
+const (
+	// ErrorUnknown is used when the reason for the failure cannot be
+	// determined from the output of the ciao command.
+	ErrorUnknown ErrorType = "unknown"
+
+	// ErrorInvalidInput is used when the arguments passed to the ciao
+	// command or the request sent to the controller were rejected.
+	ErrorInvalidInput ErrorType = "invalid input"
+
+	// ErrorPermission is used when the user is not allowed to perform
+	// the requested operation.
+	ErrorPermission ErrorType = "permission denied"
+
+	// ErrorQuota is used when the operation would exceed a tenant quota.
+	ErrorQuota ErrorType = "quota exceeded"
+
+	// ErrorForbidden is used when the controller refuses the operation
+	// for any other reason.
+	ErrorForbidden ErrorType = "forbidden"
+
+	// ErrorNotFound is used when the resource the command operates on
+	// does not exist.
+	ErrorNotFound ErrorType = "not found"
+
+	// ErrorServer is used when the controller fails to process the
+	// request.
+	ErrorServer ErrorType = "server error"
+)
+
+func (t ErrorType) Error() string {
+	return string(t)
+}
+
+// NotFoundError is an ErrorNotFound failure restricted to a given type of
+// resource.  It matches, with IsError, the failures of the ciao commands
+// reporting that such a resource does not exist.
+type NotFoundError string
+
+// Errors matching the failures of ciao commands operating on resources
+// which do not exist.
+const (
+	// ErrInstanceNotFound matches the failures reporting that an
+	// instance does not exist
+	ErrInstanceNotFound NotFoundError = "instance"
+
+	// ErrVolumeNotFound matches the failures reporting that a volume
+	// does not exist
+	ErrVolumeNotFound NotFoundError = "volume"
+
+	// ErrWorkloadNotFound matches the failures reporting that a
+	// workload does not exist
+	ErrWorkloadNotFound NotFoundError = "workload"
+
+	// ErrImageNotFound matches the failures reporting that an image
+	// does not exist
+	ErrImageNotFound NotFoundError = "image"
+
+	// ErrTenantNotFound matches the failures reporting that a tenant
+	// does not exist
+	ErrTenantNotFound NotFoundError = "tenant"
+
+	// ErrPoolNotFound matches the failures reporting that an external
+	// IP pool does not exist
+	ErrPoolNotFound NotFoundError = "pool"
+)
+
+func (r NotFoundError) Error() string {
+	return string(r) + " not found"
+}
+
+// Aliases of the ErrorTypes, named after the failures they match.
+const (
+	// ErrInvalidInput is ErrorInvalidInput
+	ErrInvalidInput = ErrorInvalidInput
+
+	// ErrPermissionDenied is ErrorPermission
+	ErrPermissionDenied = ErrorPermission
+
+	// ErrQuotaExceeded is ErrorQuota
+	ErrQuotaExceeded = ErrorQuota
+
+	// ErrForbidden is ErrorForbidden
+	ErrForbidden = ErrorForbidden
+
+	// ErrNotFound is ErrorNotFound
+	ErrNotFound = ErrorNotFound
+
+	// ErrServer is ErrorServer
+	ErrServer = ErrorServer
+)
+
+// CommandError is returned by RunCIAOCmd and RunCIAOCmdAsAdmin when the
+// ciao command exits with an error.  It contains the output and exit code of
+// the ciao command and the details of the failure parsed from its standard
+// error.
+type CommandError struct {
+	// Args are the arguments passed to the ciao command
+	Args []string
+
+	// Type is the category of the failure
+	Type ErrorType
+
+	// StatusCode is the HTTP status returned by the controller, or 0 if
+	// the command failed before a response was received
+	StatusCode int
+
+	// Message is the error message returned by the controller, or the
+	// error printed by the ciao command if no response was received
+	Message string
+
+	// Stdout is the raw output written by the ciao command to stdout
+	Stdout string
+
+	// Stderr is the raw output written by the ciao command to stderr
+	Stderr string
+
+	// ExitCode is the exit code of the ciao command, or -1 if it was
+	// killed
+	ExitCode int
+
+	err error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("failed to launch ciao %v : %v\n%s", e.Args, e.err, e.Stderr)
+}
+
+var (
+	httpErrorRegexp  = regexp.MustCompile(`HTTP Error \[(\d+)\] for \[[^\]]*\]: (.*)`)
+	httpStatusRegexp = regexp.MustCompile(`(?:HTTP Error|not as expected): (\d{3})`)
+)
+
+// cobra reports errors in the command line with these messages.
+var usageErrors = []string{
+	"unknown command",
+	"unknown flag",
+	"unknown shorthand flag",
+	"invalid argument",
+	"flag needs an argument",
+	"arg(s)",
+}
+
+// Is returns true if target is the ErrorType of the failure of the ciao
+// command, or a NotFoundError for the resource it did not find.
+func (e *CommandError) Is(target error) bool {
+	switch target := target.(type) {
+	case ErrorType:
+		return target == e.Type
+	case NotFoundError:
+		return e.Type == ErrorNotFound &&
+			strings.Contains(strings.ToLower(e.Message), string(target))
+	}
+	return false
+}
+
+func newCommandError(args []string, err error, stdout string, stderr string) *CommandError {
+	cmdErr := &CommandError{
+		Args:    args,
+		Type:    ErrorUnknown,
+		Message: strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(stderr), "Error:")),
+		Stdout:  stdout,
+		Stderr:  stderr,
+		err:     err,
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			cmdErr.ExitCode = status.ExitStatus()
+		}
+	}
+
+	if m := httpErrorRegexp.FindStringSubmatch(stderr); m != nil {
+		cmdErr.StatusCode, _ = strconv.Atoi(m[1])
+
+		var body struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal([]byte(m[2]), &body) == nil && body.Error.Message != "" {
+			cmdErr.Message = body.Error.Message
+		} else {
+			cmdErr.Message = strings.TrimSpace(m[2])
+		}
+	} else if m := httpStatusRegexp.FindStringSubmatch(stderr); m != nil {
+		cmdErr.StatusCode, _ = strconv.Atoi(m[1])
+	}
+
+	cmdErr.Type = classifyError(cmdErr.StatusCode, cmdErr.Message)
+
+	return cmdErr
+}
+
+func classifyError(status int, message string) ErrorType {
+	lower := strings.ToLower(message)
+
+	// quota violations are not always reported with the same status.
+	switch {
+	case status != 0 && strings.Contains(lower, "over quota"):
+		return ErrorQuota
+	case status == http.StatusBadRequest:
+		return ErrorInvalidInput
+	case status == http.StatusUnauthorized:
+		return ErrorPermission
+	case status == http.StatusForbidden:
+		return ErrorForbidden
+	case status == http.StatusNotFound:
+		return ErrorNotFound
+	case status >= http.StatusInternalServerError:
+		return ErrorServer
+	case status != 0:
+		return ErrorUnknown
+	}
+
+	if strings.Contains(lower, "restricted to privileged users") ||
+		strings.Contains(lower, "permission denied") {
+		return ErrorPermission
+	}
+
+	for _, s := range usageErrors {
+		if strings.Contains(lower, s) {
+			return ErrorInvalidInput
+		}
+	}
+
+	// the ciao command fails resolving the names of missing resources.
+	if strings.HasPrefix(lower, "no ") && strings.Contains(lower, " named ") {
+		return ErrorNotFound
+	}
+
+	return ErrorUnknown
+}
+
+// GetCommandError returns the CommandError from an error returned by
+// any of the functions in this package, or nil if the error was not caused
+// by a failing ciao command.
+func GetCommandError(err error) *CommandError {
+	cmdErr, ok := errors.Cause(err).(*CommandError)
+	if !ok {
+		return nil
+	}
+	return cmdErr
+}
+
+// IsErrorType returns true if err was caused by a ciao command failing
+// with the given type of error.  It is equivalent to IsError(err, errType).
+func IsErrorType(err error, errType ErrorType) bool {
+	return IsError(err, errType)
+}
+
+// IsError returns true if err is target, or was caused by a ciao command
+// failing with an error matched by target, i.e., its ErrorType, such as
+// ErrorQuota, or a NotFoundError, such as ErrInstanceNotFound.
+func IsError(err error, target error) bool {
+	cause := errors.Cause(err)
+	if cause == target {
+		return true
+	}
+
+	cmdErr, ok := cause.(*CommandError)
+	return ok && cmdErr.Is(target)
+}
+
+func expectFailure(args []string, err error, expected string) (*CommandError, error) {
+	if err == nil {
+		return nil, fmt.Errorf("ciao %v succeeded but was expected to fail", args)
+	}
+
+	cmdErr := GetCommandError(err)
+	if cmdErr == nil {
+		return nil, errors.Wrapf(err, "unable to run ciao %v", args)
+	}
+
+	if !strings.Contains(cmdErr.Stderr, expected) {
+		return cmdErr, fmt.Errorf("ciao %v failed without reporting %q: %s",
+			args, expected, cmdErr.Stderr)
+	}
+
+	return cmdErr, nil
+}
+
+// ExpectCommandFailure runs the ciao command with the provided arguments
+// and checks that it fails, writing expected to stderr.  If expected is
+// empty any failure will do.  The details of the failure are returned so
+// that the caller can check its type.  An error is returned if the command
+// succeeds, cannot be run, or does not report the expected error.
+func ExpectCommandFailure(ctx context.Context, tenant string, args []string,
+	expected string) (*CommandError, error) {
+	_, err := RunCIAOCmd(ctx, tenant, args)
+	return expectFailure(args, err, expected)
+}
+
+// ExpectCommandFailureAsAdmin is similar to ExpectCommandFailure with the
+// exception that the ciao command is run as the admin user.
+func ExpectCommandFailureAsAdmin(ctx context.Context, tenant string, args []string,
+	expected string) (*CommandError, error) {
+	_, err := RunCIAOCmdAsAdmin(ctx, tenant, args)
+	return expectFailure(args, err, expected)
+}
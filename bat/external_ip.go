@@ -16,17 +16,31 @@
 
 package bat
 
-import "context"
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
 
 // ExternalIP contains information about a single external ip.
 type ExternalIP struct {
+	MappingID  string `json:"mapping_id"`
 	ExternalIP string `json:"external_ip"`
 	InternalIP string `json:"internal_ip"`
 	InstanceID string `json:"instance_id"`
 	TenantID   string `json:"tenant_id"`
+	PoolID     string `json:"pool_id"`
 	PoolName   string `json:"pool_name"`
 }
 
+// ExternalIPPool contains summary information about an external ip pool.
+type ExternalIPPool struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Free     int    `json:"free"`
+	TotalIPs int    `json:"total_ips"`
+}
+
 // CreateExternalIPPool creates a new pool for external ips. The pool is created
 // using the ciao create pool command. An error will be returned if the
 // following environment variables are not set; CIAO_ADMIN_CLIENT_CERT_FILE,
@@ -47,6 +61,36 @@ func AddExternalIPToPool(ctx context.Context, tenant, name, ip string) error {
 	return err
 }
 
+// AddExternalIPsToPool adds one or more external ips to an existing pool. The
+// addresses are added using the ciao add external-ip command. An error will be
+// returned if the following environment variables are not set;
+// CIAO_ADMIN_CLIENT_CERT_FILE, CIAO_CONTROLLER.
+func AddExternalIPsToPool(ctx context.Context, tenant, name string, ips []string) error {
+	args := append([]string{"add", "external-ip", name}, ips...)
+	_, err := RunCIAOCmdAsAdmin(ctx, tenant, args)
+	return err
+}
+
+// AddExternalIPSubnetToPool adds all the addresses of a subnet, specified in
+// CIDR format, to an existing pool. The subnet is added using the ciao add
+// external-ip command. An error will be returned if the following environment
+// variables are not set; CIAO_ADMIN_CLIENT_CERT_FILE, CIAO_CONTROLLER.
+func AddExternalIPSubnetToPool(ctx context.Context, tenant, name, subnet string) error {
+	args := []string{"add", "external-ip", name, subnet}
+	_, err := RunCIAOCmdAsAdmin(ctx, tenant, args)
+	return err
+}
+
+// RemoveExternalIPFromPool removes an external ip, or a subnet specified in
+// CIDR format, from a pool. The address is removed using the ciao remove
+// external-ip command. An error will be returned if the following environment
+// variables are not set; CIAO_ADMIN_CLIENT_CERT_FILE, CIAO_CONTROLLER.
+func RemoveExternalIPFromPool(ctx context.Context, tenant, name, address string) error {
+	args := []string{"remove", "external-ip", name, address}
+	_, err := RunCIAOCmdAsAdmin(ctx, tenant, args)
+	return err
+}
+
 // MapExternalIP maps an external ip from a given pool to an instance. The
 // address is mapped using the ciao attach external-ip command. An error will
 // be returned if the following environment variables are not set;
@@ -57,6 +101,20 @@ func MapExternalIP(ctx context.Context, tenant, pool, instance string) error {
 	return err
 }
 
+// MapExternalIPAndGet maps an external ip from a given pool to an instance and
+// returns the new mapping. The address is mapped using the ciao attach
+// external-ip command. An error will be returned if the following environment
+// variables are not set; CIAO_CLIENT_CERT_FILE, CIAO_ADMIN_CLIENT_CERT_FILE,
+// CIAO_CONTROLLER.
+func MapExternalIPAndGet(ctx context.Context, tenant, pool, instance string) (*ExternalIP, error) {
+	err := MapExternalIP(ctx, tenant, pool, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetInstanceExternalIP(ctx, tenant, instance)
+}
+
 // UnmapExternalIP unmaps an external ip from an instance. The address is
 // unmapped using the ciao detach external-ip command. An error will be
 // returned if the following environment variables are not set;
@@ -92,3 +150,98 @@ func ListExternalIPs(ctx context.Context, tenant string) ([]*ExternalIP, error)
 
 	return externalIPs, nil
 }
+
+func findExternalIP(externalIPs []*ExternalIP, instance string) *ExternalIP {
+	for _, ip := range externalIPs {
+		if ip.InstanceID == instance {
+			return ip
+		}
+	}
+
+	return nil
+}
+
+// GetInstanceExternalIP returns the external ip mapped to an instance. The
+// information is retrieved using the ciao list external-ips command. An error
+// will be returned if the following environment variables are not set;
+// CIAO_ADMIN_CLIENT_CERT_FILE, CIAO_CONTROLLER.
+func GetInstanceExternalIP(ctx context.Context, tenant, instance string) (*ExternalIP, error) {
+	externalIPs, err := ListExternalIPs(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	ip := findExternalIP(externalIPs, instance)
+	if ip == nil {
+		return nil, errors.Wrapf(ErrNotFound, "No external ip mapped to instance %s", instance)
+	}
+
+	return ip, nil
+}
+
+// ListExternalIPPools returns summary information about all the external ip
+// pools. The information is retrieved using the ciao list pools command. An
+// error will be returned if the following environment variables are not set;
+// CIAO_ADMIN_CLIENT_CERT_FILE, CIAO_CONTROLLER.
+func ListExternalIPPools(ctx context.Context, tenant string) ([]*ExternalIPPool, error) {
+	var pools []*ExternalIPPool
+	args := []string{"list", "pools", "-f", "{{tojson .}}"}
+	err := RunCIAOCmdAsAdminJS(ctx, tenant, args, &pools)
+	if err != nil {
+		return nil, err
+	}
+
+	return pools, nil
+}
+
+func findExternalIPPool(pools []*ExternalIPPool, name string) *ExternalIPPool {
+	for _, pool := range pools {
+		if pool.Name == name || pool.ID == name {
+			return pool
+		}
+	}
+
+	return nil
+}
+
+// GetExternalIPPool returns summary information about the external ip pool
+// with the given name or ID. An error matching ErrPoolNotFound is returned if
+// there is no such pool. An error will also be returned if the following
+// environment variables are not set; CIAO_ADMIN_CLIENT_CERT_FILE,
+// CIAO_CONTROLLER.
+func GetExternalIPPool(ctx context.Context, tenant, name string) (*ExternalIPPool, error) {
+	pools, err := ListExternalIPPools(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := findExternalIPPool(pools, name)
+	if pool == nil {
+		return nil, errors.Wrapf(ErrPoolNotFound, "No pool named %s", name)
+	}
+
+	return pool, nil
+}
+
+// CreateExternalIPPoolWithIPs creates a new pool for external ips, adds the
+// given addresses to it and returns the new pool. The addresses may be
+// individual ips or a single subnet in CIDR format. An error will be returned
+// if the following environment variables are not set;
+// CIAO_ADMIN_CLIENT_CERT_FILE, CIAO_CONTROLLER.
+func CreateExternalIPPoolWithIPs(ctx context.Context, tenant, name string,
+	addresses []string) (*ExternalIPPool, error) {
+	err := CreateExternalIPPool(ctx, tenant, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(addresses) > 0 {
+		err = AddExternalIPsToPool(ctx, tenant, name, addresses)
+		if err != nil {
+			_ = DeleteExternalIPPool(ctx, tenant, name)
+			return nil, err
+		}
+	}
+
+	return GetExternalIPPool(ctx, tenant, name)
+}
@@ -25,7 +25,10 @@ package bat
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
@@ -45,6 +48,10 @@ type Image struct {
 	SizeBytes   int    `json:"size"`
 	Status      string `json:"state"`
 	CreatedDate string `json:"create_time"`
+
+	// Checksum is the sha256:HEX checksum of the data uploaded by
+	// AddRandomImage.  It is empty for other images.
+	Checksum string `json:"-"`
 }
 
 func computeImageAddArgs(options *ImageOptions) []string {
@@ -91,16 +98,83 @@ func AddImage(ctx context.Context, admin bool, tenant, path string, options *Ima
 // options parameter. It is implemented by calling ciao create image. On
 // success the function returns the entire meta data of the newly updated image
 // that includes the caller supplied meta data and the meta data added by the
-// image service. An error  will be returned if the following environment
-// variables are not set; CIAO_ADMIN_CLIENT_CERT_FILE (if admin set) otherwise
-// CIAO_CLIENT_CERT_FILE, CIAO_CONTROLLER.
+// image service, along with the checksum of the random data, which can be
+// passed to VerifyImageContent. An error  will be returned if the following
+// environment variables are not set; CIAO_ADMIN_CLIENT_CERT_FILE (if admin
+// set) otherwise CIAO_CLIENT_CERT_FILE, CIAO_CONTROLLER.
 func AddRandomImage(ctx context.Context, admin bool, tenant string, size int, options *ImageOptions) (*Image, error) {
 	path, err := CreateRandomFile(size)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to create random file : %v", err)
 	}
 	defer func() { _ = os.Remove(path) }()
-	return AddImage(ctx, admin, tenant, path, options)
+
+	checksum, err := fileChecksum(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to compute checksum of random file : %v", err)
+	}
+
+	img, err := AddImage(ctx, admin, tenant, path, options)
+	if err != nil {
+		return nil, err
+	}
+
+	img.Checksum = checksum
+	return img, nil
+}
+
+// VerifyImageContent downloads the data of an image and checks that it
+// matches checksum, of the form sha256:HEX, as returned by AddRandomImage.
+// It is implemented by calling ciao export image. An error will be returned
+// if the following environment variables are not set;
+// CIAO_ADMIN_CLIENT_CERT_FILE (if admin set) otherwise CIAO_CLIENT_CERT_FILE,
+// CIAO_CONTROLLER.
+func VerifyImageContent(ctx context.Context, admin bool, tenant, ID, checksum string) error {
+	f, err := ioutil.TempFile("/tmp", "ciao-image-")
+	if err != nil {
+		return err
+	}
+	path := f.Name()
+	_ = f.Close()
+	defer func() { _ = os.Remove(path) }()
+
+	args := []string{"export", "image", ID, "--file", path}
+	if admin {
+		_, err = RunCIAOCmdAsAdmin(ctx, tenant, args)
+	} else {
+		_, err = RunCIAOCmd(ctx, tenant, args)
+	}
+	if err != nil {
+		return err
+	}
+
+	downloaded, err := fileChecksum(path)
+	if err != nil {
+		return fmt.Errorf("Unable to compute checksum of image %s : %v", ID, err)
+	}
+
+	if downloaded != checksum {
+		return fmt.Errorf("Content of image %s does not match: expected %s got %s",
+			ID, checksum, downloaded)
+	}
+
+	return nil
+}
+
+// fileChecksum returns the sha256:HEX checksum of the file at path.
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
 }
 
 // DeleteImage deletes an image from the image service. It is implemented by
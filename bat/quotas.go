@@ -50,7 +50,7 @@ func ListQuotas(ctx context.Context, tenantID string, forTenantID string) ([]Quo
 // UpdateQuota updates the provided named quota for the provided tenant (using
 // forTenantID) to the desired value.
 func UpdateQuota(ctx context.Context, tenantID string, forTenantID string, name string, value string) error {
-	args := []string{"update", "quota", forTenantID, name, value}
+	args := []string{"update", "quota", forTenantID, name, value, "--yes"}
 
 	_, err := RunCIAOCmdAsAdmin(ctx, tenantID, args)
 
@@ -64,11 +64,36 @@ func DeleteVolume(ctx context.Context, tenant, ID string) error {
 	return err
 }
 
-// AddVolume adds a new volume to a tenant. The volume is added using ciao
-// create volume. An error will be returned if the following environment
-// variables are not set; CIAO_CLIENT_CERT_FILE, CIAO_CONTROLLER.
-func AddVolume(ctx context.Context, tenant, source, sourceType string,
-	options *VolumeOptions) (string, error) {
+// DeleteVolumeAndWait deletes a volume and waits for it to disappear, or to
+// be moved to the recycle bin. An error will be returned if the following
+// environment variables are not set; CIAO_CLIENT_CERT_FILE, CIAO_CONTROLLER.
+func DeleteVolumeAndWait(ctx context.Context, tenant, ID string) error {
+	err := DeleteVolume(ctx, tenant, ID)
+	if err != nil {
+		return err
+	}
+
+	for {
+		vol, err := GetVolume(ctx, tenant, ID)
+		if IsError(err, ErrVolumeNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if vol.Status == string(types.Deleted) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Test timed out waiting for volume %s to be deleted", ID)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func computeVolumeCreateArgs(source, sourceType string, options *VolumeOptions) []string {
 	args := []string{"create", "volume", "-f", "{{ tojson . }}"}
 
 	if sourceType != "" {
@@ -94,8 +119,51 @@ func AddVolume(ctx context.Context, tenant, source, sourceType string,
 		args = append(args, "--size", fmt.Sprintf("%d", options.Size))
 	}
 
-	var vol types.Volume
+	return args
+}
+
+// CreateVolume creates a new volume in a tenant and returns it. The volume
+// is created using ciao create volume. An error will be returned if the
+// following environment variables are not set; CIAO_CLIENT_CERT_FILE,
+// CIAO_CONTROLLER.
+func CreateVolume(ctx context.Context, tenant, source, sourceType string,
+	options *VolumeOptions) (*Volume, error) {
+	var vol Volume
+
+	args := computeVolumeCreateArgs(source, sourceType, options)
 	err := RunCIAOCmdJS(ctx, tenant, args, &vol)
+	if err != nil {
+		return nil, err
+	}
+
+	return &vol, nil
+}
+
+// CreateVolumeAndWait creates a new volume in a tenant and waits for it to
+// become available, returning the available volume. An error will be
+// returned if the following environment variables are not set;
+// CIAO_CLIENT_CERT_FILE, CIAO_CONTROLLER.
+func CreateVolumeAndWait(ctx context.Context, tenant, source, sourceType string,
+	options *VolumeOptions) (*Volume, error) {
+	vol, err := CreateVolume(ctx, tenant, source, sourceType, options)
+	if err != nil {
+		return nil, err
+	}
+
+	err = WaitForVolumeStatus(ctx, tenant, vol.ID, string(types.Available))
+	if err != nil {
+		return nil, err
+	}
+
+	return GetVolume(ctx, tenant, vol.ID)
+}
+
+// AddVolume adds a new volume to a tenant and returns its ID. The volume is
+// added using ciao create volume. An error will be returned if the following
+// environment variables are not set; CIAO_CLIENT_CERT_FILE, CIAO_CONTROLLER.
+func AddVolume(ctx context.Context, tenant, source, sourceType string,
+	options *VolumeOptions) (string, error) {
+	vol, err := CreateVolume(ctx, tenant, source, sourceType, options)
 	if err != nil {
 		return "", err
 	}
@@ -127,10 +195,30 @@ func GetAllVolumes(ctx context.Context, tenant string) (map[string]*Volume, erro
 	return volumes, nil
 }
 
+// volumeFailureStatuses are the statuses volumes are not expected to leave
+// on their own.
+var volumeFailureStatuses = map[string]bool{
+	string(types.VolumeError): true,
+	string(types.Deleted):     true,
+}
+
+func checkVolumeStatus(vol *Volume, status string) (bool, error) {
+	if vol.Status == status {
+		return true, nil
+	}
+
+	if volumeFailureStatuses[vol.Status] {
+		return false, fmt.Errorf("Volume %s is %s", vol.ID, vol.Status)
+	}
+
+	return false, nil
+}
+
 // WaitForVolumeStatus blocks until the status of the specified volume matches
-// the status parameter or the context is cancelled. An error will be returned
-// if the following environment variables are not set; CIAO_CLIENT_CERT_FILE,
-// CIAO_CONTROLLER.
+// the status parameter or the context is cancelled. The function fails early
+// if the volume enters a status it is not expected to leave on its own, such
+// as error. An error will be returned if the following environment variables
+// are not set; CIAO_CLIENT_CERT_FILE, CIAO_CONTROLLER.
 func WaitForVolumeStatus(ctx context.Context, tenant, volume, status string) error {
 	for {
 		vol, err := GetVolume(ctx, tenant, volume)
@@ -138,7 +226,11 @@ func WaitForVolumeStatus(ctx context.Context, tenant, volume, status string) err
 			return fmt.Errorf("Unable to retrieve meta data for volume %s :%v",
 				volume, err)
 		}
-		if status == vol.Status {
+
+		finished, err := checkVolumeStatus(vol, status)
+		if err != nil {
+			return err
+		} else if finished {
 			break
 		}
 		select {
@@ -182,7 +274,7 @@ func DetachVolume(ctx context.Context, tenant, volume string) error {
 	return err
 }
 
-// DetachVolumeAndWait attaches a volume to an instance and waits for the status
+// DetachVolumeAndWait detaches a volume from an instance and waits for the status
 // of that volume to transition to "available". An error will be returned if the
 // following environment variables are not set; CIAO_CLIENT_CERT_FILE,
 // CIAO_CONTROLLER.
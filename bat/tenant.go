@@ -70,10 +70,10 @@ func CreateTenant(ctx context.Context, config TenantConfig) (TenantSummary, erro
 	return summary, err
 }
 
-// UpdateTenant updates a new tenant with the given config.
+// UpdateTenantConfig updates the configuration of the given tenant.
 // It calls ciao update tenant
-func UpdateTenant(ctx context.Context, ID string, config TenantConfig) error {
-	args := []string{"update", "tenant", ID}
+func UpdateTenantConfig(ctx context.Context, ID string, config TenantConfig) error {
+	args := []string{"update", "tenant", ID, "--yes"}
 	if config.Name != "" {
 		name := []string{"--name", config.Name}
 		args = append(args, name...)
@@ -92,6 +92,14 @@ func UpdateTenant(ctx context.Context, ID string, config TenantConfig) error {
 	return err
 }
 
+// UpdateTenant updates a new tenant with the given config.
+// It calls ciao update tenant
+//
+// Deprecated: use UpdateTenantConfig.
+func UpdateTenant(ctx context.Context, ID string, config TenantConfig) error {
+	return UpdateTenantConfig(ctx, ID, config)
+}
+
 // GetTenantConfig retrieves the configuration for the given tenant.
 func GetTenantConfig(ctx context.Context, ID string) (TenantConfig, error) {
 	var config TenantConfig
@@ -110,3 +118,10 @@ func DeleteTenant(ctx context.Context, ID string) error {
 
 	return err
 }
+
+// ListTenantQuotas returns the quotas of the given tenant.  It calls ciao
+// list quotas as the admin user, so the tenant does not need to be one of
+// the tenants of the user running the BAT tests.
+func ListTenantQuotas(ctx context.Context, ID string) ([]QuotaDetails, error) {
+	return ListQuotas(ctx, "", ID)
+}
@@ -0,0 +1,199 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package bat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TraceSummary contains the number of instances traced with a label
+type TraceSummary struct {
+	Label     string `json:"label"`
+	Instances int    `json:"instances"`
+}
+
+// BatchFrameStat contains the statistics computed from the traces of a
+// batch of instance launches.  All times are in seconds.
+type BatchFrameStat struct {
+	NumInstances             int     `json:"num_instances"`
+	TotalElapsed             float64 `json:"total_elapsed"`
+	AverageElapsed           float64 `json:"average_elapsed"`
+	AverageControllerElapsed float64 `json:"average_controller_elapsed"`
+	AverageLauncherElapsed   float64 `json:"average_launcher_elapsed"`
+	AverageSchedulerElapsed  float64 `json:"average_scheduler_elapsed"`
+	VarianceController       float64 `json:"controller_variance"`
+	VarianceLauncher         float64 `json:"launcher_variance"`
+	VarianceScheduler        float64 `json:"scheduler_variance"`
+}
+
+// TraceThresholds contains the maximum average latencies, in seconds, of
+// the stages of a traced batch of instance launches.  Thresholds which are
+// 0 are not checked.
+type TraceThresholds struct {
+	AverageElapsed           float64
+	AverageControllerElapsed float64
+	AverageSchedulerElapsed  float64
+	AverageLauncherElapsed   float64
+}
+
+// LaunchTracedInstances launches num instances of the specified workload,
+// tracing their launches with label.  The UUIDs of the launched instances
+// are returned.  The instances are launched using ciao create instance. An
+// error will be returned if the following environment variables are not
+// set; CIAO_CLIENT_CERT_FILE, CIAO_CONTROLLER.
+func LaunchTracedInstances(ctx context.Context, tenant string, workload string, label string,
+	num int) ([]string, error) {
+	template := `
+[
+{{- range $i, $val := .}}
+  {{- if $i }},{{end}}"{{$val.ID | js }}"
+{{- end }}
+]
+`
+	args := []string{"create", "instance", workload,
+		"--instances", fmt.Sprintf("%d", num), "--label", label, "-f", template}
+	var instances []string
+	err := RunCIAOCmdJS(ctx, tenant, args, &instances)
+	if err != nil {
+		return nil, err
+	}
+
+	return instances, nil
+}
+
+// GetTraces retrieves the labels of the traces recorded in the cluster
+// along with the number of instances traced with each of them, by calling
+// ciao list traces. An error will be returned if the following environment
+// variables are not set; CIAO_ADMIN_CLIENT_CERT_FILE, CIAO_CONTROLLER.
+func GetTraces(ctx context.Context) ([]TraceSummary, error) {
+	var traces []TraceSummary
+
+	args := []string{"list", "traces", "-f", "{{tojson .}}"}
+	err := RunCIAOCmdAsAdminJS(ctx, "", args, &traces)
+	if err != nil {
+		return nil, err
+	}
+
+	return traces, nil
+}
+
+// WaitForTrace blocks until the launches of num instances have been traced
+// with label, or until the context is cancelled. An error will be returned
+// if the following environment variables are not set;
+// CIAO_ADMIN_CLIENT_CERT_FILE, CIAO_CONTROLLER.
+func WaitForTrace(ctx context.Context, label string, num int) error {
+	for {
+		traces, err := GetTraces(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, t := range traces {
+			if t.Label == label && t.Instances >= num {
+				return nil
+			}
+		}
+
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return fmt.Errorf("Trace %s incomplete: %v", label, ctx.Err())
+		}
+	}
+}
+
+// GetTraceStats retrieves the statistics of the launches traced with label
+// by calling ciao show trace. An error will be returned if the following
+// environment variables are not set; CIAO_ADMIN_CLIENT_CERT_FILE,
+// CIAO_CONTROLLER.
+func GetTraceStats(ctx context.Context, label string) (*BatchFrameStat, error) {
+	var stats BatchFrameStat
+
+	args := []string{"show", "trace", label, "-f", "{{tojson .}}"}
+	err := RunCIAOCmdAsAdminJS(ctx, "", args, &stats)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// DeleteTrace deletes the trace data recorded with label by calling ciao
+// delete trace. An error will be returned if the following environment
+// variables are not set; CIAO_ADMIN_CLIENT_CERT_FILE, CIAO_CONTROLLER.
+func DeleteTrace(ctx context.Context, label string) error {
+	args := []string{"delete", "trace", label}
+	_, err := RunCIAOCmdAsAdmin(ctx, "", args)
+	return err
+}
+
+// CheckTraceThresholds compares the average stage latencies of a traced
+// batch of launches to thresholds.  An error listing every stage whose
+// average latency exceeds its threshold is returned.
+func CheckTraceThresholds(stats *BatchFrameStat, thresholds TraceThresholds) error {
+	checks := []struct {
+		stage     string
+		average   float64
+		threshold float64
+	}{
+		{"total", stats.AverageElapsed, thresholds.AverageElapsed},
+		{"controller", stats.AverageControllerElapsed, thresholds.AverageControllerElapsed},
+		{"scheduler", stats.AverageSchedulerElapsed, thresholds.AverageSchedulerElapsed},
+		{"launcher", stats.AverageLauncherElapsed, thresholds.AverageLauncherElapsed},
+	}
+
+	var exceeded []string
+	for _, c := range checks {
+		if c.threshold > 0 && c.average > c.threshold {
+			exceeded = append(exceeded, fmt.Sprintf("%s %.3fs > %.3fs",
+				c.stage, c.average, c.threshold))
+		}
+	}
+
+	if len(exceeded) > 0 {
+		return fmt.Errorf("Average latencies exceeded: %s", strings.Join(exceeded, ", "))
+	}
+
+	return nil
+}
+
+// RunTracedLaunch launches num instances of the specified workload traced
+// with label, waits for all their launches to be traced and returns the
+// statistics of the batch, along with the UUIDs of the instances which
+// the caller is responsible for deleting.  The trace data is deleted.  An
+// error will be returned if the following environment variables are not
+// set; CIAO_CLIENT_CERT_FILE, CIAO_ADMIN_CLIENT_CERT_FILE, CIAO_CONTROLLER.
+func RunTracedLaunch(ctx context.Context, tenant string, workload string, label string,
+	num int) (*BatchFrameStat, []string, error) {
+	instances, err := LaunchTracedInstances(ctx, tenant, workload, label, num)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer func() { _ = DeleteTrace(ctx, label) }()
+
+	err = WaitForTrace(ctx, label, len(instances))
+	if err != nil {
+		return nil, instances, err
+	}
+
+	stats, err := GetTraceStats(ctx, label)
+	return stats, instances, err
+}
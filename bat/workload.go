@@ -54,9 +54,13 @@ type Disk struct {
 type WorkloadRequirements struct {
 	VCPUs      int    `yaml:"vcpus"`
 	MemMB      int    `yaml:"mem_mb"`
+	DiskMB     int    `yaml:"disk_mb,omitempty"`
+	DiskIOPS   int    `yaml:"disk_iops,omitempty"`
+	NetMbps    int    `yaml:"net_mbps,omitempty"`
 	NodeID     string `yaml:"node_id,omitempty"`
 	Hostname   string `yaml:"hostname,omitempty"`
 	Privileged bool   `yaml:"privileged,omitempty"`
+	Priority   string `yaml:"priority,omitempty"`
 }
 
 // WorkloadOptions is used to generate a workload definition in yaml.
@@ -15,6 +15,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -24,6 +25,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/ciao-project/ciao/payloads"
 	"github.com/ciao-project/ciao/ssntp"
@@ -55,16 +57,44 @@ type APIResponse struct {
 
 func errorResponse(err error) APIResponse {
 	switch err {
-	case types.ErrQuota:
+	case types.ErrQuota,
+		types.ErrInstanceNotStopped,
+		types.ErrExportNotSupported,
+		types.ErrImageNotActive:
 		return APIResponse{http.StatusForbidden, nil}
 	case types.ErrTenantNotFound,
-		types.ErrInstanceNotFound:
+		types.ErrInstanceNotFound,
+		api.ErrNoImage:
 		return APIResponse{http.StatusNotFound, nil}
 	default:
 		return APIResponse{http.StatusInternalServerError, nil}
 	}
 }
 
+// writeErrorResponse sends err back to the client as an OpenStack
+// formatted fault.
+func writeErrorResponse(w http.ResponseWriter, r *http.Request, status int, err error) {
+	data := HTTPErrorData{
+		Code:    status,
+		Name:    http.StatusText(status),
+		Message: err.Error(),
+	}
+
+	code := HTTPReturnErrorCode{
+		Error: data,
+	}
+
+	glog.Warningf("Returning error response to request: %s: %v", r.URL.String(), err)
+
+	b, err := json.Marshal(code)
+	if err != nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	http.Error(w, string(b), status)
+}
+
 type pagerFilterType uint8
 
 const (
@@ -226,12 +256,14 @@ func findQuota(qds []types.QuotaDetails, name string) *types.QuotaDetails {
 }
 
 func getResources(c *controller, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	ctx := r.Context()
+
 	var tenantResource types.CiaoTenantResources
 
 	vars := mux.Vars(r)
 	tenant := vars["tenant"]
 
-	t, err := c.ds.GetTenant(tenant)
+	t, err := c.ds.GetTenant(ctx, tenant)
 	if err != nil || t == nil {
 		return errorResponse(types.ErrTenantNotFound), types.ErrTenantNotFound
 	}
@@ -306,9 +338,11 @@ func getUsage(c *controller, w http.ResponseWriter, r *http.Request) (APIRespons
 	return APIResponse{http.StatusOK, usage}, nil
 }
 
-type instanceAction func(string) error
+type instanceAction func(context.Context, string) error
 
 func serversAction(c *controller, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	ctx := r.Context()
+
 	vars := mux.Vars(r)
 	tenant := vars["tenant"]
 	var servers types.CiaoServersAction
@@ -347,7 +381,7 @@ func serversAction(c *controller, w http.ResponseWriter, r *http.Request) (APIRe
 				return errorResponse(err), err
 			}
 
-			err = actionFunc(instanceID)
+			err = actionFunc(ctx, instanceID)
 			if err != nil {
 				return errorResponse(err), err
 			}
@@ -365,7 +399,7 @@ func serversAction(c *controller, w http.ResponseWriter, r *http.Request) (APIRe
 				continue
 			}
 
-			err = actionFunc(instance.ID)
+			err = actionFunc(ctx, instance.ID)
 			if err != nil {
 				return errorResponse(err), err
 			}
@@ -394,6 +428,37 @@ func trimComputeNodes(c *controller, nodeList types.CiaoNodes, targetRole ssntp.
 	return trimmedNodes, nil
 }
 
+// nodeVCPUsAllocated returns the number of VCPUs allocated to the instances
+// assigned to each node.
+func nodeVCPUsAllocated(c *controller) (map[string]int, error) {
+	vcpus := make(map[string]int)
+
+	instances, err := c.ds.GetAllInstances()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range instances {
+		i.StateLock.RLock()
+		nodeID := i.NodeID
+		state := i.State
+		i.StateLock.RUnlock()
+
+		if nodeID == "" || state == payloads.Exited || i.IsDeleted() {
+			continue
+		}
+
+		wl, err := c.ds.GetWorkload(i.WorkloadID)
+		if err != nil {
+			continue
+		}
+
+		vcpus[nodeID] += wl.Requirements.VCPUs
+	}
+
+	return vcpus, nil
+}
+
 func listSubsetOfNodes(c *controller, w http.ResponseWriter, r *http.Request, targetRole ssntp.Role) (APIResponse, error) {
 	allNodes := c.ds.GetNodeLastStats()
 
@@ -413,6 +478,11 @@ func listSubsetOfNodes(c *controller, w http.ResponseWriter, r *http.Request, ta
 		return errorResponse(err), err
 	}
 
+	vcpus, err := nodeVCPUsAllocated(c)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
 	for _, node := range nodeSummary {
 		for i := range subsetOfNodes.Nodes {
 			if subsetOfNodes.Nodes[i].ID != node.NodeID {
@@ -430,6 +500,10 @@ func listSubsetOfNodes(c *controller, w http.ResponseWriter, r *http.Request, ta
 		}
 	}
 
+	for i := range subsetOfNodes.Nodes {
+		subsetOfNodes.Nodes[i].VCPUsAllocated = vcpus[subsetOfNodes.Nodes[i].ID]
+	}
+
 	sort.Sort(types.SortedNodesByID(subsetOfNodes.Nodes))
 
 	pager := nodePager{
@@ -492,6 +566,28 @@ func listNodeServers(c *controller, w http.ResponseWriter, r *http.Request) (API
 	return APIResponse{http.StatusOK, resp}, nil
 }
 
+func listTenantServerStats(c *controller, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	serversStats := c.ds.GetTenantInstanceLastStats(tenant)
+
+	instances, err := c.ds.GetAllInstancesFromTenant(tenant)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	for _, instance := range instances {
+		for i := range serversStats.Servers {
+			if serversStats.Servers[i].ID == instance.ID {
+				serversStats.Servers[i].IPv4 = instance.IPAddress
+			}
+		}
+	}
+
+	return APIResponse{http.StatusOK, serversStats}, nil
+}
+
 func listCNCIs(c *controller, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
 	var ciaoCNCIs types.CiaoCNCIs
 
@@ -562,9 +658,11 @@ func listCNCIDetails(c *controller, w http.ResponseWriter, r *http.Request) (API
 }
 
 func listTraces(c *controller, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	ctx := r.Context()
+
 	var traces types.CiaoTracesSummary
 
-	summaries, err := c.ds.GetBatchFrameSummary()
+	summaries, err := c.ds.GetBatchFrameSummary(ctx)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -577,16 +675,64 @@ func listTraces(c *controller, w http.ResponseWriter, r *http.Request) (APIRespo
 		traces.Summaries = append(traces.Summaries, summary)
 	}
 
+	traces.Active = c.activeTraceLabel()
+
 	return APIResponse{http.StatusOK, traces}, err
 }
 
+func startTrace(c *controller, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	var req types.CiaoTraceRequest
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return APIResponse{http.StatusBadRequest, nil}, err
+	}
+
+	// "active" would be shadowed by the v2.1/traces/active route.
+	if req.Label == "" || req.Label == "active" {
+		return APIResponse{http.StatusBadRequest, nil},
+			fmt.Errorf("Invalid trace label %q", req.Label)
+	}
+
+	c.startTracing(req.Label)
+
+	return APIResponse{http.StatusNoContent, nil}, nil
+}
+
+func stopTrace(c *controller, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	c.stopTracing()
+
+	return APIResponse{http.StatusNoContent, nil}, nil
+}
+
+func deleteTrace(c *controller, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	ctx := r.Context()
+
+	vars := mux.Vars(r)
+	label := vars["label"]
+
+	err := c.ds.DeleteBatchFrameStatistics(ctx, label)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return APIResponse{http.StatusAccepted, nil}, nil
+}
+
 func listEvents(c *controller, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	ctx := r.Context()
+
 	vars := mux.Vars(r)
 	tenant := vars["tenant"]
 
 	events := types.NewCiaoEvents()
 
-	logs, err := c.ds.GetEventLog()
+	logs, err := c.ds.GetEventLog(ctx)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -609,7 +755,9 @@ func listEvents(c *controller, w http.ResponseWriter, r *http.Request) (APIRespo
 }
 
 func clearEvents(c *controller, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
-	err := c.ds.ClearLog()
+	ctx := r.Context()
+
+	err := c.ds.ClearLog(ctx)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -618,11 +766,13 @@ func clearEvents(c *controller, w http.ResponseWriter, r *http.Request) (APIResp
 }
 
 func traceData(c *controller, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	ctx := r.Context()
+
 	vars := mux.Vars(r)
 	label := vars["label"]
 	var traceData types.CiaoTraceData
 
-	batchStats, err := c.ds.GetBatchFrameStatistics(label)
+	batchStats, err := c.ds.GetBatchFrameStatistics(ctx, label)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -15,16 +15,21 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
 	"github.com/ciao-project/ciao/service"
 	"github.com/ciao-project/ciao/uuid"
 	"github.com/golang/glog"
@@ -58,6 +63,52 @@ const (
 
 	// InstancesV1 is the content-type string for v1 of our intances resource
 	InstancesV1 = "x.ciao.instances.v1"
+
+	// OperationsV1 is the content-type string for v1 of our operations resource
+	OperationsV1 = "x.ciao.operations.v1"
+
+	// CatalogV1 is the content-type string for v1 of our catalog resource
+	CatalogV1 = "x.ciao.catalog.v1"
+
+	// IPAMV1 is the content-type string for v1 of our ipam resource
+	IPAMV1 = "x.ciao.ipam.v1"
+
+	// IPsV1 is the content-type string for v1 of our tenant IP reservations
+	// resource
+	IPsV1 = "x.ciao.ips.v1"
+
+	// CommandsV1 is the content-type string for v1 of our commands resource
+	CommandsV1 = "x.ciao.commands.v1"
+
+	// SecretsV1 is the content-type string for v1 of our secrets resource
+	SecretsV1 = "x.ciao.secrets.v1"
+
+	// TokensV1 is the content-type string for v1 of our API tokens resource
+	TokensV1 = "x.ciao.tokens.v1"
+
+	// RecycleBinV1 is the content-type string for v1 of our recycle bin
+	// resource
+	RecycleBinV1 = "x.ciao.recycle-bin.v1"
+
+	// FederationV1 is the content-type string for v1 of our federation
+	// peers resource
+	FederationV1 = "x.ciao.federation.v1"
+
+	// ConsolesV1 is the content-type string for v1 of our console
+	// sessions resource
+	ConsolesV1 = "x.ciao.consoles.v1"
+
+	// ClusterV1 is the content-type string for v1 of our cluster summary
+	// resource
+	ClusterV1 = "x.ciao.cluster.v1"
+
+	// SnapshotsV1 is the content-type string for v1 of our snapshot sets
+	// resource
+	SnapshotsV1 = "x.ciao.snapshots.v1"
+
+	// SignedRequestsV1 is the content-type string for v1 of our signed
+	// requests resource
+	SignedRequestsV1 = "x.ciao.signed-requests.v1"
 )
 
 // ErrorImage defines all possible image handling errors
@@ -79,6 +130,10 @@ var (
 
 	// ErrQuota is returned when the tenant exceeds its quota
 	ErrQuota = errors.New("Tenant over quota")
+
+	// ErrImageServiceDisabled is returned by the image routes of
+	// deployments running without the image service.
+	ErrImageServiceDisabled = errors.New("Image service disabled, only pre-provisioned images are available")
 )
 
 // CreateImageRequest contains information for a create image request.
@@ -86,6 +141,153 @@ type CreateImageRequest struct {
 	Name       string           `json:"name,omitempty"`
 	ID         string           `json:"id,omitempty"`
 	Visibility types.Visibility `json:"visibility,omitempty"`
+
+	// URL is the HTTP(S) URL the image data is imported from. When it
+	// is set the controller fetches the image data itself, verifying
+	// it against Checksum, of the form sha256:HEX or sha512:HEX, if
+	// provided.
+	URL      string `json:"url,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// ImageFilter selects the images returned by an image list request.  Zero
+// values match all images.  Images are listed oldest first and at most
+// Limit of them are returned, starting after the image whose ID is Marker.
+type ImageFilter struct {
+	Name         string
+	Visibility   types.Visibility
+	TenantID     string
+	MinSize      uint64
+	MaxSize      uint64
+	CreatedSince time.Time
+	Limit        int
+	Marker       string
+}
+
+// Values returns the query parameters of an image list request.
+func (f ImageFilter) Values() url.Values {
+	values := url.Values{}
+
+	if f.Name != "" {
+		values.Set("name", f.Name)
+	}
+	if f.Visibility != "" {
+		values.Set("visibility", string(f.Visibility))
+	}
+	if f.TenantID != "" {
+		values.Set("tenant", f.TenantID)
+	}
+	if f.MinSize != 0 {
+		values.Set("min_size", strconv.FormatUint(f.MinSize, 10))
+	}
+	if f.MaxSize != 0 {
+		values.Set("max_size", strconv.FormatUint(f.MaxSize, 10))
+	}
+	if !f.CreatedSince.IsZero() {
+		values.Set("created_since", f.CreatedSince.Format(time.RFC3339))
+	}
+	if f.Limit != 0 {
+		values.Set("limit", strconv.Itoa(f.Limit))
+	}
+	if f.Marker != "" {
+		values.Set("marker", f.Marker)
+	}
+
+	return values
+}
+
+// Match returns whether an image is selected by the filter, regardless of
+// the limit and marker.  Name matches images whose name contains it.
+func (f ImageFilter) Match(i types.Image) bool {
+	if f.Name != "" && !strings.Contains(i.Name, f.Name) {
+		return false
+	}
+	if f.Visibility != "" && i.Visibility != f.Visibility {
+		return false
+	}
+	if f.TenantID != "" && i.TenantID != f.TenantID {
+		return false
+	}
+	if i.Size < f.MinSize || (f.MaxSize != 0 && i.Size > f.MaxSize) {
+		return false
+	}
+
+	return !i.CreateTime.Before(f.CreatedSince)
+}
+
+func parseImageFilter(values url.Values) (ImageFilter, error) {
+	var f ImageFilter
+	var err error
+
+	f.Name = values.Get("name")
+	f.TenantID = values.Get("tenant")
+	f.Marker = values.Get("marker")
+
+	switch v := types.Visibility(values.Get("visibility")); v {
+	case "", types.Public, types.Private, types.Internal:
+		f.Visibility = v
+	default:
+		return f, types.ErrBadRequest
+	}
+
+	if v := values.Get("min_size"); v != "" {
+		if f.MinSize, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return f, types.ErrBadRequest
+		}
+	}
+	if v := values.Get("max_size"); v != "" {
+		if f.MaxSize, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return f, types.ErrBadRequest
+		}
+	}
+	if v := values.Get("created_since"); v != "" {
+		if f.CreatedSince, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, types.ErrBadRequest
+		}
+	}
+	if v := values.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 0 {
+			return f, types.ErrBadRequest
+		}
+	}
+
+	return f, nil
+}
+
+// filterImages returns the page of images selected by a filter.
+func filterImages(images []types.Image, f ImageFilter) ([]types.Image, error) {
+	sort.Slice(images, func(i, j int) bool {
+		if images[i].CreateTime.Equal(images[j].CreateTime) {
+			return images[i].ID < images[j].ID
+		}
+		return images[i].CreateTime.Before(images[j].CreateTime)
+	})
+
+	if f.Marker != "" {
+		found := false
+		for i := range images {
+			if images[i].ID == f.Marker {
+				images = images[i+1:]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, types.ErrBadRequest
+		}
+	}
+
+	page := []types.Image{}
+	for _, i := range images {
+		if f.Limit > 0 && len(page) >= f.Limit {
+			break
+		}
+		if f.Match(i) {
+			page = append(page, i)
+		}
+	}
+
+	return page, nil
 }
 
 // RequestedVolume contains information about a volume to be created.
@@ -95,6 +297,8 @@ type RequestedVolume struct {
 	Description string `json:"description,omitempty"`
 	Name        string `json:"name,omitempty"`
 	ImageRef    string `json:"imageRef,omitempty"`
+	Class       string `json:"class,omitempty"`
+	Encrypted   bool   `json:"encrypted,omitempty"`
 	Internal    bool   `json:"-"`
 }
 
@@ -107,7 +311,10 @@ type CreateServerRequest struct {
 		WorkloadID   string            `json:"workload_id"`
 		MaxInstances int               `json:"max_count"`
 		MinInstances int               `json:"min_count"`
+		IPAddress    string            `json:"ip_address,omitempty"`
 		Metadata     map[string]string `json:"metadata,omitempty"`
+		Preemptible  bool              `json:"preemptible,omitempty"`
+		Group        string            `json:"group,omitempty"`
 	} `json:"server"`
 }
 
@@ -131,6 +338,54 @@ type ServerDetails struct {
 	TenantID         string             `json:"tenant_id"`
 	SSHIP            string             `json:"ssh_ip"`
 	SSHPort          int                `json:"ssh_port"`
+	Preemptible      bool               `json:"preemptible,omitempty"`
+	TerminationTime  *time.Time         `json:"termination_time,omitempty"`
+	Rescued          bool               `json:"rescued,omitempty"`
+	Reachable        *bool              `json:"reachable,omitempty"`
+	ProbeTime        *time.Time         `json:"probe_time,omitempty"`
+	PeerID           string             `json:"peer_id,omitempty"`
+	Failure          *types.Failure     `json:"failure,omitempty"`
+	Group            string             `json:"group,omitempty"`
+	ArchiveState     string             `json:"archive_state,omitempty"`
+	OperationID      string             `json:"operation_id,omitempty"`
+}
+
+// RescueServerRequest contains the image an instance is rescued from.  The
+// rescue image of the controller is used when no image is given.
+type RescueServerRequest struct {
+	ImageID string `json:"image_id,omitempty"`
+}
+
+// Reboot types of a RebootServerRequest.
+const (
+	// RebootSoft asks the guest of an instance to shut down before it is
+	// booted again.  It is killed if it does not shut down in time.
+	RebootSoft = "soft"
+
+	// RebootHard kills an instance straight away before booting it again.
+	RebootHard = "hard"
+)
+
+// RebootServerRequest contains the type of reboot of an instance.  A soft
+// reboot is performed when no type is given.
+type RebootServerRequest struct {
+	Type string `json:"type,omitempty"`
+}
+
+// CloneServerRequest contains the number of copies of an instance to
+// create and their name.  A single copy is created when Instances is 0.
+type CloneServerRequest struct {
+	Instances int    `json:"instances,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+// SimpleServerRequest is used to launch an instance of an image without
+// defining a workload.  Size names the size profile of the instance, i.e.,
+// small, medium or large.  Instances are small by default.
+type SimpleServerRequest struct {
+	ImageID string `json:"image_id"`
+	Size    string `json:"size,omitempty"`
+	Name    string `json:"name,omitempty"`
 }
 
 // Servers holds multiple servers including a count
@@ -144,6 +399,63 @@ type Server struct {
 	Server ServerDetails `json:"server"`
 }
 
+// InstanceGroup describes a named group of instances of a tenant.
+type InstanceGroup struct {
+	Name       string   `json:"name"`
+	WorkloadID string   `json:"workload_id"`
+	Instances  []string `json:"instances"`
+}
+
+// InstanceGroups holds the instance groups of a tenant.
+type InstanceGroups struct {
+	Groups []InstanceGroup `json:"groups"`
+}
+
+// InstanceGroupAction is an operation applied to all the instances of a
+// group.
+type InstanceGroupAction string
+
+const (
+	// GroupStop stops the running instances of a group.
+	GroupStop InstanceGroupAction = "stop"
+
+	// GroupRestart restarts the exited instances of a group.
+	GroupRestart InstanceGroupAction = "restart"
+
+	// GroupDelete deletes all the instances of a group.
+	GroupDelete InstanceGroupAction = "delete"
+
+	// GroupScale launches or deletes instances of a group until it has
+	// the requested number of instances.  The most recently created
+	// instances are deleted first.
+	GroupScale InstanceGroupAction = "scale"
+)
+
+// InstanceGroupActionRequest requests an action on an instance group.
+// Instances is the number of instances to scale the group to.
+type InstanceGroupActionRequest struct {
+	Action    InstanceGroupAction `json:"action"`
+	Instances int                 `json:"instances,omitempty"`
+}
+
+// InstanceGroupMemberResult is the result of an instance group action for
+// one instance of the group.  InstanceID is empty for the instances a
+// scale action failed to launch.
+type InstanceGroupMemberResult struct {
+	InstanceID string `json:"instance_id,omitempty"`
+	Operation  string `json:"operation"`
+	Skipped    bool   `json:"skipped,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// InstanceGroupActionResult reports the outcome of an instance group action
+// for each instance it applied to.
+type InstanceGroupActionResult struct {
+	Group   string                      `json:"group"`
+	Action  InstanceGroupAction         `json:"action"`
+	Results []InstanceGroupMemberResult `json:"results"`
+}
+
 var (
 	//ErrInstanceNotFound is used if instance not found
 	ErrInstanceNotFound = errors.New("Instance not found")
@@ -181,27 +493,87 @@ type Response struct {
 }
 
 func errorResponse(err error) Response {
+	if _, ok := err.(types.NameConflictError); ok {
+		return Response{http.StatusConflict, nil}
+	}
+
 	switch err {
 	case types.ErrPoolNotFound,
 		types.ErrTenantNotFound,
 		types.ErrAddressNotFound,
 		types.ErrInstanceNotFound,
-		types.ErrWorkloadNotFound:
+		types.ErrWorkloadNotFound,
+		types.ErrOperationNotFound,
+		types.ErrIPReservationNotFound,
+		types.ErrFailedCommandNotFound,
+		types.ErrSecretNotFound,
+		types.ErrAPITokenNotFound,
+		types.ErrVolumeNotFound,
+		types.ErrNotInRecycleBin,
+		types.ErrPeerNotFound,
+		types.ErrConsoleSessionNotFound,
+		types.ErrInstanceGroupNotFound,
+		types.ErrSnapshotSetNotFound:
 		return Response{http.StatusNotFound, nil}
 
+	case types.ErrAmbiguousName,
+		types.ErrTenantHasInstances,
+		types.ErrSignedRequestReplayed:
+		return Response{http.StatusConflict, nil}
+
 	case types.ErrQuota,
 		types.ErrInstanceNotAssigned,
 		types.ErrDuplicateSubnet,
 		types.ErrDuplicateIP,
 		types.ErrInvalidIP,
+		types.ErrIPInUse,
 		types.ErrPoolNotEmpty,
 		types.ErrInvalidPoolAddress,
 		types.ErrBadRequest,
 		types.ErrPoolEmpty,
 		types.ErrDuplicatePoolName,
-		types.ErrWorkloadInUse:
+		types.ErrWorkloadInUse,
+		types.ErrSecretInUse,
+		types.ErrPeerInUse,
+		types.ErrConsoleInUse,
+		types.ErrNoConsole,
+		types.ErrInvalidSubnetBits,
+		types.ErrInvalidCNCIFlavor,
+		types.ErrGuestAgentNotPermitted,
+		types.ErrSnapshotSetNotAvailable,
+		types.ErrSignedRequestInvalid,
+		types.ErrSignedRequestExpired,
+		types.ErrInstanceNotStopped,
+		types.ErrInstanceArchived,
+		types.ErrInstanceNotArchived,
+		types.ErrArchiveNotSupported,
+		types.ErrNoVolumesToArchive,
+		types.ErrSnapshotNotSupported,
+		types.ErrSnapshotInstanceState,
+		types.ErrNoBootVolume,
+		types.ErrSnapshotSetNoBootVolume,
+		types.ErrInstanceMigrating,
+		types.ErrInstanceNotRunning,
+		types.ErrInstanceNotPaused,
+		types.ErrStaticIPInstances,
+		types.ErrRescueNotSupported,
+		types.ErrInstanceRescued,
+		types.ErrInstanceNotRescued,
+		types.ErrUnrescueInstanceState,
+		types.ErrGuestAgentNotSupported,
+		types.ErrPacketCaptureNotSupported,
+		types.ErrMigrateNotSupported,
+		types.ErrMigrateSameNode:
 		return Response{http.StatusForbidden, nil}
 
+	case types.ErrGuestAgentTimeout,
+		types.ErrPacketCaptureTimeout:
+		return Response{http.StatusGatewayTimeout, nil}
+
+	case ErrImageServiceDisabled,
+		types.ErrSignedRequestsDisabled:
+		return Response{http.StatusNotImplemented, nil}
+
 	default:
 		return Response{http.StatusInternalServerError, nil}
 	}
@@ -216,6 +588,29 @@ type Handler struct {
 	Privileged bool
 }
 
+// writeError sends err back to the client as an OpenStack formatted fault.
+func writeError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	data := HTTPErrorData{
+		Code:    status,
+		Name:    http.StatusText(status),
+		Message: err.Error(),
+	}
+
+	code := HTTPReturnErrorCode{
+		Error: data,
+	}
+
+	glog.Warningf("Returning error response to request: %s: %v", r.URL.String(), err)
+
+	b, err := json.Marshal(code)
+	if err != nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	http.Error(w, string(b), status)
+}
+
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// check whether we should send permission denied for this route.
 	if h.Privileged {
@@ -226,30 +621,16 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// set the content type to whatever was requested.
-	contentType := r.Header.Get("Content-Type")
+	// send the resource version or plain JSON, as accepted.
+	contentType, err := negotiate(r, h.offers(r))
+	if err != nil {
+		writeError(w, r, http.StatusNotAcceptable, err)
+		return
+	}
 
 	resp, err := h.Handler(h.Context, w, r)
 	if err != nil {
-		data := HTTPErrorData{
-			Code:    resp.status,
-			Name:    http.StatusText(resp.status),
-			Message: err.Error(),
-		}
-
-		code := HTTPReturnErrorCode{
-			Error: data,
-		}
-
-		glog.Warningf("Returning error response to request: %s: %v", r.URL.String(), err)
-
-		b, err := json.Marshal(code)
-		if err != nil {
-			http.Error(w, http.StatusText(resp.status), resp.status)
-			return
-		}
-
-		http.Error(w, string(b), resp.status)
+		writeError(w, r, resp.status, err)
 		return
 	}
 
@@ -343,19 +724,21 @@ func listResources(c *Context, w http.ResponseWriter, r *http.Request) (Response
 	}
 
 	// for the "images" resource
-	link = types.APILink{
-		Rel:        "images",
-		Version:    ImagesV1,
-		MinVersion: ImagesV1,
-	}
+	if !c.imagesDisabled {
+		link = types.APILink{
+			Rel:        "images",
+			Version:    ImagesV1,
+			MinVersion: ImagesV1,
+		}
 
-	if !ok {
-		link.Href = fmt.Sprintf("%s/images", c.URL)
-	} else {
-		link.Href = fmt.Sprintf("%s/%s/images", c.URL, tenantID)
-	}
+		if !ok {
+			link.Href = fmt.Sprintf("%s/images", c.URL)
+		} else {
+			link.Href = fmt.Sprintf("%s/%s/images", c.URL, tenantID)
+		}
 
-	links = append(links, link)
+		links = append(links, link)
+	}
 
 	// for the "volumes" resource
 	if ok {
@@ -381,6 +764,108 @@ func listResources(c *Context, w http.ResponseWriter, r *http.Request) (Response
 		links = append(links, link)
 	}
 
+	// for the "operations" resource
+	link = types.APILink{
+		Rel:        "operations",
+		Version:    OperationsV1,
+		MinVersion: OperationsV1,
+	}
+
+	if !ok {
+		link.Href = fmt.Sprintf("%s/operations", c.URL)
+	} else {
+		link.Href = fmt.Sprintf("%s/%s/operations", c.URL, tenantID)
+	}
+
+	links = append(links, link)
+
+	// for the "catalog" resource
+	link = types.APILink{
+		Rel:        "catalog",
+		Version:    CatalogV1,
+		MinVersion: CatalogV1,
+	}
+
+	if !ok {
+		link.Href = fmt.Sprintf("%s/catalog", c.URL)
+	} else {
+		link.Href = fmt.Sprintf("%s/%s/catalog", c.URL, tenantID)
+	}
+
+	links = append(links, link)
+
+	// for the "ipam" resource
+	if !ok {
+		link = types.APILink{
+			Rel:        "ipam",
+			Version:    IPAMV1,
+			MinVersion: IPAMV1,
+		}
+
+		link.Href = fmt.Sprintf("%s/ipam", c.URL)
+		links = append(links, link)
+	}
+
+	// for the "ips" resource
+	if ok {
+		link = types.APILink{
+			Rel:        "ips",
+			Version:    IPsV1,
+			MinVersion: IPsV1,
+		}
+
+		link.Href = fmt.Sprintf("%s/%s/ips", c.URL, tenantID)
+		links = append(links, link)
+	}
+
+	// for the "secrets" resource
+	if ok {
+		link = types.APILink{
+			Rel:        "secrets",
+			Version:    SecretsV1,
+			MinVersion: SecretsV1,
+		}
+
+		link.Href = fmt.Sprintf("%s/%s/secrets", c.URL, tenantID)
+		links = append(links, link)
+	}
+
+	// for the "tokens" resource
+	if ok {
+		link = types.APILink{
+			Rel:        "tokens",
+			Version:    TokensV1,
+			MinVersion: TokensV1,
+		}
+
+		link.Href = fmt.Sprintf("%s/%s/tokens", c.URL, tenantID)
+		links = append(links, link)
+	}
+
+	// for the "recycle-bin" resource
+	if ok {
+		link = types.APILink{
+			Rel:        "recycle-bin",
+			Version:    RecycleBinV1,
+			MinVersion: RecycleBinV1,
+		}
+
+		link.Href = fmt.Sprintf("%s/%s/recycle-bin", c.URL, tenantID)
+		links = append(links, link)
+	}
+
+	// for the "commands" resource
+	if !ok {
+		link = types.APILink{
+			Rel:        "commands",
+			Version:    CommandsV1,
+			MinVersion: CommandsV1,
+		}
+
+		link.Href = fmt.Sprintf("%s/commands", c.URL)
+		links = append(links, link)
+	}
+
 	return Response{http.StatusOK, links}, nil
 }
 
@@ -388,7 +873,7 @@ func showPool(c *Context, w http.ResponseWriter, r *http.Request) (Response, err
 	vars := mux.Vars(r)
 	ID := vars["pool"]
 
-	pool, err := c.ShowPool(ID)
+	pool, err := c.ShowPool(r.Context(), ID)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -401,7 +886,7 @@ func listPools(c *Context, w http.ResponseWriter, r *http.Request) (Response, er
 	vars := mux.Vars(r)
 	_, ok := vars["tenant"]
 
-	pools, err := c.ListPools()
+	pools, err := c.ListPools(r.Context())
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -464,7 +949,7 @@ func addPool(c *Context, w http.ResponseWriter, r *http.Request) (Response, erro
 		ips = append(ips, ip.IP)
 	}
 
-	_, err = c.AddPool(req.Name, req.Subnet, ips)
+	_, err = c.AddPool(r.Context(), req.Name, req.Subnet, ips)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -476,7 +961,7 @@ func deletePool(c *Context, w http.ResponseWriter, r *http.Request) (Response, e
 	vars := mux.Vars(r)
 	ID := vars["pool"]
 
-	err := c.DeletePool(ID)
+	err := c.DeletePool(r.Context(), ID)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -506,7 +991,7 @@ func addToPool(c *Context, w http.ResponseWriter, r *http.Request) (Response, er
 		ips = append(ips, ip.IP)
 	}
 
-	err = c.AddAddress(ID, req.Subnet, ips)
+	err = c.AddAddress(r.Context(), ID, req.Subnet, ips)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -519,7 +1004,7 @@ func deleteSubnet(c *Context, w http.ResponseWriter, r *http.Request) (Response,
 	poolID := vars["pool"]
 	subnetID := vars["subnet"]
 
-	err := c.RemoveAddress(poolID, &subnetID, nil)
+	err := c.RemoveAddress(r.Context(), poolID, &subnetID, nil)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -532,7 +1017,7 @@ func deleteExternalIP(c *Context, w http.ResponseWriter, r *http.Request) (Respo
 	poolID := vars["pool"]
 	IPID := vars["ip_id"]
 
-	err := c.RemoveAddress(poolID, nil, &IPID)
+	err := c.RemoveAddress(r.Context(), poolID, nil, &IPID)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -547,11 +1032,11 @@ func listMappedIPs(c *Context, w http.ResponseWriter, r *http.Request) (Response
 	var short []types.MappedIPShort
 
 	if !ok {
-		IPs = c.ListMappedAddresses(nil)
+		IPs = c.ListMappedAddresses(r.Context(), nil)
 		return Response{http.StatusOK, IPs}, nil
 	}
 
-	IPs = c.ListMappedAddresses(&tenantID)
+	IPs = c.ListMappedAddresses(r.Context(), &tenantID)
 	for _, IP := range IPs {
 		s := types.MappedIPShort{
 			ID:         IP.ID,
@@ -582,7 +1067,7 @@ func mapExternalIP(c *Context, w http.ResponseWriter, r *http.Request) (Response
 
 	tenantID := vars["tenant"]
 
-	err = c.MapAddress(tenantID, req.PoolName, req.InstanceID)
+	err = c.MapAddress(r.Context(), tenantID, req.PoolName, req.InstanceID)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -598,14 +1083,14 @@ func unmapExternalIP(c *Context, w http.ResponseWriter, r *http.Request) (Respon
 	var IPs []types.MappedIP
 
 	if !ok {
-		IPs = c.ListMappedAddresses(nil)
+		IPs = c.ListMappedAddresses(r.Context(), nil)
 	} else {
-		IPs = c.ListMappedAddresses(&tenantID)
+		IPs = c.ListMappedAddresses(r.Context(), &tenantID)
 	}
 
 	for _, m := range IPs {
 		if m.ID == mappingID {
-			err := c.UnMapAddress(m.ExternalIP)
+			err := c.UnMapAddress(r.Context(), m.ExternalIP)
 			if err != nil {
 				return errorResponse(err), err
 			}
@@ -642,7 +1127,7 @@ func addWorkload(c *Context, w http.ResponseWriter, r *http.Request) (Response,
 		req.Visibility = types.Public
 	}
 
-	wl, err := c.CreateWorkload(req)
+	wl, err := c.CreateWorkload(r.Context(), req)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -677,7 +1162,7 @@ func deleteWorkload(c *Context, w http.ResponseWriter, r *http.Request) (Respons
 		tenantID = "admin"
 	}
 
-	err := c.DeleteWorkload(tenantID, ID)
+	err := c.DeleteWorkload(r.Context(), tenantID, ID)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -694,7 +1179,7 @@ func showWorkload(c *Context, w http.ResponseWriter, r *http.Request) (Response,
 		tenant = "admin"
 	}
 
-	wl, err := c.ShowWorkload(tenant, ID)
+	wl, err := c.ShowWorkload(r.Context(), tenant, ID)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -707,7 +1192,7 @@ func listWorkloads(c *Context, w http.ResponseWriter, r *http.Request) (Response
 
 	tenant := vars["tenant"]
 
-	wls, err := c.ListWorkloads(tenant)
+	wls, err := c.ListWorkloads(r.Context(), tenant)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -723,7 +1208,7 @@ func listQuotas(c *Context, w http.ResponseWriter, r *http.Request) (Response, e
 	}
 
 	var resp types.QuotaListResponse
-	resp.Quotas = c.ListQuotas(tenantID)
+	resp.Quotas = c.ListQuotas(r.Context(), tenantID)
 
 	return Response{http.StatusOK, resp}, nil
 }
@@ -743,13 +1228,15 @@ func updateQuotas(c *Context, w http.ResponseWriter, r *http.Request) (Response,
 		return errorResponse(err), err
 	}
 
-	err = c.UpdateQuotas(tenantID, req.Quotas)
+	var resp types.QuotaUpdateResponse
+	resp.Previous = c.ListQuotas(r.Context(), tenantID)
+
+	err = c.UpdateQuotas(r.Context(), tenantID, req.Quotas)
 	if err != nil {
 		return errorResponse(err), err
 	}
 
-	var resp types.QuotaListResponse
-	resp.Quotas = c.ListQuotas(tenantID)
+	resp.Quotas = c.ListQuotas(r.Context(), tenantID)
 
 	return Response{http.StatusCreated, resp}, nil
 }
@@ -770,9 +1257,13 @@ func changeNodeStatus(c *Context, w http.ResponseWriter, r *http.Request) (Respo
 	}
 
 	if status.Status == types.NodeStatusReady {
-		err = c.RestoreNode(ID)
+		err = c.RestoreNode(r.Context(), ID)
 	} else if status.Status == types.NodeStatusMaintenance {
-		err = c.EvacuateNode(ID)
+		var op types.Operation
+		op, err = c.EvacuateNode(r.Context(), ID)
+		if err == nil {
+			w.Header().Set("Location", fmt.Sprintf("%s/operations/%s", c.URL, op.ID))
+		}
 	} else {
 		err = fmt.Errorf("Cannot transition node %s to %s",
 			ID, status.Status)
@@ -785,13 +1276,58 @@ func changeNodeStatus(c *Context, w http.ResponseWriter, r *http.Request) (Respo
 	return Response{http.StatusNoContent, nil}, nil
 }
 
+func listNodePolicies(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	policies, err := c.ListNodePolicies(r.Context())
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, types.NodePolicies{Policies: policies}}, nil
+}
+
+func updateNodePolicy(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	ID := vars["node_id"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	var policy types.NodePolicy
+	err = json.Unmarshal(body, &policy)
+	if err != nil {
+		return errorResponse(err), err
+	}
+	policy.NodeID = ID
+
+	err = c.UpdateNodePolicy(r.Context(), policy)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func deleteNodePolicy(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	ID := vars["node_id"]
+
+	err := c.DeleteNodePolicy(r.Context(), ID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
 func listTenants(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	var resp types.TenantsListResponse
 
 	queries := r.URL.Query()
 	IDs, returnSingleTenant := queries["id"]
 
-	tenants, err := c.ListTenants()
+	tenants, err := c.ListTenants(r.Context())
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -816,7 +1352,7 @@ func showTenant(c *Context, w http.ResponseWriter, r *http.Request) (Response, e
 	vars := mux.Vars(r)
 	ID := vars["tenant"]
 
-	resp, err := c.ShowTenant(ID)
+	resp, err := c.ShowTenant(r.Context(), ID)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -833,12 +1369,12 @@ func updateTenant(c *Context, w http.ResponseWriter, r *http.Request) (Response,
 		return errorResponse(err), err
 	}
 
-	err = c.PatchTenant(ID, body)
+	resp, err := c.PatchTenant(r.Context(), ID, body)
 	if err != nil {
 		return errorResponse(err), err
 	}
 
-	return Response{http.StatusNoContent, nil}, nil
+	return Response{http.StatusOK, resp}, nil
 }
 
 func createTenant(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
@@ -853,7 +1389,7 @@ func createTenant(c *Context, w http.ResponseWriter, r *http.Request) (Response,
 		return errorResponse(err), err
 	}
 
-	resp, err := c.CreateTenant(req.ID, req.Config)
+	resp, err := c.CreateTenant(r.Context(), req.ID, req.Config)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -861,11 +1397,34 @@ func createTenant(c *Context, w http.ResponseWriter, r *http.Request) (Response,
 	return Response{http.StatusCreated, resp}, nil
 }
 
+func renumberTenant(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	ID := vars["tenant"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	var req types.TenantRenumberRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	resp, err := c.RenumberTenant(r.Context(), ID, req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, resp}, nil
+}
+
 func deleteTenant(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	vars := mux.Vars(r)
 	ID := vars["tenant"]
 
-	err := c.DeleteTenant(ID)
+	err := c.DeleteTenant(r.Context(), ID)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -878,7 +1437,7 @@ func validPrivilege(visibility types.Visibility, privileged bool) bool {
 }
 
 // createImage creates information about an image, but doesn't contain
-// any actual image.
+// any actual image unless it is imported from a URL.
 func createImage(context *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	vars := mux.Vars(r)
 	tenantID := vars["tenant"]
@@ -901,18 +1460,22 @@ func createImage(context *Context, w http.ResponseWriter, r *http.Request) (Resp
 		return Response{http.StatusForbidden, nil}, nil
 	}
 
-	resp, err := context.CreateImage(tenantID, req)
+	resp, err := context.CreateImage(r.Context(), tenantID, req)
 
 	if err != nil {
 		return errorResponse(err), err
 	}
 
+	if req.URL != "" {
+		return Response{http.StatusAccepted, resp}, nil
+	}
+
 	return Response{http.StatusCreated, resp}, nil
 }
 
-// listImages returns a list of all created images.
+// listImages returns a page of the images selected by the filter given as
+// query parameters.
 //
-// TBD: support query & sort parameters
 func listImages(context *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	vars := mux.Vars(r)
 	tenantID, ok := vars["tenant"]
@@ -920,9 +1483,19 @@ func listImages(context *Context, w http.ResponseWriter, r *http.Request) (Respo
 		tenantID = "admin"
 	}
 
-	images, err := context.ListImages(tenantID)
+	filter, err := parseImageFilter(r.URL.Query())
 	if err != nil {
-		return errorResponse(err), err
+		return Response{http.StatusBadRequest, nil}, err
+	}
+
+	images, err := context.ListImages(r.Context(), tenantID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	images, err = filterImages(images, filter)
+	if err != nil {
+		return Response{http.StatusBadRequest, nil}, err
 	}
 
 	return Response{http.StatusOK, images}, nil
@@ -939,7 +1512,7 @@ func getImage(context *Context, w http.ResponseWriter, r *http.Request) (Respons
 		tenantID = "admin"
 	}
 
-	image, err := context.GetImage(tenantID, imageID)
+	image, err := context.GetImage(r.Context(), tenantID, imageID)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -947,6 +1520,10 @@ func getImage(context *Context, w http.ResponseWriter, r *http.Request) (Respons
 	return Response{http.StatusOK, image}, nil
 }
 
+func imageServiceDisabled(context *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	return errorResponse(ErrImageServiceDisabled), ErrImageServiceDisabled
+}
+
 func uploadImage(context *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	vars := mux.Vars(r)
 	imageID := vars["image_id"]
@@ -956,7 +1533,7 @@ func uploadImage(context *Context, w http.ResponseWriter, r *http.Request) (Resp
 		tenantID = "admin"
 	}
 
-	err := context.UploadImage(tenantID, imageID, r.Body)
+	err := context.UploadImage(r.Context(), tenantID, imageID, r.Body)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -972,7 +1549,7 @@ func deleteImage(context *Context, w http.ResponseWriter, r *http.Request) (Resp
 		tenantID = "admin"
 	}
 
-	err := context.DeleteImage(tenantID, imageID)
+	err := context.DeleteImage(r.Context(), tenantID, imageID)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -980,6 +1557,24 @@ func deleteImage(context *Context, w http.ResponseWriter, r *http.Request) (Resp
 	return Response{http.StatusNoContent, nil}, nil
 }
 
+func showImageGC(context *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	report, err := context.ShowImageGC(r.Context())
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, report}, nil
+}
+
+func collectImageGarbage(context *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	report, err := context.CollectImageGarbage(r.Context())
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, report}, nil
+}
+
 func createVolume(bc *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	vars := mux.Vars(r)
 	tenant := vars["tenant"]
@@ -995,7 +1590,7 @@ func createVolume(bc *Context, w http.ResponseWriter, r *http.Request) (Response
 		return Response{http.StatusInternalServerError, nil}, err
 	}
 
-	vol, err := bc.CreateVolume(tenant, req)
+	vol, err := bc.CreateVolume(r.Context(), tenant, req)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -1007,7 +1602,7 @@ func listVolumesDetail(bc *Context, w http.ResponseWriter, r *http.Request) (Res
 	vars := mux.Vars(r)
 	tenant := vars["tenant"]
 
-	vols, err := bc.ListVolumesDetail(tenant)
+	vols, err := bc.ListVolumesDetail(r.Context(), tenant)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -1020,7 +1615,20 @@ func showVolumeDetails(bc *Context, w http.ResponseWriter, r *http.Request) (Res
 	tenant := vars["tenant"]
 	volume := vars["volume_id"]
 
-	vol, err := bc.ShowVolumeDetails(tenant, volume)
+	vol, err := bc.ShowVolumeDetails(r.Context(), tenant, volume)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, vol}, nil
+}
+
+func showVolumeByName(bc *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	name := vars["name"]
+
+	vol, err := bc.ShowVolumeByName(r.Context(), tenant, name)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -1034,7 +1642,7 @@ func deleteVolume(bc *Context, w http.ResponseWriter, r *http.Request) (Response
 	volume := vars["volume_id"]
 
 	// TBD - satisfy preconditions here, or in interface?
-	err := bc.DeleteVolume(tenant, volume)
+	err := bc.DeleteVolume(r.Context(), tenant, volume)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -1042,7 +1650,7 @@ func deleteVolume(bc *Context, w http.ResponseWriter, r *http.Request) (Response
 	return Response{http.StatusAccepted, nil}, nil
 }
 
-func volumeActionAttach(bc *Context, m map[string]interface{}, tenant string, volume string) (Response, error) {
+func volumeActionAttach(ctx context.Context, bc *Context, m map[string]interface{}, tenant string, volume string) (Response, error) {
 	val := m["attach"]
 
 	m = val.(map[string]interface{})
@@ -1061,7 +1669,7 @@ func volumeActionAttach(bc *Context, m map[string]interface{}, tenant string, vo
 	}
 	mountPoint := val.(string)
 
-	err := bc.AttachVolume(tenant, volume, instance, mountPoint)
+	err := bc.AttachVolume(ctx, tenant, volume, instance, mountPoint)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -1069,7 +1677,7 @@ func volumeActionAttach(bc *Context, m map[string]interface{}, tenant string, vo
 	return Response{http.StatusAccepted, nil}, nil
 }
 
-func volumeActionDetach(bc *Context, m map[string]interface{}, tenant string, volume string) (Response, error) {
+func volumeActionDetach(ctx context.Context, bc *Context, m map[string]interface{}, tenant string, volume string) (Response, error) {
 	val := m["detach"]
 
 	m = val.(map[string]interface{})
@@ -1081,7 +1689,7 @@ func volumeActionDetach(bc *Context, m map[string]interface{}, tenant string, vo
 		attachment = val.(string)
 	}
 
-	err := bc.DetachVolume(tenant, volume, attachment)
+	err := bc.DetachVolume(ctx, tenant, volume, attachment)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -1111,11 +1719,11 @@ func volumeAction(bc *Context, w http.ResponseWriter, r *http.Request) (Response
 	// for now, we will support only attach and detach
 
 	if m["attach"] != nil {
-		return volumeActionAttach(bc, m, tenant, volume)
+		return volumeActionAttach(r.Context(), bc, m, tenant, volume)
 	}
 
 	if m["detach"] != nil {
-		return volumeActionDetach(bc, m, tenant, volume)
+		return volumeActionDetach(r.Context(), bc, m, tenant, volume)
 	}
 
 	return Response{http.StatusBadRequest, nil}, err
@@ -1137,13 +1745,41 @@ func createInstance(c *Context, w http.ResponseWriter, r *http.Request) (Respons
 		return Response{http.StatusBadRequest, nil}, err
 	}
 
-	resp, err := c.CreateServer(tenant, req)
+	resp, err := c.CreateServer(r.Context(), tenant, req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusAccepted, resp}, nil
+}
+func createSimpleInstance(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return Response{http.StatusBadRequest, nil}, err
+	}
+
+	var req SimpleServerRequest
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return Response{http.StatusBadRequest, nil}, err
+	}
+
+	if req.ImageID == "" {
+		return Response{http.StatusBadRequest, nil}, errors.New("Missing image ID")
+	}
+
+	resp, err := c.CreateSimpleServer(r.Context(), tenant, req)
 	if err != nil {
 		return errorResponse(err), err
 	}
 
 	return Response{http.StatusAccepted, resp}, nil
 }
+
 func listInstanceDetails(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	vars := mux.Vars(r)
 	tenant := vars["tenant"]
@@ -1162,7 +1798,7 @@ func listInstanceDetails(c *Context, w http.ResponseWriter, r *http.Request) (Re
 		}
 	}
 
-	servers, err := c.ListServersDetail(tenant)
+	servers, err := c.ListServersDetail(r.Context(), tenant)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -1189,7 +1825,20 @@ func showInstanceDetails(c *Context, w http.ResponseWriter, r *http.Request) (Re
 	tenant := vars["tenant"]
 	server := vars["instance_id"]
 
-	resp, err := c.ShowServerDetails(tenant, server)
+	resp, err := c.ShowServerDetails(r.Context(), tenant, server)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, resp}, nil
+}
+
+func showInstanceByName(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	name := vars["name"]
+
+	resp, err := c.ShowServerByName(r.Context(), tenant, name)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -1202,7 +1851,7 @@ func deleteInstance(c *Context, w http.ResponseWriter, r *http.Request) (Respons
 	tenant := vars["tenant"]
 	server := vars["instance_id"]
 
-	err := c.DeleteServer(tenant, server)
+	err := c.DeleteServer(r.Context(), tenant, server)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -1223,9 +1872,13 @@ func instanceAction(c *Context, w http.ResponseWriter, r *http.Request) (Respons
 	bodyString := string(body)
 
 	if strings.Contains(bodyString, "os-start") {
-		err = c.StartServer(tenant, server)
+		err = c.StartServer(r.Context(), tenant, server)
 	} else if strings.Contains(bodyString, "os-stop") {
-		err = c.StopServer(tenant, server)
+		err = c.StopServer(r.Context(), tenant, server)
+	} else if strings.Contains(bodyString, "unpause") {
+		err = c.UnpauseServer(r.Context(), tenant, server)
+	} else if strings.Contains(bodyString, "pause") {
+		err = c.PauseServer(r.Context(), tenant, server)
 	} else {
 		return Response{http.StatusServiceUnavailable, nil},
 			errors.New("Unsupported Action")
@@ -1238,193 +1891,1159 @@ func instanceAction(c *Context, w http.ResponseWriter, r *http.Request) (Respons
 	return Response{http.StatusAccepted, nil}, nil
 }
 
-// Service is an interface which must be implemented by the ciao API context.
-type Service interface {
-	AddPool(name string, subnet *string, ips []string) (types.Pool, error)
-	ListPools() ([]types.Pool, error)
-	ShowPool(id string) (types.Pool, error)
-	DeletePool(id string) error
-	AddAddress(poolID string, subnet *string, IPs []string) error
-	RemoveAddress(poolID string, subnetID *string, IPID *string) error
-	ListMappedAddresses(tenantID *string) []types.MappedIP
-	MapAddress(tenantID string, poolName *string, instanceID string) error
-	UnMapAddress(ID string) error
-	CreateWorkload(req types.Workload) (types.Workload, error)
-	DeleteWorkload(tenantID string, workloadID string) error
-	ShowWorkload(tenantID string, workloadID string) (types.Workload, error)
-	ListWorkloads(tenantID string) ([]types.Workload, error)
-	ListQuotas(tenantID string) []types.QuotaDetails
-	UpdateQuotas(tenantID string, qds []types.QuotaDetails) error
-	EvacuateNode(nodeID string) error
-	RestoreNode(nodeID string) error
-	ListTenants() ([]types.TenantSummary, error)
-	ShowTenant(ID string) (types.TenantConfig, error)
-	PatchTenant(ID string, patch []byte) error
-	CreateTenant(ID string, config types.TenantConfig) (types.TenantSummary, error)
-	DeleteTenant(ID string) error
-	CreateImage(string, CreateImageRequest) (types.Image, error)
-	UploadImage(string, string, io.Reader) error
-	ListImages(string) ([]types.Image, error)
-	GetImage(string, string) (types.Image, error)
-	DeleteImage(string, string) error
-	CreateVolume(tenant string, req RequestedVolume) (types.Volume, error)
-	DeleteVolume(tenant string, volume string) error
-	AttachVolume(tenant string, volume string, instance string, mountpoint string) error
-	DetachVolume(tenant string, volume string, attachment string) error
-	ListVolumesDetail(tenant string) ([]types.Volume, error)
-	ShowVolumeDetails(tenant string, volume string) (types.Volume, error)
-	CreateServer(string, CreateServerRequest) (interface{}, error)
-	ListServersDetail(tenant string) ([]ServerDetails, error)
-	ShowServerDetails(tenant string, server string) (Server, error)
-	DeleteServer(tenant string, server string) error
-	StartServer(tenant string, server string) error
-	StopServer(tenant string, server string) error
-}
-
-// Context is used to provide the services and current URL to the handlers.
-type Context struct {
-	URL string
-	Service
-}
+func rescueInstance(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	var req RescueServerRequest
 
-// Config is used to setup the Context for the ciao API.
-type Config struct {
-	URL         string
-	CiaoService Service
-}
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	server := vars["instance_id"]
 
-// Routes returns the supported ciao API endpoints.
-// A plain application/json request will return v1 of the resource
-// since we only have one version of this api so far, that means
-// most routes will match both json as well as our custom
-// content type.
-func Routes(config Config, r *mux.Router) *mux.Router {
-	// make new Context
-	context := &Context{config.URL, config.CiaoService}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return Response{http.StatusBadRequest, nil}, err
+	}
 
-	if r == nil {
-		r = mux.NewRouter()
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			return Response{http.StatusBadRequest, nil}, err
+		}
 	}
 
-	// external IP pools
-	route := r.Handle("/", Handler{context, listResources, true})
-	route.Methods("GET")
+	err = c.RescueServer(r.Context(), tenant, server, req.ImageID)
+	if err != nil {
+		return errorResponse(err), err
+	}
 
-	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}", Handler{context, listResources, false})
-	route.Methods("GET")
+	return Response{http.StatusAccepted, nil}, nil
+}
 
-	matchContent := fmt.Sprintf("application/(%s|json)", PoolsV1)
+func cloneInstance(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	var req CloneServerRequest
 
-	route = r.Handle("/pools", Handler{context, listPools, true})
-	route.Methods("GET")
-	route.HeadersRegexp("Content-Type", matchContent)
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	server := vars["instance_id"]
 
-	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/pools", Handler{context, listPools, false})
-	route.Methods("GET")
-	route.HeadersRegexp("Content-Type", matchContent)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return Response{http.StatusBadRequest, nil}, err
+	}
 
-	route = r.Handle("/pools", Handler{context, addPool, true})
-	route.Methods("POST")
-	route.HeadersRegexp("Content-Type", matchContent)
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			return Response{http.StatusBadRequest, nil}, err
+		}
+	}
 
-	route = r.Handle("/pools/{pool:"+uuid.UUIDRegex+"}", Handler{context, showPool, true})
-	route.Methods("GET")
-	route.HeadersRegexp("Content-Type", matchContent)
+	resp, err := c.CloneServer(r.Context(), tenant, server, req)
+	if err != nil {
+		return errorResponse(err), err
+	}
 
-	route = r.Handle("/pools/{pool:"+uuid.UUIDRegex+"}", Handler{context, deletePool, true})
-	route.Methods("DELETE")
-	route.HeadersRegexp("Content-Type", matchContent)
+	return Response{http.StatusAccepted, resp}, nil
+}
 
-	route = r.Handle("/pools/{pool:"+uuid.UUIDRegex+"}", Handler{context, addToPool, true})
-	route.Methods("POST")
-	route.HeadersRegexp("Content-Type", matchContent)
+func rebootInstance(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	var req RebootServerRequest
 
-	route = r.Handle("/pools/{pool:"+uuid.UUIDRegex+"}/subnets/{subnet:"+uuid.UUIDRegex+"}", Handler{context, deleteSubnet, true})
-	route.Methods("DELETE")
-	route.HeadersRegexp("Content-Type", matchContent)
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	server := vars["instance_id"]
 
-	route = r.Handle("/pools/{pool:"+uuid.UUIDRegex+"}/external-ips/{ip_id:"+uuid.UUIDRegex+"}", Handler{context, deleteExternalIP, true})
-	route.Methods("DELETE")
-	route.HeadersRegexp("Content-Type", matchContent)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return Response{http.StatusBadRequest, nil}, err
+	}
 
-	// mapped external IPs
-	matchContent = fmt.Sprintf("application/(%s|json)", ExternalIPsV1)
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			return Response{http.StatusBadRequest, nil}, err
+		}
+	}
 
-	route = r.Handle("/external-ips", Handler{context, listMappedIPs, true})
-	route.Methods("GET")
-	route.HeadersRegexp("Content-Type", matchContent)
+	var hard bool
+	switch req.Type {
+	case "", RebootSoft:
+	case RebootHard:
+		hard = true
+	default:
+		return Response{http.StatusBadRequest, nil},
+			fmt.Errorf("Invalid reboot type %s", req.Type)
+	}
 
-	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/external-ips", Handler{context, listMappedIPs, false})
-	route.Methods("GET")
-	route.HeadersRegexp("Content-Type", matchContent)
+	err = c.RebootServer(r.Context(), tenant, server, hard)
+	if err != nil {
+		return errorResponse(err), err
+	}
 
-	route = r.Handle("/external-ips", Handler{context, mapExternalIP, true})
-	route.Methods("POST")
-	route.HeadersRegexp("Content-Type", matchContent)
+	return Response{http.StatusAccepted, nil}, nil
+}
 
-	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/external-ips", Handler{context, mapExternalIP, false})
-	route.Methods("POST")
-	route.HeadersRegexp("Content-Type", matchContent)
+func guestAgentInstance(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	var req types.GuestAgentRequest
 
-	route = r.Handle("/external-ips/{mapping_id:"+uuid.UUIDRegex+"}", Handler{context, unmapExternalIP, true})
-	route.Methods("DELETE")
-	route.HeadersRegexp("Content-Type", matchContent)
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	server := vars["instance_id"]
 
-	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/external-ips/{mapping_id:"+uuid.UUIDRegex+"}", Handler{context, unmapExternalIP, false})
-	route.Methods("DELETE")
-	route.HeadersRegexp("Content-Type", matchContent)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return Response{http.StatusBadRequest, nil}, err
+	}
 
-	// workloads
-	matchContent = fmt.Sprintf("application/(%s|json)", WorkloadsV1)
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return Response{http.StatusBadRequest, nil}, err
+	}
 
-	route = r.Handle("/workloads", Handler{context, addWorkload, true})
-	route.Methods("POST")
-	route.HeadersRegexp("Content-Type", matchContent)
+	switch req.Operation {
+	case types.GuestAgentExec:
+		if req.Path == "" {
+			return Response{http.StatusBadRequest, nil},
+				fmt.Errorf("No command to run in the guest")
+		}
+	case types.GuestAgentFreeze, types.GuestAgentThaw, types.GuestAgentNetworkInfo:
+	default:
+		return Response{http.StatusBadRequest, nil},
+			fmt.Errorf("Invalid guest agent operation %s", req.Operation)
+	}
 
-	route = r.Handle("/workloads", Handler{context, listWorkloads, true})
-	route.Methods("GET")
-	route.HeadersRegexp("Content-Type", matchContent)
+	result, err := c.GuestAgentCommand(r.Context(), tenant, server, req)
+	if err != nil {
+		return errorResponse(err), err
+	}
 
-	route = r.Handle("/workloads/{workload_id:"+uuid.UUIDRegex+"}", Handler{context, deleteWorkload, true})
-	route.Methods("DELETE")
-	route.HeadersRegexp("Content-Type", matchContent)
+	return Response{http.StatusOK, result}, nil
+}
 
-	route = r.Handle("/workloads/{workload_id:"+uuid.UUIDRegex+"}", Handler{context, showWorkload, true})
-	route.Methods("GET")
-	route.HeadersRegexp("Content-Type", matchContent)
+func captureInstance(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	var req types.PacketCaptureRequest
 
-	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/workloads", Handler{context, addWorkload, false})
-	route.Methods("POST")
-	route.HeadersRegexp("Content-Type", matchContent)
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	server := vars["instance_id"]
 
-	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/workloads", Handler{context, listWorkloads, false})
-	route.Methods("GET")
-	route.HeadersRegexp("Content-Type", matchContent)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return Response{http.StatusBadRequest, nil}, err
+	}
 
-	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/workloads/{workload_id:"+uuid.UUIDRegex+"}", Handler{context, deleteWorkload, false})
-	route.Methods("DELETE")
-	route.HeadersRegexp("Content-Type", matchContent)
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return Response{http.StatusBadRequest, nil}, err
+	}
 
-	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/workloads/{workload_id:"+uuid.UUIDRegex+"}", Handler{context, showWorkload, false})
-	route.Methods("GET")
-	route.HeadersRegexp("Content-Type", matchContent)
+	if req.MaxBytes < 0 || req.MaxBytes > payloads.MaxPacketCaptureBytes {
+		return Response{http.StatusBadRequest, nil},
+			fmt.Errorf("Packet captures are limited to %d bytes", payloads.MaxPacketCaptureBytes)
+	}
 
-	// tenants
-	matchContent = fmt.Sprintf("application/(%s|json)", TenantsV1)
+	if req.MaxSeconds < 0 || req.MaxSeconds > payloads.MaxPacketCaptureSeconds {
+		return Response{http.StatusBadRequest, nil},
+			fmt.Errorf("Packet captures are limited to %d seconds", payloads.MaxPacketCaptureSeconds)
+	}
 
-	route = r.Handle("/tenants", Handler{context, listTenants, true})
-	route.Methods("GET")
-	route.HeadersRegexp("Content-Type", matchContent)
+	result, err := c.PacketCaptureInstance(r.Context(), tenant, server, req)
+	if err != nil {
+		return errorResponse(err), err
+	}
 
-	route = r.Handle("/tenants", Handler{context, createTenant, true})
-	route.Methods("POST")
-	route.HeadersRegexp("Content-Type", matchContent)
+	return Response{http.StatusOK, result}, nil
+}
 
-	route = r.Handle("/tenants/{tenant:"+uuid.UUIDRegex+"}", Handler{context, showTenant, true})
-	route.Methods("GET")
-	route.HeadersRegexp("Content-Type", matchContent)
+func unrescueInstance(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	server := vars["instance_id"]
 
-	route = r.Handle("/tenants/{tenant:"+uuid.UUIDRegex+"}", Handler{context, deleteTenant, true})
+	err := c.UnrescueServer(r.Context(), tenant, server)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusAccepted, nil}, nil
+}
+
+func archiveInstance(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	server := vars["instance_id"]
+
+	op, err := c.ArchiveServer(r.Context(), tenant, server)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusAccepted, op}, nil
+}
+
+func rehydrateInstance(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	server := vars["instance_id"]
+
+	op, err := c.RehydrateServer(r.Context(), tenant, server)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusAccepted, op}, nil
+}
+
+func migrateInstance(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	var req types.MigrateRequest
+
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	server := vars["instance_id"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return Response{http.StatusBadRequest, nil}, err
+	}
+
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			return Response{http.StatusBadRequest, nil}, err
+		}
+	}
+
+	op, err := c.MigrateServer(r.Context(), tenant, server, req.NodeID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusAccepted, op}, nil
+}
+
+func listInstanceActions(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	server := vars["instance_id"]
+
+	actions, err := c.ListInstanceActions(r.Context(), tenant, server)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, actions}, nil
+}
+
+func listInstanceGroups(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	groups, err := c.ListInstanceGroups(r.Context(), tenant)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, InstanceGroups{Groups: groups}}, nil
+}
+
+func showInstanceGroup(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	group := vars["group"]
+
+	resp, err := c.ShowInstanceGroup(r.Context(), tenant, group)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, resp}, nil
+}
+
+func instanceGroupAction(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	var req InstanceGroupActionRequest
+
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	group := vars["group"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return Response{http.StatusBadRequest, nil}, err
+	}
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return Response{http.StatusBadRequest, nil}, err
+	}
+
+	resp, err := c.InstanceGroupAction(r.Context(), tenant, group, req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusAccepted, resp}, nil
+}
+
+func listOperations(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+
+	tenantID, ok := vars["tenant"]
+	if !ok {
+		tenantID = "admin"
+	}
+
+	ops, err := c.ListOperations(r.Context(), tenantID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, ops}, nil
+}
+
+func showOperation(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	operationID := vars["operation_id"]
+
+	tenantID, ok := vars["tenant"]
+	if !ok {
+		tenantID = "admin"
+	}
+
+	op, err := c.ShowOperation(r.Context(), tenantID, operationID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, op}, nil
+}
+
+func listCatalog(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	category := r.URL.Query().Get("category")
+
+	wls, err := c.ListCatalog(r.Context(), category)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, wls}, nil
+}
+
+func updateCatalog(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	ID := vars["workload_id"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	var req types.CatalogUpdateRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	if req.Category == "" {
+		return errorResponse(types.ErrBadRequest), types.ErrBadRequest
+	}
+
+	err = c.UpdateCatalogWorkload(r.Context(), ID, req.Category)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func removeFromCatalog(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	ID := vars["workload_id"]
+
+	err := c.UpdateCatalogWorkload(r.Context(), ID, "")
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func cloneCatalogWorkload(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	ID := vars["workload_id"]
+	tenantID := vars["tenant"]
+
+	wl, err := c.CloneCatalogWorkload(r.Context(), tenantID, ID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	link := types.Link{
+		Rel:  "self",
+		Href: fmt.Sprintf("%s/%s/workloads/%s", c.URL, tenantID, wl.ID),
+	}
+
+	resp := types.WorkloadResponse{
+		Workload: wl,
+		Link:     link,
+	}
+
+	return Response{http.StatusCreated, resp}, nil
+}
+
+func auditIPAM(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	audit, err := c.AuditIPAM(r.Context(), r.URL.Query().Get("tenant"), false)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, audit}, nil
+}
+
+func repairIPAM(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	audit, err := c.AuditIPAM(r.Context(), r.URL.Query().Get("tenant"), true)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, audit}, nil
+}
+
+func listFailedCommands(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	cmds, err := c.ListFailedCommands(r.Context())
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, cmds}, nil
+}
+
+func replayFailedCommand(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	ID := vars["command_id"]
+
+	err := c.ReplayFailedCommand(r.Context(), ID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func deleteFailedCommand(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	ID := vars["command_id"]
+
+	err := c.DeleteFailedCommand(r.Context(), ID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func listFederationPeers(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	peers, err := c.ListFederationPeers(r.Context())
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, peers}, nil
+}
+
+func addFederationPeer(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	var req types.FederationPeerRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	if req.URL == "" || req.TenantID == "" || req.APIToken == "" || len(req.Workloads) == 0 {
+		return errorResponse(types.ErrBadRequest), types.ErrBadRequest
+	}
+
+	peer, err := c.AddFederationPeer(r.Context(), req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusCreated, peer}, nil
+}
+
+func deleteFederationPeer(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	ID := vars["peer_id"]
+
+	err := c.DeleteFederationPeer(r.Context(), ID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func showTenantUsage(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+
+	summary, err := c.ShowTenantUsage(r.Context(), tenantID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, summary}, nil
+}
+
+func showCluster(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	summary, err := c.ShowCluster(r.Context())
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, summary}, nil
+}
+
+func openConsole(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	var req types.ConsoleRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	if req.InstanceID == "" {
+		return errorResponse(types.ErrBadRequest), types.ErrBadRequest
+	}
+
+	session, err := c.OpenConsole(r.Context(), req.InstanceID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusCreated, session}, nil
+}
+
+func writeConsole(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	ID := vars["session_id"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	var req types.ConsoleInputRequest
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			return errorResponse(err), err
+		}
+	}
+
+	session, err := c.WriteConsole(r.Context(), ID, req.Input)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, session}, nil
+}
+
+func closeConsole(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	ID := vars["session_id"]
+
+	err := c.CloseConsole(r.Context(), ID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func listIPReservations(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+
+	reservations, err := c.ListIPReservations(r.Context(), tenantID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, reservations}, nil
+}
+
+func reserveIP(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	var req types.IPReservationRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	if req.IPAddress == "" {
+		return errorResponse(types.ErrBadRequest), types.ErrBadRequest
+	}
+
+	reservation, err := c.ReserveIP(r.Context(), tenantID, req.IPAddress)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusCreated, reservation}, nil
+}
+
+func releaseIPReservation(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+	IP := vars["ip"]
+
+	err := c.ReleaseIPReservation(r.Context(), tenantID, IP)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func listSecrets(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+
+	secrets, err := c.ListSecrets(r.Context(), tenantID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, secrets}, nil
+}
+
+func setSecret(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+	name := vars["name"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	var req types.SecretRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	err = c.SetSecret(r.Context(), tenantID, name, req.Value)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func deleteSecret(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+	name := vars["name"]
+
+	err := c.DeleteSecret(r.Context(), tenantID, name)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func listAPITokens(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+
+	tokens, err := c.ListAPITokens(r.Context(), tenantID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, tokens}, nil
+}
+
+// errAPITokenManagement is returned when a client authenticated with an API
+// token tries to issue or revoke API tokens.
+var errAPITokenManagement = errors.New("API tokens cannot be managed with an API token")
+
+func createAPIToken(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+
+	if _, ok := service.GetAPIToken(r.Context()); ok {
+		return Response{http.StatusForbidden, nil}, errAPITokenManagement
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	var req types.APITokenRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	token, err := c.CreateAPIToken(r.Context(), tenantID, req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusCreated, token}, nil
+}
+
+func deleteAPIToken(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+	tokenID := vars["token_id"]
+
+	if _, ok := service.GetAPIToken(r.Context()); ok {
+		return Response{http.StatusForbidden, nil}, errAPITokenManagement
+	}
+
+	err := c.DeleteAPIToken(r.Context(), tenantID, tokenID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func createSnapshotSet(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+	instanceID := vars["instance_id"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return Response{http.StatusBadRequest, nil}, err
+	}
+
+	var req types.SnapshotSetRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return Response{http.StatusBadRequest, nil}, err
+	}
+
+	set, err := c.CreateSnapshotSet(r.Context(), tenantID, instanceID, req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusCreated, set}, nil
+}
+
+func listSnapshotSets(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+
+	sets, err := c.ListSnapshotSets(r.Context(), tenantID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, sets}, nil
+}
+
+func showSnapshotSet(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+	ID := vars["snapshot_id"]
+
+	set, err := c.ShowSnapshotSet(r.Context(), tenantID, ID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, set}, nil
+}
+
+func deleteSnapshotSet(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+	ID := vars["snapshot_id"]
+
+	err := c.DeleteSnapshotSet(r.Context(), tenantID, ID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func restoreSnapshotSet(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	var req types.SnapshotSetRestoreRequest
+
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+	ID := vars["snapshot_id"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return Response{http.StatusBadRequest, nil}, err
+	}
+
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			return Response{http.StatusBadRequest, nil}, err
+		}
+	}
+
+	servers, err := c.RestoreSnapshotSet(r.Context(), tenantID, ID, req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusAccepted, servers}, nil
+}
+
+func submitSignedRequest(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return Response{http.StatusBadRequest, nil}, err
+	}
+
+	var req types.SignedRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return Response{http.StatusBadRequest, nil}, err
+	}
+
+	result, err := c.SubmitSignedRequest(r.Context(), req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, result}, nil
+}
+
+func listSignedRequests(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	records, err := c.ListSignedRequests(r.Context())
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, records}, nil
+}
+
+func listDeletedResources(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+
+	resources, err := c.ListDeletedResources(r.Context(), tenantID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, resources}, nil
+}
+
+func restoreDeletedResource(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+	resourceID := vars["resource_id"]
+
+	err := c.RestoreDeletedResource(r.Context(), tenantID, resourceID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func purgeDeletedResource(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+	resourceID := vars["resource_id"]
+
+	err := c.PurgeDeletedResource(r.Context(), tenantID, resourceID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+// Service is an interface which must be implemented by the ciao API context.
+type Service interface {
+	AddPool(ctx context.Context, name string, subnet *string, ips []string) (types.Pool, error)
+	ListPools(ctx context.Context) ([]types.Pool, error)
+	ShowPool(ctx context.Context, id string) (types.Pool, error)
+	DeletePool(ctx context.Context, id string) error
+	AddAddress(ctx context.Context, poolID string, subnet *string, IPs []string) error
+	RemoveAddress(ctx context.Context, poolID string, subnetID *string, IPID *string) error
+	ListMappedAddresses(ctx context.Context, tenantID *string) []types.MappedIP
+	MapAddress(ctx context.Context, tenantID string, poolName *string, instanceID string) error
+	UnMapAddress(ctx context.Context, ID string) error
+	CreateWorkload(ctx context.Context, req types.Workload) (types.Workload, error)
+	DeleteWorkload(ctx context.Context, tenantID string, workloadID string) error
+	ShowWorkload(ctx context.Context, tenantID string, workloadID string) (types.Workload, error)
+	ListWorkloads(ctx context.Context, tenantID string) ([]types.Workload, error)
+	ListCatalog(ctx context.Context, category string) ([]types.Workload, error)
+	UpdateCatalogWorkload(ctx context.Context, workloadID string, category string) error
+	CloneCatalogWorkload(ctx context.Context, tenantID string, workloadID string) (types.Workload, error)
+	ListQuotas(ctx context.Context, tenantID string) []types.QuotaDetails
+	UpdateQuotas(ctx context.Context, tenantID string, qds []types.QuotaDetails) error
+	EvacuateNode(ctx context.Context, nodeID string) (types.Operation, error)
+	RestoreNode(ctx context.Context, nodeID string) error
+	ListNodePolicies(ctx context.Context) ([]types.NodePolicy, error)
+	UpdateNodePolicy(ctx context.Context, policy types.NodePolicy) error
+	DeleteNodePolicy(ctx context.Context, nodeID string) error
+	ListTenants(ctx context.Context) ([]types.TenantSummary, error)
+	ShowTenant(ctx context.Context, ID string) (types.TenantConfig, error)
+	ShowTenantUsage(ctx context.Context, ID string) (types.TenantUsageSummary, error)
+	PatchTenant(ctx context.Context, ID string, patch []byte) (types.TenantUpdateResponse, error)
+	RenumberTenant(ctx context.Context, ID string, req types.TenantRenumberRequest) (types.TenantRenumbering, error)
+	CreateTenant(ctx context.Context, ID string, config types.TenantConfig) (types.TenantSummary, error)
+	DeleteTenant(ctx context.Context, ID string) error
+	CreateImage(context.Context, string, CreateImageRequest) (types.Image, error)
+	UploadImage(context.Context, string, string, io.Reader) error
+	ListImages(context.Context, string) ([]types.Image, error)
+	GetImage(context.Context, string, string) (types.Image, error)
+	DeleteImage(context.Context, string, string) error
+	ShowImageGC(ctx context.Context) (types.ImageGCReport, error)
+	CollectImageGarbage(ctx context.Context) (types.ImageGCReport, error)
+	CreateVolume(ctx context.Context, tenant string, req RequestedVolume) (types.Volume, error)
+	DeleteVolume(ctx context.Context, tenant string, volume string) error
+	AttachVolume(ctx context.Context, tenant string, volume string, instance string, mountpoint string) error
+	DetachVolume(ctx context.Context, tenant string, volume string, attachment string) error
+	ListVolumesDetail(ctx context.Context, tenant string) ([]types.Volume, error)
+	ShowVolumeDetails(ctx context.Context, tenant string, volume string) (types.Volume, error)
+	ShowVolumeByName(ctx context.Context, tenant string, name string) (types.Volume, error)
+	CreateServer(context.Context, string, CreateServerRequest) (interface{}, error)
+	CreateSimpleServer(ctx context.Context, tenant string, req SimpleServerRequest) (interface{}, error)
+	ListServersDetail(ctx context.Context, tenant string) ([]ServerDetails, error)
+	ShowServerDetails(ctx context.Context, tenant string, server string) (Server, error)
+	ShowServerByName(ctx context.Context, tenant string, name string) (Server, error)
+	DeleteServer(ctx context.Context, tenant string, server string) error
+	StartServer(ctx context.Context, tenant string, server string) error
+	StopServer(ctx context.Context, tenant string, server string) error
+	PauseServer(ctx context.Context, tenant string, server string) error
+	UnpauseServer(ctx context.Context, tenant string, server string) error
+	RescueServer(ctx context.Context, tenant string, server string, imageID string) error
+	UnrescueServer(ctx context.Context, tenant string, server string) error
+	ArchiveServer(ctx context.Context, tenant string, server string) (types.Operation, error)
+	RehydrateServer(ctx context.Context, tenant string, server string) (types.Operation, error)
+	MigrateServer(ctx context.Context, tenant string, server string, nodeID string) (types.Operation, error)
+	GuestAgentCommand(ctx context.Context, tenant string, server string, req types.GuestAgentRequest) (types.GuestAgentResult, error)
+	PacketCaptureInstance(ctx context.Context, tenant string, server string, req types.PacketCaptureRequest) (types.PacketCaptureResult, error)
+	RebootServer(ctx context.Context, tenant string, server string, hard bool) error
+	CloneServer(ctx context.Context, tenant string, server string, req CloneServerRequest) (Servers, error)
+	ListInstanceActions(ctx context.Context, tenant string, server string) ([]types.InstanceAction, error)
+	ListInstanceGroups(ctx context.Context, tenant string) ([]InstanceGroup, error)
+	ShowInstanceGroup(ctx context.Context, tenant string, group string) (InstanceGroup, error)
+	InstanceGroupAction(ctx context.Context, tenant string, group string, req InstanceGroupActionRequest) (InstanceGroupActionResult, error)
+	ListOperations(ctx context.Context, tenant string) ([]types.Operation, error)
+	ShowOperation(ctx context.Context, tenant string, operation string) (types.Operation, error)
+	AuditIPAM(ctx context.Context, tenantID string, repair bool) (types.IPAMAudit, error)
+	ListIPReservations(ctx context.Context, tenantID string) ([]types.IPReservation, error)
+	ReserveIP(ctx context.Context, tenantID string, IP string) (types.IPReservation, error)
+	ReleaseIPReservation(ctx context.Context, tenantID string, IP string) error
+	ListSecrets(ctx context.Context, tenantID string) ([]types.Secret, error)
+	SetSecret(ctx context.Context, tenantID string, name string, value string) error
+	DeleteSecret(ctx context.Context, tenantID string, name string) error
+	ListAPITokens(ctx context.Context, tenantID string) ([]types.APIToken, error)
+	CreateAPIToken(ctx context.Context, tenantID string, req types.APITokenRequest) (types.APIToken, error)
+	DeleteAPIToken(ctx context.Context, tenantID string, tokenID string) error
+	ListDeletedResources(ctx context.Context, tenantID string) ([]types.DeletedResource, error)
+	RestoreDeletedResource(ctx context.Context, tenantID string, resourceID string) error
+	CreateSnapshotSet(ctx context.Context, tenantID string, instanceID string, req types.SnapshotSetRequest) (types.SnapshotSet, error)
+	ListSnapshotSets(ctx context.Context, tenantID string) ([]types.SnapshotSet, error)
+	ShowSnapshotSet(ctx context.Context, tenantID string, ID string) (types.SnapshotSet, error)
+	DeleteSnapshotSet(ctx context.Context, tenantID string, ID string) error
+	RestoreSnapshotSet(ctx context.Context, tenantID string, ID string, req types.SnapshotSetRestoreRequest) (Servers, error)
+	SubmitSignedRequest(ctx context.Context, req types.SignedRequest) (types.SignedRequestResult, error)
+	ListSignedRequests(ctx context.Context) ([]types.SignedRequestRecord, error)
+	PurgeDeletedResource(ctx context.Context, tenantID string, resourceID string) error
+	ListFailedCommands(ctx context.Context) ([]types.FailedCommand, error)
+	ReplayFailedCommand(ctx context.Context, ID string) error
+	DeleteFailedCommand(ctx context.Context, ID string) error
+	ListFederationPeers(ctx context.Context) ([]types.FederationPeer, error)
+	AddFederationPeer(ctx context.Context, req types.FederationPeerRequest) (types.FederationPeer, error)
+	DeleteFederationPeer(ctx context.Context, ID string) error
+	OpenConsole(ctx context.Context, instanceID string) (types.ConsoleSession, error)
+	WriteConsole(ctx context.Context, ID string, input string) (types.ConsoleSession, error)
+	CloseConsole(ctx context.Context, ID string) error
+	ShowCluster(ctx context.Context) (types.ClusterSummary, error)
+}
+
+// Context is used to provide the services and current URL to the handlers.
+type Context struct {
+	URL string
+	Service
+	imagesDisabled bool
+
+	// version is the resource version the handlers using the context
+	// serve, or empty if they only serve plain JSON.
+	version string
+}
+
+// Config is used to setup the Context for the ciao API.
+type Config struct {
+	URL         string
+	CiaoService Service
+
+	// DisableImages removes the images resource, for deployments only
+	// using pre-provisioned images.
+	DisableImages bool
+}
+
+// Routes returns the supported ciao API endpoints.
+// A plain application/json request will return v1 of the resource
+// since we only have one version of this api so far, that means
+// most routes will match both json as well as our custom
+// content type.  The response is sent with the media type of the
+// resource version or as plain JSON, as negotiated with the Accept
+// header of the request.  Newer versions of a resource are to be
+// served side by side with the previous ones with Versions.
+func Routes(config Config, r *mux.Router) *mux.Router {
+	// make new Context
+	base := &Context{URL: config.URL, Service: config.CiaoService, imagesDisabled: config.DisableImages}
+	context := base
+
+	if r == nil {
+		r = mux.NewRouter()
+	}
+
+	// external IP pools
+	route := r.Handle("/", Handler{context, listResources, true})
+	route.Methods("GET")
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}", Handler{context, listResources, false})
+	route.Methods("GET")
+
+	context, matchContent := base.resource(PoolsV1)
+
+	route = r.Handle("/pools", Handler{context, listPools, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/pools", Handler{context, listPools, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/pools", Handler{context, addPool, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/pools/{pool:"+uuid.UUIDRegex+"}", Handler{context, showPool, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/pools/{pool:"+uuid.UUIDRegex+"}", Handler{context, deletePool, true})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/pools/{pool:"+uuid.UUIDRegex+"}", Handler{context, addToPool, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/pools/{pool:"+uuid.UUIDRegex+"}/subnets/{subnet:"+uuid.UUIDRegex+"}", Handler{context, deleteSubnet, true})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/pools/{pool:"+uuid.UUIDRegex+"}/external-ips/{ip_id:"+uuid.UUIDRegex+"}", Handler{context, deleteExternalIP, true})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// mapped external IPs
+	context, matchContent = base.resource(ExternalIPsV1)
+
+	route = r.Handle("/external-ips", Handler{context, listMappedIPs, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/external-ips", Handler{context, listMappedIPs, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/external-ips", Handler{context, mapExternalIP, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/external-ips", Handler{context, mapExternalIP, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/external-ips/{mapping_id:"+uuid.UUIDRegex+"}", Handler{context, unmapExternalIP, true})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/external-ips/{mapping_id:"+uuid.UUIDRegex+"}", Handler{context, unmapExternalIP, false})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// workloads
+	context, matchContent = base.resource(WorkloadsV1)
+
+	route = r.Handle("/workloads", Handler{context, addWorkload, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/workloads", Handler{context, listWorkloads, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/workloads/{workload_id:"+uuid.UUIDRegex+"}", Handler{context, deleteWorkload, true})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/workloads/{workload_id:"+uuid.UUIDRegex+"}", Handler{context, showWorkload, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/workloads", Handler{context, addWorkload, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/workloads", Handler{context, listWorkloads, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/workloads/{workload_id:"+uuid.UUIDRegex+"}", Handler{context, deleteWorkload, false})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/workloads/{workload_id:"+uuid.UUIDRegex+"}", Handler{context, showWorkload, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// tenants
+	context, matchContent = base.resource(TenantsV1)
+
+	route = r.Handle("/tenants", Handler{context, listTenants, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/tenants", Handler{context, createTenant, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/tenants/{tenant:"+uuid.UUIDRegex+"}", Handler{context, showTenant, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/tenants/{tenant:"+uuid.UUIDRegex+"}", Handler{context, deleteTenant, true})
 	route.Methods("DELETE")
 	route.HeadersRegexp("Content-Type", matchContent)
 
@@ -1436,6 +3055,14 @@ func Routes(config Config, r *mux.Router) *mux.Router {
 	route.Methods("PATCH")
 	route.HeadersRegexp("Content-Type", `application/merge-patch\+json`)
 
+	route = r.Handle("/tenants/{tenant:"+uuid.UUIDRegex+"}/renumber", Handler{context, renumberTenant, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/tenants/{tenant:"+uuid.UUIDRegex+"}/summary", Handler{context, showTenantUsage, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	// tenant quotas
 	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/tenants/quotas", Handler{context, listQuotas, false})
 	route.Methods("GET")
@@ -1450,14 +3077,34 @@ func Routes(config Config, r *mux.Router) *mux.Router {
 	route.HeadersRegexp("Content-Type", matchContent)
 
 	// evacuation and restore
-	matchContent = fmt.Sprintf("application/(%s|json)", NodeV1)
+	context, matchContent = base.resource(NodeV1)
 
 	route = r.Handle("/node/{node_id:"+uuid.UUIDRegex+"}", Handler{context, changeNodeStatus, true})
 	route.Methods("PUT")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	// node scheduling policies
+	route = r.Handle("/node/policies", Handler{context, listNodePolicies, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/node/{node_id:"+uuid.UUIDRegex+"}/policy", Handler{context, updateNodePolicy, true})
+	route.Methods("PUT")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/node/{node_id:"+uuid.UUIDRegex+"}/policy", Handler{context, deleteNodePolicy, true})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	// images
-	matchContent = fmt.Sprintf("application/(%s|json)", ImagesV1)
+	context, matchContent = base.resource(ImagesV1)
+
+	// routes are matched in order, these ones take precedence over
+	// all the image routes.
+	if config.DisableImages {
+		r.PathPrefix("/{tenant}/images").Handler(Handler{context, imageServiceDisabled, false})
+		r.PathPrefix("/images").Handler(Handler{context, imageServiceDisabled, true})
+	}
 
 	route = r.Handle("/{tenant}/images", Handler{context, createImage, false})
 	route.Methods("POST")
@@ -1499,8 +3146,16 @@ func Routes(config Config, r *mux.Router) *mux.Router {
 	route.Methods("DELETE")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	route = r.Handle("/images/gc", Handler{context, showImageGC, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/images/gc", Handler{context, collectImageGarbage, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	// Volumes
-	matchContent = fmt.Sprintf("application/(%s|json)", VolumesV1)
+	context, matchContent = base.resource(VolumesV1)
 	route = r.Handle("/{tenant}/volumes", Handler{context, createVolume, false})
 	route.Methods("POST")
 	route.HeadersRegexp("Content-Type", matchContent)
@@ -1509,6 +3164,10 @@ func Routes(config Config, r *mux.Router) *mux.Router {
 	route.Methods("GET")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	route = r.Handle("/{tenant}/volumes/by-name/{name}", Handler{context, showVolumeByName, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	route = r.Handle("/{tenant}/volumes/{volume_id}", Handler{context, showVolumeDetails, false})
 	route.Methods("GET")
 	route.HeadersRegexp("Content-Type", matchContent)
@@ -1523,16 +3182,36 @@ func Routes(config Config, r *mux.Router) *mux.Router {
 	route.HeadersRegexp("Content-Type", matchContent)
 
 	// Instances
-	matchContent = fmt.Sprintf("application/(%s|json)", InstancesV1)
+	context, matchContent = base.resource(InstancesV1)
 
 	route = r.Handle("/{tenant}/instances", Handler{context, createInstance, false})
 	route.Methods("POST")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	route = r.Handle("/{tenant}/instances/simple", Handler{context, createSimpleInstance, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	route = r.Handle("/{tenant}/instances/detail", Handler{context, listInstanceDetails, false})
 	route.Methods("GET")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	route = r.Handle("/{tenant}/instances/by-name/{name}", Handler{context, showInstanceByName, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant}/instances/groups", Handler{context, listInstanceGroups, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant}/instances/groups/{group}", Handler{context, showInstanceGroup, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant}/instances/groups/{group}/action", Handler{context, instanceGroupAction, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	route = r.Handle("/{tenant}/instances/{instance_id}", Handler{context, showInstanceDetails, false})
 	route.Methods("GET")
 	route.HeadersRegexp("Content-Type", matchContent)
@@ -1545,5 +3224,245 @@ func Routes(config Config, r *mux.Router) *mux.Router {
 	route.Methods("POST")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	route = r.Handle("/{tenant}/instances/{instance_id}/actions", Handler{context, listInstanceActions, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant}/instances/{instance_id}/rescue", Handler{context, rescueInstance, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant}/instances/{instance_id}/unrescue", Handler{context, unrescueInstance, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant}/instances/{instance_id}/archive", Handler{context, archiveInstance, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant}/instances/{instance_id}/rehydrate", Handler{context, rehydrateInstance, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant}/instances/{instance_id}/migrate", Handler{context, migrateInstance, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant}/instances/{instance_id}/guest-agent", Handler{context, guestAgentInstance, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant}/instances/{instance_id}/capture", Handler{context, captureInstance, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant}/instances/{instance_id}/reboot", Handler{context, rebootInstance, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant}/instances/{instance_id}/clone", Handler{context, cloneInstance, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// Operations.  They are not persisted, the operations started
+	// before a restart of the controller are not found.
+	context, matchContent = base.resource(OperationsV1)
+
+	route = r.Handle("/operations", Handler{context, listOperations, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/operations/{operation_id:"+uuid.UUIDRegex+"}", Handler{context, showOperation, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant}/operations", Handler{context, listOperations, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant}/operations/{operation_id:"+uuid.UUIDRegex+"}", Handler{context, showOperation, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// Catalog
+	context, matchContent = base.resource(CatalogV1)
+
+	route = r.Handle("/catalog", Handler{context, listCatalog, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/catalog/{workload_id:"+uuid.UUIDRegex+"}", Handler{context, updateCatalog, true})
+	route.Methods("PUT")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/catalog/{workload_id:"+uuid.UUIDRegex+"}", Handler{context, removeFromCatalog, true})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/catalog", Handler{context, listCatalog, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/catalog/{workload_id:"+uuid.UUIDRegex+"}", Handler{context, cloneCatalogWorkload, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// IP address management
+	context, matchContent = base.resource(IPAMV1)
+
+	route = r.Handle("/ipam/audit", Handler{context, auditIPAM, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/ipam/repair", Handler{context, repairIPAM, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// failed SSNTP commands
+	context, matchContent = base.resource(CommandsV1)
+
+	route = r.Handle("/commands/failed", Handler{context, listFailedCommands, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/commands/failed/{command_id:"+uuid.UUIDRegex+"}/replay", Handler{context, replayFailedCommand, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/commands/failed/{command_id:"+uuid.UUIDRegex+"}", Handler{context, deleteFailedCommand, true})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// federation peers
+	context, matchContent = base.resource(FederationV1)
+
+	route = r.Handle("/federation/peers", Handler{context, listFederationPeers, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/federation/peers", Handler{context, addFederationPeer, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/federation/peers/{peer_id:"+uuid.UUIDRegex+"}", Handler{context, deleteFederationPeer, true})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// cluster summary
+	context, matchContent = base.resource(ClusterV1)
+
+	route = r.Handle("/cluster", Handler{context, showCluster, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// emergency serial consoles
+	context, matchContent = base.resource(ConsolesV1)
+
+	route = r.Handle("/consoles", Handler{context, openConsole, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/consoles/{session_id:"+uuid.UUIDRegex+"}", Handler{context, writeConsole, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/consoles/{session_id:"+uuid.UUIDRegex+"}", Handler{context, closeConsole, true})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// tenant IP reservations
+	context, matchContent = base.resource(IPsV1)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/ips", Handler{context, listIPReservations, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/ips", Handler{context, reserveIP, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/ips/{ip}", Handler{context, releaseIPReservation, false})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// tenant secrets
+	context, matchContent = base.resource(SecretsV1)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/secrets", Handler{context, listSecrets, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/secrets/{name}", Handler{context, setSecret, false})
+	route.Methods("PUT")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/secrets/{name}", Handler{context, deleteSecret, false})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// tenant API tokens
+	context, matchContent = base.resource(TokensV1)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/tokens", Handler{context, listAPITokens, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/tokens", Handler{context, createAPIToken, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/tokens/{token_id:"+uuid.UUIDRegex+"}", Handler{context, deleteAPIToken, false})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// recycle bin
+	context, matchContent = base.resource(RecycleBinV1)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/recycle-bin", Handler{context, listDeletedResources, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/recycle-bin/{resource_id:"+uuid.UUIDRegex+"}/restore", Handler{context, restoreDeletedResource, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/recycle-bin/{resource_id:"+uuid.UUIDRegex+"}", Handler{context, purgeDeletedResource, false})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// snapshot sets
+	context, matchContent = base.resource(SnapshotsV1)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/instances/{instance_id}/snapshots", Handler{context, createSnapshotSet, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/snapshots", Handler{context, listSnapshotSets, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/snapshots/{snapshot_id:"+uuid.UUIDRegex+"}", Handler{context, showSnapshotSet, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/snapshots/{snapshot_id:"+uuid.UUIDRegex+"}", Handler{context, deleteSnapshotSet, false})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/snapshots/{snapshot_id:"+uuid.UUIDRegex+"}/restore", Handler{context, restoreSnapshotSet, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// signed requests
+	context, matchContent = base.resource(SignedRequestsV1)
+
+	route = r.Handle("/signed-requests", Handler{context, submitSignedRequest, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/signed-requests", Handler{context, listSignedRequests, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	return r
 }
@@ -16,10 +16,13 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -27,6 +30,7 @@ import (
 	storage "github.com/ciao-project/ciao/ciao-storage"
 	"github.com/ciao-project/ciao/payloads"
 	"github.com/ciao-project/ciao/service"
+	"github.com/gorilla/mux"
 )
 
 type test struct {
@@ -45,7 +49,7 @@ var tests = []test{
 		"",
 		"application/text",
 		http.StatusOK,
-		`[{"rel":"pools","href":"/pools","version":"x.ciao.pools.v1","minimum_version":"x.ciao.pools.v1"},{"rel":"external-ips","href":"/external-ips","version":"x.ciao.external-ips.v1","minimum_version":"x.ciao.external-ips.v1"},{"rel":"workloads","href":"/workloads","version":"x.ciao.workloads.v1","minimum_version":"x.ciao.workloads.v1"},{"rel":"tenants","href":"/tenants","version":"x.ciao.tenants.v1","minimum_version":"x.ciao.tenants.v1"},{"rel":"node","href":"/node","version":"x.ciao.node.v1","minimum_version":"x.ciao.node.v1"},{"rel":"images","href":"/images","version":"x.ciao.images.v1","minimum_version":"x.ciao.images.v1"}]`,
+		`[{"rel":"pools","href":"/pools","version":"x.ciao.pools.v1","minimum_version":"x.ciao.pools.v1"},{"rel":"external-ips","href":"/external-ips","version":"x.ciao.external-ips.v1","minimum_version":"x.ciao.external-ips.v1"},{"rel":"workloads","href":"/workloads","version":"x.ciao.workloads.v1","minimum_version":"x.ciao.workloads.v1"},{"rel":"tenants","href":"/tenants","version":"x.ciao.tenants.v1","minimum_version":"x.ciao.tenants.v1"},{"rel":"node","href":"/node","version":"x.ciao.node.v1","minimum_version":"x.ciao.node.v1"},{"rel":"images","href":"/images","version":"x.ciao.images.v1","minimum_version":"x.ciao.images.v1"},{"rel":"operations","href":"/operations","version":"x.ciao.operations.v1","minimum_version":"x.ciao.operations.v1"},{"rel":"catalog","href":"/catalog","version":"x.ciao.catalog.v1","minimum_version":"x.ciao.catalog.v1"},{"rel":"ipam","href":"/ipam","version":"x.ciao.ipam.v1","minimum_version":"x.ciao.ipam.v1"},{"rel":"commands","href":"/commands","version":"x.ciao.commands.v1","minimum_version":"x.ciao.commands.v1"}]`,
 	},
 	{
 		"GET",
@@ -133,7 +137,7 @@ var tests = []test{
 		`{"id":"","description":"testWorkload","fw_type":"legacy","vm_type":"qemu","image_name":"","config":"this will totally work!"}`,
 		fmt.Sprintf("application/%s", WorkloadsV1),
 		http.StatusCreated,
-		`{"workload":{"id":"ba58f471-0735-4773-9550-188e2d012941","description":"testWorkload","fw_type":"legacy","vm_type":"qemu","image_name":"","config":"this will totally work!","storage":null,"visibility":"public","workload_requirements":{"MemMB":0,"VCPUs":0,"NodeID":"","Hostname":"","NetworkNode":false,"Privileged":false}},"link":{"rel":"self","href":"/workloads/ba58f471-0735-4773-9550-188e2d012941"}}`,
+		`{"workload":{"id":"ba58f471-0735-4773-9550-188e2d012941","description":"testWorkload","fw_type":"legacy","vm_type":"qemu","image_name":"","config":"this will totally work!","storage":null,"visibility":"public","workload_requirements":{"MemMB":0,"VCPUs":0,"DiskIOPS":0,"DiskMB":0,"NetMbps":0,"NodeID":"","Hostname":"","NetworkNode":false,"Privileged":false,"Console":false,"Priority":"","Watchdog":""}},"link":{"rel":"self","href":"/workloads/ba58f471-0735-4773-9550-188e2d012941"}}`,
 	},
 	{
 		"DELETE",
@@ -149,7 +153,7 @@ var tests = []test{
 		"",
 		fmt.Sprintf("application/%s", WorkloadsV1),
 		http.StatusOK,
-		`{"id":"ba58f471-0735-4773-9550-188e2d012941","description":"testWorkload","fw_type":"legacy","vm_type":"qemu","image_name":"","config":"this will totally work!","storage":null,"visibility":"private","workload_requirements":{"MemMB":0,"VCPUs":0,"NodeID":"","Hostname":"","NetworkNode":false,"Privileged":false}}`,
+		`{"id":"ba58f471-0735-4773-9550-188e2d012941","description":"testWorkload","fw_type":"legacy","vm_type":"qemu","image_name":"","config":"this will totally work!","storage":null,"visibility":"private","workload_requirements":{"MemMB":0,"VCPUs":0,"DiskIOPS":0,"DiskMB":0,"NetMbps":0,"NodeID":"","Hostname":"","NetworkNode":false,"Privileged":false,"Console":false,"Priority":"","Watchdog":""}}`,
 	},
 	{
 		"GET",
@@ -157,7 +161,427 @@ var tests = []test{
 		"",
 		fmt.Sprintf("application/%s", WorkloadsV1),
 		http.StatusOK,
-		`[{"id":"ba58f471-0735-4773-9550-188e2d012941","description":"testWorkload","fw_type":"legacy","vm_type":"qemu","image_name":"","config":"this will totally work!","storage":null,"visibility":"private","workload_requirements":{"MemMB":0,"VCPUs":0,"NodeID":"","Hostname":"","NetworkNode":false,"Privileged":false}}]`,
+		`[{"id":"ba58f471-0735-4773-9550-188e2d012941","description":"testWorkload","fw_type":"legacy","vm_type":"qemu","image_name":"","config":"this will totally work!","storage":null,"visibility":"private","workload_requirements":{"MemMB":0,"VCPUs":0,"DiskIOPS":0,"DiskMB":0,"NetMbps":0,"NodeID":"","Hostname":"","NetworkNode":false,"Privileged":false,"Console":false,"Priority":"","Watchdog":""}}]`,
+	},
+	{
+		"GET",
+		"/catalog",
+		"",
+		fmt.Sprintf("application/%s", CatalogV1),
+		http.StatusOK,
+		`[{"id":"ba58f471-0735-4773-9550-188e2d012941","description":"testWorkload","fw_type":"legacy","vm_type":"qemu","image_name":"","config":"this will totally work!","storage":null,"visibility":"public","category":"test","workload_requirements":{"MemMB":0,"VCPUs":0,"DiskIOPS":0,"DiskMB":0,"NetMbps":0,"NodeID":"","Hostname":"","NetworkNode":false,"Privileged":false,"Console":false,"Priority":"","Watchdog":""}}]`,
+	},
+	{
+		"GET",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/catalog?category=test",
+		"",
+		fmt.Sprintf("application/%s", CatalogV1),
+		http.StatusOK,
+		`[{"id":"ba58f471-0735-4773-9550-188e2d012941","description":"testWorkload","fw_type":"legacy","vm_type":"qemu","image_name":"","config":"this will totally work!","storage":null,"visibility":"public","category":"test","workload_requirements":{"MemMB":0,"VCPUs":0,"DiskIOPS":0,"DiskMB":0,"NetMbps":0,"NodeID":"","Hostname":"","NetworkNode":false,"Privileged":false,"Console":false,"Priority":"","Watchdog":""}}]`,
+	},
+	{
+		"GET",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/catalog?category=other",
+		"",
+		fmt.Sprintf("application/%s", CatalogV1),
+		http.StatusOK,
+		`[]`,
+	},
+	{
+		"PUT",
+		"/catalog/ba58f471-0735-4773-9550-188e2d012941",
+		`{"category":"test"}`,
+		fmt.Sprintf("application/%s", CatalogV1),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"DELETE",
+		"/catalog/ba58f471-0735-4773-9550-188e2d012941",
+		"",
+		fmt.Sprintf("application/%s", CatalogV1),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"POST",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/catalog/ba58f471-0735-4773-9550-188e2d012941",
+		"",
+		fmt.Sprintf("application/%s", CatalogV1),
+		http.StatusCreated,
+		`{"workload":{"id":"cf5d7e2b-3a64-4d8e-9f0c-2a1f6e7b4d93","description":"testWorkload","fw_type":"legacy","vm_type":"qemu","image_name":"","config":"this will totally work!","storage":null,"visibility":"private","workload_requirements":{"MemMB":0,"VCPUs":0,"DiskIOPS":0,"DiskMB":0,"NetMbps":0,"NodeID":"","Hostname":"","NetworkNode":false,"Privileged":false,"Console":false,"Priority":"","Watchdog":""}},"link":{"rel":"self","href":"/093ae09b-f653-464e-9ae6-5ae28bd03a22/workloads/cf5d7e2b-3a64-4d8e-9f0c-2a1f6e7b4d93"}}`,
+	},
+	{
+		"GET",
+		"/ipam/audit",
+		"",
+		fmt.Sprintf("application/%s", IPAMV1),
+		http.StatusOK,
+		`{"issues":[{"type":"leak","tenant_id":"093ae09b-f653-464e-9ae6-5ae28bd03a22","ip_address":"172.16.0.3","repaired":false}]}`,
+	},
+	{
+		"POST",
+		"/ipam/repair?tenant=093ae09b-f653-464e-9ae6-5ae28bd03a22",
+		"",
+		fmt.Sprintf("application/%s", IPAMV1),
+		http.StatusOK,
+		`{"issues":[{"type":"leak","tenant_id":"093ae09b-f653-464e-9ae6-5ae28bd03a22","ip_address":"172.16.0.3","repaired":true}]}`,
+	},
+	{
+		"GET",
+		"/node/policies",
+		"",
+		fmt.Sprintf("application/%s", NodeV1),
+		http.StatusOK,
+		`{"policies":[{"node_id":"0e0aa7f2-5c1e-4f6b-8a55-1b1f3a1c6d2e","weight":50,"max_instances":10,"allow_cnci_colocation":false}]}`,
+	},
+	{
+		"PUT",
+		"/node/0e0aa7f2-5c1e-4f6b-8a55-1b1f3a1c6d2e/policy",
+		`{"weight":50,"max_instances":10}`,
+		fmt.Sprintf("application/%s", NodeV1),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"PUT",
+		"/node/0e0aa7f2-5c1e-4f6b-8a55-1b1f3a1c6d2e/policy",
+		`{"weight":500}`,
+		fmt.Sprintf("application/%s", NodeV1),
+		http.StatusForbidden,
+		`{"error":{"code":403,"name":"Forbidden","message":"Invalid Request"}}
+`,
+	},
+	{
+		"DELETE",
+		"/node/0e0aa7f2-5c1e-4f6b-8a55-1b1f3a1c6d2e/policy",
+		"",
+		fmt.Sprintf("application/%s", NodeV1),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"GET",
+		"/commands/failed",
+		"",
+		fmt.Sprintf("application/%s", CommandsV1),
+		http.StatusOK,
+		`[{"id":"5d4e1c8a-3f1b-4a39-9f8e-0c6a2b7d9e41","command":"DELETE","instance_id":"validServerID","node_id":"validNodeID","reason":"Connection closed","timestamp":"2017-10-12T09:00:00Z","payload":"delete:\n  instance_uuid: validServerID\n"}]`,
+	},
+	{
+		"POST",
+		"/commands/failed/5d4e1c8a-3f1b-4a39-9f8e-0c6a2b7d9e41/replay",
+		"",
+		fmt.Sprintf("application/%s", CommandsV1),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"POST",
+		"/commands/failed/0b1a6f3e-8c2d-4e5f-9a7b-1c3d5e7f9a2b/replay",
+		"",
+		fmt.Sprintf("application/%s", CommandsV1),
+		http.StatusNotFound,
+		`{"error":{"code":404,"name":"Not Found","message":"Failed command not found"}}
+`,
+	},
+	{
+		"DELETE",
+		"/commands/failed/5d4e1c8a-3f1b-4a39-9f8e-0c6a2b7d9e41",
+		"",
+		fmt.Sprintf("application/%s", CommandsV1),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"GET",
+		"/federation/peers",
+		"",
+		fmt.Sprintf("application/%s", FederationV1),
+		http.StatusOK,
+		`[{"id":"8f2c6b1e-4d3a-4f5b-9c7e-2a1d0e9f8b73","name":"east","url":"https://east.example.com:8889","tenant_id":"093ae09b-f653-464e-9ae6-5ae28bd03a22","workloads":{"validWorkloadID":"peerWorkloadID"},"create_time":"2017-10-12T09:00:00Z"}]`,
+	},
+	{
+		"POST",
+		"/federation/peers",
+		`{"name":"east","url":"https://east.example.com:8889","tenant_id":"093ae09b-f653-464e-9ae6-5ae28bd03a22","api_token":"ciao_secret","workloads":{"validWorkloadID":"peerWorkloadID"}}`,
+		fmt.Sprintf("application/%s", FederationV1),
+		http.StatusCreated,
+		`{"id":"8f2c6b1e-4d3a-4f5b-9c7e-2a1d0e9f8b73","name":"east","url":"https://east.example.com:8889","tenant_id":"093ae09b-f653-464e-9ae6-5ae28bd03a22","workloads":{"validWorkloadID":"peerWorkloadID"},"create_time":"2017-10-12T09:00:00Z"}`,
+	},
+	{
+		"POST",
+		"/federation/peers",
+		`{"name":"east","url":"https://east.example.com:8889","tenant_id":"093ae09b-f653-464e-9ae6-5ae28bd03a22","workloads":{"validWorkloadID":"peerWorkloadID"}}`,
+		fmt.Sprintf("application/%s", FederationV1),
+		http.StatusForbidden,
+		`{"error":{"code":403,"name":"Forbidden","message":"Invalid Request"}}
+`,
+	},
+	{
+		"DELETE",
+		"/federation/peers/8f2c6b1e-4d3a-4f5b-9c7e-2a1d0e9f8b73",
+		"",
+		fmt.Sprintf("application/%s", FederationV1),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"DELETE",
+		"/federation/peers/0b1a6f3e-8c2d-4e5f-9a7b-1c3d5e7f9a2b",
+		"",
+		fmt.Sprintf("application/%s", FederationV1),
+		http.StatusNotFound,
+		`{"error":{"code":404,"name":"Not Found","message":"Federation peer not found"}}
+`,
+	},
+	{
+		"POST",
+		"/consoles",
+		`{"instance_id":"validServerID"}`,
+		fmt.Sprintf("application/%s", ConsolesV1),
+		http.StatusCreated,
+		`{"id":"3c7e9a1b-5d2f-4b8e-a6c4-0f1e2d3c4b5a","instance_id":"validServerID","node_id":"validNodeID","output":"","closed":false,"expire_time":"2017-10-12T09:05:00Z"}`,
+	},
+	{
+		"POST",
+		"/consoles",
+		`{}`,
+		fmt.Sprintf("application/%s", ConsolesV1),
+		http.StatusForbidden,
+		`{"error":{"code":403,"name":"Forbidden","message":"Invalid Request"}}
+`,
+	},
+	{
+		"POST",
+		"/consoles/3c7e9a1b-5d2f-4b8e-a6c4-0f1e2d3c4b5a",
+		`{"input":"root\n"}`,
+		fmt.Sprintf("application/%s", ConsolesV1),
+		http.StatusOK,
+		`{"id":"3c7e9a1b-5d2f-4b8e-a6c4-0f1e2d3c4b5a","instance_id":"validServerID","node_id":"validNodeID","output":"root\nPassword: ","closed":false,"expire_time":"2017-10-12T09:05:00Z"}`,
+	},
+	{
+		"POST",
+		"/consoles/0b1a6f3e-8c2d-4e5f-9a7b-1c3d5e7f9a2b",
+		`{"input":"root\n"}`,
+		fmt.Sprintf("application/%s", ConsolesV1),
+		http.StatusNotFound,
+		`{"error":{"code":404,"name":"Not Found","message":"Console session not found"}}
+`,
+	},
+	{
+		"DELETE",
+		"/consoles/3c7e9a1b-5d2f-4b8e-a6c4-0f1e2d3c4b5a",
+		"",
+		fmt.Sprintf("application/%s", ConsolesV1),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"GET",
+		"/cluster",
+		"",
+		fmt.Sprintf("application/%s", ClusterV1),
+		http.StatusOK,
+		`{"nodes":3,"tenants":2,"instances":10,"config_version":2,"config_loaded":"2017-10-12T09:00:00Z","config_reload_error":"Invalid cluster configuration: Invalid CNCI sizing"}`,
+	},
+	{
+		"GET",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/ips",
+		"",
+		fmt.Sprintf("application/%s", IPsV1),
+		http.StatusOK,
+		`[{"ip_address":"172.16.0.10","instance_id":"validServerID"},{"ip_address":"172.16.0.11"}]`,
+	},
+	{
+		"POST",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/ips",
+		`{"ip_address":"172.16.0.12"}`,
+		fmt.Sprintf("application/%s", IPsV1),
+		http.StatusCreated,
+		`{"ip_address":"172.16.0.12"}`,
+	},
+	{
+		"DELETE",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/ips/172.16.0.11",
+		"",
+		fmt.Sprintf("application/%s", IPsV1),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"GET",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/secrets",
+		"",
+		fmt.Sprintf("application/%s", SecretsV1),
+		http.StatusOK,
+		`[{"name":"db-password","create_time":"2017-10-16T10:00:00Z"}]`,
+	},
+	{
+		"PUT",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/secrets/db-password",
+		`{"value":"hunter2"}`,
+		fmt.Sprintf("application/%s", SecretsV1),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"DELETE",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/secrets/db-password",
+		"",
+		fmt.Sprintf("application/%s", SecretsV1),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"DELETE",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/secrets/missing",
+		"",
+		fmt.Sprintf("application/%s", SecretsV1),
+		http.StatusNotFound,
+		`{"error":{"code":404,"name":"Not Found","message":"Secret not found"}}
+`,
+	},
+	{
+		"GET",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/tokens",
+		"",
+		fmt.Sprintf("application/%s", TokensV1),
+		http.StatusOK,
+		`[{"id":"` + testAPITokenID + `","tenant_id":"093ae09b-f653-464e-9ae6-5ae28bd03a22","name":"ci","scope":"read-only","create_time":"2017-10-16T10:00:00Z","expiry_time":"2017-11-15T10:00:00Z"}]`,
+	},
+	{
+		"POST",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/tokens",
+		`{"name":"ci","scope":"full","expiry":"24h"}`,
+		fmt.Sprintf("application/%s", TokensV1),
+		http.StatusCreated,
+		`{"id":"` + testAPITokenID + `","tenant_id":"093ae09b-f653-464e-9ae6-5ae28bd03a22","name":"ci","scope":"full","create_time":"2017-10-16T10:00:00Z","expiry_time":"2017-10-17T10:00:00Z","token":"` + testAPITokenID + `.secret"}`,
+	},
+	{
+		"POST",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/tokens",
+		`{"name":"ci","scope":"admin"}`,
+		fmt.Sprintf("application/%s", TokensV1),
+		http.StatusForbidden,
+		`{"error":{"code":403,"name":"Forbidden","message":"Invalid Request"}}
+`,
+	},
+	{
+		"DELETE",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/tokens/" + testAPITokenID,
+		"",
+		fmt.Sprintf("application/%s", TokensV1),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"DELETE",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/tokens/b3b8a1a6-2f2e-4b67-9d7e-3a4e1c5d9f10",
+		"",
+		fmt.Sprintf("application/%s", TokensV1),
+		http.StatusNotFound,
+		`{"error":{"code":404,"name":"Not Found","message":"API token not found"}}
+`,
+	},
+	{
+		"GET",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/recycle-bin",
+		"",
+		fmt.Sprintf("application/%s", RecycleBinV1),
+		http.StatusOK,
+		`[{"id":"` + testDeletedResourceID + `","type":"volume","name":"scratch","delete_time":"2017-10-16T10:00:00Z","purge_time":"2017-10-17T10:00:00Z"}]`,
+	},
+	{
+		"POST",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/recycle-bin/" + testDeletedResourceID + "/restore",
+		"",
+		fmt.Sprintf("application/%s", RecycleBinV1),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"POST",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/recycle-bin/b3b8a1a6-2f2e-4b67-9d7e-3a4e1c5d9f10/restore",
+		"",
+		fmt.Sprintf("application/%s", RecycleBinV1),
+		http.StatusNotFound,
+		`{"error":{"code":404,"name":"Not Found","message":"Resource not in the recycle bin"}}
+`,
+	},
+	{
+		"DELETE",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/recycle-bin/" + testDeletedResourceID,
+		"",
+		fmt.Sprintf("application/%s", RecycleBinV1),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"POST",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/instances/3390740c-dce9-48d6-b83a-a717417072ce/snapshots",
+		`{"name":"nightly"}`,
+		fmt.Sprintf("application/%s", SnapshotsV1),
+		http.StatusCreated,
+		`{"id":"` + testSnapshotSetID + `","name":"nightly","tenant_id":"093ae09b-f653-464e-9ae6-5ae28bd03a22","instance_id":"3390740c-dce9-48d6-b83a-a717417072ce","workload_id":"ab68111c-03a6-11e7-b74d-00000000000a","state":"creating","consistent":true,"create_time":"2017-10-16T10:00:00Z","volumes":[{"id":"1e2f7c2d-6a2b-4a63-9d5e-4b1f8c3a0d21","volume_id":"67d4cc62-7d4b-4d0c-9d19-1e4b2c9f5a13","boot":true,"ephemeral":true,"size":20}]}`,
+	},
+	{
+		"GET",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/snapshots",
+		"",
+		fmt.Sprintf("application/%s", SnapshotsV1),
+		http.StatusOK,
+		`[{"id":"` + testSnapshotSetID + `","name":"nightly","tenant_id":"093ae09b-f653-464e-9ae6-5ae28bd03a22","instance_id":"3390740c-dce9-48d6-b83a-a717417072ce","workload_id":"ab68111c-03a6-11e7-b74d-00000000000a","state":"available","consistent":true,"create_time":"2017-10-16T10:00:00Z","volumes":[{"id":"1e2f7c2d-6a2b-4a63-9d5e-4b1f8c3a0d21","volume_id":"67d4cc62-7d4b-4d0c-9d19-1e4b2c9f5a13","boot":true,"ephemeral":true,"size":20}]}]`,
+	},
+	{
+		"GET",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/snapshots/b3b8a1a6-2f2e-4b67-9d7e-3a4e1c5d9f10",
+		"",
+		fmt.Sprintf("application/%s", SnapshotsV1),
+		http.StatusNotFound,
+		`{"error":{"code":404,"name":"Not Found","message":"Snapshot set not found"}}
+`,
+	},
+	{
+		"POST",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/snapshots/" + testSnapshotSetID + "/restore",
+		`{"name":"restored"}`,
+		fmt.Sprintf("application/%s", SnapshotsV1),
+		http.StatusAccepted,
+		`{"total_servers":1,"servers":[{"private_addresses":null,"created":"0001-01-01T00:00:00Z","workload_id":"ab68111c-03a6-11e7-b74d-00000000000a","node_id":"","id":"restored","name":"restored","volumes":null,"status":"","tenant_id":"093ae09b-f653-464e-9ae6-5ae28bd03a22","ssh_ip":"","ssh_port":0}]}`,
+	},
+	{
+		"POST",
+		"/signed-requests",
+		`{"method":"DELETE","path":"/validTenantID/volumes/validVolumeID","created":"2017-10-12T09:00:00Z","nonce":"5f1c9e2a","certificate":"","signature":""}`,
+		fmt.Sprintf("application/%s", SignedRequestsV1),
+		http.StatusOK,
+		`{"status_code":204,"body":"null"}`,
+	},
+	{
+		"POST",
+		"/signed-requests",
+		`{"method":"DELETE","path":"/validTenantID/volumes/validVolumeID","created":"2017-10-12T09:00:00Z","nonce":"replayed","certificate":"","signature":""}`,
+		fmt.Sprintf("application/%s", SignedRequestsV1),
+		http.StatusConflict,
+		`{"error":{"code":409,"name":"Conflict","message":"Signed request already submitted"}}
+`,
+	},
+	{
+		"GET",
+		"/signed-requests",
+		"",
+		fmt.Sprintf("application/%s", SignedRequestsV1),
+		http.StatusOK,
+		`[{"nonce":"5f1c9e2a","method":"DELETE","path":"/validTenantID/volumes/validVolumeID","signer":"admin","created":"2017-10-12T09:00:00Z","submit_time":"2017-10-12T10:00:00Z"}]`,
+	},
+	{
+		"DELETE",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/snapshots/" + testSnapshotSetID,
+		"",
+		fmt.Sprintf("application/%s", SnapshotsV1),
+		http.StatusNoContent,
+		"null",
 	},
 	{
 		"GET",
@@ -167,6 +591,14 @@ var tests = []test{
 		http.StatusOK,
 		`{"quotas":[{"name":"test-quota-1","value":"10","usage":"3"},{"name":"test-quota-2","value":"unlimited","usage":"10"},{"name":"test-limit","value":"123"}]}`,
 	},
+	{
+		"PUT",
+		"/tenants/093ae09b-f653-464e-9ae6-5ae28bd03a22/quotas",
+		`{"quotas":[{"name":"test-quota-1","value":"10"}]}`,
+		fmt.Sprintf("application/%s", TenantsV1),
+		http.StatusCreated,
+		`{"quotas":[{"name":"test-quota-1","value":"10","usage":"3"},{"name":"test-quota-2","value":"unlimited","usage":"10"},{"name":"test-limit","value":"123"}],"previous":[{"name":"test-quota-1","value":"10","usage":"3"},{"name":"test-quota-2","value":"unlimited","usage":"10"},{"name":"test-limit","value":"123"}]}`,
+	},
 	{
 		"GET",
 		"/tenants",
@@ -181,15 +613,31 @@ var tests = []test{
 		"",
 		fmt.Sprintf("application/%s", TenantsV1),
 		http.StatusOK,
-		`{"name":"Test Tenant","subnet_bits":24,"permissions":{"privileged_containers":false}}`,
+		`{"name":"Test Tenant","subnet_bits":24,"permissions":{"privileged_containers":false,"guest_agent":false},"cnci_flavor":{}}`,
 	},
 	{
 		"PATCH",
 		"/tenants/093ae09b-f653-464e-9ae6-5ae28bd03a22",
 		`{"name":"Updated Test Tenant","subnet_bits":4}`,
 		fmt.Sprintf("application/%s", "merge-patch+json"),
-		http.StatusNoContent,
-		"null",
+		http.StatusOK,
+		`{"previous":{"name":"Test Tenant","subnet_bits":24,"permissions":{"privileged_containers":false,"guest_agent":false},"cnci_flavor":{}},"config":{"name":"Updated Test Tenant","subnet_bits":4,"permissions":{"privileged_containers":false,"guest_agent":false},"cnci_flavor":{}}}`,
+	},
+	{
+		"POST",
+		"/tenants/093ae09b-f653-464e-9ae6-5ae28bd03a22/renumber",
+		`{"subnet_bits":26,"dry_run":true}`,
+		fmt.Sprintf("application/%s", TenantsV1),
+		http.StatusOK,
+		`{"old_subnet_bits":24,"subnet_bits":26,"dry_run":true,"conflicts":[{"ip_address":"172.16.0.64","subnet":"172.16.0.64/26","instance_id":"3390740c-dce9-48d6-b83a-a717417072ce","reserved":false}]}`,
+	},
+	{
+		"GET",
+		"/tenants/093ae09b-f653-464e-9ae6-5ae28bd03a22/summary",
+		"",
+		fmt.Sprintf("application/%s", TenantsV1),
+		http.StatusOK,
+		`{"id":"093ae09b-f653-464e-9ae6-5ae28bd03a22","name":"Test Tenant","instances":2,"instance_states":{"exited":1,"running":1},"volumes":1,"volume_size_gb":10,"images":0,"image_size":0,"external_ips":[],"quotas":[{"name":"tenant-instances-quota","value":"10","usage":"2"}],"events":[{"time_stamp":"2017-01-02T03:04:05Z","tenant_id":"093ae09b-f653-464e-9ae6-5ae28bd03a22","type":"info","message":"Started instance"}]}`,
 	},
 	{
 		"POST",
@@ -214,6 +662,14 @@ var tests = []test{
 		http.StatusCreated,
 		`{"id":"b2173dd3-7ad6-4362-baa6-a68bce3565cb","state":"created","tenant_id":"","name":"Ubuntu","create_time":"2015-11-29T22:21:42Z","size":0,"visibility":"private"}`,
 	},
+	{
+		"POST",
+		"/images",
+		`{"name":"Ubuntu","id":"b2173dd3-7ad6-4362-baa6-a68bce3565cb","visibility":"private","url":"https://example.com/ubuntu.img","checksum":"sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}`,
+		fmt.Sprintf("application/%s", ImagesV1),
+		http.StatusAccepted,
+		`{"id":"b2173dd3-7ad6-4362-baa6-a68bce3565cb","state":"saving","tenant_id":"","name":"Ubuntu","create_time":"2015-11-29T22:21:42Z","size":0,"visibility":"private"}`,
+	},
 	{
 		"GET",
 		"/images",
@@ -222,6 +678,31 @@ var tests = []test{
 		http.StatusOK,
 		`[{"id":"b2173dd3-7ad6-4362-baa6-a68bce3565cb","state":"created","tenant_id":"","name":"Ubuntu","create_time":"2015-11-29T22:21:42Z","size":0,"visibility":"public"}]`,
 	},
+	{
+		"GET",
+		"/images?name=Ubu&visibility=public&created_since=2015-11-01T00:00:00Z&limit=1",
+		"",
+		fmt.Sprintf("application/%s", ImagesV1),
+		http.StatusOK,
+		`[{"id":"b2173dd3-7ad6-4362-baa6-a68bce3565cb","state":"created","tenant_id":"","name":"Ubuntu","create_time":"2015-11-29T22:21:42Z","size":0,"visibility":"public"}]`,
+	},
+	{
+		"GET",
+		"/images?marker=b2173dd3-7ad6-4362-baa6-a68bce3565cb",
+		"",
+		fmt.Sprintf("application/%s", ImagesV1),
+		http.StatusOK,
+		"[]",
+	},
+	{
+		"GET",
+		"/images?min_size=large",
+		"",
+		fmt.Sprintf("application/%s", ImagesV1),
+		http.StatusBadRequest,
+		`{"error":{"code":400,"name":"Bad Request","message":"Invalid Request"}}
+`,
+	},
 	{
 		"GET",
 		"/images/1bea47ed-f6a9-463b-b423-14b9cca9ad27",
@@ -238,13 +719,29 @@ var tests = []test{
 		http.StatusNoContent,
 		`null`,
 	},
+	{
+		"GET",
+		"/images/gc",
+		"",
+		fmt.Sprintf("application/%s", ImagesV1),
+		http.StatusOK,
+		`{"last_run":"2017-10-12T09:00:00Z","devices":[{"id":"3390740c-dce9-48d6-b83a-a717417072ce","state":"quarantined","first_seen":"2017-10-12T09:00:00Z"}]}`,
+	},
+	{
+		"POST",
+		"/images/gc",
+		"",
+		fmt.Sprintf("application/%s", ImagesV1),
+		http.StatusOK,
+		`{"last_run":"2017-10-13T09:00:00Z","devices":[{"id":"3390740c-dce9-48d6-b83a-a717417072ce","state":"removed","first_seen":"2017-10-12T09:00:00Z"}]}`,
+	},
 	{
 		"POST",
 		"/validtenantid/volumes",
 		`{"size": 10,"source_volid": null,"description":null,"name":null,"imageRef":null}`,
 		fmt.Sprintf("application/%s", VolumesV1),
 		http.StatusAccepted,
-		`{"id":"new-test-id","bootable":false,"boot_index":0,"ephemeral":false,"local":false,"swap":false,"size":123456,"tenant_id":"test-tenant-id","state":"available","created":"0001-01-01T00:00:00Z","name":"new volume","description":"newly created volume","internal":false}`,
+		`{"id":"new-test-id","bootable":false,"boot_index":0,"ephemeral":false,"local":false,"swap":false,"size":123456,"tenant_id":"test-tenant-id","state":"available","created":"0001-01-01T00:00:00Z","name":"new volume","description":"newly created volume","internal":false,"used_mb":0,"class":"","encrypted":false}`,
 	},
 	{
 		"GET",
@@ -252,7 +749,7 @@ var tests = []test{
 		"",
 		fmt.Sprintf("application/%s", VolumesV1),
 		http.StatusOK,
-		`[{"id":"new-test-id","bootable":false,"boot_index":0,"ephemeral":false,"local":false,"swap":false,"size":123456,"tenant_id":"test-tenant-id","state":"available","created":"0001-01-01T00:00:00Z","name":"my volume","description":"my volume for stuff","internal":false},{"id":"new-test-id2","bootable":false,"boot_index":0,"ephemeral":false,"local":false,"swap":false,"size":123456,"tenant_id":"test-tenant-id","state":"available","created":"0001-01-01T00:00:00Z","name":"volume 2","description":"my other volume","internal":false}]`,
+		`[{"id":"new-test-id","bootable":false,"boot_index":0,"ephemeral":false,"local":false,"swap":false,"size":123456,"tenant_id":"test-tenant-id","state":"available","created":"0001-01-01T00:00:00Z","name":"my volume","description":"my volume for stuff","internal":false,"used_mb":0,"class":"","encrypted":false},{"id":"new-test-id2","bootable":false,"boot_index":0,"ephemeral":false,"local":false,"swap":false,"size":123456,"tenant_id":"test-tenant-id","state":"available","created":"0001-01-01T00:00:00Z","name":"volume 2","description":"my other volume","internal":false,"used_mb":0,"class":"","encrypted":false}]`,
 	},
 	{
 		"GET",
@@ -260,7 +757,24 @@ var tests = []test{
 		"",
 		fmt.Sprintf("application/%s", VolumesV1),
 		http.StatusOK,
-		`{"id":"new-test-id","bootable":false,"boot_index":0,"ephemeral":false,"local":false,"swap":false,"size":123456,"tenant_id":"test-tenant-id","state":"available","created":"0001-01-01T00:00:00Z","name":"my volume","description":"my volume for stuff","internal":false}`,
+		`{"id":"new-test-id","bootable":false,"boot_index":0,"ephemeral":false,"local":false,"swap":false,"size":123456,"tenant_id":"test-tenant-id","state":"available","created":"0001-01-01T00:00:00Z","name":"my volume","description":"my volume for stuff","internal":false,"used_mb":0,"class":"","encrypted":false}`,
+	},
+	{
+		"GET",
+		"/validtenantid/volumes/by-name/my-volume",
+		"",
+		fmt.Sprintf("application/%s", VolumesV1),
+		http.StatusOK,
+		`{"id":"new-test-id","bootable":false,"boot_index":0,"ephemeral":false,"local":false,"swap":false,"size":123456,"tenant_id":"test-tenant-id","state":"available","created":"0001-01-01T00:00:00Z","name":"my volume","description":"my volume for stuff","internal":false,"used_mb":0,"class":"","encrypted":false}`,
+	},
+	{
+		"GET",
+		"/validtenantid/volumes/by-name/duplicated",
+		"",
+		fmt.Sprintf("application/%s", VolumesV1),
+		http.StatusConflict,
+		`{"error":{"code":409,"name":"Conflict","message":"Name shared by several resources, use the ID instead"}}
+`,
 	},
 	{
 		"DELETE",
@@ -294,20 +808,54 @@ var tests = []test{
 		http.StatusAccepted,
 		`{"server":{"id":"validServerID","name":"new-server-test","imageRef":"http://glance.openstack.example.com/images/70a599e0-31e7-49b7-b260-868f441e862b","workload_id":"http://openstack.example.com/flavors/1","max_count":0,"min_count":0,"metadata":{"My Server Name":"Apache1"}}}`,
 	},
+	{
+		"POST",
+		"/validtenantid/instances/simple",
+		`{"image_id":"70a599e0-31e7-49b7-b260-868f441e862b","size":"medium"}`,
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusAccepted,
+		`{"server":{"id":"validServerID","name":"","imageRef":"70a599e0-31e7-49b7-b260-868f441e862b","workload_id":"simple-medium","max_count":0,"min_count":1}}`,
+	},
+	{
+		"POST",
+		"/validtenantid/instances/simple",
+		`{"size":"medium"}`,
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusBadRequest,
+		`{"error":{"code":400,"name":"Bad Request","message":"Missing image ID"}}
+`,
+	},
 	{
 		"GET",
 		"/validtenantid/instances/detail",
 		"",
 		fmt.Sprintf("application/%s", InstancesV1),
 		http.StatusOK,
-		`{"total_servers":1,"servers":[{"private_addresses":[{"addr":"192.169.0.1","mac_addr":"00:02:00:01:02:03"}],"created":"0001-01-01T00:00:00Z","workload_id":"testWorkloadUUID","node_id":"nodeUUID","id":"testUUID","name":"","volumes":null,"status":"active","tenant_id":"validtenantid","ssh_ip":"","ssh_port":0}]}`},
+		`{"total_servers":1,"servers":[{"private_addresses":[{"addr":"192.169.0.1","mac_addr":"00:02:00:01:02:03"}],"created":"0001-01-01T00:00:00Z","workload_id":"testWorkloadUUID","node_id":"validNodeID","id":"testUUID","name":"","volumes":null,"status":"active","tenant_id":"validtenantid","ssh_ip":"","ssh_port":0}]}`},
 	{
 		"GET",
 		"/validtenantid/instances/instanceid",
 		"",
 		fmt.Sprintf("application/%s", InstancesV1),
 		http.StatusOK,
-		`{"server":{"private_addresses":[{"addr":"192.169.0.1","mac_addr":"00:02:00:01:02:03"}],"created":"0001-01-01T00:00:00Z","workload_id":"testWorkloadUUID","node_id":"nodeUUID","id":"instanceid","name":"","volumes":null,"status":"active","tenant_id":"validtenantid","ssh_ip":"","ssh_port":0}}`,
+		`{"server":{"private_addresses":[{"addr":"192.169.0.1","mac_addr":"00:02:00:01:02:03"}],"created":"0001-01-01T00:00:00Z","workload_id":"testWorkloadUUID","node_id":"validNodeID","id":"instanceid","name":"","volumes":null,"status":"active","tenant_id":"validtenantid","ssh_ip":"","ssh_port":0}}`,
+	},
+	{
+		"GET",
+		"/validtenantid/instances/by-name/web",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusOK,
+		`{"server":{"private_addresses":[{"addr":"192.169.0.1","mac_addr":"00:02:00:01:02:03"}],"created":"0001-01-01T00:00:00Z","workload_id":"testWorkloadUUID","node_id":"validNodeID","id":"instanceid","name":"","volumes":null,"status":"active","tenant_id":"validtenantid","ssh_ip":"","ssh_port":0}}`,
+	},
+	{
+		"GET",
+		"/validtenantid/instances/by-name/unknown",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusNotFound,
+		`{"error":{"code":404,"name":"Not Found","message":"Instance not found"}}
+`,
 	},
 	{
 		"DELETE",
@@ -327,17 +875,234 @@ var tests = []test{
 	},
 	{
 		"POST",
-		"/validtenantid/instances/instanceid/action",
-		`{"os-stop":null}`,
+		"/validtenantid/instances/instanceid/action",
+		`{"os-stop":null}`,
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusAccepted,
+		"null",
+	},
+	{
+		"POST",
+		"/validtenantid/instances/instanceid/action",
+		`{"pause":null}`,
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusAccepted,
+		"null",
+	},
+	{
+		"POST",
+		"/validtenantid/instances/instanceid/action",
+		`{"unpause":null}`,
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusAccepted,
+		"null",
+	},
+	{
+		"POST",
+		"/validtenantid/instances/instanceid/rescue",
+		`{"image_id":"rescue-image"}`,
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusAccepted,
+		"null",
+	},
+	{
+		"POST",
+		"/validtenantid/instances/instanceid/unrescue",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusAccepted,
+		"null",
+	},
+	{
+		"POST",
+		"/validtenantid/instances/instanceid/archive",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusAccepted,
+		`{"id":"1b2a5a0e-29e5-4b4c-a0c7-8b6f5e2cf0b1","tenant_id":"validtenantid","type":"instance_archive","resource_id":"instanceid","state":"running","progress":0,"created":"0001-01-01T00:00:00Z","updated":"0001-01-01T00:00:00Z"}`,
+	},
+	{
+		"POST",
+		"/validtenantid/instances/archivedid/archive",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusForbidden,
+		`{"error":{"code":403,"name":"Forbidden","message":"Cannot perform operation: instance archived"}}
+`,
+	},
+	{
+		"POST",
+		"/validtenantid/instances/novolumesid/archive",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusForbidden,
+		`{"error":{"code":403,"name":"Forbidden","message":"Instance has no volumes to archive"}}
+`,
+	},
+	{
+		"POST",
+		"/validtenantid/instances/archivedid/rehydrate",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusAccepted,
+		`{"id":"1b2a5a0e-29e5-4b4c-a0c7-8b6f5e2cf0b1","tenant_id":"validtenantid","type":"instance_rehydrate","resource_id":"archivedid","state":"running","progress":0,"created":"0001-01-01T00:00:00Z","updated":"0001-01-01T00:00:00Z"}`,
+	},
+	{
+		"POST",
+		"/validtenantid/instances/instanceid/migrate",
+		`{"node_id":"nodeid"}`,
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusAccepted,
+		`{"id":"1b2a5a0e-29e5-4b4c-a0c7-8b6f5e2cf0b1","tenant_id":"validtenantid","type":"instance_migrate","resource_id":"instanceid","state":"running","progress":0,"created":"0001-01-01T00:00:00Z","updated":"0001-01-01T00:00:00Z"}`,
+	},
+	{
+		"POST",
+		"/validtenantid/instances/migratingid/migrate",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusForbidden,
+		`{"error":{"code":403,"name":"Forbidden","message":"Cannot perform operation: instance migrating"}}
+`,
+	},
+	{
+		"POST",
+		"/validtenantid/instances/instanceid/guest-agent",
+		`{"operation":"exec","path":"/bin/uname","args":["-r"]}`,
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusOK,
+		`{"instance_id":"instanceid","operation":"exec","exit_code":0,"stdout":"4.13.0\n"}`,
+	},
+	{
+		"POST",
+		"/validtenantid/instances/instanceid/guest-agent",
+		`{"operation":"reboot"}`,
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusBadRequest,
+		`{"error":{"code":400,"name":"Bad Request","message":"Invalid guest agent operation reboot"}}
+`,
+	},
+	{
+		"POST",
+		"/validtenantid/instances/instanceid/capture",
+		`{"max_seconds":30,"filter":"icmp"}`,
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusOK,
+		`{"instance_id":"instanceid","node_id":"nodeid","truncated":false,"pcap":"1MOyoQIABAAAAAAAAAAAAP//AAABAAAA"}`,
+	},
+	{
+		"POST",
+		"/validtenantid/instances/instanceid/capture",
+		`{"max_seconds":3600}`,
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusBadRequest,
+		`{"error":{"code":400,"name":"Bad Request","message":"Packet captures are limited to 300 seconds"}}
+`,
+	},
+	{
+		"POST",
+		"/validtenantid/instances/instanceid/reboot",
+		`{"type":"hard"}`,
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusAccepted,
+		"null",
+	},
+	{
+		"POST",
+		"/validtenantid/instances/instanceid/reboot",
+		`{"type":"warm"}`,
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusBadRequest,
+		`{"error":{"code":400,"name":"Bad Request","message":"Invalid reboot type warm"}}
+`,
+	},
+	{
+		"POST",
+		"/validtenantid/instances/instanceid/clone",
+		`{"instances":2}`,
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusAccepted,
+		`{"total_servers":2,"servers":[{"private_addresses":null,"created":"0001-01-01T00:00:00Z","workload_id":"","node_id":"","id":"clone-0","name":"","volumes":null,"status":"pending","tenant_id":"validtenantid","ssh_ip":"","ssh_port":0},{"private_addresses":null,"created":"0001-01-01T00:00:00Z","workload_id":"","node_id":"","id":"clone-1","name":"","volumes":null,"status":"pending","tenant_id":"validtenantid","ssh_ip":"","ssh_port":0}]}`,
+	},
+	{
+		"GET",
+		"/validtenantid/instances/instanceid/actions",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusOK,
+		`[{"instance_id":"instanceid","previous_state":"active","state":"exited","initiator":"user","reason":"api_request","timestamp":"0001-01-01T00:00:00Z"}]`,
+	},
+	{
+		"GET",
+		"/validtenantid/instances/groups",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusOK,
+		`{"groups":[{"name":"web","workload_id":"testWorkloadUUID","instances":["instanceid"]}]}`,
+	},
+	{
+		"GET",
+		"/validtenantid/instances/groups/web",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusOK,
+		`{"name":"web","workload_id":"testWorkloadUUID","instances":["instanceid"]}`,
+	},
+	{
+		"GET",
+		"/validtenantid/instances/groups/unknown",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusNotFound,
+		`{"error":{"code":404,"name":"Not Found","message":"Instance group not found"}}
+`,
+	},
+	{
+		"POST",
+		"/validtenantid/instances/groups/web/action",
+		`{"action":"stop"}`,
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusAccepted,
+		`{"group":"web","action":"stop","results":[{"instance_id":"instanceid","operation":"stop"}]}`,
+	},
+	{
+		"POST",
+		"/validtenantid/instances/groups/web/action",
+		`{"action":"reboot"}`,
 		fmt.Sprintf("application/%s", InstancesV1),
-		http.StatusAccepted,
-		"null",
+		http.StatusForbidden,
+		`{"error":{"code":403,"name":"Forbidden","message":"Invalid Request"}}
+`,
+	},
+	{
+		"GET",
+		"/validtenantid/operations",
+		"",
+		fmt.Sprintf("application/%s", OperationsV1),
+		http.StatusOK,
+		`[{"id":"1b2a5a0e-29e5-4b4c-a0c7-8b6f5e2cf0b1","tenant_id":"validtenantid","type":"volume_clone","resource_id":"new-test-id","state":"running","progress":50,"created":"0001-01-01T00:00:00Z","updated":"0001-01-01T00:00:00Z"}]`,
+	},
+	{
+		"GET",
+		"/validtenantid/operations/1b2a5a0e-29e5-4b4c-a0c7-8b6f5e2cf0b1",
+		"",
+		fmt.Sprintf("application/%s", OperationsV1),
+		http.StatusOK,
+		`{"id":"1b2a5a0e-29e5-4b4c-a0c7-8b6f5e2cf0b1","tenant_id":"validtenantid","type":"volume_clone","resource_id":"new-test-id","state":"running","progress":50,"created":"0001-01-01T00:00:00Z","updated":"0001-01-01T00:00:00Z"}`,
+	},
+	{
+		"GET",
+		"/validtenantid/operations/5d3b8e5e-8f0e-4a3f-9a1d-6a2b5bde7c11",
+		"",
+		fmt.Sprintf("application/%s", OperationsV1),
+		http.StatusNotFound,
+		`{"error":{"code":404,"name":"Not Found","message":"Operation not found"}}
+`,
 	},
 }
 
 type testCiaoService struct{}
 
-func (ts testCiaoService) ListPools() ([]types.Pool, error) {
+func (ts testCiaoService) ListPools(ctx context.Context) ([]types.Pool, error) {
 	self := types.Link{
 		Rel:  "self",
 		Href: "/pools/ba58f471-0735-4773-9550-188e2d012941",
@@ -356,11 +1121,11 @@ func (ts testCiaoService) ListPools() ([]types.Pool, error) {
 	return []types.Pool{resp}, nil
 }
 
-func (ts testCiaoService) AddPool(name string, subnet *string, ips []string) (types.Pool, error) {
+func (ts testCiaoService) AddPool(ctx context.Context, name string, subnet *string, ips []string) (types.Pool, error) {
 	return types.Pool{}, nil
 }
 
-func (ts testCiaoService) ShowPool(id string) (types.Pool, error) {
+func (ts testCiaoService) ShowPool(ctx context.Context, id string) (types.Pool, error) {
 	fmt.Println("ShowPool")
 	self := types.Link{
 		Rel:  "self",
@@ -380,19 +1145,19 @@ func (ts testCiaoService) ShowPool(id string) (types.Pool, error) {
 	return resp, nil
 }
 
-func (ts testCiaoService) DeletePool(id string) error {
+func (ts testCiaoService) DeletePool(ctx context.Context, id string) error {
 	return nil
 }
 
-func (ts testCiaoService) AddAddress(poolID string, subnet *string, ips []string) error {
+func (ts testCiaoService) AddAddress(ctx context.Context, poolID string, subnet *string, ips []string) error {
 	return nil
 }
 
-func (ts testCiaoService) RemoveAddress(poolID string, subnet *string, extIP *string) error {
+func (ts testCiaoService) RemoveAddress(ctx context.Context, poolID string, subnet *string, extIP *string) error {
 	return nil
 }
 
-func (ts testCiaoService) ListMappedAddresses(tenant *string) []types.MappedIP {
+func (ts testCiaoService) ListMappedAddresses(ctx context.Context, tenant *string) []types.MappedIP {
 	var ref string
 
 	m := types.MappedIP{
@@ -431,24 +1196,24 @@ func (ts testCiaoService) ListMappedAddresses(tenant *string) []types.MappedIP {
 	return []types.MappedIP{m}
 }
 
-func (ts testCiaoService) MapAddress(tenantID string, name *string, instanceID string) error {
+func (ts testCiaoService) MapAddress(ctx context.Context, tenantID string, name *string, instanceID string) error {
 	return nil
 }
 
-func (ts testCiaoService) UnMapAddress(string) error {
+func (ts testCiaoService) UnMapAddress(context.Context, string) error {
 	return nil
 }
 
-func (ts testCiaoService) CreateWorkload(req types.Workload) (types.Workload, error) {
+func (ts testCiaoService) CreateWorkload(ctx context.Context, req types.Workload) (types.Workload, error) {
 	req.ID = "ba58f471-0735-4773-9550-188e2d012941"
 	return req, nil
 }
 
-func (ts testCiaoService) DeleteWorkload(tenant string, workload string) error {
+func (ts testCiaoService) DeleteWorkload(ctx context.Context, tenant string, workload string) error {
 	return nil
 }
 
-func (ts testCiaoService) ShowWorkload(tenant string, ID string) (types.Workload, error) {
+func (ts testCiaoService) ShowWorkload(ctx context.Context, tenant string, ID string) (types.Workload, error) {
 	return types.Workload{
 		ID:          "ba58f471-0735-4773-9550-188e2d012941",
 		TenantID:    tenant,
@@ -460,7 +1225,7 @@ func (ts testCiaoService) ShowWorkload(tenant string, ID string) (types.Workload
 	}, nil
 }
 
-func (ts testCiaoService) ListWorkloads(tenant string) ([]types.Workload, error) {
+func (ts testCiaoService) ListWorkloads(ctx context.Context, tenant string) ([]types.Workload, error) {
 	return []types.Workload{
 		{
 			ID:          "ba58f471-0735-4773-9550-188e2d012941",
@@ -474,7 +1239,237 @@ func (ts testCiaoService) ListWorkloads(tenant string) ([]types.Workload, error)
 	}, nil
 }
 
-func (ts testCiaoService) ListQuotas(tenantID string) []types.QuotaDetails {
+func (ts testCiaoService) ListCatalog(ctx context.Context, category string) ([]types.Workload, error) {
+	if category != "" && category != "test" {
+		return []types.Workload{}, nil
+	}
+
+	return []types.Workload{
+		{
+			ID:          "ba58f471-0735-4773-9550-188e2d012941",
+			Description: "testWorkload",
+			FWType:      payloads.Legacy,
+			VMType:      payloads.QEMU,
+			Config:      "this will totally work!",
+			Visibility:  types.Public,
+			Category:    "test",
+		},
+	}, nil
+}
+
+func (ts testCiaoService) UpdateCatalogWorkload(ctx context.Context, workloadID string, category string) error {
+	return nil
+}
+
+func (ts testCiaoService) CloneCatalogWorkload(ctx context.Context, tenantID string, workloadID string) (types.Workload, error) {
+	return types.Workload{
+		ID:          "cf5d7e2b-3a64-4d8e-9f0c-2a1f6e7b4d93",
+		TenantID:    tenantID,
+		Description: "testWorkload",
+		FWType:      payloads.Legacy,
+		VMType:      payloads.QEMU,
+		Config:      "this will totally work!",
+		Visibility:  types.Private,
+	}, nil
+}
+
+func (ts testCiaoService) ListIPReservations(ctx context.Context, tenantID string) ([]types.IPReservation, error) {
+	return []types.IPReservation{
+		{
+			IPAddress:  "172.16.0.10",
+			InstanceID: "validServerID",
+		},
+		{
+			IPAddress: "172.16.0.11",
+		},
+	}, nil
+}
+
+func (ts testCiaoService) ReserveIP(ctx context.Context, tenantID string, IP string) (types.IPReservation, error) {
+	return types.IPReservation{IPAddress: IP}, nil
+}
+
+func (ts testCiaoService) ReleaseIPReservation(ctx context.Context, tenantID string, IP string) error {
+	return nil
+}
+
+func (ts testCiaoService) ListSecrets(ctx context.Context, tenantID string) ([]types.Secret, error) {
+	return []types.Secret{
+		{
+			Name:       "db-password",
+			TenantID:   tenantID,
+			CreateTime: time.Date(2017, 10, 16, 10, 0, 0, 0, time.UTC),
+			Data:       []byte("encrypted"),
+		},
+	}, nil
+}
+
+func (ts testCiaoService) SetSecret(ctx context.Context, tenantID string, name string, value string) error {
+	return nil
+}
+
+func (ts testCiaoService) DeleteSecret(ctx context.Context, tenantID string, name string) error {
+	if name != "db-password" {
+		return types.ErrSecretNotFound
+	}
+
+	return nil
+}
+
+const testAPITokenID = "5d4f2c1e-8b3a-4f6d-9e2b-7c1a0f3e6d52"
+
+func (ts testCiaoService) ListAPITokens(ctx context.Context, tenantID string) ([]types.APIToken, error) {
+	createTime := time.Date(2017, 10, 16, 10, 0, 0, 0, time.UTC)
+
+	return []types.APIToken{
+		{
+			ID:         testAPITokenID,
+			TenantID:   tenantID,
+			Name:       "ci",
+			Scope:      types.APITokenReadOnly,
+			CreateTime: createTime,
+			ExpiryTime: createTime.Add(30 * 24 * time.Hour),
+		},
+	}, nil
+}
+
+func (ts testCiaoService) CreateAPIToken(ctx context.Context, tenantID string, req types.APITokenRequest) (types.APIToken, error) {
+	if req.Scope != types.APITokenReadOnly && req.Scope != types.APITokenFull {
+		return types.APIToken{}, types.ErrBadRequest
+	}
+
+	expiry, err := time.ParseDuration(req.Expiry)
+	if err != nil {
+		return types.APIToken{}, types.ErrBadRequest
+	}
+
+	createTime := time.Date(2017, 10, 16, 10, 0, 0, 0, time.UTC)
+
+	return types.APIToken{
+		ID:         testAPITokenID,
+		TenantID:   tenantID,
+		Name:       req.Name,
+		Scope:      req.Scope,
+		CreateTime: createTime,
+		ExpiryTime: createTime.Add(expiry),
+		Token:      testAPITokenID + ".secret",
+	}, nil
+}
+
+func (ts testCiaoService) DeleteAPIToken(ctx context.Context, tenantID string, tokenID string) error {
+	if tokenID != testAPITokenID {
+		return types.ErrAPITokenNotFound
+	}
+
+	return nil
+}
+
+const testDeletedResourceID = "8e3c6a2b-1d4f-4b7e-a9c5-2f6d0b8e1a47"
+
+func (ts testCiaoService) ListDeletedResources(ctx context.Context, tenantID string) ([]types.DeletedResource, error) {
+	deleteTime := time.Date(2017, 10, 16, 10, 0, 0, 0, time.UTC)
+
+	return []types.DeletedResource{
+		{
+			ID:         testDeletedResourceID,
+			Type:       types.DeletedVolume,
+			Name:       "scratch",
+			DeleteTime: deleteTime,
+			PurgeTime:  deleteTime.Add(24 * time.Hour),
+		},
+	}, nil
+}
+
+func (ts testCiaoService) RestoreDeletedResource(ctx context.Context, tenantID string, resourceID string) error {
+	if resourceID != testDeletedResourceID {
+		return types.ErrNotInRecycleBin
+	}
+
+	return nil
+}
+
+const testSnapshotSetID = "c5a2f4e1-7b3d-4e9a-8f6c-1d2b3a4c5e6f"
+
+func testSnapshotSet(state string) types.SnapshotSet {
+	return types.SnapshotSet{
+		ID:         testSnapshotSetID,
+		Name:       "nightly",
+		TenantID:   "093ae09b-f653-464e-9ae6-5ae28bd03a22",
+		InstanceID: "3390740c-dce9-48d6-b83a-a717417072ce",
+		WorkloadID: "ab68111c-03a6-11e7-b74d-00000000000a",
+		State:      state,
+		Consistent: true,
+		CreateTime: time.Date(2017, 10, 16, 10, 0, 0, 0, time.UTC),
+		Volumes: []types.SnapshotVolume{
+			{
+				ID:        "1e2f7c2d-6a2b-4a63-9d5e-4b1f8c3a0d21",
+				VolumeID:  "67d4cc62-7d4b-4d0c-9d19-1e4b2c9f5a13",
+				Boot:      true,
+				Ephemeral: true,
+				Size:      20,
+			},
+		},
+	}
+}
+
+func (ts testCiaoService) CreateSnapshotSet(ctx context.Context, tenantID string, instanceID string, req types.SnapshotSetRequest) (types.SnapshotSet, error) {
+	return testSnapshotSet(types.SnapshotSetCreating), nil
+}
+
+func (ts testCiaoService) ListSnapshotSets(ctx context.Context, tenantID string) ([]types.SnapshotSet, error) {
+	return []types.SnapshotSet{testSnapshotSet(types.SnapshotSetAvailable)}, nil
+}
+
+func (ts testCiaoService) ShowSnapshotSet(ctx context.Context, tenantID string, ID string) (types.SnapshotSet, error) {
+	if ID != testSnapshotSetID {
+		return types.SnapshotSet{}, types.ErrSnapshotSetNotFound
+	}
+
+	return testSnapshotSet(types.SnapshotSetAvailable), nil
+}
+
+func (ts testCiaoService) DeleteSnapshotSet(ctx context.Context, tenantID string, ID string) error {
+	return nil
+}
+
+func (ts testCiaoService) RestoreSnapshotSet(ctx context.Context, tenantID string, ID string, req types.SnapshotSetRestoreRequest) (Servers, error) {
+	set := testSnapshotSet(types.SnapshotSetAvailable)
+
+	return Servers{
+		TotalServers: 1,
+		Servers: []ServerDetails{
+			{
+				ID:         req.Name,
+				Name:       req.Name,
+				TenantID:   tenantID,
+				WorkloadID: set.WorkloadID,
+			},
+		},
+	}, nil
+}
+
+func (ts testCiaoService) PurgeDeletedResource(ctx context.Context, tenantID string, resourceID string) error {
+	if resourceID != testDeletedResourceID {
+		return types.ErrNotInRecycleBin
+	}
+
+	return nil
+}
+
+func (ts testCiaoService) AuditIPAM(ctx context.Context, tenantID string, repair bool) (types.IPAMAudit, error) {
+	return types.IPAMAudit{
+		Issues: []types.IPAMIssue{
+			{
+				Type:      types.IPAMLeak,
+				TenantID:  "093ae09b-f653-464e-9ae6-5ae28bd03a22",
+				IPAddress: "172.16.0.3",
+				Repaired:  repair,
+			},
+		},
+	}, nil
+}
+
+func (ts testCiaoService) ListQuotas(ctx context.Context, tenantID string) []types.QuotaDetails {
 	return []types.QuotaDetails{
 		{Name: "test-quota-1", Value: 10, Usage: 3},
 		{Name: "test-quota-2", Value: -1, Usage: 10},
@@ -482,19 +1477,19 @@ func (ts testCiaoService) ListQuotas(tenantID string) []types.QuotaDetails {
 	}
 }
 
-func (ts testCiaoService) EvacuateNode(nodeID string) error {
-	return nil
+func (ts testCiaoService) EvacuateNode(ctx context.Context, nodeID string) (types.Operation, error) {
+	return types.Operation{ID: "d7d86208-b46c-4465-9018-ee14087d415f"}, nil
 }
 
-func (ts testCiaoService) RestoreNode(nodeID string) error {
+func (ts testCiaoService) RestoreNode(ctx context.Context, nodeID string) error {
 	return nil
 }
 
-func (ts testCiaoService) UpdateQuotas(tenantID string, qds []types.QuotaDetails) error {
+func (ts testCiaoService) UpdateQuotas(ctx context.Context, tenantID string, qds []types.QuotaDetails) error {
 	return nil
 }
 
-func (ts testCiaoService) ListTenants() ([]types.TenantSummary, error) {
+func (ts testCiaoService) ListTenants(ctx context.Context) ([]types.TenantSummary, error) {
 	summary := types.TenantSummary{
 		ID:   "bc70dcd6-7298-4933-98a9-cded2d232d02",
 		Name: "Test Tenant",
@@ -512,7 +1507,7 @@ func (ts testCiaoService) ListTenants() ([]types.TenantSummary, error) {
 	return []types.TenantSummary{summary}, nil
 }
 
-func (ts testCiaoService) ShowTenant(ID string) (types.TenantConfig, error) {
+func (ts testCiaoService) ShowTenant(ctx context.Context, ID string) (types.TenantConfig, error) {
 	config := types.TenantConfig{
 		Name:       "Test Tenant",
 		SubnetBits: 24,
@@ -521,11 +1516,62 @@ func (ts testCiaoService) ShowTenant(ID string) (types.TenantConfig, error) {
 	return config, nil
 }
 
-func (ts testCiaoService) PatchTenant(string, []byte) error {
-	return nil
+func (ts testCiaoService) ShowTenantUsage(ctx context.Context, ID string) (types.TenantUsageSummary, error) {
+	summary := types.TenantUsageSummary{
+		ID:             ID,
+		Name:           "Test Tenant",
+		Instances:      2,
+		InstanceStates: map[string]int{"running": 1, "exited": 1},
+		Volumes:        1,
+		VolumeSizeGB:   10,
+		ExternalIPs:    []types.MappedIP{},
+		Quotas: []types.QuotaDetails{
+			{Name: "tenant-instances-quota", Value: 10, Usage: 2},
+		},
+		Events: []types.CiaoEvent{
+			{
+				Timestamp: time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC),
+				TenantID:  ID,
+				EventType: "info",
+				Message:   "Started instance",
+			},
+		},
+	}
+
+	return summary, nil
 }
 
-func (ts testCiaoService) CreateTenant(ID string, config types.TenantConfig) (types.TenantSummary, error) {
+func (ts testCiaoService) PatchTenant(context.Context, string, []byte) (types.TenantUpdateResponse, error) {
+	resp := types.TenantUpdateResponse{
+		Previous: types.TenantConfig{
+			Name:       "Test Tenant",
+			SubnetBits: 24,
+		},
+		Config: types.TenantConfig{
+			Name:       "Updated Test Tenant",
+			SubnetBits: 4,
+		},
+	}
+
+	return resp, nil
+}
+
+func (ts testCiaoService) RenumberTenant(ctx context.Context, ID string, req types.TenantRenumberRequest) (types.TenantRenumbering, error) {
+	return types.TenantRenumbering{
+		OldSubnetBits: 24,
+		SubnetBits:    req.SubnetBits,
+		DryRun:        req.DryRun,
+		Conflicts: []types.SubnetConflict{
+			{
+				IPAddress:  "172.16.0.64",
+				Subnet:     "172.16.0.64/26",
+				InstanceID: "3390740c-dce9-48d6-b83a-a717417072ce",
+			},
+		},
+	}, nil
+}
+
+func (ts testCiaoService) CreateTenant(ctx context.Context, ID string, config types.TenantConfig) (types.TenantSummary, error) {
 	summary := types.TenantSummary{
 		ID:   ID,
 		Name: config.Name,
@@ -541,16 +1587,21 @@ func (ts testCiaoService) CreateTenant(ID string, config types.TenantConfig) (ty
 	return summary, nil
 }
 
-func (ts testCiaoService) DeleteTenant(string) error {
+func (ts testCiaoService) DeleteTenant(context.Context, string) error {
 	return nil
 }
 
-func (ts testCiaoService) CreateImage(tenantID string, req CreateImageRequest) (types.Image, error) {
+func (ts testCiaoService) CreateImage(ctx context.Context, tenantID string, req CreateImageRequest) (types.Image, error) {
 	name := "Ubuntu"
 	createdAt, _ := time.Parse(time.RFC3339, "2015-11-29T22:21:42Z")
 
+	state := types.Created
+	if req.URL != "" {
+		state = types.Saving
+	}
+
 	return types.Image{
-		State:      types.Created,
+		State:      state,
 		CreateTime: createdAt,
 		Visibility: types.Private,
 		ID:         "b2173dd3-7ad6-4362-baa6-a68bce3565cb",
@@ -558,49 +1609,241 @@ func (ts testCiaoService) CreateImage(tenantID string, req CreateImageRequest) (
 	}, nil
 }
 
-func (ts testCiaoService) ListImages(tenantID string) ([]types.Image, error) {
+func (ts testCiaoService) ListImages(ctx context.Context, tenantID string) ([]types.Image, error) {
 	name := "Ubuntu"
 	createdAt, _ := time.Parse(time.RFC3339, "2015-11-29T22:21:42Z")
 
-	image := types.Image{
-		State:      types.Created,
-		CreateTime: createdAt,
-		ID:         "b2173dd3-7ad6-4362-baa6-a68bce3565cb",
-		Name:       name,
-		Visibility: types.Public,
+	image := types.Image{
+		State:      types.Created,
+		CreateTime: createdAt,
+		ID:         "b2173dd3-7ad6-4362-baa6-a68bce3565cb",
+		Name:       name,
+		Visibility: types.Public,
+	}
+
+	var images []types.Image
+	images = append(images, image)
+
+	return images, nil
+}
+
+func (ts testCiaoService) GetImage(ctx context.Context, tenantID, ID string) (types.Image, error) {
+	imageID := "1bea47ed-f6a9-463b-b423-14b9cca9ad27"
+	name := "cirros-0.3.2-x86_64-disk"
+	createdAt, _ := time.Parse(time.RFC3339, "2014-05-05T17:15:10Z")
+	var size uint64 = 13167616
+
+	return types.Image{
+		State:      types.Active,
+		CreateTime: createdAt,
+		Visibility: types.Public,
+		ID:         imageID,
+		Name:       name,
+		Size:       size,
+	}, nil
+}
+
+func (ts testCiaoService) UploadImage(context.Context, string, string, io.Reader) error {
+	return nil
+}
+
+func (ts testCiaoService) DeleteImage(context.Context, string, string) error {
+	return nil
+}
+
+const testFailedCommandID = "5d4e1c8a-3f1b-4a39-9f8e-0c6a2b7d9e41"
+
+const testNodePolicyID = "0e0aa7f2-5c1e-4f6b-8a55-1b1f3a1c6d2e"
+
+func (ts testCiaoService) ListNodePolicies(ctx context.Context) ([]types.NodePolicy, error) {
+	return []types.NodePolicy{
+		{
+			NodeID:       testNodePolicyID,
+			Weight:       50,
+			MaxInstances: 10,
+		},
+	}, nil
+}
+
+func (ts testCiaoService) UpdateNodePolicy(ctx context.Context, policy types.NodePolicy) error {
+	if policy.NodeID != testNodePolicyID || policy.Weight > payloads.MaxNodeWeight {
+		return types.ErrBadRequest
+	}
+
+	return nil
+}
+
+func (ts testCiaoService) DeleteNodePolicy(ctx context.Context, nodeID string) error {
+	return nil
+}
+
+func (ts testCiaoService) SubmitSignedRequest(ctx context.Context, req types.SignedRequest) (types.SignedRequestResult, error) {
+	if req.Nonce == "replayed" {
+		return types.SignedRequestResult{}, types.ErrSignedRequestReplayed
+	}
+
+	return types.SignedRequestResult{StatusCode: http.StatusNoContent, Body: "null"}, nil
+}
+
+func (ts testCiaoService) ListSignedRequests(ctx context.Context) ([]types.SignedRequestRecord, error) {
+	created, _ := time.Parse(time.RFC3339, "2017-10-12T09:00:00Z")
+	submitted, _ := time.Parse(time.RFC3339, "2017-10-12T10:00:00Z")
+
+	return []types.SignedRequestRecord{
+		{
+			Nonce:      "5f1c9e2a",
+			Method:     "DELETE",
+			Path:       "/validTenantID/volumes/validVolumeID",
+			Signer:     "admin",
+			Created:    created,
+			SubmitTime: submitted,
+		},
+	}, nil
+}
+
+func (ts testCiaoService) ListFailedCommands(ctx context.Context) ([]types.FailedCommand, error) {
+	timestamp, _ := time.Parse(time.RFC3339, "2017-10-12T09:00:00Z")
+
+	return []types.FailedCommand{
+		{
+			ID:         testFailedCommandID,
+			Command:    "DELETE",
+			InstanceID: "validServerID",
+			NodeID:     "validNodeID",
+			Reason:     "Connection closed",
+			Timestamp:  timestamp,
+			Payload:    "delete:\n  instance_uuid: validServerID\n",
+		},
+	}, nil
+}
+
+func (ts testCiaoService) ReplayFailedCommand(ctx context.Context, ID string) error {
+	if ID != testFailedCommandID {
+		return types.ErrFailedCommandNotFound
+	}
+
+	return nil
+}
+
+func (ts testCiaoService) DeleteFailedCommand(ctx context.Context, ID string) error {
+	if ID != testFailedCommandID {
+		return types.ErrFailedCommandNotFound
+	}
+
+	return nil
+}
+
+const testPeerID = "8f2c6b1e-4d3a-4f5b-9c7e-2a1d0e9f8b73"
+
+func testFederationPeer() types.FederationPeer {
+	createTime, _ := time.Parse(time.RFC3339, "2017-10-12T09:00:00Z")
+
+	return types.FederationPeer{
+		ID:         testPeerID,
+		Name:       "east",
+		URL:        "https://east.example.com:8889",
+		TenantID:   "093ae09b-f653-464e-9ae6-5ae28bd03a22",
+		Workloads:  map[string]string{"validWorkloadID": "peerWorkloadID"},
+		CreateTime: createTime,
+		Token:      []byte("encrypted"),
+	}
+}
+
+func (ts testCiaoService) ListFederationPeers(ctx context.Context) ([]types.FederationPeer, error) {
+	return []types.FederationPeer{testFederationPeer()}, nil
+}
+
+func (ts testCiaoService) AddFederationPeer(ctx context.Context, req types.FederationPeerRequest) (types.FederationPeer, error) {
+	return testFederationPeer(), nil
+}
+
+func (ts testCiaoService) DeleteFederationPeer(ctx context.Context, ID string) error {
+	if ID != testPeerID {
+		return types.ErrPeerNotFound
+	}
+
+	return nil
+}
+
+const testConsoleSessionID = "3c7e9a1b-5d2f-4b8e-a6c4-0f1e2d3c4b5a"
+
+func testConsoleSession() types.ConsoleSession {
+	expireTime, _ := time.Parse(time.RFC3339, "2017-10-12T09:05:00Z")
+
+	return types.ConsoleSession{
+		ID:         testConsoleSessionID,
+		InstanceID: "validServerID",
+		NodeID:     "validNodeID",
+		ExpireTime: expireTime,
+	}
+}
+
+func (ts testCiaoService) OpenConsole(ctx context.Context, instanceID string) (types.ConsoleSession, error) {
+	return testConsoleSession(), nil
+}
+
+func (ts testCiaoService) WriteConsole(ctx context.Context, ID string, input string) (types.ConsoleSession, error) {
+	if ID != testConsoleSessionID {
+		return types.ConsoleSession{}, types.ErrConsoleSessionNotFound
 	}
 
-	var images []types.Image
-	images = append(images, image)
+	session := testConsoleSession()
+	session.Output = input + "Password: "
+	return session, nil
+}
 
-	return images, nil
+func (ts testCiaoService) CloseConsole(ctx context.Context, ID string) error {
+	if ID != testConsoleSessionID {
+		return types.ErrConsoleSessionNotFound
+	}
+
+	return nil
 }
 
-func (ts testCiaoService) GetImage(tenantID, ID string) (types.Image, error) {
-	imageID := "1bea47ed-f6a9-463b-b423-14b9cca9ad27"
-	name := "cirros-0.3.2-x86_64-disk"
-	createdAt, _ := time.Parse(time.RFC3339, "2014-05-05T17:15:10Z")
-	var size uint64 = 13167616
+func (ts testCiaoService) ShowCluster(ctx context.Context) (types.ClusterSummary, error) {
+	loaded, _ := time.Parse(time.RFC3339, "2017-10-12T09:00:00Z")
 
-	return types.Image{
-		State:      types.Active,
-		CreateTime: createdAt,
-		Visibility: types.Public,
-		ID:         imageID,
-		Name:       name,
-		Size:       size,
+	return types.ClusterSummary{
+		Nodes:             3,
+		Tenants:           2,
+		Instances:         10,
+		ConfigVersion:     2,
+		ConfigLoaded:      loaded,
+		ConfigReloadError: "Invalid cluster configuration: Invalid CNCI sizing",
 	}, nil
 }
 
-func (ts testCiaoService) UploadImage(string, string, io.Reader) error {
-	return nil
+func (ts testCiaoService) ShowImageGC(ctx context.Context) (types.ImageGCReport, error) {
+	firstSeen, _ := time.Parse(time.RFC3339, "2017-10-12T09:00:00Z")
+
+	return types.ImageGCReport{
+		LastRun: firstSeen,
+		Devices: []types.ImageGCEntry{
+			{
+				ID:        "3390740c-dce9-48d6-b83a-a717417072ce",
+				State:     types.ImageGCQuarantined,
+				FirstSeen: firstSeen,
+			},
+		},
+	}, nil
 }
 
-func (ts testCiaoService) DeleteImage(string, string) error {
-	return nil
+func (ts testCiaoService) CollectImageGarbage(ctx context.Context) (types.ImageGCReport, error) {
+	firstSeen, _ := time.Parse(time.RFC3339, "2017-10-12T09:00:00Z")
+
+	return types.ImageGCReport{
+		LastRun: firstSeen.Add(24 * time.Hour),
+		Devices: []types.ImageGCEntry{
+			{
+				ID:        "3390740c-dce9-48d6-b83a-a717417072ce",
+				State:     types.ImageGCRemoved,
+				FirstSeen: firstSeen,
+			},
+		},
+	}, nil
 }
 
-func (ts testCiaoService) ShowVolumeDetails(tenant string, volume string) (types.Volume, error) {
+func (ts testCiaoService) ShowVolumeDetails(ctx context.Context, tenant string, volume string) (types.Volume, error) {
 	return types.Volume{
 		BlockDevice: storage.BlockDevice{
 			ID:   "new-test-id",
@@ -613,7 +1856,15 @@ func (ts testCiaoService) ShowVolumeDetails(tenant string, volume string) (types
 	}, nil
 }
 
-func (ts testCiaoService) CreateVolume(tenant string, req RequestedVolume) (types.Volume, error) {
+func (ts testCiaoService) ShowVolumeByName(ctx context.Context, tenant string, name string) (types.Volume, error) {
+	if name == "duplicated" {
+		return types.Volume{}, types.ErrAmbiguousName
+	}
+
+	return ts.ShowVolumeDetails(ctx, tenant, "new-test-id")
+}
+
+func (ts testCiaoService) CreateVolume(ctx context.Context, tenant string, req RequestedVolume) (types.Volume, error) {
 	return types.Volume{
 		BlockDevice: storage.BlockDevice{
 			ID:   "new-test-id",
@@ -626,19 +1877,19 @@ func (ts testCiaoService) CreateVolume(tenant string, req RequestedVolume) (type
 	}, nil
 }
 
-func (ts testCiaoService) DeleteVolume(tenant string, volume string) error {
+func (ts testCiaoService) DeleteVolume(ctx context.Context, tenant string, volume string) error {
 	return nil
 }
 
-func (ts testCiaoService) AttachVolume(tenant string, volume string, instance string, mountpoint string) error {
+func (ts testCiaoService) AttachVolume(ctx context.Context, tenant string, volume string, instance string, mountpoint string) error {
 	return nil
 }
 
-func (ts testCiaoService) DetachVolume(tenant string, volume string, attachment string) error {
+func (ts testCiaoService) DetachVolume(ctx context.Context, tenant string, volume string, attachment string) error {
 	return nil
 }
 
-func (ts testCiaoService) ListVolumesDetail(tenant string) ([]types.Volume, error) {
+func (ts testCiaoService) ListVolumesDetail(ctx context.Context, tenant string) ([]types.Volume, error) {
 	return []types.Volume{
 		{
 			BlockDevice: storage.BlockDevice{
@@ -663,16 +1914,26 @@ func (ts testCiaoService) ListVolumesDetail(tenant string) ([]types.Volume, erro
 	}, nil
 }
 
-func (ts testCiaoService) CreateServer(tenant string, req CreateServerRequest) (interface{}, error) {
+func (ts testCiaoService) CreateServer(ctx context.Context, tenant string, req CreateServerRequest) (interface{}, error) {
 	req.Server.ID = "validServerID"
 	return req, nil
 }
 
-func (ts testCiaoService) ListServersDetail(tenant string) ([]ServerDetails, error) {
+func (ts testCiaoService) CreateSimpleServer(ctx context.Context, tenant string, req SimpleServerRequest) (interface{}, error) {
+	var server CreateServerRequest
+
+	server.Server.ID = "validServerID"
+	server.Server.Image = req.ImageID
+	server.Server.WorkloadID = "simple-" + req.Size
+	server.Server.MinInstances = 1
+	return server, nil
+}
+
+func (ts testCiaoService) ListServersDetail(ctx context.Context, tenant string) ([]ServerDetails, error) {
 	var servers []ServerDetails
 
 	server := ServerDetails{
-		NodeID:     "nodeUUID",
+		NodeID:     "validNodeID",
 		ID:         "testUUID",
 		TenantID:   tenant,
 		WorkloadID: "testWorkloadUUID",
@@ -690,9 +1951,9 @@ func (ts testCiaoService) ListServersDetail(tenant string) ([]ServerDetails, err
 	return servers, nil
 }
 
-func (ts testCiaoService) ShowServerDetails(tenant string, server string) (Server, error) {
+func (ts testCiaoService) ShowServerDetails(ctx context.Context, tenant string, server string) (Server, error) {
 	s := ServerDetails{
-		NodeID:     "nodeUUID",
+		NodeID:     "validNodeID",
 		ID:         server,
 		TenantID:   tenant,
 		WorkloadID: "testWorkloadUUID",
@@ -708,22 +1969,192 @@ func (ts testCiaoService) ShowServerDetails(tenant string, server string) (Serve
 	return Server{Server: s}, nil
 }
 
-func (ts testCiaoService) DeleteServer(tenant string, server string) error {
+func (ts testCiaoService) ShowServerByName(ctx context.Context, tenant string, name string) (Server, error) {
+	if name == "unknown" {
+		return Server{}, types.ErrInstanceNotFound
+	}
+
+	return ts.ShowServerDetails(ctx, tenant, "instanceid")
+}
+
+func (ts testCiaoService) DeleteServer(ctx context.Context, tenant string, server string) error {
 	return nil
 }
 
-func (ts testCiaoService) StartServer(tenant string, server string) error {
+func (ts testCiaoService) StartServer(ctx context.Context, tenant string, server string) error {
 	return nil
 }
 
-func (ts testCiaoService) StopServer(tenant string, server string) error {
+func (ts testCiaoService) StopServer(ctx context.Context, tenant string, server string) error {
+	return nil
+}
+
+func (ts testCiaoService) PauseServer(ctx context.Context, tenant string, server string) error {
+	return nil
+}
+
+func (ts testCiaoService) UnpauseServer(ctx context.Context, tenant string, server string) error {
+	return nil
+}
+
+func (ts testCiaoService) RescueServer(ctx context.Context, tenant string, server string, imageID string) error {
+	return nil
+}
+
+func (ts testCiaoService) UnrescueServer(ctx context.Context, tenant string, server string) error {
+	return nil
+}
+
+func (ts testCiaoService) ArchiveServer(ctx context.Context, tenant string, server string) (types.Operation, error) {
+	if server == "archivedid" {
+		return types.Operation{}, types.ErrInstanceArchived
+	}
+
+	if server == "novolumesid" {
+		return types.Operation{}, types.ErrNoVolumesToArchive
+	}
+
+	return types.Operation{
+		ID:         testOperationID,
+		TenantID:   tenant,
+		Type:       types.InstanceArchive,
+		ResourceID: server,
+		State:      types.OperationRunning,
+	}, nil
+}
+
+func (ts testCiaoService) RehydrateServer(ctx context.Context, tenant string, server string) (types.Operation, error) {
+	if server != "archivedid" {
+		return types.Operation{}, types.ErrInstanceNotArchived
+	}
+
+	return types.Operation{
+		ID:         testOperationID,
+		TenantID:   tenant,
+		Type:       types.InstanceRehydrate,
+		ResourceID: server,
+		State:      types.OperationRunning,
+	}, nil
+}
+
+func (ts testCiaoService) MigrateServer(ctx context.Context, tenant string, server string, nodeID string) (types.Operation, error) {
+	if server == "migratingid" {
+		return types.Operation{}, types.ErrInstanceMigrating
+	}
+
+	return types.Operation{
+		ID:         testOperationID,
+		TenantID:   tenant,
+		Type:       types.InstanceMigrate,
+		ResourceID: server,
+		State:      types.OperationRunning,
+	}, nil
+}
+
+func (ts testCiaoService) GuestAgentCommand(ctx context.Context, tenant string, server string, req types.GuestAgentRequest) (types.GuestAgentResult, error) {
+	return types.GuestAgentResult{
+		InstanceID: server,
+		Operation:  req.Operation,
+		Stdout:     "4.13.0\n",
+	}, nil
+}
+
+func (ts testCiaoService) PacketCaptureInstance(ctx context.Context, tenant string, server string, req types.PacketCaptureRequest) (types.PacketCaptureResult, error) {
+	return types.PacketCaptureResult{
+		InstanceID: server,
+		NodeID:     "nodeid",
+		Pcap:       []byte{0xd4, 0xc3, 0xb2, 0xa1, 2, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0, 0, 1, 0, 0, 0},
+	}, nil
+}
+
+func (ts testCiaoService) RebootServer(ctx context.Context, tenant string, server string, hard bool) error {
 	return nil
 }
 
+func (ts testCiaoService) CloneServer(ctx context.Context, tenant string, server string, req CloneServerRequest) (Servers, error) {
+	var servers Servers
+
+	for i := 0; i < req.Instances; i++ {
+		servers.Servers = append(servers.Servers, ServerDetails{
+			ID:       fmt.Sprintf("clone-%d", i),
+			TenantID: tenant,
+			Status:   "pending",
+		})
+	}
+	servers.TotalServers = len(servers.Servers)
+
+	return servers, nil
+}
+
+func (ts testCiaoService) ListInstanceActions(ctx context.Context, tenant string, server string) ([]types.InstanceAction, error) {
+	return []types.InstanceAction{
+		{
+			InstanceID:    server,
+			PreviousState: "active",
+			State:         "exited",
+			Initiator:     types.InitiatorUser,
+			Reason:        types.ReasonAPIRequest,
+		},
+	}, nil
+}
+
+func (ts testCiaoService) ListInstanceGroups(ctx context.Context, tenant string) ([]InstanceGroup, error) {
+	group, _ := ts.ShowInstanceGroup(ctx, tenant, "web")
+	return []InstanceGroup{group}, nil
+}
+
+func (ts testCiaoService) ShowInstanceGroup(ctx context.Context, tenant string, group string) (InstanceGroup, error) {
+	if group != "web" {
+		return InstanceGroup{}, types.ErrInstanceGroupNotFound
+	}
+
+	return InstanceGroup{
+		Name:       group,
+		WorkloadID: "testWorkloadUUID",
+		Instances:  []string{"instanceid"},
+	}, nil
+}
+
+func (ts testCiaoService) InstanceGroupAction(ctx context.Context, tenant string, group string, req InstanceGroupActionRequest) (InstanceGroupActionResult, error) {
+	if req.Action != GroupStop {
+		return InstanceGroupActionResult{}, types.ErrBadRequest
+	}
+
+	return InstanceGroupActionResult{
+		Group:  group,
+		Action: req.Action,
+		Results: []InstanceGroupMemberResult{
+			{InstanceID: "instanceid", Operation: string(req.Action)},
+		},
+	}, nil
+}
+
+const testOperationID = "1b2a5a0e-29e5-4b4c-a0c7-8b6f5e2cf0b1"
+
+func (ts testCiaoService) ListOperations(ctx context.Context, tenant string) ([]types.Operation, error) {
+	op, _ := ts.ShowOperation(ctx, tenant, testOperationID)
+	return []types.Operation{op}, nil
+}
+
+func (ts testCiaoService) ShowOperation(ctx context.Context, tenant string, operation string) (types.Operation, error) {
+	if operation != testOperationID {
+		return types.Operation{}, types.ErrOperationNotFound
+	}
+
+	return types.Operation{
+		ID:         testOperationID,
+		TenantID:   tenant,
+		Type:       types.VolumeClone,
+		ResourceID: "new-test-id",
+		State:      types.OperationRunning,
+		Progress:   50,
+	}, nil
+}
+
 func TestResponse(t *testing.T) {
 	var ts testCiaoService
 
-	mux := Routes(Config{"", ts}, nil)
+	mux := Routes(Config{"", ts, false}, nil)
 
 	for i, tt := range tests {
 		req, err := http.NewRequest(tt.method, tt.request, bytes.NewBuffer([]byte(tt.requestBody)))
@@ -751,10 +2182,314 @@ func TestResponse(t *testing.T) {
 
 func TestRoutes(t *testing.T) {
 	var ts testCiaoService
-	config := Config{"", ts}
+	config := Config{"", ts, false}
 
 	r := Routes(config, nil)
 	if r == nil {
 		t.Fatalf("No routes returned")
 	}
 }
+
+// Test that clients authenticated with an API token cannot issue or revoke
+// API tokens.
+func TestAPITokenManagementWithToken(t *testing.T) {
+	var ts testCiaoService
+
+	mux := Routes(Config{"", ts, false}, nil)
+
+	requests := []struct {
+		method string
+		url    string
+		body   string
+	}{
+		{"POST", "/093ae09b-f653-464e-9ae6-5ae28bd03a22/tokens", `{"name":"ci","scope":"full","expiry":"24h"}`},
+		{"DELETE", "/093ae09b-f653-464e-9ae6-5ae28bd03a22/tokens/" + testAPITokenID, ""},
+	}
+
+	for _, r := range requests {
+		req, err := http.NewRequest(r.method, r.url, bytes.NewBuffer([]byte(r.body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = req.WithContext(service.SetAPIToken(req.Context(), testAPITokenID))
+		req.Header.Set("Content-Type", fmt.Sprintf("application/%s", TokensV1))
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("%s %s: got %v, expected %v", r.method, r.url, rr.Code, http.StatusForbidden)
+		}
+	}
+}
+
+// Test that the operation tracking a node evacuation is returned in the
+// Location header.
+func TestEvacuateNodeLocation(t *testing.T) {
+	var ts testCiaoService
+
+	mux := Routes(Config{"", ts, false}, nil)
+
+	req, err := http.NewRequest("PUT", "/node/0e0aa7f2-5c1e-4f6b-8a55-1b1f3a1c6d2e", bytes.NewBufferString(`{"status":"MAINTENANCE"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req = req.WithContext(service.SetPrivilege(req.Context(), true))
+	req.Header.Set("Content-Type", fmt.Sprintf("application/%s", NodeV1))
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("got %v, expected %v", rr.Code, http.StatusNoContent)
+	}
+
+	location := rr.Header().Get("Location")
+	if location != "/operations/d7d86208-b46c-4465-9018-ee14087d415f" {
+		t.Errorf("Unexpected location %q", location)
+	}
+}
+
+// Test that responses are sent with the media type negotiated from the
+// Accept header of the requests.
+func TestContentNegotiation(t *testing.T) {
+	var ts testCiaoService
+
+	mux := Routes(Config{"", ts, false}, nil)
+
+	pools := fmt.Sprintf("application/%s", PoolsV1)
+
+	tests := []struct {
+		media       string
+		accept      string
+		status      int
+		contentType string
+	}{
+		{pools, "", http.StatusOK, pools},
+		{pools, pools, http.StatusOK, pools},
+		{pools, "application/json", http.StatusOK, "application/json"},
+		{pools, "text/html, */*;q=0.1", http.StatusOK, pools},
+		{"application/json", "", http.StatusOK, "application/json"},
+		{"application/json", pools, http.StatusOK, pools},
+		{"application/json", "application/x.ciao.pools.v2, application/json;q=0.5", http.StatusOK, "application/json"},
+		{pools, "application/x.ciao.pools.v2", http.StatusNotAcceptable, ""},
+		{pools, "application/json;q=0, text/html", http.StatusNotAcceptable, ""},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", "/pools", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = req.WithContext(service.SetPrivilege(req.Context(), true))
+		req.Header.Set("Content-Type", tt.media)
+		req.Header.Set("Accept", tt.accept)
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("%s accepting %q: got %v, expected %v", tt.media, tt.accept, rr.Code, tt.status)
+			continue
+		}
+
+		if tt.contentType != "" && rr.Header().Get("Content-Type") != tt.contentType {
+			t.Errorf("%s accepting %q: got %s, expected %s", tt.media, tt.accept,
+				rr.Header().Get("Content-Type"), tt.contentType)
+		}
+	}
+
+	// JSON merge patches are answered with the media type of the request.
+	patch := "application/merge-patch+json"
+	req, err := http.NewRequest("PATCH", "/tenants/093ae09b-f653-464e-9ae6-5ae28bd03a22",
+		bytes.NewBufferString(`{"name":"Updated Test Tenant"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req = req.WithContext(service.SetPrivilege(req.Context(), true))
+	req.Header.Set("Content-Type", patch)
+	req.Header.Set("Accept", patch)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != patch {
+		t.Errorf("Unexpected patch response %v %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+}
+
+// Test that requests for a resource served in several versions are
+// dispatched to the handler of the version they ask for.
+func TestVersions(t *testing.T) {
+	var ts testCiaoService
+
+	base := &Context{URL: "", Service: ts}
+	v1, matchContent := base.resource("x.ciao.test.v1")
+	v2, _ := base.resource("x.ciao.test.v2")
+
+	handler := func(version string) func(*Context, http.ResponseWriter, *http.Request) (Response, error) {
+		return func(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+			return Response{http.StatusOK, version}, nil
+		}
+	}
+
+	r := mux.NewRouter()
+	route := r.Handle("/test", Versions{
+		Handler{v1, handler("v1"), false},
+		Handler{v2, handler("v2"), false},
+	})
+	route.HeadersRegexp("Content-Type", matchVersions("x.ciao.test.v1", "x.ciao.test.v2"))
+
+	if matchContent != matchVersions("x.ciao.test.v1") {
+		t.Fatalf("Unexpected content match %s", matchContent)
+	}
+
+	tests := []struct {
+		media    string
+		accept   string
+		status   int
+		response string
+	}{
+		{"application/json", "", http.StatusOK, `"v1"`},
+		{"application/json", "application/json", http.StatusOK, `"v1"`},
+		{"application/json", "application/x.ciao.test.v2", http.StatusOK, `"v2"`},
+		{"application/x.ciao.test.v2", "", http.StatusOK, `"v2"`},
+		{"application/x.ciao.test.v1", "application/x.ciao.test.v2", http.StatusNotAcceptable, ""},
+		{"application/json", "application/x.ciao.test.v3", http.StatusNotAcceptable, ""},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", "/test", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("Content-Type", tt.media)
+		req.Header.Set("Accept", tt.accept)
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("%s accepting %q: got %v, expected %v", tt.media, tt.accept, rr.Code, tt.status)
+			continue
+		}
+
+		if tt.response != "" && rr.Body.String() != tt.response {
+			t.Errorf("%s accepting %q: got %s, expected %s", tt.media, tt.accept, rr.Body.String(), tt.response)
+		}
+	}
+}
+
+// Test that the image routes fail and that the images resource is not
+// listed when the image service is disabled.
+func TestImagesDisabled(t *testing.T) {
+	var ts testCiaoService
+
+	mux := Routes(Config{"", ts, true}, nil)
+
+	requests := []struct {
+		method string
+		url    string
+		status int
+	}{
+		{"GET", "/images", http.StatusNotImplemented},
+		{"POST", "/images", http.StatusNotImplemented},
+		{"GET", "/images/gc", http.StatusNotImplemented},
+		{"DELETE", "/093ae09b-f653-464e-9ae6-5ae28bd03a22/images/73a86d7e-93c0-480e-9c41-ab42f69b7799", http.StatusNotImplemented},
+		{"PUT", "/093ae09b-f653-464e-9ae6-5ae28bd03a22/images/73a86d7e-93c0-480e-9c41-ab42f69b7799/file", http.StatusNotImplemented},
+		{"GET", "/093ae09b-f653-464e-9ae6-5ae28bd03a22/volumes", http.StatusOK},
+	}
+
+	for _, r := range requests {
+		req, err := http.NewRequest(r.method, r.url, bytes.NewBuffer(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = req.WithContext(service.SetPrivilege(req.Context(), true))
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != r.status {
+			t.Errorf("%s %s: got %v, expected %v", r.method, r.url, rr.Code, r.status)
+		}
+
+		if r.status == http.StatusNotImplemented && !strings.Contains(rr.Body.String(), ErrImageServiceDisabled.Error()) {
+			t.Errorf("%s %s: unexpected error %s", r.method, r.url, rr.Body.String())
+		}
+	}
+
+	for _, url := range []string{"/", "/093ae09b-f653-464e-9ae6-5ae28bd03a22"} {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = req.WithContext(service.SetPrivilege(req.Context(), true))
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), `"images"`) {
+			t.Errorf("%s: unexpected resources %s", url, rr.Body.String())
+		}
+	}
+}
+
+func TestFilterImages(t *testing.T) {
+	created, _ := time.Parse(time.RFC3339, "2017-01-01T00:00:00Z")
+
+	var images []types.Image
+	for i := 4; i >= 0; i-- {
+		images = append(images, types.Image{
+			ID:         fmt.Sprintf("image-%d", i),
+			Name:       fmt.Sprintf("image-%d", i),
+			CreateTime: created.Add(time.Duration(i) * time.Hour),
+			Size:       uint64(i) * 1024,
+			Visibility: types.Private,
+		})
+	}
+
+	filter, err := parseImageFilter(ImageFilter{MinSize: 1024, Limit: 2}.Values())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for {
+		page, err := filterImages(images, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(page) == 0 {
+			break
+		}
+
+		if len(page) > filter.Limit {
+			t.Fatalf("Got %d images, limit is %d", len(page), filter.Limit)
+		}
+
+		for _, i := range page {
+			ids = append(ids, i.ID)
+		}
+		filter.Marker = page[len(page)-1].ID
+	}
+
+	expected := []string{"image-1", "image-2", "image-3", "image-4"}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Fatalf("Expected images %v, got %v", expected, ids)
+	}
+
+	filter = ImageFilter{Marker: "image-5"}
+	if _, err := filterImages(images, filter); err == nil {
+		t.Fatal("Unknown marker accepted")
+	}
+}
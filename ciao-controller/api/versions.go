@@ -0,0 +1,192 @@
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const jsonMediaType = "application/json"
+
+// mediaRange is a media range of an Accept header along with its quality.
+type mediaRange struct {
+	mediaType string
+	quality   float64
+}
+
+// parseAccept returns the media ranges of an Accept header, most preferred
+// first. Invalid media ranges and the ones with a zero quality are ignored.
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+
+	for _, a := range strings.Split(accept, ",") {
+		if strings.TrimSpace(a) == "" {
+			continue
+		}
+
+		mediaType, params, err := mime.ParseMediaType(a)
+		if err != nil {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil || quality < 0 || quality > 1 {
+				continue
+			}
+		}
+
+		if quality == 0 {
+			continue
+		}
+
+		ranges = append(ranges, mediaRange{mediaType, quality})
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+
+	return ranges
+}
+
+func (m mediaRange) matches(mediaType string) bool {
+	return m.mediaType == "*/*" || m.mediaType == "application/*" || m.mediaType == mediaType
+}
+
+// requestMediaType returns the media type of the body of a request.
+func requestMediaType(r *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+
+	return mediaType
+}
+
+// negotiate returns the first of the offered media types the Accept header
+// of a request allows, or the first offered media type when the request
+// has no Accept header.
+func negotiate(r *http.Request, offers []string) (string, error) {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return offers[0], nil
+	}
+
+	for _, m := range parseAccept(accept) {
+		for _, offer := range offers {
+			if m.matches(offer) {
+				return offer, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("None of the accepted media types %s is supported, expected one of %s",
+		accept, strings.Join(offers, ", "))
+}
+
+// offers returns the media types the handlers using a context can send:
+// plain JSON and the resource version served, which is a JSON document
+// too.  The media type of the request is preferred, so that a resource
+// version or a JSON based media type, e.g. of a JSON merge patch, is sent
+// back as requested.
+func (c *Context) offers(r *http.Request) []string {
+	offers := []string{jsonMediaType}
+	if c.version != "" {
+		offers = append(offers, "application/"+c.version)
+	}
+
+	mediaType := requestMediaType(r)
+	for i, offer := range offers {
+		if offer == mediaType {
+			offers[0], offers[i] = offers[i], offers[0]
+			return offers
+		}
+	}
+
+	if strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json") {
+		offers = append([]string{mediaType}, offers...)
+	}
+
+	return offers
+}
+
+// resource returns a copy of the context for the handlers serving a
+// version of a resource, along with the regular expression the
+// Content-Type header of the requests routed to them must match.
+func (c *Context) resource(version string) (*Context, string) {
+	context := *c
+	context.version = version
+
+	return &context, matchVersions(version)
+}
+
+// matchVersions returns the regular expression matching the media types of
+// versions of a resource and plain JSON.
+func matchVersions(versions ...string) string {
+	return fmt.Sprintf("application/(%s|json)", strings.Join(versions, "|"))
+}
+
+// Versions serves several versions of a resource side by side. Requests are
+// dispatched to the handler of the version named in their Content-Type
+// header or, failing that, to the one of the version they accept. The
+// first handler serves plain JSON requests and must serve the oldest
+// version, for existing clients to keep working when a version is added.
+// The routes of the resource must match the media types of all the
+// versions, e.g. with matchVersions.
+type Versions []Handler
+
+func (vs Versions) handler(r *http.Request) (Handler, error) {
+	mediaType := requestMediaType(r)
+	for _, h := range vs {
+		if mediaType == "application/"+h.version {
+			return h, nil
+		}
+	}
+
+	offers := []string{jsonMediaType}
+	for _, h := range vs {
+		offers = append(offers, "application/"+h.version)
+	}
+
+	offer, err := negotiate(r, offers)
+	if err != nil {
+		return Handler{}, err
+	}
+
+	for _, h := range vs {
+		if offer == "application/"+h.version {
+			return h, nil
+		}
+	}
+
+	return vs[0], nil
+}
+
+func (vs Versions) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, err := vs.handler(r)
+	if err != nil {
+		writeError(w, r, http.StatusNotAcceptable, err)
+		return
+	}
+
+	h.ServeHTTP(w, r)
+}
@@ -0,0 +1,404 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+var archivePath = flag.String("archive_path", "/var/lib/ciao/data/controller/archive", "Directory holding the volumes of archived instances, e.g. a mounted object store bucket")
+
+// volumeArchive returns the file holding the archive of a volume.
+func volumeArchive(volumeID string) string {
+	return filepath.Join(*archivePath, volumeID+".gz")
+}
+
+// removeVolumeArchive removes the archive of a volume.  Archives which do
+// not exist are ignored.
+func removeVolumeArchive(volumeID string) error {
+	err := os.Remove(volumeArchive(volumeID))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "Error removing archive of volume %s", volumeID)
+	}
+
+	return nil
+}
+
+// archiveResources returns the quota resources consumed by the archive of
+// a volume.
+func archiveResources(data types.Volume) []payloads.RequestedResource {
+	return []payloads.RequestedResource{
+		{Type: payloads.ArchiveDiskGiB, Value: data.Size},
+	}
+}
+
+// instanceArchiveState returns the state of the archived volumes of an
+// instance, or an empty state if none of its volumes is archived or moving
+// to or from the archive.
+func (c *controller) instanceArchiveState(instanceID string) types.BlockState {
+	for _, a := range c.ds.GetStorageAttachments(instanceID) {
+		vol, err := c.ds.GetBlockDevice(a.BlockID)
+		if err != nil {
+			continue
+		}
+
+		switch vol.State {
+		case types.Archiving, types.Archived, types.Rehydrating:
+			return vol.State
+		}
+	}
+
+	return ""
+}
+
+// checkInstanceNotArchived fails if the volumes of an instance are
+// archived, or moving to or from the archive.
+func (c *controller) checkInstanceNotArchived(instanceID string) error {
+	if c.instanceArchiveState(instanceID) != "" {
+		return types.ErrInstanceArchived
+	}
+
+	return nil
+}
+
+// instanceVolumes returns the volumes of an instance in a given state.
+func (c *controller) instanceVolumes(instanceID string, state types.BlockState) ([]types.Volume, error) {
+	var volumes []types.Volume
+
+	for _, a := range c.ds.GetStorageAttachments(instanceID) {
+		vol, err := c.ds.GetBlockDevice(a.BlockID)
+		if err != nil {
+			return nil, err
+		}
+
+		if vol.State == state {
+			volumes = append(volumes, vol)
+		}
+	}
+
+	return volumes, nil
+}
+
+// quotaResources returns the quota resources consumed by the volumes of a
+// tenant.  Internal volumes do not consume any quota.
+func quotaResources(volumes []types.Volume, resources func(types.Volume) []payloads.RequestedResource) []payloads.RequestedResource {
+	var res []payloads.RequestedResource
+
+	for _, vol := range volumes {
+		if !vol.Internal {
+			res = append(res, resources(vol)...)
+		}
+	}
+
+	return res
+}
+
+// archiveVolume writes the block device of a volume to its archive.  The
+// block device is left in place.
+func (c *controller) archiveVolume(vol types.Volume) (err error) {
+	driver, err := c.volumeDriver(vol.Class)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(*archivePath, 0700)
+	if err != nil {
+		return errors.Wrap(err, "Error creating archive directory")
+	}
+
+	f, err := os.OpenFile(volumeArchive(vol.ID), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "Error creating archive of volume %s", vol.ID)
+	}
+
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = removeVolumeArchive(vol.ID)
+		}
+	}()
+
+	w := gzip.NewWriter(f)
+
+	err = driver.ReadBlockDevice(vol.ID, w)
+	if err != nil {
+		return errors.Wrapf(err, "Error reading volume %s", vol.ID)
+	}
+
+	err = w.Close()
+	if err != nil {
+		return errors.Wrapf(err, "Error writing archive of volume %s", vol.ID)
+	}
+
+	err = f.Sync()
+	if err != nil {
+		return errors.Wrapf(err, "Error writing archive of volume %s", vol.ID)
+	}
+
+	err = f.Close()
+	if err != nil {
+		return errors.Wrapf(err, "Error writing archive of volume %s", vol.ID)
+	}
+
+	return nil
+}
+
+// rehydrateVolume restores the block device of a volume from its archive.
+// The archive is left in place.  A block device left behind by a
+// rehydration interrupted by a restart of the controller is replaced.
+func (c *controller) rehydrateVolume(vol types.Volume) error {
+	driver, err := c.volumeDriver(vol.Class)
+	if err != nil {
+		return err
+	}
+
+	_ = driver.DeleteBlockDevice(vol.ID)
+
+	f, err := os.Open(volumeArchive(vol.ID))
+	if err != nil {
+		return errors.Wrapf(err, "Error opening archive of volume %s", vol.ID)
+	}
+	defer func() { _ = f.Close() }()
+
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return errors.Wrapf(err, "Error reading archive of volume %s", vol.ID)
+	}
+
+	_, err = driver.CreateBlockDeviceFromStream(vol.ID, r)
+	if err != nil {
+		return errors.Wrapf(err, "Error restoring volume %s", vol.ID)
+	}
+
+	return nil
+}
+
+// ArchiveServer moves the volumes of a stopped instance to the archive
+// storage and frees their block devices.  The volumes consume archive
+// quota instead of storage quota once archived.  The archival runs in the
+// background and is tracked by the operation returned.
+func (c *controller) ArchiveServer(ctx context.Context, tenant string, ID string) (types.Operation, error) {
+	i, err := c.ds.GetTenantInstance(tenant, ID)
+	if err != nil {
+		return types.Operation{}, err
+	}
+
+	if i.IsDeleted() {
+		return types.Operation{}, types.ErrInstanceNotFound
+	}
+
+	i.StateLock.RLock()
+	state := i.State
+	peerID := i.PeerID
+	i.StateLock.RUnlock()
+
+	if i.CNCI || peerID != "" {
+		return types.Operation{}, types.ErrArchiveNotSupported
+	}
+
+	if state != payloads.Exited {
+		return types.Operation{}, types.ErrInstanceNotStopped
+	}
+
+	c.archiveLock.Lock()
+	defer c.archiveLock.Unlock()
+
+	if err := c.checkInstanceNotArchived(ID); err != nil {
+		return types.Operation{}, err
+	}
+
+	volumes, err := c.instanceVolumes(ID, types.InUse)
+	if err != nil {
+		return types.Operation{}, err
+	}
+
+	if len(volumes) == 0 {
+		return types.Operation{}, types.ErrNoVolumesToArchive
+	}
+
+	resources := quotaResources(volumes, archiveResources)
+	res := <-c.qs.Consume(tenant, resources...)
+	if !res.Allowed() {
+		c.qs.Release(tenant, res.Resources()...)
+		return types.Operation{}, api.ErrQuota
+	}
+
+	for n := range volumes {
+		c.updateVolumeState(ctx, &volumes[n], types.Archiving)
+	}
+
+	op := c.newOperation(tenant, types.InstanceArchive, ID)
+	go c.archiveInstance(context.Background(), i, volumes, op)
+
+	return op, nil
+}
+
+// archiveInstance archives the volumes of an instance.  The block devices
+// are only deleted once all the volumes are archived, and each volume is
+// marked archived before its block device is deleted, so that a volume
+// still archiving after a restart of the controller always has its block
+// device.  The archives written are removed if one of the volumes cannot
+// be archived.
+func (c *controller) archiveInstance(ctx context.Context, i *types.Instance, volumes []types.Volume, op types.Operation) {
+	for n := range volumes {
+		err := c.archiveVolume(volumes[n])
+		if err == nil {
+			c.updateOperation(&op, (n+1)*100/len(volumes))
+			continue
+		}
+
+		glog.Errorf("Error archiving instance %s: %v", i.ID, err)
+
+		for _, vol := range volumes[:n] {
+			_ = removeVolumeArchive(vol.ID)
+		}
+
+		for m := range volumes {
+			c.updateVolumeState(ctx, &volumes[m], types.InUse)
+		}
+
+		c.qs.Release(i.TenantID, quotaResources(volumes, archiveResources)...)
+		c.completeOperation(&op, err)
+
+		msg := fmt.Sprintf("Failed to archive instance %s: %v", i.ID, err)
+		_ = c.ds.LogError(ctx, i.TenantID, msg)
+		return
+	}
+
+	for n := range volumes {
+		c.updateVolumeState(ctx, &volumes[n], types.Archived)
+
+		driver, err := c.volumeDriver(volumes[n].Class)
+		if err == nil {
+			err = driver.DeleteBlockDevice(volumes[n].ID)
+		}
+		if err != nil {
+			glog.Errorf("Error deleting archived volume %s: %v", volumes[n].ID, err)
+		}
+	}
+
+	c.qs.Release(i.TenantID, quotaResources(volumes, storageResources)...)
+	c.completeOperation(&op, nil)
+
+	msg := fmt.Sprintf("Archived instance %s", i.ID)
+	_ = c.ds.LogEvent(ctx, i.TenantID, msg)
+}
+
+// RehydrateServer restores the block devices of the volumes of an archived
+// instance, so that the instance can be started again.  The rehydration
+// runs in the background and is tracked by the operation returned.
+func (c *controller) RehydrateServer(ctx context.Context, tenant string, ID string) (types.Operation, error) {
+	i, err := c.ds.GetTenantInstance(tenant, ID)
+	if err != nil {
+		return types.Operation{}, err
+	}
+
+	if i.IsDeleted() {
+		return types.Operation{}, types.ErrInstanceNotFound
+	}
+
+	c.archiveLock.Lock()
+	defer c.archiveLock.Unlock()
+
+	state := c.instanceArchiveState(ID)
+	if state == "" {
+		return types.Operation{}, types.ErrInstanceNotArchived
+	}
+
+	if state != types.Archived {
+		return types.Operation{}, types.ErrInstanceArchived
+	}
+
+	volumes, err := c.instanceVolumes(ID, types.Archived)
+	if err != nil {
+		return types.Operation{}, err
+	}
+
+	resources := quotaResources(volumes, storageResources)
+	res := <-c.qs.Consume(tenant, resources...)
+	if !res.Allowed() {
+		c.qs.Release(tenant, res.Resources()...)
+		return types.Operation{}, api.ErrQuota
+	}
+
+	for n := range volumes {
+		c.updateVolumeState(ctx, &volumes[n], types.Rehydrating)
+	}
+
+	op := c.newOperation(tenant, types.InstanceRehydrate, ID)
+	go c.rehydrateInstance(context.Background(), i, volumes, op)
+
+	return op, nil
+}
+
+// rehydrateInstance restores the volumes of an archived instance and
+// removes their archives.  The volumes which were restored are deleted
+// again if one of them cannot be restored.
+func (c *controller) rehydrateInstance(ctx context.Context, i *types.Instance, volumes []types.Volume, op types.Operation) {
+	for n := range volumes {
+		err := c.rehydrateVolume(volumes[n])
+		if err == nil {
+			c.updateOperation(&op, (n+1)*100/len(volumes))
+			continue
+		}
+
+		glog.Errorf("Error rehydrating instance %s: %v", i.ID, err)
+
+		for _, vol := range volumes[:n] {
+			driver, derr := c.volumeDriver(vol.Class)
+			if derr == nil {
+				derr = driver.DeleteBlockDevice(vol.ID)
+			}
+			if derr != nil {
+				glog.Errorf("Error deleting volume %s: %v", vol.ID, derr)
+			}
+		}
+
+		for m := range volumes {
+			c.updateVolumeState(ctx, &volumes[m], types.Archived)
+		}
+
+		c.qs.Release(i.TenantID, quotaResources(volumes, storageResources)...)
+		c.completeOperation(&op, err)
+
+		msg := fmt.Sprintf("Failed to rehydrate instance %s: %v", i.ID, err)
+		_ = c.ds.LogError(ctx, i.TenantID, msg)
+		return
+	}
+
+	for n := range volumes {
+		c.updateVolumeState(ctx, &volumes[n], types.InUse)
+		if err := removeVolumeArchive(volumes[n].ID); err != nil {
+			glog.Warningf("Error cleaning up rehydrated instance %s: %v", i.ID, err)
+		}
+	}
+
+	c.qs.Release(i.TenantID, quotaResources(volumes, archiveResources)...)
+	c.completeOperation(&op, nil)
+
+	msg := fmt.Sprintf("Rehydrated instance %s", i.ID)
+	_ = c.ds.LogEvent(ctx, i.TenantID, msg)
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+)
+
+var autoscaleInterval = flag.Duration("autoscale_interval", 30*time.Second, "Interval between evaluations of workload autoscaling policies")
+
+func validateAutoscalePolicy(req *types.Workload) error {
+	policy := req.Autoscale
+
+	// autoscaled instances are launched on behalf of the workload owner.
+	if req.Visibility != types.Private {
+		return types.ErrBadRequest
+	}
+
+	if policy.MinInstances < 0 || policy.MaxInstances < 1 ||
+		policy.MaxInstances < policy.MinInstances {
+		return types.ErrBadRequest
+	}
+
+	if policy.CPUTarget < 1 || policy.CPUTarget > 100 {
+		return types.ErrBadRequest
+	}
+
+	return nil
+}
+
+// autoscaleDelta returns the number of instances to launch, or to delete
+// when negative, for a workload running count instances whose average CPU
+// utilization is avgCPU. Outside of the [min, max] band the count is brought
+// back to the nearest bound. Within the band a single instance is added when
+// the average is over the target, and removed when the remaining instances
+// would stay under the target after absorbing its load.
+func autoscaleDelta(policy types.AutoscalePolicy, count int, avgCPU int) int {
+	if count < policy.MinInstances {
+		return policy.MinInstances - count
+	}
+
+	if count > policy.MaxInstances {
+		return policy.MaxInstances - count
+	}
+
+	if avgCPU < 0 {
+		return 0
+	}
+
+	if avgCPU > policy.CPUTarget && count < policy.MaxInstances {
+		return 1
+	}
+
+	if count > policy.MinInstances && count > 1 &&
+		avgCPU*count/(count-1) < policy.CPUTarget {
+		return -1
+	}
+
+	return 0
+}
+
+func (c *controller) autoscaler(stop <-chan struct{}) {
+	ctx := context.Background()
+
+	ticker := time.NewTicker(*autoscaleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.autoscale(ctx)
+		}
+	}
+}
+
+// autoscale evaluates the autoscaling policy of every tenant workload.
+func (c *controller) autoscale(ctx context.Context) {
+	tenants, err := c.ds.GetAllTenants()
+	if err != nil {
+		glog.Warningf("Error getting tenants for autoscaling: %v", err)
+		return
+	}
+
+	for _, t := range tenants {
+		wls, err := c.ds.GetTenantWorkloads(t.ID)
+		if err != nil {
+			glog.Warningf("Error getting workloads of tenant %s: %v", t.ID, err)
+			continue
+		}
+
+		for _, wl := range wls {
+			if wl.Autoscale == nil {
+				continue
+			}
+
+			c.autoscaleWorkload(ctx, wl)
+		}
+	}
+}
+
+func (c *controller) autoscaleWorkload(ctx context.Context, wl types.Workload) {
+	instances, err := c.ds.GetAllInstancesFromTenant(wl.TenantID)
+	if err != nil {
+		glog.Warningf("Error getting instances of tenant %s: %v", wl.TenantID, err)
+		return
+	}
+
+	var running []*types.Instance
+	for _, i := range instances {
+		if i.WorkloadID != wl.ID {
+			continue
+		}
+
+		switch i.State {
+		case payloads.Pending:
+			// wait for previous scaling decisions to settle.
+			return
+		case payloads.Running:
+			running = append(running, i)
+		}
+	}
+
+	avgCPU := -1
+	if len(running) > 0 {
+		total := 0
+		for _, i := range running {
+			stat, _ := c.ds.GetInstanceLastStat(i.ID)
+			total += stat.VCPUUsage
+		}
+		avgCPU = total / len(running)
+	}
+
+	delta := autoscaleDelta(*wl.Autoscale, len(running), avgCPU)
+	if delta > 0 {
+		c.scaleUp(ctx, wl, delta, avgCPU)
+	} else if delta < 0 {
+		c.scaleDown(ctx, wl, running, -delta, avgCPU)
+	}
+}
+
+func (c *controller) logScalingEvent(ctx context.Context, wl types.Workload, msg string, avgCPU int) {
+	msg = fmt.Sprintf("Autoscaling workload %s: %s (average CPU %d%%, target %d%%)",
+		wl.ID, msg, avgCPU, wl.Autoscale.CPUTarget)
+
+	err := c.ds.LogEvent(ctx, wl.TenantID, msg)
+	if err != nil {
+		glog.Warningf("Error logging event: %v", err)
+	}
+}
+
+func (c *controller) scaleUp(ctx context.Context, wl types.Workload, num int, avgCPU int) {
+	w := types.WorkloadRequest{
+		WorkloadID: wl.ID,
+		TenantID:   wl.TenantID,
+		Instances:  num,
+	}
+
+	instances, err := c.startWorkload(ctx, w)
+	if err != nil {
+		glog.Warningf("Error scaling up workload %s: %v", wl.ID, err)
+	}
+
+	if len(instances) > 0 {
+		c.logScalingEvent(ctx, wl, fmt.Sprintf("launched %d instance(s)", len(instances)), avgCPU)
+	}
+}
+
+// scaleDown deletes the most recently created running instances first.
+func (c *controller) scaleDown(ctx context.Context, wl types.Workload, running []*types.Instance, num int, avgCPU int) {
+	sort.Slice(running, func(i, j int) bool {
+		return running[i].CreateTime.After(running[j].CreateTime)
+	})
+
+	deleted := 0
+	for _, i := range running {
+		if deleted == num {
+			break
+		}
+
+		err := c.deleteInstance(ctx, i.ID)
+		if err != nil {
+			glog.Warningf("Error scaling down workload %s: %v", wl.ID, err)
+			continue
+		}
+
+		deleted++
+	}
+
+	if deleted > 0 {
+		c.logScalingEvent(ctx, wl, fmt.Sprintf("deleted %d instance(s)", deleted), avgCPU)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Limits of the packet captures which do not set their own.
+	defaultPacketCaptureBytes   = 4 * 1024 * 1024
+	defaultPacketCaptureSeconds = 60
+
+	// packetCaptureGracePeriod is how long to wait for the packets
+	// captured to be received once the capture is over.
+	packetCaptureGracePeriod = 30 * time.Second
+)
+
+// PacketCaptureInstance captures the network traffic of a running instance
+// on its compute node and waits for the captured packets.
+func (c *controller) PacketCaptureInstance(ctx context.Context, tenantID string, ID string,
+	req types.PacketCaptureRequest) (types.PacketCaptureResult, error) {
+	i, err := c.ds.GetTenantInstance(tenantID, ID)
+	if err != nil {
+		return types.PacketCaptureResult{}, err
+	}
+
+	if i.CNCI {
+		return types.PacketCaptureResult{}, types.ErrPacketCaptureNotSupported
+	}
+
+	i.StateLock.RLock()
+	state := i.State
+	nodeID := i.NodeID
+	i.StateLock.RUnlock()
+
+	if nodeID == "" {
+		return types.PacketCaptureResult{}, types.ErrInstanceNotAssigned
+	}
+
+	if state != payloads.Running {
+		return types.PacketCaptureResult{}, types.ErrInstanceNotRunning
+	}
+
+	cmd := payloads.PacketCaptureCmd{
+		InstanceUUID:      i.ID,
+		WorkloadAgentUUID: nodeID,
+		CaptureUUID:       uuid.Generate().String(),
+		MaxBytes:          req.MaxBytes,
+		MaxSeconds:        req.MaxSeconds,
+		Filter:            req.Filter,
+	}
+
+	if cmd.MaxBytes == 0 {
+		cmd.MaxBytes = defaultPacketCaptureBytes
+	}
+
+	if cmd.MaxSeconds == 0 {
+		cmd.MaxSeconds = defaultPacketCaptureSeconds
+	}
+
+	resultCh := make(chan payloads.PacketCaptureResultEvent, 1)
+	c.captureLock.Lock()
+	if c.captureCalls == nil {
+		c.captureCalls = make(map[string]chan payloads.PacketCaptureResultEvent)
+	}
+	c.captureCalls[cmd.CaptureUUID] = resultCh
+	c.captureLock.Unlock()
+
+	defer func() {
+		c.captureLock.Lock()
+		delete(c.captureCalls, cmd.CaptureUUID)
+		c.captureLock.Unlock()
+	}()
+
+	msg := fmt.Sprintf("Capturing packets of instance %s for up to %d seconds", i.ID, cmd.MaxSeconds)
+	_ = c.ds.LogEvent(ctx, tenantID, msg)
+
+	err = c.client.PacketCaptureCommand(cmd)
+	if err != nil {
+		return types.PacketCaptureResult{}, errors.Wrap(err, "Error sending packet capture command")
+	}
+
+	timeout := time.Duration(cmd.MaxSeconds)*time.Second + packetCaptureGracePeriod
+
+	var event payloads.PacketCaptureResultEvent
+	select {
+	case event = <-resultCh:
+	case <-ctx.Done():
+		return types.PacketCaptureResult{}, ctx.Err()
+	case <-time.After(timeout):
+		return types.PacketCaptureResult{}, types.ErrPacketCaptureTimeout
+	}
+
+	if event.Error != "" {
+		return types.PacketCaptureResult{}, fmt.Errorf("Packet capture failed: %s", event.Error)
+	}
+
+	pcap, err := base64.StdEncoding.DecodeString(event.Pcap)
+	if err != nil {
+		return types.PacketCaptureResult{}, errors.Wrap(err, "Invalid packet capture received")
+	}
+
+	return types.PacketCaptureResult{
+		InstanceID: i.ID,
+		NodeID:     nodeID,
+		Truncated:  event.Truncated,
+		Pcap:       pcap,
+	}, nil
+}
+
+// packetCaptureResult hands the packets captured over to the request
+// waiting for them.  Captures of requests which timed out are dropped.
+func (c *controller) packetCaptureResult(event payloads.PacketCaptureResultEvent) {
+	c.captureLock.Lock()
+	resultCh, ok := c.captureCalls[event.CaptureUUID]
+	c.captureLock.Unlock()
+
+	if !ok {
+		glog.Warningf("Dropping packets of unknown capture %s", event.CaptureUUID)
+		return
+	}
+
+	select {
+	case resultCh <- event:
+	default:
+	}
+}
@@ -18,48 +18,107 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/ciao-project/ciao/payloads"
 	"github.com/ciao-project/ciao/ssntp"
+	"github.com/ciao-project/ciao/uuid"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
 )
 
+// maxStreamedPayloads is the amount of memory used to rebuild the payloads
+// streamed to the controller, i.e., the packet captures.
+const maxStreamedPayloads = 4 * payloads.MaxPacketCaptureBytes
+
 type controllerClient interface {
 	ssntp.ClientNotifier
 	StartTracedWorkload(config string, startTime time.Time, label string) error
 	StartWorkload(config string) error
 	DeleteInstance(instanceID string, nodeID string) error
 	StopInstance(instanceID string, nodeID string) error
+	PauseInstance(instanceID string, nodeID string) error
+	UnpauseInstance(instanceID string, nodeID string) error
+	RescueInstance(instanceID string, nodeID string, volume payloads.StorageResource) error
+	UnrescueInstance(instanceID string, nodeID string) error
+	RebootInstance(instanceID string, nodeID string, hard bool) error
+	ConsoleCommand(cmd payloads.ConsoleCmd) error
+	GuestAgentCommand(cmd payloads.GuestAgentCmd) error
+	PacketCaptureCommand(cmd payloads.PacketCaptureCmd) error
 	RestartInstance(i *types.Instance, w *types.Workload, t *types.Tenant) error
+	MigrateInstance(i *types.Instance, w *types.Workload, t *types.Tenant, source string, nodeID string) error
+	MigrateCommand(cmd payloads.MigrateCmd) error
 	RemoveInstance(instanceID string)
 	EvacuateNode(nodeID string) error
 	RestoreNode(nodeID string) error
+	SendNodePolicies(policies []types.NodePolicy) error
+	SendConfiguration(payload []byte) error
 	Disconnect()
+	Connected() bool
 	mapExternalIP(t types.Tenant, m types.MappedIP) error
 	unMapExternalIP(t types.Tenant, m types.MappedIP) error
 	attachVolume(volID string, instanceID string, nodeID string) error
 	ssntpClient() *ssntp.Client
 	CNCIRefresh(cnciID string, cnciList []payloads.CNCINet) error
+	replayCommand(cmd ssntp.Command, payload []byte) error
 }
 
 type ssntpClient struct {
-	ctl   *controller
-	ssntp ssntp.Client
-	name  string
+	// sequence is the sequence of the last instance command sent.  It
+	// is accessed atomically and kept first for 64-bit alignment.
+	sequence uint64
+
+	ctl     *controller
+	ssntp   ssntp.Client
+	name    string
+	streams *ssntp.StreamAssembler
+
+	connected     bool
+	connectedLock sync.Mutex
 }
 
 func (client *ssntpClient) ConnectNotify() {
+	ctx := context.Background()
+
 	glog.Info(client.name, " connected")
+
+	client.connectedLock.Lock()
+	client.connected = true
+	client.connectedLock.Unlock()
+
+	// the scheduler does not persist the node policies
+	go func() {
+		policies, err := client.ctl.ds.GetNodePolicies(ctx)
+		if err == nil {
+			err = client.SendNodePolicies(policies)
+		}
+		if err != nil {
+			glog.Warningf("Error sending node policies: %v", err)
+		}
+	}()
 }
 
 func (client *ssntpClient) DisconnectNotify() {
 	glog.Info(client.name, " disconnected")
+
+	client.connectedLock.Lock()
+	client.connected = false
+	client.connectedLock.Unlock()
+}
+
+// Connected reports whether the controller is connected to the scheduler.
+func (client *ssntpClient) Connected() bool {
+	client.connectedLock.Lock()
+	defer client.connectedLock.Unlock()
+	return client.connected
 }
 
 func (client *ssntpClient) StatusNotify(status ssntp.Status, frame *ssntp.Frame) {
@@ -67,6 +126,8 @@ func (client *ssntpClient) StatusNotify(status ssntp.Status, frame *ssntp.Frame)
 }
 
 func (client *ssntpClient) CommandNotify(command ssntp.Command, frame *ssntp.Frame) {
+	ctx := context.Background()
+
 	var stats payloads.Stat
 	payload := frame.Payload
 
@@ -79,16 +140,40 @@ func (client *ssntpClient) CommandNotify(command ssntp.Command, frame *ssntp.Fra
 			glog.Warningf("Error unmarshalling STATS: %v", err)
 			return
 		}
-		err = client.ctl.ds.HandleStats(stats)
+
+		// only the instances which were not running when last
+		// reported may complete their launch.
+		var started []string
+		for _, i := range stats.Instances {
+			if i.State != payloads.Running {
+				continue
+			}
+			last, ok := client.ctl.ds.GetInstanceLastStat(i.InstanceUUID)
+			if !ok || last.Status != payloads.Running {
+				started = append(started, i.InstanceUUID)
+			}
+		}
+
+		err = client.ctl.ds.HandleStats(ctx, stats)
 		if err != nil {
 			glog.Warningf("Error updating stats in datastore: %v", err)
 		}
+
+		for _, ID := range started {
+			client.ctl.completeResourceOperations(ID, types.InstanceCreate, nil)
+		}
+
+		client.ctl.completeNodeEvacuation(stats.NodeUUID)
+
+		for _, i := range stats.Instances {
+			client.ctl.releaseRescueVolume(ctx, i.InstanceUUID, i.Volumes)
+		}
 	}
 	glog.V(1).Info(string(payload))
 }
 
-func (client *ssntpClient) deleteEphemeralStorage(instanceID string) {
-	err := client.ctl.deleteEphemeralStorage(instanceID)
+func (client *ssntpClient) deleteEphemeralStorage(ctx context.Context, instanceID string) {
+	err := client.ctl.deleteEphemeralStorage(ctx, instanceID)
 	if err != nil {
 		glog.Warningf("Error deleting ephemeral storage for instance: %s: %v", instanceID, err)
 	}
@@ -110,20 +195,19 @@ func (client *ssntpClient) releaseResources(instanceID string) error {
 		return errors.Wrapf(err, "error getting workload for instance from datastore")
 	}
 
-	resources := []payloads.RequestedResource{
-		{Type: payloads.Instance, Value: 1},
-		{Type: payloads.MemMB, Value: wl.Requirements.MemMB},
-		{Type: payloads.VCPUs, Value: wl.Requirements.VCPUs}}
+	resources := instanceResources(&wl, i.Preemptible)
 	client.ctl.qs.Release(i.TenantID, resources...)
 	return nil
 }
 
 func (client *ssntpClient) RemoveInstance(instanceID string) {
+	ctx := context.Background()
+
 	err := client.releaseResources(instanceID)
 	if err != nil {
 		glog.Warningf("Error when releasing resources for deleted instance: %v", err)
 	}
-	client.deleteEphemeralStorage(instanceID)
+	client.deleteEphemeralStorage(ctx, instanceID)
 
 	i, err := client.ctl.ds.GetInstance(instanceID)
 	if err != nil {
@@ -131,13 +215,17 @@ func (client *ssntpClient) RemoveInstance(instanceID string) {
 		return
 	}
 
-	err = client.ctl.ds.DeleteInstance(instanceID)
+	err = client.ctl.ds.DeleteInstance(ctx, instanceID)
 	if err != nil {
 		glog.Warningf("Error deleting instance from datastore: %v", err)
 	}
 
+	if !i.CNCI {
+		client.ctl.releaseSimpleWorkload(ctx, i.WorkloadID)
+	}
+
 	if i.CNCI {
-		tenant, err := client.ctl.ds.GetTenant(i.TenantID)
+		tenant, err := client.ctl.ds.GetTenant(ctx, i.TenantID)
 		if err != nil {
 			glog.Warningf("Error retrieving tenant %v", err)
 			return
@@ -163,10 +251,15 @@ func (client *ssntpClient) instanceDeleted(payload []byte) {
 		glog.Warningf("Error unmarshalling InstanceDeleted: %v", err)
 		return
 	}
-	client.RemoveInstance(event.InstanceDeleted.InstanceUUID)
+	instanceID := event.InstanceDeleted.InstanceUUID
+	i, err := client.ctl.ds.GetInstance(instanceID)
+	client.RemoveInstance(instanceID)
+	if err == nil {
+		client.ctl.completeNodeEvacuation(i.NodeID)
+	}
 }
 
-func (client *ssntpClient) instanceStopped(payload []byte) {
+func (client *ssntpClient) instanceStopped(ctx context.Context, payload []byte) {
 	var event payloads.EventInstanceStopped
 	err := yaml.Unmarshal(payload, &event)
 	if err != nil {
@@ -182,13 +275,23 @@ func (client *ssntpClient) instanceStopped(payload []byte) {
 		return
 	}
 
-	err = client.ctl.ds.InstanceStopped(instanceID)
+	err = client.ctl.ds.InstanceStopped(ctx, instanceID)
 	if err != nil {
 		glog.Warningf("Error stopping instance from datastore: %v", err)
 	}
 
+	client.ctl.completeNodeEvacuation(i.NodeID)
+
+	if event.InstanceStopped.StopMethod == payloads.StopForced {
+		msg := fmt.Sprintf("Instance %s killed after failing to shut down", instanceID)
+		err = client.ctl.ds.LogEvent(ctx, i.TenantID, msg)
+		if err != nil {
+			glog.Warningf("Error logging event: %v", err)
+		}
+	}
+
 	if i.CNCI {
-		tenant, err := client.ctl.ds.GetTenant(i.TenantID)
+		tenant, err := client.ctl.ds.GetTenant(ctx, i.TenantID)
 		if err != nil {
 			glog.Warningf("Error retrieving tenant %v", err)
 			return
@@ -200,7 +303,24 @@ func (client *ssntpClient) instanceStopped(payload []byte) {
 	}
 }
 
-func (client *ssntpClient) concentratorInstanceAdded(payload []byte) {
+func (client *ssntpClient) instancesPreempted(ctx context.Context, payload []byte) {
+	var event payloads.EventInstancesPreempted
+	err := yaml.Unmarshal(payload, &event)
+	if err != nil {
+		glog.Warningf("Error unmarshalling InstancesPreempted: %v", err)
+		return
+	}
+
+	preemption := event.InstancesPreempted
+	for _, instanceID := range preemption.Preempted {
+		err = client.ctl.preemptInstance(ctx, instanceID, preemption.InstanceUUID)
+		if err != nil {
+			glog.Warningf("Error preempting instance %s: %v", instanceID, err)
+		}
+	}
+}
+
+func (client *ssntpClient) concentratorInstanceAdded(ctx context.Context, payload []byte) {
 	var event payloads.EventConcentratorInstanceAdded
 	err := yaml.Unmarshal(payload, &event)
 	if err != nil {
@@ -217,12 +337,12 @@ func (client *ssntpClient) concentratorInstanceAdded(payload []byte) {
 	i.IPAddress = newCNCI.ConcentratorIP
 	i.MACAddress = newCNCI.ConcentratorMAC
 
-	err = client.ctl.ds.UpdateInstance(i)
+	err = client.ctl.ds.UpdateInstance(ctx, i)
 	if err != nil {
 		glog.Warningf("Error updating CNCI Info: %v", err)
 	}
 
-	tenant, err := client.ctl.ds.GetTenant(i.TenantID)
+	tenant, err := client.ctl.ds.GetTenant(ctx, i.TenantID)
 	if err != nil || tenant == nil {
 		glog.Warningf("Error getting tenant: %v", err)
 		return
@@ -234,14 +354,14 @@ func (client *ssntpClient) concentratorInstanceAdded(payload []byte) {
 	}
 }
 
-func (client *ssntpClient) traceReport(payload []byte) {
+func (client *ssntpClient) traceReport(ctx context.Context, payload []byte) {
 	var trace payloads.Trace
 	err := yaml.Unmarshal(payload, &trace)
 	if err != nil {
 		glog.Warningf("Error unmarshalling TraceReport: %v", err)
 		return
 	}
-	err = client.ctl.ds.HandleTraceReport(trace)
+	err = client.ctl.ds.HandleTraceReport(ctx, trace)
 	if err != nil {
 		glog.Warningf("Error updating trace report in datastore: %v", err)
 	}
@@ -268,13 +388,17 @@ func (client *ssntpClient) nodeDisconnected(payload []byte) {
 	}
 
 	glog.Infof("Node %s disconnected", nodeDisconnected.Disconnected.NodeUUID)
+
+	if nodeUUID, err := uuid.Parse(nodeDisconnected.Disconnected.NodeUUID); err == nil {
+		client.streams.Discard(nodeUUID)
+	}
 	err = client.ctl.ds.DeleteNode(nodeDisconnected.Disconnected.NodeUUID)
 	if err != nil {
 		glog.Warningf("Error marking node as deleted in datastore: %v", err)
 	}
 }
 
-func (client *ssntpClient) unassignEvent(payload []byte) {
+func (client *ssntpClient) unassignEvent(ctx context.Context, payload []byte) {
 	var event payloads.EventPublicIPUnassigned
 	err := yaml.Unmarshal(payload, &event)
 	if err != nil {
@@ -288,7 +412,7 @@ func (client *ssntpClient) unassignEvent(payload []byte) {
 		return
 	}
 
-	err = client.ctl.ds.UnMapExternalIP(event.UnassignedIP.PublicIP)
+	err = client.ctl.ds.UnMapExternalIP(ctx, event.UnassignedIP.PublicIP)
 	if err != nil {
 		glog.Warningf("Error unmapping external IP: %v", err)
 		return
@@ -297,13 +421,15 @@ func (client *ssntpClient) unassignEvent(payload []byte) {
 	client.ctl.qs.Release(i.TenantID, payloads.RequestedResource{Type: payloads.ExternalIP, Value: 1})
 
 	msg := fmt.Sprintf("Unmapped %s from %s", event.UnassignedIP.PublicIP, event.UnassignedIP.PrivateIP)
-	err = client.ctl.ds.LogEvent(i.TenantID, msg)
+	err = client.ctl.ds.LogEvent(ctx, i.TenantID, msg)
 	if err != nil {
 		glog.Warningf("Error logging event: %v", err)
 	}
+
+	client.ctl.removeExternalIPRecord(ctx, i, event.UnassignedIP.PublicIP)
 }
 
-func (client *ssntpClient) assignEvent(payload []byte) {
+func (client *ssntpClient) assignEvent(ctx context.Context, payload []byte) {
 	var event payloads.EventPublicIPAssigned
 	err := yaml.Unmarshal(payload, &event)
 	if err != nil {
@@ -318,13 +444,175 @@ func (client *ssntpClient) assignEvent(payload []byte) {
 	}
 
 	msg := fmt.Sprintf("Mapped %s to %s", event.AssignedIP.PublicIP, event.AssignedIP.PrivateIP)
-	err = client.ctl.ds.LogEvent(i.TenantID, msg)
+	err = client.ctl.ds.LogEvent(ctx, i.TenantID, msg)
 	if err != nil {
 		glog.Warningf("Error logging event: %v", err)
 	}
+
+	client.ctl.publishExternalIPRecord(ctx, i, event.AssignedIP.PublicIP)
+}
+
+func (client *ssntpClient) diskUsageAlert(ctx context.Context, payload []byte) {
+	var event payloads.EventDiskUsageAlert
+	err := yaml.Unmarshal(payload, &event)
+	if err != nil {
+		glog.Warningf("Error unmarshalling DiskUsageAlert: %v", err)
+		return
+	}
+
+	alert := event.DiskUsageAlert
+	i, err := client.ctl.ds.GetInstance(alert.InstanceUUID)
+	if err != nil {
+		glog.Warningf("Error getting instance from datastore: %v", err)
+		return
+	}
+
+	msg := fmt.Sprintf("Instance %s is using %d%% of its disk: %d/%d MB",
+		alert.InstanceUUID, alert.Threshold, alert.UsageMB, alert.LimitMB)
+	err = client.ctl.ds.LogError(ctx, i.TenantID, msg)
+	if err != nil {
+		glog.Warningf("Error logging event: %v", err)
+	}
+}
+
+func (client *ssntpClient) watchdogFired(ctx context.Context, payload []byte) {
+	var event payloads.EventWatchdogFired
+	err := yaml.Unmarshal(payload, &event)
+	if err != nil {
+		glog.Warningf("Error unmarshalling WatchdogFired: %v", err)
+		return
+	}
+
+	fired := event.WatchdogFired
+	i, err := client.ctl.ds.GetInstance(fired.InstanceUUID)
+	if err != nil {
+		glog.Warningf("Error getting instance from datastore: %v", err)
+		return
+	}
+
+	msg := fmt.Sprintf("Watchdog of instance %s fired, action: %s",
+		fired.InstanceUUID, fired.Action)
+	err = client.ctl.ds.LogError(ctx, i.TenantID, msg)
+	if err != nil {
+		glog.Warningf("Error logging event: %v", err)
+	}
+}
+
+func (client *ssntpClient) consoleOutput(ctx context.Context, payload []byte) {
+	var event payloads.EventConsoleOutput
+	err := yaml.Unmarshal(payload, &event)
+	if err != nil {
+		glog.Warningf("Error unmarshalling ConsoleOutput: %v", err)
+		return
+	}
+
+	client.ctl.consoleOutput(ctx, event.ConsoleOutput)
+}
+
+func (client *ssntpClient) guestAgentResult(payload []byte) {
+	var event payloads.EventGuestAgentResult
+	err := yaml.Unmarshal(payload, &event)
+	if err != nil {
+		glog.Warningf("Error unmarshalling GuestAgentResult: %v", err)
+		return
+	}
+
+	client.ctl.guestAgentResult(event.GuestAgentResult)
+}
+
+// packetCaptureResult rebuilds the PacketCaptureResult events streamed by
+// the launchers before handing them over to the controller.
+func (client *ssntpClient) packetCaptureResult(frame *ssntp.Frame) {
+	payload, complete, err := client.streams.Add(frame)
+	if err != nil {
+		glog.Warningf("Error receiving PacketCaptureResult: %v", err)
+		return
+	}
+
+	if !complete {
+		return
+	}
+
+	var event payloads.EventPacketCaptureResult
+	err = yaml.Unmarshal(payload, &event)
+	if err != nil {
+		glog.Warningf("Error unmarshalling PacketCaptureResult: %v", err)
+		return
+	}
+
+	client.ctl.packetCaptureResult(event.PacketCaptureResult)
+}
+
+func (client *ssntpClient) migrationStatus(ctx context.Context, payload []byte) {
+	var event payloads.EventMigrationStatus
+	err := yaml.Unmarshal(payload, &event)
+	if err != nil {
+		glog.Warningf("Error unmarshalling MigrationStatus: %v", err)
+		return
+	}
+
+	client.ctl.migrationStatus(ctx, event.MigrationStatus)
+}
+
+func (client *ssntpClient) instanceReachability(ctx context.Context, payload []byte) {
+	var event payloads.EventInstanceReachability
+	err := yaml.Unmarshal(payload, &event)
+	if err != nil {
+		glog.Warningf("Error unmarshalling InstanceReachability: %v", err)
+		return
+	}
+
+	probe := event.InstanceReachability
+	tenant, err := client.ctl.ds.GetTenant(ctx, probe.TenantUUID)
+	if err != nil || tenant == nil {
+		glog.Warningf("Error getting tenant: %v", err)
+		return
+	}
+
+	reachable := make(map[string]bool)
+	for _, r := range probe.IPs {
+		reachable[r.IP] = r.Reachable
+	}
+
+	instances, err := client.ctl.ds.GetAllInstancesFromTenant(probe.TenantUUID)
+	if err != nil {
+		glog.Warningf("Error getting instances from datastore: %v", err)
+		return
+	}
+
+	probeTime := time.Now()
+	for _, i := range instances {
+		if i.CNCI {
+			continue
+		}
+
+		// only the instances on the subnets served by the CNCI
+		// were probed.
+		cnci, err := tenant.CNCIctrl.GetSubnetCNCI(i.Subnet)
+		if err != nil || cnci.ID != probe.ConcentratorUUID {
+			continue
+		}
+
+		i.StateLock.Lock()
+		lost := i.State == payloads.Running && !i.ProbeTime.IsZero() &&
+			i.Reachable && !reachable[i.IPAddress]
+		i.Reachable = reachable[i.IPAddress]
+		i.ProbeTime = probeTime
+		i.StateLock.Unlock()
+
+		if lost {
+			msg := fmt.Sprintf("Instance %s is running but no longer reachable at %s",
+				i.ID, i.IPAddress)
+			if err := client.ctl.ds.LogError(ctx, i.TenantID, msg); err != nil {
+				glog.Warningf("Error logging event: %v", err)
+			}
+		}
+	}
 }
 
 func (client *ssntpClient) EventNotify(event ssntp.Event, frame *ssntp.Frame) {
+	ctx := context.Background()
+
 	payload := frame.Payload
 
 	glog.Info("EVENT ", event, " for ", client.name)
@@ -336,13 +624,37 @@ func (client *ssntpClient) EventNotify(event ssntp.Event, frame *ssntp.Frame) {
 		client.instanceDeleted(payload)
 
 	case ssntp.InstanceStopped:
-		client.instanceStopped(payload)
+		client.instanceStopped(ctx, payload)
+
+	case ssntp.InstancesPreempted:
+		client.instancesPreempted(ctx, payload)
+
+	case ssntp.DiskUsageAlert:
+		client.diskUsageAlert(ctx, payload)
+
+	case ssntp.WatchdogFired:
+		client.watchdogFired(ctx, payload)
+
+	case ssntp.ConsoleOutput:
+		client.consoleOutput(ctx, payload)
+
+	case ssntp.GuestAgentResult:
+		client.guestAgentResult(payload)
+
+	case ssntp.PacketCaptureResult:
+		client.packetCaptureResult(frame)
+
+	case ssntp.MigrationStatus:
+		client.migrationStatus(ctx, payload)
+
+	case ssntp.InstanceReachability:
+		client.instanceReachability(ctx, payload)
 
 	case ssntp.ConcentratorInstanceAdded:
-		client.concentratorInstanceAdded(payload)
+		client.concentratorInstanceAdded(ctx, payload)
 
 	case ssntp.TraceReport:
-		client.traceReport(payload)
+		client.traceReport(ctx, payload)
 
 	case ssntp.NodeConnected:
 		client.nodeConnected(payload)
@@ -351,23 +663,46 @@ func (client *ssntpClient) EventNotify(event ssntp.Event, frame *ssntp.Frame) {
 		client.nodeDisconnected(payload)
 
 	case ssntp.PublicIPAssigned:
-		client.assignEvent(payload)
+		client.assignEvent(ctx, payload)
 
 	case ssntp.PublicIPUnassigned:
-		client.unassignEvent(payload)
+		client.unassignEvent(ctx, payload)
 
 	}
 }
 
-func (client *ssntpClient) startFailure(payload []byte) {
+func (client *ssntpClient) startFailure(ctx context.Context, payload []byte) {
 	var failure payloads.ErrorStartFailure
 	err := yaml.Unmarshal(payload, &failure)
 	if err != nil {
 		glog.Warningf("Error unmarshalling StartFailure: %v", err)
 		return
 	}
+
+	if d := failure.Diagnostics; d != nil {
+		glog.Warningf("Instance %s failed to start on %s: %s\ncommand line: %v\nstderr: %s\nlog:\n%s",
+			failure.InstanceUUID, failure.NodeUUID, d.Error, d.CommandLine, d.Stderr,
+			strings.Join(d.Log, "\n"))
+	}
+
+	// the instance of a failed live migration keeps running on its
+	// source node.
+	if client.ctl.instanceMigrating(failure.InstanceUUID) {
+		client.ctl.endMigration(ctx, failure.InstanceUUID, errors.New(failure.Reason.String()))
+		return
+	}
+
+	// instances the cluster has no room for are launched in a federation
+	// peer instead, when one can run their workload.
+	if (failure.Reason == payloads.FullCloud || failure.Reason == payloads.NoComputeNodes) &&
+		!failure.Restart && client.ctl.burstInstance(ctx, failure.InstanceUUID) {
+		client.deleteEphemeralStorage(ctx, failure.InstanceUUID)
+		client.ctl.completeResourceOperations(failure.InstanceUUID, types.InstanceCreate, nil)
+		return
+	}
+
 	if failure.Reason.IsFatal() && !failure.Restart {
-		client.deleteEphemeralStorage(failure.InstanceUUID)
+		client.deleteEphemeralStorage(ctx, failure.InstanceUUID)
 		err = client.releaseResources(failure.InstanceUUID)
 		if err != nil {
 			glog.Warningf("Error when releasing resources for start failed instance: %v", err)
@@ -383,13 +718,22 @@ func (client *ssntpClient) startFailure(payload []byte) {
 	cnci := i.CNCI
 	tenantID := i.TenantID
 
-	err = client.ctl.ds.StartFailure(failure.InstanceUUID, failure.Reason, failure.Restart, failure.NodeUUID)
+	// a failed restart leaves the instance in place, so it can be
+	// restarted again once the node has been fixed.
+	if failure.Restart {
+		client.restartFailure(ctx, i, failure)
+	}
+
+	err = client.ctl.ds.StartFailure(ctx, failure.InstanceUUID, failure.Reason, failure.Restart, failure.NodeUUID)
 	if err != nil {
 		glog.Warningf("Error adding StartFailure to datastore: %v", err)
 	}
 
+	client.ctl.completeResourceOperations(failure.InstanceUUID, types.InstanceCreate,
+		errors.New(failure.Reason.String()))
+
 	if cnci {
-		tenant, err := client.ctl.ds.GetTenant(tenantID)
+		tenant, err := client.ctl.ds.GetTenant(ctx, tenantID)
 		if err != nil {
 			glog.Warningf("Unable to send start failure event: Error getting tenant %v", err)
 			return
@@ -402,20 +746,69 @@ func (client *ssntpClient) startFailure(payload []byte) {
 	}
 }
 
-func (client *ssntpClient) attachVolumeFailure(payload []byte) {
+func (client *ssntpClient) restartFailure(ctx context.Context, i *types.Instance, failure payloads.ErrorStartFailure) {
+	w, err := client.ctl.instanceWorkload(i)
+	if err != nil {
+		glog.Warningf("Unable to record failed restart: Error getting workload %v", err)
+		return
+	}
+
+	t, err := client.ctl.ds.GetTenant(ctx, i.TenantID)
+	if err != nil {
+		glog.Warningf("Unable to record failed restart: Error getting tenant %v", err)
+		return
+	}
+
+	// secrets and volume keys are not recorded, they are added back
+	// when the restart is replayed.
+	y, err := client.ctl.restartPayload(ctx, i, &w, t, false)
+	if err != nil {
+		glog.Warningf("Unable to record failed restart: %v", err)
+		return
+	}
+
+	client.ctl.recordFailedCommand(ctx, ssntp.START, y, i.ID, failure.NodeUUID, failure.Reason.String())
+}
+
+func (client *ssntpClient) attachVolumeFailure(ctx context.Context, payload []byte) {
 	var failure payloads.ErrorAttachVolumeFailure
 	err := yaml.Unmarshal(payload, &failure)
 	if err != nil {
 		glog.Warningf("Error unmarshalling AttachVolumeFailure: %v", err)
 		return
 	}
-	err = client.ctl.ds.AttachVolumeFailure(failure.InstanceUUID, failure.VolumeUUID, failure.Reason)
+
+	// volume keys are not recorded, they are added back when the attach
+	// is replayed.
+	pool := client.ctl.volumePool(failure.VolumeUUID)
+	y, err := attachVolumePayload(failure.VolumeUUID, pool, "", failure.InstanceUUID, failure.NodeUUID)
+	if err != nil {
+		glog.Warningf("Unable to record failed volume attach: %v", err)
+	} else {
+		client.ctl.recordFailedCommand(ctx, ssntp.AttachVolume, y, failure.InstanceUUID, failure.NodeUUID, failure.Reason.String())
+	}
+
+	err = client.ctl.ds.AttachVolumeFailure(ctx, failure.InstanceUUID, failure.VolumeUUID, failure.Reason)
 	if err != nil {
 		glog.Warningf("Error handling AttachVolumeFailure in datastore: %v", err)
 	}
 }
 
-func (client *ssntpClient) assignError(payload []byte) {
+func (client *ssntpClient) deleteFailure(ctx context.Context, payload []byte) {
+	var failure payloads.ErrorDeleteFailure
+	err := yaml.Unmarshal(payload, &failure)
+	if err != nil {
+		glog.Warningf("Error unmarshalling DeleteFailure: %v", err)
+		return
+	}
+
+	err = client.ctl.ds.DeleteFailure(ctx, failure.InstanceUUID, failure.Reason, failure.NodeUUID)
+	if err != nil {
+		glog.Warningf("Error handling DeleteFailure in datastore: %v", err)
+	}
+}
+
+func (client *ssntpClient) assignError(ctx context.Context, payload []byte) {
 	var failure payloads.ErrorPublicIPFailure
 	err := yaml.Unmarshal(payload, &failure)
 	if err != nil {
@@ -423,21 +816,26 @@ func (client *ssntpClient) assignError(payload []byte) {
 		return
 	}
 
-	err = client.ctl.ds.UnMapExternalIP(failure.PublicIP)
+	err = client.ctl.ds.UnMapExternalIP(ctx, failure.PublicIP)
 	if err != nil {
 		glog.Warningf("Error unmapping external IP: %v", err)
 	}
 
 	client.ctl.qs.Release(failure.TenantUUID, payloads.RequestedResource{Type: payloads.ExternalIP, Value: 1})
 
+	err = client.ctl.ds.SetInstanceFailure(ctx, failure.InstanceUUID, types.NewPublicIPFailure(types.FailureAssignPublicIP, failure.Reason))
+	if err != nil {
+		glog.Warningf("Error recording instance failure: %v", err)
+	}
+
 	msg := fmt.Sprintf("Failed to map %s to %s: %s", failure.PublicIP, failure.InstanceUUID, failure.Reason.String())
-	err = client.ctl.ds.LogError(failure.TenantUUID, msg)
+	err = client.ctl.ds.LogError(ctx, failure.TenantUUID, msg)
 	if err != nil {
 		glog.Warningf("Error logging error: %v", err)
 	}
 }
 
-func (client *ssntpClient) unassignError(payload []byte) {
+func (client *ssntpClient) unassignError(ctx context.Context, payload []byte) {
 	var failure payloads.ErrorPublicIPFailure
 	err := yaml.Unmarshal(payload, &failure)
 	if err != nil {
@@ -445,15 +843,22 @@ func (client *ssntpClient) unassignError(payload []byte) {
 		return
 	}
 
-	// we can't unmap the IP - all we can do is log.
+	// we can't unmap the IP - all we can do is record the failure and log.
+	err = client.ctl.ds.SetInstanceFailure(ctx, failure.InstanceUUID, types.NewPublicIPFailure(types.FailureUnassignPublicIP, failure.Reason))
+	if err != nil {
+		glog.Warningf("Error recording instance failure: %v", err)
+	}
+
 	msg := fmt.Sprintf("Failed to unmap %s from %s: %s", failure.PublicIP, failure.InstanceUUID, failure.Reason.String())
-	err = client.ctl.ds.LogError(failure.TenantUUID, msg)
+	err = client.ctl.ds.LogError(ctx, failure.TenantUUID, msg)
 	if err != nil {
 		glog.Warningf("Error logging error: %v", err)
 	}
 }
 
 func (client *ssntpClient) ErrorNotify(err ssntp.Error, frame *ssntp.Frame) {
+	ctx := context.Background()
+
 	payload := frame.Payload
 
 	glog.Info("ERROR (", err, ") for ", client.name)
@@ -461,30 +866,56 @@ func (client *ssntpClient) ErrorNotify(err ssntp.Error, frame *ssntp.Frame) {
 
 	switch err {
 	case ssntp.StartFailure:
-		client.startFailure(payload)
+		client.startFailure(ctx, payload)
+
+	case ssntp.DeleteFailure:
+		client.deleteFailure(ctx, payload)
 
 	case ssntp.AttachVolumeFailure:
-		client.attachVolumeFailure(payload)
+		client.attachVolumeFailure(ctx, payload)
 
 	case ssntp.AssignPublicIPFailure:
-		client.assignError(payload)
+		client.assignError(ctx, payload)
 
 	case ssntp.UnassignPublicIPFailure:
-		client.unassignError(payload)
+		client.unassignError(ctx, payload)
 
 	}
 }
 
 func newSSNTPClient(ctl *controller, config *ssntp.Config) (controllerClient, error) {
-	client := &ssntpClient{name: "ciao Controller", ctl: ctl}
+	client := &ssntpClient{
+		name:    "ciao Controller",
+		ctl:     ctl,
+		streams: ssntp.NewStreamAssembler(maxStreamedPayloads),
+	}
 
 	err := client.ssntp.Dial(config, client)
 	return client, err
 }
 
+// nextSequence returns the sequence of the next instance command, which
+// launchers use to ignore the commands they receive out of order.
+// Sequences are derived from the time so that they keep increasing across
+// restarts of the controller.
+func (client *ssntpClient) nextSequence() uint64 {
+	for {
+		last := atomic.LoadUint64(&client.sequence)
+		next := uint64(time.Now().UnixNano())
+		if next <= last {
+			next = last + 1
+		}
+
+		if atomic.CompareAndSwapUint64(&client.sequence, last, next) {
+			return next
+		}
+	}
+}
+
 func (client *ssntpClient) StartTracedWorkload(config string, startTime time.Time, label string) error {
-	glog.V(1).Info("START TRACED config:")
-	glog.V(1).Info(config)
+	// The START payload is not logged as it contains the decrypted
+	// secrets and the volume keys of the instance.
+	glog.V(1).Info("START TRACED ", label)
 
 	traceConfig := &ssntp.TraceConfig{
 		PathTrace: true,
@@ -498,15 +929,16 @@ func (client *ssntpClient) StartTracedWorkload(config string, startTime time.Tim
 }
 
 func (client *ssntpClient) StartWorkload(config string) error {
-	glog.V(1).Info("START config:")
-	glog.V(1).Info(config)
+	// The START payload is not logged as it contains the decrypted
+	// secrets and the volume keys of the instance.
+	glog.V(1).Info("START")
 
 	_, err := client.ssntp.SendCommand(ssntp.START, []byte(config))
 
 	return err
 }
 
-func (client *ssntpClient) deleteInstance(payload *payloads.Delete, instanceID string, nodeID string) error {
+func (client *ssntpClient) deleteInstance(ctx context.Context, payload *payloads.Delete, instanceID string, nodeID string) error {
 	y, err := yaml.Marshal(*payload)
 	if err != nil {
 		return err
@@ -515,12 +947,12 @@ func (client *ssntpClient) deleteInstance(payload *payloads.Delete, instanceID s
 	glog.Info("DELETE instance_id: ", instanceID, "node_id ", nodeID)
 	glog.V(1).Info(string(y))
 
-	_, err = client.ssntp.SendCommand(ssntp.DELETE, y)
-
-	return err
+	return client.sendReplayableCommand(ctx, ssntp.DELETE, y, instanceID, nodeID)
 }
 
 func (client *ssntpClient) DeleteInstance(instanceID string, nodeID string) error {
+	ctx := context.Background()
+
 	if nodeID == "" {
 		// This instance is not running and not assigned to a node.  We
 		// can just remove its details from controller's db and delete
@@ -534,38 +966,302 @@ func (client *ssntpClient) DeleteInstance(instanceID string, nodeID string) erro
 		Delete: payloads.StopCmd{
 			InstanceUUID:      instanceID,
 			WorkloadAgentUUID: nodeID,
+			Sequence:          client.nextSequence(),
 		},
 	}
 
-	return client.deleteInstance(&payload, instanceID, nodeID)
+	return client.deleteInstance(ctx, &payload, instanceID, nodeID)
 }
 
 func (client *ssntpClient) StopInstance(instanceID string, nodeID string) error {
+	ctx := context.Background()
+
 	payload := payloads.Delete{
 		Delete: payloads.StopCmd{
 			InstanceUUID:      instanceID,
 			WorkloadAgentUUID: nodeID,
 			Stop:              true,
+			Sequence:          client.nextSequence(),
+		},
+	}
+
+	return client.deleteInstance(ctx, &payload, instanceID, nodeID)
+}
+
+func (client *ssntpClient) sendPauseCommand(ctx context.Context, cmd ssntp.Command, payload interface{}, instanceID string, nodeID string) error {
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Info(cmd, " instance_id: ", instanceID, "node_id ", nodeID)
+	glog.V(1).Info(string(y))
+
+	return client.sendReplayableCommand(ctx, cmd, y, instanceID, nodeID)
+}
+
+func (client *ssntpClient) PauseInstance(instanceID string, nodeID string) error {
+	ctx := context.Background()
+
+	payload := payloads.Pause{
+		Pause: payloads.PauseCmd{
+			InstanceUUID:      instanceID,
+			WorkloadAgentUUID: nodeID,
+			Sequence:          client.nextSequence(),
+		},
+	}
+
+	return client.sendPauseCommand(ctx, ssntp.PAUSE, &payload, instanceID, nodeID)
+}
+
+func (client *ssntpClient) UnpauseInstance(instanceID string, nodeID string) error {
+	ctx := context.Background()
+
+	payload := payloads.Unpause{
+		Unpause: payloads.PauseCmd{
+			InstanceUUID:      instanceID,
+			WorkloadAgentUUID: nodeID,
+			Sequence:          client.nextSequence(),
+		},
+	}
+
+	return client.sendPauseCommand(ctx, ssntp.UNPAUSE, &payload, instanceID, nodeID)
+}
+
+// sendRescueCommand sends a RESCUE or UNRESCUE command.  They are not
+// recorded when they fail as the payload of RESCUE holds the key of the
+// rescue volume.
+func (client *ssntpClient) sendRescueCommand(cmd ssntp.Command, payload interface{}, instanceID string, nodeID string) error {
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Info(cmd, " instance_id: ", instanceID, "node_id ", nodeID)
+
+	_, err = client.ssntp.SendCommand(cmd, y)
+
+	return err
+}
+
+func (client *ssntpClient) RescueInstance(instanceID string, nodeID string, volume payloads.StorageResource) error {
+	payload := payloads.Rescue{
+		Rescue: payloads.RescueCmd{
+			InstanceUUID:      instanceID,
+			WorkloadAgentUUID: nodeID,
+			Volume:            volume,
+			Sequence:          client.nextSequence(),
+		},
+	}
+
+	return client.sendRescueCommand(ssntp.RESCUE, &payload, instanceID, nodeID)
+}
+
+func (client *ssntpClient) UnrescueInstance(instanceID string, nodeID string) error {
+	payload := payloads.Unrescue{
+		Unrescue: payloads.UnrescueCmd{
+			InstanceUUID:      instanceID,
+			WorkloadAgentUUID: nodeID,
+			Sequence:          client.nextSequence(),
 		},
 	}
 
-	return client.deleteInstance(&payload, instanceID, nodeID)
+	return client.sendRescueCommand(ssntp.UNRESCUE, &payload, instanceID, nodeID)
+}
+
+// RebootInstance sends a REBOOT command.  Reboot commands are not recorded
+// when they fail as rebooting an instance long after it was requested would
+// be unexpected.
+func (client *ssntpClient) RebootInstance(instanceID string, nodeID string, hard bool) error {
+	payload := payloads.Reboot{
+		Reboot: payloads.RebootCmd{
+			InstanceUUID:      instanceID,
+			WorkloadAgentUUID: nodeID,
+			Type:              payloads.RebootSoft,
+			Sequence:          client.nextSequence(),
+		},
+	}
+
+	if hard {
+		payload.Reboot.Type = payloads.RebootHard
+	}
+
+	y, err := yaml.Marshal(&payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Info(ssntp.REBOOT, " ", payload.Reboot.Type, " instance_id: ", instanceID, "node_id ", nodeID)
+
+	_, err = client.ssntp.SendCommand(ssntp.REBOOT, y)
+
+	return err
+}
+
+// ConsoleCommand sends a CONSOLE command.  Console commands are not
+// recorded when they fail as replaying them once the session is gone
+// makes no sense.
+func (client *ssntpClient) ConsoleCommand(cmd payloads.ConsoleCmd) error {
+	payload := payloads.Console{
+		Console: cmd,
+	}
+
+	y, err := yaml.Marshal(&payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Info(ssntp.CONSOLE, " ", cmd.Action, " session_id: ", cmd.SessionUUID,
+		" instance_id: ", cmd.InstanceUUID, " node_id: ", cmd.WorkloadAgentUUID)
+
+	_, err = client.ssntp.SendCommand(ssntp.CONSOLE, y)
+
+	return err
+}
+
+// GuestAgentCommand sends a GUESTAGENT command.  Like console commands,
+// guest agent commands are not recorded when they fail as the caller waits
+// for their result.
+func (client *ssntpClient) GuestAgentCommand(cmd payloads.GuestAgentCmd) error {
+	payload := payloads.GuestAgent{
+		GuestAgent: cmd,
+	}
+
+	y, err := yaml.Marshal(&payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Info(ssntp.GUESTAGENT, " ", cmd.Operation, " request_id: ", cmd.RequestUUID,
+		" instance_id: ", cmd.InstanceUUID, " node_id: ", cmd.WorkloadAgentUUID)
+
+	_, err = client.ssntp.SendCommand(ssntp.GUESTAGENT, y)
+
+	return err
+}
+
+// PacketCaptureCommand sends a CAPTURE command.  Like guest agent
+// commands, capture commands are not recorded when they fail as the caller
+// waits for their result.
+func (client *ssntpClient) PacketCaptureCommand(cmd payloads.PacketCaptureCmd) error {
+	payload := payloads.PacketCapture{
+		PacketCapture: cmd,
+	}
+
+	y, err := yaml.Marshal(&payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Info(ssntp.CAPTURE, " capture_id: ", cmd.CaptureUUID, " instance_id: ",
+		cmd.InstanceUUID, " node_id: ", cmd.WorkloadAgentUUID)
+
+	_, err = client.ssntp.SendCommand(ssntp.CAPTURE, y)
+
+	return err
 }
 
 func (client *ssntpClient) RestartInstance(i *types.Instance, w *types.Workload,
 	t *types.Tenant) error {
-	var cnci *types.Instance
+	ctx := context.Background()
 
-	err := client.ctl.ds.InstanceRestarting(i.ID)
+	err := client.ctl.ds.InstanceRestarting(ctx, i.ID)
 	if err != nil {
 		return errors.Wrapf(err, "Unable to update instance state before restarting")
 	}
 
+	y, err := client.ctl.restartPayload(ctx, i, w, t, true)
+	if err != nil {
+		return err
+	}
+
+	glog.Info("RESTART instance: ", i.ID)
+
+	_, err = client.ssntp.SendCommand(ssntp.START, y)
+	if err != nil {
+		// secrets and volume keys are not recorded, they are added
+		// back when the restart is replayed.
+		recorded, perr := client.ctl.restartPayload(ctx, i, w, t, false)
+		if perr != nil {
+			glog.Warningf("Unable to record failed restart: %v", perr)
+			return err
+		}
+		client.ctl.recordFailedCommand(ctx, ssntp.START, recorded, i.ID, i.NodeID, err.Error())
+	}
+
+	return err
+}
+
+// MigrateInstance sends the START command placing the instance live
+// migrated from source on another node, nodeID if it is set.  The command is
+// not recorded when it fails as the migration fails with it.
+func (client *ssntpClient) MigrateInstance(i *types.Instance, w *types.Workload,
+	t *types.Tenant, source string, nodeID string) error {
+	ctx := context.Background()
+
+	y, err := client.ctl.startPayload(ctx, i, w, t, true, source, nodeID)
+	if err != nil {
+		return err
+	}
+
+	glog.Info("MIGRATE instance: ", i.ID, " from: ", source)
+
+	_, err = client.ssntp.SendCommand(ssntp.START, y)
+
+	return err
+}
+
+// MigrateCommand sends a MIGRATE command to the node an instance is live
+// migrated from.
+func (client *ssntpClient) MigrateCommand(cmd payloads.MigrateCmd) error {
+	payload := payloads.Migrate{
+		Migrate: cmd,
+	}
+
+	y, err := yaml.Marshal(&payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Info(ssntp.MIGRATE, " instance_id: ", cmd.InstanceUUID, " node_id: ",
+		cmd.WorkloadAgentUUID, " target: ", cmd.TargetAgentUUID)
+
+	_, err = client.ssntp.SendCommand(ssntp.MIGRATE, y)
+
+	return err
+}
+
+// restartPayload creates the payload of the START command used to restart
+// an instance.  If withSecrets is set the secrets of the instance are
+// injected in its meta-data and the keys of its encrypted volumes are added
+// to its storage.
+func (c *controller) restartPayload(ctx context.Context, i *types.Instance, w *types.Workload,
+	t *types.Tenant, withSecrets bool) ([]byte, error) {
+	return c.startPayload(ctx, i, w, t, withSecrets, "", "")
+}
+
+// startPayload creates the payload of the START command of an existing
+// instance.  The instance is restarted unless migrationSource is set, in
+// which case it is live migrated from the migrationSource node to another
+// node, nodeID if it is set.
+func (c *controller) startPayload(ctx context.Context, i *types.Instance, w *types.Workload,
+	t *types.Tenant, withSecrets bool, migrationSource string, nodeID string) ([]byte, error) {
+	var cnci *types.Instance
+	var secrets map[string]string
+	var err error
+
+	if withSecrets {
+		secrets, err = c.instanceSecrets(ctx, i.TenantID, w)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if !i.CNCI {
 		// get the CNCI for this instance
 		cnci, err = t.CNCIctrl.GetInstanceCNCI(i.ID)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
@@ -575,11 +1271,13 @@ func (client *ssntpClient) RestartInstance(i *types.Instance, w *types.Workload,
 	}
 
 	metaData := userData{
-		UUID:     i.ID,
-		Hostname: hostname,
+		UUID:        i.ID,
+		Hostname:    hostname,
+		Preemptible: i.Preemptible,
+		Secrets:     secrets,
 	}
 
-	attachments := client.ctl.ds.GetStorageAttachments(i.ID)
+	attachments := c.ds.GetStorageAttachments(i.ID)
 
 	restartCmd := payloads.StartCmd{
 		TenantUUID:          i.TenantID,
@@ -603,6 +1301,18 @@ func (client *ssntpClient) RestartInstance(i *types.Instance, w *types.Workload,
 		restartCmd.Networking.PrivateIP = i.IPAddress
 	}
 
+	if i.Preemptible {
+		restartCmd.Requirements.Priority = payloads.LowPriority
+	}
+
+	if migrationSource != "" {
+		restartCmd.Restart = false
+		restartCmd.MigrationSource = migrationSource
+		if nodeID != "" {
+			restartCmd.Requirements.NodeID = nodeID
+		}
+	}
+
 	if w.VMType == payloads.Docker {
 		restartCmd.DockerImage = w.ImageName
 	}
@@ -612,6 +1322,23 @@ func (client *ssntpClient) RestartInstance(i *types.Instance, w *types.Workload,
 		vol.ID = attachments[k].BlockID
 		vol.Bootable = attachments[k].Boot
 		vol.Ephemeral = attachments[k].Ephemeral
+		vol.Pool = c.volumePool(vol.ID)
+
+		if withSecrets {
+			vol.Key, err = c.volumeKey(ctx, vol.ID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// rescued instances keep booting from their rescue volume.
+	if i.Rescued && i.RescueVolume != "" {
+		rescue, err := c.rescueStorage(ctx, i.RescueVolume, withSecrets)
+		if err != nil {
+			return nil, err
+		}
+		restartCmd.Storage = append(restartCmd.Storage, rescue)
 	}
 
 	payload := payloads.Start{
@@ -620,12 +1347,12 @@ func (client *ssntpClient) RestartInstance(i *types.Instance, w *types.Workload,
 
 	y, err := yaml.Marshal(payload)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	b, err := json.Marshal(&metaData)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var buf bytes.Buffer
@@ -637,15 +1364,12 @@ func (client *ssntpClient) RestartInstance(i *types.Instance, w *types.Workload,
 	_, _ = buf.Write(b)
 	_, _ = buf.WriteString("\n...\n")
 
-	glog.Info("RESTART instance: ", i.ID)
-	glog.V(1).Info(buf.String())
-
-	_, err = client.ssntp.SendCommand(ssntp.START, buf.Bytes())
-
-	return err
+	return buf.Bytes(), nil
 }
 
 func (client *ssntpClient) EvacuateNode(nodeID string) error {
+	ctx := context.Background()
+
 	evacuateCmd := payloads.EvacuateCmd{
 		WorkloadAgentUUID: nodeID,
 	}
@@ -662,12 +1386,12 @@ func (client *ssntpClient) EvacuateNode(nodeID string) error {
 	glog.Info("EVACUATE node: ", nodeID)
 	glog.V(1).Info(string(y))
 
-	_, err = client.ssntp.SendCommand(ssntp.EVACUATE, y)
-
-	return err
+	return client.sendReplayableCommand(ctx, ssntp.EVACUATE, y, "", nodeID)
 }
 
 func (client *ssntpClient) RestoreNode(nodeID string) error {
+	ctx := context.Background()
+
 	restoreCmd := payloads.RestoreCmd{
 		WorkloadAgentUUID: nodeID,
 	}
@@ -684,33 +1408,111 @@ func (client *ssntpClient) RestoreNode(nodeID string) error {
 	glog.Info("Restore node: ", nodeID)
 	glog.V(1).Info(string(y))
 
-	_, err = client.ssntp.SendCommand(ssntp.Restore, y)
+	return client.sendReplayableCommand(ctx, ssntp.Restore, y, "", nodeID)
+}
+
+func (client *ssntpClient) SendNodePolicies(policies []types.NodePolicy) error {
+	payload := payloads.NodePolicies{
+		Policies: []payloads.NodePolicy{},
+	}
+
+	for _, policy := range policies {
+		payload.Policies = append(payload.Policies, payloads.NodePolicy{
+			NodeUUID:            policy.NodeID,
+			Weight:              policy.Weight,
+			MaxInstances:        policy.MaxInstances,
+			AllowCNCIColocation: policy.AllowCNCIColocation,
+			DedicatedTenant:     policy.DedicatedTenant,
+		})
+	}
+
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("NodePolicy for %d nodes", len(policies))
+	glog.V(1).Info(string(y))
+
+	_, err = client.ssntp.SendCommand(ssntp.NodePolicy, y)
 
 	return err
 }
 
-func (client *ssntpClient) attachVolume(volID string, instanceID string, nodeID string) error {
+// SendConfiguration sends a cluster configuration to the scheduler, which
+// hands it to the SSNTP clients connecting to it from then on.
+func (client *ssntpClient) SendConfiguration(payload []byte) error {
+	glog.Info("CONFIGURE")
+	glog.V(1).Info(string(payload))
+
+	_, err := client.ssntp.SendCommand(ssntp.CONFIGURE, payload)
+
+	return err
+}
+
+func attachVolumePayload(volID string, pool string, key string, instanceID string, nodeID string) ([]byte, error) {
 	payload := payloads.AttachVolume{
 		Attach: payloads.VolumeCmd{
 			InstanceUUID:      instanceID,
 			VolumeUUID:        volID,
+			Pool:              pool,
+			Key:               key,
 			WorkloadAgentUUID: nodeID,
 		},
 	}
 
-	y, err := yaml.Marshal(payload)
+	return yaml.Marshal(payload)
+}
+
+func (client *ssntpClient) attachVolume(volID string, instanceID string, nodeID string) error {
+	ctx := context.Background()
+
+	key, err := client.ctl.volumeKey(ctx, volID)
 	if err != nil {
 		return err
 	}
 
+	y, err := attachVolumePayload(volID, client.ctl.volumePool(volID), key, instanceID, nodeID)
+	if err != nil {
+		return err
+	}
+
+	// the payload is not logged, it carries the passphrase of encrypted
+	// volumes.
 	glog.Infof("AttachVolume %s to %s\n", volID, instanceID)
-	glog.V(1).Info(string(y))
 
 	_, err = client.ssntp.SendCommand(ssntp.AttachVolume, y)
 
 	return err
 }
 
+// sendReplayableCommand sends a command whose caller does not undo its
+// changes if the command cannot be sent. The command is recorded when
+// sending it fails, so that it can be replayed later.
+func (client *ssntpClient) sendReplayableCommand(ctx context.Context, cmd ssntp.Command, payload []byte, instanceID string, nodeID string) error {
+	_, err := client.ssntp.SendCommand(cmd, payload)
+	if err != nil {
+		client.ctl.recordFailedCommand(ctx, cmd, payload, instanceID, nodeID, err.Error())
+	}
+
+	return err
+}
+
+func (client *ssntpClient) replayCommand(cmd ssntp.Command, payload []byte) error {
+	// the payload is not logged, replayed START payloads carry the
+	// secrets and the volume keys of the instance.
+	glog.Info("Replaying ", cmd)
+
+	payload, err := resequencePayload(cmd, payload, client.nextSequence())
+	if err != nil {
+		return err
+	}
+
+	_, err = client.ssntp.SendCommand(cmd, payload)
+
+	return err
+}
+
 func (client *ssntpClient) ssntpClient() *ssntp.Client {
 	return &client.ssntp
 }
@@ -66,7 +66,11 @@ func (client *ssntpClientWrapper) ErrorNotify(err ssntp.Error, frame *ssntp.Fram
 }
 
 func newWrappedSSNTPClient(ctl *controller, config *ssntp.Config) (*ssntpClientWrapper, error) {
-	realClient := &ssntpClient{name: "ciao Controller", ctl: ctl}
+	realClient := &ssntpClient{
+		name:    "ciao Controller",
+		ctl:     ctl,
+		streams: ssntp.NewStreamAssembler(maxStreamedPayloads),
+	}
 	client := &ssntpClientWrapper{name: "ciao Controller", realClient: realClient}
 	client.openClientChans()
 
@@ -91,11 +95,52 @@ func (client *ssntpClientWrapper) StopInstance(instanceID string, nodeID string)
 	return client.realClient.StopInstance(instanceID, nodeID)
 }
 
+func (client *ssntpClientWrapper) PauseInstance(instanceID string, nodeID string) error {
+	return client.realClient.PauseInstance(instanceID, nodeID)
+}
+
+func (client *ssntpClientWrapper) UnpauseInstance(instanceID string, nodeID string) error {
+	return client.realClient.UnpauseInstance(instanceID, nodeID)
+}
+
+func (client *ssntpClientWrapper) RescueInstance(instanceID string, nodeID string, volume payloads.StorageResource) error {
+	return client.realClient.RescueInstance(instanceID, nodeID, volume)
+}
+
+func (client *ssntpClientWrapper) UnrescueInstance(instanceID string, nodeID string) error {
+	return client.realClient.UnrescueInstance(instanceID, nodeID)
+}
+
+func (client *ssntpClientWrapper) RebootInstance(instanceID string, nodeID string, hard bool) error {
+	return client.realClient.RebootInstance(instanceID, nodeID, hard)
+}
+
+func (client *ssntpClientWrapper) ConsoleCommand(cmd payloads.ConsoleCmd) error {
+	return client.realClient.ConsoleCommand(cmd)
+}
+
+func (client *ssntpClientWrapper) GuestAgentCommand(cmd payloads.GuestAgentCmd) error {
+	return client.realClient.GuestAgentCommand(cmd)
+}
+
+func (client *ssntpClientWrapper) PacketCaptureCommand(cmd payloads.PacketCaptureCmd) error {
+	return client.realClient.PacketCaptureCommand(cmd)
+}
+
 func (client *ssntpClientWrapper) RestartInstance(i *types.Instance, w *types.Workload,
 	t *types.Tenant) error {
 	return client.realClient.RestartInstance(i, w, t)
 }
 
+func (client *ssntpClientWrapper) MigrateInstance(i *types.Instance, w *types.Workload,
+	t *types.Tenant, source string, nodeID string) error {
+	return client.realClient.MigrateInstance(i, w, t, source, nodeID)
+}
+
+func (client *ssntpClientWrapper) MigrateCommand(cmd payloads.MigrateCmd) error {
+	return client.realClient.MigrateCommand(cmd)
+}
+
 func (client *ssntpClientWrapper) EvacuateNode(nodeID string) error {
 	return client.realClient.EvacuateNode(nodeID)
 }
@@ -104,6 +149,14 @@ func (client *ssntpClientWrapper) RestoreNode(nodeID string) error {
 	return client.realClient.RestoreNode(nodeID)
 }
 
+func (client *ssntpClientWrapper) SendNodePolicies(policies []types.NodePolicy) error {
+	return client.realClient.SendNodePolicies(policies)
+}
+
+func (client *ssntpClientWrapper) SendConfiguration(payload []byte) error {
+	return client.realClient.SendConfiguration(payload)
+}
+
 func (client *ssntpClientWrapper) mapExternalIP(t types.Tenant, m types.MappedIP) error {
 	return client.realClient.mapExternalIP(t, m)
 }
@@ -116,6 +169,10 @@ func (client *ssntpClientWrapper) attachVolume(volID string, instanceID string,
 	return client.realClient.attachVolume(volID, instanceID, nodeID)
 }
 
+func (client *ssntpClientWrapper) replayCommand(cmd ssntp.Command, payload []byte) error {
+	return client.realClient.replayCommand(cmd, payload)
+}
+
 func (client *ssntpClientWrapper) ssntpClient() *ssntp.Client {
 	return client.realClient.ssntpClient()
 }
@@ -125,6 +182,10 @@ func (client *ssntpClientWrapper) Disconnect() {
 	client.closeClientChans()
 }
 
+func (client *ssntpClientWrapper) Connected() bool {
+	return client.realClient.Connected()
+}
+
 func (client *ssntpClientWrapper) openClientChans() {
 	client.CmdChansLock.Lock()
 	client.CmdChans = make(map[ssntp.Command]chan struct{})
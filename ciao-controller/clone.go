@@ -0,0 +1,278 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// bootAttachment returns the attachment of the boot volume of an instance.
+func (c *controller) bootAttachment(instanceID string) (types.StorageAttachment, bool) {
+	for _, a := range c.ds.GetStorageAttachments(instanceID) {
+		if a.Boot {
+			return a, true
+		}
+	}
+
+	return types.StorageAttachment{}, false
+}
+
+// CloneServer creates copies of a stopped instance, booting from
+// copy-on-write clones of a snapshot of its boot volume.  The copies are
+// instances of the same workload with a new identity, i.e., new IDs,
+// addresses and host names.  Once they are started, the clones are
+// flattened in the background and the snapshot deleted.
+func (c *controller) CloneServer(ctx context.Context, tenant string, ID string, req api.CloneServerRequest) (api.Servers, error) {
+	var servers api.Servers
+
+	if req.Instances < 0 {
+		return servers, types.ErrBadRequest
+	}
+
+	if req.Instances == 0 {
+		req.Instances = 1
+	}
+
+	if req.Name != "" && !validGroupName(req.Name) {
+		return servers, types.ErrBadName
+	}
+
+	i, err := c.ds.GetTenantInstance(tenant, ID)
+	if err != nil {
+		return servers, err
+	}
+
+	i.StateLock.RLock()
+	state := i.State
+	i.StateLock.RUnlock()
+
+	if i.CNCI || state != payloads.Exited {
+		return servers, types.ErrInstanceNotStopped
+	}
+
+	if err := c.checkInstanceNotArchived(ID); err != nil {
+		return servers, err
+	}
+
+	boot, ok := c.bootAttachment(ID)
+	if !ok {
+		return servers, errors.New("Instance has no boot volume to clone")
+	}
+
+	vol, err := c.ds.GetBlockDevice(boot.BlockID)
+	if err != nil {
+		return servers, err
+	}
+
+	driver, err := c.volumeDriver(vol.Class)
+	if err != nil {
+		return servers, err
+	}
+
+	clone := types.BootClone{
+		VolumeID:  vol.ID,
+		Snapshot:  "ciao-clone-" + uuid.Generate().String(),
+		Ephemeral: boot.Ephemeral,
+		Internal:  vol.Internal,
+	}
+
+	err = driver.CreateBlockDeviceSnapshot(vol.ID, clone.Snapshot)
+	if err != nil {
+		return servers, errors.Wrap(err, "Error creating boot volume snapshot")
+	}
+
+	w := types.WorkloadRequest{
+		WorkloadID:  i.WorkloadID,
+		TenantID:    tenant,
+		Instances:   req.Instances,
+		TraceLabel:  c.activeTraceLabel(),
+		Name:        req.Name,
+		Preemptible: i.Preemptible,
+		Group:       i.Group,
+		BootClone:   &clone,
+	}
+
+	instances, err := c.startWorkload(ctx, w)
+
+	var clones []string
+	for _, instance := range instances {
+		if a, ok := c.bootAttachment(instance.ID); ok {
+			clones = append(clones, a.BlockID)
+		}
+
+		server, serr := instanceToServer(c, instance)
+		if serr != nil && err == nil {
+			err = serr
+		}
+		server.OperationID = c.resourceOperationID(instance.ID, types.InstanceCreate)
+		servers.Servers = append(servers.Servers, server)
+	}
+	servers.TotalServers = len(servers.Servers)
+
+	go c.flattenClones(clone, vol.Class, clones)
+
+	if err != nil {
+		_ = c.ds.LogError(ctx, tenant, fmt.Sprintf("Error cloning instance %s: %v", ID, err))
+		if len(servers.Servers) == 0 {
+			return servers, err
+		}
+	}
+
+	msg := fmt.Sprintf("Cloned instance %s %d time(s)", ID, len(servers.Servers))
+	_ = c.ds.LogEvent(ctx, tenant, msg)
+
+	return servers, nil
+}
+
+// createClonedInstance creates an instance booting from a clone of a boot
+// volume snapshot, along with clones of the snapshots of its other volumes
+// if any.  The clones are deleted if the instance cannot be created.
+func (c *controller) createClonedInstance(ctx context.Context, w types.WorkloadRequest, wl types.Workload,
+	name string, newIP net.IP) (*types.Instance, error) {
+	vol, err := c.cloneVolumeSnapshot(ctx, w.TenantID, *w.BootClone, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error cloning boot volume")
+	}
+	clones := []string{vol.ID}
+
+	// the workload storage is shared, the boot storage of the instance
+	// is replaced in a copy of it.
+	boot := types.StorageResource{
+		ID:        vol.ID,
+		Bootable:  true,
+		Ephemeral: w.BootClone.Ephemeral,
+	}
+
+	storage := []types.StorageResource{boot}
+	if w.VolumeClones == nil {
+		for _, s := range wl.Storage {
+			if !s.Bootable {
+				storage = append(storage, s)
+			}
+		}
+	}
+
+	for _, clone := range w.VolumeClones {
+		vol, err := c.cloneVolumeSnapshot(ctx, w.TenantID, clone, false)
+		if err != nil {
+			c.deleteVolumeClones(ctx, clones)
+			return nil, errors.Wrap(err, "Error cloning volume")
+		}
+		clones = append(clones, vol.ID)
+
+		storage = append(storage, types.StorageResource{
+			ID:        vol.ID,
+			Ephemeral: clone.Ephemeral,
+		})
+	}
+	wl.Storage = storage
+
+	w.BootClone = nil
+	w.VolumeClones = nil
+	instance, err := c.createInstance(ctx, w, wl, name, newIP)
+	if err != nil {
+		c.deleteVolumeClones(ctx, clones)
+		return nil, err
+	}
+
+	return instance, nil
+}
+
+// cloneVolumeSnapshot creates a volume from a copy-on-write clone of a
+// volume snapshot.
+func (c *controller) cloneVolumeSnapshot(ctx context.Context, tenant string, clone types.BootClone,
+	bootable bool) (types.Volume, error) {
+	src, err := c.ds.GetBlockDevice(clone.VolumeID)
+	if err != nil {
+		return types.Volume{}, err
+	}
+
+	driver, err := c.volumeDriver(src.Class)
+	if err != nil {
+		return types.Volume{}, err
+	}
+
+	bd, err := driver.CloneBlockDevice(src.ID, clone.Snapshot, "")
+	if err != nil {
+		return types.Volume{}, err
+	}
+	bd.Bootable = bootable
+
+	req := api.RequestedVolume{
+		Description: fmt.Sprintf("Clone of volume: %s", src.ID),
+		SourceVolID: src.ID,
+		Class:       src.Class,
+		Encrypted:   src.Encrypted,
+		Internal:    clone.Internal,
+	}
+
+	return c.addVolume(ctx, tenant, req, driver, bd)
+}
+
+// deleteVolumeClones deletes the volume clones of an instance which could
+// not be created, unless they were already deleted with the instance.
+func (c *controller) deleteVolumeClones(ctx context.Context, volumeIDs []string) {
+	for _, ID := range volumeIDs {
+		vol, err := c.ds.GetBlockDevice(ID)
+		if err != nil || vol.State != types.Available {
+			continue
+		}
+
+		err = c.ds.DeleteBlockDevice(ctx, vol.ID)
+		if err == nil {
+			err = c.deleteVolumeBlockDevice(ctx, vol)
+		}
+
+		if err != nil {
+			glog.Warningf("Error deleting volume clone %s: %v", vol.ID, err)
+			continue
+		}
+
+		if !vol.Internal {
+			c.qs.Release(vol.TenantID, volumeResources(vol)...)
+		}
+	}
+}
+
+// flattenClones makes boot volume clones independent from the snapshot
+// they were cloned from and deletes the snapshot, so that the cloned
+// instance and its volume can be deleted.
+func (c *controller) flattenClones(clone types.BootClone, class string, clones []string) {
+	driver, err := c.volumeDriver(class)
+	if err != nil {
+		glog.Warningf("Error flattening clones of volume %s: %v", clone.VolumeID, err)
+		return
+	}
+
+	for _, ID := range clones {
+		if err := driver.FlattenBlockDevice(ID); err != nil {
+			glog.Warningf("Error flattening volume %s: %v", ID, err)
+		}
+	}
+
+	err = driver.DeleteBlockDeviceSnapshot(clone.VolumeID, clone.Snapshot)
+	if err != nil {
+		glog.Warningf("Error deleting snapshot %s of volume %s: %v", clone.Snapshot, clone.VolumeID, err)
+	}
+}
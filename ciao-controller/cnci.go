@@ -15,6 +15,7 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
@@ -49,6 +50,12 @@ var (
 
 var cnciEventTimeout = (2 * time.Minute)
 
+// cnciLaunchAttempts is the number of CNCIs WaitForActive launches for a
+// subnet whose CNCIs fail to start.
+const cnciLaunchAttempts = 3
+
+var errCNCIStartFailure = errors.New("CNCI failed to start")
+
 // CNCI represents a cnci instance that manages a single subnet.
 type CNCI struct {
 	instance *types.Instance
@@ -56,6 +63,11 @@ type CNCI struct {
 	eventCh  *chan event
 	subnet   string
 	timer    *time.Timer
+
+	// launching is closed once the launch of the cnci completes, when
+	// it becomes active or fails to start.  It is nil if no launch is
+	// in progress.
+	launching chan struct{}
 }
 
 // CNCIManager is a structure which defines a manager for CNCI instances
@@ -74,13 +86,13 @@ type CNCIManager struct {
 	subnets map[string]*CNCI
 }
 
-func (c *CNCI) stop() error {
+func (c *CNCI) stop(ctx context.Context) error {
 	err := c.instance.TransitionInstanceState(payloads.Stopping)
 	if err != nil {
 		return err
 	}
 
-	err = c.ctrl.deleteInstance(c.instance.ID)
+	err = c.ctrl.deleteInstance(ctx, c.instance.ID)
 	if err != nil {
 		return errors.Wrapf(err, "error deleting CNCI instance")
 	}
@@ -168,7 +180,7 @@ func (c *CNCIManager) Active(ID string) bool {
 	return instanceActive(cnci.instance)
 }
 
-func (c *CNCIManager) launch(subnet string) (*types.Instance, error) {
+func (c *CNCIManager) launch(ctx context.Context, subnet string) (*types.Instance, error) {
 	glog.V(2).Infof("launching cnci for subnet %s", subnet)
 
 	b := make([]byte, 4)
@@ -192,7 +204,7 @@ func (c *CNCIManager) launch(subnet string) (*types.Instance, error) {
 		Name:       name,
 	}
 
-	instances, err := c.ctrl.startWorkload(w)
+	instances, err := c.ctrl.startWorkload(ctx, w)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to Launch CNCI")
 	}
@@ -200,10 +212,12 @@ func (c *CNCIManager) launch(subnet string) (*types.Instance, error) {
 	return instances[0], nil
 }
 
-// WaitForActive will launch a cnci if needed and wait for it to be active,
-// or wait for an existing cnci to become active.
-func (c *CNCIManager) WaitForActive(subnet string) error {
+// launchOnce returns the cnci of a subnet, launching it if the subnet has
+// none.  Concurrent callers share the cnci launched by the first of them,
+// which is the only one for which launched is true.
+func (c *CNCIManager) launchOnce(ctx context.Context, subnet string) (cnci *CNCI, launched bool, err error) {
 	c.cnciLock.Lock()
+	defer c.cnciLock.Unlock()
 
 	cnci, ok := c.subnets[subnet]
 	if ok {
@@ -212,56 +226,62 @@ func (c *CNCIManager) WaitForActive(subnet string) error {
 			cnci.timer = nil
 		}
 
-		// subnet already exists
-		c.cnciLock.Unlock()
-
-		// block until subnet is active
-		return c.waitForActive(subnet)
+		return cnci, false, nil
 	}
 
 	glog.V(2).Infof("cnci does not exist for subnet %s", subnet)
 
-	ch := make(chan event)
+	// the lock is held until the cnci is recorded, so that no other
+	// cnci can be launched for the subnet in the meantime.
+	instance, err := c.launch(ctx, subnet)
+	if err != nil {
+		return nil, false, err
+	}
+
+	glog.V(2).Infof("AddSubnet CNCI instance is %s", instance.ID)
 
 	cnci = &CNCI{
-		ctrl:    c.ctrl,
-		eventCh: &ch,
-		subnet:  subnet,
+		instance:  instance,
+		ctrl:      c.ctrl,
+		subnet:    subnet,
+		launching: make(chan struct{}),
 	}
 
-	// we initialized the eventCh because we are going to wait for
-	// an event. Close and delete at the conclusion of this function.
-	defer func() {
-		close(ch)
-		cnci.eventCh = nil
-	}()
-
 	c.subnets[subnet] = cnci
+	c.cncis[instance.ID] = cnci
 
-	// send a launch command
-	instance, err := c.launch(subnet)
-	if err != nil {
-		c.cnciLock.Unlock()
-		return err
-	}
+	return cnci, true, nil
+}
 
-	glog.V(2).Infof("AddSubnet CNCI instance is %s", instance.ID)
+// WaitForActive will launch a cnci if needed and wait for it to be active,
+// or wait for an existing cnci to become active.  Only one cnci is launched
+// for a subnet at a time, whatever the number of concurrent callers.  If
+// the cnci fails to start another one is launched, up to
+// cnciLaunchAttempts times.  Callers stop waiting after cnciEventTimeout,
+// but the launch carries on and is shared with later callers.
+func (c *CNCIManager) WaitForActive(subnet string) error {
+	ctx := context.Background()
 
-	cnci.instance = instance
-	cnci.subnet = subnet
+	for attempt := 1; ; attempt++ {
+		cnci, launched, err := c.launchOnce(ctx, subnet)
+		if err != nil {
+			return err
+		}
 
-	c.cncis[instance.ID] = cnci
+		err = c.waitForActive(cnci)
+		if err == nil {
+			if launched {
+				return c.refresh()
+			}
+			return nil
+		}
 
-	c.cnciLock.Unlock()
+		if err != errCNCIStartFailure || attempt == cnciLaunchAttempts {
+			return err
+		}
 
-	// we release the lock before waiting because
-	// we need to be able to read the event channel.
-	err = waitForEventTimeout(ch, added, cnciEventTimeout)
-	if err != nil {
-		return err
+		glog.Warningf("CNCI %s for subnet %s failed to start, relaunching", cnci.instance.ID, subnet)
 	}
-
-	return c.refresh()
 }
 
 // ScheduleRemoveSubnet will kick off a timer to remove a subnet after 5 min.
@@ -302,6 +322,8 @@ func (c *CNCIManager) ScheduleRemoveSubnet(subnet string) error {
 // RemoveSubnet is called when a subnet no longer is needed.
 // a cnci can be stopped.
 func (c *CNCIManager) RemoveSubnet(subnet string) error {
+	ctx := context.Background()
+
 	glog.V(2).Infof("RemoveSubnet %s", subnet)
 
 	c.cnciLock.Lock()
@@ -315,7 +337,7 @@ func (c *CNCIManager) RemoveSubnet(subnet string) error {
 
 	delete(c.subnets, subnet)
 
-	err := cnci.stop()
+	err := cnci.stop(ctx)
 	if err != nil {
 		c.cnciLock.Unlock()
 		return err
@@ -361,6 +383,8 @@ func (c *CNCIManager) CNCIRemoved(id string) error {
 // CNCIStopped will move the CNCI to the exited state
 // and send an event through the event channel.
 func (c *CNCIManager) CNCIStopped(id string) error {
+	ctx := context.Background()
+
 	c.cnciLock.Lock()
 	defer c.cnciLock.Unlock()
 
@@ -370,7 +394,7 @@ func (c *CNCIManager) CNCIStopped(id string) error {
 	}
 
 	cnci.transitionState(exited)
-	err := c.ctrl.restartInstance(cnci.instance.ID)
+	err := c.ctrl.restartInstanceFor(ctx, cnci.instance.ID, types.InitiatorSystem, types.ReasonHealthCheck)
 
 	return errors.Wrap(err, "Error restarting instance")
 }
@@ -387,6 +411,7 @@ func (c *CNCIManager) CNCIAdded(id string) error {
 	}
 
 	cnci.transitionState(active)
+	cnci.launchDone()
 
 	return nil
 }
@@ -403,40 +428,39 @@ func (c *CNCIManager) StartFailure(id string) error {
 	}
 
 	delete(c.cncis, id)
-	delete(c.subnets, cnci.subnet)
+	if c.subnets[cnci.subnet] == cnci {
+		delete(c.subnets, cnci.subnet)
+	}
 
 	cnci.transitionState(failed)
+	cnci.launchDone()
 
 	return nil
 }
 
-func (c *CNCIManager) waitForActive(subnet string) error {
+// waitForActive blocks until the launch of cnci, if any, completes and
+// checks whether it is active.  errCNCIStartFailure is returned if it
+// failed to start.
+func (c *CNCIManager) waitForActive(cnci *CNCI) error {
 	c.cnciLock.RLock()
-
-	cnci, ok := c.subnets[subnet]
-
+	launching := cnci.launching
 	c.cnciLock.RUnlock()
 
-	if !ok {
-		return errors.New("No CNCI found")
-	}
-
-	if instanceActive(cnci.instance) {
-		return nil
+	if launching != nil {
+		select {
+		case <-launching:
+		case <-time.After(cnciEventTimeout):
+			return fmt.Errorf("timeout waiting for CNCI %s to be active", cnci.instance.ID)
+		}
 	}
 
-	// lock eventCh
-	eCh := cnci.eventCh
+	c.cnciLock.RLock()
+	defer c.cnciLock.RUnlock()
 
-	// CNCI launch not in process, and it's not active.
-	if eCh == nil {
-		return errors.New("CNCI not active")
+	if c.subnets[cnci.subnet] != cnci {
+		return errCNCIStartFailure
 	}
 
-	// CNCI launch in process. we wait here till
-	// the channel is closed. When it is, the cnci
-	// is either active, or it failed to start.
-	<-*eCh
 	if instanceActive(cnci.instance) {
 		return nil
 	}
@@ -444,6 +468,15 @@ func (c *CNCIManager) waitForActive(subnet string) error {
 	return errors.New("CNCI not active")
 }
 
+// launchDone marks the launch of the cnci, if any, as complete.  The
+// manager lock must be held.
+func (c *CNCI) launchDone() {
+	if c.launching != nil {
+		close(c.launching)
+		c.launching = nil
+	}
+}
+
 func (c *CNCIManager) refresh() error {
 	c.cnciLock.RLock()
 	defer c.cnciLock.RUnlock()
@@ -545,7 +578,7 @@ func (c *CNCIManager) Shutdown() {
 	}
 }
 
-func newCNCIManager(ctrl *controller, tenant string) (*CNCIManager, error) {
+func newCNCIManager(ctx context.Context, ctrl *controller, tenant string) (*CNCIManager, error) {
 	mgr := CNCIManager{
 		tenant: tenant,
 		ctrl:   ctrl,
@@ -612,7 +645,7 @@ func shutdownCNCICtrls(c *controller) {
 	return
 }
 
-func initializeCNCICtrls(c *controller) error {
+func initializeCNCICtrls(ctx context.Context, c *controller) error {
 	// get all the current tenants
 	ts, err := c.ds.GetAllTenants()
 	if err != nil {
@@ -620,7 +653,7 @@ func initializeCNCICtrls(c *controller) error {
 	}
 
 	for _, t := range ts {
-		t.CNCIctrl, err = newCNCIManager(c, t.ID)
+		err = c.prepareTenant(ctx, t.ID)
 		if err != nil {
 			return errors.Wrap(err, "error allocating CNCI manager")
 		}
@@ -15,13 +15,22 @@
 package main
 
 import (
+	"context"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
 	"github.com/ciao-project/ciao/ssntp"
+	"github.com/ciao-project/ciao/uuid"
+	yaml "gopkg.in/yaml.v2"
 )
 
 func TestCNCIInitializeCtrls(t *testing.T) {
-	err := initializeCNCICtrls(ctl)
+	ctx := context.Background()
+
+	err := initializeCNCICtrls(ctx, ctl)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -39,6 +48,8 @@ func TestCNCIInitializeCtrls(t *testing.T) {
 }
 
 func TestCNCILaunch(t *testing.T) {
+	ctx := context.Background()
+
 	testClient, client, instances := testStartWorkloadLaunchCNCI(t, 1)
 	defer testClient.Shutdown()
 	defer client.Shutdown()
@@ -59,7 +70,7 @@ func TestCNCILaunch(t *testing.T) {
 		t.Fatal("CNCI Info not updated")
 	}
 
-	tenant, err := ctl.ds.GetTenant(id)
+	tenant, err := ctl.ds.GetTenant(ctx, id)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -70,6 +81,8 @@ func TestCNCILaunch(t *testing.T) {
 }
 
 func TestCNCIRemoved(t *testing.T) {
+	ctx := context.Background()
+
 	netClient, client, instances := testStartWorkloadLaunchCNCI(t, 1)
 	defer client.Shutdown()
 	defer netClient.Shutdown()
@@ -82,7 +95,7 @@ func TestCNCIRemoved(t *testing.T) {
 	instance := instances[0]
 
 	// get the tenant
-	tenant, err := ctl.ds.GetTenant(tenantID)
+	tenant, err := ctl.ds.GetTenant(ctx, tenantID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -103,7 +116,7 @@ func TestCNCIRemoved(t *testing.T) {
 	clientCh := client.AddCmdChan(ssntp.DELETE)
 	netClientCh := netClient.AddCmdChan(ssntp.DELETE)
 
-	err = ctl.deleteInstance(instanceID)
+	err = ctl.deleteInstance(ctx, instanceID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -133,7 +146,7 @@ func TestCNCIRemoved(t *testing.T) {
 		t.Fatal("instance was not deleted")
 	}
 
-	tenant, err = ctl.ds.GetTenant(tenantID)
+	tenant, err = ctl.ds.GetTenant(ctx, tenantID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -165,3 +178,291 @@ func TestCNCIRemoved(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// waitForTenantCNCIs polls the CNCIs of a tenant until num have been
+// launched.
+func waitForTenantCNCIs(t *testing.T, tenantID string, num int) []*types.Instance {
+	for i := 0; i < 100; i++ {
+		cncis, err := ctl.ds.GetTenantCNCIs(tenantID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(cncis) >= num {
+			return cncis
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	t.Fatalf("Timeout waiting for %d CNCIs", num)
+	return nil
+}
+
+func TestConfirmTenantConcurrent(t *testing.T) {
+	const callers = 50
+
+	ctx := context.Background()
+	tenantID := uuid.Generate().String()
+
+	var wg sync.WaitGroup
+	ctrlCh := make(chan types.CNCIController, callers)
+	errCh := make(chan error, callers)
+
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := ctl.confirmTenant(ctx, tenantID)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			tenant, err := ctl.ds.GetTenant(ctx, tenantID)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			ctrlCh <- tenant.CNCIctrl
+		}()
+	}
+
+	wg.Wait()
+	close(errCh)
+	close(ctrlCh)
+
+	for err := range errCh {
+		t.Fatal(err)
+	}
+
+	var ctrl types.CNCIController
+	for c := range ctrlCh {
+		if c == nil {
+			t.Fatal("Confirmed tenant has no CNCI controller")
+		}
+		if ctrl == nil {
+			ctrl = c
+		} else if c != ctrl {
+			t.Fatal("Several CNCI controllers created for a tenant")
+		}
+	}
+}
+
+func TestCNCIConcurrentLaunch(t *testing.T) {
+	const callers = 20
+
+	ctx := context.Background()
+
+	tenant, err := addTestTenantNoCNCI(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subnet := "172.16.0.0/24"
+	errCh := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			errCh <- tenant.CNCIctrl.WaitForActive(subnet)
+		}()
+	}
+
+	cncis := waitForTenantCNCIs(t, tenant.ID, 1)
+
+	err = tenant.CNCIctrl.CNCIAdded(cncis[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < callers; i++ {
+		if err := <-errCh; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cncis, err = ctl.ds.GetTenantCNCIs(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cncis) != 1 {
+		t.Fatalf("Expected 1 CNCI for the subnet, %d launched", len(cncis))
+	}
+}
+
+func TestCNCILaunchRetry(t *testing.T) {
+	const callers = 10
+
+	ctx := context.Background()
+
+	tenant, err := addTestTenantNoCNCI(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subnet := "172.16.0.0/24"
+	errCh := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			errCh <- tenant.CNCIctrl.WaitForActive(subnet)
+		}()
+	}
+
+	cncis := waitForTenantCNCIs(t, tenant.ID, 1)
+	failedID := cncis[0].ID
+
+	err = ctl.ds.StartFailure(ctx, failedID, payloads.FullCloud, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tenant.CNCIctrl.StartFailure(failedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var relaunched []*types.Instance
+	for i := 0; i < 100 && len(relaunched) == 0; i++ {
+		relaunched, err = ctl.ds.GetTenantCNCIs(tenant.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if len(relaunched) != 1 || relaunched[0].ID == failedID {
+		t.Fatalf("Expected 1 CNCI to be relaunched, got %d CNCIs", len(relaunched))
+	}
+
+	err = tenant.CNCIctrl.CNCIAdded(relaunched[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < callers; i++ {
+		if err := <-errCh; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCNCILaunchTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenantNoCNCI(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := cnciEventTimeout
+	cnciEventTimeout = 100 * time.Millisecond
+	defer func() { cnciEventTimeout = timeout }()
+
+	subnet := "172.16.0.0/24"
+	err = tenant.CNCIctrl.WaitForActive(subnet)
+	if err == nil {
+		t.Fatal("Expected timeout waiting for CNCI")
+	}
+
+	// the launch in progress is shared rather than retried.
+	err = tenant.CNCIctrl.WaitForActive(subnet)
+	if err == nil {
+		t.Fatal("Expected timeout waiting for CNCI")
+	}
+
+	cncis, err := ctl.ds.GetTenantCNCIs(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cncis) != 1 {
+		t.Fatalf("Expected 1 CNCI for the subnet, %d launched", len(cncis))
+	}
+
+	err = tenant.CNCIctrl.CNCIAdded(cncis[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tenant.CNCIctrl.WaitForActive(subnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCNCIFlavor(t *testing.T) {
+	ctx := context.Background()
+
+	netClient, client, instances := testStartWorkloadLaunchCNCI(t, 1)
+	defer netClient.Shutdown()
+	defer client.Shutdown()
+
+	tenantID := instances[0].TenantID
+
+	// the stats of the network node assign the cnci to it.
+	sendStatsCmd(netClient, t)
+
+	cncis, err := ctl.ds.GetTenantCNCIs(tenantID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cncis) != 1 {
+		t.Fatalf("Expected 1 CNCI, got %d", len(cncis))
+	}
+
+	cnci := cncis[0]
+
+	_, err = ctl.PatchTenant(ctx, tenantID, []byte(`{"cnci_flavor":{"vcpus":-1}}`))
+	if err != types.ErrInvalidCNCIFlavor {
+		t.Fatalf("Expected %v, got %v", types.ErrInvalidCNCIFlavor, err)
+	}
+
+	serverCh := server.AddCmdChan(ssntp.DELETE)
+
+	resp, err := ctl.PatchTenant(ctx, tenantID, []byte(`{"cnci_flavor":{"vcpus":8,"mem_mb":2048}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Previous.CNCIFlavor.VCPUs == 8 || resp.Config.CNCIFlavor.VCPUs != 8 {
+		t.Fatalf("Unexpected CNCI flavors %+v and %+v", resp.Previous.CNCIFlavor, resp.Config.CNCIFlavor)
+	}
+
+	// the running cnci is stopped to be restarted with the new flavor.
+	result, err := server.GetCmdChanResult(serverCh, ssntp.DELETE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.InstanceUUID != cnci.ID {
+		t.Fatalf("Expected CNCI %s to be stopped, got %s", cnci.ID, result.InstanceUUID)
+	}
+
+	tenant, err := ctl.ds.GetTenant(ctx, tenantID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := ctl.instanceWorkload(cnci)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	y, err := ctl.restartPayload(ctx, cnci, &w, tenant, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var restart payloads.Start
+	err = yaml.Unmarshal(y, &restart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := restart.Start.Requirements
+	if reqs.VCPUs != 8 || reqs.MemMB != 2048 || !reqs.NetworkNode {
+		t.Fatalf("CNCI restarted without its flavor: %+v", reqs)
+	}
+}
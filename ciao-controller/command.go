@@ -17,18 +17,41 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"net"
 	"runtime"
 	"time"
 
+	"github.com/ciao-project/ciao/ciao-controller/internal/datastore"
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/ciao-project/ciao/payloads"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 )
 
-func (c *controller) restartInstance(instanceID string) error {
+var preemptionWarning = flag.Duration("preemption_warning", 30*time.Second, "Time a preempted instance is given to shut down before it is stopped")
+
+// instanceWorkload returns the workload an instance is restarted from.
+// CNCIs are sized according to the current CNCI flavor of their tenant.
+func (c *controller) instanceWorkload(i *types.Instance) (types.Workload, error) {
+	if i.CNCI {
+		return c.ds.GetCNCIWorkload(i.TenantID)
+	}
+
+	return c.ds.GetWorkload(i.WorkloadID)
+}
+
+// restartInstance restarts an exited instance on behalf of a user.
+func (c *controller) restartInstance(ctx context.Context, instanceID string) error {
+	return c.restartInstanceFor(ctx, instanceID, types.InitiatorUser, types.ReasonAPIRequest)
+}
+
+// restartInstanceFor restarts an exited instance, recording who initiated
+// the restart and why.
+func (c *controller) restartInstanceFor(ctx context.Context, instanceID string, initiator types.InstanceActionInitiator,
+	reason types.InstanceActionReason) error {
 	// should I bother to see if instanceID is valid?
 	i, err := c.ds.GetInstance(instanceID)
 	if err != nil {
@@ -39,12 +62,16 @@ func (c *controller) restartInstance(instanceID string) error {
 		return errors.New("You may only restart paused instances")
 	}
 
-	w, err := c.ds.GetWorkload(i.WorkloadID)
+	if err := c.checkInstanceNotArchived(instanceID); err != nil {
+		return err
+	}
+
+	w, err := c.instanceWorkload(i)
 	if err != nil {
 		return err
 	}
 
-	t, err := c.ds.GetTenant(i.TenantID)
+	t, err := c.ds.GetTenant(ctx, i.TenantID)
 	if err != nil {
 		return err
 	}
@@ -56,6 +83,8 @@ func (c *controller) restartInstance(instanceID string) error {
 		}
 	}
 
+	c.ds.SetInstanceActionCause(instanceID, initiator, reason)
+
 	go func() {
 		if err := c.client.RestartInstance(i, &w, t); err != nil {
 			glog.Warningf("Error restarting instance: %v", err)
@@ -65,7 +94,7 @@ func (c *controller) restartInstance(instanceID string) error {
 	return nil
 }
 
-func (c *controller) stopInstance(instanceID string) error {
+func (c *controller) stopInstance(ctx context.Context, instanceID string) error {
 	// get node id.  If there is no node id we can't send a delete
 	i, err := c.ds.GetInstance(instanceID)
 	if err != nil {
@@ -80,6 +109,8 @@ func (c *controller) stopInstance(instanceID string) error {
 		return errors.New("You may not stop a pending instance")
 	}
 
+	c.ds.SetInstanceActionCause(instanceID, types.InitiatorUser, types.ReasonAPIRequest)
+
 	go func() {
 		if err := c.client.StopInstance(instanceID, i.NodeID); err != nil {
 			glog.Warningf("Error stopping instance: %v", err)
@@ -89,8 +120,120 @@ func (c *controller) stopInstance(instanceID string) error {
 	return nil
 }
 
+// preemptInstance stops an instance the scheduler preempted to make room for
+// a higher priority instance.
+func (c *controller) preemptInstance(ctx context.Context, instanceID string, preemptorID string) error {
+	i, err := c.ds.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+
+	if i.NodeID == "" {
+		return types.ErrInstanceNotAssigned
+	}
+
+	c.ds.SetInstanceActionCause(instanceID, types.InitiatorSystem, types.ReasonPreemption)
+
+	// Warn the instance owner before reclaiming the instance. The
+	// termination time is reported in the instance details.
+	terminationTime := time.Now().Add(*preemptionWarning)
+	i.StateLock.Lock()
+	i.TerminationTime = terminationTime
+	i.StateLock.Unlock()
+
+	msg := fmt.Sprintf("Instance %s preempted by %s, terminating at %s", instanceID, preemptorID,
+		terminationTime.Format(time.RFC3339))
+	if err := c.ds.LogEvent(ctx, i.TenantID, msg); err != nil {
+		glog.Warningf("Error logging event: %v", err)
+	}
+
+	go func() {
+		time.Sleep(*preemptionWarning)
+		if err := c.client.StopInstance(instanceID, i.NodeID); err != nil {
+			glog.Warningf("Error stopping preempted instance: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+func (c *controller) pauseInstance(instanceID string) error {
+	i, err := c.ds.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+
+	if i.NodeID == "" {
+		return types.ErrInstanceNotAssigned
+	}
+
+	if i.State != payloads.Running {
+		return types.ErrInstanceNotRunning
+	}
+
+	c.ds.SetInstanceActionCause(instanceID, types.InitiatorUser, types.ReasonAPIRequest)
+
+	go func() {
+		if err := c.client.PauseInstance(instanceID, i.NodeID); err != nil {
+			glog.Warningf("Error pausing instance: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+func (c *controller) rebootInstance(instanceID string, hard bool) error {
+	i, err := c.ds.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+
+	if i.NodeID == "" {
+		return types.ErrInstanceNotAssigned
+	}
+
+	if i.State != payloads.Running {
+		return types.ErrInstanceNotRunning
+	}
+
+	c.ds.SetInstanceActionCause(instanceID, types.InitiatorUser, types.ReasonAPIRequest)
+
+	go func() {
+		if err := c.client.RebootInstance(instanceID, i.NodeID, hard); err != nil {
+			glog.Warningf("Error rebooting instance: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+func (c *controller) unpauseInstance(instanceID string) error {
+	i, err := c.ds.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+
+	if i.NodeID == "" {
+		return types.ErrInstanceNotAssigned
+	}
+
+	if i.State != payloads.Paused {
+		return types.ErrInstanceNotPaused
+	}
+
+	c.ds.SetInstanceActionCause(instanceID, types.InitiatorUser, types.ReasonAPIRequest)
+
+	go func() {
+		if err := c.client.UnpauseInstance(instanceID, i.NodeID); err != nil {
+			glog.Warningf("Error unpausing instance: %v", err)
+		}
+	}()
+
+	return nil
+}
+
 // delete an instance, wait for the deleted event.
-func (c *controller) deleteInstanceSync(instanceID string) error {
+func (c *controller) deleteInstanceSync(ctx context.Context, instanceID string) error {
 	wait := make(chan struct{})
 
 	i, err := c.ds.GetInstance(instanceID)
@@ -98,7 +241,7 @@ func (c *controller) deleteInstanceSync(instanceID string) error {
 		return err
 	}
 
-	err = c.deleteInstance(instanceID)
+	err = c.deleteInstance(ctx, instanceID)
 	if err != nil {
 		return err
 	}
@@ -134,7 +277,7 @@ func (c *controller) deleteInstanceSync(instanceID string) error {
 	}
 }
 
-func (c *controller) deleteInstance(instanceID string) error {
+func (c *controller) deleteInstance(ctx context.Context, instanceID string) error {
 	// get node id.  If there is no node id and the instance is
 	// pending we can't send a delete
 	i, err := c.ds.GetInstance(instanceID)
@@ -167,97 +310,80 @@ func (c *controller) deleteInstance(instanceID string) error {
 	return nil
 }
 
-func (c *controller) confirmTenantRaw(tenantID string) error {
-	tenant, err := c.ds.GetTenant(tenantID)
-	if err != nil {
-		return err
-	}
+// tenantReadyTimeout is the time confirmTenant waits for a tenant being
+// prepared by another request.
+var tenantReadyTimeout = time.Minute
 
-	if tenant != nil {
-		return nil
-	}
-
-	// if we are adding tenant this way, we need to use defaults
-	config := types.TenantConfig{
-		Name:       "",
-		SubnetBits: 24,
-	}
-
-	tenant, err = c.ds.AddTenant(tenantID, config)
-	if err != nil {
-		return err
-	}
+// prepareTenant creates the CNCI controller of a tenant stored in the
+// datastore, unless it has already been created.
+func (c *controller) prepareTenant(ctx context.Context, tenantID string) error {
+	return c.ds.PrepareTenant(ctx, tenantID, tenantReadyTimeout, func() (types.CNCIController, error) {
+		return newCNCIManager(ctx, c, tenantID)
+	})
+}
 
-	tenant.CNCIctrl, err = newCNCIManager(c, tenantID)
+// confirmTenant makes sure that a tenant exists and is ready to run
+// instances, adding it with the default configuration if needed.  Only
+// one CNCI controller is ever created for a tenant, whatever the number
+// of concurrent requests confirming it.
+func (c *controller) confirmTenant(ctx context.Context, tenantID string) error {
+	tenant, err := c.ds.GetTenant(ctx, tenantID)
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
-
-func (c *controller) confirmTenant(tenantID string) error {
-	c.tenantReadinessLock.Lock()
-	memo := c.tenantReadiness[tenantID]
-	if memo != nil {
-
-		// Someone else has already or is in the process of confirming
-		// this tenant.  We need to wait until memo.ch is closed before
-		// continuing.
-
-		c.tenantReadinessLock.Unlock()
-		<-memo.ch
-		if memo.err != nil {
-			return memo.err
+	if tenant == nil {
+		// if we are adding tenant this way, we need to use defaults
+		config := types.TenantConfig{
+			Name:       "",
+			SubnetBits: 24,
 		}
 
-		// If we get here we know that confirmTenantRaw has already
-		// been successfully called for this tenant during the life
-		// time of this controller invocation.
-
-		return nil
+		// a concurrent request may have added the tenant first.
+		_, err = c.ds.AddTenant(ctx, tenantID, config)
+		if err != nil && err != datastore.ErrDuplicateTenant {
+			return err
+		}
 	}
 
-	ch := make(chan struct{})
-	c.tenantReadiness[tenantID] = &tenantConfirmMemo{ch: ch}
-	c.tenantReadinessLock.Unlock()
-	err := c.confirmTenantRaw(tenantID)
-	if err != nil {
-		c.tenantReadinessLock.Lock()
-		c.tenantReadiness[tenantID].err = err
-		delete(c.tenantReadiness, tenantID)
-		c.tenantReadinessLock.Unlock()
-	}
-	close(ch)
-	return err
+	return c.prepareTenant(ctx, tenantID)
 }
 
-func (c *controller) createInstance(w types.WorkloadRequest, wl types.Workload, name string, newIP net.IP) (*types.Instance, error) {
+func (c *controller) createInstance(ctx context.Context, w types.WorkloadRequest, wl types.Workload, name string, newIP net.IP) (*types.Instance, error) {
+	if w.BootClone != nil {
+		return c.createClonedInstance(ctx, w, wl, name, newIP)
+	}
+
 	startTime := time.Now()
 
-	instance, err := newInstance(c, w.TenantID, &wl, name, w.Subnet, newIP)
+	instance, err := newInstance(ctx, c, w.TenantID, &wl, name, w.Subnet, newIP, w.Preemptible)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error creating instance")
 	}
 	instance.startTime = startTime
+	instance.Group = w.Group
 
 	ok, err := instance.Allowed()
 	if err != nil {
-		_ = instance.Clean()
+		_ = instance.Clean(ctx)
 		return nil, errors.Wrap(err, "Error checking if instance allowed")
 	}
 
 	if !ok {
-		_ = instance.Clean()
+		_ = instance.Clean(ctx)
 		return nil, errors.New("Over quota")
 	}
 
-	err = instance.Add()
+	err = instance.Add(ctx)
 	if err != nil {
-		_ = instance.Clean()
+		_ = instance.Clean(ctx)
 		return nil, errors.Wrap(err, "Error adding instance")
 	}
 
+	// The operation completes when the instance is reported as running,
+	// or when the instance fails to start.
+	op := c.newOperation(w.TenantID, types.InstanceCreate, instance.ID)
+
 	if w.TraceLabel == "" {
 		err = c.client.StartWorkload(instance.newConfig.config)
 	} else {
@@ -265,14 +391,15 @@ func (c *controller) createInstance(w types.WorkloadRequest, wl types.Workload,
 	}
 
 	if err != nil {
-		_ = instance.Clean()
+		c.completeOperation(&op, err)
+		_ = instance.Clean(ctx)
 		return nil, errors.Wrap(err, "Error starting workload")
 	}
 
 	return instance.Instance, nil
 }
 
-func (c *controller) startWorkload(w types.WorkloadRequest) ([]*types.Instance, error) {
+func (c *controller) startWorkload(ctx context.Context, w types.WorkloadRequest) ([]*types.Instance, error) {
 	var e error
 	var sem = make(chan int, runtime.NumCPU())
 
@@ -285,8 +412,15 @@ func (c *controller) startWorkload(w types.WorkloadRequest) ([]*types.Instance,
 		return nil, err
 	}
 
+	if isCNCIWorkload(&wl) {
+		wl, err = c.ds.GetCNCIWorkload(w.TenantID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if wl.Requirements.Privileged {
-		tenant, err := c.ds.GetTenant(w.TenantID)
+		tenant, err := c.ds.GetTenant(ctx, w.TenantID)
 		if err != nil {
 			return nil, errors.Wrap(err, "error getting tenant from datastore")
 		}
@@ -296,11 +430,35 @@ func (c *controller) startWorkload(w types.WorkloadRequest) ([]*types.Instance,
 		}
 	}
 
+	names := make([]string, w.Instances)
+	for i := range names {
+		names[i] = w.Name
+		if w.Name != "" && w.Instances > 1 {
+			names[i] = fmt.Sprintf("%s-%d", w.Name, i)
+		}
+	}
+
+	release, err := c.reserveNames(instanceResource, w.TenantID, names...)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	var IPPool []net.IP
 
 	// if this is for a CNCI, we don't want to allocate any IPs.
-	if w.Subnet == "" {
-		IPPool, err = c.ds.AllocateTenantIPPool(w.TenantID, w.Instances)
+	if w.Subnet == "" && w.IPAddress != "" {
+		if w.Instances != 1 {
+			return nil, types.ErrStaticIPInstances
+		}
+
+		IP, err := c.ds.AllocateTenantIPAddress(ctx, w.TenantID, w.IPAddress)
+		if err != nil {
+			return nil, err
+		}
+		IPPool = []net.IP{IP}
+	} else if w.Subnet == "" {
+		IPPool, err = c.ds.AllocateTenantIPPool(ctx, w.TenantID, w.Instances)
 		if err != nil {
 			return nil, err
 		}
@@ -321,23 +479,16 @@ func (c *controller) startWorkload(w types.WorkloadRequest) ([]*types.Instance,
 			newIP = IPPool[i]
 		}
 
-		name := w.Name
-		if name != "" {
-			if w.Instances > 1 {
-				name = fmt.Sprintf("%s-%d", name, i)
-			}
-		}
-
 		go func(newIP net.IP, name string) {
 			sem <- 1
-			instance, err := c.createInstance(w, wl, name, newIP)
+			instance, err := c.createInstance(ctx, w, wl, name, newIP)
 			ret := result{
 				err:      err,
 				instance: instance,
 			}
 			<-sem
 			errChan <- ret
-		}(newIP, name)
+		}(newIP, names[i])
 	}
 
 	for i := 0; i < w.Instances; i++ {
@@ -353,13 +504,13 @@ func (c *controller) startWorkload(w types.WorkloadRequest) ([]*types.Instance,
 	return newInstances, e
 }
 
-func (c *controller) deleteEphemeralStorage(instanceID string) error {
+func (c *controller) deleteEphemeralStorage(ctx context.Context, instanceID string) error {
 	attachments := c.ds.GetStorageAttachments(instanceID)
 	for _, attachment := range attachments {
 		if !attachment.Ephemeral {
 			continue
 		}
-		err := c.ds.DeleteStorageAttachment(attachment.ID)
+		err := c.ds.DeleteStorageAttachment(ctx, attachment.ID)
 		if err != nil {
 			return errors.Wrap(err, "Error deleting storage attachment from datastore")
 		}
@@ -367,19 +518,23 @@ func (c *controller) deleteEphemeralStorage(instanceID string) error {
 		if err != nil {
 			return errors.Wrap(err, "Error getting block device from datastore")
 		}
-		err = c.ds.DeleteBlockDevice(attachment.BlockID)
+		err = c.ds.DeleteBlockDevice(ctx, attachment.BlockID)
 		if err != nil {
 			return errors.Wrap(err, "Error deleting block device from datastore")
 		}
-		err = c.DeleteBlockDevice(attachment.BlockID)
+		err = c.deleteVolumeBlockDevice(ctx, bd)
 		if err != nil {
 			return errors.Wrap(err, "Error deleting block device")
 		}
 		if !bd.Internal {
-			c.qs.Release(bd.TenantID,
-				payloads.RequestedResource{Type: payloads.Volume, Value: 1},
-				payloads.RequestedResource{Type: payloads.SharedDiskGiB, Value: bd.Size})
+			c.qs.Release(bd.TenantID, volumeResources(bd)...)
 		}
 	}
+
+	i, err := c.ds.GetInstance(instanceID)
+	if err == nil && i.RescueVolume != "" {
+		return c.deleteRescueVolume(ctx, i.RescueVolume)
+	}
+
 	return nil
 }
@@ -15,12 +15,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"sort"
 
 	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
 	"github.com/gorilla/mux"
 )
 
@@ -45,17 +47,38 @@ func instanceToServer(ctl *controller, instance *types.Instance) (api.ServerDeta
 				MacAddr: instance.MACAddress,
 			},
 		},
-		Volumes: volumes,
-		SSHIP:   instance.SSHIP,
-		SSHPort: instance.SSHPort,
-		Created: instance.CreateTime,
-		Name:    instance.Name,
+		Volumes:     volumes,
+		SSHIP:       instance.SSHIP,
+		SSHPort:     instance.SSHPort,
+		Created:     instance.CreateTime,
+		Name:        instance.Name,
+		Preemptible: instance.Preemptible,
+		Group:       instance.Group,
 	}
 
+	server.ArchiveState = string(ctl.instanceArchiveState(instance.ID))
+
+	instance.StateLock.RLock()
+	if !instance.TerminationTime.IsZero() {
+		terminationTime := instance.TerminationTime
+		server.TerminationTime = &terminationTime
+	}
+	server.Rescued = instance.Rescued
+	server.PeerID = instance.PeerID
+	server.Failure = instance.Failure
+	// probe results are only reported while the instance is running.
+	if !instance.ProbeTime.IsZero() && instance.State == payloads.Running {
+		reachable := instance.Reachable
+		probeTime := instance.ProbeTime
+		server.Reachable = &reachable
+		server.ProbeTime = &probeTime
+	}
+	instance.StateLock.RUnlock()
+
 	return server, nil
 }
 
-func (c *controller) CreateServer(tenant string, server api.CreateServerRequest) (resp interface{}, err error) {
+func (c *controller) CreateServer(ctx context.Context, tenant string, server api.CreateServerRequest) (resp interface{}, err error) {
 	nInstances := 1
 
 	if server.Server.MaxInstances > 0 {
@@ -72,17 +95,31 @@ func (c *controller) CreateServer(tenant string, server api.CreateServerRequest)
 		}
 	}
 
+	if server.Server.Group != "" && !validGroupName(server.Server.Group) {
+		return server, types.ErrBadName
+	}
+
+	if server.Server.IPAddress != "" && nInstances != 1 {
+		return server, types.ErrBadRequest
+	}
+
 	label := server.Server.Metadata["label"]
+	if label == "" {
+		label = c.activeTraceLabel()
+	}
 
 	w := types.WorkloadRequest{
-		WorkloadID: server.Server.WorkloadID,
-		TenantID:   tenant,
-		Instances:  nInstances,
-		TraceLabel: label,
-		Name:       server.Server.Name,
+		WorkloadID:  server.Server.WorkloadID,
+		TenantID:    tenant,
+		Instances:   nInstances,
+		TraceLabel:  label,
+		Name:        server.Server.Name,
+		IPAddress:   server.Server.IPAddress,
+		Preemptible: server.Server.Preemptible,
+		Group:       server.Server.Group,
 	}
 	var e error
-	instances, err := c.startWorkload(w)
+	instances, err := c.startWorkload(ctx, w)
 	if err != nil {
 		e = err
 	}
@@ -94,11 +131,12 @@ func (c *controller) CreateServer(tenant string, server api.CreateServerRequest)
 		if err != nil && e == nil {
 			e = err
 		}
+		server.OperationID = c.resourceOperationID(instance.ID, types.InstanceCreate)
 		servers.Servers = append(servers.Servers, server)
 	}
 
 	if e != nil {
-		_ = c.ds.LogError(tenant, fmt.Sprintf("Error launching instance(s): %v", e))
+		_ = c.ds.LogError(ctx, tenant, fmt.Sprintf("Error launching instance(s): %v", e))
 	}
 
 	// If no instances launcher or if none converted bail early
@@ -129,7 +167,7 @@ func (c *controller) CreateServer(tenant string, server api.CreateServerRequest)
 	return builtServers, nil
 }
 
-func (c *controller) ListServersDetail(tenant string) ([]api.ServerDetails, error) {
+func (c *controller) ListServersDetail(ctx context.Context, tenant string) ([]api.ServerDetails, error) {
 	var servers []api.ServerDetails
 	var err error
 	var instances []*types.Instance
@@ -147,6 +185,10 @@ func (c *controller) ListServersDetail(tenant string) ([]api.ServerDetails, erro
 	sort.Sort(types.SortedInstancesByID(instances))
 
 	for _, instance := range instances {
+		if instance.IsDeleted() {
+			continue
+		}
+
 		server, err := instanceToServer(c, instance)
 		if err != nil {
 			continue
@@ -158,7 +200,7 @@ func (c *controller) ListServersDetail(tenant string) ([]api.ServerDetails, erro
 	return servers, nil
 }
 
-func (c *controller) ShowServerDetails(tenant string, server string) (api.Server, error) {
+func (c *controller) ShowServerDetails(ctx context.Context, tenant string, server string) (api.Server, error) {
 	var s api.Server
 
 	instance, err := c.ds.GetTenantInstance(tenant, server)
@@ -174,40 +216,130 @@ func (c *controller) ShowServerDetails(tenant string, server string) (api.Server
 	return s, nil
 }
 
-func (c *controller) DeleteServer(tenant string, server string) error {
-	/* First check that the instance belongs to this tenant */
+// ListInstanceActions returns the state transitions of an instance along
+// with who initiated them and why.
+func (c *controller) ListInstanceActions(ctx context.Context, tenant string, server string) ([]types.InstanceAction, error) {
 	_, err := c.ds.GetTenantInstance(tenant, server)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ds.GetInstanceActions(server), nil
+}
+
+func (c *controller) DeleteServer(ctx context.Context, tenant string, server string) error {
+	/* First check that the instance belongs to this tenant */
+	i, err := c.ds.GetTenantInstance(tenant, server)
 	if err != nil {
 		return api.ErrInstanceNotFound
 	}
 
-	err = c.deleteInstance(server)
+	pc, remoteID, err := c.peerInstance(ctx, i)
+	if err != nil {
+		return err
+	}
+
+	// the disks of instances burst to a federation peer are in the peer,
+	// so they are deleted right away.
+	if pc != nil {
+		return c.deletePeerInstance(i, pc, remoteID)
+	}
+
+	// archived instances may be deleted, but not while their volumes
+	// are moving to or from the archive.
+	switch c.instanceArchiveState(i.ID) {
+	case types.Archiving, types.Rehydrating:
+		return types.ErrInstanceArchived
+	}
+
+	if err := c.checkInstanceNotMigrating(i.ID); err != nil {
+		return err
+	}
+
+	if c.deletedRetention > 0 {
+		return c.softDeleteInstance(ctx, i)
+	}
+
+	err = c.deleteInstance(ctx, server)
 
 	return err
 }
 
-func (c *controller) StartServer(tenant string, ID string) error {
-	_, err := c.ds.GetTenantInstance(tenant, ID)
+func (c *controller) StartServer(ctx context.Context, tenant string, ID string) error {
+	i, err := c.ds.GetTenantInstance(tenant, ID)
+	if err != nil {
+		return err
+	}
+
+	pc, remoteID, err := c.peerInstance(ctx, i)
 	if err != nil {
 		return err
 	}
 
-	err = c.restartInstance(ID)
+	if pc != nil {
+		c.ds.SetInstanceActionCause(ID, types.InitiatorUser, types.ReasonAPIRequest)
+		return pc.StartInstance(remoteID)
+	}
+
+	err = c.restartInstance(ctx, ID)
 
 	return err
 }
 
-func (c *controller) StopServer(tenant string, ID string) error {
-	_, err := c.ds.GetTenantInstance(tenant, ID)
+func (c *controller) StopServer(ctx context.Context, tenant string, ID string) error {
+	i, err := c.ds.GetTenantInstance(tenant, ID)
+	if err != nil {
+		return err
+	}
+
+	pc, remoteID, err := c.peerInstance(ctx, i)
 	if err != nil {
 		return err
 	}
 
-	err = c.stopInstance(ID)
+	if pc != nil {
+		c.ds.SetInstanceActionCause(ID, types.InitiatorUser, types.ReasonAPIRequest)
+		return pc.StopInstance(remoteID)
+	}
+
+	if err := c.checkInstanceNotMigrating(ID); err != nil {
+		return err
+	}
+
+	err = c.stopInstance(ctx, ID)
 
 	return err
 }
 
+func (c *controller) PauseServer(ctx context.Context, tenant string, ID string) error {
+	_, err := c.ds.GetTenantInstance(tenant, ID)
+	if err != nil {
+		return err
+	}
+
+	return c.pauseInstance(ID)
+}
+
+func (c *controller) UnpauseServer(ctx context.Context, tenant string, ID string) error {
+	_, err := c.ds.GetTenantInstance(tenant, ID)
+	if err != nil {
+		return err
+	}
+
+	return c.unpauseInstance(ID)
+}
+
+// RebootServer reboots a running instance in place, on the node it is
+// running on.  The guest is asked to shut down first unless hard is set.
+func (c *controller) RebootServer(ctx context.Context, tenant string, ID string, hard bool) error {
+	_, err := c.ds.GetTenantInstance(tenant, ID)
+	if err != nil {
+		return err
+	}
+
+	return c.rebootInstance(ID, hard)
+}
+
 func (c *controller) createComputeRoutes(r *mux.Router) error {
 	legacyComputeRoutes(c, r)
 
@@ -15,7 +15,9 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -24,6 +26,7 @@ import (
 	"net/url"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -102,7 +105,9 @@ func testHTTPRequest(t *testing.T, method string, URL string, expectedResponse i
 }
 
 func testCreateServer(t *testing.T, n int) api.Servers {
-	tenant, err := ctl.ds.GetTenant(testutil.ComputeUser)
+	ctx := context.Background()
+
+	tenant, err := ctl.ds.GetTenant(ctx, testutil.ComputeUser)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -163,7 +168,9 @@ func TestCreateSingleServer(t *testing.T) {
 }
 
 func TestListServerDetailsTenant(t *testing.T) {
-	tenant, err := ctl.ds.GetTenant(testutil.ComputeUser)
+	ctx := context.Background()
+
+	tenant, err := ctl.ds.GetTenant(ctx, testutil.ComputeUser)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -181,7 +188,9 @@ func TestListServerDetailsTenant(t *testing.T) {
 }
 
 func testShowServerDetails(t *testing.T, httpExpectedStatus int, validToken bool) {
-	tenant, err := ctl.ds.GetTenant(testutil.ComputeUser)
+	ctx := context.Background()
+
+	tenant, err := ctl.ds.GetTenant(ctx, testutil.ComputeUser)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -226,7 +235,9 @@ func TestShowServerDetails(t *testing.T) {
 }
 
 func testDeleteServer(t *testing.T, httpExpectedStatus int, httpExpectedErrorStatus int, validToken bool) {
-	tenant, err := ctl.ds.GetTenant(testutil.ComputeUser)
+	ctx := context.Background()
+
+	tenant, err := ctl.ds.GetTenant(ctx, testutil.ComputeUser)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -272,7 +283,9 @@ func TestDeleteServer(t *testing.T) {
 }
 
 func testServersActionStart(t *testing.T, httpExpectedStatus int, validToken bool) {
-	tenant, err := ctl.ds.GetTenant(testutil.ComputeUser)
+	ctx := context.Background()
+
+	tenant, err := ctl.ds.GetTenant(ctx, testutil.ComputeUser)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -298,7 +311,7 @@ func testServersActionStart(t *testing.T, httpExpectedStatus int, validToken boo
 
 	serverCh := server.AddCmdChan(ssntp.DELETE)
 
-	err = ctl.stopInstance(servers.Servers[0].ID)
+	err = ctl.stopInstance(ctx, servers.Servers[0].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -334,7 +347,9 @@ func TestServersActionStart(t *testing.T) {
 }
 
 func testServersActionStop(t *testing.T, httpExpectedStatus int, action string) {
-	tenant, err := ctl.ds.GetTenant(testutil.ComputeUser)
+	ctx := context.Background()
+
+	tenant, err := ctl.ds.GetTenant(ctx, testutil.ComputeUser)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -383,9 +398,11 @@ func TestServersActionStopWrongAction(t *testing.T) {
 }
 
 func testServerActionStop(t *testing.T, httpExpectedStatus int, validToken bool) {
+	ctx := context.Background()
+
 	action := "os-stop"
 
-	tenant, err := ctl.ds.GetTenant(testutil.ComputeUser)
+	tenant, err := ctl.ds.GetTenant(ctx, testutil.ComputeUser)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -416,9 +433,11 @@ func TestServerActionStop(t *testing.T) {
 }
 
 func TestServerActionStart(t *testing.T) {
+	ctx := context.Background()
+
 	action := "os-start"
 
-	tenant, err := ctl.ds.GetTenant(testutil.ComputeUser)
+	tenant, err := ctl.ds.GetTenant(ctx, testutil.ComputeUser)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -442,7 +461,7 @@ func TestServerActionStart(t *testing.T) {
 
 	serverCh := server.AddCmdChan(ssntp.DELETE)
 
-	err = ctl.stopInstance(servers.Servers[0].ID)
+	err = ctl.stopInstance(ctx, servers.Servers[0].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -485,12 +504,14 @@ func sendStopEvent(client *testutil.SsntpTestClient, instanceUUID string) error
 }
 
 func testListTenantResources(t *testing.T, httpExpectedStatus int, validToken bool) {
+	ctx := context.Background()
+
 	var usage types.CiaoUsageHistory
 
 	endTime := time.Now()
 	startTime := endTime.Add(-15 * time.Minute)
 
-	tenant, err := ctl.ds.GetTenant(testutil.ComputeUser)
+	tenant, err := ctl.ds.GetTenant(ctx, testutil.ComputeUser)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -531,7 +552,9 @@ func TestListTenantResources(t *testing.T) {
 }
 
 func testListTenantQuotas(t *testing.T, httpExpectedStatus int, validToken bool) {
-	tenant, err := ctl.ds.GetTenant(testutil.ComputeUser)
+	ctx := context.Background()
+
+	tenant, err := ctl.ds.GetTenant(ctx, testutil.ComputeUser)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -590,7 +613,9 @@ func TestListTenantQuotas(t *testing.T) {
 }
 
 func testListEventsTenant(t *testing.T, httpExpectedStatus int, validToken bool) {
-	tenant, err := ctl.ds.GetTenant(testutil.ComputeUser)
+	ctx := context.Background()
+
+	tenant, err := ctl.ds.GetTenant(ctx, testutil.ComputeUser)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -599,7 +624,7 @@ func testListEventsTenant(t *testing.T, httpExpectedStatus int, validToken bool)
 
 	expected := types.NewCiaoEvents()
 
-	logs, err := ctl.ds.GetEventLog()
+	logs, err := ctl.ds.GetEventLog(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -636,6 +661,74 @@ func TestListEventsTenant(t *testing.T) {
 	testListEventsTenant(t, http.StatusOK, true)
 }
 
+func TestWatchEventsTenant(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := ctl.ds.GetTenant(ctx, testutil.ComputeUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/events/watch?type=error"
+
+	clientCertFile := "/etc/pki/ciao/auth-admin.pem"
+	cert, err := tls.LoadX509KeyPair(clientCertFile, clientCertFile)
+	if err != nil {
+		t.Fatalf("Unable to load client certiticate: %s", err)
+	}
+
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	}
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK ||
+		resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Unexpected event stream response %s %s", resp.Status,
+			resp.Header.Get("Content-Type"))
+	}
+
+	// only the last event matches the tenant and type of the stream.
+	_ = ctl.ds.LogEvent(ctx, tenant.ID, "watched info")
+	_ = ctl.ds.LogError(ctx, "other-tenant", "watched error")
+	_ = ctl.ds.LogError(ctx, tenant.ID, "watched error")
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), "data: ") {
+				lines <- strings.TrimPrefix(scanner.Text(), "data: ")
+			}
+		}
+		close(lines)
+	}()
+
+	var data string
+	select {
+	case data = <-lines:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for event")
+	}
+
+	var event types.CiaoEvent
+	err = json.Unmarshal([]byte(data), &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if event.TenantID != tenant.ID || event.EventType != "error" ||
+		event.Message != "watched error" {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+}
+
 func testListNodeServers(t *testing.T, httpExpectedStatus int, validToken bool) {
 	computeNodes := ctl.ds.GetNodeLastStats()
 
@@ -674,6 +767,48 @@ func TestListNodeServers(t *testing.T) {
 	testListNodeServers(t, http.StatusOK, true)
 }
 
+func TestListTenantServerStats(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := ctl.ds.GetTenant(ctx, testutil.ComputeUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instances, err := ctl.ds.GetAllInstancesFromTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/servers/stats"
+
+	body := testHTTPRequest(t, "GET", url, http.StatusOK, nil, true)
+
+	var result types.CiaoServersStats
+
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.TotalServers != len(result.Servers) {
+		t.Fatalf("Expected %d servers, got %d", result.TotalServers, len(result.Servers))
+	}
+
+	ids := make(map[string]bool)
+	for _, i := range instances {
+		if !i.CNCI {
+			ids[i.ID] = true
+		}
+	}
+
+	for _, s := range result.Servers {
+		if s.TenantID != tenant.ID || !ids[s.ID] {
+			t.Fatalf("Unexpected server stats %+v", s)
+		}
+	}
+}
+
 func testListNodes(t *testing.T, httpExpectedStatus int, validToken bool) {
 	expected := ctl.ds.GetNodeLastStats()
 
@@ -696,6 +831,15 @@ func testListNodes(t *testing.T, httpExpectedStatus int, validToken bool) {
 		}
 	}
 
+	vcpus, err := nodeVCPUsAllocated(ctl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range expected.Nodes {
+		expected.Nodes[i].VCPUsAllocated = vcpus[expected.Nodes[i].ID]
+	}
+
 	sort.Sort(types.SortedNodesByID(expected.Nodes))
 
 	url := testutil.ComputeURL + "/v2.1/nodes"
@@ -847,6 +991,8 @@ func TestListCNCIDetails(t *testing.T) {
 }
 
 func testListTraces(t *testing.T, httpExpectedStatus int, validToken bool) {
+	ctx := context.Background()
+
 	var expected types.CiaoTracesSummary
 
 	client := testStartTracedWorkload(t)
@@ -856,7 +1002,7 @@ func testListTraces(t *testing.T, httpExpectedStatus int, validToken bool) {
 
 	time.Sleep(2 * time.Second)
 
-	summaries, err := ctl.ds.GetBatchFrameSummary()
+	summaries, err := ctl.ds.GetBatchFrameSummary(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -894,11 +1040,13 @@ func TestListTraces(t *testing.T) {
 }
 
 func testListEvents(t *testing.T, httpExpectedStatus int, validToken bool) {
+	ctx := context.Background()
+
 	url := testutil.ComputeURL + "/v2.1/events"
 
 	expected := types.NewCiaoEvents()
 
-	logs, err := ctl.ds.GetEventLog()
+	logs, err := ctl.ds.GetEventLog(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -936,6 +1084,8 @@ func TestListEvents(t *testing.T) {
 }
 
 func testClearEvents(t *testing.T, httpExpectedStatus int, validToken bool) {
+	ctx := context.Background()
+
 	url := testutil.ComputeURL + "/v2.1/events"
 
 	_ = testHTTPRequest(t, "DELETE", url, httpExpectedStatus, nil, validToken)
@@ -944,7 +1094,7 @@ func testClearEvents(t *testing.T, httpExpectedStatus int, validToken bool) {
 		return
 	}
 
-	logs, err := ctl.ds.GetEventLog()
+	logs, err := ctl.ds.GetEventLog(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -959,6 +1109,8 @@ func TestClearEvents(t *testing.T) {
 }
 
 func testTraceData(t *testing.T, httpExpectedStatus int, validToken bool) {
+	ctx := context.Background()
+
 	client := testStartTracedWorkload(t)
 	defer client.Shutdown()
 
@@ -966,7 +1118,7 @@ func testTraceData(t *testing.T, httpExpectedStatus int, validToken bool) {
 
 	time.Sleep(2 * time.Second)
 
-	summaries, err := ctl.ds.GetBatchFrameSummary()
+	summaries, err := ctl.ds.GetBatchFrameSummary(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -974,7 +1126,7 @@ func testTraceData(t *testing.T, httpExpectedStatus int, validToken bool) {
 	for _, s := range summaries {
 		var expected types.CiaoTraceData
 
-		batchStats, err := ctl.ds.GetBatchFrameStatistics(s.BatchID)
+		batchStats, err := ctl.ds.GetBatchFrameStatistics(ctx, s.BatchID)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -1015,3 +1167,81 @@ func testTraceData(t *testing.T, httpExpectedStatus int, validToken bool) {
 func TestTraceData(t *testing.T) {
 	testTraceData(t, http.StatusOK, true)
 }
+
+func getTracesSummary(t *testing.T) types.CiaoTracesSummary {
+	var result types.CiaoTracesSummary
+
+	url := testutil.ComputeURL + "/v2.1/traces"
+	body := testHTTPRequest(t, "GET", url, http.StatusOK, nil, true)
+
+	err := json.Unmarshal(body, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+func TestStartStopTrace(t *testing.T) {
+	url := testutil.ComputeURL + "/v2.1/traces/active"
+
+	for _, label := range []string{"", "active"} {
+		req := []byte(fmt.Sprintf(`{"label": "%s"}`, label))
+		_ = testHTTPRequest(t, "PUT", url, http.StatusBadRequest, req, true)
+	}
+
+	req := []byte(`{"label": "clustertrace"}`)
+	_ = testHTTPRequest(t, "PUT", url, http.StatusNoContent, req, true)
+	defer ctl.stopTracing()
+
+	result := getTracesSummary(t)
+	if result.Active != "clustertrace" {
+		t.Fatalf("Expected active trace label clustertrace, got %q", result.Active)
+	}
+
+	_ = testHTTPRequest(t, "DELETE", url, http.StatusNoContent, nil, true)
+
+	result = getTracesSummary(t)
+	if result.Active != "" {
+		t.Fatalf("Expected no active trace label, got %q", result.Active)
+	}
+}
+
+func TestDeleteTrace(t *testing.T) {
+	ctx := context.Background()
+
+	now := time.Now().Format(time.RFC3339Nano)
+	trace := payloads.Trace{
+		Frames: []payloads.FrameTrace{
+			{
+				Label:          "deletetrace",
+				Type:           "START",
+				Operand:        "operand",
+				StartTimestamp: now,
+				EndTimestamp:   now,
+				Nodes: []payloads.SSNTPNode{
+					{
+						SSNTPUUID:   testutil.AgentUUID,
+						SSNTPRole:   "AGENT",
+						TxTimestamp: now,
+						RxTimestamp: now,
+					},
+				},
+			},
+		},
+	}
+
+	err := ctl.ds.HandleTraceReport(ctx, trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := testutil.ComputeURL + "/v2.1/traces/deletetrace"
+	_ = testHTTPRequest(t, "DELETE", url, http.StatusAccepted, nil, true)
+
+	for _, s := range getTracesSummary(t).Summaries {
+		if s.Label == "deletetrace" {
+			t.Fatal("Trace data not deleted")
+		}
+	}
+}
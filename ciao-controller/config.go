@@ -0,0 +1,239 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"net/url"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/configuration"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+var configURI = flag.String("configuration_uri", "file:///etc/ciao/configuration.yaml", "Cluster configuration reloaded on SIGHUP and when it changes")
+var configWatchInterval = flag.Duration("configuration_watch_interval", 10*time.Second, "Interval between checks for changes of the cluster configuration, 0 to only reload it on SIGHUP")
+
+// clusterConfig is the cluster configuration the controller runs with.
+// The version is incremented every time a new configuration is applied.
+type clusterConfig struct {
+	sync.Mutex
+	conf        payloads.Configure
+	version     int
+	loaded      time.Time
+	reloadError string
+}
+
+func (cc *clusterConfig) set(conf payloads.Configure, now time.Time) {
+	cc.conf = conf
+	cc.version++
+	cc.loaded = now
+	cc.reloadError = ""
+}
+
+// validateClusterConfig checks the settings of a cluster configuration the
+// controller uses, whether they can be reloaded or not, so that a broken
+// configuration is rejected as a whole.
+func validateClusterConfig(conf payloads.Configure) error {
+	controller := conf.Configure.Controller
+
+	if controller.CNCIVcpus <= 0 || controller.CNCIMem <= 0 || controller.CNCIDisk < 0 {
+		return errors.New("Invalid CNCI sizing")
+	}
+
+	if controller.CNCINet != "" {
+		var net cnciNetFlag
+		if err := net.Set(controller.CNCINet); err != nil {
+			return err
+		}
+	}
+
+	for _, sink := range controller.EventSinks {
+		if err := validateEventSink(sink); err != nil {
+			return err
+		}
+	}
+
+	_, err := newHTTPServerConfig(controller)
+	return err
+}
+
+// restartRequired returns whether two cluster configurations differ in
+// settings of the controller which are only applied when it starts.
+func restartRequired(a payloads.Configure, b payloads.Configure) bool {
+	for _, conf := range []*payloads.Configure{&a, &b} {
+		conf.Configure.Controller.CNCIVcpus = 0
+		conf.Configure.Controller.CNCIMem = 0
+		conf.Configure.Controller.CNCIDisk = 0
+		conf.Configure.Controller.AdminSSHKey = ""
+	}
+
+	return !reflect.DeepEqual(a.Configure.Controller, b.Configure.Controller) ||
+		!reflect.DeepEqual(a.Configure.Storage, b.Configure.Storage)
+}
+
+// reloadConfiguration reads the cluster configuration again and applies
+// the settings which can be changed at runtime, i.e., the sizing and the
+// admin SSH key of the CNCIs.  The configuration is validated first and
+// the current one is kept if it is invalid.  A new configuration is sent
+// to the scheduler, for the SSNTP clients connecting to it to be
+// configured with it.
+func (c *controller) reloadConfiguration() error {
+	c.config.Lock()
+	defer c.config.Unlock()
+
+	blob, conf, err := loadClusterConfig(*configURI)
+	if err != nil {
+		c.config.reloadError = err.Error()
+		return err
+	}
+
+	if reflect.DeepEqual(conf, c.config.conf) {
+		glog.V(1).Infof("Cluster configuration %s unchanged", *configURI)
+		c.config.reloadError = ""
+		return nil
+	}
+
+	if restartRequired(c.config.conf, conf) {
+		glog.Warningf("Some cluster configuration changes only apply when ciao-controller restarts")
+	}
+
+	controller := conf.Configure.Controller
+	c.ds.GenerateCNCIWorkload(controller.CNCIVcpus, controller.CNCIMem, controller.CNCIDisk, controller.AdminSSHKey)
+
+	c.config.set(conf, time.Now())
+	glog.Infof("Cluster configuration version %d loaded from %s", c.config.version, *configURI)
+
+	if c.client != nil {
+		if err := c.client.SendConfiguration(blob); err != nil {
+			glog.Warningf("Unable to send cluster configuration to the scheduler: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func loadClusterConfig(uri string) ([]byte, payloads.Configure, error) {
+	var conf payloads.Configure
+
+	blob, err := configuration.ExtractBlob(uri)
+	if err != nil {
+		return nil, conf, errors.Wrap(err, "Unable to read cluster configuration")
+	}
+
+	conf, err = configuration.Payload(blob)
+	if err != nil {
+		return nil, conf, errors.Wrap(err, "Unable to parse cluster configuration")
+	}
+
+	err = validateClusterConfig(conf)
+	if err != nil {
+		return nil, conf, errors.Wrap(err, "Invalid cluster configuration")
+	}
+
+	return blob, conf, nil
+}
+
+// configModTime returns the modification time of the cluster configuration
+// file, or the zero time when it cannot be watched.
+func configModTime(uri string) time.Time {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return time.Time{}
+	}
+
+	fi, err := os.Stat(u.Path)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return fi.ModTime()
+}
+
+// configWatcher reloads the cluster configuration on SIGHUP and when the
+// configuration file is modified.
+func (c *controller) configWatcher(stop <-chan struct{}, hup <-chan os.Signal) {
+	var tick <-chan time.Time
+	if *configWatchInterval > 0 {
+		ticker := time.NewTicker(*configWatchInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	modTime := configModTime(*configURI)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-hup:
+			glog.Info("Reloading cluster configuration on SIGHUP")
+		case <-tick:
+			t := configModTime(*configURI)
+			if t.Equal(modTime) {
+				continue
+			}
+			modTime = t
+		}
+
+		if err := c.reloadConfiguration(); err != nil {
+			glog.Errorf("Keeping cluster configuration version %d: %v", c.configVersion(), err)
+		}
+	}
+}
+
+// configVersion returns the version of the cluster configuration the
+// controller runs with.
+func (c *controller) configVersion() int {
+	c.config.Lock()
+	defer c.config.Unlock()
+
+	return c.config.version
+}
+
+// ShowCluster returns summary information about the cluster and the
+// cluster configuration.
+func (c *controller) ShowCluster(ctx context.Context) (types.ClusterSummary, error) {
+	var summary types.ClusterSummary
+
+	summary.Nodes = len(c.ds.GetNodeLastStats().Nodes)
+
+	tenants, err := c.ds.GetAllTenants()
+	if err != nil {
+		return summary, err
+	}
+	summary.Tenants = len(tenants)
+
+	instances, err := c.ds.GetAllInstances()
+	if err != nil {
+		return summary, err
+	}
+	summary.Instances = len(instances)
+
+	c.config.Lock()
+	summary.ConfigVersion = c.config.version
+	summary.ConfigLoaded = c.config.loaded
+	summary.ConfigReloadError = c.config.reloadError
+	c.config.Unlock()
+
+	return summary, nil
+}
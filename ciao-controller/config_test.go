@@ -0,0 +1,126 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ciao-project/ciao/ssntp"
+)
+
+const testClusterConfig = `configure:
+  scheduler:
+    storage_uri: /etc/ciao/configuration.yaml
+  storage:
+    ceph_id: ciao
+  controller:
+    compute_ca: /etc/pki/ciao/compute_ca.pem
+    compute_cert: /etc/pki/ciao/compute_key.pem
+    client_auth_ca_cert_path: /etc/pki/ciao/auth-CA.pem
+    cnci_vcpus: %d
+    cnci_mem: 256
+  launcher:
+    compute_net:
+    - 192.168.1.0/24
+    mgmt_net:
+    - 192.168.1.0/24
+`
+
+func TestReloadConfiguration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "controller-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "configuration.yaml")
+	err = ioutil.WriteFile(path, []byte(fmt.Sprintf(testClusterConfig, 8)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	savedURI := *configURI
+	*configURI = "file://" + path
+	defer func() {
+		*configURI = savedURI
+		ctl.ds.GenerateCNCIWorkload(4, 128, 128, "")
+	}()
+
+	cnciID, err := ctl.ds.GetCNCIWorkloadID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version := ctl.configVersion()
+
+	serverCh := server.AddCmdChan(ssntp.CONFIGURE)
+
+	err = ctl.reloadConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = server.GetCmdChanResult(serverCh, ssntp.CONFIGURE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wl, err := ctl.ds.GetWorkload(cnciID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wl.Requirements.VCPUs != 8 || wl.Requirements.MemMB != 256 {
+		t.Fatalf("CNCI workload not resized: %+v", wl.Requirements)
+	}
+
+	if ctl.configVersion() != version+1 {
+		t.Fatalf("Expected configuration version %d, got %d", version+1, ctl.configVersion())
+	}
+
+	// an invalid configuration is rejected and the current one kept.
+	err = ioutil.WriteFile(path, []byte(fmt.Sprintf(testClusterConfig, -1)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.reloadConfiguration()
+	if err == nil {
+		t.Fatal("Invalid configuration applied")
+	}
+
+	wl, err = ctl.ds.GetWorkload(cnciID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wl.Requirements.VCPUs != 8 {
+		t.Fatalf("CNCI workload changed by invalid configuration: %+v", wl.Requirements)
+	}
+
+	summary, err := ctl.ShowCluster(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.ConfigVersion != version+1 || summary.ConfigReloadError == "" {
+		t.Fatalf("Unexpected cluster summary %+v", summary)
+	}
+}
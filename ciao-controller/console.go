@@ -0,0 +1,234 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+var consoleTimeout = flag.Duration("console_session_timeout", 5*time.Minute, "Time after which emergency serial console sessions are closed")
+
+const (
+	// consoleInputLimit is the maximum size of the input written to a
+	// console in a single exchange.
+	consoleInputLimit = 1024
+
+	// consoleOutputLimit is the maximum size of the output buffered for
+	// a session between two exchanges.  Older output is dropped.
+	consoleOutputLimit = 64 * 1024
+)
+
+// consoleSession is an emergency serial console session.  The console is
+// reached through the scheduler and the launcher running the instance, so
+// it remains available when the network of the instance is broken.
+type consoleSession struct {
+	types.ConsoleSession
+	tenantID string
+	output   []byte
+	timer    *time.Timer
+}
+
+// OpenConsole opens a console session with a running instance.  VMs are
+// reached through their serial console and containers through their
+// terminal, which they only have when their workload asks for a console.
+// Instances have a single console session at a time, closed after
+// console_session_timeout.
+func (c *controller) OpenConsole(ctx context.Context, instanceID string) (types.ConsoleSession, error) {
+	i, err := c.ds.GetInstance(instanceID)
+	if err != nil {
+		return types.ConsoleSession{}, err
+	}
+
+	wl, err := c.ds.GetWorkload(i.WorkloadID)
+	if err != nil {
+		return types.ConsoleSession{}, err
+	}
+
+	if wl.VMType == payloads.Docker && !wl.Requirements.Console {
+		return types.ConsoleSession{}, types.ErrNoConsole
+	}
+
+	i.StateLock.RLock()
+	state := i.State
+	nodeID := i.NodeID
+	i.StateLock.RUnlock()
+
+	if nodeID == "" {
+		return types.ConsoleSession{}, types.ErrInstanceNotAssigned
+	}
+
+	if state != payloads.Running {
+		return types.ConsoleSession{}, types.ErrInstanceNotRunning
+	}
+
+	s := &consoleSession{
+		ConsoleSession: types.ConsoleSession{
+			ID:         uuid.Generate().String(),
+			InstanceID: i.ID,
+			NodeID:     nodeID,
+			ExpireTime: time.Now().Add(*consoleTimeout),
+		},
+		tenantID: i.TenantID,
+	}
+
+	c.consoleSessionsLock.Lock()
+	for _, cs := range c.consoleSessions {
+		if cs.InstanceID == i.ID && !cs.Closed {
+			c.consoleSessionsLock.Unlock()
+			return types.ConsoleSession{}, types.ErrConsoleInUse
+		}
+	}
+
+	if c.consoleSessions == nil {
+		c.consoleSessions = make(map[string]*consoleSession)
+	}
+	c.consoleSessions[s.ID] = s
+
+	ID := s.ID
+	s.timer = time.AfterFunc(*consoleTimeout, func() {
+		if err := c.closeConsole(context.Background(), ID, "timed out"); err != nil && err != types.ErrConsoleSessionNotFound {
+			glog.Warningf("Error closing console session %s: %v", ID, err)
+		}
+	})
+	session := s.ConsoleSession
+	c.consoleSessionsLock.Unlock()
+
+	err = c.client.ConsoleCommand(c.consoleCmd(s, payloads.ConsoleOpen, ""))
+	if err != nil {
+		c.consoleSessionsLock.Lock()
+		s.timer.Stop()
+		delete(c.consoleSessions, s.ID)
+		c.consoleSessionsLock.Unlock()
+		return types.ConsoleSession{}, errors.Wrap(err, "Error opening console")
+	}
+
+	msg := fmt.Sprintf("Console session %s opened on instance %s", s.ID, i.ID)
+	_ = c.ds.LogEvent(ctx, s.tenantID, msg)
+
+	return session, nil
+}
+
+// WriteConsole writes input to the console of a session and returns the
+// output read from the console since the previous exchange.  Sessions
+// closed by the launcher are forgotten once their output has been read.
+func (c *controller) WriteConsole(ctx context.Context, ID string, input string) (types.ConsoleSession, error) {
+	if len(input) > consoleInputLimit {
+		return types.ConsoleSession{}, types.ErrBadRequest
+	}
+
+	c.consoleSessionsLock.Lock()
+	s, ok := c.consoleSessions[ID]
+	if !ok {
+		c.consoleSessionsLock.Unlock()
+		return types.ConsoleSession{}, types.ErrConsoleSessionNotFound
+	}
+	closed := s.Closed
+	c.consoleSessionsLock.Unlock()
+
+	if input != "" && !closed {
+		glog.Infof("Console session %s: writing %d bytes to instance %s", ID, len(input), s.InstanceID)
+
+		err := c.client.ConsoleCommand(c.consoleCmd(s, payloads.ConsoleInput, input))
+		if err != nil {
+			return types.ConsoleSession{}, errors.Wrap(err, "Error writing to console")
+		}
+	}
+
+	c.consoleSessionsLock.Lock()
+	defer c.consoleSessionsLock.Unlock()
+
+	session := s.ConsoleSession
+	session.Output = string(s.output)
+	s.output = nil
+
+	if s.Closed {
+		s.timer.Stop()
+		delete(c.consoleSessions, ID)
+	}
+
+	return session, nil
+}
+
+// CloseConsole closes a console session.
+func (c *controller) CloseConsole(ctx context.Context, ID string) error {
+	return c.closeConsole(ctx, ID, "closed")
+}
+
+func (c *controller) closeConsole(ctx context.Context, ID string, reason string) error {
+	c.consoleSessionsLock.Lock()
+	s, ok := c.consoleSessions[ID]
+	if !ok {
+		c.consoleSessionsLock.Unlock()
+		return types.ErrConsoleSessionNotFound
+	}
+	delete(c.consoleSessions, ID)
+	s.timer.Stop()
+	closed := s.Closed
+	c.consoleSessionsLock.Unlock()
+
+	if closed {
+		return nil
+	}
+
+	msg := fmt.Sprintf("Console session %s on instance %s %s", ID, s.InstanceID, reason)
+	_ = c.ds.LogEvent(ctx, s.tenantID, msg)
+
+	return c.client.ConsoleCommand(c.consoleCmd(s, payloads.ConsoleClose, ""))
+}
+
+func (c *controller) consoleCmd(s *consoleSession, action payloads.ConsoleAction, input string) payloads.ConsoleCmd {
+	return payloads.ConsoleCmd{
+		InstanceUUID:      s.InstanceID,
+		WorkloadAgentUUID: s.NodeID,
+		SessionUUID:       s.ID,
+		Action:            action,
+		Input:             input,
+	}
+}
+
+// consoleOutput buffers the output of a console session until it is read.
+func (c *controller) consoleOutput(ctx context.Context, event payloads.ConsoleOutputEvent) {
+	c.consoleSessionsLock.Lock()
+	s, ok := c.consoleSessions[event.SessionUUID]
+	if !ok || s.InstanceID != event.InstanceUUID || s.Closed {
+		c.consoleSessionsLock.Unlock()
+		return
+	}
+
+	s.output = append(s.output, event.Output...)
+	if len(s.output) > consoleOutputLimit {
+		s.output = s.output[len(s.output)-consoleOutputLimit:]
+	}
+
+	if event.Closed {
+		s.Closed = true
+	}
+	c.consoleSessionsLock.Unlock()
+
+	if event.Closed {
+		msg := fmt.Sprintf("Console session %s on instance %s closed by the launcher",
+			s.ID, s.InstanceID)
+		_ = c.ds.LogEvent(ctx, s.tenantID, msg)
+	}
+}
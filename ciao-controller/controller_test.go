@@ -17,14 +17,25 @@
 package main
 
 import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -34,14 +45,18 @@ import (
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/ciao-project/ciao/ciao-controller/utils"
 	"github.com/ciao-project/ciao/ciao-storage"
+	"github.com/ciao-project/ciao/client"
 	"github.com/ciao-project/ciao/payloads"
 	"github.com/ciao-project/ciao/ssntp"
 	"github.com/ciao-project/ciao/testutil"
 	"github.com/ciao-project/ciao/uuid"
 	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
 )
 
-func addTestWorkload(tenantID string) error {
+func addTestWorkload(ctx context.Context, tenantID string) error {
 	testConfig := `
 ---
 #cloud-config
@@ -71,10 +86,10 @@ users:
 		Storage: nil,
 	}
 
-	return ctl.ds.AddWorkload(wl)
+	return ctl.ds.AddWorkload(ctx, wl)
 }
 
-func addFakeCNCI(tenant *types.Tenant) (*types.Instance, error) {
+func addFakeCNCI(ctx context.Context, tenant *types.Tenant) (*types.Instance, error) {
 	mac, err := utils.NewHardwareAddr()
 	if err != nil {
 		return nil, err
@@ -91,10 +106,10 @@ func addFakeCNCI(tenant *types.Tenant) (*types.Instance, error) {
 		Subnet:     "172.16.0.0/24",
 	}
 
-	return &CNCI, ctl.ds.AddInstance(&CNCI)
+	return &CNCI, ctl.ds.AddInstance(ctx, &CNCI)
 }
 
-func addTestTenant() (tenant *types.Tenant, err error) {
+func addTestTenant(ctx context.Context) (tenant *types.Tenant, err error) {
 	/* add a new tenant */
 	tuuid := uuid.Generate()
 
@@ -103,28 +118,28 @@ func addTestTenant() (tenant *types.Tenant, err error) {
 		SubnetBits: 24,
 	}
 
-	tenant, err = ctl.ds.AddTenant(tuuid.String(), config)
+	tenant, err = ctl.ds.AddTenant(ctx, tuuid.String(), config)
 	if err != nil {
 		return
 	}
 
-	_, err = addFakeCNCI(tenant)
+	_, err = addFakeCNCI(ctx, tenant)
 	if err != nil {
 		return
 	}
 
-	tenant.CNCIctrl, err = newCNCIManager(ctl, tenant.ID)
+	err = ctl.prepareTenant(ctx, tenant.ID)
 	if err != nil {
 		return
 	}
 
 	// give this tenant a workload to run.
-	err = addTestWorkload(tenant.ID)
+	err = addTestWorkload(ctx, tenant.ID)
 
 	return
 }
 
-func addTestTenantNoCNCI() (tenant *types.Tenant, err error) {
+func addTestTenantNoCNCI(ctx context.Context) (tenant *types.Tenant, err error) {
 	/* add a new tenant */
 	tuuid := uuid.Generate()
 
@@ -133,53 +148,55 @@ func addTestTenantNoCNCI() (tenant *types.Tenant, err error) {
 		SubnetBits: 24,
 	}
 
-	tenant, err = ctl.ds.AddTenant(tuuid.String(), config)
+	tenant, err = ctl.ds.AddTenant(ctx, tuuid.String(), config)
 	if err != nil {
 		return
 	}
 
-	tenant.CNCIctrl, err = newCNCIManager(ctl, tenant.ID)
+	err = ctl.prepareTenant(ctx, tenant.ID)
 	if err != nil {
 		return
 	}
 
 	// give this tenant a workload to run.
-	err = addTestWorkload(tenant.ID)
+	err = addTestWorkload(ctx, tenant.ID)
 
 	return
 }
 
-func addComputeTestTenant() (tenant *types.Tenant, err error) {
+func addComputeTestTenant(ctx context.Context) (tenant *types.Tenant, err error) {
 	/* add a new tenant */
 	config := types.TenantConfig{
 		Name:       "compute test tenant",
 		SubnetBits: 24,
 	}
 
-	tenant, err = ctl.ds.AddTenant(testutil.ComputeUser, config)
+	tenant, err = ctl.ds.AddTenant(ctx, testutil.ComputeUser, config)
 	if err != nil {
 		return
 	}
 
-	_, err = addFakeCNCI(tenant)
+	_, err = addFakeCNCI(ctx, tenant)
 	if err != nil {
 		return
 	}
 
-	tenant.CNCIctrl, err = newCNCIManager(ctl, tenant.ID)
+	err = ctl.prepareTenant(ctx, tenant.ID)
 	if err != nil {
 		return
 	}
 
-	err = addTestWorkload(tenant.ID)
+	err = addTestWorkload(ctx, tenant.ID)
 
 	return
 }
 
 func BenchmarkStartSingleWorkload(b *testing.B) {
+	ctx := context.Background()
+
 	var err error
 
-	tenant, err := addTestTenant()
+	tenant, err := addTestTenant(ctx)
 	if err != nil {
 		b.Error(err)
 	}
@@ -197,7 +214,7 @@ func BenchmarkStartSingleWorkload(b *testing.B) {
 			TenantID:   tenant.ID,
 			Instances:  1,
 		}
-		_, err = ctl.startWorkload(w)
+		_, err = ctl.startWorkload(ctx, w)
 		if err != nil {
 			b.Error(err)
 		}
@@ -205,9 +222,11 @@ func BenchmarkStartSingleWorkload(b *testing.B) {
 }
 
 func BenchmarkStart1000Workload(b *testing.B) {
+	ctx := context.Background()
+
 	var err error
 
-	tenant, err := addTestTenant()
+	tenant, err := addTestTenant(ctx)
 	if err != nil {
 		b.Error(err)
 	}
@@ -225,7 +244,7 @@ func BenchmarkStart1000Workload(b *testing.B) {
 			TenantID:   tenant.ID,
 			Instances:  1000,
 		}
-		_, err = ctl.startWorkload(w)
+		_, err = ctl.startWorkload(ctx, w)
 		if err != nil {
 			b.Error(err)
 		}
@@ -233,9 +252,11 @@ func BenchmarkStart1000Workload(b *testing.B) {
 }
 
 func BenchmarkNewConfig(b *testing.B) {
+	ctx := context.Background()
+
 	var err error
 
-	tenant, err := addTestTenant()
+	tenant, err := addTestTenant(ctx)
 	if err != nil {
 		b.Error(err)
 	}
@@ -252,7 +273,7 @@ func BenchmarkNewConfig(b *testing.B) {
 
 	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
-		_, err := newConfig(ctl, &wls[0], id.String(), tenant.ID, fmt.Sprintf("test-%d", n), ip)
+		_, err := newConfig(ctx, ctl, &wls[0], id.String(), tenant.ID, fmt.Sprintf("test-%d", n), ip, false)
 		if err != nil {
 			b.Error(err)
 		}
@@ -260,9 +281,11 @@ func BenchmarkNewConfig(b *testing.B) {
 }
 
 func TestTenantWithinBounds(t *testing.T) {
+	ctx := context.Background()
+
 	var err error
 
-	tenant, err := addTestTenant()
+	tenant, err := addTestTenant(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -283,7 +306,7 @@ func TestTenantWithinBounds(t *testing.T) {
 		TenantID:   tenant.ID,
 		Instances:  1,
 	}
-	_, err = ctl.startWorkload(w)
+	_, err = ctl.startWorkload(ctx, w)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -294,10 +317,12 @@ func TestTenantWithinBounds(t *testing.T) {
 }
 
 func TestTenantOutOfBounds(t *testing.T) {
+	ctx := context.Background()
+
 	var err error
 
 	/* add a new tenant */
-	tenant, err := addTestTenant()
+	tenant, err := addTestTenant(ctx)
 	if err != nil {
 		t.Error(err)
 	}
@@ -319,7 +344,7 @@ func TestTenantOutOfBounds(t *testing.T) {
 		TenantID:   tenant.ID,
 		Instances:  2,
 	}
-	_, err = ctl.startWorkload(w)
+	_, err = ctl.startWorkload(ctx, w)
 	if err == nil {
 		t.Errorf("Not tracking limits correctly")
 	}
@@ -337,6 +362,8 @@ func TestStartWorkload(t *testing.T) {
 }
 
 func TestNamedWorkload(t *testing.T) {
+	ctx := context.Background()
+
 	var reason payloads.StartFailureReason
 
 	client, instances := testStartWorkload(t, 1, false, reason)
@@ -346,7 +373,7 @@ func TestNamedWorkload(t *testing.T) {
 		t.Errorf("Expected one instance created")
 	}
 
-	sds, err := ctl.ListServersDetail(instances[0].TenantID)
+	sds, err := ctl.ListServersDetail(ctx, instances[0].TenantID)
 	if err != nil {
 		t.Error(err)
 	}
@@ -360,6 +387,63 @@ func TestNamedWorkload(t *testing.T) {
 	}
 }
 
+func TestUniqueInstanceNames(t *testing.T) {
+	ctx := context.Background()
+
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	tenantID := instances[0].TenantID
+
+	s, err := ctl.ShowServerByName(ctx, tenantID, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Server.ID != instances[0].ID {
+		t.Fatalf("Expected instance %s, got %s", instances[0].ID, s.Server.ID)
+	}
+
+	_, err = ctl.ShowServerByName(ctx, tenantID, "unknown")
+	if err != types.ErrInstanceNotFound {
+		t.Fatalf("Expected ErrInstanceNotFound, got %v", err)
+	}
+
+	ctl.uniqueNames = true
+	defer func() { ctl.uniqueNames = false }()
+
+	w := types.WorkloadRequest{
+		WorkloadID: instances[0].WorkloadID,
+		TenantID:   tenantID,
+		Instances:  1,
+		Name:       "test",
+	}
+	_, err = ctl.startWorkload(ctx, w)
+	if nameErr, ok := err.(types.NameConflictError); !ok || nameErr.ID != instances[0].ID {
+		t.Fatalf("Expected name conflict with %s, got %v", instances[0].ID, err)
+	}
+
+	release, err := ctl.reserveNames(instanceResource, tenantID, "test-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ctl.reserveNames(instanceResource, tenantID, "other-0", "test-1")
+	if _, ok := err.(types.NameConflictError); !ok {
+		t.Fatalf("Expected name conflict with pending instance, got %v", err)
+	}
+
+	release()
+
+	release, err = ctl.reserveNames(instanceResource, tenantID, "other-0", "test-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
 func TestStartTracedWorkload(t *testing.T) {
 	client := testStartTracedWorkload(t)
 	defer client.Shutdown()
@@ -402,6 +486,8 @@ func sendStatsCmd(client *testutil.SsntpTestClient, t *testing.T) {
 // network node and test that way.
 
 func TestDeleteInstance(t *testing.T) {
+	ctx := context.Background()
+
 	var reason payloads.StartFailureReason
 
 	client, instances := testStartWorkload(t, 1, false, reason)
@@ -411,7 +497,7 @@ func TestDeleteInstance(t *testing.T) {
 
 	serverCh := server.AddCmdChan(ssntp.DELETE)
 
-	err := ctl.deleteInstance(instances[0].ID)
+	err := ctl.deleteInstance(ctx, instances[0].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -425,7 +511,9 @@ func TestDeleteInstance(t *testing.T) {
 	}
 }
 
-func TestStopInstance(t *testing.T) {
+func TestRecycleBinInstance(t *testing.T) {
+	ctx := context.Background()
+
 	var reason payloads.StartFailureReason
 
 	client, instances := testStartWorkload(t, 1, false, reason)
@@ -433,9 +521,16 @@ func TestStopInstance(t *testing.T) {
 
 	sendStatsCmd(client, t)
 
+	ctl.deletedRetention = time.Hour
+	defer func() { ctl.deletedRetention = 0 }()
+
+	tenantID := instances[0].TenantID
+	instanceID := instances[0].ID
+
+	// the instance is stopped rather than deleted.
 	serverCh := server.AddCmdChan(ssntp.DELETE)
 
-	err := ctl.stopInstance(instances[0].ID)
+	err := ctl.DeleteServer(ctx, tenantID, instanceID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -444,12 +539,60 @@ func TestStopInstance(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if result.InstanceUUID != instances[0].ID {
+	if result.InstanceUUID != instanceID {
+		t.Fatal("Did not get correct Instance ID")
+	}
+
+	_, err = ctl.ShowServerDetails(ctx, tenantID, instanceID)
+	if err == nil {
+		t.Fatal("Deleted instance should not be shown")
+	}
+
+	deleted, err := ctl.ListDeletedResources(ctx, tenantID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0].ID != instanceID || deleted[0].Type != types.DeletedInstance {
+		t.Fatalf("Expected instance %s in the recycle bin, got %v", instanceID, deleted)
+	}
+
+	err = ctl.RestoreDeletedResource(ctx, tenantID, instanceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ctl.ShowServerDetails(ctx, tenantID, instanceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.RestoreDeletedResource(ctx, tenantID, instanceID)
+	if err != types.ErrNotInRecycleBin {
+		t.Fatalf("Expected ErrNotInRecycleBin, got %v", err)
+	}
+
+	// the instance is only purged once its retention time has elapsed.
+	err = ctl.ds.SetInstanceDeleteTime(ctx, instanceID, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverCh = server.AddCmdChan(ssntp.DELETE)
+
+	ctl.purgeDeleted(ctx, time.Now().Add(2*time.Hour))
+
+	result, err = server.GetCmdChanResult(serverCh, ssntp.DELETE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.InstanceUUID != instanceID {
 		t.Fatal("Did not get correct Instance ID")
 	}
 }
 
-func TestRestartInstance(t *testing.T) {
+func TestStopInstance(t *testing.T) {
+	ctx := context.Background()
+
 	var reason payloads.StartFailureReason
 
 	client, instances := testStartWorkload(t, 1, false, reason)
@@ -458,9 +601,8 @@ func TestRestartInstance(t *testing.T) {
 	sendStatsCmd(client, t)
 
 	serverCh := server.AddCmdChan(ssntp.DELETE)
-	clientCh := client.AddCmdChan(ssntp.DELETE)
 
-	err := ctl.stopInstance(instances[0].ID)
+	err := ctl.stopInstance(ctx, instances[0].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -469,327 +611,449 @@ func TestRestartInstance(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = client.GetCmdChanResult(clientCh, ssntp.DELETE)
-	if err != nil {
-		t.Fatal(err)
-	}
 	if result.InstanceUUID != instances[0].ID {
 		t.Fatal("Did not get correct Instance ID")
 	}
+}
 
-	err = sendStopEvent(client, instances[0].ID)
-	if err != nil {
-		t.Fatal(err)
+func TestNextSequence(t *testing.T) {
+	client := &ssntpClient{}
+
+	last := client.nextSequence()
+	for i := 0; i < 100; i++ {
+		next := client.nextSequence()
+		if next <= last {
+			t.Fatalf("Sequence %d not greater than %d", next, last)
+		}
+		last = next
 	}
 
-	serverCh = server.AddCmdChan(ssntp.START)
+	future := uint64(time.Now().Add(time.Hour).UnixNano())
+	client.sequence = future
+	if next := client.nextSequence(); next != future+1 {
+		t.Errorf("Expected sequence %d, got %d", future+1, next)
+	}
+}
 
-	err = ctl.restartInstance(instances[0].ID)
+func TestPauseInstance(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	sendStatsCmd(client, t)
+
+	serverCh := server.AddCmdChan(ssntp.PAUSE)
+
+	err := ctl.pauseInstance(instances[0].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	result, err = server.GetCmdChanResult(serverCh, ssntp.START)
+	result, err := server.GetCmdChanResult(serverCh, ssntp.PAUSE)
 	if err != nil {
 		t.Fatal(err)
 	}
 	if result.InstanceUUID != instances[0].ID {
 		t.Fatal("Did not get correct Instance ID")
 	}
+
+	// the instance is still reported as running.
+	err = ctl.unpauseInstance(instances[0].ID)
+	if err != types.ErrInstanceNotPaused {
+		t.Fatalf("Expected %v unpausing a running instance, got %v", types.ErrInstanceNotPaused, err)
+	}
 }
 
-func TestEvacuateNode(t *testing.T) {
-	client, err := testutil.NewSsntpTestClientConnection("EvacuateNode", ssntp.AGENT, testutil.AgentUUID)
+func TestShowTenantUsage(t *testing.T) {
+	ctx := context.Background()
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	tenantID := instances[0].TenantID
+
+	for n := 0; n <= tenantSummaryEvents; n++ {
+		err := ctl.ds.LogEvent(ctx, tenantID, fmt.Sprintf("Event %d", n))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	summary, err := ctl.ShowTenantUsage(ctx, tenantID)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer client.Shutdown()
 
-	serverCh := server.AddCmdChan(ssntp.EVACUATE)
+	if summary.Instances != len(instances) {
+		t.Fatalf("Expected %d instances, got %d", len(instances), summary.Instances)
+	}
 
-	// ok to not send workload first?
+	states := 0
+	for _, n := range summary.InstanceStates {
+		states += n
+	}
+	if states != summary.Instances {
+		t.Fatalf("Instance states %v do not add up to %d", summary.InstanceStates, summary.Instances)
+	}
 
-	err = ctl.EvacuateNode(client.UUID)
-	if err != nil {
-		t.Error(err)
+	if len(summary.Events) != tenantSummaryEvents {
+		t.Fatalf("Expected %d events, got %d", tenantSummaryEvents, len(summary.Events))
 	}
 
-	result, err := server.GetCmdChanResult(serverCh, ssntp.EVACUATE)
-	if err != nil {
-		t.Fatal(err)
+	if len(summary.Quotas) == 0 {
+		t.Fatal("No quotas in tenant summary")
 	}
-	if result.NodeUUID != client.UUID {
-		t.Fatal("Did not get node ID")
+
+	_, err = ctl.ShowTenantUsage(ctx, uuid.Generate().String())
+	if err == nil {
+		t.Fatal("Expected error for unknown tenant")
 	}
 }
 
-func TestRestoreNode(t *testing.T) {
-	client, err := testutil.NewSsntpTestClientConnection("RestoreNode", ssntp.AGENT, testutil.AgentUUID)
-	if err != nil {
-		t.Fatal(err)
-	}
+func TestRebootInstance(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
 	defer client.Shutdown()
 
-	serverCh := server.AddCmdChan(ssntp.Restore)
+	// the instance is not reported as running yet.
+	err := ctl.rebootInstance(instances[0].ID, false)
+	if err == nil {
+		t.Fatal("Rebooting a pending instance should fail")
+	}
+
+	sendStatsCmd(client, t)
+
+	serverCh := server.AddCmdChan(ssntp.REBOOT)
 
-	err = ctl.RestoreNode(client.UUID)
+	err = ctl.RebootServer(context.Background(), instances[0].TenantID, instances[0].ID, true)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 
-	result, err := server.GetCmdChanResult(serverCh, ssntp.Restore)
+	result, err := server.GetCmdChanResult(serverCh, ssntp.REBOOT)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if result.NodeUUID != client.UUID {
-		t.Fatal("Did not get node ID")
+	if result.InstanceUUID != instances[0].ID {
+		t.Fatal("Did not get correct Instance ID")
 	}
-}
 
-func TestAttachVolume(t *testing.T) {
-	client, err := testutil.NewSsntpTestClientConnection("AttachVolume", ssntp.AGENT, testutil.AgentUUID)
-	if err != nil {
-		t.Fatal(err)
+	sendNodeStats(client.UUID, instances[0].ID, payloads.Paused, t)
+
+	err = ctl.rebootInstance(instances[0].ID, false)
+	if err != types.ErrInstanceNotRunning {
+		t.Fatalf("Expected %v rebooting a paused instance, got %v", types.ErrInstanceNotRunning, err)
 	}
-	defer client.Ssntp.Close()
+}
 
-	serverCh := server.AddCmdChan(ssntp.AttachVolume)
+func TestRescueInstance(t *testing.T) {
+	ctx := context.Background()
 
-	// ok to not send workload first?
+	var reason payloads.StartFailureReason
 
-	err = ctl.client.attachVolume("volID", "instanceID", client.UUID)
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	sendStatsCmd(client, t)
+
+	tenantID := instances[0].TenantID
+	imageID := createTestImage(tenantID, "rescue", types.Active, t)
+
+	serverCh := server.AddCmdChan(ssntp.RESCUE)
+
+	err := ctl.RescueServer(ctx, tenantID, instances[0].ID, imageID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	result, err := server.GetCmdChanResult(serverCh, ssntp.AttachVolume)
+	result, err := server.GetCmdChanResult(serverCh, ssntp.RESCUE)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	if result.NodeUUID != client.UUID {
-		t.Fatal("Did not get node ID")
+	if result.InstanceUUID != instances[0].ID {
+		t.Fatal("Did not get correct Instance ID")
 	}
 
-	if result.VolumeUUID != "volID" {
-		t.Fatal("Did not get volume ID")
+	i, err := ctl.ds.GetInstance(instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !i.Rescued || i.RescueVolume == "" {
+		t.Fatal("Instance not marked as rescued")
 	}
+	rescueVolume := i.RescueVolume
 
-	if result.InstanceUUID != "instanceID" {
-		t.Fatal("Did not get instance ID")
+	err = ctl.RescueServer(ctx, tenantID, instances[0].ID, imageID)
+	if err != types.ErrInstanceRescued {
+		t.Fatalf("Expected %v rescuing a rescued instance, got %v", types.ErrInstanceRescued, err)
 	}
-}
 
-func addTestBlockDevice(t *testing.T, tenantID string) types.Volume {
-	bd, err := ctl.CreateBlockDevice("", "", 0)
+	serverCh = server.AddCmdChan(ssntp.UNRESCUE)
+
+	err = ctl.UnrescueServer(ctx, tenantID, instances[0].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	data := types.Volume{
-		BlockDevice: bd,
-		CreateTime:  time.Now(),
-		TenantID:    tenantID,
-		State:       types.Available,
+	result, err = server.GetCmdChanResult(serverCh, ssntp.UNRESCUE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.InstanceUUID != instances[0].ID {
+		t.Fatal("Did not get correct Instance ID")
 	}
 
-	err = ctl.ds.AddBlockDevice(data)
+	// the rescue volume is released once the launcher stops reporting it.
+	sendStatsCmd(client, t)
+
+	i, err = ctl.ds.GetInstance(instances[0].ID)
 	if err != nil {
-		_ = ctl.DeleteBlockDevice(bd.ID)
 		t.Fatal(err)
 	}
+	if i.Rescued || i.RescueVolume != "" {
+		t.Fatal("Rescue volume of unrescued instance not released")
+	}
 
-	return data
+	_, err = ctl.ds.GetBlockDevice(rescueVolume)
+	if err == nil {
+		t.Fatal("Rescue volume not deleted")
+	}
+
+	err = ctl.UnrescueServer(ctx, tenantID, instances[0].ID)
+	if err != types.ErrInstanceNotRescued {
+		t.Fatalf("Expected %v unrescuing an unrescued instance, got %v", types.ErrInstanceNotRescued, err)
+	}
 }
 
-// Note: caller should close ssntp client
-func doAttachVolumeCommand(t *testing.T, fail bool) (client *testutil.SsntpTestClient, tenant string, volume string, instanceID string) {
+func TestRestartInstance(t *testing.T) {
+	ctx := context.Background()
+
 	var reason payloads.StartFailureReason
 
 	client, instances := testStartWorkload(t, 1, false, reason)
-
-	tenantID := instances[0].TenantID
+	defer client.Shutdown()
 
 	sendStatsCmd(client, t)
 
-	data := addTestBlockDevice(t, tenantID)
-
-	serverCh := server.AddCmdChan(ssntp.AttachVolume)
-	agentCh := client.AddCmdChan(ssntp.AttachVolume)
-	var serverErrorCh chan testutil.Result
-	var controllerCh chan struct{}
-
-	if fail == true {
-		serverErrorCh = server.AddErrorChan(ssntp.AttachVolumeFailure)
-		controllerCh = wrappedClient.addErrorChan(ssntp.AttachVolumeFailure)
-		client.AttachFail = true
-		client.AttachVolumeFailReason = payloads.AttachVolumeAlreadyAttached
+	serverCh := server.AddCmdChan(ssntp.DELETE)
+	clientCh := client.AddCmdChan(ssntp.DELETE)
 
-		defer func() {
-			client.AttachFail = false
-			client.AttachVolumeFailReason = ""
-		}()
+	err := ctl.stopInstance(ctx, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	err := ctl.AttachVolume(tenantID, data.ID, instances[0].ID, "")
+	result, err := server.GetCmdChanResult(serverCh, ssntp.DELETE)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	result, err := server.GetCmdChanResult(serverCh, ssntp.AttachVolume)
+	_, err = client.GetCmdChanResult(clientCh, ssntp.DELETE)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if result.InstanceUUID != instances[0].ID {
+		t.Fatal("Did not get correct Instance ID")
+	}
 
-	if result.InstanceUUID != instances[0].ID ||
-		result.NodeUUID != client.UUID ||
-		result.VolumeUUID != data.ID {
-		t.Fatalf("expected %s %s %s, got %s %s %s", instances[0].ID, client.UUID, data.ID, result.InstanceUUID, result.NodeUUID, result.VolumeUUID)
+	err = sendStopEvent(client, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	if fail == true {
-		_, err = client.GetCmdChanResult(agentCh, ssntp.AttachVolume)
-		if err == nil {
-			t.Fatal("Success when Failure expected")
-		}
-
-		_, err = server.GetErrorChanResult(serverErrorCh, ssntp.AttachVolumeFailure)
-		if err != nil {
-			t.Fatal(err)
-		}
+	checkLastInstanceAction(t, instances[0].ID, payloads.Exited, types.InitiatorUser)
 
-		err = wrappedClient.getErrorChan(controllerCh, ssntp.AttachVolumeFailure)
-		if err != nil {
-			t.Fatal(err)
-		}
+	serverCh = server.AddCmdChan(ssntp.START)
 
-		// at this point, the state of the block device should
-		// be set back to available.
-		data2, err := ctl.ds.GetBlockDevice(data.ID)
-		if err != nil {
-			t.Fatal(err)
-		}
+	err = ctl.restartInstance(ctx, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		if data2.State != types.Available {
-			t.Fatalf("block device state not updated")
-		}
-	} else {
-		_, err = client.GetCmdChanResult(agentCh, ssntp.AttachVolume)
-		if err != nil {
-			t.Fatal(err)
-		}
+	result, err = server.GetCmdChanResult(serverCh, ssntp.START)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.InstanceUUID != instances[0].ID {
+		t.Fatal("Did not get correct Instance ID")
 	}
 
-	return client, tenantID, data.ID, instances[0].ID
+	checkLastInstanceAction(t, instances[0].ID, payloads.Pending, types.InitiatorUser)
 }
 
-func TestAttachVolumeCommand(t *testing.T) {
-	client, _, _, _ := doAttachVolumeCommand(t, false)
-	client.Ssntp.Close()
-}
+func TestCloneInstance(t *testing.T) {
+	ctx := context.Background()
 
-func TestAttachVolumeFailure(t *testing.T) {
-	client, _, _, _ := doAttachVolumeCommand(t, true)
-	client.Ssntp.Close()
-}
+	var reason payloads.StartFailureReason
 
-func doDetachVolumeCommand(t *testing.T, fail bool) {
-	// attach volume should succeed for this test
-	client, tenantID, volume, instanceID := doAttachVolumeCommand(t, false)
-	defer client.Ssntp.Close()
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
 
 	sendStatsCmd(client, t)
 
-	data, err := ctl.ds.GetBlockDevice(volume)
+	tenantID := instances[0].TenantID
+
+	_, err := ctl.CloneServer(ctx, tenantID, instances[0].ID, api.CloneServerRequest{})
+	if err != types.ErrInstanceNotStopped {
+		t.Fatalf("Expected %v cloning a running instance, got %v", types.ErrInstanceNotStopped, err)
+	}
+
+	serverCh := server.AddCmdChan(ssntp.DELETE)
+
+	err = ctl.stopInstance(ctx, instances[0].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if data.State != types.InUse {
-		t.Fatalf("expected state %s, got %s\n", types.Detaching, data.State)
+	_, err = server.GetCmdChanResult(serverCh, ssntp.DELETE)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	if fail {
-		err := ctl.DetachVolume(tenantID, volume, "")
-		if err == nil {
-			t.Fatal("Expected error when detaching volume from active instance")
+	err = sendStopEvent(client, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		}
+	volID := createTestVolume(tenantID, 20, t)
+	boot := payloads.StorageResource{
+		ID:        volID,
+		Bootable:  true,
+		Ephemeral: true,
+	}
 
-		data, err := ctl.ds.GetBlockDevice(volume)
-		if err != nil {
-			t.Fatal(err)
+	_, err = ctl.ds.CreateStorageAttachment(ctx, instances[0].ID, boot)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		}
+	source, ok := ctl.bootAttachment(instances[0].ID)
+	if !ok {
+		t.Fatal("Instance has no boot volume")
+	}
 
-		if data.State != types.InUse {
-			t.Fatalf("expected state %s, got %s\n", types.Detaching, data.State)
-		}
-	} else {
-		serverCh := server.AddCmdChan(ssntp.DELETE)
-		clientCh := client.AddCmdChan(ssntp.DELETE)
+	req := api.CloneServerRequest{
+		Instances: 2,
+		Name:      "clone",
+	}
 
-		err := ctl.stopInstance(instanceID)
-		if err != nil {
-			t.Fatal(err)
-		}
+	servers, err := ctl.CloneServer(ctx, tenantID, instances[0].ID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		result, err := server.GetCmdChanResult(serverCh, ssntp.DELETE)
+	if servers.TotalServers != 2 {
+		t.Fatalf("Expected 2 clones, got %d", servers.TotalServers)
+	}
+
+	for _, s := range servers.Servers {
+		i, err := ctl.ds.GetInstance(s.ID)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		_, err = client.GetCmdChanResult(clientCh, ssntp.DELETE)
-		if err != nil {
-			t.Fatal(err)
+		if i.WorkloadID != instances[0].WorkloadID || i.IPAddress == instances[0].IPAddress {
+			t.Fatalf("Unexpected clone %+v", i)
 		}
 
-		if result.InstanceUUID != instanceID {
-			t.Fatal("Did not get correct Instance ID")
+		boot, ok := ctl.bootAttachment(s.ID)
+		if !ok {
+			t.Fatalf("Clone %s has no boot volume", s.ID)
 		}
 
-		err = sendStopEvent(client, instanceID)
-		if err != nil {
-			t.Fatal(err)
+		if boot.BlockID == source.BlockID || boot.Ephemeral != source.Ephemeral {
+			t.Fatalf("Clone %s boots from %+v, source boots from %+v", s.ID, boot, source)
 		}
+	}
+}
 
-		err = ctl.DetachVolume(tenantID, volume, "")
-		if err != nil {
-			t.Fatal(err)
+func simpleInstances(t *testing.T, tenantID string) []*types.Instance {
+	instances, err := ctl.ds.GetAllInstancesFromTenant(tenantID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var simple []*types.Instance
+	for _, i := range instances {
+		if !i.CNCI {
+			simple = append(simple, i)
 		}
+	}
 
-		data, err := ctl.ds.GetBlockDevice(volume)
+	return simple
+}
+
+func TestCreateSimpleServer(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imageID := createTestImage(tenant.ID, "simple-image", types.Active, t)
+
+	_, err = ctl.CreateSimpleServer(ctx, tenant.ID, api.SimpleServerRequest{
+		ImageID: imageID,
+		Size:    "huge",
+	})
+	if err != types.ErrBadRequest {
+		t.Fatalf("Expected %v for unknown size, got %v", types.ErrBadRequest, err)
+	}
+
+	req := api.SimpleServerRequest{
+		ImageID: imageID,
+		Size:    "medium",
+	}
+
+	for n := 0; n < 2; n++ {
+		_, err = ctl.CreateSimpleServer(ctx, tenant.ID, req)
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		if data.State != types.Available {
-			t.Fatalf("expected state %s, got %s\n", types.Detaching, data.State)
-		}
 	}
-}
 
-func TestDetachVolumeCommand(t *testing.T) {
-	doDetachVolumeCommand(t, false)
-}
+	instances := simpleInstances(t, tenant.ID)
+	if len(instances) != 2 {
+		t.Fatalf("Expected 2 instances, got %d", len(instances))
+	}
 
-func TestDetachVolumeFailure(t *testing.T) {
-	doDetachVolumeCommand(t, true)
-}
+	if instances[0].WorkloadID != instances[1].WorkloadID {
+		t.Fatal("Instances of the same image and size not launched from the same workload")
+	}
 
-func TestDetachVolumeByAttachment(t *testing.T) {
-	tenant, err := addTestTenant()
+	wl, err := ctl.ds.GetWorkload(instances[0].WorkloadID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = ctl.DetachVolume(tenant.ID, "invalidVolume", "attachmentID")
-	if err == nil {
-		t.Fatal("Detach by attachment ID not supported yet")
+	if wl.TenantID != tenant.ID || wl.Visibility != types.Private ||
+		wl.Requirements.VCPUs != instanceSizes["medium"].vcpus ||
+		wl.Requirements.MemMB != instanceSizes["medium"].memMB ||
+		len(wl.Storage) != 1 || wl.Storage[0].Source != imageID {
+		t.Fatalf("Unexpected simple workload %+v", wl)
+	}
+
+	ctl.client.RemoveInstance(instances[0].ID)
+
+	_, err = ctl.ds.GetWorkload(wl.ID)
+	if err != nil {
+		t.Fatalf("Workload deleted while still in use: %v", err)
+	}
+
+	ctl.client.RemoveInstance(instances[1].ID)
+
+	_, err = ctl.ds.GetWorkload(wl.ID)
+	if err != types.ErrWorkloadNotFound {
+		t.Fatalf("Expected workload to be deleted with its last instance, got %v", err)
 	}
 }
 
-func TestInstanceDeletedEvent(t *testing.T) {
+func TestSnapshotSet(t *testing.T) {
+	ctx := context.Background()
+
 	var reason payloads.StartFailureReason
 
 	client, instances := testStartWorkload(t, 1, false, reason)
@@ -797,1336 +1061,4886 @@ func TestInstanceDeletedEvent(t *testing.T) {
 
 	sendStatsCmd(client, t)
 
-	serverCh := server.AddCmdChan(ssntp.DELETE)
+	tenantID := instances[0].TenantID
 
-	err := ctl.deleteInstance(instances[0].ID)
+	volID := createTestVolume(tenantID, 20, t)
+	_, err := ctl.ds.CreateStorageAttachment(ctx, instances[0].ID, payloads.StorageResource{
+		ID:       volID,
+		Bootable: true,
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = server.GetCmdChanResult(serverCh, ssntp.DELETE)
+	dataID := createTestVolume(tenantID, 10, t)
+	_, err = ctl.ds.CreateStorageAttachment(ctx, instances[0].ID, payloads.StorageResource{
+		ID: dataID,
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	clientEvtCh := client.AddEventChan(ssntp.InstanceDeleted)
-	serverEvtCh := server.AddEventChan(ssntp.InstanceDeleted)
-	controllerCh := wrappedClient.addEventChan(ssntp.InstanceDeleted)
-	go client.SendDeleteEvent(instances[0].ID)
-	_, err = client.GetEventChanResult(clientEvtCh, ssntp.InstanceDeleted)
+	_, err = ctl.CreateSnapshotSet(ctx, tenantID, instances[0].ID, types.SnapshotSetRequest{})
+	if err != types.ErrBadName {
+		t.Fatalf("Expected %v creating an unnamed snapshot set, got %v", types.ErrBadName, err)
+	}
+
+	// the tenant may not use the guest agent to freeze the instance.
+	req := types.SnapshotSetRequest{Name: "nightly"}
+	_, err = ctl.CreateSnapshotSet(ctx, tenantID, instances[0].ID, req)
+	if err == nil {
+		t.Fatal("Consistent snapshot of an instance without guest agent should fail")
+	}
+
+	req.AllowInconsistent = true
+	set, err := ctl.CreateSnapshotSet(ctx, tenantID, instances[0].ID, req)
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = server.GetEventChanResult(serverEvtCh, ssntp.InstanceDeleted)
+
+	if set.Consistent || len(set.Volumes) != 2 {
+		t.Fatalf("Unexpected snapshot set %+v", set)
+	}
+
+	for _, v := range set.Volumes {
+		if v.ID == "" || v.ID == v.VolumeID || v.Boot != (v.VolumeID == volID) {
+			t.Fatalf("Unexpected snapshot set volume %+v", v)
+		}
+	}
+
+	for i := 0; set.State == types.SnapshotSetCreating; i++ {
+		if i == 50 {
+			t.Fatal("Snapshot set was not completed")
+		}
+		time.Sleep(100 * time.Millisecond)
+
+		set, err = ctl.ShowSnapshotSet(ctx, tenantID, set.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if set.State != types.SnapshotSetAvailable {
+		t.Fatalf("Expected snapshot set to be %s, got %s", types.SnapshotSetAvailable, set.State)
+	}
+
+	sets, err := ctl.ListSnapshotSets(ctx, tenantID)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = wrappedClient.getEventChan(controllerCh, ssntp.InstanceDeleted)
+
+	found := false
+	for _, s := range sets {
+		found = found || s.ID == set.ID
+	}
+	if !found {
+		t.Fatalf("Snapshot set %s not listed", set.ID)
+	}
+
+	servers, err := ctl.RestoreSnapshotSet(ctx, tenantID, set.ID, types.SnapshotSetRestoreRequest{})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// try to get instance info
-	_, err = ctl.ds.GetInstance(instances[0].ID)
-	if err == nil {
-		t.Error("Instance not deleted")
+	if servers.TotalServers != 1 {
+		t.Fatalf("Expected 1 restored instance, got %d", servers.TotalServers)
 	}
-}
 
-func TestStartFailure(t *testing.T) {
-	reason := payloads.FullCloud
-
-	client, _ := testStartWorkload(t, 1, true, reason)
-	defer client.Shutdown()
+	attachments := ctl.ds.GetStorageAttachments(servers.Servers[0].ID)
+	if len(attachments) != 2 {
+		t.Fatalf("Expected 2 volumes attached to the restored instance, got %d", len(attachments))
+	}
 
-	// since we had a start failure, we should confirm that the
-	// instance is no longer pending in the database
-}
+	for _, a := range attachments {
+		if a.BlockID == volID || a.BlockID == dataID {
+			t.Fatalf("Restored instance uses volume %s of the source instance", a.BlockID)
+		}
+	}
 
-func TestStopFailure(t *testing.T) {
-	err := ctl.ds.ClearLog()
+	err = ctl.DeleteSnapshotSet(ctx, tenantID, set.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	var reason payloads.StartFailureReason
+	_, err = ctl.ShowSnapshotSet(ctx, tenantID, set.ID)
+	if err != types.ErrSnapshotSetNotFound {
+		t.Fatalf("Expected %v after deleting snapshot set, got %v", types.ErrSnapshotSetNotFound, err)
+	}
+}
 
-	client, instances := testStartWorkload(t, 1, false, reason)
-	defer client.Shutdown()
+func TestSignedRequests(t *testing.T) {
+	ctx := context.Background()
 
-	client.DeleteFail = true
-	client.DeleteFailReason = payloads.DeleteNoInstance
+	_, err := ctl.SubmitSignedRequest(ctx, types.SignedRequest{})
+	if err != types.ErrSignedRequestsDisabled {
+		t.Fatalf("Expected %v, got %v", types.ErrSignedRequestsDisabled, err)
+	}
 
-	sendStatsCmd(client, t)
+	ctl.signedRequestMaxAge = time.Hour
+	defer func() { ctl.signedRequestMaxAge = 0 }()
 
-	serverCh := server.AddCmdChan(ssntp.DELETE)
-	controllerCh := wrappedClient.addErrorChan(ssntp.DeleteFailure)
+	var signed bytes.Buffer
+	signer := client.Client{
+		ControllerURL:  "https://controller.invalid:8889",
+		ClientCertFile: "/etc/pki/ciao/auth-admin.pem",
+		SignTo:         &signed,
+	}
+	if err := signer.Init(); err != nil {
+		t.Fatal(err)
+	}
 
-	err = ctl.stopInstance(instances[0].ID)
-	if err != nil {
+	tenantID := uuid.Generate().String()
+	_, err = signer.CreateTenantConfig(tenantID, types.TenantConfig{Name: "signed", SubnetBits: 24})
+	if errors.Cause(err) != client.ErrRequestSigned {
+		t.Fatalf("Expected %v, got %v", client.ErrRequestSigned, err)
+	}
+
+	var req types.SignedRequest
+	if err := json.NewDecoder(&signed).Decode(&req); err != nil {
 		t.Fatal(err)
 	}
 
-	result, err := server.GetCmdChanResult(serverCh, ssntp.DELETE)
+	tampered := req
+	tampered.Body = json.RawMessage(strings.Replace(string(req.Body), "signed", "tampered", 1))
+	_, err = ctl.SubmitSignedRequest(ctx, tampered)
+	if err != types.ErrSignedRequestInvalid {
+		t.Fatalf("Expected %v, got %v", types.ErrSignedRequestInvalid, err)
+	}
+
+	ctl.signedRequestMaxAge = time.Nanosecond
+	_, err = ctl.SubmitSignedRequest(ctx, req)
+	if err != types.ErrSignedRequestExpired {
+		t.Fatalf("Expected %v, got %v", types.ErrSignedRequestExpired, err)
+	}
+	ctl.signedRequestMaxAge = time.Hour
+
+	result, err := ctl.SubmitSignedRequest(ctx, req)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = wrappedClient.getErrorChan(controllerCh, ssntp.DeleteFailure)
-	if err != nil {
+
+	if result.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %+v", http.StatusCreated, result)
+	}
+
+	tenant, err := ctl.ds.GetTenant(ctx, tenantID)
+	if err != nil || tenant == nil || tenant.Name != "signed" {
+		t.Fatalf("Tenant not created by signed request: %+v (%v)", tenant, err)
+	}
+
+	_, err = ctl.SubmitSignedRequest(ctx, req)
+	if err != types.ErrSignedRequestReplayed {
+		t.Fatalf("Expected %v, got %v", types.ErrSignedRequestReplayed, err)
+	}
+
+	body := testHTTPRequest(t, "GET", testutil.ComputeURL+"/signed-requests", http.StatusOK, nil, true)
+
+	var records []types.SignedRequestRecord
+	if err := json.Unmarshal(body, &records); err != nil {
 		t.Fatal(err)
 	}
-	if result.InstanceUUID != instances[0].ID {
-		t.Fatal("Did not get correct Instance ID")
+
+	if len(records) != 1 || records[0].Nonce != req.Nonce || records[0].Signer != "admin" ||
+		records[0].Method != "POST" || records[0].Path != "/tenants" {
+		t.Fatalf("Expected record of request %+v, got %+v", req, records)
 	}
-}
 
-func TestRestartFailure(t *testing.T) {
-	err := ctl.ds.ClearLog()
+	err = ctl.DeleteTenant(ctx, tenantID)
 	if err != nil {
 		t.Fatal(err)
 	}
+}
+
+func TestExportInstance(t *testing.T) {
+	ctx := context.Background()
 
 	var reason payloads.StartFailureReason
 
 	client, instances := testStartWorkload(t, 1, false, reason)
 	defer client.Shutdown()
 
-	client.StartFail = true
-	client.StartFailReason = payloads.LaunchFailure
-
 	sendStatsCmd(client, t)
 
+	tenantID := instances[0].TenantID
+
+	_, err := ctl.exportInstance(tenantID, instances[0].ID)
+	if err != types.ErrInstanceNotStopped {
+		t.Fatalf("Expected %v exporting a running instance, got %v", types.ErrInstanceNotStopped, err)
+	}
+
 	serverCh := server.AddCmdChan(ssntp.DELETE)
-	clientCh := client.AddCmdChan(ssntp.DELETE)
 
-	err = ctl.stopInstance(instances[0].ID)
+	err = ctl.stopInstance(ctx, instances[0].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = sendStopEvent(client, instances[0].ID)
+	_, err = server.GetCmdChanResult(serverCh, ssntp.DELETE)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = client.GetCmdChanResult(clientCh, ssntp.DELETE)
+	err = sendStopEvent(client, instances[0].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
-	result, err := server.GetCmdChanResult(serverCh, ssntp.DELETE)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if result.InstanceUUID != instances[0].ID {
-		t.Fatal("Did not get correct Instance ID")
-	}
-
-	sendStatsCmd(client, t)
 
-	serverCh = server.AddCmdChan(ssntp.START)
-	controllerCh := wrappedClient.addErrorChan(ssntp.StartFailure)
-
-	err = ctl.restartInstance(instances[0].ID)
-	if err != nil {
-		t.Fatal(err)
+	_, err = ctl.exportInstance(tenantID, instances[0].ID)
+	if err != types.ErrExportNotSupported {
+		t.Fatalf("Expected %v exporting an instance without a boot volume, got %v", types.ErrExportNotSupported, err)
 	}
 
-	result, err = server.GetCmdChanResult(serverCh, ssntp.START)
+	volID := createTestVolume(tenantID, 1, t)
+	_, err = ctl.ds.CreateStorageAttachment(ctx, instances[0].ID, payloads.StorageResource{
+		ID:       volID,
+		Bootable: true,
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = wrappedClient.getErrorChan(controllerCh, ssntp.StartFailure)
+
+	r := legacyComputeRoutes(ctl, mux.NewRouter())
+	url := fmt.Sprintf("/v2.1/%s/servers/%s/export", tenantID, instances[0].ID)
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if result.InstanceUUID != instances[0].ID {
-		t.Fatal("Did not get correct Instance ID")
-	}
 
-	// the response to a restart failure is to log the failure
-	entries, err := ctl.ds.GetEventLog()
-	if err != nil {
-		t.Fatal(err)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
 	}
 
-	expectedMsg := fmt.Sprintf("Start Failure %s: %s", instances[0].ID, client.StartFailReason.String())
+	files := make(map[string][]byte)
+	tr := tar.NewReader(rr.Body)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
 
-	for i := range entries {
-		if entries[i].Message == expectedMsg {
-			return
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
 		}
+		files[hdr.Name] = data
 	}
-	t.Error("Did not find failure message in Log")
-}
 
-// NOTE: the caller is responsible for calling Shutdown() on the *SsntpTestClient
-func testStartTracedWorkload(t *testing.T) *testutil.SsntpTestClient {
-	tenant, err := addTestTenant()
+	var metadata types.InstanceExport
+	err = json.Unmarshal(files[exportMetadataFile], &metadata)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	client, err := testutil.NewSsntpTestClientConnection("StartTracedWorkload", ssntp.AGENT, testutil.AgentUUID)
-	if err != nil {
-		t.Fatal(err)
+	if metadata.InstanceID != instances[0].ID || metadata.Workload.ID != instances[0].WorkloadID {
+		t.Errorf("Wrong export metadata %+v", metadata)
 	}
-	// caller of TestStartTracedWorkload() owns doing the close
-	//defer client.Shutdown()
 
-	wls, err := ctl.ds.GetWorkloads(tenant.ID)
-	if err != nil {
-		t.Fatal(err)
+	if _, ok := files[metadata.Disk]; !ok {
+		t.Errorf("Boot disk missing from the export bundle")
 	}
-	if len(wls) == 0 {
-		t.Fatal("No workloads, expected len(wls) > 0, got len(wls) == 0")
+
+	if metadata.UserData != "" && string(files[metadata.UserData]) != metadata.Workload.Config {
+		t.Errorf("Wrong user data in the export bundle")
 	}
+}
 
-	clientCh := client.AddCmdChan(ssntp.START)
-	serverCh := server.AddCmdChan(ssntp.START)
+func waitForOperation(resourceID string, opType types.OperationType, t *testing.T) types.Operation {
+	for i := 0; i < 50; i++ {
+		ops := ctl.ds.GetResourceOperations(resourceID, opType)
+		if len(ops) > 0 && ops[len(ops)-1].State != types.OperationRunning {
+			return ops[len(ops)-1]
+		}
 
-	w := types.WorkloadRequest{
-		WorkloadID: wls[0].ID,
-		TenantID:   tenant.ID,
-		Instances:  1,
-		TraceLabel: "testtrace",
-	}
-	instances, err := ctl.startWorkload(w)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if len(instances) != 1 {
-		t.Fatalf("Wrong number of instances, expected 1, got %d", len(instances))
+		time.Sleep(100 * time.Millisecond)
 	}
 
-	_, err = client.GetCmdChanResult(clientCh, ssntp.START)
-	if err != nil {
-		t.Fatal(err)
+	t.Fatalf("%s operation on %s did not complete", opType, resourceID)
+	return types.Operation{}
+}
+
+func quotaUsage(tenantID string, name string) int {
+	if qd := findQuota(ctl.qs.DumpQuotas(tenantID), name); qd != nil {
+		return qd.Usage
 	}
-	result, err := server.GetCmdChanResult(serverCh, ssntp.START)
+
+	return 0
+}
+
+func TestArchiveInstance(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "controller-archive")
 	if err != nil {
 		t.Fatal(err)
 	}
-	if result.InstanceUUID != instances[0].ID {
-		t.Fatal("Did not get correct Instance ID")
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	savedPath := *archivePath
+	*archivePath = dir
+	defer func() { *archivePath = savedPath }()
+
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	sendStatsCmd(client, t)
+
+	tenantID := instances[0].TenantID
+	ID := instances[0].ID
+
+	_, err = ctl.ArchiveServer(ctx, tenantID, ID)
+	if err != types.ErrInstanceNotStopped {
+		t.Fatalf("Expected %v archiving a running instance, got %v", types.ErrInstanceNotStopped, err)
 	}
 
-	return client
-}
+	serverCh := server.AddCmdChan(ssntp.DELETE)
 
-// NOTE: the caller is responsible for calling Shutdown() on the *SsntpTestClient
-func testStartWorkload(t *testing.T, num int, fail bool, reason payloads.StartFailureReason) (*testutil.SsntpTestClient, []*types.Instance) {
-	tenant, err := addTestTenant()
+	err = ctl.stopInstance(ctx, ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	client, err := testutil.NewSsntpTestClientConnection("StartWorkload", ssntp.AGENT, testutil.AgentUUID)
+	_, err = server.GetCmdChanResult(serverCh, ssntp.DELETE)
 	if err != nil {
 		t.Fatal(err)
 	}
-	// caller of TestStartWorkload() owns doing the close
-	//defer client.Shutdown()
 
-	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	err = sendStopEvent(client, ID)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(wls) == 0 {
-		t.Fatal("No workloads, expected len(wls) > 0, got len(wls) == 0")
-	}
-
-	clientCmdCh := client.AddCmdChan(ssntp.START)
-	clientErrCh := client.AddErrorChan(ssntp.StartFailure)
-	client.StartFail = fail
-	client.StartFailReason = reason
 
-	w := types.WorkloadRequest{
-		WorkloadID: wls[0].ID,
-		TenantID:   tenant.ID,
-		Instances:  num,
-		Name:       "test",
+	_, err = ctl.ArchiveServer(ctx, tenantID, ID)
+	if err != types.ErrNoVolumesToArchive {
+		t.Fatalf("Expected %v archiving an instance without volumes, got %v", types.ErrNoVolumesToArchive, err)
 	}
-	instances, err := ctl.startWorkload(w)
+
+	volID := createTestVolume(tenantID, 2, t)
+	_, err = ctl.ds.CreateStorageAttachment(ctx, ID, payloads.StorageResource{
+		ID:       volID,
+		Bootable: true,
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(instances) != num {
-		t.Fatalf("Wrong number of instances, expected %d, got %d", len(instances), num)
-	}
 
-	if fail == true {
-		_, err := client.GetErrorChanResult(clientErrCh, ssntp.StartFailure)
-		if err == nil { // unexpected success
-			t.Fatal(err)
-		}
-	}
+	storage := quotaUsage(tenantID, "tenant-storage-quota")
+	archive := quotaUsage(tenantID, "tenant-archive-quota")
 
-	result, err := client.GetCmdChanResult(clientCmdCh, ssntp.START)
-	if fail == true && err == nil { // unexpected success
+	op, err := ctl.ArchiveServer(ctx, tenantID, ID)
+	if err != nil {
 		t.Fatal(err)
 	}
-	if fail == false && err != nil { // unexpected failure
-		t.Fatal(err)
+
+	if op.Type != types.InstanceArchive || op.ResourceID != ID {
+		t.Fatalf("Unexpected archive operation %+v", op)
 	}
-	if result.InstanceUUID != instances[0].ID {
-		t.Fatal("Did not get correct Instance ID")
+
+	op = waitForOperation(ID, types.InstanceArchive, t)
+	if op.State != types.OperationSucceeded {
+		t.Fatalf("Archive failed: %s", op.Error)
 	}
 
-	return client, instances
-}
+	waitForVolumeState(volID, types.Archived, t)
 
-func startTestWorkload(t *testing.T, instanceCh chan []*types.Instance, workloadID string, tenantID string, num int) {
-	w := types.WorkloadRequest{
-		WorkloadID: workloadID,
-		TenantID:   tenantID,
-		Instances:  num,
+	if _, err := os.Stat(volumeArchive(volID)); err != nil {
+		t.Fatalf("Volume archive missing: %v", err)
 	}
-	instances, err := ctl.startWorkload(w)
+
+	if quotaUsage(tenantID, "tenant-storage-quota") != storage-2 ||
+		quotaUsage(tenantID, "tenant-archive-quota") != archive+2 {
+		t.Fatal("Archived volume still consumes storage quota")
+	}
+
+	s, err := ctl.ShowServerDetails(ctx, tenantID, ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(instances) != num {
-		t.Fatalf("Wrong number of instances, expected %d, got %d", num, len(instances))
+	if s.Server.ArchiveState != string(types.Archived) {
+		t.Fatalf("Expected archive state %s, got %q", types.Archived, s.Server.ArchiveState)
 	}
 
-	instanceCh <- instances
-}
+	err = ctl.restartInstance(ctx, ID)
+	if err != types.ErrInstanceArchived {
+		t.Fatalf("Expected %v starting an archived instance, got %v", types.ErrInstanceArchived, err)
+	}
 
-func startTenantWorkload(t *testing.T, tenantID string, instanceCh chan []*types.Instance) {
-	wls, err := ctl.ds.GetWorkloads(tenantID)
+	_, err = ctl.ArchiveServer(ctx, tenantID, ID)
+	if err != types.ErrInstanceArchived {
+		t.Fatalf("Expected %v archiving an archived instance, got %v", types.ErrInstanceArchived, err)
+	}
+
+	_, err = ctl.RehydrateServer(ctx, tenantID, ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(wls) == 0 {
-		t.Fatal("No workloads for this tenant")
+	op = waitForOperation(ID, types.InstanceRehydrate, t)
+	if op.State != types.OperationSucceeded {
+		t.Fatalf("Rehydration failed: %s", op.Error)
 	}
 
-	startTestWorkload(t, instanceCh, wls[0].ID, tenantID, 1)
-}
+	waitForVolumeState(volID, types.InUse, t)
 
-// NOTE: the caller is responsible for calling Shutdown() on the *SsntpTestClient
-func testStartWorkloadLaunchCNCI(t *testing.T, num int) (*testutil.SsntpTestClient, *testutil.SsntpTestClient, []*types.Instance) {
-	netClient, err := testutil.NewSsntpTestClientConnection("StartWorkloadLaunchCNCI", ssntp.NETAGENT, testutil.NetAgentUUID)
-	if err != nil {
-		t.Fatal(err)
+	if _, err := os.Stat(volumeArchive(volID)); !os.IsNotExist(err) {
+		t.Fatal("Volume archive not removed after rehydration")
 	}
 
-	client, err := testutil.NewSsntpTestClientConnection("StartWorkload", ssntp.AGENT, testutil.AgentUUID)
-	if err != nil {
-		t.Fatal(err)
+	if quotaUsage(tenantID, "tenant-storage-quota") != storage ||
+		quotaUsage(tenantID, "tenant-archive-quota") != archive {
+		t.Fatal("Rehydrated volume quota not restored")
 	}
-	// caller of TestStartWorkloadLaunchCNCI owns doing the close.
 
-	tt, err := addTestTenantNoCNCI()
-	if err != nil {
-		t.Fatal(err)
+	_, err = ctl.RehydrateServer(ctx, tenantID, ID)
+	if err != types.ErrInstanceNotArchived {
+		t.Fatalf("Expected %v rehydrating an instance, got %v", types.ErrInstanceNotArchived, err)
 	}
+}
 
-	newTenant := tt.ID
-
-	// caller of testStartWorkloadLaunchCNCI() owns doing the close
-	//defer netClient.Shutdown()
+func TestPreemptInstance(t *testing.T) {
+	ctx := context.Background()
 
-	serverCmdCh := server.AddCmdChan(ssntp.START)
-	netClientCmdCh := netClient.AddCmdChan(ssntp.START)
-	clientCmdCh := client.AddCmdChan(ssntp.START)
+	var reason payloads.StartFailureReason
 
-	// trigger the START command flow, and await results
-	instanceCh := make(chan []*types.Instance)
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
 
-	go startTenantWorkload(t, newTenant, instanceCh)
+	sendStatsCmd(client, t)
 
-	_, err = netClient.GetCmdChanResult(netClientCmdCh, ssntp.START)
-	if err != nil {
-		t.Fatal(err)
-	}
-	result, err := server.GetCmdChanResult(serverCmdCh, ssntp.START)
-	if err != nil {
-		t.Fatal(err)
-	}
+	warning := *preemptionWarning
+	*preemptionWarning = 0
+	defer func() { *preemptionWarning = warning }()
 
-	if result.TenantUUID != newTenant {
-		t.Fatal("Did not get correct tenant ID")
-	}
+	serverCh := server.AddCmdChan(ssntp.DELETE)
+	clientCh := client.AddCmdChan(ssntp.DELETE)
 
-	if !result.CNCI {
-		t.Fatal("this is not a CNCI launch request")
+	event := payloads.EventInstancesPreempted{
+		InstancesPreempted: payloads.InstancesPreemptedEvent{
+			InstanceUUID: testutil.InstanceUUID,
+			NodeUUID:     client.UUID,
+			Preempted:    []string{instances[0].ID},
+		},
 	}
 
-	// start a test CNCI client
-	cnciClient, err := testutil.NewSsntpTestClientConnection("StartWorkloadLaunchCNCI", ssntp.CNCIAGENT, newTenant)
+	y, err := yaml.Marshal(&event)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// make CNCI send an ssntp.ConcentratorInstanceAdded event, and await results
-	cnciEventCh := cnciClient.AddEventChan(ssntp.ConcentratorInstanceAdded)
-	serverEventCh := server.AddEventChan(ssntp.ConcentratorInstanceAdded)
-	tenantCNCI, _ := ctl.ds.GetTenantCNCISummary(result.InstanceUUID)
-	go cnciClient.SendConcentratorAddedEvent(result.InstanceUUID, newTenant, testutil.CNCIIP, tenantCNCI[0].MACAddress)
-	result, err = cnciClient.GetEventChanResult(cnciEventCh, ssntp.ConcentratorInstanceAdded)
+	go ctl.client.EventNotify(ssntp.InstancesPreempted, &ssntp.Frame{Payload: y})
+
+	result, err := server.GetCmdChanResult(serverCh, ssntp.DELETE)
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = server.GetEventChanResult(serverEventCh, ssntp.ConcentratorInstanceAdded)
+	_, err = client.GetCmdChanResult(clientCh, ssntp.DELETE)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	// shutdown the test CNCI client
-	cnciClient.Shutdown()
-
-	if result.InstanceUUID != tenantCNCI[0].InstanceID {
-		t.Fatalf("Did not get correct Instance ID, got %s, expected %s", result.InstanceUUID, tenantCNCI[0].InstanceID)
+	if result.InstanceUUID != instances[0].ID {
+		t.Fatal("Did not get correct Instance ID")
 	}
 
-	result, err = client.GetCmdChanResult(clientCmdCh, ssntp.START)
+	details, err := instanceToServer(ctl, instances[0])
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	if result.TenantUUID != newTenant {
-		t.Fatal("Did not get correct tenant ID")
+	if details.TerminationTime == nil {
+		t.Fatal("Termination time not reported for preempted instance")
 	}
 
-	instances := <-instanceCh
-	if instances == nil {
-		t.Fatal("did not receive instance")
+	err = sendStopEvent(client, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	return netClient, client, instances
-}
+	checkLastInstanceAction(t, instances[0].ID, payloads.Exited, types.InitiatorSystem)
 
-func TestGetStorageForVolume(t *testing.T) {
-	tenant, err := addTestTenant()
+	actions, err := ctl.ListInstanceActions(ctx, instances[0].TenantID, instances[0].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if actions[len(actions)-1].Reason != types.ReasonPreemption {
+		t.Fatalf("Expected %s reason, got %s", types.ReasonPreemption, actions[len(actions)-1].Reason)
+	}
+}
 
-	sourceVolume := addTestBlockDevice(t, tenant.ID)
-	defer func() { _ = ctl.DeleteBlockDevice(sourceVolume.ID) }()
+func TestDiskUsageAlert(t *testing.T) {
+	ctx := context.Background()
 
-	// a temporary in memory filesystem?
-	s := types.StorageResource{
-		ID:         "",
-		Bootable:   true,
-		Ephemeral:  false,
-		SourceType: types.VolumeService,
-		Source:     sourceVolume.ID,
-	}
+	var reason payloads.StartFailureReason
 
-	pl, err := getStorage(ctl, s, tenant.ID, "")
-	if err != nil {
-		t.Fatal(err)
-	}
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
 
-	if pl.ID == "" {
-		t.Errorf("storage ID does not exist")
+	event := payloads.EventDiskUsageAlert{
+		DiskUsageAlert: payloads.DiskUsageAlertEvent{
+			InstanceUUID: instances[0].ID,
+			NodeUUID:     client.UUID,
+			UsageMB:      960,
+			LimitMB:      1000,
+			Threshold:    95,
+		},
 	}
 
-	if pl.Bootable != true {
-		t.Errorf("bootable flag not correct")
+	y, err := yaml.Marshal(&event)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	if pl.Ephemeral != false {
-		t.Errorf("ephemeral flag not correct")
-	}
+	ctl.client.EventNotify(ssntp.DiskUsageAlert, &ssntp.Frame{Payload: y})
 
-	createdVolume, err := ctl.ds.GetBlockDevice(pl.ID)
+	entries, err := ctl.ds.GetEventLog(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if createdVolume.State != types.Available {
-		t.Fatal("Expected newly created block device to be available")
+	expectedMsg := fmt.Sprintf("Instance %s is using 95%% of its disk: 960/1000 MB", instances[0].ID)
+
+	for i := range entries {
+		if entries[i].Message == expectedMsg {
+			if entries[i].TenantID != instances[0].TenantID {
+				t.Fatalf("Expected tenant %s, got %s", instances[0].TenantID, entries[i].TenantID)
+			}
+			return
+		}
 	}
+	t.Error("Did not find disk usage alert in Log")
 }
 
-func TestGetStorageForImage(t *testing.T) {
-	tenant, err := addTestTenant()
-	if err != nil {
-		t.Fatal(err)
+func TestWatchdogFired(t *testing.T) {
+	ctx := context.Background()
+
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	event := payloads.EventWatchdogFired{
+		WatchdogFired: payloads.WatchdogFiredEvent{
+			InstanceUUID: instances[0].ID,
+			NodeUUID:     client.UUID,
+			Action:       payloads.WatchdogReset,
+		},
 	}
 
-	// add fake image to images store
-	//
-	tmpfile, err := ioutil.TempFile("", "testImage")
+	y, err := yaml.Marshal(&event)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer func() { _ = os.Remove(tmpfile.Name()) }()
 
-	// a temporary in memory filesystem?
-	s := types.StorageResource{
-		ID:         "",
-		Bootable:   true,
-		Ephemeral:  false,
-		SourceType: types.ImageService,
-		Source:     filepath.Base(tmpfile.Name()),
-	}
+	ctl.client.EventNotify(ssntp.WatchdogFired, &ssntp.Frame{Payload: y})
 
-	pl, err := getStorage(ctl, s, tenant.ID, "")
+	entries, err := ctl.ds.GetEventLog(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if pl.ID == "" {
-		t.Errorf("storage ID does not exist")
-	}
+	expectedMsg := fmt.Sprintf("Watchdog of instance %s fired, action: reset", instances[0].ID)
 
-	if pl.Bootable != true {
-		t.Errorf("bootable flag not correct")
+	for i := range entries {
+		if entries[i].Message == expectedMsg {
+			if entries[i].TenantID != instances[0].TenantID {
+				t.Fatalf("Expected tenant %s, got %s", instances[0].TenantID, entries[i].TenantID)
+			}
+			return
+		}
 	}
+	t.Error("Did not find watchdog event in Log")
+}
 
-	if pl.Ephemeral != false {
-		t.Errorf("ephemeral flag not correct")
+func sendConsoleOutputEvent(instanceID string, sessionID string, output string, closed bool, t *testing.T) {
+	event := payloads.EventConsoleOutput{
+		ConsoleOutput: payloads.ConsoleOutputEvent{
+			InstanceUUID: instanceID,
+			SessionUUID:  sessionID,
+			Output:       output,
+			Closed:       closed,
+		},
 	}
 
-	createdVolume, err := ctl.ds.GetBlockDevice(pl.ID)
+	y, err := yaml.Marshal(&event)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if createdVolume.State != types.Available {
-		t.Fatal("Expected newly created block device to be available")
-	}
+	ctl.client.EventNotify(ssntp.ConsoleOutput, &ssntp.Frame{Payload: y})
 }
 
-func TestStorageConfig(t *testing.T) {
-	var err error
+func TestConsole(t *testing.T) {
+	ctx := context.Background()
 
-	tenant, err := addTestTenant()
-	if err != nil {
-		t.Fatal(err)
-	}
+	var reason payloads.StartFailureReason
 
-	// get workload ID
-	wls, err := ctl.ds.GetWorkloads(tenant.ID)
-	if err != nil || len(wls) == 0 {
-		t.Fatal(err)
-	}
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
 
-	tmpfile, err := ioutil.TempFile("", "test-image")
+	sendStatsCmd(client, t)
+
+	serverCh := server.AddCmdChan(ssntp.CONSOLE)
+
+	session, err := ctl.OpenConsole(ctx, instances[0].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer func() { _ = os.Remove(tmpfile.Name()) }()
 
-	info, err := tmpfile.Stat()
+	result, err := server.GetCmdChanResult(serverCh, ssntp.CONSOLE)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	// a temporary in memory filesystem?
-	s := types.StorageResource{
-		ID:         "",
-		Bootable:   true,
-		Ephemeral:  false,
-		SourceType: types.ImageService,
-		Source:     info.Name(),
+	if result.InstanceUUID != instances[0].ID {
+		t.Fatal("Did not get correct Instance ID")
 	}
 
-	wls[0].Storage = []types.StorageResource{s}
+	_, err = ctl.OpenConsole(ctx, instances[0].ID)
+	if err != types.ErrConsoleInUse {
+		t.Fatalf("Expected %v, got %v", types.ErrConsoleInUse, err)
+	}
 
-	id := uuid.Generate()
+	sendConsoleOutputEvent(instances[0].ID, session.ID, "login: ", false, t)
 
-	ip := net.ParseIP("172.16.0.2")
+	serverCh = server.AddCmdChan(ssntp.CONSOLE)
 
-	_, err = newConfig(ctl, &wls[0], id.String(), tenant.ID, "test", ip)
+	session, err = ctl.WriteConsole(ctx, session.ID, "root\n")
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	wls[0].Storage = []types.StorageResource{}
-}
-
-func createTestVolume(tenantID string, size int, t *testing.T) string {
-	req := api.RequestedVolume{
-		Size: size,
+	if session.Output != "login: " || session.Closed {
+		t.Fatalf("Unexpected console session %+v", session)
 	}
 
-	vol, err := ctl.CreateVolume(tenantID, req)
+	_, err = server.GetCmdChanResult(serverCh, ssntp.CONSOLE)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if vol.TenantID != tenantID || vol.State != types.Available ||
-		vol.Size != size || vol.Bootable != false {
-		t.Fatalf("incorrect volume returned\n")
+	_, err = ctl.WriteConsole(ctx, session.ID, strings.Repeat("x", consoleInputLimit+1))
+	if err != types.ErrBadRequest {
+		t.Fatalf("Expected %v, got %v", types.ErrBadRequest, err)
 	}
 
-	return vol.ID
-}
+	serverCh = server.AddCmdChan(ssntp.CONSOLE)
 
-func TestCreateVolume(t *testing.T) {
-	tenant, err := addTestTenant()
+	err = ctl.CloseConsole(ctx, session.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	volID := createTestVolume(tenant.ID, 20, t)
-
-	// confirm that we can retrieve the volume from
-	// the datastore.
-	bd, err := ctl.ds.GetBlockDevice(volID)
+	_, err = server.GetCmdChanResult(serverCh, ssntp.CONSOLE)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if bd.State != types.Available || bd.TenantID != tenant.ID {
-		t.Fatalf("incorrect volume information stored\n")
+	_, err = ctl.WriteConsole(ctx, session.ID, "")
+	if err != types.ErrConsoleSessionNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrConsoleSessionNotFound, err)
 	}
-}
 
-func TestCreateImageVolume(t *testing.T) {
-	tenant, err := addTestTenant()
+	entries, err := ctl.ds.GetEventLog(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	imageRef := "test-image-id"
-	req := api.RequestedVolume{
-		ImageRef: imageRef,
+	expected := map[string]bool{
+		fmt.Sprintf("Console session %s opened on instance %s", session.ID, instances[0].ID): false,
+		fmt.Sprintf("Console session %s on instance %s closed", session.ID, instances[0].ID): false,
+	}
+	for _, e := range entries {
+		if _, ok := expected[e.Message]; ok && e.TenantID == instances[0].TenantID {
+			expected[e.Message] = true
+		}
+	}
+	for msg, found := range expected {
+		if !found {
+			t.Errorf("Did not find %q in Log", msg)
+		}
+	}
+
+	sendNodeStats(client.UUID, instances[0].ID, payloads.Paused, t)
+
+	_, err = ctl.OpenConsole(ctx, instances[0].ID)
+	if err != types.ErrInstanceNotRunning {
+		t.Fatalf("Expected %v opening the console of a paused instance, got %v", types.ErrInstanceNotRunning, err)
 	}
+}
+
+// Test consoles of containers
+//
+// Starts a container of a workload asking for a console and a container of
+// a workload which does not, and opens a console session with each.
+//
+// The console of the first container should be opened through the
+// launcher, the second container should have no console.  Test is expected
+// to pass.
+func TestContainerConsole(t *testing.T) {
+	ctx := context.Background()
 
-	vol, err := ctl.CreateVolume(tenant.ID, req)
+	tenant, err := addTestTenant(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// confirm that we can retrieve the volume from
-	// the datastore.
-	bd, err := ctl.ds.GetBlockDevice(vol.ID)
+	client, err := testutil.NewSsntpTestClientConnection("ContainerConsole", ssntp.AGENT, testutil.AgentUUID)
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer client.Shutdown()
 
-	if bd.State != types.Available || bd.TenantID != tenant.ID || bd.Bootable == false {
-		t.Fatalf("incorrect volume information stored\n")
+	for _, console := range []bool{true, false} {
+		wl, err := ctl.CreateWorkload(ctx, types.Workload{
+			TenantID:    tenant.ID,
+			Description: "console container",
+			VMType:      payloads.Docker,
+			ImageName:   "ubuntu:latest",
+			Config:      "#cloud-config\n",
+			Requirements: payloads.WorkloadRequirements{
+				VCPUs:   1,
+				MemMB:   128,
+				Console: console,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		clientCh := client.AddCmdChan(ssntp.START)
+		instances, err := ctl.startWorkload(ctx, types.WorkloadRequest{
+			WorkloadID: wl.ID,
+			TenantID:   tenant.ID,
+			Instances:  1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = client.GetCmdChanResult(clientCh, ssntp.START)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sendStatsCmd(client, t)
+
+		if !console {
+			_, err = ctl.OpenConsole(ctx, instances[0].ID)
+			if err != types.ErrNoConsole {
+				t.Fatalf("Expected %v, got %v", types.ErrNoConsole, err)
+			}
+			continue
+		}
+
+		serverCh := server.AddCmdChan(ssntp.CONSOLE)
+
+		session, err := ctl.OpenConsole(ctx, instances[0].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := server.GetCmdChanResult(serverCh, ssntp.CONSOLE)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.InstanceUUID != instances[0].ID {
+			t.Fatal("Did not get correct Instance ID")
+		}
+
+		err = ctl.CloseConsole(ctx, session.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
 	}
 }
 
-func TestDeleteVolume(t *testing.T) {
-	tenant, err := addTestTenant()
+func TestConsoleClosedByLauncher(t *testing.T) {
+	ctx := context.Background()
+
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	sendStatsCmd(client, t)
+
+	serverCh := server.AddCmdChan(ssntp.CONSOLE)
+
+	session, err := ctl.OpenConsole(ctx, instances[0].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	volID := createTestVolume(tenant.ID, 20, t)
-
-	// confirm that we can retrieve the volume from
-	// the datastore.
-	_, err = ctl.ds.GetBlockDevice(volID)
+	_, err = server.GetCmdChanResult(serverCh, ssntp.CONSOLE)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// attempt to delete invalid volume
-	err = ctl.DeleteVolume(tenant.ID, "badID")
-	if err != datastore.ErrNoBlockData {
-		t.Fatal("Incorrect error")
-	}
+	sendConsoleOutputEvent(instances[0].ID, session.ID, "Power down.", true, t)
 
-	// add second tenant to datastore to prevent CNCI launching.
-	tenant2, err := addTestTenant()
+	session, err = ctl.WriteConsole(ctx, session.ID, "")
 	if err != nil {
 		t.Fatal(err)
 	}
+	if session.Output != "Power down." || !session.Closed {
+		t.Fatalf("Unexpected console session %+v", session)
+	}
 
-	// attempt to delete with bad tenant ID
-	err = ctl.DeleteVolume(tenant2.ID, volID)
-	if err != api.ErrVolumeOwner {
-		t.Fatal("Incorrect error")
+	// closed sessions are forgotten once their output has been read.
+	_, err = ctl.WriteConsole(ctx, session.ID, "")
+	if err != types.ErrConsoleSessionNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrConsoleSessionNotFound, err)
 	}
+}
 
-	// this should work
-	err = ctl.DeleteVolume(tenant.ID, volID)
-	if err != nil {
-		t.Fatal(err)
+func sendGuestAgentResultEvent(result payloads.GuestAgentResultEvent, t *testing.T) {
+	event := payloads.EventGuestAgentResult{
+		GuestAgentResult: result,
 	}
 
-	// confirm that we cannot retrieve the volume from
-	// the datastore.
-	_, err = ctl.ds.GetBlockDevice(volID)
-	if err != datastore.ErrNoBlockData {
+	y, err := yaml.Marshal(&event)
+	if err != nil {
 		t.Fatal(err)
 	}
+
+	ctl.client.EventNotify(ssntp.GuestAgentResult, &ssntp.Frame{Payload: y})
 }
 
-func TestShowVolumeDetails(t *testing.T) {
-	tenant, err := addTestTenant()
-	if err != nil {
-		t.Fatal(err)
+func TestGuestAgentCommand(t *testing.T) {
+	ctx := context.Background()
+
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	sendStatsCmd(client, t)
+
+	tenantID := instances[0].TenantID
+	req := types.GuestAgentRequest{
+		Operation: types.GuestAgentExec,
+		Path:      "/bin/uname",
+		Args:      []string{"-r"},
 	}
 
-	volID := createTestVolume(tenant.ID, 20, t)
+	_, err := ctl.GuestAgentCommand(ctx, tenantID, instances[0].ID, req)
+	if err != types.ErrGuestAgentNotPermitted {
+		t.Fatalf("Expected %v, got %v", types.ErrGuestAgentNotPermitted, err)
+	}
 
-	vol, err := ctl.ShowVolumeDetails(tenant.ID, volID)
+	err = ctl.ds.JSONPatchTenant(ctx, tenantID, []byte(`{"permissions":{"guest_agent":true}}`))
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if vol.ID != volID {
-		t.Fatal("wrong volume retrieved")
-	}
-}
+	serverCh := server.AddCmdChan(ssntp.GUESTAGENT)
 
-func TestListVolumesDetail(t *testing.T) {
-	tenant, err := addTestTenant()
+	errCh := make(chan error)
+	resultCh := make(chan types.GuestAgentResult)
+	go func() {
+		result, err := ctl.GuestAgentCommand(ctx, tenantID, instances[0].ID, req)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		resultCh <- result
+	}()
+
+	result, err := server.GetCmdChanResult(serverCh, ssntp.GUESTAGENT)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if result.InstanceUUID != instances[0].ID {
+		t.Fatal("Did not get correct Instance ID")
+	}
 
-	_ = createTestVolume(tenant.ID, 20, t)
+	var request string
+	ctl.guestAgentLock.Lock()
+	for r := range ctl.guestAgentCalls {
+		request = r
+	}
+	ctl.guestAgentLock.Unlock()
 
-	vols, err := ctl.ListVolumesDetail(tenant.ID)
-	if err != nil {
+	sendGuestAgentResultEvent(payloads.GuestAgentResultEvent{
+		InstanceUUID: instances[0].ID,
+		RequestUUID:  request,
+		ExitCode:     1,
+		Stdout:       "4.13.0\n",
+	}, t)
+
+	select {
+	case err := <-errCh:
 		t.Fatal(err)
+	case r := <-resultCh:
+		if r.InstanceID != instances[0].ID || r.ExitCode != 1 || r.Stdout != "4.13.0\n" {
+			t.Fatalf("Unexpected guest agent result %+v", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for guest agent result")
 	}
 
-	if len(vols) != 1 {
-		t.Fatal("Incorrect number of volumes returned")
+	ctl.guestAgentLock.Lock()
+	calls := len(ctl.guestAgentCalls)
+	ctl.guestAgentLock.Unlock()
+	if calls != 0 {
+		t.Fatalf("%d guest agent requests still pending", calls)
+	}
+
+	sendNodeStats(client.UUID, instances[0].ID, payloads.Paused, t)
+
+	_, err = ctl.GuestAgentCommand(ctx, tenantID, instances[0].ID, req)
+	if err != types.ErrInstanceNotRunning {
+		t.Fatalf("Expected %v using the guest agent of a paused instance, got %v", types.ErrInstanceNotRunning, err)
 	}
 }
 
-func testAddPool(t *testing.T, name string, subnet *string, ips []string) {
-	pool, err := ctl.AddPool(name, subnet, ips)
+// sendPacketCaptureResultEvent streams a PacketCaptureResult event in two
+// chunks, the last one first, as launchers stream them.
+func sendPacketCaptureResultEvent(result payloads.PacketCaptureResultEvent, t *testing.T) {
+	event := payloads.EventPacketCaptureResult{
+		PacketCaptureResult: result,
+	}
+
+	y, err := yaml.Marshal(&event)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if pool.ID == "" {
-		t.Fatal("id not set")
-	}
+	origin := uuid.Generate()
+	half := len(y) / 2
+	ctl.client.EventNotify(ssntp.PacketCaptureResult, &ssntp.Frame{
+		Origin:  origin,
+		Payload: y[half:],
+		Chunk:   &ssntp.FrameChunk{Stream: 1, Index: 1, Last: true},
+	})
+	ctl.client.EventNotify(ssntp.PacketCaptureResult, &ssntp.Frame{
+		Origin:  origin,
+		Payload: y[:half],
+		Chunk:   &ssntp.FrameChunk{Stream: 1, Index: 0},
+	})
+}
 
-	expected := types.Pool{
-		ID:   pool.ID,
-		Name: name,
-	}
+func TestPacketCaptureInstance(t *testing.T) {
+	ctx := context.Background()
 
-	if subnet != nil {
-		if pool.Subnets[0].ID == "" {
-			t.Fatal("subnet id not created")
-		}
+	var reason payloads.StartFailureReason
 
-		sub := types.ExternalSubnet{
-			ID:   pool.Subnets[0].ID,
-			CIDR: *subnet,
-		}
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
 
-		expected.Subnets = []types.ExternalSubnet{sub}
+	sendStatsCmd(client, t)
 
-		_, ipNet, err := net.ParseCIDR(*subnet)
-		if err != nil {
-			t.Fatal(err)
-		}
+	serverCh := server.AddCmdChan(ssntp.CAPTURE)
 
-		ones, bits := ipNet.Mask.Size()
-		expected.TotalIPs = (1 << uint32(bits-ones)) - 2
-		expected.Free = expected.TotalIPs
-	} else if len(ips) > 0 {
-		// not an easy way to check this, so we're going to
-		// do some manual tests
-		if pool.TotalIPs != len(ips) ||
-			pool.Free != len(ips) ||
-			len(pool.IPs) != len(ips) {
-			t.Fatal("External IPs not handled correctly")
-		}
-		return
-	}
+	tenantID := instances[0].TenantID
+	req := types.PacketCaptureRequest{Filter: "icmp"}
 
-	if reflect.DeepEqual(expected, pool) == false {
-		t.Fatalf("expected %v, got %v\n", expected, pool)
-	}
-}
+	errCh := make(chan error)
+	resultCh := make(chan types.PacketCaptureResult)
+	go func() {
+		result, err := ctl.PacketCaptureInstance(ctx, tenantID, instances[0].ID, req)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		resultCh <- result
+	}()
 
-func deletePool(name string) error {
-	pools, err := ctl.ListPools()
+	result, err := server.GetCmdChanResult(serverCh, ssntp.CAPTURE)
 	if err != nil {
-		return err
+		t.Fatal(err)
+	}
+	if result.InstanceUUID != instances[0].ID {
+		t.Fatal("Did not get correct Instance ID")
 	}
 
-	if len(pools) < 1 {
-		return types.ErrPoolNotFound
+	var capture string
+	ctl.captureLock.Lock()
+	for c := range ctl.captureCalls {
+		capture = c
 	}
+	ctl.captureLock.Unlock()
 
-	for _, pool := range pools {
-		if pool.Name == name {
-			return ctl.DeletePool(pool.ID)
+	pcap := []byte{0xd4, 0xc3, 0xb2, 0xa1, 2, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0, 0, 1, 0, 0, 0}
+	sendPacketCaptureResultEvent(payloads.PacketCaptureResultEvent{
+		InstanceUUID: instances[0].ID,
+		CaptureUUID:  capture,
+		Truncated:    true,
+		Pcap:         base64.StdEncoding.EncodeToString(pcap),
+	}, t)
+
+	select {
+	case err := <-errCh:
+		t.Fatal(err)
+	case r := <-resultCh:
+		if r.InstanceID != instances[0].ID || !r.Truncated || !bytes.Equal(r.Pcap, pcap) {
+			t.Fatalf("Unexpected packet capture result %+v", r)
 		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for packet capture result")
 	}
 
-	return types.ErrPoolNotFound
-}
+	ctl.captureLock.Lock()
+	calls := len(ctl.captureCalls)
+	ctl.captureLock.Unlock()
+	if calls != 0 {
+		t.Fatalf("%d packet captures still pending", calls)
+	}
 
-func TestAddPoolWithSubnet(t *testing.T) {
-	subnet := "192.168.0.0/16"
-	testAddPool(t, "test1", &subnet, []string{})
-	err := deletePool("test1")
-	if err != nil {
-		t.Fatal(err)
+	sendNodeStats(client.UUID, instances[0].ID, payloads.Paused, t)
+
+	_, err = ctl.PacketCaptureInstance(ctx, tenantID, instances[0].ID, req)
+	if err != types.ErrInstanceNotRunning {
+		t.Fatalf("Expected %v capturing the traffic of a paused instance, got %v", types.ErrInstanceNotRunning, err)
 	}
 }
 
-func TestAddPoolWithIPs(t *testing.T) {
-	ips := []string{"10.10.0.1", "10.10.0.2"}
-	testAddPool(t, "test2", nil, ips)
-	err := deletePool("test2")
-	if err != nil {
-		t.Fatal(err)
+func sendMigrationStatusEvent(status payloads.MigrationStatusEvent, t *testing.T) {
+	event := payloads.EventMigrationStatus{
+		MigrationStatus: status,
 	}
-}
 
-func TestAddPool(t *testing.T) {
-	testAddPool(t, "test3", nil, []string{})
-	err := deletePool("test3")
+	y, err := yaml.Marshal(&event)
 	if err != nil {
 		t.Fatal(err)
 	}
+
+	ctl.client.EventNotify(ssntp.MigrationStatus, &ssntp.Frame{Payload: y})
 }
 
-func TestListPools(t *testing.T) {
-	testAddPool(t, "listPoolTest", nil, []string{})
+func TestMigrateInstance(t *testing.T) {
+	ctx := context.Background()
 
-	pools, err := ctl.ListPools()
-	if err != nil {
-		t.Fatal(err)
-	}
+	var reason payloads.StartFailureReason
 
-	if len(pools) < 1 {
-		t.Fatal("Unable to retrieve pools")
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	sendStatsCmd(client, t)
+
+	tenantID := instances[0].TenantID
+	ID := instances[0].ID
+	target := uuid.Generate().String()
+
+	_, err := ctl.MigrateServer(ctx, tenantID, ID, client.UUID)
+	if err != types.ErrMigrateSameNode {
+		t.Fatalf("Expected %v migrating an instance to its own node, got %v", types.ErrMigrateSameNode, err)
 	}
 
-	for _, pool := range pools {
-		if pool.Name == "listPoolTest" {
-			err := ctl.DeletePool(pool.ID)
-			if err != nil {
-				t.Fatal(err)
-			}
-			return
+	for _, fail := range []bool{true, false} {
+		serverCh := server.AddCmdChan(ssntp.START)
+
+		op, err := ctl.MigrateServer(ctx, tenantID, ID, "")
+		if err != nil {
+			t.Fatal(err)
 		}
-	}
 
-	t.Fatal("Could not list pools")
-}
+		if op.Type != types.InstanceMigrate || op.State != types.OperationRunning {
+			t.Fatalf("Unexpected migrate operation %+v", op)
+		}
 
-func TestShowPool(t *testing.T) {
-	testAddPool(t, "showPoolTest", nil, []string{})
+		result, err := server.GetCmdChanResult(serverCh, ssntp.START)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.InstanceUUID != ID {
+			t.Fatal("Did not get correct Instance ID")
+		}
 
-	pools, err := ctl.ListPools()
-	if err != nil {
-		t.Fatal(err)
-	}
+		_, err = ctl.MigrateServer(ctx, tenantID, ID, "")
+		if err != types.ErrInstanceMigrating {
+			t.Fatalf("Expected %v migrating a migrating instance, got %v", types.ErrInstanceMigrating, err)
+		}
 
-	if len(pools) < 1 {
-		t.Fatal("Unable to retrieve pools")
-	}
+		err = ctl.DeleteServer(ctx, tenantID, ID)
+		if err != types.ErrInstanceMigrating {
+			t.Fatalf("Expected %v deleting a migrating instance, got %v", types.ErrInstanceMigrating, err)
+		}
 
-	for _, pool := range pools {
-		if pool.Name == "showPoolTest" {
-			_, err := ctl.ShowPool(pool.ID)
+		serverCh = server.AddCmdChan(ssntp.MIGRATE)
+
+		sendMigrationStatusEvent(payloads.MigrationStatusEvent{
+			InstanceUUID: ID,
+			NodeUUID:     target,
+			State:        payloads.MigrationIncoming,
+			Address:      testutil.MigrationAddress,
+		}, t)
+
+		result, err = server.GetCmdChanResult(serverCh, ssntp.MIGRATE)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.InstanceUUID != ID || result.NodeUUID != client.UUID {
+			t.Fatalf("Unexpected migrate command %+v", result)
+		}
+
+		status := payloads.MigrationStatusEvent{
+			InstanceUUID: ID,
+			NodeUUID:     client.UUID,
+			State:        payloads.MigrationCompleted,
+		}
+		if fail {
+			status.State = payloads.MigrationFailed
+			status.Error = "Migration did not complete"
+		}
+		sendMigrationStatusEvent(status, t)
+
+		for n := 0; n < 50 && op.State == types.OperationRunning; n++ {
+			time.Sleep(100 * time.Millisecond)
+			op, err = ctl.ds.GetOperation(op.ID)
 			if err != nil {
 				t.Fatal(err)
 			}
+		}
 
-			err = ctl.DeletePool(pool.ID)
-			if err != nil {
-				t.Fatal(err)
+		i, err := ctl.ds.GetInstance(ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fail {
+			if op.State != types.OperationFailed || op.Error != status.Error {
+				t.Fatalf("Expected failed migrate operation, got %+v", op)
 			}
+			if i.NodeID != client.UUID || i.State != payloads.Running {
+				t.Fatalf("Expected instance running on source node, got %s on %s", i.State, i.NodeID)
+			}
+			continue
+		}
 
-			return
+		if op.State != types.OperationSucceeded {
+			t.Fatalf("Expected successful migrate operation, got %+v", op)
+		}
+		if i.NodeID != target || i.State != payloads.Running {
+			t.Fatalf("Expected instance running on target node, got %s on %s", i.State, i.NodeID)
 		}
 	}
-
-	t.Fatal("Could not show pool")
 }
 
-func TestDeletePool(t *testing.T) {
-	testAddPool(t, "deletePoolTest", nil, []string{})
+func sendReachabilityEvent(cnciID string, tenantID string, IP string, reachable bool, t *testing.T) {
+	event := payloads.EventInstanceReachability{
+		InstanceReachability: payloads.InstanceReachabilityEvent{
+			ConcentratorUUID: cnciID,
+			TenantUUID:       tenantID,
+			IPs: []payloads.IPReachability{
+				{
+					IP:        IP,
+					Reachable: reachable,
+				},
+			},
+		},
+	}
 
-	pools, err := ctl.ListPools()
+	y, err := yaml.Marshal(&event)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(pools) < 1 {
-		t.Fatal("Unable to retrieve pools")
-	}
+	ctl.client.EventNotify(ssntp.InstanceReachability, &ssntp.Frame{Payload: y})
+}
 
-	for _, pool := range pools {
-		if pool.Name == "deletePoolTest" {
-			err := ctl.DeletePool(pool.ID)
-			if err != nil {
-				t.Fatal(err)
-			}
+func TestInstanceReachability(t *testing.T) {
+	ctx := context.Background()
 
-			_, err = ctl.ShowPool(pool.ID)
-			if err != types.ErrPoolNotFound {
-				t.Fatal("Pool not deleted")
-			}
-			return
-		}
-	}
+	var reason payloads.StartFailureReason
 
-	t.Fatal("Could not delete pool")
-}
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
 
-func TestAddPoolSubnet(t *testing.T) {
-	subnet := "192.168.0.0/24"
+	sendStatsCmd(client, t)
 
-	testAddPool(t, "addsubnet", nil, []string{})
+	tenantID := instances[0].TenantID
+	tenant, err := ctl.ds.GetTenant(ctx, tenantID)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	pools, err := ctl.ListPools()
+	cnci, err := tenant.CNCIctrl.GetInstanceCNCI(instances[0].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(pools) < 1 {
-		t.Fatal("Unable to retrieve pools")
+	server, err := ctl.ShowServerDetails(ctx, tenantID, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.Server.Reachable != nil || server.Server.ProbeTime != nil {
+		t.Fatal("Reachability of unprobed instance reported")
 	}
 
-	for _, pool := range pools {
-		if pool.Name == "addsubnet" {
-			err := ctl.AddAddress(pool.ID, &subnet, []string{})
-			if err != nil {
-				t.Fatal(err)
-			}
+	for _, reachable := range []bool{true, false} {
+		sendReachabilityEvent(cnci.ID, tenantID, instances[0].IPAddress, reachable, t)
 
-			p1, err := ctl.ShowPool(pool.ID)
-			if err != nil {
-				t.Fatal(err)
-			}
+		server, err = ctl.ShowServerDetails(ctx, tenantID, instances[0].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if server.Server.Reachable == nil || *server.Server.Reachable != reachable ||
+			server.Server.ProbeTime == nil {
+			t.Fatalf("Expected instance reachability %v, got %v", reachable, server.Server.Reachable)
+		}
+	}
 
-			// we should have a our subnet.
-			if p1.Subnets[0].CIDR != subnet {
-				t.Fatalf("expectd %s subnet got %s", subnet, p1.Subnets[0].CIDR)
-			}
+	entries, err := ctl.ds.GetEventLog(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-			err = ctl.DeletePool(pool.ID)
-			if err != nil {
-				t.Fatal(err)
-			}
+	expectedMsg := fmt.Sprintf("Instance %s is running but no longer reachable at %s",
+		instances[0].ID, instances[0].IPAddress)
+
+	for i := range entries {
+		if entries[i].Message == expectedMsg {
 			return
 		}
 	}
-
+	t.Error("Did not find lost reachability in Log")
 }
 
-func TestAddPoolAddress(t *testing.T) {
-	address := "192.168.1.1"
+func TestInstanceFailure(t *testing.T) {
+	ctx := context.Background()
 
-	testAddPool(t, "addaddress", nil, []string{})
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	sendStatsCmd(client, t)
 
-	pools, err := ctl.ListPools()
+	tenantID := instances[0].TenantID
+
+	server, err := ctl.ShowServerDetails(ctx, tenantID, instances[0].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	if len(pools) < 1 {
-		t.Fatal("Unable to retrieve pools")
+	if server.Server.Failure != nil {
+		t.Fatalf("Failure of running instance reported: %+v", server.Server.Failure)
 	}
 
-	for _, pool := range pools {
-		if pool.Name == "addaddress" {
-			err := ctl.AddAddress(pool.ID, nil, []string{address})
-			if err != nil {
-				t.Fatal(err)
-			}
+	failure := payloads.ErrorDeleteFailure{
+		NodeUUID:     client.UUID,
+		InstanceUUID: instances[0].ID,
+		Reason:       payloads.DeleteInvalidData,
+	}
 
-			p1, err := ctl.ShowPool(pool.ID)
-			if err != nil {
-				t.Fatal(err)
-			}
+	y, err := yaml.Marshal(&failure)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-			// we should have a our subnet.
-			if p1.IPs[0].Address != address {
-				t.Fatalf("expected %s address got %s", address, p1.IPs[0].Address)
-			}
+	ctl.client.ErrorNotify(ssntp.DeleteFailure, &ssntp.Frame{Payload: y})
 
-			err = ctl.DeletePool(pool.ID)
-			if err != nil {
-				t.Fatal(err)
-			}
-			return
-		}
+	server, err = ctl.ShowServerDetails(ctx, tenantID, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
 	}
 
+	f := server.Server.Failure
+	if f == nil || f.Code != types.FailureInvalidRequest || f.Operation != types.FailureDelete ||
+		f.Reason != string(payloads.DeleteInvalidData) {
+		t.Fatalf("Expected invalid request delete failure, got %+v", f)
+	}
 }
 
-func TestRemovePoolSubnet(t *testing.T) {
-	subnet := "192.168.0.0/24"
-	address := "192.168.1.1"
+func TestPreemptibleWorkload(t *testing.T) {
+	ctx := context.Background()
 
-	testAddPool(t, "addsubnet", &subnet, []string{})
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatal(err)
+	}
 
-	pools, err := ctl.ListPools()
+	w := types.WorkloadRequest{
+		WorkloadID:  wls[0].ID,
+		TenantID:    tenant.ID,
+		Instances:   1,
+		Preemptible: true,
+	}
+	instances, err := ctl.startWorkload(ctx, w)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	var pool types.Pool
+	if !instances[0].Preemptible {
+		t.Fatal("Instance not marked as preemptible")
+	}
 
-	for _, pool = range pools {
-		if pool.Name == "addsubnet" {
-			// make sure the subnet is there.
-			for _, sub := range pool.Subnets {
-				if sub.CIDR == subnet {
-					err := ctl.RemoveAddress(pool.ID, &sub.ID, nil)
-					if err != nil {
-						t.Fatalf("%s: %v\n", err, pool.Subnets)
-					}
-				}
-			}
-		}
-		break
+	qds := ctl.qs.DumpQuotas(tenant.ID)
+
+	memMB := (wls[0].Requirements.MemMB + 1) / 2
+	if qd := findQuota(qds, "tenant-mem-quota"); qd == nil || qd.Usage != memMB {
+		t.Fatalf("Expected memory usage of %d", memMB)
+	}
+
+	vcpus := (wls[0].Requirements.VCPUs + 1) / 2
+	if qd := findQuota(qds, "tenant-vcpu-quota"); qd == nil || qd.Usage != vcpus {
+		t.Fatalf("Expected VCPU usage of %d", vcpus)
 	}
+}
+
+func TestInstanceGroup(t *testing.T) {
+	ctx := context.Background()
 
-	p1, err := ctl.ShowPool(pool.ID)
+	tenant, err := addTestTenant(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(p1.Subnets) != 0 || p1.TotalIPs != 0 || p1.Free != 0 {
-		fmt.Printf("pool %v\n", p1)
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatal(err)
+	}
+
+	w := types.WorkloadRequest{
+		WorkloadID: wls[0].ID,
+		TenantID:   tenant.ID,
+		Instances:  2,
+		Group:      "web",
+	}
+	instances, err := ctl.startWorkload(ctx, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := ctl.ListInstanceGroups(ctx, tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Name != "web" || len(groups[0].Instances) != 2 ||
+		groups[0].WorkloadID != wls[0].ID {
+		t.Fatalf("Expected group web with 2 instances, got %+v", groups)
+	}
+
+	req := api.InstanceGroupActionRequest{Action: api.GroupScale, Instances: 3}
+	result, err := ctl.InstanceGroupAction(ctx, tenant.ID, "web", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Results) != 1 || result.Results[0].Operation != "create" ||
+		result.Results[0].InstanceID == "" || result.Results[0].Error != "" {
+		t.Fatalf("Expected 1 instance created, got %+v", result.Results)
+	}
+	created := result.Results[0].InstanceID
+
+	group, err := ctl.ShowInstanceGroup(ctx, tenant.ID, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(group.Instances) != 3 || group.Instances[2] != created {
+		t.Fatalf("Expected %s to be the newest of 3 instances, got %v", created, group.Instances)
+	}
+
+	// pending instances can be neither stopped nor deleted
+	req = api.InstanceGroupActionRequest{Action: api.GroupStop}
+	result, err = ctl.InstanceGroupAction(ctx, tenant.ID, "web", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range result.Results {
+		if !r.Skipped {
+			t.Fatalf("Expected pending instances to be skipped, got %+v", result.Results)
+		}
+	}
+
+	req = api.InstanceGroupActionRequest{Action: api.GroupScale, Instances: 1}
+	result, err = ctl.InstanceGroupAction(ctx, tenant.ID, "web", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Results) != 2 || result.Results[0].InstanceID != created ||
+		result.Results[1].InstanceID != group.Instances[1] {
+		t.Fatalf("Expected the 2 newest instances to be deleted, got %+v", result.Results)
+	}
+	for _, r := range result.Results {
+		if r.Operation != "delete" || r.Error != types.ErrInstanceNotAssigned.Error() {
+			t.Fatalf("Expected delete failures, got %+v", result.Results)
+		}
+	}
+
+	req = api.InstanceGroupActionRequest{Action: api.GroupScale, Instances: -1}
+	_, err = ctl.InstanceGroupAction(ctx, tenant.ID, "web", req)
+	if err != types.ErrBadRequest {
+		t.Fatalf("Expected bad request, got %v", err)
+	}
+
+	req = api.InstanceGroupActionRequest{Action: "reboot"}
+	_, err = ctl.InstanceGroupAction(ctx, tenant.ID, "web", req)
+	if err != types.ErrBadRequest {
+		t.Fatalf("Expected bad request, got %v", err)
+	}
+
+	_, err = ctl.ShowInstanceGroup(ctx, tenant.ID, "db")
+	if err != types.ErrInstanceGroupNotFound {
+		t.Fatalf("Expected group not found, got %v", err)
+	}
+
+	for _, i := range instances {
+		if i.Group != "web" {
+			t.Fatalf("Instance %s not in group web", i.ID)
+		}
+	}
+}
+
+func checkLastInstanceAction(t *testing.T, instanceID string, state string, initiator types.InstanceActionInitiator) {
+	ctx := context.Background()
+
+	i, err := ctl.ds.GetInstance(instanceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actions, err := ctl.ListInstanceActions(ctx, i.TenantID, instanceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(actions) == 0 {
+		t.Fatal("No instance actions recorded")
+	}
+
+	action := actions[len(actions)-1]
+	if action.State != state || action.Initiator != initiator {
+		t.Fatalf("Expected %s transition by %s, got %v", state, initiator, action)
+	}
+}
+
+func TestEvacuateNode(t *testing.T) {
+	ctx := context.Background()
+
+	client, err := testutil.NewSsntpTestClientConnection("EvacuateNode", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	serverCh := server.AddCmdChan(ssntp.EVACUATE)
+
+	// ok to not send workload first?
+
+	op, err := ctl.EvacuateNode(ctx, client.UUID)
+	if err != nil {
+		t.Error(err)
+	}
+
+	result, err := server.GetCmdChanResult(serverCh, ssntp.EVACUATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.NodeUUID != client.UUID {
+		t.Fatal("Did not get node ID")
+	}
+
+	_, err = ctl.ShowOperation(ctx, "admin", op.ID)
+	if err != nil {
+		t.Fatalf("Evacuation operation not found: %v", err)
+	}
+}
+
+func sendNodeStats(nodeID string, instanceID string, state string, t *testing.T) {
+	stats := testutil.StatsPayload(nodeID, "evacuated", []payloads.InstanceStat{
+		{InstanceUUID: instanceID, State: state},
+	}, nil)
+
+	y, err := yaml.Marshal(&stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctl.client.CommandNotify(ssntp.STATS, &ssntp.Frame{Payload: y})
+}
+
+// Test the completion of node evacuations
+//
+// Reports an instance running on a node, evacuates the node and then
+// reports the instance stopped.
+//
+// The evacuation operation should only succeed once the instance is
+// reported stopped.  Test is expected to pass.
+func TestEvacuateNodeDrained(t *testing.T) {
+	ctx := context.Background()
+
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	nodeID := uuid.Generate().String()
+	sendNodeStats(nodeID, instances[0].ID, payloads.Running, t)
+
+	serverCh := server.AddCmdChan(ssntp.EVACUATE)
+
+	op, err := ctl.EvacuateNode(ctx, nodeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = server.GetCmdChanResult(serverCh, ssntp.EVACUATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctl.completeNodeEvacuation(nodeID)
+
+	op, err = ctl.ShowOperation(ctx, "admin", op.ID)
+	if err != nil || op.State != types.OperationRunning {
+		t.Fatalf("Expected evacuation of a node running instances to be running, got %s: %v", op.State, err)
+	}
+
+	sendNodeStats(nodeID, instances[0].ID, payloads.Exited, t)
+
+	op, err = ctl.ShowOperation(ctx, "admin", op.ID)
+	if err != nil || op.State != types.OperationSucceeded {
+		t.Fatalf("Expected evacuation of a drained node to succeed, got %s: %v", op.State, err)
+	}
+}
+
+func TestNodePolicy(t *testing.T) {
+	ctx := context.Background()
+
+	serverCh := server.AddCmdChan(ssntp.NodePolicy)
+
+	policy := types.NodePolicy{
+		NodeID:              testutil.AgentUUID,
+		Weight:              50,
+		MaxInstances:        10,
+		AllowCNCIColocation: true,
+	}
+
+	err := ctl.UpdateNodePolicy(ctx, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := server.GetCmdChanResult(serverCh, ssntp.NodePolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.NodeUUID != testutil.AgentUUID {
+		t.Fatal("Did not get node ID")
+	}
+
+	policies, err := ctl.ListNodePolicies(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 1 || policies[0] != policy {
+		t.Fatalf("Unexpected node policies %v", policies)
+	}
+
+	policy.Weight = payloads.MaxNodeWeight + 1
+	if err := ctl.UpdateNodePolicy(ctx, policy); err != types.ErrBadRequest {
+		t.Fatalf("Expected ErrBadRequest, got %v", err)
+	}
+
+	policy.Weight = 50
+	policy.DedicatedTenant = uuid.Generate().String()
+	if err := ctl.UpdateNodePolicy(ctx, policy); err != types.ErrTenantNotFound {
+		t.Fatalf("Expected ErrTenantNotFound dedicating node to unknown tenant, got %v", err)
+	}
+
+	tenant, err := addTestTenantNoCNCI(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverCh = server.AddCmdChan(ssntp.NodePolicy)
+
+	policy.DedicatedTenant = tenant.ID
+	err = ctl.UpdateNodePolicy(ctx, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = server.GetCmdChanResult(serverCh, ssntp.NodePolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the nodes of deleted tenants are shared again
+	serverCh = server.AddCmdChan(ssntp.NodePolicy)
+
+	err = ctl.releaseDedicatedNodes(ctx, tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = server.GetCmdChanResult(serverCh, ssntp.NodePolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policies, err = ctl.ListNodePolicies(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 1 || policies[0].DedicatedTenant != "" {
+		t.Fatalf("Unexpected node policies %v", policies)
+	}
+
+	serverCh = server.AddCmdChan(ssntp.NodePolicy)
+
+	err = ctl.DeleteNodePolicy(ctx, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = server.GetCmdChanResult(serverCh, ssntp.NodePolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policies, err = ctl.ListNodePolicies(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 0 {
+		t.Fatalf("Expected no node policies, got %v", policies)
+	}
+}
+
+func TestRestoreNode(t *testing.T) {
+	ctx := context.Background()
+
+	client, err := testutil.NewSsntpTestClientConnection("RestoreNode", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	serverCh := server.AddCmdChan(ssntp.Restore)
+
+	err = ctl.RestoreNode(ctx, client.UUID)
+	if err != nil {
+		t.Error(err)
+	}
+
+	result, err := server.GetCmdChanResult(serverCh, ssntp.Restore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.NodeUUID != client.UUID {
+		t.Fatal("Did not get node ID")
+	}
+}
+
+func TestAttachVolume(t *testing.T) {
+	client, err := testutil.NewSsntpTestClientConnection("AttachVolume", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Ssntp.Close()
+
+	serverCh := server.AddCmdChan(ssntp.AttachVolume)
+
+	// ok to not send workload first?
+
+	err = ctl.client.attachVolume("volID", "instanceID", client.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := server.GetCmdChanResult(serverCh, ssntp.AttachVolume)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.NodeUUID != client.UUID {
+		t.Fatal("Did not get node ID")
+	}
+
+	if result.VolumeUUID != "volID" {
+		t.Fatal("Did not get volume ID")
+	}
+
+	if result.InstanceUUID != "instanceID" {
+		t.Fatal("Did not get instance ID")
+	}
+}
+
+func addTestBlockDevice(t *testing.T, tenantID string) types.Volume {
+	ctx := context.Background()
+
+	bd, err := ctl.CreateBlockDevice("", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := types.Volume{
+		BlockDevice: bd,
+		CreateTime:  time.Now(),
+		TenantID:    tenantID,
+		State:       types.Available,
+	}
+
+	err = ctl.ds.AddBlockDevice(ctx, data)
+	if err != nil {
+		_ = ctl.DeleteBlockDevice(bd.ID)
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// Note: caller should close ssntp client
+func doAttachVolumeCommand(t *testing.T, fail bool) (client *testutil.SsntpTestClient, tenant string, volume string, instanceID string) {
+	ctx := context.Background()
+
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+
+	tenantID := instances[0].TenantID
+
+	sendStatsCmd(client, t)
+
+	data := addTestBlockDevice(t, tenantID)
+
+	serverCh := server.AddCmdChan(ssntp.AttachVolume)
+	agentCh := client.AddCmdChan(ssntp.AttachVolume)
+	var serverErrorCh chan testutil.Result
+	var controllerCh chan struct{}
+
+	if fail == true {
+		serverErrorCh = server.AddErrorChan(ssntp.AttachVolumeFailure)
+		controllerCh = wrappedClient.addErrorChan(ssntp.AttachVolumeFailure)
+		client.AttachFail = true
+		client.AttachVolumeFailReason = payloads.AttachVolumeAlreadyAttached
+
+		defer func() {
+			client.AttachFail = false
+			client.AttachVolumeFailReason = ""
+		}()
+	}
+
+	err := ctl.AttachVolume(ctx, tenantID, data.ID, instances[0].ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := server.GetCmdChanResult(serverCh, ssntp.AttachVolume)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.InstanceUUID != instances[0].ID ||
+		result.NodeUUID != client.UUID ||
+		result.VolumeUUID != data.ID {
+		t.Fatalf("expected %s %s %s, got %s %s %s", instances[0].ID, client.UUID, data.ID, result.InstanceUUID, result.NodeUUID, result.VolumeUUID)
+	}
+
+	if fail == true {
+		_, err = client.GetCmdChanResult(agentCh, ssntp.AttachVolume)
+		if err == nil {
+			t.Fatal("Success when Failure expected")
+		}
+
+		_, err = server.GetErrorChanResult(serverErrorCh, ssntp.AttachVolumeFailure)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = wrappedClient.getErrorChan(controllerCh, ssntp.AttachVolumeFailure)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// at this point, the state of the block device should
+		// be set back to available.
+		data2, err := ctl.ds.GetBlockDevice(data.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if data2.State != types.Available {
+			t.Fatalf("block device state not updated")
+		}
+	} else {
+		_, err = client.GetCmdChanResult(agentCh, ssntp.AttachVolume)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return client, tenantID, data.ID, instances[0].ID
+}
+
+func TestAttachVolumeCommand(t *testing.T) {
+	client, _, _, _ := doAttachVolumeCommand(t, false)
+	client.Ssntp.Close()
+}
+
+func TestAttachVolumeFailure(t *testing.T) {
+	client, _, _, _ := doAttachVolumeCommand(t, true)
+	client.Ssntp.Close()
+}
+
+// findFailedCommand returns the failed command recorded for an instance
+// or nil if there is none.
+func findFailedCommand(t *testing.T, cmd ssntp.Command, instanceID string) *types.FailedCommand {
+	ctx := context.Background()
+
+	cmds, err := ctl.ListFailedCommands(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range cmds {
+		if cmds[i].Command == cmd.String() && cmds[i].InstanceID == instanceID {
+			return &cmds[i]
+		}
+	}
+
+	return nil
+}
+
+func TestReplayFailedCommand(t *testing.T) {
+	ctx := context.Background()
+
+	client, _, volume, instanceID := doAttachVolumeCommand(t, true)
+	defer client.Ssntp.Close()
+
+	failed := findFailedCommand(t, ssntp.AttachVolume, instanceID)
+	if failed == nil {
+		t.Fatal("Failed volume attach not recorded")
+	}
+
+	reason := payloads.AttachVolumeFailureReason(payloads.AttachVolumeAlreadyAttached)
+	if failed.Reason != reason.String() {
+		t.Errorf("Expected failure reason %s, got %s", reason, failed.Reason)
+	}
+
+	serverCh := server.AddCmdChan(ssntp.AttachVolume)
+	agentCh := client.AddCmdChan(ssntp.AttachVolume)
+
+	err := ctl.ReplayFailedCommand(ctx, failed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := server.GetCmdChanResult(serverCh, ssntp.AttachVolume)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.GetCmdChanResult(agentCh, ssntp.AttachVolume)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.InstanceUUID != instanceID || result.VolumeUUID != volume {
+		t.Fatalf("expected %s %s, got %s %s", instanceID, volume, result.InstanceUUID, result.VolumeUUID)
+	}
+
+	data, err := ctl.ds.GetBlockDevice(volume)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data.State != types.Attaching {
+		t.Fatalf("Expected volume to be attaching, got %s", data.State)
+	}
+
+	if findFailedCommand(t, ssntp.AttachVolume, instanceID) != nil {
+		t.Fatal("Replayed command not removed")
+	}
+
+	err = ctl.ReplayFailedCommand(ctx, failed.ID)
+	if err != types.ErrFailedCommandNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrFailedCommandNotFound, err)
+	}
+}
+
+// Test the sequence of replayed commands
+//
+// Stamps recorded DELETE, PAUSE and UNPAUSE payloads with a new sequence.
+//
+// The payloads should carry the new sequence and keep their instance, and
+// the payloads of commands which are not sequenced should be left alone.
+// Test is expected to pass.
+func TestResequencePayload(t *testing.T) {
+	instanceID := uuid.Generate().String()
+	const sequence = 42
+
+	recorded := []interface{}{
+		&payloads.Delete{Delete: payloads.StopCmd{InstanceUUID: instanceID, Stop: true, Sequence: 1}},
+		&payloads.Pause{Pause: payloads.PauseCmd{InstanceUUID: instanceID, Sequence: 1}},
+		&payloads.Unpause{Unpause: payloads.PauseCmd{InstanceUUID: instanceID, Sequence: 1}},
+	}
+	expected := []interface{}{
+		&payloads.Delete{Delete: payloads.StopCmd{InstanceUUID: instanceID, Stop: true, Sequence: sequence}},
+		&payloads.Pause{Pause: payloads.PauseCmd{InstanceUUID: instanceID, Sequence: sequence}},
+		&payloads.Unpause{Unpause: payloads.PauseCmd{InstanceUUID: instanceID, Sequence: sequence}},
+	}
+
+	for i, cmd := range []ssntp.Command{ssntp.DELETE, ssntp.PAUSE, ssntp.UNPAUSE} {
+		y, err := yaml.Marshal(recorded[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		y, err = resequencePayload(cmd, y, sequence)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		replayed := reflect.New(reflect.TypeOf(recorded[i]).Elem()).Interface()
+		err = yaml.Unmarshal(y, replayed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(replayed, expected[i]) {
+			t.Errorf("Expected replayed %s payload %+v, got %+v", cmd, expected[i], replayed)
+		}
+	}
+
+	evacuate := []byte("evacuate:\n  workload_agent_uuid: node\n")
+	y, err := resequencePayload(ssntp.EVACUATE, evacuate, sequence)
+	if err != nil || !bytes.Equal(y, evacuate) {
+		t.Errorf("Expected EVACUATE payload to be left alone, got %q: %v", y, err)
+	}
+}
+
+func TestDeleteFailedCommand(t *testing.T) {
+	ctx := context.Background()
+
+	instanceID := uuid.Generate().String()
+
+	ctl.recordFailedCommand(ctx, ssntp.DELETE, []byte("delete: {}\n"), instanceID, "", "Connection closed")
+
+	failed := findFailedCommand(t, ssntp.DELETE, instanceID)
+	if failed == nil {
+		t.Fatal("Failed command not recorded")
+	}
+
+	err := ctl.DeleteFailedCommand(ctx, failed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if findFailedCommand(t, ssntp.DELETE, instanceID) != nil {
+		t.Fatal("Failed command not deleted")
+	}
+
+	err = ctl.DeleteFailedCommand(ctx, failed.ID)
+	if err != types.ErrFailedCommandNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrFailedCommandNotFound, err)
+	}
+}
+
+func doDetachVolumeCommand(t *testing.T, fail bool) {
+	ctx := context.Background()
+
+	// attach volume should succeed for this test
+	client, tenantID, volume, instanceID := doAttachVolumeCommand(t, false)
+	defer client.Ssntp.Close()
+
+	sendStatsCmd(client, t)
+
+	data, err := ctl.ds.GetBlockDevice(volume)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data.State != types.InUse {
+		t.Fatalf("expected state %s, got %s\n", types.Detaching, data.State)
+	}
+
+	if fail {
+		err := ctl.DetachVolume(ctx, tenantID, volume, "")
+		if err == nil {
+			t.Fatal("Expected error when detaching volume from active instance")
+
+		}
+
+		data, err := ctl.ds.GetBlockDevice(volume)
+		if err != nil {
+			t.Fatal(err)
+
+		}
+
+		if data.State != types.InUse {
+			t.Fatalf("expected state %s, got %s\n", types.Detaching, data.State)
+		}
+	} else {
+		serverCh := server.AddCmdChan(ssntp.DELETE)
+		clientCh := client.AddCmdChan(ssntp.DELETE)
+
+		err := ctl.stopInstance(ctx, instanceID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := server.GetCmdChanResult(serverCh, ssntp.DELETE)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = client.GetCmdChanResult(clientCh, ssntp.DELETE)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.InstanceUUID != instanceID {
+			t.Fatal("Did not get correct Instance ID")
+		}
+
+		err = sendStopEvent(client, instanceID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ctl.DetachVolume(ctx, tenantID, volume, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ctl.ds.GetBlockDevice(volume)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if data.State != types.Available {
+			t.Fatalf("expected state %s, got %s\n", types.Detaching, data.State)
+		}
+	}
+}
+
+func TestDetachVolumeCommand(t *testing.T) {
+	doDetachVolumeCommand(t, false)
+}
+
+func TestDetachVolumeFailure(t *testing.T) {
+	doDetachVolumeCommand(t, true)
+}
+
+func TestDetachVolumeByAttachment(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.DetachVolume(ctx, tenant.ID, "invalidVolume", "attachmentID")
+	if err == nil {
+		t.Fatal("Detach by attachment ID not supported yet")
+	}
+}
+
+func TestInstanceDeletedEvent(t *testing.T) {
+	ctx := context.Background()
+
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	sendStatsCmd(client, t)
+
+	serverCh := server.AddCmdChan(ssntp.DELETE)
+
+	err := ctl.deleteInstance(ctx, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = server.GetCmdChanResult(serverCh, ssntp.DELETE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientEvtCh := client.AddEventChan(ssntp.InstanceDeleted)
+	serverEvtCh := server.AddEventChan(ssntp.InstanceDeleted)
+	controllerCh := wrappedClient.addEventChan(ssntp.InstanceDeleted)
+	go client.SendDeleteEvent(instances[0].ID)
+	_, err = client.GetEventChanResult(clientEvtCh, ssntp.InstanceDeleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = server.GetEventChanResult(serverEvtCh, ssntp.InstanceDeleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wrappedClient.getEventChan(controllerCh, ssntp.InstanceDeleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// try to get instance info
+	_, err = ctl.ds.GetInstance(instances[0].ID)
+	if err == nil {
+		t.Error("Instance not deleted")
+	}
+}
+
+func TestStartFailure(t *testing.T) {
+	reason := payloads.FullCloud
+
+	client, _ := testStartWorkload(t, 1, true, reason)
+	defer client.Shutdown()
+
+	// since we had a start failure, we should confirm that the
+	// instance is no longer pending in the database
+}
+
+func TestStopFailure(t *testing.T) {
+	ctx := context.Background()
+
+	err := ctl.ds.ClearLog(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	client.DeleteFail = true
+	client.DeleteFailReason = payloads.DeleteNoInstance
+
+	sendStatsCmd(client, t)
+
+	serverCh := server.AddCmdChan(ssntp.DELETE)
+	controllerCh := wrappedClient.addErrorChan(ssntp.DeleteFailure)
+
+	err = ctl.stopInstance(ctx, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := server.GetCmdChanResult(serverCh, ssntp.DELETE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wrappedClient.getErrorChan(controllerCh, ssntp.DeleteFailure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.InstanceUUID != instances[0].ID {
+		t.Fatal("Did not get correct Instance ID")
+	}
+}
+
+func TestRestartFailure(t *testing.T) {
+	ctx := context.Background()
+
+	err := ctl.ds.ClearLog(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	client.StartFail = true
+	client.StartFailReason = payloads.LaunchFailure
+
+	sendStatsCmd(client, t)
+
+	serverCh := server.AddCmdChan(ssntp.DELETE)
+	clientCh := client.AddCmdChan(ssntp.DELETE)
+
+	err = ctl.stopInstance(ctx, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sendStopEvent(client, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.GetCmdChanResult(clientCh, ssntp.DELETE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := server.GetCmdChanResult(serverCh, ssntp.DELETE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.InstanceUUID != instances[0].ID {
+		t.Fatal("Did not get correct Instance ID")
+	}
+
+	sendStatsCmd(client, t)
+
+	serverCh = server.AddCmdChan(ssntp.START)
+	controllerCh := wrappedClient.addErrorChan(ssntp.StartFailure)
+
+	err = ctl.restartInstance(ctx, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = server.GetCmdChanResult(serverCh, ssntp.START)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wrappedClient.getErrorChan(controllerCh, ssntp.StartFailure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.InstanceUUID != instances[0].ID {
+		t.Fatal("Did not get correct Instance ID")
+	}
+
+	failed := findFailedCommand(t, ssntp.START, instances[0].ID)
+	if failed == nil {
+		t.Fatal("Failed restart not recorded")
+	}
+
+	if failed.Reason != client.StartFailReason.String() {
+		t.Errorf("Expected failure reason %s, got %s", client.StartFailReason, failed.Reason)
+	}
+
+	// the response to a restart failure is to log the failure
+	entries, err := ctl.ds.GetEventLog(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedMsg := fmt.Sprintf("Start Failure %s: %s", instances[0].ID, client.StartFailReason.String())
+
+	for i := range entries {
+		if entries[i].Message == expectedMsg {
+			return
+		}
+	}
+	t.Error("Did not find failure message in Log")
+}
+
+// NOTE: the caller is responsible for calling Shutdown() on the *SsntpTestClient
+func testStartTracedWorkload(t *testing.T) *testutil.SsntpTestClient {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := testutil.NewSsntpTestClientConnection("StartTracedWorkload", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// caller of TestStartTracedWorkload() owns doing the close
+	//defer client.Shutdown()
+
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wls) == 0 {
+		t.Fatal("No workloads, expected len(wls) > 0, got len(wls) == 0")
+	}
+
+	clientCh := client.AddCmdChan(ssntp.START)
+	serverCh := server.AddCmdChan(ssntp.START)
+
+	w := types.WorkloadRequest{
+		WorkloadID: wls[0].ID,
+		TenantID:   tenant.ID,
+		Instances:  1,
+		TraceLabel: "testtrace",
+	}
+	instances, err := ctl.startWorkload(ctx, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 1 {
+		t.Fatalf("Wrong number of instances, expected 1, got %d", len(instances))
+	}
+
+	_, err = client.GetCmdChanResult(clientCh, ssntp.START)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := server.GetCmdChanResult(serverCh, ssntp.START)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.InstanceUUID != instances[0].ID {
+		t.Fatal("Did not get correct Instance ID")
+	}
+
+	return client
+}
+
+// NOTE: the caller is responsible for calling Shutdown() on the *SsntpTestClient
+func testStartWorkload(t *testing.T, num int, fail bool, reason payloads.StartFailureReason) (*testutil.SsntpTestClient, []*types.Instance) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := testutil.NewSsntpTestClientConnection("StartWorkload", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// caller of TestStartWorkload() owns doing the close
+	//defer client.Shutdown()
+
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wls) == 0 {
+		t.Fatal("No workloads, expected len(wls) > 0, got len(wls) == 0")
+	}
+
+	clientCmdCh := client.AddCmdChan(ssntp.START)
+	clientErrCh := client.AddErrorChan(ssntp.StartFailure)
+	client.StartFail = fail
+	client.StartFailReason = reason
+
+	w := types.WorkloadRequest{
+		WorkloadID: wls[0].ID,
+		TenantID:   tenant.ID,
+		Instances:  num,
+		Name:       "test",
+	}
+	instances, err := ctl.startWorkload(ctx, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != num {
+		t.Fatalf("Wrong number of instances, expected %d, got %d", len(instances), num)
+	}
+
+	if fail == true {
+		_, err := client.GetErrorChanResult(clientErrCh, ssntp.StartFailure)
+		if err == nil { // unexpected success
+			t.Fatal(err)
+		}
+	}
+
+	result, err := client.GetCmdChanResult(clientCmdCh, ssntp.START)
+	if fail == true && err == nil { // unexpected success
+		t.Fatal(err)
+	}
+	if fail == false && err != nil { // unexpected failure
+		t.Fatal(err)
+	}
+	if result.InstanceUUID != instances[0].ID {
+		t.Fatal("Did not get correct Instance ID")
+	}
+
+	return client, instances
+}
+
+func startTestWorkload(t *testing.T, instanceCh chan []*types.Instance, workloadID string, tenantID string, num int) {
+	ctx := context.Background()
+
+	w := types.WorkloadRequest{
+		WorkloadID: workloadID,
+		TenantID:   tenantID,
+		Instances:  num,
+	}
+	instances, err := ctl.startWorkload(ctx, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(instances) != num {
+		t.Fatalf("Wrong number of instances, expected %d, got %d", num, len(instances))
+	}
+
+	instanceCh <- instances
+}
+
+func startTenantWorkload(t *testing.T, tenantID string, instanceCh chan []*types.Instance) {
+	wls, err := ctl.ds.GetWorkloads(tenantID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(wls) == 0 {
+		t.Fatal("No workloads for this tenant")
+	}
+
+	startTestWorkload(t, instanceCh, wls[0].ID, tenantID, 1)
+}
+
+// NOTE: the caller is responsible for calling Shutdown() on the *SsntpTestClient
+func testStartWorkloadLaunchCNCI(t *testing.T, num int) (*testutil.SsntpTestClient, *testutil.SsntpTestClient, []*types.Instance) {
+	ctx := context.Background()
+
+	netClient, err := testutil.NewSsntpTestClientConnection("StartWorkloadLaunchCNCI", ssntp.NETAGENT, testutil.NetAgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := testutil.NewSsntpTestClientConnection("StartWorkload", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// caller of TestStartWorkloadLaunchCNCI owns doing the close.
+
+	tt, err := addTestTenantNoCNCI(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newTenant := tt.ID
+
+	// caller of testStartWorkloadLaunchCNCI() owns doing the close
+	//defer netClient.Shutdown()
+
+	serverCmdCh := server.AddCmdChan(ssntp.START)
+	netClientCmdCh := netClient.AddCmdChan(ssntp.START)
+	clientCmdCh := client.AddCmdChan(ssntp.START)
+
+	// trigger the START command flow, and await results
+	instanceCh := make(chan []*types.Instance)
+
+	go startTenantWorkload(t, newTenant, instanceCh)
+
+	_, err = netClient.GetCmdChanResult(netClientCmdCh, ssntp.START)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := server.GetCmdChanResult(serverCmdCh, ssntp.START)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.TenantUUID != newTenant {
+		t.Fatal("Did not get correct tenant ID")
+	}
+
+	if !result.CNCI {
+		t.Fatal("this is not a CNCI launch request")
+	}
+
+	// start a test CNCI client
+	cnciClient, err := testutil.NewSsntpTestClientConnection("StartWorkloadLaunchCNCI", ssntp.CNCIAGENT, newTenant)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// make CNCI send an ssntp.ConcentratorInstanceAdded event, and await results
+	cnciEventCh := cnciClient.AddEventChan(ssntp.ConcentratorInstanceAdded)
+	serverEventCh := server.AddEventChan(ssntp.ConcentratorInstanceAdded)
+	tenantCNCI, _ := ctl.ds.GetTenantCNCISummary(result.InstanceUUID)
+	go cnciClient.SendConcentratorAddedEvent(result.InstanceUUID, newTenant, testutil.CNCIIP, tenantCNCI[0].MACAddress)
+	result, err = cnciClient.GetEventChanResult(cnciEventCh, ssntp.ConcentratorInstanceAdded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = server.GetEventChanResult(serverEventCh, ssntp.ConcentratorInstanceAdded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// shutdown the test CNCI client
+	cnciClient.Shutdown()
+
+	if result.InstanceUUID != tenantCNCI[0].InstanceID {
+		t.Fatalf("Did not get correct Instance ID, got %s, expected %s", result.InstanceUUID, tenantCNCI[0].InstanceID)
+	}
+
+	result, err = client.GetCmdChanResult(clientCmdCh, ssntp.START)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.TenantUUID != newTenant {
+		t.Fatal("Did not get correct tenant ID")
+	}
+
+	instances := <-instanceCh
+	if instances == nil {
+		t.Fatal("did not receive instance")
+	}
+
+	return netClient, client, instances
+}
+
+func TestGetStorageForVolume(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sourceVolume := addTestBlockDevice(t, tenant.ID)
+	defer func() { _ = ctl.DeleteBlockDevice(sourceVolume.ID) }()
+
+	// a temporary in memory filesystem?
+	s := types.StorageResource{
+		ID:         "",
+		Bootable:   true,
+		Ephemeral:  false,
+		SourceType: types.VolumeService,
+		Source:     sourceVolume.ID,
+	}
+
+	pl, err := getStorage(ctx, ctl, s, tenant.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pl.ID == "" {
+		t.Errorf("storage ID does not exist")
+	}
+
+	if pl.Bootable != true {
+		t.Errorf("bootable flag not correct")
+	}
+
+	if pl.Ephemeral != false {
+		t.Errorf("ephemeral flag not correct")
+	}
+
+	createdVolume, err := ctl.ds.GetBlockDevice(pl.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if createdVolume.State != types.Available {
+		t.Fatal("Expected newly created block device to be available")
+	}
+}
+
+func TestGetStorageForImage(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// add fake image to images store
+	//
+	tmpfile, err := ioutil.TempFile("", "testImage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Remove(tmpfile.Name()) }()
+
+	// a temporary in memory filesystem?
+	s := types.StorageResource{
+		ID:         "",
+		Bootable:   true,
+		Ephemeral:  false,
+		SourceType: types.ImageService,
+		Source:     filepath.Base(tmpfile.Name()),
+	}
+
+	pl, err := getStorage(ctx, ctl, s, tenant.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pl.ID == "" {
+		t.Errorf("storage ID does not exist")
+	}
+
+	if pl.Bootable != true {
+		t.Errorf("bootable flag not correct")
+	}
+
+	if pl.Ephemeral != false {
+		t.Errorf("ephemeral flag not correct")
+	}
+
+	createdVolume, err := ctl.ds.GetBlockDevice(pl.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if createdVolume.State != types.Available {
+		t.Fatal("Expected newly created block device to be available")
+	}
+
+	s.CDROM = true
+	pl, err = getStorage(ctx, ctl, s, tenant.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pl.CDROM != true {
+		t.Errorf("cdrom flag not correct")
+	}
+}
+
+func TestStorageConfig(t *testing.T) {
+	ctx := context.Background()
+
+	var err error
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// get workload ID
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatal(err)
+	}
+
+	tmpfile, err := ioutil.TempFile("", "test-image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Remove(tmpfile.Name()) }()
+
+	info, err := tmpfile.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a temporary in memory filesystem?
+	s := types.StorageResource{
+		ID:         "",
+		Bootable:   true,
+		Ephemeral:  false,
+		SourceType: types.ImageService,
+		Source:     info.Name(),
+	}
+
+	wls[0].Storage = []types.StorageResource{s}
+
+	id := uuid.Generate()
+
+	ip := net.ParseIP("172.16.0.2")
+
+	_, err = newConfig(ctx, ctl, &wls[0], id.String(), tenant.ID, "test", ip, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls[0].Storage = []types.StorageResource{}
+}
+
+func createTestVolume(tenantID string, size int, t *testing.T) string {
+	ctx := context.Background()
+
+	req := api.RequestedVolume{
+		Size: size,
+	}
+
+	vol, err := ctl.CreateVolume(ctx, tenantID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.TenantID != tenantID || vol.State != types.Available ||
+		vol.Size != size || vol.Bootable != false {
+		t.Fatalf("incorrect volume returned\n")
+	}
+
+	return vol.ID
+}
+
+func TestCreateVolume(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volID := createTestVolume(tenant.ID, 20, t)
+
+	// confirm that we can retrieve the volume from
+	// the datastore.
+	bd, err := ctl.ds.GetBlockDevice(volID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bd.State != types.Available || bd.TenantID != tenant.ID {
+		t.Fatalf("incorrect volume information stored\n")
+	}
+}
+
+func TestUniqueVolumeNames(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vol, err := ctl.CreateVolume(ctx, tenant.ID, api.RequestedVolume{Size: 20, Name: "data"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ctl.CreateVolume(ctx, tenant.ID, api.RequestedVolume{Size: 20, Name: "data"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ctl.ShowVolumeByName(ctx, tenant.ID, "data")
+	if err != types.ErrAmbiguousName {
+		t.Fatalf("Expected ErrAmbiguousName, got %v", err)
+	}
+
+	ctl.uniqueNames = true
+	defer func() { ctl.uniqueNames = false }()
+
+	_, err = ctl.CreateVolume(ctx, tenant.ID, api.RequestedVolume{Size: 20, Name: "data"})
+	if nameErr, ok := err.(types.NameConflictError); !ok || nameErr.Resource != volumeResource {
+		t.Fatalf("Expected volume name conflict, got %v", err)
+	}
+
+	_, err = ctl.CreateVolume(ctx, tenant.ID, api.RequestedVolume{SourceVolID: vol.ID, Name: "data"})
+	if _, ok := err.(types.NameConflictError); !ok {
+		t.Fatalf("Expected volume name conflict copying volume, got %v", err)
+	}
+
+	other, err := ctl.CreateVolume(ctx, tenant.ID, api.RequestedVolume{Size: 20, Name: "logs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := ctl.ShowVolumeByName(ctx, tenant.ID, "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.ID != other.ID {
+		t.Fatalf("Expected volume %s, got %s", other.ID, found.ID)
+	}
+
+	_, err = ctl.ShowVolumeByName(ctx, tenant.ID, "unknown")
+	if err != types.ErrVolumeNotFound {
+		t.Fatalf("Expected ErrVolumeNotFound, got %v", err)
+	}
+}
+
+func TestCreateVolumeStorageClass(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctl.storageClasses = map[string]string{"ssd": "ciao-ssd"}
+	defer func() { ctl.storageClasses = nil }()
+
+	_, err = ctl.CreateVolume(ctx, tenant.ID, api.RequestedVolume{Size: 20, Class: "hdd"})
+	if err != types.ErrBadRequest {
+		t.Fatalf("expected ErrBadRequest for unknown storage class, got %v\n", err)
+	}
+
+	vol, err := ctl.CreateVolume(ctx, tenant.ID, api.RequestedVolume{Size: 20, Class: "ssd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bd, err := ctl.ds.GetBlockDevice(vol.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bd.Class != "ssd" || ctl.volumePool(vol.ID) != "ciao-ssd" {
+		t.Fatalf("incorrect volume storage class stored\n")
+	}
+
+	found := false
+	for _, q := range ctl.qs.DumpQuotas(tenant.ID) {
+		if q.Name == "tenant-storage-ssd-quota" {
+			found = true
+			if q.Usage != 20 {
+				t.Fatalf("expected 20GiB of ssd storage used, got %d\n", q.Usage)
+			}
+		}
+	}
+
+	if !found {
+		t.Fatalf("storage class quota not reported\n")
+	}
+
+	_, err = ctl.CreateVolume(ctx, tenant.ID, api.RequestedVolume{
+		SourceVolID: vol.ID,
+		Size:        20,
+		Class:       "hdd",
+	})
+	if err != types.ErrBadRequest {
+		t.Fatalf("expected ErrBadRequest copying to another storage class, got %v\n", err)
+	}
+
+	err = ctl.DeleteVolume(ctx, tenant.ID, vol.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateEncryptedVolume(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ctl.CreateVolume(ctx, tenant.ID, api.RequestedVolume{
+		ImageRef:  "73a86d7e-93c0-480e-9c41-ab42f69b7799",
+		Encrypted: true,
+	})
+	if err != types.ErrBadRequest {
+		t.Fatalf("expected ErrBadRequest for encrypted image volume, got %v\n", err)
+	}
+
+	vol, err := ctl.CreateVolume(ctx, tenant.ID, api.RequestedVolume{Size: 20, Encrypted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ctl.volumeKey(ctx, vol.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !vol.Encrypted || key == "" {
+		t.Fatalf("encrypted volume has no key\n")
+	}
+
+	secrets, err := ctl.ListSecrets(ctx, tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(secrets) != 0 {
+		t.Fatalf("volume key visible to the tenant: %v\n", secrets)
+	}
+
+	err = ctl.SetSecret(ctx, tenant.ID, volumeKeyName(vol.ID), "value")
+	if err != types.ErrBadRequest {
+		t.Fatalf("expected ErrBadRequest overwriting a volume key, got %v\n", err)
+	}
+
+	err = ctl.DeleteSecret(ctx, tenant.ID, volumeKeyName(vol.ID))
+	if err != types.ErrSecretNotFound {
+		t.Fatalf("expected ErrSecretNotFound deleting a volume key, got %v\n", err)
+	}
+
+	copied, err := ctl.CreateVolume(ctx, tenant.ID, api.RequestedVolume{SourceVolID: vol.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bd := waitForVolumeState(copied.ID, types.Available, t)
+	copyKey, err := ctl.volumeKey(ctx, copied.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bd.Encrypted || copyKey != key {
+		t.Fatalf("copy of encrypted volume does not share its key\n")
+	}
+
+	plainID := createTestVolume(tenant.ID, 20, t)
+	_, err = ctl.CreateVolume(ctx, tenant.ID, api.RequestedVolume{SourceVolID: plainID, Encrypted: true})
+	if err != types.ErrBadRequest {
+		t.Fatalf("expected ErrBadRequest encrypting a copy, got %v\n", err)
+	}
+
+	for _, ID := range []string{vol.ID, copied.ID} {
+		err = ctl.DeleteVolume(ctx, tenant.ID, ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = ctl.ds.GetSecret(ctx, tenant.ID, volumeKeyName(ID))
+		if err != types.ErrSecretNotFound {
+			t.Fatalf("key of deleted volume %s not removed: %v\n", ID, err)
+		}
+	}
+}
+
+// gcTestDriver is a block driver which reports a fixed set of block
+// devices and records the ones which are deleted.
+type gcTestDriver struct {
+	storage.BlockDriver
+	devices []string
+	deleted map[string]bool
+}
+
+func (d *gcTestDriver) ListBlockDevices() ([]string, error) {
+	var devices []string
+	for _, ID := range d.devices {
+		if !d.deleted[ID] {
+			devices = append(devices, ID)
+		}
+	}
+	return devices, nil
+}
+
+func (d *gcTestDriver) DeleteBlockDevice(ID string) error {
+	d.deleted[ID] = true
+	return nil
+}
+
+func createTestImage(tenantID string, name string, state types.ImageState, t *testing.T) string {
+	ctx := context.Background()
+
+	image, err := ctl.CreateImage(ctx, tenantID, api.CreateImageRequest{
+		Name:       name,
+		Visibility: types.Private,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image.State = state
+	err = ctl.ds.UpdateImage(ctx, image)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return image.ID
+}
+
+func TestCollectImageGarbage(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volID := createTestVolume(tenant.ID, 20, t)
+	activeID := createTestImage(tenant.ID, "gc-active", types.Active, t)
+	killedID := createTestImage(tenant.ID, "gc-killed", types.Killed, t)
+	interruptedID := createTestImage(tenant.ID, "gc-interrupted", types.Saving, t)
+	uploadingID := createTestImage(tenant.ID, "gc-uploading", types.Saving, t)
+	orphanID := uuid.Generate().String()
+
+	driver := &gcTestDriver{
+		BlockDriver: ctl.BlockDriver,
+		devices: []string{volID, activeID, killedID, interruptedID,
+			uploadingID, orphanID, "not-a-ciao-device"},
+		deleted: make(map[string]bool),
+	}
+	ctl.BlockDriver = driver
+	defer func() { ctl.BlockDriver = driver.BlockDriver }()
+
+	ctl.startImageUpload(uploadingID)
+	defer ctl.endImageUpload(uploadingID)
+
+	orphans := []string{killedID, interruptedID, orphanID}
+	sort.Strings(orphans)
+
+	checkReport := func(report types.ImageGCReport, state types.ImageGCState) {
+		if len(report.Devices) != len(orphans) {
+			t.Fatalf("Expected %d orphaned devices, got %d", len(orphans), len(report.Devices))
+		}
+
+		for i, entry := range report.Devices {
+			if entry.ID != orphans[i] || entry.State != state {
+				t.Fatalf("Expected %s to be %s, got %s %s", orphans[i], state, entry.ID, entry.State)
+			}
+		}
+	}
+
+	now := time.Now()
+	report, err := ctl.collectImageGarbage(ctx, now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReport(report, types.ImageGCQuarantined)
+
+	if len(driver.deleted) != 0 {
+		t.Fatal("Quarantined devices deleted")
+	}
+
+	image, err := ctl.ds.GetImage(interruptedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image.State != types.Killed {
+		t.Fatalf("Expected interrupted image to be %s, got %s", types.Killed, image.State)
+	}
+
+	report, err = ctl.collectImageGarbage(ctx, now.Add(2*time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReport(report, types.ImageGCRemoved)
+
+	for _, ID := range orphans {
+		if !driver.deleted[ID] {
+			t.Fatalf("Orphaned device %s not deleted", ID)
+		}
+	}
+
+	if len(driver.deleted) != len(orphans) {
+		t.Fatal("Referenced devices deleted")
+	}
+
+	report, err = ctl.ShowImageGC(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReport(report, types.ImageGCRemoved)
+}
+
+// imageDataTestDriver is a block driver whose block devices all contain
+// the same data.
+type imageDataTestDriver struct {
+	storage.BlockDriver
+	data []byte
+}
+
+func (d *imageDataTestDriver) ReadBlockDevice(volumeUUID string, data io.Writer) error {
+	_, err := data.Write(d.data)
+	return err
+}
+
+func TestImageDownload(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	activeID := createTestImage(tenant.ID, "download-active", types.Active, t)
+	savingID := createTestImage(tenant.ID, "download-saving", types.Saving, t)
+
+	driver := &imageDataTestDriver{
+		BlockDriver: ctl.BlockDriver,
+		data:        []byte("image data"),
+	}
+	ctl.BlockDriver = driver
+	defer func() { ctl.BlockDriver = driver.BlockDriver }()
+
+	r := imageRoutes(ctl, mux.NewRouter())
+
+	var tests = []struct {
+		imageID string
+		status  int
+	}{
+		{activeID, http.StatusOK},
+		{savingID, http.StatusForbidden},
+		{uuid.Generate().String(), http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		url := fmt.Sprintf("/%s/images/%s/file", tenant.ID, test.imageID)
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		if rr.Code != test.status {
+			t.Fatalf("Expected %d downloading %s, got %d: %s",
+				test.status, test.imageID, rr.Code, rr.Body.String())
+		}
+
+		if test.status == http.StatusOK && !bytes.Equal(rr.Body.Bytes(), driver.data) {
+			t.Fatalf("Expected image data %q, got %q", driver.data, rr.Body.Bytes())
+		}
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/images/%s/file", activeID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected %d downloading as a non admin, got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+// imageUploadTestDriver is a block driver which records the data of the
+// block devices created from a file, which are converted, and from a
+// stream, which are not.
+type imageUploadTestDriver struct {
+	storage.BlockDriver
+	converted []byte
+	streamed  []byte
+}
+
+func (d *imageUploadTestDriver) CreateBlockDevice(volumeUUID string, image string, sizeGB int) (storage.BlockDevice, error) {
+	data, err := ioutil.ReadFile(image)
+	if err != nil {
+		return storage.BlockDevice{}, err
+	}
+	d.converted = data
+	return storage.BlockDevice{ID: volumeUUID}, nil
+}
+
+func (d *imageUploadTestDriver) CreateBlockDeviceFromStream(volumeUUID string, data io.Reader) (storage.BlockDevice, error) {
+	streamed, err := ioutil.ReadAll(data)
+	if err != nil {
+		return storage.BlockDevice{}, err
+	}
+	d.streamed = streamed
+	return storage.BlockDevice{ID: volumeUUID}, nil
+}
+
+// Test the upload of raw and qcow2 images
+//
+// Uploads an image starting with a qcow2 header and a raw image.
+//
+// The qcow2 image should be staged in a file so that it is converted when
+// its block device is created, and the raw image streamed into its block
+// device.  Test is expected to pass.
+func TestUploadImageFormats(t *testing.T) {
+	var tests = []struct {
+		data    []byte
+		convert bool
+	}{
+		{append([]byte{'Q', 'F', 'I', 0xfb, 0, 0, 0, 3}, make([]byte, 1024)...), true},
+		{[]byte("raw image data"), false},
+	}
+
+	for _, test := range tests {
+		driver := &imageUploadTestDriver{BlockDriver: ctl.BlockDriver}
+		ctl.BlockDriver = driver
+
+		err := ctl.uploadImage(uuid.Generate().String(), bytes.NewReader(test.data))
+		ctl.BlockDriver = driver.BlockDriver
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected, other := driver.streamed, driver.converted
+		if test.convert {
+			expected, other = driver.converted, driver.streamed
+		}
+
+		if !bytes.Equal(expected, test.data) || other != nil {
+			t.Fatalf("Expected image %q to be converted %v", test.data[:4], test.convert)
+		}
+	}
+}
+
+func TestGetImageDisabled(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imageID := createTestImage(tenant.ID, "disabled-image", types.Active, t)
+
+	ctl.imagesDisabled = true
+	defer func() { ctl.imagesDisabled = false }()
+
+	_, err = ctl.GetImage(ctx, tenant.ID, imageID)
+	if err != api.ErrImageServiceDisabled {
+		t.Fatalf("Expected %v, got %v", api.ErrImageServiceDisabled, err)
+	}
+}
+
+func waitForImageImport(imageID string, t *testing.T) (types.Image, types.Operation) {
+	for i := 0; i < 50; i++ {
+		image, err := ctl.ds.GetImage(imageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if image.State != types.Saving {
+			ops := ctl.ds.GetResourceOperations(imageID, types.ImageImport)
+			if len(ops) != 1 {
+				t.Fatalf("Expected 1 import operation, got %d", len(ops))
+			}
+			return image, ops[0]
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	t.Fatalf("Image %s import did not complete", imageID)
+	return types.Image{}, types.Operation{}
+}
+
+func TestImportImage(t *testing.T) {
+	ctx := context.Background()
+
+	data := []byte("test image data")
+	sum := sha256.Sum256(data)
+	checksum := "sha256:" + hex.EncodeToString(sum[:])
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/image.img" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer ts.Close()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		url      string
+		checksum string
+		state    types.ImageState
+	}{
+		{"import-checksum", ts.URL + "/image.img", checksum, types.Active},
+		{"import-no-checksum", ts.URL + "/image.img", "", types.Active},
+		{"import-bad-checksum", ts.URL + "/image.img", "sha256:" + hex.EncodeToString(make([]byte, sha256.Size)), types.Killed},
+		{"import-not-found", ts.URL + "/missing.img", "", types.Killed},
+	}
+
+	for _, test := range tests {
+		image, err := ctl.CreateImage(ctx, tenant.ID, api.CreateImageRequest{
+			Name:       test.name,
+			Visibility: types.Private,
+			URL:        test.url,
+			Checksum:   test.checksum,
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if image.State != types.Saving {
+			t.Fatalf("%s: expected image to be %s, got %s", test.name, types.Saving, image.State)
+		}
+
+		image, op := waitForImageImport(image.ID, t)
+		if image.State != test.state {
+			t.Fatalf("%s: expected image to be %s, got %s", test.name, test.state, image.State)
+		}
+
+		if test.state == types.Active && (op.State != types.OperationSucceeded || op.Progress != 100) {
+			t.Fatalf("%s: import operation not completed: %+v", test.name, op)
+		} else if test.state == types.Killed && (op.State != types.OperationFailed || op.Error == "") {
+			t.Fatalf("%s: import operation not failed: %+v", test.name, op)
+		}
+	}
+}
+
+func TestImportImageInvalid(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []api.CreateImageRequest{
+		{Name: "import-scheme", URL: "file:///etc/passwd"},
+		{Name: "import-relative", URL: "/image.img"},
+		{Name: "import-algorithm", URL: "http://example.com/image.img", Checksum: "md4:00"},
+		{Name: "import-length", URL: "http://example.com/image.img", Checksum: "sha256:00"},
+		{Name: "import-no-url", Checksum: "sha256:00"},
+	}
+
+	for _, req := range invalid {
+		req.Visibility = types.Private
+		_, err := ctl.CreateImage(ctx, tenant.ID, req)
+		if err != types.ErrBadRequest {
+			t.Fatalf("%s: expected %v, got %v", req.Name, types.ErrBadRequest, err)
+		}
+	}
+}
+
+func TestCreateImageVolume(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imageRef := "test-image-id"
+	req := api.RequestedVolume{
+		ImageRef: imageRef,
+	}
+
+	vol, err := ctl.CreateVolume(ctx, tenant.ID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.State != types.Pending || vol.Bootable == false {
+		t.Fatalf("expected a pending bootable volume, got %s\n", vol.State)
+	}
+
+	// the clone operation only completes once the volume is
+	// available in the datastore.
+	op := waitForOperation(vol.ID, types.VolumeClone, t)
+	if op.State != types.OperationSucceeded || op.Progress != 100 || op.ID != vol.OperationID {
+		t.Fatalf("unexpected clone operation %+v\n", op)
+	}
+
+	bd, err := ctl.ShowVolumeDetails(ctx, tenant.ID, vol.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bd.State != types.Available || bd.TenantID != tenant.ID ||
+		bd.Bootable == false || bd.OperationID != op.ID {
+		t.Fatalf("incorrect volume information stored\n")
+	}
+}
+
+func TestCreateVolumeFromVolume(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sourceID := createTestVolume(tenant.ID, 20, t)
+
+	req := api.RequestedVolume{
+		SourceVolID: sourceID,
+		Size:        30,
+	}
+
+	vol, err := ctl.CreateVolume(ctx, tenant.ID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bd := waitForVolumeState(vol.ID, types.Available, t)
+
+	if bd.ID == sourceID || bd.Size != 30 || bd.Bootable == true {
+		t.Fatalf("incorrect volume information stored\n")
+	}
+
+	ops, err := ctl.ListOperations(ctx, tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ops) != 1 || ops[0].ResourceID != vol.ID ||
+		ops[0].Type != types.VolumeClone || ops[0].State != types.OperationSucceeded {
+		t.Fatalf("incorrect volume clone operation: %v\n", ops)
+	}
+
+	_, err = ctl.ShowOperation(ctx, uuid.Generate().String(), ops[0].ID)
+	if err != types.ErrOperationNotFound {
+		t.Fatalf("operation visible from another tenant\n")
+	}
+}
+
+func TestCreateVolumeFromVolumeQuota(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sourceID := createTestVolume(tenant.ID, 20, t)
+
+	ctl.qs.Update(tenant.ID, []types.QuotaDetails{
+		{Name: "tenant-storage-quota", Value: 40},
+	})
+	defer ctl.qs.Update(tenant.ID, []types.QuotaDetails{
+		{Name: "tenant-storage-quota", Value: -1},
+	})
+
+	req := api.RequestedVolume{
+		SourceVolID: sourceID,
+		Size:        30,
+	}
+
+	_, err = ctl.CreateVolume(ctx, tenant.ID, req)
+	if err != api.ErrQuota {
+		t.Fatalf("expected %v, got %v\n", api.ErrQuota, err)
+	}
+
+	bds, err := ctl.ds.GetBlockDevices(tenant.ID)
+	if err != nil || len(bds) != 1 {
+		t.Fatalf("volume over quota added: %v %v\n", bds, err)
+	}
+
+	req.Size = 0
+	vol, err := ctl.CreateVolume(ctx, tenant.ID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.Size != 20 {
+		t.Fatalf("expected a pending volume of 20GiB, got %d\n", vol.Size)
+	}
+
+	// the reservation is adjusted to the size of the clone reported by
+	// the storage driver.
+	bd := waitForVolumeState(vol.ID, types.Available, t)
+
+	for _, q := range ctl.qs.DumpQuotas(tenant.ID) {
+		if q.Name == "tenant-storage-quota" && q.Usage != 20+bd.Size {
+			t.Fatalf("expected %dGiB of storage used, got %d\n", 20+bd.Size, q.Usage)
+		}
+	}
+}
+
+func waitForVolumeState(volID string, state types.BlockState, t *testing.T) types.Volume {
+	for i := 0; i < 50; i++ {
+		bd, err := ctl.ds.GetBlockDevice(volID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if bd.State == state {
+			return bd
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	t.Fatalf("volume %s did not reach state %s\n", volID, state)
+	return types.Volume{}
+}
+
+func TestDeleteVolume(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volID := createTestVolume(tenant.ID, 20, t)
+
+	// confirm that we can retrieve the volume from
+	// the datastore.
+	_, err = ctl.ds.GetBlockDevice(volID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// attempt to delete invalid volume
+	err = ctl.DeleteVolume(ctx, tenant.ID, "badID")
+	if err != datastore.ErrNoBlockData {
+		t.Fatal("Incorrect error")
+	}
+
+	// add second tenant to datastore to prevent CNCI launching.
+	tenant2, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// attempt to delete with bad tenant ID
+	err = ctl.DeleteVolume(ctx, tenant2.ID, volID)
+	if err != api.ErrVolumeOwner {
+		t.Fatal("Incorrect error")
+	}
+
+	// this should work
+	err = ctl.DeleteVolume(ctx, tenant.ID, volID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// confirm that we cannot retrieve the volume from
+	// the datastore.
+	_, err = ctl.ds.GetBlockDevice(volID)
+	if err != datastore.ErrNoBlockData {
+		t.Fatal(err)
+	}
+}
+
+func TestRecycleBinVolume(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctl.deletedRetention = time.Hour
+	defer func() { ctl.deletedRetention = 0 }()
+
+	volID := createTestVolume(tenant.ID, 20, t)
+
+	err = ctl.DeleteVolume(ctx, tenant.ID, volID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ctl.ShowVolumeDetails(ctx, tenant.ID, volID)
+	if err != types.ErrVolumeNotFound {
+		t.Fatalf("Expected ErrVolumeNotFound, got %v", err)
+	}
+
+	vols, err := ctl.ListVolumesDetail(ctx, tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vols) != 0 {
+		t.Fatalf("Expected no volumes, got %d", len(vols))
+	}
+
+	deleted, err := ctl.ListDeletedResources(ctx, tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0].ID != volID || deleted[0].Type != types.DeletedVolume {
+		t.Fatalf("Expected volume %s in the recycle bin, got %v", volID, deleted)
+	}
+
+	if !deleted[0].PurgeTime.Equal(deleted[0].DeleteTime.Add(time.Hour)) {
+		t.Fatalf("Unexpected purge time %v", deleted[0].PurgeTime)
+	}
+
+	err = ctl.RestoreDeletedResource(ctx, tenant.ID, volID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vol, err := ctl.ShowVolumeDetails(ctx, tenant.ID, volID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vol.State != types.Available {
+		t.Fatalf("Expected restored volume to be available, got %s", vol.State)
+	}
+
+	err = ctl.DeleteVolume(ctx, tenant.ID, volID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing is purged before the retention time has elapsed.
+	ctl.purgeDeleted(ctx, time.Now())
+
+	_, err = ctl.ds.GetBlockDevice(volID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.PurgeDeletedResource(ctx, tenant.ID, volID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ctl.ds.GetBlockDevice(volID)
+	if err != datastore.ErrNoBlockData {
+		t.Fatalf("Expected ErrNoBlockData, got %v", err)
+	}
+
+	err = ctl.PurgeDeletedResource(ctx, tenant.ID, volID)
+	if err != types.ErrNotInRecycleBin {
+		t.Fatalf("Expected ErrNotInRecycleBin, got %v", err)
+	}
+}
+
+func TestShowVolumeDetails(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volID := createTestVolume(tenant.ID, 20, t)
+
+	vol, err := ctl.ShowVolumeDetails(ctx, tenant.ID, volID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.ID != volID {
+		t.Fatal("wrong volume retrieved")
+	}
+}
+
+func TestListVolumesDetail(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = createTestVolume(tenant.ID, 20, t)
+
+	vols, err := ctl.ListVolumesDetail(ctx, tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vols) != 1 {
+		t.Fatal("Incorrect number of volumes returned")
+	}
+}
+
+func testAddPool(t *testing.T, name string, subnet *string, ips []string) {
+	ctx := context.Background()
+
+	pool, err := ctl.AddPool(ctx, name, subnet, ips)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pool.ID == "" {
+		t.Fatal("id not set")
+	}
+
+	expected := types.Pool{
+		ID:   pool.ID,
+		Name: name,
+	}
+
+	if subnet != nil {
+		if pool.Subnets[0].ID == "" {
+			t.Fatal("subnet id not created")
+		}
+
+		sub := types.ExternalSubnet{
+			ID:   pool.Subnets[0].ID,
+			CIDR: *subnet,
+		}
+
+		expected.Subnets = []types.ExternalSubnet{sub}
+
+		_, ipNet, err := net.ParseCIDR(*subnet)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ones, bits := ipNet.Mask.Size()
+		expected.TotalIPs = (1 << uint32(bits-ones)) - 2
+		expected.Free = expected.TotalIPs
+	} else if len(ips) > 0 {
+		// not an easy way to check this, so we're going to
+		// do some manual tests
+		if pool.TotalIPs != len(ips) ||
+			pool.Free != len(ips) ||
+			len(pool.IPs) != len(ips) {
+			t.Fatal("External IPs not handled correctly")
+		}
+		return
+	}
+
+	if reflect.DeepEqual(expected, pool) == false {
+		t.Fatalf("expected %v, got %v\n", expected, pool)
+	}
+}
+
+func deletePool(ctx context.Context, name string) error {
+	pools, err := ctl.ListPools(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(pools) < 1 {
+		return types.ErrPoolNotFound
+	}
+
+	for _, pool := range pools {
+		if pool.Name == name {
+			return ctl.DeletePool(ctx, pool.ID)
+		}
+	}
+
+	return types.ErrPoolNotFound
+}
+
+func TestAddPoolWithSubnet(t *testing.T) {
+	ctx := context.Background()
+
+	subnet := "192.168.0.0/16"
+	testAddPool(t, "test1", &subnet, []string{})
+	err := deletePool(ctx, "test1")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddPoolWithIPs(t *testing.T) {
+	ctx := context.Background()
+
+	ips := []string{"10.10.0.1", "10.10.0.2"}
+	testAddPool(t, "test2", nil, ips)
+	err := deletePool(ctx, "test2")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddPool(t *testing.T) {
+	ctx := context.Background()
+
+	testAddPool(t, "test3", nil, []string{})
+	err := deletePool(ctx, "test3")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListPools(t *testing.T) {
+	ctx := context.Background()
+
+	testAddPool(t, "listPoolTest", nil, []string{})
+
+	pools, err := ctl.ListPools(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pools) < 1 {
+		t.Fatal("Unable to retrieve pools")
+	}
+
+	for _, pool := range pools {
+		if pool.Name == "listPoolTest" {
+			err := ctl.DeletePool(ctx, pool.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+	}
+
+	t.Fatal("Could not list pools")
+}
+
+func TestShowPool(t *testing.T) {
+	ctx := context.Background()
+
+	testAddPool(t, "showPoolTest", nil, []string{})
+
+	pools, err := ctl.ListPools(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pools) < 1 {
+		t.Fatal("Unable to retrieve pools")
+	}
+
+	for _, pool := range pools {
+		if pool.Name == "showPoolTest" {
+			_, err := ctl.ShowPool(ctx, pool.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = ctl.DeletePool(ctx, pool.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return
+		}
+	}
+
+	t.Fatal("Could not show pool")
+}
+
+func TestDeletePool(t *testing.T) {
+	ctx := context.Background()
+
+	testAddPool(t, "deletePoolTest", nil, []string{})
+
+	pools, err := ctl.ListPools(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pools) < 1 {
+		t.Fatal("Unable to retrieve pools")
+	}
+
+	for _, pool := range pools {
+		if pool.Name == "deletePoolTest" {
+			err := ctl.DeletePool(ctx, pool.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = ctl.ShowPool(ctx, pool.ID)
+			if err != types.ErrPoolNotFound {
+				t.Fatal("Pool not deleted")
+			}
+			return
+		}
+	}
+
+	t.Fatal("Could not delete pool")
+}
+
+func TestAddPoolSubnet(t *testing.T) {
+	ctx := context.Background()
+
+	subnet := "192.168.0.0/24"
+
+	testAddPool(t, "addsubnet", nil, []string{})
+
+	pools, err := ctl.ListPools(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pools) < 1 {
+		t.Fatal("Unable to retrieve pools")
+	}
+
+	for _, pool := range pools {
+		if pool.Name == "addsubnet" {
+			err := ctl.AddAddress(ctx, pool.ID, &subnet, []string{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p1, err := ctl.ShowPool(ctx, pool.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// we should have a our subnet.
+			if p1.Subnets[0].CIDR != subnet {
+				t.Fatalf("expectd %s subnet got %s", subnet, p1.Subnets[0].CIDR)
+			}
+
+			err = ctl.DeletePool(ctx, pool.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+	}
+
+}
+
+func TestAddPoolAddress(t *testing.T) {
+	ctx := context.Background()
+
+	address := "192.168.1.1"
+
+	testAddPool(t, "addaddress", nil, []string{})
+
+	pools, err := ctl.ListPools(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pools) < 1 {
+		t.Fatal("Unable to retrieve pools")
+	}
+
+	for _, pool := range pools {
+		if pool.Name == "addaddress" {
+			err := ctl.AddAddress(ctx, pool.ID, nil, []string{address})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p1, err := ctl.ShowPool(ctx, pool.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// we should have a our subnet.
+			if p1.IPs[0].Address != address {
+				t.Fatalf("expected %s address got %s", address, p1.IPs[0].Address)
+			}
+
+			err = ctl.DeletePool(ctx, pool.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+	}
+
+}
+
+func TestRemovePoolSubnet(t *testing.T) {
+	ctx := context.Background()
+
+	subnet := "192.168.0.0/24"
+	address := "192.168.1.1"
+
+	testAddPool(t, "addsubnet", &subnet, []string{})
+
+	pools, err := ctl.ListPools(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pool types.Pool
+
+	for _, pool = range pools {
+		if pool.Name == "addsubnet" {
+			// make sure the subnet is there.
+			for _, sub := range pool.Subnets {
+				if sub.CIDR == subnet {
+					err := ctl.RemoveAddress(ctx, pool.ID, &sub.ID, nil)
+					if err != nil {
+						t.Fatalf("%s: %v\n", err, pool.Subnets)
+					}
+				}
+			}
+		}
+		break
+	}
+
+	p1, err := ctl.ShowPool(ctx, pool.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p1.Subnets) != 0 || p1.TotalIPs != 0 || p1.Free != 0 {
+		fmt.Printf("pool %v\n", p1)
 		t.Fatal("subnet not deleted")
 	}
 
-	err = ctl.AddAddress(pool.ID, nil, []string{address})
+	err = ctl.AddAddress(ctx, pool.ID, nil, []string{address})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p1, err = ctl.ShowPool(ctx, pool.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.RemoveAddress(ctx, pool.ID, nil, &p1.IPs[0].ID)
+	if err != nil {
+		t.Fatalf("%s: %v\n", err, pool.IPs)
+	}
+
+	err = ctl.RemoveAddress(ctx, pool.ID, nil, nil)
+	if err != types.ErrBadRequest {
+		t.Fatal("invalid remove address request allowed")
+	}
+}
+
+func TestMapAddress(t *testing.T) {
+	ctx := context.Background()
+
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	ips := []string{"10.10.0.1"}
+	poolName := "testmap"
+
+	testAddPool(t, poolName, nil, ips)
+
+	pools, err := ctl.ListPools(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pools) < 1 {
+		t.Fatal("Unable to retrieve pools")
+	}
+
+	for _, pool := range pools {
+		if pool.Name == "testmap" {
+			if pool.Free != 1 {
+				t.Fatal("Pool Free not correct")
+			}
+		}
+	}
+
+	err = ctl.MapAddress(ctx, instances[0].TenantID, &poolName, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pools, err = ctl.ListPools(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pools) < 1 {
+		t.Fatal("Unable to retrieve pools")
+	}
+
+	for _, pool := range pools {
+		if pool.Name == "testmap" {
+			if pool.Free != 0 {
+				fmt.Printf("%v", pool)
+				t.Fatal("Pool Free not decremented")
+			}
+		}
+	}
+}
+
+func TestMapAddressNoPool(t *testing.T) {
+	ctx := context.Background()
+
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	ips := []string{"10.10.0.2"}
+	poolName := "testmapnopool"
+
+	testAddPool(t, poolName, nil, ips)
+
+	err := ctl.MapAddress(ctx, instances[0].TenantID, nil, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pools, err := ctl.ListPools(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pools) < 1 {
+		t.Fatal("Unable to retrieve pools")
+	}
+
+	for _, pool := range pools {
+		if pool.Name == "testmapnopool" {
+			if pool.Free != 0 {
+				fmt.Printf("%v", pool)
+				t.Fatal("Pool Free not decremented")
+			}
+		}
+	}
+
+	mappedIPs := ctl.ListMappedAddresses(ctx, &instances[0].TenantID)
+	if len(mappedIPs) != 1 {
+		t.Fatal("mapped IP not in list")
+	}
+}
+
+func TestListTenants(t *testing.T) {
+	ctx := context.Background()
+
+	tenants, err := ctl.ds.GetAllTenants()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := ctl.ListTenants(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tenant := range tenants {
+		var match bool
+
+		if tenant.ID == "public" {
+			continue
+		}
+
+		for _, s := range summary {
+			if s.ID != tenant.ID {
+				continue
+			}
+
+			if s.Name != tenant.Name {
+				t.Fatal("bad name")
+			}
+			match = true
+
+			break
+		}
+
+		if match == false {
+			t.Fatal("did not list all tenants")
+		}
+	}
+}
+
+func TestShowTenant(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ctl.ShowTenant(ctx, tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Name != tenant.Name ||
+		config.SubnetBits != tenant.SubnetBits {
+		fmt.Printf("expect name %s, got %s\n", tenant.Name, config.Name)
+		fmt.Printf("expect bits %d, got %d\n", tenant.SubnetBits, config.SubnetBits)
+		t.Fatal("incorrect config returned")
+	}
+}
+
+func TestUpdateTenant(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenantNoCNCI(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ctl.ShowTenant(ctx, tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldconfig := config
+
+	config.Name = "test1"
+	config.SubnetBits = 30
+
+	a, err := json.Marshal(oldconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merge, err := jsonpatch.CreateMergePatch(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := ctl.PatchTenant(ctx, tenant.ID, merge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Previous != oldconfig || resp.Config.Name != "test1" {
+		t.Fatalf("Unexpected tenant update response %+v", resp)
+	}
+
+	config, err = ctl.ShowTenant(ctx, tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Name != "test1" || config.SubnetBits != 30 {
+		t.Fatal("Tenant Update not successful")
+	}
+}
+
+func TestCreateTenant(t *testing.T) {
+	ctx := context.Background()
+
+	config := types.TenantConfig{
+		Name:       "createTenant",
+		SubnetBits: 21,
+	}
+
+	ID := uuid.Generate()
+
+	summary, err := ctl.CreateTenant(ctx, ID.String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Name != "createTenant" || summary.ID != ID.String() {
+		t.Fatal(err)
+	}
+
+	if len(summary.Links) != 1 {
+		t.Fatal("Link not built correctly")
+	}
+}
+
+func TestDeleteTenant(t *testing.T) {
+	ctx := context.Background()
+
+	config := types.TenantConfig{
+		Name:       "deleteTenant",
+		SubnetBits: 24,
+	}
+
+	ID := uuid.Generate()
+
+	_, err := ctl.CreateTenant(ctx, ID.String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.DeleteTenant(ctx, ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+var ctl *controller
+var server *testutil.SsntpTestServer
+var wrappedClient *ssntpClientWrapper
+
+func TestMain(m *testing.M) {
+	ctx := context.Background()
+
+	flag.Parse()
+
+	// create fake ssntp server
+	server = testutil.StartTestServer()
+
+	ctl = new(controller)
+	ctl.pendingNames = make(map[string]bool)
+	ctl.ds = new(datastore.Datastore)
+	ctl.qs = new(quotas.Quotas)
+
+	ctl.BlockDriver = func() storage.BlockDriver {
+		return &storage.NoopDriver{}
+	}()
+
+	secrets, err := newSecretsCipher(make([]byte, secretsKeySize))
+	if err != nil {
+		os.Exit(1)
+	}
+	ctl.secrets = secrets
+
+	dir, err := ioutil.TempDir("", "controller_test")
+	if err != nil {
+		os.Exit(1)
+	}
+	fakeImage := fmt.Sprintf("%s/73a86d7e-93c0-480e-9c41-ab42f69b7799", dir)
+
+	f, err := os.Create(fakeImage)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		os.Exit(1)
+	}
+
+	dsConfig := datastore.Config{
+		PersistentURI:     "file:memdb1?mode=memory&cache=shared",
+		InitWorkloadsPath: *workloadsPath,
+	}
+
+	err = ctl.ds.Init(dsConfig)
+	if err != nil {
+		_ = f.Close()
+		_ = os.RemoveAll(dir)
+		os.Exit(1)
+	}
+
+	ctl.ds.GenerateCNCIWorkload(4, 128, 128, "")
+
+	ctl.qs.Init()
+
+	config := &ssntp.Config{
+		URI:    "localhost",
+		CAcert: ssntp.DefaultCACert,
+		Cert:   ssntp.RoleToDefaultCertName(ssntp.Controller),
+	}
+
+	wrappedClient, err = newWrappedSSNTPClient(ctl, config)
+	if err != nil {
+		os.Exit(1)
+	}
+	ctl.client = wrappedClient
+
+	_, _ = addComputeTestTenant(ctx)
+
+	s, err := ctl.createCiaoServer()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating ciao server: %v", err)
+		os.Exit(1)
+	}
+
+	go func() { _ = s.ListenAndServeTLS(httpsCAcert, httpsKey) }()
+	time.Sleep(1 * time.Second)
+
+	code := m.Run()
+
+	ctl.client.Disconnect()
+	ctl.ds.Exit()
+	ctl.qs.Shutdown()
+	server.Shutdown()
+	_ = f.Close()
+	_ = os.RemoveAll(dir)
+
+	os.Exit(code)
+}
+
+func TestWorkloadCatalog(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := types.Workload{
+		Description: "catalog workload",
+		VMType:      payloads.Docker,
+		ImageName:   "ubuntu:latest",
+		Config:      "#cloud-config\n",
+		Visibility:  types.Public,
+		Category:    "test-catalog",
+	}
+
+	wl, err := ctl.CreateWorkload(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ctl.DeleteWorkload(ctx, "admin", wl.ID) }()
+
+	catalog, err := ctl.ListCatalog(ctx, "test-catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(catalog) != 1 || catalog[0].ID != wl.ID {
+		t.Fatalf("Expected workload %s in catalog, got %v", wl.ID, catalog)
+	}
+
+	clone, err := ctl.CloneCatalogWorkload(ctx, tenant.ID, wl.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ctl.DeleteWorkload(ctx, tenant.ID, clone.ID) }()
+
+	if clone.ID == wl.ID || clone.Visibility != types.Private ||
+		clone.Category != "" || clone.ImageName != wl.ImageName {
+		t.Fatalf("Incorrect cloned workload %v", clone)
+	}
+
+	_, err = ctl.ShowWorkload(ctx, tenant.ID, clone.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.UpdateCatalogWorkload(ctx, wl.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	catalog, err = ctl.ListCatalog(ctx, "test-catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(catalog) != 0 {
+		t.Fatalf("Expected empty catalog, got %v", catalog)
+	}
+
+	_, err = ctl.CloneCatalogWorkload(ctx, tenant.ID, wl.ID)
+	if err != types.ErrWorkloadNotFound {
+		t.Fatalf("Expected ErrWorkloadNotFound, got %v", err)
+	}
+}
+
+func TestAutoscaleDelta(t *testing.T) {
+	policy := types.AutoscalePolicy{
+		MinInstances: 2,
+		MaxInstances: 4,
+		CPUTarget:    60,
+	}
+
+	tests := []struct {
+		count  int
+		avgCPU int
+		delta  int
+	}{
+		{0, -1, 2},
+		{1, 10, 1},
+		{6, 90, -2},
+		{2, -1, 0},
+		{2, 70, 1},
+		{4, 95, 0},
+		{3, 50, 0},
+		{3, 30, -1},
+		{2, 10, 0},
+	}
+
+	for _, test := range tests {
+		delta := autoscaleDelta(policy, test.count, test.avgCPU)
+		if delta != test.delta {
+			t.Errorf("count %d, cpu %d: expected delta %d, got %d",
+				test.count, test.avgCPU, test.delta, delta)
+		}
+	}
+}
+
+func addIPAMTestInstance(t *testing.T, tenantID string, ip string) *types.Instance {
+	ctx := context.Background()
+
+	mac, err := utils.NewHardwareAddr()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance := &types.Instance{
+		TenantID:   tenantID,
+		State:      payloads.Running,
+		ID:         uuid.Generate().String(),
+		IPAddress:  ip,
+		MACAddress: mac.String(),
+	}
+
+	err = ctl.ds.AddInstance(ctx, instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return instance
+}
+
+func ipamIssueTypes(audit types.IPAMAudit) map[types.IPAMIssueType]int {
+	issues := make(map[types.IPAMIssueType]int)
+	for _, issue := range audit.Issues {
+		issues[issue.Type]++
+	}
+	return issues
+}
+
+func TestAuditIPAM(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// leak: allocated but not used by any instance
+	_, err = ctl.ds.AllocateTenantIP(ctx, tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// unallocated: used by an instance but not allocated
+	addIPAMTestInstance(t, tenant.ID, "172.16.0.50")
+
+	// conflict: used by two instances
+	addIPAMTestInstance(t, tenant.ID, "172.16.0.60")
+	addIPAMTestInstance(t, tenant.ID, "172.16.0.60")
+	err = ctl.ds.ClaimTenantIP(ctx, tenant.ID, "172.16.0.60")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	p1, err = ctl.ShowPool(pool.ID)
+	// missing CNCI: the fake CNCI only serves 172.16.0.0/24
+	addIPAMTestInstance(t, tenant.ID, "172.16.1.5")
+	err = ctl.ds.ClaimTenantIP(ctx, tenant.ID, "172.16.1.5")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = ctl.RemoveAddress(pool.ID, nil, &p1.IPs[0].ID)
+	audit, err := ctl.AuditIPAM(ctx, tenant.ID, false)
 	if err != nil {
-		t.Fatalf("%s: %v\n", err, pool.IPs)
+		t.Fatal(err)
 	}
 
-	err = ctl.RemoveAddress(pool.ID, nil, nil)
-	if err != types.ErrBadRequest {
-		t.Fatal("invalid remove address request allowed")
+	expected := map[types.IPAMIssueType]int{
+		types.IPAMLeak:        1,
+		types.IPAMUnallocated: 1,
+		types.IPAMConflict:    1,
+		types.IPAMMissingCNCI: 1,
+	}
+	if !reflect.DeepEqual(ipamIssueTypes(audit), expected) {
+		t.Fatalf("Unexpected audit issues %v", audit.Issues)
 	}
-}
-
-func TestMapAddress(t *testing.T) {
-	var reason payloads.StartFailureReason
 
-	client, instances := testStartWorkload(t, 1, false, reason)
-	defer client.Shutdown()
+	for _, issue := range audit.Issues {
+		if issue.Repaired {
+			t.Errorf("Issue %v repaired by audit", issue)
+		}
+	}
 
-	ips := []string{"10.10.0.1"}
-	poolName := "testmap"
+	audit, err = ctl.AuditIPAM(ctx, tenant.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	testAddPool(t, poolName, nil, ips)
+	for _, issue := range audit.Issues {
+		repairable := issue.Type == types.IPAMLeak ||
+			issue.Type == types.IPAMUnallocated
+		if issue.Repaired != repairable {
+			t.Errorf("Unexpected repair state for issue %v", issue)
+		}
+	}
 
-	pools, err := ctl.ListPools()
+	audit, err = ctl.AuditIPAM(ctx, tenant.ID, false)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(pools) < 1 {
-		t.Fatal("Unable to retrieve pools")
+	expected = map[types.IPAMIssueType]int{
+		types.IPAMConflict:    1,
+		types.IPAMMissingCNCI: 1,
+	}
+	if !reflect.DeepEqual(ipamIssueTypes(audit), expected) {
+		t.Fatalf("Unexpected audit issues after repair %v", audit.Issues)
 	}
 
-	for _, pool := range pools {
-		if pool.Name == "testmap" {
-			if pool.Free != 1 {
-				t.Fatal("Pool Free not correct")
-			}
-		}
+	_, err = ctl.AuditIPAM(ctx, uuid.Generate().String(), false)
+	if err != types.ErrTenantNotFound {
+		t.Fatalf("Expected ErrTenantNotFound, got %v", err)
 	}
+}
+
+func TestStartWorkloadStaticIP(t *testing.T) {
+	ctx := context.Background()
 
-	err = ctl.MapAddress(instances[0].TenantID, &poolName, instances[0].ID)
+	tenant, err := addTestTenant(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	pools, err = ctl.ListPools()
+	client, err := testutil.NewSsntpTestClientConnection("StartWorkloadStaticIP", ssntp.AGENT, testutil.AgentUUID)
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer client.Shutdown()
 
-	if len(pools) < 1 {
-		t.Fatal("Unable to retrieve pools")
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	for _, pool := range pools {
-		if pool.Name == "testmap" {
-			if pool.Free != 0 {
-				fmt.Printf("%v", pool)
-				t.Fatal("Pool Free not decremented")
-			}
-		}
+	if len(wls) == 0 {
+		t.Fatal("No workloads, expected len(wls) > 0, got len(wls) == 0")
 	}
-}
-
-func TestMapAddressNoPool(t *testing.T) {
-	var reason payloads.StartFailureReason
 
-	client, instances := testStartWorkload(t, 1, false, reason)
-	defer client.Shutdown()
-
-	ips := []string{"10.10.0.2"}
-	poolName := "testmapnopool"
+	ip := "172.16.0.20"
+	_, err = ctl.ReserveIP(ctx, tenant.ID, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	testAddPool(t, poolName, nil, ips)
+	clientCh := client.AddCmdChan(ssntp.START)
 
-	err := ctl.MapAddress(instances[0].TenantID, nil, instances[0].ID)
+	w := types.WorkloadRequest{
+		WorkloadID: wls[0].ID,
+		TenantID:   tenant.ID,
+		Instances:  1,
+		IPAddress:  ip,
+	}
+	instances, err := ctl.startWorkload(ctx, w)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if len(instances) != 1 {
+		t.Fatalf("Wrong number of instances, expected 1, got %d", len(instances))
+	}
 
-	pools, err := ctl.ListPools()
+	_, err = client.GetCmdChanResult(clientCh, ssntp.START)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(pools) < 1 {
-		t.Fatal("Unable to retrieve pools")
+	if instances[0].IPAddress != ip {
+		t.Fatalf("Expected IP address %s, got %s", ip, instances[0].IPAddress)
 	}
 
-	for _, pool := range pools {
-		if pool.Name == "testmapnopool" {
-			if pool.Free != 0 {
-				fmt.Printf("%v", pool)
-				t.Fatal("Pool Free not decremented")
-			}
-		}
+	_, err = ctl.startWorkload(ctx, w)
+	if err != types.ErrIPInUse {
+		t.Fatalf("Expected %v, got %v", types.ErrIPInUse, err)
 	}
 
-	mappedIPs := ctl.ListMappedAddresses(&instances[0].TenantID)
-	if len(mappedIPs) != 1 {
-		t.Fatal("mapped IP not in list")
+	w.Instances = 2
+	_, err = ctl.startWorkload(ctx, w)
+	if err != types.ErrStaticIPInstances {
+		t.Fatalf("Expected %v, got %v", types.ErrStaticIPInstances, err)
 	}
-}
 
-func TestListTenants(t *testing.T) {
-	tenants, err := ctl.ds.GetAllTenants()
+	err = ctl.ReleaseIPReservation(ctx, tenant.ID, ip)
+	if err != types.ErrIPInUse {
+		t.Fatalf("Expected %v, got %v", types.ErrIPInUse, err)
+	}
+
+	reservations, err := ctl.ListIPReservations(ctx, tenant.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if len(reservations) != 1 || reservations[0].InstanceID != instances[0].ID {
+		t.Fatalf("Unexpected reservations %v", reservations)
+	}
 
-	summary, err := ctl.ListTenants()
+	audit, err := ctl.AuditIPAM(ctx, tenant.ID, false)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if len(audit.Issues) != 0 {
+		t.Fatalf("Unexpected audit issues %v", audit.Issues)
+	}
+}
 
-	for _, tenant := range tenants {
-		var match bool
+func TestSweepInstances(t *testing.T) {
+	ctx := context.Background()
 
-		if tenant.ID == "public" {
-			continue
-		}
+	var reason payloads.StartFailureReason
 
-		for _, s := range summary {
-			if s.ID != tenant.ID {
-				continue
-			}
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
 
-			if s.Name != tenant.Name {
-				t.Fatal("bad name")
-			}
-			match = true
+	launched := instances[0]
 
-			break
-		}
+	// an instance restarted after its creation
+	restarted := addIPAMTestInstance(t, launched.TenantID, "")
+	restarted.State = payloads.Pending
+	restarted.CreateTime = time.Now().Add(-time.Hour)
+	restarted.PendingTime = time.Now()
 
-		if match == false {
-			t.Fatal("did not list all tenants")
+	var tenantInstances []types.PendingInstance
+	for _, i := range ctl.ds.GetPendingInstances() {
+		if i.TenantID == launched.TenantID {
+			tenantInstances = append(tenantInstances, i)
 		}
 	}
-}
 
-func TestShowTenant(t *testing.T) {
-	tenant, err := addTestTenant()
-	if err != nil {
-		t.Fatal(err)
+	if len(tenantInstances) != 2 {
+		t.Fatalf("Expected 2 pending instances, got %d", len(tenantInstances))
+	}
+
+	// nothing has been pending for long enough yet
+	ctl.sweepInstances(ctx, tenantInstances, time.Now(), time.Minute)
+
+	if launched.State != payloads.Pending || restarted.State != payloads.Pending {
+		t.Fatal("Instances failed before their timeout")
 	}
 
-	config, err := ctl.ShowTenant(tenant.ID)
+	controllerCh := wrappedClient.addErrorChan(ssntp.StartFailure)
+
+	swept := make(chan struct{})
+	go func() {
+		ctl.sweepInstances(ctx, tenantInstances, time.Now().Add(2*time.Minute), time.Minute)
+		close(swept)
+	}()
+
+	err := wrappedClient.getErrorChan(controllerCh, ssntp.StartFailure)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if config.Name != tenant.Name ||
-		config.SubnetBits != tenant.SubnetBits {
-		fmt.Printf("expect name %s, got %s\n", tenant.Name, config.Name)
-		fmt.Printf("expect bits %d, got %d\n", tenant.SubnetBits, config.SubnetBits)
-		t.Fatal("incorrect config returned")
+	<-swept
+
+	_, err = ctl.ds.GetInstance(launched.ID)
+	if err == nil {
+		t.Error("Timed out instance not deleted")
 	}
-}
 
-func TestUpdateTenant(t *testing.T) {
-	tenant, err := addTestTenantNoCNCI()
-	if err != nil {
-		t.Fatal(err)
+	for _, op := range ctl.ds.GetResourceOperations(launched.ID, types.InstanceCreate) {
+		if op.State != types.OperationFailed {
+			t.Errorf("Expected operation to fail, got %s", op.State)
+		}
 	}
 
-	config, err := ctl.ShowTenant(tenant.ID)
+	i, err := ctl.ds.GetInstance(restarted.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	oldconfig := config
+	if i.State != payloads.Exited {
+		t.Errorf("Expected restarted instance to be %s, got %s", payloads.Exited, i.State)
+	}
+}
 
-	config.Name = "test1"
-	config.SubnetBits = 30
+func TestWorkloadIORequirements(t *testing.T) {
+	ctx := context.Background()
 
-	a, err := json.Marshal(oldconfig)
+	tenant, err := addTestTenant(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	b, err := json.Marshal(config)
-	if err != nil {
-		t.Fatal(err)
+	req := types.Workload{
+		TenantID:    tenant.ID,
+		Description: "io workload",
+		VMType:      payloads.Docker,
+		ImageName:   "ubuntu:latest",
+		Config:      "#cloud-config\n",
+		Requirements: payloads.WorkloadRequirements{
+			VCPUs:    1,
+			MemMB:    128,
+			DiskIOPS: 500,
+			NetMbps:  100,
+		},
 	}
 
-	merge, err := jsonpatch.CreateMergePatch(a, b)
+	wl, err := ctl.CreateWorkload(ctx, req)
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer func() { _ = ctl.DeleteWorkload(ctx, tenant.ID, wl.ID) }()
 
-	err = ctl.PatchTenant(tenant.ID, merge)
+	wl, err = ctl.ShowWorkload(ctx, tenant.ID, wl.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	config, err = ctl.ShowTenant(tenant.ID)
-	if err != nil {
-		t.Fatal(err)
+	if wl.Requirements.DiskIOPS != 500 || wl.Requirements.NetMbps != 100 {
+		t.Fatalf("Incorrect workload requirements %v", wl.Requirements)
 	}
 
-	if config.Name != "test1" || config.SubnetBits != 30 {
-		t.Fatal("Tenant Update not successful")
+	req.Requirements.NetMbps = -1
+	_, err = ctl.CreateWorkload(ctx, req)
+	if err != types.ErrBadRequest {
+		t.Fatal("Workload with negative network bandwidth created")
 	}
 }
 
-func TestCreateTenant(t *testing.T) {
-	config := types.TenantConfig{
-		Name:       "createTenant",
-		SubnetBits: 21,
+func TestWorkloadPriority(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	ID := uuid.Generate()
+	req := types.Workload{
+		TenantID:    tenant.ID,
+		Description: "low priority workload",
+		VMType:      payloads.Docker,
+		ImageName:   "ubuntu:latest",
+		Config:      "#cloud-config\n",
+		Requirements: payloads.WorkloadRequirements{
+			VCPUs:    1,
+			MemMB:    128,
+			Priority: payloads.LowPriority,
+		},
+	}
 
-	summary, err := ctl.CreateTenant(ID.String(), config)
+	wl, err := ctl.CreateWorkload(ctx, req)
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer func() { _ = ctl.DeleteWorkload(ctx, tenant.ID, wl.ID) }()
 
-	if summary.Name != "createTenant" || summary.ID != ID.String() {
+	wl, err = ctl.ShowWorkload(ctx, tenant.ID, wl.ID)
+	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(summary.Links) != 1 {
-		t.Fatal("Link not built correctly")
+	if wl.Requirements.Priority != payloads.LowPriority {
+		t.Fatalf("Incorrect workload priority %s", wl.Requirements.Priority)
 	}
-}
 
-func TestDeleteTenant(t *testing.T) {
-	config := types.TenantConfig{
-		Name:       "deleteTenant",
-		SubnetBits: 24,
+	req.Requirements.Priority = "urgent"
+	_, err = ctl.CreateWorkload(ctx, req)
+	if err != types.ErrBadRequest {
+		t.Fatal("Workload with unknown priority created")
 	}
+}
 
-	ID := uuid.Generate()
-
-	_, err := ctl.CreateTenant(ID.String(), config)
-	if err != nil {
-		t.Fatal(err)
-	}
+func TestWorkloadWatchdog(t *testing.T) {
+	ctx := context.Background()
 
-	err = ctl.DeleteTenant(ID.String())
+	tenant, err := addTestTenant(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
-}
-
-var ctl *controller
-var server *testutil.SsntpTestServer
-var wrappedClient *ssntpClientWrapper
-
-func TestMain(m *testing.M) {
-	flag.Parse()
-
-	// create fake ssntp server
-	server = testutil.StartTestServer()
 
-	ctl = new(controller)
-	ctl.tenantReadiness = make(map[string]*tenantConfirmMemo)
-	ctl.ds = new(datastore.Datastore)
-	ctl.qs = new(quotas.Quotas)
+	volID := createTestVolume(tenant.ID, 20, t)
 
-	ctl.BlockDriver = func() storage.BlockDriver {
-		return &storage.NoopDriver{}
-	}()
+	req := types.Workload{
+		TenantID:    tenant.ID,
+		Description: "watchdog workload",
+		VMType:      payloads.QEMU,
+		FWType:      payloads.Legacy,
+		Config:      "#cloud-config\n",
+		Requirements: payloads.WorkloadRequirements{
+			VCPUs:    1,
+			MemMB:    128,
+			Watchdog: payloads.WatchdogReset,
+		},
+		Storage: []types.StorageResource{
+			{
+				Bootable:   true,
+				SourceType: types.VolumeService,
+				Source:     volID,
+			},
+		},
+	}
 
-	dir, err := ioutil.TempDir("", "controller_test")
+	wl, err := ctl.CreateWorkload(ctx, req)
 	if err != nil {
-		os.Exit(1)
+		t.Fatal(err)
 	}
-	fakeImage := fmt.Sprintf("%s/73a86d7e-93c0-480e-9c41-ab42f69b7799", dir)
+	defer func() { _ = ctl.DeleteWorkload(ctx, tenant.ID, wl.ID) }()
 
-	f, err := os.Create(fakeImage)
+	wl, err = ctl.ShowWorkload(ctx, tenant.ID, wl.ID)
 	if err != nil {
-		_ = os.RemoveAll(dir)
-		os.Exit(1)
+		t.Fatal(err)
 	}
 
-	dsConfig := datastore.Config{
-		PersistentURI:     "file:memdb1?mode=memory&cache=shared",
-		InitWorkloadsPath: *workloadsPath,
+	if wl.Requirements.Watchdog != payloads.WatchdogReset {
+		t.Fatalf("Incorrect workload watchdog action %s", wl.Requirements.Watchdog)
 	}
 
-	err = ctl.ds.Init(dsConfig)
-	if err != nil {
-		_ = f.Close()
-		_ = os.RemoveAll(dir)
-		os.Exit(1)
+	req.Requirements.Watchdog = "shutdown"
+	_, err = ctl.CreateWorkload(ctx, req)
+	if err != types.ErrBadRequest {
+		t.Fatal("Workload with unknown watchdog action created")
 	}
 
-	ctl.ds.GenerateCNCIWorkload(4, 128, 128, "")
-
-	ctl.qs.Init()
-
-	config := &ssntp.Config{
-		URI:    "localhost",
-		CAcert: ssntp.DefaultCACert,
-		Cert:   ssntp.RoleToDefaultCertName(ssntp.Controller),
+	req.Requirements.Watchdog = payloads.WatchdogReset
+	req.VMType = payloads.Docker
+	req.ImageName = "ubuntu:latest"
+	req.Storage = nil
+	_, err = ctl.CreateWorkload(ctx, req)
+	if err != types.ErrBadRequest {
+		t.Fatal("Container workload with a watchdog created")
 	}
+}
 
-	wrappedClient, err = newWrappedSSNTPClient(ctl, config)
+func TestWorkloadCDROM(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
 	if err != nil {
-		os.Exit(1)
+		t.Fatal(err)
 	}
-	ctl.client = wrappedClient
 
-	_, _ = addComputeTestTenant()
+	volID := createTestVolume(tenant.ID, 20, t)
 
-	s, err := ctl.createCiaoServer()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating ciao server: %v", err)
-		os.Exit(1)
+	req := types.Workload{
+		TenantID:    tenant.ID,
+		Description: "cdrom workload",
+		VMType:      payloads.QEMU,
+		FWType:      payloads.Legacy,
+		Config:      "#cloud-config\n",
+		Requirements: payloads.WorkloadRequirements{
+			VCPUs: 1,
+			MemMB: 128,
+		},
+		Storage: []types.StorageResource{
+			{
+				Size:       10,
+				SourceType: types.Empty,
+				CDROM:      true,
+			},
+			{
+				Bootable:   true,
+				SourceType: types.VolumeService,
+				Source:     volID,
+			},
+		},
 	}
 
-	go func() { _ = s.ListenAndServeTLS(httpsCAcert, httpsKey) }()
-	time.Sleep(1 * time.Second)
-
-	code := m.Run()
+	_, err = ctl.CreateWorkload(ctx, req)
+	if err != types.ErrBadRequest {
+		t.Fatal("Workload with an empty CD-ROM created")
+	}
 
-	ctl.client.Disconnect()
-	ctl.ds.Exit()
-	ctl.qs.Shutdown()
-	server.Shutdown()
-	_ = f.Close()
-	_ = os.RemoveAll(dir)
+	req.VMType = payloads.Docker
+	req.ImageName = "ubuntu:latest"
+	req.Storage[0].SourceType = types.ImageService
+	req.Storage[0].Source = "installer.iso"
 
-	os.Exit(code)
+	_, err = ctl.CreateWorkload(ctx, req)
+	if err != types.ErrBadRequest {
+		t.Fatal("Container workload with a CD-ROM created")
+	}
 }
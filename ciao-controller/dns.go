@@ -0,0 +1,195 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+var dnsProviderName = flag.String("dns_provider", "", "DNS provider publishing records for mapped external IPs (nsupdate), disabled if empty")
+var dnsZone = flag.String("dns_zone", "", "DNS zone records for mapped external IPs are created in")
+var dnsTTL = flag.Int("dns_ttl", 300, "TTL of the records created for mapped external IPs")
+var nsupdateServer = flag.String("nsupdate_server", "", "Name server the nsupdate DNS provider sends updates to, defaults to the primary server of the zone")
+var nsupdateKeyFile = flag.String("nsupdate_key_file", "", "TSIG key file used by the nsupdate DNS provider to sign updates")
+var nsupdatePath = flag.String("nsupdate_path", "nsupdate", "Path to the nsupdate command")
+
+// dnsUpdateTimeout bounds the time spent publishing a DNS record.
+const dnsUpdateTimeout = 10 * time.Second
+
+// dnsProvider publishes the DNS records of the external IPs mapped to
+// instances.
+type dnsProvider interface {
+	fmt.Stringer
+
+	// UpdateRecord creates the address record of a fully qualified
+	// name, replacing any existing record of the name.
+	UpdateRecord(name string, ip net.IP, ttl int) error
+
+	// DeleteRecord removes the address record of a fully qualified
+	// name pointing to ip.
+	DeleteRecord(name string, ip net.IP) error
+}
+
+func newDNSProvider(name string) (dnsProvider, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "nsupdate":
+		return &nsupdateDNS{
+			command: *nsupdatePath,
+			server:  *nsupdateServer,
+			keyFile: *nsupdateKeyFile,
+		}, nil
+	default:
+		return nil, fmt.Errorf("Unknown DNS provider %s", name)
+	}
+}
+
+// nsupdateDNS publishes records with RFC 2136 dynamic updates sent by the
+// nsupdate command, signed with a TSIG key if one is configured.
+type nsupdateDNS struct {
+	command string
+	server  string
+	keyFile string
+}
+
+func (n *nsupdateDNS) String() string {
+	return "nsupdate"
+}
+
+func recordType(ip net.IP) string {
+	if ip.To4() != nil {
+		return "A"
+	}
+	return "AAAA"
+}
+
+func (n *nsupdateDNS) update(commands []string) error {
+	var script bytes.Buffer
+
+	if n.server != "" {
+		fmt.Fprintf(&script, "server %s\n", n.server)
+	}
+	for _, c := range commands {
+		fmt.Fprintln(&script, c)
+	}
+	fmt.Fprintln(&script, "send")
+
+	args := []string{}
+	if n.keyFile != "" {
+		args = append(args, "-k", n.keyFile)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsUpdateTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, n.command, args...)
+	cmd.Stdin = &script
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "nsupdate failed: %s", strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+func (n *nsupdateDNS) UpdateRecord(name string, ip net.IP, ttl int) error {
+	rtype := recordType(ip)
+	return n.update([]string{
+		fmt.Sprintf("update delete %s %s", name, rtype),
+		fmt.Sprintf("update add %s %d %s %s", name, ttl, rtype, ip),
+	})
+}
+
+func (n *nsupdateDNS) DeleteRecord(name string, ip net.IP) error {
+	return n.update([]string{
+		fmt.Sprintf("update delete %s %s %s", name, recordType(ip), ip),
+	})
+}
+
+// externalIPRecordName returns the name of the DNS record of the external
+// IP mapped to an instance: the instance name, or its ID if the name is not
+// a valid DNS label, followed by the tenant ID and the zone.
+func externalIPRecordName(i *types.Instance) string {
+	label := i.Name
+	if label == "" || len(label) > 63 {
+		label = i.ID
+	}
+
+	return fmt.Sprintf("%s.%s.%s", label, i.TenantID, strings.TrimSuffix(*dnsZone, ".")+".")
+}
+
+// publishExternalIPRecord creates the DNS record of an external IP newly
+// mapped to an instance.
+func (c *controller) publishExternalIPRecord(ctx context.Context, i *types.Instance, externalIP string) {
+	if c.dns == nil {
+		return
+	}
+
+	ip := net.ParseIP(externalIP)
+	if ip == nil {
+		glog.Warningf("Invalid external IP %s", externalIP)
+		return
+	}
+
+	name := externalIPRecordName(i)
+	err := c.dns.UpdateRecord(name, ip, *dnsTTL)
+	if err != nil {
+		glog.Warningf("Error publishing DNS record %s with %s: %v", name, c.dns, err)
+		msg := fmt.Sprintf("Failed to publish DNS record %s for %s", name, externalIP)
+		_ = c.ds.LogError(ctx, i.TenantID, msg)
+		return
+	}
+
+	msg := fmt.Sprintf("Published DNS record %s for %s", name, externalIP)
+	_ = c.ds.LogEvent(ctx, i.TenantID, msg)
+}
+
+// removeExternalIPRecord removes the DNS record of an external IP unmapped
+// from an instance.
+func (c *controller) removeExternalIPRecord(ctx context.Context, i *types.Instance, externalIP string) {
+	if c.dns == nil {
+		return
+	}
+
+	ip := net.ParseIP(externalIP)
+	if ip == nil {
+		glog.Warningf("Invalid external IP %s", externalIP)
+		return
+	}
+
+	name := externalIPRecordName(i)
+	err := c.dns.DeleteRecord(name, ip)
+	if err != nil {
+		glog.Warningf("Error removing DNS record %s with %s: %v", name, c.dns, err)
+		msg := fmt.Sprintf("Failed to remove DNS record %s for %s", name, externalIP)
+		_ = c.ds.LogError(ctx, i.TenantID, msg)
+		return
+	}
+
+	msg := fmt.Sprintf("Removed DNS record %s for %s", name, externalIP)
+	_ = c.ds.LogEvent(ctx, i.TenantID, msg)
+}
@@ -0,0 +1,206 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+type testDNSProvider struct {
+	records map[string]string
+	err     error
+}
+
+func (p *testDNSProvider) String() string {
+	return "test"
+}
+
+func (p *testDNSProvider) UpdateRecord(name string, ip net.IP, ttl int) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.records[name] = ip.String()
+	return nil
+}
+
+func (p *testDNSProvider) DeleteRecord(name string, ip net.IP) error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.records[name] == ip.String() {
+		delete(p.records, name)
+	}
+	return nil
+}
+
+func TestNSUpdateDNS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dns_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	// the fake nsupdate records its arguments and the update script.
+	out := filepath.Join(dir, "out")
+	command := filepath.Join(dir, "nsupdate")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$@\" > %s\ncat >> %s\n", out, out)
+	err = ioutil.WriteFile(command, []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := &nsupdateDNS{
+		command: command,
+		server:  "ns1.example.com",
+		keyFile: "/etc/ciao/dns.key",
+	}
+
+	err = n.UpdateRecord("web.example.com.", net.ParseIP("203.0.113.10"), 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `-k /etc/ciao/dns.key
+server ns1.example.com
+update delete web.example.com. A
+update add web.example.com. 300 A 203.0.113.10
+send
+`
+	if string(data) != expected {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expected, data)
+	}
+
+	n.server = ""
+	n.keyFile = ""
+	err = n.DeleteRecord("web.example.com.", net.ParseIP("2001:db8::10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = `
+update delete web.example.com. AAAA 2001:db8::10
+send
+`
+	if string(data) != expected {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expected, data)
+	}
+
+	n.command = filepath.Join(dir, "missing")
+	err = n.UpdateRecord("web.example.com.", net.ParseIP("203.0.113.10"), 300)
+	if err == nil {
+		t.Fatal("Expected error running a missing nsupdate")
+	}
+}
+
+func TestNewDNSProvider(t *testing.T) {
+	p, err := newDNSProvider("")
+	if err != nil || p != nil {
+		t.Fatalf("Expected no DNS provider, got %v %v", p, err)
+	}
+
+	p, err = newDNSProvider("nsupdate")
+	if err != nil || p.String() != "nsupdate" {
+		t.Fatalf("Expected nsupdate DNS provider, got %v %v", p, err)
+	}
+
+	_, err = newDNSProvider("route53")
+	if err == nil {
+		t.Fatal("Expected error for an unknown DNS provider")
+	}
+}
+
+func TestExternalIPRecords(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zone := *dnsZone
+	*dnsZone = "ciao.example.com"
+	provider := &testDNSProvider{records: make(map[string]string)}
+	ctl.dns = provider
+	defer func() {
+		ctl.dns = nil
+		*dnsZone = zone
+	}()
+
+	named := &types.Instance{
+		ID:       uuid.Generate().String(),
+		TenantID: tenant.ID,
+		Name:     "web",
+	}
+	unnamed := &types.Instance{
+		ID:       uuid.Generate().String(),
+		TenantID: tenant.ID,
+	}
+
+	ctl.publishExternalIPRecord(ctx, named, "203.0.113.10")
+	ctl.publishExternalIPRecord(ctx, unnamed, "203.0.113.11")
+
+	namedRecord := "web." + tenant.ID + ".ciao.example.com."
+	unnamedRecord := unnamed.ID + "." + tenant.ID + ".ciao.example.com."
+	if provider.records[namedRecord] != "203.0.113.10" ||
+		provider.records[unnamedRecord] != "203.0.113.11" {
+		t.Fatalf("Unexpected DNS records %v", provider.records)
+	}
+
+	ctl.removeExternalIPRecord(ctx, named, "203.0.113.10")
+	if _, ok := provider.records[namedRecord]; ok {
+		t.Fatalf("DNS record %s not removed", namedRecord)
+	}
+
+	provider.err = errors.New("update refused")
+	ctl.removeExternalIPRecord(ctx, unnamed, "203.0.113.11")
+
+	logs, err := ctl.ds.GetEventLog(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, l := range logs {
+		if l.TenantID == tenant.ID && l.EventType == "error" &&
+			strings.Contains(l.Message, unnamedRecord) {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Fatal("DNS record failure not logged")
+	}
+}
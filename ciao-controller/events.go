@@ -0,0 +1,103 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/service"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+// eventStreamKeepAlive is the interval between the comments sent on idle
+// event streams, so that proxies do not close them.
+const eventStreamKeepAlive = 30 * time.Second
+
+// eventStreamHandler streams the events logged from now on to a client as
+// server-sent events, each carrying a JSON encoded types.CiaoEvent. Events
+// are restricted to the tenant of the route, if any, and to the event type
+// given by the optional type query parameter.
+type eventStreamHandler struct {
+	*controller
+	Privileged bool
+}
+
+func (h eventStreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Privileged && !service.GetPrivilege(r.Context()) {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	eventType := r.URL.Query().Get("type")
+
+	events, stop := h.ds.WatchEvents()
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			_, _ = fmt.Fprint(w, ": keep-alive\n\n")
+		case e, ok := <-events:
+			if !ok {
+				// the controller is shutting down or the
+				// client is not keeping up.
+				return
+			}
+
+			if (tenant != "" && tenant != e.TenantID) ||
+				(eventType != "" && eventType != e.EventType) {
+				continue
+			}
+
+			b, err := json.Marshal(types.CiaoEvent{
+				Timestamp: e.Timestamp,
+				TenantID:  e.TenantID,
+				EventType: e.EventType,
+				Message:   e.Message,
+			})
+			if err != nil {
+				glog.Warningf("Error marshalling event: %v", err)
+				continue
+			}
+
+			_, _ = fmt.Fprintf(w, "data: %s\n\n", b)
+		}
+
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// eventSinkTag is the default tag of the events in syslog and journald.
+const eventSinkTag = "ciao-controller"
+
+// journaldSocket is the socket of the native protocol of journald.
+var journaldSocket = "/run/systemd/journal/socket"
+
+// eventSink mirrors the cluster events to an external event collector.
+type eventSink interface {
+	send(e types.LogEntry) error
+	close() error
+}
+
+// eventText formats an event for collectors which only take a message.
+func eventText(e types.LogEntry) string {
+	text := e.Message
+	if e.TenantID != "" {
+		text += " tenant_id=" + e.TenantID
+	}
+	if e.NodeID != "" {
+		text += " node_id=" + e.NodeID
+	}
+	return text
+}
+
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func newSyslogSink(conf payloads.EventSink, tag string) (eventSink, error) {
+	var network, raddr string
+
+	if conf.Address != "" {
+		u, err := url.Parse(conf.Address)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("Invalid syslog address %s", conf.Address)
+		}
+		network, raddr = u.Scheme, u.Host
+	}
+
+	w, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error connecting to syslog")
+	}
+
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) send(e types.LogEntry) error {
+	if e.EventType == "error" {
+		return s.w.Err(eventText(e))
+	}
+	return s.w.Info(eventText(e))
+}
+
+func (s *syslogSink) close() error {
+	return s.w.Close()
+}
+
+type journaldSink struct {
+	conn *net.UnixConn
+	tag  string
+}
+
+func newJournaldSink(conf payloads.EventSink, tag string) (eventSink, error) {
+	addr := &net.UnixAddr{Name: journaldSocket, Net: "unixgram"}
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error connecting to journald")
+	}
+
+	return &journaldSink{conn: conn, tag: tag}, nil
+}
+
+// appendJournaldField appends a field to a journald native protocol
+// message.  Values spanning several lines are sent with their length.
+func appendJournaldField(b *bytes.Buffer, name string, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(b, "%s=%s\n", name, value)
+		return
+	}
+
+	b.WriteString(name)
+	b.WriteByte('\n')
+	_ = binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+func journaldMessage(e types.LogEntry, tag string) []byte {
+	priority := "6"
+	if e.EventType == "error" {
+		priority = "3"
+	}
+
+	var b bytes.Buffer
+	appendJournaldField(&b, "MESSAGE", e.Message)
+	appendJournaldField(&b, "PRIORITY", priority)
+	appendJournaldField(&b, "SYSLOG_IDENTIFIER", tag)
+	appendJournaldField(&b, "CIAO_EVENT_TYPE", e.EventType)
+	if e.TenantID != "" {
+		appendJournaldField(&b, "CIAO_TENANT_ID", e.TenantID)
+	}
+	if e.NodeID != "" {
+		appendJournaldField(&b, "CIAO_NODE_ID", e.NodeID)
+	}
+
+	return b.Bytes()
+}
+
+func (s *journaldSink) send(e types.LogEntry) error {
+	_, err := s.conn.Write(journaldMessage(e, s.tag))
+	return err
+}
+
+func (s *journaldSink) close() error {
+	return s.conn.Close()
+}
+
+// kafkaRecord is a record of the produce requests of the Kafka REST proxy.
+type kafkaRecord struct {
+	Value types.LogEntry `json:"value"`
+}
+
+// kafkaSink produces the events to a Kafka topic through a Kafka REST
+// proxy.
+type kafkaSink struct {
+	url    string
+	client *http.Client
+}
+
+func newKafkaSink(conf payloads.EventSink) (eventSink, error) {
+	if conf.Address == "" || conf.Topic == "" {
+		return nil, errors.New("Kafka event sinks need an address and a topic")
+	}
+
+	u, err := url.Parse(conf.Address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("Invalid Kafka REST proxy URL %s", conf.Address)
+	}
+
+	return &kafkaSink{
+		url:    strings.TrimSuffix(conf.Address, "/") + "/topics/" + url.PathEscape(conf.Topic),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (s *kafkaSink) send(e types.LogEntry) error {
+	records := struct {
+		Records []kafkaRecord `json:"records"`
+	}{
+		Records: []kafkaRecord{{Value: e}},
+	}
+
+	b, err := json.Marshal(&records)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.url, "application/vnd.kafka.json.v2+json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Kafka REST proxy returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+func (s *kafkaSink) close() error {
+	return nil
+}
+
+// validateEventSink checks the configuration of an event sink without
+// connecting to its collector.
+func validateEventSink(conf payloads.EventSink) error {
+	switch conf.Type {
+	case "syslog":
+		if conf.Address != "" {
+			u, err := url.Parse(conf.Address)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("Invalid syslog address %s", conf.Address)
+			}
+		}
+	case "journald":
+	case "kafka":
+		_, err := newKafkaSink(conf)
+		return err
+	default:
+		return fmt.Errorf("Unknown event sink type %s", conf.Type)
+	}
+
+	return nil
+}
+
+func newEventSink(conf payloads.EventSink) (eventSink, error) {
+	tag := conf.Tag
+	if tag == "" {
+		tag = eventSinkTag
+	}
+
+	switch conf.Type {
+	case "syslog":
+		return newSyslogSink(conf, tag)
+	case "journald":
+		return newJournaldSink(conf, tag)
+	case "kafka":
+		return newKafkaSink(conf)
+	}
+
+	return nil, fmt.Errorf("Unknown event sink type %s", conf.Type)
+}
+
+// newEventSinks connects to the event collectors of the cluster
+// configuration.  The collectors which cannot be reached are skipped.
+func newEventSinks(confs []payloads.EventSink) []eventSink {
+	var sinks []eventSink
+
+	for _, conf := range confs {
+		sink, err := newEventSink(conf)
+		if err != nil {
+			glog.Errorf("Error creating %s event sink: %v", conf.Type, err)
+			continue
+		}
+		sinks = append(sinks, sink)
+	}
+
+	return sinks
+}
+
+// eventForwarder mirrors the events logged to the datastore to the event
+// sinks until stop is closed.  The events logged while the sinks fall
+// behind are not mirrored.
+func (c *controller) eventForwarder(sinks []eventSink, stop <-chan struct{}) {
+	events, cancel := c.ds.WatchEvents()
+
+	defer func() {
+		cancel()
+		for _, sink := range sinks {
+			_ = sink.close()
+		}
+	}()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case e, ok := <-events:
+			if !ok {
+				glog.Warning("Event sinks fell behind, some events were not mirrored")
+				events, cancel = c.ds.WatchEvents()
+				continue
+			}
+
+			for _, sink := range sinks {
+				if err := sink.send(e); err != nil {
+					glog.Warningf("Error mirroring event to event sink: %v", err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/testutil"
+)
+
+func TestValidateEventSink(t *testing.T) {
+	tests := []struct {
+		sink  payloads.EventSink
+		valid bool
+	}{
+		{payloads.EventSink{Type: "syslog"}, true},
+		{payloads.EventSink{Type: "syslog", Address: "udp://loghost:514"}, true},
+		{payloads.EventSink{Type: "syslog", Address: "loghost"}, false},
+		{payloads.EventSink{Type: "journald"}, true},
+		{payloads.EventSink{Type: "kafka", Address: "http://kafka:8082", Topic: "ciao-events"}, true},
+		{payloads.EventSink{Type: "kafka", Address: "http://kafka:8082"}, false},
+		{payloads.EventSink{Type: "kafka", Address: "kafka:8082", Topic: "ciao-events"}, false},
+		{payloads.EventSink{Type: "splunk"}, false},
+	}
+
+	for _, test := range tests {
+		err := validateEventSink(test.sink)
+		if (err == nil) != test.valid {
+			t.Errorf("Unexpected result validating %+v: %v", test.sink, err)
+		}
+	}
+}
+
+// readDatagram returns the first datagram received containing text, as
+// other events may be logged while the test runs.
+func readDatagram(t *testing.T, conn net.PacketConn, text string) string {
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	b := make([]byte, 4096)
+	for {
+		n, _, err := conn.ReadFrom(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Contains(string(b[:n]), text) {
+			return string(b[:n])
+		}
+	}
+}
+
+func TestEventForwarder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "controller-event-sinks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	syslogConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = syslogConn.Close() }()
+
+	savedSocket := journaldSocket
+	journaldSocket = filepath.Join(dir, "journal.socket")
+	defer func() { journaldSocket = savedSocket }()
+
+	journaldConn, err := net.ListenPacket("unixgram", journaldSocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = journaldConn.Close() }()
+
+	kafkaCh := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.URL.Path == "/topics/ciao-events" &&
+			r.Header.Get("Content-Type") == "application/vnd.kafka.json.v2+json" &&
+			strings.Contains(string(body), "Mirrored event") {
+			kafkaCh <- string(body)
+		}
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+
+	sinks := newEventSinks([]payloads.EventSink{
+		{Type: "syslog", Address: "udp://" + syslogConn.LocalAddr().String()},
+		{Type: "journald", Tag: "ciao-test"},
+		{Type: "kafka", Address: ts.URL, Topic: "ciao-events"},
+	})
+	if len(sinks) != 3 {
+		t.Fatalf("Expected 3 event sinks, got %d", len(sinks))
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		ctl.eventForwarder(sinks, stop)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	// the forwarder watches the events once it has started.
+	time.Sleep(100 * time.Millisecond)
+
+	err = ctl.ds.LogEvent(context.Background(), testutil.ComputeUser, "Mirrored event")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := readDatagram(t, syslogConn, "Mirrored event")
+	if !strings.Contains(msg, "Mirrored event tenant_id="+testutil.ComputeUser) {
+		t.Errorf("Event not mirrored to syslog: %s", msg)
+	}
+
+	msg = readDatagram(t, journaldConn, "Mirrored event")
+	for _, field := range []string{"MESSAGE=Mirrored event\n", "SYSLOG_IDENTIFIER=ciao-test\n",
+		"CIAO_TENANT_ID=" + testutil.ComputeUser + "\n", "PRIORITY=6\n"} {
+		if !strings.Contains(msg, field) {
+			t.Errorf("Field %q missing from journald message: %q", field, msg)
+		}
+	}
+
+	select {
+	case body := <-kafkaCh:
+		var records struct {
+			Records []kafkaRecord `json:"records"`
+		}
+		if err := json.Unmarshal([]byte(body), &records); err != nil {
+			t.Fatal(err)
+		}
+		if len(records.Records) != 1 || records.Records[0].Value.Message != "Mirrored event" {
+			t.Errorf("Event not produced to Kafka: %s", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Timed out waiting for event to be produced to Kafka")
+	}
+}
+
+func TestJournaldMultilineField(t *testing.T) {
+	msg := journaldMessage(types.LogEntry{Message: "first\nsecond", EventType: "info"}, "ciao-test")
+
+	expected := "MESSAGE\n\x0c\x00\x00\x00\x00\x00\x00\x00first\nsecond\n"
+	if !strings.HasPrefix(string(msg), expected) {
+		t.Fatalf("Expected message to start with %q, got %q", expected, msg)
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+const (
+	exportMetadataFile = "metadata.json"
+	exportDiskFile     = "disk.qcow2"
+	exportUserDataFile = "user-data"
+)
+
+// instanceExport is an instance whose boot volume has been exported to a
+// temporary directory, ready to be sent as a bundle.
+type instanceExport struct {
+	dir      string
+	metadata types.InstanceExport
+	userData string
+}
+
+// exportInstance exports the boot volume of a stopped VM instance as a
+// qcow2 image.  The caller must call cleanup once done with the export.
+func (c *controller) exportInstance(tenant string, ID string) (*instanceExport, error) {
+	i, err := c.ds.GetTenantInstance(tenant, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if i.IsDeleted() {
+		return nil, types.ErrInstanceNotFound
+	}
+
+	i.StateLock.RLock()
+	state := i.State
+	i.StateLock.RUnlock()
+
+	if state != payloads.Exited {
+		return nil, types.ErrInstanceNotStopped
+	}
+
+	if err := c.checkInstanceNotArchived(i.ID); err != nil {
+		return nil, err
+	}
+
+	wl, err := c.ds.GetWorkload(i.WorkloadID)
+	if err != nil {
+		return nil, err
+	}
+
+	if i.CNCI || wl.VMType != payloads.QEMU {
+		return nil, types.ErrExportNotSupported
+	}
+
+	var bootVolume string
+	for _, a := range c.ds.GetStorageAttachments(i.ID) {
+		if a.Boot {
+			bootVolume = a.BlockID
+			break
+		}
+	}
+
+	if bootVolume == "" {
+		return nil, types.ErrExportNotSupported
+	}
+
+	vol, err := c.ds.GetBlockDevice(bootVolume)
+	if err != nil {
+		return nil, err
+	}
+
+	if vol.Encrypted {
+		return nil, types.ErrExportNotSupported
+	}
+
+	driver, err := c.volumeDriver(vol.Class)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir("", "ciao-export-")
+	if err != nil {
+		return nil, errors.Wrap(err, "Error creating export directory")
+	}
+
+	err = driver.ExportBlockDevice(vol.ID, filepath.Join(dir, exportDiskFile))
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, errors.Wrapf(err, "Error exporting volume %s", vol.ID)
+	}
+
+	export := &instanceExport{
+		dir: dir,
+		metadata: types.InstanceExport{
+			InstanceID: i.ID,
+			Name:       i.Name,
+			ExportTime: time.Now(),
+			Workload:   wl,
+			Disk:       exportDiskFile,
+		},
+		userData: wl.Config,
+	}
+
+	if wl.Config != "" {
+		export.metadata.UserData = exportUserDataFile
+	}
+
+	return export, nil
+}
+
+func (e *instanceExport) cleanup() {
+	if err := os.RemoveAll(e.dir); err != nil {
+		glog.Warningf("Error removing export directory %s: %v", e.dir, err)
+	}
+}
+
+func writeTarFile(tw *tar.Writer, name string, size int64, data io.Reader) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	_, err := io.Copy(tw, data)
+	return err
+}
+
+// writeBundle writes the export as a tar archive holding its metadata, the
+// cloud-init user data of its workload and its boot disk.
+func (e *instanceExport) writeBundle(w io.Writer) error {
+	tw := tar.NewWriter(w)
+
+	metadata, err := json.MarshalIndent(e.metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = writeTarFile(tw, exportMetadataFile, int64(len(metadata)), bytes.NewReader(metadata))
+	if err != nil {
+		return err
+	}
+
+	if e.metadata.UserData != "" {
+		err = writeTarFile(tw, exportUserDataFile, int64(len(e.userData)), strings.NewReader(e.userData))
+		if err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Open(filepath.Join(e.dir, exportDiskFile))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = writeTarFile(tw, exportDiskFile, fi.Size(), f)
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// instanceExportHandler sends a stopped VM instance as a tar bundle
+// holding its boot disk as a qcow2 image, the cloud-init user data of its
+// workload and a JSON encoded types.InstanceExport describing them, so
+// that it can be moved to another cluster.
+type instanceExportHandler struct {
+	*controller
+}
+
+func (h instanceExportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	export, err := h.exportInstance(tenant, vars["server"])
+	if err != nil {
+		writeErrorResponse(w, r, errorResponse(err).status, err)
+		return
+	}
+	defer export.cleanup()
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"%s.tar\"", export.metadata.InstanceID))
+	w.WriteHeader(http.StatusOK)
+
+	if err := export.writeBundle(w); err != nil {
+		glog.Warningf("Error sending export of instance %s: %v", export.metadata.InstanceID, err)
+		return
+	}
+
+	msg := fmt.Sprintf("Exported instance %s", export.metadata.InstanceID)
+	_ = h.ds.LogEvent(ctx, tenant, msg)
+}
@@ -15,6 +15,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/ciao-project/ciao/ciao-controller/types"
@@ -88,7 +89,7 @@ func (c *controller) makeMappedIPLinks(IP *types.MappedIP, tenant *string) {
 	}
 }
 
-func (c *controller) AddPool(name string, subnet *string, ips []string) (types.Pool, error) {
+func (c *controller) AddPool(ctx context.Context, name string, subnet *string, ips []string) (types.Pool, error) {
 	pools, err := c.ds.GetPools()
 	if err != nil {
 		return types.Pool{}, err
@@ -105,12 +106,12 @@ func (c *controller) AddPool(name string, subnet *string, ips []string) (types.P
 		Name: name,
 	}
 
-	err = c.ds.AddPool(pool)
+	err = c.ds.AddPool(ctx, pool)
 	if err != nil {
 		return pool, err
 	}
 
-	err = c.AddAddress(pool.ID, subnet, ips)
+	err = c.AddAddress(ctx, pool.ID, subnet, ips)
 	if err != nil {
 		return pool, err
 	}
@@ -118,7 +119,7 @@ func (c *controller) AddPool(name string, subnet *string, ips []string) (types.P
 	return c.ds.GetPool(pool.ID)
 }
 
-func (c *controller) ListPools() ([]types.Pool, error) {
+func (c *controller) ListPools(ctx context.Context) ([]types.Pool, error) {
 	pools, err := c.ds.GetPools()
 	if err != nil {
 		return pools, err
@@ -134,7 +135,7 @@ func (c *controller) ListPools() ([]types.Pool, error) {
 	return pools, nil
 }
 
-func (c *controller) ShowPool(ID string) (types.Pool, error) {
+func (c *controller) ShowPool(ctx context.Context, ID string) (types.Pool, error) {
 	pool, err := c.ds.GetPool(ID)
 	if err != nil {
 		return pool, err
@@ -145,31 +146,31 @@ func (c *controller) ShowPool(ID string) (types.Pool, error) {
 	return pool, nil
 }
 
-func (c *controller) AddAddress(poolID string, subnet *string, ips []string) error {
+func (c *controller) AddAddress(ctx context.Context, poolID string, subnet *string, ips []string) error {
 	if subnet != nil {
-		return c.ds.AddExternalSubnet(poolID, *subnet)
+		return c.ds.AddExternalSubnet(ctx, poolID, *subnet)
 	}
 
-	return c.ds.AddExternalIPs(poolID, ips)
+	return c.ds.AddExternalIPs(ctx, poolID, ips)
 }
 
-func (c *controller) DeletePool(ID string) error {
-	return c.ds.DeletePool(ID)
+func (c *controller) DeletePool(ctx context.Context, ID string) error {
+	return c.ds.DeletePool(ctx, ID)
 }
 
-func (c *controller) RemoveAddress(poolID string, subnetID *string, IPID *string) error {
+func (c *controller) RemoveAddress(ctx context.Context, poolID string, subnetID *string, IPID *string) error {
 	if subnetID != nil {
-		return c.ds.DeleteSubnet(poolID, *subnetID)
+		return c.ds.DeleteSubnet(ctx, poolID, *subnetID)
 	}
 
 	if IPID != nil {
-		return c.ds.DeleteExternalIP(poolID, *IPID)
+		return c.ds.DeleteExternalIP(ctx, poolID, *IPID)
 	}
 
 	return types.ErrBadRequest
 }
 
-func (c *controller) ListMappedAddresses(tenant *string) []types.MappedIP {
+func (c *controller) ListMappedAddresses(ctx context.Context, tenant *string) []types.MappedIP {
 	IPs := c.ds.GetMappedIPs(tenant)
 
 	for i := range IPs {
@@ -180,7 +181,7 @@ func (c *controller) ListMappedAddresses(tenant *string) []types.MappedIP {
 	return IPs
 }
 
-func (c *controller) MapAddress(tenantID string, poolName *string, instanceID string) (err error) {
+func (c *controller) MapAddress(ctx context.Context, tenantID string, poolName *string, instanceID string) (err error) {
 	var m types.MappedIP
 	var i *types.Instance
 
@@ -216,11 +217,11 @@ func (c *controller) MapAddress(tenantID string, poolName *string, instanceID st
 	for _, pool := range pools {
 		if poolName != nil {
 			if pool.Name == *poolName {
-				m, err = c.ds.MapExternalIP(pool.ID, instanceID)
+				m, err = c.ds.MapExternalIP(ctx, pool.ID, instanceID)
 				break
 			}
 		} else if pool.Free > 0 {
-			m, err = c.ds.MapExternalIP(pool.ID, instanceID)
+			m, err = c.ds.MapExternalIP(ctx, pool.ID, instanceID)
 			break
 		}
 	}
@@ -230,22 +231,22 @@ func (c *controller) MapAddress(tenantID string, poolName *string, instanceID st
 	}
 
 	// get tenant CNCI info
-	t, err := c.ds.GetTenant(m.TenantID)
+	t, err := c.ds.GetTenant(ctx, m.TenantID)
 	if err != nil {
-		_ = c.UnMapAddress(m.ExternalIP)
+		_ = c.UnMapAddress(ctx, m.ExternalIP)
 		return err
 	}
 
 	err = c.client.mapExternalIP(*t, m)
 	if err != nil {
 		// can never fail at this point.
-		_ = c.UnMapAddress(m.ExternalIP)
+		_ = c.UnMapAddress(ctx, m.ExternalIP)
 	}
 
 	return err
 }
 
-func (c *controller) UnMapAddress(address string) error {
+func (c *controller) UnMapAddress(ctx context.Context, address string) error {
 	// get mapping
 	m, err := c.ds.GetMappedIP(address)
 	if err != nil {
@@ -253,7 +254,7 @@ func (c *controller) UnMapAddress(address string) error {
 	}
 
 	// get tenant CNCI info
-	t, err := c.ds.GetTenant(m.TenantID)
+	t, err := c.ds.GetTenant(ctx, m.TenantID)
 	if err != nil {
 		return err
 	}
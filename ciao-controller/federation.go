@@ -0,0 +1,330 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/client"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+var peerPollInterval = flag.Duration("federation_poll_interval", 30*time.Second, "Interval between refreshes of the state of the instances burst to federation peers")
+
+// federationSecretScope replaces the tenant ID when encrypting the API
+// tokens of the federation peers, so that they cannot be swapped with
+// tenant secrets.
+const federationSecretScope = "federation"
+
+// ListFederationPeers returns the peer clusters instances are burst to.
+func (c *controller) ListFederationPeers(ctx context.Context) ([]types.FederationPeer, error) {
+	return c.ds.GetFederationPeers(ctx)
+}
+
+// AddFederationPeer registers a peer cluster.  The API token of the peer
+// tenant is checked before the peer is stored.
+func (c *controller) AddFederationPeer(ctx context.Context, req types.FederationPeerRequest) (types.FederationPeer, error) {
+	for workloadID := range req.Workloads {
+		if _, err := c.ds.GetWorkload(workloadID); err != nil {
+			return types.FederationPeer{}, err
+		}
+	}
+
+	peer := types.FederationPeer{
+		ID:         uuid.Generate().String(),
+		Name:       req.Name,
+		URL:        req.URL,
+		TenantID:   req.TenantID,
+		CACertFile: req.CACertFile,
+		Workloads:  req.Workloads,
+		CreateTime: time.Now(),
+	}
+
+	token, err := c.encryptSecret(federationSecretScope, peer.ID, req.APIToken)
+	if err != nil {
+		return types.FederationPeer{}, err
+	}
+	peer.Token = token
+
+	pc, err := c.peerClient(peer)
+	if err != nil {
+		return types.FederationPeer{}, err
+	}
+
+	if _, err := pc.ListInstances(); err != nil {
+		c.dropPeerClient(peer.ID)
+		return types.FederationPeer{}, errors.Wrap(err, "Error accessing federation peer")
+	}
+
+	err = c.ds.AddFederationPeer(ctx, peer)
+	if err != nil {
+		c.dropPeerClient(peer.ID)
+		return types.FederationPeer{}, err
+	}
+
+	glog.Infof("Added federation peer %s (%s)", peer.ID, peer.URL)
+
+	return peer, nil
+}
+
+// DeleteFederationPeer removes a peer cluster.  Peers still running
+// instances of the cluster cannot be removed.
+func (c *controller) DeleteFederationPeer(ctx context.Context, ID string) error {
+	_, err := c.ds.GetFederationPeer(ctx, ID)
+	if err != nil {
+		return err
+	}
+
+	instances, err := c.ds.GetAllInstances()
+	if err != nil {
+		return err
+	}
+
+	for _, i := range instances {
+		if i.PeerID == ID {
+			return types.ErrPeerInUse
+		}
+	}
+
+	err = c.ds.DeleteFederationPeer(ctx, ID)
+	if err != nil {
+		return err
+	}
+
+	c.dropPeerClient(ID)
+
+	glog.Infof("Removed federation peer %s", ID)
+
+	return nil
+}
+
+// peerClient returns the client used to access a peer, which is shared by
+// all the requests made to the peer.
+func (c *controller) peerClient(peer types.FederationPeer) (*client.Client, error) {
+	c.peerClientsLock.Lock()
+	defer c.peerClientsLock.Unlock()
+
+	if pc, ok := c.peerClients[peer.ID]; ok {
+		return pc, nil
+	}
+
+	token, err := c.decryptSecret(types.Secret{
+		TenantID: federationSecretScope,
+		Name:     peer.ID,
+		Data:     peer.Token,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pc := &client.Client{
+		ControllerURL: peer.URL,
+		TenantID:      peer.TenantID,
+		APIToken:      token,
+		CACertFile:    peer.CACertFile,
+	}
+
+	err = pc.Init()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error initialising client of federation peer %s", peer.ID)
+	}
+
+	if c.peerClients == nil {
+		c.peerClients = make(map[string]*client.Client)
+	}
+	c.peerClients[peer.ID] = pc
+
+	return pc, nil
+}
+
+func (c *controller) dropPeerClient(ID string) {
+	c.peerClientsLock.Lock()
+	delete(c.peerClients, ID)
+	c.peerClientsLock.Unlock()
+}
+
+// burstInstance launches an instance the scheduler could not place in
+// the first peer able to run its workload.  The instance is kept in the
+// datastore so that it is still listed, with its state refreshed from the
+// peer.  It returns false if no peer launched the instance.
+func (c *controller) burstInstance(ctx context.Context, instanceID string) bool {
+	i, err := c.ds.GetInstance(instanceID)
+	if err != nil || i.CNCI {
+		return false
+	}
+
+	peers, err := c.ds.GetFederationPeers(ctx)
+	if err != nil {
+		glog.Warningf("Error getting federation peers: %v", err)
+		return false
+	}
+
+	for _, peer := range peers {
+		workloadID, ok := peer.Workloads[i.WorkloadID]
+		if !ok {
+			continue
+		}
+
+		remoteID, err := c.launchPeerInstance(peer, workloadID, i.Name)
+		if err != nil {
+			glog.Warningf("Error bursting instance %s to federation peer %s: %v", i.ID, peer.ID, err)
+			continue
+		}
+
+		err = c.ds.SetInstancePeer(ctx, i.ID, peer.ID, remoteID)
+		if err != nil {
+			glog.Warningf("Error recording burst of instance %s: %v", i.ID, err)
+			c.deleteRemoteInstance(peer, remoteID)
+			return false
+		}
+
+		msg := fmt.Sprintf("Burst instance %s to federation peer %s", i.ID, peer.Name)
+		_ = c.ds.LogEvent(ctx, i.TenantID, msg)
+
+		return true
+	}
+
+	return false
+}
+
+// launchPeerInstance creates an instance of a peer workload and returns
+// the ID of the instance in the peer.
+func (c *controller) launchPeerInstance(peer types.FederationPeer, workloadID string, name string) (string, error) {
+	pc, err := c.peerClient(peer)
+	if err != nil {
+		return "", err
+	}
+
+	var req api.CreateServerRequest
+	req.Server.WorkloadID = workloadID
+	req.Server.Name = name
+	req.Server.MaxInstances = 1
+	req.Server.MinInstances = 1
+
+	servers, err := pc.CreateInstances(req)
+	if err != nil {
+		return "", err
+	}
+
+	if len(servers.Servers) != 1 {
+		return "", fmt.Errorf("Expected 1 instance, got %d", len(servers.Servers))
+	}
+
+	return servers.Servers[0].ID, nil
+}
+
+func (c *controller) deleteRemoteInstance(peer types.FederationPeer, remoteID string) {
+	pc, err := c.peerClient(peer)
+	if err == nil {
+		err = pc.DeleteInstance(remoteID)
+	}
+
+	if err != nil {
+		glog.Warningf("Error deleting instance %s of federation peer %s: %v", remoteID, peer.ID, err)
+	}
+}
+
+// peerInstance returns the peer an instance was burst to, if any.
+func (c *controller) peerInstance(ctx context.Context, i *types.Instance) (*client.Client, string, error) {
+	i.StateLock.RLock()
+	peerID := i.PeerID
+	remoteID := i.RemoteID
+	i.StateLock.RUnlock()
+
+	if peerID == "" {
+		return nil, "", nil
+	}
+
+	peer, err := c.ds.GetFederationPeer(ctx, peerID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	pc, err := c.peerClient(peer)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return pc, remoteID, nil
+}
+
+// deletePeerInstance deletes an instance burst to a peer, both in the
+// peer and in the cluster.
+func (c *controller) deletePeerInstance(i *types.Instance, pc *client.Client, remoteID string) error {
+	err := pc.DeleteInstance(remoteID)
+	if err != nil {
+		return errors.Wrap(err, "Error deleting instance in federation peer")
+	}
+
+	c.client.RemoveInstance(i.ID)
+
+	return nil
+}
+
+func (c *controller) peerPoller(stop <-chan struct{}) {
+	ctx := context.Background()
+
+	ticker := time.NewTicker(*peerPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.refreshPeerInstances(ctx)
+		}
+	}
+}
+
+// refreshPeerInstances updates the state of the instances burst to
+// federation peers with the state reported by the peers.
+func (c *controller) refreshPeerInstances(ctx context.Context) {
+	instances, err := c.ds.GetAllInstances()
+	if err != nil {
+		glog.Warningf("Error getting instances burst to federation peers: %v", err)
+		return
+	}
+
+	for _, i := range instances {
+		pc, remoteID, err := c.peerInstance(ctx, i)
+		if err != nil {
+			glog.Warningf("Error getting federation peer of instance %s: %v", i.ID, err)
+			continue
+		}
+
+		if pc == nil {
+			continue
+		}
+
+		server, err := pc.GetInstance(remoteID)
+		if err != nil {
+			glog.Warningf("Error getting instance %s from federation peer: %v", i.ID, err)
+			continue
+		}
+
+		err = c.ds.PeerInstanceStatus(ctx, i.ID, server.Server.Status, server.Server.SSHIP, server.Server.SSHPort)
+		if err != nil {
+			glog.Warningf("Error updating state of instance %s: %v", i.ID, err)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/ssntp"
+	"github.com/ciao-project/ciao/testutil"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+const testPeerToken = "peer-token-secret"
+
+// testPeer is a fake peer cluster running a single instance.
+type testPeer struct {
+	*httptest.Server
+	caCertFile string
+	tenantID   string
+	workloadID string
+	remoteID   string
+
+	lock    sync.Mutex
+	deleted bool
+}
+
+func (p *testPeer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+testPeerToken ||
+		!strings.HasPrefix(r.URL.Path, "/"+p.tenantID+"/instances") {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var resp interface{}
+	switch {
+	case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/detail"):
+		resp = api.Servers{}
+	case r.Method == "POST":
+		var req api.CreateServerRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil ||
+			req.Server.WorkloadID != p.workloadID {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		resp = api.Servers{
+			TotalServers: 1,
+			Servers:      []api.ServerDetails{{ID: p.remoteID}},
+		}
+	case r.Method == "GET":
+		resp = api.Server{
+			Server: api.ServerDetails{
+				ID:      p.remoteID,
+				Status:  payloads.Running,
+				SSHIP:   "198.51.100.7",
+				SSHPort: 33022,
+			},
+		}
+	case r.Method == "DELETE":
+		p.deleted = true
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func newTestPeer(t *testing.T) *testPeer {
+	p := &testPeer{
+		tenantID:   uuid.Generate().String(),
+		workloadID: uuid.Generate().String(),
+		remoteID:   uuid.Generate().String(),
+	}
+	p.Server = httptest.NewTLSServer(p)
+
+	f, err := ioutil.TempFile("", "peer-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	err = pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: p.Certificate().Raw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.caCertFile = f.Name()
+
+	return p
+}
+
+func (p *testPeer) Close() {
+	p.Server.Close()
+	_ = os.Remove(p.caCertFile)
+}
+
+func TestFederationBurst(t *testing.T) {
+	ctx := context.Background()
+
+	p := newTestPeer(t)
+	defer p.Close()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatalf("No workloads: %v", err)
+	}
+
+	req := types.FederationPeerRequest{
+		Name:       "east",
+		URL:        p.URL,
+		TenantID:   p.tenantID,
+		APIToken:   "wrong-token",
+		CACertFile: p.caCertFile,
+		Workloads:  map[string]string{wls[0].ID: p.workloadID},
+	}
+
+	_, err = ctl.AddFederationPeer(ctx, req)
+	if err == nil {
+		t.Fatal("Expected an error adding a peer with an invalid token")
+	}
+
+	req.APIToken = testPeerToken
+	peer, err := ctl.AddFederationPeer(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(peer.Token, []byte(testPeerToken)) {
+		t.Fatal("Peer API token stored in clear")
+	}
+
+	client, err := testutil.NewSsntpTestClientConnection("FederationBurst", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	client.StartFail = true
+	client.StartFailReason = payloads.FullCloud
+
+	controllerCh := wrappedClient.addErrorChan(ssntp.StartFailure)
+
+	w := types.WorkloadRequest{
+		WorkloadID: wls[0].ID,
+		TenantID:   tenant.ID,
+		Instances:  1,
+		Name:       "burst",
+	}
+	instances, err := ctl.startWorkload(ctx, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = wrappedClient.getErrorChan(controllerCh, ssntp.StartFailure)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := ctl.ds.GetInstance(instances[0].ID)
+	if err != nil {
+		t.Fatalf("Burst instance deleted: %v", err)
+	}
+
+	if i.PeerID != peer.ID || i.RemoteID != p.remoteID {
+		t.Fatalf("Expected instance %s in peer %s, got %s in %s", p.remoteID, peer.ID, i.RemoteID, i.PeerID)
+	}
+
+	ctl.refreshPeerInstances(ctx)
+
+	s, err := ctl.ShowServerDetails(ctx, tenant.ID, i.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Server.Status != payloads.Running || s.Server.SSHIP != "198.51.100.7" ||
+		s.Server.SSHPort != 33022 || s.Server.PeerID != peer.ID {
+		t.Fatalf("Unexpected state of burst instance: %+v", s.Server)
+	}
+
+	err = ctl.DeleteFederationPeer(ctx, peer.ID)
+	if err != types.ErrPeerInUse {
+		t.Fatalf("Expected %v, got %v", types.ErrPeerInUse, err)
+	}
+
+	err = ctl.DeleteServer(ctx, tenant.ID, i.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.lock.Lock()
+	deleted := p.deleted
+	p.lock.Unlock()
+	if !deleted {
+		t.Fatal("Instance not deleted in the peer")
+	}
+
+	_, err = ctl.ds.GetInstance(i.ID)
+	if err == nil {
+		t.Fatal("Burst instance not deleted")
+	}
+
+	err = ctl.DeleteFederationPeer(ctx, peer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ctl.ds.GetFederationPeer(ctx, peer.ID)
+	if err != types.ErrPeerNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrPeerNotFound, err)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"regexp"
+	"sort"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+)
+
+var groupNameRegexp = regexp.MustCompile("^[a-z0-9-]{1,64}$")
+
+func validGroupName(name string) bool {
+	return groupNameRegexp.MatchString(name)
+}
+
+// sortGroupMembers sorts the instances of a group, oldest first.
+func sortGroupMembers(members []*types.Instance) {
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].CreateTime.Equal(members[j].CreateTime) {
+			return members[i].ID < members[j].ID
+		}
+		return members[i].CreateTime.Before(members[j].CreateTime)
+	})
+}
+
+// groupInstances returns the instances of a tenant which belong to a
+// group, oldest first.
+func (c *controller) groupInstances(tenant string, group string) ([]*types.Instance, error) {
+	instances, err := c.ds.GetAllInstancesFromTenant(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	var members []*types.Instance
+	for _, i := range instances {
+		if i.Group == group && !i.IsDeleted() {
+			members = append(members, i)
+		}
+	}
+
+	if len(members) == 0 {
+		return nil, types.ErrInstanceGroupNotFound
+	}
+
+	sortGroupMembers(members)
+
+	return members, nil
+}
+
+func instanceGroup(name string, members []*types.Instance) api.InstanceGroup {
+	group := api.InstanceGroup{
+		Name:       name,
+		WorkloadID: members[len(members)-1].WorkloadID,
+	}
+
+	for _, i := range members {
+		group.Instances = append(group.Instances, i.ID)
+	}
+
+	return group
+}
+
+// ListInstanceGroups returns the instance groups of a tenant sorted by
+// name.
+func (c *controller) ListInstanceGroups(ctx context.Context, tenant string) ([]api.InstanceGroup, error) {
+	instances, err := c.ds.GetAllInstancesFromTenant(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[string][]*types.Instance)
+	for _, i := range instances {
+		if i.Group != "" && !i.IsDeleted() {
+			members[i.Group] = append(members[i.Group], i)
+		}
+	}
+
+	groups := []api.InstanceGroup{}
+	for name, m := range members {
+		sortGroupMembers(m)
+		groups = append(groups, instanceGroup(name, m))
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+
+	return groups, nil
+}
+
+// ShowInstanceGroup returns an instance group of a tenant.
+func (c *controller) ShowInstanceGroup(ctx context.Context, tenant string, group string) (api.InstanceGroup, error) {
+	members, err := c.groupInstances(tenant, group)
+	if err != nil {
+		return api.InstanceGroup{}, err
+	}
+
+	return instanceGroup(group, members), nil
+}
+
+func memberResult(instanceID string, operation string, err error) api.InstanceGroupMemberResult {
+	result := api.InstanceGroupMemberResult{
+		InstanceID: instanceID,
+		Operation:  operation,
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+// InstanceGroupAction applies an action to all the instances of a group.
+// Members the action does not apply to, e.g. exited instances when
+// stopping a group, are reported as skipped.  The action goes on when it
+// fails for a member and the failure is reported in the member result.
+func (c *controller) InstanceGroupAction(ctx context.Context, tenant string, group string,
+	req api.InstanceGroupActionRequest) (api.InstanceGroupActionResult, error) {
+	result := api.InstanceGroupActionResult{
+		Group:   group,
+		Action:  req.Action,
+		Results: []api.InstanceGroupMemberResult{},
+	}
+
+	members, err := c.groupInstances(tenant, group)
+	if err != nil {
+		return result, err
+	}
+
+	switch req.Action {
+	case api.GroupStop:
+		result.Results = c.groupApply(ctx, tenant, members, "stop", payloads.Running, c.StopServer)
+	case api.GroupRestart:
+		result.Results = c.groupApply(ctx, tenant, members, "restart", payloads.Exited, c.StartServer)
+	case api.GroupDelete:
+		result.Results = c.groupApply(ctx, tenant, members, "delete", "", c.DeleteServer)
+	case api.GroupScale:
+		if req.Instances < 0 {
+			return result, types.ErrBadRequest
+		}
+		result.Results = c.scaleGroup(ctx, tenant, group, members, req.Instances)
+	default:
+		return result, types.ErrBadRequest
+	}
+
+	return result, nil
+}
+
+// groupApply applies a per instance operation to the members of a group in
+// a given state, or to all of them if state is empty.
+func (c *controller) groupApply(ctx context.Context, tenant string, members []*types.Instance, operation string,
+	state string, action func(context.Context, string, string) error) []api.InstanceGroupMemberResult {
+	results := []api.InstanceGroupMemberResult{}
+
+	for _, i := range members {
+		if state != "" && i.State != state {
+			result := memberResult(i.ID, operation, nil)
+			result.Skipped = true
+			results = append(results, result)
+			continue
+		}
+
+		err := action(ctx, tenant, i.ID)
+		results = append(results, memberResult(i.ID, operation, err))
+	}
+
+	return results
+}
+
+// scaleGroup launches new instances of the workload of a group, or deletes
+// its most recently created instances, until it has num instances.
+func (c *controller) scaleGroup(ctx context.Context, tenant string, group string, members []*types.Instance,
+	num int) []api.InstanceGroupMemberResult {
+	results := []api.InstanceGroupMemberResult{}
+
+	if num < len(members) {
+		for i := len(members) - 1; i >= num; i-- {
+			err := c.DeleteServer(ctx, tenant, members[i].ID)
+			results = append(results, memberResult(members[i].ID, "delete", err))
+		}
+
+		return results
+	}
+
+	if num == len(members) {
+		return results
+	}
+
+	w := types.WorkloadRequest{
+		WorkloadID: members[len(members)-1].WorkloadID,
+		TenantID:   tenant,
+		Instances:  num - len(members),
+		Group:      group,
+	}
+
+	instances, err := c.startWorkload(ctx, w)
+	for _, i := range instances {
+		results = append(results, memberResult(i.ID, "create", nil))
+	}
+
+	for n := len(instances); n < w.Instances; n++ {
+		results = append(results, memberResult("", "create", err))
+	}
+
+	return results
+}
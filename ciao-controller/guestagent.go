@@ -0,0 +1,165 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+var guestAgentTimeout = flag.Duration("guest_agent_timeout", time.Minute, "Time to wait for the result of guest agent operations")
+
+// GuestAgentCommand runs an operation through the QEMU guest agent of a
+// running VM instance and waits for its result.  Only tenants with the
+// guest agent permission may use the guest agent of their instances.
+func (c *controller) GuestAgentCommand(ctx context.Context, tenantID string, ID string,
+	req types.GuestAgentRequest) (types.GuestAgentResult, error) {
+	tenant, err := c.ds.GetTenant(ctx, tenantID)
+	if err != nil {
+		return types.GuestAgentResult{}, err
+	}
+
+	if tenant == nil {
+		return types.GuestAgentResult{}, types.ErrTenantNotFound
+	}
+
+	if !tenant.Permissions.GuestAgent {
+		return types.GuestAgentResult{}, types.ErrGuestAgentNotPermitted
+	}
+
+	i, err := c.ds.GetTenantInstance(tenantID, ID)
+	if err != nil {
+		return types.GuestAgentResult{}, err
+	}
+
+	wl, err := c.ds.GetWorkload(i.WorkloadID)
+	if err != nil {
+		return types.GuestAgentResult{}, err
+	}
+
+	if i.CNCI || wl.VMType != payloads.QEMU {
+		return types.GuestAgentResult{}, types.ErrGuestAgentNotSupported
+	}
+
+	i.StateLock.RLock()
+	state := i.State
+	nodeID := i.NodeID
+	i.StateLock.RUnlock()
+
+	if nodeID == "" {
+		return types.GuestAgentResult{}, types.ErrInstanceNotAssigned
+	}
+
+	if state != payloads.Running {
+		return types.GuestAgentResult{}, types.ErrInstanceNotRunning
+	}
+
+	cmd := payloads.GuestAgentCmd{
+		InstanceUUID:      i.ID,
+		WorkloadAgentUUID: nodeID,
+		RequestUUID:       uuid.Generate().String(),
+		Operation:         payloads.GuestAgentOperation(req.Operation),
+		Path:              req.Path,
+		Args:              req.Args,
+	}
+
+	resultCh := make(chan payloads.GuestAgentResultEvent, 1)
+	c.guestAgentLock.Lock()
+	if c.guestAgentCalls == nil {
+		c.guestAgentCalls = make(map[string]chan payloads.GuestAgentResultEvent)
+	}
+	c.guestAgentCalls[cmd.RequestUUID] = resultCh
+	c.guestAgentLock.Unlock()
+
+	defer func() {
+		c.guestAgentLock.Lock()
+		delete(c.guestAgentCalls, cmd.RequestUUID)
+		c.guestAgentLock.Unlock()
+	}()
+
+	switch req.Operation {
+	case types.GuestAgentExec:
+		msg := fmt.Sprintf("Running %s in the guest of instance %s",
+			strings.Join(append([]string{req.Path}, req.Args...), " "), i.ID)
+		_ = c.ds.LogEvent(ctx, tenantID, msg)
+	case types.GuestAgentFreeze, types.GuestAgentThaw:
+		msg := fmt.Sprintf("Guest agent %s on instance %s", req.Operation, i.ID)
+		_ = c.ds.LogEvent(ctx, tenantID, msg)
+	}
+
+	err = c.client.GuestAgentCommand(cmd)
+	if err != nil {
+		return types.GuestAgentResult{}, errors.Wrap(err, "Error sending guest agent command")
+	}
+
+	var event payloads.GuestAgentResultEvent
+	select {
+	case event = <-resultCh:
+	case <-ctx.Done():
+		return types.GuestAgentResult{}, ctx.Err()
+	case <-time.After(*guestAgentTimeout):
+		return types.GuestAgentResult{}, types.ErrGuestAgentTimeout
+	}
+
+	if event.Error != "" {
+		return types.GuestAgentResult{}, fmt.Errorf("Guest agent %s failed: %s", req.Operation, event.Error)
+	}
+
+	result := types.GuestAgentResult{
+		InstanceID:  i.ID,
+		Operation:   req.Operation,
+		ExitCode:    event.ExitCode,
+		Stdout:      event.Stdout,
+		Stderr:      event.Stderr,
+		Filesystems: event.Filesystems,
+	}
+
+	for _, iface := range event.Interfaces {
+		result.Interfaces = append(result.Interfaces, types.GuestInterface{
+			Name:            iface.Name,
+			HardwareAddress: iface.HardwareAddress,
+			IPAddresses:     iface.IPAddresses,
+		})
+	}
+
+	return result, nil
+}
+
+// guestAgentResult hands the result of a guest agent operation over to the
+// request waiting for it.  Results of requests which timed out are dropped.
+func (c *controller) guestAgentResult(event payloads.GuestAgentResultEvent) {
+	c.guestAgentLock.Lock()
+	resultCh, ok := c.guestAgentCalls[event.RequestUUID]
+	c.guestAgentLock.Unlock()
+
+	if !ok {
+		glog.Warningf("Dropping guest agent result of unknown request %s", event.RequestUUID)
+		return
+	}
+
+	select {
+	case resultCh <- event:
+	default:
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// readinessTimeout bounds the time taken by each readiness check, as the
+// storage checks cannot be cancelled.
+const readinessTimeout = 10 * time.Second
+
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+func (c *controller) readinessChecks() []readinessCheck {
+	return []readinessCheck{
+		{"datastore", c.ds.Ping},
+		{"ssntp", func(ctx context.Context) error {
+			if !c.client.Connected() {
+				return errors.New("Not connected to the scheduler")
+			}
+			return nil
+		}},
+		{"storage", func(ctx context.Context) error {
+			_, err := c.ListBlockDevices()
+			return errors.Wrap(err, "Error listing block devices")
+		}},
+	}
+}
+
+func runReadinessCheck(ctx context.Context, rc readinessCheck) error {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- rc.check(ctx)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "Check did not complete")
+	}
+}
+
+// healthz reports that the controller is alive.  It requires no
+// authentication, so that load balancers can probe it.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// readyz reports whether the controller can serve requests: its
+// datastore can be queried, it is connected to the scheduler and the
+// storage cluster can be reached.  The status of each check is returned,
+// with 503 if any of them failed.
+func (c *controller) readyz(w http.ResponseWriter, r *http.Request) {
+	status := make(map[string]string)
+	code := http.StatusOK
+
+	for _, rc := range c.readinessChecks() {
+		err := runReadinessCheck(r.Context(), rc)
+		if err != nil {
+			glog.Warningf("Readiness check %s failed: %v", rc.name, err)
+			status[rc.name] = err.Error()
+			code = http.StatusServiceUnavailable
+			continue
+		}
+		status[rc.name] = "ok"
+	}
+
+	b, err := json.Marshal(status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(b)
+}
@@ -15,9 +15,13 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"regexp"
 	"time"
@@ -25,12 +29,14 @@ import (
 	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/service"
 	"github.com/ciao-project/ciao/uuid"
 	"github.com/golang/glog"
+	"github.com/gorilla/mux"
 )
 
 // CreateImage will create an empty image in the image datastore.
-func (c *controller) CreateImage(tenantID string, req api.CreateImageRequest) (types.Image, error) {
+func (c *controller) CreateImage(ctx context.Context, tenantID string, req api.CreateImageRequest) (types.Image, error) {
 	// create an ImageInfo struct and store it in our image
 	// datastore.
 	glog.Infof("Creating Image: %v", req.ID)
@@ -50,6 +56,15 @@ func (c *controller) CreateImage(tenantID string, req api.CreateImageRequest) (t
 		return types.Image{}, types.ErrBadName
 	}
 
+	if req.URL != "" {
+		if err := validateImageSource(req.URL, req.Checksum); err != nil {
+			glog.Errorf("Invalid image source: %v", err)
+			return types.Image{}, types.ErrBadRequest
+		}
+	} else if req.Checksum != "" {
+		return types.Image{}, types.ErrBadRequest
+	}
+
 	i := types.Image{
 		ID:         id,
 		TenantID:   tenantID,
@@ -59,7 +74,7 @@ func (c *controller) CreateImage(tenantID string, req api.CreateImageRequest) (t
 		Visibility: req.Visibility,
 	}
 
-	err := c.ds.AddImage(i)
+	err := c.ds.AddImage(ctx, i)
 	if err != nil {
 		glog.Errorf("Error adding image to datastore: %v", err)
 		return types.Image{}, err
@@ -72,12 +87,16 @@ func (c *controller) CreateImage(tenantID string, req api.CreateImageRequest) (t
 		return types.Image{}, api.ErrQuota
 	}
 
+	if req.URL != "" {
+		return c.startImageImport(ctx, i, req.URL, req.Checksum)
+	}
+
 	glog.Infof("Image %v added", id)
 	return i, nil
 }
 
 // ListImages will return a list of all the images in the datastore.
-func (c *controller) ListImages(tenant string) ([]types.Image, error) {
+func (c *controller) ListImages(ctx context.Context, tenant string) ([]types.Image, error) {
 	glog.Infof("Listing images from [%v]", tenant)
 
 	if tenant == "admin" {
@@ -87,7 +106,13 @@ func (c *controller) ListImages(tenant string) ([]types.Image, error) {
 	return c.ds.GetImages(tenant, false)
 }
 
-func (c *controller) uploadImage(imageID string, body io.Reader) error {
+// qcow2Magic is the signature at the start of qcow2 images.
+var qcow2Magic = []byte{'Q', 'F', 'I', 0xfb}
+
+// stageImage writes the data of an image to a temporary file and creates
+// its block device from this file, converting the image to a raw block
+// device.
+func (c *controller) stageImage(imageID string, body io.Reader) error {
 	f, err := ioutil.TempFile("", "ciao-image")
 	if err != nil {
 		return fmt.Errorf("Error creating temporary image file: %v", err)
@@ -107,7 +132,27 @@ func (c *controller) uploadImage(imageID string, body io.Reader) error {
 	}
 
 	_, err = c.CreateBlockDevice(imageID, f.Name(), 0)
+	return err
+}
+
+func (c *controller) uploadImage(imageID string, body io.Reader) error {
+	// Raw images are streamed straight into the block device, no local
+	// staging file is needed.  qcow2 images need to be converted, which
+	// qemu-img cannot do from a stream, so they are staged.
+	r := bufio.NewReaderSize(body, 1<<16)
+	magic, err := r.Peek(len(qcow2Magic))
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("Error reading image data: %v", err)
+	}
+
+	if bytes.Equal(magic, qcow2Magic) {
+		err = c.stageImage(imageID, r)
+	} else {
+		_, err = c.CreateBlockDeviceFromStream(imageID, r)
+	}
 	if err != nil {
+		// an interrupted stream may leave partial data behind
+		_ = c.DeleteBlockDevice(imageID)
 		return fmt.Errorf("Error creating block device: %v", err)
 	}
 
@@ -121,7 +166,7 @@ func (c *controller) uploadImage(imageID string, body io.Reader) error {
 }
 
 // UploadImage will upload a raw image data and update its status.
-func (c *controller) UploadImage(tenantID, imageID string, body io.Reader) error {
+func (c *controller) UploadImage(ctx context.Context, tenantID, imageID string, body io.Reader) error {
 	glog.Infof("Uploading image: %v", imageID)
 
 	image, err := c.ds.GetImage(imageID)
@@ -133,42 +178,58 @@ func (c *controller) UploadImage(tenantID, imageID string, body io.Reader) error
 		return api.ErrNoImage
 	}
 
+	c.startImageUpload(imageID)
+	defer c.endImageUpload(imageID)
+
 	image.State = types.Saving
-	err = c.ds.UpdateImage(image)
+	err = c.ds.UpdateImage(ctx, image)
+	if err != nil {
+		return err
+	}
+
+	op := c.newOperation(image.TenantID, types.ImageImport, imageID)
+
+	err = c.storeImage(ctx, image, &op, body)
 	if err != nil {
 		return err
 	}
 
-	err = c.uploadImage(imageID, body)
+	glog.Infof("Image %v uploaded", imageID)
+	return nil
+}
+
+// storeImage stores the data of an image being saved and makes it active,
+// or kills it if the data cannot be stored. The outcome is recorded in the
+// image import operation op.
+func (c *controller) storeImage(ctx context.Context, image types.Image, op *types.Operation, body io.Reader) error {
+	err := c.uploadImage(image.ID, body)
 	if err != nil {
 		glog.Errorf("Error uploading image: %v", err)
 		image.State = types.Killed
-		_ = c.ds.UpdateImage(image)
+		_ = c.ds.UpdateImage(ctx, image)
+		c.completeOperation(op, err)
 		return api.ErrImageSaving
 	}
 
-	imageSize, err := c.GetBlockDeviceSize(imageID)
+	imageSize, err := c.GetBlockDeviceSize(image.ID)
 	if err != nil {
 		glog.Errorf("Error getting block device size: %v", err)
 		image.State = types.Killed
-		_ = c.ds.UpdateImage(image)
+		_ = c.ds.UpdateImage(ctx, image)
+		c.completeOperation(op, err)
 		return api.ErrImageSaving
 	}
 
 	image.Size = imageSize
 	image.State = types.Active
 
-	err = c.ds.UpdateImage(image)
-	if err != nil {
-		return err
-	}
-
-	glog.Infof("Image %v uploaded", imageID)
-	return nil
+	err = c.ds.UpdateImage(ctx, image)
+	c.completeOperation(op, err)
+	return err
 }
 
 // DeleteImage will delete a raw image and its metadata
-func (c *controller) DeleteImage(tenantID, imageID string) error {
+func (c *controller) DeleteImage(ctx context.Context, tenantID, imageID string) error {
 	glog.Infof("Deleting image: %v", imageID)
 
 	image, err := c.ds.GetImage(imageID)
@@ -202,9 +263,13 @@ func (c *controller) DeleteImage(tenantID, imageID string) error {
 }
 
 // GetImage gets image metadata after checking permissions
-func (c *controller) GetImage(tenantID, imageID string) (types.Image, error) {
+func (c *controller) GetImage(ctx context.Context, tenantID, imageID string) (types.Image, error) {
 	glog.Infof("Getting Image [%v] from [%v]", imageID, tenantID)
 
+	if c.imagesDisabled {
+		return types.Image{}, api.ErrImageServiceDisabled
+	}
+
 	id, err := c.ds.ResolveImage(tenantID, imageID)
 	if err != nil {
 		return types.Image{}, err
@@ -222,3 +287,49 @@ func (c *controller) GetImage(tenantID, imageID string) (types.Image, error) {
 	glog.Infof("Image %v found", imageID)
 	return image, nil
 }
+
+// imageDownloadHandler sends the raw data of an active image, as it was
+// uploaded, so that its content can be verified or moved to another
+// cluster.
+type imageDownloadHandler struct {
+	*controller
+	Privileged bool
+}
+
+func (h imageDownloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Privileged && !service.GetPrivilege(r.Context()) {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	tenantID, ok := vars["tenant"]
+	if !ok {
+		tenantID = "admin"
+	}
+
+	image, err := h.GetImage(r.Context(), tenantID, vars["image_id"])
+	if err == nil && image.State != types.Active {
+		err = types.ErrImageNotActive
+	}
+	if err != nil {
+		writeErrorResponse(w, r, errorResponse(err).status, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+
+	if err := h.ReadBlockDevice(image.ID, w); err != nil {
+		glog.Warningf("Error sending image %s: %v", image.ID, err)
+	}
+}
+
+func imageRoutes(ctl *controller, r *mux.Router) *mux.Router {
+	r.Handle("/{tenant}/images/{image_id:"+uuid.UUIDRegex+"}/file",
+		imageDownloadHandler{ctl, false}).Methods("GET")
+	r.Handle("/images/{image_id:"+uuid.UUIDRegex+"}/file",
+		imageDownloadHandler{ctl, true}).Methods("GET")
+
+	return r
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/internal/datastore"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+var imageGCInterval = flag.Duration("image_gc_interval", time.Hour, "Interval between collections of orphaned image data, 0 disables the collection")
+var imageGCGrace = flag.Duration("image_gc_grace", 24*time.Hour, "Time orphaned image data is quarantined before it is removed")
+
+// imageGC holds the state of the image data garbage collector.
+type imageGC struct {
+	sync.Mutex
+
+	// uploads contains the images whose data is being uploaded.
+	uploads map[string]struct{}
+
+	// quarantined maps the orphaned block devices to the time they
+	// were first found.
+	quarantined map[string]time.Time
+
+	report types.ImageGCReport
+}
+
+func (c *controller) startImageUpload(imageID string) {
+	c.gc.Lock()
+	defer c.gc.Unlock()
+
+	if c.gc.uploads == nil {
+		c.gc.uploads = make(map[string]struct{})
+	}
+	c.gc.uploads[imageID] = struct{}{}
+}
+
+func (c *controller) endImageUpload(imageID string) {
+	c.gc.Lock()
+	defer c.gc.Unlock()
+
+	delete(c.gc.uploads, imageID)
+}
+
+func (c *controller) imageCollector(stop <-chan struct{}) {
+	ctx := context.Background()
+
+	if *imageGCInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(*imageGCInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			_, err := c.collectImageGarbage(ctx, time.Now(), *imageGCGrace)
+			if err != nil {
+				glog.Warningf("Error collecting image data: %v", err)
+			}
+		}
+	}
+}
+
+// imageDataOrphaned returns true if the block device ID is not referenced
+// by any volume, nor by an image whose data is valid or being uploaded.
+// Images left in the saving state by a controller restart are marked as
+// killed, as their upload can no longer complete.
+// Must be called with c.gc locked.
+func (c *controller) imageDataOrphaned(ctx context.Context, ID string) bool {
+	// devices which were not created by ciao are left alone
+	if _, err := uuid.Parse(ID); err != nil {
+		return false
+	}
+
+	if _, err := c.ds.GetBlockDevice(ID); err != datastore.ErrNoBlockData {
+		return false
+	}
+
+	image, err := c.ds.GetImage(ID)
+	if err == api.ErrNoImage {
+		return true
+	} else if err != nil {
+		return false
+	}
+
+	switch image.State {
+	case types.Killed:
+		return true
+	case types.Saving:
+		if _, ok := c.gc.uploads[ID]; ok {
+			return false
+		}
+
+		glog.Warningf("Upload of image %s interrupted", ID)
+		image.State = types.Killed
+		if err := c.ds.UpdateImage(ctx, image); err != nil {
+			glog.Warningf("Error marking image %s as killed: %v", ID, err)
+			return false
+		}
+		return true
+	}
+
+	return false
+}
+
+func (c *controller) removeImageData(ID string) error {
+	// Uploaded images are protected by a snapshot which has to be
+	// removed first. Failed uploads may not have one.
+	_ = c.DeleteBlockDeviceSnapshot(ID, "ciao-image")
+
+	return c.DeleteBlockDevice(ID)
+}
+
+// collectImageGarbage compares the block devices in the storage cluster with
+// the image and volume records. Orphaned devices are quarantined when they
+// are first found and removed once they have been orphaned for longer than
+// grace. The quarantine protects the devices of images and volumes which
+// are being created, as their records are stored after their devices.
+func (c *controller) collectImageGarbage(ctx context.Context, now time.Time, grace time.Duration) (types.ImageGCReport, error) {
+	devices, err := c.ListBlockDevices()
+	if err != nil {
+		return types.ImageGCReport{}, errors.Wrap(err, "error listing block devices")
+	}
+	sort.Strings(devices)
+
+	c.gc.Lock()
+	defer c.gc.Unlock()
+
+	report := types.ImageGCReport{
+		LastRun: now,
+		Devices: []types.ImageGCEntry{},
+	}
+	quarantined := make(map[string]time.Time)
+
+	for _, ID := range devices {
+		if !c.imageDataOrphaned(ctx, ID) {
+			continue
+		}
+
+		firstSeen, ok := c.gc.quarantined[ID]
+		if !ok {
+			firstSeen = now
+			glog.Infof("Quarantining orphaned image data %s", ID)
+		}
+
+		entry := types.ImageGCEntry{
+			ID:        ID,
+			State:     types.ImageGCQuarantined,
+			FirstSeen: firstSeen,
+		}
+
+		if now.Sub(firstSeen) < grace {
+			quarantined[ID] = firstSeen
+		} else if err := c.removeImageData(ID); err != nil {
+			glog.Warningf("Error removing orphaned image data %s: %v", ID, err)
+			entry.Error = err.Error()
+			quarantined[ID] = firstSeen
+		} else {
+			glog.Infof("Orphaned image data %s removed", ID)
+			entry.State = types.ImageGCRemoved
+		}
+
+		report.Devices = append(report.Devices, entry)
+	}
+
+	c.gc.quarantined = quarantined
+	c.gc.report = report
+
+	return report, nil
+}
+
+// ShowImageGC returns the report of the last image garbage collection.
+func (c *controller) ShowImageGC(ctx context.Context) (types.ImageGCReport, error) {
+	c.gc.Lock()
+	defer c.gc.Unlock()
+
+	report := c.gc.report
+	if report.Devices == nil {
+		report.Devices = []types.ImageGCEntry{}
+	}
+
+	return report, nil
+}
+
+// CollectImageGarbage runs an image garbage collection immediately.
+func (c *controller) CollectImageGarbage(ctx context.Context) (types.ImageGCReport, error) {
+	return c.collectImageGarbage(ctx, time.Now(), *imageGCGrace)
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// imageImportClient is the HTTP client used to fetch imported images.
+// Images can be large so only the response headers are time limited.
+var imageImportClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+}
+
+var imageChecksums = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// parseImageChecksum parses a checksum of the form ALGORITHM:HEX, e.g.
+// sha256:e3b0c442..., and returns a hash to compute it along with the
+// expected sum. A nil hash is returned for an empty checksum.
+func parseImageChecksum(checksum string) (hash.Hash, []byte, error) {
+	if checksum == "" {
+		return nil, nil, nil
+	}
+
+	parts := strings.SplitN(checksum, ":", 2)
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("Checksum must be of the form ALGORITHM:HEX")
+	}
+
+	newHash, ok := imageChecksums[strings.ToLower(parts[0])]
+	if !ok {
+		return nil, nil, fmt.Errorf("Unsupported checksum algorithm %s", parts[0])
+	}
+
+	sum, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Invalid checksum")
+	}
+
+	h := newHash()
+	if len(sum) != h.Size() {
+		return nil, nil, fmt.Errorf("Invalid %s checksum length", parts[0])
+	}
+
+	return h, sum, nil
+}
+
+// validateImageSource checks that an image can be imported from source
+// and verified with checksum.
+func validateImageSource(source string, checksum string) error {
+	u, err := url.Parse(source)
+	if err != nil {
+		return errors.Wrap(err, "Invalid image URL")
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Image URL must be an absolute HTTP(S) URL")
+	}
+
+	_, _, err = parseImageChecksum(checksum)
+	return err
+}
+
+// imageSourceReader reads the body of an imported image, reporting the
+// import progress and verifying the image checksum once all of it has
+// been read.
+type imageSourceReader struct {
+	body     io.ReadCloser
+	size     int64
+	read     int64
+	hash     hash.Hash
+	checksum []byte
+	progress func(int)
+	reported int
+}
+
+func (r *imageSourceReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if n > 0 {
+		r.read += int64(n)
+		if r.hash != nil {
+			_, _ = r.hash.Write(p[:n])
+		}
+
+		// The import is only complete once the image is stored.
+		if r.size > 0 {
+			progress := int(r.read * 100 / r.size)
+			if progress > 99 {
+				progress = 99
+			}
+			if progress != r.reported {
+				r.reported = progress
+				r.progress(progress)
+			}
+		}
+	}
+
+	if err == io.EOF && r.hash != nil {
+		if sum := r.hash.Sum(nil); !bytes.Equal(sum, r.checksum) {
+			return n, fmt.Errorf("Image checksum mismatch: expected %x, got %x", r.checksum, sum)
+		}
+	}
+
+	return n, err
+}
+
+func (r *imageSourceReader) Close() error {
+	return r.body.Close()
+}
+
+// openImageSource starts fetching an image from source.
+func openImageSource(source string, checksum string, progress func(int)) (io.ReadCloser, error) {
+	h, sum, err := parseImageChecksum(checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := imageImportClient.Get(source)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error fetching image")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("Unexpected HTTP response fetching image: %s", resp.Status)
+	}
+
+	return &imageSourceReader{
+		body:     resp.Body,
+		size:     resp.ContentLength,
+		hash:     h,
+		checksum: sum,
+		progress: progress,
+	}, nil
+}
+
+// startImageImport starts importing the data of a newly created image from
+// source in the background. The progress of the import is tracked by an
+// image import operation.
+func (c *controller) startImageImport(ctx context.Context, image types.Image, source string, checksum string) (types.Image, error) {
+	c.startImageUpload(image.ID)
+
+	image.State = types.Saving
+	err := c.ds.UpdateImage(ctx, image)
+	if err != nil {
+		c.endImageUpload(image.ID)
+		return types.Image{}, err
+	}
+
+	op := c.newOperation(image.TenantID, types.ImageImport, image.ID)
+
+	go c.importImage(context.Background(), image, source, checksum, op)
+
+	glog.Infof("Image %v importing from %s", image.ID, source)
+	image.OperationID = op.ID
+	return image, nil
+}
+
+func (c *controller) importImage(ctx context.Context, image types.Image, source string, checksum string, op types.Operation) {
+	defer c.endImageUpload(image.ID)
+
+	body, err := openImageSource(source, checksum, func(progress int) {
+		c.updateOperation(&op, progress)
+	})
+	if err != nil {
+		glog.Errorf("Error importing image %s: %v", image.ID, err)
+		image.State = types.Killed
+		_ = c.ds.UpdateImage(ctx, image)
+		c.completeOperation(&op, err)
+		return
+	}
+	defer func() { _ = body.Close() }()
+
+	err = c.storeImage(ctx, image, &op, body)
+	if err != nil {
+		glog.Errorf("Error importing image %s: %v", image.ID, err)
+		return
+	}
+
+	glog.Infof("Image %v imported", image.ID)
+}
@@ -17,6 +17,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -49,16 +50,38 @@ type instance struct {
 }
 
 type userData struct {
-	UUID     string `json:"uuid"`
-	Hostname string `json:"hostname"`
+	UUID        string `json:"uuid"`
+	Hostname    string `json:"hostname"`
+	Preemptible bool   `json:"preemptible,omitempty"`
+
+	// Secrets maps the names of the secrets referenced by the workload
+	// to their values.
+	Secrets map[string]string `json:"secrets,omitempty"`
 }
 
 func isCNCIWorkload(workload *types.Workload) bool {
 	return workload.Requirements.NetworkNode
 }
 
-func newInstance(ctl *controller, tenantID string, workload *types.Workload,
-	name string, subnet string, IPAddr net.IP) (*instance, error) {
+// instanceResources returns the quota resources consumed by an instance of
+// workload. Preemptible instances can be reclaimed at any time and are only
+// charged half of the workload memory and VCPUs.
+func instanceResources(workload *types.Workload, preemptible bool) []payloads.RequestedResource {
+	memMB := workload.Requirements.MemMB
+	vcpus := workload.Requirements.VCPUs
+	if preemptible {
+		memMB = (memMB + 1) / 2
+		vcpus = (vcpus + 1) / 2
+	}
+
+	return []payloads.RequestedResource{
+		{Type: payloads.Instance, Value: 1},
+		{Type: payloads.MemMB, Value: memMB},
+		{Type: payloads.VCPUs, Value: vcpus}}
+}
+
+func newInstance(ctx context.Context, ctl *controller, tenantID string, workload *types.Workload,
+	name string, subnet string, IPAddr net.IP, preemptible bool) (*instance, error) {
 	id := uuid.Generate()
 
 	if name != "" {
@@ -72,11 +95,13 @@ func newInstance(ctl *controller, tenantID string, workload *types.Workload,
 		}
 	}
 
-	config, err := newConfig(ctl, workload, id.String(), tenantID, name, IPAddr)
+	config, err := newConfig(ctx, ctl, workload, id.String(), tenantID, name, IPAddr, preemptible)
 	if err != nil {
 		return nil, err
 	}
 
+	now := time.Now()
+
 	newInstance := types.Instance{
 		TenantID:    tenantID,
 		WorkloadID:  workload.ID,
@@ -87,8 +112,10 @@ func newInstance(ctl *controller, tenantID string, workload *types.Workload,
 		VnicUUID:    config.sc.Start.Networking.VnicUUID,
 		Subnet:      config.sc.Start.Networking.Subnet,
 		MACAddress:  config.mac,
-		CreateTime:  time.Now(),
+		CreateTime:  now,
+		PendingTime: now,
 		Name:        name,
+		Preemptible: preemptible,
 		StateChange: sync.NewCond(&sync.Mutex{}),
 	}
 
@@ -105,10 +132,10 @@ func newInstance(ctl *controller, tenantID string, workload *types.Workload,
 	return i, nil
 }
 
-func (i *instance) Add() error {
+func (i *instance) Add(ctx context.Context) error {
 	ds := i.ctl.ds
 	var err error
-	err = ds.AddInstance(i.Instance)
+	err = ds.AddInstance(ctx, i.Instance)
 	if err != nil {
 		return errors.Wrapf(err, "Error creating instance in datastore")
 	}
@@ -123,7 +150,7 @@ func (i *instance) Add() error {
 			return fmt.Errorf("Invalid block device mapping.  %s already in use", volume.ID)
 		}
 
-		_, err = ds.CreateStorageAttachment(i.Instance.ID, volume)
+		_, err = ds.CreateStorageAttachment(ctx, i.Instance.ID, volume)
 		if err != nil {
 			return errors.Wrap(err, "Error creating storage attachment")
 		}
@@ -132,13 +159,13 @@ func (i *instance) Add() error {
 	return nil
 }
 
-func (i *instance) Clean() error {
+func (i *instance) Clean(ctx context.Context) error {
 	if i.CNCI {
 		// CNCI resources are not tracked by quota system
 		return nil
 	}
 
-	err := i.ctl.ds.ReleaseTenantIP(i.TenantID, i.IPAddress)
+	err := i.ctl.ds.ReleaseTenantIP(ctx, i.TenantID, i.IPAddress)
 	if err != nil {
 		return errors.Wrap(err, "error releasing tenant IP")
 	}
@@ -148,13 +175,10 @@ func (i *instance) Clean() error {
 		return errors.Wrap(err, "error getting workload from datastore")
 	}
 
-	resources := []payloads.RequestedResource{
-		{Type: payloads.Instance, Value: 1},
-		{Type: payloads.MemMB, Value: wl.Requirements.MemMB},
-		{Type: payloads.VCPUs, Value: wl.Requirements.VCPUs}}
+	resources := instanceResources(&wl, i.Preemptible)
 	i.ctl.qs.Release(i.TenantID, resources...)
 
-	err = i.ctl.deleteEphemeralStorage(i.ID)
+	err = i.ctl.deleteEphemeralStorage(ctx, i.ID)
 	if err != nil {
 		return errors.Wrap(err, "error deleting ephemeral strorage")
 	}
@@ -175,10 +199,7 @@ func (i *instance) Allowed() (bool, error) {
 		return true, errors.Wrap(err, "error getting workload from datastore")
 	}
 
-	resources := []payloads.RequestedResource{
-		{Type: payloads.Instance, Value: 1},
-		{Type: payloads.MemMB, Value: wl.Requirements.MemMB},
-		{Type: payloads.VCPUs, Value: wl.Requirements.VCPUs}}
+	resources := instanceResources(&wl, i.Preemptible)
 	res := <-i.ctl.qs.Consume(i.TenantID, resources...)
 
 	// Cleanup on disallowed happens in Clean()
@@ -196,10 +217,15 @@ func instanceActive(i *types.Instance) bool {
 	return false
 }
 
-func getStorage(c *controller, s types.StorageResource, tenant string, instanceID string) (payloads.StorageResource, error) {
+func getStorage(ctx context.Context, c *controller, s types.StorageResource, tenant string, instanceID string) (payloads.StorageResource, error) {
 	// storage already exists, use preexisting definition.
 	if s.ID != "" {
-		return payloads.StorageResource{ID: s.ID, Bootable: s.Bootable}, nil
+		key, err := c.volumeKey(ctx, s.ID)
+		if err != nil {
+			return payloads.StorageResource{}, err
+		}
+
+		return payloads.StorageResource{ID: s.ID, Bootable: s.Bootable, Ephemeral: s.Ephemeral, Pool: c.volumePool(s.ID), Key: key, CDROM: s.CDROM}, nil
 	}
 
 	var err error
@@ -207,6 +233,7 @@ func getStorage(c *controller, s types.StorageResource, tenant string, instanceI
 		Description: fmt.Sprintf("Volume for instance: %s", instanceID),
 		Internal:    s.Internal,
 		Size:        s.Size,
+		Class:       s.Class,
 	}
 
 	switch s.SourceType {
@@ -220,11 +247,17 @@ func getStorage(c *controller, s types.StorageResource, tenant string, instanceI
 		return payloads.StorageResource{}, errors.New("Unsupported workload storage variant in getStorage()")
 	}
 
-	volume, err := c.CreateVolume(tenant, req)
+	volume, err := c.createVolume(ctx, tenant, req)
 	if err != nil {
 		return payloads.StorageResource{}, errors.Wrap(err, "Error creating volume")
 	}
-	return payloads.StorageResource{ID: volume.ID, Bootable: s.Bootable, Ephemeral: s.Ephemeral}, nil
+
+	key, err := c.volumeKey(ctx, volume.ID)
+	if err != nil {
+		return payloads.StorageResource{}, err
+	}
+
+	return payloads.StorageResource{ID: volume.ID, Bootable: s.Bootable, Ephemeral: s.Ephemeral, Pool: c.volumePool(volume.ID), Key: key, CDROM: s.CDROM}, nil
 }
 
 func networkConfig(ctl *controller, tenant *types.Tenant, networking *payloads.NetworkResources, cnci bool, ipAddress net.IP) error {
@@ -264,8 +297,8 @@ func networkConfig(ctl *controller, tenant *types.Tenant, networking *payloads.N
 	return nil
 }
 
-func newConfig(ctl *controller, wl *types.Workload, instanceID string, tenantID string,
-	name string, IPaddr net.IP) (config, error) {
+func newConfig(ctx context.Context, ctl *controller, wl *types.Workload, instanceID string, tenantID string,
+	name string, IPaddr net.IP, preemptible bool) (config, error) {
 	var metaData userData
 	var config config
 	var networking payloads.NetworkResources
@@ -276,8 +309,16 @@ func newConfig(ctl *controller, wl *types.Workload, instanceID string, tenantID
 	fwType := wl.FWType
 	config.cnci = isCNCIWorkload(wl)
 	metaData.UUID = instanceID
+	metaData.Preemptible = preemptible
 
-	tenant, err := ctl.ds.GetTenant(tenantID)
+	// resolve the secrets before any network resource is allocated.
+	secrets, err := ctl.instanceSecrets(ctx, tenantID, wl)
+	if err != nil {
+		return config, err
+	}
+	metaData.Secrets = secrets
+
+	tenant, err := ctl.ds.GetTenant(ctx, tenantID)
 	if err != nil {
 		fmt.Println("unable to get tenant")
 	}
@@ -296,7 +337,7 @@ func newConfig(ctl *controller, wl *types.Workload, instanceID string, tenantID
 
 	// handle storage resources in workload definition
 	for i := range wl.Storage {
-		workloadStorage, err := getStorage(ctl, wl.Storage[i], tenantID, instanceID)
+		workloadStorage, err := getStorage(ctx, ctl, wl.Storage[i], tenantID, instanceID)
 		if err != nil {
 			return config, err
 		}
@@ -317,6 +358,12 @@ func newConfig(ctl *controller, wl *types.Workload, instanceID string, tenantID
 		Requirements:        wl.Requirements,
 	}
 
+	// preemptible instances are the first ones to be reclaimed by
+	// the scheduler when the cluster is full.
+	if preemptible {
+		startCmd.Requirements.Priority = payloads.LowPriority
+	}
+
 	if wl.VMType == payloads.Docker {
 		startCmd.DockerImage = wl.ImageName
 	}
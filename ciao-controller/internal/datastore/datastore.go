@@ -20,6 +20,8 @@
 package datastore
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
@@ -43,6 +45,8 @@ var (
 	ErrNoTenant            = errors.New("Tenant not found")
 	ErrNoBlockData         = errors.New("Block Device not found")
 	ErrNoStorageAttachment = errors.New("No Volume Attached")
+	ErrDuplicateTenant     = errors.New("Duplicate Tenant ID")
+	ErrTenantNotReady      = errors.New("Timeout waiting for tenant to be ready")
 )
 
 // Config contains configuration information for the datastore.
@@ -50,6 +54,10 @@ type Config struct {
 	DBBackend         persistentStore
 	PersistentURI     string
 	InitWorkloadsPath string
+
+	// QueryTimeout bounds the time spent waiting for and running a
+	// single database operation.  0 means no timeout.
+	QueryTimeout time.Duration
 }
 
 type userEventType string
@@ -61,11 +69,33 @@ const (
 
 type tenant struct {
 	types.Tenant
-	network   map[uint32]map[uint32]bool
-	instances map[string]*types.Instance
-	devices   map[string]types.Volume
-	workloads []string
-	images    []string
+	network     map[uint32]map[uint32]bool
+	reservedIPs map[uint32]bool
+	instances   map[string]*types.Instance
+	devices     map[string]types.Volume
+	workloads   []string
+	images      []string
+	readiness   tenantReadiness
+	preparation *tenantPreparation
+}
+
+// tenantReadiness is the state of the preparation of a tenant to run
+// instances.  A tenant moves from unprepared to preparing when a caller
+// of PrepareTenant starts preparing it, and then to ready, or back to
+// unprepared if the preparation fails.
+type tenantReadiness int
+
+const (
+	tenantUnprepared tenantReadiness = iota
+	tenantPreparing
+	tenantReady
+)
+
+// tenantPreparation is an attempt at preparing a tenant.  done is closed
+// once the attempt completes, err then holding its outcome.
+type tenantPreparation struct {
+	done chan struct{}
+	err  error
 }
 
 type node struct {
@@ -86,69 +116,115 @@ type tenantIP struct {
 type persistentStore interface {
 	init(config Config) error
 	disconnect()
+	ping(ctx context.Context) error
 
 	// interfaces related to logging
-	logEvent(event types.LogEntry) error
-	clearLog() error
-	getEventLog() (logEntries []*types.LogEntry, err error)
+	logEvent(ctx context.Context, event types.LogEntry) error
+	clearLog(ctx context.Context) error
+	getEventLog(ctx context.Context) (logEntries []*types.LogEntry, err error)
 
 	// interfaces related to workloads
-	addWorkload(wl types.Workload) error
-	deleteWorkload(ID string) error
-	getWorkloads() ([]types.Workload, error)
+	addWorkload(ctx context.Context, wl types.Workload) error
+	deleteWorkload(ctx context.Context, ID string) error
+	updateWorkloadCategory(ctx context.Context, ID string, category string) error
+	getWorkloads(ctx context.Context) ([]types.Workload, error)
 
 	// interfaces related to tenants
-	addTenant(id string, config types.TenantConfig) (err error)
-	getTenant(id string) (t *tenant, err error)
-	getTenants() ([]*tenant, error)
-	releaseTenantIP(tenantID string, subnetInt uint32, rest uint32) (err error)
-	claimTenantIP(tenantID string, subnetInt uint32, rest uint32) (err error)
-	claimTenantIPs(tenantID string, IPs []tenantIP) (err error)
-	updateTenant(tenant *types.Tenant) error
-	deleteTenant(tenantID string) error
+	addTenant(ctx context.Context, id string, config types.TenantConfig) (err error)
+	getTenant(ctx context.Context, id string) (t *tenant, err error)
+	getTenants(ctx context.Context) ([]*tenant, error)
+	releaseTenantIP(ctx context.Context, tenantID string, subnetInt uint32, rest uint32) (err error)
+	claimTenantIP(ctx context.Context, tenantID string, subnetInt uint32, rest uint32) (err error)
+	claimTenantIPs(ctx context.Context, tenantID string, IPs []tenantIP) (err error)
+	addTenantIPReservation(ctx context.Context, tenantID string, host uint32) (err error)
+	deleteTenantIPReservation(ctx context.Context, tenantID string, host uint32) (err error)
+	updateTenant(ctx context.Context, tenant *types.Tenant) error
+	renumberTenant(ctx context.Context, tenant *types.Tenant) error
+	deleteTenant(ctx context.Context, tenantID string) error
 
 	// interfaces related to instances
-	getInstances() (instances []*types.Instance, err error)
-	addInstance(instance *types.Instance) (err error)
-	deleteInstance(instanceID string) (err error)
-	updateInstance(instance *types.Instance) (err error)
+	getInstances(ctx context.Context) (instances []*types.Instance, err error)
+	addInstance(ctx context.Context, instance *types.Instance) (err error)
+	deleteInstance(ctx context.Context, instanceID string) (err error)
+	updateInstance(ctx context.Context, instance *types.Instance) (err error)
+	updateInstanceDeleteTime(ctx context.Context, instanceID string, deleteTime time.Time) (err error)
+	updateInstanceRescue(ctx context.Context, instanceID string, volumeID string, rescued bool) (err error)
+	updateInstancePeer(ctx context.Context, instanceID string, peerID string, remoteID string) (err error)
+	updateInstanceFailure(ctx context.Context, instanceID string, failure *types.Failure) (err error)
 
 	// interfaces related to statistics
-	addNodeStat(stat payloads.Stat) (err error)
-	addInstanceStats(stats []payloads.InstanceStat, nodeID string) (err error)
-	addFrameStat(stat payloads.FrameTrace) (err error)
-	getBatchFrameSummary() (stats []types.BatchFrameSummary, err error)
-	getBatchFrameStatistics(label string) (stats []types.BatchFrameStat, err error)
+	addNodeStat(ctx context.Context, stat payloads.Stat) (err error)
+	addInstanceStats(ctx context.Context, stats []payloads.InstanceStat, nodeID string) (err error)
+	addFrameStat(ctx context.Context, stat payloads.FrameTrace) (err error)
+	getBatchFrameSummary(ctx context.Context) (stats []types.BatchFrameSummary, err error)
+	getBatchFrameStatistics(ctx context.Context, label string) (stats []types.BatchFrameStat, err error)
+	deleteBatchFrameStatistics(ctx context.Context, label string) (err error)
 
 	// storage interfaces
-	getWorkloadStorage(ID string) ([]types.StorageResource, error)
-	getAllBlockData() (map[string]types.Volume, error)
-	addBlockData(data types.Volume) error
-	updateBlockData(data types.Volume) error
-	deleteBlockData(string) error
-	getTenantDevices(tenantID string) (map[string]types.Volume, error)
-	addStorageAttachment(a types.StorageAttachment) error
-	getAllStorageAttachments() (map[string]types.StorageAttachment, error)
-	deleteStorageAttachment(ID string) error
+	getWorkloadStorage(ctx context.Context, ID string) ([]types.StorageResource, error)
+	getAllBlockData(ctx context.Context) (map[string]types.Volume, error)
+	addBlockData(ctx context.Context, data types.Volume) error
+	updateBlockData(ctx context.Context, data types.Volume) error
+	deleteBlockData(ctx context.Context, ID string) error
+	getTenantDevices(ctx context.Context, tenantID string) (map[string]types.Volume, error)
+	addStorageAttachment(ctx context.Context, a types.StorageAttachment) error
+	getAllStorageAttachments(ctx context.Context) (map[string]types.StorageAttachment, error)
+	deleteStorageAttachment(ctx context.Context, ID string) error
 
 	// external IP interfaces
-	addPool(pool types.Pool) error
-	updatePool(pool types.Pool) error
-	getAllPools() map[string]types.Pool
-	deletePool(ID string) error
+	addPool(ctx context.Context, pool types.Pool) error
+	updatePool(ctx context.Context, pool types.Pool) error
+	getAllPools(ctx context.Context) map[string]types.Pool
+	deletePool(ctx context.Context, ID string) error
 
-	addMappedIP(m types.MappedIP) error
-	deleteMappedIP(ID string) error
-	getMappedIPs() map[string]types.MappedIP
+	addMappedIP(ctx context.Context, m types.MappedIP) error
+	deleteMappedIP(ctx context.Context, ID string) error
+	getMappedIPs(ctx context.Context) map[string]types.MappedIP
 
 	// quotas
-	updateQuotas(tenantID string, qds []types.QuotaDetails) error
-	getQuotas(tenantID string) ([]types.QuotaDetails, error)
+	updateQuotas(ctx context.Context, tenantID string, qds []types.QuotaDetails) error
+	getQuotas(ctx context.Context, tenantID string) ([]types.QuotaDetails, error)
 
 	// images
-	updateImage(i types.Image) error
-	deleteImage(ID string) error
-	getImages() ([]types.Image, error)
+	updateImage(ctx context.Context, i types.Image) error
+	deleteImage(ctx context.Context, ID string) error
+	getImages(ctx context.Context) ([]types.Image, error)
+
+	// failed commands
+	addFailedCommand(ctx context.Context, cmd types.FailedCommand) error
+	getFailedCommands(ctx context.Context) ([]types.FailedCommand, error)
+	deleteFailedCommand(ctx context.Context, ID string) error
+
+	// node policies
+	updateNodePolicy(ctx context.Context, policy types.NodePolicy) error
+	deleteNodePolicy(ctx context.Context, nodeID string) error
+	getNodePolicies(ctx context.Context) ([]types.NodePolicy, error)
+
+	// secrets
+	updateSecret(ctx context.Context, secret types.Secret) error
+	deleteSecret(ctx context.Context, tenantID string, name string) error
+	getSecrets(ctx context.Context, tenantID string) ([]types.Secret, error)
+
+	// API tokens
+	addAPIToken(ctx context.Context, token types.APIToken) error
+	deleteAPIToken(ctx context.Context, ID string) error
+	getAPIToken(ctx context.Context, ID string) (types.APIToken, error)
+	getAPITokens(ctx context.Context, tenantID string) ([]types.APIToken, error)
+
+	// federation peers
+	addFederationPeer(ctx context.Context, peer types.FederationPeer) error
+	deleteFederationPeer(ctx context.Context, ID string) error
+	getFederationPeers(ctx context.Context) ([]types.FederationPeer, error)
+
+	// snapshot sets
+	addSnapshotSet(ctx context.Context, set types.SnapshotSet) error
+	updateSnapshotSet(ctx context.Context, set types.SnapshotSet) error
+	deleteSnapshotSet(ctx context.Context, ID string) error
+	getSnapshotSets(ctx context.Context, tenantID string) ([]types.SnapshotSet, error)
+
+	// signed requests
+	addSignedRequest(ctx context.Context, rec types.SignedRequestRecord) error
+	getSignedRequests(ctx context.Context) ([]types.SignedRequestRecord, error)
 }
 
 // Datastore provides context for the datastore package.
@@ -164,7 +240,8 @@ type Datastore struct {
 	tenants     map[string]*tenant
 	tenantsLock *sync.RWMutex
 
-	cnciWorkload types.Workload
+	cnciWorkload     types.Workload
+	cnciWorkloadLock *sync.RWMutex
 
 	nodes     map[string]*node
 	nodesLock *sync.RWMutex
@@ -198,14 +275,32 @@ type Datastore struct {
 	workloadsLock   *sync.RWMutex
 	workloads       map[string]types.Workload
 	publicWorkloads []string
+
+	// operations are not persisted, they only track
+	// asynchronous actions started by this controller.  They are
+	// indexed by the resource they act on, and dropped once they
+	// have not been updated for operationRetention.
+	operations         map[string]types.Operation
+	resourceOperations map[string][]string
+	operationsLock     *sync.RWMutex
+
+	// instance actions are not persisted either, they record the
+	// state transitions seen by this controller.
+	instanceActions      map[string][]types.InstanceAction
+	instanceActionCauses map[string]types.InstanceAction
+	instanceActionsLock  *sync.RWMutex
+
+	// event watchers receive the events logged by this controller.
+	eventWatchers     map[chan types.LogEntry]struct{}
+	eventWatchersLock *sync.Mutex
 }
 
-func (ds *Datastore) initExternalIPs() {
+func (ds *Datastore) initExternalIPs(ctx context.Context) {
 	ds.poolsLock = &sync.RWMutex{}
 	ds.externalSubnets = make(map[string]bool)
 	ds.externalIPs = make(map[string]bool)
 
-	ds.pools = ds.db.getAllPools()
+	ds.pools = ds.db.getAllPools(ctx)
 
 	for _, pool := range ds.pools {
 		for _, subnet := range pool.Subnets {
@@ -217,13 +312,13 @@ func (ds *Datastore) initExternalIPs() {
 		}
 	}
 
-	ds.mappedIPs = ds.db.getMappedIPs()
+	ds.mappedIPs = ds.db.getMappedIPs(ctx)
 }
 
-func (ds *Datastore) initImages() error {
+func (ds *Datastore) initImages(ctx context.Context) error {
 	ds.imageLock = &sync.RWMutex{}
 	ds.images = make(map[string]types.Image)
-	images, err := ds.db.getImages()
+	images, err := ds.db.getImages(ctx)
 	if err != nil {
 		return errors.Wrap(err, "error getting images from database")
 	}
@@ -250,10 +345,10 @@ func (ds *Datastore) initImages() error {
 	return nil
 }
 
-func (ds *Datastore) initWorkloads() error {
+func (ds *Datastore) initWorkloads(ctx context.Context) error {
 	ds.workloadsLock = &sync.RWMutex{}
 	ds.workloads = make(map[string]types.Workload)
-	workloads, err := ds.db.getWorkloads()
+	workloads, err := ds.db.getWorkloads(ctx)
 	if err != nil {
 		return errors.Wrap(err, "error getting workloads from database")
 	}
@@ -296,6 +391,26 @@ func (ds *Datastore) Init(config Config) error {
 
 	ds.db = ps
 
+	ctx := context.Background()
+
+	err = ds.failInterruptedClones(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error failing interrupted volume clones")
+	}
+
+	err = ds.rollBackInterruptedArchives(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error rolling back interrupted volume archives")
+	}
+
+	err = ds.failInterruptedSnapshotSets(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error failing interrupted snapshot sets")
+	}
+
+	ds.eventWatchers = make(map[chan types.LogEntry]struct{})
+	ds.eventWatchersLock = &sync.Mutex{}
+
 	ds.nodeLastStat = make(map[string]types.CiaoNode)
 	ds.nodeLastStatLock = &sync.RWMutex{}
 
@@ -308,22 +423,28 @@ func (ds *Datastore) Init(config Config) error {
 	ds.tenants = make(map[string]*tenant)
 	ds.tenantsLock = &sync.RWMutex{}
 
+	ds.cnciWorkloadLock = &sync.RWMutex{}
+
 	// cache all our instances prior to getting tenants
 	ds.instancesLock = &sync.RWMutex{}
 	ds.instances = make(map[string]*types.Instance)
 
-	instances, err := ds.db.getInstances()
+	instances, err := ds.db.getInstances(ctx)
 	if err != nil {
 		return errors.Wrap(err, "error getting instances from database")
 	}
 
+	now := time.Now()
 	for i := range instances {
+		if instances[i].State == payloads.Pending {
+			instances[i].PendingTime = now
+		}
 		ds.instances[instances[i].ID] = instances[i]
 	}
 
 	// cache our current tenants into a map that we can
 	// quickly index
-	tenants, err := ds.db.getTenants()
+	tenants, err := ds.db.getTenants(ctx)
 	if err != nil {
 		return errors.Wrap(err, "error getting tenants from database")
 	}
@@ -331,12 +452,12 @@ func (ds *Datastore) Init(config Config) error {
 		ds.tenants[tenants[i].ID] = tenants[i]
 	}
 
-	err = ds.initImages()
+	err = ds.initImages(ctx)
 	if err != nil {
 		return errors.Wrap(err, "error initialising images")
 	}
 
-	err = ds.initWorkloads()
+	err = ds.initWorkloads(ctx)
 	if err != nil {
 		return errors.Wrap(err, "error initialising workloads")
 	}
@@ -371,14 +492,14 @@ func (ds *Datastore) Init(config Config) error {
 	ds.tenantUsage = make(map[string][]types.CiaoUsage)
 	ds.tenantUsageLock = &sync.RWMutex{}
 
-	ds.blockDevices, err = ds.db.getAllBlockData()
+	ds.blockDevices, err = ds.db.getAllBlockData(ctx)
 	if err != nil {
 		return errors.Wrap(err, "error getting block devices from database")
 	}
 
 	ds.bdLock = &sync.RWMutex{}
 
-	ds.attachments, err = ds.db.getAllStorageAttachments()
+	ds.attachments, err = ds.db.getAllStorageAttachments(ctx)
 	if err != nil {
 		return errors.Wrap(err, "error getting storage attachments from database")
 	}
@@ -396,7 +517,106 @@ func (ds *Datastore) Init(config Config) error {
 
 	ds.attachLock = &sync.RWMutex{}
 
-	ds.initExternalIPs()
+	ds.initExternalIPs(ctx)
+
+	ds.operations = make(map[string]types.Operation)
+	ds.resourceOperations = make(map[string][]string)
+	ds.operationsLock = &sync.RWMutex{}
+
+	ds.instanceActions = make(map[string][]types.InstanceAction)
+	ds.instanceActionCauses = make(map[string]types.InstanceAction)
+	ds.instanceActionsLock = &sync.RWMutex{}
+
+	return nil
+}
+
+// failInterruptedClones puts in error the volumes which were being cloned
+// when the controller stopped, as their clone will never complete.
+func (ds *Datastore) failInterruptedClones(ctx context.Context) error {
+	devices, err := ds.db.getAllBlockData(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error getting block devices from database")
+	}
+
+	for _, bd := range devices {
+		if bd.State != types.Pending && bd.State != types.Cloning {
+			continue
+		}
+
+		glog.Warningf("Clone of volume %s was interrupted", bd.ID)
+
+		bd.State = types.VolumeError
+		err = ds.db.updateBlockData(ctx, bd)
+		if err != nil {
+			return errors.Wrapf(err, "error updating block device %s", bd.ID)
+		}
+	}
+
+	return nil
+}
+
+// rollBackInterruptedArchives returns the volumes which were being archived
+// or rehydrated when the controller stopped to their previous state, as
+// their archival or rehydration will never complete.  A volume being
+// archived still has its block device, which is only deleted once the
+// volume is archived, and a volume being rehydrated still has its
+// archive, which is only removed once the volume is in use again.
+func (ds *Datastore) rollBackInterruptedArchives(ctx context.Context) error {
+	devices, err := ds.db.getAllBlockData(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error getting block devices from database")
+	}
+
+	for _, bd := range devices {
+		switch bd.State {
+		case types.Archiving:
+			glog.Warningf("Archival of volume %s was interrupted", bd.ID)
+			bd.State = types.InUse
+		case types.Rehydrating:
+			glog.Warningf("Rehydration of volume %s was interrupted", bd.ID)
+			bd.State = types.Archived
+		default:
+			continue
+		}
+
+		err = ds.db.updateBlockData(ctx, bd)
+		if err != nil {
+			return errors.Wrapf(err, "error updating block device %s", bd.ID)
+		}
+	}
+
+	return nil
+}
+
+// failInterruptedSnapshotSets puts in error the snapshot sets which were
+// being created when the controller stopped, as their creation will never
+// complete.  Sets in error can be deleted.
+func (ds *Datastore) failInterruptedSnapshotSets(ctx context.Context) error {
+	tenants, err := ds.db.getTenants(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error getting tenants from database")
+	}
+
+	for _, t := range tenants {
+		sets, err := ds.db.getSnapshotSets(ctx, t.ID)
+		if err != nil {
+			return errors.Wrapf(err, "error getting snapshot sets of tenant %s", t.ID)
+		}
+
+		for _, set := range sets {
+			if set.State != types.SnapshotSetCreating {
+				continue
+			}
+
+			glog.Warningf("Creation of snapshot set %s was interrupted", set.ID)
+
+			set.State = types.SnapshotSetError
+			err = ds.db.updateSnapshotSet(ctx, set)
+			if err != nil {
+				return errors.Wrapf(err, "error updating snapshot set %s", set.ID)
+			}
+		}
+	}
 
 	return nil
 }
@@ -406,23 +626,33 @@ func (ds *Datastore) Exit() {
 	ds.db.disconnect()
 }
 
+// Ping checks that the database can be queried.
+func (ds *Datastore) Ping(ctx context.Context) error {
+	return ds.db.ping(ctx)
+}
+
 // AddTenant stores information about a tenant into the datastore.
 // and makes sure that this new tenant is cached.
-func (ds *Datastore) AddTenant(id string, config types.TenantConfig) (*types.Tenant, error) {
+func (ds *Datastore) AddTenant(ctx context.Context, id string, config types.TenantConfig) (*types.Tenant, error) {
 	ds.tenantsLock.Lock()
 	defer ds.tenantsLock.Unlock()
 
 	t, ok := ds.tenants[id]
 	if ok {
-		return nil, errors.New("Duplicate Tenant ID")
+		return nil, ErrDuplicateTenant
+	}
+
+	err := validateCNCIFlavor(config.CNCIFlavor)
+	if err != nil {
+		return nil, err
 	}
 
-	err := ds.db.addTenant(id, config)
+	err = ds.db.addTenant(ctx, id, config)
 	if err != nil {
 		return nil, errors.Wrapf(err, "error adding tenant (%v) to database", id)
 	}
 
-	t, err = ds.db.getTenant(id)
+	t, err = ds.db.getTenant(ctx, id)
 	if err != nil || t == nil {
 		return nil, err
 	}
@@ -435,7 +665,7 @@ func (ds *Datastore) AddTenant(id string, config types.TenantConfig) (*types.Ten
 // DeleteTenant removes a tenant from the datastore.
 // It is the responsibility of the caller to ensure all tenant artifacts
 // are removed first.
-func (ds *Datastore) DeleteTenant(ID string) error {
+func (ds *Datastore) DeleteTenant(ctx context.Context, ID string) error {
 	ds.tenantsLock.Lock()
 	defer ds.tenantsLock.Unlock()
 
@@ -446,10 +676,10 @@ func (ds *Datastore) DeleteTenant(ID string) error {
 
 	delete(ds.tenants, ID)
 
-	return ds.db.deleteTenant(ID)
+	return ds.db.deleteTenant(ctx, ID)
 }
 
-func (ds *Datastore) getTenant(id string) (*tenant, error) {
+func (ds *Datastore) getTenant(ctx context.Context, id string) (*tenant, error) {
 	// check cache first
 	ds.tenantsLock.RLock()
 	t := ds.tenants[id]
@@ -459,13 +689,13 @@ func (ds *Datastore) getTenant(id string) (*tenant, error) {
 		return t, nil
 	}
 
-	t, err := ds.db.getTenant(id)
+	t, err := ds.db.getTenant(ctx, id)
 	return t, errors.Wrapf(err, "error getting tenant (%v) from database", id)
 }
 
 // GetTenant returns details about a tenant referenced by the uuid
-func (ds *Datastore) GetTenant(id string) (*types.Tenant, error) {
-	t, err := ds.getTenant(id)
+func (ds *Datastore) GetTenant(ctx context.Context, id string) (*types.Tenant, error) {
+	t, err := ds.getTenant(ctx, id)
 	if err != nil || t == nil {
 		return nil, err
 	}
@@ -473,8 +703,65 @@ func (ds *Datastore) GetTenant(id string) (*types.Tenant, error) {
 	return &t.Tenant, nil
 }
 
+// PrepareTenant makes sure the CNCI controller of a tenant has been created
+// by prepare, readying the tenant to run instances.  The tenant is prepared
+// by a single caller at a time: concurrent callers wait for up to timeout
+// for the preparation in progress and share its outcome, without calling
+// prepare.  Once prepared, a tenant stays ready until it is deleted.  A
+// failed preparation is retried by the next caller.
+func (ds *Datastore) PrepareTenant(ctx context.Context, id string, timeout time.Duration,
+	prepare func() (types.CNCIController, error)) error {
+	ds.tenantsLock.Lock()
+
+	t, ok := ds.tenants[id]
+	if !ok {
+		ds.tenantsLock.Unlock()
+		return ErrNoTenant
+	}
+
+	switch t.readiness {
+	case tenantReady:
+		ds.tenantsLock.Unlock()
+		return nil
+	case tenantPreparing:
+		p := t.preparation
+		ds.tenantsLock.Unlock()
+
+		select {
+		case <-p.done:
+			return p.err
+		case <-time.After(timeout):
+			return ErrTenantNotReady
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	p := &tenantPreparation{done: make(chan struct{})}
+	t.readiness = tenantPreparing
+	t.preparation = p
+	ds.tenantsLock.Unlock()
+
+	ctrl, err := prepare()
+
+	ds.tenantsLock.Lock()
+	if err == nil {
+		t.CNCIctrl = ctrl
+		t.readiness = tenantReady
+	} else {
+		t.readiness = tenantUnprepared
+	}
+	t.preparation = nil
+	p.err = err
+	ds.tenantsLock.Unlock()
+
+	close(p.done)
+
+	return err
+}
+
 // JSONPatchTenant will update a tenant with changes from a json merge patch.
-func (ds *Datastore) JSONPatchTenant(ID string, patch []byte) error {
+func (ds *Datastore) JSONPatchTenant(ctx context.Context, ID string, patch []byte) error {
 	var config types.TenantConfig
 
 	ds.tenantsLock.Lock()
@@ -502,27 +789,190 @@ func (ds *Datastore) JSONPatchTenant(ID string, patch []byte) error {
 		return errors.Wrap(err, "error updating tenant")
 	}
 
-	// SubnetBits must not modified if there are active instances.
-	// for now, the cncis must also be removed. In the future we might
-	// be able to just update the cnci with the new subnet info.
-	if len(tenant.instances) > 0 {
-		if oldconfig.SubnetBits != config.SubnetBits {
-			return errors.New("Unable to update with active instances")
+	err = validateCNCIFlavor(config.CNCIFlavor)
+	if err != nil {
+		return err
+	}
+
+	if config.SubnetBits == oldconfig.SubnetBits {
+		updated := tenant.Tenant
+		updated.TenantConfig = config
+
+		err = ds.db.updateTenant(ctx, &updated)
+		if err != nil {
+			return err
+		}
+
+		tenant.TenantConfig = config
+		return nil
+	}
+
+	if config.SubnetBits < 12 || config.SubnetBits > 30 {
+		return types.ErrInvalidSubnetBits
+	}
+
+	// instances keep the subnet they were started in, so changing its
+	// size requires an explicit renumbering.
+	for _, i := range tenant.instances {
+		if !i.CNCI {
+			return types.ErrTenantHasInstances
+		}
+	}
+
+	return ds.renumberTenant(ctx, tenant, config)
+}
+
+// renumberTenant changes the subnet size of a tenant, regrouping its
+// allocated addresses into subnets of the new size.
+//
+// lock for tenant must be held.
+func (ds *Datastore) renumberTenant(ctx context.Context, t *tenant, config types.TenantConfig) error {
+	updated := t.Tenant
+	updated.TenantConfig = config
+
+	err := ds.db.renumberTenant(ctx, &updated)
+	if err != nil {
+		return err
+	}
+
+	t.TenantConfig = config
+
+	subMask := binary.BigEndian.Uint32(net.CIDRMask(config.SubnetBits, 32))
+	network := make(map[uint32]map[uint32]bool)
+	for _, hosts := range t.network {
+		for host := range hosts {
+			subnet := host & subMask
+			if network[subnet] == nil {
+				network[subnet] = make(map[uint32]bool)
+			}
+			network[subnet][host] = true
+		}
+	}
+	t.network = network
+
+	return nil
+}
+
+// subnetConflicts returns the addresses of the instances and the
+// reservations of a tenant which would become the network, gateway or
+// broadcast address of their subnet with a new subnet size.
+//
+// lock for tenant must be held.
+func subnetConflicts(t *tenant, subnetBits int) []types.SubnetConflict {
+	hosts := make(map[uint32]types.SubnetConflict)
+
+	for _, i := range t.instances {
+		ipAddr := net.ParseIP(i.IPAddress)
+		if i.CNCI || ipAddr == nil || ipAddr.To4() == nil {
+			continue
+		}
+
+		hosts[binary.BigEndian.Uint32(ipAddr.To4())] = types.SubnetConflict{
+			IPAddress:  i.IPAddress,
+			InstanceID: i.ID,
+		}
+	}
+
+	for host := range t.reservedIPs {
+		c, ok := hosts[host]
+		if !ok {
+			IP := make(net.IP, net.IPv4len)
+			binary.BigEndian.PutUint32(IP, host)
+			c.IPAddress = IP.String()
+		}
+		c.Reserved = true
+		hosts[host] = c
+	}
+
+	mask := net.CIDRMask(subnetBits, 32)
+	subMask := binary.BigEndian.Uint32(mask)
+
+	conflicts := []types.SubnetConflict{}
+	for host, c := range hosts {
+		hostNum := host &^ subMask
+		if hostNum >= 2 && hostNum != ^subMask {
+			continue
+		}
+
+		ipNet := net.IPNet{
+			IP:   net.ParseIP(c.IPAddress).Mask(mask),
+			Mask: mask,
 		}
+		c.Subnet = ipNet.String()
+		conflicts = append(conflicts, c)
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(conflicts[i].IPAddress), net.ParseIP(conflicts[j].IPAddress)) < 0
+	})
+
+	return conflicts
+}
+
+// RenumberTenant changes the subnet size of a tenant which may have
+// instances.  Existing instances keep their addresses and the subnet they
+// were started in, new addresses are allocated out of subnets of the new
+// size which do not overlap the subnets still in use.  The addresses
+// which conflict with the new subnet size are returned.  The subnet size
+// is left unchanged when dryRun is set.
+func (ds *Datastore) RenumberTenant(ctx context.Context, tenantID string, subnetBits int, dryRun bool) ([]types.SubnetConflict, error) {
+	if subnetBits < 12 || subnetBits > 30 {
+		return nil, types.ErrInvalidSubnetBits
+	}
+
+	ds.tenantsLock.Lock()
+	defer ds.tenantsLock.Unlock()
+
+	t := ds.tenants[tenantID]
+	if t == nil {
+		return nil, ErrNoTenant
+	}
+
+	conflicts := subnetConflicts(t, subnetBits)
+	if dryRun || subnetBits == t.SubnetBits {
+		return conflicts, nil
 	}
 
-	tenant.TenantConfig = config
+	config := t.TenantConfig
+	config.SubnetBits = subnetBits
+
+	return conflicts, ds.renumberTenant(ctx, t, config)
+}
+
+// oldSubnets returns the subnets of the instances of a tenant which were
+// started before its subnet size changed.
+//
+// lock for tenant must be held.
+func oldSubnets(t *tenant) []*net.IPNet {
+	var subnets []*net.IPNet
+
+	seen := make(map[string]bool)
+	for _, i := range t.instances {
+		if i.CNCI || seen[i.Subnet] {
+			continue
+		}
+		seen[i.Subnet] = true
+
+		_, ipNet, err := net.ParseCIDR(i.Subnet)
+		if err != nil {
+			continue
+		}
+
+		if ones, _ := ipNet.Mask.Size(); ones != t.SubnetBits {
+			subnets = append(subnets, ipNet)
+		}
+	}
 
-	return ds.db.updateTenant(&tenant.Tenant)
+	return subnets
 }
 
 // AddWorkload is used to add a new workload to the datastore.
 // Both cache and persistent store are updated.
-func (ds *Datastore) AddWorkload(w types.Workload) error {
+func (ds *Datastore) AddWorkload(ctx context.Context, w types.Workload) error {
 	ds.workloadsLock.Lock()
 	defer ds.workloadsLock.Unlock()
 
-	err := ds.db.addWorkload(w)
+	err := ds.db.addWorkload(ctx, w)
 	if err != nil {
 		return errors.Wrapf(err, "error updating workload (%v) in database", w.ID)
 	}
@@ -545,7 +995,7 @@ func (ds *Datastore) AddWorkload(w types.Workload) error {
 
 // DeleteWorkload will delete an unused workload from the datastore.
 // workload ID out of the datastore.
-func (ds *Datastore) DeleteWorkload(workloadID string) error {
+func (ds *Datastore) DeleteWorkload(ctx context.Context, workloadID string) error {
 	ds.workloadsLock.Lock()
 	defer ds.workloadsLock.Unlock()
 
@@ -566,7 +1016,7 @@ func (ds *Datastore) DeleteWorkload(workloadID string) error {
 		return types.ErrWorkloadNotFound
 	}
 
-	err := ds.db.deleteWorkload(workloadID)
+	err := ds.db.deleteWorkload(ctx, workloadID)
 	if err != nil {
 		return errors.Wrapf(err, "error deleting workload %v from database", workloadID)
 	}
@@ -600,10 +1050,36 @@ func (ds *Datastore) DeleteWorkload(workloadID string) error {
 	return nil
 }
 
+// UpdateWorkloadCategory sets the catalog category of a workload. An
+// empty category removes the workload from the catalog.
+func (ds *Datastore) UpdateWorkloadCategory(ctx context.Context, workloadID string, category string) error {
+	ds.workloadsLock.Lock()
+	defer ds.workloadsLock.Unlock()
+
+	wl, ok := ds.workloads[workloadID]
+	if !ok {
+		return types.ErrWorkloadNotFound
+	}
+
+	err := ds.db.updateWorkloadCategory(ctx, workloadID, category)
+	if err != nil {
+		return errors.Wrapf(err, "error updating category of workload %v in database", workloadID)
+	}
+
+	wl.Category = category
+	ds.workloads[workloadID] = wl
+
+	return nil
+}
+
 // GetWorkload returns details about a specific workload referenced by id
 func (ds *Datastore) GetWorkload(ID string) (types.Workload, error) {
-	if ID == ds.cnciWorkload.ID {
-		return ds.cnciWorkload, nil
+	ds.cnciWorkloadLock.RLock()
+	cnciWorkload := ds.cnciWorkload
+	ds.cnciWorkloadLock.RUnlock()
+
+	if ID == cnciWorkload.ID {
+		return cnciWorkload, nil
 	}
 
 	ds.workloadsLock.RLock()
@@ -658,8 +1134,89 @@ func (ds *Datastore) getWorkloads(tenantID string, includePublic bool) ([]types.
 }
 
 // UpdateInstance will update certain fields of an instance
-func (ds *Datastore) UpdateInstance(instance *types.Instance) error {
-	return ds.db.updateInstance(instance)
+func (ds *Datastore) UpdateInstance(ctx context.Context, instance *types.Instance) error {
+	return ds.db.updateInstance(ctx, instance)
+}
+
+// SetInstanceDeleteTime records when an instance was moved to the recycle
+// bin.  A zero time restores the instance.
+func (ds *Datastore) SetInstanceDeleteTime(ctx context.Context, instanceID string, deleteTime time.Time) error {
+	i, err := ds.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+
+	err = ds.db.updateInstanceDeleteTime(ctx, instanceID, deleteTime)
+	if err != nil {
+		return errors.Wrap(err, "Error updating instance in database")
+	}
+
+	i.StateLock.Lock()
+	i.DeleteTime = deleteTime
+	i.StateLock.Unlock()
+
+	return nil
+}
+
+// SetInstanceRescue records the rescue volume of an instance and whether
+// the instance is in rescue mode.
+func (ds *Datastore) SetInstanceRescue(ctx context.Context, instanceID string, volumeID string, rescued bool) error {
+	i, err := ds.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+
+	err = ds.db.updateInstanceRescue(ctx, instanceID, volumeID, rescued)
+	if err != nil {
+		return errors.Wrap(err, "Error updating instance in database")
+	}
+
+	i.StateLock.Lock()
+	i.RescueVolume = volumeID
+	i.Rescued = rescued
+	i.StateLock.Unlock()
+
+	return nil
+}
+
+// SetInstancePeer records the federation peer an instance was burst to
+// and the ID of the instance in the peer.
+func (ds *Datastore) SetInstancePeer(ctx context.Context, instanceID string, peerID string, remoteID string) error {
+	i, err := ds.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+
+	err = ds.db.updateInstancePeer(ctx, instanceID, peerID, remoteID)
+	if err != nil {
+		return errors.Wrap(err, "Error updating instance in database")
+	}
+
+	i.StateLock.Lock()
+	i.PeerID = peerID
+	i.RemoteID = remoteID
+	i.StateLock.Unlock()
+
+	return nil
+}
+
+// SetInstanceFailure records the last failure reported for an instance.
+func (ds *Datastore) SetInstanceFailure(ctx context.Context, instanceID string, failure *types.Failure) error {
+	i, err := ds.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+
+	err = ds.db.updateInstanceFailure(ctx, instanceID, failure)
+	if err != nil {
+		return errors.Wrap(err, "Error updating instance in database")
+	}
+
+	i.StateLock.Lock()
+	i.Failure = failure
+	i.StateLock.Unlock()
+
+	return nil
 }
 
 // GetAllTenants returns all the tenants from the datastore.
@@ -676,7 +1233,7 @@ func (ds *Datastore) GetAllTenants() ([]*types.Tenant, error) {
 // ReleaseTenantIP will return an IP address previously allocated to the pool.
 // Once a tenant IP address is released, it can be reassigned to another
 // instance.
-func (ds *Datastore) ReleaseTenantIP(tenantID string, ip string) error {
+func (ds *Datastore) ReleaseTenantIP(ctx context.Context, tenantID string, ip string) error {
 	removeSubnet := false
 	var i uint32
 
@@ -685,7 +1242,7 @@ func (ds *Datastore) ReleaseTenantIP(tenantID string, ip string) error {
 		return errors.New("Invalid IPv4 Address")
 	}
 
-	tenant, err := ds.GetTenant(tenantID)
+	tenant, err := ds.GetTenant(ctx, tenantID)
 	if err != nil {
 		return err
 	}
@@ -702,9 +1259,15 @@ func (ds *Datastore) ReleaseTenantIP(tenantID string, ip string) error {
 	// clear from cache
 	ds.tenantsLock.Lock()
 
-	if ds.tenants[tenantID] != nil {
-		delete(ds.tenants[tenantID].network[subnetInt], hostInt)
-		network := ds.tenants[tenantID].network
+	// reserved addresses stay allocated until the reservation is deleted.
+	if ds.tenants[tenantID] != nil && ds.tenants[tenantID].reservedIPs[hostInt] {
+		ds.tenantsLock.Unlock()
+		return nil
+	}
+
+	if ds.tenants[tenantID] != nil {
+		delete(ds.tenants[tenantID].network[subnetInt], hostInt)
+		network := ds.tenants[tenantID].network
 		i = subnetInt
 
 		if len(network[i]) == 0 {
@@ -724,7 +1287,7 @@ func (ds *Datastore) ReleaseTenantIP(tenantID string, ip string) error {
 
 	ds.tenantsLock.Unlock()
 
-	return ds.db.releaseTenantIP(tenantID, subnetInt, hostInt)
+	return ds.db.releaseTenantIP(ctx, tenantID, subnetInt, hostInt)
 }
 
 // lock for tenant must be held.
@@ -741,13 +1304,21 @@ func (ds *Datastore) cleanTenantIPs(tenantID string, IPs []tenantIP) {
 
 // lock for tenant must not be held here.
 func (ds *Datastore) activateSubnets(tenantID string, IPs []net.IP) error {
-	mgr := ds.tenants[tenantID].CNCIctrl
+	ds.tenantsLock.RLock()
+	tenant := ds.tenants[tenantID]
+	if tenant == nil {
+		ds.tenantsLock.RUnlock()
+		return ErrNoTenant
+	}
+	mgr := tenant.CNCIctrl
+	subnetBits := tenant.SubnetBits
+	ds.tenantsLock.RUnlock()
+
 	if mgr == nil {
 		return nil
 	}
 
-	tenant := ds.tenants[tenantID]
-	mask := net.CIDRMask(tenant.SubnetBits, 32)
+	mask := net.CIDRMask(subnetBits, 32)
 
 	for _, ip := range IPs {
 		ipnet := net.IPNet{
@@ -764,11 +1335,11 @@ func (ds *Datastore) activateSubnets(tenantID string, IPs []net.IP) error {
 }
 
 // AllocateTenantIPPool will reserve a pool of IP addresses for the caller.
-func (ds *Datastore) AllocateTenantIPPool(tenantID string, num int) ([]net.IP, error) {
+func (ds *Datastore) AllocateTenantIPPool(ctx context.Context, tenantID string, num int) ([]net.IP, error) {
 	var addrs []net.IP
 	var tenantAddrs []tenantIP
 	var retval error
-	tenant, err := ds.GetTenant(tenantID)
+	tenant, err := ds.GetTenant(ctx, tenantID)
 	if err != nil {
 		return nil, err
 	}
@@ -805,6 +1376,10 @@ func (ds *Datastore) AllocateTenantIPPool(tenantID string, num int) ([]net.IP, e
 
 	subnets := ds.tenants[tenantID].network
 
+	// subnets of another size are in use until their instances are
+	// deleted.
+	inUse := oldSubnets(ds.tenants[tenantID])
+
 	// look for any subnets that have available host nums
 	for k, v := range subnets {
 		if len(v) < maxHosts {
@@ -820,9 +1395,26 @@ func (ds *Datastore) AllocateTenantIPPool(tenantID string, num int) ([]net.IP, e
 			return nil, errors.New("out of addrs")
 		}
 
+		subnetNum := start & mask
+
+		subnetIP := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(subnetIP, subnetNum)
+		subnet := net.IPNet{IP: subnetIP, Mask: ipNet.Mask}
+
+		overlaps := false
+		for _, used := range inUse {
+			if used.Contains(subnetIP) || subnet.Contains(used.IP) {
+				overlaps = true
+				break
+			}
+		}
+		if overlaps {
+			start += uint32(maxHosts)
+			continue
+		}
+
 		// if we have not yet allocated out of this subnet,
 		// we need to make a new map to hold the host addrs.
-		subnetNum := start & mask
 		if subnets[subnetNum] == nil {
 			subnets[subnetNum] = make(map[uint32]bool)
 		}
@@ -840,7 +1432,7 @@ func (ds *Datastore) AllocateTenantIPPool(tenantID string, num int) ([]net.IP, e
 				hostCount++
 				if hostCount == num {
 					// attempt bulk db insert here.
-					err := ds.db.claimTenantIPs(tenantID, tenantAddrs)
+					err := ds.db.claimTenantIPs(ctx, tenantID, tenantAddrs)
 					if err != nil {
 						ds.cleanTenantIPs(tenantID, tenantAddrs)
 						addrs = nil
@@ -860,14 +1452,274 @@ func (ds *Datastore) AllocateTenantIPPool(tenantID string, num int) ([]net.IP, e
 }
 
 // AllocateTenantIP will allocate a single IP address for a tenant.
-func (ds *Datastore) AllocateTenantIP(tenantID string) (net.IP, error) {
-	ips, err := ds.AllocateTenantIPPool(tenantID, 1)
+func (ds *Datastore) AllocateTenantIP(ctx context.Context, tenantID string) (net.IP, error) {
+	ips, err := ds.AllocateTenantIPPool(ctx, tenantID, 1)
 	if err != nil {
 		return nil, err
 	}
 	return ips[0], nil
 }
 
+// GetTenantIPs returns all the IP addresses currently allocated to a tenant.
+func (ds *Datastore) GetTenantIPs(tenantID string) ([]net.IP, error) {
+	var IPs []net.IP
+
+	ds.tenantsLock.RLock()
+	defer ds.tenantsLock.RUnlock()
+
+	tenant := ds.tenants[tenantID]
+	if tenant == nil {
+		return nil, ErrNoTenant
+	}
+
+	for _, hosts := range tenant.network {
+		for host := range hosts {
+			IP := make(net.IP, net.IPv4len)
+			binary.BigEndian.PutUint32(IP, host)
+			IPs = append(IPs, IP)
+		}
+	}
+
+	return IPs, nil
+}
+
+// ClaimTenantIP marks a specific IP address as allocated to a tenant.  This
+// is used to repair allocations that have been lost.
+func (ds *Datastore) ClaimTenantIP(ctx context.Context, tenantID string, ip string) error {
+	ipAddr := net.ParseIP(ip)
+	if ipAddr == nil || ipAddr.To4() == nil {
+		return errors.New("Invalid IPv4 Address")
+	}
+
+	ds.tenantsLock.Lock()
+	defer ds.tenantsLock.Unlock()
+
+	tenant := ds.tenants[tenantID]
+	if tenant == nil {
+		return ErrNoTenant
+	}
+
+	mask := net.CIDRMask(tenant.SubnetBits, 32)
+	subMask := binary.BigEndian.Uint32(mask)
+	hostInt := binary.BigEndian.Uint32(ipAddr.To4())
+	subnetInt := hostInt & subMask
+
+	if tenant.network[subnetInt][hostInt] {
+		return nil
+	}
+
+	err := ds.db.claimTenantIP(ctx, tenantID, subnetInt, hostInt)
+	if err != nil {
+		return err
+	}
+
+	if tenant.network[subnetInt] == nil {
+		tenant.network[subnetInt] = make(map[uint32]bool)
+	}
+	tenant.network[subnetInt][hostInt] = true
+
+	return nil
+}
+
+// tenantHostIP checks that ip is a host address that can be assigned to an
+// instance in the tenant's network and returns its subnet and host numbers.
+func tenantHostIP(t *tenant, ip string) (tenantIP, error) {
+	ipAddr := net.ParseIP(ip)
+	if ipAddr == nil || ipAddr.To4() == nil {
+		return tenantIP{}, types.ErrInvalidIP
+	}
+
+	// tenant networks are allocated out of 172.16.0.0/12.
+	_, tenantNet, _ := net.ParseCIDR("172.16.0.0/12")
+	if !tenantNet.Contains(ipAddr) {
+		return tenantIP{}, types.ErrInvalidIP
+	}
+
+	mask := net.CIDRMask(t.SubnetBits, 32)
+	subMask := binary.BigEndian.Uint32(mask)
+	hostInt := binary.BigEndian.Uint32(ipAddr.To4())
+
+	// skip network, gateway, and broadcast addrs.
+	hostNum := hostInt &^ subMask
+	if hostNum < 2 || hostNum == ^subMask {
+		return tenantIP{}, types.ErrInvalidIP
+	}
+
+	return tenantIP{subnet: hostInt & subMask, host: hostInt}, nil
+}
+
+// lock for tenant must be held.
+func (ds *Datastore) claimTenantHostIP(ctx context.Context, t *tenant, addr tenantIP) error {
+	err := ds.db.claimTenantIP(ctx, t.ID, addr.subnet, addr.host)
+	if err != nil {
+		return err
+	}
+
+	if t.network[addr.subnet] == nil {
+		t.network[addr.subnet] = make(map[uint32]bool)
+	}
+	t.network[addr.subnet][addr.host] = true
+
+	return nil
+}
+
+// lock for tenant must be held.
+func ipInstance(t *tenant, ip string) string {
+	for _, i := range t.instances {
+		if i.IPAddress == ip {
+			return i.ID
+		}
+	}
+	return ""
+}
+
+// ReserveTenantIP reserves an IP address in a tenant's network.  The
+// address is allocated to the tenant but is only assigned to instances
+// which explicitly request it.
+func (ds *Datastore) ReserveTenantIP(ctx context.Context, tenantID string, ip string) error {
+	ds.tenantsLock.Lock()
+	defer ds.tenantsLock.Unlock()
+
+	t := ds.tenants[tenantID]
+	if t == nil {
+		return ErrNoTenant
+	}
+
+	addr, err := tenantHostIP(t, ip)
+	if err != nil {
+		return err
+	}
+
+	if t.network[addr.subnet][addr.host] {
+		return types.ErrIPInUse
+	}
+
+	err = ds.claimTenantHostIP(ctx, t, addr)
+	if err != nil {
+		return err
+	}
+
+	err = ds.db.addTenantIPReservation(ctx, tenantID, addr.host)
+	if err != nil {
+		_ = ds.db.releaseTenantIP(ctx, tenantID, addr.subnet, addr.host)
+		ds.cleanTenantIPs(tenantID, []tenantIP{addr})
+		return err
+	}
+
+	if t.reservedIPs == nil {
+		t.reservedIPs = make(map[uint32]bool)
+	}
+	t.reservedIPs[addr.host] = true
+
+	return nil
+}
+
+// DeleteTenantIPReservation removes a reservation made by ReserveTenantIP
+// and releases the address.  Addresses that are assigned to an instance
+// cannot be released.
+func (ds *Datastore) DeleteTenantIPReservation(ctx context.Context, tenantID string, ip string) error {
+	ds.tenantsLock.Lock()
+
+	t := ds.tenants[tenantID]
+	if t == nil {
+		ds.tenantsLock.Unlock()
+		return ErrNoTenant
+	}
+
+	addr, err := tenantHostIP(t, ip)
+	if err != nil || !t.reservedIPs[addr.host] {
+		ds.tenantsLock.Unlock()
+		return types.ErrIPReservationNotFound
+	}
+
+	if ipInstance(t, ip) != "" {
+		ds.tenantsLock.Unlock()
+		return types.ErrIPInUse
+	}
+
+	err = ds.db.deleteTenantIPReservation(ctx, tenantID, addr.host)
+	if err != nil {
+		ds.tenantsLock.Unlock()
+		return err
+	}
+
+	delete(t.reservedIPs, addr.host)
+
+	ds.tenantsLock.Unlock()
+
+	return ds.ReleaseTenantIP(ctx, tenantID, ip)
+}
+
+// GetTenantIPReservations returns the IP addresses reserved by a tenant
+// along with the instances they are assigned to.
+func (ds *Datastore) GetTenantIPReservations(tenantID string) ([]types.IPReservation, error) {
+	ds.tenantsLock.RLock()
+	defer ds.tenantsLock.RUnlock()
+
+	t := ds.tenants[tenantID]
+	if t == nil {
+		return nil, ErrNoTenant
+	}
+
+	reservations := []types.IPReservation{}
+	for host := range t.reservedIPs {
+		IP := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(IP, host)
+		reservations = append(reservations, types.IPReservation{
+			IPAddress:  IP.String(),
+			InstanceID: ipInstance(t, IP.String()),
+		})
+	}
+
+	sort.Slice(reservations, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(reservations[i].IPAddress),
+			net.ParseIP(reservations[j].IPAddress)) < 0
+	})
+
+	return reservations, nil
+}
+
+// AllocateTenantIPAddress allocates a specific IP address for a tenant
+// instance.  Addresses reserved by the tenant can be allocated provided
+// they are not already assigned to another instance.
+func (ds *Datastore) AllocateTenantIPAddress(ctx context.Context, tenantID string, ip string) (net.IP, error) {
+	ds.tenantsLock.Lock()
+
+	t := ds.tenants[tenantID]
+	if t == nil {
+		ds.tenantsLock.Unlock()
+		return nil, ErrNoTenant
+	}
+
+	addr, err := tenantHostIP(t, ip)
+	if err != nil {
+		ds.tenantsLock.Unlock()
+		return nil, err
+	}
+
+	if t.reservedIPs[addr.host] {
+		if ipInstance(t, ip) != "" {
+			err = types.ErrIPInUse
+		}
+	} else if t.network[addr.subnet][addr.host] {
+		err = types.ErrIPInUse
+	} else {
+		err = ds.claimTenantHostIP(ctx, t, addr)
+	}
+
+	ds.tenantsLock.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	IP := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(IP, addr.host)
+
+	// go ahead and return the IP to the user but possibly with error.
+	return IP, ds.activateSubnets(tenantID, []net.IP{IP})
+}
+
 func (ds *Datastore) getInstances(cncis bool) ([]*types.Instance, error) {
 	var instances []*types.Instance
 
@@ -897,6 +1749,32 @@ func (ds *Datastore) GetAllCNCIInstances() ([]*types.Instance, error) {
 	return ds.getInstances(true)
 }
 
+// GetPendingInstances retrieves a snapshot of the tenant instances which are
+// pending.  The snapshot is taken under the datastore lock as the state,
+// the pending time and the node of the instances change when the launchers
+// report them.
+func (ds *Datastore) GetPendingInstances() []types.PendingInstance {
+	var pending []types.PendingInstance
+
+	ds.instancesLock.RLock()
+	for _, i := range ds.instances {
+		if i.CNCI || i.State != payloads.Pending {
+			continue
+		}
+
+		pending = append(pending, types.PendingInstance{
+			ID:          i.ID,
+			TenantID:    i.TenantID,
+			NodeID:      i.NodeID,
+			CreateTime:  i.CreateTime,
+			PendingTime: i.PendingTime,
+		})
+	}
+	ds.instancesLock.RUnlock()
+
+	return pending
+}
+
 // GetInstance retrieves an instance out of the datastore.
 // The CNCI could be retrieved this way.
 func (ds *Datastore) GetInstance(id string) (*types.Instance, error) {
@@ -928,6 +1806,11 @@ func (ds *Datastore) GetTenantInstance(tenantID string, instanceID string) (*typ
 		return nil, types.ErrInstanceNotFound
 	}
 
+	// instances in the recycle bin are hidden until they are restored.
+	if value.IsDeleted() {
+		return nil, types.ErrInstanceNotFound
+	}
+
 	return value, nil
 }
 
@@ -987,8 +1870,8 @@ func (ds *Datastore) GetAllInstancesByNode(nodeID string) ([]*types.Instance, er
 
 // AddInstance will store a new instance in the datastore.
 // The instance will be updated both in the cache and in the database
-func (ds *Datastore) AddInstance(instance *types.Instance) error {
-	err := ds.db.addInstance(instance)
+func (ds *Datastore) AddInstance(ctx context.Context, instance *types.Instance) error {
+	err := ds.db.addInstance(ctx, instance)
 
 	if err != nil {
 		return errors.Wrap(err, "Error adding instance to database")
@@ -1032,8 +1915,9 @@ func (ds *Datastore) AddInstance(instance *types.Instance) error {
 // Only instances whose status is pending are removed when a StartFailure event
 // is received.  StartFailure errors may also be generated when restarting an
 // exited instance and we want to make sure that a failure to restart such
-// an instance does not result in it being deleted.
-func (ds *Datastore) StartFailure(instanceID string, reason payloads.StartFailureReason, migration bool, nodeID string) error {
+// an instance does not result in it being deleted.  The failure is recorded
+// on the instances which are not removed.
+func (ds *Datastore) StartFailure(ctx context.Context, instanceID string, reason payloads.StartFailureReason, migration bool, nodeID string) error {
 	i, err := ds.GetInstance(instanceID)
 	if err != nil {
 		return errors.Wrapf(err, "error getting instance (%v)", instanceID)
@@ -1044,9 +1928,14 @@ func (ds *Datastore) StartFailure(instanceID string, reason payloads.StartFailur
 	}
 
 	if reason.IsFatal() && !migration {
-		if _, err := ds.deleteInstance(instanceID); err != nil {
+		if _, err := ds.deleteInstance(ctx, instanceID); err != nil {
 			return errors.Wrap(err, "Error deleting instance")
 		}
+	} else {
+		err = ds.SetInstanceFailure(ctx, instanceID, types.NewStartFailure(reason))
+		if err != nil {
+			return errors.Wrapf(err, "error recording failure of instance (%v)", instanceID)
+		}
 	}
 
 	ds.nodesLock.Lock()
@@ -1065,13 +1954,13 @@ func (ds *Datastore) StartFailure(instanceID string, reason payloads.StartFailur
 		Message:   msg,
 		NodeID:    nodeID,
 	}
-	return errors.Wrap(ds.db.logEvent(e), "Error logging event")
+	return errors.Wrap(ds.logEvent(ctx, e), "Error logging event")
 }
 
 // AttachVolumeFailure will clean up after a failure to attach a volume.
-// The volume state will be changed back to available, and an error message
-// will be logged.
-func (ds *Datastore) AttachVolumeFailure(instanceID string, volumeID string, reason payloads.AttachVolumeFailureReason) error {
+// The volume state will be changed back to available, the failure will be
+// recorded on the volume and an error message will be logged.
+func (ds *Datastore) AttachVolumeFailure(ctx context.Context, instanceID string, volumeID string, reason payloads.AttachVolumeFailureReason) error {
 	// update the block data to reflect correct state
 	data, err := ds.GetBlockDevice(volumeID)
 	if err != nil {
@@ -1080,7 +1969,8 @@ func (ds *Datastore) AttachVolumeFailure(instanceID string, volumeID string, rea
 
 	oldState := data.State
 	data.State = types.Available
-	err = ds.UpdateBlockDevice(data)
+	data.Failure = types.NewAttachVolumeFailure(reason)
+	err = ds.UpdateBlockDevice(ctx, data)
 	if err != nil {
 		data.State = oldState
 		return errors.Wrapf(err, "error updating block device for volume (%v)", volumeID)
@@ -1109,11 +1999,43 @@ func (ds *Datastore) AttachVolumeFailure(instanceID string, volumeID string, rea
 		NodeID:    i.NodeID,
 	}
 
-	return errors.Wrap(ds.db.logEvent(e), "Error logging event")
+	return errors.Wrap(ds.logEvent(ctx, e), "Error logging event")
+}
+
+// DeleteFailure records the failure of a node to delete an instance on the
+// instance and logs an error message.  The instance is left in place.
+func (ds *Datastore) DeleteFailure(ctx context.Context, instanceID string, reason payloads.DeleteFailureReason, nodeID string) error {
+	i, err := ds.GetInstance(instanceID)
+	if err != nil {
+		return errors.Wrapf(err, "error getting instance (%v)", instanceID)
+	}
+
+	err = ds.SetInstanceFailure(ctx, instanceID, types.NewDeleteFailure(reason))
+	if err != nil {
+		return errors.Wrapf(err, "error recording failure of instance (%v)", instanceID)
+	}
+
+	ds.nodesLock.Lock()
+	defer ds.nodesLock.Unlock()
+
+	n, ok := ds.nodes[nodeID]
+	if ok {
+		n.TotalFailures++
+		n.DeleteFailures++
+	}
+
+	msg := fmt.Sprintf("Delete Failure %s: %s", instanceID, reason.String())
+	e := types.LogEntry{
+		TenantID:  i.TenantID,
+		EventType: string(userError),
+		Message:   msg,
+		NodeID:    nodeID,
+	}
+	return errors.Wrap(ds.logEvent(ctx, e), "Error logging event")
 }
 
-func (ds *Datastore) deleteInstance(instanceID string) (string, error) {
-	if err := ds.db.deleteInstance(instanceID); err != nil {
+func (ds *Datastore) deleteInstance(ctx context.Context, instanceID string) (string, error) {
+	if err := ds.db.deleteInstance(ctx, instanceID); err != nil {
 		glog.Warningf("error deleting instance (%v): %v", instanceID, err)
 		return "", errors.Wrapf(err, "error deleting instance from database (%v)", instanceID)
 	}
@@ -1142,13 +2064,13 @@ func (ds *Datastore) deleteInstance(instanceID string) (string, error) {
 	}
 
 	var err error
-	if tmpErr := ds.db.deleteInstance(i.ID); tmpErr != nil {
+	if tmpErr := ds.db.deleteInstance(ctx, i.ID); tmpErr != nil {
 		glog.Warningf("error deleting instance (%v): %v", i.ID, err)
 		err = errors.Wrapf(tmpErr, "error deleting instance from database (%v)", i.ID)
 	}
 
 	if i.CNCI == false {
-		if tmpErr := ds.ReleaseTenantIP(i.TenantID, i.IPAddress); tmpErr != nil {
+		if tmpErr := ds.ReleaseTenantIP(ctx, i.TenantID, i.IPAddress); tmpErr != nil {
 			glog.Warningf("error releasing IP for instance (%v): %v", i.ID, tmpErr)
 			if err == nil {
 				err = errors.Wrapf(err, "error releasing IP for instance (%v)", i.ID)
@@ -1156,13 +2078,15 @@ func (ds *Datastore) deleteInstance(instanceID string) (string, error) {
 		}
 	}
 
-	ds.updateStorageAttachments(instanceID)
+	ds.updateStorageAttachments(ctx, instanceID)
+
+	ds.deleteInstanceActions(instanceID)
 
 	return i.TenantID, err
 }
 
 // DeleteInstance removes an instance from the datastore.
-func (ds *Datastore) DeleteInstance(instanceID string) error {
+func (ds *Datastore) DeleteInstance(ctx context.Context, instanceID string) error {
 	i, err := ds.GetInstance(instanceID)
 	if err != nil {
 		return errors.Wrapf(err, "error deleting instance")
@@ -1170,7 +2094,7 @@ func (ds *Datastore) DeleteInstance(instanceID string) error {
 
 	nodeID := i.NodeID
 
-	tenantID, err := ds.deleteInstance(instanceID)
+	tenantID, err := ds.deleteInstance(ctx, instanceID)
 	if err != nil {
 		return errors.Wrapf(err, "error deleting instance")
 	}
@@ -1182,10 +2106,10 @@ func (ds *Datastore) DeleteInstance(instanceID string) error {
 		Message:   msg,
 		NodeID:    nodeID,
 	}
-	return errors.Wrap(ds.db.logEvent(e), "Error logging event")
+	return errors.Wrap(ds.logEvent(ctx, e), "Error logging event")
 }
 
-func (ds *Datastore) updateInstanceStatus(status, instanceID string) error {
+func (ds *Datastore) updateInstanceStatus(ctx context.Context, status, instanceID string) error {
 	stats := []payloads.InstanceStat{
 		{
 			InstanceUUID: instanceID,
@@ -1193,7 +2117,7 @@ func (ds *Datastore) updateInstanceStatus(status, instanceID string) error {
 		},
 	}
 
-	err := ds.db.addInstanceStats(stats, "")
+	err := ds.db.addInstanceStats(ctx, stats, "")
 	if err != nil {
 		return errors.Wrapf(err, "error adding instance stats to database")
 	}
@@ -1210,24 +2134,116 @@ func (ds *Datastore) updateInstanceStatus(status, instanceID string) error {
 	return nil
 }
 
+// PeerInstanceStatus records the state of an instance running in a
+// federation peer, as reported by the peer.
+func (ds *Datastore) PeerInstanceStatus(ctx context.Context, instanceID string, state string, sshIP string, sshPort int) error {
+	stats := []payloads.InstanceStat{
+		{
+			InstanceUUID: instanceID,
+			State:        state,
+			SSHIP:        sshIP,
+			SSHPort:      sshPort,
+		},
+	}
+
+	err := ds.db.addInstanceStats(ctx, stats, "")
+	if err != nil {
+		return errors.Wrapf(err, "error adding instance stats to database")
+	}
+
+	ds.instanceLastStatLock.Lock()
+	instanceStat := ds.instanceLastStat[instanceID]
+	instanceStat.ID = instanceID
+	instanceStat.Timestamp = time.Now()
+	instanceStat.Status = state
+	ds.instanceLastStat[instanceID] = instanceStat
+	ds.instanceLastStatLock.Unlock()
+
+	ds.instancesLock.Lock()
+	i, ok := ds.instances[instanceID]
+	if ok {
+		ds.addInstanceAction(instanceID, i.State, state, types.ReasonStateReported)
+		i.State = state
+		i.SSHIP = sshIP
+		i.SSHPort = sshPort
+	}
+	ds.instancesLock.Unlock()
+
+	return nil
+}
+
 // InstanceRestarting resets a restarting instance's state to pending.
-func (ds *Datastore) InstanceRestarting(instanceID string) error {
-	err := ds.updateInstanceStatus(payloads.Pending, instanceID)
+func (ds *Datastore) InstanceRestarting(ctx context.Context, instanceID string) error {
+	err := ds.updateInstanceStatus(ctx, payloads.Pending, instanceID)
 	if err != nil {
 		return errors.Wrap(err, "Error marking instance as restarting")
 	}
 
 	ds.instancesLock.Lock()
 	i := ds.instances[instanceID]
+	oldState := i.State
 	i.State = payloads.Pending
+	i.PendingTime = time.Now()
+	ds.instancesLock.Unlock()
+
+	ds.addInstanceAction(instanceID, oldState, payloads.Pending, types.ReasonHealthCheck)
+
+	return nil
+}
+
+// InstanceMigrating marks an instance as being live migrated to another
+// node.
+func (ds *Datastore) InstanceMigrating(ctx context.Context, instanceID string) error {
+	err := ds.updateInstanceStatus(ctx, payloads.Migrating, instanceID)
+	if err != nil {
+		return errors.Wrap(err, "Error marking instance as migrating")
+	}
+
+	ds.instancesLock.Lock()
+	i := ds.instances[instanceID]
+	oldState := i.State
+	i.State = payloads.Migrating
+	ds.instancesLock.Unlock()
+
+	ds.addInstanceAction(instanceID, oldState, payloads.Migrating, types.ReasonMigration)
+
+	return nil
+}
+
+// InstanceMigrated records the end of the live migration of an instance,
+// which now runs on nodeID.  nodeID is the node the instance was migrated
+// from if the migration failed.
+func (ds *Datastore) InstanceMigrated(ctx context.Context, instanceID string, nodeID string) error {
+	err := ds.updateInstanceStatus(ctx, payloads.Running, instanceID)
+	if err != nil {
+		return errors.Wrap(err, "Error marking instance as migrated")
+	}
+
+	ds.instancesLock.Lock()
+	i := ds.instances[instanceID]
+	oldNodeID := i.NodeID
+	oldState := i.State
+	i.NodeID = nodeID
+	i.State = payloads.Running
+
+	ds.nodesLock.Lock()
+	if n, ok := ds.nodes[oldNodeID]; ok {
+		delete(n.instances, instanceID)
+	}
+	if n, ok := ds.nodes[nodeID]; ok {
+		n.instances[instanceID] = i
+	}
+	ds.nodesLock.Unlock()
 	ds.instancesLock.Unlock()
 
+	ds.addInstanceAction(instanceID, oldState, payloads.Running, types.ReasonMigration)
+
 	return nil
 }
 
 // InstanceStopped removes the link between an instance and its node
-func (ds *Datastore) InstanceStopped(instanceID string) error {
-	err := ds.updateInstanceStatus(payloads.Exited, instanceID)
+func (ds *Datastore) InstanceStopped(ctx context.Context, instanceID string) error {
+	err := ds.updateInstanceStatus(ctx, payloads.Exited, instanceID)
 	if err != nil {
 		return errors.Wrap(err, "Error marked instance as stopped")
 	}
@@ -1235,10 +2251,13 @@ func (ds *Datastore) InstanceStopped(instanceID string) error {
 	ds.instancesLock.Lock()
 	i := ds.instances[instanceID]
 	oldNodeID := i.NodeID
+	oldState := i.State
 	i.NodeID = ""
 	i.State = payloads.Exited
 	ds.instancesLock.Unlock()
 
+	ds.addInstanceAction(instanceID, oldState, payloads.Exited, types.ReasonStateReported)
+
 	// we may not have received any node stats for this instance
 	if oldNodeID != "" {
 		ds.nodesLock.Lock()
@@ -1253,8 +2272,15 @@ func (ds *Datastore) InstanceStopped(instanceID string) error {
 func (ds *Datastore) DeleteNode(nodeID string) error {
 	ds.nodesLock.Lock()
 	for _, i := range ds.nodes[nodeID].instances {
+		i.StateLock.RLock()
+		oldState := i.State
+		i.StateLock.RUnlock()
+
 		_ = i.TransitionInstanceState(payloads.Missing)
 		i.NodeID = ""
+
+		ds.SetInstanceActionCause(i.ID, types.InitiatorSystem, types.ReasonNodeFailure)
+		ds.addInstanceAction(i.ID, oldState, payloads.Missing, types.ReasonNodeFailure)
 	}
 	delete(ds.nodes, nodeID)
 	ds.nodesLock.Unlock()
@@ -1311,23 +2337,23 @@ func (ds *Datastore) GetNode(nodeID string) (types.Node, error) {
 }
 
 // HandleStats makes sure that the data from the stat payload is stored.
-func (ds *Datastore) HandleStats(stat payloads.Stat) error {
+func (ds *Datastore) HandleStats(ctx context.Context, stat payloads.Stat) error {
 	if stat.Load != -1 {
-		if err := ds.addNodeStat(stat); err != nil {
+		if err := ds.addNodeStat(ctx, stat); err != nil {
 			return errors.Wrap(err, "error updating node stats")
 		}
 	}
 
-	return errors.Wrapf(ds.addInstanceStats(stat.Instances, stat.NodeUUID), "error updating stats")
+	return errors.Wrapf(ds.addInstanceStats(ctx, stat.Instances, stat.NodeUUID), "error updating stats")
 }
 
 // HandleTraceReport stores the provided trace data in the datastore.
-func (ds *Datastore) HandleTraceReport(trace payloads.Trace) error {
+func (ds *Datastore) HandleTraceReport(ctx context.Context, trace payloads.Trace) error {
 	var err error
 	for index := range trace.Frames {
 		i := trace.Frames[index]
 
-		if tmpErr := ds.db.addFrameStat(i); tmpErr != nil {
+		if tmpErr := ds.db.addFrameStat(ctx, i); tmpErr != nil {
 			if err == nil {
 				err = errors.Wrapf(tmpErr, "error adding stats to database")
 			}
@@ -1363,6 +2389,45 @@ func (ds *Datastore) GetInstanceLastStats(nodeID string) types.CiaoServersStats
 	return serversStats
 }
 
+// GetTenantInstanceLastStats retrieves the last stats received for the
+// instances of a tenant, sorted by instance ID.  It returns them in a format
+// suitable for the compute API.
+func (ds *Datastore) GetTenantInstanceLastStats(tenantID string) types.CiaoServersStats {
+	serversStats := types.NewCiaoServersStats()
+
+	ds.instanceLastStatLock.RLock()
+	for _, instance := range ds.instanceLastStat {
+		i, err := ds.GetInstance(instance.ID)
+		if err != nil {
+			continue
+		}
+
+		if i.TenantID != tenantID || i.CNCI {
+			continue
+		}
+
+		instance.TenantID = i.TenantID
+		serversStats.Servers = append(serversStats.Servers, instance)
+	}
+	ds.instanceLastStatLock.RUnlock()
+
+	sort.Slice(serversStats.Servers, func(i, j int) bool {
+		return serversStats.Servers[i].ID < serversStats.Servers[j].ID
+	})
+	serversStats.TotalServers = len(serversStats.Servers)
+
+	return serversStats
+}
+
+// GetInstanceLastStat retrieves the last stats received for an instance.
+func (ds *Datastore) GetInstanceLastStat(instanceID string) (types.CiaoServerStats, bool) {
+	ds.instanceLastStatLock.RLock()
+	defer ds.instanceLastStatLock.RUnlock()
+
+	stat, ok := ds.instanceLastStat[instanceID]
+	return stat, ok
+}
+
 // GetNodeLastStats retrieves the last nodes' stats received.
 // It returns it in a format suitable for the compute API.
 func (ds *Datastore) GetNodeLastStats() types.CiaoNodes {
@@ -1377,7 +2442,7 @@ func (ds *Datastore) GetNodeLastStats() types.CiaoNodes {
 	return nodes
 }
 
-func (ds *Datastore) addNodeStat(stat payloads.Stat) error {
+func (ds *Datastore) addNodeStat(ctx context.Context, stat payloads.Stat) error {
 	ds.nodesLock.Lock()
 
 	n, ok := ds.nodes[stat.NodeUUID]
@@ -1414,7 +2479,7 @@ func (ds *Datastore) addNodeStat(stat payloads.Stat) error {
 
 	ds.nodeLastStatLock.Unlock()
 
-	return errors.Wrap(ds.db.addNodeStat(stat), "error adding node stats to database")
+	return errors.Wrap(ds.db.addNodeStat(ctx, stat), "error adding node stats to database")
 }
 
 var tenantUsagePeriodMinutes float64 = 5
@@ -1507,7 +2572,9 @@ func reduceToZero(v int) int {
 	return v
 }
 
-func (ds *Datastore) addInstanceStats(stats []payloads.InstanceStat, nodeID string) error {
+func (ds *Datastore) addInstanceStats(ctx context.Context, stats []payloads.InstanceStat, nodeID string) error {
+	stats = ds.filterMigratingStats(stats, nodeID)
+
 	for index := range stats {
 		stat := stats[index]
 
@@ -1543,6 +2610,7 @@ func (ds *Datastore) addInstanceStats(stats []payloads.InstanceStat, nodeID stri
 		ds.instancesLock.Lock()
 		instance, ok := ds.instances[stat.InstanceUUID]
 		if ok {
+			ds.addInstanceAction(instance.ID, instance.State, stat.State, types.ReasonStateReported)
 			instance.State = stat.State
 			instance.NodeID = nodeID
 			instance.SSHIP = stat.SSHIP
@@ -1552,9 +2620,56 @@ func (ds *Datastore) addInstanceStats(stats []payloads.InstanceStat, nodeID stri
 			ds.nodesLock.Unlock()
 		}
 		ds.instancesLock.Unlock()
+
+		ds.updateVolumeUsage(stat.VolumeUsage)
+	}
+
+	return errors.Wrapf(ds.db.addInstanceStats(ctx, stats, nodeID), "error adding instance stats to database")
+}
+
+// filterMigratingStats drops the statistics reported for instances being
+// live migrated by the nodes which do not own them.  An instance migrating
+// is reported by both its source and target nodes, and by its source for a
+// short while after it completed its migration.
+func (ds *Datastore) filterMigratingStats(stats []payloads.InstanceStat, nodeID string) []payloads.InstanceStat {
+	filtered := make([]payloads.InstanceStat, 0, len(stats))
+
+	ds.instancesLock.RLock()
+	for _, stat := range stats {
+		i, ok := ds.instances[stat.InstanceUUID]
+		if ok && i.NodeID != "" && i.NodeID != nodeID &&
+			(i.State == payloads.Migrating || stat.State == payloads.Migrating) {
+			continue
+		}
+		filtered = append(filtered, stat)
+	}
+	ds.instancesLock.RUnlock()
+
+	return filtered
+}
+
+// updateVolumeUsage records the space allocated to volumes reported by a
+// node. The usage is refreshed periodically so it is not stored persistently.
+func (ds *Datastore) updateVolumeUsage(usage []payloads.VolumeStat) {
+	ds.bdLock.Lock()
+	ds.tenantsLock.Lock()
+
+	for _, u := range usage {
+		dev, ok := ds.blockDevices[u.VolumeUUID]
+		if !ok {
+			continue
+		}
+
+		dev.UsedMB = u.UsedMB
+		ds.blockDevices[dev.ID] = dev
+
+		if t, ok := ds.tenants[dev.TenantID]; ok {
+			t.devices[dev.ID] = dev
+		}
 	}
 
-	return errors.Wrapf(ds.db.addInstanceStats(stats, nodeID), "error adding instance stats to database")
+	ds.tenantsLock.Unlock()
+	ds.bdLock.Unlock()
 }
 
 // GetTenantCNCISummary retrieves information about a given CNCI id, or all CNCIs
@@ -1596,6 +2711,9 @@ func (ds *Datastore) GetTenantCNCISummary(cnciID string) ([]types.TenantCNCI, er
 // GetCNCIWorkloadID returns the UUID of the workload template
 // for the CNCI workload
 func (ds *Datastore) GetCNCIWorkloadID() (string, error) {
+	ds.cnciWorkloadLock.RLock()
+	defer ds.cnciWorkloadLock.RUnlock()
+
 	if ds.cnciWorkload.ID == "" {
 		return "", errors.New("No CNCI Workload in datastore")
 	}
@@ -1642,55 +2760,207 @@ func (ds *Datastore) GetNodeSummary() ([]*types.NodeSummary, error) {
 }
 
 // GetBatchFrameSummary will retieve the count of traces we have for a specific label
-func (ds *Datastore) GetBatchFrameSummary() ([]types.BatchFrameSummary, error) {
+func (ds *Datastore) GetBatchFrameSummary(ctx context.Context) ([]types.BatchFrameSummary, error) {
 	// until we start caching frame stats, we have to send this
 	// right through to the database.
-	return ds.db.getBatchFrameSummary()
+	return ds.db.getBatchFrameSummary(ctx)
 }
 
 // GetBatchFrameStatistics will show individual trace data per instance for a batch of trace data.
 // The batch is identified by the label.
-func (ds *Datastore) GetBatchFrameStatistics(label string) ([]types.BatchFrameStat, error) {
+func (ds *Datastore) GetBatchFrameStatistics(ctx context.Context, label string) ([]types.BatchFrameStat, error) {
 	// until we start caching frame stats, we have to send this
 	// right through to the database.
-	return ds.db.getBatchFrameStatistics(label)
+	return ds.db.getBatchFrameStatistics(ctx, label)
+}
+
+// DeleteBatchFrameStatistics will remove all the trace data of a batch.
+// The batch is identified by the label.
+func (ds *Datastore) DeleteBatchFrameStatistics(ctx context.Context, label string) error {
+	return ds.db.deleteBatchFrameStatistics(ctx, label)
 }
 
 // GetEventLog retrieves all the log entries stored in the datastore.
-func (ds *Datastore) GetEventLog() ([]*types.LogEntry, error) {
+func (ds *Datastore) GetEventLog(ctx context.Context) ([]*types.LogEntry, error) {
 	// we don't as of yet cache any of the events that are logged.
-	return ds.db.getEventLog()
+	return ds.db.getEventLog(ctx)
 }
 
 // ClearLog will remove all the event entries from the event log
-func (ds *Datastore) ClearLog() error {
+func (ds *Datastore) ClearLog(ctx context.Context) error {
 	// we don't as of yet cache any of the events that are logged.
-	return ds.db.clearLog()
+	return ds.db.clearLog(ctx)
+}
+
+// AddFailedCommand stores an SSNTP command which failed so that it can be
+// replayed later.
+func (ds *Datastore) AddFailedCommand(ctx context.Context, cmd types.FailedCommand) error {
+	return ds.db.addFailedCommand(ctx, cmd)
+}
+
+// GetFailedCommands retrieves all the failed commands, oldest first.
+func (ds *Datastore) GetFailedCommands(ctx context.Context) ([]types.FailedCommand, error) {
+	// failed commands are rare, so they are not cached.
+	return ds.db.getFailedCommands(ctx)
+}
+
+// GetFailedCommand retrieves the failed command with the given ID.
+func (ds *Datastore) GetFailedCommand(ctx context.Context, ID string) (types.FailedCommand, error) {
+	cmds, err := ds.db.getFailedCommands(ctx)
+	if err != nil {
+		return types.FailedCommand{}, err
+	}
+
+	for _, cmd := range cmds {
+		if cmd.ID == ID {
+			return cmd, nil
+		}
+	}
+
+	return types.FailedCommand{}, types.ErrFailedCommandNotFound
+}
+
+// DeleteFailedCommand removes a failed command once it has been replayed
+// or is no longer needed.
+func (ds *Datastore) DeleteFailedCommand(ctx context.Context, ID string) error {
+	return ds.db.deleteFailedCommand(ctx, ID)
+}
+
+// UpdateNodePolicy sets the scheduling policy of a node, replacing any
+// policy previously set for it.
+func (ds *Datastore) UpdateNodePolicy(ctx context.Context, policy types.NodePolicy) error {
+	return ds.db.updateNodePolicy(ctx, policy)
+}
+
+// DeleteNodePolicy removes the scheduling policy of a node.
+func (ds *Datastore) DeleteNodePolicy(ctx context.Context, nodeID string) error {
+	return ds.db.deleteNodePolicy(ctx, nodeID)
+}
+
+// GetNodePolicies retrieves the scheduling policies of all nodes.
+func (ds *Datastore) GetNodePolicies(ctx context.Context) ([]types.NodePolicy, error) {
+	return ds.db.getNodePolicies(ctx)
+}
+
+// UpdateSecret stores a tenant secret, replacing any secret of the same
+// name.
+func (ds *Datastore) UpdateSecret(ctx context.Context, secret types.Secret) error {
+	return ds.db.updateSecret(ctx, secret)
+}
+
+// DeleteSecret removes a tenant secret.
+func (ds *Datastore) DeleteSecret(ctx context.Context, tenantID string, name string) error {
+	return ds.db.deleteSecret(ctx, tenantID, name)
+}
+
+// GetSecrets retrieves the secrets of a tenant, sorted by name.
+func (ds *Datastore) GetSecrets(ctx context.Context, tenantID string) ([]types.Secret, error) {
+	return ds.db.getSecrets(ctx, tenantID)
+}
+
+// GetSecret retrieves a tenant secret by name.
+func (ds *Datastore) GetSecret(ctx context.Context, tenantID string, name string) (types.Secret, error) {
+	secrets, err := ds.db.getSecrets(ctx, tenantID)
+	if err != nil {
+		return types.Secret{}, err
+	}
+
+	for _, secret := range secrets {
+		if secret.Name == name {
+			return secret, nil
+		}
+	}
+
+	return types.Secret{}, types.ErrSecretNotFound
+}
+
+// eventWatcherBacklog is the number of events buffered for an event
+// watcher. A watcher falling further behind is dropped.
+const eventWatcherBacklog = 64
+
+// logEvent adds an entry to the persistent event log and forwards it to
+// the event watchers.
+func (ds *Datastore) logEvent(ctx context.Context, e types.LogEntry) error {
+	err := ds.db.logEvent(ctx, e)
+	if err != nil {
+		return err
+	}
+
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now().UTC()
+	}
+
+	ds.eventWatchersLock.Lock()
+	defer ds.eventWatchersLock.Unlock()
+
+	for ch := range ds.eventWatchers {
+		select {
+		case ch <- e:
+		default:
+			// logging must not block on a slow watcher.
+			delete(ds.eventWatchers, ch)
+			close(ch)
+		}
+	}
+
+	return nil
+}
+
+// WatchEvents returns a channel receiving the events logged from now on,
+// and a function to call to stop watching. The channel is closed when the
+// watcher falls behind or the watchers are stopped.
+func (ds *Datastore) WatchEvents() (<-chan types.LogEntry, func()) {
+	ch := make(chan types.LogEntry, eventWatcherBacklog)
+
+	ds.eventWatchersLock.Lock()
+	ds.eventWatchers[ch] = struct{}{}
+	ds.eventWatchersLock.Unlock()
+
+	return ch, func() {
+		ds.eventWatchersLock.Lock()
+		defer ds.eventWatchersLock.Unlock()
+
+		if _, ok := ds.eventWatchers[ch]; ok {
+			delete(ds.eventWatchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// StopEventWatchers closes the channels of all the event watchers.
+func (ds *Datastore) StopEventWatchers() {
+	ds.eventWatchersLock.Lock()
+	defer ds.eventWatchersLock.Unlock()
+
+	for ch := range ds.eventWatchers {
+		delete(ds.eventWatchers, ch)
+		close(ch)
+	}
 }
 
 // LogEvent will add a message to the persistent event log.
-func (ds *Datastore) LogEvent(tenant string, msg string) error {
+func (ds *Datastore) LogEvent(ctx context.Context, tenant string, msg string) error {
 	e := types.LogEntry{
 		TenantID:  tenant,
 		EventType: string(userInfo),
 		Message:   msg,
 	}
-	return ds.db.logEvent(e)
+	return ds.logEvent(ctx, e)
 }
 
 // LogError will add a message to the persistent event log as an error
-func (ds *Datastore) LogError(tenant string, msg string) error {
+func (ds *Datastore) LogError(ctx context.Context, tenant string, msg string) error {
 	e := types.LogEntry{
 		TenantID:  tenant,
 		EventType: string(userError),
 		Message:   msg,
 	}
-	return ds.db.logEvent(e)
+	return ds.logEvent(ctx, e)
 }
 
 // AddBlockDevice will store information about new BlockData into
 // the datastore.
-func (ds *Datastore) AddBlockDevice(device types.Volume) error {
+func (ds *Datastore) AddBlockDevice(ctx context.Context, device types.Volume) error {
 	ds.bdLock.Lock()
 	_, update := ds.blockDevices[device.ID]
 	ds.bdLock.Unlock()
@@ -1698,9 +2968,9 @@ func (ds *Datastore) AddBlockDevice(device types.Volume) error {
 	// store persistently
 	var err error
 	if !update {
-		err = errors.Wrap(ds.db.addBlockData(device), "Error adding block data to database")
+		err = errors.Wrap(ds.db.addBlockData(ctx, device), "Error adding block data to database")
 	} else {
-		err = errors.Wrap(ds.db.updateBlockData(device), "Error updating block data in database")
+		err = errors.Wrap(ds.db.updateBlockData(ctx, device), "Error updating block data in database")
 	}
 
 	if err != nil {
@@ -1721,7 +2991,7 @@ func (ds *Datastore) AddBlockDevice(device types.Volume) error {
 
 // DeleteBlockDevice will delete a volume from the datastore.
 // It also deletes it from the tenant's list of devices.
-func (ds *Datastore) DeleteBlockDevice(ID string) error {
+func (ds *Datastore) DeleteBlockDevice(ctx context.Context, ID string) error {
 	ds.bdLock.Lock()
 	dev, ok := ds.blockDevices[ID]
 	if !ok {
@@ -1731,7 +3001,7 @@ func (ds *Datastore) DeleteBlockDevice(ID string) error {
 	}
 	ds.bdLock.Unlock()
 
-	err := errors.Wrap(ds.db.deleteBlockData(ID), "Error deleting block data from database")
+	err := errors.Wrap(ds.db.deleteBlockData(ctx, ID), "Error deleting block data from database")
 	if err != nil {
 		return err
 	}
@@ -1785,7 +3055,7 @@ func (ds *Datastore) GetBlockDevice(ID string) (types.Volume, error) {
 
 // UpdateBlockDevice will replace existing information about a block device
 // in the datastore.
-func (ds *Datastore) UpdateBlockDevice(data types.Volume) error {
+func (ds *Datastore) UpdateBlockDevice(ctx context.Context, data types.Volume) error {
 	ds.bdLock.RLock()
 	_, ok := ds.blockDevices[data.ID]
 	ds.bdLock.RUnlock()
@@ -1794,12 +3064,12 @@ func (ds *Datastore) UpdateBlockDevice(data types.Volume) error {
 		return ErrNoBlockData
 	}
 
-	return errors.Wrapf(ds.AddBlockDevice(data), "error updating block device (%v)", data.ID)
+	return errors.Wrapf(ds.AddBlockDevice(ctx, data), "error updating block device (%v)", data.ID)
 }
 
 // CreateStorageAttachment will associate an instance with a block device in
 // the datastore
-func (ds *Datastore) CreateStorageAttachment(instanceID string, volume payloads.StorageResource) (types.StorageAttachment, error) {
+func (ds *Datastore) CreateStorageAttachment(ctx context.Context, instanceID string, volume payloads.StorageResource) (types.StorageAttachment, error) {
 	link := attachment{
 		instanceID: instanceID,
 		volumeID:   volume.ID,
@@ -1813,7 +3083,7 @@ func (ds *Datastore) CreateStorageAttachment(instanceID string, volume payloads.
 		Boot:       volume.Bootable,
 	}
 
-	err := ds.db.addStorageAttachment(a)
+	err := ds.db.addStorageAttachment(ctx, a)
 	if err != nil {
 		return types.StorageAttachment{}, errors.Wrap(err, "error adding storage attachment to database")
 	}
@@ -1821,14 +3091,14 @@ func (ds *Datastore) CreateStorageAttachment(instanceID string, volume payloads.
 	// ensure that the volume is marked in use as we have created an attachment
 	bd, err := ds.GetBlockDevice(volume.ID)
 	if err != nil {
-		_ = ds.db.deleteStorageAttachment(a.ID)
+		_ = ds.db.deleteStorageAttachment(ctx, a.ID)
 		return types.StorageAttachment{}, errors.Wrapf(err, "error fetching block device (%v)", volume.ID)
 	}
 
 	bd.State = types.InUse
-	err = ds.UpdateBlockDevice(bd)
+	err = ds.UpdateBlockDevice(ctx, bd)
 	if err != nil {
-		_ = ds.db.deleteStorageAttachment(a.ID)
+		_ = ds.db.deleteStorageAttachment(ctx, a.ID)
 		return types.StorageAttachment{}, errors.Wrapf(err, "error updating block device (%v)", volume.ID)
 	}
 
@@ -1856,7 +3126,7 @@ func (ds *Datastore) GetStorageAttachments(instanceID string) []types.StorageAtt
 	return links
 }
 
-func (ds *Datastore) updateStorageAttachments(instanceID string) {
+func (ds *Datastore) updateStorageAttachments(ctx context.Context, instanceID string) {
 	ds.attachLock.Lock()
 
 	// check to see if all the attachments we already
@@ -1871,9 +3141,12 @@ func (ds *Datastore) updateStorageAttachments(instanceID string) {
 				continue
 			}
 
-			// update the state of the volume.
-			bd.State = types.Available
-			err = ds.UpdateBlockDevice(bd)
+			// update the state of the volume.  Archived volumes
+			// stay archived as they have no block device to attach.
+			if bd.State != types.Archived {
+				bd.State = types.Available
+			}
+			err = ds.UpdateBlockDevice(ctx, bd)
 			if err != nil {
 				glog.Warningf("error updating block device (%v): %v", a.BlockID, err)
 			}
@@ -1891,7 +3164,7 @@ func (ds *Datastore) updateStorageAttachments(instanceID string) {
 			// ok for lock to be held here, but
 			// not needed as the db keeps it's
 			// own locks.
-			err = ds.db.deleteStorageAttachment(ID)
+			err = ds.db.deleteStorageAttachment(ctx, ID)
 			if err != nil {
 				glog.Warningf("error updating storage attachments: %v", err)
 			}
@@ -1924,8 +3197,8 @@ func (ds *Datastore) getStorageAttachment(instanceID string, volumeID string) (t
 
 // DeleteStorageAttachment will delete the attachment with the associated ID
 // from the datastore.
-func (ds *Datastore) DeleteStorageAttachment(ID string) error {
-	err := errors.Wrapf(ds.db.deleteStorageAttachment(ID), "error deleting storage attachment (%v) from database", ID)
+func (ds *Datastore) DeleteStorageAttachment(ctx context.Context, ID string) error {
+	err := errors.Wrapf(ds.db.deleteStorageAttachment(ctx, ID), "error deleting storage attachment (%v) from database", ID)
 	if err != nil {
 		return err
 	}
@@ -2032,7 +3305,7 @@ func (ds *Datastore) isDuplicateIP(new net.IP) bool {
 }
 
 // AddPool will add a brand new pool to our datastore.
-func (ds *Datastore) AddPool(pool types.Pool) error {
+func (ds *Datastore) AddPool(ctx context.Context, pool types.Pool) error {
 	ds.poolsLock.Lock()
 
 	if len(pool.Subnets) > 0 {
@@ -2078,20 +3351,20 @@ func (ds *Datastore) AddPool(pool types.Pool) error {
 	}
 
 	ds.pools[pool.ID] = pool
-	err := ds.db.addPool(pool)
+	err := ds.db.addPool(ctx, pool)
 
 	ds.poolsLock.Unlock()
 
 	if err != nil {
 		// lock must not be held when calling.
-		_ = ds.DeletePool(pool.ID)
+		_ = ds.DeletePool(ctx, pool.ID)
 	}
 
 	return errors.Wrap(err, "error adding pool to database")
 }
 
 // DeletePool will delete an unused pool from our datastore.
-func (ds *Datastore) DeletePool(ID string) error {
+func (ds *Datastore) DeletePool(ctx context.Context, ID string) error {
 	ds.poolsLock.Lock()
 	defer ds.poolsLock.Unlock()
 
@@ -2106,7 +3379,7 @@ func (ds *Datastore) DeletePool(ID string) error {
 	}
 
 	// delete from persistent store
-	err := errors.Wrapf(ds.db.deletePool(ID), "error deleting pool (%v) from database", ID)
+	err := errors.Wrapf(ds.db.deletePool(ctx, ID), "error deleting pool (%v) from database", ID)
 
 	// delete all subnets
 	for _, subnet := range p.Subnets {
@@ -2125,7 +3398,7 @@ func (ds *Datastore) DeletePool(ID string) error {
 }
 
 // AddExternalSubnet will add a new subnet to an existing pool.
-func (ds *Datastore) AddExternalSubnet(poolID string, subnet string) error {
+func (ds *Datastore) AddExternalSubnet(ctx context.Context, poolID string, subnet string) error {
 	sub := types.ExternalSubnet{
 		ID:   uuid.Generate().String(),
 		CIDR: subnet,
@@ -2160,7 +3433,7 @@ func (ds *Datastore) AddExternalSubnet(poolID string, subnet string) error {
 	p.Free += newIPs
 	p.Subnets = append(p.Subnets, sub)
 
-	err = ds.db.updatePool(p)
+	err = ds.db.updatePool(ctx, p)
 	if err != nil {
 		return errors.Wrap(err, "error updating pool in database")
 	}
@@ -2173,7 +3446,7 @@ func (ds *Datastore) AddExternalSubnet(poolID string, subnet string) error {
 }
 
 // AddExternalIPs will add a list of individual IPs to an existing pool.
-func (ds *Datastore) AddExternalIPs(poolID string, IPs []string) error {
+func (ds *Datastore) AddExternalIPs(ctx context.Context, poolID string, IPs []string) error {
 	ds.poolsLock.Lock()
 	defer ds.poolsLock.Unlock()
 
@@ -2213,7 +3486,7 @@ func (ds *Datastore) AddExternalIPs(poolID string, IPs []string) error {
 	}
 
 	// update persistent store.
-	err := ds.db.updatePool(p)
+	err := ds.db.updatePool(ctx, p)
 	if err != nil {
 		return errors.Wrap(err, "error updating pool in database")
 	}
@@ -2228,7 +3501,7 @@ func (ds *Datastore) AddExternalIPs(poolID string, IPs []string) error {
 }
 
 // DeleteSubnet will remove an unused subnet from an existing pool.
-func (ds *Datastore) DeleteSubnet(poolID string, subnetID string) error {
+func (ds *Datastore) DeleteSubnet(ctx context.Context, poolID string, subnetID string) error {
 	ds.poolsLock.Lock()
 	defer ds.poolsLock.Unlock()
 
@@ -2262,7 +3535,7 @@ func (ds *Datastore) DeleteSubnet(poolID string, subnetID string) error {
 		p.Free -= numIPs
 		p.Subnets = append(p.Subnets[:i], p.Subnets[i+1:]...)
 
-		err = ds.db.updatePool(p)
+		err = ds.db.updatePool(ctx, p)
 		if err != nil {
 			return errors.Wrap(err, "error updating pool in database")
 		}
@@ -2277,7 +3550,7 @@ func (ds *Datastore) DeleteSubnet(poolID string, subnetID string) error {
 }
 
 // DeleteExternalIP will remove an individual IP address from a pool.
-func (ds *Datastore) DeleteExternalIP(poolID string, addrID string) error {
+func (ds *Datastore) DeleteExternalIP(ctx context.Context, poolID string, addrID string) error {
 	ds.poolsLock.Lock()
 	defer ds.poolsLock.Unlock()
 
@@ -2302,7 +3575,7 @@ func (ds *Datastore) DeleteExternalIP(poolID string, addrID string) error {
 		p.Free--
 		p.IPs = append(p.IPs[:i], p.IPs[i+1:]...)
 
-		err := ds.db.updatePool(p)
+		err := ds.db.updatePool(ctx, p)
 		if err != nil {
 			return errors.Wrap(err, "error updating pool in database")
 		}
@@ -2358,7 +3631,7 @@ func (ds *Datastore) GetMappedIP(address string) (types.MappedIP, error) {
 }
 
 // MapExternalIP will allocate an external IP to an instance from a given pool.
-func (ds *Datastore) MapExternalIP(poolID string, instanceID string) (types.MappedIP, error) {
+func (ds *Datastore) MapExternalIP(ctx context.Context, poolID string, instanceID string) (types.MappedIP, error) {
 	var m types.MappedIP
 
 	instance, err := ds.GetInstance(instanceID)
@@ -2404,13 +3677,13 @@ func (ds *Datastore) MapExternalIP(poolID string, instanceID string) (types.Mapp
 
 				pool.Free--
 
-				err = ds.db.addMappedIP(m)
+				err = ds.db.addMappedIP(ctx, m)
 				if err != nil {
 					return types.MappedIP{}, errors.Wrap(err, "error adding IP mapping to database")
 				}
 				ds.mappedIPs[IP.String()] = m
 
-				err = ds.db.updatePool(pool)
+				err = ds.db.updatePool(ctx, pool)
 				if err != nil {
 					return types.MappedIP{}, errors.Wrap(err, "error updating pool in database")
 				}
@@ -2436,13 +3709,13 @@ func (ds *Datastore) MapExternalIP(poolID string, instanceID string) (types.Mapp
 
 			pool.Free--
 
-			err = ds.db.addMappedIP(m)
+			err = ds.db.addMappedIP(ctx, m)
 			if err != nil {
 				return types.MappedIP{}, errors.Wrap(err, "error adding IP mapping to database")
 			}
 			ds.mappedIPs[IP.Address] = m
 
-			err = ds.db.updatePool(pool)
+			err = ds.db.updatePool(ctx, pool)
 			if err != nil {
 				return types.MappedIP{}, errors.Wrap(err, "error updating pool in database")
 			}
@@ -2459,7 +3732,7 @@ func (ds *Datastore) MapExternalIP(poolID string, instanceID string) (types.Mapp
 }
 
 // UnMapExternalIP will stop associating a given address with an instance.
-func (ds *Datastore) UnMapExternalIP(address string) error {
+func (ds *Datastore) UnMapExternalIP(ctx context.Context, address string) error {
 	ds.poolsLock.Lock()
 	defer ds.poolsLock.Unlock()
 
@@ -2476,13 +3749,13 @@ func (ds *Datastore) UnMapExternalIP(address string) error {
 
 	pool.Free++
 
-	err := ds.db.deleteMappedIP(m.ID)
+	err := ds.db.deleteMappedIP(ctx, m.ID)
 	if err != nil {
 		return errors.Wrap(err, "error deleting IP mapping from database")
 	}
 	delete(ds.mappedIPs, address)
 
-	err = ds.db.updatePool(pool)
+	err = ds.db.updatePool(ctx, pool)
 	if err != nil {
 		return errors.Wrap(err, "error updating pool in database")
 	}
@@ -2493,7 +3766,8 @@ func (ds *Datastore) UnMapExternalIP(address string) error {
 }
 
 // GenerateCNCIWorkload is used to create a workload definition for the CNCI.
-// This function should be called prior to any workload launch.
+// This function should be called prior to any workload launch, and again
+// when the cluster configuration of the CNCIs changes.
 func (ds *Datastore) GenerateCNCIWorkload(vcpus int, memMB int, diskMB int, key string) {
 	// generate the CNCI workload.
 	config := `---
@@ -2517,8 +3791,18 @@ users:
 		Internal:   true,
 	}
 
+	ds.cnciWorkloadLock.Lock()
+	defer ds.cnciWorkloadLock.Unlock()
+
+	// the workload keeps its ID when it is regenerated so that the
+	// existing CNCIs still refer to it.
+	ID := ds.cnciWorkload.ID
+	if ID == "" {
+		ID = uuid.Generate().String()
+	}
+
 	wl := types.Workload{
-		ID:          uuid.Generate().String(),
+		ID:          ID,
 		Description: "CNCI",
 		FWType:      string(payloads.EFI),
 		VMType:      payloads.QEMU,
@@ -2536,14 +3820,65 @@ users:
 	ds.cnciWorkload = wl
 }
 
+// validateCNCIFlavor checks that none of the sizes of a CNCI flavor are
+// negative.
+func validateCNCIFlavor(flavor types.CNCIFlavor) error {
+	if flavor.VCPUs < 0 || flavor.MemMB < 0 || flavor.DiskGiB < 0 {
+		return types.ErrInvalidCNCIFlavor
+	}
+
+	return nil
+}
+
+// GetCNCIWorkload returns the workload the CNCIs of a tenant are started
+// from, the global CNCI workload sized according to the CNCI flavor of
+// the tenant.
+func (ds *Datastore) GetCNCIWorkload(tenantID string) (types.Workload, error) {
+	ds.tenantsLock.RLock()
+	t, ok := ds.tenants[tenantID]
+	var flavor types.CNCIFlavor
+	if ok {
+		flavor = t.CNCIFlavor
+	}
+	ds.tenantsLock.RUnlock()
+
+	if !ok {
+		return types.Workload{}, ErrNoTenant
+	}
+
+	ds.cnciWorkloadLock.RLock()
+	wl := ds.cnciWorkload
+	ds.cnciWorkloadLock.RUnlock()
+
+	if flavor.VCPUs > 0 {
+		wl.Requirements.VCPUs = flavor.VCPUs
+	}
+
+	if flavor.MemMB > 0 {
+		wl.Requirements.MemMB = flavor.MemMB
+	}
+
+	// the storage is copied so that the global workload is unchanged.
+	wl.Storage = append([]types.StorageResource(nil), wl.Storage...)
+	if flavor.DiskGiB > 0 {
+		for i := range wl.Storage {
+			if wl.Storage[i].Bootable {
+				wl.Storage[i].Size = flavor.DiskGiB
+			}
+		}
+	}
+
+	return wl, nil
+}
+
 // GetQuotas returns the set of quotas from the database without any caching.
-func (ds *Datastore) GetQuotas(tenantID string) ([]types.QuotaDetails, error) {
-	return ds.db.getQuotas(tenantID)
+func (ds *Datastore) GetQuotas(ctx context.Context, tenantID string) ([]types.QuotaDetails, error) {
+	return ds.db.getQuotas(ctx, tenantID)
 }
 
 // UpdateQuotas updates the quotas for a tenant in the database.
-func (ds *Datastore) UpdateQuotas(tenantID string, qds []types.QuotaDetails) error {
-	return ds.db.updateQuotas(tenantID, qds)
+func (ds *Datastore) UpdateQuotas(ctx context.Context, tenantID string, qds []types.QuotaDetails) error {
+	return ds.db.updateQuotas(ctx, tenantID, qds)
 }
 
 // ResolveInstance maps an instance name to an uuid, returning "" if not found
@@ -2567,7 +3902,7 @@ func (ds *Datastore) ResolveInstance(tenantID string, name string) (string, erro
 }
 
 // AddImage adds an image to the datastore and database
-func (ds *Datastore) AddImage(i types.Image) error {
+func (ds *Datastore) AddImage(ctx context.Context, i types.Image) error {
 	ds.imageLock.Lock()
 	defer ds.imageLock.Unlock()
 	if _, ok := ds.images[i.ID]; ok {
@@ -2588,7 +3923,7 @@ func (ds *Datastore) AddImage(i types.Image) error {
 		return err
 	}
 
-	err = ds.db.updateImage(i)
+	err = ds.db.updateImage(ctx, i)
 	if err != nil {
 		return errors.Wrap(err, "Unable to add image to database")
 	}
@@ -2597,7 +3932,7 @@ func (ds *Datastore) AddImage(i types.Image) error {
 		ds.tenantsLock.Lock()
 		if _, ok := ds.tenants[i.TenantID]; !ok {
 			ds.tenantsLock.Unlock()
-			_ = ds.db.deleteImage(i.ID)
+			_ = ds.db.deleteImage(ctx, i.ID)
 			return types.ErrTenantNotFound
 		}
 
@@ -2619,7 +3954,7 @@ func (ds *Datastore) AddImage(i types.Image) error {
 }
 
 // UpdateImage updates the image metadate in the datastore and database
-func (ds *Datastore) UpdateImage(i types.Image) error {
+func (ds *Datastore) UpdateImage(ctx context.Context, i types.Image) error {
 	ds.imageLock.Lock()
 	defer ds.imageLock.Unlock()
 
@@ -2642,7 +3977,7 @@ func (ds *Datastore) UpdateImage(i types.Image) error {
 		}
 	}
 
-	if err := ds.db.updateImage(i); err != nil {
+	if err := ds.db.updateImage(ctx, i); err != nil {
 		return errors.Wrap(err, "Error updating image in database")
 	}
 
@@ -2785,3 +4120,285 @@ func (ds *Datastore) DeleteImage(ID string) error {
 
 	return nil
 }
+
+// operationRetention is how long an operation is kept after its last
+// update.
+const operationRetention = 24 * time.Hour
+
+// AddOperation stores a new operation record in the datastore.  The
+// operations which have not been updated for operationRetention are
+// dropped.
+func (ds *Datastore) AddOperation(op types.Operation) error {
+	ds.operationsLock.Lock()
+	defer ds.operationsLock.Unlock()
+
+	_, ok := ds.operations[op.ID]
+	if ok {
+		return errors.New("Duplicate Operation ID")
+	}
+
+	ds.pruneOperations(op.CreateTime.Add(-operationRetention))
+
+	ds.operations[op.ID] = op
+	ds.resourceOperations[op.ResourceID] = append(ds.resourceOperations[op.ResourceID], op.ID)
+
+	return nil
+}
+
+// pruneOperations drops the operations last updated before a given time.
+// It must be called with the operations lock held.
+func (ds *Datastore) pruneOperations(before time.Time) {
+	for ID, op := range ds.operations {
+		if !op.UpdateTime.Before(before) {
+			continue
+		}
+
+		delete(ds.operations, ID)
+
+		var IDs []string
+		for _, opID := range ds.resourceOperations[op.ResourceID] {
+			if opID != ID {
+				IDs = append(IDs, opID)
+			}
+		}
+
+		if len(IDs) == 0 {
+			delete(ds.resourceOperations, op.ResourceID)
+		} else {
+			ds.resourceOperations[op.ResourceID] = IDs
+		}
+	}
+}
+
+// UpdateOperation replaces an existing operation record in the datastore.
+func (ds *Datastore) UpdateOperation(op types.Operation) error {
+	ds.operationsLock.Lock()
+	defer ds.operationsLock.Unlock()
+
+	_, ok := ds.operations[op.ID]
+	if !ok {
+		return types.ErrOperationNotFound
+	}
+
+	ds.operations[op.ID] = op
+
+	return nil
+}
+
+// GetOperation retrieves an operation record from the datastore.
+func (ds *Datastore) GetOperation(ID string) (types.Operation, error) {
+	ds.operationsLock.RLock()
+	defer ds.operationsLock.RUnlock()
+
+	op, ok := ds.operations[ID]
+	if !ok {
+		return types.Operation{}, types.ErrOperationNotFound
+	}
+
+	return op, nil
+}
+
+// GetOperations retrieves all the operations of a tenant. If tenantID is
+// empty, the operations of all tenants are returned.
+func (ds *Datastore) GetOperations(tenantID string) []types.Operation {
+	var ops []types.Operation
+
+	ds.operationsLock.RLock()
+	defer ds.operationsLock.RUnlock()
+
+	for _, op := range ds.operations {
+		if tenantID == "" || op.TenantID == tenantID {
+			ops = append(ops, op)
+		}
+	}
+
+	return ops
+}
+
+// GetResourceOperations retrieves all the operations of a given type
+// tracking an action on a given resource, in the order they were added.
+func (ds *Datastore) GetResourceOperations(resourceID string, opType types.OperationType) []types.Operation {
+	var ops []types.Operation
+
+	ds.operationsLock.RLock()
+	defer ds.operationsLock.RUnlock()
+
+	for _, ID := range ds.resourceOperations[resourceID] {
+		op := ds.operations[ID]
+		if op.Type == opType {
+			ops = append(ops, op)
+		}
+	}
+
+	return ops
+}
+
+// maxInstanceActions is the number of state transitions kept for each
+// instance.
+const maxInstanceActions = 50
+
+// SetInstanceActionCause records who initiated, and why, the next state
+// transition of an instance.  It is used when the transition is requested
+// by the controller but only reported later by the cluster.
+func (ds *Datastore) SetInstanceActionCause(instanceID string, initiator types.InstanceActionInitiator, reason types.InstanceActionReason) {
+	ds.instanceActionsLock.Lock()
+	ds.instanceActionCauses[instanceID] = types.InstanceAction{
+		Initiator: initiator,
+		Reason:    reason,
+	}
+	ds.instanceActionsLock.Unlock()
+}
+
+// addInstanceAction appends a state transition to the history of an
+// instance.  The transition is attributed to the cause recorded by
+// SetInstanceActionCause if there is one, or to the system for the
+// provided reason otherwise.
+func (ds *Datastore) addInstanceAction(instanceID string, from string, to string, reason types.InstanceActionReason) {
+	if from == to {
+		return
+	}
+
+	ds.instanceActionsLock.Lock()
+	defer ds.instanceActionsLock.Unlock()
+
+	action, ok := ds.instanceActionCauses[instanceID]
+	if ok {
+		delete(ds.instanceActionCauses, instanceID)
+	} else {
+		action.Initiator = types.InitiatorSystem
+		action.Reason = reason
+	}
+
+	action.InstanceID = instanceID
+	action.PreviousState = from
+	action.State = to
+	action.Timestamp = time.Now()
+
+	actions := append(ds.instanceActions[instanceID], action)
+	if len(actions) > maxInstanceActions {
+		actions = actions[len(actions)-maxInstanceActions:]
+	}
+	ds.instanceActions[instanceID] = actions
+}
+
+func (ds *Datastore) deleteInstanceActions(instanceID string) {
+	ds.instanceActionsLock.Lock()
+	delete(ds.instanceActions, instanceID)
+	delete(ds.instanceActionCauses, instanceID)
+	ds.instanceActionsLock.Unlock()
+}
+
+// GetInstanceActions retrieves the state transitions of an instance, the
+// oldest first.
+func (ds *Datastore) GetInstanceActions(instanceID string) []types.InstanceAction {
+	ds.instanceActionsLock.RLock()
+	defer ds.instanceActionsLock.RUnlock()
+
+	actions := make([]types.InstanceAction, len(ds.instanceActions[instanceID]))
+	copy(actions, ds.instanceActions[instanceID])
+
+	return actions
+}
+
+// AddAPIToken stores an API token.
+func (ds *Datastore) AddAPIToken(ctx context.Context, token types.APIToken) error {
+	return ds.db.addAPIToken(ctx, token)
+}
+
+// DeleteAPIToken removes an API token.
+func (ds *Datastore) DeleteAPIToken(ctx context.Context, ID string) error {
+	return ds.db.deleteAPIToken(ctx, ID)
+}
+
+// GetAPIToken retrieves an API token by ID.
+func (ds *Datastore) GetAPIToken(ctx context.Context, ID string) (types.APIToken, error) {
+	return ds.db.getAPIToken(ctx, ID)
+}
+
+// GetAPITokens retrieves the API tokens of a tenant, sorted by creation
+// time.
+func (ds *Datastore) GetAPITokens(ctx context.Context, tenantID string) ([]types.APIToken, error) {
+	return ds.db.getAPITokens(ctx, tenantID)
+}
+
+// AddFederationPeer stores a federation peer.
+func (ds *Datastore) AddFederationPeer(ctx context.Context, peer types.FederationPeer) error {
+	return ds.db.addFederationPeer(ctx, peer)
+}
+
+// DeleteFederationPeer removes a federation peer.
+func (ds *Datastore) DeleteFederationPeer(ctx context.Context, ID string) error {
+	return ds.db.deleteFederationPeer(ctx, ID)
+}
+
+// GetFederationPeers retrieves the federation peers, sorted by
+// registration time.
+func (ds *Datastore) GetFederationPeers(ctx context.Context) ([]types.FederationPeer, error) {
+	return ds.db.getFederationPeers(ctx)
+}
+
+// GetFederationPeer retrieves a federation peer by ID.
+func (ds *Datastore) GetFederationPeer(ctx context.Context, ID string) (types.FederationPeer, error) {
+	peers, err := ds.db.getFederationPeers(ctx)
+	if err != nil {
+		return types.FederationPeer{}, err
+	}
+
+	for _, peer := range peers {
+		if peer.ID == ID {
+			return peer, nil
+		}
+	}
+
+	return types.FederationPeer{}, types.ErrPeerNotFound
+}
+
+// AddSnapshotSet stores a snapshot set.
+func (ds *Datastore) AddSnapshotSet(ctx context.Context, set types.SnapshotSet) error {
+	return ds.db.addSnapshotSet(ctx, set)
+}
+
+// UpdateSnapshotSet updates the state and the volumes of a snapshot set.
+func (ds *Datastore) UpdateSnapshotSet(ctx context.Context, set types.SnapshotSet) error {
+	return ds.db.updateSnapshotSet(ctx, set)
+}
+
+// DeleteSnapshotSet removes a snapshot set.
+func (ds *Datastore) DeleteSnapshotSet(ctx context.Context, ID string) error {
+	return ds.db.deleteSnapshotSet(ctx, ID)
+}
+
+// GetSnapshotSets retrieves the snapshot sets of a tenant, sorted by
+// creation time.
+func (ds *Datastore) GetSnapshotSets(ctx context.Context, tenantID string) ([]types.SnapshotSet, error) {
+	return ds.db.getSnapshotSets(ctx, tenantID)
+}
+
+// GetSnapshotSet retrieves a snapshot set of a tenant by ID.
+func (ds *Datastore) GetSnapshotSet(ctx context.Context, tenantID string, ID string) (types.SnapshotSet, error) {
+	sets, err := ds.db.getSnapshotSets(ctx, tenantID)
+	if err != nil {
+		return types.SnapshotSet{}, err
+	}
+
+	for _, set := range sets {
+		if set.ID == ID {
+			return set, nil
+		}
+	}
+
+	return types.SnapshotSet{}, types.ErrSnapshotSetNotFound
+}
+
+// AddSignedRequest records the submission of a signed request.  It returns
+// types.ErrSignedRequestReplayed if a request with the same nonce has
+// already been recorded.
+func (ds *Datastore) AddSignedRequest(ctx context.Context, rec types.SignedRequestRecord) error {
+	return ds.db.addSignedRequest(ctx, rec)
+}
+
+// GetSignedRequests retrieves the records of the signed requests submitted,
+// oldest first.
+func (ds *Datastore) GetSignedRequests(ctx context.Context) ([]types.SignedRequestRecord, error) {
+	return ds.db.getSignedRequests(ctx)
+}
@@ -17,15 +17,19 @@
 package datastore
 
 import (
+	"context"
 	"database/sql"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"net"
 	"os"
 	"reflect"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -41,7 +45,7 @@ import (
 func addInstance(tenant *types.Tenant, workload types.Workload, name string) (instance *types.Instance, err error) {
 	id := uuid.Generate()
 
-	ip, err := ds.AllocateTenantIP(tenant.ID)
+	ip, err := ds.AllocateTenantIP(ctx, tenant.ID)
 	if err != nil {
 		return
 	}
@@ -66,7 +70,7 @@ func addInstance(tenant *types.Tenant, workload types.Workload, name string) (in
 		Name:       name,
 	}
 
-	err = ds.AddInstance(instance)
+	err = ds.AddInstance(ctx, instance)
 	if err != nil {
 		return
 	}
@@ -126,7 +130,7 @@ users:
 		Storage: []types.StorageResource{storage},
 	}
 
-	return ds.AddWorkload(wl)
+	return ds.AddWorkload(ctx, wl)
 }
 
 func addTestTenant() (tenant *types.Tenant, err error) {
@@ -138,7 +142,7 @@ func addTestTenant() (tenant *types.Tenant, err error) {
 		SubnetBits: 24,
 	}
 
-	tenant, err = ds.AddTenant(tuuid.String(), config)
+	tenant, err = ds.AddTenant(ctx, tuuid.String(), config)
 	if err != nil {
 		return
 	}
@@ -158,7 +162,7 @@ func addTestTenant() (tenant *types.Tenant, err error) {
 		MACAddress: mac.String(),
 	}
 
-	err = ds.AddInstance(&CNCI)
+	err = ds.AddInstance(ctx, &CNCI)
 	if err != nil {
 		return
 	}
@@ -214,12 +218,12 @@ func addTestInstanceStats(t *testing.T) ([]*types.Instance, payloads.Stat) {
 		Instances:       stats,
 	}
 
-	err = ds.addNodeStat(stat)
+	err = ds.addNodeStat(ctx, stat)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = ds.addInstanceStats(stats, stat.NodeUUID)
+	err = ds.addInstanceStats(ctx, stats, stat.NodeUUID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -235,14 +239,14 @@ func BenchmarkGetTenantNoCache(b *testing.B) {
 		SubnetBits: 24,
 	}
 
-	_, err := ds.AddTenant(tuuid, config)
+	_, err := ds.AddTenant(ctx, tuuid, config)
 	if err != nil {
 		b.Error(err)
 	}
 
 	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
-		_, err = ds.db.getTenant(tuuid)
+		_, err = ds.db.getTenant(ctx, tuuid)
 		if err != nil {
 			b.Error(err)
 		}
@@ -257,14 +261,14 @@ func BenchmarkAllocateTenantIP(b *testing.B) {
 		SubnetBits: 24,
 	}
 
-	_, err := ds.AddTenant(tuuid, config)
+	_, err := ds.AddTenant(ctx, tuuid, config)
 	if err != nil {
 		b.Error(err)
 	}
 
 	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
-		_, err = ds.AllocateTenantIP(tuuid)
+		_, err = ds.AllocateTenantIP(ctx, tuuid)
 		if err != nil {
 			b.Error(err)
 		}
@@ -279,20 +283,20 @@ func BenchmarkAllocate1000TenantIP(b *testing.B) {
 		SubnetBits: 24,
 	}
 
-	_, err := ds.AddTenant(tuuid, config)
+	_, err := ds.AddTenant(ctx, tuuid, config)
 	if err != nil {
 		b.Error(err)
 	}
 
 	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
-		addrs, err := ds.AllocateTenantIPPool(tuuid, 1000)
+		addrs, err := ds.AllocateTenantIPPool(ctx, tuuid, 1000)
 		if err != nil {
 			b.Fatal(err)
 		}
 		b.StopTimer()
 		for _, ip := range addrs {
-			err = ds.ReleaseTenantIP(tuuid, ip.String())
+			err = ds.ReleaseTenantIP(ctx, tuuid, ip.String())
 			if err != nil {
 				b.Error(err)
 			}
@@ -318,11 +322,11 @@ func TestTenantCreate(t *testing.T) {
 		SubnetBits: 24,
 	}
 
-	_, err := ds.AddTenant(tuuid.String(), config)
+	_, err := ds.AddTenant(ctx, tuuid.String(), config)
 	if err != nil {
 		t.Fatal(err)
 	}
-	tenant, err := ds.GetTenant(tuuid.String())
+	tenant, err := ds.GetTenant(ctx, tuuid.String())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -372,12 +376,12 @@ func TestDeleteInstanceNetwork(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = ds.DeleteInstance(instance.ID)
+	err = ds.DeleteInstance(ctx, instance.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	tenantAfter, err := ds.getTenant(tenant.ID)
+	tenantAfter, err := ds.getTenant(ctx, tenant.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -401,7 +405,7 @@ func TestDeleteInstanceNetwork(t *testing.T) {
 	ds.tenantsLock.Unlock()
 
 	// get updated tenant info - should hit database
-	newTenant, err := ds.getTenant(tenant.ID)
+	newTenant, err := ds.getTenant(ctx, tenant.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -599,7 +603,7 @@ func TestHandleStats(t *testing.T) {
 		Instances:       stats,
 	}
 
-	err = ds.HandleStats(stat)
+	err = ds.HandleStats(ctx, stat)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -622,6 +626,73 @@ func TestHandleStats(t *testing.T) {
 	}
 }
 
+func TestHandleStatsVolumeUsage(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volume := types.Volume{
+		BlockDevice: storage.BlockDevice{
+			ID:   uuid.Generate().String(),
+			Size: 10,
+		},
+		State:      types.InUse,
+		TenantID:   tenant.ID,
+		CreateTime: time.Now(),
+	}
+
+	err = ds.AddBlockDevice(ctx, volume)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stat := payloads.Stat{
+		NodeUUID: uuid.Generate().String(),
+		Load:     -1,
+		Instances: []payloads.InstanceStat{
+			{
+				InstanceUUID: uuid.Generate().String(),
+				State:        payloads.ComputeStatusRunning,
+				Volumes:      []string{volume.ID},
+				VolumeUsage: []payloads.VolumeStat{
+					{
+						VolumeUUID: volume.ID,
+						UsedMB:     1234,
+					},
+					{
+						VolumeUUID: uuid.Generate().String(),
+						UsedMB:     10,
+					},
+				},
+			},
+		},
+	}
+
+	err = ds.HandleStats(ctx, stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vol, err := ds.GetBlockDevice(volume.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.UsedMB != 1234 {
+		t.Fatalf("Expected 1234MB used, got %d", vol.UsedMB)
+	}
+
+	devices, err := ds.GetBlockDevices(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(devices) != 1 || devices[0].UsedMB != 1234 {
+		t.Fatalf("Volume usage not updated for tenant: %+v", devices)
+	}
+}
+
 func TestGetInstanceLastStats(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
@@ -668,7 +739,7 @@ func TestGetInstanceLastStats(t *testing.T) {
 		Instances:       stats,
 	}
 
-	err = ds.HandleStats(stat)
+	err = ds.HandleStats(ctx, stat)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -678,6 +749,22 @@ func TestGetInstanceLastStats(t *testing.T) {
 	if len(serverStats.Servers) != len(instances) {
 		t.Fatal("Not enough instance stats retrieved")
 	}
+
+	serverStats = ds.GetTenantInstanceLastStats(tenant.ID)
+
+	if serverStats.TotalServers != len(instances) || len(serverStats.Servers) != len(instances) {
+		t.Fatalf("Expected %d tenant instance stats, got %d", len(instances), len(serverStats.Servers))
+	}
+
+	for i, s := range serverStats.Servers {
+		if s.TenantID != tenant.ID || s.Status != payloads.ComputeStatusRunning {
+			t.Errorf("Unexpected tenant instance stat %+v", s)
+		}
+
+		if i > 0 && serverStats.Servers[i-1].ID >= s.ID {
+			t.Errorf("Tenant instance stats not sorted")
+		}
+	}
 }
 
 func TestGetNodeLastStats(t *testing.T) {
@@ -726,7 +813,7 @@ func TestGetNodeLastStats(t *testing.T) {
 		Instances:       stats,
 	}
 
-	err = ds.HandleStats(stat)
+	err = ds.HandleStats(ctx, stat)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -772,12 +859,12 @@ func TestGetBatchFrameStatistics(t *testing.T) {
 		Frames: createTestFrameTraces("batch_frame_test"),
 	}
 
-	err := ds.HandleTraceReport(trace)
+	err := ds.HandleTraceReport(ctx, trace)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = ds.db.getBatchFrameStatistics("batch_frame_test")
+	_, err = ds.db.getBatchFrameStatistics(ctx, "batch_frame_test")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -788,12 +875,12 @@ func TestGetBatchFrameSummary(t *testing.T) {
 		Frames: createTestFrameTraces("batch_summary_test"),
 	}
 
-	err := ds.HandleTraceReport(trace)
+	err := ds.HandleTraceReport(ctx, trace)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = ds.db.getBatchFrameSummary()
+	_, err = ds.db.getBatchFrameSummary(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -805,12 +892,12 @@ func TestGetEventLog(t *testing.T) {
 		EventType: "info",
 		Message:   "this is a test",
 	}
-	err := ds.db.logEvent(e)
+	err := ds.db.logEvent(ctx, e)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = ds.db.getEventLog()
+	_, err = ds.db.getEventLog(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -822,14 +909,52 @@ func TestLogEvent(t *testing.T) {
 		EventType: "info",
 		Message:   "this is a test",
 	}
-	err := ds.db.logEvent(e)
+	err := ds.db.logEvent(ctx, e)
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
+func TestWatchEvents(t *testing.T) {
+	events, stop := ds.WatchEvents()
+	slow, _ := ds.WatchEvents()
+
+	err := ds.LogEvent(ctx, "test-tenantID", "watched event")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := <-events
+	if e.TenantID != "test-tenantID" || e.Message != "watched event" ||
+		e.EventType != string(userInfo) || e.Timestamp.IsZero() {
+		t.Fatalf("Unexpected event %+v", e)
+	}
+
+	for i := 0; i < eventWatcherBacklog; i++ {
+		err = ds.LogError(ctx, "test-tenantID", "flood")
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-events
+	}
+
+	// the slow watcher has a full backlog and is dropped.
+	for i := 0; i < eventWatcherBacklog; i++ {
+		<-slow
+	}
+	if _, ok := <-slow; ok {
+		t.Fatal("Expected slow event watcher to be dropped")
+	}
+
+	stop()
+	if _, ok := <-events; ok {
+		t.Fatal("Expected event watcher channel to be closed")
+	}
+	stop()
+}
+
 func TestClearLog(t *testing.T) {
-	err := ds.db.clearLog()
+	err := ds.db.clearLog(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -837,7 +962,7 @@ func TestClearLog(t *testing.T) {
 
 func TestAddFrameStat(t *testing.T) {
 	stat := createTestFrameTraces("test")[0]
-	err := ds.db.addFrameStat(stat)
+	err := ds.db.addFrameStat(ctx, stat)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -861,7 +986,7 @@ func TestAddInstanceStats(t *testing.T) {
 
 	nodeID := uuid.Generate().String()
 
-	err := ds.addInstanceStats(stats, nodeID)
+	err := ds.addInstanceStats(ctx, stats, nodeID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -894,7 +1019,7 @@ func TestAddNodeStats(t *testing.T) {
 		Instances:       stats,
 	}
 
-	err := ds.addNodeStat(stat)
+	err := ds.addNodeStat(ctx, stat)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -907,13 +1032,13 @@ func TestAllocateTenantIP(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	ip, err := ds.AllocateTenantIP(tenant.ID)
+	ip, err := ds.AllocateTenantIP(ctx, tenant.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// this should hit cache
-	newTenant, err := ds.getTenant(tenant.ID)
+	newTenant, err := ds.getTenant(ctx, tenant.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -938,7 +1063,7 @@ func TestAllocateTenantIP(t *testing.T) {
 	ds.tenantsLock.Unlock()
 
 	// this should not hit cache
-	newTenant, err = ds.getTenant(tenant.ID)
+	newTenant, err = ds.getTenant(ctx, tenant.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -961,7 +1086,7 @@ func TestGetTenant(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	testTenant, err := ds.GetTenant(tenant.ID)
+	testTenant, err := ds.GetTenant(ctx, tenant.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -988,7 +1113,7 @@ func TestUpdateTenant(t *testing.T) {
 		SubnetBits: 24,
 	}
 
-	tenant, err := ds.AddTenant(tuuid.String(), initConfig)
+	tenant, err := ds.AddTenant(ctx, tuuid.String(), initConfig)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1018,12 +1143,12 @@ func TestUpdateTenant(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = ds.JSONPatchTenant(tenant.ID, merge)
+	err = ds.JSONPatchTenant(ctx, tenant.ID, merge)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	testTenant, err := ds.GetTenant(tenant.ID)
+	testTenant, err := ds.GetTenant(ctx, tenant.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1033,29 +1158,316 @@ func TestUpdateTenant(t *testing.T) {
 	}
 }
 
+func TestUpdateTenantSubnetBits(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.JSONPatchTenant(ctx, tenant.ID, []byte(`{"subnet_bits":31}`))
+	if err != types.ErrInvalidSubnetBits {
+		t.Fatalf("Expected ErrInvalidSubnetBits, got %v", err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatal(err)
+	}
+
+	_, err = addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.JSONPatchTenant(ctx, tenant.ID, []byte(`{"subnet_bits":26}`))
+	if err != types.ErrTenantHasInstances {
+		t.Fatalf("Expected ErrTenantHasInstances, got %v", err)
+	}
+
+	testTenant, err := ds.GetTenant(ctx, tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if testTenant.SubnetBits != 24 {
+		t.Fatal("Subnet size changed despite instances")
+	}
+}
+
+func TestTenantCNCIFlavor(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wl, err := ds.GetCNCIWorkload(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wl.ID != ds.cnciWorkload.ID || wl.Requirements.VCPUs != 4 || wl.Requirements.MemMB != 128 {
+		t.Fatalf("Unexpected CNCI workload without flavor: %+v", wl.Requirements)
+	}
+
+	err = ds.JSONPatchTenant(ctx, tenant.ID, []byte(`{"cnci_flavor":{"mem_mb":-1}}`))
+	if err != types.ErrInvalidCNCIFlavor {
+		t.Fatalf("Expected ErrInvalidCNCIFlavor, got %v", err)
+	}
+
+	err = ds.JSONPatchTenant(ctx, tenant.ID, []byte(`{"cnci_flavor":{"vcpus":8,"disk_gib":20}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wl, err = ds.GetCNCIWorkload(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wl.Requirements.VCPUs != 8 || wl.Requirements.MemMB != 128 ||
+		len(wl.Storage) != 1 || wl.Storage[0].Size != 20 {
+		t.Fatalf("CNCI flavor not applied: %+v %+v", wl.Requirements, wl.Storage)
+	}
+
+	if ds.cnciWorkload.Requirements.VCPUs != 4 || ds.cnciWorkload.Storage[0].Size != 0 {
+		t.Fatal("Global CNCI workload modified")
+	}
+
+	_, err = ds.GetCNCIWorkload(uuid.Generate().String())
+	if err != ErrNoTenant {
+		t.Fatalf("Expected ErrNoTenant, got %v", err)
+	}
+
+	config := types.TenantConfig{SubnetBits: 24}
+	config.CNCIFlavor.VCPUs = -2
+	_, err = ds.AddTenant(ctx, uuid.Generate().String(), config)
+	if err != types.ErrInvalidCNCIFlavor {
+		t.Fatalf("Expected ErrInvalidCNCIFlavor, got %v", err)
+	}
+}
+
+func TestRenumberTenant(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatal(err)
+	}
+
+	instances, err := addTestInstances(tenant, wls[0], 70)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// with /26 subnets, x.x.x.63 becomes a broadcast address, x.x.x.64
+	// a network address and x.x.x.65 a gateway address.
+	conflicts, err := ds.RenumberTenant(ctx, tenant.ID, 26, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]bool{}
+	for _, i := range instances {
+		host := net.ParseIP(i.IPAddress).To4()[3]
+		if host >= 63 && host <= 65 {
+			expected[i.IPAddress] = true
+		}
+	}
+
+	if len(conflicts) != len(expected) {
+		t.Fatalf("Expected %d conflicts, got %d", len(expected), len(conflicts))
+	}
+
+	for _, c := range conflicts {
+		if !expected[c.IPAddress] || c.InstanceID == "" || c.Reserved {
+			t.Fatalf("Unexpected conflict %+v", c)
+		}
+	}
+
+	testTenant, err := ds.GetTenant(ctx, tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if testTenant.SubnetBits != 24 {
+		t.Fatal("Dry run changed the subnet size")
+	}
+
+	_, err = ds.RenumberTenant(ctx, tenant.ID, 26, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testTenant, err = ds.GetTenant(ctx, tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if testTenant.SubnetBits != 26 {
+		t.Fatal("Subnet size not changed")
+	}
+
+	// new addresses must not come from the /24 still used by the
+	// existing instances.
+	_, oldSubnet, err := net.ParseCIDR(instances[0].Subnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip, err := ds.AllocateTenantIP(ctx, tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if oldSubnet.Contains(ip) {
+		t.Fatalf("Allocated %s out of the old subnet %s", ip, oldSubnet)
+	}
+
+	_, err = ds.RenumberTenant(ctx, tenant.ID, 8, false)
+	if err != types.ErrInvalidSubnetBits {
+		t.Fatalf("Expected ErrInvalidSubnetBits, got %v", err)
+	}
+}
+
 func TestDeleteTenant(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = ds.DeleteTenant(tenant.ID)
+	err = ds.DeleteTenant(ctx, tenant.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	testTenant, err := ds.GetTenant(tenant.ID)
+	testTenant, err := ds.GetTenant(ctx, tenant.ID)
 	if err == nil || testTenant != nil {
 		t.Fatal("Tenant not deleted")
 	}
 }
 
+// testCNCIController is a CNCI controller which is never used.
+type testCNCIController struct {
+	types.CNCIController
+}
+
+func TestPrepareTenant(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.PrepareTenant(ctx, uuid.Generate().String(), time.Second,
+		func() (types.CNCIController, error) {
+			t.Error("Unknown tenant prepared")
+			return nil, nil
+		})
+	if err != ErrNoTenant {
+		t.Fatalf("Expected %v preparing an unknown tenant, got %v", ErrNoTenant, err)
+	}
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	errPrepare := errors.New("preparation failed")
+	result := make(chan error)
+
+	go func() {
+		result <- ds.PrepareTenant(ctx, tenant.ID, time.Second, func() (types.CNCIController, error) {
+			close(entered)
+			<-release
+			return nil, errPrepare
+		})
+	}()
+
+	<-entered
+
+	err = ds.PrepareTenant(ctx, tenant.ID, 10*time.Millisecond, func() (types.CNCIController, error) {
+		t.Error("Tenant prepared twice concurrently")
+		return nil, nil
+	})
+	if err != ErrTenantNotReady {
+		t.Fatalf("Expected %v waiting for a slow preparation, got %v", ErrTenantNotReady, err)
+	}
+
+	close(release)
+	if err := <-result; err != errPrepare {
+		t.Fatalf("Expected %v, got %v", errPrepare, err)
+	}
+
+	// the failed preparation is retried by the next caller.
+	ctrl := &testCNCIController{}
+	err = ds.PrepareTenant(ctx, tenant.ID, time.Second, func() (types.CNCIController, error) {
+		return ctrl, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.PrepareTenant(ctx, tenant.ID, time.Second, func() (types.CNCIController, error) {
+		t.Error("Ready tenant prepared")
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenant, err = ds.GetTenant(ctx, tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tenant.CNCIctrl != ctrl {
+		t.Fatal("CNCI controller of prepared tenant not set")
+	}
+}
+
+func TestPrepareTenantConcurrent(t *testing.T) {
+	const callers = 100
+
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var prepared int32
+	var wg sync.WaitGroup
+	errCh := make(chan error, callers)
+
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errCh <- ds.PrepareTenant(ctx, tenant.ID, 10*time.Second, func() (types.CNCIController, error) {
+				atomic.AddInt32(&prepared, 1)
+				time.Sleep(10 * time.Millisecond)
+				return &testCNCIController{}, nil
+			})
+		}()
+	}
+
+	wg.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if prepared != 1 {
+		t.Fatalf("Expected tenant to be prepared once, prepared %d times", prepared)
+	}
+}
+
 func TestHandleTraceReport(t *testing.T) {
 	trace := payloads.Trace{
 		Frames: createTestFrameTraces("test"),
 	}
 
-	err := ds.HandleTraceReport(trace)
+	err := ds.HandleTraceReport(ctx, trace)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1095,7 +1507,7 @@ func TestReleaseTenantIP(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	ip, err := ds.AllocateTenantIP(tenant.ID)
+	ip, err := ds.AllocateTenantIP(ctx, tenant.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1110,7 +1522,7 @@ func TestReleaseTenantIP(t *testing.T) {
 	subnetInt := hostInt & subMask
 
 	// get updated tenant info
-	newTenant, err := ds.getTenant(tenant.ID)
+	newTenant, err := ds.getTenant(ctx, tenant.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1120,13 +1532,13 @@ func TestReleaseTenantIP(t *testing.T) {
 		t.Fatal("IP Address not marked Used")
 	}
 
-	err = ds.ReleaseTenantIP(tenant.ID, ip.String())
+	err = ds.ReleaseTenantIP(ctx, tenant.ID, ip.String())
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// get updated tenant info - should hit cache
-	newTenant, err = ds.getTenant(tenant.ID)
+	newTenant, err = ds.getTenant(ctx, tenant.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1142,7 +1554,7 @@ func TestReleaseTenantIP(t *testing.T) {
 	ds.tenantsLock.Unlock()
 
 	// get updated tenant info - should hit database
-	newTenant, err = ds.getTenant(tenant.ID)
+	newTenant, err = ds.getTenant(ctx, tenant.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1151,34 +1563,278 @@ func TestReleaseTenantIP(t *testing.T) {
 	if newTenant.network[subnetInt][hostInt] != false {
 		t.Fatal("IP Address not released from database")
 	}
-}
+}
+
+func TestClaimTenantIP(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip := "172.16.0.10"
+	err = ds.ClaimTenantIP(ctx, tenant.ID, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// claiming twice should be harmless
+	err = ds.ClaimTenantIP(ctx, tenant.ID, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	IPs, err := ds.GetTenantIPs(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(IPs) != 1 || IPs[0].String() != ip {
+		t.Fatalf("Expected %s to be allocated, got %v", ip, IPs)
+	}
+
+	// the next allocation must not reuse the claimed address
+	newIP, err := ds.AllocateTenantIP(ctx, tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if newIP.String() == ip {
+		t.Fatal("Claimed IP address allocated twice")
+	}
+
+	err = ds.ClaimTenantIP(ctx, tenant.ID, "not an ip")
+	if err == nil {
+		t.Fatal("Invalid IP address claimed")
+	}
+}
+
+func TestReserveTenantIP(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip := "172.16.0.10"
+	err = ds.ReserveTenantIP(ctx, tenant.ID, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.ReserveTenantIP(ctx, tenant.ID, ip)
+	if err != types.ErrIPInUse {
+		t.Fatalf("Expected %v, got %v", types.ErrIPInUse, err)
+	}
+
+	// network, gateway, broadcast and out of range addresses
+	for _, invalid := range []string{"172.16.0.0", "172.16.0.1", "172.16.0.255", "10.0.0.10"} {
+		err = ds.ReserveTenantIP(ctx, tenant.ID, invalid)
+		if err != types.ErrInvalidIP {
+			t.Fatalf("Expected %v for %s, got %v", types.ErrInvalidIP, invalid, err)
+		}
+	}
+
+	// reserved addresses can only be allocated explicitly
+	newIP, err := ds.AllocateTenantIP(ctx, tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if newIP.String() == ip {
+		t.Fatal("Reserved IP address allocated from pool")
+	}
+
+	_, err = ds.AllocateTenantIPAddress(ctx, tenant.ID, newIP.String())
+	if err != types.ErrIPInUse {
+		t.Fatalf("Expected %v, got %v", types.ErrIPInUse, err)
+	}
+
+	IP, err := ds.AllocateTenantIPAddress(ctx, tenant.ID, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if IP.String() != ip {
+		t.Fatalf("Expected %s, got %s", ip, IP)
+	}
+
+	// releasing a reserved address must keep it allocated
+	err = ds.ReleaseTenantIP(ctx, tenant.ID, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reservations, err := ds.GetTenantIPReservations(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reservations) != 1 || reservations[0].IPAddress != ip {
+		t.Fatalf("Expected reservation of %s, got %v", ip, reservations)
+	}
+
+	err = ds.DeleteTenantIPReservation(ctx, tenant.ID, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.DeleteTenantIPReservation(ctx, tenant.ID, ip)
+	if err != types.ErrIPReservationNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrIPReservationNotFound, err)
+	}
+
+	IPs, err := ds.GetTenantIPs(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, IP := range IPs {
+		if IP.String() == ip {
+			t.Fatalf("Reserved IP address %s not released", ip)
+		}
+	}
+}
+
+func TestStartFailureFullCloud(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reason := payloads.FullCloud
+
+	err = ds.StartFailure(ctx, instance.ID, reason, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ds.GetInstance(instance.ID)
+	if err == nil {
+		t.Fatal("Expected instance not to be present")
+	}
+}
+
+func TestStartFailureRecorded(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-func TestStartFailureFullCloud(t *testing.T) {
-	tenant, err := addTestTenant()
+	instance, err := addTestInstance(tenant, wls[0])
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	wls, err := ds.GetWorkloads(tenant.ID)
+	err = ds.StartFailure(ctx, instance.ID, payloads.LaunchTimeout, true, "")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	instance, err := addTestInstance(tenant, wls[0])
+	i, err := ds.GetInstance(instance.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	reason := payloads.FullCloud
+	if i.Failure == nil || i.Failure.Code != types.FailureTimeout ||
+		i.Failure.Operation != types.FailureStart ||
+		i.Failure.Reason != string(payloads.LaunchTimeout) {
+		t.Fatalf("Expected launch timeout failure, got %+v", i.Failure)
+	}
 
-	err = ds.StartFailure(instance.ID, reason, false, "")
+	err = ds.DeleteFailure(ctx, instance.ID, payloads.DeleteNoInstance, "")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = ds.GetInstance(instance.ID)
-	if err == nil {
-		t.Fatal("Expected instance not to be present")
+	if i.Failure == nil || i.Failure.Code != types.FailureNotFound ||
+		i.Failure.Operation != types.FailureDelete {
+		t.Fatalf("Expected delete failure, got %+v", i.Failure)
+	}
+}
+
+func TestFailureCodes(t *testing.T) {
+	startReasons := map[payloads.StartFailureReason]types.FailureCode{
+		payloads.FullCloud:         types.FailureCapacity,
+		payloads.FullComputeNode:   types.FailureCapacity,
+		payloads.NodeInMaintenance: types.FailureCapacity,
+		payloads.NodeDraining:      types.FailureCapacity,
+		payloads.NoComputeNodes:    types.FailureCapacity,
+		payloads.NoNetworkNodes:    types.FailureCapacity,
+		payloads.InvalidPayload:    types.FailureInvalidRequest,
+		payloads.InvalidData:       types.FailureInvalidRequest,
+		payloads.AlreadyRunning:    types.FailureConflict,
+		payloads.InstanceExists:    types.FailureConflict,
+		payloads.ImageFailure:      types.FailureImage,
+		payloads.LaunchFailure:     types.FailureLaunch,
+		payloads.NetworkFailure:    types.FailureNetwork,
+		payloads.LaunchTimeout:     types.FailureTimeout,
+		"bogus":                    types.FailureUnknown,
+	}
+
+	for reason, code := range startReasons {
+		if c := types.StartFailureCode(reason); c != code {
+			t.Errorf("Expected %s for start failure %s, got %s", code, reason, c)
+		}
+	}
+
+	deleteReasons := map[payloads.DeleteFailureReason]types.FailureCode{
+		payloads.DeleteNoInstance:     types.FailureNotFound,
+		payloads.DeleteInvalidPayload: types.FailureInvalidRequest,
+		payloads.DeleteInvalidData:    types.FailureInvalidRequest,
+		"bogus":                       types.FailureUnknown,
+	}
+
+	for reason, code := range deleteReasons {
+		if c := types.DeleteFailureCode(reason); c != code {
+			t.Errorf("Expected %s for delete failure %s, got %s", code, reason, c)
+		}
+	}
+
+	attachReasons := map[payloads.AttachVolumeFailureReason]types.FailureCode{
+		payloads.AttachVolumeNoInstance:      types.FailureNotFound,
+		payloads.AttachVolumeInvalidPayload:  types.FailureInvalidRequest,
+		payloads.AttachVolumeInvalidData:     types.FailureInvalidRequest,
+		payloads.AttachVolumeAttachFailure:   types.FailureStorage,
+		payloads.AttachVolumeAlreadyAttached: types.FailureConflict,
+		payloads.AttachVolumeStateFailure:    types.FailureInstanceState,
+		payloads.AttachVolumeInstanceFailure: types.FailureInstanceState,
+		payloads.AttachVolumeNotSupported:    types.FailureNotSupported,
+		"bogus":                              types.FailureUnknown,
+	}
+
+	for reason, code := range attachReasons {
+		if c := types.AttachVolumeFailureCode(reason); c != code {
+			t.Errorf("Expected %s for attach failure %s, got %s", code, reason, c)
+		}
+	}
+
+	publicIPReasons := map[payloads.PublicIPFailureReason]types.FailureCode{
+		payloads.PublicIPNoInstance:     types.FailureNotFound,
+		payloads.PublicIPInvalidPayload: types.FailureInvalidRequest,
+		payloads.PublicIPInvalidData:    types.FailureInvalidRequest,
+		payloads.PublicIPAssignFailure:  types.FailureNetwork,
+		payloads.PublicIPReleaseFailure: types.FailureNetwork,
+		"bogus":                         types.FailureUnknown,
+	}
+
+	for reason, code := range publicIPReasons {
+		if c := types.PublicIPFailureCode(reason); c != code {
+			t.Errorf("Expected %s for public IP failure %s, got %s", code, reason, c)
+		}
 	}
 }
 
@@ -1211,7 +1867,7 @@ func TestAttachVolumeFailure(t *testing.T) {
 		CreateTime:  time.Now(),
 	}
 
-	err = ds.AddBlockDevice(data)
+	err = ds.AddBlockDevice(ctx, data)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1219,13 +1875,13 @@ func TestAttachVolumeFailure(t *testing.T) {
 	// update block data to indicate it is attaching
 	data.State = types.Attaching
 
-	err = ds.UpdateBlockDevice(data)
+	err = ds.UpdateBlockDevice(ctx, data)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// pretend we got a failure to attach.
-	err = ds.AttachVolumeFailure(instance.ID, data.ID, payloads.AttachVolumeAlreadyAttached)
+	err = ds.AttachVolumeFailure(ctx, instance.ID, data.ID, payloads.AttachVolumeAlreadyAttached)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1239,6 +1895,11 @@ func TestAttachVolumeFailure(t *testing.T) {
 	if bd.State != types.Available {
 		t.Fatalf("expected state: %s, got %s\n", types.Available, bd.State)
 	}
+
+	if bd.Failure == nil || bd.Failure.Code != types.FailureConflict ||
+		bd.Failure.Operation != types.FailureAttachVolume {
+		t.Fatalf("expected attach volume conflict failure, got %+v\n", bd.Failure)
+	}
 }
 
 func testAllocateTenantIPs(t *testing.T, nIPs int) {
@@ -1261,7 +1922,7 @@ func testAllocateTenantIPs(t *testing.T, nIPs int) {
 
 	// make this tenant have some network hosts assigned to them.
 	// switch this to use pool
-	IPs, err := ds.AllocateTenantIPPool(newTenant.ID, nIPs)
+	IPs, err := ds.AllocateTenantIPPool(ctx, newTenant.ID, nIPs)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1271,7 +1932,7 @@ func testAllocateTenantIPs(t *testing.T, nIPs int) {
 	}
 
 	// get private tenant type
-	tenant, err := ds.getTenant(newTenant.ID)
+	tenant, err := ds.getTenant(ctx, newTenant.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1339,7 +2000,7 @@ func TestAddBlockDevice(t *testing.T) {
 		CreateTime:  time.Now(),
 	}
 
-	err = ds.AddBlockDevice(data)
+	err = ds.AddBlockDevice(ctx, data)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1380,7 +2041,7 @@ func TestDeleteBlockDevice(t *testing.T) {
 		CreateTime:  time.Now(),
 	}
 
-	err = ds.AddBlockDevice(data)
+	err = ds.AddBlockDevice(ctx, data)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1392,7 +2053,7 @@ func TestDeleteBlockDevice(t *testing.T) {
 	}
 
 	// remove the block device
-	err = ds.DeleteBlockDevice(data.ID)
+	err = ds.DeleteBlockDevice(ctx, data.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1404,7 +2065,7 @@ func TestDeleteBlockDevice(t *testing.T) {
 	}
 
 	// attempt to delete a non-existing block device
-	err = ds.DeleteBlockDevice("unknownID")
+	err = ds.DeleteBlockDevice(ctx, "unknownID")
 	if err != ErrNoBlockData {
 		t.Fatalf("expecting %s error, received %s\n", ErrNoBlockData, err)
 	}
@@ -1427,7 +2088,7 @@ func TestUpdateBlockDevice(t *testing.T) {
 		CreateTime:  time.Now(),
 	}
 
-	err = ds.AddBlockDevice(data)
+	err = ds.AddBlockDevice(ctx, data)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1441,7 +2102,7 @@ func TestUpdateBlockDevice(t *testing.T) {
 	// update the state of the block device.
 	data.State = types.Attaching
 
-	err = ds.UpdateBlockDevice(data)
+	err = ds.UpdateBlockDevice(ctx, data)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1491,7 +2152,7 @@ func TestUpdateBlockDeviceErr(t *testing.T) {
 	}
 
 	// confirm that we get the correct error for missing id
-	err = ds.UpdateBlockDevice(data)
+	err = ds.UpdateBlockDevice(ctx, data)
 	if err != ErrNoBlockData {
 		t.Fatal(err)
 	}
@@ -1514,7 +2175,7 @@ func TestCreateStorageAttachment(t *testing.T) {
 		CreateTime:  time.Now(),
 	}
 
-	err = ds.AddBlockDevice(data)
+	err = ds.AddBlockDevice(ctx, data)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1538,7 +2199,7 @@ func TestCreateStorageAttachment(t *testing.T) {
 		Ephemeral: false,
 		Bootable:  false,
 	}
-	_, err = ds.CreateStorageAttachment(instance.ID, volume)
+	_, err = ds.CreateStorageAttachment(ctx, instance.ID, volume)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1572,7 +2233,7 @@ func TestUpdateStorageAttachmentDeleted(t *testing.T) {
 		CreateTime:  time.Now(),
 	}
 
-	err = ds.AddBlockDevice(data)
+	err = ds.AddBlockDevice(ctx, data)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1596,12 +2257,12 @@ func TestUpdateStorageAttachmentDeleted(t *testing.T) {
 		Ephemeral: false,
 		Bootable:  false,
 	}
-	_, err = ds.CreateStorageAttachment(instance.ID, volume)
+	_, err = ds.CreateStorageAttachment(ctx, instance.ID, volume)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	ds.updateStorageAttachments(instance.ID)
+	ds.updateStorageAttachments(ctx, instance.ID)
 }
 
 func TestGetStorageAttachment(t *testing.T) {
@@ -1621,7 +2282,7 @@ func TestGetStorageAttachment(t *testing.T) {
 		CreateTime:  time.Now(),
 	}
 
-	err = ds.AddBlockDevice(data)
+	err = ds.AddBlockDevice(ctx, data)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1645,7 +2306,7 @@ func TestGetStorageAttachment(t *testing.T) {
 		Ephemeral: false,
 		Bootable:  false,
 	}
-	_, err = ds.CreateStorageAttachment(instance.ID, volume)
+	_, err = ds.CreateStorageAttachment(ctx, instance.ID, volume)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1677,7 +2338,7 @@ func TestGetStorageAttachmentError(t *testing.T) {
 		CreateTime:  time.Now(),
 	}
 
-	err = ds.AddBlockDevice(data)
+	err = ds.AddBlockDevice(ctx, data)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1719,7 +2380,7 @@ func TestDeleteStorageAttachment(t *testing.T) {
 		CreateTime:  time.Now(),
 	}
 
-	err = ds.AddBlockDevice(data)
+	err = ds.AddBlockDevice(ctx, data)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1743,7 +2404,7 @@ func TestDeleteStorageAttachment(t *testing.T) {
 		Ephemeral: false,
 		Bootable:  false,
 	}
-	_, err = ds.CreateStorageAttachment(instance.ID, volume)
+	_, err = ds.CreateStorageAttachment(ctx, instance.ID, volume)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1757,7 +2418,7 @@ func TestDeleteStorageAttachment(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = ds.DeleteStorageAttachment(a.ID)
+	err = ds.DeleteStorageAttachment(ctx, a.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1785,7 +2446,7 @@ func TestDeleteStorageAttachmentError(t *testing.T) {
 		CreateTime:  time.Now(),
 	}
 
-	err = ds.AddBlockDevice(data)
+	err = ds.AddBlockDevice(ctx, data)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1809,7 +2470,7 @@ func TestDeleteStorageAttachmentError(t *testing.T) {
 		Ephemeral: false,
 		Bootable:  false,
 	}
-	_, err = ds.CreateStorageAttachment(instance.ID, volume)
+	_, err = ds.CreateStorageAttachment(ctx, instance.ID, volume)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1823,7 +2484,7 @@ func TestDeleteStorageAttachmentError(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = ds.DeleteStorageAttachment(a.ID)
+	err = ds.DeleteStorageAttachment(ctx, a.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1833,7 +2494,7 @@ func TestDeleteStorageAttachmentError(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = ds.DeleteStorageAttachment(a.ID)
+	err = ds.DeleteStorageAttachment(ctx, a.ID)
 	if err != ErrNoStorageAttachment {
 		t.Fatal(err)
 	}
@@ -1856,7 +2517,7 @@ func TestGetVolumeAttachments(t *testing.T) {
 		CreateTime:  time.Now(),
 	}
 
-	err = ds.AddBlockDevice(data)
+	err = ds.AddBlockDevice(ctx, data)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1880,7 +2541,7 @@ func TestGetVolumeAttachments(t *testing.T) {
 		Ephemeral: false,
 		Bootable:  false,
 	}
-	_, err = ds.CreateStorageAttachment(instance.ID, volume)
+	_, err = ds.CreateStorageAttachment(ctx, instance.ID, volume)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1907,7 +2568,7 @@ func TestAddPool(t *testing.T) {
 		Name: "test",
 	}
 
-	err := ds.AddPool(pool)
+	err := ds.AddPool(ctx, pool)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1924,7 +2585,7 @@ func TestAddPool(t *testing.T) {
 		Subnets: []types.ExternalSubnet{subnet},
 	}
 
-	err = ds.AddPool(pool2)
+	err = ds.AddPool(ctx, pool2)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1941,7 +2602,7 @@ func TestAddPool(t *testing.T) {
 		Subnets: []types.ExternalSubnet{subnet},
 	}
 
-	err = ds.AddPool(pool3)
+	err = ds.AddPool(ctx, pool3)
 	if err != types.ErrDuplicateSubnet {
 		t.Fatal("Duplicate subnet allowed")
 	}
@@ -1958,7 +2619,7 @@ func TestAddPool(t *testing.T) {
 		IPs:  []types.ExternalIP{addr},
 	}
 
-	err = ds.AddPool(pool4)
+	err = ds.AddPool(ctx, pool4)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1975,7 +2636,7 @@ func TestAddPool(t *testing.T) {
 		IPs:  []types.ExternalIP{addr},
 	}
 
-	err = ds.AddPool(pool5)
+	err = ds.AddPool(ctx, pool5)
 	if err != types.ErrDuplicateIP {
 		t.Fatal("Duplicate IP allowed")
 	}
@@ -1983,7 +2644,7 @@ func TestAddPool(t *testing.T) {
 	// add one that overlaps an existing subnet
 	addr.Address = "192.168.0.1"
 	pool5.IPs = []types.ExternalIP{addr}
-	err = ds.AddPool(pool5)
+	err = ds.AddPool(ctx, pool5)
 	if err != types.ErrDuplicateIP {
 		t.Fatal("Duplicate IP allowed")
 	}
@@ -1995,7 +2656,7 @@ func TestAddPool(t *testing.T) {
 	}
 
 	for _, p := range pools {
-		err := ds.DeletePool(p.ID)
+		err := ds.DeletePool(ctx, p.ID)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -2008,7 +2669,7 @@ func TestGetPool(t *testing.T) {
 		Name: "test",
 	}
 
-	err := ds.AddPool(orig)
+	err := ds.AddPool(ctx, orig)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2028,7 +2689,7 @@ func TestGetPool(t *testing.T) {
 		t.Fatal("Found non existent pool")
 	}
 
-	err = ds.DeletePool(orig.ID)
+	err = ds.DeletePool(ctx, orig.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2040,13 +2701,13 @@ func TestAddExternalSubnet(t *testing.T) {
 		Name: "test",
 	}
 
-	err := ds.AddPool(orig)
+	err := ds.AddPool(ctx, orig)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	subnet := "192.168.2.0/24"
-	err = ds.AddExternalSubnet(orig.ID, subnet)
+	err = ds.AddExternalSubnet(ctx, orig.ID, subnet)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2061,27 +2722,27 @@ func TestAddExternalSubnet(t *testing.T) {
 	}
 
 	// try to add to a not existing pool
-	err = ds.AddExternalSubnet(uuid.Generate().String(), subnet)
+	err = ds.AddExternalSubnet(ctx, uuid.Generate().String(), subnet)
 	if err != types.ErrPoolNotFound {
 		t.Fatal("Unknown pool allowed")
 	}
 
 	// try to add an overlapping subnet
 	overlap := "192.168.0.0/8"
-	err = ds.AddExternalSubnet(orig.ID, overlap)
+	err = ds.AddExternalSubnet(ctx, orig.ID, overlap)
 	if err != types.ErrDuplicateSubnet {
 		t.Fatal("overlapping subnet allowed")
 	}
 
 	// try an invalid subnet
 	invalid := "not.a.subnet/10"
-	err = ds.AddExternalSubnet(orig.ID, invalid)
+	err = ds.AddExternalSubnet(ctx, orig.ID, invalid)
 	if err == nil {
 		t.Fatal("invalid subnet allowed")
 	}
 
 	// cleanup.
-	err = ds.DeletePool(orig.ID)
+	err = ds.DeletePool(ctx, orig.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2093,13 +2754,13 @@ func TestAddExternalIPs(t *testing.T) {
 		Name: "test",
 	}
 
-	err := ds.AddPool(orig)
+	err := ds.AddPool(ctx, orig)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	IPs := []string{"192.168.0.1"}
-	err = ds.AddExternalIPs(orig.ID, IPs)
+	err = ds.AddExternalIPs(ctx, orig.ID, IPs)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2115,34 +2776,34 @@ func TestAddExternalIPs(t *testing.T) {
 
 	// add an invalid IP
 	IPs = []string{"not.a.IP"}
-	err = ds.AddExternalIPs(orig.ID, IPs)
+	err = ds.AddExternalIPs(ctx, orig.ID, IPs)
 	if err != types.ErrInvalidIP {
 		t.Fatal("invalid IP allowed")
 	}
 
 	// add a duplicate IP
 	IPs = []string{"192.168.0.1"}
-	err = ds.AddExternalIPs(orig.ID, IPs)
+	err = ds.AddExternalIPs(ctx, orig.ID, IPs)
 	if err != types.ErrDuplicateIP {
 		t.Fatal("duplicate IP allowed")
 	}
 
 	// add duplicate in set
 	IPs = []string{"192.168.0.2", "192.168.0.2"}
-	err = ds.AddExternalIPs(orig.ID, IPs)
+	err = ds.AddExternalIPs(ctx, orig.ID, IPs)
 	if err != types.ErrDuplicateIP {
 		t.Fatal("duplicate IP allowed")
 	}
 
 	// add to an invalid pool
 	IPs = []string{"192.168.0.2"}
-	err = ds.AddExternalIPs(uuid.Generate().String(), IPs)
+	err = ds.AddExternalIPs(ctx, uuid.Generate().String(), IPs)
 	if err != types.ErrPoolNotFound {
 		t.Fatal("duplicate IP allowed")
 	}
 
 	// cleanup.
-	err = ds.DeletePool(orig.ID)
+	err = ds.DeletePool(ctx, orig.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2154,13 +2815,13 @@ func TestDeleteExternalSubnet(t *testing.T) {
 		Name: "test",
 	}
 
-	err := ds.AddPool(orig)
+	err := ds.AddPool(ctx, orig)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	subnet := "192.168.2.0/24"
-	err = ds.AddExternalSubnet(orig.ID, subnet)
+	err = ds.AddExternalSubnet(ctx, orig.ID, subnet)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2171,19 +2832,19 @@ func TestDeleteExternalSubnet(t *testing.T) {
 	}
 
 	// delete from the wrong pool
-	err = ds.DeleteSubnet(uuid.Generate().String(), pool.Subnets[0].CIDR)
+	err = ds.DeleteSubnet(ctx, uuid.Generate().String(), pool.Subnets[0].CIDR)
 	if err != types.ErrPoolNotFound {
 		t.Fatal("delete from invalid pool allowed")
 	}
 
 	// delete the wrong address
-	err = ds.DeleteSubnet(pool.ID, "192.168.0.0/24")
+	err = ds.DeleteSubnet(ctx, pool.ID, "192.168.0.0/24")
 	if err != types.ErrInvalidPoolAddress {
 		t.Fatal("delete of wrong subnet")
 	}
 
 	// delete an invalid address
-	err = ds.DeleteSubnet(pool.ID, "192.not.a.subnet/24")
+	err = ds.DeleteSubnet(ctx, pool.ID, "192.not.a.subnet/24")
 	if err == nil {
 		t.Fatal("delete of invalid subnet")
 	}
@@ -2204,30 +2865,30 @@ func TestDeleteExternalSubnet(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	m, err := ds.MapExternalIP(pool.ID, instance.ID)
+	m, err := ds.MapExternalIP(ctx, pool.ID, instance.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = ds.DeleteSubnet(pool.ID, pool.Subnets[0].ID)
+	err = ds.DeleteSubnet(ctx, pool.ID, pool.Subnets[0].ID)
 	if err != types.ErrPoolNotEmpty {
 		t.Fatal("delete with mapped IP in subnet allowed")
 	}
 
 	// unmap
-	err = ds.UnMapExternalIP(m.ExternalIP)
+	err = ds.UnMapExternalIP(ctx, m.ExternalIP)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// delete an existing subnet
-	err = ds.DeleteSubnet(pool.ID, pool.Subnets[0].ID)
+	err = ds.DeleteSubnet(ctx, pool.ID, pool.Subnets[0].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// cleanup.
-	err = ds.DeletePool(orig.ID)
+	err = ds.DeletePool(ctx, orig.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2239,13 +2900,13 @@ func TestDeleteExternalIPs(t *testing.T) {
 		Name: "test",
 	}
 
-	err := ds.AddPool(orig)
+	err := ds.AddPool(ctx, orig)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	IPs := []string{"192.168.0.1"}
-	err = ds.AddExternalIPs(orig.ID, IPs)
+	err = ds.AddExternalIPs(ctx, orig.ID, IPs)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2256,13 +2917,13 @@ func TestDeleteExternalIPs(t *testing.T) {
 	}
 
 	// try to delete from invalid pool
-	err = ds.DeleteExternalIP(uuid.Generate().String(), pool.IPs[0].ID)
+	err = ds.DeleteExternalIP(ctx, uuid.Generate().String(), pool.IPs[0].ID)
 	if err != types.ErrPoolNotFound {
 		t.Fatal("delete from invalid pool")
 	}
 
 	// try to delete an invalid address
-	err = ds.DeleteExternalIP(pool.ID, uuid.Generate().String())
+	err = ds.DeleteExternalIP(ctx, pool.ID, uuid.Generate().String())
 	if err != types.ErrInvalidPoolAddress {
 		t.Fatal("delete invalid address")
 	}
@@ -2283,29 +2944,29 @@ func TestDeleteExternalIPs(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	m, err := ds.MapExternalIP(pool.ID, instance.ID)
+	m, err := ds.MapExternalIP(ctx, pool.ID, instance.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = ds.DeleteExternalIP(pool.ID, pool.IPs[0].ID)
+	err = ds.DeleteExternalIP(ctx, pool.ID, pool.IPs[0].ID)
 	if err != types.ErrPoolNotEmpty {
 		t.Fatal("delete mapped address")
 	}
 
 	// unmap
-	err = ds.UnMapExternalIP(m.ExternalIP)
+	err = ds.UnMapExternalIP(ctx, m.ExternalIP)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = ds.DeleteExternalIP(pool.ID, pool.IPs[0].ID)
+	err = ds.DeleteExternalIP(ctx, pool.ID, pool.IPs[0].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// cleanup.
-	err = ds.DeletePool(pool.ID)
+	err = ds.DeletePool(ctx, pool.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2317,13 +2978,13 @@ func TestMapIPs(t *testing.T) {
 		Name: "test",
 	}
 
-	err := ds.AddPool(orig)
+	err := ds.AddPool(ctx, orig)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	IPs := []string{"192.168.0.1"}
-	err = ds.AddExternalIPs(orig.ID, IPs)
+	err = ds.AddExternalIPs(ctx, orig.ID, IPs)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2350,47 +3011,47 @@ func TestMapIPs(t *testing.T) {
 	}
 
 	// try to map to an invalid instance.
-	_, err = ds.MapExternalIP(pool.ID, uuid.Generate().String())
+	_, err = ds.MapExternalIP(ctx, pool.ID, uuid.Generate().String())
 	if err == nil {
 		t.Fatal("map to invalid instance allowed")
 	}
 
 	// try to map to an invalid pool
-	_, err = ds.MapExternalIP(uuid.Generate().String(), instance.ID)
+	_, err = ds.MapExternalIP(ctx, uuid.Generate().String(), instance.ID)
 	if err != types.ErrPoolNotFound {
 		t.Fatal("map to invalid pool allowed")
 	}
 
 	// try to map to an empty pool
-	err = ds.DeleteExternalIP(pool.ID, pool.IPs[0].ID)
+	err = ds.DeleteExternalIP(ctx, pool.ID, pool.IPs[0].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = ds.MapExternalIP(pool.ID, instance.ID)
+	_, err = ds.MapExternalIP(ctx, pool.ID, instance.ID)
 	if err != types.ErrPoolEmpty {
 		t.Fatal(err)
 	}
 
 	// try to map to a valid instance.
-	err = ds.AddExternalIPs(orig.ID, IPs)
+	err = ds.AddExternalIPs(ctx, orig.ID, IPs)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	m, err := ds.MapExternalIP(pool.ID, instance.ID)
+	m, err := ds.MapExternalIP(ctx, pool.ID, instance.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// unmap
-	err = ds.UnMapExternalIP(m.ExternalIP)
+	err = ds.UnMapExternalIP(ctx, m.ExternalIP)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// cleanup.
-	err = ds.DeletePool(pool.ID)
+	err = ds.DeletePool(ctx, pool.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2402,13 +3063,13 @@ func TestGetMappedIPs(t *testing.T) {
 		Name: "test",
 	}
 
-	err := ds.AddPool(orig)
+	err := ds.AddPool(ctx, orig)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	IPs := []string{"192.168.0.1"}
-	err = ds.AddExternalIPs(orig.ID, IPs)
+	err = ds.AddExternalIPs(ctx, orig.ID, IPs)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2434,7 +3095,7 @@ func TestGetMappedIPs(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	m, err := ds.MapExternalIP(pool.ID, instance.ID)
+	m, err := ds.MapExternalIP(ctx, pool.ID, instance.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2464,13 +3125,13 @@ func TestGetMappedIPs(t *testing.T) {
 	}
 
 	// unmap
-	err = ds.UnMapExternalIP(m.ExternalIP)
+	err = ds.UnMapExternalIP(ctx, m.ExternalIP)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// cleanup.
-	err = ds.DeletePool(pool.ID)
+	err = ds.DeletePool(ctx, pool.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2493,18 +3154,18 @@ func TestDeleteWorkload(t *testing.T) {
 	}
 
 	// attempt to delete this workload, should fail.
-	err = ds.DeleteWorkload(wls[0].ID)
+	err = ds.DeleteWorkload(ctx, wls[0].ID)
 	if err != types.ErrWorkloadInUse {
 		t.Fatal("Deleting an in use workload did not fail")
 	}
 
-	err = ds.DeleteInstance(instance.ID)
+	err = ds.DeleteInstance(ctx, instance.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// attempt to delete this workload, should pass
-	err = ds.DeleteWorkload(wls[0].ID)
+	err = ds.DeleteWorkload(ctx, wls[0].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2566,7 +3227,7 @@ func TestAddRemoveImage(t *testing.T) {
 		TenantID:   tenant.ID,
 	}
 
-	err = ds.AddImage(i)
+	err = ds.AddImage(ctx, i)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2603,7 +3264,7 @@ func TestAddRemovePublicImage(t *testing.T) {
 		Visibility: types.Public,
 	}
 
-	err = ds.AddImage(i)
+	err = ds.AddImage(ctx, i)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2651,7 +3312,7 @@ func TestAddRemoveInternalImage(t *testing.T) {
 		Visibility: types.Internal,
 	}
 
-	err = ds.AddImage(i)
+	err = ds.AddImage(ctx, i)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2708,12 +3369,12 @@ func TestAddRemoveDuplicateImage(t *testing.T) {
 		TenantID:   tenant.ID,
 	}
 
-	err = ds.AddImage(i)
+	err = ds.AddImage(ctx, i)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = ds.AddImage(i)
+	err = ds.AddImage(ctx, i)
 	if err != api.ErrAlreadyExists {
 		t.Fatal("Expected error when adding duplicate")
 	}
@@ -2747,7 +3408,7 @@ func TestAddRemoveDuplicateNameImage(t *testing.T) {
 		TenantID:   tenant.ID,
 	}
 
-	err = ds.AddImage(i)
+	err = ds.AddImage(ctx, i)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2759,7 +3420,7 @@ func TestAddRemoveDuplicateNameImage(t *testing.T) {
 		TenantID:   tenant.ID,
 	}
 
-	err = ds.AddImage(i2)
+	err = ds.AddImage(ctx, i2)
 	if err != api.ErrAlreadyExists {
 		t.Fatal("Expected error when adding duplicate")
 	}
@@ -2788,7 +3449,7 @@ func TestResolveImage(t *testing.T) {
 		TenantID:   tenant.ID,
 	}
 
-	err = ds.AddImage(i)
+	err = ds.AddImage(ctx, i)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2810,8 +3471,441 @@ func TestResolveImage(t *testing.T) {
 
 var ds *Datastore
 
+var ctx = context.Background()
+
 var workloadsPath = flag.String("workloads_path", "../../workloads", "path to yaml files")
 
+func TestOperations(t *testing.T) {
+	op := types.Operation{
+		ID:         uuid.Generate().String(),
+		TenantID:   uuid.Generate().String(),
+		Type:       types.VolumeClone,
+		ResourceID: uuid.Generate().String(),
+		State:      types.OperationRunning,
+		CreateTime: time.Now(),
+	}
+
+	err := ds.AddOperation(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.AddOperation(op)
+	if err == nil {
+		t.Fatal("Expected duplicate operation error")
+	}
+
+	op.State = types.OperationSucceeded
+	op.Progress = 100
+	err = ds.UpdateOperation(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	op2, err := ds.GetOperation(op.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if op2.State != types.OperationSucceeded || op2.Progress != 100 {
+		t.Fatalf("Operation not updated: %v", op2)
+	}
+
+	ops := ds.GetOperations(op.TenantID)
+	if len(ops) != 1 || ops[0].ID != op.ID {
+		t.Fatalf("Expected 1 tenant operation, got %d", len(ops))
+	}
+
+	ops = ds.GetResourceOperations(op.ResourceID, types.VolumeClone)
+	if len(ops) != 1 || ops[0].ID != op.ID {
+		t.Fatalf("Expected 1 resource operation, got %d", len(ops))
+	}
+
+	ops = ds.GetResourceOperations(op.ResourceID, types.ImageImport)
+	if len(ops) != 0 {
+		t.Fatalf("Expected no image operation, got %d", len(ops))
+	}
+
+	_, err = ds.GetOperation(uuid.Generate().String())
+	if err != types.ErrOperationNotFound {
+		t.Fatalf("Expected ErrOperationNotFound, got %v", err)
+	}
+}
+
+func TestPruneOperations(t *testing.T) {
+	now := time.Now()
+	resourceID := uuid.Generate().String()
+
+	old := types.Operation{
+		ID:         uuid.Generate().String(),
+		Type:       types.VolumeClone,
+		ResourceID: resourceID,
+		State:      types.OperationSucceeded,
+		CreateTime: now.Add(-2 * operationRetention),
+		UpdateTime: now.Add(-2 * operationRetention),
+	}
+
+	err := ds.AddOperation(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	op := old
+	op.ID = uuid.Generate().String()
+	op.State = types.OperationRunning
+	op.CreateTime = now
+	op.UpdateTime = now
+
+	err = ds.AddOperation(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ds.GetOperation(old.ID)
+	if err != types.ErrOperationNotFound {
+		t.Fatalf("Expected expired operation to be pruned, got %v", err)
+	}
+
+	ops := ds.GetResourceOperations(resourceID, types.VolumeClone)
+	if len(ops) != 1 || ops[0].ID != op.ID {
+		t.Fatalf("Expected 1 resource operation, got %v", ops)
+	}
+}
+
+func TestInstanceActions(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(wls) == 0 {
+		t.Fatal("No Workloads Found")
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance.State = payloads.Running
+
+	ds.SetInstanceActionCause(instance.ID, types.InitiatorUser, types.ReasonAPIRequest)
+
+	err = ds.InstanceStopped(ctx, instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nobody asked for this restart
+	err = ds.InstanceRestarting(ctx, instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []types.InstanceAction{
+		{
+			InstanceID:    instance.ID,
+			PreviousState: payloads.Running,
+			State:         payloads.Exited,
+			Initiator:     types.InitiatorUser,
+			Reason:        types.ReasonAPIRequest,
+		},
+		{
+			InstanceID:    instance.ID,
+			PreviousState: payloads.Exited,
+			State:         payloads.Pending,
+			Initiator:     types.InitiatorSystem,
+			Reason:        types.ReasonHealthCheck,
+		},
+	}
+
+	actions := ds.GetInstanceActions(instance.ID)
+	if len(actions) != len(expected) {
+		t.Fatalf("Expected %d actions, got %d", len(expected), len(actions))
+	}
+
+	for i := range actions {
+		actions[i].Timestamp = time.Time{}
+		if actions[i] != expected[i] {
+			t.Errorf("Expected action %v, got %v", expected[i], actions[i])
+		}
+	}
+
+	err = ds.DeleteInstance(ctx, instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ds.GetInstanceActions(instance.ID)) != 0 {
+		t.Error("Actions of deleted instance not removed")
+	}
+}
+
+func TestInstanceMigration(t *testing.T) {
+	instances, stat := addTestInstanceStats(t)
+	instance := instances[0]
+
+	target := stat
+	target.NodeUUID = uuid.Generate().String()
+	target.Instances = []payloads.InstanceStat{
+		{
+			InstanceUUID: instance.ID,
+			State:        payloads.Migrating,
+		},
+	}
+
+	err := ds.addNodeStat(ctx, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.InstanceMigrating(ctx, instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the target reports the instance while it waits for its state
+	err = ds.addInstanceStats(ctx, target.Instances, target.NodeUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := ds.GetInstance(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.NodeID != stat.NodeUUID || i.State != payloads.Migrating {
+		t.Fatalf("Expected migrating instance on %s, got %s on %s",
+			stat.NodeUUID, i.State, i.NodeID)
+	}
+
+	err = ds.InstanceMigrated(ctx, instance.ID, target.NodeUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the source reports the instance until it discarded it
+	err = ds.addInstanceStats(ctx, []payloads.InstanceStat{
+		{
+			InstanceUUID: instance.ID,
+			State:        payloads.Migrating,
+		},
+	}, stat.NodeUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.NodeID != target.NodeUUID || i.State != payloads.Running {
+		t.Fatalf("Expected running instance on %s, got %s on %s",
+			target.NodeUUID, i.State, i.NodeID)
+	}
+
+	for _, n := range []string{stat.NodeUUID, target.NodeUUID} {
+		found := false
+		nodeInstances, err := ds.GetAllInstancesByNode(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ni := range nodeInstances {
+			found = found || ni.ID == instance.ID
+		}
+		if found != (n == target.NodeUUID) {
+			t.Errorf("Unexpected instances of node %s after migration", n)
+		}
+	}
+}
+
+// Test that the clones interrupted by a restart are failed
+//
+// Stores pending, cloning and available volumes in a database, then
+// initialises a datastore with this database.
+//
+// The pending and cloning volumes should be put in error, both in the
+// database and in the datastore, and the available volume left alone.
+func TestInitInterruptedClones(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.disconnect()
+
+	states := map[string]types.BlockState{}
+	for _, state := range []types.BlockState{types.Pending, types.Cloning, types.Available} {
+		volume := types.Volume{
+			BlockDevice: storage.BlockDevice{ID: uuid.Generate().String()},
+			TenantID:    uuid.Generate().String(),
+			State:       state,
+		}
+
+		err = db.addBlockData(ctx, volume)
+		if err != nil {
+			t.Fatal(err)
+		}
+		states[volume.ID] = state
+	}
+
+	// the database of getPersistentStore is opened again under another
+	// URI, as a URI can only be registered once with the sql package.
+	restarted := &Datastore{}
+	err = restarted.Init(Config{
+		PersistentURI:     db.(*sqliteDB).dbName + "&restarted=1",
+		InitWorkloadsPath: *workloadsPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Exit()
+
+	stored, err := db.getAllBlockData(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for ID, state := range states {
+		expected := state
+		if state != types.Available {
+			expected = types.VolumeError
+		}
+
+		if stored[ID].State != expected {
+			t.Errorf("Expected %s volume to be stored %s, got %s", state, expected, stored[ID].State)
+		}
+
+		bd, err := restarted.GetBlockDevice(ID)
+		if err != nil || bd.State != expected {
+			t.Errorf("Expected %s volume to be %s, got %s: %v", state, expected, bd.State, err)
+		}
+	}
+}
+
+// Test that the archivals and rehydrations interrupted by a restart are
+// rolled back
+//
+// Stores archiving, rehydrating and archived volumes in a database, then
+// initialises a datastore with this database.
+//
+// The archiving volume should be back in use, the rehydrating volume back
+// to archived, both in the database and in the datastore, and the archived
+// volume left alone.
+func TestInitInterruptedArchives(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.disconnect()
+
+	expected := map[types.BlockState]types.BlockState{
+		types.Archiving:   types.InUse,
+		types.Rehydrating: types.Archived,
+		types.Archived:    types.Archived,
+	}
+
+	states := map[string]types.BlockState{}
+	for state := range expected {
+		volume := types.Volume{
+			BlockDevice: storage.BlockDevice{ID: uuid.Generate().String()},
+			TenantID:    uuid.Generate().String(),
+			State:       state,
+		}
+
+		err = db.addBlockData(ctx, volume)
+		if err != nil {
+			t.Fatal(err)
+		}
+		states[volume.ID] = state
+	}
+
+	restarted := &Datastore{}
+	err = restarted.Init(Config{
+		PersistentURI:     db.(*sqliteDB).dbName + "&restarted=1",
+		InitWorkloadsPath: *workloadsPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Exit()
+
+	stored, err := db.getAllBlockData(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for ID, state := range states {
+		if stored[ID].State != expected[state] {
+			t.Errorf("Expected %s volume to be stored %s, got %s", state, expected[state], stored[ID].State)
+		}
+
+		bd, err := restarted.GetBlockDevice(ID)
+		if err != nil || bd.State != expected[state] {
+			t.Errorf("Expected %s volume to be %s, got %s: %v", state, expected[state], bd.State, err)
+		}
+	}
+}
+
+// Test that the snapshot sets interrupted by a restart are failed
+//
+// Stores creating and available snapshot sets in a database, then
+// initialises a datastore with this database.
+//
+// The creating set should be put in error and the available set left
+// alone.
+func TestInitInterruptedSnapshotSets(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.disconnect()
+
+	tenantID := uuid.Generate().String()
+	err = db.addTenant(ctx, tenantID, types.TenantConfig{Name: "snapshots"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		types.SnapshotSetCreating:  types.SnapshotSetError,
+		types.SnapshotSetAvailable: types.SnapshotSetAvailable,
+	}
+
+	states := map[string]string{}
+	for state := range expected {
+		set := types.SnapshotSet{
+			ID:         uuid.Generate().String(),
+			TenantID:   tenantID,
+			State:      state,
+			CreateTime: time.Now(),
+		}
+
+		err = db.addSnapshotSet(ctx, set)
+		if err != nil {
+			t.Fatal(err)
+		}
+		states[set.ID] = state
+	}
+
+	restarted := &Datastore{}
+	err = restarted.Init(Config{
+		PersistentURI:     db.(*sqliteDB).dbName + "&restarted=1",
+		InitWorkloadsPath: *workloadsPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Exit()
+
+	for ID, state := range states {
+		set, err := restarted.GetSnapshotSet(ctx, tenantID, ID)
+		if err != nil || set.State != expected[state] {
+			t.Errorf("Expected %s set to be %s, got %s: %v", state, expected[state], set.State, err)
+		}
+	}
+}
+
 func TestMain(m *testing.M) {
 	flag.Parse()
 
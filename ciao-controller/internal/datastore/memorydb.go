@@ -16,7 +16,10 @@
 package datastore
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/ciao-project/ciao/payloads"
@@ -32,6 +35,13 @@ type MemoryDB struct {
 	attachments     map[string]types.StorageAttachment
 	instanceVolumes map[attachment]string
 	logEntries      []*types.LogEntry
+	failedCommands  []types.FailedCommand
+	nodePolicies    map[string]types.NodePolicy
+	secrets         map[string]map[string]types.Secret
+	apiTokens       map[string]types.APIToken
+	peers           map[string]types.FederationPeer
+	snapshotSets    map[string]types.SnapshotSet
+	signedRequests  []types.SignedRequestRecord
 
 	workloadsPath string
 }
@@ -43,7 +53,7 @@ func (db *MemoryDB) fillWorkloads() error {
 	}
 
 	// add dummy public tenant.
-	return db.addTenant("public", config)
+	return db.addTenant(context.Background(), "public", config)
 }
 
 func (db *MemoryDB) init(config Config) error {
@@ -54,6 +64,11 @@ func (db *MemoryDB) init(config Config) error {
 	db.blockDevices = make(map[string]types.Volume)
 	db.attachments = make(map[string]types.StorageAttachment)
 	db.instanceVolumes = make(map[attachment]string)
+	db.nodePolicies = make(map[string]types.NodePolicy)
+	db.secrets = make(map[string]map[string]types.Secret)
+	db.apiTokens = make(map[string]types.APIToken)
+	db.peers = make(map[string]types.FederationPeer)
+	db.snapshotSets = make(map[string]types.SnapshotSet)
 
 	db.workloadsPath = config.InitWorkloadsPath
 	return db.fillWorkloads()
@@ -63,39 +78,45 @@ func (db *MemoryDB) disconnect() {
 
 }
 
-func (db *MemoryDB) logEvent(entry types.LogEntry) error {
+func (db *MemoryDB) ping(ctx context.Context) error {
+	return nil
+}
+
+func (db *MemoryDB) logEvent(ctx context.Context, entry types.LogEntry) error {
 	db.logEntries = append(db.logEntries, &entry)
 
 	return nil
 }
 
-func (db *MemoryDB) clearLog() error {
+func (db *MemoryDB) clearLog(ctx context.Context) error {
 	db.logEntries = nil
 	return nil
 }
 
-func (db *MemoryDB) getEventLog() ([]*types.LogEntry, error) {
+func (db *MemoryDB) getEventLog(ctx context.Context) ([]*types.LogEntry, error) {
 	return db.logEntries, nil
 }
 
-func (db *MemoryDB) addTenant(id string, config types.TenantConfig) error {
+func (db *MemoryDB) addTenant(ctx context.Context, id string, config types.TenantConfig) error {
 	t := &tenant{
 		Tenant: types.Tenant{
 			ID: id,
 			TenantConfig: types.TenantConfig{
 				Name:       config.Name,
 				SubnetBits: config.SubnetBits,
+				CNCIFlavor: config.CNCIFlavor,
 			},
 		},
-		network:   make(map[uint32]map[uint32]bool),
-		instances: make(map[string]*types.Instance),
-		devices:   make(map[string]types.Volume),
+		network:     make(map[uint32]map[uint32]bool),
+		reservedIPs: make(map[uint32]bool),
+		instances:   make(map[string]*types.Instance),
+		devices:     make(map[string]types.Volume),
 	}
 	db.tenants[id] = t
 	return nil
 }
 
-func (db *MemoryDB) getTenant(id string) (*tenant, error) {
+func (db *MemoryDB) getTenant(ctx context.Context, id string) (*tenant, error) {
 	tenant, ok := db.tenants[id]
 	if !ok {
 		return nil, fmt.Errorf("Tenant %s not found", id)
@@ -103,7 +124,7 @@ func (db *MemoryDB) getTenant(id string) (*tenant, error) {
 	return tenant, nil
 }
 
-func (db *MemoryDB) getTenants() ([]*tenant, error) {
+func (db *MemoryDB) getTenants(ctx context.Context) ([]*tenant, error) {
 	var tenants []*tenant
 	for _, t := range db.tenants {
 		tenants = append(tenants, t)
@@ -111,19 +132,27 @@ func (db *MemoryDB) getTenants() ([]*tenant, error) {
 	return tenants, nil
 }
 
-func (db *MemoryDB) releaseTenantIP(tenantID string, subnetInt uint32, rest uint32) error {
+func (db *MemoryDB) releaseTenantIP(ctx context.Context, tenantID string, subnetInt uint32, rest uint32) error {
 	return nil
 }
 
-func (db *MemoryDB) claimTenantIP(tenantID string, subnetInt uint32, rest uint32) error {
+func (db *MemoryDB) claimTenantIP(ctx context.Context, tenantID string, subnetInt uint32, rest uint32) error {
 	return nil
 }
 
-func (db *MemoryDB) claimTenantIPs(tenantID string, IPs []tenantIP) error {
+func (db *MemoryDB) claimTenantIPs(ctx context.Context, tenantID string, IPs []tenantIP) error {
 	return nil
 }
 
-func (db *MemoryDB) getInstances() ([]*types.Instance, error) {
+func (db *MemoryDB) addTenantIPReservation(ctx context.Context, tenantID string, host uint32) error {
+	return nil
+}
+
+func (db *MemoryDB) deleteTenantIPReservation(ctx context.Context, tenantID string, host uint32) error {
+	return nil
+}
+
+func (db *MemoryDB) getInstances(ctx context.Context) ([]*types.Instance, error) {
 	var instances []*types.Instance
 	for _, instance := range db.instances {
 		instances = append(instances, instance)
@@ -131,139 +160,326 @@ func (db *MemoryDB) getInstances() ([]*types.Instance, error) {
 	return instances, nil
 }
 
-func (db *MemoryDB) addInstance(instance *types.Instance) error {
+func (db *MemoryDB) addInstance(ctx context.Context, instance *types.Instance) error {
 	return nil
 }
 
-func (db *MemoryDB) deleteInstance(instanceID string) error {
+func (db *MemoryDB) deleteInstance(ctx context.Context, instanceID string) error {
 	return nil
 }
 
-func (db *MemoryDB) addNodeStat(stat payloads.Stat) error {
+func (db *MemoryDB) addNodeStat(ctx context.Context, stat payloads.Stat) error {
 	return nil
 }
 
-func (db *MemoryDB) addInstanceStats(stats []payloads.InstanceStat, nodeID string) error {
+func (db *MemoryDB) addInstanceStats(ctx context.Context, stats []payloads.InstanceStat, nodeID string) error {
 	return nil
 }
 
-func (db *MemoryDB) addFrameStat(stat payloads.FrameTrace) error {
+func (db *MemoryDB) addFrameStat(ctx context.Context, stat payloads.FrameTrace) error {
 	return nil
 }
 
-func (db *MemoryDB) getBatchFrameSummary() ([]types.BatchFrameSummary, error) {
+func (db *MemoryDB) getBatchFrameSummary(ctx context.Context) ([]types.BatchFrameSummary, error) {
 	return nil, nil
 }
 
-func (db *MemoryDB) getBatchFrameStatistics(label string) ([]types.BatchFrameStat, error) {
+func (db *MemoryDB) getBatchFrameStatistics(ctx context.Context, label string) ([]types.BatchFrameStat, error) {
 	return nil, nil
 }
 
-func (db *MemoryDB) getWorkloadStorage(ID string) ([]types.StorageResource, error) {
+func (db *MemoryDB) deleteBatchFrameStatistics(ctx context.Context, label string) error {
+	return nil
+}
+
+func (db *MemoryDB) getWorkloadStorage(ctx context.Context, ID string) ([]types.StorageResource, error) {
 	return []types.StorageResource{}, nil
 }
 
-func (db *MemoryDB) getAllBlockData() (map[string]types.Volume, error) {
+func (db *MemoryDB) getAllBlockData(ctx context.Context) (map[string]types.Volume, error) {
 	return db.blockDevices, nil
 }
 
-func (db *MemoryDB) addBlockData(data types.Volume) error {
+func (db *MemoryDB) addBlockData(ctx context.Context, data types.Volume) error {
 	return nil
 }
 
-func (db *MemoryDB) updateBlockData(types.Volume) error {
+func (db *MemoryDB) updateBlockData(context.Context, types.Volume) error {
 	return nil
 }
 
-func (db *MemoryDB) deleteBlockData(string) error {
+func (db *MemoryDB) deleteBlockData(context.Context, string) error {
 	return nil
 }
 
-func (db *MemoryDB) getTenantDevices(tenantID string) (map[string]types.Volume, error) {
+func (db *MemoryDB) getTenantDevices(ctx context.Context, tenantID string) (map[string]types.Volume, error) {
 	return nil, nil
 }
 
-func (db *MemoryDB) addStorageAttachment(a types.StorageAttachment) error {
+func (db *MemoryDB) addStorageAttachment(ctx context.Context, a types.StorageAttachment) error {
 	return nil
 }
 
-func (db *MemoryDB) getAllStorageAttachments() (map[string]types.StorageAttachment, error) {
+func (db *MemoryDB) getAllStorageAttachments(ctx context.Context) (map[string]types.StorageAttachment, error) {
 	return db.attachments, nil
 }
 
-func (db *MemoryDB) deleteStorageAttachment(ID string) error {
+func (db *MemoryDB) deleteStorageAttachment(ctx context.Context, ID string) error {
 	return nil
 }
 
-func (db *MemoryDB) addPool(pool types.Pool) error {
+func (db *MemoryDB) addPool(ctx context.Context, pool types.Pool) error {
 	return nil
 }
 
-func (db *MemoryDB) updatePool(pool types.Pool) error {
+func (db *MemoryDB) updatePool(ctx context.Context, pool types.Pool) error {
 	return nil
 }
 
-func (db *MemoryDB) deletePool(ID string) error {
+func (db *MemoryDB) deletePool(ctx context.Context, ID string) error {
 	return nil
 }
 
-func (db *MemoryDB) getAllPools() map[string]types.Pool {
+func (db *MemoryDB) getAllPools(ctx context.Context) map[string]types.Pool {
 	return make(map[string]types.Pool)
 }
 
-func (db *MemoryDB) addMappedIP(m types.MappedIP) error {
+func (db *MemoryDB) addMappedIP(ctx context.Context, m types.MappedIP) error {
 	return nil
 }
 
-func (db *MemoryDB) deleteMappedIP(ID string) error {
+func (db *MemoryDB) deleteMappedIP(ctx context.Context, ID string) error {
 	return nil
 }
 
-func (db *MemoryDB) getMappedIPs() map[string]types.MappedIP {
+func (db *MemoryDB) getMappedIPs(ctx context.Context) map[string]types.MappedIP {
 	return make(map[string]types.MappedIP)
 }
 
-func (db *MemoryDB) addWorkload(wl types.Workload) error {
+func (db *MemoryDB) addWorkload(ctx context.Context, wl types.Workload) error {
 	return nil
 }
 
-func (db *MemoryDB) deleteWorkload(ID string) error {
+func (db *MemoryDB) deleteWorkload(ctx context.Context, ID string) error {
 	return nil
 }
 
-func (db *MemoryDB) getWorkloads() ([]types.Workload, error) {
+func (db *MemoryDB) updateWorkloadCategory(ctx context.Context, ID string, category string) error {
+	return nil
+}
+
+func (db *MemoryDB) getWorkloads(ctx context.Context) ([]types.Workload, error) {
 	return []types.Workload{}, nil
 }
 
-func (db *MemoryDB) updateQuotas(tenantID string, qds []types.QuotaDetails) error {
+func (db *MemoryDB) updateQuotas(ctx context.Context, tenantID string, qds []types.QuotaDetails) error {
 	return nil
 }
 
-func (db *MemoryDB) getQuotas(tenantID string) ([]types.QuotaDetails, error) {
+func (db *MemoryDB) getQuotas(ctx context.Context, tenantID string) ([]types.QuotaDetails, error) {
 	return []types.QuotaDetails{}, nil
 }
 
-func (db *MemoryDB) updateInstance(instance *types.Instance) error {
+func (db *MemoryDB) updateInstance(ctx context.Context, instance *types.Instance) error {
+	return nil
+}
+
+func (db *MemoryDB) updateInstanceDeleteTime(ctx context.Context, instanceID string, deleteTime time.Time) error {
+	return nil
+}
+
+func (db *MemoryDB) updateInstanceRescue(ctx context.Context, instanceID string, volumeID string, rescued bool) error {
+	return nil
+}
+
+func (db *MemoryDB) updateInstancePeer(ctx context.Context, instanceID string, peerID string, remoteID string) error {
+	return nil
+}
+
+func (db *MemoryDB) updateInstanceFailure(ctx context.Context, instanceID string, failure *types.Failure) error {
 	return nil
 }
 
-func (db *MemoryDB) updateTenant(tenant *types.Tenant) error {
+func (db *MemoryDB) updateTenant(ctx context.Context, tenant *types.Tenant) error {
 	return nil
 }
 
-func (db *MemoryDB) deleteTenant(tenantID string) error {
+func (db *MemoryDB) renumberTenant(ctx context.Context, tenant *types.Tenant) error {
+	return nil
+}
+
+func (db *MemoryDB) deleteTenant(ctx context.Context, tenantID string) error {
 	delete(db.tenants, tenantID)
 	return nil
 }
 
-func (db *MemoryDB) getImages() ([]types.Image, error) {
+func (db *MemoryDB) getImages(ctx context.Context) ([]types.Image, error) {
 	return []types.Image{}, nil
 }
 
-func (db *MemoryDB) updateImage(i types.Image) error {
+func (db *MemoryDB) updateImage(ctx context.Context, i types.Image) error {
 	return nil
 }
 
-func (db *MemoryDB) deleteImage(ID string) error {
+func (db *MemoryDB) deleteImage(ctx context.Context, ID string) error {
 	return nil
 }
+
+func (db *MemoryDB) addFailedCommand(ctx context.Context, cmd types.FailedCommand) error {
+	db.failedCommands = append(db.failedCommands, cmd)
+	return nil
+}
+
+func (db *MemoryDB) getFailedCommands(ctx context.Context) ([]types.FailedCommand, error) {
+	return append([]types.FailedCommand{}, db.failedCommands...), nil
+}
+
+func (db *MemoryDB) deleteFailedCommand(ctx context.Context, ID string) error {
+	for i := range db.failedCommands {
+		if db.failedCommands[i].ID == ID {
+			db.failedCommands = append(db.failedCommands[:i], db.failedCommands[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (db *MemoryDB) updateNodePolicy(ctx context.Context, policy types.NodePolicy) error {
+	db.nodePolicies[policy.NodeID] = policy
+	return nil
+}
+
+func (db *MemoryDB) deleteNodePolicy(ctx context.Context, nodeID string) error {
+	delete(db.nodePolicies, nodeID)
+	return nil
+}
+
+func (db *MemoryDB) getNodePolicies(ctx context.Context) ([]types.NodePolicy, error) {
+	policies := []types.NodePolicy{}
+	for _, policy := range db.nodePolicies {
+		policies = append(policies, policy)
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].NodeID < policies[j].NodeID
+	})
+	return policies, nil
+}
+
+func (db *MemoryDB) updateSecret(ctx context.Context, secret types.Secret) error {
+	if db.secrets[secret.TenantID] == nil {
+		db.secrets[secret.TenantID] = make(map[string]types.Secret)
+	}
+	db.secrets[secret.TenantID][secret.Name] = secret
+	return nil
+}
+
+func (db *MemoryDB) deleteSecret(ctx context.Context, tenantID string, name string) error {
+	delete(db.secrets[tenantID], name)
+	return nil
+}
+
+func (db *MemoryDB) getSecrets(ctx context.Context, tenantID string) ([]types.Secret, error) {
+	secrets := []types.Secret{}
+	for _, secret := range db.secrets[tenantID] {
+		secrets = append(secrets, secret)
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		return secrets[i].Name < secrets[j].Name
+	})
+	return secrets, nil
+}
+
+func (db *MemoryDB) addAPIToken(ctx context.Context, token types.APIToken) error {
+	db.apiTokens[token.ID] = token
+	return nil
+}
+
+func (db *MemoryDB) deleteAPIToken(ctx context.Context, ID string) error {
+	delete(db.apiTokens, ID)
+	return nil
+}
+
+func (db *MemoryDB) getAPIToken(ctx context.Context, ID string) (types.APIToken, error) {
+	token, ok := db.apiTokens[ID]
+	if !ok {
+		return types.APIToken{}, types.ErrAPITokenNotFound
+	}
+	return token, nil
+}
+
+func (db *MemoryDB) getAPITokens(ctx context.Context, tenantID string) ([]types.APIToken, error) {
+	tokens := []types.APIToken{}
+	for _, token := range db.apiTokens {
+		if token.TenantID == tenantID {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreateTime.Before(tokens[j].CreateTime)
+	})
+	return tokens, nil
+}
+
+func (db *MemoryDB) addFederationPeer(ctx context.Context, peer types.FederationPeer) error {
+	db.peers[peer.ID] = peer
+	return nil
+}
+
+func (db *MemoryDB) deleteFederationPeer(ctx context.Context, ID string) error {
+	delete(db.peers, ID)
+	return nil
+}
+
+func (db *MemoryDB) getFederationPeers(ctx context.Context) ([]types.FederationPeer, error) {
+	peers := []types.FederationPeer{}
+	for _, peer := range db.peers {
+		peers = append(peers, peer)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].CreateTime.Before(peers[j].CreateTime)
+	})
+	return peers, nil
+}
+
+func (db *MemoryDB) addSnapshotSet(ctx context.Context, set types.SnapshotSet) error {
+	db.snapshotSets[set.ID] = set
+	return nil
+}
+
+func (db *MemoryDB) updateSnapshotSet(ctx context.Context, set types.SnapshotSet) error {
+	db.snapshotSets[set.ID] = set
+	return nil
+}
+
+func (db *MemoryDB) deleteSnapshotSet(ctx context.Context, ID string) error {
+	delete(db.snapshotSets, ID)
+	return nil
+}
+
+func (db *MemoryDB) getSnapshotSets(ctx context.Context, tenantID string) ([]types.SnapshotSet, error) {
+	sets := []types.SnapshotSet{}
+	for _, set := range db.snapshotSets {
+		if set.TenantID == tenantID {
+			sets = append(sets, set)
+		}
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].CreateTime.Before(sets[j].CreateTime)
+	})
+	return sets, nil
+}
+
+func (db *MemoryDB) addSignedRequest(ctx context.Context, rec types.SignedRequestRecord) error {
+	for _, r := range db.signedRequests {
+		if r.Nonce == rec.Nonce {
+			return types.ErrSignedRequestReplayed
+		}
+	}
+
+	db.signedRequests = append(db.signedRequests, rec)
+	return nil
+}
+
+func (db *MemoryDB) getSignedRequests(ctx context.Context) ([]types.SignedRequestRecord, error) {
+	return append([]types.SignedRequestRecord{}, db.signedRequests...), nil
+}
@@ -16,14 +16,16 @@
 package datastore
 
 import (
+	"context"
 	"database/sql"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/url"
 	"os"
 	"path/filepath"
-	"reflect"
 	"strings"
 	"sync"
 	"time"
@@ -41,12 +43,15 @@ type sqliteDB struct {
 	dbName        string
 	tables        []persistentData
 	workloadsPath string
-	dbLock        *sync.Mutex
+	queryTimeout  time.Duration
+
+	// dbLock serialises the database operations.  It is a channel
+	// rather than a mutex so that waiting for it can be cancelled.
+	dbLock chan struct{}
 }
 
 type persistentData interface {
 	Init() error
-	Create(...string) error
 	Name() string
 	DB() *sql.DB
 }
@@ -57,11 +62,6 @@ type namedData struct {
 	db   *sql.DB
 }
 
-func (d namedData) Create(record ...string) (err error) {
-	err = d.ds.create(d.name, record)
-	return
-}
-
 func (d namedData) Name() (name string) {
 	return d.name
 }
@@ -104,6 +104,22 @@ func (d subnetData) Init() error {
 	return d.ds.exec(d.db, cmd)
 }
 
+type ipReservationData struct {
+	namedData
+}
+
+func (d ipReservationData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS tenant_ip_reservations
+		(
+		tenant_id varchar(32),
+		host unsigned int,
+		primary key(tenant_id, host),
+		foreign key(tenant_id) references tenants(id)
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
 // Handling of Instance specific data
 type instanceData struct {
 	namedData
@@ -122,12 +138,34 @@ func (d instanceData) Init() error {
 		create_time DATETIME,
 		name string,
 		cnci int,
+		preemptible int,
+		delete_time DATETIME,
+		rescue_volume string,
+		rescued int,
+		peer_id string,
+		remote_id string,
+		failure text,
+		group_name string,
 		foreign key(tenant_id) references tenants(id),
 		foreign key(workload_id) references workload_template(id),
 		unique(tenant_id, ip, mac_address)
 		);`
 
-	return d.ds.exec(d.db, cmd)
+	err := d.ds.exec(d.db, cmd)
+	if err != nil {
+		return err
+	}
+
+	return d.ds.addColumns(d.db, d.name, []string{
+		"preemptible int DEFAULT 0",
+		"delete_time DATETIME",
+		"rescue_volume string DEFAULT ''",
+		"rescued int DEFAULT 0",
+		"peer_id string DEFAULT ''",
+		"remote_id string DEFAULT ''",
+		"failure text DEFAULT 'null'",
+		"group_name string DEFAULT ''",
+	})
 }
 
 // Volume Data
@@ -146,10 +184,24 @@ func (d blockData) Init() error {
 		name string,
 		description string,
 		internal int,
+		class string,
+		encrypted int,
+		delete_time DATETIME,
+		failure text,
 		foreign key(tenant_id) references tenants(id)
 		);`
 
-	return d.ds.exec(d.db, cmd)
+	err := d.ds.exec(d.db, cmd)
+	if err != nil {
+		return err
+	}
+
+	return d.ds.addColumns(d.db, d.name, []string{
+		"class string DEFAULT ''",
+		"encrypted int DEFAULT 0",
+		"delete_time DATETIME",
+		"failure text DEFAULT 'null'",
+	})
 }
 
 type attachments struct {
@@ -188,10 +240,74 @@ func (d workloadStorage) Init() error {
 		source_type string,
 		source_id string,
 		tag string,
+		class string,
+		cdrom int,
 		foreign key(workload_id) references workloads(id),
 		foreign key(volume_id) references block_data(id)
 		);`
 
+	err := d.ds.exec(d.db, cmd)
+	if err != nil {
+		return err
+	}
+
+	return d.ds.addColumns(d.db, d.name, []string{
+		"class string DEFAULT ''",
+		"cdrom int DEFAULT 0",
+	})
+}
+
+// workload catalog entries
+
+type workloadCatalog struct {
+	namedData
+}
+
+func (d workloadCatalog) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS workload_catalog
+		(
+		workload_id varchar(32) primary key,
+		category text,
+		foreign key(workload_id) references workload_template(id)
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+// workload autoscaling policies
+
+type workloadAutoscale struct {
+	namedData
+}
+
+func (d workloadAutoscale) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS workload_autoscale
+		(
+		workload_id varchar(32) primary key,
+		min_instances int,
+		max_instances int,
+		cpu_target int,
+		foreign key(workload_id) references workload_template(id)
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+// workload secret references
+
+type workloadSecrets struct {
+	namedData
+}
+
+func (d workloadSecrets) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS workload_secrets
+		(
+		workload_id varchar(32),
+		name string,
+		primary key(workload_id, name),
+		foreign key(workload_id) references workload_template(id)
+		);`
+
 	return d.ds.exec(d.db, cmd)
 }
 
@@ -206,10 +322,18 @@ func (d tenantData) Init() error {
 		id varchar(32) primary key,
 		name text,
 		subnet_bits int,
-		permissions text
+		permissions text,
+		cnci_flavor text
 		);`
 
-	return d.ds.exec(d.db, cmd)
+	err := d.ds.exec(d.db, cmd)
+	if err != nil {
+		return err
+	}
+
+	return d.ds.addColumns(d.db, d.name, []string{
+		"cnci_flavor text DEFAULT 'null'",
+	})
 }
 
 // workload template data
@@ -412,6 +536,137 @@ func (d imageData) Init() error {
 	return d.ds.exec(d.db, cmd)
 }
 
+type failedCommandData struct {
+	namedData
+}
+
+func (d failedCommandData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS failed_commands
+		(
+			id varchar(32) primary key,
+			command string,
+			instance_id varchar(32),
+			node_id varchar(32),
+			reason string,
+			timestamp DATETIME,
+			payload string
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+type nodePolicyData struct {
+	namedData
+}
+
+func (d nodePolicyData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS node_policies
+		(
+			node_id varchar(32) primary key,
+			weight int,
+			max_instances int,
+			allow_cnci_colocation int,
+			dedicated_tenant varchar(32)
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+type secretData struct {
+	namedData
+}
+
+func (d secretData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS secrets
+		(
+			tenant_id varchar(32),
+			name string,
+			create_time DATETIME,
+			data blob,
+			primary key(tenant_id, name)
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+type apiTokenData struct {
+	namedData
+}
+
+func (d apiTokenData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS api_tokens
+		(
+			id string primary key,
+			tenant_id varchar(32),
+			name string,
+			scope string,
+			create_time DATETIME,
+			expiry_time DATETIME,
+			hash blob
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+type federationPeerData struct {
+	namedData
+}
+
+func (d federationPeerData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS federation_peers
+		(
+			id string primary key,
+			name string,
+			url string,
+			tenant_id string,
+			ca_cert_file string,
+			workloads string,
+			create_time DATETIME,
+			token blob
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+type snapshotSetData struct {
+	namedData
+}
+
+func (d snapshotSetData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS snapshot_sets
+		(
+			id string primary key,
+			name string,
+			tenant_id string,
+			instance_id string,
+			workload_id string,
+			state string,
+			consistent int,
+			create_time DATETIME,
+			volumes string
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+type signedRequestData struct {
+	namedData
+}
+
+func (d signedRequestData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS signed_requests
+		(
+			nonce string primary key,
+			method string,
+			path string,
+			signer string,
+			created DATETIME,
+			submit_time DATETIME
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
 func (ds *sqliteDB) exec(db *sql.DB, cmd string) error {
 	glog.V(2).Info("exec: ", cmd)
 
@@ -420,42 +675,60 @@ func (ds *sqliteDB) exec(db *sql.DB, cmd string) error {
 	return err
 }
 
-// This function is deprecated and will be removed soon. It should not be used
-// for newly written or updated code.
-func (ds *sqliteDB) create(tableName string, record ...interface{}) error {
-	// get database location of this table
-	db := ds.getTableDB(tableName)
-
-	if db == nil {
-		return errors.New("Bad table name")
+// addColumns adds the columns which are missing from an existing table,
+// i.e., the columns added to its definition after the database was
+// created.  The columns are added in order, after those of the existing
+// table, and are described as in a CREATE TABLE statement, with the
+// default value of the rows already in the table.
+func (ds *sqliteDB) addColumns(db *sql.DB, table string, columns []string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return errors.Wrapf(err, "Error getting columns of table %s", table)
 	}
+	defer func() { _ = rows.Close() }()
 
-	var values []string
-	for _, val := range record {
-		v := reflect.ValueOf(val)
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue interface{}
 
-		var newval string
+		err = rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk)
+		if err != nil {
+			return errors.Wrapf(err, "Error reading columns of table %s", table)
+		}
+		existing[name] = true
+	}
 
-		// enclose strings in quotes to not confuse sqlite
-		if v.Kind() == reflect.String {
-			newval = fmt.Sprintf("'%v'", val)
-		} else if v.Kind() == reflect.Bool {
-			if val.(bool) {
-				newval = "1"
-			} else {
-				newval = "0"
-			}
-		} else {
-			newval = fmt.Sprintf("%v", val)
+	if err = rows.Err(); err != nil {
+		return errors.Wrapf(err, "Error reading columns of table %s", table)
+	}
+
+	for _, column := range columns {
+		name := strings.Fields(column)[0]
+		if existing[name] {
+			continue
 		}
 
-		values = append(values, newval)
+		glog.Infof("Adding column %s to table %s", name, table)
+
+		err = ds.exec(db, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, column))
+		if err != nil {
+			return errors.Wrapf(err, "Error adding column %s to table %s", name, table)
+		}
 	}
 
-	args := strings.Join(values, ",")
-	cmd := "INSERT into " + tableName + " VALUES (" + args + ");"
+	return nil
+}
+
+// nullTime returns the value stored for an optional time, NULL when
+// the time is not set.
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
 
-	return ds.exec(db, cmd)
+	return t.Format(time.RFC3339Nano)
 }
 
 func (ds *sqliteDB) getTableDB(name string) *sql.DB {
@@ -468,6 +741,61 @@ func (ds *sqliteDB) getTableDB(name string) *sql.DB {
 	return nil
 }
 
+// operation returns the context of a database operation, cancelled when
+// ctx is done or after the query timeout, and the function to call once
+// the operation completes.  The sqlite driver interrupts the connection of
+// a statement whenever its context is done, even after the statement has
+// completed, so the context of an operation is never cancelled once it has
+// completed lest the next statement run on the connection be interrupted.
+func (ds *sqliteDB) operation(ctx context.Context) (context.Context, func()) {
+	opCtx, cancel := context.WithCancel(context.Background())
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		var timeout <-chan time.Time
+		if ds.queryTimeout > 0 {
+			timer := time.NewTimer(ds.queryTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-timeout:
+			cancel()
+		case <-stop:
+		}
+	}()
+
+	return opCtx, func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// lock waits for exclusive access to the database and starts an
+// operation.  The returned function completes the operation and releases
+// the database.  An error is returned if ctx is done before the database
+// becomes available.
+func (ds *sqliteDB) lock(ctx context.Context) (context.Context, func(), error) {
+	select {
+	case ds.dbLock <- struct{}{}:
+	case <-ctx.Done():
+		return ctx, nil, errors.Wrap(ctx.Err(), "Error waiting for database")
+	}
+
+	opCtx, done := ds.operation(ctx)
+
+	return opCtx, func() {
+		done()
+		<-ds.dbLock
+	}, nil
+}
+
 // init initializes the private data for the database object.
 // The datastore caches are also filled.
 func (ds *sqliteDB) init(config Config) error {
@@ -489,7 +817,8 @@ func (ds *sqliteDB) init(config Config) error {
 		return err
 	}
 
-	ds.dbLock = &sync.Mutex{}
+	ds.dbLock = make(chan struct{}, 1)
+	ds.queryTimeout = config.QueryTimeout
 
 	ds.tables = []persistentData{
 		tenantData{namedData{ds: ds, name: "tenants", db: ds.db}},
@@ -498,18 +827,29 @@ func (ds *sqliteDB) init(config Config) error {
 		nodeStatisticsData{namedData{ds: ds, name: "node_statistics", db: ds.db}},
 		logData{namedData{ds: ds, name: "log", db: ds.db}},
 		subnetData{namedData{ds: ds, name: "tenant_network", db: ds.db}},
+		ipReservationData{namedData{ds: ds, name: "tenant_ip_reservations", db: ds.db}},
 		instanceStatisticsData{namedData{ds: ds, name: "instance_statistics", db: ds.db}},
 		frameStatisticsData{namedData{ds: ds, name: "frame_statistics", db: ds.db}},
 		traceData{namedData{ds: ds, name: "trace_data", db: ds.db}},
 		blockData{namedData{ds: ds, name: "block_data", db: ds.db}},
 		attachments{namedData{ds: ds, name: "attachments", db: ds.db}},
 		workloadStorage{namedData{ds: ds, name: "workload_storage", db: ds.db}},
+		workloadCatalog{namedData{ds: ds, name: "workload_catalog", db: ds.db}},
+		workloadAutoscale{namedData{ds: ds, name: "workload_autoscale", db: ds.db}},
+		workloadSecrets{namedData{ds: ds, name: "workload_secrets", db: ds.db}},
 		poolData{namedData{ds: ds, name: "pools", db: ds.db}},
 		subnetPoolData{namedData{ds: ds, name: "subnet_pool", db: ds.db}},
 		addressData{namedData{ds: ds, name: "address_pool", db: ds.db}},
 		mappedIPData{namedData{ds: ds, name: "mapped_ips", db: ds.db}},
 		quotaData{namedData{ds: ds, name: "quotas", db: ds.db}},
 		imageData{namedData{ds: ds, name: "images", db: ds.db}},
+		failedCommandData{namedData{ds: ds, name: "failed_commands", db: ds.db}},
+		nodePolicyData{namedData{ds: ds, name: "node_policies", db: ds.db}},
+		secretData{namedData{ds: ds, name: "secrets", db: ds.db}},
+		apiTokenData{namedData{ds: ds, name: "api_tokens", db: ds.db}},
+		federationPeerData{namedData{ds: ds, name: "federation_peers", db: ds.db}},
+		snapshotSetData{namedData{ds: ds, name: "snapshot_sets", db: ds.db}},
+		signedRequestData{namedData{ds: ds, name: "signed_requests", db: ds.db}},
 	}
 
 	ds.workloadsPath = config.InitWorkloadsPath
@@ -576,44 +916,62 @@ func (ds *sqliteDB) disconnect() {
 	_ = ds.db.Close()
 }
 
-func (ds *sqliteDB) logEvent(event types.LogEntry) error {
+func (ds *sqliteDB) ping(ctx context.Context) error {
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var one int
+	err = ds.db.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+	return errors.Wrap(err, "Error querying database")
+}
+
+func (ds *sqliteDB) logEvent(ctx context.Context, event types.LogEntry) error {
 	db := ds.getTableDB("log")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	_, err := db.Exec("INSERT INTO log (tenant_id, node_id, type, message) VALUES (?, ?, ?, ?)", event.TenantID, event.NodeID, event.EventType, event.Message)
+	_, err = db.ExecContext(ctx, "INSERT INTO log (tenant_id, node_id, type, message) VALUES (?, ?, ?, ?)", event.TenantID, event.NodeID, event.EventType, event.Message)
 
-	return err
+	return errors.Wrap(err, "Error adding event to database")
 }
 
 // ClearLog will remove all the event entries from the event log
-func (ds *sqliteDB) clearLog() error {
+func (ds *sqliteDB) clearLog(ctx context.Context) error {
 	db := ds.getTableDB("log")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	_, err := db.Exec("DELETE FROM log")
+	_, err = db.ExecContext(ctx, "DELETE FROM log")
 
-	return err
+	return errors.Wrap(err, "Error deleting event from database")
 }
 
-func (ds *sqliteDB) getConfig(ID string) (string, error) {
+func (ds *sqliteDB) getConfig(ctx context.Context, ID string) (string, error) {
 	var configFile string
 
 	db := ds.getTableDB("workload_template")
 
-	err := db.QueryRow("SELECT filename FROM workload_template where id = ?", ID).Scan(&configFile)
+	err := db.QueryRowContext(ctx, "SELECT filename FROM workload_template where id = ?", ID).Scan(&configFile)
 
 	if err != nil {
-		return "", err
+		return "", errors.Wrap(err, "Error getting workload configuration from database")
 	}
 
 	path := fmt.Sprintf("%s/%s", ds.workloadsPath, configFile)
 	bytes, err := ioutil.ReadFile(path)
 	if err != nil {
-		return "", err
+		return "", errors.Wrap(err, "Error reading workload configuration")
 	}
 
 	config := string(bytes)
@@ -622,28 +980,104 @@ func (ds *sqliteDB) getConfig(ID string) (string, error) {
 }
 
 // lock must be held by caller
-func (ds *sqliteDB) createWorkloadStorage(tx *sql.Tx, workloadID string, storage *types.StorageResource) error {
-	_, err := tx.Exec("INSERT INTO workload_storage (workload_id, volume_id, bootable, ephemeral, size, source_type, source_id, tag) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", workloadID, storage.ID, storage.Bootable, storage.Ephemeral, storage.Size, string(storage.SourceType), storage.Source, storage.Tag)
+func (ds *sqliteDB) createWorkloadStorage(ctx context.Context, tx *sql.Tx, workloadID string, storage *types.StorageResource) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO workload_storage (workload_id, volume_id, bootable, ephemeral, size, source_type, source_id, tag, class, cdrom) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", workloadID, storage.ID, storage.Bootable, storage.Ephemeral, storage.Size, string(storage.SourceType), storage.Source, storage.Tag, storage.Class, storage.CDROM)
 
-	return err
+	return errors.Wrap(err, "Error adding workload storage to database")
 }
 
 // lock must be held by caller
-func (ds *sqliteDB) deleteWorkloadStorage(tx *sql.Tx, workloadID string) error {
-	_, err := tx.Exec("DELETE FROM workload_storage WHERE workload_id = ?", workloadID)
+func (ds *sqliteDB) deleteWorkloadStorage(ctx context.Context, tx *sql.Tx, workloadID string) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM workload_storage WHERE workload_id = ?", workloadID)
 
-	return err
+	return errors.Wrap(err, "Error deleting workload storage from database")
+}
+
+func (ds *sqliteDB) setWorkloadCategory(ctx context.Context, tx *sql.Tx, workloadID string, category string) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM workload_catalog WHERE workload_id = ?", workloadID)
+	if err != nil || category == "" {
+		return errors.Wrap(err, "Error deleting workload category from database")
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO workload_catalog (workload_id, category) VALUES (?, ?)", workloadID, category)
+	return errors.Wrap(err, "Error adding workload category to database")
+}
+
+func (ds *sqliteDB) getWorkloadCategory(ctx context.Context, ID string) (string, error) {
+	var category string
+
+	err := ds.db.QueryRowContext(ctx, "SELECT category FROM workload_catalog WHERE workload_id = ?", ID).Scan(&category)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return category, errors.Wrap(err, "Error getting workload category from database")
+}
+
+func (ds *sqliteDB) createWorkloadAutoscale(ctx context.Context, tx *sql.Tx, workloadID string, policy *types.AutoscalePolicy) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO workload_autoscale (workload_id, min_instances, max_instances, cpu_target) VALUES (?, ?, ?, ?)", workloadID, policy.MinInstances, policy.MaxInstances, policy.CPUTarget)
+	return errors.Wrap(err, "Error adding workload autoscale policy to database")
+}
+
+func (ds *sqliteDB) getWorkloadAutoscale(ctx context.Context, ID string) (*types.AutoscalePolicy, error) {
+	var policy types.AutoscalePolicy
+
+	err := ds.db.QueryRowContext(ctx, "SELECT min_instances, max_instances, cpu_target FROM workload_autoscale WHERE workload_id = ?", ID).Scan(&policy.MinInstances, &policy.MaxInstances, &policy.CPUTarget)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "Error getting workload autoscale policy from database")
+	}
+
+	return &policy, nil
+}
+
+func (ds *sqliteDB) createWorkloadSecrets(ctx context.Context, tx *sql.Tx, workloadID string, names []string) error {
+	for _, name := range names {
+		_, err := tx.ExecContext(ctx, "INSERT INTO workload_secrets (workload_id, name) VALUES (?, ?)", workloadID, name)
+		if err != nil {
+			return errors.Wrap(err, "Error adding workload secret to database")
+		}
+	}
+
+	return nil
+}
+
+func (ds *sqliteDB) getWorkloadSecrets(ctx context.Context, ID string) ([]string, error) {
+	var names []string
+
+	rows, err := ds.db.QueryContext(ctx, "SELECT name FROM workload_secrets WHERE workload_id = ? ORDER BY name", ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting workload secrets from database")
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var name string
+
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error reading workload secret row from database")
+		}
+
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
 }
 
-func (ds *sqliteDB) getWorkloadStorage(ID string) ([]types.StorageResource, error) {
+func (ds *sqliteDB) getWorkloadStorage(ctx context.Context, ID string) ([]types.StorageResource, error) {
+	ctx, done := ds.operation(ctx)
+	defer done()
+
 	query := `SELECT volume_id, bootable, ephemeral, size,
-			 source_type, source_id, tag
+			 source_type, source_id, tag, class, cdrom
 		  FROM 	workload_storage
 		  WHERE workload_id = ?`
 
-	rows, err := ds.db.Query(query, ID)
+	rows, err := ds.db.QueryContext(ctx, query, ID)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "Error getting workload storage from database")
 	}
 	defer func() { _ = rows.Close() }()
 
@@ -652,10 +1086,10 @@ func (ds *sqliteDB) getWorkloadStorage(ID string) ([]types.StorageResource, erro
 
 	for rows.Next() {
 		var r types.StorageResource
-		err := rows.Scan(&r.ID, &r.Bootable, &r.Ephemeral, &r.Size, &sourceType, &r.Source, &r.Tag)
+		err := rows.Scan(&r.ID, &r.Bootable, &r.Ephemeral, &r.Size, &sourceType, &r.Source, &r.Tag, &r.Class, &r.CDROM)
 
 		if err != nil {
-			return []types.StorageResource{}, err
+			return []types.StorageResource{}, errors.Wrap(err, "Error reading workload storage row from database")
 		}
 		r.SourceType = types.SourceType(sourceType)
 		res = append(res, r)
@@ -663,65 +1097,89 @@ func (ds *sqliteDB) getWorkloadStorage(ID string) ([]types.StorageResource, erro
 	return res, nil
 }
 
-func (ds *sqliteDB) addTenant(ID string, config types.TenantConfig) error {
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+func (ds *sqliteDB) addTenant(ctx context.Context, ID string, config types.TenantConfig) error {
+	db := ds.getTableDB("tenants")
+
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	perms, err := json.Marshal(config.Permissions)
 	if err != nil {
 		return errors.Wrap(err, "Error marshalling permissions")
 	}
 
-	err = ds.create("tenants", ID, config.Name, config.SubnetBits, string(perms))
+	flavor, err := json.Marshal(config.CNCIFlavor)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling CNCI flavor")
+	}
 
-	return err
+	_, err = db.ExecContext(ctx, "INSERT INTO tenants (id, name, subnet_bits, permissions, cnci_flavor) VALUES (?, ?, ?, ?, ?)", ID, config.Name, config.SubnetBits, string(perms), string(flavor))
+
+	return errors.Wrap(err, "Error adding tenant to database")
 }
 
-func (ds *sqliteDB) getTenant(ID string) (*tenant, error) {
+func (ds *sqliteDB) getTenant(ctx context.Context, ID string) (*tenant, error) {
+	ctx, done := ds.operation(ctx)
+	defer done()
+
 	query := `SELECT	tenants.id,
 				tenants.name,
 				tenants.subnet_bits,
-				tenants.permissions
+				tenants.permissions,
+				tenants.cnci_flavor
 		  FROM tenants
 		  WHERE tenants.id = ?`
 
 	db := ds.db
 
-	row := db.QueryRow(query, ID)
+	row := db.QueryRowContext(ctx, query, ID)
 
 	t := &tenant{}
 
 	var perms []byte
-	err := row.Scan(&t.ID, &t.Name, &t.SubnetBits, &perms)
+	var flavor []byte
+	err := row.Scan(&t.ID, &t.Name, &t.SubnetBits, &perms, &flavor)
 	if err != nil {
 		glog.Warning("unable to retrieve tenant from tenants")
 
 		if err == sql.ErrNoRows {
 			// not an error, it's just not there.
-			err = nil
+			return nil, nil
 		}
 
-		return nil, err
+		return nil, errors.Wrap(err, "Error getting tenant from database")
 	}
 
 	if err := json.Unmarshal(perms, &t.Permissions); err != nil {
 		return nil, errors.Wrap(err, "Error unmarshalling permissions")
 	}
 
+	if err := json.Unmarshal(flavor, &t.CNCIFlavor); err != nil {
+		return nil, errors.Wrap(err, "Error unmarshalling CNCI flavor")
+	}
+
 	// for these items below, its ok to get err returned
 	// because a tenant could simply not have used any
 	// resources or networks yet.
-	err = ds.getTenantNetwork(t)
+	err = ds.getTenantNetwork(ctx, t)
+	if err != nil {
+		glog.V(2).Info(err)
+	}
+
+	err = ds.getTenantIPReservations(ctx, t)
 	if err != nil {
 		glog.V(2).Info(err)
 	}
 
-	t.instances, err = ds.getTenantInstances(t.ID)
+	t.instances, err = ds.getTenantInstances(ctx, t.ID)
 	if err != nil {
 		glog.V(2).Info(err)
 	}
 
-	t.devices, err = ds.getTenantDevices(t.ID)
+	t.devices, err = ds.getTenantDevices(ctx, t.ID)
 	if err != nil {
 		glog.V(2).Info(err)
 	}
@@ -729,7 +1187,10 @@ func (ds *sqliteDB) getTenant(ID string) (*tenant, error) {
 	return t, err
 }
 
-func (ds *sqliteDB) getWorkloads() ([]types.Workload, error) {
+func (ds *sqliteDB) getWorkloads(ctx context.Context) ([]types.Workload, error) {
+	ctx, done := ds.operation(ctx)
+	defer done()
+
 	var workloads []types.Workload
 
 	db := ds.db
@@ -744,9 +1205,9 @@ func (ds *sqliteDB) getWorkloads() ([]types.Workload, error) {
 			 requirements
 		  FROM workload_template`
 
-	rows, err := db.Query(query)
+	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "Error getting workloads from database")
 	}
 	defer func() { _ = rows.Close() }()
 
@@ -759,12 +1220,12 @@ func (ds *sqliteDB) getWorkloads() ([]types.Workload, error) {
 
 		err = rows.Scan(&wl.ID, &wl.TenantID, &wl.Description, &wl.FWType, &VMType, &wl.ImageName, &visibility, &requirements)
 		if err != nil {
-			return nil, err
+			return nil, errors.Wrap(err, "Error reading workload row from database")
 		}
 
 		err = json.Unmarshal(requirements, &wl.Requirements)
 		if err != nil {
-			return nil, err
+			return nil, errors.Wrap(err, "Error unmarshalling workload requirements")
 		}
 
 		wl.Visibility = types.Visibility(visibility)
@@ -773,42 +1234,60 @@ func (ds *sqliteDB) getWorkloads() ([]types.Workload, error) {
 			continue
 		}
 
-		wl.Config, err = ds.getConfig(wl.ID)
+		wl.Config, err = ds.getConfig(ctx, wl.ID)
 		if err != nil {
 			return nil, err
 		}
 
-		wl.Storage, err = ds.getWorkloadStorage(wl.ID)
+		wl.Storage, err = ds.getWorkloadStorage(ctx, wl.ID)
 		if err != nil {
 			return nil, err
 		}
 
-		wl.VMType = payloads.Hypervisor(VMType)
+		wl.Category, err = ds.getWorkloadCategory(ctx, wl.ID)
+		if err != nil {
+			return nil, err
+		}
 
-		workloads = append(workloads, wl)
-	}
+		wl.Autoscale, err = ds.getWorkloadAutoscale(ctx, wl.ID)
+		if err != nil {
+			return nil, err
+		}
 
-	if err = rows.Err(); err != nil {
-		return nil, err
+		wl.Secrets, err = ds.getWorkloadSecrets(ctx, wl.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		wl.VMType = payloads.Hypervisor(VMType)
+
+		workloads = append(workloads, wl)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "Error reading workloads from database")
 	}
 
 	return workloads, nil
 }
 
-func (ds *sqliteDB) addWorkload(w types.Workload) error {
+func (ds *sqliteDB) addWorkload(ctx context.Context, w types.Workload) error {
 	db := ds.getTableDB("workload_template")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
-
-	tx, err := db.Begin()
+	ctx, unlock, err := ds.lock(ctx)
 	if err != nil {
 		return err
 	}
+	defer unlock()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction for workload")
+	}
 
 	// add in any workload storage resources
 	for i := range w.Storage {
-		err := ds.createWorkloadStorage(tx, w.ID, &w.Storage[i])
+		err := ds.createWorkloadStorage(ctx, tx, w.ID, &w.Storage[i])
 		if err != nil {
 			_ = tx.Rollback()
 			return err
@@ -821,61 +1300,128 @@ func (ds *sqliteDB) addWorkload(w types.Workload) error {
 	err = ioutil.WriteFile(path, []byte(w.Config), 0644)
 	if err != nil {
 		_ = tx.Rollback()
-		return err
+		return errors.Wrap(err, "Error writing workload configuration")
 	}
 
 	requirements, err := json.Marshal(w.Requirements)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, "Error marshalling workload requirements")
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO workload_template (id, tenant_id, description, filename, fw_type, vm_type, image_name, visibility, requirements) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", w.ID, w.TenantID, w.Description, filename, w.FWType, string(w.VMType), w.ImageName, w.Visibility, string(requirements))
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, "Error adding workload to database")
+	}
+
+	err = ds.setWorkloadCategory(ctx, tx, w.ID, w.Category)
 	if err != nil {
 		_ = tx.Rollback()
 		return err
 	}
 
-	_, err = tx.Exec("INSERT INTO workload_template (id, tenant_id, description, filename, fw_type, vm_type, image_name, visibility, requirements) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", w.ID, w.TenantID, w.Description, filename, w.FWType, string(w.VMType), w.ImageName, w.Visibility, string(requirements))
+	if w.Autoscale != nil {
+		err = ds.createWorkloadAutoscale(ctx, tx, w.ID, w.Autoscale)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	err = ds.createWorkloadSecrets(ctx, tx, w.ID, w.Secrets)
 	if err != nil {
 		_ = tx.Rollback()
 		return err
 	}
 
 	err = tx.Commit()
-	return err
+	return errors.Wrap(err, "Error committing transaction for workload")
 }
 
-func (ds *sqliteDB) deleteWorkload(ID string) error {
-	db := ds.getTableDB("workload_template")
+func (ds *sqliteDB) updateWorkloadCategory(ctx context.Context, ID string, category string) error {
+	db := ds.getTableDB("workload_catalog")
+
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction for workload category")
+	}
+
+	err = ds.setWorkloadCategory(ctx, tx, ID, category)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return errors.Wrap(tx.Commit(), "Error committing transaction for workload category")
+}
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+func (ds *sqliteDB) deleteWorkload(ctx context.Context, ID string) error {
+	db := ds.getTableDB("workload_template")
 
-	tx, err := db.Begin()
+	ctx, unlock, err := ds.lock(ctx)
 	if err != nil {
 		return err
 	}
+	defer unlock()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction for workload")
+	}
 
-	err = ds.deleteWorkloadStorage(tx, ID)
+	err = ds.deleteWorkloadStorage(ctx, tx, ID)
 	if err != nil {
 		_ = tx.Rollback()
 		return err
 	}
 
-	_, err = tx.Exec("DELETE FROM workload_template WHERE id = ?", ID)
+	err = ds.setWorkloadCategory(ctx, tx, ID, "")
 	if err != nil {
 		_ = tx.Rollback()
 		return err
 	}
 
+	_, err = tx.ExecContext(ctx, "DELETE FROM workload_autoscale WHERE workload_id = ?", ID)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, "Error deleting workload autoscale policy from database")
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM workload_secrets WHERE workload_id = ?", ID)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, "Error deleting workload secret from database")
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM workload_template WHERE id = ?", ID)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, "Error deleting workload from database")
+	}
+
 	filename := fmt.Sprintf("%s_config.yaml", ID)
 	path := filepath.Join(ds.workloadsPath, filename)
 	err = os.Remove(path)
 	if err != nil {
 		_ = tx.Rollback()
-		return err
+		return errors.Wrap(err, "Error removing workload configuration")
 	}
 
 	err = tx.Commit()
-	return err
+	return errors.Wrap(err, "Error committing transaction for workload")
 }
 
-func (ds *sqliteDB) getTenants() ([]*tenant, error) {
+func (ds *sqliteDB) getTenants(ctx context.Context) ([]*tenant, error) {
+	ctx, done := ds.operation(ctx)
+	defer done()
+
 	var tenants []*tenant
 
 	db := ds.getTableDB("tenants")
@@ -883,12 +1429,13 @@ func (ds *sqliteDB) getTenants() ([]*tenant, error) {
 	query := `SELECT	tenants.id,
 				tenants.name,
 				tenants.subnet_bits,
-				tenants.permissions
+				tenants.permissions,
+				tenants.cnci_flavor
 		  FROM tenants `
 
-	rows, err := db.Query(query)
+	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "Error getting tenants from database")
 	}
 	defer func() { _ = rows.Close() }()
 
@@ -896,11 +1443,12 @@ func (ds *sqliteDB) getTenants() ([]*tenant, error) {
 		var id sql.NullString
 		var name sql.NullString
 		var perms []byte
+		var flavor []byte
 
 		t := new(tenant)
-		err = rows.Scan(&id, &name, &t.SubnetBits, &perms)
+		err = rows.Scan(&id, &name, &t.SubnetBits, &perms, &flavor)
 		if err != nil {
-			return nil, err
+			return nil, errors.Wrap(err, "Error reading tenant row from database")
 		}
 
 		if id.Valid {
@@ -915,17 +1463,26 @@ func (ds *sqliteDB) getTenants() ([]*tenant, error) {
 			return nil, errors.Wrap(err, "Error getting unmarshalling permissions")
 		}
 
-		err = ds.getTenantNetwork(t)
+		if err := json.Unmarshal(flavor, &t.CNCIFlavor); err != nil {
+			return nil, errors.Wrap(err, "Error unmarshalling CNCI flavor")
+		}
+
+		err = ds.getTenantNetwork(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+
+		err = ds.getTenantIPReservations(ctx, t)
 		if err != nil {
 			return nil, err
 		}
 
-		t.instances, err = ds.getTenantInstances(t.ID)
+		t.instances, err = ds.getTenantInstances(ctx, t.ID)
 		if err != nil {
 			return nil, err
 		}
 
-		t.devices, err = ds.getTenantDevices(t.ID)
+		t.devices, err = ds.getTenantDevices(ctx, t.ID)
 		if err != nil {
 			return nil, err
 		}
@@ -933,71 +1490,83 @@ func (ds *sqliteDB) getTenants() ([]*tenant, error) {
 		tenants = append(tenants, t)
 	}
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "Error reading tenants from database")
 	}
 
 	return tenants, nil
 }
 
-func (ds *sqliteDB) claimTenantIP(tenantID string, subnetInt uint32, rest uint32) error {
+func (ds *sqliteDB) claimTenantIP(ctx context.Context, tenantID string, subnetInt uint32, rest uint32) error {
 	db := ds.getTableDB("tenant_network")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	_, err := db.Exec("INSERT INTO tenant_network VALUES(?, ?, ?)", tenantID, subnetInt, rest)
+	_, err = db.ExecContext(ctx, "INSERT INTO tenant_network VALUES(?, ?, ?)", tenantID, subnetInt, rest)
 
-	return err
+	return errors.Wrap(err, "Error adding tenant IP to database")
 }
 
-func (ds *sqliteDB) claimTenantIPs(tenantID string, IPs []tenantIP) error {
+func (ds *sqliteDB) claimTenantIPs(ctx context.Context, tenantID string, IPs []tenantIP) error {
 	db := ds.getTableDB("tenant_network")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
-
-	tx, err := db.Begin()
+	ctx, unlock, err := ds.lock(ctx)
 	if err != nil {
 		return err
 	}
+	defer unlock()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction for tenant IPs")
+	}
 
 	cmd := `INSERT INTO tenant_network VALUES(?, ?, ?)`
 
-	stmt, err := tx.Prepare(cmd)
+	stmt, err := tx.PrepareContext(ctx, cmd)
 	if err != nil {
 		tx.Rollback()
-		return err
+		return errors.Wrap(err, "Error preparing statement for tenant IPs")
 	}
 
 	defer stmt.Close()
 
 	for _, ip := range IPs {
-		_, err = stmt.Exec(tenantID, ip.subnet, ip.host)
+		_, err = stmt.ExecContext(ctx, tenantID, ip.subnet, ip.host)
 		if err != nil {
 			tx.Rollback()
-			return err
+			return errors.Wrap(err, "Error adding tenant IP to database")
 		}
 	}
 
-	return tx.Commit()
+	return errors.Wrap(tx.Commit(), "Error committing transaction for tenant IPs")
 }
 
-func (ds *sqliteDB) releaseTenantIP(tenantID string, subnetInt uint32, rest uint32) error {
+func (ds *sqliteDB) releaseTenantIP(ctx context.Context, tenantID string, subnetInt uint32, rest uint32) error {
 	db := ds.getTableDB("tenant_network")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	_, err := db.Exec("DELETE FROM tenant_network WHERE tenant_id = ? AND subnet = ? AND rest = ?", tenantID, subnetInt, rest)
+	_, err = db.ExecContext(ctx, "DELETE FROM tenant_network WHERE tenant_id = ? AND subnet = ? AND rest = ?", tenantID, subnetInt, rest)
 
-	return err
+	return errors.Wrap(err, "Error deleting tenant IP from database")
 }
 
-func (ds *sqliteDB) getTenantNetwork(tenant *tenant) error {
+func (ds *sqliteDB) getTenantNetwork(ctx context.Context, tenant *tenant) error {
 	tenant.network = make(map[uint32]map[uint32]bool)
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	db := ds.getTableDB("tenant_network")
 
@@ -1006,9 +1575,9 @@ func (ds *sqliteDB) getTenantNetwork(tenant *tenant) error {
 		  FROM tenant_network
 		  WHERE tenant_id = ?`
 
-	rows, err := db.Query(query, tenant.ID)
+	rows, err := db.QueryContext(ctx, query, tenant.ID)
 	if err != nil {
-		return err
+		return errors.Wrap(err, "Error getting tenant network from database")
 	}
 	defer func() { _ = rows.Close() }()
 
@@ -1018,7 +1587,7 @@ func (ds *sqliteDB) getTenantNetwork(tenant *tenant) error {
 
 		err = rows.Scan(&subnetInt, &rest)
 		if err != nil {
-			return err
+			return errors.Wrap(err, "Error reading tenant network row from database")
 		}
 
 		_, ok := tenant.network[subnetInt]
@@ -1033,58 +1602,177 @@ func (ds *sqliteDB) getTenantNetwork(tenant *tenant) error {
 	return err
 }
 
-func (ds *sqliteDB) updateTenant(tenant *types.Tenant) error {
+func (ds *sqliteDB) getTenantIPReservations(ctx context.Context, tenant *tenant) error {
+	tenant.reservedIPs = make(map[uint32]bool)
+
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	db := ds.getTableDB("tenant_ip_reservations")
+
+	rows, err := db.QueryContext(ctx, "SELECT host FROM tenant_ip_reservations WHERE tenant_id = ?", tenant.ID)
+	if err != nil {
+		return errors.Wrap(err, "Error getting tenant IP reservations from database")
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var host uint32
+
+		err = rows.Scan(&host)
+		if err != nil {
+			return errors.Wrap(err, "Error reading tenant IP reservation row from database")
+		}
+
+		tenant.reservedIPs[host] = true
+	}
+
+	return rows.Err()
+}
+
+func (ds *sqliteDB) addTenantIPReservation(ctx context.Context, tenantID string, host uint32) error {
+	db := ds.getTableDB("tenant_ip_reservations")
+
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = db.ExecContext(ctx, "INSERT INTO tenant_ip_reservations VALUES(?, ?)", tenantID, host)
+
+	return errors.Wrap(err, "Error adding tenant IP reservation to database")
+}
+
+func (ds *sqliteDB) deleteTenantIPReservation(ctx context.Context, tenantID string, host uint32) error {
+	db := ds.getTableDB("tenant_ip_reservations")
+
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = db.ExecContext(ctx, "DELETE FROM tenant_ip_reservations WHERE tenant_id = ? AND host = ?", tenantID, host)
+
+	return errors.Wrap(err, "Error deleting tenant IP reservation from database")
+}
+
+func (ds *sqliteDB) updateTenant(ctx context.Context, tenant *types.Tenant) error {
 	db := ds.getTableDB("tenants")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	perms, err := json.Marshal(tenant.Permissions)
 	if err != nil {
 		return errors.Wrap(err, "Error marshalling permissions")
 	}
 
-	_, err = db.Exec("UPDATE tenants SET name = ?, subnet_bits = ?, permissions = ? WHERE id = ?", tenant.Name, tenant.SubnetBits, string(perms), tenant.ID)
+	flavor, err := json.Marshal(tenant.CNCIFlavor)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling CNCI flavor")
+	}
+
+	_, err = db.ExecContext(ctx, "UPDATE tenants SET name = ?, subnet_bits = ?, permissions = ?, cnci_flavor = ? WHERE id = ?", tenant.Name, tenant.SubnetBits, string(perms), string(flavor), tenant.ID)
 
-	return err
+	return errors.Wrap(err, "Error updating tenant in database")
 }
 
-func (ds *sqliteDB) deleteTenant(tenantID string) error {
+func (ds *sqliteDB) renumberTenant(ctx context.Context, tenant *types.Tenant) error {
 	db := ds.getTableDB("tenants")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	perms, err := json.Marshal(tenant.Permissions)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling permissions")
+	}
+
+	flavor, err := json.Marshal(tenant.CNCIFlavor)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling CNCI flavor")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction for tenant renumbering")
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE tenants SET name = ?, subnet_bits = ?, permissions = ?, cnci_flavor = ? WHERE id = ?", tenant.Name, tenant.SubnetBits, string(perms), string(flavor), tenant.ID)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "Error updating tenant in database")
+	}
+
+	subMask := binary.BigEndian.Uint32(net.CIDRMask(tenant.SubnetBits, 32))
+	_, err = tx.ExecContext(ctx, "UPDATE tenant_network SET subnet = rest & ? WHERE tenant_id = ?", subMask, tenant.ID)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "Error renumbering tenant network in database")
+	}
+
+	return errors.Wrap(tx.Commit(), "Error committing transaction for tenant renumbering")
+}
+
+func (ds *sqliteDB) deleteTenant(ctx context.Context, tenantID string) error {
+	db := ds.getTableDB("tenants")
 
-	tx, err := db.Begin()
+	ctx, unlock, err := ds.lock(ctx)
 	if err != nil {
 		return err
 	}
+	defer unlock()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction for tenant")
+	}
 
 	// first delete any quotas associated with this tenant
-	_, err = tx.Exec("DELETE FROM quotas WHERE tenant_id = ?", tenantID)
+	_, err = tx.ExecContext(ctx, "DELETE FROM quotas WHERE tenant_id = ?", tenantID)
 	if err != nil {
 		_ = tx.Rollback()
-		return err
+		return errors.Wrap(err, "Error deleting tenant quotas from database")
 	}
 
-	_, err = tx.Exec("DELETE FROM tenants WHERE id = ?", tenantID)
+	_, err = tx.ExecContext(ctx, "DELETE FROM tenant_ip_reservations WHERE tenant_id = ?", tenantID)
 	if err != nil {
 		_ = tx.Rollback()
-		return err
+		return errors.Wrap(err, "Error deleting tenant IP reservation from database")
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM tenants WHERE id = ?", tenantID)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, "Error deleting tenant from database")
 	}
 
 	err = tx.Commit()
 
-	return err
+	return errors.Wrap(err, "Error committing transaction for tenant")
 }
 
-func (ds *sqliteDB) getInstances() ([]*types.Instance, error) {
+func (ds *sqliteDB) getInstances(ctx context.Context) ([]*types.Instance, error) {
 	var instances []*types.Instance
 
 	db := ds.getTableDB("instances")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
 	query := `
 	WITH latest AS
@@ -1110,15 +1798,23 @@ func (ds *sqliteDB) getInstances() ([]*types.Instance, error) {
 		subnet,
 		ip,
 		name,
-		cnci
+		cnci,
+		preemptible,
+		delete_time,
+		rescue_volume,
+		rescued,
+		peer_id,
+		remote_id,
+		failure,
+		group_name
 	FROM instances
 	LEFT JOIN latest
 	ON instances.id = latest.instance_id
 	`
 
-	rows, err := db.Query(query)
+	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "Error getting instances from database")
 	}
 	defer func() { _ = rows.Close() }()
 
@@ -1126,10 +1822,20 @@ func (ds *sqliteDB) getInstances() ([]*types.Instance, error) {
 		var i types.Instance
 
 		var sshPort sql.NullInt64
+		var deleteTime *time.Time
+		var failure []byte
 
-		err = rows.Scan(&i.ID, &i.TenantID, &i.State, &i.WorkloadID, &i.SSHIP, &sshPort, &i.NodeID, &i.MACAddress, &i.VnicUUID, &i.Subnet, &i.IPAddress, &i.Name, &i.CNCI)
+		err = rows.Scan(&i.ID, &i.TenantID, &i.State, &i.WorkloadID, &i.SSHIP, &sshPort, &i.NodeID, &i.MACAddress, &i.VnicUUID, &i.Subnet, &i.IPAddress, &i.Name, &i.CNCI, &i.Preemptible, &deleteTime, &i.RescueVolume, &i.Rescued, &i.PeerID, &i.RemoteID, &failure, &i.Group)
 		if err != nil {
-			return nil, err
+			return nil, errors.Wrap(err, "Error reading instance row from database")
+		}
+
+		if err := json.Unmarshal(failure, &i.Failure); err != nil {
+			return nil, errors.Wrap(err, "Error unmarshalling instance failure")
+		}
+
+		if deleteTime != nil {
+			i.DeleteTime = *deleteTime
 		}
 
 		if sshPort.Valid {
@@ -1142,17 +1848,20 @@ func (ds *sqliteDB) getInstances() ([]*types.Instance, error) {
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "Error reading instances from database")
 	}
 
 	return instances, nil
 }
 
-func (ds *sqliteDB) getTenantInstances(tenantID string) (map[string]*types.Instance, error) {
+func (ds *sqliteDB) getTenantInstances(ctx context.Context, tenantID string) (map[string]*types.Instance, error) {
 	db := ds.getTableDB("instances")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
 	query := `
 	WITH latest AS
@@ -1178,16 +1887,24 @@ func (ds *sqliteDB) getTenantInstances(tenantID string) (map[string]*types.Insta
 		subnet,
 		ip,
 		name,
-		cnci
+		cnci,
+		preemptible,
+		delete_time,
+		rescue_volume,
+		rescued,
+		peer_id,
+		remote_id,
+		failure,
+		group_name
 	FROM instances
 	LEFT JOIN latest
 	ON instances.id = latest.instance_id
 	WHERE instances.tenant_id = ?
 	`
 
-	rows, err := db.Query(query, tenantID)
+	rows, err := db.QueryContext(ctx, query, tenantID)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "Error getting tenant instances from database")
 	}
 	defer func() { _ = rows.Close() }()
 
@@ -1196,12 +1913,22 @@ func (ds *sqliteDB) getTenantInstances(tenantID string) (map[string]*types.Insta
 		var nodeID sql.NullString
 		var sshIP sql.NullString
 		var sshPort sql.NullInt64
+		var deleteTime *time.Time
+		var failure []byte
 
 		i := &types.Instance{}
 
-		err = rows.Scan(&i.ID, &i.TenantID, &i.State, &sshIP, &sshPort, &i.WorkloadID, &nodeID, &i.MACAddress, &i.VnicUUID, &i.Subnet, &i.IPAddress, &i.Name, &i.CNCI)
+		err = rows.Scan(&i.ID, &i.TenantID, &i.State, &sshIP, &sshPort, &i.WorkloadID, &nodeID, &i.MACAddress, &i.VnicUUID, &i.Subnet, &i.IPAddress, &i.Name, &i.CNCI, &i.Preemptible, &deleteTime, &i.RescueVolume, &i.Rescued, &i.PeerID, &i.RemoteID, &failure, &i.Group)
 		if err != nil {
-			return nil, err
+			return nil, errors.Wrap(err, "Error reading instance row from database")
+		}
+
+		if err := json.Unmarshal(failure, &i.Failure); err != nil {
+			return nil, errors.Wrap(err, "Error unmarshalling instance failure")
+		}
+
+		if deleteTime != nil {
+			i.DeleteTime = *deleteTime
 		}
 
 		if nodeID.Valid {
@@ -1222,74 +1949,155 @@ func (ds *sqliteDB) getTenantInstances(tenantID string) (map[string]*types.Insta
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "Error reading tenant instances from database")
 	}
 
 	return instances, nil
 }
 
-func (ds *sqliteDB) addInstance(instance *types.Instance) error {
+func (ds *sqliteDB) addInstance(ctx context.Context, instance *types.Instance) error {
+	db := ds.getTableDB("instances")
+
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	failure, err := json.Marshal(instance.Failure)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling instance failure")
+	}
+
+	_, err = db.ExecContext(ctx, "INSERT INTO instances VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", instance.ID, instance.TenantID, instance.WorkloadID, instance.MACAddress, instance.VnicUUID, instance.Subnet, instance.IPAddress, instance.CreateTime.Format(time.RFC3339Nano), instance.Name, instance.CNCI, instance.Preemptible, nullTime(instance.DeleteTime), instance.RescueVolume, instance.Rescued, instance.PeerID, instance.RemoteID, string(failure), instance.Group)
+
+	return errors.Wrap(err, "Error adding instance to database")
+}
+
+func (ds *sqliteDB) deleteInstance(ctx context.Context, instanceID string) error {
 	db := ds.getTableDB("instances")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = db.ExecContext(ctx, "DELETE FROM instances WHERE id = ?", instanceID)
 
-	_, err := db.Exec("INSERT INTO instances VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", instance.ID, instance.TenantID, instance.WorkloadID, instance.MACAddress, instance.VnicUUID, instance.Subnet, instance.IPAddress, instance.CreateTime.Format(time.RFC3339Nano), instance.Name, instance.CNCI)
+	return errors.Wrap(err, "Error deleting instance from database")
+}
 
-	return err
+func (ds *sqliteDB) updateInstance(ctx context.Context, instance *types.Instance) error {
+	db := ds.getTableDB("instances")
+
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = db.ExecContext(ctx, "UPDATE instances SET mac_address = ?, ip = ? WHERE id = ?", instance.MACAddress, instance.IPAddress, instance.ID)
+
+	return errors.Wrap(err, "Error updating instance in database")
 }
 
-func (ds *sqliteDB) deleteInstance(instanceID string) error {
+func (ds *sqliteDB) updateInstanceDeleteTime(ctx context.Context, instanceID string, deleteTime time.Time) error {
 	db := ds.getTableDB("instances")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	_, err := db.Exec("DELETE FROM instances WHERE id = ?", instanceID)
+	_, err = db.ExecContext(ctx, "UPDATE instances SET delete_time = ? WHERE id = ?", nullTime(deleteTime), instanceID)
 
-	return err
+	return errors.Wrap(err, "Error updating instance in database")
 }
 
-func (ds *sqliteDB) updateInstance(instance *types.Instance) error {
+func (ds *sqliteDB) updateInstanceRescue(ctx context.Context, instanceID string, volumeID string, rescued bool) error {
 	db := ds.getTableDB("instances")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	_, err := db.Exec("UPDATE instances SET mac_address = ?, ip = ? WHERE id = ?", instance.MACAddress, instance.IPAddress, instance.ID)
+	_, err = db.ExecContext(ctx, "UPDATE instances SET rescue_volume = ?, rescued = ? WHERE id = ?", volumeID, rescued, instanceID)
 
-	return err
+	return errors.Wrap(err, "Error updating instance in database")
+}
+
+func (ds *sqliteDB) updateInstancePeer(ctx context.Context, instanceID string, peerID string, remoteID string) error {
+	db := ds.getTableDB("instances")
+
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = db.ExecContext(ctx, "UPDATE instances SET peer_id = ?, remote_id = ? WHERE id = ?", peerID, remoteID, instanceID)
+
+	return errors.Wrap(err, "Error updating instance in database")
+}
+
+func (ds *sqliteDB) updateInstanceFailure(ctx context.Context, instanceID string, failure *types.Failure) error {
+	db := ds.getTableDB("instances")
+
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	data, err := json.Marshal(failure)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling instance failure")
+	}
+
+	_, err = db.ExecContext(ctx, "UPDATE instances SET failure = ? WHERE id = ?", string(data), instanceID)
+
+	return errors.Wrap(err, "Error updating instance in database")
 }
 
-func (ds *sqliteDB) addNodeStat(stat payloads.Stat) error {
+func (ds *sqliteDB) addNodeStat(ctx context.Context, stat payloads.Stat) error {
 	db := ds.getTableDB("node_statistics")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	_, err := db.Exec("INSERT INTO node_statistics (node_id, mem_total_mb, mem_available_mb, disk_total_mb, disk_available_mb, load, cpus_online) VALUES(?, ?, ?, ?, ?, ?, ?)", stat.NodeUUID, stat.MemTotalMB, stat.MemAvailableMB, stat.DiskTotalMB, stat.DiskAvailableMB, stat.Load, stat.CpusOnline)
+	_, err = db.ExecContext(ctx, "INSERT INTO node_statistics (node_id, mem_total_mb, mem_available_mb, disk_total_mb, disk_available_mb, load, cpus_online) VALUES(?, ?, ?, ?, ?, ?, ?)", stat.NodeUUID, stat.MemTotalMB, stat.MemAvailableMB, stat.DiskTotalMB, stat.DiskAvailableMB, stat.Load, stat.CpusOnline)
 
-	return err
+	return errors.Wrap(err, "Error adding node statistics to database")
 }
 
-func (ds *sqliteDB) addInstanceStats(stats []payloads.InstanceStat, nodeID string) error {
+func (ds *sqliteDB) addInstanceStats(ctx context.Context, stats []payloads.InstanceStat, nodeID string) error {
 	db := ds.getTableDB("instance_statistics")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
-
-	tx, err := db.Begin()
+	ctx, unlock, err := ds.lock(ctx)
 	if err != nil {
 		return err
 	}
+	defer unlock()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction for instance statistics")
+	}
 
 	cmd := `INSERT INTO instance_statistics (instance_id, memory_usage_mb, disk_usage_mb, cpu_usage, state, node_id, ssh_ip, ssh_port)
 		VALUES(?, ?, ?, ?, ?, ?, ?, ?)`
 
-	stmt, err := tx.Prepare(cmd)
+	stmt, err := tx.PrepareContext(ctx, cmd)
 	if err != nil {
 		_ = tx.Rollback()
-		return err
+		return errors.Wrap(err, "Error preparing statement for instance statistics")
 	}
 
 	defer func() { _ = stmt.Close() }()
@@ -1297,7 +2105,7 @@ func (ds *sqliteDB) addInstanceStats(stats []payloads.InstanceStat, nodeID strin
 	for index := range stats {
 		stat := stats[index]
 
-		_, err = stmt.Exec(stat.InstanceUUID, stat.MemoryUsageMB, stat.DiskUsageMB, stat.CPUUsage, stat.State, nodeID, stat.SSHIP, stat.SSHPort)
+		_, err = stmt.ExecContext(ctx, stat.InstanceUUID, stat.MemoryUsageMB, stat.DiskUsageMB, stat.CPUUsage, stat.State, nodeID, stat.SSHIP, stat.SSHPort)
 		if err != nil {
 			glog.Warning(err)
 			// but keep going
@@ -1306,35 +2114,38 @@ func (ds *sqliteDB) addInstanceStats(stats []payloads.InstanceStat, nodeID strin
 
 	err = tx.Commit()
 
-	return err
+	return errors.Wrap(err, "Error committing transaction for instance statistics")
 }
 
-func (ds *sqliteDB) addFrameStat(stat payloads.FrameTrace) error {
+func (ds *sqliteDB) addFrameStat(ctx context.Context, stat payloads.FrameTrace) error {
 	db := ds.getTableDB("frame_statistics")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
-
-	tx, err := db.Begin()
+	ctx, unlock, err := ds.lock(ctx)
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	query := `INSERT INTO frame_statistics (label, type, operand, start_timestamp, end_timestamp)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction for frame statistics")
+	}
+
+	query := `INSERT INTO frame_statistics (label, type, operand, start_timestamp, end_timestamp)
 		  VALUES(?, ?, ?, ?, ?)`
 
-	_, err = tx.Exec(query, stat.Label, stat.Type, stat.Operand, stat.StartTimestamp, stat.EndTimestamp)
+	_, err = tx.ExecContext(ctx, query, stat.Label, stat.Type, stat.Operand, stat.StartTimestamp, stat.EndTimestamp)
 	if err != nil {
 		_ = tx.Rollback()
-		return err
+		return errors.Wrap(err, "Error adding frame statistics to database")
 	}
 
 	var id int
 
-	err = tx.QueryRow("SELECT last_insert_rowid();").Scan(&id)
+	err = tx.QueryRowContext(ctx, "SELECT last_insert_rowid();").Scan(&id)
 	if err != nil {
 		_ = tx.Rollback()
-		return err
+		return errors.Wrap(err, "Error getting frame ID from database")
 	}
 
 	for index := range stat.Nodes {
@@ -1343,31 +2154,34 @@ func (ds *sqliteDB) addFrameStat(stat payloads.FrameTrace) error {
 		cmd := `INSERT INTO trace_data (frame_id, ssntp_uuid, tx_timestamp, rx_timestamp)
 			VALUES(?, ?, ?, ?);`
 
-		_, err = tx.Exec(cmd, id, t.SSNTPUUID, t.TxTimestamp, t.RxTimestamp)
+		_, err = tx.ExecContext(ctx, cmd, id, t.SSNTPUUID, t.TxTimestamp, t.RxTimestamp)
 		if err != nil {
 			_ = tx.Rollback()
-			return err
+			return errors.Wrap(err, "Error adding trace data to database")
 		}
 	}
 
 	err = tx.Commit()
 
-	return err
+	return errors.Wrap(err, "Error committing transaction for frame statistics")
 }
 
 // GetEventLog retrieves all the log entries stored in the datastore.
-func (ds *sqliteDB) getEventLog() ([]*types.LogEntry, error) {
+func (ds *sqliteDB) getEventLog(ctx context.Context) ([]*types.LogEntry, error) {
 	var logEntries []*types.LogEntry
 
 	db := ds.getTableDB("log")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
-
-	rows, err := db.Query("SELECT timestamp, tenant_id, node_id, type, message FROM log")
+	ctx, unlock, err := ds.lock(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer unlock()
+
+	rows, err := db.QueryContext(ctx, "SELECT timestamp, tenant_id, node_id, type, message FROM log")
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting event log from database")
+	}
 	defer func() { _ = rows.Close() }()
 
 	logEntries = make([]*types.LogEntry, 0)
@@ -1375,7 +2189,7 @@ func (ds *sqliteDB) getEventLog() ([]*types.LogEntry, error) {
 		var e types.LogEntry
 		err = rows.Scan(&e.Timestamp, &e.TenantID, &e.NodeID, &e.EventType, &e.Message)
 		if err != nil {
-			return nil, err
+			return nil, errors.Wrap(err, "Error reading event row from database")
 		}
 		logEntries = append(logEntries, &e)
 	}
@@ -1384,21 +2198,24 @@ func (ds *sqliteDB) getEventLog() ([]*types.LogEntry, error) {
 }
 
 // GetBatchFrameSummary will retieve the count of traces we have for a specific label
-func (ds *sqliteDB) getBatchFrameSummary() ([]types.BatchFrameSummary, error) {
+func (ds *sqliteDB) getBatchFrameSummary(ctx context.Context) ([]types.BatchFrameSummary, error) {
 	var stats []types.BatchFrameSummary
 
 	db := ds.getTableDB("frame_statistics")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
 	query := `SELECT label, count(id)
 		  FROM frame_statistics
 		  GROUP BY label;`
 
-	rows, err := db.Query(query)
+	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "Error getting batch frame summary from database")
 	}
 	defer func() { _ = rows.Close() }()
 
@@ -1409,7 +2226,7 @@ func (ds *sqliteDB) getBatchFrameSummary() ([]types.BatchFrameSummary, error) {
 
 		err = rows.Scan(&stat.BatchID, &stat.NumInstances)
 		if err != nil {
-			return nil, err
+			return nil, errors.Wrap(err, "Error reading batch frame summary row from database")
 		}
 
 		stats = append(stats, stat)
@@ -1420,7 +2237,7 @@ func (ds *sqliteDB) getBatchFrameSummary() ([]types.BatchFrameSummary, error) {
 
 // GetBatchFrameStatistics will show individual trace data per instance for a batch of trace data.
 // The batch is identified by the label.
-func (ds *sqliteDB) getBatchFrameStatistics(label string) ([]types.BatchFrameStat, error) {
+func (ds *sqliteDB) getBatchFrameStatistics(ctx context.Context, label string) ([]types.BatchFrameStat, error) {
 	var stats []types.BatchFrameStat
 
 	db := ds.getTableDB("frame_statistics")
@@ -1508,13 +2325,16 @@ func (ds *sqliteDB) getBatchFrameStatistics(label string) ([]types.BatchFrameSta
 		JOIN total
 		JOIN averages;`
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
-
-	rows, err := db.Query(query, label)
+	ctx, unlock, err := ds.lock(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer unlock()
+
+	rows, err := db.QueryContext(ctx, query, label)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting batch frame statistics from database")
+	}
 	defer func() { _ = rows.Close() }()
 
 	stats = make([]types.BatchFrameStat, 0)
@@ -1533,7 +2353,7 @@ func (ds *sqliteDB) getBatchFrameStatistics(label string) ([]types.BatchFrameSta
 
 		err = rows.Scan(&numInstances, &totalElapsed, &averageElapsed, &averageControllerElapsed, &averageLauncherElapsed, &averageSchedulerElapsed, &varianceController, &varianceLauncher, &varianceScheduler)
 		if err != nil {
-			return nil, err
+			return nil, errors.Wrap(err, "Error reading batch frame statistics row from database")
 		}
 
 		if numInstances.Valid {
@@ -1578,13 +2398,50 @@ func (ds *sqliteDB) getBatchFrameStatistics(label string) ([]types.BatchFrameSta
 	return stats, err
 }
 
-func (ds *sqliteDB) getTenantDevices(tenantID string) (map[string]types.Volume, error) {
+// deleteBatchFrameStatistics will remove the trace data of a batch.
+// The batch is identified by the label.
+func (ds *sqliteDB) deleteBatchFrameStatistics(ctx context.Context, label string) error {
+	db := ds.getTableDB("frame_statistics")
+
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction for batch frame statistics")
+	}
+
+	query := `DELETE FROM trace_data
+		  WHERE frame_id IN (SELECT id FROM frame_statistics WHERE label = ?)`
+
+	_, err = tx.ExecContext(ctx, query, label)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, "Error deleting trace data from database")
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM frame_statistics WHERE label = ?", label)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, "Error deleting frame statistics from database")
+	}
+
+	return errors.Wrap(tx.Commit(), "Error committing transaction for batch frame statistics")
+}
+
+func (ds *sqliteDB) getTenantDevices(ctx context.Context, tenantID string) (map[string]types.Volume, error) {
 	devices := make(map[string]types.Volume)
 
 	db := ds.getTableDB("block_data")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
 	query := `SELECT	block_data.id,
 				block_data.tenant_id,
@@ -1593,37 +2450,54 @@ func (ds *sqliteDB) getTenantDevices(tenantID string) (map[string]types.Volume,
 				block_data.create_time,
 				block_data.name,
 				block_data.description,
-				block_data.internal
+				block_data.internal,
+				block_data.class,
+				block_data.encrypted,
+				block_data.delete_time,
+				block_data.failure
 		  FROM	block_data
 		  WHERE block_data.tenant_id = ?`
 
-	rows, err := db.Query(query, tenantID)
+	rows, err := db.QueryContext(ctx, query, tenantID)
 	if err != nil {
-		return devices, err
+		return devices, errors.Wrap(err, "Error getting tenant volumes from database")
 	}
 	defer func() { _ = rows.Close() }()
 
 	for rows.Next() {
 		var state string
 		var data types.Volume
+		var deleteTime *time.Time
+		var failure []byte
 
-		err = rows.Scan(&data.ID, &data.TenantID, &data.Size, &state, &data.CreateTime, &data.Name, &data.Description, &data.Internal)
+		err = rows.Scan(&data.ID, &data.TenantID, &data.Size, &state, &data.CreateTime, &data.Name, &data.Description, &data.Internal, &data.Class, &data.Encrypted, &deleteTime, &failure)
 		if err != nil {
 			continue
 		}
 
+		if err = json.Unmarshal(failure, &data.Failure); err != nil {
+			continue
+		}
+
+		if deleteTime != nil {
+			data.DeleteTime = *deleteTime
+		}
+
 		data.State = types.BlockState(state)
 		devices[data.ID] = data
 	}
 
 	if err = rows.Err(); err != nil {
-		return devices, err
+		return devices, errors.Wrap(err, "Error reading tenant volumes from database")
 	}
 
 	return devices, nil
 }
 
-func (ds *sqliteDB) getAllBlockData() (map[string]types.Volume, error) {
+func (ds *sqliteDB) getAllBlockData(ctx context.Context) (map[string]types.Volume, error) {
+	ctx, done := ds.operation(ctx)
+	defer done()
+
 	devices := make(map[string]types.Volume)
 
 	db := ds.getTableDB("block_data")
@@ -1635,78 +2509,120 @@ func (ds *sqliteDB) getAllBlockData() (map[string]types.Volume, error) {
 				block_data.create_time,
 				block_data.name,
 				block_data.description,
-				block_data.internal
+				block_data.internal,
+				block_data.class,
+				block_data.encrypted,
+				block_data.delete_time,
+				block_data.failure
 		  FROM	block_data `
 
-	rows, err := db.Query(query)
+	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "Error getting volumes from database")
 	}
 	defer func() { _ = rows.Close() }()
 
 	for rows.Next() {
 		var data types.Volume
 		var state string
+		var deleteTime *time.Time
+		var failure []byte
 
-		err = rows.Scan(&data.ID, &data.TenantID, &data.Size, &state, &data.CreateTime, &data.Name, &data.Description, &data.Internal)
+		err = rows.Scan(&data.ID, &data.TenantID, &data.Size, &state, &data.CreateTime, &data.Name, &data.Description, &data.Internal, &data.Class, &data.Encrypted, &deleteTime, &failure)
 		if err != nil {
 			continue
 		}
 
+		if err = json.Unmarshal(failure, &data.Failure); err != nil {
+			continue
+		}
+
+		if deleteTime != nil {
+			data.DeleteTime = *deleteTime
+		}
+
 		data.State = types.BlockState(state)
 		devices[data.ID] = data
 	}
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "Error reading volumes from database")
 	}
 
 	return devices, nil
 }
 
-func (ds *sqliteDB) addBlockData(data types.Volume) error {
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+func (ds *sqliteDB) addBlockData(ctx context.Context, data types.Volume) error {
+	db := ds.getTableDB("block_data")
+
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	failure, err := json.Marshal(data.Failure)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling volume failure")
+	}
 
-	err := ds.create("block_data", data.ID, data.TenantID, data.Size, string(data.State), data.CreateTime.Format(time.RFC3339Nano), data.Name, data.Description, data.Internal)
+	_, err = db.ExecContext(ctx, "INSERT INTO block_data VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", data.ID, data.TenantID, data.Size, string(data.State), data.CreateTime.Format(time.RFC3339Nano), data.Name, data.Description, data.Internal, data.Class, data.Encrypted, nullTime(data.DeleteTime), string(failure))
 
-	return err
+	return errors.Wrap(err, "Error adding volume to database")
 }
 
-// For now we only support updating the state.
-func (ds *sqliteDB) updateBlockData(data types.Volume) error {
+// For now we only support updating the state, the deletion time and the
+// failure.
+func (ds *sqliteDB) updateBlockData(ctx context.Context, data types.Volume) error {
 	db := ds.getTableDB("block_data")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	failure, err := json.Marshal(data.Failure)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling volume failure")
+	}
 
-	_, err := db.Exec("UPDATE block_data SET state = ? WHERE id = ?", string(data.State), data.ID)
+	_, err = db.ExecContext(ctx, "UPDATE block_data SET state = ?, delete_time = ?, failure = ? WHERE id = ?", string(data.State), nullTime(data.DeleteTime), string(failure), data.ID)
 
-	return err
+	return errors.Wrap(err, "Error updating volume in database")
 }
 
-func (ds *sqliteDB) deleteBlockData(ID string) error {
+func (ds *sqliteDB) deleteBlockData(ctx context.Context, ID string) error {
 	db := ds.getTableDB("block_data")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	_, err := db.Exec("DELETE FROM block_data WHERE id = ?", ID)
+	_, err = db.ExecContext(ctx, "DELETE FROM block_data WHERE id = ?", ID)
 
-	return err
+	return errors.Wrap(err, "Error deleting volume from database")
 }
 
-func (ds *sqliteDB) addStorageAttachment(a types.StorageAttachment) error {
+func (ds *sqliteDB) addStorageAttachment(ctx context.Context, a types.StorageAttachment) error {
 	db := ds.getTableDB("attachments")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	_, err := db.Exec("INSERT INTO attachments (id, instance_id, block_id, ephemeral, boot) VALUES (?, ?, ?, ?, ?)", a.ID, a.InstanceID, a.BlockID, a.Ephemeral, a.Boot)
+	_, err = db.ExecContext(ctx, "INSERT INTO attachments (id, instance_id, block_id, ephemeral, boot) VALUES (?, ?, ?, ?, ?)", a.ID, a.InstanceID, a.BlockID, a.Ephemeral, a.Boot)
 
-	return err
+	return errors.Wrap(err, "Error adding storage attachment to database")
 }
 
-func (ds *sqliteDB) getAllStorageAttachments() (map[string]types.StorageAttachment, error) {
+func (ds *sqliteDB) getAllStorageAttachments(ctx context.Context) (map[string]types.StorageAttachment, error) {
+	ctx, done := ds.operation(ctx)
+	defer done()
+
 	attachments := make(map[string]types.StorageAttachment)
 
 	db := ds.getTableDB("attachments")
@@ -1718,9 +2634,9 @@ func (ds *sqliteDB) getAllStorageAttachments() (map[string]types.StorageAttachme
 				attachments.boot
 		  FROM	attachments `
 
-	rows, err := db.Query(query)
+	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
-		return attachments, err
+		return attachments, errors.Wrap(err, "Error getting storage attachments from database")
 	}
 	defer func() { _ = rows.Close() }()
 
@@ -1735,33 +2651,39 @@ func (ds *sqliteDB) getAllStorageAttachments() (map[string]types.StorageAttachme
 	}
 
 	if err = rows.Err(); err != nil {
-		return attachments, err
+		return attachments, errors.Wrap(err, "Error reading storage attachments from database")
 	}
 
 	return attachments, nil
 }
 
-func (ds *sqliteDB) deleteStorageAttachment(ID string) error {
+func (ds *sqliteDB) deleteStorageAttachment(ctx context.Context, ID string) error {
 	db := ds.getTableDB("attachments")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	_, err := db.Exec("DELETE FROM attachments WHERE id = ?", ID)
+	_, err = db.ExecContext(ctx, "DELETE FROM attachments WHERE id = ?", ID)
 
-	return err
+	return errors.Wrap(err, "Error deleting storage attachment from database")
 }
 
 // this is here just for readability.
-func (ds *sqliteDB) addPool(pool types.Pool) error {
-	return ds.updatePool(pool)
+func (ds *sqliteDB) addPool(ctx context.Context, pool types.Pool) error {
+	ctx, done := ds.operation(ctx)
+	defer done()
+
+	return ds.updatePool(ctx, pool)
 }
 
 // lock must be held by caller. Any rollbacks will need to be handled
 // by caller.
-func (ds *sqliteDB) updateSubnets(tx *sql.Tx, pool types.Pool) error {
+func (ds *sqliteDB) updateSubnets(ctx context.Context, tx *sql.Tx, pool types.Pool) error {
 	// get currently known subnets.
-	subnets, err := ds.getPoolSubnets(pool.ID)
+	subnets, err := ds.getPoolSubnets(ctx, pool.ID)
 	if err != nil {
 		// TBD: what about row not found?
 		return err
@@ -1777,9 +2699,9 @@ func (ds *sqliteDB) updateSubnets(tx *sql.Tx, pool types.Pool) error {
 	for _, sub := range subnets {
 		_, ok := subMap[sub.ID]
 		if !ok {
-			_, err = tx.Exec("DELETE FROM subnet_pool WHERE id = ?", sub.ID)
+			_, err = tx.ExecContext(ctx, "DELETE FROM subnet_pool WHERE id = ?", sub.ID)
 			if err != nil {
-				return err
+				return errors.Wrap(err, "Error deleting pool subnet from database")
 			}
 		}
 	}
@@ -1787,9 +2709,9 @@ func (ds *sqliteDB) updateSubnets(tx *sql.Tx, pool types.Pool) error {
 	// any subnets that already exist in the table will be ignored,
 	// new ones will be added.
 	for _, subnet := range pool.Subnets {
-		_, err = tx.Exec("INSERT OR IGNORE INTO subnet_pool (id, pool_id, cidr) VALUES (?, ?, ?)", subnet.ID, pool.ID, subnet.CIDR)
+		_, err = tx.ExecContext(ctx, "INSERT OR IGNORE INTO subnet_pool (id, pool_id, cidr) VALUES (?, ?, ?)", subnet.ID, pool.ID, subnet.CIDR)
 		if err != nil {
-			return err
+			return errors.Wrap(err, "Error adding pool subnet to database")
 		}
 	}
 
@@ -1798,9 +2720,9 @@ func (ds *sqliteDB) updateSubnets(tx *sql.Tx, pool types.Pool) error {
 
 // lock must be held by caller. Any rollbacks will need to be handled
 // by caller.
-func (ds *sqliteDB) updateAddresses(tx *sql.Tx, pool types.Pool) error {
+func (ds *sqliteDB) updateAddresses(ctx context.Context, tx *sql.Tx, pool types.Pool) error {
 	// get currently known individual addresses.
-	addresses, err := ds.getPoolAddresses(pool.ID)
+	addresses, err := ds.getPoolAddresses(ctx, pool.ID)
 	if err != nil {
 		// TBD: what about row not found?
 		return err
@@ -1816,10 +2738,10 @@ func (ds *sqliteDB) updateAddresses(tx *sql.Tx, pool types.Pool) error {
 	for _, addr := range addresses {
 		_, ok := addrMap[addr.ID]
 		if !ok {
-			_, err = tx.Exec("DELETE FROM address_pool WHERE id = ?", addr.ID)
+			_, err = tx.ExecContext(ctx, "DELETE FROM address_pool WHERE id = ?", addr.ID)
 			if err != nil {
 				_ = tx.Rollback()
-				return err
+				return errors.Wrap(err, "Error deleting pool address from database")
 			}
 		}
 	}
@@ -1827,10 +2749,10 @@ func (ds *sqliteDB) updateAddresses(tx *sql.Tx, pool types.Pool) error {
 	// any addresses that already exist in the table will be ignored,
 	// new ones will be added.
 	for _, IP := range pool.IPs {
-		_, err = tx.Exec("INSERT OR IGNORE INTO address_pool (id, pool_id, address) VALUES (?, ?, ?)", IP.ID, pool.ID, IP.Address)
+		_, err = tx.ExecContext(ctx, "INSERT OR IGNORE INTO address_pool (id, pool_id, address) VALUES (?, ?, ?)", IP.ID, pool.ID, IP.Address)
 		if err != nil {
 			_ = tx.Rollback()
-			return err
+			return errors.Wrap(err, "Error adding pool address to database")
 		}
 	}
 
@@ -1839,27 +2761,30 @@ func (ds *sqliteDB) updateAddresses(tx *sql.Tx, pool types.Pool) error {
 
 // updatePool is used to update all pool related fields even if they
 // are in different tables.
-func (ds *sqliteDB) updatePool(pool types.Pool) error {
+func (ds *sqliteDB) updatePool(ctx context.Context, pool types.Pool) error {
 	db := ds.getTableDB("pools")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	pools := ds.getAllPools()
+	pools := ds.getAllPools(ctx)
 
 	// do the below as a single transaction.
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return errors.Wrap(err, "Error starting transaction for pool")
 	}
 
-	err = ds.updateSubnets(tx, pool)
+	err = ds.updateSubnets(ctx, tx, pool)
 	if err != nil {
 		_ = tx.Rollback()
 		return err
 	}
 
-	err = ds.updateAddresses(tx, pool)
+	err = ds.updateAddresses(ctx, tx, pool)
 	if err != nil {
 		_ = tx.Rollback()
 		return err
@@ -1868,26 +2793,29 @@ func (ds *sqliteDB) updatePool(pool types.Pool) error {
 	// if this is a new pool, put it in, otherwise just update.
 	_, ok := pools[pool.ID]
 	if !ok {
-		_, err = tx.Exec("INSERT INTO pools (id, name, free, total) VALUES (?, ?, ?, ?)", pool.ID, pool.Name, pool.Free, pool.TotalIPs)
+		_, err = tx.ExecContext(ctx, "INSERT INTO pools (id, name, free, total) VALUES (?, ?, ?, ?)", pool.ID, pool.Name, pool.Free, pool.TotalIPs)
 		if err != nil {
 			_ = tx.Rollback()
-			return err
+			return errors.Wrap(err, "Error adding pool to database")
 		}
 	} else {
 		// update free and total counts.
-		_, err = tx.Exec("UPDATE pools SET free = ?, total = ? WHERE id = ?", pool.Free, pool.TotalIPs, pool.ID)
+		_, err = tx.ExecContext(ctx, "UPDATE pools SET free = ?, total = ? WHERE id = ?", pool.Free, pool.TotalIPs, pool.ID)
 		if err != nil {
 			_ = tx.Rollback()
-			return err
+			return errors.Wrap(err, "Error updating pool in database")
 		}
 	}
 
 	err = tx.Commit()
 
-	return err
+	return errors.Wrap(err, "Error committing transaction for pool")
 }
 
-func (ds *sqliteDB) getAllPools() map[string]types.Pool {
+func (ds *sqliteDB) getAllPools(ctx context.Context) map[string]types.Pool {
+	ctx, done := ds.operation(ctx)
+	defer done()
+
 	pools := make(map[string]types.Pool)
 
 	db := ds.getTableDB("pools")
@@ -1898,7 +2826,7 @@ func (ds *sqliteDB) getAllPools() map[string]types.Pool {
 				total
 		  FROM	pools`
 
-	rows, err := db.Query(query)
+	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
 		return nil
 	}
@@ -1912,12 +2840,12 @@ func (ds *sqliteDB) getAllPools() map[string]types.Pool {
 			continue
 		}
 
-		pool.Subnets, err = ds.getPoolSubnets(pool.ID)
+		pool.Subnets, err = ds.getPoolSubnets(ctx, pool.ID)
 		if err != nil {
 			continue
 		}
 
-		pool.IPs, err = ds.getPoolAddresses(pool.ID)
+		pool.IPs, err = ds.getPoolAddresses(ctx, pool.ID)
 		if err != nil {
 			continue
 		}
@@ -1932,57 +2860,60 @@ func (ds *sqliteDB) getAllPools() map[string]types.Pool {
 	return pools
 }
 
-func (ds *sqliteDB) deletePool(ID string) error {
+func (ds *sqliteDB) deletePool(ctx context.Context, ID string) error {
 	db := ds.getTableDB("pools")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
-
-	tx, err := db.Begin()
+	ctx, unlock, err := ds.lock(ctx)
 	if err != nil {
 		return err
 	}
+	defer unlock()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction for pool")
+	}
 
 	// lock is held here and ok because the
 	// get functions don't hold a lock.
-	subnets, err := ds.getPoolSubnets(ID)
+	subnets, err := ds.getPoolSubnets(ctx, ID)
 	if err != nil {
 		return err
 	}
 
-	IPs, err := ds.getPoolAddresses(ID)
+	IPs, err := ds.getPoolAddresses(ctx, ID)
 	if err != nil {
 		return err
 	}
 
 	for _, subnet := range subnets {
-		_, err = tx.Exec("DELETE FROM subnet_pool WHERE id = ?", subnet.ID)
+		_, err = tx.ExecContext(ctx, "DELETE FROM subnet_pool WHERE id = ?", subnet.ID)
 		if err != nil {
 			_ = tx.Rollback()
-			return err
+			return errors.Wrap(err, "Error deleting pool subnet from database")
 		}
 	}
 
 	for _, addr := range IPs {
-		_, err = tx.Exec("DELETE FROM address_pool WHERE id = ?", addr.ID)
+		_, err = tx.ExecContext(ctx, "DELETE FROM address_pool WHERE id = ?", addr.ID)
 		if err != nil {
 			_ = tx.Rollback()
-			return err
+			return errors.Wrap(err, "Error deleting pool address from database")
 		}
 	}
 
-	_, err = tx.Exec("DELETE FROM pools WHERE id = ?", ID)
+	_, err = tx.ExecContext(ctx, "DELETE FROM pools WHERE id = ?", ID)
 	if err != nil {
 		_ = tx.Rollback()
-		return err
+		return errors.Wrap(err, "Error deleting pool from database")
 	}
 
 	err = tx.Commit()
 
-	return err
+	return errors.Wrap(err, "Error committing transaction for pool")
 }
 
-func (ds *sqliteDB) getPoolSubnets(poolID string) ([]types.ExternalSubnet, error) {
+func (ds *sqliteDB) getPoolSubnets(ctx context.Context, poolID string) ([]types.ExternalSubnet, error) {
 	var subnets []types.ExternalSubnet
 
 	db := ds.getTableDB("subnet_pool")
@@ -1992,9 +2923,9 @@ func (ds *sqliteDB) getPoolSubnets(poolID string) ([]types.ExternalSubnet, error
 		  FROM	subnet_pool
 		  WHERE pool_id = ?`
 
-	rows, err := db.Query(query, poolID)
+	rows, err := db.QueryContext(ctx, query, poolID)
 	if err != nil {
-		return subnets, err
+		return subnets, errors.Wrap(err, "Error getting pool subnets from database")
 	}
 	defer func() { _ = rows.Close() }()
 
@@ -2010,13 +2941,13 @@ func (ds *sqliteDB) getPoolSubnets(poolID string) ([]types.ExternalSubnet, error
 	}
 
 	if err = rows.Err(); err != nil {
-		return subnets, err
+		return subnets, errors.Wrap(err, "Error reading pool subnets from database")
 	}
 
 	return subnets, nil
 }
 
-func (ds *sqliteDB) getPoolAddresses(poolID string) ([]types.ExternalIP, error) {
+func (ds *sqliteDB) getPoolAddresses(ctx context.Context, poolID string) ([]types.ExternalIP, error) {
 	var IPs []types.ExternalIP
 
 	db := ds.getTableDB("address_pool")
@@ -2026,9 +2957,9 @@ func (ds *sqliteDB) getPoolAddresses(poolID string) ([]types.ExternalIP, error)
 		  FROM	address_pool
 		  WHERE pool_id = ?`
 
-	rows, err := db.Query(query, poolID)
+	rows, err := db.QueryContext(ctx, query, poolID)
 	if err != nil {
-		return IPs, err
+		return IPs, errors.Wrap(err, "Error getting pool addresses from database")
 	}
 	defer func() { _ = rows.Close() }()
 
@@ -2044,35 +2975,44 @@ func (ds *sqliteDB) getPoolAddresses(poolID string) ([]types.ExternalIP, error)
 	}
 
 	if err = rows.Err(); err != nil {
-		return IPs, err
+		return IPs, errors.Wrap(err, "Error reading pool addresses from database")
 	}
 
 	return IPs, nil
 }
 
-func (ds *sqliteDB) addMappedIP(m types.MappedIP) error {
+func (ds *sqliteDB) addMappedIP(ctx context.Context, m types.MappedIP) error {
 	db := ds.getTableDB("mapped_ips")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	_, err := db.Exec("INSERT INTO mapped_ips (id, pool_id, external_ip, instance_id) VALUES (?, ?, ?, ?)", m.ID, m.PoolID, m.ExternalIP, m.InstanceID)
+	_, err = db.ExecContext(ctx, "INSERT INTO mapped_ips (id, pool_id, external_ip, instance_id) VALUES (?, ?, ?, ?)", m.ID, m.PoolID, m.ExternalIP, m.InstanceID)
 
-	return err
+	return errors.Wrap(err, "Error adding mapped IP to database")
 }
 
-func (ds *sqliteDB) deleteMappedIP(ID string) error {
+func (ds *sqliteDB) deleteMappedIP(ctx context.Context, ID string) error {
 	db := ds.getTableDB("mapped_ips")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	_, err := db.Exec("DELETE FROM mapped_ips WHERE id = ?", ID)
+	_, err = db.ExecContext(ctx, "DELETE FROM mapped_ips WHERE id = ?", ID)
 
-	return err
+	return errors.Wrap(err, "Error deleting mapped IP from database")
 }
 
-func (ds *sqliteDB) getMappedIPs() map[string]types.MappedIP {
+func (ds *sqliteDB) getMappedIPs(ctx context.Context) map[string]types.MappedIP {
+	ctx, done := ds.operation(ctx)
+	defer done()
+
 	IPs := make(map[string]types.MappedIP)
 
 	db := ds.getTableDB("mapped_ips")
@@ -2090,7 +3030,7 @@ func (ds *sqliteDB) getMappedIPs() map[string]types.MappedIP {
 		  JOIN pools
 		  ON pools.id = mapped_ips.pool_id`
 
-	rows, err := db.Query(query)
+	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
 		fmt.Println(err)
 		return IPs
@@ -2115,19 +3055,22 @@ func (ds *sqliteDB) getMappedIPs() map[string]types.MappedIP {
 	return IPs
 }
 
-func (ds *sqliteDB) updateQuotas(tenantID string, qds []types.QuotaDetails) error {
+func (ds *sqliteDB) updateQuotas(ctx context.Context, tenantID string, qds []types.QuotaDetails) error {
 	db := ds.getTableDB("quotas")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return errors.Wrap(err, "error starting transaction for quota update")
 	}
 
 	for i := range qds {
-		_, err = tx.Exec("REPLACE INTO quotas (tenant_id, name, value) VALUES (?, ?, ?)", tenantID, qds[i].Name, qds[i].Value)
+		_, err = tx.ExecContext(ctx, "REPLACE INTO quotas (tenant_id, name, value) VALUES (?, ?, ?)", tenantID, qds[i].Name, qds[i].Value)
 		if err != nil {
 			_ = tx.Rollback()
 			return errors.Wrap(err, "error executing query for quota update")
@@ -2139,12 +3082,15 @@ func (ds *sqliteDB) updateQuotas(tenantID string, qds []types.QuotaDetails) erro
 	return errors.Wrap(err, "error committing transaction for quotas update")
 }
 
-func (ds *sqliteDB) getQuotas(tenantID string) ([]types.QuotaDetails, error) {
+func (ds *sqliteDB) getQuotas(ctx context.Context, tenantID string) ([]types.QuotaDetails, error) {
+	ctx, done := ds.operation(ctx)
+	defer done()
+
 	query := `SELECT name, value from quotas WHERE tenant_id = ?`
 
 	db := ds.getTableDB("quotas")
 
-	rows, err := db.Query(query, tenantID)
+	rows, err := db.QueryContext(ctx, query, tenantID)
 	if err != nil {
 		return nil, errors.Wrap(err, "error getting quotas from database")
 	}
@@ -2167,16 +3113,19 @@ func (ds *sqliteDB) getQuotas(tenantID string) ([]types.QuotaDetails, error) {
 	return results, nil
 }
 
-func (ds *sqliteDB) getImages() ([]types.Image, error) {
+func (ds *sqliteDB) getImages(ctx context.Context) ([]types.Image, error) {
 	images := []types.Image{}
 
 	query := `SELECT id, state, tenant_id, name, createtime, size, visibility FROM images`
 
 	db := ds.getTableDB("images")
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
-	rows, err := db.Query(query)
+	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
 		return images, errors.Wrap(err, "error getting images from database")
 	}
@@ -2200,26 +3149,537 @@ func (ds *sqliteDB) getImages() ([]types.Image, error) {
 	return images, nil
 }
 
-func (ds *sqliteDB) updateImage(i types.Image) error {
+func (ds *sqliteDB) updateImage(ctx context.Context, i types.Image) error {
 	query := `REPLACE INTO images (id, state, tenant_id, name, createtime, size, visibility) VALUES (?, ?, ?, ?, ?, ?, ?)`
 
 	db := ds.getTableDB("images")
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	_, err := db.Exec(query, i.ID, i.State, i.TenantID, i.Name, i.CreateTime, i.Size, i.Visibility)
+	_, err = db.ExecContext(ctx, query, i.ID, i.State, i.TenantID, i.Name, i.CreateTime, i.Size, i.Visibility)
 
 	return errors.Wrap(err, "Error updatiing image into database")
 }
 
-func (ds *sqliteDB) deleteImage(ID string) error {
+func (ds *sqliteDB) deleteImage(ctx context.Context, ID string) error {
 	query := `DELETE FROM images WHERE id = ?`
 
 	db := ds.getTableDB("images")
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	_, err := db.Exec(query, ID)
+	_, err = db.ExecContext(ctx, query, ID)
 
 	return errors.Wrap(err, "Error deleting image from database")
 }
+
+func (ds *sqliteDB) addFailedCommand(ctx context.Context, cmd types.FailedCommand) error {
+	query := `INSERT INTO failed_commands (id, command, instance_id, node_id, reason, timestamp, payload) VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	db := ds.getTableDB("failed_commands")
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = db.ExecContext(ctx, query, cmd.ID, cmd.Command, cmd.InstanceID, cmd.NodeID, cmd.Reason, cmd.Timestamp, cmd.Payload)
+
+	return errors.Wrap(err, "Error adding failed command into database")
+}
+
+func (ds *sqliteDB) getFailedCommands(ctx context.Context) ([]types.FailedCommand, error) {
+	cmds := []types.FailedCommand{}
+
+	query := `SELECT id, command, instance_id, node_id, reason, timestamp, payload FROM failed_commands ORDER BY timestamp`
+
+	db := ds.getTableDB("failed_commands")
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return cmds, errors.Wrap(err, "error getting failed commands from database")
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var cmd types.FailedCommand
+
+		err = rows.Scan(&cmd.ID, &cmd.Command, &cmd.InstanceID, &cmd.NodeID, &cmd.Reason, &cmd.Timestamp, &cmd.Payload)
+		if err != nil {
+			return []types.FailedCommand{}, errors.Wrap(err, "error reading failed command row from database")
+		}
+
+		cmds = append(cmds, cmd)
+	}
+
+	return cmds, nil
+}
+
+func (ds *sqliteDB) deleteFailedCommand(ctx context.Context, ID string) error {
+	query := `DELETE FROM failed_commands WHERE id = ?`
+
+	db := ds.getTableDB("failed_commands")
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = db.ExecContext(ctx, query, ID)
+
+	return errors.Wrap(err, "Error deleting failed command from database")
+}
+
+func (ds *sqliteDB) updateNodePolicy(ctx context.Context, policy types.NodePolicy) error {
+	query := `INSERT OR REPLACE INTO node_policies (node_id, weight, max_instances, allow_cnci_colocation, dedicated_tenant) VALUES (?, ?, ?, ?, ?)`
+
+	db := ds.getTableDB("node_policies")
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = db.ExecContext(ctx, query, policy.NodeID, policy.Weight, policy.MaxInstances, policy.AllowCNCIColocation, policy.DedicatedTenant)
+
+	return errors.Wrap(err, "Error updating node policy in database")
+}
+
+func (ds *sqliteDB) deleteNodePolicy(ctx context.Context, nodeID string) error {
+	query := `DELETE FROM node_policies WHERE node_id = ?`
+
+	db := ds.getTableDB("node_policies")
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = db.ExecContext(ctx, query, nodeID)
+
+	return errors.Wrap(err, "Error deleting node policy from database")
+}
+
+func (ds *sqliteDB) getNodePolicies(ctx context.Context) ([]types.NodePolicy, error) {
+	policies := []types.NodePolicy{}
+
+	query := `SELECT node_id, weight, max_instances, allow_cnci_colocation, dedicated_tenant FROM node_policies ORDER BY node_id`
+
+	db := ds.getTableDB("node_policies")
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return policies, errors.Wrap(err, "error getting node policies from database")
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var policy types.NodePolicy
+
+		err = rows.Scan(&policy.NodeID, &policy.Weight, &policy.MaxInstances, &policy.AllowCNCIColocation, &policy.DedicatedTenant)
+		if err != nil {
+			return []types.NodePolicy{}, errors.Wrap(err, "error reading node policy row from database")
+		}
+
+		policies = append(policies, policy)
+	}
+
+	return policies, nil
+}
+
+func (ds *sqliteDB) updateSecret(ctx context.Context, secret types.Secret) error {
+	query := `INSERT OR REPLACE INTO secrets (tenant_id, name, create_time, data) VALUES (?, ?, ?, ?)`
+
+	db := ds.getTableDB("secrets")
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = db.ExecContext(ctx, query, secret.TenantID, secret.Name, secret.CreateTime, secret.Data)
+
+	return errors.Wrap(err, "Error updating secret in database")
+}
+
+func (ds *sqliteDB) deleteSecret(ctx context.Context, tenantID string, name string) error {
+	query := `DELETE FROM secrets WHERE tenant_id = ? AND name = ?`
+
+	db := ds.getTableDB("secrets")
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = db.ExecContext(ctx, query, tenantID, name)
+
+	return errors.Wrap(err, "Error deleting secret from database")
+}
+
+func (ds *sqliteDB) getSecrets(ctx context.Context, tenantID string) ([]types.Secret, error) {
+	secrets := []types.Secret{}
+
+	query := `SELECT name, create_time, data FROM secrets WHERE tenant_id = ? ORDER BY name`
+
+	db := ds.getTableDB("secrets")
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := db.QueryContext(ctx, query, tenantID)
+	if err != nil {
+		return secrets, errors.Wrap(err, "error getting secrets from database")
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		secret := types.Secret{TenantID: tenantID}
+
+		err = rows.Scan(&secret.Name, &secret.CreateTime, &secret.Data)
+		if err != nil {
+			return []types.Secret{}, errors.Wrap(err, "error reading secret row from database")
+		}
+
+		secrets = append(secrets, secret)
+	}
+
+	return secrets, nil
+}
+
+func (ds *sqliteDB) addAPIToken(ctx context.Context, token types.APIToken) error {
+	query := `INSERT INTO api_tokens (id, tenant_id, name, scope, create_time, expiry_time, hash) VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	db := ds.getTableDB("api_tokens")
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = db.ExecContext(ctx, query, token.ID, token.TenantID, token.Name, string(token.Scope),
+		token.CreateTime, token.ExpiryTime, token.Hash)
+
+	return errors.Wrap(err, "Error adding API token to database")
+}
+
+func (ds *sqliteDB) deleteAPIToken(ctx context.Context, ID string) error {
+	query := `DELETE FROM api_tokens WHERE id = ?`
+
+	db := ds.getTableDB("api_tokens")
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = db.ExecContext(ctx, query, ID)
+
+	return errors.Wrap(err, "Error deleting API token from database")
+}
+
+func (ds *sqliteDB) getAPIToken(ctx context.Context, ID string) (types.APIToken, error) {
+	var token types.APIToken
+	var scope string
+
+	query := `SELECT id, tenant_id, name, scope, create_time, expiry_time, hash FROM api_tokens WHERE id = ?`
+
+	db := ds.getTableDB("api_tokens")
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return types.APIToken{}, err
+	}
+	defer unlock()
+
+	err = db.QueryRowContext(ctx, query, ID).Scan(&token.ID, &token.TenantID, &token.Name, &scope,
+		&token.CreateTime, &token.ExpiryTime, &token.Hash)
+	if err == sql.ErrNoRows {
+		return types.APIToken{}, types.ErrAPITokenNotFound
+	} else if err != nil {
+		return types.APIToken{}, errors.Wrap(err, "error getting API token from database")
+	}
+	token.Scope = types.APITokenScope(scope)
+
+	return token, nil
+}
+
+func (ds *sqliteDB) getAPITokens(ctx context.Context, tenantID string) ([]types.APIToken, error) {
+	tokens := []types.APIToken{}
+
+	query := `SELECT id, name, scope, create_time, expiry_time, hash FROM api_tokens WHERE tenant_id = ? ORDER BY create_time`
+
+	db := ds.getTableDB("api_tokens")
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := db.QueryContext(ctx, query, tenantID)
+	if err != nil {
+		return tokens, errors.Wrap(err, "error getting API tokens from database")
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var scope string
+		token := types.APIToken{TenantID: tenantID}
+
+		err = rows.Scan(&token.ID, &token.Name, &scope, &token.CreateTime, &token.ExpiryTime, &token.Hash)
+		if err != nil {
+			return []types.APIToken{}, errors.Wrap(err, "error reading API token row from database")
+		}
+		token.Scope = types.APITokenScope(scope)
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, nil
+}
+
+func (ds *sqliteDB) addFederationPeer(ctx context.Context, peer types.FederationPeer) error {
+	workloads, err := json.Marshal(peer.Workloads)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling peer workloads")
+	}
+
+	query := `INSERT INTO federation_peers (id, name, url, tenant_id, ca_cert_file, workloads, create_time, token) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	db := ds.getTableDB("federation_peers")
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = db.ExecContext(ctx, query, peer.ID, peer.Name, peer.URL, peer.TenantID, peer.CACertFile,
+		string(workloads), peer.CreateTime, peer.Token)
+
+	return errors.Wrap(err, "Error adding federation peer to database")
+}
+
+func (ds *sqliteDB) deleteFederationPeer(ctx context.Context, ID string) error {
+	query := `DELETE FROM federation_peers WHERE id = ?`
+
+	db := ds.getTableDB("federation_peers")
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = db.ExecContext(ctx, query, ID)
+
+	return errors.Wrap(err, "Error deleting federation peer from database")
+}
+
+func (ds *sqliteDB) getFederationPeers(ctx context.Context) ([]types.FederationPeer, error) {
+	peers := []types.FederationPeer{}
+
+	query := `SELECT id, name, url, tenant_id, ca_cert_file, workloads, create_time, token FROM federation_peers ORDER BY create_time`
+
+	db := ds.getTableDB("federation_peers")
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return peers, errors.Wrap(err, "error getting federation peers from database")
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var peer types.FederationPeer
+		var workloads string
+
+		err = rows.Scan(&peer.ID, &peer.Name, &peer.URL, &peer.TenantID, &peer.CACertFile,
+			&workloads, &peer.CreateTime, &peer.Token)
+		if err != nil {
+			return []types.FederationPeer{}, errors.Wrap(err, "error reading federation peer row from database")
+		}
+
+		err = json.Unmarshal([]byte(workloads), &peer.Workloads)
+		if err != nil {
+			return []types.FederationPeer{}, errors.Wrap(err, "error unmarshalling peer workloads")
+		}
+
+		peers = append(peers, peer)
+	}
+
+	return peers, nil
+}
+
+func (ds *sqliteDB) addSnapshotSet(ctx context.Context, set types.SnapshotSet) error {
+	volumes, err := json.Marshal(set.Volumes)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling snapshot set volumes")
+	}
+
+	query := `INSERT INTO snapshot_sets (id, name, tenant_id, instance_id, workload_id, state, consistent, create_time, volumes) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	db := ds.getTableDB("snapshot_sets")
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = db.ExecContext(ctx, query, set.ID, set.Name, set.TenantID, set.InstanceID, set.WorkloadID,
+		set.State, set.Consistent, set.CreateTime, string(volumes))
+
+	return errors.Wrap(err, "Error adding snapshot set to database")
+}
+
+func (ds *sqliteDB) updateSnapshotSet(ctx context.Context, set types.SnapshotSet) error {
+	volumes, err := json.Marshal(set.Volumes)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling snapshot set volumes")
+	}
+
+	query := `UPDATE snapshot_sets SET state = ?, volumes = ? WHERE id = ?`
+
+	db := ds.getTableDB("snapshot_sets")
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = db.ExecContext(ctx, query, set.State, string(volumes), set.ID)
+
+	return errors.Wrap(err, "Error updating snapshot set in database")
+}
+
+func (ds *sqliteDB) deleteSnapshotSet(ctx context.Context, ID string) error {
+	query := `DELETE FROM snapshot_sets WHERE id = ?`
+
+	db := ds.getTableDB("snapshot_sets")
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = db.ExecContext(ctx, query, ID)
+
+	return errors.Wrap(err, "Error deleting snapshot set from database")
+}
+
+func (ds *sqliteDB) getSnapshotSets(ctx context.Context, tenantID string) ([]types.SnapshotSet, error) {
+	sets := []types.SnapshotSet{}
+
+	query := `SELECT id, name, tenant_id, instance_id, workload_id, state, consistent, create_time, volumes FROM snapshot_sets WHERE tenant_id = ? ORDER BY create_time`
+
+	db := ds.getTableDB("snapshot_sets")
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := db.QueryContext(ctx, query, tenantID)
+	if err != nil {
+		return sets, errors.Wrap(err, "error getting snapshot sets from database")
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var set types.SnapshotSet
+		var volumes string
+
+		err = rows.Scan(&set.ID, &set.Name, &set.TenantID, &set.InstanceID, &set.WorkloadID,
+			&set.State, &set.Consistent, &set.CreateTime, &volumes)
+		if err != nil {
+			return []types.SnapshotSet{}, errors.Wrap(err, "error reading snapshot set row from database")
+		}
+
+		err = json.Unmarshal([]byte(volumes), &set.Volumes)
+		if err != nil {
+			return []types.SnapshotSet{}, errors.Wrap(err, "error unmarshalling snapshot set volumes")
+		}
+
+		sets = append(sets, set)
+	}
+
+	return sets, nil
+}
+
+func (ds *sqliteDB) addSignedRequest(ctx context.Context, rec types.SignedRequestRecord) error {
+	query := `INSERT OR IGNORE INTO signed_requests (nonce, method, path, signer, created, submit_time) VALUES (?, ?, ?, ?, ?, ?)`
+
+	db := ds.getTableDB("signed_requests")
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	res, err := db.ExecContext(ctx, query, rec.Nonce, rec.Method, rec.Path, rec.Signer, rec.Created, rec.SubmitTime)
+	if err != nil {
+		return errors.Wrap(err, "Error adding signed request to database")
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "Error adding signed request to database")
+	}
+
+	if count == 0 {
+		return types.ErrSignedRequestReplayed
+	}
+
+	return nil
+}
+
+func (ds *sqliteDB) getSignedRequests(ctx context.Context) ([]types.SignedRequestRecord, error) {
+	records := []types.SignedRequestRecord{}
+
+	query := `SELECT nonce, method, path, signer, created, submit_time FROM signed_requests ORDER BY submit_time`
+
+	db := ds.getTableDB("signed_requests")
+	ctx, unlock, err := ds.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return records, errors.Wrap(err, "error getting signed requests from database")
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var rec types.SignedRequestRecord
+
+		err = rows.Scan(&rec.Nonce, &rec.Method, &rec.Path, &rec.Signer, &rec.Created, &rec.SubmitTime)
+		if err != nil {
+			return []types.SignedRequestRecord{}, errors.Wrap(err, "error reading signed request row from database")
+		}
+
+		records = append(records, rec)
+	}
+
+	return records, nil
+}
@@ -15,6 +15,9 @@
 package datastore
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
 	"fmt"
 	"os"
 	"reflect"
@@ -25,6 +28,8 @@ import (
 	"github.com/ciao-project/ciao/ciao-storage"
 	"github.com/ciao-project/ciao/payloads"
 	"github.com/ciao-project/ciao/uuid"
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
 )
 
 var dbCount = 1
@@ -46,7 +51,7 @@ func TestSQLiteDBGetWorkloadStorage(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	_, err = db.getWorkloadStorage("validid")
+	_, err = db.getWorkloadStorage(ctx, "validid")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -71,13 +76,13 @@ func TestSQLiteDBGetTenantDevices(t *testing.T) {
 		CreateTime:  time.Now(),
 	}
 
-	err = db.addBlockData(data)
+	err = db.addBlockData(ctx, data)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// make sure our query works.
-	devices, err := db.getTenantDevices(data.TenantID)
+	devices, err := db.getTenantDevices(ctx, data.TenantID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -90,6 +95,198 @@ func TestSQLiteDBGetTenantDevices(t *testing.T) {
 	db.disconnect()
 }
 
+func TestSQLiteDBDeletedBlockData(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := types.Volume{
+		BlockDevice: storage.BlockDevice{ID: uuid.Generate().String()},
+		State:       types.Available,
+		TenantID:    uuid.Generate().String(),
+		CreateTime:  time.Now(),
+	}
+
+	err = db.addBlockData(ctx, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, deleteTime := range []time.Time{time.Now(), {}} {
+		data.State = types.Deleted
+		data.DeleteTime = deleteTime
+		if deleteTime.IsZero() {
+			data.State = types.Available
+		}
+
+		err = db.updateBlockData(ctx, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		devices, err := db.getTenantDevices(ctx, data.TenantID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		vol := devices[data.ID]
+		if vol.State != data.State || !vol.DeleteTime.Equal(deleteTime) {
+			t.Fatalf("Expected %s deleted at %v, got %s deleted at %v",
+				data.State, deleteTime, vol.State, vol.DeleteTime)
+		}
+	}
+
+	db.disconnect()
+}
+
+func TestSQLiteDBInstanceRescue(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := types.Instance{
+		ID:         uuid.Generate().String(),
+		TenantID:   uuid.Generate().String(),
+		WorkloadID: uuid.Generate().String(),
+		IPAddress:  "172.16.0.2",
+	}
+
+	err = db.addInstance(ctx, &i)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volumeID := uuid.Generate().String()
+	err = db.updateInstanceRescue(ctx, i.ID, volumeID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instances, err := db.getInstances(ctx)
+	if err != nil || len(instances) != 1 {
+		t.Fatal(err)
+	}
+
+	if instances[0].RescueVolume != volumeID || !instances[0].Rescued {
+		t.Fatalf("Expected rescue volume %s, got %s (rescued %v)",
+			volumeID, instances[0].RescueVolume, instances[0].Rescued)
+	}
+
+	db.disconnect()
+}
+
+func TestSQLiteDBFailures(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := types.Instance{
+		ID:         uuid.Generate().String(),
+		TenantID:   uuid.Generate().String(),
+		WorkloadID: uuid.Generate().String(),
+		IPAddress:  "172.16.0.2",
+	}
+
+	err = db.addInstance(ctx, &i)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instances, err := db.getInstances(ctx)
+	if err != nil || len(instances) != 1 {
+		t.Fatal(err)
+	}
+
+	if instances[0].Failure != nil {
+		t.Fatalf("Expected instance without failure, got %+v", instances[0].Failure)
+	}
+
+	failure := types.NewStartFailure(payloads.ImageFailure)
+	err = db.updateInstanceFailure(ctx, i.ID, failure)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instances, err = db.getInstances(ctx)
+	if err != nil || len(instances) != 1 {
+		t.Fatal(err)
+	}
+
+	stored := instances[0].Failure
+	if stored == nil || stored.Code != types.FailureImage ||
+		stored.Operation != types.FailureStart ||
+		stored.Reason != string(payloads.ImageFailure) ||
+		!stored.Time.Equal(failure.Time) {
+		t.Fatalf("Expected failure %+v, got %+v", failure, stored)
+	}
+
+	data := types.Volume{
+		BlockDevice: storage.BlockDevice{ID: uuid.Generate().String()},
+		TenantID:    i.TenantID,
+		State:       types.Attaching,
+		CreateTime:  time.Now(),
+	}
+
+	err = db.addBlockData(ctx, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data.State = types.Available
+	data.Failure = types.NewAttachVolumeFailure(payloads.AttachVolumeNotSupported)
+	err = db.updateBlockData(ctx, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	devices, err := db.getAllBlockData(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vol := devices[data.ID]
+	if vol.Failure == nil || vol.Failure.Code != types.FailureNotSupported ||
+		vol.Failure.Operation != types.FailureAttachVolume {
+		t.Fatalf("Expected failure %+v, got %+v", data.Failure, vol.Failure)
+	}
+
+	db.disconnect()
+}
+
+func TestSQLiteDBInstanceGroup(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := types.Instance{
+		ID:         uuid.Generate().String(),
+		TenantID:   uuid.Generate().String(),
+		WorkloadID: uuid.Generate().String(),
+		IPAddress:  "172.16.0.2",
+		Group:      "web",
+	}
+
+	err = db.addInstance(ctx, &i)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instances, err := db.getInstances(ctx)
+	if err != nil || len(instances) != 1 {
+		t.Fatal(err)
+	}
+
+	if instances[0].Group != i.Group {
+		t.Fatalf("Expected group %s, got %s", i.Group, instances[0].Group)
+	}
+
+	db.disconnect()
+}
+
 func TestSQLiteDBGetTenantWithStorage(t *testing.T) {
 	db, err := getPersistentStore()
 	if err != nil {
@@ -103,7 +300,7 @@ func TestSQLiteDBGetTenantWithStorage(t *testing.T) {
 		SubnetBits: 24,
 	}
 
-	err = db.addTenant(tenantID, config)
+	err = db.addTenant(ctx, tenantID, config)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -119,13 +316,13 @@ func TestSQLiteDBGetTenantWithStorage(t *testing.T) {
 		CreateTime:  time.Now(),
 	}
 
-	err = db.addBlockData(data)
+	err = db.addBlockData(ctx, data)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// make sure our query works.
-	tenant, err := db.getTenant(data.TenantID)
+	tenant, err := db.getTenant(ctx, data.TenantID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -160,12 +357,12 @@ func TestSQLiteDBGetAllBlockData(t *testing.T) {
 		Internal:    true,
 	}
 
-	err = db.addBlockData(data)
+	err = db.addBlockData(ctx, data)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	devices, err := db.getAllBlockData()
+	devices, err := db.getAllBlockData(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -199,17 +396,17 @@ func TestSQLiteDBDeleteBlockData(t *testing.T) {
 		CreateTime:  time.Now(),
 	}
 
-	err = db.addBlockData(data)
+	err = db.addBlockData(ctx, data)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = db.deleteBlockData(data.ID)
+	err = db.deleteBlockData(ctx, data.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	devices, err := db.getAllBlockData()
+	devices, err := db.getAllBlockData(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -235,12 +432,12 @@ func TestSQLiteDBGetAllStorageAttachments(t *testing.T) {
 		Ephemeral:  false,
 	}
 
-	err = db.addStorageAttachment(a)
+	err = db.addStorageAttachment(ctx, a)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	attachments, err := db.getAllStorageAttachments()
+	attachments, err := db.getAllStorageAttachments(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -262,12 +459,12 @@ func TestSQLiteDBGetAllStorageAttachments(t *testing.T) {
 		Ephemeral:  true,
 	}
 
-	err = db.addStorageAttachment(b)
+	err = db.addStorageAttachment(ctx, b)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	attachments, err = db.getAllStorageAttachments()
+	attachments, err = db.getAllStorageAttachments(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -276,12 +473,12 @@ func TestSQLiteDBGetAllStorageAttachments(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = db.deleteStorageAttachment(a.ID)
+	err = db.deleteStorageAttachment(ctx, a.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	attachments, err = db.getAllStorageAttachments()
+	attachments, err = db.getAllStorageAttachments(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -309,12 +506,12 @@ func TestCreatePool(t *testing.T) {
 		Name: "test",
 	}
 
-	err = db.addPool(pool)
+	err = db.addPool(ctx, pool)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	pools := db.getAllPools()
+	pools := db.getAllPools(ctx)
 	if pools == nil {
 		t.Fatal("pool not stored")
 	}
@@ -338,7 +535,7 @@ func TestUpdatePool(t *testing.T) {
 		Name: "test",
 	}
 
-	err = db.addPool(pool)
+	err = db.addPool(ctx, pool)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -346,12 +543,12 @@ func TestUpdatePool(t *testing.T) {
 	pool.Free = 2
 	pool.TotalIPs = 10
 
-	err = db.updatePool(pool)
+	err = db.updatePool(ctx, pool)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	pools := db.getAllPools()
+	pools := db.getAllPools(ctx)
 	if pools == nil {
 		t.Fatal("pool not stored")
 	}
@@ -375,12 +572,12 @@ func TestDeletePool(t *testing.T) {
 		Name: "test",
 	}
 
-	err = db.addPool(pool)
+	err = db.addPool(ctx, pool)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	pools := db.getAllPools()
+	pools := db.getAllPools(ctx)
 	if pools == nil {
 		t.Fatal("pool not stored")
 	}
@@ -390,12 +587,12 @@ func TestDeletePool(t *testing.T) {
 		t.Fatal("pool not updated")
 	}
 
-	err = db.deletePool(pool.ID)
+	err = db.deletePool(ctx, pool.ID)
 	if err != nil {
 		t.Fatal("pool not deleted")
 	}
 
-	pools = db.getAllPools()
+	pools = db.getAllPools(ctx)
 	if pools == nil {
 		t.Fatal("pool not stored")
 	}
@@ -419,7 +616,7 @@ func TestCreateSubnet(t *testing.T) {
 		Name: "test",
 	}
 
-	err = db.addPool(pool)
+	err = db.addPool(ctx, pool)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -431,12 +628,12 @@ func TestCreateSubnet(t *testing.T) {
 
 	pool.Subnets = append(pool.Subnets, subnet)
 
-	err = db.updatePool(pool)
+	err = db.updatePool(ctx, pool)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	pools := db.getAllPools()
+	pools := db.getAllPools(ctx)
 	if pools == nil {
 		t.Fatal("pool not stored")
 	}
@@ -476,18 +673,18 @@ func TestDeleteSubnet(t *testing.T) {
 
 	pool.Subnets = append(pool.Subnets, subnet)
 
-	err = db.addPool(pool)
+	err = db.addPool(ctx, pool)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	pool.Subnets = []types.ExternalSubnet{}
-	err = db.updatePool(pool)
+	err = db.updatePool(ctx, pool)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	pools := db.getAllPools()
+	pools := db.getAllPools(ctx)
 	if pools == nil {
 		t.Fatal("pool not stored")
 	}
@@ -523,12 +720,12 @@ func TestCreateAddress(t *testing.T) {
 
 	pool.IPs = append(pool.IPs, IP)
 
-	err = db.addPool(pool)
+	err = db.addPool(ctx, pool)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	pools := db.getAllPools()
+	pools := db.getAllPools(ctx)
 	if pools == nil {
 		t.Fatal("pool not stored")
 	}
@@ -564,19 +761,19 @@ func TestDeleteAddress(t *testing.T) {
 
 	pool.IPs = append(pool.IPs, IP)
 
-	err = db.addPool(pool)
+	err = db.addPool(ctx, pool)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	pool.IPs = []types.ExternalIP{}
 
-	err = db.updatePool(pool)
+	err = db.updatePool(ctx, pool)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	pools := db.getAllPools()
+	pools := db.getAllPools(ctx)
 	if pools == nil {
 		t.Fatal("pool not stored")
 	}
@@ -607,12 +804,12 @@ func TestCreateMappedIP(t *testing.T) {
 		IPAddress:  "172.16.0.2",
 	}
 
-	err = db.addInstance(&i)
+	err = db.addInstance(ctx, &i)
 	if err != nil {
 		t.Fatalf("unable to store instance: %v\n", err)
 	}
 
-	instances, err := db.getInstances()
+	instances, err := db.getInstances(ctx)
 	if err != nil || len(instances) != 1 {
 		t.Fatal(err)
 	}
@@ -622,7 +819,7 @@ func TestCreateMappedIP(t *testing.T) {
 		Name: "test",
 	}
 
-	err = db.addPool(pool)
+	err = db.addPool(ctx, pool)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -637,12 +834,12 @@ func TestCreateMappedIP(t *testing.T) {
 		PoolName:   pool.Name,
 	}
 
-	err = db.addMappedIP(m)
+	err = db.addMappedIP(ctx, m)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	IPs := db.getMappedIPs()
+	IPs := db.getMappedIPs(ctx)
 	if len(IPs) != 1 {
 		t.Fatal("could not get mapped IP")
 	}
@@ -665,12 +862,12 @@ func TestDeleteMappedIP(t *testing.T) {
 		IPAddress:  "172.16.0.2",
 	}
 
-	err = db.addInstance(&i)
+	err = db.addInstance(ctx, &i)
 	if err != nil {
 		t.Fatalf("unable to store instance: %v\n", err)
 	}
 
-	instances, err := db.getInstances()
+	instances, err := db.getInstances(ctx)
 	if err != nil || len(instances) != 1 {
 		t.Fatal(err)
 	}
@@ -680,7 +877,7 @@ func TestDeleteMappedIP(t *testing.T) {
 		Name: "test",
 	}
 
-	err = db.addPool(pool)
+	err = db.addPool(ctx, pool)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -695,12 +892,12 @@ func TestDeleteMappedIP(t *testing.T) {
 		PoolName:   pool.Name,
 	}
 
-	err = db.addMappedIP(m)
+	err = db.addMappedIP(ctx, m)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	IPs := db.getMappedIPs()
+	IPs := db.getMappedIPs(ctx)
 	if len(IPs) != 1 {
 		t.Fatal("could not get mapped IP")
 	}
@@ -709,12 +906,12 @@ func TestDeleteMappedIP(t *testing.T) {
 		t.Fatalf("expected %v, got %v\n", m, IPs[m.ExternalIP])
 	}
 
-	err = db.deleteMappedIP(m.ID)
+	err = db.deleteMappedIP(ctx, m.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	IPs = db.getMappedIPs()
+	IPs = db.getMappedIPs(ctx)
 	if len(IPs) != 0 {
 		t.Fatal("IP not deleted")
 	}
@@ -727,12 +924,12 @@ func createTestTenant(db persistentStore, t *testing.T) *tenant {
 		SubnetBits: 24,
 	}
 
-	err := db.addTenant(tid, config)
+	err := db.addTenant(ctx, tid, config)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	tn, err := db.getTenant(tid)
+	tn, err := db.getTenant(ctx, tid)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -756,7 +953,7 @@ func TestSQLiteDBTestTenants(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	tns, err := db.getTenants()
+	tns, err := db.getTenants(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -768,7 +965,7 @@ func TestSQLiteDBTestTenants(t *testing.T) {
 	_ = createTestTenant(db, t)
 	_ = createTestTenant(db, t)
 
-	tns, err = db.getTenants()
+	tns, err = db.getTenants(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -778,7 +975,7 @@ func TestSQLiteDBTestTenants(t *testing.T) {
 	}
 
 	for _, tn := range tns {
-		tn2, err := db.getTenant(tn.ID)
+		tn2, err := db.getTenant(ctx, tn.ID)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -788,6 +985,116 @@ func TestSQLiteDBTestTenants(t *testing.T) {
 	}
 }
 
+func TestSQLiteDBLockCancelled(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.disconnect()
+
+	sqlDB := db.(*sqliteDB)
+
+	// hold the database as a long running operation would.
+	sqlDB.dbLock <- struct{}{}
+
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+
+	config := types.TenantConfig{
+		Name:       "TestTenant",
+		SubnetBits: 24,
+	}
+
+	err = db.addTenant(tctx, uuid.Generate().String(), config)
+	if errors.Cause(err) != context.DeadlineExceeded {
+		t.Fatalf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	<-sqlDB.dbLock
+
+	_ = createTestTenant(db, t)
+}
+
+func TestSQLiteDBTenantNameInjection(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.disconnect()
+
+	tid := uuid.Generate().String()
+	config := types.TenantConfig{
+		Name:       "x'); DROP TABLE tenants; --",
+		SubnetBits: 24,
+	}
+
+	err = db.addTenant(ctx, tid, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tn, err := db.getTenant(ctx, tid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tn == nil {
+		t.Fatal("Expected added tenant")
+	}
+
+	if tn.Name != config.Name {
+		t.Fatalf("Expected %v, got %v", config.Name, tn.Name)
+	}
+
+	tns, err := db.getTenants(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tns) != 1 {
+		t.Fatalf("Expected 1 tenant, got %d", len(tns))
+	}
+}
+
+func TestSQLiteDBBlockDataInjection(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.disconnect()
+
+	tn := createTestTenant(db, t)
+
+	data := types.Volume{
+		BlockDevice: storage.BlockDevice{
+			ID: uuid.Generate().String(),
+		},
+		State:       types.Available,
+		TenantID:    tn.ID,
+		CreateTime:  time.Now(),
+		Name:        "vol'); DROP TABLE block_data; --",
+		Description: "it's \"quoted\"",
+	}
+
+	err = db.addBlockData(ctx, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	devices, err := db.getTenantDevices(ctx, tn.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, ok := devices[data.ID]
+	if !ok {
+		t.Fatal("Expected added block data")
+	}
+
+	if d.Name != data.Name || d.Description != data.Description {
+		t.Fatalf("Expected %q %q, got %q %q", data.Name, data.Description, d.Name, d.Description)
+	}
+}
+
 func TestSQLiteDBGetBatchFrameStatistics(t *testing.T) {
 	db, err := getPersistentStore()
 	if err != nil {
@@ -796,13 +1103,13 @@ func TestSQLiteDBGetBatchFrameStatistics(t *testing.T) {
 
 	frames := createTestFrameTraces("batch_frame_test")
 	for _, frame := range frames {
-		err := db.addFrameStat(frame)
+		err := db.addFrameStat(ctx, frame)
 		if err != nil {
 			t.Fatal(err)
 		}
 	}
 
-	_, err = db.getBatchFrameStatistics("batch_frame_test")
+	_, err = db.getBatchFrameStatistics(ctx, "batch_frame_test")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -816,16 +1123,67 @@ func TestSQLiteDBGetBatchFrameSummary(t *testing.T) {
 
 	frames := createTestFrameTraces("batch_summary_test")
 	for _, frame := range frames {
-		err := db.addFrameStat(frame)
+		err := db.addFrameStat(ctx, frame)
 		if err != nil {
 			t.Fatal(err)
 		}
 	}
 
-	_, err = db.getBatchFrameSummary()
+	_, err = db.getBatchFrameSummary(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSQLiteDBDeleteBatchFrameStatistics(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, label := range []string{"batch_delete_test", "batch_keep_test"} {
+		for _, frame := range createTestFrameTraces(label) {
+			err := db.addFrameStat(ctx, frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	err = db.deleteBatchFrameStatistics(ctx, "batch_delete_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summaries, err := db.getBatchFrameSummary(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, s := range summaries {
+		if s.BatchID == "batch_delete_test" {
+			t.Fatal("Trace data not deleted")
+		}
+		if s.BatchID == "batch_keep_test" {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Fatal("Unrelated trace data deleted")
+	}
+
+	var count int
+	err = db.(*sqliteDB).getTableDB("trace_data").QueryRow(`SELECT count(*) FROM trace_data
+		WHERE frame_id NOT IN (SELECT id FROM frame_statistics)`).Scan(&count)
 	if err != nil {
 		t.Fatal(err)
 	}
+
+	if count != 0 {
+		t.Fatalf("%d orphaned trace data rows", count)
+	}
 }
 
 func TestSQLiteDBEventLog(t *testing.T) {
@@ -834,7 +1192,7 @@ func TestSQLiteDBEventLog(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	log, err := db.getEventLog()
+	log, err := db.getEventLog(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -851,12 +1209,12 @@ func TestSQLiteDBEventLog(t *testing.T) {
 		Message:   "test message 1",
 		NodeID:    "validNodeID",
 	}
-	err = db.logEvent(e)
+	err = db.logEvent(ctx, e)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	log, err = db.getEventLog()
+	log, err = db.getEventLog(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -865,12 +1223,12 @@ func TestSQLiteDBEventLog(t *testing.T) {
 	}
 
 	e.Message = "test message 2"
-	err = db.logEvent(e)
+	err = db.logEvent(ctx, e)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	log, err = db.getEventLog()
+	log, err = db.getEventLog(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -878,12 +1236,12 @@ func TestSQLiteDBEventLog(t *testing.T) {
 		t.Fatal("Expected 2 log message")
 	}
 
-	err = db.clearLog()
+	err = db.clearLog(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	log, err = db.getEventLog()
+	log, err = db.getEventLog(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -915,22 +1273,92 @@ func TestSQLiteDBInstanceStats(t *testing.T) {
 
 	nodeID := uuid.Generate().String()
 
-	err = db.addInstanceStats(stats, nodeID)
+	err = db.addInstanceStats(ctx, stats, nodeID)
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
-func TestSQLiteDBUpdateDeleteWorkload(t *testing.T) {
+func TestSQLiteDBWorkloadCategory(t *testing.T) {
 	db, err := getPersistentStore()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	tn := createTestTenant(db, t)
+	wl := types.Workload{
+		ID:          uuid.Generate().String(),
+		Description: "testWorkload",
+		FWType:      string(payloads.EFI),
+		VMType:      payloads.QEMU,
+		Visibility:  types.Public,
+		Category:    "databases",
+		Storage:     []types.StorageResource{},
+	}
 
-	testConfig := `
----
+	filename := fmt.Sprintf("%s/%s_config.yaml", *workloadsPath, wl.ID)
+	defer func() { _ = os.Remove(filename) }()
+
+	err = db.addWorkload(ctx, wl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getCategory := func() string {
+		workloads, err := db.getWorkloads(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, w := range workloads {
+			if w.ID == wl.ID {
+				return w.Category
+			}
+		}
+
+		t.Fatal("Workload not found")
+		return ""
+	}
+
+	if category := getCategory(); category != "databases" {
+		t.Fatalf("Expected category databases, got %q", category)
+	}
+
+	err = db.updateWorkloadCategory(ctx, wl.ID, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if category := getCategory(); category != "web" {
+		t.Fatalf("Expected category web, got %q", category)
+	}
+
+	err = db.updateWorkloadCategory(ctx, wl.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if category := getCategory(); category != "" {
+		t.Fatalf("Expected no category, got %q", category)
+	}
+
+	err = db.deleteWorkload(ctx, wl.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.disconnect()
+}
+
+func TestSQLiteDBUpdateDeleteWorkload(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tn := createTestTenant(db, t)
+
+	testConfig := `
+---
 #cloud-config
 users:
   - name: demouser
@@ -962,18 +1390,24 @@ users:
 			MemMB: 512,
 		},
 		Storage: []types.StorageResource{storage},
+		Autoscale: &types.AutoscalePolicy{
+			MinInstances: 1,
+			MaxInstances: 4,
+			CPUTarget:    70,
+		},
+		Secrets: []string{"api-key", "db-password"},
 	}
 
 	// file will be added, so we will want to remove it.
 	filename := fmt.Sprintf("%s/%s_config.yaml", *workloadsPath, wl.ID)
 	defer func() { _ = os.Remove(filename) }()
 
-	err = db.addWorkload(wl)
+	err = db.addWorkload(ctx, wl)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	workloads, err := db.getWorkloads()
+	workloads, err := db.getWorkloads(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -993,12 +1427,12 @@ users:
 	}
 
 	// now try to delete the workload
-	err = db.deleteWorkload(wl.ID)
+	err = db.deleteWorkload(ctx, wl.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	workloads, err = db.getWorkloads()
+	workloads, err = db.getWorkloads(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1026,7 +1460,7 @@ func TestSQLiteDBAddQuotas(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	qds, err := db.getQuotas("test-tenand-id")
+	qds, err := db.getQuotas(ctx, "test-tenand-id")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1035,12 +1469,12 @@ func TestSQLiteDBAddQuotas(t *testing.T) {
 		t.Fatalf("Expected zero quota entries: got %d", len(qds))
 	}
 
-	err = db.updateQuotas("test-tenant-id", []types.QuotaDetails{{Name: "test-quota-name", Value: 10}})
+	err = db.updateQuotas(ctx, "test-tenant-id", []types.QuotaDetails{{Name: "test-quota-name", Value: 10}})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	qds, err = db.getQuotas("test-tenant-id")
+	qds, err = db.getQuotas(ctx, "test-tenant-id")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1049,12 +1483,12 @@ func TestSQLiteDBAddQuotas(t *testing.T) {
 		t.Fatal("Added quota not found")
 	}
 
-	err = db.updateQuotas("test-tenant-id", []types.QuotaDetails{{Name: "test-quota-name-2", Value: 20}})
+	err = db.updateQuotas(ctx, "test-tenant-id", []types.QuotaDetails{{Name: "test-quota-name-2", Value: 20}})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	qds, err = db.getQuotas("test-tenant-id")
+	qds, err = db.getQuotas(ctx, "test-tenant-id")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1074,7 +1508,7 @@ func TestSQLiteDBUpdateQuotas(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	qds, err := db.getQuotas("test-tenand-id")
+	qds, err := db.getQuotas(ctx, "test-tenand-id")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1083,12 +1517,12 @@ func TestSQLiteDBUpdateQuotas(t *testing.T) {
 		t.Fatalf("Expected zero quota entries: got %d", len(qds))
 	}
 
-	err = db.updateQuotas("test-tenant-id", []types.QuotaDetails{{Name: "test-quota-name", Value: 10}})
+	err = db.updateQuotas(ctx, "test-tenant-id", []types.QuotaDetails{{Name: "test-quota-name", Value: 10}})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	qds, err = db.getQuotas("test-tenant-id")
+	qds, err = db.getQuotas(ctx, "test-tenant-id")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1097,12 +1531,12 @@ func TestSQLiteDBUpdateQuotas(t *testing.T) {
 		t.Fatal("Added quota not found")
 	}
 
-	err = db.updateQuotas("test-tenant-id", []types.QuotaDetails{{Name: "test-quota-name", Value: 20}})
+	err = db.updateQuotas(ctx, "test-tenant-id", []types.QuotaDetails{{Name: "test-quota-name", Value: 20}})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	qds, err = db.getQuotas("test-tenant-id")
+	qds, err = db.getQuotas(ctx, "test-tenant-id")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1132,7 +1566,7 @@ func TestInstanceNameConstraint(t *testing.T) {
 		Name:       "test",
 	}
 
-	err = db.addInstance(&i)
+	err = db.addInstance(ctx, &i)
 	if err != nil {
 		t.Fatalf("unable to store instance %v\n", err)
 	}
@@ -1145,7 +1579,7 @@ func TestInstanceNameConstraint(t *testing.T) {
 		Name:       "test",
 	}
 
-	err = db.addInstance(&i2)
+	err = db.addInstance(ctx, &i2)
 
 	if err == nil {
 		t.Fatal("Expected instance add to fail (duplicate name)")
@@ -1168,12 +1602,12 @@ func TestAddCNCIInstance(t *testing.T) {
 		CNCI:       true,
 	}
 
-	err = db.addInstance(&i)
+	err = db.addInstance(ctx, &i)
 	if err != nil {
 		t.Fatalf("unable to store instance %v\n", err)
 	}
 
-	instances, err := db.getInstances()
+	instances, err := db.getInstances(ctx)
 	if err != nil || len(instances) != 1 {
 		t.Fatal(err)
 	}
@@ -1185,6 +1619,39 @@ func TestAddCNCIInstance(t *testing.T) {
 	db.disconnect()
 }
 
+func TestAddPreemptibleInstance(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenantID := uuid.Generate().String()
+	i := types.Instance{
+		ID:          uuid.Generate().String(),
+		TenantID:    tenantID,
+		WorkloadID:  uuid.Generate().String(),
+		IPAddress:   "172.16.0.2",
+		Name:        "test",
+		Preemptible: true,
+	}
+
+	err = db.addInstance(ctx, &i)
+	if err != nil {
+		t.Fatalf("unable to store instance %v\n", err)
+	}
+
+	instances, err := db.getInstances(ctx)
+	if err != nil || len(instances) != 1 {
+		t.Fatal(err)
+	}
+
+	if instances[0].Preemptible != true {
+		t.Fatal("Preemptible Instance not properly stored")
+	}
+
+	db.disconnect()
+}
+
 func TestSQLiteDBUpdateTenant(t *testing.T) {
 	db, err := getPersistentStore()
 	if err != nil {
@@ -1197,12 +1664,12 @@ func TestSQLiteDBUpdateTenant(t *testing.T) {
 		SubnetBits: 24,
 	}
 
-	err = db.addTenant(tenantID, config)
+	err = db.addTenant(ctx, tenantID, config)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	tenant, err := db.getTenant(tenantID)
+	tenant, err := db.getTenant(ctx, tenantID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1210,13 +1677,14 @@ func TestSQLiteDBUpdateTenant(t *testing.T) {
 	tenant.Name = "name2"
 	tenant.SubnetBits = 20
 	tenant.Permissions.PrivilegedContainers = true
+	tenant.CNCIFlavor = types.CNCIFlavor{VCPUs: 8, MemMB: 4096}
 
-	err = db.updateTenant(&tenant.Tenant)
+	err = db.updateTenant(ctx, &tenant.Tenant)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	tenant, err = db.getTenant(tenantID)
+	tenant, err = db.getTenant(ctx, tenantID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1225,6 +1693,10 @@ func TestSQLiteDBUpdateTenant(t *testing.T) {
 		t.Fatal("update not successful")
 	}
 
+	if tenant.CNCIFlavor.VCPUs != 8 || tenant.CNCIFlavor.MemMB != 4096 || tenant.CNCIFlavor.DiskGiB != 0 {
+		t.Fatalf("CNCI flavor not updated: %+v", tenant.CNCIFlavor)
+	}
+
 	db.disconnect()
 }
 
@@ -1241,12 +1713,12 @@ func TestSQLiteDBTenantPermissions(t *testing.T) {
 	}
 	config.Permissions.PrivilegedContainers = true
 
-	err = db.addTenant(tenantID, config)
+	err = db.addTenant(ctx, tenantID, config)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	tenant, err := db.getTenant(tenantID)
+	tenant, err := db.getTenant(ctx, tenantID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1255,7 +1727,7 @@ func TestSQLiteDBTenantPermissions(t *testing.T) {
 		t.Fatal("Expected tenant permission set correctly")
 	}
 
-	ts, err := db.getTenants()
+	ts, err := db.getTenants(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1268,7 +1740,7 @@ func TestSQLiteDBTenantPermissions(t *testing.T) {
 		t.Fatal("Expected tenant permission set correctly")
 	}
 
-	err = db.deleteTenant(tenantID)
+	err = db.deleteTenant(ctx, tenantID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1288,22 +1760,22 @@ func TestSQLiteDBDeleteTenant(t *testing.T) {
 		SubnetBits: 24,
 	}
 
-	err = db.addTenant(tenantID, config)
+	err = db.addTenant(ctx, tenantID, config)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = db.updateQuotas(tenantID, []types.QuotaDetails{{Name: "test-quota-name", Value: 10}})
+	err = db.updateQuotas(ctx, tenantID, []types.QuotaDetails{{Name: "test-quota-name", Value: 10}})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = db.deleteTenant(tenantID)
+	err = db.deleteTenant(ctx, tenantID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	tenant, err := db.getTenant(tenantID)
+	tenant, err := db.getTenant(ctx, tenantID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1324,12 +1796,12 @@ func TestSQLiteDBAddRemoveImages(t *testing.T) {
 		Name: "name1",
 	}
 
-	err = db.addTenant(tenantID, config)
+	err = db.addTenant(ctx, tenantID, config)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	images, err := db.getImages()
+	images, err := db.getImages(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1347,12 +1819,12 @@ func TestSQLiteDBAddRemoveImages(t *testing.T) {
 		Visibility: types.Public,
 	}
 
-	err = db.updateImage(i)
+	err = db.updateImage(ctx, i)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	images, err = db.getImages()
+	images, err = db.getImages(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1374,12 +1846,12 @@ func TestSQLiteDBAddRemoveImages(t *testing.T) {
 		Visibility: types.Private,
 	}
 
-	err = db.updateImage(i2)
+	err = db.updateImage(ctx, i2)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	images, err = db.getImages()
+	images, err = db.getImages(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1388,12 +1860,12 @@ func TestSQLiteDBAddRemoveImages(t *testing.T) {
 		t.Fatalf("Unexpected image count: %d vs 2", len(images))
 	}
 
-	err = db.deleteImage(i.ID)
+	err = db.deleteImage(ctx, i.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	images, err = db.getImages()
+	images, err = db.getImages(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1414,12 +1886,12 @@ func TestSQLiteDBUpdateImage(t *testing.T) {
 		Name: "name1",
 	}
 
-	err = db.addTenant(tenantID, config)
+	err = db.addTenant(ctx, tenantID, config)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	images, err := db.getImages()
+	images, err := db.getImages(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1437,12 +1909,12 @@ func TestSQLiteDBUpdateImage(t *testing.T) {
 		Visibility: types.Public,
 	}
 
-	err = db.updateImage(i)
+	err = db.updateImage(ctx, i)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	images, err = db.getImages()
+	images, err = db.getImages(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1457,12 +1929,12 @@ func TestSQLiteDBUpdateImage(t *testing.T) {
 
 	i.State = types.Killed
 
-	err = db.updateImage(i)
+	err = db.updateImage(ctx, i)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	images, err = db.getImages()
+	images, err = db.getImages(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1475,3 +1947,568 @@ func TestSQLiteDBUpdateImage(t *testing.T) {
 		t.Fatalf("Returned image not as expected %v vs %v", images[0], i)
 	}
 }
+
+func TestSQLiteDBFailedCommands(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := types.FailedCommand{
+		ID:         uuid.Generate().String(),
+		Command:    "DELETE",
+		InstanceID: uuid.Generate().String(),
+		NodeID:     uuid.Generate().String(),
+		Reason:     "Connection closed",
+		Timestamp:  time.Now().UTC(),
+		Payload:    "delete:\n  instance_uuid: test\n",
+	}
+
+	err = db.addFailedCommand(ctx, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmds, err := db.getFailedCommands(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cmds) != 1 {
+		t.Fatalf("Expected 1 failed command, got %d", len(cmds))
+	}
+
+	if !cmds[0].Timestamp.Equal(cmd.Timestamp) {
+		t.Fatalf("Expected timestamp %v, got %v", cmd.Timestamp, cmds[0].Timestamp)
+	}
+
+	cmds[0].Timestamp = cmd.Timestamp
+	if !reflect.DeepEqual(cmd, cmds[0]) {
+		t.Fatalf("Expected %+v, got %+v", cmd, cmds[0])
+	}
+
+	err = db.deleteFailedCommand(ctx, cmd.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmds, err = db.getFailedCommands(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cmds) != 0 {
+		t.Fatal("Failed command not deleted")
+	}
+}
+
+func TestSQLiteDBNodePolicies(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := types.NodePolicy{
+		NodeID:              uuid.Generate().String(),
+		Weight:              50,
+		MaxInstances:        10,
+		AllowCNCIColocation: true,
+		DedicatedTenant:     uuid.Generate().String(),
+	}
+
+	err = db.updateNodePolicy(ctx, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy.Weight = 20
+	err = db.updateNodePolicy(ctx, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policies, err := db.getNodePolicies(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(policies) != 1 || !reflect.DeepEqual(policy, policies[0]) {
+		t.Fatalf("Expected [%+v], got %+v", policy, policies)
+	}
+
+	err = db.deleteNodePolicy(ctx, policy.NodeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policies, err = db.getNodePolicies(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(policies) != 0 {
+		t.Fatal("Node policy not deleted")
+	}
+}
+
+func TestSQLiteDBSecrets(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := types.Secret{
+		Name:       "db-password",
+		TenantID:   uuid.Generate().String(),
+		CreateTime: time.Now().UTC(),
+		Data:       []byte{0x01, 0x02, 0x03},
+	}
+
+	err = db.updateSecret(ctx, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret.Data = []byte{0x04, 0x05}
+	err = db.updateSecret(ctx, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secrets, err := db.getSecrets(ctx, secret.TenantID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(secrets) != 1 || secrets[0].Name != secret.Name ||
+		!secrets[0].CreateTime.Equal(secret.CreateTime) ||
+		!bytes.Equal(secrets[0].Data, secret.Data) {
+		t.Fatalf("Expected [%+v], got %+v", secret, secrets)
+	}
+
+	secrets, err = db.getSecrets(ctx, uuid.Generate().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(secrets) != 0 {
+		t.Fatal("Secret visible to another tenant")
+	}
+
+	err = db.deleteSecret(ctx, secret.TenantID, secret.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secrets, err = db.getSecrets(ctx, secret.TenantID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(secrets) != 0 {
+		t.Fatal("Secret not deleted")
+	}
+}
+
+func TestSQLiteDBAPITokens(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := types.APIToken{
+		ID:         uuid.Generate().String(),
+		TenantID:   uuid.Generate().String(),
+		Name:       "ci",
+		Scope:      types.APITokenReadOnly,
+		CreateTime: time.Now().UTC(),
+		ExpiryTime: time.Now().UTC().Add(time.Hour),
+		Hash:       []byte{0x01, 0x02, 0x03},
+	}
+
+	err = db.addAPIToken(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := db.getAPIToken(ctx, token.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.TenantID != token.TenantID || stored.Name != token.Name ||
+		stored.Scope != token.Scope || !stored.ExpiryTime.Equal(token.ExpiryTime) ||
+		!bytes.Equal(stored.Hash, token.Hash) {
+		t.Fatalf("Expected %+v, got %+v", token, stored)
+	}
+
+	tokens, err := db.getAPITokens(ctx, token.TenantID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tokens) != 1 || tokens[0].ID != token.ID || tokens[0].Scope != token.Scope {
+		t.Fatalf("Expected [%+v], got %+v", token, tokens)
+	}
+
+	tokens, err = db.getAPITokens(ctx, uuid.Generate().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tokens) != 0 {
+		t.Fatal("API token visible to another tenant")
+	}
+
+	err = db.deleteAPIToken(ctx, token.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.getAPIToken(ctx, token.ID)
+	if err != types.ErrAPITokenNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrAPITokenNotFound, err)
+	}
+}
+
+func TestSQLiteDBFederationPeers(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer := types.FederationPeer{
+		ID:         uuid.Generate().String(),
+		Name:       "east",
+		URL:        "https://east.example.com:8889",
+		TenantID:   uuid.Generate().String(),
+		Workloads:  map[string]string{uuid.Generate().String(): uuid.Generate().String()},
+		CreateTime: time.Now().UTC(),
+		Token:      []byte{0x01, 0x02, 0x03},
+	}
+
+	err = db.addFederationPeer(ctx, peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peers, err := db.getFederationPeers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(peers) != 1 || peers[0].ID != peer.ID || peers[0].URL != peer.URL ||
+		!reflect.DeepEqual(peers[0].Workloads, peer.Workloads) ||
+		!bytes.Equal(peers[0].Token, peer.Token) {
+		t.Fatalf("Expected [%+v], got %+v", peer, peers)
+	}
+
+	i := types.Instance{
+		ID:         uuid.Generate().String(),
+		TenantID:   uuid.Generate().String(),
+		WorkloadID: uuid.Generate().String(),
+		IPAddress:  "172.16.0.3",
+	}
+
+	err = db.addInstance(ctx, &i)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remoteID := uuid.Generate().String()
+	err = db.updateInstancePeer(ctx, i.ID, peer.ID, remoteID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instances, err := db.getInstances(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored *types.Instance
+	for _, instance := range instances {
+		if instance.ID == i.ID {
+			stored = instance
+		}
+	}
+
+	if stored == nil || stored.PeerID != peer.ID || stored.RemoteID != remoteID {
+		t.Fatalf("Expected instance in peer %s as %s, got %+v", peer.ID, remoteID, stored)
+	}
+
+	err = db.deleteFederationPeer(ctx, peer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peers, err = db.getFederationPeers(ctx)
+	if err != nil || len(peers) != 0 {
+		t.Fatalf("Expected no peers, got %+v (%v)", peers, err)
+	}
+}
+
+func TestSQLiteDBSnapshotSets(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := types.SnapshotSet{
+		ID:         uuid.Generate().String(),
+		Name:       "nightly",
+		TenantID:   uuid.Generate().String(),
+		InstanceID: uuid.Generate().String(),
+		WorkloadID: uuid.Generate().String(),
+		State:      types.SnapshotSetCreating,
+		Consistent: true,
+		CreateTime: time.Now().UTC(),
+		Volumes: []types.SnapshotVolume{
+			{
+				ID:       uuid.Generate().String(),
+				VolumeID: uuid.Generate().String(),
+				Boot:     true,
+				Size:     20,
+			},
+		},
+	}
+
+	err = db.addSnapshotSet(ctx, set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set.State = types.SnapshotSetAvailable
+	err = db.updateSnapshotSet(ctx, set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sets, err := db.getSnapshotSets(ctx, set.TenantID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sets) != 1 || sets[0].ID != set.ID || sets[0].Name != set.Name ||
+		sets[0].State != types.SnapshotSetAvailable || !sets[0].Consistent ||
+		!reflect.DeepEqual(sets[0].Volumes, set.Volumes) {
+		t.Fatalf("Expected [%+v], got %+v", set, sets)
+	}
+
+	sets, err = db.getSnapshotSets(ctx, uuid.Generate().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sets) != 0 {
+		t.Fatalf("Got snapshot sets of another tenant: %+v", sets)
+	}
+
+	err = db.deleteSnapshotSet(ctx, set.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sets, err = db.getSnapshotSets(ctx, set.TenantID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sets) != 0 {
+		t.Fatalf("Snapshot set not deleted: %+v", sets)
+	}
+}
+
+func TestSQLiteDBSignedRequests(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := types.SignedRequestRecord{
+		Nonce:      uuid.Generate().String(),
+		Method:     "DELETE",
+		Path:       "/" + uuid.Generate().String() + "/volumes/" + uuid.Generate().String(),
+		Signer:     "admin",
+		Created:    time.Now().UTC().Add(-time.Hour),
+		SubmitTime: time.Now().UTC(),
+	}
+
+	err = db.addSignedRequest(ctx, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.addSignedRequest(ctx, rec)
+	if err != types.ErrSignedRequestReplayed {
+		t.Fatalf("Expected %v, got %v", types.ErrSignedRequestReplayed, err)
+	}
+
+	records, err := db.getSignedRequests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range records {
+		if r.Nonce == rec.Nonce {
+			if r.Method != rec.Method || r.Path != rec.Path || r.Signer != rec.Signer ||
+				!r.Created.Equal(rec.Created) || !r.SubmitTime.Equal(rec.SubmitTime) {
+				t.Fatalf("Expected %+v, got %+v", rec, r)
+			}
+			return
+		}
+	}
+
+	t.Fatalf("Signed request %s not recorded: %+v", rec.Nonce, records)
+}
+
+// baselineSchema creates the tables whose columns were extended since the
+// first releases of ciao as they were then defined, with a row in each.
+var baselineSchema = []string{
+	`CREATE TABLE tenants
+		(
+		id varchar(32) primary key,
+		name text,
+		subnet_bits int,
+		permissions text
+		);`,
+	`CREATE TABLE instances
+		(
+		id string primary key,
+		tenant_id string,
+		workload_id string,
+		mac_address string,
+		vnic_uuid string,
+		subnet string,
+		ip string,
+		create_time DATETIME,
+		name string,
+		cnci int,
+		foreign key(tenant_id) references tenants(id),
+		foreign key(workload_id) references workload_template(id),
+		unique(tenant_id, ip, mac_address)
+		);`,
+	`CREATE TABLE block_data
+		(
+		id string primary_key,
+		tenant_id string,
+		size integer,
+		state string,
+		create_time DATETIME,
+		name string,
+		description string,
+		internal int,
+		foreign key(tenant_id) references tenants(id)
+		);`,
+	`CREATE TABLE workload_storage
+	        (
+		workload_id string,
+		volume_id string,
+		bootable int,
+		ephemeral int,
+		size integer,
+		source_type string,
+		source_id string,
+		tag string,
+		foreign key(workload_id) references workloads(id),
+		foreign key(volume_id) references block_data(id)
+		);`,
+	`INSERT INTO tenants VALUES ('tenant', 'old tenant', 24, 'null');`,
+	`INSERT INTO instances VALUES ('old-instance', 'tenant', 'workload', 'aa:bb:cc:dd:ee:ff',
+		'vnic', '172.16.0.0', '172.16.0.2', '2017-01-01T00:00:00Z', 'old', 0);`,
+	`INSERT INTO block_data VALUES ('old-volume', 'tenant', 1, 'available',
+		'2017-01-01T00:00:00Z', 'old', '', 0);`,
+	`INSERT INTO workload_storage VALUES ('workload', 'old-volume', 1, 0, 1,
+		'image', 'image', '');`,
+}
+
+// Test that databases created by older versions of ciao are upgraded
+//
+// Creates the instances, block_data, workload_storage and tenants tables
+// with their original columns, initialises the datastore with the
+// database, then reads the existing rows and adds new ones.
+//
+// The missing columns should be added, the existing rows should be read
+// with the default values of the new columns and new rows should be
+// stored with all their columns.
+func TestSQLiteDBUpgradeSchema(t *testing.T) {
+	URI := fmt.Sprintf("file:memdb%d?mode=memory&cache=shared", dbCount)
+	dbCount = dbCount + 2
+
+	sql.Register("baseline-"+URI, &sqlite3.SQLiteDriver{})
+	baseline, err := sql.Open("baseline-"+URI, URI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = baseline.Close() }()
+
+	for _, cmd := range baselineSchema {
+		if _, err := baseline.Exec(cmd); err != nil {
+			t.Fatalf("Unable to create baseline schema: %v", err)
+		}
+	}
+
+	db := &sqliteDB{}
+	err = db.init(Config{PersistentURI: URI, InitWorkloadsPath: *workloadsPath})
+	if err != nil {
+		t.Fatalf("Unable to upgrade database: %v", err)
+	}
+	defer db.disconnect()
+
+	// a second initialisation finds all the columns in place
+	for _, table := range db.tables {
+		if err := table.Init(); err != nil {
+			t.Fatalf("Unable to initialise table %s again: %v", table.Name(), err)
+		}
+	}
+
+	tenant, err := db.getTenant(ctx, "tenant")
+	if err != nil || tenant == nil {
+		t.Fatalf("Unable to get existing tenant: %v", err)
+	}
+
+	instances, err := db.getInstances(ctx)
+	if err != nil || len(instances) != 1 {
+		t.Fatalf("Unable to get existing instance: %v", err)
+	}
+	if i := instances[0]; i.ID != "old-instance" || i.Preemptible || i.Rescued ||
+		i.Group != "" || i.Failure != nil || !i.DeleteTime.IsZero() {
+		t.Fatalf("Unexpected existing instance %+v", i)
+	}
+
+	volumes, err := db.getAllBlockData(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := volumes["old-volume"]; !ok || v.Class != "" || v.Encrypted || v.Failure != nil {
+		t.Fatalf("Unexpected existing volumes %+v", volumes)
+	}
+
+	storage, err := db.getWorkloadStorage(ctx, "workload")
+	if err != nil || len(storage) != 1 || storage[0].CDROM || storage[0].Class != "" {
+		t.Fatalf("Unexpected existing workload storage %+v: %v", storage, err)
+	}
+
+	i := types.Instance{
+		ID:          uuid.Generate().String(),
+		TenantID:    "tenant",
+		WorkloadID:  "workload",
+		IPAddress:   "172.16.0.3",
+		Preemptible: true,
+		Group:       "group",
+	}
+	err = db.addInstance(ctx, &i)
+	if err != nil {
+		t.Fatalf("Unable to add instance to upgraded database: %v", err)
+	}
+
+	instances, err = db.getInstances(ctx)
+	if err != nil || len(instances) != 2 {
+		t.Fatalf("Unable to get instances: %v", err)
+	}
+	for _, instance := range instances {
+		if instance.ID == i.ID && (!instance.Preemptible || instance.Group != "group") {
+			t.Fatalf("Expected preemptible instance of group, got %+v", instance)
+		}
+	}
+}
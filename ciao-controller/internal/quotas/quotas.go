@@ -15,6 +15,8 @@
 package quotas
 
 import (
+	"strings"
+
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/ciao-project/ciao/payloads"
 )
@@ -82,6 +84,7 @@ var supportedResources = [...]payloads.Resource{
 	payloads.MemMB,
 	payloads.Volume,
 	payloads.SharedDiskGiB,
+	payloads.ArchiveDiskGiB,
 	payloads.Instance,
 	payloads.Image,
 	payloads.ExternalIP,
@@ -102,6 +105,30 @@ func makeTentantData() *tenantData {
 	return &td
 }
 
+// storageClassQuotaPrefix and storageClassQuotaSuffix enclose the name of a
+// storage class in the name of the quota on the storage of its volumes.
+const (
+	storageClassQuotaPrefix = "tenant-storage-"
+	storageClassQuotaSuffix = "-quota"
+)
+
+// storageClassResourcePrefix is the prefix of the resources used for the
+// storage of the volumes of a storage class.
+var storageClassResourcePrefix = string(payloads.StorageClassDiskGiB(""))
+
+// getQuota returns the quota of a resource, creating the quota of a storage
+// class the first time it is used.
+func (td *tenantData) getQuota(r payloads.Resource) (*quota, bool) {
+	q, ok := td.quotas[r]
+	if !ok && strings.HasPrefix(string(r), storageClassResourcePrefix) {
+		q = &quota{-1, 0}
+		td.quotas[r] = q
+		ok = true
+	}
+
+	return q, ok
+}
+
 func getTenantData(tenantDetails map[string]*tenantData, tenantID string) *tenantData {
 	td, ok := tenantDetails[tenantID]
 	if !ok {
@@ -117,7 +144,7 @@ func consumeQuota(tenantDetails map[string]*tenantData, op *consumeOp) Result {
 	allowed := true
 
 	for _, r := range op.resources {
-		q, ok := td.quotas[r.Type]
+		q, ok := td.getQuota(r.Type)
 
 		if ok {
 			q.consumed += r.Value
@@ -169,7 +196,7 @@ func release(tenantDetails map[string]*tenantData, op *releaseOp) {
 	td := getTenantData(tenantDetails, op.tenantID)
 
 	for _, r := range op.resources {
-		q, ok := td.quotas[r.Type]
+		q, ok := td.getQuota(r.Type)
 
 		if ok {
 			q.consumed -= r.Value
@@ -188,6 +215,8 @@ func quotaNameToResource(name string) payloads.Resource {
 		return payloads.MemMB
 	case "tenant-storage-quota":
 		return payloads.SharedDiskGiB
+	case "tenant-archive-quota":
+		return payloads.ArchiveDiskGiB
 	case "tenant-volumes-quota":
 		return payloads.Volume
 	case "tenant-instances-quota":
@@ -198,6 +227,15 @@ func quotaNameToResource(name string) payloads.Resource {
 		return payloads.ExternalIP
 	}
 
+	if strings.HasPrefix(name, storageClassQuotaPrefix) &&
+		strings.HasSuffix(name, storageClassQuotaSuffix) {
+		class := strings.TrimSuffix(strings.TrimPrefix(name, storageClassQuotaPrefix),
+			storageClassQuotaSuffix)
+		if class != "" {
+			return payloads.StorageClassDiskGiB(class)
+		}
+	}
+
 	return ""
 }
 
@@ -211,6 +249,8 @@ func resourceToQuotaName(r payloads.Resource) string {
 		return "tenant-volumes-quota"
 	case payloads.SharedDiskGiB:
 		return "tenant-storage-quota"
+	case payloads.ArchiveDiskGiB:
+		return "tenant-archive-quota"
 	case payloads.Instance:
 		return "tenant-instances-quota"
 	case payloads.Image:
@@ -218,6 +258,12 @@ func resourceToQuotaName(r payloads.Resource) string {
 	case payloads.ExternalIP:
 		return "tenant-external-ips-quota"
 	}
+
+	if strings.HasPrefix(string(r), storageClassResourcePrefix) {
+		class := strings.TrimPrefix(string(r), storageClassResourcePrefix)
+		return storageClassQuotaPrefix + class + storageClassQuotaSuffix
+	}
+
 	return ""
 }
 
@@ -228,7 +274,9 @@ func update(tenantDetails map[string]*tenantData, op *updateOp) {
 		r := quotaNameToResource(q.Name)
 
 		if r != "" {
-			td.quotas[r].limit = q.Value
+			if quota, ok := td.getQuota(r); ok {
+				quota.limit = q.Value
+			}
 		}
 
 		switch q.Name {
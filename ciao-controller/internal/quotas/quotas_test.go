@@ -165,6 +165,7 @@ func TestResourceQuotaMapping(t *testing.T) {
 		payloads.Instance,
 		payloads.Image,
 		payloads.ExternalIP,
+		payloads.StorageClassDiskGiB("ssd"),
 	}
 
 	for _, resource := range resources {
@@ -177,6 +178,46 @@ func TestResourceQuotaMapping(t *testing.T) {
 	}
 }
 
+func TestStorageClassQuota(t *testing.T) {
+	qs := &Quotas{}
+	qs.Init()
+
+	quotas := []types.QuotaDetails{{Name: "tenant-storage-ssd-quota", Value: 10}}
+
+	qs.Update("test-tenant-1", quotas)
+
+	ssd := payloads.StorageClassDiskGiB("ssd")
+	res := <-qs.Consume("test-tenant-1",
+		payloads.RequestedResource{Type: payloads.SharedDiskGiB, Value: 8},
+		payloads.RequestedResource{Type: ssd, Value: 8})
+	if !res.Allowed() {
+		t.Fatal("Expected to be allowed")
+	}
+
+	res2 := <-qs.Consume("test-tenant-1",
+		payloads.RequestedResource{Type: payloads.SharedDiskGiB, Value: 8},
+		payloads.RequestedResource{Type: ssd, Value: 8})
+	if res2.Allowed() {
+		t.Fatal("Expected to be denied")
+	}
+	qs.Release("test-tenant-1", res2.Resources()...)
+
+	// volumes of another class are not limited by the ssd quota
+	res3 := <-qs.Consume("test-tenant-1",
+		payloads.RequestedResource{Type: payloads.SharedDiskGiB, Value: 8},
+		payloads.RequestedResource{Type: payloads.StorageClassDiskGiB("hdd"), Value: 8})
+	if !res3.Allowed() {
+		t.Fatal("Expected to be allowed")
+	}
+
+	dumpedQuotas := qs.DumpQuotas("test-tenant-1")
+	testHasQuota(t, dumpedQuotas, types.QuotaDetails{Name: "tenant-storage-ssd-quota", Value: 10, Usage: 8})
+	testHasQuota(t, dumpedQuotas, types.QuotaDetails{Name: "tenant-storage-hdd-quota", Value: -1, Usage: 8})
+	testHasQuota(t, dumpedQuotas, types.QuotaDetails{Name: "tenant-storage-quota", Value: -1, Usage: 16})
+
+	qs.Shutdown()
+}
+
 func TestAllLimits(t *testing.T) {
 	qs := &Quotas{}
 	qs.Init()
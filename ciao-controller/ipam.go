@@ -0,0 +1,310 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// AuditIPAM cross checks the IP addresses allocated to each tenant, or to a
+// single tenant if tenantID is not empty, against the addresses used by the
+// tenant's instances and the subnets served by the tenant's CNCIs.  If repair
+// is true, the discrepancies that can be safely
+// fixed are repaired.  Conflicts and subnets without a CNCI are only
+// reported.
+//
+// Addresses are allocated before the instances that use them are stored, so
+// instances that are being created at the time of the audit may show up as
+// leaks.  Repairs should not be run while instances are being launched.
+func (c *controller) AuditIPAM(ctx context.Context, tenantID string, repair bool) (types.IPAMAudit, error) {
+	audit := types.IPAMAudit{
+		Issues: []types.IPAMIssue{},
+	}
+
+	var tenants []*types.Tenant
+	if tenantID != "" {
+		t, err := c.ds.GetTenant(ctx, tenantID)
+		if err != nil {
+			return audit, err
+		}
+		if t == nil {
+			return audit, types.ErrTenantNotFound
+		}
+		tenants = append(tenants, t)
+	} else {
+		var err error
+		tenants, err = c.ds.GetAllTenants()
+		if err != nil {
+			return audit, errors.Wrap(err, "error getting tenants")
+		}
+	}
+
+	sort.Slice(tenants, func(i, j int) bool {
+		return tenants[i].ID < tenants[j].ID
+	})
+
+	for _, t := range tenants {
+		issues, err := c.auditTenantIPs(ctx, t, repair)
+		if err != nil {
+			return audit, errors.Wrapf(err, "error auditing tenant %s", t.ID)
+		}
+		audit.Issues = append(audit.Issues, issues...)
+	}
+
+	return audit, nil
+}
+
+func tenantSubnet(t *types.Tenant, ip net.IP) string {
+	mask := net.CIDRMask(t.SubnetBits, 32)
+	ipNet := net.IPNet{
+		IP:   ip.Mask(mask),
+		Mask: mask,
+	}
+	return ipNet.String()
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *controller) repairIPAMIssue(ctx context.Context, issue *types.IPAMIssue, repairFn func() error) {
+	resource := issue.IPAddress
+	if resource == "" {
+		resource = issue.Subnet
+	}
+
+	err := repairFn()
+	if err != nil {
+		glog.Warningf("Unable to repair %s of %s: %v", issue.Type, resource, err)
+		issue.Error = err.Error()
+		return
+	}
+
+	issue.Repaired = true
+	msg := fmt.Sprintf("Repaired IPAM %s of %s", issue.Type, resource)
+	_ = c.ds.LogEvent(ctx, issue.TenantID, msg)
+}
+
+func (c *controller) auditTenantIPs(ctx context.Context, t *types.Tenant, repair bool) ([]types.IPAMIssue, error) {
+	var issues []types.IPAMIssue
+
+	IPs, err := c.ds.GetTenantIPs(t.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	allocated := make(map[string]bool)
+	allocatedSubnets := make(map[string]bool)
+	for _, ip := range IPs {
+		allocated[ip.String()] = true
+		allocatedSubnets[tenantSubnet(t, ip)] = true
+	}
+
+	reservations, err := c.ds.GetTenantIPReservations(t.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	reserved := make(map[string]bool)
+	for _, r := range reservations {
+		reserved[r.IPAddress] = true
+	}
+
+	instances, err := c.ds.GetAllInstancesFromTenant(t.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make(map[string][]string)
+	usedSubnets := make(map[string][]string)
+	for _, i := range instances {
+		ip := net.ParseIP(i.IPAddress)
+		if ip == nil {
+			continue
+		}
+		subnet := tenantSubnet(t, ip)
+		owners[i.IPAddress] = append(owners[i.IPAddress], i.ID)
+		usedSubnets[subnet] = append(usedSubnets[subnet], i.ID)
+	}
+
+	for _, ip := range sortedKeys(owners) {
+		IDs := owners[ip]
+		sort.Strings(IDs)
+
+		if len(IDs) > 1 {
+			issues = append(issues, types.IPAMIssue{
+				Type:        types.IPAMConflict,
+				TenantID:    t.ID,
+				IPAddress:   ip,
+				InstanceIDs: IDs,
+			})
+		}
+
+		if allocated[ip] {
+			continue
+		}
+
+		issue := types.IPAMIssue{
+			Type:        types.IPAMUnallocated,
+			TenantID:    t.ID,
+			IPAddress:   ip,
+			InstanceIDs: IDs,
+		}
+		if repair {
+			c.repairIPAMIssue(ctx, &issue, func() error {
+				return c.ds.ClaimTenantIP(ctx, t.ID, ip)
+			})
+		}
+		issues = append(issues, issue)
+	}
+
+	leaked := make([]string, 0, len(allocated))
+	for ip := range allocated {
+		if len(owners[ip]) == 0 && !reserved[ip] {
+			leaked = append(leaked, ip)
+		}
+	}
+	sort.Strings(leaked)
+
+	for _, ip := range leaked {
+		issue := types.IPAMIssue{
+			Type:      types.IPAMLeak,
+			TenantID:  t.ID,
+			IPAddress: ip,
+		}
+		if repair {
+			c.repairIPAMIssue(ctx, &issue, func() error {
+				return c.ds.ReleaseTenantIP(ctx, t.ID, ip)
+			})
+		}
+		issues = append(issues, issue)
+	}
+
+	if t.CNCIctrl == nil {
+		return issues, nil
+	}
+
+	cncis, err := c.ds.GetTenantCNCIs(t.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	cnciSubnets := make(map[string][]string)
+	for _, cnci := range cncis {
+		cnciSubnets[cnci.Subnet] = append(cnciSubnets[cnci.Subnet], cnci.ID)
+	}
+
+	for _, subnet := range sortedKeys(usedSubnets) {
+		if len(cnciSubnets[subnet]) > 0 {
+			continue
+		}
+
+		issues = append(issues, types.IPAMIssue{
+			Type:        types.IPAMMissingCNCI,
+			TenantID:    t.ID,
+			Subnet:      subnet,
+			InstanceIDs: usedSubnets[subnet],
+		})
+	}
+
+	// subnets holding reserved addresses keep their CNCI.
+	for _, subnet := range sortedKeys(cnciSubnets) {
+		if len(usedSubnets[subnet]) > 0 || allocatedSubnets[subnet] {
+			continue
+		}
+
+		issue := types.IPAMIssue{
+			Type:        types.IPAMIdleCNCI,
+			TenantID:    t.ID,
+			Subnet:      subnet,
+			InstanceIDs: cnciSubnets[subnet],
+		}
+		if repair {
+			c.repairIPAMIssue(ctx, &issue, func() error {
+				return t.CNCIctrl.ScheduleRemoveSubnet(subnet)
+			})
+		}
+		issues = append(issues, issue)
+	}
+
+	return issues, nil
+}
+
+func (c *controller) checkTenant(ctx context.Context, tenantID string) error {
+	t, err := c.ds.GetTenant(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return types.ErrTenantNotFound
+	}
+	return nil
+}
+
+// ListIPReservations returns the IP addresses reserved by a tenant.
+func (c *controller) ListIPReservations(ctx context.Context, tenantID string) ([]types.IPReservation, error) {
+	if err := c.checkTenant(ctx, tenantID); err != nil {
+		return nil, err
+	}
+
+	return c.ds.GetTenantIPReservations(tenantID)
+}
+
+// ReserveIP reserves an address in a tenant's network so that it can be
+// assigned to an instance at launch time.
+func (c *controller) ReserveIP(ctx context.Context, tenantID string, IP string) (types.IPReservation, error) {
+	if err := c.checkTenant(ctx, tenantID); err != nil {
+		return types.IPReservation{}, err
+	}
+
+	err := c.ds.ReserveTenantIP(ctx, tenantID, IP)
+	if err != nil {
+		return types.IPReservation{}, err
+	}
+
+	msg := fmt.Sprintf("Reserved IP address %s", IP)
+	_ = c.ds.LogEvent(ctx, tenantID, msg)
+
+	return types.IPReservation{IPAddress: IP}, nil
+}
+
+// ReleaseIPReservation deletes a tenant's reservation of an address.
+func (c *controller) ReleaseIPReservation(ctx context.Context, tenantID string, IP string) error {
+	if err := c.checkTenant(ctx, tenantID); err != nil {
+		return err
+	}
+
+	err := c.ds.DeleteTenantIPReservation(ctx, tenantID, IP)
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("Released IP address reservation %s", IP)
+	_ = c.ds.LogEvent(ctx, tenantID, msg)
+
+	return nil
+}
@@ -21,7 +21,6 @@ import (
 	"net/http"
 
 	"github.com/ciao-project/ciao/service"
-	"github.com/golang/glog"
 	"github.com/gorilla/mux"
 )
 
@@ -46,25 +45,7 @@ func (h legacyAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	resp, err := h.Handler(h.controller, w, r)
 	if err != nil {
-		data := HTTPErrorData{
-			Code:    resp.status,
-			Name:    http.StatusText(resp.status),
-			Message: err.Error(),
-		}
-
-		code := HTTPReturnErrorCode{
-			Error: data,
-		}
-
-		glog.Warningf("Returning error response to request: %s: %v", r.URL.String(), err)
-
-		b, err := json.Marshal(code)
-		if err != nil {
-			http.Error(w, http.StatusText(resp.status), resp.status)
-			return
-		}
-
-		http.Error(w, string(b), resp.status)
+		writeErrorResponse(w, r, resp.status, err)
 		return
 	}
 
@@ -111,6 +92,10 @@ func legacyListNodeServers(c *controller, w http.ResponseWriter, r *http.Request
 	return listNodeServers(c, w, r)
 }
 
+func legacyListTenantServerStats(c *controller, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	return listTenantServerStats(c, w, r)
+}
+
 func legacyListCNCIs(c *controller, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
 	return listCNCIs(c, w, r)
 }
@@ -139,6 +124,18 @@ func legacyTraceData(c *controller, w http.ResponseWriter, r *http.Request) (API
 	return traceData(c, w, r)
 }
 
+func legacyStartTrace(c *controller, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	return startTrace(c, w, r)
+}
+
+func legacyStopTrace(c *controller, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	return stopTrace(c, w, r)
+}
+
+func legacyDeleteTrace(c *controller, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	return deleteTrace(c, w, r)
+}
+
 func legacyComputeRoutes(ctl *controller, r *mux.Router) *mux.Router {
 	r.Handle("/v2.1/{tenant}/servers/action",
 		legacyAPIHandler{ctl, tenantServersAction, false}).Methods("POST")
@@ -149,6 +146,12 @@ func legacyComputeRoutes(ctl *controller, r *mux.Router) *mux.Router {
 	r.Handle("/v2.1/{tenant}/quotas",
 		legacyAPIHandler{ctl, listTenantQuotas, false}).Methods("GET")
 
+	r.Handle("/v2.1/{tenant}/servers/stats",
+		legacyAPIHandler{ctl, legacyListTenantServerStats, false}).Methods("GET")
+
+	r.Handle("/v2.1/{tenant}/servers/{server}/export",
+		instanceExportHandler{ctl}).Methods("GET")
+
 	r.Handle("/v2.1/nodes",
 		legacyAPIHandler{ctl, legacyListNodes, true}).Methods("GET")
 	r.Handle("/v2.1/nodes/{node}/servers/detail",
@@ -169,11 +172,21 @@ func legacyComputeRoutes(ctl *controller, r *mux.Router) *mux.Router {
 		legacyAPIHandler{ctl, legacyClearEvents, true}).Methods("DELETE")
 	r.Handle("/v2.1/{tenant}/events",
 		legacyAPIHandler{ctl, legacyListTenantEvents, false}).Methods("GET")
+	r.Handle("/v2.1/events/watch",
+		eventStreamHandler{ctl, true}).Methods("GET")
+	r.Handle("/v2.1/{tenant}/events/watch",
+		eventStreamHandler{ctl, false}).Methods("GET")
 
 	r.Handle("/v2.1/traces",
 		legacyAPIHandler{ctl, legacyListTraces, true}).Methods("GET")
+	r.Handle("/v2.1/traces/active",
+		legacyAPIHandler{ctl, legacyStartTrace, true}).Methods("PUT")
+	r.Handle("/v2.1/traces/active",
+		legacyAPIHandler{ctl, legacyStopTrace, true}).Methods("DELETE")
 	r.Handle("/v2.1/traces/{label}",
 		legacyAPIHandler{ctl, legacyTraceData, true}).Methods("GET")
+	r.Handle("/v2.1/traces/{label}",
+		legacyAPIHandler{ctl, legacyDeleteTrace, true}).Methods("DELETE")
 
 	return r
 }
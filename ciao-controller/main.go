@@ -18,6 +18,7 @@ package main
 
 import (
 	"context"
+	"crypto/cipher"
 	"crypto/tls"
 	"crypto/x509"
 	"flag"
@@ -28,33 +29,58 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/ciao-controller/internal/datastore"
 	"github.com/ciao-project/ciao/ciao-controller/internal/quotas"
 	storage "github.com/ciao-project/ciao/ciao-storage"
+	"github.com/ciao-project/ciao/client"
 	"github.com/ciao-project/ciao/clogger/gloginterface"
 	"github.com/ciao-project/ciao/database"
 	"github.com/ciao-project/ciao/osprepare"
+	"github.com/ciao-project/ciao/payloads"
 	"github.com/ciao-project/ciao/ssntp"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 )
 
-type tenantConfirmMemo struct {
-	ch  chan struct{}
-	err error
-}
-
 type controller struct {
 	storage.BlockDriver
 	client              controllerClient
 	ds                  *datastore.Datastore
 	apiURL              string
-	tenantReadiness     map[string]*tenantConfirmMemo
-	tenantReadinessLock sync.Mutex
 	qs                  *quotas.Quotas
 	httpServers         []*http.Server
+	gc                  imageGC
+	traceLabel          string
+	traceLock           sync.Mutex
+	secrets             cipher.AEAD
+	secretBackends      []secretBackend
+	dns                 dnsProvider
+	storageClasses      map[string]string
+	uniqueNames         bool
+	pendingNames        map[string]bool
+	pendingNamesLock    sync.Mutex
+	deletedRetention    time.Duration
+	peerClients         map[string]*client.Client
+	peerClientsLock     sync.Mutex
+	consoleSessions     map[string]*consoleSession
+	consoleSessionsLock sync.Mutex
+	guestAgentCalls     map[string]chan payloads.GuestAgentResultEvent
+	guestAgentLock      sync.Mutex
+	captureCalls        map[string]chan payloads.PacketCaptureResultEvent
+	captureLock         sync.Mutex
+	simpleWorkloadLock  sync.Mutex
+	archiveLock         sync.Mutex
+	migrations          map[string]*migration
+	migrationLock       sync.Mutex
+	httpConfig          httpServerConfig
+	config              clusterConfig
+	imagesDisabled      bool
+	signedRequestMaxAge time.Duration
+	clientCAs           *x509.CertPool
+	apiHandler          http.Handler
 }
 
 type cnciNetFlag string
@@ -83,6 +109,7 @@ var httpsCAcert = "/etc/pki/ciao/ciao-controller-cacert.pem"
 var httpsKey = "/etc/pki/ciao/ciao-controller-key.pem"
 var workloadsPath = flag.String("workloads_path", "/var/lib/ciao/data/controller/workloads", "path to yaml files")
 var persistentDatastoreLocation = flag.String("database_path", "/var/lib/ciao/data/controller/ciao-controller.db", "path to persistent database")
+var databaseTimeout = flag.Duration("database_timeout", 30*time.Second, "Time after which database operations are aborted, 0 for no timeout")
 var logDir = "/var/lib/ciao/logs/controller"
 
 var clientCertCAPath = "/etc/pki/ciao/auth-CA.pem"
@@ -157,13 +184,17 @@ func main() {
 	var err error
 
 	ctl := new(controller)
-	ctl.tenantReadiness = make(map[string]*tenantConfirmMemo)
+	ctl.pendingNames = make(map[string]bool)
+	ctl.uniqueNames = *uniqueNames
+	ctl.deletedRetention = *deletedRetention
+	ctl.signedRequestMaxAge = *signedRequestMaxAge
 	ctl.ds = new(datastore.Datastore)
 	ctl.qs = new(quotas.Quotas)
 
 	dsConfig := datastore.Config{
 		PersistentURI:     "file:" + *persistentDatastoreLocation,
 		InitWorkloadsPath: *workloadsPath,
+		QueryTimeout:      *databaseTimeout,
 	}
 
 	err = ctl.ds.Init(dsConfig)
@@ -172,8 +203,36 @@ func main() {
 		return
 	}
 
+	ctl.secrets, err = loadSecretsCipher(*secretsKeyPath)
+	if err != nil {
+		glog.Fatalf("Unable to load secrets key: %v", err)
+		return
+	}
+
+	ctl.dns, err = newDNSProvider(*dnsProviderName)
+	if err != nil {
+		glog.Fatalf("Unable to configure DNS provider: %v", err)
+		return
+	}
+	if ctl.dns != nil && *dnsZone == "" {
+		glog.Fatalf("A DNS zone is required by the %s DNS provider", ctl.dns)
+		return
+	}
+
+	if *vaultAddr != "" {
+		vault, err := newVaultSecrets(*vaultAddr, *vaultCACert, *vaultMount, *vaultRoleID, *vaultSecretIDFile)
+		if err != nil {
+			glog.Fatalf("Unable to configure Vault secret backend: %v", err)
+			return
+		}
+		ctl.secretBackends = append(ctl.secretBackends, vault)
+		glog.Infof("Resolving secrets from %s", vault)
+	}
+
+	ctx := context.Background()
+
 	ctl.qs.Init()
-	err = populateQuotasFromDatastore(ctl.qs, ctl.ds)
+	err = populateQuotasFromDatastore(ctx, ctl.qs, ctl.ds)
 	if err != nil {
 		glog.Fatalf("Error populating quotas from datastore: %v", err)
 		return
@@ -207,12 +266,22 @@ func main() {
 		*cephID = clusterConfig.Configure.Storage.CephID
 	}
 
+	ctl.storageClasses = make(map[string]string)
+	for _, class := range clusterConfig.Configure.Storage.Classes {
+		ctl.storageClasses[class.Name] = class.Pool
+	}
+
 	cnciVCPUs := clusterConfig.Configure.Controller.CNCIVcpus
 	cnciMem := clusterConfig.Configure.Controller.CNCIMem
 	cnciDisk := clusterConfig.Configure.Controller.CNCIDisk
 
 	adminSSHKey = clusterConfig.Configure.Controller.AdminSSHKey
 
+	ctl.imagesDisabled = clusterConfig.Configure.Controller.DisableImages
+	if ctl.imagesDisabled {
+		glog.Info("Image service disabled")
+	}
+
 	if clusterConfig.Configure.Controller.ClientAuthCACertPath != "" {
 		clientCertCAPath = clusterConfig.Configure.Controller.ClientAuthCACertPath
 	}
@@ -225,7 +294,14 @@ func main() {
 		}
 	}
 
+	ctl.httpConfig, err = newHTTPServerConfig(clusterConfig.Configure.Controller)
+	if err != nil {
+		glog.Fatalf("Invalid HTTP server cluster configuration: %v", err)
+		return
+	}
+
 	ctl.ds.GenerateCNCIWorkload(cnciVCPUs, cnciMem, cnciDisk, adminSSHKey)
+	ctl.config.set(clusterConfig, time.Now())
 
 	database.Logger = gloginterface.CiaoGlogLogger{}
 
@@ -236,7 +312,7 @@ func main() {
 		return driver
 	}()
 
-	err = initializeCNCICtrls(ctl)
+	err = initializeCNCICtrls(ctx, ctl)
 	if err != nil {
 		glog.Fatal("Unable to initialize CNCI controllers: ", err)
 		return
@@ -256,11 +332,46 @@ func main() {
 	}
 	ctl.httpServers = append(ctl.httpServers, server)
 
+	autoscaleStop := make(chan struct{})
+	go ctl.autoscaler(autoscaleStop)
+
+	sweeperStop := make(chan struct{})
+	go ctl.instanceSweeper(sweeperStop)
+
+	// image data is left alone when the images are pre-provisioned.
+	imageGCStop := make(chan struct{})
+	if !ctl.imagesDisabled {
+		go ctl.imageCollector(imageGCStop)
+	}
+
+	purgerStop := make(chan struct{})
+	go ctl.deletedPurger(purgerStop)
+
+	peerPollerStop := make(chan struct{})
+	go ctl.peerPoller(peerPollerStop)
+
+	eventForwarderStop := make(chan struct{})
+	if sinks := newEventSinks(clusterConfig.Configure.Controller.EventSinks); len(sinks) > 0 {
+		go ctl.eventForwarder(sinks, eventForwarderStop)
+	}
+
+	configStop := make(chan struct{})
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go ctl.configWatcher(configStop, hupCh)
+
 	signalCh := make(chan os.Signal, 1)
 	signal.Notify(signalCh, syscall.SIGTERM, syscall.SIGINT)
 	go func() {
 		s := <-signalCh
 		glog.Warningf("Received signal: %s", s)
+		close(autoscaleStop)
+		close(sweeperStop)
+		close(imageGCStop)
+		close(purgerStop)
+		close(peerPollerStop)
+		close(eventForwarderStop)
+		close(configStop)
 		ctl.ShutdownHTTPServers()
 		shutdownCNCICtrls(ctl)
 	}()
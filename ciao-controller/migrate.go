@@ -0,0 +1,229 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// A live migration goes through the following steps:
+//
+// 1. The controller sends a START command with the migration source set.
+//    The scheduler places the instance on another node, whose launcher
+//    starts qemu waiting for the state of the instance.
+// 2. The target launcher reports the address it waits on with an incoming
+//    MigrationStatus event.
+// 3. The controller sends a MIGRATE command to the source launcher, which
+//    streams the instance to the target.
+// 4. The source launcher reports the migration completed, or failed, and
+//    the controller moves the instance to the target node, or leaves it on
+//    the source node.
+
+// migration tracks a live migration in progress.
+type migration struct {
+	op     types.Operation
+	source string
+	target string
+}
+
+// instanceMigrating returns true if an instance is being live migrated.
+func (c *controller) instanceMigrating(instanceID string) bool {
+	c.migrationLock.Lock()
+	_, migrating := c.migrations[instanceID]
+	c.migrationLock.Unlock()
+
+	return migrating
+}
+
+// checkInstanceNotMigrating fails if an instance is being live migrated.
+func (c *controller) checkInstanceNotMigrating(instanceID string) error {
+	if c.instanceMigrating(instanceID) {
+		return types.ErrInstanceMigrating
+	}
+
+	return nil
+}
+
+// MigrateServer live migrates a running instance to another compute node.
+// The scheduler picks the target node if nodeID is empty.  The migration
+// runs in the background and is tracked by the operation returned.
+func (c *controller) MigrateServer(ctx context.Context, tenant string, ID string, nodeID string) (types.Operation, error) {
+	i, err := c.ds.GetTenantInstance(tenant, ID)
+	if err != nil {
+		return types.Operation{}, err
+	}
+
+	if i.IsDeleted() {
+		return types.Operation{}, types.ErrInstanceNotFound
+	}
+
+	i.StateLock.RLock()
+	state := i.State
+	source := i.NodeID
+	peerID := i.PeerID
+	i.StateLock.RUnlock()
+
+	if i.CNCI || peerID != "" {
+		return types.Operation{}, types.ErrMigrateNotSupported
+	}
+
+	if state == payloads.Migrating {
+		return types.Operation{}, types.ErrInstanceMigrating
+	}
+
+	if state != payloads.Running || source == "" {
+		return types.Operation{}, types.ErrInstanceNotRunning
+	}
+
+	if nodeID == source {
+		return types.Operation{}, types.ErrMigrateSameNode
+	}
+
+	if err := c.checkInstanceNotArchived(ID); err != nil {
+		return types.Operation{}, err
+	}
+
+	w, err := c.instanceWorkload(i)
+	if err != nil {
+		return types.Operation{}, err
+	}
+
+	if w.VMType != payloads.QEMU {
+		return types.Operation{}, types.ErrMigrateNotSupported
+	}
+
+	t, err := c.ds.GetTenant(ctx, i.TenantID)
+	if err != nil {
+		return types.Operation{}, err
+	}
+
+	c.migrationLock.Lock()
+	defer c.migrationLock.Unlock()
+
+	if _, ok := c.migrations[ID]; ok {
+		return types.Operation{}, types.ErrInstanceMigrating
+	}
+
+	err = c.ds.InstanceMigrating(ctx, ID)
+	if err != nil {
+		return types.Operation{}, err
+	}
+
+	m := &migration{
+		op:     c.newOperation(tenant, types.InstanceMigrate, ID),
+		source: source,
+		target: nodeID,
+	}
+
+	if c.migrations == nil {
+		c.migrations = make(map[string]*migration)
+	}
+	c.migrations[ID] = m
+
+	c.ds.SetInstanceActionCause(ID, types.InitiatorUser, types.ReasonAPIRequest)
+
+	go func() {
+		if err := c.client.MigrateInstance(i, &w, t, source, nodeID); err != nil {
+			c.endMigration(context.Background(), ID, errors.Wrap(err, "Error starting migration"))
+		}
+	}()
+
+	return m.op, nil
+}
+
+// migrationStatus handles the MigrationStatus events sent by the launchers
+// taking part in a live migration.
+func (c *controller) migrationStatus(ctx context.Context, event payloads.MigrationStatusEvent) {
+	c.migrationLock.Lock()
+	m, ok := c.migrations[event.InstanceUUID]
+	if ok && event.State == payloads.MigrationIncoming {
+		m.target = event.NodeUUID
+	}
+	c.migrationLock.Unlock()
+
+	if !ok {
+		glog.Warningf("Migration status %s received for instance %s which is not migrating",
+			event.State, event.InstanceUUID)
+		return
+	}
+
+	switch event.State {
+	case payloads.MigrationIncoming:
+		cmd := payloads.MigrateCmd{
+			InstanceUUID:      event.InstanceUUID,
+			WorkloadAgentUUID: m.source,
+			TargetAgentUUID:   event.NodeUUID,
+			TargetAddress:     event.Address,
+		}
+
+		err := c.client.MigrateCommand(cmd)
+		if err != nil {
+			c.endMigration(ctx, event.InstanceUUID, errors.Wrap(err, "Error sending migrate command"))
+		}
+	case payloads.MigrationCompleted:
+		c.endMigration(ctx, event.InstanceUUID, nil)
+	case payloads.MigrationFailed:
+		c.endMigration(ctx, event.InstanceUUID, errors.New(event.Error))
+	}
+}
+
+// endMigration records the end of the live migration of an instance.  The
+// instance runs on its target node if err is nil, and is left on its source
+// node otherwise.  Instances which are not migrating are ignored as both
+// nodes may report a failed migration.
+func (c *controller) endMigration(ctx context.Context, instanceID string, err error) {
+	c.migrationLock.Lock()
+	m, ok := c.migrations[instanceID]
+	delete(c.migrations, instanceID)
+	c.migrationLock.Unlock()
+
+	if !ok {
+		return
+	}
+
+	nodeID := m.target
+	if err != nil {
+		nodeID = m.source
+	}
+
+	i, ierr := c.ds.GetInstance(instanceID)
+	if ierr != nil {
+		glog.Warningf("Error getting migrated instance: %v", ierr)
+		c.completeOperation(&m.op, err)
+		return
+	}
+
+	if ierr = c.ds.InstanceMigrated(ctx, instanceID, nodeID); ierr != nil {
+		glog.Warningf("Error updating migrated instance: %v", ierr)
+	}
+
+	c.completeOperation(&m.op, err)
+
+	if err != nil {
+		glog.Errorf("Migration of instance %s from %s failed: %v", instanceID, m.source, err)
+		msg := fmt.Sprintf("Failed to migrate instance %s: %v", instanceID, err)
+		_ = c.ds.LogError(ctx, i.TenantID, msg)
+		return
+	}
+
+	msg := fmt.Sprintf("Migrated instance %s from %s to %s", instanceID, m.source, nodeID)
+	_ = c.ds.LogEvent(ctx, i.TenantID, msg)
+}
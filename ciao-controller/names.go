@@ -0,0 +1,178 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+)
+
+var uniqueNames = flag.Bool("unique_names", false, "Reject instances and volumes named after another instance or volume of the same tenant")
+
+const (
+	instanceResource = "instance"
+	volumeResource   = "volume"
+)
+
+func pendingNameKey(resource string, tenantID string, name string) string {
+	return resource + "/" + tenantID + "/" + name
+}
+
+// usedName returns the ID of the instance or volume of a tenant holding
+// name, or an empty string if no such resource exists.
+func (c *controller) usedName(resource string, tenantID string, name string) (string, error) {
+	if resource == instanceResource {
+		instances, err := c.ds.GetAllInstancesFromTenant(tenantID)
+		if err != nil {
+			return "", err
+		}
+
+		for _, i := range instances {
+			if i.Name == name {
+				return i.ID, nil
+			}
+		}
+
+		return "", nil
+	}
+
+	vols, err := c.ds.GetBlockDevices(tenantID)
+	if err != nil {
+		return "", err
+	}
+
+	for _, vol := range vols {
+		if vol.Name == name {
+			return vol.ID, nil
+		}
+	}
+
+	return "", nil
+}
+
+// reserveNames checks that the names of the instances or volumes about to
+// be created are not used by any other instance or volume of the tenant,
+// including the ones still being created, when the controller enforces
+// unique names.  The names are held until the returned function is called,
+// which must happen once the resources are in the datastore or have failed
+// to be created.  Unnamed resources are never in conflict.
+func (c *controller) reserveNames(resource string, tenantID string, names ...string) (func(), error) {
+	var keys []string
+	release := func() {
+		c.pendingNamesLock.Lock()
+		for _, key := range keys {
+			delete(c.pendingNames, key)
+		}
+		c.pendingNamesLock.Unlock()
+	}
+
+	if !c.uniqueNames {
+		return release, nil
+	}
+
+	c.pendingNamesLock.Lock()
+	defer c.pendingNamesLock.Unlock()
+
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+
+		key := pendingNameKey(resource, tenantID, name)
+		if c.pendingNames[key] {
+			return nil, types.NameConflictError{Resource: resource, Name: name}
+		}
+
+		ID, err := c.usedName(resource, tenantID, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if ID != "" {
+			return nil, types.NameConflictError{Resource: resource, Name: name, ID: ID}
+		}
+	}
+
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+
+		key := pendingNameKey(resource, tenantID, name)
+		c.pendingNames[key] = true
+		keys = append(keys, key)
+	}
+
+	return release, nil
+}
+
+// ShowVolumeByName returns the volume of a tenant with the given name.
+func (c *controller) ShowVolumeByName(ctx context.Context, tenant string, name string) (types.Volume, error) {
+	if err := c.checkTenant(ctx, tenant); err != nil {
+		return types.Volume{}, err
+	}
+
+	vols, err := c.ListVolumesDetail(ctx, tenant)
+	if err != nil {
+		return types.Volume{}, err
+	}
+
+	var found []types.Volume
+	for _, vol := range vols {
+		if vol.Name == name {
+			found = append(found, vol)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return types.Volume{}, types.ErrVolumeNotFound
+	case 1:
+		return found[0], nil
+	default:
+		return types.Volume{}, types.ErrAmbiguousName
+	}
+}
+
+// ShowServerByName returns the instance of a tenant with the given name.
+func (c *controller) ShowServerByName(ctx context.Context, tenant string, name string) (api.Server, error) {
+	if err := c.checkTenant(ctx, tenant); err != nil {
+		return api.Server{}, err
+	}
+
+	instances, err := c.ds.GetAllInstancesFromTenant(tenant)
+	if err != nil {
+		return api.Server{}, err
+	}
+
+	var found []*types.Instance
+	for _, i := range instances {
+		if i.Name == name && !i.IsDeleted() {
+			found = append(found, i)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return api.Server{}, types.ErrInstanceNotFound
+	case 1:
+		return c.ShowServerDetails(ctx, tenant, found[0].ID)
+	default:
+		return api.Server{}, types.ErrAmbiguousName
+	}
+}
@@ -14,19 +14,72 @@
 
 package main
 
-import "github.com/golang/glog"
+import (
+	"context"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+)
+
+func (c *controller) EvacuateNode(ctx context.Context, nodeID string) (types.Operation, error) {
+	op := c.newOperation("", types.NodeEvacuate, nodeID)
+
+	instances, err := c.ds.GetAllInstancesByNode(nodeID)
+	if err != nil {
+		glog.Warningf("Error getting instances of node %s: %v", nodeID, err)
+	}
+
+	// the instances are reported as stopped once evacuated
+	for _, i := range instances {
+		c.ds.SetInstanceActionCause(i.ID, types.InitiatorSystem, types.ReasonEvacuation)
+	}
 
-func (c *controller) EvacuateNode(nodeID string) error {
 	// should I bother to see if nodeID is valid?
 	go func() {
-		if err := c.client.EvacuateNode(nodeID); err != nil {
+		err := c.client.EvacuateNode(nodeID)
+		if err != nil {
 			glog.Warningf("Error evacuating node")
+			c.completeOperation(&op, err)
+			return
 		}
+
+		c.completeNodeEvacuation(nodeID)
 	}()
-	return nil
+	return op, nil
+}
+
+// completeNodeEvacuation completes the evacuation operations of a node
+// once it is drained, i.e., once its last instance has been reported
+// stopped, or has been migrated or deleted.
+func (c *controller) completeNodeEvacuation(nodeID string) {
+	evacuating := false
+	for _, op := range c.ds.GetResourceOperations(nodeID, types.NodeEvacuate) {
+		evacuating = evacuating || op.State == types.OperationRunning
+	}
+	if !evacuating {
+		return
+	}
+
+	instances, err := c.ds.GetAllInstancesByNode(nodeID)
+	if err != nil {
+		glog.Warningf("Error getting instances of node %s: %v", nodeID, err)
+		return
+	}
+
+	for _, i := range instances {
+		i.StateLock.RLock()
+		state := i.State
+		i.StateLock.RUnlock()
+
+		if state == payloads.Running {
+			return
+		}
+	}
+
+	c.completeResourceOperations(nodeID, types.NodeEvacuate, nil)
 }
 
-func (c *controller) RestoreNode(nodeID string) error {
+func (c *controller) RestoreNode(ctx context.Context, nodeID string) error {
 	go func() {
 		if err := c.client.RestoreNode(nodeID); err != nil {
 			glog.Warning("Error restoring node")
@@ -34,3 +87,93 @@ func (c *controller) RestoreNode(nodeID string) error {
 	}()
 	return nil
 }
+
+// sendNodePolicies sends the scheduling policies of all nodes to the
+// scheduler, which does not persist them.
+func (c *controller) sendNodePolicies(ctx context.Context) error {
+	policies, err := c.ds.GetNodePolicies(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.client.SendNodePolicies(policies)
+}
+
+// ListNodePolicies returns the scheduling policies set for nodes.
+func (c *controller) ListNodePolicies(ctx context.Context) ([]types.NodePolicy, error) {
+	return c.ds.GetNodePolicies(ctx)
+}
+
+// UpdateNodePolicy sets the scheduling weight and instance limit of a node,
+// whether it may run tenant workloads next to the CNCI of their tenant and
+// the tenant it is dedicated to, if any.
+func (c *controller) UpdateNodePolicy(ctx context.Context, policy types.NodePolicy) error {
+	if policy.Weight < 0 || policy.Weight > payloads.MaxNodeWeight || policy.MaxInstances < 0 {
+		return types.ErrBadRequest
+	}
+
+	if policy.DedicatedTenant != "" {
+		tenant, err := c.ds.GetTenant(ctx, policy.DedicatedTenant)
+		if err != nil {
+			return err
+		}
+
+		if tenant == nil {
+			return types.ErrTenantNotFound
+		}
+	}
+
+	err := c.ds.UpdateNodePolicy(ctx, policy)
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("Node %s policy set to weight %d, max instances %d, CNCI co-location %t, dedicated tenant %q",
+		policy.NodeID, policy.Weight, policy.MaxInstances, policy.AllowCNCIColocation, policy.DedicatedTenant)
+
+	return c.sendNodePolicies(ctx)
+}
+
+// DeleteNodePolicy resets the scheduling policy of a node to the default
+// weight and no instance limit.
+func (c *controller) DeleteNodePolicy(ctx context.Context, nodeID string) error {
+	err := c.ds.DeleteNodePolicy(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("Node %s policy reset", nodeID)
+
+	return c.sendNodePolicies(ctx)
+}
+
+// releaseDedicatedNodes shares the nodes dedicated to a tenant with all the
+// tenants again.
+func (c *controller) releaseDedicatedNodes(ctx context.Context, tenantID string) error {
+	policies, err := c.ds.GetNodePolicies(ctx)
+	if err != nil {
+		return err
+	}
+
+	released := false
+	for _, policy := range policies {
+		if policy.DedicatedTenant != tenantID {
+			continue
+		}
+
+		policy.DedicatedTenant = ""
+		err = c.ds.UpdateNodePolicy(ctx, policy)
+		if err != nil {
+			return err
+		}
+		released = true
+
+		glog.Infof("Node %s no longer dedicated to tenant %s", policy.NodeID, tenantID)
+	}
+
+	if !released {
+		return nil
+	}
+
+	return c.sendNodePolicies(ctx)
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/golang/glog"
+)
+
+// newOperation creates and stores a running operation tracking an
+// asynchronous action on a resource.
+func (c *controller) newOperation(tenantID string, opType types.OperationType, resourceID string) types.Operation {
+	now := time.Now()
+
+	op := types.Operation{
+		ID:         uuid.Generate().String(),
+		TenantID:   tenantID,
+		Type:       opType,
+		ResourceID: resourceID,
+		State:      types.OperationRunning,
+		CreateTime: now,
+		UpdateTime: now,
+	}
+
+	err := c.ds.AddOperation(op)
+	if err != nil {
+		glog.Errorf("Error adding %s operation for %s: %v", opType, resourceID, err)
+	}
+
+	return op
+}
+
+// updateOperation records the progress of a running operation.
+func (c *controller) updateOperation(op *types.Operation, progress int) {
+	op.Progress = progress
+	op.UpdateTime = time.Now()
+
+	err := c.ds.UpdateOperation(*op)
+	if err != nil {
+		glog.Errorf("Error updating operation %s: %v", op.ID, err)
+	}
+}
+
+// completeOperation marks an operation as succeeded, or as failed if
+// err is not nil.
+func (c *controller) completeOperation(op *types.Operation, err error) {
+	if err != nil {
+		op.State = types.OperationFailed
+		op.Error = err.Error()
+	} else {
+		op.State = types.OperationSucceeded
+		op.Progress = 100
+	}
+	op.UpdateTime = time.Now()
+
+	uerr := c.ds.UpdateOperation(*op)
+	if uerr != nil {
+		glog.Errorf("Error completing operation %s: %v", op.ID, uerr)
+	}
+}
+
+// completeResourceOperations completes all the running operations of a
+// given type for a resource. This is used when completion is signalled
+// asynchronously by the cluster, e.g. through SSNTP.
+func (c *controller) completeResourceOperations(resourceID string, opType types.OperationType, err error) {
+	for _, op := range c.ds.GetResourceOperations(resourceID, opType) {
+		if op.State != types.OperationRunning {
+			continue
+		}
+
+		c.completeOperation(&op, err)
+	}
+}
+
+// resourceOperationID returns the ID of the last operation of a given type
+// started on a resource, or an empty string if there is none.
+func (c *controller) resourceOperationID(resourceID string, opType types.OperationType) string {
+	ops := c.ds.GetResourceOperations(resourceID, opType)
+	if len(ops) == 0 {
+		return ""
+	}
+
+	return ops[len(ops)-1].ID
+}
+
+// ListOperations returns the operations of a tenant, or of all tenants
+// for the admin.
+func (c *controller) ListOperations(ctx context.Context, tenantID string) ([]types.Operation, error) {
+	if tenantID == "admin" {
+		return c.ds.GetOperations(""), nil
+	}
+
+	return c.ds.GetOperations(tenantID), nil
+}
+
+// ShowOperation returns an operation after checking permissions.
+func (c *controller) ShowOperation(ctx context.Context, tenantID string, operationID string) (types.Operation, error) {
+	op, err := c.ds.GetOperation(operationID)
+	if err != nil {
+		return types.Operation{}, err
+	}
+
+	if tenantID != "admin" && op.TenantID != tenantID {
+		return types.Operation{}, types.ErrOperationNotFound
+	}
+
+	return op, nil
+}
@@ -15,6 +15,7 @@
 package main
 
 import (
+	"context"
 	"github.com/ciao-project/ciao/ciao-controller/internal/datastore"
 	"github.com/ciao-project/ciao/ciao-controller/internal/quotas"
 	"github.com/ciao-project/ciao/ciao-controller/types"
@@ -22,8 +23,8 @@ import (
 	"github.com/pkg/errors"
 )
 
-func (c *controller) UpdateQuotas(tenantID string, qds []types.QuotaDetails) error {
-	err := c.ds.UpdateQuotas(tenantID, qds)
+func (c *controller) UpdateQuotas(ctx context.Context, tenantID string, qds []types.QuotaDetails) error {
+	err := c.ds.UpdateQuotas(ctx, tenantID, qds)
 	if err != nil {
 		return errors.Wrap(err, "error updating quotas in database")
 	}
@@ -31,11 +32,11 @@ func (c *controller) UpdateQuotas(tenantID string, qds []types.QuotaDetails) err
 	return nil
 }
 
-func (c *controller) ListQuotas(tenantID string) []types.QuotaDetails {
+func (c *controller) ListQuotas(ctx context.Context, tenantID string) []types.QuotaDetails {
 	return c.qs.DumpQuotas(tenantID)
 }
 
-func populateQuotasFromDatastore(qs *quotas.Quotas, ds *datastore.Datastore) error {
+func populateQuotasFromDatastore(ctx context.Context, qs *quotas.Quotas, ds *datastore.Datastore) error {
 	ts, err := ds.GetAllTenants()
 	if err != nil {
 		return errors.Wrap(err, "error getting tenants")
@@ -43,7 +44,7 @@ func populateQuotasFromDatastore(qs *quotas.Quotas, ds *datastore.Datastore) err
 
 	for _, t := range ts {
 		// Populate quotas/limits from datastore
-		qds, err := ds.GetQuotas(t.ID)
+		qds, err := ds.GetQuotas(ctx, t.ID)
 		if err != nil {
 			return errors.Wrapf(err, "error getting quotas for tenant %s", t.ID)
 		}
@@ -56,18 +57,17 @@ func populateQuotasFromDatastore(qs *quotas.Quotas, ds *datastore.Datastore) err
 		if err != nil {
 			return errors.Wrapf(err, "error getting block devices for tenant %s", t.ID)
 		}
-		var size, count int
+		var resources []payloads.RequestedResource
 		for _, bd := range bds {
-			if bd.Internal {
+			// volumes which creation failed do not consume
+			// any quota.
+			if bd.Internal || bd.State == types.VolumeError {
 				continue
 			}
-			size += bd.Size
-			count++
+			resources = append(resources, volumeResources(bd)...)
 		}
 		// With initial population we disregard the result of consumption
-		<-qs.Consume(t.ID,
-			payloads.RequestedResource{Type: payloads.Volume, Value: count},
-			payloads.RequestedResource{Type: payloads.SharedDiskGiB, Value: size})
+		<-qs.Consume(t.ID, resources...)
 
 		instances, err := ds.GetAllInstancesFromTenant(t.ID)
 		if err != nil {
@@ -79,10 +79,7 @@ func populateQuotasFromDatastore(qs *quotas.Quotas, ds *datastore.Datastore) err
 			if err != nil {
 				return errors.Wrapf(err, "error getting workload")
 			}
-			resources := []payloads.RequestedResource{
-				{Type: payloads.Instance, Value: 1},
-				{Type: payloads.MemMB, Value: wl.Requirements.MemMB},
-				{Type: payloads.VCPUs, Value: wl.Requirements.VCPUs}}
+			resources := instanceResources(&wl, instance.Preemptible)
 			<-qs.Consume(t.ID, resources...)
 		}
 	}
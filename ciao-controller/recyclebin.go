@@ -0,0 +1,303 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+)
+
+var deletedRetention = flag.Duration("deleted_retention", 0, "Time deleted instances and volumes can be restored before they are purged, 0 deletes them immediately")
+var purgeInterval = flag.Duration("deleted_purge_interval", time.Minute, "Interval between purges of the instances and volumes whose retention time has elapsed")
+
+// softDeleteInstance moves an instance to the recycle bin.  The instance is
+// stopped but keeps its resources, so that it can be restored, until it is
+// purged.  Instances which never started have nothing worth restoring and
+// are deleted right away.
+func (c *controller) softDeleteInstance(ctx context.Context, i *types.Instance) error {
+	i.StateLock.RLock()
+	state := i.State
+	nodeID := i.NodeID
+	i.StateLock.RUnlock()
+
+	if state == payloads.Pending {
+		return c.deleteInstance(ctx, i.ID)
+	}
+
+	if state == payloads.Missing {
+		return types.ErrInstanceNotAssigned
+	}
+
+	// mapped instances would remain reachable from the outside.
+	IPs := c.ds.GetMappedIPs(&i.TenantID)
+	for _, m := range IPs {
+		if m.InstanceID == i.ID {
+			return types.ErrInstanceMapped
+		}
+	}
+
+	if state == payloads.Running && nodeID != "" {
+		err := c.stopInstance(ctx, i.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := c.ds.SetInstanceDeleteTime(ctx, i.ID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("Moved instance %s to the recycle bin", i.ID)
+	_ = c.ds.LogEvent(ctx, i.TenantID, msg)
+
+	return nil
+}
+
+// softDeleteVolume moves an available volume to the recycle bin.
+func (c *controller) softDeleteVolume(ctx context.Context, vol types.Volume) error {
+	vol.State = types.Deleted
+	vol.DeleteTime = time.Now()
+
+	err := c.ds.UpdateBlockDevice(ctx, vol)
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("Moved volume %s to the recycle bin", vol.ID)
+	_ = c.ds.LogEvent(ctx, vol.TenantID, msg)
+
+	return nil
+}
+
+// deletedInstance returns the instance of a tenant in the recycle bin.
+func (c *controller) deletedInstance(tenant string, ID string) (*types.Instance, bool) {
+	i, err := c.ds.GetInstance(ID)
+	if err != nil || i.TenantID != tenant || i.CNCI || !i.IsDeleted() {
+		return nil, false
+	}
+
+	return i, true
+}
+
+// deletedVolume returns the volume of a tenant in the recycle bin.
+func (c *controller) deletedVolume(tenant string, ID string) (types.Volume, bool) {
+	vol, err := c.ds.GetBlockDevice(ID)
+	if err != nil || vol.TenantID != tenant || vol.State != types.Deleted {
+		return types.Volume{}, false
+	}
+
+	return vol, true
+}
+
+// ListDeletedResources returns the instances and volumes of a tenant which
+// are in the recycle bin, oldest deletion first.
+func (c *controller) ListDeletedResources(ctx context.Context, tenant string) ([]types.DeletedResource, error) {
+	if err := c.checkTenant(ctx, tenant); err != nil {
+		return nil, err
+	}
+
+	resources := []types.DeletedResource{}
+
+	instances, err := c.ds.GetAllInstancesFromTenant(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range instances {
+		i.StateLock.RLock()
+		deleteTime := i.DeleteTime
+		i.StateLock.RUnlock()
+
+		if deleteTime.IsZero() {
+			continue
+		}
+
+		resources = append(resources, types.DeletedResource{
+			ID:         i.ID,
+			Type:       types.DeletedInstance,
+			Name:       i.Name,
+			DeleteTime: deleteTime,
+			PurgeTime:  deleteTime.Add(c.deletedRetention),
+		})
+	}
+
+	vols, err := c.ds.GetBlockDevices(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, vol := range vols {
+		if vol.State != types.Deleted {
+			continue
+		}
+
+		resources = append(resources, types.DeletedResource{
+			ID:         vol.ID,
+			Type:       types.DeletedVolume,
+			Name:       vol.Name,
+			DeleteTime: vol.DeleteTime,
+			PurgeTime:  vol.DeleteTime.Add(c.deletedRetention),
+		})
+	}
+
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].DeleteTime.Before(resources[j].DeleteTime)
+	})
+
+	return resources, nil
+}
+
+// RestoreDeletedResource takes an instance or a volume out of the recycle
+// bin.  Restored instances are left stopped.
+func (c *controller) RestoreDeletedResource(ctx context.Context, tenant string, ID string) error {
+	if i, ok := c.deletedInstance(tenant, ID); ok {
+		err := c.ds.SetInstanceDeleteTime(ctx, i.ID, time.Time{})
+		if err != nil {
+			return err
+		}
+
+		msg := fmt.Sprintf("Restored instance %s from the recycle bin", i.ID)
+		_ = c.ds.LogEvent(ctx, tenant, msg)
+		return nil
+	}
+
+	if vol, ok := c.deletedVolume(tenant, ID); ok {
+		vol.State = types.Available
+		vol.DeleteTime = time.Time{}
+
+		err := c.ds.UpdateBlockDevice(ctx, vol)
+		if err != nil {
+			return err
+		}
+
+		msg := fmt.Sprintf("Restored volume %s from the recycle bin", vol.ID)
+		_ = c.ds.LogEvent(ctx, tenant, msg)
+		return nil
+	}
+
+	return types.ErrNotInRecycleBin
+}
+
+// PurgeDeletedResource permanently deletes an instance or a volume in the
+// recycle bin without waiting for its retention time to elapse.
+func (c *controller) PurgeDeletedResource(ctx context.Context, tenant string, ID string) error {
+	if i, ok := c.deletedInstance(tenant, ID); ok {
+		return c.deleteInstance(ctx, i.ID)
+	}
+
+	if vol, ok := c.deletedVolume(tenant, ID); ok {
+		return c.purgeVolume(ctx, vol)
+	}
+
+	return types.ErrNotInRecycleBin
+}
+
+// purgeVolume permanently deletes a volume and releases its quota.
+func (c *controller) purgeVolume(ctx context.Context, vol types.Volume) error {
+	// remove the block data from our datastore.
+	err := c.ds.DeleteBlockDevice(ctx, vol.ID)
+	if err != nil {
+		return err
+	}
+
+	// tell the underlying storage media to remove.
+	err = c.deleteVolumeBlockDevice(ctx, vol)
+	if err != nil {
+		return err
+	}
+
+	// release quota associated with this volume
+	c.qs.Release(vol.TenantID, volumeResources(vol)...)
+
+	return nil
+}
+
+func (c *controller) deletedPurger(stop <-chan struct{}) {
+	ctx := context.Background()
+
+	if c.deletedRetention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(*purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.purgeDeleted(ctx, time.Now())
+		}
+	}
+}
+
+// purgeDeleted permanently deletes the instances and volumes which have
+// been in the recycle bin for longer than the retention time.  Instances
+// are removed from the recycle bin once the launcher reports them deleted,
+// so the delete is sent again at each pass until it succeeds.
+func (c *controller) purgeDeleted(ctx context.Context, now time.Time) {
+	instances, err := c.ds.GetAllInstances()
+	if err != nil {
+		glog.Warningf("Error getting instances to purge: %v", err)
+		return
+	}
+
+	for _, i := range instances {
+		i.StateLock.RLock()
+		deleteTime := i.DeleteTime
+		i.StateLock.RUnlock()
+
+		if deleteTime.IsZero() || now.Sub(deleteTime) < c.deletedRetention {
+			continue
+		}
+
+		if err := c.deleteInstance(ctx, i.ID); err != nil {
+			glog.Warningf("Error purging instance %s: %v", i.ID, err)
+		}
+	}
+
+	tenants, err := c.ds.GetAllTenants()
+	if err != nil {
+		glog.Warningf("Error getting tenants to purge: %v", err)
+		return
+	}
+
+	for _, t := range tenants {
+		vols, err := c.ds.GetBlockDevices(t.ID)
+		if err != nil {
+			glog.Warningf("Error getting volumes to purge: %v", err)
+			continue
+		}
+
+		for _, vol := range vols {
+			if vol.State != types.Deleted || now.Sub(vol.DeleteTime) < c.deletedRetention {
+				continue
+			}
+
+			if err := c.purgeVolume(ctx, vol); err != nil {
+				glog.Warningf("Error purging volume %s: %v", vol.ID, err)
+			}
+		}
+	}
+}
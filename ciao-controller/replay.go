@@ -0,0 +1,232 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/ssntp"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// replayableCommands are the commands which are recorded when they fail.
+var replayableCommands = []ssntp.Command{
+	ssntp.START,
+	ssntp.DELETE,
+	ssntp.PAUSE,
+	ssntp.UNPAUSE,
+	ssntp.EVACUATE,
+	ssntp.Restore,
+	ssntp.AttachVolume,
+}
+
+func replayableCommand(name string) (ssntp.Command, bool) {
+	for _, cmd := range replayableCommands {
+		if cmd.String() == name {
+			return cmd, true
+		}
+	}
+
+	return 0, false
+}
+
+// recordFailedCommand stores a command which failed, along with its
+// payload, so that an administrator can replay it.
+func (c *controller) recordFailedCommand(ctx context.Context, cmd ssntp.Command, payload []byte, instanceID string, nodeID string, reason string) {
+	failed := types.FailedCommand{
+		ID:         uuid.Generate().String(),
+		Command:    cmd.String(),
+		InstanceID: instanceID,
+		NodeID:     nodeID,
+		Reason:     reason,
+		Timestamp:  time.Now().UTC(),
+		Payload:    string(payload),
+	}
+
+	err := c.ds.AddFailedCommand(ctx, failed)
+	if err != nil {
+		glog.Warningf("Error recording failed %s command: %v", cmd, err)
+		return
+	}
+
+	glog.Warningf("%s command failed (%s), recorded as %s", cmd, reason, failed.ID)
+}
+
+// prepareReplay restores the state the controller had when a command was
+// first sent, as the failure of the command may have reverted it.
+func (c *controller) prepareReplay(ctx context.Context, cmd ssntp.Command, failed types.FailedCommand) error {
+	switch cmd {
+	case ssntp.START:
+		// only restarts are recorded
+		return c.ds.InstanceRestarting(ctx, failed.InstanceID)
+	case ssntp.AttachVolume:
+		var payload payloads.AttachVolume
+
+		err := yaml.Unmarshal([]byte(failed.Payload), &payload)
+		if err != nil {
+			return errors.Wrap(err, "Error parsing attach volume payload")
+		}
+
+		data, err := c.ds.GetBlockDevice(payload.Attach.VolumeUUID)
+		if err != nil {
+			return err
+		}
+
+		if data.State != types.Available {
+			return nil
+		}
+
+		data.State = types.Attaching
+		return c.ds.UpdateBlockDevice(ctx, data)
+	}
+
+	return nil
+}
+
+// restartReplayPayload recreates the payload of a recorded restart, as the
+// secrets and volume keys of the instance are not recorded.
+func (c *controller) restartReplayPayload(ctx context.Context, instanceID string) ([]byte, error) {
+	i, err := c.ds.GetInstance(instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := c.instanceWorkload(i)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := c.ds.GetTenant(ctx, i.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.restartPayload(ctx, i, &w, t, true)
+}
+
+// attachReplayPayload recreates the payload of a recorded volume attach, as
+// the key of the volume is not recorded.
+func (c *controller) attachReplayPayload(ctx context.Context, failed types.FailedCommand) ([]byte, error) {
+	var payload payloads.AttachVolume
+
+	err := yaml.Unmarshal([]byte(failed.Payload), &payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error parsing attach volume payload")
+	}
+
+	attach := payload.Attach
+	key, err := c.volumeKey(ctx, attach.VolumeUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return attachVolumePayload(attach.VolumeUUID, attach.Pool, key, attach.InstanceUUID, attach.WorkloadAgentUUID)
+}
+
+// resequencePayload stamps the payload of a replayed instance command with
+// a new sequence.  The launcher ignores the commands whose sequence is not
+// greater than the one of the last command it accepted for the instance,
+// which the sequence the command was recorded with may no longer be.
+func resequencePayload(cmd ssntp.Command, payload []byte, sequence uint64) ([]byte, error) {
+	var p interface{}
+	var seq *uint64
+
+	switch cmd {
+	case ssntp.DELETE:
+		var d payloads.Delete
+		p, seq = &d, &d.Delete.Sequence
+	case ssntp.PAUSE:
+		var pause payloads.Pause
+		p, seq = &pause, &pause.Pause.Sequence
+	case ssntp.UNPAUSE:
+		var unpause payloads.Unpause
+		p, seq = &unpause, &unpause.Unpause.Sequence
+	default:
+		return payload, nil
+	}
+
+	err := yaml.Unmarshal(payload, p)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error parsing %s payload", cmd)
+	}
+
+	*seq = sequence
+
+	return yaml.Marshal(p)
+}
+
+// ListFailedCommands returns the commands which failed and have not been
+// replayed yet.
+func (c *controller) ListFailedCommands(ctx context.Context) ([]types.FailedCommand, error) {
+	return c.ds.GetFailedCommands(ctx)
+}
+
+// ReplayFailedCommand sends a failed command again, with its original
+// payload stamped with a new sequence. Restarts and volume attaches are
+// sent with a new payload as the secrets of their instances and the keys
+// of their volumes are not recorded. The command is forgotten once it has
+// been sent.
+func (c *controller) ReplayFailedCommand(ctx context.Context, ID string) error {
+	failed, err := c.ds.GetFailedCommand(ctx, ID)
+	if err != nil {
+		return err
+	}
+
+	cmd, ok := replayableCommand(failed.Command)
+	if !ok {
+		return types.ErrBadRequest
+	}
+
+	payload := []byte(failed.Payload)
+	switch cmd {
+	case ssntp.START:
+		payload, err = c.restartReplayPayload(ctx, failed.InstanceID)
+	case ssntp.AttachVolume:
+		payload, err = c.attachReplayPayload(ctx, failed)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Unable to replay %s command", failed.Command)
+	}
+
+	err = c.prepareReplay(ctx, cmd, failed)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to replay %s command", failed.Command)
+	}
+
+	err = c.client.replayCommand(cmd, payload)
+	if err != nil {
+		return errors.Wrapf(err, "Error replaying %s command", failed.Command)
+	}
+
+	glog.Infof("Failed %s command %s replayed", failed.Command, ID)
+
+	return c.ds.DeleteFailedCommand(ctx, ID)
+}
+
+// DeleteFailedCommand forgets a failed command without replaying it.
+func (c *controller) DeleteFailedCommand(ctx context.Context, ID string) error {
+	_, err := c.ds.GetFailedCommand(ctx, ID)
+	if err != nil {
+		return err
+	}
+
+	return c.ds.DeleteFailedCommand(ctx, ID)
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+var rescueImage = flag.String("rescue_image", "", "Image booted by instances in rescue mode when the rescue request names no image")
+
+// RescueServer reboots a running instance from a volume created from a
+// rescue image.  The volumes of the instance stay attached as secondary
+// devices so that they can be repaired from the rescue system.
+func (c *controller) RescueServer(ctx context.Context, tenant string, ID string, imageID string) error {
+	i, err := c.ds.GetTenantInstance(tenant, ID)
+	if err != nil {
+		return err
+	}
+
+	if imageID == "" {
+		imageID = *rescueImage
+	}
+
+	if imageID == "" {
+		return types.ErrBadRequest
+	}
+
+	wl, err := c.ds.GetWorkload(i.WorkloadID)
+	if err != nil {
+		return err
+	}
+
+	if i.CNCI || wl.VMType != payloads.QEMU {
+		return types.ErrRescueNotSupported
+	}
+
+	i.StateLock.RLock()
+	state := i.State
+	nodeID := i.NodeID
+	rescueVolume := i.RescueVolume
+	i.StateLock.RUnlock()
+
+	if nodeID == "" {
+		return types.ErrInstanceNotAssigned
+	}
+
+	if state != payloads.Running {
+		return types.ErrInstanceNotRunning
+	}
+
+	if rescueVolume != "" {
+		return types.ErrInstanceRescued
+	}
+
+	image, err := c.GetImage(ctx, tenant, imageID)
+	if err != nil {
+		return err
+	}
+
+	req := api.RequestedVolume{
+		Description: fmt.Sprintf("Rescue volume for instance: %s", ID),
+		Internal:    true,
+		ImageRef:    image.ID,
+	}
+
+	vol, err := c.createVolume(ctx, tenant, req)
+	if err != nil {
+		return errors.Wrap(err, "Error creating rescue volume")
+	}
+
+	storage, err := c.rescueStorage(ctx, vol.ID, true)
+	if err == nil {
+		err = c.ds.SetInstanceRescue(ctx, ID, vol.ID, true)
+	}
+	if err != nil {
+		if derr := c.deleteRescueVolume(ctx, vol.ID); derr != nil {
+			glog.Warningf("Error deleting rescue volume %s: %v", vol.ID, derr)
+		}
+		return err
+	}
+
+	go func() {
+		if err := c.client.RescueInstance(ID, nodeID, storage); err != nil {
+			glog.Warningf("Error rescuing instance: %v", err)
+		}
+	}()
+
+	msg := fmt.Sprintf("Rescuing instance %s from image %s", ID, image.ID)
+	_ = c.ds.LogEvent(ctx, tenant, msg)
+
+	return nil
+}
+
+// UnrescueServer boots a rescued instance from its original volumes again.
+// The rescue volume of a running instance is deleted once the launcher no
+// longer reports it in use.
+func (c *controller) UnrescueServer(ctx context.Context, tenant string, ID string) error {
+	i, err := c.ds.GetTenantInstance(tenant, ID)
+	if err != nil {
+		return err
+	}
+
+	i.StateLock.RLock()
+	state := i.State
+	nodeID := i.NodeID
+	rescued := i.Rescued
+	rescueVolume := i.RescueVolume
+	i.StateLock.RUnlock()
+
+	if !rescued {
+		return types.ErrInstanceNotRescued
+	}
+
+	switch {
+	case state == payloads.Running && nodeID != "":
+		err = c.ds.SetInstanceRescue(ctx, ID, rescueVolume, false)
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			if err := c.client.UnrescueInstance(ID, nodeID); err != nil {
+				glog.Warningf("Error unrescuing instance: %v", err)
+			}
+		}()
+	case state == payloads.Exited:
+		// a stopped instance boots from its original volumes when it
+		// is restarted.
+		err = c.ds.SetInstanceRescue(ctx, ID, "", false)
+		if err != nil {
+			return err
+		}
+
+		err = c.deleteRescueVolume(ctx, rescueVolume)
+		if err != nil {
+			return err
+		}
+	default:
+		return types.ErrUnrescueInstanceState
+	}
+
+	msg := fmt.Sprintf("Unrescuing instance %s", ID)
+	_ = c.ds.LogEvent(ctx, tenant, msg)
+
+	return nil
+}
+
+// rescueStorage returns the storage resource of a rescue volume in the
+// payloads of the commands sent to the launcher.  The key of the volume is
+// only added if withKey is set.
+func (c *controller) rescueStorage(ctx context.Context, volumeID string, withKey bool) (payloads.StorageResource, error) {
+	storage := payloads.StorageResource{
+		ID:       volumeID,
+		Bootable: true,
+		Rescue:   true,
+		Pool:     c.volumePool(volumeID),
+	}
+
+	if withKey {
+		key, err := c.volumeKey(ctx, volumeID)
+		if err != nil {
+			return payloads.StorageResource{}, err
+		}
+		storage.Key = key
+	}
+
+	return storage, nil
+}
+
+func (c *controller) deleteRescueVolume(ctx context.Context, volumeID string) error {
+	vol, err := c.ds.GetBlockDevice(volumeID)
+	if err != nil {
+		return errors.Wrap(err, "Error getting rescue volume from datastore")
+	}
+
+	err = c.ds.DeleteBlockDevice(ctx, volumeID)
+	if err != nil {
+		return errors.Wrap(err, "Error deleting rescue volume from datastore")
+	}
+
+	return c.deleteVolumeBlockDevice(ctx, vol)
+}
+
+// releaseRescueVolume deletes the rescue volume of an unrescued instance
+// once the volumes reported by the launcher for the instance no longer
+// include it.
+func (c *controller) releaseRescueVolume(ctx context.Context, instanceID string, volumes []string) {
+	i, err := c.ds.GetInstance(instanceID)
+	if err != nil {
+		return
+	}
+
+	i.StateLock.RLock()
+	rescued := i.Rescued
+	rescueVolume := i.RescueVolume
+	i.StateLock.RUnlock()
+
+	if rescued || rescueVolume == "" {
+		return
+	}
+
+	for _, vol := range volumes {
+		if vol == rescueVolume {
+			return
+		}
+	}
+
+	err = c.ds.SetInstanceRescue(ctx, instanceID, "", false)
+	if err != nil {
+		glog.Warningf("Error releasing rescue volume of instance %s: %v", instanceID, err)
+		return
+	}
+
+	err = c.deleteRescueVolume(ctx, rescueVolume)
+	if err != nil {
+		glog.Warningf("Error deleting rescue volume %s: %v", rescueVolume, err)
+	}
+}
@@ -0,0 +1,364 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+var secretsKeyPath = flag.String("secrets_key", "/var/lib/ciao/data/controller/secrets.key", "Path to the cluster key encrypting tenant secrets, generated if it does not exist")
+
+// secretsKeySize is the size of the AES-256 cluster key.
+const secretsKeySize = 32
+
+var secretNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,63}$`)
+
+// volumeKeyPrefix prefixes the names of the secrets holding the LUKS keys
+// of encrypted volumes.  These secrets are reserved to the controller.
+const volumeKeyPrefix = "ciao-volume-"
+
+// volumeKeySize is the size of the random LUKS passphrase of a volume.
+const volumeKeySize = 32
+
+func validSecretName(name string) bool {
+	return secretNameRegexp.MatchString(name) && !strings.HasPrefix(name, volumeKeyPrefix)
+}
+
+// secretBackend is an external store the secrets referenced by workloads
+// are resolved from before the built-in store.
+type secretBackend interface {
+	fmt.Stringer
+
+	// GetSecret returns the value of a tenant secret, or
+	// types.ErrSecretNotFound if the backend does not hold it.
+	GetSecret(tenantID string, name string) (string, error)
+}
+
+// loadSecretsCipher reads the cluster key used to encrypt secrets at rest,
+// generating it the first time the controller runs.
+func loadSecretsCipher(path string) (cipher.AEAD, error) {
+	key, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		key = make([]byte, secretsKeySize)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, errors.Wrap(err, "Error generating secrets key")
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, errors.Wrap(err, "Error creating secrets key directory")
+		}
+
+		if err := ioutil.WriteFile(path, key, 0600); err != nil {
+			return nil, errors.Wrap(err, "Error writing secrets key")
+		}
+
+		glog.Infof("Generated secrets key %s", path)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "Error reading secrets key")
+	}
+
+	return newSecretsCipher(key)
+}
+
+func newSecretsCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != secretsKeySize {
+		return nil, fmt.Errorf("Secrets key must be %d bytes long", secretsKeySize)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// secretAdditionalData binds an encrypted secret to its tenant and name so
+// that it cannot be swapped with another secret in the datastore.
+func secretAdditionalData(tenantID string, name string) []byte {
+	return []byte(tenantID + "/" + name)
+}
+
+func (c *controller) encryptSecret(tenantID string, name string, value string) ([]byte, error) {
+	if c.secrets == nil {
+		return nil, errors.New("No secrets key configured")
+	}
+
+	nonce := make([]byte, c.secrets.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "Error generating nonce")
+	}
+
+	return c.secrets.Seal(nonce, nonce, []byte(value), secretAdditionalData(tenantID, name)), nil
+}
+
+func (c *controller) decryptSecret(secret types.Secret) (string, error) {
+	if c.secrets == nil {
+		return "", errors.New("No secrets key configured")
+	}
+
+	nonceSize := c.secrets.NonceSize()
+	if len(secret.Data) < nonceSize {
+		return "", fmt.Errorf("Secret %s is corrupted", secret.Name)
+	}
+
+	value, err := c.secrets.Open(nil, secret.Data[:nonceSize], secret.Data[nonceSize:],
+		secretAdditionalData(secret.TenantID, secret.Name))
+	if err != nil {
+		return "", errors.Wrapf(err, "Error decrypting secret %s", secret.Name)
+	}
+
+	return string(value), nil
+}
+
+// resolveSecret returns the value of a tenant secret from the first secret
+// backend holding it, falling back to the built-in store. Errors other than
+// a missing secret are not masked by the fallback.
+func (c *controller) resolveSecret(ctx context.Context, tenantID string, name string) (string, error) {
+	for _, backend := range c.secretBackends {
+		value, err := backend.GetSecret(tenantID, name)
+		if err == types.ErrSecretNotFound {
+			continue
+		} else if err != nil {
+			return "", errors.Wrapf(err, "Error getting secret %s from %s", name, backend)
+		}
+
+		return value, nil
+	}
+
+	secret, err := c.ds.GetSecret(ctx, tenantID, name)
+	if err != nil {
+		return "", err
+	}
+
+	return c.decryptSecret(secret)
+}
+
+// ListSecrets returns the secrets of a tenant held by the built-in store,
+// without their values.
+func (c *controller) ListSecrets(ctx context.Context, tenantID string) ([]types.Secret, error) {
+	if err := c.checkTenant(ctx, tenantID); err != nil {
+		return nil, err
+	}
+
+	secrets, err := c.ds.GetSecrets(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	tenantSecrets := make([]types.Secret, 0, len(secrets))
+	for _, secret := range secrets {
+		if !validSecretName(secret.Name) {
+			continue
+		}
+
+		secret.Data = nil
+		tenantSecrets = append(tenantSecrets, secret)
+	}
+
+	return tenantSecrets, nil
+}
+
+// SetSecret encrypts and stores the value of a tenant secret, replacing
+// the previous value of the secret. Instances only see the new value once
+// they are restarted.
+func (c *controller) SetSecret(ctx context.Context, tenantID string, name string, value string) error {
+	if !validSecretName(name) {
+		return types.ErrBadRequest
+	}
+
+	if err := c.checkTenant(ctx, tenantID); err != nil {
+		return err
+	}
+
+	data, err := c.encryptSecret(tenantID, name, value)
+	if err != nil {
+		return err
+	}
+
+	secret := types.Secret{
+		Name:       name,
+		TenantID:   tenantID,
+		CreateTime: time.Now().UTC(),
+		Data:       data,
+	}
+
+	err = c.ds.UpdateSecret(ctx, secret)
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("Set secret %s", name)
+	_ = c.ds.LogEvent(ctx, tenantID, msg)
+
+	return nil
+}
+
+// DeleteSecret removes a tenant secret which is no longer referenced by
+// any of the tenant's workloads.
+func (c *controller) DeleteSecret(ctx context.Context, tenantID string, name string) error {
+	if err := c.checkTenant(ctx, tenantID); err != nil {
+		return err
+	}
+
+	if !validSecretName(name) {
+		return types.ErrSecretNotFound
+	}
+
+	_, err := c.ds.GetSecret(ctx, tenantID, name)
+	if err != nil {
+		return err
+	}
+
+	workloads, err := c.ds.GetTenantWorkloads(tenantID)
+	if err != nil {
+		return err
+	}
+
+	for _, wl := range workloads {
+		for _, s := range wl.Secrets {
+			if s == name {
+				return types.ErrSecretInUse
+			}
+		}
+	}
+
+	err = c.ds.DeleteSecret(ctx, tenantID, name)
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("Deleted secret %s", name)
+	_ = c.ds.LogEvent(ctx, tenantID, msg)
+
+	return nil
+}
+
+// validateWorkloadSecrets checks the secrets referenced by a workload.
+// Public workloads are instantiated by other tenants so their secrets can
+// only be resolved when their instances are started.
+func (c *controller) validateWorkloadSecrets(ctx context.Context, req *types.Workload) error {
+	for _, name := range req.Secrets {
+		if !validSecretName(name) {
+			return types.ErrBadRequest
+		}
+
+		if req.Visibility == types.Public {
+			continue
+		}
+
+		_, err := c.resolveSecret(ctx, req.TenantID, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// instanceSecrets returns the decrypted secrets injected in the meta-data
+// of an instance of a workload.
+func (c *controller) instanceSecrets(ctx context.Context, tenantID string, wl *types.Workload) (map[string]string, error) {
+	if len(wl.Secrets) == 0 {
+		return nil, nil
+	}
+
+	secrets := make(map[string]string)
+	for _, name := range wl.Secrets {
+		value, err := c.resolveSecret(ctx, tenantID, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error getting secret %s", name)
+		}
+
+		secrets[name] = value
+	}
+
+	return secrets, nil
+}
+
+func volumeKeyName(volumeID string) string {
+	return volumeKeyPrefix + volumeID
+}
+
+// newVolumeKey generates a random LUKS passphrase for a volume.
+func newVolumeKey() (string, error) {
+	key := make([]byte, volumeKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", errors.Wrap(err, "Error generating volume key")
+	}
+
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// storeVolumeKey stores the LUKS passphrase of an encrypted volume in the
+// built-in secret store.
+func (c *controller) storeVolumeKey(ctx context.Context, tenantID string, volumeID string, key string) error {
+	name := volumeKeyName(volumeID)
+	data, err := c.encryptSecret(tenantID, name, key)
+	if err != nil {
+		return err
+	}
+
+	return c.ds.UpdateSecret(ctx, types.Secret{
+		Name:       name,
+		TenantID:   tenantID,
+		CreateTime: time.Now().UTC(),
+		Data:       data,
+	})
+}
+
+// volumeKey returns the LUKS passphrase of a volume, which is empty for
+// volumes which are not encrypted and for volumes unknown to the datastore.
+func (c *controller) volumeKey(ctx context.Context, volumeID string) (string, error) {
+	volume, err := c.ds.GetBlockDevice(volumeID)
+	if err != nil || !volume.Encrypted {
+		return "", nil
+	}
+
+	secret, err := c.ds.GetSecret(ctx, volume.TenantID, volumeKeyName(volumeID))
+	if err != nil {
+		return "", errors.Wrapf(err, "Error getting key of volume %s", volumeID)
+	}
+
+	return c.decryptSecret(secret)
+}
+
+func (c *controller) deleteVolumeKey(ctx context.Context, data types.Volume) {
+	if !data.Encrypted {
+		return
+	}
+
+	err := c.ds.DeleteSecret(ctx, data.TenantID, volumeKeyName(data.ID))
+	if err != nil && err != types.ErrSecretNotFound {
+		glog.Warningf("Error deleting key of volume %s: %v", data.ID, err)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+func TestLoadSecretsCipher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "keys", "secrets.key")
+
+	aead, err := loadSecretsCipher(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Mode().Perm() != 0600 || fi.Size() != secretsKeySize {
+		t.Fatalf("Unexpected secrets key file %v %d", fi.Mode(), fi.Size())
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	sealed := aead.Seal(nil, nonce, []byte("value"), nil)
+
+	aead, err = loadSecretsCipher(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil || string(value) != "value" {
+		t.Fatalf("Secrets key not reloaded: %v", err)
+	}
+
+	err = ioutil.WriteFile(path, []byte("short"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = loadSecretsCipher(path)
+	if err == nil {
+		t.Fatal("Expected error loading an invalid secrets key")
+	}
+}
+
+func TestSecrets(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.SetSecret(ctx, tenant.ID, "invalid/name", "value")
+	if err != types.ErrBadRequest {
+		t.Fatalf("Expected %v, got %v", types.ErrBadRequest, err)
+	}
+
+	err = ctl.SetSecret(ctx, tenant.ID, "db-password", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secrets, err := ctl.ds.GetSecrets(ctx, tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(secrets) != 1 || bytes.Contains(secrets[0].Data, []byte("hunter2")) {
+		t.Fatalf("Expected one encrypted secret, got %v", secrets)
+	}
+
+	secrets, err = ctl.ListSecrets(ctx, tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(secrets) != 1 || secrets[0].Name != "db-password" || secrets[0].Data != nil {
+		t.Fatalf("Unexpected secrets %v", secrets)
+	}
+
+	wl := types.Workload{
+		TenantID:    tenant.ID,
+		Description: "secrets workload",
+		VMType:      payloads.Docker,
+		ImageName:   "ubuntu:latest",
+		Config:      "#cloud-config\n",
+		Requirements: payloads.WorkloadRequirements{
+			VCPUs: 1,
+			MemMB: 128,
+		},
+		Secrets: []string{"missing"},
+	}
+
+	_, err = ctl.CreateWorkload(ctx, wl)
+	if err != types.ErrSecretNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrSecretNotFound, err)
+	}
+
+	wl.Secrets = []string{"db-password"}
+	wl, err = ctl.CreateWorkload(ctx, wl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := newConfig(ctx, ctl, &wl, uuid.Generate().String(), tenant.ID, "",
+		net.ParseIP("172.16.0.2"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(config.config, `"db-password": "hunter2"`) {
+		t.Fatalf("Secret not injected in meta-data:\n%s", config.config)
+	}
+
+	err = ctl.DeleteSecret(ctx, tenant.ID, "db-password")
+	if err != types.ErrSecretInUse {
+		t.Fatalf("Expected %v, got %v", types.ErrSecretInUse, err)
+	}
+
+	err = ctl.DeleteWorkload(ctx, tenant.ID, wl.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.DeleteSecret(ctx, tenant.ID, "db-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.DeleteSecret(ctx, tenant.ID, "db-password")
+	if err != types.ErrSecretNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrSecretNotFound, err)
+	}
+}
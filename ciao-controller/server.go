@@ -21,10 +21,13 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
 	"github.com/ciao-project/ciao/service"
 	"github.com/golang/glog"
 	"github.com/gorilla/mux"
@@ -37,6 +40,15 @@ type clientCertAuthHandler struct {
 }
 
 func (h *clientCertAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// clients which cannot manage certificates authenticate with an
+	// API token instead.
+	if len(r.TLS.PeerCertificates) == 0 {
+		h.serveAPIToken(w, r)
+		return
+	}
+
 	if len(r.TLS.VerifiedChains) != 1 {
 		http.Error(w, "Unexpected number of certificate chains presented", http.StatusUnauthorized)
 		return
@@ -71,17 +83,61 @@ func (h *clientCertAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 
 	r = r.WithContext(service.SetTenantID(r.Context(), tenantFromVars))
 	if tenantFromVars != "" {
-		err := h.Controller.confirmTenant(tenantFromVars)
+		err := h.Controller.confirmTenant(ctx, tenantFromVars)
 		if err != nil {
 			http.Error(w, "Error confirming tenant", http.StatusInternalServerError)
+			return
 		}
 	}
 
 	h.Next.ServeHTTP(w, r)
 }
 
+func (h *clientCertAuthHandler) serveAPIToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		http.Error(w, "Client certificate or API token required", http.StatusUnauthorized)
+		return
+	}
+
+	token, err := h.Controller.authenticateAPIToken(ctx, strings.TrimPrefix(auth, "Bearer "))
+	if err == errInvalidAPIToken {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		glog.Warningf("Error authenticating API token: %v", err)
+		http.Error(w, "Error authenticating API token", http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(r)
+	tenantFromVars := vars["tenant"]
+	if tenantFromVars != token.TenantID {
+		http.Error(w, "Access to tenant not permitted with API token", http.StatusUnauthorized)
+		return
+	}
+
+	if token.Scope != types.APITokenFull && r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Operation not permitted with read-only API token", http.StatusForbidden)
+		return
+	}
+
+	r = r.WithContext(service.SetPrivilege(r.Context(), false))
+	r = r.WithContext(service.SetAPIToken(r.Context(), token.ID))
+	r = r.WithContext(service.SetTenantID(r.Context(), tenantFromVars))
+	err = h.Controller.confirmTenant(ctx, tenantFromVars)
+	if err != nil {
+		http.Error(w, "Error confirming tenant", http.StatusInternalServerError)
+		return
+	}
+
+	h.Next.ServeHTTP(w, r)
+}
+
 func (c *controller) createCiaoRoutes(r *mux.Router) error {
-	config := api.Config{URL: c.apiURL, CiaoService: c}
+	config := api.Config{URL: c.apiURL, CiaoService: c, DisableImages: c.imagesDisabled}
 
 	r = api.Routes(config, r)
 
@@ -98,14 +154,105 @@ func (c *controller) createCiaoRoutes(r *mux.Router) error {
 	return err
 }
 
+// httpServerConfig holds the hardening settings of the ciao API server.
+// Zero values keep the net/http and crypto/tls defaults.
+type httpServerConfig struct {
+	readTimeout   time.Duration
+	writeTimeout  time.Duration
+	idleTimeout   time.Duration
+	tlsMinVersion uint16
+	cipherSuites  []uint16
+	hstsMaxAge    int
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+}
+
+// tlsCipherSuites lists the cipher suites which can be allowed by the
+// configuration.  RC4, 3DES and CBC-SHA256 suites are left out as they are
+// insecure.
+var tlsCipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":    tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":  tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+}
+
+func newHTTPServerConfig(conf payloads.ConfigureController) (httpServerConfig, error) {
+	var hc httpServerConfig
+
+	if conf.HTTPReadTimeout < 0 || conf.HTTPWriteTimeout < 0 || conf.HTTPIdleTimeout < 0 {
+		return hc, errors.New("HTTP timeouts must not be negative")
+	}
+	hc.readTimeout = time.Duration(conf.HTTPReadTimeout) * time.Second
+	hc.writeTimeout = time.Duration(conf.HTTPWriteTimeout) * time.Second
+	hc.idleTimeout = time.Duration(conf.HTTPIdleTimeout) * time.Second
+
+	if conf.TLSMinVersion != "" {
+		v, ok := tlsVersions[conf.TLSMinVersion]
+		if !ok {
+			return hc, fmt.Errorf("Unknown TLS version %s", conf.TLSMinVersion)
+		}
+		hc.tlsMinVersion = v
+	}
+
+	for _, name := range conf.TLSCipherSuites {
+		ID, ok := tlsCipherSuites[name]
+		if !ok {
+			return hc, fmt.Errorf("Unknown or insecure TLS cipher suite %s", name)
+		}
+		hc.cipherSuites = append(hc.cipherSuites, ID)
+	}
+
+	if conf.HSTSMaxAge < 0 {
+		return hc, errors.New("HSTS max-age must not be negative")
+	}
+	hc.hstsMaxAge = conf.HSTSMaxAge
+
+	return hc, nil
+}
+
+type hstsHandler struct {
+	maxAge int
+	Next   http.Handler
+}
+
+func (h *hstsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d", h.maxAge))
+	h.Next.ServeHTTP(w, r)
+}
+
 func (c *controller) createCiaoServer() (*http.Server, error) {
 	r := mux.NewRouter()
 
 	addr := fmt.Sprintf(":%d", controllerAPIPort)
 
 	server := &http.Server{
-		Handler: r,
-		Addr:    addr,
+		Handler:      r,
+		Addr:         addr,
+		ReadTimeout:  c.httpConfig.readTimeout,
+		WriteTimeout: c.httpConfig.writeTimeout,
+		IdleTimeout:  c.httpConfig.idleTimeout,
+	}
+
+	if c.httpConfig.hstsMaxAge > 0 {
+		server.Handler = &hstsHandler{
+			maxAge: c.httpConfig.hstsMaxAge,
+			Next:   r,
+		}
 	}
 
 	clientCertCAbytes, err := ioutil.ReadFile(clientCertCAPath)
@@ -118,25 +265,45 @@ func (c *controller) createCiaoServer() (*http.Server, error) {
 		return nil, errors.New("Error importing client auth CA to poool")
 	}
 	tlsConfig := tls.Config{
-		ClientAuth: tls.RequireAndVerifyClientCert,
-		ClientCAs:  certPool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    certPool,
+		MinVersion:   c.httpConfig.tlsMinVersion,
+		CipherSuites: c.httpConfig.cipherSuites,
 	}
 	server.TLSConfig = &tlsConfig
 
+	// signed requests are verified against the client CA and served by
+	// the API router directly.
+	c.clientCAs = certPool
+	c.apiHandler = r
+
 	if err := c.createComputeRoutes(r); err != nil {
 		return nil, errors.Wrap(err, "Error adding compute routes")
 	}
 
+	if !c.imagesDisabled {
+		imageRoutes(c, r)
+	}
+
+	r.HandleFunc("/readyz", c.readyz).Methods("GET")
+
 	err = c.createCiaoRoutes(r)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error adding ciao routes")
 	}
 
+	// added after the ciao routes so that it is not authenticated.
+	r.HandleFunc("/healthz", healthz).Methods("GET")
+
 	return server, nil
 }
 
 func (c *controller) ShutdownHTTPServers() {
 	glog.Warning("Shutting down HTTP servers")
+
+	// event streams never complete on their own.
+	c.ds.StopEventWatchers()
+
 	var wg sync.WaitGroup
 	for _, server := range c.httpServers {
 		wg.Add(1)
@@ -0,0 +1,140 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/testutil"
+)
+
+func TestNewHTTPServerConfig(t *testing.T) {
+	conf := payloads.ConfigureController{
+		HTTPReadTimeout:  10,
+		HTTPWriteTimeout: 20,
+		HTTPIdleTimeout:  30,
+		TLSMinVersion:    "1.2",
+		TLSCipherSuites:  []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+		HSTSMaxAge:       3600,
+	}
+
+	hc, err := newHTTPServerConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hc.readTimeout != 10*time.Second || hc.writeTimeout != 20*time.Second ||
+		hc.idleTimeout != 30*time.Second {
+		t.Errorf("Wrong timeouts %v %v %v", hc.readTimeout, hc.writeTimeout, hc.idleTimeout)
+	}
+
+	if hc.tlsMinVersion != tls.VersionTLS12 {
+		t.Errorf("Wrong TLS minimum version %x", hc.tlsMinVersion)
+	}
+
+	if len(hc.cipherSuites) != 1 || hc.cipherSuites[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("Wrong cipher suites %v", hc.cipherSuites)
+	}
+
+	if hc.hstsMaxAge != 3600 {
+		t.Errorf("Wrong HSTS max-age %d", hc.hstsMaxAge)
+	}
+}
+
+func TestNewHTTPServerConfigDefaults(t *testing.T) {
+	hc, err := newHTTPServerConfig(payloads.ConfigureController{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hc.readTimeout != 0 || hc.tlsMinVersion != 0 || hc.cipherSuites != nil || hc.hstsMaxAge != 0 {
+		t.Errorf("Unexpected non default configuration %+v", hc)
+	}
+}
+
+func TestNewHTTPServerConfigInvalid(t *testing.T) {
+	confs := []payloads.ConfigureController{
+		{HTTPReadTimeout: -1},
+		{TLSMinVersion: "1.4"},
+		{TLSMinVersion: "1.3"},
+		{TLSCipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{HSTSMaxAge: -1},
+	}
+
+	for _, conf := range confs {
+		if _, err := newHTTPServerConfig(conf); err == nil {
+			t.Errorf("Expected error with configuration %+v", conf)
+		}
+	}
+}
+
+func TestHSTSHandler(t *testing.T) {
+	h := &hstsHandler{
+		maxAge: 3600,
+		Next:   http.NotFoundHandler(),
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if hsts := w.Header().Get("Strict-Transport-Security"); hsts != "max-age=3600" {
+		t.Errorf("Wrong Strict-Transport-Security header %q", hsts)
+	}
+}
+
+func testUnauthenticatedRequest(t *testing.T, URL string) int {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{}}}
+	resp, err := client.Get(URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func TestHealthz(t *testing.T) {
+	code := testUnauthenticatedRequest(t, testutil.ComputeURL+"/healthz")
+	if code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d", http.StatusOK, code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	code := testUnauthenticatedRequest(t, testutil.ComputeURL+"/readyz")
+	if code != http.StatusUnauthorized {
+		t.Fatalf("Expected %d without authentication, got %d", http.StatusUnauthorized, code)
+	}
+
+	body := testHTTPRequest(t, "GET", testutil.ComputeURL+"/readyz", http.StatusOK, nil, true)
+
+	var status map[string]string
+	err := json.Unmarshal(body, &status)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, check := range []string{"datastore", "ssntp", "storage"} {
+		if status[check] != "ok" {
+			t.Errorf("Unexpected %s status %q", check, status[check])
+		}
+	}
+}
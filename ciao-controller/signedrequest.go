@@ -0,0 +1,184 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"flag"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/golang/glog"
+)
+
+var signedRequestMaxAge = flag.Duration("signed_request_max_age", 72*time.Hour, "Time after which signed requests can no longer be submitted, 0 disables signed requests")
+
+// signedRequestClockSkew is how far in the future a signed request may
+// have been created, to allow for the clock of the machine it was signed on.
+const signedRequestClockSkew = 5 * time.Minute
+
+// signedRequestWriter captures the response of the API to a signed request.
+type signedRequestWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *signedRequestWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *signedRequestWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+func (w *signedRequestWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// verifySignedRequest checks that a signed request was signed with a client
+// certificate issued by the client CA and returns its verified chain.
+func (c *controller) verifySignedRequest(req types.SignedRequest) ([]*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(req.Certificate))
+	if block == nil {
+		return nil, types.ErrSignedRequestInvalid
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, types.ErrSignedRequestInvalid
+	}
+
+	chains, err := cert.Verify(x509.VerifyOptions{
+		Roots:     c.clientCAs,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		glog.Warningf("Error verifying certificate of signed request %s: %v", req.Nonce, err)
+		return nil, types.ErrSignedRequestInvalid
+	}
+
+	var algo x509.SignatureAlgorithm
+	switch cert.PublicKeyAlgorithm {
+	case x509.RSA:
+		algo = x509.SHA256WithRSA
+	case x509.ECDSA:
+		algo = x509.ECDSAWithSHA256
+	default:
+		return nil, types.ErrSignedRequestInvalid
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(req.Signature)
+	if err != nil {
+		return nil, types.ErrSignedRequestInvalid
+	}
+
+	data, err := req.SigningData()
+	if err != nil {
+		return nil, types.ErrSignedRequestInvalid
+	}
+
+	if err := cert.CheckSignature(algo, data, signature); err != nil {
+		glog.Warningf("Error verifying signature of signed request %s: %v", req.Nonce, err)
+		return nil, types.ErrSignedRequestInvalid
+	}
+
+	return chains[0], nil
+}
+
+// SubmitSignedRequest runs a request signed offline as if it had been sent
+// by the owner of the certificate it was signed with.  Requests are only
+// run once, and only while they are fresher than the signed_request_max_age.
+func (c *controller) SubmitSignedRequest(ctx context.Context, req types.SignedRequest) (types.SignedRequestResult, error) {
+	if c.signedRequestMaxAge <= 0 {
+		return types.SignedRequestResult{}, types.ErrSignedRequestsDisabled
+	}
+
+	if req.Nonce == "" || req.Method == "GET" || req.Method == "HEAD" ||
+		!strings.HasPrefix(req.Path, "/") || strings.HasPrefix(req.Path, "/signed-requests") {
+		return types.SignedRequestResult{}, types.ErrBadRequest
+	}
+
+	chain, err := c.verifySignedRequest(req)
+	if err != nil {
+		return types.SignedRequestResult{}, err
+	}
+
+	age := time.Since(req.Created)
+	if age > c.signedRequestMaxAge || age < -signedRequestClockSkew {
+		return types.SignedRequestResult{}, types.ErrSignedRequestExpired
+	}
+
+	cert := chain[0]
+	rec := types.SignedRequestRecord{
+		Nonce:      req.Nonce,
+		Method:     req.Method,
+		Path:       req.Path,
+		Signer:     cert.Subject.CommonName,
+		Created:    req.Created,
+		SubmitTime: time.Now(),
+	}
+
+	err = c.ds.AddSignedRequest(ctx, rec)
+	if err != nil {
+		return types.SignedRequestResult{}, err
+	}
+
+	r, err := http.NewRequest(req.Method, req.Path, bytes.NewReader(req.Body))
+	if err != nil {
+		return types.SignedRequestResult{}, types.ErrBadRequest
+	}
+	r = r.WithContext(ctx)
+
+	if req.ContentType != "" {
+		r.Header.Set("Content-Type", "application/"+req.ContentType)
+		r.Header.Set("Accept", "application/"+req.ContentType)
+	}
+	r.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{chain},
+	}
+
+	w := &signedRequestWriter{header: make(http.Header)}
+	c.apiHandler.ServeHTTP(w, r)
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	glog.Infof("Signed request %s %s of %s submitted: %d", req.Method, req.Path, rec.Signer, w.status)
+
+	return types.SignedRequestResult{
+		StatusCode:  w.status,
+		ContentType: w.header.Get("Content-Type"),
+		Body:        w.body.String(),
+	}, nil
+}
+
+// ListSignedRequests returns the records of the signed requests submitted.
+func (c *controller) ListSignedRequests(ctx context.Context) ([]types.SignedRequestRecord, error) {
+	return c.ds.GetSignedRequests(ctx)
+}
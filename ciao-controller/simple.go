@@ -0,0 +1,171 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+)
+
+// simpleWorkloadPrefix starts the description of the workloads synthesized
+// for the instances launched without a workload.
+const simpleWorkloadPrefix = "Simple instance: "
+
+const simpleWorkloadConfig = `---
+#cloud-config
+...
+`
+
+// instanceSize is a size profile of the instances launched without a
+// workload.
+type instanceSize struct {
+	vcpus  int
+	memMB  int
+	diskGB int
+}
+
+const defaultInstanceSize = "small"
+
+var instanceSizes = map[string]instanceSize{
+	"small":  {vcpus: 1, memMB: 1024, diskGB: 10},
+	"medium": {vcpus: 2, memMB: 4096, diskGB: 20},
+	"large":  {vcpus: 4, memMB: 8192, diskGB: 40},
+}
+
+func simpleWorkloadDescription(size string, imageID string) string {
+	return fmt.Sprintf("%s%s %s", simpleWorkloadPrefix, size, imageID)
+}
+
+func isSimpleWorkload(wl *types.Workload) bool {
+	return wl.Visibility == types.Private &&
+		strings.HasPrefix(wl.Description, simpleWorkloadPrefix)
+}
+
+// simpleWorkload returns the workload of the tenant instances of an image
+// and size launched without a workload, creating it if there is none.  The
+// simpleWorkloadLock must be held by the caller.
+func (c *controller) simpleWorkload(ctx context.Context, tenantID string, imageID string,
+	size string) (types.Workload, error) {
+	description := simpleWorkloadDescription(size, imageID)
+
+	wls, err := c.ds.GetTenantWorkloads(tenantID)
+	if err != nil {
+		return types.Workload{}, err
+	}
+
+	for _, wl := range wls {
+		if wl.Description == description && isSimpleWorkload(&wl) {
+			return wl, nil
+		}
+	}
+
+	profile := instanceSizes[size]
+	req := types.Workload{
+		TenantID:    tenantID,
+		Description: description,
+		FWType:      payloads.Legacy,
+		VMType:      payloads.QEMU,
+		Config:      simpleWorkloadConfig,
+		Visibility:  types.Private,
+		Requirements: payloads.WorkloadRequirements{
+			VCPUs: profile.vcpus,
+			MemMB: profile.memMB,
+		},
+		Storage: []types.StorageResource{
+			{
+				Bootable:   true,
+				Ephemeral:  true,
+				Size:       profile.diskGB,
+				SourceType: types.ImageService,
+				Source:     imageID,
+			},
+		},
+	}
+
+	return c.CreateWorkload(ctx, req)
+}
+
+// deleteSimpleWorkload deletes a workload synthesized for the instances
+// launched without a workload once it has no instances left.  The
+// simpleWorkloadLock must be held by the caller.
+func (c *controller) deleteSimpleWorkload(ctx context.Context, workloadID string) {
+	wl, err := c.ds.GetWorkload(workloadID)
+	if err != nil || !isSimpleWorkload(&wl) {
+		return
+	}
+
+	err = c.ds.DeleteWorkload(ctx, workloadID)
+	if err != nil && err != types.ErrWorkloadInUse {
+		glog.Warningf("Error deleting workload %s: %v", workloadID, err)
+	}
+}
+
+// releaseSimpleWorkload is called when an instance is removed to delete
+// the workload it was launched from if it was synthesized for it.
+func (c *controller) releaseSimpleWorkload(ctx context.Context, workloadID string) {
+	c.simpleWorkloadLock.Lock()
+	defer c.simpleWorkloadLock.Unlock()
+
+	c.deleteSimpleWorkload(ctx, workloadID)
+}
+
+// CreateSimpleServer launches an instance of an image without a workload.
+// The instance is launched from a private workload of the tenant which is
+// synthesized for the image and size profile requested.  The workload is
+// shared by the instances of the same image and size and is deleted along
+// with the last of them.
+func (c *controller) CreateSimpleServer(ctx context.Context, tenant string, req api.SimpleServerRequest) (interface{}, error) {
+	size := req.Size
+	if size == "" {
+		size = defaultInstanceSize
+	}
+
+	if _, ok := instanceSizes[size]; !ok {
+		return nil, types.ErrBadRequest
+	}
+
+	image, err := c.GetImage(ctx, tenant, req.ImageID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.simpleWorkloadLock.Lock()
+	defer c.simpleWorkloadLock.Unlock()
+
+	wl, err := c.simpleWorkload(ctx, tenant, image.ID, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var server api.CreateServerRequest
+	server.Server.Name = req.Name
+	server.Server.Image = image.ID
+	server.Server.WorkloadID = wl.ID
+	server.Server.MinInstances = 1
+
+	resp, err := c.CreateServer(ctx, tenant, server)
+	if err != nil {
+		c.deleteSimpleWorkload(ctx, wl.ID)
+		return nil, err
+	}
+
+	return resp, nil
+}
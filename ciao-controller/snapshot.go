@@ -0,0 +1,403 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// snapshotSetSnapshot is the snapshot of the volumes of the snapshot sets
+// the volumes of restored instances are cloned from.
+const snapshotSetSnapshot = "ciao-snapshot"
+
+// CreateSnapshotSet snapshots all the volumes of an instance at the same
+// time.  The filesystems of running VMs are frozen through their guest
+// agent while the snapshots are taken.  The snapshots are then cloned into
+// internal volumes owned by the set, which are flattened in the background
+// so that the set outlives the instance and its volumes.
+func (c *controller) CreateSnapshotSet(ctx context.Context, tenant string, ID string,
+	req types.SnapshotSetRequest) (types.SnapshotSet, error) {
+	if req.Name == "" {
+		return types.SnapshotSet{}, types.ErrBadName
+	}
+
+	i, err := c.ds.GetTenantInstance(tenant, ID)
+	if err != nil {
+		return types.SnapshotSet{}, err
+	}
+
+	if i.CNCI {
+		return types.SnapshotSet{}, types.ErrSnapshotNotSupported
+	}
+
+	i.StateLock.RLock()
+	state := i.State
+	i.StateLock.RUnlock()
+
+	if state != payloads.Running && state != payloads.Exited {
+		return types.SnapshotSet{}, types.ErrSnapshotInstanceState
+	}
+
+	if err := c.checkInstanceNotArchived(ID); err != nil {
+		return types.SnapshotSet{}, err
+	}
+
+	if _, ok := c.bootAttachment(ID); !ok {
+		return types.SnapshotSet{}, types.ErrNoBootVolume
+	}
+
+	set := types.SnapshotSet{
+		ID:         uuid.Generate().String(),
+		Name:       req.Name,
+		TenantID:   tenant,
+		InstanceID: i.ID,
+		WorkloadID: i.WorkloadID,
+		State:      types.SnapshotSetCreating,
+		Consistent: state == payloads.Exited,
+		CreateTime: time.Now(),
+	}
+	snapshot := "ciao-snapshot-" + set.ID
+
+	var volumes []types.Volume
+	for _, a := range c.ds.GetStorageAttachments(ID) {
+		vol, err := c.ds.GetBlockDevice(a.BlockID)
+		if err != nil {
+			return types.SnapshotSet{}, err
+		}
+		volumes = append(volumes, vol)
+
+		set.Volumes = append(set.Volumes, types.SnapshotVolume{
+			VolumeID:  vol.ID,
+			Boot:      a.Boot,
+			Ephemeral: a.Ephemeral,
+			Internal:  vol.Internal,
+			Size:      vol.Size,
+		})
+	}
+
+	if state == payloads.Running {
+		_, err = c.GuestAgentCommand(ctx, tenant, ID, types.GuestAgentRequest{Operation: types.GuestAgentFreeze})
+		if err != nil && !req.AllowInconsistent {
+			return types.SnapshotSet{}, errors.Wrap(err, "Error freezing instance filesystems")
+		}
+		set.Consistent = err == nil
+	}
+
+	err = c.snapshotVolumes(volumes, snapshot)
+
+	if state == payloads.Running && set.Consistent {
+		// the filesystems are thawed whatever happened to the request.
+		_, terr := c.GuestAgentCommand(context.Background(), tenant, ID,
+			types.GuestAgentRequest{Operation: types.GuestAgentThaw})
+		if terr != nil {
+			msg := fmt.Sprintf("Error thawing filesystems of instance %s: %v", ID, terr)
+			_ = c.ds.LogError(ctx, tenant, msg)
+		}
+	}
+
+	if err != nil {
+		return types.SnapshotSet{}, err
+	}
+
+	var clones []string
+	for k := range set.Volumes {
+		clone := types.BootClone{
+			VolumeID: set.Volumes[k].VolumeID,
+			Snapshot: snapshot,
+			Internal: true,
+		}
+
+		var vol types.Volume
+		vol, err = c.cloneVolumeSnapshot(ctx, tenant, clone, set.Volumes[k].Boot)
+		if err != nil {
+			break
+		}
+		clones = append(clones, vol.ID)
+		set.Volumes[k].ID = vol.ID
+	}
+
+	if err == nil {
+		err = c.ds.AddSnapshotSet(ctx, set)
+	}
+
+	if err != nil {
+		c.deleteVolumeClones(ctx, clones)
+		c.deleteVolumeSnapshots(volumes, snapshot)
+		return types.SnapshotSet{}, errors.Wrap(err, "Error creating snapshot set")
+	}
+
+	go c.completeSnapshotSet(set, snapshot)
+
+	msg := fmt.Sprintf("Snapshot set %s (%s) of instance %s created", set.ID, set.Name, ID)
+	_ = c.ds.LogEvent(ctx, tenant, msg)
+
+	return set, nil
+}
+
+// snapshotVolumes creates a snapshot of each volume.  The snapshots already
+// created are deleted if a snapshot cannot be created.
+func (c *controller) snapshotVolumes(volumes []types.Volume, snapshot string) error {
+	for k, vol := range volumes {
+		driver, err := c.volumeDriver(vol.Class)
+		if err == nil {
+			err = driver.CreateBlockDeviceSnapshot(vol.ID, snapshot)
+		}
+
+		if err != nil {
+			c.deleteVolumeSnapshots(volumes[:k], snapshot)
+			return errors.Wrapf(err, "Error creating snapshot of volume %s", vol.ID)
+		}
+	}
+
+	return nil
+}
+
+func (c *controller) deleteVolumeSnapshots(volumes []types.Volume, snapshot string) {
+	for _, vol := range volumes {
+		driver, err := c.volumeDriver(vol.Class)
+		if err == nil {
+			err = driver.DeleteBlockDeviceSnapshot(vol.ID, snapshot)
+		}
+
+		if err != nil {
+			glog.Warningf("Error deleting snapshot %s of volume %s: %v", snapshot, vol.ID, err)
+		}
+	}
+}
+
+// completeSnapshotSet flattens the volumes of a new snapshot set, deletes
+// the snapshots of the instance volumes they were cloned from and
+// snapshots them for instances to be restored from clones of them.
+func (c *controller) completeSnapshotSet(set types.SnapshotSet, snapshot string) {
+	set.State = types.SnapshotSetAvailable
+
+	for _, v := range set.Volumes {
+		vol, err := c.ds.GetBlockDevice(v.ID)
+		if err != nil {
+			glog.Errorf("Error completing snapshot set %s: %v", set.ID, err)
+			set.State = types.SnapshotSetError
+			break
+		}
+
+		driver, err := c.volumeDriver(vol.Class)
+		if err == nil {
+			err = driver.FlattenBlockDevice(vol.ID)
+		}
+		if err == nil {
+			err = driver.DeleteBlockDeviceSnapshot(v.VolumeID, snapshot)
+		}
+		if err == nil {
+			err = driver.CreateBlockDeviceSnapshot(vol.ID, snapshotSetSnapshot)
+		}
+
+		if err != nil {
+			glog.Errorf("Error completing snapshot set %s with volume %s: %v", set.ID, vol.ID, err)
+			set.State = types.SnapshotSetError
+			break
+		}
+	}
+
+	ctx := context.Background()
+	if err := c.ds.UpdateSnapshotSet(ctx, set); err != nil {
+		glog.Errorf("Error updating snapshot set %s: %v", set.ID, err)
+		return
+	}
+
+	if set.State == types.SnapshotSetError {
+		msg := fmt.Sprintf("Error creating snapshot set %s of instance %s", set.ID, set.InstanceID)
+		_ = c.ds.LogError(ctx, set.TenantID, msg)
+	}
+}
+
+// ListSnapshotSets returns the snapshot sets of a tenant.
+func (c *controller) ListSnapshotSets(ctx context.Context, tenant string) ([]types.SnapshotSet, error) {
+	return c.ds.GetSnapshotSets(ctx, tenant)
+}
+
+// ShowSnapshotSet returns a snapshot set of a tenant.
+func (c *controller) ShowSnapshotSet(ctx context.Context, tenant string, ID string) (types.SnapshotSet, error) {
+	return c.ds.GetSnapshotSet(ctx, tenant, ID)
+}
+
+// DeleteSnapshotSet deletes a snapshot set and its volumes.  Sets cannot
+// be deleted while they are created, nor while the volumes of the instances
+// restored from them are being flattened.
+func (c *controller) DeleteSnapshotSet(ctx context.Context, tenant string, ID string) error {
+	set, err := c.ds.GetSnapshotSet(ctx, tenant, ID)
+	if err != nil {
+		return err
+	}
+
+	if set.State == types.SnapshotSetCreating {
+		return types.ErrSnapshotSetNotAvailable
+	}
+
+	if set.State == types.SnapshotSetAvailable {
+		var volumes []types.Volume
+		for _, v := range set.Volumes {
+			vol, err := c.ds.GetBlockDevice(v.ID)
+			if err != nil {
+				return err
+			}
+			volumes = append(volumes, vol)
+		}
+
+		for _, vol := range volumes {
+			driver, err := c.volumeDriver(vol.Class)
+			if err == nil {
+				err = driver.DeleteBlockDeviceSnapshot(vol.ID, snapshotSetSnapshot)
+			}
+			if err != nil {
+				return errors.Wrapf(err, "Error deleting snapshot of volume %s", vol.ID)
+			}
+		}
+	}
+
+	var volumes []string
+	for _, v := range set.Volumes {
+		if v.ID != "" {
+			volumes = append(volumes, v.ID)
+		}
+	}
+	c.deleteVolumeClones(ctx, volumes)
+
+	// the snapshots of the instance volumes are left behind by the sets
+	// which could not be completed.
+	if set.State == types.SnapshotSetError {
+		for _, v := range set.Volumes {
+			vol, err := c.ds.GetBlockDevice(v.VolumeID)
+			if err == nil {
+				c.deleteVolumeSnapshots([]types.Volume{vol}, "ciao-snapshot-"+set.ID)
+			}
+		}
+	}
+
+	err = c.ds.DeleteSnapshotSet(ctx, ID)
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("Snapshot set %s (%s) deleted", set.ID, set.Name)
+	_ = c.ds.LogEvent(ctx, tenant, msg)
+
+	return nil
+}
+
+// RestoreSnapshotSet creates an instance of the workload of the instance
+// a snapshot set was taken of, with copy-on-write clones of the volumes of
+// the set.  The clones are flattened in the background.
+func (c *controller) RestoreSnapshotSet(ctx context.Context, tenant string, ID string,
+	req types.SnapshotSetRestoreRequest) (api.Servers, error) {
+	var servers api.Servers
+
+	set, err := c.ds.GetSnapshotSet(ctx, tenant, ID)
+	if err != nil {
+		return servers, err
+	}
+
+	if set.State != types.SnapshotSetAvailable {
+		return servers, types.ErrSnapshotSetNotAvailable
+	}
+
+	w := types.WorkloadRequest{
+		WorkloadID:   set.WorkloadID,
+		TenantID:     tenant,
+		Instances:    1,
+		TraceLabel:   c.activeTraceLabel(),
+		Name:         req.Name,
+		VolumeClones: []types.BootClone{},
+	}
+
+	for _, v := range set.Volumes {
+		clone := types.BootClone{
+			VolumeID:  v.ID,
+			Snapshot:  snapshotSetSnapshot,
+			Ephemeral: v.Ephemeral,
+			Internal:  v.Internal,
+		}
+
+		if v.Boot {
+			w.BootClone = &clone
+		} else {
+			w.VolumeClones = append(w.VolumeClones, clone)
+		}
+	}
+
+	if w.BootClone == nil {
+		return servers, types.ErrSnapshotSetNoBootVolume
+	}
+
+	instances, err := c.startWorkload(ctx, w)
+
+	var clones []string
+	for _, instance := range instances {
+		for _, a := range c.ds.GetStorageAttachments(instance.ID) {
+			clones = append(clones, a.BlockID)
+		}
+
+		server, serr := instanceToServer(c, instance)
+		if serr != nil && err == nil {
+			err = serr
+		}
+		servers.Servers = append(servers.Servers, server)
+	}
+	servers.TotalServers = len(servers.Servers)
+
+	go c.flattenVolumes(clones)
+
+	if err != nil {
+		_ = c.ds.LogError(ctx, tenant, fmt.Sprintf("Error restoring snapshot set %s: %v", ID, err))
+		if len(servers.Servers) == 0 {
+			return servers, err
+		}
+	}
+
+	for _, server := range servers.Servers {
+		msg := fmt.Sprintf("Restored snapshot set %s (%s) as instance %s", set.ID, set.Name, server.ID)
+		_ = c.ds.LogEvent(ctx, tenant, msg)
+	}
+
+	return servers, nil
+}
+
+// flattenVolumes makes volume clones independent from the snapshot they
+// were cloned from.
+func (c *controller) flattenVolumes(volumeIDs []string) {
+	for _, ID := range volumeIDs {
+		vol, err := c.ds.GetBlockDevice(ID)
+		if err != nil {
+			glog.Warningf("Error flattening volume %s: %v", ID, err)
+			continue
+		}
+
+		driver, err := c.volumeDriver(vol.Class)
+		if err == nil {
+			err = driver.FlattenBlockDevice(vol.ID)
+		}
+
+		if err != nil {
+			glog.Warningf("Error flattening volume %s: %v", ID, err)
+		}
+	}
+}
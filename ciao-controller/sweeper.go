@@ -0,0 +1,110 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/ssntp"
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+var instanceTimeout = flag.Duration("instance_timeout", 10*time.Minute, "Time an instance may remain pending before it is considered failed, 0 disables the timeout")
+var sweepInterval = flag.Duration("instance_sweep_interval", time.Minute, "Interval between checks for instances stuck in the pending state")
+
+func (c *controller) instanceSweeper(stop <-chan struct{}) {
+	ctx := context.Background()
+
+	if *instanceTimeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(*sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.sweepInstances(ctx, c.ds.GetPendingInstances(), time.Now(), *instanceTimeout)
+		}
+	}
+}
+
+// sweepInstances fails the instances that have been pending for longer
+// than timeout.
+func (c *controller) sweepInstances(ctx context.Context, instances []types.PendingInstance, now time.Time, timeout time.Duration) {
+	for _, i := range instances {
+		if now.Sub(i.PendingTime) < timeout {
+			continue
+		}
+
+		c.instanceTimedOut(ctx, i, timeout)
+	}
+}
+
+// instanceTimedOut handles an instance that has been pending for too long.
+// Instances that are being launched are treated as if the launcher had
+// reported a fatal start failure, which deletes them and releases their
+// resources.  Instances that are being restarted, or whose launch time is
+// unknown because the controller restarted, are marked as exited so that
+// they can be restarted or deleted by their owner.
+func (c *controller) instanceTimedOut(ctx context.Context, i types.PendingInstance, timeout time.Duration) {
+	glog.Warningf("Instance %s pending for more than %v", i.ID, timeout)
+
+	if i.PendingTime.After(i.CreateTime) {
+		c.ds.SetInstanceActionCause(i.ID, types.InitiatorSystem, types.ReasonLaunchTimeout)
+		err := c.ds.InstanceStopped(ctx, i.ID)
+		if err != nil {
+			glog.Warningf("Error marking instance %s as exited: %v", i.ID, err)
+			return
+		}
+
+		msg := fmt.Sprintf("Restart Failure %s: %s", i.ID, payloads.StartFailureReason(payloads.LaunchTimeout))
+		_ = c.ds.LogError(ctx, i.TenantID, msg)
+		return
+	}
+
+	nodeID := i.NodeID
+	failure := payloads.ErrorStartFailure{
+		NodeUUID:     nodeID,
+		InstanceUUID: i.ID,
+		Reason:       payloads.LaunchTimeout,
+	}
+
+	payload, err := yaml.Marshal(&failure)
+	if err != nil {
+		glog.Warningf("Error marshalling start failure: %v", err)
+		return
+	}
+
+	c.client.ErrorNotify(ssntp.StartFailure, &ssntp.Frame{Payload: payload})
+
+	// clean up anything the launcher may have created for the instance.
+	if nodeID != "" {
+		go func() {
+			if err := c.client.DeleteInstance(i.ID, nodeID); err != nil {
+				glog.Warningf("Error deleting timed out instance: %v", err)
+			}
+		}()
+	}
+}
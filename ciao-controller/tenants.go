@@ -15,16 +15,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
 	"github.com/ciao-project/ciao/uuid"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 )
 
-func (c *controller) ListTenants() ([]types.TenantSummary, error) {
+func (c *controller) ListTenants(ctx context.Context) ([]types.TenantSummary, error) {
 	var summary []types.TenantSummary
 
 	tenants, err := c.ds.GetAllTenants()
@@ -55,10 +58,110 @@ func (c *controller) ListTenants() ([]types.TenantSummary, error) {
 	return summary, nil
 }
 
-func (c *controller) ShowTenant(tenantID string) (types.TenantConfig, error) {
+// tenantSummaryEvents is the number of events of a tenant returned in its
+// usage summary.
+const tenantSummaryEvents = 20
+
+// ShowTenantUsage returns the number of instances, volumes, images and
+// external IPs of a tenant along with its quotas and its most recent
+// events, so that the usage of a tenant can be checked in one call.
+func (c *controller) ShowTenantUsage(ctx context.Context, tenantID string) (types.TenantUsageSummary, error) {
+	summary := types.TenantUsageSummary{
+		ID:             tenantID,
+		InstanceStates: make(map[string]int),
+		ExternalIPs:    []types.MappedIP{},
+		Events:         []types.CiaoEvent{},
+	}
+
+	tenant, err := c.ds.GetTenant(ctx, tenantID)
+	if err != nil {
+		return summary, err
+	}
+	if tenant == nil {
+		return summary, types.ErrTenantNotFound
+	}
+	summary.Name = tenant.Name
+
+	instances, err := c.ds.GetAllInstancesFromTenant(tenantID)
+	if err != nil {
+		return summary, err
+	}
+
+	for _, i := range instances {
+		if i.CNCI {
+			continue
+		}
+
+		i.StateLock.RLock()
+		summary.InstanceStates[i.State]++
+		i.StateLock.RUnlock()
+		summary.Instances++
+	}
+
+	volumes, err := c.ds.GetBlockDevices(tenantID)
+	if err != nil {
+		return summary, err
+	}
+
+	for _, v := range volumes {
+		if v.Internal {
+			continue
+		}
+
+		summary.Volumes++
+		summary.VolumeSizeGB += v.Size
+	}
+
+	images, err := c.ds.GetImages(tenantID, false)
+	if err != nil {
+		return summary, err
+	}
+
+	for _, i := range images {
+		if i.TenantID != tenantID {
+			continue
+		}
+
+		summary.Images++
+		summary.ImageSize += i.Size
+	}
+
+	summary.ExternalIPs = append(summary.ExternalIPs, c.ds.GetMappedIPs(&tenantID)...)
+	summary.Quotas = c.qs.DumpQuotas(tenantID)
+
+	logs, err := c.ds.GetEventLog(ctx)
+	if err != nil {
+		return summary, err
+	}
+
+	for _, l := range logs {
+		if l.TenantID != tenantID {
+			continue
+		}
+
+		summary.Events = append(summary.Events, types.CiaoEvent{
+			Timestamp: l.Timestamp,
+			TenantID:  l.TenantID,
+			EventType: l.EventType,
+			Message:   l.Message,
+		})
+	}
+
+	sort.SliceStable(summary.Events, func(i, j int) bool {
+		return summary.Events[i].Timestamp.After(summary.Events[j].Timestamp)
+	})
+
+	if len(summary.Events) > tenantSummaryEvents {
+		summary.Events = summary.Events[:tenantSummaryEvents]
+	}
+
+	return summary, nil
+}
+
+func (c *controller) ShowTenant(ctx context.Context, tenantID string) (types.TenantConfig, error) {
 	var config types.TenantConfig
 
-	tenant, err := c.ds.GetTenant(tenantID)
+	tenant, err := c.ds.GetTenant(ctx, tenantID)
 	if err != nil {
 		return config, err
 	}
@@ -66,12 +169,114 @@ func (c *controller) ShowTenant(tenantID string) (types.TenantConfig, error) {
 	return tenant.TenantConfig, err
 }
 
-func (c *controller) PatchTenant(tenantID string, patch []byte) error {
+// PatchTenant applies a JSON merge patch to the configuration of a tenant
+// and returns its configuration before and after the update.
+func (c *controller) PatchTenant(ctx context.Context, tenantID string, patch []byte) (types.TenantUpdateResponse, error) {
+	var resp types.TenantUpdateResponse
+
+	tenant, err := c.ds.GetTenant(ctx, tenantID)
+	if err != nil {
+		return resp, err
+	}
+	if tenant == nil {
+		return resp, types.ErrTenantNotFound
+	}
+
+	resp.Previous = tenant.TenantConfig
+
 	// we need to update through datastore.
-	return c.ds.JSONPatchTenant(tenantID, patch)
+	err = c.ds.JSONPatchTenant(ctx, tenantID, patch)
+	if err != nil {
+		return resp, err
+	}
+
+	tenant, err = c.ds.GetTenant(ctx, tenantID)
+	if err != nil {
+		return resp, err
+	}
+
+	resp.Config = tenant.TenantConfig
+
+	if resp.Config.CNCIFlavor != resp.Previous.CNCIFlavor {
+		return resp, c.resizeCNCIs(ctx, tenantID)
+	}
+
+	return resp, nil
 }
 
-func (c *controller) CreateTenant(tenantID string, config types.TenantConfig) (types.TenantSummary, error) {
+// resizeCNCIs stops the running CNCIs of a tenant so that they are
+// restarted with its new CNCI flavor, as CNCIs are restarted as soon as
+// they are reported as stopped.  The traffic of the tenant is interrupted
+// until they are active again.  The boot volumes of existing CNCIs are
+// not resized, the disk size of the flavor only applies to new CNCIs.
+func (c *controller) resizeCNCIs(ctx context.Context, tenantID string) error {
+	cncis, err := c.ds.GetTenantCNCIs(tenantID)
+	if err != nil {
+		return errors.Wrap(err, "Unable to resize tenant CNCIs")
+	}
+
+	resized := 0
+	for _, i := range cncis {
+		i.StateLock.RLock()
+		state := i.State
+		nodeID := i.NodeID
+		i.StateLock.RUnlock()
+
+		// CNCIs which are not running pick up the flavor when they
+		// are next started.
+		if state != payloads.Running || nodeID == "" {
+			continue
+		}
+
+		c.ds.SetInstanceActionCause(i.ID, types.InitiatorSystem, types.ReasonCNCIResize)
+
+		go func(ID string, nodeID string) {
+			if err := c.client.StopInstance(ID, nodeID); err != nil {
+				glog.Warningf("Error stopping CNCI for resize: %v", err)
+			}
+		}(i.ID, nodeID)
+		resized++
+	}
+
+	msg := fmt.Sprintf("Restarting %d CNCIs to apply CNCI flavor", resized)
+	_ = c.ds.LogEvent(ctx, tenantID, msg)
+
+	return nil
+}
+
+// RenumberTenant changes the subnet size of a tenant which has instances.
+// The addresses of the instances and reservations which become unusable
+// with the new size are reported.  These instances must be recreated.
+func (c *controller) RenumberTenant(ctx context.Context, tenantID string, req types.TenantRenumberRequest) (types.TenantRenumbering, error) {
+	tenant, err := c.ds.GetTenant(ctx, tenantID)
+	if err != nil {
+		return types.TenantRenumbering{}, err
+	}
+	if tenant == nil {
+		return types.TenantRenumbering{}, types.ErrTenantNotFound
+	}
+
+	result := types.TenantRenumbering{
+		OldSubnetBits: tenant.SubnetBits,
+		SubnetBits:    req.SubnetBits,
+		DryRun:        req.DryRun,
+	}
+
+	result.Conflicts, err = c.ds.RenumberTenant(ctx, tenantID, req.SubnetBits, req.DryRun)
+	if err != nil {
+		return types.TenantRenumbering{}, err
+	}
+
+	if !req.DryRun && req.SubnetBits != result.OldSubnetBits {
+		msg := fmt.Sprintf("Renumbered tenant network from /%d to /%d subnets, %d conflicting addresses",
+			result.OldSubnetBits, req.SubnetBits, len(result.Conflicts))
+		_ = c.ds.LogEvent(ctx, tenantID, msg)
+	}
+
+	return result, nil
+}
+
+func (c *controller) CreateTenant(ctx context.Context, tenantID string, config types.TenantConfig) (types.TenantSummary, error) {
 	// tenant ID must be a UUID4
 	tuuid, err := uuid.Parse(tenantID)
 	if err != nil {
@@ -83,16 +288,16 @@ func (c *controller) CreateTenant(tenantID string, config types.TenantConfig) (t
 		config.SubnetBits = 24
 	} else {
 		if config.SubnetBits < 12 || config.SubnetBits > 30 {
-			return types.TenantSummary{}, errors.New("subnet bits must be between 12 and 30")
+			return types.TenantSummary{}, types.ErrInvalidSubnetBits
 		}
 	}
 
-	tenant, err := c.ds.AddTenant(tuuid.String(), config)
+	tenant, err := c.ds.AddTenant(ctx, tuuid.String(), config)
 	if err != nil {
 		return types.TenantSummary{}, err
 	}
 
-	tenant.CNCIctrl, err = newCNCIManager(c, tenantID)
+	err = c.prepareTenant(ctx, tenant.ID)
 	if err != nil {
 		return types.TenantSummary{}, err
 	}
@@ -112,9 +317,9 @@ func (c *controller) CreateTenant(tenantID string, config types.TenantConfig) (t
 	return ts, nil
 }
 
-func (c *controller) deleteCNCIInstances(tenantID string) error {
+func (c *controller) deleteCNCIInstances(ctx context.Context, tenantID string) error {
 	// We need to explicitly delete all CNCIs synchronously
-	tenant, err := c.ds.GetTenant(tenantID)
+	tenant, err := c.ds.GetTenant(ctx, tenantID)
 	if err != nil {
 		return errors.Wrap(err, "Unable to remove tenant")
 	}
@@ -149,11 +354,11 @@ func (c *controller) deleteCNCIInstances(tenantID string) error {
 	return nil
 }
 
-func (c *controller) deleteInstances(tenantID string) error {
+func (c *controller) deleteInstances(ctx context.Context, tenantID string) error {
 	// remove any external IPs
-	ips := c.ListMappedAddresses(&tenantID)
+	ips := c.ListMappedAddresses(ctx, &tenantID)
 	for _, addr := range ips {
-		err := c.UnMapAddress(addr.ExternalIP)
+		err := c.UnMapAddress(ctx, addr.ExternalIP)
 		if err != nil {
 			return errors.Wrap(err, "Unable to remove tenant")
 		}
@@ -170,7 +375,7 @@ func (c *controller) deleteInstances(tenantID string) error {
 	for _, i := range instances {
 		wg.Add(1)
 		go func(ID string) {
-			err := c.deleteInstanceSync(ID)
+			err := c.deleteInstanceSync(ctx, ID)
 			if err != nil {
 				// remove directly.
 				c.client.RemoveInstance(ID)
@@ -190,13 +395,13 @@ func (c *controller) deleteInstances(tenantID string) error {
 // revoked the tenant's certificate. So no more
 // activity can happen for this tenant while this
 // command is going.
-func (c *controller) DeleteTenant(tenantID string) error {
-	err := c.deleteInstances(tenantID)
+func (c *controller) DeleteTenant(ctx context.Context, tenantID string) error {
+	err := c.deleteInstances(ctx, tenantID)
 	if err != nil {
 		return err
 	}
 
-	err = c.deleteCNCIInstances(tenantID)
+	err = c.deleteCNCIInstances(ctx, tenantID)
 	if err != nil {
 		return err
 	}
@@ -208,7 +413,7 @@ func (c *controller) DeleteTenant(tenantID string) error {
 	}
 
 	for _, w := range workloads {
-		err := c.DeleteWorkload(tenantID, w.ID)
+		err := c.DeleteWorkload(ctx, tenantID, w.ID)
 		if err != nil {
 			return errors.Wrap(err, "Unable to remove tenant")
 		}
@@ -224,7 +429,7 @@ func (c *controller) DeleteTenant(tenantID string) error {
 		if i.Visibility == types.Public {
 			continue
 		}
-		err := c.DeleteImage(tenantID, i.ID)
+		err := c.DeleteImage(ctx, tenantID, i.ID)
 		if err != nil {
 			return errors.Wrap(err, "Unable to remove tenant")
 		}
@@ -237,14 +442,33 @@ func (c *controller) DeleteTenant(tenantID string) error {
 	}
 
 	for _, bd := range bds {
-		err := c.DeleteBlockDevice(bd.ID)
+		err := c.deleteVolumeBlockDevice(ctx, bd)
 		if err != nil {
 			return errors.Wrap(err, "Unable to remove tenant")
 		}
 	}
 
+	// revoke the API tokens of this tenant.
+	tokens, err := c.ds.GetAPITokens(ctx, tenantID)
+	if err != nil {
+		return errors.Wrap(err, "Unable to remove tenant")
+	}
+
+	for _, t := range tokens {
+		err := c.ds.DeleteAPIToken(ctx, t.ID)
+		if err != nil {
+			return errors.Wrap(err, "Unable to remove tenant")
+		}
+	}
+
+	// the nodes dedicated to this tenant are shared again.
+	err = c.releaseDedicatedNodes(ctx, tenantID)
+	if err != nil {
+		return errors.Wrap(err, "Unable to remove tenant")
+	}
+
 	c.qs.DeleteTenant(tenantID)
 
 	// quotas get deleted from database as side effect to deleting tenant
-	return c.ds.DeleteTenant(tenantID)
+	return c.ds.DeleteTenant(ctx, tenantID)
 }
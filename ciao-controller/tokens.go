@@ -0,0 +1,171 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/pkg/errors"
+)
+
+var apiTokenExpiry = flag.Duration("api_token_expiry", 30*24*time.Hour, "Default lifetime of API tokens")
+var apiTokenMaxExpiry = flag.Duration("api_token_max_expiry", 365*24*time.Hour, "Maximum lifetime of API tokens")
+
+// apiTokenSecretSize is the number of random bytes in the secret of an API
+// token.
+const apiTokenSecretSize = 32
+
+// errInvalidAPIToken is returned when an API token is unknown, malformed or
+// expired. The cause is not reported to the client.
+var errInvalidAPIToken = errors.New("Invalid API token")
+
+func hashAPITokenSecret(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+// ListAPITokens returns the API tokens issued to a tenant, without their
+// secrets.
+func (c *controller) ListAPITokens(ctx context.Context, tenantID string) ([]types.APIToken, error) {
+	if err := c.checkTenant(ctx, tenantID); err != nil {
+		return nil, err
+	}
+
+	tokens, err := c.ds.GetAPITokens(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range tokens {
+		tokens[i].Hash = nil
+	}
+
+	return tokens, nil
+}
+
+// CreateAPIToken issues an API token to a tenant. The returned token is the
+// only copy of its secret, the controller only stores a hash of it.
+func (c *controller) CreateAPIToken(ctx context.Context, tenantID string, req types.APITokenRequest) (types.APIToken, error) {
+	if req.Scope != types.APITokenReadOnly && req.Scope != types.APITokenFull {
+		return types.APIToken{}, types.ErrBadRequest
+	}
+
+	expiry := *apiTokenExpiry
+	if req.Expiry != "" {
+		var err error
+		expiry, err = time.ParseDuration(req.Expiry)
+		if err != nil {
+			return types.APIToken{}, types.ErrBadRequest
+		}
+	}
+
+	if expiry <= 0 || expiry > *apiTokenMaxExpiry {
+		return types.APIToken{}, types.ErrBadRequest
+	}
+
+	if err := c.checkTenant(ctx, tenantID); err != nil {
+		return types.APIToken{}, err
+	}
+
+	buf := make([]byte, apiTokenSecretSize)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return types.APIToken{}, errors.Wrap(err, "Error generating API token")
+	}
+	secret := hex.EncodeToString(buf)
+
+	now := time.Now().UTC()
+	token := types.APIToken{
+		ID:         uuid.Generate().String(),
+		TenantID:   tenantID,
+		Name:       req.Name,
+		Scope:      req.Scope,
+		CreateTime: now,
+		ExpiryTime: now.Add(expiry),
+		Hash:       hashAPITokenSecret(secret),
+	}
+
+	err := c.ds.AddAPIToken(ctx, token)
+	if err != nil {
+		return types.APIToken{}, err
+	}
+
+	msg := fmt.Sprintf("Issued %s API token %s expiring %s", token.Scope, token.ID,
+		token.ExpiryTime.Format(time.RFC3339))
+	_ = c.ds.LogEvent(ctx, tenantID, msg)
+
+	token.Hash = nil
+	token.Token = token.ID + "." + secret
+
+	return token, nil
+}
+
+// DeleteAPIToken revokes an API token of a tenant.
+func (c *controller) DeleteAPIToken(ctx context.Context, tenantID string, tokenID string) error {
+	token, err := c.ds.GetAPIToken(ctx, tokenID)
+	if err != nil {
+		return err
+	}
+
+	if token.TenantID != tenantID {
+		return types.ErrAPITokenNotFound
+	}
+
+	err = c.ds.DeleteAPIToken(ctx, tokenID)
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("Revoked API token %s", tokenID)
+	_ = c.ds.LogEvent(ctx, tenantID, msg)
+
+	return nil
+}
+
+// authenticateAPIToken returns the API token matching a bearer token
+// presented by a client.
+func (c *controller) authenticateAPIToken(ctx context.Context, bearer string) (types.APIToken, error) {
+	parts := strings.SplitN(bearer, ".", 2)
+	if len(parts) != 2 {
+		return types.APIToken{}, errInvalidAPIToken
+	}
+
+	token, err := c.ds.GetAPIToken(ctx, parts[0])
+	if err == types.ErrAPITokenNotFound {
+		return types.APIToken{}, errInvalidAPIToken
+	} else if err != nil {
+		return types.APIToken{}, err
+	}
+
+	if subtle.ConstantTimeCompare(token.Hash, hashAPITokenSecret(parts[1])) != 1 {
+		return types.APIToken{}, errInvalidAPIToken
+	}
+
+	if !time.Now().Before(token.ExpiryTime) {
+		return types.APIToken{}, errInvalidAPIToken
+	}
+
+	return token, nil
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/service"
+	"github.com/gorilla/mux"
+)
+
+func TestAPITokens(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	badRequests := []types.APITokenRequest{
+		{Name: "ci", Scope: "admin"},
+		{Name: "ci", Scope: types.APITokenFull, Expiry: "forever"},
+		{Name: "ci", Scope: types.APITokenFull, Expiry: "-1h"},
+		{Name: "ci", Scope: types.APITokenFull, Expiry: (*apiTokenMaxExpiry + time.Hour).String()},
+	}
+
+	for _, req := range badRequests {
+		_, err = ctl.CreateAPIToken(ctx, tenant.ID, req)
+		if err != types.ErrBadRequest {
+			t.Fatalf("Expected %v for %+v, got %v", types.ErrBadRequest, req, err)
+		}
+	}
+
+	token, err := ctl.CreateAPIToken(ctx, tenant.ID, types.APITokenRequest{
+		Name:  "ci",
+		Scope: types.APITokenReadOnly,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token.Token == "" || token.Hash != nil ||
+		!token.ExpiryTime.Equal(token.CreateTime.Add(*apiTokenExpiry)) {
+		t.Fatalf("Unexpected API token %+v", token)
+	}
+
+	tokens, err := ctl.ListAPITokens(ctx, tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tokens) != 1 || tokens[0].ID != token.ID || tokens[0].Token != "" || tokens[0].Hash != nil {
+		t.Fatalf("Unexpected API tokens %+v", tokens)
+	}
+
+	authenticated, err := ctl.authenticateAPIToken(ctx, token.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if authenticated.TenantID != tenant.ID || authenticated.Scope != types.APITokenReadOnly {
+		t.Fatalf("Unexpected authenticated API token %+v", authenticated)
+	}
+
+	invalid := []string{
+		"",
+		token.ID,
+		token.ID + ".secret",
+		"missing." + token.Token[len(token.ID)+1:],
+	}
+
+	for _, bearer := range invalid {
+		_, err = ctl.authenticateAPIToken(ctx, bearer)
+		if err != errInvalidAPIToken {
+			t.Fatalf("Expected %v for %q, got %v", errInvalidAPIToken, bearer, err)
+		}
+	}
+
+	other, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.DeleteAPIToken(ctx, other.ID, token.ID)
+	if err != types.ErrAPITokenNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrAPITokenNotFound, err)
+	}
+
+	err = ctl.DeleteAPIToken(ctx, tenant.ID, token.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ctl.authenticateAPIToken(ctx, token.Token)
+	if err != errInvalidAPIToken {
+		t.Fatalf("Expected revoked token to be invalid, got %v", err)
+	}
+}
+
+func TestAPITokenExpiry(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := ctl.CreateAPIToken(ctx, tenant.ID, types.APITokenRequest{
+		Name:   "ci",
+		Scope:  types.APITokenFull,
+		Expiry: "1h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := ctl.ds.GetAPIToken(ctx, token.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.ds.DeleteAPIToken(ctx, token.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored.ExpiryTime = time.Now().Add(-time.Minute)
+	err = ctl.ds.AddAPIToken(ctx, stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ctl.authenticateAPIToken(ctx, token.Token)
+	if err != errInvalidAPIToken {
+		t.Fatalf("Expected expired token to be invalid, got %v", err)
+	}
+}
+
+func TestAPITokenAuthHandler(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readOnly, err := ctl.CreateAPIToken(ctx, tenant.ID, types.APITokenRequest{
+		Name:  "monitoring",
+		Scope: types.APITokenReadOnly,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	full, err := ctl.CreateAPIToken(ctx, tenant.ID, types.APITokenRequest{
+		Name:  "ci",
+		Scope: types.APITokenFull,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenID, ok := service.GetAPIToken(r.Context())
+		if !ok || tokenID == "" || service.GetPrivilege(r.Context()) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	r := mux.NewRouter()
+	r.Handle("/{tenant}/instances", &clientCertAuthHandler{Controller: ctl, Next: next})
+
+	tests := []struct {
+		method   string
+		tenantID string
+		auth     string
+		expected int
+	}{
+		{"GET", tenant.ID, "", http.StatusUnauthorized},
+		{"GET", tenant.ID, "Bearer " + readOnly.ID + ".secret", http.StatusUnauthorized},
+		{"GET", tenant.ID, "Bearer " + readOnly.Token, http.StatusOK},
+		{"POST", tenant.ID, "Bearer " + readOnly.Token, http.StatusForbidden},
+		{"GET", other.ID, "Bearer " + readOnly.Token, http.StatusUnauthorized},
+		{"POST", tenant.ID, "Bearer " + full.Token, http.StatusOK},
+		{"DELETE", other.ID, "Bearer " + full.Token, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, "/"+tt.tenantID+"/instances", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.TLS = &tls.ConnectionState{}
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		if rr.Code != tt.expected {
+			t.Errorf("%s %s with %q: got %d, expected %d", tt.method, tt.tenantID,
+				tt.auth, rr.Code, tt.expected)
+		}
+	}
+}
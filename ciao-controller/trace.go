@@ -0,0 +1,49 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/golang/glog"
+)
+
+// activeTraceLabel returns the label instance launches which do not
+// specify one are traced with. No launches are traced if it is empty.
+func (c *controller) activeTraceLabel() string {
+	c.traceLock.Lock()
+	defer c.traceLock.Unlock()
+
+	return c.traceLabel
+}
+
+// startTracing traces all subsequent instance launches with label, until
+// tracing is stopped.
+func (c *controller) startTracing(label string) {
+	c.traceLock.Lock()
+	defer c.traceLock.Unlock()
+
+	c.traceLabel = label
+	glog.Infof("Tracing instance launches with label %s", label)
+}
+
+// stopTracing stops tracing instance launches which do not specify a label.
+func (c *controller) stopTracing() {
+	c.traceLock.Lock()
+	defer c.traceLock.Unlock()
+
+	if c.traceLabel != "" {
+		glog.Infof("Stopped tracing instance launches with label %s", c.traceLabel)
+	}
+	c.traceLabel = ""
+}
@@ -17,8 +17,10 @@
 package types
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 	"sync"
@@ -73,8 +75,17 @@ type StorageResource struct {
 	// Tag is a piece of abitrary search/sort identifier text
 	Tag string
 
+	// Class is the storage class of the storage to be created if new.
+	// The default storage pool is used when it is empty.
+	Class string `json:"class,omitempty"`
+
 	// Internal indicates whether this storage should be shown to the user
 	Internal bool
+
+	// CDROM indicates that the storage is attached to the instance as a
+	// read-only CD-ROM drive, such as installer media from the image
+	// service, rather than as a disk.
+	CDROM bool `json:"cdrom,omitempty"`
 }
 
 // Workload contains resource and configuration information for a user
@@ -89,7 +100,19 @@ type Workload struct {
 	Config       string                        `json:"config"`
 	Storage      []StorageResource             `json:"storage"`
 	Visibility   Visibility                    `json:"visibility"`
+	Category     string                        `json:"category,omitempty"`
 	Requirements payloads.WorkloadRequirements `json:"workload_requirements"`
+	Autoscale    *AutoscalePolicy              `json:"autoscale,omitempty"`
+	Secrets      []string                      `json:"secrets,omitempty"`
+}
+
+// AutoscalePolicy describes the band of instance counts the controller
+// maintains for a workload and the average CPU utilization, in percent,
+// it aims for within that band.
+type AutoscalePolicy struct {
+	MinInstances int `json:"min_instances"`
+	MaxInstances int `json:"max_instances"`
+	CPUTarget    int `json:"cpu_target"`
 }
 
 // WorkloadResponse will be returned from /workloads apis
@@ -102,12 +125,36 @@ type WorkloadResponse struct {
 // WorkloadRequest contains resource and configuration for a user
 // workload.
 type WorkloadRequest struct {
-	WorkloadID string
-	TenantID   string
-	Instances  int
-	TraceLabel string
-	Name       string
-	Subnet     string
+	WorkloadID  string
+	TenantID    string
+	Instances   int
+	TraceLabel  string
+	Name        string
+	Subnet      string
+	IPAddress   string
+	Preemptible bool
+	Group       string
+
+	// BootClone, when set, is the boot volume snapshot the instances
+	// boot from copy-on-write clones of, instead of creating their boot
+	// volume from the workload storage.
+	BootClone *BootClone
+
+	// VolumeClones, when not nil, are the snapshots of the other volumes
+	// the instances are given copy-on-write clones of, instead of the
+	// non bootable volumes of the workload storage.  Only used with
+	// BootClone.
+	VolumeClones []BootClone
+}
+
+// BootClone identifies the snapshot of the boot volume of an instance
+// which other instances are cloned from.  Internal is whether the clones
+// are internal volumes.
+type BootClone struct {
+	VolumeID  string
+	Snapshot  string
+	Ephemeral bool
+	Internal  bool
 }
 
 // Instance contains information about an instance of a workload.
@@ -125,9 +172,52 @@ type Instance struct {
 	SSHPort     int          `json:"ssh_port"`
 	CNCI        bool         `json:"-"`
 	CreateTime  time.Time    `json:"-"`
+	PendingTime time.Time    `json:"-"`
 	Name        string       `json:"name"`
+	Preemptible bool         `json:"preemptible"`
 	StateLock   sync.RWMutex `json:"-"`
 	StateChange *sync.Cond   `json:"-"`
+
+	// Group is the name of the instance group the instance belongs to,
+	// if any.  Instance groups are usually launched by a single request
+	// and operated on as a whole.
+	Group string `json:"group,omitempty"`
+
+	// TerminationTime is the time at which a preemptible instance being
+	// reclaimed will be stopped. It is zero unless the instance has been
+	// preempted.
+	TerminationTime time.Time `json:"-"`
+
+	// DeleteTime is the time at which the instance was moved to the
+	// recycle bin. It is zero unless the instance has been soft deleted.
+	DeleteTime time.Time `json:"-"`
+
+	// RescueVolume is the volume booted by the instance in rescue mode.
+	// It is kept once the instance is unrescued until the launcher stops
+	// using it.
+	RescueVolume string `json:"-"`
+
+	// Rescued is set while the instance is in rescue mode.
+	Rescued bool `json:"-"`
+
+	// Reachable is set if the instance answered the last reachability
+	// probe of its CNCI.  It is only meaningful if ProbeTime is set.
+	Reachable bool `json:"-"`
+
+	// ProbeTime is the time at which the result of the last reachability
+	// probe of the instance was received.  Probe results are not stored
+	// in the datastore as they are refreshed by each probe.
+	ProbeTime time.Time `json:"-"`
+
+	// PeerID is the federation peer the instance was burst to when the
+	// cluster was full, and RemoteID the ID of the instance in the peer.
+	// Both are empty for instances running in the cluster.
+	PeerID   string `json:"-"`
+	RemoteID string `json:"-"`
+
+	// Failure describes the last failure reported for the instance.  It
+	// is nil if no failure has been reported.
+	Failure *Failure `json:"-"`
 }
 
 // SortedInstancesByID implements sort.Interface for Instance by ID string
@@ -144,13 +234,30 @@ func (s SortedNodesByID) Len() int           { return len(s) }
 func (s SortedNodesByID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 func (s SortedNodesByID) Less(i, j int) bool { return s[i].ID < s[j].ID }
 
+// CNCIFlavor overrides the cluster wide size of the CNCIs of a tenant.
+// Sizes which are 0 keep the cluster wide value.
+type CNCIFlavor struct {
+	VCPUs   int `json:"vcpus,omitempty"`
+	MemMB   int `json:"mem_mb,omitempty"`
+	DiskGiB int `json:"disk_gib,omitempty"`
+}
+
 // TenantConfig stores the configurable attributes of a tenant.
 type TenantConfig struct {
 	Name        string `json:"name"`
 	SubnetBits  int    `json:"subnet_bits"`
 	Permissions struct {
 		PrivilegedContainers bool `json:"privileged_containers"`
+		GuestAgent           bool `json:"guest_agent"`
 	} `json:"permissions"`
+	CNCIFlavor CNCIFlavor `json:"cnci_flavor"`
+}
+
+// TenantUpdateResponse holds the configuration of a tenant after updating
+// it, along with its configuration before the update.
+type TenantUpdateResponse struct {
+	Previous TenantConfig `json:"previous"`
+	Config   TenantConfig `json:"config"`
 }
 
 // Tenant contains information about a tenant or project.
@@ -167,6 +274,22 @@ type TenantSummary struct {
 	Links []Link `json:"links,omitempty"`
 }
 
+// TenantUsageSummary aggregates the resources, quota utilization and most
+// recent events of a tenant, most recent event first.
+type TenantUsageSummary struct {
+	ID             string         `json:"id"`
+	Name           string         `json:"name"`
+	Instances      int            `json:"instances"`
+	InstanceStates map[string]int `json:"instance_states"`
+	Volumes        int            `json:"volumes"`
+	VolumeSizeGB   int            `json:"volume_size_gb"`
+	Images         int            `json:"images"`
+	ImageSize      uint64         `json:"image_size"`
+	ExternalIPs    []MappedIP     `json:"external_ips"`
+	Quotas         []QuotaDetails `json:"quotas"`
+	Events         []CiaoEvent    `json:"events"`
+}
+
 // TenantsListResponse stores a list of tenants retrieved by listTenants
 type TenantsListResponse struct {
 	Tenants []TenantSummary `json:"tenants"`
@@ -199,6 +322,18 @@ type NodeStats struct {
 	CpusOnline      int       `json:"cpus_online"`
 }
 
+// ClusterSummary contains summary information about the cluster and the
+// version of the cluster configuration the controller runs with, which is
+// incremented whenever the configuration is reloaded.
+type ClusterSummary struct {
+	Nodes             int       `json:"nodes"`
+	Tenants           int       `json:"tenants"`
+	Instances         int       `json:"instances"`
+	ConfigVersion     int       `json:"config_version"`
+	ConfigLoaded      time.Time `json:"config_loaded"`
+	ConfigReloadError string    `json:"config_reload_error,omitempty"`
+}
+
 // NodeSummary contains summary information for all nodes in the cluster.
 type NodeSummary struct {
 	NodeID                string `json:"node_id"`
@@ -278,6 +413,33 @@ const (
 	// Detaching means that the volume is in process
 	// of detaching.
 	Detaching BlockState = "detaching"
+
+	// Pending means that the volume has been requested
+	// but its creation has not started yet.
+	Pending BlockState = "pending"
+
+	// Cloning means that the volume is being cloned from
+	// an image or from another volume.
+	Cloning BlockState = "cloning"
+
+	// VolumeError means that the volume creation failed.
+	VolumeError BlockState = "error"
+
+	// Deleted means that the volume is in the recycle bin,
+	// waiting to be restored or purged.
+	Deleted BlockState = "deleted"
+
+	// Archiving means that the volume is being moved to the
+	// archive storage.
+	Archiving BlockState = "archiving"
+
+	// Archived means that the volume data is held by the archive
+	// storage and that it has no block device.
+	Archived BlockState = "archived"
+
+	// Rehydrating means that the block device of an archived volume
+	// is being restored from the archive storage.
+	Rehydrating BlockState = "rehydrating"
 )
 
 // Volume respresents the attributes of this block device.
@@ -285,12 +447,21 @@ const (
 // or can we use a set of interfaces to get the info?
 type Volume struct {
 	storage.BlockDevice
-	TenantID    string     `json:"tenant_id"`   // the tenant who owns this volume
-	State       BlockState `json:"state"`       // status of
-	CreateTime  time.Time  `json:"created"`     // when we created the volume
-	Name        string     `json:"name"`        // a human readable name for this volume
-	Description string     `json:"description"` // some text to describe this volume.
-	Internal    bool       `json:"internal"`    // whether this storage should be shown to the user
+	TenantID    string     `json:"tenant_id"`         // the tenant who owns this volume
+	State       BlockState `json:"state"`             // status of
+	CreateTime  time.Time  `json:"created"`           // when we created the volume
+	Name        string     `json:"name"`              // a human readable name for this volume
+	Description string     `json:"description"`       // some text to describe this volume.
+	Internal    bool       `json:"internal"`          // whether this storage should be shown to the user
+	UsedMB      int        `json:"used_mb"`           // space allocated to the thin-provisioned volume
+	Class       string     `json:"class"`             // storage class, empty for the default pool
+	Encrypted   bool       `json:"encrypted"`         // whether the volume is encrypted with LUKS
+	DeleteTime  time.Time  `json:"-"`                 // when the volume was moved to the recycle bin
+	Failure     *Failure   `json:"failure,omitempty"` // the last failure reported for the volume
+
+	// OperationID identifies the operation cloning the volume.  It is
+	// only set in the response to the creation of the volume.
+	OperationID string `json:"operation_id,omitempty"`
 }
 
 // StorageAttachment represents a link between a block device and
@@ -316,6 +487,7 @@ type CiaoNode struct {
 	DiskAvailable         int       `json:"disk_available"`
 	Load                  int       `json:"load"`
 	OnlineCPUs            int       `json:"online_cpus"`
+	VCPUsAllocated        int       `json:"vcpus_allocated"`
 	TotalInstances        int       `json:"total_instances"`
 	TotalRunningInstances int       `json:"total_running_instances"`
 	TotalPendingInstances int       `json:"total_pending_instances"`
@@ -348,6 +520,36 @@ type CiaoNodeStatus struct {
 	Status NodeStatusType `json:"status"`
 }
 
+// NodePolicy contains the scheduling overrides set by admins for a node.
+// Instances are started on the nodes with the highest weight they fit on,
+// and a node with an instance limit is treated as full once it runs that
+// many instances.  A node dedicated to a tenant only runs the instances of
+// that tenant, which in turn only run on its dedicated nodes.
+type NodePolicy struct {
+	NodeID string `json:"node_id"`
+
+	// Weight is between 0 and payloads.MaxNodeWeight, the weight of the
+	// nodes without a policy.
+	Weight int `json:"weight"`
+
+	// MaxInstances is 0 for nodes without an instance limit.
+	MaxInstances int `json:"max_instances"`
+
+	// AllowCNCIColocation lets tenant workloads run on the node while
+	// it hosts the CNCI of their tenant.
+	AllowCNCIColocation bool `json:"allow_cnci_colocation"`
+
+	// DedicatedTenant is the ID of the tenant the node is reserved for,
+	// empty for nodes shared by all tenants.
+	DedicatedTenant string `json:"dedicated_tenant,omitempty"`
+}
+
+// NodePolicies represents the unmarshalled version of the contents of a
+// /node/policies response.
+type NodePolicies struct {
+	Policies []NodePolicy `json:"policies"`
+}
+
 // CiaoNodes represents the unmarshalled version of the contents of a
 // /v2.1/nodes response.  It contains status and statistics information
 // for a set of nodes.
@@ -482,9 +684,18 @@ type CiaoTraceSummary struct {
 
 // CiaoTracesSummary represents the unmarshalled version of the response to a
 // v2.1/traces request.  It contains a list of all trace labels and the
-// number of instances associated with them.
+// number of instances associated with them, along with the label instance
+// launches are currently traced with, if any.
 type CiaoTracesSummary struct {
 	Summaries []CiaoTraceSummary `json:"summaries"`
+	Active    string             `json:"active,omitempty"`
+}
+
+// CiaoTraceRequest represents the unmarshalled version of the contents of a
+// v2.1/traces/active request.  It contains the label subsequent instance
+// launches are traced with.
+type CiaoTraceRequest struct {
+	Label string `json:"label"`
 }
 
 // CiaoFrameStat contains the elapsed time statistics for a frame.
@@ -599,8 +810,224 @@ var (
 
 	// ErrBadName is returned when a name doesn't match the requirements
 	ErrBadName = errors.New("Requested name doesn't match requirements")
+
+	// ErrOperationNotFound is returned when an operation ID cannot be found
+	ErrOperationNotFound = errors.New("Operation not found")
+
+	// ErrIPInUse is returned when a tenant IP address is already allocated
+	ErrIPInUse = errors.New("The IP Address is already in use")
+
+	// ErrIPReservationNotFound is returned when a tenant IP address is
+	// not reserved
+	ErrIPReservationNotFound = errors.New("IP reservation not found")
+
+	// ErrFailedCommandNotFound is returned when a failed command ID
+	// cannot be found
+	ErrFailedCommandNotFound = errors.New("Failed command not found")
+
+	// ErrSecretNotFound is returned when a tenant secret cannot be found
+	ErrSecretNotFound = errors.New("Secret not found")
+
+	// ErrSecretInUse is returned when deleting a secret which is still
+	// referenced by a workload
+	ErrSecretInUse = errors.New("Secret still referenced by a workload")
+
+	// ErrAPITokenNotFound is returned when an API token cannot be found
+	ErrAPITokenNotFound = errors.New("API token not found")
+
+	// ErrVolumeNotFound is returned when a volume cannot be found
+	ErrVolumeNotFound = errors.New("Volume not found")
+
+	// ErrAmbiguousName is returned when looking up a resource by a name
+	// shared by several resources of the tenant
+	ErrAmbiguousName = errors.New("Name shared by several resources, use the ID instead")
+
+	// ErrNotInRecycleBin is returned when restoring or purging a resource
+	// which has not been deleted
+	ErrNotInRecycleBin = errors.New("Resource not in the recycle bin")
+
+	// ErrInstanceNotStopped is returned when an operation requires an
+	// instance to be stopped
+	ErrInstanceNotStopped = errors.New("Cannot perform operation: instance not stopped")
+
+	// ErrExportNotSupported is returned when exporting an instance which
+	// does not boot from an unencrypted volume
+	ErrExportNotSupported = errors.New("Only instances booting from an unencrypted volume can be exported")
+
+	// ErrPeerNotFound is returned when a federation peer cannot be found
+	ErrPeerNotFound = errors.New("Federation peer not found")
+
+	// ErrPeerInUse is returned when removing a federation peer which
+	// still runs instances of the cluster
+	ErrPeerInUse = errors.New("Federation peer still running instances")
+
+	// ErrConsoleSessionNotFound is returned when a console session
+	// cannot be found
+	ErrConsoleSessionNotFound = errors.New("Console session not found")
+
+	// ErrConsoleInUse is returned when opening a console session with an
+	// instance which already has one
+	ErrConsoleInUse = errors.New("Console already in use by another session")
+
+	// ErrNoConsole is returned when opening a console session with a
+	// container whose workload does not ask for a console
+	ErrNoConsole = errors.New("Instance has no console, its workload does not ask for one")
+
+	// ErrInvalidSubnetBits is returned when the subnet size of a tenant
+	// is not between 12 and 30 bits
+	ErrInvalidSubnetBits = errors.New("Subnet bits must be between 12 and 30")
+
+	// ErrTenantHasInstances is returned when changing the subnet size of
+	// a tenant with instances without renumbering its network
+	ErrTenantHasInstances = errors.New("Subnet size of a tenant with instances can only be changed by renumbering")
+
+	// ErrImageNotActive is returned when downloading an image whose data
+	// has not been successfully uploaded
+	ErrImageNotActive = errors.New("Image data not available")
+
+	// ErrInvalidCNCIFlavor is returned when a size of the CNCI flavor of
+	// a tenant is negative
+	ErrInvalidCNCIFlavor = errors.New("CNCI flavor sizes must not be negative")
+
+	// ErrInstanceGroupNotFound is returned when an instance group has no
+	// instances
+	ErrInstanceGroupNotFound = errors.New("Instance group not found")
+
+	// ErrGuestAgentNotPermitted is returned when running a guest agent
+	// operation on an instance of a tenant without the guest agent
+	// permission
+	ErrGuestAgentNotPermitted = errors.New("Permission denied: tenant may not use the guest agent")
+
+	// ErrGuestAgentTimeout is returned when the result of a guest agent
+	// operation is not received in time
+	ErrGuestAgentTimeout = errors.New("Timed out waiting for the guest agent")
+
+	// ErrPacketCaptureTimeout is returned when the packets captured on
+	// an instance are not received in time
+	ErrPacketCaptureTimeout = errors.New("Timed out waiting for the packet capture")
+
+	// ErrSnapshotSetNotFound is returned when a snapshot set cannot be
+	// found
+	ErrSnapshotSetNotFound = errors.New("Snapshot set not found")
+
+	// ErrSnapshotSetNotAvailable is returned when restoring or deleting
+	// a snapshot set which is still being created
+	ErrSnapshotSetNotAvailable = errors.New("Snapshot set not available")
+
+	// ErrSignedRequestInvalid is returned when the signature or the
+	// certificate of a signed request cannot be verified
+	ErrSignedRequestInvalid = errors.New("Invalid signed request")
+
+	// ErrSignedRequestExpired is returned when a signed request was
+	// created too long ago, or in the future
+	ErrSignedRequestExpired = errors.New("Signed request expired")
+
+	// ErrSignedRequestReplayed is returned when a signed request has
+	// already been submitted
+	ErrSignedRequestReplayed = errors.New("Signed request already submitted")
+
+	// ErrSignedRequestsDisabled is returned when submitting a signed
+	// request to a controller without a signed_request_max_age
+	ErrSignedRequestsDisabled = errors.New("Signed requests are disabled")
+
+	// ErrInstanceArchived is returned when trying to use an instance
+	// whose volumes are archived, or being archived or rehydrated
+	ErrInstanceArchived = errors.New("Cannot perform operation: instance archived")
+
+	// ErrInstanceNotArchived is returned when rehydrating an instance
+	// whose volumes are not archived
+	ErrInstanceNotArchived = errors.New("Cannot perform operation: instance not archived")
+
+	// ErrSnapshotNotSupported is returned when taking a snapshot set of
+	// a CNCI instance
+	ErrSnapshotNotSupported = errors.New("You may not snapshot CNCI instances")
+
+	// ErrSnapshotInstanceState is returned when taking a snapshot set of
+	// an instance which is neither running nor stopped
+	ErrSnapshotInstanceState = errors.New("You may only snapshot running or stopped instances")
+
+	// ErrNoBootVolume is returned when taking a snapshot set of an
+	// instance which does not boot from a volume
+	ErrNoBootVolume = errors.New("Instance has no boot volume to snapshot")
+
+	// ErrSnapshotSetNoBootVolume is returned when restoring a snapshot
+	// set without a boot volume
+	ErrSnapshotSetNoBootVolume = errors.New("Snapshot set has no boot volume")
+
+	// ErrArchiveNotSupported is returned when archiving a CNCI or an
+	// instance running on a federation peer
+	ErrArchiveNotSupported = errors.New("You may only archive instances running in the cluster")
+
+	// ErrNoVolumesToArchive is returned when archiving an instance which
+	// has no volumes in use
+	ErrNoVolumesToArchive = errors.New("Instance has no volumes to archive")
+
+	// ErrInstanceMigrating is returned when trying to use an instance
+	// which is being live migrated to another node
+	ErrInstanceMigrating = errors.New("Cannot perform operation: instance migrating")
+
+	// ErrInstanceNotRunning is returned when an operation which requires
+	// a running instance is attempted on an instance in another state
+	ErrInstanceNotRunning = errors.New("Cannot perform operation: instance not running")
+
+	// ErrInstanceNotPaused is returned when unpausing an instance which
+	// is not paused
+	ErrInstanceNotPaused = errors.New("Cannot perform operation: instance not paused")
+
+	// ErrStaticIPInstances is returned when requesting a static IP address
+	// for more than one instance
+	ErrStaticIPInstances = errors.New("A static IP address can only be assigned to a single instance")
+
+	// ErrRescueNotSupported is returned when rescuing a CNCI or a
+	// container instance
+	ErrRescueNotSupported = errors.New("You may only rescue VM instances")
+
+	// ErrInstanceRescued is returned when rescuing an instance which is
+	// already rescued or is still releasing its rescue volume
+	ErrInstanceRescued = errors.New("Instance already rescued or still releasing its rescue volume")
+
+	// ErrInstanceNotRescued is returned when unrescuing an instance which
+	// is not rescued
+	ErrInstanceNotRescued = errors.New("Cannot perform operation: instance not rescued")
+
+	// ErrUnrescueInstanceState is returned when unrescuing an instance
+	// which is neither running nor stopped
+	ErrUnrescueInstanceState = errors.New("You may only unrescue running or stopped instances")
+
+	// ErrGuestAgentNotSupported is returned when using the guest agent of
+	// a CNCI or a container instance
+	ErrGuestAgentNotSupported = errors.New("The guest agent is only available for VM instances")
+
+	// ErrPacketCaptureNotSupported is returned when capturing the traffic
+	// of a CNCI
+	ErrPacketCaptureNotSupported = errors.New("The traffic of CNCIs cannot be captured")
+
+	// ErrMigrateNotSupported is returned when migrating a CNCI, a
+	// container or an instance running on a federation peer
+	ErrMigrateNotSupported = errors.New("You may only live migrate VM instances running in the cluster")
+
+	// ErrMigrateSameNode is returned when migrating an instance to the
+	// node it is running on
+	ErrMigrateSameNode = errors.New("Instance is already running on the target node")
 )
 
+// NameConflictError is returned when creating an instance or a volume with
+// a name already used by another instance or volume of the tenant while
+// the controller enforces unique names.
+type NameConflictError struct {
+	Resource string
+	Name     string
+	ID       string
+}
+
+func (e NameConflictError) Error() string {
+	if e.ID == "" {
+		return fmt.Sprintf("The %s name %s is already used by a %s being created", e.Resource, e.Name, e.Resource)
+	}
+	return fmt.Sprintf("The %s name %s is already used by %s %s, choose another name or use that %s",
+		e.Resource, e.Name, e.Resource, e.ID, e.Resource)
+}
+
 // Link provides a url and relationship for a resource.
 type Link struct {
 	Rel  string `json:"rel"`
@@ -767,11 +1194,24 @@ type QuotaUpdateRequest struct {
 	Quotas []QuotaDetails `json:"quotas"`
 }
 
+// CatalogUpdateRequest holds the layout for publishing a public workload
+// in the workload catalog.
+type CatalogUpdateRequest struct {
+	Category string `json:"category"`
+}
+
 // QuotaListResponse holds the layout for returning quotas in the API
 type QuotaListResponse struct {
 	Quotas []QuotaDetails `json:"quotas"`
 }
 
+// QuotaUpdateResponse holds the layout for returning the quotas of a
+// tenant after updating them, along with their values before the update.
+type QuotaUpdateResponse struct {
+	Quotas   []QuotaDetails `json:"quotas"`
+	Previous []QuotaDetails `json:"previous"`
+}
+
 // CNCIController is the interface for the cnci controller associated with each tenant
 type CNCIController interface {
 	CNCIAdded(ID string) error
@@ -804,6 +1244,49 @@ const (
 	Killed ImageState = "killed"
 )
 
+// ImageGCState describes what the image garbage collector did with orphaned
+// image data.
+type ImageGCState string
+
+const (
+	// ImageGCQuarantined means that the data is not referenced by any
+	// image or volume and will be removed if it is still orphaned once
+	// its grace period expires.
+	ImageGCQuarantined ImageGCState = "quarantined"
+
+	// ImageGCRemoved means that the data has been removed.
+	ImageGCRemoved ImageGCState = "removed"
+)
+
+// ImageGCEntry describes a block device found by the image garbage
+// collector not to be referenced by any image or volume.
+type ImageGCEntry struct {
+	ID        string       `json:"id"`
+	State     ImageGCState `json:"state"`
+	FirstSeen time.Time    `json:"first_seen"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// ImageGCReport contains the results of an image garbage collection.
+type ImageGCReport struct {
+	LastRun time.Time      `json:"last_run"`
+	Devices []ImageGCEntry `json:"devices"`
+}
+
+// FailedCommand is an SSNTP command sent by the controller which could
+// not be delivered or which was rejected by the node it was sent to. The
+// command payload is kept so that the command can be replayed once the
+// cause of the failure has been fixed.
+type FailedCommand struct {
+	ID         string    `json:"id"`
+	Command    string    `json:"command"`
+	InstanceID string    `json:"instance_id,omitempty"`
+	NodeID     string    `json:"node_id,omitempty"`
+	Reason     string    `json:"reason"`
+	Timestamp  time.Time `json:"timestamp"`
+	Payload    string    `json:"payload"`
+}
+
 // Visibility defines whether an image is per tenant or public.
 type Visibility string
 
@@ -827,6 +1310,606 @@ type Image struct {
 	CreateTime time.Time  `json:"create_time"`
 	Size       uint64     `json:"size"`
 	Visibility Visibility `json:"visibility"`
+
+	// OperationID identifies the operation importing the image.  It
+	// is only set in the response to the creation of the image.
+	OperationID string `json:"operation_id,omitempty"`
+}
+
+// OperationType identifies the kind of action an operation tracks.
+type OperationType string
+
+const (
+	// InstanceCreate operations track instances launches.
+	InstanceCreate OperationType = "instance_create"
+
+	// ImageImport operations track image data uploads.
+	ImageImport OperationType = "image_import"
+
+	// VolumeClone operations track volume clones from images or volumes.
+	VolumeClone OperationType = "volume_clone"
+
+	// NodeEvacuate operations track compute node evacuations.  They
+	// complete once no instance is running on the node anymore.
+	NodeEvacuate OperationType = "node_evacuate"
+
+	// InstanceArchive operations track the archival of the volumes of
+	// stopped instances.
+	InstanceArchive OperationType = "instance_archive"
+
+	// InstanceRehydrate operations track the restoration of the volumes
+	// of archived instances.
+	InstanceRehydrate OperationType = "instance_rehydrate"
+
+	// InstanceMigrate operations track the live migration of running
+	// instances between compute nodes.
+	InstanceMigrate OperationType = "instance_migrate"
+)
+
+// OperationState represents the state of an operation.
+type OperationState string
+
+const (
+	// OperationRunning means that the operation is in progress.
+	OperationRunning OperationState = "running"
+
+	// OperationSucceeded means that the operation completed successfully.
+	OperationSucceeded OperationState = "succeeded"
+
+	// OperationFailed means that the operation completed with an error.
+	OperationFailed OperationState = "failed"
+)
+
+// Operation tracks the progress of an asynchronous action on a resource.
+// Operations are only kept in the memory of the controller: they are lost,
+// and their IDs no longer found, when the controller restarts.
+type Operation struct {
+	ID         string         `json:"id"`
+	TenantID   string         `json:"tenant_id"`
+	Type       OperationType  `json:"type"`
+	ResourceID string         `json:"resource_id"`
+	State      OperationState `json:"state"`
+	Progress   int            `json:"progress"`
+	CreateTime time.Time      `json:"created"`
+	UpdateTime time.Time      `json:"updated"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// InstanceActionInitiator identifies who caused an instance to change state.
+type InstanceActionInitiator string
+
+const (
+	// InitiatorUser is used for state changes requested through the API.
+	InitiatorUser InstanceActionInitiator = "user"
+
+	// InitiatorSystem is used for state changes made by ciao itself or
+	// caused by a failure in the cluster.
+	InitiatorSystem InstanceActionInitiator = "system"
+)
+
+// InstanceActionReason explains why an instance changed state.
+type InstanceActionReason string
+
+const (
+	// ReasonAPIRequest is used when the state change was requested by
+	// a user.
+	ReasonAPIRequest InstanceActionReason = "api_request"
+
+	// ReasonHealthCheck is used when ciao restarts an instance it needs
+	// that has stopped, such as a CNCI.
+	ReasonHealthCheck InstanceActionReason = "health_check"
+
+	// ReasonEvacuation is used when an instance is stopped because its
+	// node is being evacuated.
+	ReasonEvacuation InstanceActionReason = "evacuation"
+
+	// ReasonNodeFailure is used when the node running an instance
+	// disconnected.
+	ReasonNodeFailure InstanceActionReason = "node_failure"
+
+	// ReasonLaunchTimeout is used when an instance did not start within
+	// the instance timeout.
+	ReasonLaunchTimeout InstanceActionReason = "launch_timeout"
+
+	// ReasonStateReported is used when a node reports a state change
+	// that nobody asked for, e.g. an instance shutting itself down.
+	ReasonStateReported InstanceActionReason = "state_reported"
+
+	// ReasonPreemption is used when an instance is stopped by the
+	// scheduler to make room for a higher priority instance.
+	ReasonPreemption InstanceActionReason = "preemption"
+
+	// ReasonCNCIResize is used when a CNCI is restarted to apply a new
+	// CNCI flavor of its tenant.
+	ReasonCNCIResize InstanceActionReason = "cnci_resize"
+
+	// ReasonMigration is used when an instance changes state because it
+	// is live migrated to another node.
+	ReasonMigration InstanceActionReason = "migration"
+)
+
+// PendingInstance is a snapshot of an instance waiting to be reported as
+// running by its launcher.
+type PendingInstance struct {
+	ID          string
+	TenantID    string
+	NodeID      string
+	CreateTime  time.Time
+	PendingTime time.Time
+}
+
+// InstanceAction records a state transition of an instance along with
+// who initiated it and why.
+type InstanceAction struct {
+	InstanceID    string                  `json:"instance_id"`
+	PreviousState string                  `json:"previous_state"`
+	State         string                  `json:"state"`
+	Initiator     InstanceActionInitiator `json:"initiator"`
+	Reason        InstanceActionReason    `json:"reason"`
+	Timestamp     time.Time               `json:"timestamp"`
+}
+
+// FailureCode is a stable category of the failure of an operation on an
+// instance or a volume.  The failure reasons reported by the nodes are
+// mapped to failure codes so that clients do not depend on them.
+type FailureCode string
+
+const (
+	// FailureCapacity means that the cluster or the node had no room for
+	// the operation.
+	FailureCapacity FailureCode = "capacity"
+
+	// FailureInvalidRequest means that the node could not parse or
+	// rejected the command sent by ciao.
+	FailureInvalidRequest FailureCode = "invalid_request"
+
+	// FailureConflict means that the operation had already been done,
+	// e.g. the instance was already running.
+	FailureConflict FailureCode = "conflict"
+
+	// FailureNotFound means that the instance did not exist on the node.
+	FailureNotFound FailureCode = "not_found"
+
+	// FailureImage means that the image of the instance could not be
+	// used.
+	FailureImage FailureCode = "image"
+
+	// FailureLaunch means that the hypervisor or the container engine
+	// failed to launch the instance.
+	FailureLaunch FailureCode = "launch"
+
+	// FailureNetwork means that the network of the instance could not be
+	// set up.
+	FailureNetwork FailureCode = "network"
+
+	// FailureTimeout means that the operation did not complete in time.
+	FailureTimeout FailureCode = "timeout"
+
+	// FailureStorage means that a volume could not be attached.
+	FailureStorage FailureCode = "storage"
+
+	// FailureInstanceState means that the instance was not in a state
+	// allowing the operation.
+	FailureInstanceState FailureCode = "instance_state"
+
+	// FailureNotSupported means that the node does not support the
+	// operation for the instance.
+	FailureNotSupported FailureCode = "not_supported"
+
+	// FailureUnknown is used for failure reasons that ciao does not know.
+	FailureUnknown FailureCode = "unknown"
+)
+
+// FailureOperation identifies the operation which failed.
+type FailureOperation string
+
+const (
+	// FailureStart is used for failures to start an instance.
+	FailureStart FailureOperation = "start"
+
+	// FailureDelete is used for failures to delete an instance.
+	FailureDelete FailureOperation = "delete"
+
+	// FailureAttachVolume is used for failures to attach a volume.
+	FailureAttachVolume FailureOperation = "attach_volume"
+
+	// FailureAssignPublicIP is used for failures to map a public IP
+	// address to an instance.
+	FailureAssignPublicIP FailureOperation = "assign_public_ip"
+
+	// FailureUnassignPublicIP is used for failures to unmap a public IP
+	// address from an instance.
+	FailureUnassignPublicIP FailureOperation = "unassign_public_ip"
+)
+
+// Failure describes the last failure reported for an instance or a volume.
+// Reason is the failure reason sent by the node, which may change between
+// releases, and Code its stable category.
+type Failure struct {
+	Code      FailureCode      `json:"code"`
+	Operation FailureOperation `json:"operation"`
+	Reason    string           `json:"reason"`
+	Message   string           `json:"message,omitempty"`
+	Time      time.Time        `json:"time"`
+}
+
+// StartFailureCode returns the failure code of a start failure reason.
+func StartFailureCode(reason payloads.StartFailureReason) FailureCode {
+	switch reason {
+	case payloads.FullCloud, payloads.FullComputeNode, payloads.NodeInMaintenance,
+		payloads.NodeDraining, payloads.NoComputeNodes, payloads.NoNetworkNodes:
+		return FailureCapacity
+	case payloads.InvalidPayload, payloads.InvalidData:
+		return FailureInvalidRequest
+	case payloads.AlreadyRunning, payloads.InstanceExists:
+		return FailureConflict
+	case payloads.ImageFailure:
+		return FailureImage
+	case payloads.LaunchFailure:
+		return FailureLaunch
+	case payloads.NetworkFailure:
+		return FailureNetwork
+	case payloads.LaunchTimeout:
+		return FailureTimeout
+	}
+
+	return FailureUnknown
+}
+
+// DeleteFailureCode returns the failure code of a delete failure reason.
+func DeleteFailureCode(reason payloads.DeleteFailureReason) FailureCode {
+	switch reason {
+	case payloads.DeleteNoInstance:
+		return FailureNotFound
+	case payloads.DeleteInvalidPayload, payloads.DeleteInvalidData:
+		return FailureInvalidRequest
+	}
+
+	return FailureUnknown
+}
+
+// AttachVolumeFailureCode returns the failure code of an attach volume
+// failure reason.
+func AttachVolumeFailureCode(reason payloads.AttachVolumeFailureReason) FailureCode {
+	switch reason {
+	case payloads.AttachVolumeNoInstance:
+		return FailureNotFound
+	case payloads.AttachVolumeInvalidPayload, payloads.AttachVolumeInvalidData:
+		return FailureInvalidRequest
+	case payloads.AttachVolumeAttachFailure:
+		return FailureStorage
+	case payloads.AttachVolumeAlreadyAttached:
+		return FailureConflict
+	case payloads.AttachVolumeStateFailure, payloads.AttachVolumeInstanceFailure:
+		return FailureInstanceState
+	case payloads.AttachVolumeNotSupported:
+		return FailureNotSupported
+	}
+
+	return FailureUnknown
+}
+
+// PublicIPFailureCode returns the failure code of a public IP failure
+// reason.
+func PublicIPFailureCode(reason payloads.PublicIPFailureReason) FailureCode {
+	switch reason {
+	case payloads.PublicIPNoInstance:
+		return FailureNotFound
+	case payloads.PublicIPInvalidPayload, payloads.PublicIPInvalidData:
+		return FailureInvalidRequest
+	case payloads.PublicIPAssignFailure, payloads.PublicIPReleaseFailure:
+		return FailureNetwork
+	}
+
+	return FailureUnknown
+}
+
+// NewStartFailure returns the failure recorded for a start failure reason.
+func NewStartFailure(reason payloads.StartFailureReason) *Failure {
+	return &Failure{
+		Code:      StartFailureCode(reason),
+		Operation: FailureStart,
+		Reason:    string(reason),
+		Message:   reason.String(),
+		Time:      time.Now(),
+	}
+}
+
+// NewDeleteFailure returns the failure recorded for a delete failure
+// reason.
+func NewDeleteFailure(reason payloads.DeleteFailureReason) *Failure {
+	return &Failure{
+		Code:      DeleteFailureCode(reason),
+		Operation: FailureDelete,
+		Reason:    string(reason),
+		Message:   reason.String(),
+		Time:      time.Now(),
+	}
+}
+
+// NewAttachVolumeFailure returns the failure recorded for an attach volume
+// failure reason.
+func NewAttachVolumeFailure(reason payloads.AttachVolumeFailureReason) *Failure {
+	return &Failure{
+		Code:      AttachVolumeFailureCode(reason),
+		Operation: FailureAttachVolume,
+		Reason:    string(reason),
+		Message:   reason.String(),
+		Time:      time.Now(),
+	}
+}
+
+// NewPublicIPFailure returns the failure recorded for a public IP failure
+// reason of the given operation, FailureAssignPublicIP or
+// FailureUnassignPublicIP.
+func NewPublicIPFailure(op FailureOperation, reason payloads.PublicIPFailureReason) *Failure {
+	return &Failure{
+		Code:      PublicIPFailureCode(reason),
+		Operation: op,
+		Reason:    string(reason),
+		Message:   reason.String(),
+		Time:      time.Now(),
+	}
+}
+
+// IPReservation represents a tenant IP address which has been reserved
+// ahead of time so that it can be assigned to a specific instance.
+type IPReservation struct {
+	IPAddress  string `json:"ip_address"`
+	InstanceID string `json:"instance_id,omitempty"`
+}
+
+// IPReservationRequest is used to reserve a tenant IP address.
+type IPReservationRequest struct {
+	IPAddress string `json:"ip_address"`
+}
+
+// IPAMIssueType identifies the kind of discrepancy found by an IP
+// address management audit.
+type IPAMIssueType string
+
+const (
+	// IPAMLeak means that an IP address is allocated to a tenant but
+	// is not used by any instance.
+	IPAMLeak IPAMIssueType = "leak"
+
+	// IPAMConflict means that an IP address is used by more than one
+	// instance.
+	IPAMConflict IPAMIssueType = "conflict"
+
+	// IPAMUnallocated means that an instance uses an IP address which
+	// is not allocated to its tenant and could be handed out again.
+	IPAMUnallocated IPAMIssueType = "unallocated"
+
+	// IPAMMissingCNCI means that a subnet has IP addresses allocated
+	// but no CNCI to serve it.
+	IPAMMissingCNCI IPAMIssueType = "missing_cnci"
+
+	// IPAMIdleCNCI means that a CNCI serves a subnet in which no IP
+	// addresses are allocated.
+	IPAMIdleCNCI IPAMIssueType = "idle_cnci"
+)
+
+// IPAMIssue describes a single discrepancy found by an IP address
+// management audit.
+type IPAMIssue struct {
+	Type        IPAMIssueType `json:"type"`
+	TenantID    string        `json:"tenant_id"`
+	Subnet      string        `json:"subnet,omitempty"`
+	IPAddress   string        `json:"ip_address,omitempty"`
+	InstanceIDs []string      `json:"instance_ids,omitempty"`
+	Repaired    bool          `json:"repaired"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// IPAMAudit contains the results of an IP address management audit.
+type IPAMAudit struct {
+	Issues []IPAMIssue `json:"issues"`
+}
+
+// Secret is a named value, such as a credential, which is injected in the
+// meta-data of the instances of the workloads referencing it. Secrets are
+// encrypted at rest and their values are never returned by the API.
+type Secret struct {
+	Name       string    `json:"name"`
+	TenantID   string    `json:"-"`
+	CreateTime time.Time `json:"create_time"`
+
+	// Data contains the encrypted value of the secret.
+	Data []byte `json:"-"`
+}
+
+// SecretRequest is used to set the value of a secret.
+type SecretRequest struct {
+	Value string `json:"value"`
+}
+
+// APITokenScope defines the requests an API token may authenticate.
+type APITokenScope string
+
+const (
+	// APITokenReadOnly tokens only authenticate GET requests.
+	APITokenReadOnly APITokenScope = "read-only"
+
+	// APITokenFull tokens authenticate any request permitted to the
+	// tenant.
+	APITokenFull APITokenScope = "full"
+)
+
+// APIToken is a bearer token authenticating API requests on behalf of a
+// tenant, as an alternative to a client certificate.
+type APIToken struct {
+	ID         string        `json:"id"`
+	TenantID   string        `json:"tenant_id"`
+	Name       string        `json:"name"`
+	Scope      APITokenScope `json:"scope"`
+	CreateTime time.Time     `json:"create_time"`
+	ExpiryTime time.Time     `json:"expiry_time"`
+
+	// Hash contains the SHA-256 hash of the token secret.
+	Hash []byte `json:"-"`
+
+	// Token is only returned when the token is issued.
+	Token string `json:"token,omitempty"`
+}
+
+// APITokenRequest is used to issue an API token.
+type APITokenRequest struct {
+	Name  string        `json:"name"`
+	Scope APITokenScope `json:"scope"`
+
+	// Expiry is the lifetime of the token, such as "720h". The
+	// controller default is used if it is empty.
+	Expiry string `json:"expiry,omitempty"`
+}
+
+// Snapshot set states.
+const (
+	// SnapshotSetCreating sets are having their volumes copied out of the
+	// snapshots of the volumes of their instance.
+	SnapshotSetCreating = "creating"
+
+	// SnapshotSetAvailable sets can be restored and deleted.
+	SnapshotSetAvailable = "available"
+
+	// SnapshotSetError sets could not be created.  They can only be
+	// deleted.
+	SnapshotSetError = "error"
+)
+
+// SnapshotVolume is a volume of a snapshot set.  ID is the internal volume
+// holding the snapshot of VolumeID, the volume of the instance.
+type SnapshotVolume struct {
+	ID        string `json:"id"`
+	VolumeID  string `json:"volume_id"`
+	Boot      bool   `json:"boot"`
+	Ephemeral bool   `json:"ephemeral"`
+	Internal  bool   `json:"internal,omitempty"`
+	Size      int    `json:"size"`
+}
+
+// SnapshotSet is a named set of snapshots of all the volumes of an
+// instance, taken at the same time.  Consistent is set when the
+// filesystems of the instance were frozen while the snapshots were taken,
+// or when the instance was not running.
+type SnapshotSet struct {
+	ID         string           `json:"id"`
+	Name       string           `json:"name"`
+	TenantID   string           `json:"tenant_id"`
+	InstanceID string           `json:"instance_id"`
+	WorkloadID string           `json:"workload_id"`
+	State      string           `json:"state"`
+	Consistent bool             `json:"consistent"`
+	CreateTime time.Time        `json:"create_time"`
+	Volumes    []SnapshotVolume `json:"volumes"`
+}
+
+// SnapshotSetRequest is used to snapshot the volumes of an instance.
+// Running instances are only snapshotted without freezing their
+// filesystems, e.g., when their guest does not run the guest agent, when
+// AllowInconsistent is set.
+type SnapshotSetRequest struct {
+	Name              string `json:"name"`
+	AllowInconsistent bool   `json:"allow_inconsistent,omitempty"`
+}
+
+// SnapshotSetRestoreRequest is used to create an instance from a snapshot
+// set.
+type SnapshotSetRestoreRequest struct {
+	Name string `json:"name,omitempty"`
+}
+
+// SignedRequest is an API request rendered and signed offline with the
+// client certificate of its author, to be reviewed and submitted later by
+// an admin.  The controller runs it as if it had been sent by its author.
+type SignedRequest struct {
+	Method string `json:"method"`
+
+	// Path is the path of the request URL, including its query.
+	Path string `json:"path"`
+
+	// ContentType is the media type of the body without its
+	// "application/" prefix, e.g. "x.ciao.tenants.v1".
+	ContentType string          `json:"content_type,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"`
+
+	// Created and Nonce let the controller reject stale and replayed
+	// requests.
+	Created time.Time `json:"created"`
+	Nonce   string    `json:"nonce"`
+
+	// Certificate is the PEM encoded client certificate of the author.
+	Certificate string `json:"certificate"`
+
+	// Signature is the base64 encoded signature of the SigningData of
+	// the request, made with the key of the certificate.
+	Signature string `json:"signature"`
+}
+
+// SigningData returns the data of a request covered by its signature.  The
+// body is compacted and HTML escaped, as it is when the request is encoded,
+// so that the signature survives its reformatting.
+func (r *SignedRequest) SigningData() ([]byte, error) {
+	var compact, body bytes.Buffer
+	if len(r.Body) > 0 {
+		if err := json.Compact(&compact, r.Body); err != nil {
+			return nil, err
+		}
+		json.HTMLEscape(&body, compact.Bytes())
+	}
+
+	header := fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n", r.Method, r.Path, r.ContentType,
+		r.Created.UTC().Format(time.RFC3339Nano), r.Nonce)
+
+	return append([]byte(header), body.Bytes()...), nil
+}
+
+// SignedRequestRecord records the submission of a signed request.  A
+// request whose nonce has already been recorded cannot be submitted again.
+type SignedRequestRecord struct {
+	Nonce      string    `json:"nonce"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Signer     string    `json:"signer"`
+	Created    time.Time `json:"created"`
+	SubmitTime time.Time `json:"submit_time"`
+}
+
+// SignedRequestResult contains the response of the controller to a signed
+// request.
+type SignedRequestResult struct {
+	StatusCode  int    `json:"status_code"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        string `json:"body,omitempty"`
+}
+
+// DeletedResourceType is the type of a resource in the recycle bin.
+type DeletedResourceType string
+
+const (
+	// DeletedInstance is an instance in the recycle bin.
+	DeletedInstance DeletedResourceType = "instance"
+
+	// DeletedVolume is a volume in the recycle bin.
+	DeletedVolume DeletedResourceType = "volume"
+)
+
+// DeletedResource is an instance or a volume which has been deleted and
+// can be restored until its purge time.
+type DeletedResource struct {
+	ID         string              `json:"id"`
+	Type       DeletedResourceType `json:"type"`
+	Name       string              `json:"name"`
+	DeleteTime time.Time           `json:"delete_time"`
+	PurgeTime  time.Time           `json:"purge_time"`
+}
+
+// IsDeleted returns whether the instance is in the recycle bin.
+func (i *Instance) IsDeleted() bool {
+	i.StateLock.RLock()
+	defer i.StateLock.RUnlock()
+
+	return !i.DeleteTime.IsZero()
 }
 
 // TransitionInstanceState safely sets thes state on an instance
@@ -854,3 +1937,164 @@ func (i *Instance) TransitionInstanceState(to string) error {
 
 	return nil
 }
+
+// InstanceExport describes an instance exported by the controller, along
+// with the workload it was created from.  It is stored in the export
+// bundle next to the boot disk and the cloud-init user data.
+type InstanceExport struct {
+	InstanceID string    `json:"instance_id"`
+	Name       string    `json:"name,omitempty"`
+	ExportTime time.Time `json:"export_time"`
+	Workload   Workload  `json:"workload"`
+	Disk       string    `json:"disk"`
+	UserData   string    `json:"user_data,omitempty"`
+}
+
+// FederationPeer is a remote ciao cluster instance launches are burst to
+// when the cluster has no capacity left.
+type FederationPeer struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	URL        string `json:"url"`
+	TenantID   string `json:"tenant_id"`
+	CACertFile string `json:"ca_cert_file,omitempty"`
+
+	// Workloads maps the IDs of the local workloads which can be burst
+	// to the peer to the IDs of the peer workloads launched instead.
+	Workloads  map[string]string `json:"workloads"`
+	CreateTime time.Time         `json:"create_time"`
+
+	// Token contains the encrypted API token of the peer tenant.
+	Token []byte `json:"-"`
+}
+
+// ConsoleSession is an emergency serial console session with an instance.
+// Output holds the console output read since the previous exchange with
+// the session.
+type ConsoleSession struct {
+	ID         string    `json:"id"`
+	InstanceID string    `json:"instance_id"`
+	NodeID     string    `json:"node_id"`
+	Output     string    `json:"output"`
+	Closed     bool      `json:"closed"`
+	ExpireTime time.Time `json:"expire_time"`
+}
+
+// ConsoleRequest is used to open a console session with an instance.
+type ConsoleRequest struct {
+	InstanceID string `json:"instance_id"`
+}
+
+// ConsoleInputRequest is used to write to the serial console of an
+// instance.
+type ConsoleInputRequest struct {
+	Input string `json:"input"`
+}
+
+// Guest agent operations of a GuestAgentRequest.
+const (
+	// GuestAgentExec runs a command in the guest of an instance.
+	GuestAgentExec = "exec"
+
+	// GuestAgentFreeze freezes the filesystems of the guest of an
+	// instance, e.g., before snapshotting its volumes.
+	GuestAgentFreeze = "fsfreeze"
+
+	// GuestAgentThaw thaws the filesystems frozen by GuestAgentFreeze.
+	GuestAgentThaw = "fsthaw"
+
+	// GuestAgentNetworkInfo retrieves the network interfaces of the
+	// guest of an instance.
+	GuestAgentNetworkInfo = "network-info"
+)
+
+// GuestAgentRequest is used to run an operation through the QEMU guest
+// agent of an instance.  Path and Args are the command run by
+// GuestAgentExec.
+type GuestAgentRequest struct {
+	Operation string   `json:"operation"`
+	Path      string   `json:"path,omitempty"`
+	Args      []string `json:"args,omitempty"`
+}
+
+// GuestInterface is a network interface of the guest of an instance.
+type GuestInterface struct {
+	Name            string   `json:"name"`
+	HardwareAddress string   `json:"hardware_address,omitempty"`
+	IPAddresses     []string `json:"ip_addresses,omitempty"`
+}
+
+// GuestAgentResult is the result of a guest agent operation.  Only the
+// fields relevant to the operation are set.
+type GuestAgentResult struct {
+	InstanceID  string           `json:"instance_id"`
+	Operation   string           `json:"operation"`
+	ExitCode    int              `json:"exit_code"`
+	Stdout      string           `json:"stdout,omitempty"`
+	Stderr      string           `json:"stderr,omitempty"`
+	Filesystems int              `json:"filesystems,omitempty"`
+	Interfaces  []GuestInterface `json:"interfaces,omitempty"`
+}
+
+// PacketCaptureRequest is used to capture the network traffic of an
+// instance.  The capture stops once its pcap file reaches MaxBytes or after
+// MaxSeconds.  Filter is an optional pcap filter expression.
+type PacketCaptureRequest struct {
+	MaxBytes   int    `json:"max_bytes,omitempty"`
+	MaxSeconds int    `json:"max_seconds,omitempty"`
+	Filter     string `json:"filter,omitempty"`
+}
+
+// PacketCaptureResult contains the packets captured on an instance as a
+// pcap file.  Truncated is set when the capture reached its maximum size.
+type PacketCaptureResult struct {
+	InstanceID string `json:"instance_id"`
+	NodeID     string `json:"node_id"`
+	Truncated  bool   `json:"truncated"`
+	Pcap       []byte `json:"pcap"`
+}
+
+// MigrateRequest is used to live migrate a running instance.  NodeID is the
+// optional node to migrate the instance to.  The scheduler picks the target
+// node when it is not set.
+type MigrateRequest struct {
+	NodeID string `json:"node_id,omitempty"`
+}
+
+// FederationPeerRequest is used to register a federation peer.
+type FederationPeerRequest struct {
+	Name       string            `json:"name"`
+	URL        string            `json:"url"`
+	TenantID   string            `json:"tenant_id"`
+	APIToken   string            `json:"api_token"`
+	CACertFile string            `json:"ca_cert_file,omitempty"`
+	Workloads  map[string]string `json:"workloads"`
+}
+
+// TenantRenumberRequest is used to change the subnet size of a tenant
+// which has instances.
+type TenantRenumberRequest struct {
+	SubnetBits int  `json:"subnet_bits"`
+	DryRun     bool `json:"dry_run"`
+}
+
+// SubnetConflict describes an address of a tenant which cannot be used
+// with a new subnet size, as it becomes the network, gateway or broadcast
+// address of its subnet.
+type SubnetConflict struct {
+	IPAddress  string `json:"ip_address"`
+	Subnet     string `json:"subnet"`
+	InstanceID string `json:"instance_id,omitempty"`
+	Reserved   bool   `json:"reserved"`
+}
+
+// TenantRenumbering contains the results of a change of the subnet size of
+// a tenant.  Existing instances keep their addresses and the configuration
+// of the subnet they were started in, conflicting instances must be
+// recreated.
+type TenantRenumbering struct {
+	OldSubnetBits int              `json:"old_subnet_bits"`
+	SubnetBits    int              `json:"subnet_bits"`
+	DryRun        bool             `json:"dry_run"`
+	Conflicts     []SubnetConflict `json:"conflicts"`
+}
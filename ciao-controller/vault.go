@@ -0,0 +1,234 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+var vaultAddr = flag.String("vault_addr", "", "Address of the Vault server secrets are resolved from before the built-in store, e.g. https://vault:8200")
+var vaultCACert = flag.String("vault_cacert", "", "CA certificate of the Vault server")
+var vaultRoleID = flag.String("vault_role_id", "", "Vault AppRole role ID of the controller")
+var vaultSecretIDFile = flag.String("vault_secret_id_file", "", "Path to a file containing the Vault AppRole secret ID of the controller")
+var vaultMount = flag.String("vault_mount", "secret", "Mount path of the Vault KV version 2 secrets engine")
+
+// vaultTokenMargin is how long before its expiry a Vault token is renewed.
+const vaultTokenMargin = 30 * time.Second
+
+// vaultSecrets resolves secrets from a HashiCorp Vault KV version 2 secrets
+// engine, logging in with the AppRole of the controller. The secret NAME of
+// a tenant is read from the value key of MOUNT/data/TENANT/NAME.
+type vaultSecrets struct {
+	addr     string
+	mount    string
+	roleID   string
+	secretID string
+	client   *http.Client
+
+	lock   sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+func newVaultSecrets(addr string, caCert string, mount string, roleID string, secretIDFile string) (*vaultSecrets, error) {
+	u, err := url.Parse(addr)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("Invalid Vault address %s", addr)
+	}
+
+	if roleID == "" || secretIDFile == "" {
+		return nil, errors.New("A Vault role ID and secret ID are required")
+	}
+
+	secretID, err := ioutil.ReadFile(secretIDFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading Vault secret ID")
+	}
+
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+
+	if caCert != "" {
+		pem, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error reading Vault CA certificate")
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("Invalid Vault CA certificate")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &vaultSecrets{
+		addr:     strings.TrimSuffix(addr, "/"),
+		mount:    strings.Trim(mount, "/"),
+		roleID:   roleID,
+		secretID: strings.TrimSpace(string(secretID)),
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   30 * time.Second,
+		},
+	}, nil
+}
+
+func (v *vaultSecrets) String() string {
+	return "Vault " + v.addr
+}
+
+// vaultError builds an error from the error response of Vault.
+func vaultError(resp *http.Response) error {
+	var body struct {
+		Errors []string `json:"errors"`
+	}
+
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+	if len(body.Errors) > 0 {
+		return fmt.Errorf("Vault error %s: %s", resp.Status, strings.Join(body.Errors, ", "))
+	}
+
+	return fmt.Errorf("Vault error %s", resp.Status)
+}
+
+// login returns a token of the controller AppRole, logging in again when
+// the current token is about to expire. Must be called with v.lock held.
+func (v *vaultSecrets) login() (string, error) {
+	if v.token != "" && time.Now().Add(vaultTokenMargin).Before(v.expiry) {
+		return v.token, nil
+	}
+
+	req, err := json.Marshal(map[string]string{
+		"role_id":   v.roleID,
+		"secret_id": v.secretID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := v.client.Post(v.addr+"/v1/auth/approle/login", "application/json", bytes.NewReader(req))
+	if err != nil {
+		return "", errors.Wrap(err, "Error logging in to Vault")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Wrap(vaultError(resp), "Error logging in to Vault")
+	}
+
+	var body struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return "", errors.Wrap(err, "Error decoding Vault login response")
+	}
+
+	if body.Auth.ClientToken == "" {
+		return "", errors.New("Vault login response has no token")
+	}
+
+	v.token = body.Auth.ClientToken
+	v.expiry = time.Now().Add(time.Duration(body.Auth.LeaseDuration) * time.Second)
+	glog.V(1).Infof("Logged in to Vault %s", v.addr)
+
+	return v.token, nil
+}
+
+func (v *vaultSecrets) readSecret(token string, tenantID string, name string) (*http.Response, error) {
+	path := fmt.Sprintf("%s/v1/%s/data/%s/%s", v.addr, v.mount,
+		url.PathEscape(tenantID), url.PathEscape(name))
+
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	return v.client.Do(req)
+}
+
+// GetSecret reads the value of a tenant secret from Vault.
+func (v *vaultSecrets) GetSecret(tenantID string, name string) (string, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	var resp *http.Response
+	for retry := 0; ; retry++ {
+		token, err := v.login()
+		if err != nil {
+			return "", err
+		}
+
+		resp, err = v.readSecret(token, tenantID, name)
+		if err != nil {
+			return "", errors.Wrap(err, "Error reading secret from Vault")
+		}
+
+		// the token may have been revoked, log in again once.
+		if resp.StatusCode != http.StatusForbidden || retry > 0 {
+			break
+		}
+
+		_ = resp.Body.Close()
+		v.token = ""
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", types.ErrSecretNotFound
+	} else if resp.StatusCode != http.StatusOK {
+		return "", errors.Wrap(vaultError(resp), "Error reading secret from Vault")
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+
+	err := json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return "", errors.Wrap(err, "Error decoding Vault secret")
+	}
+
+	value, ok := body.Data.Data["value"].(string)
+	if !ok {
+		return "", fmt.Errorf("Vault secret %s has no value", name)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+)
+
+const (
+	testVaultRoleID   = "ciao-controller"
+	testVaultSecretID = "7d1ab2c0-0d52-4a4c-8e0a-9c1a4b7a3f2e"
+)
+
+// testVault emulates the AppRole login and KV version 2 read endpoints of
+// a Vault server.
+type testVault struct {
+	sync.Mutex
+	tokens  int
+	revoked bool
+	secrets map[string]string
+}
+
+func (tv *testVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tv.Lock()
+	defer tv.Unlock()
+
+	if r.URL.Path == "/v1/auth/approle/login" {
+		var req map[string]string
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil || req["role_id"] != testVaultRoleID || req["secret_id"] != testVaultSecretID {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"errors":["invalid role or secret ID"]}`)
+			return
+		}
+
+		tv.tokens++
+		tv.revoked = false
+		fmt.Fprintf(w, `{"auth":{"client_token":"token-%d","lease_duration":3600}}`, tv.tokens)
+		return
+	}
+
+	if tv.revoked || r.Header.Get("X-Vault-Token") != fmt.Sprintf("token-%d", tv.tokens) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"errors":["permission denied"]}`)
+		return
+	}
+
+	value, ok := tv.secrets[r.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"errors":[]}`)
+		return
+	}
+
+	fmt.Fprintf(w, `{"data":{"data":{"value":%q},"metadata":{"version":1}}}`, value)
+}
+
+func newTestVaultSecrets(t *testing.T, addr string) *vaultSecrets {
+	dir, err := ioutil.TempDir("", "vault_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	secretIDFile := filepath.Join(dir, "secret_id")
+	err = ioutil.WriteFile(secretIDFile, []byte(testVaultSecretID+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vault, err := newVaultSecrets(addr, "", "secret", testVaultRoleID, secretIDFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return vault
+}
+
+func TestVaultSecrets(t *testing.T) {
+	tenantID := "7a4b6fd4-3c42-4e7e-a8e5-0d6a1f3a8c11"
+	tv := &testVault{
+		secrets: map[string]string{
+			"/v1/secret/data/" + tenantID + "/api-key": "s3cr3t",
+		},
+	}
+	server := httptest.NewServer(tv)
+	defer server.Close()
+
+	_, err := newVaultSecrets("vault:8200", "", "secret", testVaultRoleID, "/nonexistent")
+	if err == nil {
+		t.Fatal("Expected error for an invalid Vault address")
+	}
+
+	vault := newTestVaultSecrets(t, server.URL)
+
+	value, err := vault.GetSecret(tenantID, "api-key")
+	if err != nil || value != "s3cr3t" {
+		t.Fatalf("Unexpected secret %q: %v", value, err)
+	}
+
+	_, err = vault.GetSecret(tenantID, "missing")
+	if err != types.ErrSecretNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrSecretNotFound, err)
+	}
+
+	tv.Lock()
+	tv.revoked = true
+	tv.Unlock()
+
+	value, err = vault.GetSecret(tenantID, "api-key")
+	if err != nil || value != "s3cr3t" {
+		t.Fatalf("Secret not read after token revocation %q: %v", value, err)
+	}
+
+	if tv.tokens != 2 {
+		t.Fatalf("Expected 2 logins, got %d", tv.tokens)
+	}
+
+	vault.secretID = "wrong"
+	vault.token = ""
+	_, err = vault.GetSecret(tenantID, "api-key")
+	if err == nil || err == types.ErrSecretNotFound {
+		t.Fatalf("Expected login error, got %v", err)
+	}
+}
+
+func TestVaultSecretsFallback(t *testing.T) {
+	ctx := context.Background()
+
+	tenant, err := addTestTenant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tv := &testVault{
+		secrets: map[string]string{
+			"/v1/secret/data/" + tenant.ID + "/api-key":  "from-vault",
+			"/v1/secret/data/" + tenant.ID + "/shadowed": "from-vault",
+		},
+	}
+	server := httptest.NewServer(tv)
+	defer server.Close()
+
+	for _, name := range []string{"shadowed", "builtin"} {
+		err = ctl.SetSecret(ctx, tenant.ID, name, "from-builtin")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backends := ctl.secretBackends
+	ctl.secretBackends = []secretBackend{newTestVaultSecrets(t, server.URL)}
+	defer func() { ctl.secretBackends = backends }()
+
+	wl := types.Workload{
+		TenantID: tenant.ID,
+		Secrets:  []string{"api-key", "shadowed", "builtin"},
+	}
+
+	secrets, err := ctl.instanceSecrets(ctx, tenant.ID, &wl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"api-key":  "from-vault",
+		"shadowed": "from-vault",
+		"builtin":  "from-builtin",
+	}
+	for name, value := range expected {
+		if secrets[name] != value {
+			t.Errorf("Expected %s to be %q, got %q", name, value, secrets[name])
+		}
+	}
+
+	wl.Secrets = []string{"missing"}
+	err = ctl.validateWorkloadSecrets(ctx, &wl)
+	if err != types.ErrSecretNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrSecretNotFound, err)
+	}
+
+	server.Close()
+	_, err = ctl.resolveSecret(ctx, tenant.ID, "builtin")
+	if err == nil {
+		t.Fatal("Expected error when Vault is unreachable")
+	}
+}
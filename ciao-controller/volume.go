@@ -15,78 +15,421 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/ciao-project/ciao/ciao-storage"
 	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
 	"github.com/golang/glog"
 )
 
 // CreateVolume will create a new block device and store it in the datastore.
-func (c *controller) CreateVolume(tenant string, req api.RequestedVolume) (types.Volume, error) {
-	var bd storage.BlockDevice
+// Volumes cloned from an image or from another volume are created
+// asynchronously: the returned volume is pending and its state is updated
+// in the datastore as the clone proceeds.  The progress of the clone is
+// reported by the operation whose ID is returned with the volume.
+func (c *controller) CreateVolume(ctx context.Context, tenant string, req api.RequestedVolume) (types.Volume, error) {
+	release, err := c.reserveNames(volumeResource, tenant, req.Name)
+	if err != nil {
+		return types.Volume{}, err
+	}
+	defer release()
 
-	var err error
-	// no limits checking for now.
-	if req.ImageRef != "" {
-		// create bootable volume
-		bd, err = c.CreateBlockDeviceFromSnapshot(req.ImageRef, "ciao-image")
-		bd.Bootable = true
-	} else if req.SourceVolID != "" {
-		// copy existing volume
-		bd, err = c.CopyBlockDevice(req.SourceVolID)
-	} else {
-		// create empty volume
-		bd, err = c.CreateBlockDevice("", "", req.Size)
+	if req.ImageRef == "" && req.SourceVolID == "" {
+		return c.createVolume(ctx, tenant, req)
 	}
 
-	if err == nil && req.Size > bd.Size {
-		bd.Size, err = c.Resize(bd.ID, req.Size)
+	// volumes in the recycle bin cannot be cloned.
+	if source, err := c.ds.GetBlockDevice(req.SourceVolID); err == nil && source.State == types.Deleted {
+		return types.Volume{}, types.ErrVolumeNotFound
+	}
+
+	if err := c.checkVolumeClass(&req); err != nil {
+		return types.Volume{}, err
+	}
+
+	if err := c.checkVolumeEncryption(&req); err != nil {
+		return types.Volume{}, err
 	}
 
+	data := newVolume(tenant, req, uuid.Generate().String())
+	data.State = types.Pending
+	data.Size = c.cloneSize(req)
+
+	// the quota is reserved for the size the volume is expected to
+	// have, and adjusted once it is cloned.
+	resources, err := c.consumeVolumeQuota(data)
 	if err != nil {
 		return types.Volume{}, err
 	}
 
+	err = c.ds.AddBlockDevice(ctx, data)
+	if err != nil {
+		if resources != nil {
+			c.qs.Release(tenant, resources...)
+		}
+		return types.Volume{}, err
+	}
+
+	op := c.newOperation(tenant, types.VolumeClone, data.ID)
+	go c.cloneVolume(context.Background(), data, req, op)
+
+	data.OperationID = op.ID
+
+	return data, nil
+}
+
+// cloneSize returns the size in GiB a cloned volume is expected to have,
+// i.e., the size of its source, or the requested size if it is larger.
+func (c *controller) cloneSize(req api.RequestedVolume) int {
+	size := 0
+
+	if req.SourceVolID != "" {
+		if source, err := c.ds.GetBlockDevice(req.SourceVolID); err == nil {
+			size = source.Size
+		}
+	} else if image, err := c.ds.GetImage(req.ImageRef); err == nil {
+		size = int((image.Size + 1<<30 - 1) >> 30)
+	}
+
+	if req.Size > size {
+		size = req.Size
+	}
+
+	return size
+}
+
+// storagePool returns the Ceph pool holding the volumes of a storage class.
+// Volumes without a class are held by the default pool.
+func (c *controller) storagePool(class string) (string, error) {
+	if class == "" {
+		return "", nil
+	}
+
+	pool, ok := c.storageClasses[class]
+	if !ok {
+		return "", types.ErrBadRequest
+	}
+
+	return pool, nil
+}
+
+// volumeDriver returns the block driver managing the volumes of a storage
+// class.
+func (c *controller) volumeDriver(class string) (storage.BlockDriver, error) {
+	pool, err := c.storagePool(class)
+	if err != nil {
+		return nil, err
+	}
+
+	if pool == "" {
+		return c.BlockDriver, nil
+	}
+
+	return c.BlockDriver.WithPool(pool), nil
+}
+
+// volumePool returns the Ceph pool holding a volume. It is empty for the
+// volumes of the default pool and for volumes unknown to the datastore.
+func (c *controller) volumePool(volumeID string) string {
+	volume, err := c.ds.GetBlockDevice(volumeID)
+	if err != nil {
+		return ""
+	}
+
+	pool, _ := c.storagePool(volume.Class)
+	return pool
+}
+
+// deleteVolumeBlockDevice removes the block device backing a volume from
+// the pool of its storage class, along with its key if it is encrypted.
+func (c *controller) deleteVolumeBlockDevice(ctx context.Context, data types.Volume) error {
+	// archived volumes have no block device, only an archive.
+	if data.State == types.Archived {
+		err := removeVolumeArchive(data.ID)
+		if err != nil {
+			return err
+		}
+
+		c.deleteVolumeKey(ctx, data)
+
+		return nil
+	}
+
+	driver, err := c.volumeDriver(data.Class)
+	if err != nil {
+		return err
+	}
+
+	err = driver.DeleteBlockDevice(data.ID)
+	if err != nil {
+		return err
+	}
+
+	c.deleteVolumeKey(ctx, data)
+
+	return nil
+}
+
+// volumeResources returns the quota resources consumed by a volume. The
+// volumes of a storage class also consume the storage of their class and
+// archived volumes only consume archive storage.
+func volumeResources(data types.Volume) []payloads.RequestedResource {
+	resources := []payloads.RequestedResource{
+		{Type: payloads.Volume, Value: 1},
+	}
+
+	if data.State == types.Archived {
+		return append(resources, archiveResources(data)...)
+	}
+
+	return append(resources, storageResources(data)...)
+}
+
+// storageResources returns the quota resources consumed by the block
+// device of a volume.
+func storageResources(data types.Volume) []payloads.RequestedResource {
+	resources := []payloads.RequestedResource{
+		{Type: payloads.SharedDiskGiB, Value: data.Size},
+	}
+
+	if data.Class != "" {
+		resources = append(resources, payloads.RequestedResource{
+			Type:  payloads.StorageClassDiskGiB(data.Class),
+			Value: data.Size,
+		})
+	}
+
+	return resources
+}
+
+func newVolume(tenant string, req api.RequestedVolume, ID string) types.Volume {
 	// store block device data in datastore
 	// TBD - do we really need to do this, or can we associate
 	// the block device data with the device itself?
 	// you should modify BlockData to include a "bootable" flag.
-	data := types.Volume{
-		BlockDevice: bd,
+	return types.Volume{
+		BlockDevice: storage.BlockDevice{
+			ID:       ID,
+			Bootable: req.ImageRef != "",
+		},
 		CreateTime:  time.Now(),
 		TenantID:    tenant,
-		State:       types.Available,
 		Name:        req.Name,
 		Description: req.Description,
 		Internal:    req.Internal,
+		Class:       req.Class,
+		Encrypted:   req.Encrypted,
 	}
+}
 
-	// It's best to make the quota request here as we don't know the volume
-	// size earlier. If the ceph cluster is full then it might error out
-	// earlier.
-	resources := []payloads.RequestedResource{
-		{Type: payloads.Volume, Value: 1},
-		{Type: payloads.SharedDiskGiB, Value: bd.Size},
+// createBlockDevice creates the block device backing a volume request in
+// the pool of its storage class.  The block device ID is set to ID, or to a
+// random one if ID is empty.
+func (c *controller) createBlockDevice(driver storage.BlockDriver, req api.RequestedVolume, ID string) (storage.BlockDevice, error) {
+	var bd storage.BlockDevice
+	var err error
+
+	// no limits checking for now.
+	if req.ImageRef != "" {
+		// create bootable volume
+		bd, err = driver.CreateBlockDeviceFromSnapshot(req.ImageRef, "ciao-image", ID)
+		bd.Bootable = true
+	} else if req.SourceVolID != "" {
+		// copy existing volume
+		bd, err = driver.CopyBlockDevice(req.SourceVolID, ID)
+	} else {
+		// create empty volume
+		bd, err = driver.CreateBlockDevice(ID, "", req.Size)
+	}
+
+	return bd, err
+}
+
+// checkVolumeClass checks the storage class of a volume request. Volumes
+// are copied within the pool of the source volume so a copy must be of the
+// class of its source.
+func (c *controller) checkVolumeClass(req *api.RequestedVolume) error {
+	if _, err := c.storagePool(req.Class); err != nil {
+		return err
+	}
+
+	if req.SourceVolID == "" {
+		return nil
+	}
+
+	// unknown source volumes are reported by the storage driver.
+	source, err := c.ds.GetBlockDevice(req.SourceVolID)
+	if err != nil {
+		return nil
+	}
+
+	if req.Class == "" {
+		req.Class = source.Class
+	} else if req.Class != source.Class {
+		return types.ErrBadRequest
 	}
 
-	if !data.Internal {
-		res := <-c.qs.Consume(tenant, resources...)
+	return nil
+}
+
+// checkVolumeEncryption checks the encryption of a volume request.  Image
+// volumes hold the content of their image so they cannot be encrypted, and
+// a copy of a volume is encrypted if and only if its source is.
+func (c *controller) checkVolumeEncryption(req *api.RequestedVolume) error {
+	if req.Encrypted && req.ImageRef != "" {
+		return types.ErrBadRequest
+	}
+
+	if req.SourceVolID == "" {
+		return nil
+	}
+
+	// unknown source volumes are reported by the storage driver.
+	source, err := c.ds.GetBlockDevice(req.SourceVolID)
+	if err != nil {
+		return nil
+	}
+
+	if req.Encrypted && !source.Encrypted {
+		return types.ErrBadRequest
+	}
+	req.Encrypted = source.Encrypted
+
+	return nil
+}
+
+// createVolumeKey stores the LUKS passphrase of a new encrypted volume.  The
+// launcher formats the volume with this passphrase the first time it maps
+// it, and a copy of a volume shares the passphrase of its source.
+func (c *controller) createVolumeKey(ctx context.Context, data types.Volume, req api.RequestedVolume) error {
+	if !data.Encrypted {
+		return nil
+	}
+
+	var key string
+	var err error
 
-		if !res.Allowed() {
-			_ = c.DeleteBlockDevice(bd.ID)
-			c.qs.Release(tenant, res.Resources()...)
-			return types.Volume{}, api.ErrQuota
+	if req.SourceVolID != "" {
+		key, err = c.volumeKey(ctx, req.SourceVolID)
+		if err == nil && key == "" {
+			err = fmt.Errorf("No key found for volume %s", req.SourceVolID)
 		}
+	} else {
+		key, err = newVolumeKey()
 	}
 
-	err = c.ds.AddBlockDevice(data)
 	if err != nil {
-		_ = c.DeleteBlockDevice(bd.ID)
-		if !data.Internal {
+		return err
+	}
+
+	return c.storeVolumeKey(ctx, data.TenantID, data.ID, key)
+}
+
+// adjustVolumeQuota adjusts the quota reserved for a cloned volume, of
+// reserved GiB, to the actual size of the volume.
+func (c *controller) adjustVolumeQuota(data types.Volume, reserved int) error {
+	if data.Internal || data.Size == reserved {
+		return nil
+	}
+
+	diff := data
+	if data.Size < reserved {
+		diff.Size = reserved - data.Size
+		c.qs.Release(data.TenantID, storageResources(diff)...)
+		return nil
+	}
+
+	diff.Size = data.Size - reserved
+	res := <-c.qs.Consume(data.TenantID, storageResources(diff)...)
+	if !res.Allowed() {
+		c.qs.Release(data.TenantID, res.Resources()...)
+		return api.ErrQuota
+	}
+
+	return nil
+}
+
+// consumeVolumeQuota reserves the quota for a newly created volume.
+// It's best to make the quota request once the block device is created
+// as we don't know the volume size earlier. If the ceph cluster is full
+// then it might error out earlier.
+func (c *controller) consumeVolumeQuota(data types.Volume) ([]payloads.RequestedResource, error) {
+	resources := volumeResources(data)
+
+	if data.Internal {
+		return nil, nil
+	}
+
+	res := <-c.qs.Consume(data.TenantID, resources...)
+	if !res.Allowed() {
+		c.qs.Release(data.TenantID, res.Resources()...)
+		return nil, api.ErrQuota
+	}
+
+	return resources, nil
+}
+
+// createVolume synchronously creates a new block device and stores it in
+// the datastore.
+func (c *controller) createVolume(ctx context.Context, tenant string, req api.RequestedVolume) (types.Volume, error) {
+	if err := c.checkVolumeClass(&req); err != nil {
+		return types.Volume{}, err
+	}
+
+	if err := c.checkVolumeEncryption(&req); err != nil {
+		return types.Volume{}, err
+	}
+
+	driver, err := c.volumeDriver(req.Class)
+	if err != nil {
+		return types.Volume{}, err
+	}
+
+	bd, err := c.createBlockDevice(driver, req, "")
+	if err == nil && req.Size > bd.Size {
+		bd.Size, err = driver.Resize(bd.ID, req.Size)
+	}
+
+	if err != nil {
+		return types.Volume{}, err
+	}
+
+	return c.addVolume(ctx, tenant, req, driver, bd)
+}
+
+// addVolume stores a volume backed by a newly created block device in the
+// datastore, consuming its quota.  The block device is deleted if the
+// volume cannot be added.
+func (c *controller) addVolume(ctx context.Context, tenant string, req api.RequestedVolume,
+	driver storage.BlockDriver, bd storage.BlockDevice) (types.Volume, error) {
+	data := newVolume(tenant, req, bd.ID)
+	data.BlockDevice = bd
+	data.State = types.Available
+
+	err := c.createVolumeKey(ctx, data, req)
+	if err != nil {
+		_ = driver.DeleteBlockDevice(bd.ID)
+		return types.Volume{}, err
+	}
+
+	resources, err := c.consumeVolumeQuota(data)
+	if err != nil {
+		_ = driver.DeleteBlockDevice(bd.ID)
+		c.deleteVolumeKey(ctx, data)
+		return types.Volume{}, err
+	}
+
+	err = c.ds.AddBlockDevice(ctx, data)
+	if err != nil {
+		_ = driver.DeleteBlockDevice(bd.ID)
+		c.deleteVolumeKey(ctx, data)
+		if resources != nil {
 			c.qs.Release(tenant, resources...)
 		}
 		return types.Volume{}, err
@@ -95,7 +438,82 @@ func (c *controller) CreateVolume(tenant string, req api.RequestedVolume) (types
 	return data, nil
 }
 
-func (c *controller) DeleteVolume(tenant string, volume string) error {
+func (c *controller) updateVolumeState(ctx context.Context, data *types.Volume, state types.BlockState) {
+	data.State = state
+
+	err := c.ds.UpdateBlockDevice(ctx, *data)
+	if err != nil {
+		glog.Errorf("Error updating volume %s state: %v", data.ID, err)
+	}
+}
+
+// cloneVolume clones the block device of a pending volume and makes it
+// available, or puts it in error if the clone fails, releasing the quota
+// reserved for the volume.  The progress and the outcome of the clone are
+// reported through the operation record of the volume, which is only
+// completed once the volume is available.
+func (c *controller) cloneVolume(ctx context.Context, data types.Volume, req api.RequestedVolume, op types.Operation) {
+	reserved := data.Size
+
+	fail := func(err error) {
+		glog.Errorf("Error cloning volume %s: %v", data.ID, err)
+		if !data.Internal {
+			reservation := data
+			reservation.Size = reserved
+			c.qs.Release(data.TenantID, volumeResources(reservation)...)
+		}
+		c.updateVolumeState(ctx, &data, types.VolumeError)
+		c.completeOperation(&op, err)
+	}
+
+	c.updateVolumeState(ctx, &data, types.Cloning)
+
+	driver, err := c.volumeDriver(data.Class)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	bd, err := c.createBlockDevice(driver, req, data.ID)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	bd.Bootable = data.Bootable
+	data.BlockDevice = bd
+	c.updateOperation(&op, 50)
+
+	if req.Size > bd.Size {
+		data.Size, err = driver.Resize(bd.ID, req.Size)
+		if err != nil {
+			_ = driver.DeleteBlockDevice(bd.ID)
+			fail(err)
+			return
+		}
+		c.updateOperation(&op, 75)
+	}
+
+	err = c.createVolumeKey(ctx, data, req)
+	if err != nil {
+		_ = driver.DeleteBlockDevice(bd.ID)
+		fail(err)
+		return
+	}
+
+	err = c.adjustVolumeQuota(data, reserved)
+	if err != nil {
+		_ = driver.DeleteBlockDevice(bd.ID)
+		c.deleteVolumeKey(ctx, data)
+		fail(err)
+		return
+	}
+
+	c.updateVolumeState(ctx, &data, types.Available)
+	c.completeOperation(&op, nil)
+}
+
+func (c *controller) DeleteVolume(ctx context.Context, tenant string, volume string) error {
 	// get the block device information
 	info, err := c.ds.GetBlockDevice(volume)
 	if err != nil {
@@ -107,32 +525,40 @@ func (c *controller) DeleteVolume(tenant string, volume string) error {
 		return api.ErrVolumeOwner
 	}
 
-	// check that the block device is available.
-	if info.State != types.Available {
-		return api.ErrVolumeNotAvailable
+	// volumes in the recycle bin are purged through the recycle bin.
+	if info.State == types.Deleted {
+		return types.ErrVolumeNotFound
 	}
 
-	// remove the block data from our datastore.
-	err = c.ds.DeleteBlockDevice(volume)
-	if err != nil {
-		return err
+	// a volume which creation failed does not consume any quota.  Its
+	// block device was deleted when the creation failed, unless the
+	// controller stopped while cloning it.
+	if info.State == types.VolumeError {
+		if driver, err := c.volumeDriver(info.Class); err == nil {
+			_ = driver.DeleteBlockDevice(info.ID)
+		}
+		return c.ds.DeleteBlockDevice(ctx, volume)
 	}
 
-	// tell the underlying storage media to remove.
-	err = c.DeleteBlockDevice(volume)
-	if err != nil {
-		return err
+	// archived volumes left behind by their instance are deleted along
+	// with their archive, they never go to the recycle bin.
+	if info.State == types.Archived {
+		return c.purgeVolume(ctx, info)
+	}
+
+	// check that the block device is available.
+	if info.State != types.Available {
+		return api.ErrVolumeNotAvailable
 	}
 
-	// release quota associated with this volume
-	c.qs.Release(info.TenantID,
-		payloads.RequestedResource{Type: payloads.Volume, Value: 1},
-		payloads.RequestedResource{Type: payloads.SharedDiskGiB, Value: info.Size})
+	if c.deletedRetention > 0 {
+		return c.softDeleteVolume(ctx, info)
+	}
 
-	return nil
+	return c.purgeVolume(ctx, info)
 }
 
-func (c *controller) AttachVolume(tenant string, volume string, instance string, mountpoint string) error {
+func (c *controller) AttachVolume(ctx context.Context, tenant string, volume string, instance string, mountpoint string) error {
 	// get the block device information
 	info, err := c.ds.GetBlockDevice(volume)
 	if err != nil {
@@ -158,7 +584,7 @@ func (c *controller) AttachVolume(tenant string, volume string, instance string,
 	// update volume state to attaching
 	info.State = types.Attaching
 
-	err = c.ds.UpdateBlockDevice(info)
+	err = c.ds.UpdateBlockDevice(ctx, info)
 	if err != nil {
 		return err
 	}
@@ -169,10 +595,10 @@ func (c *controller) AttachVolume(tenant string, volume string, instance string,
 		Ephemeral: false,
 		Bootable:  false,
 	}
-	_, err = c.ds.CreateStorageAttachment(i.ID, a)
+	_, err = c.ds.CreateStorageAttachment(ctx, i.ID, a)
 	if err != nil {
 		info.State = types.Available
-		dsErr := c.ds.UpdateBlockDevice(info)
+		dsErr := c.ds.UpdateBlockDevice(ctx, info)
 		if dsErr != nil {
 			glog.Error(dsErr)
 		}
@@ -183,7 +609,7 @@ func (c *controller) AttachVolume(tenant string, volume string, instance string,
 	err = c.client.attachVolume(volume, instance, i.NodeID)
 	if err != nil {
 		info.State = types.Available
-		dsErr := c.ds.UpdateBlockDevice(info)
+		dsErr := c.ds.UpdateBlockDevice(ctx, info)
 		if dsErr != nil {
 			glog.Error(dsErr)
 		}
@@ -193,7 +619,7 @@ func (c *controller) AttachVolume(tenant string, volume string, instance string,
 	return nil
 }
 
-func (c *controller) DetachVolume(tenant string, volume string, attachment string) error {
+func (c *controller) DetachVolume(ctx context.Context, tenant string, volume string, attachment string) error {
 	// we don't support detaching by attachment ID yet.
 	if attachment != "" {
 		return errors.New("Detaching by attachment ID not implemented")
@@ -259,7 +685,7 @@ func (c *controller) DetachVolume(tenant string, volume string, attachment strin
 		// update volume state to detaching
 		info.State = types.Available
 
-		err = c.ds.UpdateBlockDevice(info)
+		err = c.ds.UpdateBlockDevice(ctx, info)
 		if err != nil {
 			return err
 		}
@@ -268,7 +694,7 @@ func (c *controller) DetachVolume(tenant string, volume string, attachment strin
 	return retval
 }
 
-func (c *controller) ListVolumesDetail(tenant string) ([]types.Volume, error) {
+func (c *controller) ListVolumesDetail(ctx context.Context, tenant string) ([]types.Volume, error) {
 	vols := []types.Volume{}
 
 	devs, err := c.ds.GetBlockDevices(tenant)
@@ -277,17 +703,18 @@ func (c *controller) ListVolumesDetail(tenant string) ([]types.Volume, error) {
 	}
 
 	for _, vol := range devs {
-		if vol.Internal {
+		if vol.Internal || vol.State == types.Deleted {
 			continue
 		}
 
+		vol.OperationID = c.resourceOperationID(vol.ID, types.VolumeClone)
 		vols = append(vols, vol)
 	}
 
 	return vols, nil
 }
 
-func (c *controller) ShowVolumeDetails(tenant string, volume string) (types.Volume, error) {
+func (c *controller) ShowVolumeDetails(ctx context.Context, tenant string, volume string) (types.Volume, error) {
 	vol, err := c.ds.GetBlockDevice(volume)
 	if err != nil {
 		return types.Volume{}, err
@@ -297,5 +724,11 @@ func (c *controller) ShowVolumeDetails(tenant string, volume string) (types.Volu
 		return types.Volume{}, api.ErrVolumeOwner
 	}
 
+	if vol.State == types.Deleted {
+		return types.Volume{}, types.ErrVolumeNotFound
+	}
+
+	vol.OperationID = c.resourceOperationID(vol.ID, types.VolumeClone)
+
 	return vol, nil
 }
@@ -15,6 +15,7 @@
 package main
 
 import (
+	"context"
 	"github.com/golang/glog"
 
 	"github.com/ciao-project/ciao/ciao-controller/types"
@@ -46,7 +47,7 @@ func validateContainerWorkload(req *types.Workload) error {
 	return nil
 }
 
-func (c *controller) validateWorkloadStorageSourceID(storage *types.StorageResource, tenantID string) error {
+func (c *controller) validateWorkloadStorageSourceID(ctx context.Context, storage *types.StorageResource, tenantID string) error {
 	if storage.Source == "" {
 		// you may only use no source id with empty type
 		if storage.SourceType != types.Empty {
@@ -56,7 +57,7 @@ func (c *controller) validateWorkloadStorageSourceID(storage *types.StorageResou
 
 	if storage.SourceType == types.ImageService {
 		// If the source was specified by name this will resolve it to an ID and fix it
-		image, err := c.GetImage(tenantID, storage.Source)
+		image, err := c.GetImage(ctx, tenantID, storage.Source)
 		if err != nil {
 			return types.ErrBadRequest
 		}
@@ -64,7 +65,7 @@ func (c *controller) validateWorkloadStorageSourceID(storage *types.StorageResou
 	}
 
 	if storage.SourceType == types.VolumeService {
-		_, err := c.ShowVolumeDetails(tenantID, storage.Source)
+		_, err := c.ShowVolumeDetails(ctx, tenantID, storage.Source)
 		if err != nil {
 			return types.ErrBadRequest
 		}
@@ -72,7 +73,7 @@ func (c *controller) validateWorkloadStorageSourceID(storage *types.StorageResou
 	return nil
 }
 
-func (c *controller) validateWorkloadStorage(req *types.Workload) error {
+func (c *controller) validateWorkloadStorage(ctx context.Context, req *types.Workload) error {
 	bootableCount := 0
 	for i := range req.Storage {
 		// check that a workload type is specified
@@ -85,6 +86,13 @@ func (c *controller) validateWorkloadStorage(req *types.Workload) error {
 			return types.ErrBadRequest
 		}
 
+		// CD-ROMs of VMs are created from images, such as
+		// installer or tools media.
+		if req.Storage[i].CDROM &&
+			(req.Storage[i].SourceType != types.ImageService || req.VMType != payloads.QEMU) {
+			return types.ErrBadRequest
+		}
+
 		if req.Storage[i].ID != "" {
 			// validate that the id is at least valid
 			// uuid4.
@@ -99,7 +107,7 @@ func (c *controller) validateWorkloadStorage(req *types.Workload) error {
 			}
 		}
 
-		err := c.validateWorkloadStorageSourceID(&req.Storage[i], req.TenantID)
+		err := c.validateWorkloadStorageSourceID(ctx, &req.Storage[i], req.TenantID)
 		if err != nil {
 			return err
 		}
@@ -118,7 +126,7 @@ func (c *controller) validateWorkloadStorage(req *types.Workload) error {
 }
 
 // this is probably an insufficient amount of checking.
-func (c *controller) validateWorkloadRequest(req *types.Workload) error {
+func (c *controller) validateWorkloadRequest(ctx context.Context, req *types.Workload) error {
 	// ID must be blank.
 	if req.ID != "" {
 		glog.V(2).Info("Invalid workload request: ID is not blank")
@@ -151,46 +159,96 @@ func (c *controller) validateWorkloadRequest(req *types.Workload) error {
 		return types.ErrBadRequest
 	}
 
+	if req.Requirements.DiskIOPS < 0 || req.Requirements.NetMbps < 0 {
+		glog.V(2).Info("Invalid workload request: negative I/O requirements")
+		return types.ErrBadRequest
+	}
+
+	if req.Requirements.DiskMB < 0 {
+		glog.V(2).Info("Invalid workload request: negative disk requirement")
+		return types.ErrBadRequest
+	}
+
+	if req.Requirements.Priority.Level() < 0 {
+		glog.V(2).Info("Invalid workload request: unknown priority class")
+		return types.ErrBadRequest
+	}
+
+	// only VMs can be given a watchdog device.
+	if req.Requirements.Watchdog != "" &&
+		(req.VMType != payloads.QEMU || !req.Requirements.Watchdog.Valid()) {
+		glog.V(2).Info("Invalid workload request: invalid watchdog action")
+		return types.ErrBadRequest
+	}
+
+	// only containers are given a terminal for their console.
+	if req.Requirements.Console && req.VMType != payloads.Docker {
+		glog.V(2).Info("Invalid workload request: console requested for a VM")
+		return types.ErrBadRequest
+	}
+
+	// only public workloads can be published in the catalog.
+	if req.Category != "" && req.Visibility != types.Public {
+		glog.V(2).Info("Invalid workload request: category set on non public workload")
+		return types.ErrBadRequest
+	}
+
+	if req.Autoscale != nil {
+		err := validateAutoscalePolicy(req)
+		if err != nil {
+			glog.V(2).Info("Invalid workload request: invalid autoscaling policy")
+			return err
+		}
+	}
+
 	if len(req.Storage) > 0 {
-		err := c.validateWorkloadStorage(req)
+		err := c.validateWorkloadStorage(ctx, req)
 		if err != nil {
 			glog.V(2).Info("Invalid workload request: invalid storage")
 			return err
 		}
 	}
 
+	if len(req.Secrets) > 0 {
+		err := c.validateWorkloadSecrets(ctx, req)
+		if err != nil {
+			glog.V(2).Info("Invalid workload request: invalid secrets")
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (c *controller) CreateWorkload(req types.Workload) (types.Workload, error) {
+func (c *controller) CreateWorkload(ctx context.Context, req types.Workload) (types.Workload, error) {
 	// If the any storage sources use a name for an image these will be resolved to
 	// an ID in-place. Hence why this takes a pointer to the workload.
 
-	err := c.validateWorkloadRequest(&req)
+	err := c.validateWorkloadRequest(ctx, &req)
 	if err != nil {
 		return req, err
 	}
 
 	req.ID = uuid.Generate().String()
 
-	err = c.ds.AddWorkload(req)
+	err = c.ds.AddWorkload(ctx, req)
 	return req, err
 }
 
-func (c *controller) DeleteWorkload(tenantID string, workloadID string) error {
+func (c *controller) DeleteWorkload(ctx context.Context, tenantID string, workloadID string) error {
 	wl, err := c.ds.GetWorkload(workloadID)
 	if err != nil {
 		return err
 	}
 
 	if tenantID == "admin" || tenantID == wl.TenantID {
-		return c.ds.DeleteWorkload(workloadID)
+		return c.ds.DeleteWorkload(ctx, workloadID)
 	}
 
 	return types.ErrWorkloadNotFound
 }
 
-func (c *controller) ShowWorkload(tenantID string, workloadID string) (types.Workload, error) {
+func (c *controller) ShowWorkload(ctx context.Context, tenantID string, workloadID string) (types.Workload, error) {
 	wl, err := c.ds.GetWorkload(workloadID)
 	if err != nil {
 		return wl, err
@@ -203,6 +261,68 @@ func (c *controller) ShowWorkload(tenantID string, workloadID string) (types.Wor
 	return types.Workload{}, types.ErrWorkloadNotFound
 }
 
-func (c *controller) ListWorkloads(tenantID string) ([]types.Workload, error) {
+func (c *controller) ListWorkloads(ctx context.Context, tenantID string) ([]types.Workload, error) {
 	return c.ds.GetWorkloads(tenantID)
 }
+
+// ListCatalog returns the public workloads published in the catalog,
+// optionally restricted to a single category.
+func (c *controller) ListCatalog(ctx context.Context, category string) ([]types.Workload, error) {
+	wls, err := c.ds.GetWorkloads("")
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := []types.Workload{}
+	for _, wl := range wls {
+		if wl.Visibility != types.Public || wl.Category == "" {
+			continue
+		}
+
+		if category != "" && wl.Category != category {
+			continue
+		}
+
+		catalog = append(catalog, wl)
+	}
+
+	return catalog, nil
+}
+
+// UpdateCatalogWorkload publishes a public workload in the catalog under
+// the given category, or removes it from the catalog if category is empty.
+func (c *controller) UpdateCatalogWorkload(ctx context.Context, workloadID string, category string) error {
+	wl, err := c.ds.GetWorkload(workloadID)
+	if err != nil {
+		return err
+	}
+
+	if wl.Visibility != types.Public {
+		return types.ErrWorkloadNotFound
+	}
+
+	return c.ds.UpdateWorkloadCategory(ctx, workloadID, category)
+}
+
+// CloneCatalogWorkload creates a private copy of a catalog workload owned
+// by the tenant.
+func (c *controller) CloneCatalogWorkload(ctx context.Context, tenantID string, workloadID string) (types.Workload, error) {
+	wl, err := c.ds.GetWorkload(workloadID)
+	if err != nil {
+		return types.Workload{}, err
+	}
+
+	if wl.Visibility != types.Public || wl.Category == "" {
+		return types.Workload{}, types.ErrWorkloadNotFound
+	}
+
+	req := wl
+	req.ID = ""
+	req.TenantID = tenantID
+	req.Visibility = types.Private
+	req.Category = ""
+	req.Storage = make([]types.StorageResource, len(wl.Storage))
+	copy(req.Storage, wl.Storage)
+
+	return c.CreateWorkload(ctx, req)
+}
@@ -270,6 +270,7 @@ func setupNode(ctx context.Context, anchorCertPath string, caCertPath string, ho
 		Deps: []string{
 			"docker.service",
 		},
+		WatchdogSec: launcherWatchdogSec,
 	})
 	if err != nil {
 		return errors.Wrap(err, "Error installing tool on node")
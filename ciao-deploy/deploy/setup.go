@@ -61,10 +61,21 @@ type unitFileConf struct {
 	Caps       []string
 	Roles      []string
 	Deps       []string
+
+	// WatchdogSec makes the service a Type=notify service restarted by
+	// systemd when it does not ping its watchdog for WatchdogSec
+	// seconds.
+	WatchdogSec int
+
+	// Args are appended to the command line of the service.
+	Args []string
 }
 
 const userAlreadyExistsStatus = 9
 
+// launcherWatchdogSec is the systemd watchdog timeout of ciao-launcher.
+const launcherWatchdogSec = 60
+
 var ciaoLockDir = "/tmp/lock/ciao"
 var ciaoDataDir = "/var/lib/ciao"
 var ciaoLogsDir = ciaoDataDir + "/logs"
@@ -240,9 +251,16 @@ Wants={{.Tool}}-prepare.service
 After={{.Tool}}-prepare.service
 
 [Service]
+{{- if .WatchdogSec}}
+Type=notify
+TimeoutStartSec=infinity
+WatchdogSec={{.WatchdogSec}}
+Restart=on-watchdog
+{{- else}}
 Type=simple
-ExecStart=/usr/local/bin/{{.Tool}} --cacert={{.CACertPath}} --cert={{.CertPath}} --v 3
 Restart=no
+{{- end}}
+ExecStart=/usr/local/bin/{{.Tool}} --cacert={{.CACertPath}} --cert={{.CertPath}} --v 3{{range .Args}} {{.}}{{end}}
 KillMode=process
 TasksMax=infinity
 {{with .Caps}}
@@ -392,13 +410,21 @@ func InstallTool(ctx context.Context, config unitFileConf) (errOut error) {
 	return startAndEnableService(ctx, config.Tool)
 }
 
-func installScheduler(ctx context.Context, anchorCertPath string, caCertPath string) error {
-	err := InstallTool(ctx, unitFileConf{
+func installScheduler(ctx context.Context, anchorCertPath string, caCertPath string, localLauncher bool) error {
+	conf := unitFileConf{
 		Tool:       "ciao-scheduler",
 		User:       ciaoUser,
 		CertPath:   anchorCertPath,
 		CACertPath: caCertPath,
-	})
+	}
+
+	// the local launcher is both the compute and the network node of
+	// the cluster, so tenant workloads have to run next to their CNCI.
+	if localLauncher {
+		conf.Args = []string{"--allow-cnci-colocation"}
+	}
+
+	err := InstallTool(ctx, conf)
 	return errors.Wrap(err, "Error installing scheduler")
 }
 
@@ -638,8 +664,8 @@ func installControlPlaneCerts(ctx context.Context, force bool, clusterConf *Clus
 	}, nil
 }
 
-func setupControlPlane(ctx context.Context, imageCacheDir string, certs certPaths) (errOut error) {
-	err := installScheduler(ctx, certs.anchorCertPath, certs.caCertPath)
+func setupControlPlane(ctx context.Context, imageCacheDir string, certs certPaths, localLauncher bool) (errOut error) {
+	err := installScheduler(ctx, certs.anchorCertPath, certs.caCertPath, localLauncher)
 	if err != nil {
 		return errors.Wrap(err, "Error installing scheduler")
 	}
@@ -690,7 +716,7 @@ func SetupMaster(ctx context.Context, force bool, imageCacheDir string, clusterC
 		}
 	}()
 
-	return setupControlPlane(ctx, imageCacheDir, certs)
+	return setupControlPlane(ctx, imageCacheDir, certs, localLauncher)
 }
 
 func createLocalLauncherCert(ctx context.Context, anchorCertPath string) (string, error) {
@@ -730,6 +756,7 @@ func installLocalLauncher(ctx context.Context, launcherCertPath string, caCertPa
 		Deps: []string{
 			"docker.service",
 		},
+		WatchdogSec: launcherWatchdogSec,
 	})
 	return errors.Wrap(err, "Error installing launcher")
 }
@@ -763,7 +790,12 @@ func UpdateMaster(ctx context.Context) error {
 	controllerCertPath := path.Join(ciaoPKIDir, CertName(ssntp.Controller))
 	caCertPath := path.Join(ciaoPKIDir, "CAcert.pem")
 
-	if err := installScheduler(ctx, anchorCertPath, caCertPath); err != nil {
+	// masters set up with a local launcher keep allowing CNCI
+	// co-location.
+	_, err := os.Stat(path.Join("/etc/systemd/system", "ciao-launcher.service"))
+	localLauncher := err == nil
+
+	if err := installScheduler(ctx, anchorCertPath, caCertPath, localLauncher); err != nil {
 		return errors.Wrap(err, "Error installating scheduler")
 	}
 
@@ -23,7 +23,8 @@ import (
 )
 
 func processAttachVolume(storageDriver storage.BlockDriver, monitorCh chan interface{}, cfg *vmConfig,
-	instance, instanceDir, volumeUUID string, conn serverConn) *attachVolumeError {
+	instance, instanceDir string, vol volumeConfig, conn serverConn) *attachVolumeError {
+	volumeUUID := vol.UUID
 
 	if cfg.Container {
 		attachErr := &attachVolumeError{nil, payloads.AttachVolumeNotSupported}
@@ -39,6 +40,7 @@ func processAttachVolume(storageDriver storage.BlockDriver, monitorCh chan inter
 	}
 
 	if monitorCh != nil {
+		storageDriver = storageDriver.WithPool(vol.Pool)
 		volumeMap, err := storageDriver.GetVolumeMapping()
 		if err != nil {
 			attachErr := &attachVolumeError{err, payloads.AttachVolumeAttachFailure}
@@ -63,6 +65,20 @@ func processAttachVolume(storageDriver storage.BlockDriver, monitorCh chan inter
 			glog.Infof("Mapped instance %s volume %s as %s", instance, volumeUUID, devName)
 		}
 
+		if vol.Encrypted {
+			devName, err = openEncryptedVolume(devName, vol)
+			if err != nil {
+				glog.Errorf("Unable to open encrypted volume %s: %v", volumeUUID, err)
+				unmapErr := storageDriver.UnmapVolumeFromNode(volumeUUID)
+				if unmapErr != nil {
+					glog.Warningf("Unable to unmap %s : %v", volumeUUID, unmapErr)
+				}
+				attachErr := &attachVolumeError{err, payloads.AttachVolumeAttachFailure}
+				return attachErr
+			}
+			glog.Infof("Opened encrypted volume %s as %s", volumeUUID, devName)
+		}
+
 		responseCh := make(chan error)
 
 		monitorCh <- virtualizerAttachCmd{
@@ -75,7 +91,7 @@ func processAttachVolume(storageDriver storage.BlockDriver, monitorCh chan inter
 		if err != nil {
 			glog.Errorf("Unable to attach volume %s to instance %s: %v",
 				volumeUUID, instance, err)
-			unmapErr := storageDriver.UnmapVolumeFromNode(devName)
+			unmapErr := unmapVolume(storageDriver, vol)
 			if unmapErr != nil {
 				glog.Warningf("Unable to unmap %s : %v", devName, unmapErr)
 			}
@@ -84,7 +100,7 @@ func processAttachVolume(storageDriver storage.BlockDriver, monitorCh chan inter
 		}
 	}
 
-	cfg.Volumes = append(cfg.Volumes, volumeConfig{UUID: volumeUUID})
+	cfg.Volumes = append(cfg.Volumes, vol)
 
 	err := cfg.save(instanceDir)
 	if err != nil {
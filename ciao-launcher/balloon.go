@@ -0,0 +1,148 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"sort"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// Instances are never ballooned below this percentage of the memory
+	// they were started with.
+	balloonMinPercent = 25
+
+	// Balloons are only deflated once the memory available on the node,
+	// not counting the memory reclaimed from the instance, exceeds this
+	// value.  This prevents us from oscillating around memHWM.
+	balloonReleaseWM = 2 * memHWM
+)
+
+// balloonIdle returns true if memory can be reclaimed from an instance
+// according to the current balloon policy.
+func balloonIdle(target *ovsInstanceState) bool {
+	if balloonPolicy == "all" {
+		return true
+	}
+
+	return target.CPUUsage >= 0 && target.CPUUsage <= balloonIdleCPU
+}
+
+// balloonCandidate returns true if the balloon of an instance can be
+// resized.  Containers and the VMs started while ballooning was disabled
+// do not have balloons and the balloon driver of a paused VM cannot
+// respond to requests.
+func balloonCandidate(target *ovsInstanceState) bool {
+	return target.balloon && !target.balloonPending &&
+		target.running == ovsRunning
+}
+
+// sortedInstances returns the UUIDs of the instances managed by the
+// overseer in a stable order so that balloon decisions are predictable.
+func (ovs *overseer) sortedInstances() []string {
+	instances := make([]string, 0, len(ovs.instances))
+	for uuid := range ovs.instances {
+		instances = append(instances, uuid)
+	}
+	sort.Strings(instances)
+	return instances
+}
+
+// inflateBalloons asks idle instances to give back memory until requests
+// for at least neededMB have been issued.  The memory reclaimed is only
+// taken into account once the instances have confirmed the new balloon
+// sizes.
+func (ovs *overseer) inflateBalloons(neededMB int) {
+	if !balloonPolicy.Enabled() || neededMB <= 0 {
+		return
+	}
+
+	for _, uuid := range ovs.sortedInstances() {
+		if neededMB <= 0 {
+			break
+		}
+
+		target := ovs.instances[uuid]
+		if !balloonCandidate(target) || !balloonIdle(target) {
+			continue
+		}
+
+		stepMB := target.maxMemoryMB * balloonStep / 100
+		minMB := target.maxMemoryMB * balloonMinPercent / 100
+		sizeMB := target.maxMemoryMB - target.reclaimedMB - stepMB
+		if sizeMB < minMB {
+			sizeMB = minMB
+		}
+
+		reclaimMB := target.maxMemoryMB - target.reclaimedMB - sizeMB
+		if reclaimMB <= 0 {
+			continue
+		}
+
+		glog.Infof("Reclaiming %d MB from instance %s", reclaimMB, uuid)
+		ovs.sendBalloonCommand(uuid, target, sizeMB)
+		neededMB -= reclaimMB
+	}
+}
+
+// deflateBalloons gives memory back to instances that are no longer idle,
+// and to all ballooned instances once the memory pressure on the node has
+// eased.
+func (ovs *overseer) deflateBalloons() {
+	if !balloonPolicy.Enabled() {
+		return
+	}
+
+	memoryAvailable := ovs.memoryAvailable
+	for _, uuid := range ovs.sortedInstances() {
+		target := ovs.instances[uuid]
+		if target.reclaimedMB == 0 || !balloonCandidate(target) {
+			continue
+		}
+
+		if balloonIdle(target) &&
+			memoryAvailable-target.reclaimedMB < balloonReleaseWM {
+			continue
+		}
+
+		glog.Infof("Returning %d MB to instance %s", target.reclaimedMB, uuid)
+		memoryAvailable -= target.reclaimedMB
+		ovs.sendBalloonCommand(uuid, target, target.maxMemoryMB)
+	}
+}
+
+// sendBalloonCommand asks an instance to resize its balloon.  The overseer
+// is not allowed to send commands to the instance go routines directly, so
+// the command is routed through the server loop from a separate go routine,
+// in the same way as killMe.
+func (ovs *overseer) sendBalloonCommand(instance string, target *ovsInstanceState, sizeMB int) {
+	target.balloonPending = true
+
+	cmdCh := ovs.ac.cmdCh
+	doneCh := ovs.childDoneCh
+	wg := ovs.childWg
+	wg.Add(1)
+	go func() {
+		cmd := &cmdWrapper{instance, &insBalloonCmd{sizeMB}}
+		select {
+		case cmdCh <- cmd:
+		case <-doneCh:
+		}
+		wg.Done()
+	}()
+}
@@ -0,0 +1,158 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func newBalloonTestOverseer() *overseer {
+	return &overseer{
+		instances: map[string]*ovsInstanceState{
+			"busy": {
+				running:     ovsRunning,
+				CPUUsage:    90,
+				maxMemoryMB: 1000,
+				balloon:     true,
+			},
+			"container": {
+				running:     ovsRunning,
+				CPUUsage:    0,
+				maxMemoryMB: 1000,
+				container:   true,
+			},
+			"idle": {
+				running:     ovsRunning,
+				CPUUsage:    1,
+				maxMemoryMB: 1000,
+				balloon:     true,
+			},
+			"no-balloon": {
+				running:     ovsRunning,
+				CPUUsage:    0,
+				maxMemoryMB: 1000,
+			},
+			"paused": {
+				running:     ovsPaused,
+				CPUUsage:    0,
+				maxMemoryMB: 1000,
+				balloon:     true,
+			},
+		},
+		ac:          &agentClient{cmdCh: make(chan *cmdWrapper)},
+		childDoneCh: make(chan struct{}),
+		childWg:     new(sync.WaitGroup),
+	}
+}
+
+func expectBalloonCmd(t *testing.T, ovs *overseer, instance string, sizeMB int) {
+	select {
+	case cmd := <-ovs.ac.cmdCh:
+		balloonCmd, ok := cmd.cmd.(*insBalloonCmd)
+		if !ok {
+			t.Fatalf("Expected balloon command, found %T", cmd.cmd)
+		}
+		if cmd.instance != instance {
+			t.Errorf("Expected balloon command for %s, found %s",
+				instance, cmd.instance)
+		}
+		if balloonCmd.sizeMB != sizeMB {
+			t.Errorf("Expected size %d, found %d", sizeMB, balloonCmd.sizeMB)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for balloon command")
+	}
+
+	ovs.processBalloonUpdateCommand(&ovsBalloonUpdate{instance,
+		ovs.instances[instance].maxMemoryMB - sizeMB})
+}
+
+// Check that the overseer inflates and deflates balloons according to
+// the balloon policy.
+//
+// Create an overseer with a number of instances, only one of which is
+// an idle VM with a balloon, and ask it to reclaim memory.  Then ask it to deflate its
+// balloons, first with insufficient and then with sufficient memory
+// available.
+//
+// Only the idle VM should be ballooned and it should never be ballooned
+// below balloonMinPercent.  Its balloon should only be deflated once
+// enough memory is available.
+func TestBalloonPolicy(t *testing.T) {
+	defer func(policy balloonPolicyFlag, step, idle int) {
+		balloonPolicy, balloonStep, balloonIdleCPU = policy, step, idle
+	}(balloonPolicy, balloonStep, balloonIdleCPU)
+
+	balloonPolicy = "idle"
+	balloonStep = 50
+	balloonIdleCPU = 5
+
+	ovs := newBalloonTestOverseer()
+
+	ovs.inflateBalloons(100)
+	expectBalloonCmd(t, ovs, "idle", 500)
+
+	ovs.inflateBalloons(100)
+	expectBalloonCmd(t, ovs, "idle", 250)
+
+	ovs.inflateBalloons(100)
+	if ovs.instances["idle"].balloonPending {
+		t.Errorf("Instance ballooned below %d%%", balloonMinPercent)
+	}
+
+	ovs.updateAvailableResources(&cnStats{availableMemMB: 0})
+	if ovs.memoryReclaimed != 750 {
+		t.Errorf("Expected 750 MB reclaimed, found %d", ovs.memoryReclaimed)
+	}
+
+	ovs.memoryAvailable = balloonReleaseWM
+	ovs.deflateBalloons()
+	if ovs.instances["idle"].balloonPending {
+		t.Error("Balloon deflated while under memory pressure")
+	}
+
+	ovs.memoryAvailable = balloonReleaseWM + 750
+	ovs.deflateBalloons()
+	expectBalloonCmd(t, ovs, "idle", 1000)
+
+	close(ovs.childDoneCh)
+	ovs.childWg.Wait()
+}
+
+// Check that no memory is reclaimed when ballooning is disabled.
+//
+// Create an overseer with a number of instances, one of which is an idle
+// VM, and ask it to reclaim memory.
+//
+// No balloon commands should be sent.
+func TestBalloonPolicyNone(t *testing.T) {
+	defer func(policy balloonPolicyFlag) {
+		balloonPolicy = policy
+	}(balloonPolicy)
+
+	balloonPolicy = "none"
+
+	ovs := newBalloonTestOverseer()
+	ovs.inflateBalloons(1000)
+	for uuid, target := range ovs.instances {
+		if target.balloonPending {
+			t.Errorf("Unexpected balloon command sent to %s", uuid)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/ssntp"
+	"github.com/golang/glog"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// Console sessions receiving no input are closed after
+	// consoleIdleTimeout, in case the controller never closes them.
+	consoleIdleTimeout = 10 * time.Minute
+
+	// The serial console output is relayed in chunks of at most
+	// consoleReadSize bytes.
+	consoleReadSize = 4096
+
+	// Maximum size of the input written to a console by a single
+	// CONSOLE command.
+	consoleInputLimit = 1024
+)
+
+type insConsoleCmd struct {
+	session string
+	action  payloads.ConsoleAction
+	input   string
+}
+
+type consoleSession struct {
+	id   string
+	conn net.Conn
+}
+
+// consoleAttacher is implemented by virtualizers whose instances do not
+// expose a serial console socket in their instance directory.  It returns
+// a connection to the console of the instance, e.g., the attach stream of a
+// container.
+type consoleAttacher interface {
+	attachConsole() (net.Conn, error)
+}
+
+// bufferedConsoleConn is a console connection whose output may already have
+// been partially read into a buffer, as is the case for hijacked HTTP
+// connections.
+type bufferedConsoleConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConsoleConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func consoleSocketPath(instanceDir string) string {
+	return path.Join(instanceDir, "console")
+}
+
+// consoleParams connects the serial port of a VM to a unix socket in the
+// instance directory.  ciao-launcher relays emergency console sessions
+// from and to this socket over SSNTP, so that the console remains usable
+// when the network of the instance is broken.
+func consoleParams(instanceDir string) []string {
+	chardev := fmt.Sprintf("socket,id=console0,path=%s,server,nowait",
+		consoleSocketPath(instanceDir))
+	return []string{"-chardev", chardev, "-device", "isa-serial,chardev=console0"}
+}
+
+func sendConsoleOutput(conn serverConn, instance, session string, output []byte, closed bool) {
+	event := payloads.EventConsoleOutput{
+		ConsoleOutput: payloads.ConsoleOutputEvent{
+			InstanceUUID: instance,
+			SessionUUID:  session,
+			Output:       string(output),
+			Closed:       closed,
+		},
+	}
+
+	payload, err := yaml.Marshal(&event)
+	if err != nil {
+		glog.Errorf("Unable to Marshall ConsoleOutput %v", err)
+		return
+	}
+
+	_, err = conn.SendEvent(ssntp.ConsoleOutput, payload)
+	if err != nil {
+		glog.Errorf("Failed to send event command %v", err)
+	}
+}
+
+// consoleReader relays the output of the serial console of an instance
+// until the session is closed, times out or the VM exits.  The controller
+// is then told that the session is closed.
+func consoleReader(conn serverConn, instance string, cs *consoleSession, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	buf := make([]byte, consoleReadSize)
+	for {
+		n, err := cs.conn.Read(buf)
+		if n > 0 {
+			sendConsoleOutput(conn, instance, cs.id, buf[:n], false)
+		}
+		if err != nil {
+			glog.Infof("Console session %s of instance %s closed: %v", cs.id, instance, err)
+			sendConsoleOutput(conn, instance, cs.id, nil, true)
+			return
+		}
+	}
+}
+
+func (id *instanceData) openConsole(session string) {
+	if id.console != nil {
+		glog.Infof("Closing console session %s of instance %s", id.console.id, id.instance)
+		id.closeConsole()
+	}
+
+	if id.shuttingDown || id.monitorCh == nil {
+		glog.Errorf("Unable to open console of instance %s: not available", id.instance)
+		sendConsoleOutput(id.ac.conn, id.instance, session, nil, true)
+		return
+	}
+
+	var conn net.Conn
+	var err error
+	if ca, ok := id.vm.(consoleAttacher); ok {
+		conn, err = ca.attachConsole()
+	} else {
+		conn, err = net.DialTimeout("unix", consoleSocketPath(id.instanceDir), time.Second)
+	}
+	if err != nil {
+		glog.Errorf("Unable to open console of instance %s: %v", id.instance, err)
+		sendConsoleOutput(id.ac.conn, id.instance, session, nil, true)
+		return
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(consoleIdleTimeout))
+
+	id.console = &consoleSession{session, conn}
+	glog.Infof("Console session %s opened on instance %s", session, id.instance)
+
+	id.instanceWg.Add(1)
+	go consoleReader(id.ac.conn, id.instance, id.console, &id.instanceWg)
+}
+
+func (id *instanceData) writeConsole(session string, input string) {
+	if id.console == nil || id.console.id != session {
+		glog.Errorf("Console session %s of instance %s not open", session, id.instance)
+		sendConsoleOutput(id.ac.conn, id.instance, session, nil, true)
+		return
+	}
+
+	glog.Infof("Console session %s: writing %d bytes to instance %s", session, len(input), id.instance)
+
+	conn := id.console.conn
+	_ = conn.SetWriteDeadline(time.Now().Add(time.Second))
+	_, err := conn.Write([]byte(input))
+	if err != nil {
+		glog.Errorf("Unable to write to console of instance %s: %v", id.instance, err)
+		id.closeConsole()
+		return
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(consoleIdleTimeout))
+}
+
+// closeConsole closes the current console session.  Its reader exits and
+// reports the session closed.
+func (id *instanceData) closeConsole() {
+	_ = id.console.conn.Close()
+	id.console = nil
+}
+
+func (id *instanceData) consoleCommand(cmd *insConsoleCmd) {
+	switch cmd.action {
+	case payloads.ConsoleOpen:
+		id.openConsole(cmd.session)
+	case payloads.ConsoleInput:
+		id.writeConsole(cmd.session, cmd.input)
+	case payloads.ConsoleClose:
+		if id.console != nil && id.console.id == cmd.session {
+			glog.Infof("Console session %s of instance %s closed by controller", cmd.session, id.instance)
+			id.closeConsole()
+		}
+	}
+}
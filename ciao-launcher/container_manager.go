@@ -37,5 +37,8 @@ type containerManager interface {
 	ContainerInspectWithRaw(context.Context, string, bool) (types.ContainerJSON, []byte, error)
 	ContainerStats(context.Context, string, bool) (io.ReadCloser, error)
 	ContainerKill(context.Context, string, string) error
+	ContainerPause(context.Context, string) error
+	ContainerUnpause(context.Context, string) error
 	ContainerWait(context.Context, string) (int, error)
+	ContainerAttach(context.Context, types.ContainerAttachOptions) (types.HijackedResponse, error)
 }
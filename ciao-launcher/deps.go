@@ -23,23 +23,27 @@ import "github.com/ciao-project/ciao/osprepare"
 // qemu/kvm for VM's
 // xorriso for cloud init config drive
 // fuser for qemu instance pid
+// tc for instance network bandwidth limits
 
 var launcherClearLinuxCommonDeps = []osprepare.PackageRequirement{
 	{BinaryName: "/usr/bin/qemu-system-x86_64", PackageName: "cloud-control"},
 	{BinaryName: "/usr/bin/xorriso", PackageName: "cloud-control"},
 	{BinaryName: "/usr/sbin/fuser", PackageName: "cloud-control"},
+	{BinaryName: "/usr/sbin/tc", PackageName: "cloud-control"},
 }
 
 var launcherFedoraCommonDeps = []osprepare.PackageRequirement{
 	{BinaryName: "/usr/bin/qemu-system-x86_64", PackageName: "qemu-system-x86"},
 	{BinaryName: "/usr/bin/xorriso", PackageName: "xorriso"},
 	{BinaryName: "/usr/sbin/fuser", PackageName: "psmisc"},
+	{BinaryName: "/usr/sbin/tc", PackageName: "iproute"},
 }
 
 var launcherUbuntuCommonDeps = []osprepare.PackageRequirement{
 	{BinaryName: "/usr/bin/qemu-system-x86_64", PackageName: "qemu-system-x86"},
 	{BinaryName: "/usr/bin/xorriso", PackageName: "xorriso"},
 	{BinaryName: "/bin/fuser", PackageName: "psmisc"},
+	{BinaryName: "/sbin/tc", PackageName: "iproute2"},
 }
 
 var launcherNetNodeDeps = map[string][]osprepare.PackageRequirement{
@@ -56,11 +60,15 @@ var launcherComputeNodeDeps = map[string][]osprepare.PackageRequirement{
 	// compute nodes have a unique additional need for:
 	//
 	// docker for containers
+	// cryptsetup for encrypted volumes
 
 	"clearlinux": append(launcherClearLinuxCommonDeps,
-		osprepare.PackageRequirement{BinaryName: "/usr/bin/docker", PackageName: "cloud-control"}),
+		osprepare.PackageRequirement{BinaryName: "/usr/bin/docker", PackageName: "cloud-control"},
+		osprepare.PackageRequirement{BinaryName: "/usr/bin/cryptsetup", PackageName: "storage-utils"}),
 	"fedora": append(launcherFedoraCommonDeps,
-		osprepare.PackageRequirement{BinaryName: "/usr/bin/docker", PackageName: "docker-engine"}),
+		osprepare.PackageRequirement{BinaryName: "/usr/bin/docker", PackageName: "docker-engine"},
+		osprepare.PackageRequirement{BinaryName: "/usr/sbin/cryptsetup", PackageName: "cryptsetup"}),
 	"ubuntu": append(launcherUbuntuCommonDeps,
-		osprepare.PackageRequirement{BinaryName: "/usr/bin/docker", PackageName: "docker-engine"}),
+		osprepare.PackageRequirement{BinaryName: "/usr/bin/docker", PackageName: "docker-engine"},
+		osprepare.PackageRequirement{BinaryName: "/sbin/cryptsetup", PackageName: "cryptsetup"}),
 }
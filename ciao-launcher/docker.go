@@ -21,9 +21,12 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"path"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -172,6 +175,19 @@ func (d *docker) ensureBackingImage() error {
 	return nil
 }
 
+// secretEnv converts the secrets found in the meta-data of an instance into
+// environment variables. The name of the variable is the name of the
+// secret in upper case, with dashes and dots replaced by underscores.
+func secretEnv(secrets map[string]string) []string {
+	env := make([]string, 0, len(secrets))
+	replacer := strings.NewReplacer("-", "_", ".", "_")
+	for name, value := range secrets {
+		env = append(env, fmt.Sprintf("%s=%s", replacer.Replace(strings.ToUpper(name)), value))
+	}
+	sort.Strings(env)
+	return env
+}
+
 func (d *docker) createConfigs(bridge, gatewayIP string, userData,
 	metaData []byte, volumes []string) (config *container.Config,
 	hostConfig *container.HostConfig, networkConfig *network.NetworkingConfig) {
@@ -179,7 +195,8 @@ func (d *docker) createConfigs(bridge, gatewayIP string, userData,
 	var hostname string
 	var cmd []string
 	md := &struct {
-		Hostname string `json:"hostname"`
+		Hostname string            `json:"hostname"`
+		Secrets  map[string]string `json:"secrets"`
 	}{}
 	err := json.Unmarshal(metaData, md)
 	if err != nil {
@@ -205,10 +222,19 @@ func (d *docker) createConfigs(bridge, gatewayIP string, userData,
 		}
 	}
 
+	// Containers whose workload asks for a console are given a terminal
+	// and an open stdin so that their console can be attached to by
+	// CONSOLE.
 	config = &container.Config{
-		Hostname: hostname,
-		Image:    d.cfg.DockerImage,
-		Cmd:      cmd,
+		Hostname:  hostname,
+		Image:     d.cfg.DockerImage,
+		Cmd:       cmd,
+		Tty:       d.cfg.Console,
+		OpenStdin: d.cfg.Console,
+	}
+
+	if len(md.Secrets) > 0 {
+		config.Env = secretEnv(md.Secrets)
 	}
 
 	hostConfig = &container.HostConfig{
@@ -230,6 +256,10 @@ func (d *docker) createConfigs(bridge, gatewayIP string, userData,
 		hostConfig.CPUQuota = hostConfig.CPUPeriod * int64(d.cfg.Cpus)
 	}
 
+	if d.cfg.DiskIOPS > 0 || d.cfg.NetMbps > 0 {
+		glog.Warning("Disk IOPS and network bandwidth limits are not enforced for containers")
+	}
+
 	if d.cfg.Privileged {
 		hostConfig.Privileged = true
 		hostConfig.PidMode = "host"
@@ -268,7 +298,7 @@ func (d *docker) umountVolumes(vols []volumeConfig) {
 
 func (d *docker) unmapVolumes() {
 	for _, vol := range d.cfg.Volumes {
-		if err := d.storageDriver.UnmapVolumeFromNode(vol.UUID); err != nil {
+		if err := unmapVolume(d.storageDriver, vol); err != nil {
 			glog.Warningf("Unable to unmap %s: %v", vol.UUID, err)
 			continue
 		}
@@ -280,7 +310,7 @@ func (d *docker) mapAndMountVolumes() error {
 	for mapped, vol := range d.cfg.Volumes {
 		var devName string
 		var err error
-		if devName, err = d.storageDriver.MapVolumeToNode(vol.UUID); err != nil {
+		if devName, err = mapVolume(d.storageDriver, vol); err != nil {
 			d.umountVolumes(d.cfg.Volumes[:mapped])
 			return fmt.Errorf("Unable to map (%s) %v", vol.UUID, err)
 		}
@@ -351,7 +381,9 @@ func (d *docker) createImage(bridge, gatewayIP string, userData, metaData []byte
 
 	// This value is configurable.  Need to figure out how to get it from docker.
 
-	d.cfg.Disk = 10000
+	if d.cfg.Disk == 0 {
+		d.cfg.Disk = 10000
+	}
 
 	return nil
 }
@@ -399,11 +431,34 @@ func (d *docker) startVM(vnicName, ipAddress, cephID string, fds []*os.File) err
 		d.umountVolumes(d.cfg.Volumes)
 		d.unmapVolumes()
 		glog.Errorf("Unable to start container %v", err)
-		return err
+		return d.launchFailure(err)
 	}
 	return nil
 }
 
+// launchFailure collects the command and the error output of a container
+// which failed to start, as reported by docker.
+func (d *docker) launchFailure(err error) error {
+	lf := &launchFailure{err: err}
+
+	info, ierr := d.cli.ContainerInspect(context.Background(), d.dockerID)
+	if ierr != nil {
+		glog.Warningf("Unable to inspect container %s: %v", d.dockerID, ierr)
+		return lf
+	}
+
+	if info.Config != nil {
+		lf.commandLine = append([]string{info.Config.Image}, info.Config.Entrypoint...)
+		lf.commandLine = append(lf.commandLine, info.Config.Cmd...)
+	}
+
+	if info.ContainerJSONBase != nil && info.State != nil {
+		lf.stderr = info.State.Error
+	}
+
+	return lf
+}
+
 func dockerCommandLoop(cli containerManager, dockerChannel chan interface{}, instance, dockerID string) {
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	lostContainerCh := make(chan struct{})
@@ -428,13 +483,32 @@ DONE:
 			}
 			switch cmd := cmd.(type) {
 			case virtualizerStopCmd:
-				err := cli.ContainerKill(context.Background(), dockerID, "KILL")
+				err := cli.ContainerKill(context.Background(), dockerID, "TERM")
 				if err != nil {
 					glog.Errorf("Unable to stop instance %s:%s: %v", instance, dockerID, err)
 				}
+			case virtualizerKillCmd:
+				err := cli.ContainerKill(context.Background(), dockerID, "KILL")
+				if err != nil {
+					glog.Errorf("Unable to kill instance %s:%s: %v", instance, dockerID, err)
+				}
 			case virtualizerAttachCmd:
 				err := fmt.Errorf("Live Attach of volumes not supported for containers")
 				cmd.responseCh <- err
+			case virtualizerBalloonCmd:
+				err := fmt.Errorf("Memory ballooning not supported for containers")
+				cmd.responseCh <- err
+			case virtualizerMigrateCmd:
+				err := fmt.Errorf("Live migration not supported for containers")
+				cmd.resultCh <- err
+			case virtualizerPauseCmd:
+				var err error
+				if cmd.pause {
+					err = cli.ContainerPause(context.Background(), dockerID)
+				} else {
+					err = cli.ContainerUnpause(context.Background(), dockerID)
+				}
+				cmd.responseCh <- err
 			}
 		}
 	}
@@ -444,7 +518,7 @@ DONE:
 }
 
 func dockerConnect(cli containerManager, dockerChannel chan interface{}, instance,
-	dockerID string, closedCh chan struct{}, connectedCh chan struct{},
+	dockerID string, closedCh chan struct{}, connectedCh chan struct{}, readyCh chan struct{},
 	wg *sync.WaitGroup, boot bool) {
 
 	defer func() {
@@ -468,13 +542,17 @@ func dockerConnect(cli containerManager, dockerChannel chan interface{}, instanc
 		return
 	}
 
+	// Containers do not boot a guest, they are ready as soon as their
+	// process runs.
+
 	close(connectedCh)
+	close(readyCh)
 
 	dockerCommandLoop(cli, dockerChannel, instance, dockerID)
 }
 
-func (d *docker) monitorVM(closedCh chan struct{}, connectedCh chan struct{},
-	wg *sync.WaitGroup, boot bool) chan interface{} {
+func (d *docker) monitorVM(closedCh chan struct{}, connectedCh chan struct{}, readyCh chan struct{},
+	ovsCh chan<- interface{}, wg *sync.WaitGroup, boot bool) chan interface{} {
 
 	if d.dockerID == "" {
 		idPath := path.Join(d.instanceDir, "docker-id")
@@ -489,10 +567,40 @@ func (d *docker) monitorVM(closedCh chan struct{}, connectedCh chan struct{},
 	}
 	dockerChannel := make(chan interface{})
 	wg.Add(1)
-	go dockerConnect(d.cli, dockerChannel, d.cfg.Instance, d.dockerID, closedCh, connectedCh, wg, boot)
+	go dockerConnect(d.cli, dockerChannel, d.cfg.Instance, d.dockerID, closedCh, connectedCh, readyCh, wg, boot)
 	return dockerChannel
 }
 
+// attachConsole attaches to the terminal of the container.  As the container
+// has a terminal, its output is not multiplexed and can be relayed as is.
+func (d *docker) attachConsole() (net.Conn, error) {
+	if d.dockerID == "" {
+		return nil, fmt.Errorf("Invalid docker ID")
+	}
+
+	if !d.cfg.Console {
+		return nil, fmt.Errorf("Container %s has no console", d.dockerID)
+	}
+
+	err := d.initDockerClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := d.cli.ContainerAttach(context.Background(), types.ContainerAttachOptions{
+		ContainerID: d.dockerID,
+		Stream:      true,
+		Stdin:       true,
+		Stdout:      true,
+		Stderr:      true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to attach to container %s: %v", d.dockerID, err)
+	}
+
+	return &bufferedConsoleConn{resp.Conn, resp.Reader}, nil
+}
+
 func (d *docker) computeInstanceDiskspace() int {
 	if d.dockerID == "" {
 		return -1
@@ -17,11 +17,13 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"path"
 	"reflect"
@@ -75,7 +77,11 @@ func (s dockerTestStorage) CreateBlockDevice(volumeUUID string, image string, si
 	return storage.BlockDevice{}, nil
 }
 
-func (s dockerTestStorage) CreateBlockDeviceFromSnapshot(volumeUUID string, snapshotID string) (storage.BlockDevice, error) {
+func (s dockerTestStorage) CreateBlockDeviceFromStream(volumeUUID string, data io.Reader) (storage.BlockDevice, error) {
+	return storage.BlockDevice{}, nil
+}
+
+func (s dockerTestStorage) CreateBlockDeviceFromSnapshot(volumeUUID string, snapshotID string, cloneUUID string) (storage.BlockDevice, error) {
 	return storage.BlockDevice{}, nil
 }
 
@@ -99,14 +105,42 @@ func (s dockerTestStorage) GetVolumeMapping() (map[string][]string, error) {
 	return nil, nil
 }
 
-func (s dockerTestStorage) CopyBlockDevice(volumeUUID string) (storage.BlockDevice, error) {
+func (s dockerTestStorage) ListBlockDevices() ([]string, error) {
+	return nil, nil
+}
+
+func (s dockerTestStorage) CopyBlockDevice(volumeUUID string, copyUUID string) (storage.BlockDevice, error) {
+	return storage.BlockDevice{}, nil
+}
+
+func (s dockerTestStorage) CloneBlockDevice(volumeUUID string, snapshotID string, cloneUUID string) (storage.BlockDevice, error) {
 	return storage.BlockDevice{}, nil
 }
 
+func (s dockerTestStorage) FlattenBlockDevice(volumeUUID string) error {
+	return nil
+}
+
+func (s dockerTestStorage) ExportBlockDevice(volumeUUID string, path string) error {
+	return nil
+}
+
+func (s dockerTestStorage) ReadBlockDevice(volumeUUID string, data io.Writer) error {
+	return nil
+}
+
 func (s dockerTestStorage) GetBlockDeviceSize(volumeUUID string) (uint64, error) {
 	return 0, nil
 }
 
+func (s dockerTestStorage) GetBlockDeviceUsage(volumeUUID string) (uint64, error) {
+	return 0, nil
+}
+
+func (s dockerTestStorage) WithPool(pool string) storage.BlockDriver {
+	return s
+}
+
 func (s dockerTestStorage) IsValidSnapshotUUID(string) error {
 	return nil
 }
@@ -123,6 +157,9 @@ type dockerTestClient struct {
 	hostConfig        *container.HostConfig
 	networkConfig     *network.NetworkingConfig
 	containerWaitCh   chan struct{}
+	startErr          error
+	startErrOutput    string
+	attachConn        net.Conn
 }
 
 func (d *dockerTestClient) ImageList(context.Context, types.ImageListOptions) ([]types.Image, error) {
@@ -166,7 +203,7 @@ func (d *dockerTestClient) ContainerRemove(context.Context, types.ContainerRemov
 }
 
 func (d *dockerTestClient) ContainerStart(context.Context, string) error {
-	return nil
+	return d.startErr
 }
 
 func (d *dockerTestClient) ContainerInspectWithRaw(context.Context, string, bool) (types.ContainerJSON, []byte, error) {
@@ -178,9 +215,11 @@ func (d *dockerTestClient) ContainerInspect(context.Context, string) (types.Cont
 	return types.ContainerJSON{
 		ContainerJSONBase: &types.ContainerJSONBase{
 			State: &types.ContainerState{
-				Running: true,
+				Running: d.startErr == nil,
+				Error:   d.startErrOutput,
 			},
 		},
+		Config: d.config,
 	}, nil
 }
 
@@ -207,6 +246,14 @@ func (d *dockerTestClient) ContainerKill(context.Context, string, string) error
 	return nil
 }
 
+func (d *dockerTestClient) ContainerPause(context.Context, string) error {
+	return nil
+}
+
+func (d *dockerTestClient) ContainerUnpause(context.Context, string) error {
+	return nil
+}
+
 func (d *dockerTestClient) ContainerWait(ctx context.Context, id string) (int, error) {
 	select {
 	case <-d.containerWaitCh:
@@ -216,6 +263,18 @@ func (d *dockerTestClient) ContainerWait(ctx context.Context, id string) (int, e
 	return 0, nil
 }
 
+func (d *dockerTestClient) ContainerAttach(context.Context,
+	types.ContainerAttachOptions) (types.HijackedResponse, error) {
+	if d.err != nil {
+		return types.HijackedResponse{}, d.err
+	}
+
+	return types.HijackedResponse{
+		Conn:   d.attachConn,
+		Reader: bufio.NewReader(d.attachConn),
+	}, nil
+}
+
 // Checks that the logic of the code that mounts and unmounts ceph volumes in
 // docker containers.
 //
@@ -513,6 +572,10 @@ runcmd:
 		t.Errorf("Unexpected command.  Expected %s found %s", cmd, tc.config.Cmd)
 	}
 
+	if tc.config.Tty || tc.config.OpenStdin {
+		t.Errorf("Expected container without a terminal and an open stdin")
+	}
+
 	if d.dockerID != testutil.InstanceUUID {
 		t.Errorf("Incorrect container ID %s expected, found %s",
 			testutil.InstanceUUID, d.dockerID)
@@ -560,6 +623,42 @@ func TestDockerCreateImageFail(t *testing.T) {
 	}
 }
 
+// Check that docker.startVM reports the diagnostics of a container which
+// fails to start.
+//
+// The test calls startVM with a dockerTestClient whose ContainerStart
+// method fails.
+//
+// startVM should fail with a launchFailure containing the image and command
+// of the container and the error reported by docker.
+func TestDockerStartVMFail(t *testing.T) {
+	tc := &dockerTestClient{
+		startErr:       fmt.Errorf("ContainerStart failure forced"),
+		startErrOutput: "exec: \"sh\": executable file not found in $PATH",
+		config: &container.Config{
+			Image:      "ubuntu",
+			Entrypoint: []string{"/bin/entry"},
+			Cmd:        []string{"sh"},
+		},
+	}
+	d := &docker{instanceDir: "/tmp/i/dont/exist", cfg: &vmConfig{}, cli: tc,
+		dockerID: testutil.InstanceUUID}
+
+	err := d.startVM("", "", "", nil)
+	lf, ok := err.(*launchFailure)
+	if !ok {
+		t.Fatalf("Expected launchFailure, got %v", err)
+	}
+
+	if lf.err != tc.startErr || lf.stderr != tc.startErrOutput {
+		t.Errorf("Unexpected launch failure %+v", lf)
+	}
+
+	if !reflect.DeepEqual(lf.commandLine, []string{"ubuntu", "/bin/entry", "sh"}) {
+		t.Errorf("Unexpected command line %v", lf.commandLine)
+	}
+}
+
 // Verify that docker.createImage handles volumes correctly
 //
 // The test calls createImage with two pre-configured volumes.  It checks that
@@ -662,6 +761,44 @@ func TestDockerCreateImageWithResources(t *testing.T) {
 	}
 }
 
+// Check createImage injects secrets in the container environment
+//
+// Create an image whose meta-data contains secrets and check the
+// environment of the container.
+//
+// The image is correctly created and the secrets are converted to
+// environment variables.
+func TestDockerCreateImageWithSecrets(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "ciao-docker-tests")
+	if err != nil {
+		t.Fatal("Unable to create temporary directory")
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	tc := &dockerTestClient{}
+	d := &docker{instanceDir: tmpDir, cli: tc,
+		cfg: &vmConfig{
+			VnicMAC: testutil.VNICMAC,
+			VnicIP:  testutil.AgentIP,
+		}}
+
+	metaData := []byte(`{"hostname":"test","secrets":{"db-password":"hunter2","api.key":"abc"}}`)
+	if err := d.createImage("bridge", "172.16.0.1", nil, metaData); err != nil {
+		t.Fatalf("Unable to create image : %v", err)
+	}
+
+	expected := []string{"API_KEY=abc", "DB_PASSWORD=hunter2"}
+	if !reflect.DeepEqual(tc.config.Env, expected) {
+		t.Errorf("Expected environment %v, got %v", expected, tc.config.Env)
+	}
+
+	err = d.deleteImage()
+	if err != nil {
+		t.Errorf("Unable to delete container : %v", err)
+	}
+}
+
 // Check createImage creates privileged images correctly
 //
 // Create an image with the privileged set and check the arguments
@@ -734,6 +871,36 @@ func TestDockerCreateImagePrivileged(t *testing.T) {
 	}
 }
 
+// Check createImage creates containers with a console correctly
+//
+// Create an image with the console set and check the container config.
+//
+// The container is given a terminal and an open stdin.
+func TestDockerCreateImageConsole(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "ciao-docker-tests")
+	if err != nil {
+		t.Fatal("Unable to create temporary directory")
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	tc := &dockerTestClient{}
+	d := &docker{instanceDir: tmpDir, cli: tc, cfg: &vmConfig{Console: true}}
+
+	if err := d.createImage("", "", nil, nil); err != nil {
+		t.Fatalf("Unable to create image : %v", err)
+	}
+
+	if !tc.config.Tty || !tc.config.OpenStdin {
+		t.Errorf("Expected container with a terminal and an open stdin")
+	}
+
+	err = d.deleteImage()
+	if err != nil {
+		t.Errorf("Unable to delete container : %v", err)
+	}
+}
+
 // Checks the monitorVM function works correctly.
 //
 // This test creates a new instance, calls monitor VM, waits for the connected
@@ -773,7 +940,7 @@ func TestDockerMonitorVM(t *testing.T) {
 
 	var wg sync.WaitGroup
 
-	dockerCh := d.monitorVM(closedCh, connectedCh, &wg, false)
+	dockerCh := d.monitorVM(closedCh, connectedCh, make(chan struct{}), nil, &wg, false)
 
 	select {
 	case <-connectedCh:
@@ -811,7 +978,7 @@ func TestDockerMonitorVMClose(t *testing.T) {
 
 	var wg sync.WaitGroup
 
-	dockerCh := d.monitorVM(closedCh, connectedCh, &wg, false)
+	dockerCh := d.monitorVM(closedCh, connectedCh, make(chan struct{}), nil, &wg, false)
 
 	select {
 	case <-connectedCh:
@@ -860,3 +1027,57 @@ func TestDockerStats(t *testing.T) {
 		t.Errorf("Expected cpu usage of 0.  Got %d", cpu)
 	}
 }
+
+// Checks that the console of a container can be attached to.
+//
+// We attach to the console of a container that has not been created, to the
+// console of a container without a console and then to the console of a
+// container whose attach stream is one end of a pipe.  We then exchange some
+// data over the pipe.
+//
+// The first two attaches should fail.  The third should succeed and the data
+// written to either end of the pipe should be read from the other.
+func TestDockerAttachConsole(t *testing.T) {
+	stream, attached := net.Pipe()
+	defer func() { _ = stream.Close() }()
+
+	tc := &dockerTestClient{attachConn: attached}
+	d := &docker{cfg: &vmConfig{}, cli: tc}
+
+	_, err := d.attachConsole()
+	if err == nil {
+		t.Fatal("Expected attachConsole to fail without a container")
+	}
+
+	d.dockerID = testutil.InstanceUUID
+	_, err = d.attachConsole()
+	if err == nil {
+		t.Fatal("Expected attachConsole to fail without a console")
+	}
+
+	d.cfg.Console = true
+	conn, err := d.attachConsole()
+	if err != nil {
+		t.Fatalf("Unable to attach to console: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	go func() {
+		_, _ = stream.Write([]byte("/ # "))
+	}()
+
+	buf := make([]byte, 16)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "/ # " {
+		t.Errorf("Expected console output %q, found %q (%v)", "/ # ", buf[:n], err)
+	}
+
+	go func() {
+		_, _ = conn.Write([]byte("ls\n"))
+	}()
+
+	n, err = stream.Read(buf)
+	if err != nil || string(buf[:n]) != "ls\n" {
+		t.Errorf("Expected console input %q, found %q (%v)", "ls\n", buf[:n], err)
+	}
+}
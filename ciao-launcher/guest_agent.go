@@ -0,0 +1,376 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/ssntp"
+	"github.com/golang/glog"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	qgaSocketName   = "qga"
+	qgaPollInterval = time.Second
+	qgaTimeout      = 10 * time.Minute
+
+	// Guest agent operations, including the commands they run in the
+	// guest, fail if they do not complete within guestAgentTimeout.
+	guestAgentTimeout = 30 * time.Second
+
+	// The output of the commands run in the guest is truncated to
+	// guestOutputLimit bytes.
+	guestOutputLimit = 64 * 1024
+)
+
+type insGuestAgentCmd struct {
+	request   string
+	operation payloads.GuestAgentOperation
+	path      string
+	args      []string
+}
+
+func qgaSocketPath(instanceDir string) string {
+	return path.Join(instanceDir, qgaSocketName)
+}
+
+// qgaClient is a connection to the QEMU guest agent of an instance, which
+// listens on a unix socket in the instance directory.
+type qgaClient struct {
+	conn net.Conn
+	dec  *json.Decoder
+}
+
+type qgaError struct {
+	Class string `json:"class"`
+	Desc  string `json:"desc"`
+}
+
+type qgaReply struct {
+	Return *json.RawMessage `json:"return"`
+	Error  *qgaError        `json:"error"`
+}
+
+// qgaDial connects to the guest agent listening on socket.  All the
+// commands executed on the connection must complete before the deadline.
+func qgaDial(socket string, deadline time.Time) (*qgaClient, error) {
+	conn, err := net.DialTimeout("unix", socket, deadline.Sub(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return &qgaClient{conn, json.NewDecoder(conn)}, nil
+}
+
+func (c *qgaClient) close() {
+	_ = c.conn.Close()
+}
+
+func (c *qgaClient) send(command string, args interface{}) error {
+	cmd := struct {
+		Execute   string      `json:"execute"`
+		Arguments interface{} `json:"arguments,omitempty"`
+	}{command, args}
+
+	return json.NewEncoder(c.conn).Encode(&cmd)
+}
+
+func (c *qgaClient) receive(ret interface{}) error {
+	var reply qgaReply
+	err := c.dec.Decode(&reply)
+	if err != nil {
+		return err
+	}
+
+	if reply.Error != nil {
+		return fmt.Errorf("%s: %s", reply.Error.Class, reply.Error.Desc)
+	}
+
+	if reply.Return == nil {
+		return fmt.Errorf("Unexpected guest agent reply")
+	}
+
+	if ret == nil {
+		return nil
+	}
+
+	return json.Unmarshal(*reply.Return, ret)
+}
+
+// execute runs a guest agent command and stores its return value in ret.
+func (c *qgaClient) execute(command string, args interface{}, ret interface{}) error {
+	err := c.send(command, args)
+	if err != nil {
+		return err
+	}
+
+	return c.receive(ret)
+}
+
+// sync discards the replies to the commands sent by previous clients,
+// which the agent may only process once it runs.
+func (c *qgaClient) sync() error {
+	id := rand.Int63n(1 << 31)
+
+	err := c.send("guest-sync", map[string]int64{"id": id})
+	if err != nil {
+		return err
+	}
+
+	for {
+		var reply qgaReply
+		err := c.dec.Decode(&reply)
+		if err != nil {
+			return err
+		}
+
+		var ret int64
+		if reply.Return != nil && json.Unmarshal(*reply.Return, &ret) == nil && ret == id {
+			return nil
+		}
+	}
+}
+
+// qgaPing sends a guest-ping command to the QEMU guest agent listening on
+// socket and waits for its reply.  The agent only replies once it runs in
+// the guest.
+func qgaPing(socket string, timeout time.Duration) error {
+	c, err := qgaDial(socket, time.Now().Add(timeout))
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	return c.execute("guest-ping", nil, nil)
+}
+
+// qgaWaitReady pings the guest agent of an instance every interval until it
+// replies, at which point readyCh is closed, until the instance stops, i.e.,
+// closedCh is closed, or until timeout.  readyCh is never closed for guests
+// that do not run the QEMU guest agent.
+func qgaWaitReady(instance, socket string, closedCh, readyCh chan struct{}, wg *sync.WaitGroup,
+	interval, timeout time.Duration) {
+	defer wg.Done()
+
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closedCh:
+			return
+		case <-deadline:
+			glog.Infof("Guest agent of %s not ready after %v", instance, timeout)
+			return
+		case <-ticker.C:
+		}
+
+		if err := qgaPing(socket, interval); err == nil {
+			close(readyCh)
+			return
+		}
+	}
+}
+
+func truncateGuestOutput(data []byte) string {
+	if len(data) > guestOutputLimit {
+		data = data[:guestOutputLimit]
+	}
+	return string(data)
+}
+
+// guestExec runs a command in the guest and waits for it to exit.
+func (c *qgaClient) guestExec(cmd *insGuestAgentCmd, result *payloads.GuestAgentResultEvent) error {
+	args := struct {
+		Path          string   `json:"path"`
+		Arg           []string `json:"arg,omitempty"`
+		CaptureOutput bool     `json:"capture-output"`
+	}{cmd.path, cmd.args, true}
+
+	var pid struct {
+		PID int `json:"pid"`
+	}
+	err := c.execute("guest-exec", &args, &pid)
+	if err != nil {
+		return err
+	}
+
+	for {
+		var status struct {
+			Exited   bool   `json:"exited"`
+			ExitCode int    `json:"exitcode"`
+			OutData  []byte `json:"out-data"`
+			ErrData  []byte `json:"err-data"`
+		}
+		err = c.execute("guest-exec-status", &pid, &status)
+		if err != nil {
+			return err
+		}
+
+		if status.Exited {
+			result.ExitCode = status.ExitCode
+			result.Stdout = truncateGuestOutput(status.OutData)
+			result.Stderr = truncateGuestOutput(status.ErrData)
+			return nil
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (c *qgaClient) guestNetworkInfo(result *payloads.GuestAgentResultEvent) error {
+	var ifaces []struct {
+		Name            string `json:"name"`
+		HardwareAddress string `json:"hardware-address"`
+		IPAddresses     []struct {
+			IPAddress string `json:"ip-address"`
+			Prefix    int    `json:"prefix"`
+		} `json:"ip-addresses"`
+	}
+	err := c.execute("guest-network-get-interfaces", nil, &ifaces)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range ifaces {
+		iface := payloads.GuestInterface{
+			Name:            i.Name,
+			HardwareAddress: i.HardwareAddress,
+		}
+		for _, addr := range i.IPAddresses {
+			iface.IPAddresses = append(iface.IPAddresses,
+				fmt.Sprintf("%s/%d", addr.IPAddress, addr.Prefix))
+		}
+		result.Interfaces = append(result.Interfaces, iface)
+	}
+
+	return nil
+}
+
+// runGuestAgentCmd runs a guest agent operation and fills result with its
+// outcome.
+func runGuestAgentCmd(socket string, cmd *insGuestAgentCmd, result *payloads.GuestAgentResultEvent) error {
+	c, err := qgaDial(socket, time.Now().Add(guestAgentTimeout))
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	err = c.sync()
+	if err != nil {
+		return err
+	}
+
+	switch cmd.operation {
+	case payloads.GuestExec:
+		return c.guestExec(cmd, result)
+	case payloads.GuestFreeze:
+		return c.execute("guest-fsfreeze-freeze", nil, &result.Filesystems)
+	case payloads.GuestThaw:
+		return c.execute("guest-fsfreeze-thaw", nil, &result.Filesystems)
+	case payloads.GuestNetworkInfo:
+		return c.guestNetworkInfo(result)
+	}
+
+	return fmt.Errorf("Unsupported guest agent operation %s", cmd.operation)
+}
+
+func sendGuestAgentResult(conn serverConn, result *payloads.GuestAgentResultEvent) {
+	event := payloads.EventGuestAgentResult{
+		GuestAgentResult: *result,
+	}
+
+	payload, err := yaml.Marshal(&event)
+	if err != nil {
+		glog.Errorf("Unable to Marshall GuestAgentResult %v", err)
+		return
+	}
+
+	_, err = conn.SendEvent(ssntp.GuestAgentResult, payload)
+	if err != nil {
+		glog.Errorf("Failed to send event command %v", err)
+	}
+}
+
+func sendGuestAgentError(conn serverConn, instance, request string, err error) {
+	sendGuestAgentResult(conn, &payloads.GuestAgentResultEvent{
+		InstanceUUID: instance,
+		RequestUUID:  request,
+		Error:        err.Error(),
+	})
+}
+
+// guestAgentWorker runs a guest agent operation and reports its result.
+// The guest agent only serves one client at a time so the operations of
+// an instance are serialized by lock.
+func guestAgentWorker(conn serverConn, instance, socket string, cmd *insGuestAgentCmd,
+	lock *sync.Mutex, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	result := &payloads.GuestAgentResultEvent{
+		InstanceUUID: instance,
+		RequestUUID:  cmd.request,
+	}
+
+	err := runGuestAgentCmd(socket, cmd, result)
+	if err != nil {
+		glog.Errorf("Guest agent %s on instance %s failed: %v", cmd.operation, instance, err)
+		sendGuestAgentError(conn, instance, cmd.request, err)
+		return
+	}
+
+	glog.Infof("Guest agent %s on instance %s succeeded", cmd.operation, instance)
+	sendGuestAgentResult(conn, result)
+}
+
+func (id *instanceData) guestAgentCommand(cmd *insGuestAgentCmd) {
+	if id.cfg.Container {
+		sendGuestAgentError(id.ac.conn, id.instance, cmd.request,
+			fmt.Errorf("Guest agent not available for containers"))
+		return
+	}
+
+	if id.shuttingDown || id.monitorCh == nil || id.connectedCh != nil {
+		sendGuestAgentError(id.ac.conn, id.instance, cmd.request,
+			fmt.Errorf("Instance %s is not running", id.instance))
+		return
+	}
+
+	glog.Infof("Running guest agent %s on instance %s", cmd.operation, id.instance)
+
+	id.instanceWg.Add(1)
+	go guestAgentWorker(id.ac.conn, id.instance, qgaSocketPath(id.instanceDir), cmd,
+		&id.guestAgentLock, &id.instanceWg)
+}
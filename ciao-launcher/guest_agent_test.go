@@ -0,0 +1,257 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/payloads"
+)
+
+// serveGuestAgent implements a fake QEMU guest agent.  A stale reply is
+// sent before the reply to guest-sync, as the agent does when it processes
+// the commands of previous clients late.  The agent does not reply at all
+// unless running is set.
+func serveGuestAgent(ln net.Listener, running bool) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		dec := json.NewDecoder(conn)
+		enc := json.NewEncoder(conn)
+		for running {
+			var cmd struct {
+				Execute   string                 `json:"execute"`
+				Arguments map[string]interface{} `json:"arguments"`
+			}
+			if dec.Decode(&cmd) != nil {
+				break
+			}
+
+			var ret interface{}
+			switch cmd.Execute {
+			case "guest-sync":
+				_ = enc.Encode(map[string]interface{}{"return": map[string]interface{}{}})
+				ret = cmd.Arguments["id"]
+			case "guest-ping":
+				ret = map[string]interface{}{}
+			case "guest-exec":
+				ret = map[string]interface{}{"pid": 42}
+			case "guest-exec-status":
+				ret = map[string]interface{}{
+					"exited":   true,
+					"exitcode": 1,
+					"out-data": []byte("4.13.0\n"),
+					"err-data": []byte("warning\n"),
+				}
+			case "guest-fsfreeze-freeze", "guest-fsfreeze-thaw":
+				ret = 2
+			case "guest-network-get-interfaces":
+				ret = []map[string]interface{}{
+					{
+						"name":             "eth0",
+						"hardware-address": "02:00:e6:f5:af:f9",
+						"ip-addresses": []map[string]interface{}{
+							{"ip-address-type": "ipv4", "ip-address": "192.168.8.2", "prefix": 21},
+						},
+					},
+				}
+			default:
+				_ = enc.Encode(map[string]interface{}{
+					"error": map[string]string{"class": "CommandNotFound", "desc": cmd.Execute},
+				})
+				continue
+			}
+
+			_ = enc.Encode(map[string]interface{}{"return": ret})
+		}
+		_ = conn.Close()
+	}
+}
+
+func startGuestAgent(t *testing.T, running bool) (string, func()) {
+	dir, err := ioutil.TempDir("", "qga")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	socket := qgaSocketPath(dir)
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		t.Fatalf("Unable to open domain socket %s: %v", socket, err)
+	}
+	go serveGuestAgent(ln, running)
+
+	return socket, func() {
+		_ = ln.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// Check the guest agent operations.
+//
+// Start a fake guest agent and run each operation through it.
+//
+// The results returned by the agent should be reported.
+func TestRunGuestAgentCmd(t *testing.T) {
+	socket, cleanup := startGuestAgent(t, true)
+	defer cleanup()
+
+	tests := []struct {
+		cmd      insGuestAgentCmd
+		expected payloads.GuestAgentResultEvent
+	}{
+		{
+			insGuestAgentCmd{operation: payloads.GuestExec, path: "/bin/uname", args: []string{"-r"}},
+			payloads.GuestAgentResultEvent{ExitCode: 1, Stdout: "4.13.0\n", Stderr: "warning\n"},
+		},
+		{
+			insGuestAgentCmd{operation: payloads.GuestFreeze},
+			payloads.GuestAgentResultEvent{Filesystems: 2},
+		},
+		{
+			insGuestAgentCmd{operation: payloads.GuestThaw},
+			payloads.GuestAgentResultEvent{Filesystems: 2},
+		},
+		{
+			insGuestAgentCmd{operation: payloads.GuestNetworkInfo},
+			payloads.GuestAgentResultEvent{Interfaces: []payloads.GuestInterface{
+				{
+					Name:            "eth0",
+					HardwareAddress: "02:00:e6:f5:af:f9",
+					IPAddresses:     []string{"192.168.8.2/21"},
+				},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		var result payloads.GuestAgentResultEvent
+		err := runGuestAgentCmd(socket, &test.cmd, &result)
+		if err != nil {
+			t.Errorf("%s failed: %v", test.cmd.operation, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("Unexpected %s result %+v", test.cmd.operation, result)
+		}
+	}
+}
+
+// Check guest agent operations fail when the guest agent is not running.
+//
+// Start a fake guest agent which does not reply and run an operation.
+//
+// The operation should fail.
+func TestRunGuestAgentCmdNoAgent(t *testing.T) {
+	socket, cleanup := startGuestAgent(t, false)
+	defer cleanup()
+
+	var result payloads.GuestAgentResultEvent
+	cmd := insGuestAgentCmd{operation: payloads.GuestFreeze}
+	err := runGuestAgentCmd(socket, &cmd, &result)
+	if err == nil {
+		t.Errorf("Guest agent operation succeeded without guest agent")
+	}
+}
+
+// Check the guest of an instance is detected as ready.
+//
+// Start a fake guest agent which replies to pings and call qgaWaitReady.
+//
+// readyCh should be closed.
+func TestQgaWaitReady(t *testing.T) {
+	var wg sync.WaitGroup
+
+	dir, err := ioutil.TempDir("", "qga")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	socket := path.Join(dir, qgaSocketName)
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Unable to open domain socket %s: %v", socket, err)
+	}
+	defer func() { _ = ln.Close() }()
+	go serveGuestAgent(ln, true)
+
+	closedCh := make(chan struct{})
+	readyCh := make(chan struct{})
+	wg.Add(1)
+	go qgaWaitReady("testInstance", socket, closedCh, readyCh, &wg, 10*time.Millisecond, time.Second)
+
+	select {
+	case <-readyCh:
+	case <-time.After(time.Second):
+		t.Errorf("Timed out waiting for readyCh to close")
+	}
+	wg.Wait()
+}
+
+// Check the guest of an instance is not detected as ready if its guest
+// agent does not reply.
+//
+// Start a fake guest agent which does not reply to pings, call qgaWaitReady
+// and close closedCh.
+//
+// readyCh should not be closed and qgaWaitReady should return.
+func TestQgaWaitReadyNoAgent(t *testing.T) {
+	var wg sync.WaitGroup
+
+	dir, err := ioutil.TempDir("", "qga")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	socket := path.Join(dir, qgaSocketName)
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Unable to open domain socket %s: %v", socket, err)
+	}
+	defer func() { _ = ln.Close() }()
+	go serveGuestAgent(ln, false)
+
+	closedCh := make(chan struct{})
+	readyCh := make(chan struct{})
+	wg.Add(1)
+	go qgaWaitReady("testInstance", socket, closedCh, readyCh, &wg, 10*time.Millisecond, time.Minute)
+
+	time.Sleep(50 * time.Millisecond)
+	close(closedCh)
+	wg.Wait()
+
+	select {
+	case <-readyCh:
+		t.Errorf("readyCh closed without guest agent")
+	default:
+	}
+}
@@ -99,6 +99,8 @@ func purgeLauncherState() {
 
 	glog.Info("======= HARD RESET ======")
 
+	instancesDir = loadInstancesDir()
+
 	glog.Info("Shutting down running instances")
 
 	toRemove := make([]string, 0, 1024)
@@ -40,15 +40,27 @@ type instanceData struct {
 	instanceWg     sync.WaitGroup
 	monitorCh      chan interface{}
 	connectedCh    chan struct{}
+	readyCh        chan struct{}
 	monitorCloseCh chan struct{}
 	statsTimer     <-chan time.Time
 	vm             virtualizer
 	instanceDir    string
 	shuttingDown   bool
+	paused         bool
+	reclaimedMB    int
 	creating       bool
 	rcvStamp       time.Time
 	st             *startTimes
+	bootStamp      time.Time
+	bootTimeMS     int
 	storageDriver  storage.BlockDriver
+	volumeUsage    []payloads.VolumeStat
+	volumeStamp    time.Time
+	console        *consoleSession
+	guestAgentLock sync.Mutex
+	captureLock    sync.Mutex
+	captureStopCh  chan struct{}
+	migrationCh    chan error
 }
 
 type insStartCmd struct {
@@ -78,7 +90,38 @@ type insDeleteCmd struct {
 type insMonitorCmd struct{}
 
 type insAttachVolumeCmd struct {
-	volumeUUID string
+	volume volumeConfig
+}
+
+type insPauseCmd struct {
+	// Set to true to pause the instance and to false to unpause it.
+	pause bool
+}
+
+type insRescueCmd struct {
+	// The volume to boot the instance from, or nil to boot it from its
+	// original boot volume again.
+	volume *volumeConfig
+}
+
+type insRebootCmd struct {
+	// Whether the instance is killed straight away rather than asked to
+	// shut down before being booted again.
+	hard bool
+}
+
+type insUpdateMetadataCmd struct {
+	// The new cloud-init user data and meta data of the instance.
+	userData []byte
+	metaData []byte
+
+	// Whether the instance is rebooted once its metadata is updated.
+	reboot bool
+}
+
+type insBalloonCmd struct {
+	// The size in MB to which the instance's memory should be set.
+	sizeMB int
 }
 
 /*
@@ -132,8 +175,13 @@ func (id *instanceData) startCommand(cmd *insStartCmd) {
 		return
 	}
 	id.creating = true
-	st, startErr := processStart(cmd, id.instanceDir, id.vm, id.ac.conn)
+	var st *startTimes
+	startErr := reserveMigrationPort(cmd.cfg)
+	if startErr == nil {
+		st, startErr = processStart(cmd, id.instanceDir, id.vm, id.ac.conn)
+	}
 	if startErr != nil {
+		releaseMigrationPort(cmd.cfg)
 		glog.Errorf("Unable to start instance[%s]: %v", string(startErr.code), startErr.err)
 		startErr.send(id.ac.conn, id.instance)
 
@@ -147,9 +195,13 @@ func (id *instanceData) startCommand(cmd *insStartCmd) {
 	id.creating = false
 	id.st = st
 
+	id.bootStamp = id.rcvStamp
+	id.bootTimeMS = 0
 	id.connectedCh = make(chan struct{})
+	id.readyCh = make(chan struct{})
 	id.monitorCloseCh = make(chan struct{})
-	id.monitorCh = id.vm.monitorVM(id.monitorCloseCh, id.connectedCh, &id.instanceWg, false)
+	id.monitorCh = id.vm.monitorVM(id.monitorCloseCh, id.connectedCh, id.readyCh, id.ovsCh,
+		&id.instanceWg, false)
 	id.ovsCh <- &ovsStatusCmd{}
 	if cmd.frame != nil && cmd.frame.PathTrace() {
 		id.ovsCh <- &ovsTraceFrame{cmd.frame}
@@ -158,8 +210,10 @@ func (id *instanceData) startCommand(cmd *insStartCmd) {
 
 func (id *instanceData) monitorCommand(cmd *insMonitorCmd) {
 	id.connectedCh = make(chan struct{})
+	id.readyCh = make(chan struct{})
 	id.monitorCloseCh = make(chan struct{})
-	id.monitorCh = id.vm.monitorVM(id.monitorCloseCh, id.connectedCh, &id.instanceWg, true)
+	id.monitorCh = id.vm.monitorVM(id.monitorCloseCh, id.connectedCh, id.readyCh, id.ovsCh,
+		&id.instanceWg, true)
 }
 
 func (id *instanceData) sendInstanceDeletedEvent() {
@@ -179,10 +233,11 @@ func (id *instanceData) sendInstanceDeletedEvent() {
 	}
 }
 
-func (id *instanceData) sendInstanceStoppedEvent() {
+func (id *instanceData) sendInstanceStoppedEvent(method payloads.StopMethod) {
 	var event payloads.EventInstanceStopped
 
 	event.InstanceStopped.InstanceUUID = id.instance
+	event.InstanceStopped.StopMethod = method
 
 	payload, err := yaml.Marshal(&event)
 	if err != nil {
@@ -196,6 +251,34 @@ func (id *instanceData) sendInstanceStoppedEvent() {
 	}
 }
 
+// powerdown asks the guest of an instance to shut down and kills the
+// instance if it is still running after the shutdown grace period.  It
+// returns how the instance was stopped.
+func (id *instanceData) powerdown() payloads.StopMethod {
+	id.monitorCh <- virtualizerStopCmd{}
+
+	var timeout <-chan time.Time
+	if shutdownGracePeriod > 0 {
+		timeout = time.After(shutdownGracePeriod)
+	}
+
+	select {
+	case <-id.monitorCloseCh:
+		return payloads.StopGraceful
+	case <-timeout:
+	}
+
+	glog.Warningf("%s did not shut down within %v, killing it", id.instance, shutdownGracePeriod)
+	select {
+	case id.monitorCh <- virtualizerKillCmd{}:
+	case <-id.monitorCloseCh:
+		return payloads.StopGraceful
+	}
+	<-id.monitorCloseCh
+
+	return payloads.StopForced
+}
+
 func (id *instanceData) deleteCommand(cmd *insDeleteCmd) bool {
 	if id.shuttingDown && !cmd.suicide {
 		deleteErr := &deleteError{nil, payloads.DeleteNoInstance}
@@ -204,20 +287,21 @@ func (id *instanceData) deleteCommand(cmd *insDeleteCmd) bool {
 		return false
 	}
 
+	method := payloads.StopGraceful
 	if id.monitorCh != nil {
 		glog.Infof("Powerdown %s before deleting", id.instance)
-		id.monitorCh <- virtualizerStopCmd{}
-		<-id.monitorCloseCh
+		method = id.powerdown()
 		id.vm.lostVM()
 	}
 
 	_ = processDelete(id.vm, id.instanceDir, id.ac.conn, id.creating)
 
 	id.unmapVolumes()
+	releaseMigrationPort(id.cfg)
 
 	if !cmd.skipDeleteEvent {
 		if cmd.stop {
-			id.sendInstanceStoppedEvent()
+			id.sendInstanceStoppedEvent(method)
 		} else {
 			id.sendInstanceDeletedEvent()
 		}
@@ -230,20 +314,162 @@ func (id *instanceData) attachVolumeCommand(cmd *insAttachVolumeCmd) {
 	if id.shuttingDown {
 		attachErr := &attachVolumeError{nil, payloads.AttachVolumeInstanceFailure}
 		glog.Errorf("Unable to attach instance[%s]", string(attachErr.code))
-		attachErr.send(id.ac.conn, id.instance, cmd.volumeUUID)
+		attachErr.send(id.ac.conn, id.instance, cmd.volume.UUID)
 		return
 	}
 
 	attachErr := processAttachVolume(id.storageDriver, id.monitorCh, id.cfg, id.instance, id.instanceDir,
-		cmd.volumeUUID, id.ac.conn)
+		cmd.volume, id.ac.conn)
 	if attachErr != nil {
-		attachErr.send(id.ac.conn, id.instance, cmd.volumeUUID)
+		attachErr.send(id.ac.conn, id.instance, cmd.volume.UUID)
 		return
 	}
 	d, m, c := id.vm.stats()
-	id.ovsCh <- &ovsStatsUpdateCmd{id.instance, m, d, c, id.getVolumes()}
+	id.ovsCh <- &ovsStatsUpdateCmd{id.instance, m, d, c, id.getVolumes(), id.getVolumeUsage(), id.bootTimeMS}
+
+	glog.Infof("Volume %s attached to instance %s", cmd.volume.UUID, id.instance)
+}
+
+func (id *instanceData) pauseCommand(cmd *insPauseCmd) {
+	if id.shuttingDown || id.monitorCh == nil || id.connectedCh != nil || id.migrationCh != nil {
+		glog.Errorf("Unable to pause/unpause instance %s: not running", id.instance)
+		return
+	}
+
+	if id.paused == cmd.pause {
+		glog.Infof("Instance %s already in requested pause state", id.instance)
+		return
+	}
+
+	responseCh := make(chan error)
+	id.monitorCh <- virtualizerPauseCmd{responseCh, cmd.pause}
+	err := <-responseCh
+	if err != nil {
+		glog.Errorf("Unable to pause/unpause instance %s: %v", id.instance, err)
+		return
+	}
+
+	id.paused = cmd.pause
+	if id.paused {
+		glog.Infof("Instance %s paused", id.instance)
+		id.ovsCh <- &ovsStateChange{id.instance, ovsPaused}
+	} else {
+		glog.Infof("Instance %s unpaused", id.instance)
+		id.ovsCh <- &ovsStateChange{id.instance, ovsRunning}
+	}
+}
+
+func (id *instanceData) balloonCommand(cmd *insBalloonCmd) {
+	// We always report back to the overseer, even on failure, as it
+	// will not issue any new balloon requests for this instance until
+	// it hears from us.
+
+	defer func() {
+		id.ovsCh <- &ovsBalloonUpdate{id.instance, id.reclaimedMB}
+	}()
+
+	if id.shuttingDown || id.monitorCh == nil || id.connectedCh != nil || id.paused {
+		glog.Errorf("Unable to resize balloon of instance %s: not running", id.instance)
+		return
+	}
+
+	responseCh := make(chan error)
+	id.monitorCh <- virtualizerBalloonCmd{responseCh, cmd.sizeMB}
+	err := <-responseCh
+	if err != nil {
+		glog.Errorf("Unable to resize balloon of instance %s: %v", id.instance, err)
+		return
+	}
+
+	id.reclaimedMB = id.cfg.Mem - cmd.sizeMB
+	glog.Infof("Instance %s memory set to %d MB", id.instance, cmd.sizeMB)
+}
+
+func (id *instanceData) rescueCommand(cmd *insRescueCmd) {
+	if id.shuttingDown || id.monitorCh == nil || id.connectedCh != nil || id.migrationCh != nil {
+		glog.Errorf("Unable to rescue/unrescue instance %s: not running", id.instance)
+		return
+	}
+
+	if id.cfg.Container || id.cfg.NetworkNode {
+		glog.Errorf("Unable to rescue/unrescue instance %s: not supported", id.instance)
+		return
+	}
 
-	glog.Infof("Volume %s attached to instance %s", cmd.volumeUUID, id.instance)
+	if (id.cfg.rescueVolume() != nil) == (cmd.volume != nil) {
+		glog.Infof("Instance %s already in requested rescue state", id.instance)
+		return
+	}
+
+	if !id.relaunch(id.cfg.rescueConfig(cmd.volume), false) {
+		return
+	}
+
+	if cmd.volume != nil {
+		glog.Infof("Instance %s rebooted from rescue volume %s", id.instance, cmd.volume.UUID)
+	} else {
+		glog.Infof("Instance %s rebooted from its boot volume", id.instance)
+	}
+}
+
+func (id *instanceData) rebootCommand(cmd *insRebootCmd) {
+	if id.shuttingDown || id.monitorCh == nil || id.connectedCh != nil || id.migrationCh != nil {
+		glog.Errorf("Unable to reboot instance %s: not running", id.instance)
+		return
+	}
+
+	if !id.relaunch(id.cfg, cmd.hard) {
+		return
+	}
+
+	glog.Infof("Instance %s rebooted", id.instance)
+}
+
+// relaunch powers off a running instance and boots it again in place with
+// cfg.  The instance is asked to shut down first unless hard is set, in
+// which case it is killed straight away.  relaunch returns false if the
+// instance could not be booted again, in which case it is being killed.
+func (id *instanceData) relaunch(cfg *vmConfig, hard bool) bool {
+	if hard {
+		glog.Infof("Killing %s before rebooting it", id.instance)
+		select {
+		case id.monitorCh <- virtualizerKillCmd{}:
+			<-id.monitorCloseCh
+		case <-id.monitorCloseCh:
+		}
+	} else {
+		glog.Infof("Powerdown %s before rebooting it", id.instance)
+		id.powerdown()
+	}
+	id.vm.lostVM()
+	close(id.monitorCh)
+	id.monitorCh = nil
+	id.monitorCloseCh = nil
+	id.statsTimer = nil
+	id.paused = false
+	id.reclaimedMB = 0
+	id.unmapVolumes()
+
+	err := processRelaunch(id.vm, id.instanceDir, cfg, id.ac.conn)
+	if err != nil {
+		glog.Errorf("Unable to reboot instance %s: %v", id.instance, err)
+		id.ovsCh <- &ovsStateChange{id.instance, ovsStopped}
+		killMe(id.instance, false, true, id.doneCh, id.ac, &id.instanceWg)
+		id.shuttingDown = true
+		return false
+	}
+	id.cfg = cfg
+
+	id.bootStamp = time.Now()
+	id.bootTimeMS = 0
+	id.connectedCh = make(chan struct{})
+	id.readyCh = make(chan struct{})
+	id.monitorCloseCh = make(chan struct{})
+	id.monitorCh = id.vm.monitorVM(id.monitorCloseCh, id.connectedCh, id.readyCh, id.ovsCh,
+		&id.instanceWg, false)
+	id.ovsCh <- &ovsStatusCmd{}
+
+	return true
 }
 
 func (id *instanceData) logStartTrace() {
@@ -268,6 +494,22 @@ func (id *instanceData) logStartTrace() {
 	glog.Info("=========================================")
 }
 
+// guestReady records the boot time of an instance, from the receipt of the
+// START command, or from its reboot, to its guest being ready, and reports
+// it to the overseer.  The boot time of instances the launcher reconnected
+// to when it started is not known.
+func (id *instanceData) guestReady() {
+	if id.bootStamp.IsZero() {
+		return
+	}
+
+	id.bootTimeMS = int(time.Since(id.bootStamp) / time.Millisecond)
+	glog.Infof("Instance %s booted in %d ms", id.instance, id.bootTimeMS)
+
+	d, m, c := id.vm.stats()
+	id.ovsCh <- &ovsStatsUpdateCmd{id.instance, m, d, c, id.getVolumes(), id.getVolumeUsage(), id.bootTimeMS}
+}
+
 func (id *instanceData) instanceCommand(cmd interface{}) bool {
 	select {
 	case <-id.doneCh:
@@ -283,6 +525,24 @@ func (id *instanceData) instanceCommand(cmd interface{}) bool {
 		id.monitorCommand(cmd)
 	case *insAttachVolumeCmd:
 		id.attachVolumeCommand(cmd)
+	case *insPauseCmd:
+		id.pauseCommand(cmd)
+	case *insBalloonCmd:
+		id.balloonCommand(cmd)
+	case *insRescueCmd:
+		id.rescueCommand(cmd)
+	case *insRebootCmd:
+		id.rebootCommand(cmd)
+	case *insUpdateMetadataCmd:
+		id.updateMetadataCommand(cmd)
+	case *insConsoleCmd:
+		id.consoleCommand(cmd)
+	case *insGuestAgentCmd:
+		id.guestAgentCommand(cmd)
+	case *insPacketCaptureCmd:
+		id.packetCaptureCommand(cmd)
+	case *insMigrateCmd:
+		id.migrateCommand(cmd)
 	case *insDeleteCmd:
 		if id.deleteCommand(cmd) {
 			return false
@@ -302,6 +562,38 @@ func (id *instanceData) getVolumes() []string {
 	return volumes
 }
 
+// getVolumeUsage returns the space allocated to the volumes of the instance,
+// refreshing it when it is out of date or when a volume has been attached.
+func (id *instanceData) getVolumeUsage() []payloads.VolumeStat {
+	if len(id.cfg.Volumes) == 0 {
+		return nil
+	}
+
+	if len(id.volumeUsage) == len(id.cfg.Volumes) &&
+		time.Since(id.volumeStamp) < time.Second*volumeUsagePeriod {
+		return id.volumeUsage
+	}
+
+	usage := make([]payloads.VolumeStat, 0, len(id.cfg.Volumes))
+	for _, v := range id.cfg.Volumes {
+		used, err := id.storageDriver.WithPool(v.Pool).GetBlockDeviceUsage(v.UUID)
+		if err != nil {
+			glog.Warningf("Unable to get usage of volume %s: %v", v.UUID, err)
+			continue
+		}
+
+		usage = append(usage, payloads.VolumeStat{
+			VolumeUUID: v.UUID,
+			UsedMB:     int((used + 1024*1024 - 1) / (1024 * 1024)),
+		})
+	}
+
+	id.volumeUsage = usage
+	id.volumeStamp = time.Now()
+
+	return usage
+}
+
 func (id *instanceData) unmapVolumes() {
 	glog.Infof("Unmapping volumes for %s", id.instance)
 
@@ -311,7 +603,7 @@ func (id *instanceData) unmapVolumes() {
 		// instances on the same node.  We don't treat this as an
 		// error for now.
 
-		if err := id.storageDriver.UnmapVolumeFromNode(v.UUID); err == nil {
+		if err := unmapVolume(id.storageDriver, v); err == nil {
 			glog.Infof("Unmapping volume %s", v.UUID)
 		}
 	}
@@ -322,7 +614,7 @@ func (id *instanceData) instanceLoop() {
 	id.vm.init(id.cfg, id.instanceDir)
 
 	d, m, c := id.vm.stats()
-	id.ovsCh <- &ovsStatsUpdateCmd{id.instance, m, d, c, id.getVolumes()}
+	id.ovsCh <- &ovsStatsUpdateCmd{id.instance, m, d, c, id.getVolumes(), id.getVolumeUsage(), id.bootTimeMS}
 
 DONE:
 	for {
@@ -331,24 +623,31 @@ DONE:
 			break DONE
 		case <-id.statsTimer:
 			d, m, c := id.vm.stats()
-			id.ovsCh <- &ovsStatsUpdateCmd{id.instance, m, d, c, id.getVolumes()}
+			id.ovsCh <- &ovsStatsUpdateCmd{id.instance, m, d, c, id.getVolumes(), id.getVolumeUsage(), id.bootTimeMS}
 			id.statsTimer = time.After(time.Second * resourcePeriod)
 		case cmd := <-id.cmdCh:
 			if !id.instanceCommand(cmd) {
 				break DONE
 			}
 		case <-id.monitorCloseCh:
+			if id.migrationCh != nil && id.migrationLost() {
+				break
+			}
+
 			// Means we've lost VM for now
 			id.vm.lostVM()
 			d, m, c := id.vm.stats()
-			id.ovsCh <- &ovsStatsUpdateCmd{id.instance, m, d, c, id.getVolumes()}
+			id.ovsCh <- &ovsStatsUpdateCmd{id.instance, m, d, c, id.getVolumes(), id.getVolumeUsage(), id.bootTimeMS}
 
 			glog.Infof("Lost VM instance: %s", id.instance)
 			id.monitorCloseCh = nil
 			id.connectedCh = nil
+			id.readyCh = nil
 			close(id.monitorCh)
 			id.monitorCh = nil
 			id.statsTimer = nil
+			id.paused = false
+			id.reclaimedMB = 0
 			id.ovsCh <- &ovsStateChange{id.instance, ovsStopped}
 			id.st = nil
 			killMe(id.instance, false, true, id.doneCh, id.ac, &id.instanceWg)
@@ -357,10 +656,19 @@ DONE:
 			id.logStartTrace()
 			id.connectedCh = nil
 			id.vm.connected()
-			id.ovsCh <- &ovsStateChange{id.instance, ovsRunning}
+			if id.cfg.migrationPort != 0 {
+				id.incomingMigration()
+			} else {
+				id.ovsCh <- &ovsStateChange{id.instance, ovsRunning}
+			}
 			d, m, c := id.vm.stats()
-			id.ovsCh <- &ovsStatsUpdateCmd{id.instance, m, d, c, id.getVolumes()}
+			id.ovsCh <- &ovsStatsUpdateCmd{id.instance, m, d, c, id.getVolumes(), id.getVolumeUsage(), id.bootTimeMS}
 			id.statsTimer = time.After(time.Second * resourcePeriod)
+		case <-id.readyCh:
+			id.readyCh = nil
+			id.guestReady()
+		case err := <-id.migrationCh:
+			id.migrationDone(err)
 		}
 	}
 
@@ -368,6 +676,12 @@ DONE:
 		close(id.monitorCh)
 	}
 
+	if id.console != nil {
+		id.closeConsole()
+	}
+
+	close(id.captureStopCh)
+
 	glog.Infof("Instance goroutine %s waiting for monitor to exit", id.instance)
 	id.instanceWg.Wait()
 	glog.Infof("Instance goroutine %s exitted", id.instance)
@@ -388,6 +702,7 @@ func startInstanceWithVM(instance string, cfg *vmConfig, wg *sync.WaitGroup, don
 		vm:            vm,
 		instanceDir:   path.Join(instancesDir, instance),
 		storageDriver: storageDriver,
+		captureStopCh: make(chan struct{}),
 	}
 
 	wg.Add(1)
@@ -408,7 +723,7 @@ func startInstance(instance string, cfg *vmConfig, wg *sync.WaitGroup, doneCh ch
 	} else if cfg.Container {
 		vm = &docker{storageDriver: storageDriver}
 	} else {
-		vm = &qemuV{}
+		vm = &qemuV{storageDriver: storageDriver}
 	}
 	return startInstanceWithVM(instance, cfg, wg, doneCh, ac, ovsCh, vm, storageDriver,
 		instancesDir)
@@ -19,7 +19,9 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"path"
 	"sync"
@@ -67,9 +69,15 @@ type instanceTestState struct {
 	errorCh         chan struct{}
 	eventCh         chan struct{}
 	monitorClosedCh chan struct{}
+	connectedCh     chan struct{}
+	readyCh         chan struct{}
 	failStartVM     bool
+	ignoreStop      bool
 	ac              *agentClient
 	cfg             *vmConfig
+	consoleCh       chan payloads.ConsoleOutputEvent
+	migrationCh     chan payloads.MigrationStatusEvent
+	metadataCh      chan *insUpdateMetadataCmd
 }
 
 func (v *instanceTestState) init(cfg *vmConfig, instanceDir string) {
@@ -91,6 +99,13 @@ func (v *instanceTestState) deleteImage() error {
 	return nil
 }
 
+func (v *instanceTestState) updateMetadata(userData, metaData []byte) error {
+	if v.metadataCh != nil {
+		v.metadataCh <- &insUpdateMetadataCmd{userData: userData, metaData: metaData}
+	}
+	return nil
+}
+
 func (v *instanceTestState) startVM(vnicName, ipAddress, cephID string, fds []*os.File) error {
 	if v.failStartVM {
 		return fmt.Errorf("Failed to start VM")
@@ -98,19 +113,21 @@ func (v *instanceTestState) startVM(vnicName, ipAddress, cephID string, fds []*o
 	return nil
 }
 
-func (v *instanceTestState) monitorVM(closedCh chan struct{}, connectedCh chan struct{},
-	wg *sync.WaitGroup, boot bool) chan interface{} {
+func (v *instanceTestState) monitorVM(closedCh chan struct{}, connectedCh chan struct{}, readyCh chan struct{},
+	ovsCh chan<- interface{}, wg *sync.WaitGroup, boot bool) chan interface{} {
 
 	// Need to be careful here not to modify any state inside v before
 	// we've closed the channel.
 
 	v.monitorClosedCh = closedCh
+	v.connectedCh = connectedCh
 
 	monitorCh := make(chan interface{})
 	v.monitorCh = monitorCh
 	if v.connect {
 		close(connectedCh)
 	}
+	v.readyCh = readyCh
 	return monitorCh
 }
 
@@ -152,6 +169,32 @@ func (v *instanceTestState) SendError(error ssntp.Error, payload []byte) (int, e
 }
 
 func (v *instanceTestState) SendEvent(event ssntp.Event, payload []byte) (int, error) {
+	// console output is sent by the console readers, concurrently with
+	// the other events.
+	if event == ssntp.ConsoleOutput {
+		var co payloads.EventConsoleOutput
+		err := yaml.Unmarshal(payload, &co)
+		if err != nil {
+			v.t.Errorf("Failed to unmarshall consoleOutput event %v", err)
+		}
+		if v.consoleCh != nil {
+			v.consoleCh <- co.ConsoleOutput
+		}
+		return 0, nil
+	}
+
+	if event == ssntp.MigrationStatus {
+		var ms payloads.EventMigrationStatus
+		err := yaml.Unmarshal(payload, &ms)
+		if err != nil {
+			v.t.Errorf("Failed to unmarshall migrationStatus event %v", err)
+		}
+		if v.migrationCh != nil {
+			v.migrationCh <- ms.MigrationStatus
+		}
+		return 0, nil
+	}
+
 	switch event {
 	case ssntp.InstanceDeleted:
 		v.deMigration = false
@@ -174,6 +217,14 @@ func (v *instanceTestState) SendEvent(event ssntp.Event, payload []byte) (int, e
 	return 0, nil
 }
 
+func (v *instanceTestState) SendEventStream(event ssntp.Event, payload io.Reader) (int, error) {
+	data, err := ioutil.ReadAll(payload)
+	if err != nil {
+		return 0, err
+	}
+	return v.SendEvent(event, data)
+}
+
 func (v *instanceTestState) Dial(config *ssntp.Config, ntf ssntp.ClientNotifier) error {
 	return nil
 }
@@ -249,6 +300,12 @@ func (v *instanceTestState) expectStatsUpdateWithVolumes(t *testing.T,
 		return false
 	}
 
+	if len(volumes) != len(stats.volumeUsage) {
+		t.Errorf("Unxpected volume usage.  Expected %d volumes found %d",
+			len(volumes), len(stats.volumeUsage))
+		return false
+	}
+
 	return true
 }
 
@@ -277,6 +334,15 @@ func (v *instanceTestState) checkDeleteEvent(t *testing.T, cmd *insDeleteCmd) {
 	var instance string
 	if cmd.stop {
 		instance = v.se.InstanceStopped.InstanceUUID
+
+		method := payloads.StopGraceful
+		if v.ignoreStop {
+			method = payloads.StopForced
+		}
+		if v.se.InstanceStopped.StopMethod != method {
+			t.Errorf("Incorrect stop method.  Expected %s got %s",
+				method, v.se.InstanceStopped.StopMethod)
+		}
 	} else {
 		instance = v.de.InstanceDeleted.InstanceUUID
 	}
@@ -315,7 +381,17 @@ func (v *instanceTestState) handleInstanceShutdown(t *testing.T, ovsCh chan inte
 				return true
 			}
 		case monCmd := <-v.monitorCh:
-			if _, stopCmd := monCmd.(virtualizerStopCmd); !stopCmd {
+			switch monCmd.(type) {
+			case virtualizerStopCmd:
+				if v.ignoreStop {
+					continue
+				}
+			case virtualizerKillCmd:
+				if !v.ignoreStop {
+					t.Error("Instance killed while shutting down")
+					return false
+				}
+			default:
 				t.Errorf("Invalid monitor command found %t, expected virtualizerStopCmd", monCmd)
 				return false
 			}
@@ -380,6 +456,25 @@ func waitForStateChange(t *testing.T, ovsState ovsRunningState, ovsCh chan inter
 	}
 }
 
+func waitForStatusCmd(t *testing.T, ovsCh chan interface{}) bool {
+	for {
+		select {
+		case ovsCmd := <-ovsCh:
+			switch ovsCmd.(type) {
+			case *ovsStatusCmd:
+				return true
+			case *ovsStatsUpdateCmd:
+			default:
+				t.Error("Unexpected commands received on ovsCh")
+				return false
+			}
+		case <-time.After(time.Second):
+			t.Error("Timed out waiting for overseer channel")
+			return false
+		}
+	}
+}
+
 func (v *instanceTestState) startInstance(t *testing.T, ovsCh chan interface{},
 	cmdCh chan<- interface{}, cfg *vmConfig, errorOk bool) bool {
 
@@ -803,6 +898,33 @@ func TestStartRunningInstance(t *testing.T) {
 // The instanceLoop and then instance should start correctly.  The instance should
 // then be deleted correctly and the InstanceStopped ssntp event should be received.
 // The instanceLoop should exit cleanly.
+// Check the boot time of an instance is reported.
+//
+// Start an instance, wait a little and close its readyCh, indicating that
+// its guest has booted.
+//
+// A stats update with the boot time of the instance should be sent to the
+// overseer.
+func TestInstanceBootTime(t *testing.T) {
+	var wg sync.WaitGroup
+	cfg := standardCfg
+	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
+
+	time.Sleep(10 * time.Millisecond)
+	close(state.readyCh)
+
+	stats := state.getStatsUpdate(t, ovsCh)
+	if stats != nil && stats.bootTimeMS < 10 {
+		t.Errorf("Unexpected boot time %d ms", stats.bootTimeMS)
+	}
+
+	if !state.deleteInstance(t, ovsCh, cmdCh) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh, &wg)
+	}
+
+	wg.Wait()
+}
+
 func TestMigrateInstance(t *testing.T) {
 	var wg sync.WaitGroup
 	cfg := standardCfg
@@ -815,6 +937,31 @@ func TestMigrateInstance(t *testing.T) {
 	wg.Wait()
 }
 
+// Check an instance whose guest does not shut down is killed.
+//
+// We start the instance loop and then start an instance whose test virtualizer
+// ignores the stop command, and stop it.
+//
+// The instance should be killed once the shutdown grace period expired and
+// the InstanceStopped ssntp event should report it was forced to stop.
+func TestStopInstanceForced(t *testing.T) {
+	var wg sync.WaitGroup
+	cfg := standardCfg
+
+	savedGracePeriod := shutdownGracePeriod
+	shutdownGracePeriod = 10 * time.Millisecond
+	defer func() { shutdownGracePeriod = savedGracePeriod }()
+
+	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
+	state.ignoreStop = true
+
+	if !state.deleteInstanceEx(t, ovsCh, cmdCh, &insDeleteCmd{stop: true}) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh, &wg)
+	}
+
+	wg.Wait()
+}
+
 // Check we can add a volume to an instance
 //
 // We start the instance loop, add a volume, wait for the instance statistics
@@ -829,7 +976,7 @@ func TestAttachVolumeToInstance(t *testing.T) {
 	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
 
 	select {
-	case cmdCh <- &insAttachVolumeCmd{testutil.VolumeUUID}:
+	case cmdCh <- &insAttachVolumeCmd{volume: volumeConfig{UUID: testutil.VolumeUUID}}:
 	case <-time.After(time.Second):
 		t.Error("Timed out sending attach volume command")
 	}
@@ -850,6 +997,558 @@ func TestAttachVolumeToInstance(t *testing.T) {
 	wg.Wait()
 }
 
+// Check that an instance can be paused and unpaused
+//
+// We start the instance loop, pause the instance, unpause it and then delete
+// the instance.
+//
+// The instanceLoop and then instance should start correctly.  The pause and
+// unpause commands should be forwarded to the virtualizer and the overseer
+// should be informed of the resulting state changes.  The instance should be
+// correctly deleted.
+func TestPauseInstance(t *testing.T) {
+	var wg sync.WaitGroup
+	cfg := standardCfg
+	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
+
+	for _, pause := range []bool{true, false} {
+		select {
+		case cmdCh <- &insPauseCmd{pause}:
+		case <-time.After(time.Second):
+			t.Error("Timed out sending pause command")
+		}
+
+		select {
+		case monCmd := <-state.monitorCh:
+			pauseCmd := monCmd.(virtualizerPauseCmd)
+			if pauseCmd.pause != pause {
+				t.Errorf("Expected pause %v, found %v", pause, pauseCmd.pause)
+			}
+			pauseCmd.responseCh <- nil
+		case <-time.After(time.Second):
+			t.Error("Timed out waiting for pause command")
+		}
+
+		expected := ovsRunning
+		if pause {
+			expected = ovsPaused
+		}
+		if !waitForStateChange(t, expected, ovsCh) {
+			cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh, &wg)
+		}
+	}
+
+	if !state.deleteInstance(t, ovsCh, cmdCh) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh, &wg)
+	}
+
+	wg.Wait()
+}
+
+// Check that the memory of an instance can be ballooned.
+//
+// We start the instance loop, send a balloon command, reply to the resulting
+// virtualizer command and then delete the instance.
+//
+// The balloon command should be forwarded to the virtualizer and the overseer
+// should be informed of the amount of memory reclaimed.
+func TestBalloonInstance(t *testing.T) {
+	var wg sync.WaitGroup
+	cfg := standardCfg
+	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
+
+	sizeMB := cfg.Mem / 2
+	select {
+	case cmdCh <- &insBalloonCmd{sizeMB}:
+	case <-time.After(time.Second):
+		t.Error("Timed out sending balloon command")
+	}
+
+	select {
+	case monCmd := <-state.monitorCh:
+		balloonCmd := monCmd.(virtualizerBalloonCmd)
+		if balloonCmd.sizeMB != sizeMB {
+			t.Errorf("Expected size %d, found %d", sizeMB, balloonCmd.sizeMB)
+		}
+		balloonCmd.responseCh <- nil
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for balloon command")
+	}
+
+DONE:
+	for {
+		select {
+		case ovsCmd := <-ovsCh:
+			switch update := ovsCmd.(type) {
+			case *ovsBalloonUpdate:
+				if update.reclaimedMB != cfg.Mem-sizeMB {
+					t.Errorf("Expected %d MB reclaimed, found %d",
+						cfg.Mem-sizeMB, update.reclaimedMB)
+				}
+				break DONE
+			case *ovsStatsUpdateCmd:
+			default:
+				t.Error("Unexpected commands received on ovsCh")
+				break DONE
+			}
+		case <-time.After(time.Second):
+			t.Error("Timed out waiting for balloon update")
+			break DONE
+		}
+	}
+
+	if !state.deleteInstance(t, ovsCh, cmdCh) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh, &wg)
+	}
+
+	wg.Wait()
+}
+
+// Check that an instance can be rescued and unrescued
+//
+// We start the instance loop, rescue the instance, unrescue it and then
+// delete the instance.
+//
+// The instanceLoop and then instance should start correctly.  Each command
+// should power off the VM and boot it again, from the rescue volume and then
+// from its original volumes.  The instance should be correctly deleted.
+func TestRescueInstance(t *testing.T) {
+	var wg sync.WaitGroup
+	cfg := standardCfg
+	cfg.Volumes = []volumeConfig{{UUID: "boot", Bootable: true}}
+	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
+
+	rescueVol := &volumeConfig{UUID: "rescue", Bootable: true, Rescue: true}
+	for _, vol := range []*volumeConfig{rescueVol, nil} {
+		monitorCh := state.monitorCh
+		closedCh := state.monitorClosedCh
+
+		select {
+		case cmdCh <- &insRescueCmd{vol}:
+		case <-time.After(time.Second):
+			t.Error("Timed out sending rescue command")
+		}
+
+		select {
+		case monCmd := <-monitorCh:
+			if _, stopCmd := monCmd.(virtualizerStopCmd); !stopCmd {
+				t.Errorf("Invalid monitor command found %t, expected virtualizerStopCmd", monCmd)
+			}
+			close(closedCh)
+		case <-time.After(time.Second):
+			t.Error("Timed out waiting for stop command")
+		}
+
+		expected := []string{"boot"}
+		if vol != nil {
+			expected = []string{"rescue", "boot"}
+		}
+
+		if !waitForStatusCmd(t, ovsCh) ||
+			!waitForStateChange(t, ovsRunning, ovsCh) ||
+			!state.expectStatsUpdateWithVolumes(t, ovsCh, expected) {
+			cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh, &wg)
+		}
+
+		if len(state.cfg.Volumes) != len(expected) {
+			t.Fatalf("Expected %d volumes, found %d", len(expected), len(state.cfg.Volumes))
+		}
+		for i := range expected {
+			if state.cfg.Volumes[i].UUID != expected[i] {
+				t.Errorf("Expected volume %s, found %s", expected[i], state.cfg.Volumes[i].UUID)
+			}
+		}
+	}
+
+	if !state.deleteInstance(t, ovsCh, cmdCh) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh, &wg)
+	}
+
+	wg.Wait()
+}
+
+// Check that an instance can be rebooted in place
+//
+// We start the instance loop, soft reboot the instance, hard reboot it and
+// then delete the instance.
+//
+// The instanceLoop and then instance should start correctly.  The soft
+// reboot should power off the VM, the hard reboot should kill it, and both
+// should boot it again with its volumes.  The instance should be correctly
+// deleted.
+func TestRebootInstance(t *testing.T) {
+	var wg sync.WaitGroup
+	cfg := standardCfg
+	cfg.Volumes = []volumeConfig{{UUID: "boot", Bootable: true}}
+	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
+
+	for _, hard := range []bool{false, true} {
+		monitorCh := state.monitorCh
+		closedCh := state.monitorClosedCh
+
+		select {
+		case cmdCh <- &insRebootCmd{hard}:
+		case <-time.After(time.Second):
+			t.Error("Timed out sending reboot command")
+		}
+
+		select {
+		case monCmd := <-monitorCh:
+			switch monCmd.(type) {
+			case virtualizerStopCmd:
+				if hard {
+					t.Error("Instance powered down by a hard reboot")
+				}
+			case virtualizerKillCmd:
+				if !hard {
+					t.Error("Instance killed by a soft reboot")
+				}
+			default:
+				t.Errorf("Invalid monitor command found %t", monCmd)
+			}
+			close(closedCh)
+		case <-time.After(time.Second):
+			t.Error("Timed out waiting for stop command")
+		}
+
+		if !waitForStatusCmd(t, ovsCh) ||
+			!waitForStateChange(t, ovsRunning, ovsCh) ||
+			!state.expectStatsUpdateWithVolumes(t, ovsCh, []string{"boot"}) {
+			cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh, &wg)
+		}
+	}
+
+	if !state.deleteInstance(t, ovsCh, cmdCh) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh, &wg)
+	}
+
+	wg.Wait()
+}
+
+// Check that the metadata of an instance can be updated.
+//
+// We start the instance loop, update the metadata of the instance asking
+// for it to be rebooted and then delete the instance.
+//
+// The new metadata should be passed to the virtualizer, the instance should
+// then be powered down and launched again.
+func TestUpdateMetadataInstance(t *testing.T) {
+	var wg sync.WaitGroup
+	cfg := standardCfg
+	cfg.Volumes = []volumeConfig{{UUID: "boot", Bootable: true}}
+	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
+	state.metadataCh = make(chan *insUpdateMetadataCmd, 1)
+
+	monitorCh := state.monitorCh
+	closedCh := state.monitorClosedCh
+
+	select {
+	case cmdCh <- &insUpdateMetadataCmd{
+		userData: []byte(testutil.UpdatedUserData),
+		metaData: []byte(testutil.UpdatedMetaData),
+		reboot:   true,
+	}:
+	case <-time.After(time.Second):
+		t.Error("Timed out sending update metadata command")
+	}
+
+	select {
+	case update := <-state.metadataCh:
+		if string(update.userData) != testutil.UpdatedUserData ||
+			string(update.metaData) != testutil.UpdatedMetaData {
+			t.Errorf("Unexpected metadata %q %q", update.userData, update.metaData)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for metadata update")
+	}
+
+	select {
+	case monCmd := <-monitorCh:
+		if _, ok := monCmd.(virtualizerStopCmd); !ok {
+			t.Errorf("Invalid monitor command found %T", monCmd)
+		}
+		close(closedCh)
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for stop command")
+	}
+
+	if !waitForStatusCmd(t, ovsCh) ||
+		!waitForStateChange(t, ovsRunning, ovsCh) ||
+		!state.expectStatsUpdateWithVolumes(t, ovsCh, []string{"boot"}) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh, &wg)
+	}
+
+	if !state.deleteInstance(t, ovsCh, cmdCh) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh, &wg)
+	}
+
+	wg.Wait()
+}
+
+func expectMigrationStatus(t *testing.T, migrationCh chan payloads.MigrationStatusEvent,
+	state payloads.MigrationState) *payloads.MigrationStatusEvent {
+	select {
+	case status := <-migrationCh:
+		if status.State != state {
+			t.Errorf("Expected migration state %s, found %s (%s)", state,
+				status.State, status.Error)
+		}
+		return &status
+	case <-time.After(time.Second):
+		t.Errorf("Timed out waiting for migration status %s", state)
+	}
+	return nil
+}
+
+func expectMigrateCmd(t *testing.T, monitorCh chan interface{}, uri string) *virtualizerMigrateCmd {
+	select {
+	case monCmd := <-monitorCh:
+		migrateCmd, ok := monCmd.(virtualizerMigrateCmd)
+		if !ok {
+			t.Errorf("Invalid monitor command found %t, expected virtualizerMigrateCmd", monCmd)
+			return nil
+		}
+		if migrateCmd.uri != uri {
+			t.Errorf("Expected migration to %q, found %q", uri, migrateCmd.uri)
+		}
+		return &migrateCmd
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for migrate command")
+	}
+	return nil
+}
+
+// Check that a running instance can be live migrated.
+//
+// We start the instance loop and send a migrate command whose migration
+// fails, then a second one whose migration completes.  We then forward the
+// delete command the instance sends to itself.
+//
+// The instance should be reported as migrating while it is migrated and as
+// running again once the first migration failed.  Once the second
+// migration completed the instance should be killed and deleted without
+// any delete event being sent, as it runs on the target node.
+func TestLiveMigrateInstance(t *testing.T) {
+	var wg sync.WaitGroup
+	cfg := standardCfg
+	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
+	state.migrationCh = make(chan payloads.MigrationStatusEvent, 1)
+
+	for _, fail := range []bool{true, false} {
+		select {
+		case cmdCh <- &insMigrateCmd{testutil.TargetAgentUUID, testutil.MigrationAddress}:
+		case <-time.After(time.Second):
+			t.Error("Timed out sending migrate command")
+		}
+
+		migrateCmd := expectMigrateCmd(t, state.monitorCh, "tcp:"+testutil.MigrationAddress)
+		if migrateCmd == nil || !waitForStateChange(t, ovsMigrating, ovsCh) {
+			cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh, &wg)
+		}
+
+		if fail {
+			migrateCmd.resultCh <- fmt.Errorf("Migration failed")
+			if !waitForStateChange(t, ovsRunning, ovsCh) {
+				cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh, &wg)
+			}
+			expectMigrationStatus(t, state.migrationCh, payloads.MigrationFailed)
+			continue
+		}
+
+		migrateCmd.resultCh <- nil
+		select {
+		case monCmd := <-state.monitorCh:
+			if _, ok := monCmd.(virtualizerKillCmd); !ok {
+				t.Errorf("Invalid monitor command found %t, expected virtualizerKillCmd", monCmd)
+			}
+			close(state.monitorClosedCh)
+		case <-time.After(time.Second):
+			t.Error("Timed out waiting for kill command")
+		}
+		expectMigrationStatus(t, state.migrationCh, payloads.MigrationCompleted)
+	}
+
+	timeout := time.After(time.Second * 5)
+	var cmd *cmdWrapper
+DONE:
+	for {
+		select {
+		case <-ovsCh:
+		case cmd = <-state.ac.cmdCh:
+			break DONE
+		case <-timeout:
+			t.Error("Timedout waiting for delete cmd")
+			shutdownInstanceLoop(doneCh, ovsCh, &wg, t)
+			t.FailNow()
+		}
+	}
+
+	delCmd := cmd.cmd.(*insDeleteCmd)
+	if !delCmd.skipDeleteEvent || delCmd.stop {
+		t.Errorf("Unexpected delete command %+v", delCmd)
+	}
+
+	state.errorCh = make(chan struct{})
+	state.eventCh = make(chan struct{})
+	select {
+	case cmdCh <- delCmd:
+	case <-time.After(time.Second):
+		shutdownInstanceLoop(doneCh, ovsCh, &wg, t)
+		t.Fatal("Timed out sending delete command")
+	}
+
+	wg.Wait()
+
+	select {
+	case <-state.eventCh:
+		t.Error("Unexpected event sent for migrated instance")
+	default:
+	}
+}
+
+// Check that an instance can be live migrated to the node.
+//
+// We start the instance loop and start an instance waiting for its
+// migration from another node, simulate qemu waiting for it and complete
+// the migration.  We then delete the instance.
+//
+// The instance should report the address on which it waits for its state
+// and be reported as migrating until the migration completes, at which
+// point it should be reported as running.
+func TestIncomingLiveMigration(t *testing.T) {
+	var wg sync.WaitGroup
+	cfg := standardCfg
+	cfg.migrationSource = testutil.AgentUUID
+	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, false, false)
+	state.migrationCh = make(chan payloads.MigrationStatusEvent, 1)
+
+	close(state.connectedCh)
+	migrateCmd := expectMigrateCmd(t, state.monitorCh, "")
+	if migrateCmd == nil || !waitForStateChange(t, ovsMigrating, ovsCh) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh, &wg)
+	}
+
+	status := expectMigrationStatus(t, state.migrationCh, payloads.MigrationIncoming)
+	if status != nil {
+		_, port, err := net.SplitHostPort(status.Address)
+		if err != nil || port == "" {
+			t.Errorf("Invalid migration address %s", status.Address)
+		}
+	}
+
+	migrateCmd.resultCh <- nil
+	if !waitForStateChange(t, ovsRunning, ovsCh) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh, &wg)
+	}
+
+	if !state.deleteInstance(t, ovsCh, cmdCh) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh, &wg)
+	}
+
+	wg.Wait()
+
+	if len(migrationPortGrabber.free) != migrationPortMax-migrationPortStart {
+		t.Error("Expected the migration port to be released")
+	}
+}
+
+func expectConsoleOutput(t *testing.T, consoleCh chan payloads.ConsoleOutputEvent,
+	output string, closed bool) {
+	select {
+	case co := <-consoleCh:
+		if co.Output != output || co.Closed != closed {
+			t.Errorf("Expected output %q closed %v, found %q %v",
+				output, closed, co.Output, co.Closed)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for console output")
+	}
+}
+
+// Check that an emergency console session can be relayed.
+//
+// We start the instance loop, listen on the console socket of the
+// instance, open a console session, exchange some data with it, close it
+// and then delete the instance.
+//
+// The output of the console should be reported to the controller, the input
+// of the session should be written to the console and the session should
+// be reported closed once closed.  Input for a session which is not open
+// should be rejected by reporting the session closed.
+func TestConsoleInstance(t *testing.T) {
+	var wg sync.WaitGroup
+	cfg := standardCfg
+	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
+	state.consoleCh = make(chan payloads.ConsoleOutputEvent, 8)
+
+	instanceDir := path.Join(testInstancesDir, state.instance)
+	err := os.MkdirAll(instanceDir, 0755)
+	if err != nil {
+		t.Fatalf("Unable to create instance directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(instanceDir) }()
+
+	l, err := net.Listen("unix", consoleSocketPath(instanceDir))
+	if err != nil {
+		t.Fatalf("Unable to listen on console socket: %v", err)
+	}
+	defer func() { _ = l.Close() }()
+
+	session := testutil.ConsoleSessionUUID
+	cmds := []*insConsoleCmd{
+		{session: session, action: payloads.ConsoleOpen},
+		{session: session, action: payloads.ConsoleInput, input: "root\n"},
+		{session: testutil.InstanceUUID, action: payloads.ConsoleInput, input: "root\n"},
+		{session: session, action: payloads.ConsoleClose},
+	}
+
+	select {
+	case cmdCh <- cmds[0]:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out sending console command")
+	}
+
+	serial, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Unable to accept console connection: %v", err)
+	}
+	defer func() { _ = serial.Close() }()
+
+	_, err = serial.Write([]byte("login: "))
+	if err != nil {
+		t.Fatalf("Unable to write console output: %v", err)
+	}
+	expectConsoleOutput(t, state.consoleCh, "login: ", false)
+
+	select {
+	case cmdCh <- cmds[1]:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out sending console command")
+	}
+
+	buf := make([]byte, 16)
+	_ = serial.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := serial.Read(buf)
+	if err != nil || string(buf[:n]) != "root\n" {
+		t.Errorf("Expected console input %q, found %q (%v)", "root\n", buf[:n], err)
+	}
+
+	for _, cmd := range cmds[2:] {
+		select {
+		case cmdCh <- cmd:
+		case <-time.After(time.Second):
+			t.Fatal("Timed out sending console command")
+		}
+		expectConsoleOutput(t, state.consoleCh, "", true)
+	}
+
+	if !state.deleteInstance(t, ovsCh, cmdCh) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh, &wg)
+	}
+
+	wg.Wait()
+}
+
 // Check that adding an existing volume fails
 //
 // We start the instance loop, add a volume, add the volume a second time
@@ -864,7 +1563,7 @@ func TestAttachExistingVolumeToInstance(t *testing.T) {
 	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
 
 	select {
-	case cmdCh <- &insAttachVolumeCmd{testutil.VolumeUUID}:
+	case cmdCh <- &insAttachVolumeCmd{volume: volumeConfig{UUID: testutil.VolumeUUID}}:
 	case <-time.After(time.Second):
 		t.Error("Timed out sending attach volume command")
 	}
@@ -881,7 +1580,7 @@ func TestAttachExistingVolumeToInstance(t *testing.T) {
 	select {
 	case <-state.errorCh:
 		t.Error("Initial Volume attach failed")
-	case cmdCh <- &insAttachVolumeCmd{testutil.VolumeUUID}:
+	case cmdCh <- &insAttachVolumeCmd{volume: volumeConfig{UUID: testutil.VolumeUUID}}:
 	case <-time.After(time.Second):
 		t.Error("Timed out sending attach volume command")
 	}
@@ -20,12 +20,14 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"math"
 	"os"
 	"os/signal"
 	"os/user"
 	"path"
+	"path/filepath"
 	"runtime/debug"
 	"strconv"
 	"strings"
@@ -78,6 +80,25 @@ func (f *qemuVirtualisationFlag) Set(val string) error {
 	return nil
 }
 
+type balloonPolicyFlag string
+
+func (f *balloonPolicyFlag) String() string {
+	return string(*f)
+}
+
+func (f *balloonPolicyFlag) Set(val string) error {
+	if val != "none" && val != "idle" && val != "all" {
+		return fmt.Errorf("none, idle or all expected")
+	}
+	*f = balloonPolicyFlag(val)
+
+	return nil
+}
+
+func (f *balloonPolicyFlag) Enabled() bool {
+	return string(*f) != "none"
+}
+
 var netConfig networkConfig
 var serverCertPath string
 var clientCertPath string
@@ -92,6 +113,16 @@ var simulate bool
 var childProcessCreds *syscall.SysProcAttr
 var childProcessKVMCreds *syscall.SysProcAttr
 var maxInstances = int(math.MaxInt32)
+var balloonPolicy balloonPolicyFlag = "none"
+var balloonIdleCPU int
+var balloonStep int
+var diskIOPS int
+var netMbps int
+var disconnectTimeout time.Duration
+var shutdownGracePeriod time.Duration
+var instancesDir string
+var lockDir string
+var drainTimeout time.Duration
 
 func init() {
 	flag.StringVar(&serverCertPath, "cacert", "", "Client certificate")
@@ -102,20 +133,34 @@ func init() {
 	flag.StringVar(&cephID, "ceph_id", "", "ceph client id")
 	flag.BoolVar(&prepare, "osprepare", false, "Install dependencies")
 	flag.StringVar(&roles, "roles", "agent", "Roles for which dependencies are to be installed")
+	flag.Var(&balloonPolicy, "balloon-policy", "Instances from which memory is reclaimed under memory pressure.  Can be 'none', 'idle', 'all'")
+	flag.IntVar(&balloonIdleCPU, "balloon-idle-cpu", 5, "CPU usage percentage below which an instance is considered idle")
+	flag.IntVar(&balloonStep, "balloon-step", 25, "Percentage of an instance's memory reclaimed at each step")
+	flag.IntVar(&diskIOPS, "disk-iops", 0, "Disk I/O operations per second available to instances, 0 disables disk I/O accounting")
+	flag.IntVar(&netMbps, "net-mbps", 0, "Network bandwidth in Mbps available to instances, 0 disables network bandwidth accounting")
+	flag.DurationVar(&shutdownGracePeriod, "shutdown-grace-period", time.Minute, "Time the guest of an instance is given to shut down cleanly when it is stopped, after which it is killed, 0 to wait for it forever")
+	flag.DurationVar(&disconnectTimeout, "disconnect-timeout", 5*time.Minute, "Time without connection to the scheduler after which the launcher stops pinging the systemd watchdog, 0 to ping it regardless")
+	flag.StringVar(&instancesDir, "instances-dir", "", "Directory storing the state of the instances, overrides the cluster configuration (default "+defaultInstancesDir+")")
+	flag.DurationVar(&drainTimeout, "drain-timeout", 0, "Time given on SIGTERM to the instances being launched or migrated to complete, during which new instances are refused, 0 to exit immediately")
+	flag.StringVar(&lockDir, "lock-dir", "/tmp/lock/ciao", "Directory of the lock file preventing multiple launchers from running")
 }
 
 const (
-	lockDir         = "/tmp/lock/ciao"
-	ciaoDir         = "/var/lib/ciao"
-	instancesDir    = ciaoDir + "/instances"
-	dataDir         = ciaoDir + "/data/launcher/"
-	logDir          = ciaoDir + "/logs/launcher"
-	maintenanceFile = dataDir + "/maintenance"
-	networkFile     = dataDir + "/network"
-	instanceState   = "state"
-	lockFile        = "client-agent.lock"
-	statsPeriod     = 6
-	resourcePeriod  = 30
+	ciaoDir             = "/var/lib/ciao"
+	defaultInstancesDir = ciaoDir + "/instances"
+	dataDir             = ciaoDir + "/data/launcher/"
+	logDir              = ciaoDir + "/logs/launcher"
+	maintenanceFile     = dataDir + "/maintenance"
+	networkFile         = dataDir + "/network"
+	instancesDirFile    = dataDir + "/instances_dir"
+	instanceState       = "state"
+	lockFile            = "client-agent.lock"
+	statsPeriod         = 6
+	resourcePeriod      = 30
+
+	// Querying the space used by a volume is costly for the storage
+	// cluster so it is only done every volumeUsagePeriod seconds.
+	volumeUsagePeriod = 300
 )
 
 func installLauncherDeps(roles string, doneCh chan os.Signal) {
@@ -248,6 +293,37 @@ func processInstanceCommand(conn serverConn, cmd *cmdWrapper, ovsCh chan<- inter
 			return
 		}
 		delCmd = insCmd
+	case *insConsoleCmd:
+		target = insCmdChannel(cmd.instance, ovsCh)
+		if target == nil {
+			glog.Errorf("Instance %s does not exist", cmd.instance)
+			sendConsoleOutput(conn, cmd.instance, insCmd.session, nil, true)
+			return
+		}
+	case *insGuestAgentCmd:
+		target = insCmdChannel(cmd.instance, ovsCh)
+		if target == nil {
+			glog.Errorf("Instance %s does not exist", cmd.instance)
+			sendGuestAgentError(conn, cmd.instance, insCmd.request,
+				fmt.Errorf("Instance %s does not exist", cmd.instance))
+			return
+		}
+	case *insPacketCaptureCmd:
+		target = insCmdChannel(cmd.instance, ovsCh)
+		if target == nil {
+			glog.Errorf("Instance %s does not exist", cmd.instance)
+			sendPacketCaptureError(conn, cmd.instance, insCmd.capture,
+				fmt.Errorf("Instance %s does not exist", cmd.instance))
+			return
+		}
+	case *insMigrateCmd:
+		target = insCmdChannel(cmd.instance, ovsCh)
+		if target == nil {
+			glog.Errorf("Instance %s does not exist", cmd.instance)
+			sendMigrationStatus(conn, cmd.instance, payloads.MigrationFailed, "",
+				fmt.Errorf("Instance %s does not exist", cmd.instance))
+			return
+		}
 	default:
 		target = insCmdChannel(cmd.instance, ovsCh)
 	}
@@ -300,6 +376,12 @@ func loadClusterConfig(conn serverConn) error {
 	if cephID == "" {
 		cephID = clusterConfig.Configure.Storage.CephID
 	}
+	if instancesDir == "" {
+		instancesDir = clusterConfig.Configure.Launcher.InstancesDir
+	}
+	if err := initInstancesDir(); err != nil {
+		return err
+	}
 
 	childUser := clusterConfig.Configure.Launcher.ChildUser
 	if childUser != "" {
@@ -366,6 +448,7 @@ func printClusterConfig() {
 	glog.Infof("Disk Limit:           %v", diskLimit)
 	glog.Infof("Memory Limit:         %v", memLimit)
 	glog.Infof("Ceph ID:              %v", cephID)
+	glog.Infof("Instances Directory:  %v", instancesDir)
 	if childProcessCreds != nil {
 		glog.Infof("Credentials:          %d:%d",
 			childProcessCreds.Credential.Uid,
@@ -373,7 +456,7 @@ func printClusterConfig() {
 	}
 }
 
-func connectToServer(doneCh chan struct{}, statusCh chan struct{}) {
+func connectToServer(doneCh chan struct{}, drainCh chan struct{}, statusCh chan struct{}) {
 
 	defer func() {
 		statusCh <- struct{}{}
@@ -390,6 +473,8 @@ func connectToServer(doneCh chan struct{}, statusCh chan struct{}) {
 
 	var ovsCh chan<- interface{}
 
+	go sdMonitor(doneCh, client.conn)
+
 	dialCh := make(chan error)
 
 	go func() {
@@ -431,18 +516,36 @@ func connectToServer(doneCh chan struct{}, statusCh chan struct{}) {
 		defer shutdownNetwork()
 
 		ovsCh = startOverseer(&wg, client)
+
+		if err := sdNotify("READY=1"); err != nil {
+			glog.Warningf("Unable to notify systemd: %v", err)
+		}
 	case <-doneCh:
 		client.conn.Close()
 		<-dialCh
 		return
+	case <-drainCh:
+		client.conn.Close()
+		<-dialCh
+		return
 	}
 
+	var drainedCh chan struct{}
+
 DONE:
 	for {
 		select {
 		case <-doneCh:
 			client.conn.Close()
 			break DONE
+		case <-drainCh:
+			drainCh = nil
+			drainedCh = make(chan struct{})
+			ovsCh <- &ovsDrainCmd{drainedCh}
+		case <-drainedCh:
+			glog.Info("Node drained.  Quitting")
+			client.conn.Close()
+			break DONE
 		case cmd := <-client.cmdCh:
 			/*
 				Double check we're not quitting here.  Otherwise a flood of commands
@@ -513,18 +616,49 @@ func initLogger() error {
 }
 
 func createMandatoryDirs() error {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return fmt.Errorf("Unable to create data directory (%s) %v", dataDir, err)
+	}
+
+	return nil
+}
+
+// initInstancesDir creates the instances directory once it is known, i.e.,
+// after the cluster configuration has been loaded, and records it so that a
+// hard reset, which does not connect to the server, can find the instances.
+func initInstancesDir() error {
+	if instancesDir == "" {
+		instancesDir = defaultInstancesDir
+	}
+	instancesDir = filepath.Clean(instancesDir)
+
 	if err := os.MkdirAll(instancesDir, 0755); err != nil {
 		return fmt.Errorf("Unable to create instances directory (%s) %v",
 			instancesDir, err)
 	}
 
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		return fmt.Errorf("Unable to create data directory (%s) %v", dataDir, err)
+	if err := ioutil.WriteFile(instancesDirFile, []byte(instancesDir), 0600); err != nil {
+		glog.Warningf("Unable to save instances directory: %v", err)
 	}
 
 	return nil
 }
 
+// loadInstancesDir returns the instances directory used by the last launcher
+// run, unless it is overridden on the command line.
+func loadInstancesDir() string {
+	if instancesDir != "" {
+		return instancesDir
+	}
+
+	data, err := ioutil.ReadFile(instancesDirFile)
+	if err != nil || len(data) == 0 {
+		return defaultInstancesDir
+	}
+
+	return string(data)
+}
+
 func setLimits() {
 	var rlim syscall.Rlimit
 	err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim)
@@ -552,23 +686,48 @@ func setLimits() {
 
 func startLauncher() int {
 	doneCh := make(chan struct{})
+	drainCh := make(chan struct{})
 	statusCh := make(chan struct{})
 	signalCh := make(chan os.Signal, 1)
 	timeoutCh := make(chan struct{})
 	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-	go connectToServer(doneCh, statusCh)
+	go connectToServer(doneCh, drainCh, statusCh)
+
+	stopping := false
+	stop := func() {
+		if stopping {
+			return
+		}
+		stopping = true
+		_ = sdNotify("STOPPING=1")
+		close(doneCh)
+		go func() {
+			time.Sleep(time.Second)
+			timeoutCh <- struct{}{}
+		}()
+	}
+
+	var drainTimeoutCh <-chan time.Time
 
 DONE:
 	for {
 		select {
-		case <-signalCh:
+		case sig := <-signalCh:
+			if sig == syscall.SIGTERM && drainTimeout > 0 && drainCh != nil {
+				glog.Infof("Received SIGTERM.  Draining node for at most %v", drainTimeout)
+				_ = sdNotify("STOPPING=1\nSTATUS=Draining\n")
+				close(drainCh)
+				drainCh = nil
+				drainTimeoutCh = time.After(drainTimeout)
+				continue
+			}
 			glog.Info("Received terminating signal.  Waiting for server loop to quit")
-			close(doneCh)
-			go func() {
-				time.Sleep(time.Second)
-				timeoutCh <- struct{}{}
-			}()
+			stop()
+		case <-drainTimeoutCh:
+			glog.Warningf("Node not drained within %v.  Waiting for server loop to quit", drainTimeout)
+			drainTimeoutCh = nil
+			stop()
 		case <-statusCh:
 			glog.Info("Server Loop quit cleanly")
 			break DONE
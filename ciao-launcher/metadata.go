@@ -0,0 +1,53 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/golang/glog"
+)
+
+// metadataUpdater is implemented by virtualizers which can replace the
+// cloud-init user data and meta data of an instance after it was created.
+type metadataUpdater interface {
+	updateMetadata(userData, metaData []byte) error
+}
+
+func (id *instanceData) updateMetadataCommand(cmd *insUpdateMetadataCmd) {
+	if id.shuttingDown || id.migrationCh != nil {
+		glog.Errorf("Unable to update metadata of instance %s: shutting down or migrating",
+			id.instance)
+		return
+	}
+
+	mu, ok := id.vm.(metadataUpdater)
+	if !ok {
+		glog.Errorf("Unable to update metadata of instance %s: not supported", id.instance)
+		return
+	}
+
+	err := mu.updateMetadata(cmd.userData, cmd.metaData)
+	if err != nil {
+		glog.Errorf("Unable to update metadata of instance %s: %v", id.instance, err)
+		return
+	}
+
+	glog.Infof("Metadata of instance %s updated", id.instance)
+
+	if cmd.reboot {
+		id.rebootCommand(&insRebootCmd{})
+	}
+}
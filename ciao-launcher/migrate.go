@@ -0,0 +1,226 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/ssntp"
+	"github.com/golang/glog"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The live migration of an instance involves two instance go routines, one
+// on the source node and one on the target node.
+//
+// The target node is sent a START command naming the source node.  It
+// starts qemu waiting for the state of the instance on a migration port,
+// reports the address it waits on in a MigrationStatus event and then waits
+// for the migration to complete, the instance being reported as migrating
+// in the meantime.  The controller then sends a MIGRATE command to the source
+// node, which sends the state of the instance to the target node and
+// reports the outcome of the migration in a MigrationStatus event.
+//
+// Once the migration has completed, the copy of the instance left behind on
+// the source node is discarded, without any InstanceDeleted event being
+// sent as the instance lives on on the target node.  If the migration
+// fails, the instance keeps running on the source node and it is the copy
+// on the target node that is discarded.
+
+var (
+	// migrationTimeout is the time after which a migration which has
+	// not completed is cancelled.
+	migrationTimeout = 10 * time.Minute
+
+	// migrationPollInterval is the interval at which the progress of a
+	// migration is checked.
+	migrationPollInterval = 500 * time.Millisecond
+)
+
+type insMigrateCmd struct {
+	// The node to which the instance is migrated.
+	target string
+
+	// The host:port address on which the target node waits for the
+	// state of the instance.
+	address string
+}
+
+func sendMigrationStatus(conn serverConn, instance string, state payloads.MigrationState,
+	address string, err error) {
+	event := payloads.EventMigrationStatus{
+		MigrationStatus: payloads.MigrationStatusEvent{
+			InstanceUUID: instance,
+			NodeUUID:     conn.UUID(),
+			State:        state,
+			Address:      address,
+		},
+	}
+	if err != nil {
+		event.MigrationStatus.Error = err.Error()
+	}
+
+	payload, err := yaml.Marshal(&event)
+	if err != nil {
+		glog.Errorf("Unable to Marshall MigrationStatus %v", err)
+		return
+	}
+
+	_, err = conn.SendEvent(ssntp.MigrationStatus, payload)
+	if err != nil {
+		glog.Errorf("Failed to send event command %v", err)
+	}
+}
+
+// reserveMigrationPort reserves the port on which qemu waits for the state
+// of an instance being migrated to the node, if cfg is such an instance.
+func reserveMigrationPort(cfg *vmConfig) *startError {
+	if cfg.migrationSource == "" {
+		return nil
+	}
+
+	cfg.migrationPort = migrationPortGrabber.grabPort()
+	if cfg.migrationPort == 0 {
+		err := fmt.Errorf("No port available to migrate instance %s", cfg.Instance)
+		return &startError{err, payloads.LaunchFailure, cfg.Restart}
+	}
+
+	return nil
+}
+
+func releaseMigrationPort(cfg *vmConfig) {
+	if cfg.migrationPort == 0 {
+		return
+	}
+
+	migrationPortGrabber.releasePort(cfg.migrationPort)
+	cfg.migrationPort = 0
+}
+
+// incomingMigration is called once qemu is waiting for the state of an
+// instance being migrated to the node.  It reports the address on which
+// qemu waits and starts waiting for the migration to complete.
+func (id *instanceData) incomingMigration() {
+	address := net.JoinHostPort(getNodeIPAddress(), strconv.Itoa(id.cfg.migrationPort))
+	glog.Infof("Instance %s waiting for its migration from %s on %s", id.instance,
+		id.cfg.migrationSource, address)
+
+	id.migrationCh = make(chan error, 1)
+	id.monitorCh <- virtualizerMigrateCmd{id.migrationCh, ""}
+	id.ovsCh <- &ovsStateChange{id.instance, ovsMigrating}
+
+	sendMigrationStatus(id.ac.conn, id.instance, payloads.MigrationIncoming, address, nil)
+}
+
+func (id *instanceData) migrateCommand(cmd *insMigrateCmd) {
+	var err error
+
+	if id.cfg.Container || id.cfg.NetworkNode {
+		err = fmt.Errorf("Live migration not supported for instance %s", id.instance)
+	} else if id.shuttingDown || id.monitorCh == nil || id.connectedCh != nil {
+		err = fmt.Errorf("Instance %s is not running", id.instance)
+	} else if id.migrationCh != nil {
+		err = fmt.Errorf("Instance %s is already being migrated", id.instance)
+	} else if id.paused {
+		err = fmt.Errorf("Instance %s is paused", id.instance)
+	}
+
+	if err != nil {
+		glog.Errorf("Unable to migrate instance %s: %v", id.instance, err)
+		sendMigrationStatus(id.ac.conn, id.instance, payloads.MigrationFailed, "", err)
+		return
+	}
+
+	glog.Infof("Migrating instance %s to %s on %s", id.instance, cmd.target, cmd.address)
+
+	id.migrationCh = make(chan error, 1)
+	id.monitorCh <- virtualizerMigrateCmd{id.migrationCh, "tcp:" + cmd.address}
+	id.ovsCh <- &ovsStateChange{id.instance, ovsMigrating}
+}
+
+// migrationDone is called when the migration of an instance, to or from the
+// node, has completed or failed.
+func (id *instanceData) migrationDone(err error) {
+	id.migrationCh = nil
+
+	if id.cfg.migrationPort != 0 {
+		releaseMigrationPort(id.cfg)
+		if err != nil {
+			glog.Errorf("Migration of instance %s to the node failed: %v", id.instance, err)
+			sendMigrationStatus(id.ac.conn, id.instance, payloads.MigrationFailed, "", err)
+			id.discardMigratedInstance()
+			return
+		}
+
+		glog.Infof("Instance %s migrated to the node", id.instance)
+		id.ovsCh <- &ovsStateChange{id.instance, ovsRunning}
+		return
+	}
+
+	if err != nil {
+		glog.Errorf("Migration of instance %s failed: %v", id.instance, err)
+		id.ovsCh <- &ovsStateChange{id.instance, ovsRunning}
+		sendMigrationStatus(id.ac.conn, id.instance, payloads.MigrationFailed, "", err)
+		return
+	}
+
+	glog.Infof("Instance %s migrated", id.instance)
+	id.discardMigratedInstance()
+	sendMigrationStatus(id.ac.conn, id.instance, payloads.MigrationCompleted, "", nil)
+}
+
+// migrationLost is called when an instance exits while it is being
+// migrated.  It returns true if the instance was a copy waiting for its
+// migration to the node, which is then discarded.  Otherwise the instance
+// was running on the node and is handled as any other instance that exits.
+func (id *instanceData) migrationLost() bool {
+	err := fmt.Errorf("Instance %s exited during its migration", id.instance)
+	if id.cfg.migrationPort != 0 {
+		id.migrationDone(err)
+		return true
+	}
+
+	glog.Errorf("Migration of instance %s failed: %v", id.instance, err)
+	id.migrationCh = nil
+	sendMigrationStatus(id.ac.conn, id.instance, payloads.MigrationFailed, "", err)
+	return false
+}
+
+// discardMigratedInstance kills the copy of an instance left behind by its
+// migration and asks for it to be deleted without any event being sent to
+// controller, as the instance lives on on the other node.
+func (id *instanceData) discardMigratedInstance() {
+	select {
+	case id.monitorCh <- virtualizerKillCmd{}:
+		<-id.monitorCloseCh
+	case <-id.monitorCloseCh:
+	}
+	id.vm.lostVM()
+	close(id.monitorCh)
+	id.monitorCh = nil
+	id.monitorCloseCh = nil
+	id.connectedCh = nil
+	id.readyCh = nil
+	id.statsTimer = nil
+
+	killMe(id.instance, true, false, id.doneCh, id.ac, &id.instanceWg)
+	id.shuttingDown = true
+}
@@ -24,6 +24,7 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"os/exec"
 
 	"context"
 
@@ -329,6 +330,34 @@ func createVnic(conn serverConn, vnicCfg *libsnnet.VnicConfig) (string, string,
 	return name, bridge, gatewayIP, fds, nil
 }
 
+// limitVnicBandwidth limits the traffic to and from an instance through its
+// vnic to mbps.  The traffic sent to the instance is shaped by a token bucket
+// filter and the traffic it sends is policed on ingress.  The limits go away
+// with the vnic.
+func limitVnicBandwidth(vnicName string, mbps int) error {
+	rate := fmt.Sprintf("%dmbit", mbps)
+	cmds := [][]string{
+		{"qdisc", "replace", "dev", vnicName, "root", "tbf", "rate", rate,
+			"burst", "256kb", "latency", "50ms"},
+		{"qdisc", "replace", "dev", vnicName, "handle", "ffff:", "ingress"},
+		{"filter", "add", "dev", vnicName, "parent", "ffff:", "protocol", "all",
+			"u32", "match", "u32", "0", "0", "police", "rate", rate,
+			"burst", "256kb", "drop", "flowid", ":1"},
+	}
+
+	for _, args := range cmds {
+		out, err := exec.Command("tc", args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("Unable to limit bandwidth of %s: %v: %s",
+				vnicName, err, out)
+		}
+	}
+
+	glog.Infof("Bandwidth of %s limited to %d Mbps", vnicName, mbps)
+
+	return nil
+}
+
 func destroyVnic(conn serverConn, vnicCfg *libsnnet.VnicConfig) error {
 	if vnicCfg.VnicRole != libsnnet.DataCenter {
 		event, info, err := cnNet.DestroyVnic(vnicCfg)
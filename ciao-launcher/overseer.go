@@ -82,6 +82,13 @@ type ovsStatsUpdateCmd struct {
 	diskUsageMB   int
 	CPUUsage      int
 	volumes       []string
+	volumeUsage   []payloads.VolumeStat
+	bootTimeMS    int
+}
+
+type ovsBalloonUpdate struct {
+	instance    string
+	reclaimedMB int
 }
 
 type ovsMaintenanceCmd struct {
@@ -92,10 +99,21 @@ type ovsRestoreCmd struct {
 	doneCh chan struct{}
 }
 
+// ovsDrainCmd puts the node in drain mode.  drainedCh is closed once no
+// instance is being launched or migrated.
+type ovsDrainCmd struct {
+	drainedCh chan struct{}
+}
+
 type ovsTraceFrame struct {
 	frame *ssntp.Frame
 }
 
+type ovsWatchdogFired struct {
+	instance string
+	action   payloads.WatchdogAction
+}
+
 type ovsStatusCmd struct{}
 type ovsStatsStatusCmd struct{}
 
@@ -130,6 +148,8 @@ const (
 	ovsPending ovsRunningState = iota
 	ovsRunning
 	ovsStopped
+	ovsPaused
+	ovsMigrating
 )
 
 const (
@@ -139,6 +159,11 @@ const (
 	memLWM       = 512
 )
 
+// diskUsageThresholds are the percentages of their requested disk size
+// that instances are allowed to use before an alert is sent to the
+// controller, from the highest to the lowest.
+var diskUsageThresholds = []int{95, 80}
+
 type ovsInstanceState struct {
 	cmdCh          chan<- interface{}
 	running        ovsRunningState
@@ -148,9 +173,21 @@ type ovsInstanceState struct {
 	maxDiskUsageMB int
 	maxVCPUs       int
 	maxMemoryMB    int
+	maxDiskIOPS    int
+	maxNetMbps     int
 	sshIP          string
 	sshPort        int
 	volumes        []string
+	volumeUsage    []payloads.VolumeStat
+	bootTimeMS     int
+	container      bool
+	balloon        bool
+	reclaimedMB    int
+	balloonPending bool
+
+	// diskAlert is the highest disk usage threshold reported for the
+	// instance, 0 if none.
+	diskAlert int
 }
 
 type overseer struct {
@@ -165,24 +202,37 @@ type overseer struct {
 	vcpusAllocated     int
 	diskSpaceAllocated int
 	memoryAllocated    int
+	diskIOPSAllocated  int
+	netMbpsAllocated   int
 	diskSpaceAvailable int
 	memoryAvailable    int
+	memoryReclaimed    int
 	traceFrames        *list.List
 	statsInterval      time.Duration
 	di                 deviceInfo
 	maintenance        bool
+	draining           bool
+	drainedCh          chan struct{}
 }
 
 type cnStats struct {
-	totalMemMB      int
-	availableMemMB  int
-	totalDiskMB     int
-	availableDiskMB int
-	load            int
-	cpusOnline      int
+	totalMemMB        int
+	availableMemMB    int
+	totalDiskMB       int
+	availableDiskMB   int
+	totalDiskIOPS     int
+	availableDiskIOPS int
+	totalNetMbps      int
+	availableNetMbps  int
+	load              int
+	cpusOnline        int
 }
 
 func (ovs *overseer) roomAvailable(cfg *vmConfig) payloads.StartFailureReason {
+	if ovs.draining {
+		return payloads.NodeDraining
+	}
+
 	if ovs.maintenance {
 		return payloads.NodeInMaintenance
 	}
@@ -192,6 +242,16 @@ func (ovs *overseer) roomAvailable(cfg *vmConfig) payloads.StartFailureReason {
 		return payloads.FullComputeNode
 	}
 
+	if diskIOPS > 0 && ovs.diskIOPSAllocated+cfg.DiskIOPS > diskIOPS {
+		glog.Warningf("We're FULL.  Not enough disk IOPS for %d", cfg.DiskIOPS)
+		return payloads.FullComputeNode
+	}
+
+	if netMbps > 0 && ovs.netMbpsAllocated+cfg.NetMbps > netMbps {
+		glog.Warningf("We're FULL.  Not enough network bandwidth for %d Mbps", cfg.NetMbps)
+		return payloads.FullComputeNode
+	}
+
 	diskSpaceAvailable := ovs.diskSpaceAvailable - cfg.Disk
 	memoryAvailable := ovs.memoryAvailable - cfg.Mem
 
@@ -204,6 +264,7 @@ func (ovs *overseer) roomAvailable(cfg *vmConfig) payloads.StartFailureReason {
 	}
 
 	if memoryAvailable < memLWM {
+		ovs.inflateBalloons(memLWM - memoryAvailable)
 		if memLimit == true {
 			return payloads.FullComputeNode
 		}
@@ -215,25 +276,48 @@ func (ovs *overseer) roomAvailable(cfg *vmConfig) payloads.StartFailureReason {
 func (ovs *overseer) updateAvailableResources(cns *cnStats) {
 	diskSpaceConsumed := 0
 	memConsumed := 0
+	memReclaimed := 0
 	for _, target := range ovs.instances {
 		if target.diskUsageMB != -1 {
 			diskSpaceConsumed += target.diskUsageMB
 		}
 
+		maxMemoryMB := target.maxMemoryMB - target.reclaimedMB
 		if target.memoryUsageMB != -1 {
-			if target.memoryUsageMB < target.maxMemoryMB {
+			if target.memoryUsageMB < maxMemoryMB {
 				memConsumed += target.memoryUsageMB
 			} else {
-				memConsumed += target.maxMemoryMB
+				memConsumed += maxMemoryMB
 			}
 		}
+
+		memReclaimed += target.reclaimedMB
 	}
 
 	ovs.diskSpaceAvailable = (cns.availableDiskMB + diskSpaceConsumed) -
 		ovs.diskSpaceAllocated
 
 	ovs.memoryAvailable = (cns.availableMemMB + memConsumed) -
-		ovs.memoryAllocated
+		(ovs.memoryAllocated - memReclaimed)
+	ovs.memoryReclaimed = memReclaimed
+
+	// Disk IOPS and network bandwidth are not measured, the instances
+	// are limited to what they reserved.
+	if diskIOPS > 0 {
+		cns.totalDiskIOPS = diskIOPS
+		cns.availableDiskIOPS = diskIOPS - ovs.diskIOPSAllocated
+		if cns.availableDiskIOPS < 0 {
+			cns.availableDiskIOPS = 0
+		}
+	}
+
+	if netMbps > 0 {
+		cns.totalNetMbps = netMbps
+		cns.availableNetMbps = netMbps - ovs.netMbpsAllocated
+		if cns.availableNetMbps < 0 {
+			cns.availableNetMbps = 0
+		}
+	}
 
 	if glog.V(1) {
 		glog.Infof("Memory Available: %d Disk space Available %d",
@@ -242,6 +326,10 @@ func (ovs *overseer) updateAvailableResources(cns *cnStats) {
 }
 
 func (ovs *overseer) computeStatus() ssntp.Status {
+	if ovs.draining {
+		return ssntp.DRAINING
+	}
+
 	if ovs.maintenance {
 		return ssntp.MAINTENANCE
 	}
@@ -275,11 +363,14 @@ func (ovs *overseer) sendReadyStatusCommand(cns *cnStats) {
 	s.Load = cns.load
 	s.CpusOnline = cns.cpusOnline
 	s.DiskTotalMB, s.DiskAvailableMB = cns.totalDiskMB, cns.availableDiskMB
+	s.DiskIOPSTotal, s.DiskIOPSAvailable = cns.totalDiskIOPS, cns.availableDiskIOPS
+	s.NetMbpsTotal, s.NetMbpsAvailable = cns.totalNetMbps, cns.availableNetMbps
 	s.Networks = make([]payloads.NetworkStat, len(nicInfo))
 	for i, nic := range nicInfo {
 		s.Networks[i] = *nic
 	}
 	s.NodeHostName = hostname
+	s.Instances = len(ovs.instances)
 
 	payload, err := yaml.Marshal(&s)
 	if err != nil {
@@ -301,6 +392,8 @@ func (ovs *overseer) sendStatusCommand(cns *cnStats, status ssntp.Status) {
 		fallthrough
 	case ssntp.MAINTENANCE:
 		fallthrough
+	case ssntp.DRAINING:
+		fallthrough
 	case ssntp.OFFLINE:
 		_, err := ovs.ac.conn.SendStatus(status, nil)
 		if err != nil {
@@ -319,9 +412,12 @@ func (ovs *overseer) sendStats(cns *cnStats, status ssntp.Status) {
 	s.NodeUUID = ovs.ac.conn.UUID()
 	s.Status = status.String()
 	s.MemTotalMB, s.MemAvailableMB = cns.totalMemMB, cns.availableMemMB
+	s.MemReclaimedMB = ovs.memoryReclaimed
 	s.Load = cns.load
 	s.CpusOnline = cns.cpusOnline
 	s.DiskTotalMB, s.DiskAvailableMB = cns.totalDiskMB, cns.availableDiskMB
+	s.DiskIOPSTotal, s.DiskIOPSAvailable = cns.totalDiskIOPS, cns.availableDiskIOPS
+	s.NetMbpsTotal, s.NetMbpsAvailable = cns.totalNetMbps, cns.availableNetMbps
 	s.NodeHostName = hostname // global from network.go
 	s.Networks = make([]payloads.NetworkStat, len(nicInfo))
 	for i, nic := range nicInfo {
@@ -335,15 +431,22 @@ func (ovs *overseer) sendStats(cns *cnStats, status ssntp.Status) {
 			s.Instances[i].State = payloads.Running
 		} else if state.running == ovsStopped {
 			s.Instances[i].State = payloads.Exited
+		} else if state.running == ovsPaused {
+			s.Instances[i].State = payloads.Paused
+		} else if state.running == ovsMigrating {
+			s.Instances[i].State = payloads.Migrating
 		} else {
 			s.Instances[i].State = payloads.Pending
 		}
 		s.Instances[i].MemoryUsageMB = state.memoryUsageMB
 		s.Instances[i].DiskUsageMB = state.diskUsageMB
 		s.Instances[i].CPUUsage = state.CPUUsage
+		s.Instances[i].MemoryReclaimedMB = state.reclaimedMB
 		s.Instances[i].SSHIP = state.sshIP
 		s.Instances[i].SSHPort = state.sshPort
 		s.Instances[i].Volumes = state.volumes
+		s.Instances[i].VolumeUsage = state.volumeUsage
+		s.Instances[i].BootTimeMS = state.bootTimeMS
 		i++
 	}
 
@@ -443,6 +546,8 @@ func (ovs *overseer) processAddCommand(cmd *ovsAddCmd) {
 		ovs.vcpusAllocated += cfg.Cpus
 		ovs.diskSpaceAllocated += cfg.Disk
 		ovs.memoryAllocated += cfg.Mem
+		ovs.diskIOPSAllocated += cfg.DiskIOPS
+		ovs.netMbpsAllocated += cfg.NetMbps
 		targetCh = startInstance(cmd.instance, cfg, ovs.childWg, ovs.childDoneCh,
 			ovs.ac, ovs.ovsInstanceCh)
 		ovs.instances[cmd.instance] = &ovsInstanceState{
@@ -454,8 +559,12 @@ func (ovs *overseer) processAddCommand(cmd *ovsAddCmd) {
 			maxDiskUsageMB: cfg.Disk,
 			maxVCPUs:       cfg.Cpus,
 			maxMemoryMB:    cfg.Mem,
+			maxDiskIOPS:    cfg.DiskIOPS,
+			maxNetMbps:     cfg.NetMbps,
 			sshIP:          cfg.ConcIP,
 			sshPort:        cfg.SSHPort,
+			container:      cfg.Container,
+			balloon:        cfg.Balloon,
 		}
 	}
 	cmd.targetCh <- ovsAddResult{targetCh, errCode}
@@ -484,8 +593,20 @@ func (ovs *overseer) processRemoveCommand(cmd *ovsRemoveCmd) {
 		ovs.memoryAllocated = 0
 	}
 
+	ovs.diskIOPSAllocated -= target.maxDiskIOPS
+	if ovs.diskIOPSAllocated < 0 {
+		ovs.diskIOPSAllocated = 0
+	}
+
+	ovs.netMbpsAllocated -= target.maxNetMbps
+	if ovs.netMbpsAllocated < 0 {
+		ovs.netMbpsAllocated = 0
+	}
+
 	delete(ovs.instances, cmd.instance)
 	cmd.errCh <- nil
+
+	ovs.checkDrained()
 }
 
 func (ovs *overseer) processStatusCommand(cmd *ovsStatusCmd) {
@@ -515,7 +636,12 @@ func (ovs *overseer) processStateChangeCommand(cmd *ovsStateChange) {
 	target := ovs.instances[cmd.instance]
 	if target != nil {
 		target.running = cmd.state
+		if cmd.state == ovsStopped {
+			target.reclaimedMB = 0
+		}
 	}
+
+	ovs.checkDrained()
 }
 
 func (ovs *overseer) processStatusUpdateCommand(cmd *ovsStatsUpdateCmd) {
@@ -530,6 +656,64 @@ func (ovs *overseer) processStatusUpdateCommand(cmd *ovsStatsUpdateCmd) {
 		target.diskUsageMB = cmd.diskUsageMB
 		target.CPUUsage = cmd.CPUUsage
 		target.volumes = cmd.volumes
+		target.volumeUsage = cmd.volumeUsage
+		target.bootTimeMS = cmd.bootTimeMS
+		ovs.checkDiskUsage(cmd.instance, target)
+	}
+}
+
+// checkDiskUsage sends a DiskUsageAlert event when the disk usage of an
+// instance crosses one of the diskUsageThresholds.  Each threshold is only
+// reported once, unless the disk usage of the instance drops below it.
+func (ovs *overseer) checkDiskUsage(instance string, target *ovsInstanceState) {
+	if target.maxDiskUsageMB <= 0 || target.diskUsageMB < 0 {
+		return
+	}
+
+	threshold := 0
+	for _, t := range diskUsageThresholds {
+		if target.diskUsageMB*100 >= target.maxDiskUsageMB*t {
+			threshold = t
+			break
+		}
+	}
+
+	reported := target.diskAlert
+	target.diskAlert = threshold
+	if threshold <= reported {
+		return
+	}
+
+	glog.Warningf("Instance %s is using %d%% of its disk: %d/%d MB", instance,
+		threshold, target.diskUsageMB, target.maxDiskUsageMB)
+
+	alert := payloads.EventDiskUsageAlert{
+		DiskUsageAlert: payloads.DiskUsageAlertEvent{
+			InstanceUUID: instance,
+			NodeUUID:     ovs.ac.conn.UUID(),
+			UsageMB:      target.diskUsageMB,
+			LimitMB:      target.maxDiskUsageMB,
+			Threshold:    threshold,
+		},
+	}
+
+	payload, err := yaml.Marshal(&alert)
+	if err != nil {
+		glog.Errorf("Unable to Marshall DiskUsageAlert %v", err)
+		return
+	}
+
+	_, err = ovs.ac.conn.SendEvent(ssntp.DiskUsageAlert, payload)
+	if err != nil {
+		glog.Errorf("Failed to send DiskUsageAlert event %v", err)
+	}
+}
+
+func (ovs *overseer) processBalloonUpdateCommand(cmd *ovsBalloonUpdate) {
+	target := ovs.instances[cmd.instance]
+	if target != nil {
+		target.reclaimedMB = cmd.reclaimedMB
+		target.balloonPending = false
 	}
 }
 
@@ -538,6 +722,29 @@ func (ovs *overseer) processTraceFrameCommand(cmd *ovsTraceFrame) {
 	ovs.traceFrames.PushBack(cmd.frame)
 }
 
+// processWatchdogFiredCommand reports to the controller that the watchdog of
+// an instance fired.  QEMU has already applied the action of the workload.
+func (ovs *overseer) processWatchdogFiredCommand(cmd *ovsWatchdogFired) {
+	event := payloads.EventWatchdogFired{
+		WatchdogFired: payloads.WatchdogFiredEvent{
+			InstanceUUID: cmd.instance,
+			NodeUUID:     ovs.ac.conn.UUID(),
+			Action:       cmd.action,
+		},
+	}
+
+	payload, err := yaml.Marshal(&event)
+	if err != nil {
+		glog.Errorf("Unable to Marshall WatchdogFired %v", err)
+		return
+	}
+
+	_, err = ovs.ac.conn.SendEvent(ssntp.WatchdogFired, payload)
+	if err != nil {
+		glog.Errorf("Failed to send WatchdogFired event %v", err)
+	}
+}
+
 func (ovs *overseer) processMaintenanceCommand(cmd *ovsMaintenanceCmd) {
 	defer close(cmd.doneCh)
 	if ovs.maintenance {
@@ -570,6 +777,36 @@ func (ovs *overseer) processRestoreCommand(cmd *ovsRestoreCmd) {
 	}
 }
 
+func (ovs *overseer) processDrainCommand(cmd *ovsDrainCmd) {
+	if ovs.draining {
+		glog.Warning("Node is already draining")
+		return
+	}
+	glog.Info("Node draining")
+	ovs.draining = true
+	ovs.drainedCh = cmd.drainedCh
+	ovs.processStatsStatusCommand(&ovsStatsStatusCmd{})
+	ovs.checkDrained()
+}
+
+// checkDrained closes drainedCh once a draining node has no instance being
+// launched or migrated.
+func (ovs *overseer) checkDrained() {
+	if ovs.drainedCh == nil {
+		return
+	}
+
+	for _, target := range ovs.instances {
+		if target.running == ovsPending || target.running == ovsMigrating {
+			return
+		}
+	}
+
+	glog.Info("Node drained")
+	close(ovs.drainedCh)
+	ovs.drainedCh = nil
+}
+
 func (ovs *overseer) processCommand(cmd interface{}) {
 	switch cmd := cmd.(type) {
 	case *ovsGetCmd:
@@ -588,12 +825,18 @@ func (ovs *overseer) processCommand(cmd interface{}) {
 		ovs.processStateChangeCommand(cmd)
 	case *ovsStatsUpdateCmd:
 		ovs.processStatusUpdateCommand(cmd)
+	case *ovsBalloonUpdate:
+		ovs.processBalloonUpdateCommand(cmd)
 	case *ovsTraceFrame:
 		ovs.processTraceFrameCommand(cmd)
+	case *ovsWatchdogFired:
+		ovs.processWatchdogFiredCommand(cmd)
 	case *ovsMaintenanceCmd:
 		ovs.processMaintenanceCommand(cmd)
 	case *ovsRestoreCmd:
 		ovs.processRestoreCommand(cmd)
+	case *ovsDrainCmd:
+		ovs.processDrainCommand(cmd)
 	default:
 		panic("Unknown Overseer Command")
 	}
@@ -620,6 +863,8 @@ DONE:
 
 			cns := getStats(ovs.instancesDir)
 			ovs.updateAvailableResources(cns)
+			ovs.deflateBalloons()
+			ovs.inflateBalloons(memHWM - ovs.memoryAvailable)
 			status := ovs.computeStatus()
 			ovs.sendStatusCommand(cns, status)
 			ovs.sendStats(cns, status)
@@ -677,6 +922,8 @@ func startOverseerFull(instancesDir string, wg *sync.WaitGroup, ac *agentClient,
 	vcpusAllocated := 0
 	diskSpaceAllocated := 0
 	memoryAllocated := 0
+	diskIOPSAllocated := 0
+	netMbpsAllocated := 0
 
 	_ = filepath.Walk(instancesDir, func(path string, info os.FileInfo, err error) error {
 		if path == instancesDir {
@@ -701,6 +948,8 @@ func startOverseerFull(instancesDir string, wg *sync.WaitGroup, ac *agentClient,
 		vcpusAllocated += cfg.Cpus
 		diskSpaceAllocated += cfg.Disk
 		memoryAllocated += cfg.Mem
+		diskIOPSAllocated += cfg.DiskIOPS
+		netMbpsAllocated += cfg.NetMbps
 
 		target := startInstance(instance, cfg, childWg, childDoneCh, ac, ovsInstanceCh)
 		instances[instance] = &ovsInstanceState{
@@ -712,8 +961,12 @@ func startOverseerFull(instancesDir string, wg *sync.WaitGroup, ac *agentClient,
 			maxDiskUsageMB: cfg.Disk,
 			maxVCPUs:       cfg.Cpus,
 			maxMemoryMB:    cfg.Mem,
+			maxDiskIOPS:    cfg.DiskIOPS,
+			maxNetMbps:     cfg.NetMbps,
 			sshIP:          cfg.ConcIP,
 			sshPort:        cfg.SSHPort,
+			container:      cfg.Container,
+			balloon:        cfg.Balloon,
 		}
 		toMonitor = append(toMonitor, target)
 
@@ -739,6 +992,8 @@ func startOverseerFull(instancesDir string, wg *sync.WaitGroup, ac *agentClient,
 		vcpusAllocated:     vcpusAllocated,
 		diskSpaceAllocated: diskSpaceAllocated,
 		memoryAllocated:    memoryAllocated,
+		diskIOPSAllocated:  diskIOPSAllocated,
+		netMbpsAllocated:   netMbpsAllocated,
 		traceFrames:        list.New(),
 		statsInterval:      statsInterval,
 		di:                 di,
@@ -18,6 +18,7 @@ package main
 
 import (
 	"encoding/gob"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
@@ -55,17 +56,41 @@ func (fakeDeviceInfo) GetMemoryInfo() (total, available int) {
 }
 
 type overseerTestState struct {
-	t        *testing.T
-	ac       *agentClient
-	statusCh chan *fakeStatus
-	statsCh  chan *payloads.Stat
+	t         *testing.T
+	ac        *agentClient
+	statusCh  chan *fakeStatus
+	statsCh   chan *payloads.Stat
+	alerts    []payloads.DiskUsageAlertEvent
+	watchdogs []payloads.WatchdogFiredEvent
 }
 
 func (v *overseerTestState) SendError(error ssntp.Error, payload []byte) (int, error) {
 	return 0, nil
 }
 
+func (v *overseerTestState) SendEventStream(event ssntp.Event, payload io.Reader) (int, error) {
+	return 0, nil
+}
+
 func (v *overseerTestState) SendEvent(event ssntp.Event, payload []byte) (int, error) {
+	if event == ssntp.DiskUsageAlert {
+		alert := &payloads.EventDiskUsageAlert{}
+		err := yaml.Unmarshal(payload, alert)
+		if err != nil {
+			v.t.Errorf("Failed to unmarshall DiskUsageAlert %v", err)
+		}
+		v.alerts = append(v.alerts, alert.DiskUsageAlert)
+	}
+
+	if event == ssntp.WatchdogFired {
+		fired := &payloads.EventWatchdogFired{}
+		err := yaml.Unmarshal(payload, fired)
+		if err != nil {
+			v.t.Errorf("Failed to unmarshall WatchdogFired %v", err)
+		}
+		v.watchdogs = append(v.watchdogs, fired.WatchdogFired)
+	}
+
 	return 0, nil
 }
 
@@ -88,6 +113,8 @@ func (v *overseerTestState) SendStatus(status ssntp.Status, payload []byte) (int
 	case ssntp.FULL:
 		fallthrough
 	case ssntp.MAINTENANCE:
+		fallthrough
+	case ssntp.DRAINING:
 		v.statusCh <- &fakeStatus{status, nil}
 	}
 
@@ -573,6 +600,96 @@ func TestMaintenanceStatus(t *testing.T) {
 	wg.Wait()
 }
 
+// Check the overseer drains the node.
+//
+// Start the overseer and add an instance, which is pending.  Send an
+// ovsDrainCmd, try to add another instance and then report the first
+// instance as running.  Then shutdown the overseer.
+//
+// A ssntp.DRAINING status command should be received as soon as the node
+// starts draining, the second instance should be refused and the node
+// should only be drained once the first instance is running.  The overseer
+// should shut down cleanly.
+func TestDrain(t *testing.T) {
+	diskLimit = false
+	memLimit = false
+
+	instancesDir, err := ioutil.TempDir("", "overseer-tests")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory")
+	}
+	defer func() { _ = os.RemoveAll(instancesDir) }()
+
+	var wg sync.WaitGroup
+	state := &overseerTestState{
+		t:        t,
+		statusCh: make(chan *fakeStatus),
+	}
+	state.ac = &agentClient{conn: state, cmdCh: make(chan *cmdWrapper)}
+
+	ovsCh := startOverseerFull(instancesDir, &wg, state.ac, time.Second*1000,
+		fakeDeviceInfo{})
+
+	_ = addInstance(t, ovsCh, state, false)
+
+	drainedCh := make(chan struct{})
+	select {
+	case ovsCh <- &ovsDrainCmd{drainedCh}:
+	case <-time.After(time.Second):
+		t.Fatal("Unable to send ovsDrainCmd")
+	}
+
+	select {
+	case status := <-state.statusCh:
+		if status.status != ssntp.DRAINING {
+			t.Errorf("Expected a DRAINING status message, got %s", status.status)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for status update from overseer")
+	}
+
+	addCh := make(chan ovsAddResult)
+	select {
+	case ovsCh <- &ovsAddCmd{
+		instance: "test-instance-2",
+		cfg:      &vmConfig{Instance: "test-instance-2"},
+		targetCh: addCh,
+	}:
+	case <-time.After(time.Second):
+		t.Fatal("Unable to add instance")
+	}
+
+	select {
+	case ar := <-addCh:
+		if ar.errorCode != payloads.NodeDraining {
+			t.Errorf("Expected %s, got %s", payloads.NodeDraining, ar.errorCode)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for AddResult")
+	}
+
+	select {
+	case <-drainedCh:
+		t.Fatal("Node drained with an instance pending")
+	default:
+	}
+
+	select {
+	case ovsCh <- &ovsStateChange{"test-instance", ovsRunning}:
+	case <-time.After(time.Second):
+		t.Fatal("Unable to send ovsStateChange")
+	}
+
+	select {
+	case <-drainedCh:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for node to drain")
+	}
+
+	shutdownOverseer(ovsCh, state)
+	wg.Wait()
+}
+
 // Check we can add and delete an instance
 //
 // Start the overseer, send and ovsAddCmd, check the instance is reflected
@@ -814,3 +931,133 @@ func TestStateChange(t *testing.T) {
 	shutdownOverseer(ovsCh, state)
 	wg.Wait()
 }
+
+// Checks that disk IOPS and network bandwidth are accounted for
+//
+// Create an overseer which has reserved some of the disk IOPS and network
+// bandwidth of the node, check whether instances with different I/O
+// requirements fit and compute the available resources.
+//
+// Instances fit only if the node has enough IOPS and bandwidth left and
+// the available resources account for the reservations.
+func TestRoomAvailableIO(t *testing.T) {
+	defer func(iops, mbps int) {
+		diskIOPS, netMbps = iops, mbps
+	}(diskIOPS, netMbps)
+	diskIOPS, netMbps = 500, 100
+
+	ovs := &overseer{
+		instances:          make(map[string]*ovsInstanceState),
+		diskSpaceAvailable: 100000,
+		memoryAvailable:    100000,
+		diskIOPSAllocated:  400,
+		netMbpsAllocated:   50,
+	}
+
+	tests := []struct {
+		cfg    vmConfig
+		reason payloads.StartFailureReason
+	}{
+		{vmConfig{DiskIOPS: 100, NetMbps: 50}, ""},
+		{vmConfig{DiskIOPS: 101}, payloads.FullComputeNode},
+		{vmConfig{NetMbps: 51}, payloads.FullComputeNode},
+	}
+
+	for _, test := range tests {
+		reason := ovs.roomAvailable(&test.cfg)
+		if reason != test.reason {
+			t.Errorf("Expected %q for %+v, got %q", test.reason, test.cfg, reason)
+		}
+	}
+
+	var cns cnStats
+	ovs.updateAvailableResources(&cns)
+	if cns.totalDiskIOPS != 500 || cns.availableDiskIOPS != 100 ||
+		cns.totalNetMbps != 100 || cns.availableNetMbps != 50 {
+		t.Errorf("Unexpected available resources %+v", cns)
+	}
+}
+
+// Checks that disk usage alerts are sent when thresholds are crossed
+//
+// Create an overseer with an instance that requested 1000MB of disk and
+// send it a series of stats updates with increasing and decreasing disk
+// usage.
+//
+// An alert should be sent each time the disk usage crosses a threshold
+// upwards, but not when it stays above it or goes back below it.
+func TestDiskUsageAlert(t *testing.T) {
+	state := &overseerTestState{t: t}
+	state.ac = &agentClient{conn: state}
+
+	ovs := &overseer{
+		instances: map[string]*ovsInstanceState{
+			"test-instance": {
+				diskUsageMB:    -1,
+				maxDiskUsageMB: 1000,
+			},
+		},
+		ac: state.ac,
+	}
+
+	tests := []struct {
+		diskUsageMB int
+		threshold   int
+	}{
+		{500, 0},
+		{800, 80},
+		{850, 0},
+		{950, 95},
+		{990, 0},
+		{700, 0},
+		{820, 80},
+	}
+
+	for _, test := range tests {
+		state.alerts = nil
+		ovs.processStatusUpdateCommand(&ovsStatsUpdateCmd{
+			instance:    "test-instance",
+			diskUsageMB: test.diskUsageMB,
+		})
+
+		if test.threshold == 0 {
+			if len(state.alerts) != 0 {
+				t.Errorf("Unexpected alert for %dMB: %+v", test.diskUsageMB, state.alerts)
+			}
+			continue
+		}
+
+		if len(state.alerts) != 1 {
+			t.Fatalf("Expected one alert for %dMB, got %d", test.diskUsageMB, len(state.alerts))
+		}
+
+		alert := state.alerts[0]
+		if alert.InstanceUUID != "test-instance" || alert.NodeUUID != state.UUID() ||
+			alert.UsageMB != test.diskUsageMB || alert.LimitMB != 1000 ||
+			alert.Threshold != test.threshold {
+			t.Errorf("Unexpected alert for %dMB: %+v", test.diskUsageMB, alert)
+		}
+	}
+}
+
+func TestWatchdogFired(t *testing.T) {
+	state := &overseerTestState{t: t}
+	state.ac = &agentClient{conn: state}
+
+	ovs := &overseer{
+		instances: map[string]*ovsInstanceState{},
+		ac:        state.ac,
+	}
+
+	ovs.processCommand(&ovsWatchdogFired{"test-instance", payloads.WatchdogPowerOff})
+
+	if len(state.watchdogs) != 1 {
+		t.Fatalf("Expected one watchdog event, got %d", len(state.watchdogs))
+	}
+
+	fired := state.watchdogs[0]
+	if fired.InstanceUUID != "test-instance" || fired.NodeUUID != state.UUID() ||
+		fired.Action != payloads.WatchdogPowerOff {
+		t.Errorf("Unexpected watchdog event: %+v", fired)
+	}
+}
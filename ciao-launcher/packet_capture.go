@@ -0,0 +1,260 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ciao-project/ciao/networking/libsnnet"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/ssntp"
+	"github.com/golang/glog"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	pcapHeaderSize       = 24
+	pcapRecordHeaderSize = 16
+)
+
+type insPacketCaptureCmd struct {
+	capture  string
+	maxBytes int
+	duration time.Duration
+	filter   string
+}
+
+// tcpdumpCommand returns the command capturing the packets matching filter
+// on iface.  The packets are written as a pcap file to the standard output
+// of the command, which is flushed after each packet.
+var tcpdumpCommand = func(iface, filter string) *exec.Cmd {
+	args := []string{"-i", iface, "-n", "-U", "-w", "-"}
+	if filter != "" {
+		args = append(args, "--", filter)
+	}
+	return exec.Command("tcpdump", args...)
+}
+
+// pcapLength returns the length of the longest prefix of data made of a
+// pcap file header followed by complete packet records, or 0 if data does
+// not start with a pcap file header.
+func pcapLength(data []byte) int {
+	if len(data) < pcapHeaderSize {
+		return 0
+	}
+
+	var order binary.ByteOrder
+	switch binary.LittleEndian.Uint32(data) {
+	case 0xa1b2c3d4, 0xa1b23c4d:
+		order = binary.LittleEndian
+	case 0xd4c3b2a1, 0x4d3cb2a1:
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	n := pcapHeaderSize
+	for n+pcapRecordHeaderSize <= len(data) {
+		length := int(order.Uint32(data[n+8:]))
+		if length > len(data)-n-pcapRecordHeaderSize {
+			break
+		}
+		n += pcapRecordHeaderSize + length
+	}
+
+	return n
+}
+
+// runPacketCapture captures packets on iface until the capture reaches
+// cmd.maxBytes, until cmd.duration has elapsed or until stopCh is closed.
+// The pcap file returned only contains complete packets and truncated is
+// set if packets were dropped to keep it under cmd.maxBytes.
+func runPacketCapture(iface string, cmd *insPacketCaptureCmd,
+	stopCh <-chan struct{}) (pcap []byte, truncated bool, err error) {
+	var stderr bytes.Buffer
+
+	c := tcpdumpCommand(iface, cmd.filter)
+	c.Stderr = &stderr
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = c.Start()
+	if err != nil {
+		return nil, false, err
+	}
+
+	// tcpdump writes the packets it has captured and exits cleanly when
+	// terminated.
+	var stopLock sync.Mutex
+	stopped := false
+	stop := func() {
+		stopLock.Lock()
+		defer stopLock.Unlock()
+		if !stopped {
+			stopped = true
+			_ = c.Process.Signal(syscall.SIGTERM)
+		}
+	}
+
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	go func() {
+		select {
+		case <-time.After(cmd.duration):
+			stop()
+		case <-stopCh:
+			stop()
+		case <-doneCh:
+		}
+	}()
+
+	data, err := ioutil.ReadAll(io.LimitReader(stdout, int64(cmd.maxBytes)+1))
+	if len(data) > cmd.maxBytes {
+		truncated = true
+		stop()
+		_, _ = io.Copy(ioutil.Discard, stdout)
+	}
+
+	waitErr := c.Wait()
+	if err != nil {
+		return nil, false, err
+	}
+
+	stopLock.Lock()
+	wasStopped := stopped
+	stopLock.Unlock()
+
+	if waitErr != nil && !wasStopped {
+		return nil, false, fmt.Errorf("tcpdump failed: %v: %s", waitErr,
+			strings.TrimSpace(stderr.String()))
+	}
+
+	if len(data) > cmd.maxBytes {
+		data = data[:cmd.maxBytes]
+	}
+
+	n := pcapLength(data)
+	if n == 0 {
+		return nil, false, fmt.Errorf("No packets captured on %s", iface)
+	}
+
+	return data[:n], truncated || n < len(data), nil
+}
+
+func sendPacketCaptureResult(conn serverConn, result *payloads.PacketCaptureResultEvent) {
+	event := payloads.EventPacketCaptureResult{
+		PacketCaptureResult: *result,
+	}
+
+	payload, err := yaml.Marshal(&event)
+	if err != nil {
+		glog.Errorf("Unable to Marshall PacketCaptureResult %v", err)
+		return
+	}
+
+	// Captures are streamed as they usually do not fit in a frame.
+	_, err = conn.SendEventStream(ssntp.PacketCaptureResult, bytes.NewReader(payload))
+	if err == ssntp.ErrFeatureNotSupported {
+		_, err = conn.SendEvent(ssntp.PacketCaptureResult, payload)
+	}
+	if err != nil {
+		glog.Errorf("Failed to send event command %v", err)
+	}
+}
+
+func sendPacketCaptureError(conn serverConn, instance, capture string, err error) {
+	sendPacketCaptureResult(conn, &payloads.PacketCaptureResultEvent{
+		InstanceUUID: instance,
+		CaptureUUID:  capture,
+		Error:        err.Error(),
+	})
+}
+
+// packetCaptureWorker captures the traffic of an instance on the host side
+// link of its vnic and sends the captured packets.  Only one capture runs
+// at a time per instance, lock being held for its duration.
+func packetCaptureWorker(conn serverConn, instance string, vnicCfg *libsnnet.VnicConfig,
+	cmd *insPacketCaptureCmd, lock *sync.Mutex, stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer lock.Unlock()
+
+	iface, err := cnNet.VnicLinkName(vnicCfg)
+	if err != nil {
+		glog.Errorf("Unable to find vnic of instance %s: %v", instance, err)
+		sendPacketCaptureError(conn, instance, cmd.capture, err)
+		return
+	}
+
+	glog.Infof("Capturing packets of instance %s on %s for %v", instance, iface, cmd.duration)
+
+	pcap, truncated, err := runPacketCapture(iface, cmd, stopCh)
+	if err != nil {
+		glog.Errorf("Packet capture on instance %s failed: %v", instance, err)
+		sendPacketCaptureError(conn, instance, cmd.capture, err)
+		return
+	}
+
+	glog.Infof("Packet capture on instance %s completed: %d bytes", instance, len(pcap))
+	sendPacketCaptureResult(conn, &payloads.PacketCaptureResultEvent{
+		InstanceUUID: instance,
+		CaptureUUID:  cmd.capture,
+		Truncated:    truncated,
+		Pcap:         base64.StdEncoding.EncodeToString(pcap),
+	})
+}
+
+func (id *instanceData) packetCaptureCommand(cmd *insPacketCaptureCmd) {
+	if id.cfg.NetworkNode || cnNet == nil {
+		sendPacketCaptureError(id.ac.conn, id.instance, cmd.capture,
+			fmt.Errorf("Packet captures not available for instance %s", id.instance))
+		return
+	}
+
+	if id.shuttingDown || id.monitorCh == nil {
+		sendPacketCaptureError(id.ac.conn, id.instance, cmd.capture,
+			fmt.Errorf("Instance %s is not running", id.instance))
+		return
+	}
+
+	vnicCfg, err := createVnicCfg(id.cfg)
+	if err != nil {
+		sendPacketCaptureError(id.ac.conn, id.instance, cmd.capture, err)
+		return
+	}
+
+	if !id.captureLock.TryLock() {
+		sendPacketCaptureError(id.ac.conn, id.instance, cmd.capture,
+			fmt.Errorf("A packet capture is already running on instance %s", id.instance))
+		return
+	}
+
+	id.instanceWg.Add(1)
+	go packetCaptureWorker(id.ac.conn, id.instance, vnicCfg, cmd, &id.captureLock,
+		id.captureStopCh, &id.instanceWg)
+}
@@ -0,0 +1,145 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+	"time"
+)
+
+// testPcap returns a little endian pcap file containing a packet of each
+// of the sizes.
+func testPcap(sizes ...int) []byte {
+	var b bytes.Buffer
+
+	_ = binary.Write(&b, binary.LittleEndian, []uint32{0xa1b2c3d4, 0x00040002, 0, 0, 65535, 1})
+	for _, size := range sizes {
+		_ = binary.Write(&b, binary.LittleEndian, []uint32{0, 0, uint32(size), uint32(size)})
+		b.Write(make([]byte, size))
+	}
+
+	return b.Bytes()
+}
+
+func TestPcapLength(t *testing.T) {
+	pcap := testPcap(60, 98)
+
+	tests := []struct {
+		data   []byte
+		length int
+	}{
+		{pcap, len(pcap)},
+		{pcap[:len(pcap)-1], pcapHeaderSize + pcapRecordHeaderSize + 60},
+		{pcap[:pcapHeaderSize+10], pcapHeaderSize},
+		{pcap[:pcapHeaderSize-1], 0},
+		{make([]byte, 64), 0},
+	}
+
+	for i, test := range tests {
+		if length := pcapLength(test.data); length != test.length {
+			t.Errorf("Test %d: expected length %d, got %d", i, test.length, length)
+		}
+	}
+}
+
+// fakeTcpdump replaces tcpdump by a shell script writing the pcap file and
+// then running for the given duration.
+func fakeTcpdump(t *testing.T, pcap []byte, duration string) func() {
+	dir, err := ioutil.TempDir("", "launcher-capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := path.Join(dir, "capture.pcap")
+	if err := ioutil.WriteFile(file, pcap, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := tcpdumpCommand
+	tcpdumpCommand = func(iface, filter string) *exec.Cmd {
+		return exec.Command("sh", "-c", "cat "+file+" && exec sleep "+duration)
+	}
+
+	return func() {
+		tcpdumpCommand = saved
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestRunPacketCapture(t *testing.T) {
+	pcap := testPcap(60, 98, 1500)
+	cleanup := fakeTcpdump(t, pcap, "30")
+	defer cleanup()
+
+	cmd := &insPacketCaptureCmd{
+		maxBytes: 1 << 20,
+		duration: 100 * time.Millisecond,
+	}
+	data, truncated, err := runPacketCapture("tap0", cmd, nil)
+	if err != nil {
+		t.Fatalf("Packet capture failed: %v", err)
+	}
+	if truncated || !bytes.Equal(data, pcap) {
+		t.Errorf("Unexpected capture of %d bytes, truncated %v", len(data), truncated)
+	}
+
+	cmd.maxBytes = len(pcap) - 1
+	cmd.duration = time.Minute
+	start := time.Now()
+	data, truncated, err = runPacketCapture("tap0", cmd, nil)
+	if err != nil {
+		t.Fatalf("Packet capture failed: %v", err)
+	}
+	if !truncated || !bytes.Equal(data, testPcap(60, 98)) {
+		t.Errorf("Unexpected capture of %d bytes, truncated %v", len(data), truncated)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("Packet capture not stopped when reaching its maximum size")
+	}
+
+	stopCh := make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() { close(stopCh) })
+	cmd.maxBytes = 1 << 20
+	start = time.Now()
+	_, _, err = runPacketCapture("tap0", cmd, stopCh)
+	if err != nil {
+		t.Errorf("Packet capture failed when stopped: %v", err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("Packet capture not stopped with its instance")
+	}
+}
+
+func TestRunPacketCaptureFailure(t *testing.T) {
+	cleanup := fakeTcpdump(t, nil, "0; exit 1")
+	defer cleanup()
+
+	cmd := &insPacketCaptureCmd{
+		maxBytes: 1 << 20,
+		duration: time.Minute,
+	}
+	_, _, err := runPacketCapture("tap0", cmd, nil)
+	if err == nil {
+		t.Errorf("Packet capture should fail when tcpdump fails")
+	}
+}
@@ -19,10 +19,12 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"net"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/ciao-project/ciao/networking/libsnnet"
 	"github.com/ciao-project/ciao/payloads"
@@ -44,11 +46,13 @@ type extractedDoc struct {
 var indentedRegexp *regexp.Regexp
 var startRegexp *regexp.Regexp
 var uuidRegexp *regexp.Regexp
+var poolRegexp *regexp.Regexp
 
 func init() {
 	indentedRegexp = regexp.MustCompile("\\s+.*")
 	startRegexp = regexp.MustCompile("^start\\s*:\\s*$")
 	uuidRegexp = regexp.MustCompile("^[0-9a-fA-F]+(-[0-9a-fA-F]+)*$")
+	poolRegexp = regexp.MustCompile("^[a-zA-Z0-9_.-]*$")
 }
 
 func printCloudinit(data *payloads.Start) {
@@ -68,12 +72,14 @@ func printCloudinit(data *payloads.Start) {
 	glog.Infof("ConcUUID:             %v", net.ConcentratorUUID)
 	glog.Infof("VnicUUID:             %v", net.VnicUUID)
 	glog.Infof("Restart:              %t", start.Restart)
+	glog.Infof("Migration source:     %v", start.MigrationSource)
 	glog.Infof("Requirements:         %+v", start.Requirements)
+	glog.Infof("Annotations:          %v", start.Annotations)
 
 	for _, storage := range start.Storage {
 		if storage.ID != "" {
 			glog.Info("Volumes:")
-			glog.Infof("  %s Bootable=%t", storage.ID, storage.Bootable)
+			glog.Infof("  %s Bootable=%t CDROM=%t", storage.ID, storage.Bootable, storage.CDROM)
 		}
 	}
 }
@@ -141,18 +147,61 @@ func parseStartPayload(data []byte) (*vmConfig, *payloadError) {
 
 	cpus := start.Requirements.VCPUs
 	mem := start.Requirements.MemMB
+	disk := start.Requirements.DiskMB
+	iops := start.Requirements.DiskIOPS
+	mbps := start.Requirements.NetMbps
 	networkNode := start.Requirements.NetworkNode
 	privileged := start.Requirements.Privileged
 
+	console := start.Requirements.Console
+	if console && !container {
+		err = fmt.Errorf("Console requested for a VM")
+		return nil, &payloadError{err, payloads.InvalidData}
+	}
+
+	watchdog := start.Requirements.Watchdog
+	if watchdog != "" && (container || !watchdog.Valid()) {
+		err = fmt.Errorf("Invalid watchdog action received: %s", watchdog)
+		return nil, &payloadError{err, payloads.InvalidData}
+	}
+
+	// Only VMs running on compute nodes can be live migrated.
+
+	migrationSource := strings.TrimSpace(start.MigrationSource)
+	if migrationSource != "" && (container || networkNode || !uuidRegexp.MatchString(migrationSource)) {
+		err = fmt.Errorf("Invalid migration source received: %s", migrationSource)
+		return nil, &payloadError{err, payloads.InvalidData}
+	}
+
 	net := &start.Networking
 	vnicIP := strings.TrimSpace(net.PrivateIP)
 	sshPort := computeSSHPort(networkNode, vnicIP)
 	var volumes []volumeConfig
 	for _, storage := range start.Storage {
 		if storage.ID != "" {
+			if !poolRegexp.MatchString(storage.Pool) {
+				err = fmt.Errorf("Invalid pool received: %s", storage.Pool)
+				return nil, &payloadError{err, payloads.InvalidData}
+			}
+
+			if storage.CDROM && container {
+				err = fmt.Errorf("CD-ROM volumes are not supported by containers: %s", storage.ID)
+				return nil, &payloadError{err, payloads.InvalidData}
+			}
+
+			if storage.Rescue && container {
+				err = fmt.Errorf("Rescue volumes are not supported by containers: %s", storage.ID)
+				return nil, &payloadError{err, payloads.InvalidData}
+			}
+
 			volumes = append(volumes, volumeConfig{
-				UUID:     storage.ID,
-				Bootable: storage.Bootable,
+				UUID:      storage.ID,
+				Bootable:  storage.Bootable,
+				Pool:      storage.Pool,
+				Encrypted: storage.Key != "",
+				CDROM:     storage.CDROM,
+				Rescue:    storage.Rescue,
+				key:       storage.Key,
 			})
 		} else {
 			/* See github issue #972:
@@ -164,6 +213,9 @@ func parseStartPayload(data []byte) (*vmConfig, *payloadError) {
 
 	return &vmConfig{Cpus: cpus,
 		Mem:         mem,
+		Disk:        disk,
+		DiskIOPS:    iops,
+		NetMbps:     mbps,
 		Instance:    instance,
 		DockerImage: start.DockerImage,
 		Legacy:      legacy,
@@ -180,6 +232,12 @@ func parseStartPayload(data []byte) (*vmConfig, *payloadError) {
 		Volumes:     volumes,
 		Restart:     clouddata.Start.Restart,
 		Privileged:  privileged,
+		Console:     console,
+		Annotations: start.Annotations,
+		Watchdog:    watchdog,
+		Balloon:     !container && balloonPolicy.Enabled(),
+
+		migrationSource: migrationSource,
 	}, nil
 }
 
@@ -189,6 +247,7 @@ func generateStartError(node, instance string, startErr *startError) (out []byte
 		InstanceUUID: instance,
 		Reason:       startErr.code,
 		Restart:      startErr.restart,
+		Diagnostics:  startErr.diagnostics(),
 	}
 	return yaml.Marshal(sf)
 }
@@ -257,6 +316,277 @@ func parseDeletePayload(data []byte) (string, bool, *payloadError) {
 	return instance, clouddata.Delete.Stop, nil
 }
 
+func parsePausePayload(data []byte, pause bool) (string, error) {
+	var cmd payloads.PauseCmd
+
+	if pause {
+		var clouddata payloads.Pause
+		if err := yaml.Unmarshal(data, &clouddata); err != nil {
+			return "", err
+		}
+		cmd = clouddata.Pause
+	} else {
+		var clouddata payloads.Unpause
+		if err := yaml.Unmarshal(data, &clouddata); err != nil {
+			return "", err
+		}
+		cmd = clouddata.Unpause
+	}
+
+	instance := strings.TrimSpace(cmd.InstanceUUID)
+	if !uuidRegexp.MatchString(instance) {
+		return "", fmt.Errorf("Invalid instance id received: %s", instance)
+	}
+	return instance, nil
+}
+
+// parseCommandSequence returns the sequence of an instance command payload,
+// or 0 if the command is not sequenced.
+func parseCommandSequence(data []byte) uint64 {
+	var clouddata map[string]struct {
+		Sequence uint64 `yaml:"sequence"`
+	}
+
+	if err := yaml.Unmarshal(data, &clouddata); err != nil {
+		return 0
+	}
+
+	for _, cmd := range clouddata {
+		return cmd.Sequence
+	}
+
+	return 0
+}
+
+func parseRescuePayload(data []byte) (string, *volumeConfig, error) {
+	var clouddata payloads.Rescue
+
+	if err := yaml.Unmarshal(data, &clouddata); err != nil {
+		return "", nil, err
+	}
+
+	instance := strings.TrimSpace(clouddata.Rescue.InstanceUUID)
+	if !uuidRegexp.MatchString(instance) {
+		return "", nil, fmt.Errorf("Invalid instance id received: %s", instance)
+	}
+
+	storage := clouddata.Rescue.Volume
+	volume := strings.TrimSpace(storage.ID)
+	if !uuidRegexp.MatchString(volume) {
+		return "", nil, fmt.Errorf("Invalid volume id received: %s", volume)
+	}
+
+	if !poolRegexp.MatchString(storage.Pool) {
+		return "", nil, fmt.Errorf("Invalid pool received: %s", storage.Pool)
+	}
+
+	return instance, &volumeConfig{
+		UUID:      volume,
+		Bootable:  true,
+		Pool:      storage.Pool,
+		Encrypted: storage.Key != "",
+		Rescue:    true,
+		key:       storage.Key,
+	}, nil
+}
+
+func parseUnrescuePayload(data []byte) (string, error) {
+	var clouddata payloads.Unrescue
+
+	if err := yaml.Unmarshal(data, &clouddata); err != nil {
+		return "", err
+	}
+
+	instance := strings.TrimSpace(clouddata.Unrescue.InstanceUUID)
+	if !uuidRegexp.MatchString(instance) {
+		return "", fmt.Errorf("Invalid instance id received: %s", instance)
+	}
+	return instance, nil
+}
+
+func parseRebootPayload(data []byte) (string, *insRebootCmd, error) {
+	var clouddata payloads.Reboot
+
+	if err := yaml.Unmarshal(data, &clouddata); err != nil {
+		return "", nil, err
+	}
+
+	instance := strings.TrimSpace(clouddata.Reboot.InstanceUUID)
+	if !uuidRegexp.MatchString(instance) {
+		return "", nil, fmt.Errorf("Invalid instance id received: %s", instance)
+	}
+
+	switch clouddata.Reboot.Type {
+	case payloads.RebootSoft, payloads.RebootHard:
+	default:
+		return "", nil, fmt.Errorf("Invalid reboot type received: %s", clouddata.Reboot.Type)
+	}
+
+	return instance, &insRebootCmd{clouddata.Reboot.Type == payloads.RebootHard}, nil
+}
+
+func parseUpdateMetadataPayload(data []byte) (string, *insUpdateMetadataCmd, error) {
+	var clouddata payloads.UpdateMetadata
+
+	if err := yaml.Unmarshal(data, &clouddata); err != nil {
+		return "", nil, err
+	}
+
+	cmd := clouddata.UpdateMetadata
+	instance := strings.TrimSpace(cmd.InstanceUUID)
+	if !uuidRegexp.MatchString(instance) {
+		return "", nil, fmt.Errorf("Invalid instance id received: %s", instance)
+	}
+
+	if cmd.MetaData != "" {
+		var metaData interface{}
+		if json.Unmarshal([]byte(cmd.MetaData), &metaData) != nil {
+			return "", nil, fmt.Errorf("Invalid meta data received for instance %s", instance)
+		}
+	}
+
+	return instance, &insUpdateMetadataCmd{
+		userData: []byte(cmd.UserData),
+		metaData: []byte(cmd.MetaData),
+		reboot:   cmd.Reboot,
+	}, nil
+}
+
+func parseConsolePayload(data []byte) (string, *insConsoleCmd, error) {
+	var clouddata payloads.Console
+
+	if err := yaml.Unmarshal(data, &clouddata); err != nil {
+		return "", nil, err
+	}
+
+	instance := strings.TrimSpace(clouddata.Console.InstanceUUID)
+	if !uuidRegexp.MatchString(instance) {
+		return "", nil, fmt.Errorf("Invalid instance id received: %s", instance)
+	}
+
+	session := strings.TrimSpace(clouddata.Console.SessionUUID)
+	if !uuidRegexp.MatchString(session) {
+		return "", nil, fmt.Errorf("Invalid session id received: %s", session)
+	}
+
+	action := clouddata.Console.Action
+	switch action {
+	case payloads.ConsoleOpen, payloads.ConsoleInput, payloads.ConsoleClose:
+	default:
+		return "", nil, fmt.Errorf("Invalid console action received: %s", action)
+	}
+
+	if len(clouddata.Console.Input) > consoleInputLimit {
+		return "", nil, fmt.Errorf("Console input too large: %d bytes",
+			len(clouddata.Console.Input))
+	}
+
+	return instance, &insConsoleCmd{
+		session: session,
+		action:  action,
+		input:   clouddata.Console.Input,
+	}, nil
+}
+
+func parseGuestAgentPayload(data []byte) (string, *insGuestAgentCmd, error) {
+	var clouddata payloads.GuestAgent
+
+	if err := yaml.Unmarshal(data, &clouddata); err != nil {
+		return "", nil, err
+	}
+
+	instance := strings.TrimSpace(clouddata.GuestAgent.InstanceUUID)
+	if !uuidRegexp.MatchString(instance) {
+		return "", nil, fmt.Errorf("Invalid instance id received: %s", instance)
+	}
+
+	request := strings.TrimSpace(clouddata.GuestAgent.RequestUUID)
+	if !uuidRegexp.MatchString(request) {
+		return "", nil, fmt.Errorf("Invalid request id received: %s", request)
+	}
+
+	operation := clouddata.GuestAgent.Operation
+	switch operation {
+	case payloads.GuestExec:
+		if clouddata.GuestAgent.Path == "" {
+			return "", nil, fmt.Errorf("No command to run in the guest")
+		}
+	case payloads.GuestFreeze, payloads.GuestThaw, payloads.GuestNetworkInfo:
+	default:
+		return "", nil, fmt.Errorf("Invalid guest agent operation received: %s", operation)
+	}
+
+	return instance, &insGuestAgentCmd{
+		request:   request,
+		operation: operation,
+		path:      clouddata.GuestAgent.Path,
+		args:      clouddata.GuestAgent.Args,
+	}, nil
+}
+
+func parsePacketCapturePayload(data []byte) (string, *insPacketCaptureCmd, error) {
+	var clouddata payloads.PacketCapture
+
+	if err := yaml.Unmarshal(data, &clouddata); err != nil {
+		return "", nil, err
+	}
+
+	instance := strings.TrimSpace(clouddata.PacketCapture.InstanceUUID)
+	if !uuidRegexp.MatchString(instance) {
+		return "", nil, fmt.Errorf("Invalid instance id received: %s", instance)
+	}
+
+	capture := strings.TrimSpace(clouddata.PacketCapture.CaptureUUID)
+	if !uuidRegexp.MatchString(capture) {
+		return "", nil, fmt.Errorf("Invalid capture id received: %s", capture)
+	}
+
+	maxBytes := clouddata.PacketCapture.MaxBytes
+	if maxBytes <= 0 || maxBytes > payloads.MaxPacketCaptureBytes {
+		return "", nil, fmt.Errorf("Invalid packet capture size received: %d", maxBytes)
+	}
+
+	maxSeconds := clouddata.PacketCapture.MaxSeconds
+	if maxSeconds <= 0 || maxSeconds > payloads.MaxPacketCaptureSeconds {
+		return "", nil, fmt.Errorf("Invalid packet capture duration received: %d", maxSeconds)
+	}
+
+	return instance, &insPacketCaptureCmd{
+		capture:  capture,
+		maxBytes: maxBytes,
+		duration: time.Duration(maxSeconds) * time.Second,
+		filter:   clouddata.PacketCapture.Filter,
+	}, nil
+}
+
+func parseMigratePayload(data []byte) (string, *insMigrateCmd, error) {
+	var clouddata payloads.Migrate
+
+	if err := yaml.Unmarshal(data, &clouddata); err != nil {
+		return "", nil, err
+	}
+
+	instance := strings.TrimSpace(clouddata.Migrate.InstanceUUID)
+	if !uuidRegexp.MatchString(instance) {
+		return "", nil, fmt.Errorf("Invalid instance id received: %s", instance)
+	}
+
+	target := strings.TrimSpace(clouddata.Migrate.TargetAgentUUID)
+	if !uuidRegexp.MatchString(target) {
+		return "", nil, fmt.Errorf("Invalid target node id received: %s", target)
+	}
+
+	address := strings.TrimSpace(clouddata.Migrate.TargetAddress)
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return "", nil, fmt.Errorf("Invalid target address received: %s", address)
+	}
+
+	return instance, &insMigrateCmd{
+		target:  target,
+		address: address,
+	}, nil
+}
+
 func extractVolumeInfo(cmd *payloads.VolumeCmd, errString string) (string, string, *payloadError) {
 	instance := strings.TrimSpace(cmd.InstanceUUID)
 	if !uuidRegexp.MatchString(instance) {
@@ -272,16 +602,32 @@ func extractVolumeInfo(cmd *payloads.VolumeCmd, errString string) (string, strin
 	return instance, volume, nil
 }
 
-func parseAttachVolumePayload(data []byte) (string, string, *payloadError) {
+func parseAttachVolumePayload(data []byte) (string, volumeConfig, *payloadError) {
 	var clouddata payloads.AttachVolume
 
 	err := yaml.Unmarshal(data, &clouddata)
 	if err != nil {
 		glog.Errorf("YAML error: %v", err)
-		return "", "", &payloadError{err, payloads.AttachVolumeInvalidPayload}
+		return "", volumeConfig{}, &payloadError{err, payloads.AttachVolumeInvalidPayload}
 	}
 
-	return extractVolumeInfo(&clouddata.Attach, payloads.AttachVolumeInvalidData)
+	instance, volume, payloadErr := extractVolumeInfo(&clouddata.Attach, payloads.AttachVolumeInvalidData)
+	if payloadErr != nil {
+		return "", volumeConfig{}, payloadErr
+	}
+
+	pool := strings.TrimSpace(clouddata.Attach.Pool)
+	if !poolRegexp.MatchString(pool) {
+		err := fmt.Errorf("Invalid pool received: %s", pool)
+		return "", volumeConfig{}, &payloadError{err, payloads.AttachVolumeInvalidData}
+	}
+
+	return instance, volumeConfig{
+		UUID:      volume,
+		Pool:      pool,
+		Encrypted: clouddata.Attach.Key != "",
+		key:       clouddata.Attach.Key,
+	}, nil
 }
 
 func linesToBytes(doc []string, buf *bytes.Buffer) {
@@ -17,8 +17,11 @@
 package main
 
 import (
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	yaml "gopkg.in/yaml.v2"
 
@@ -67,12 +70,87 @@ start:
 			SSHPort:    35050,
 			Volumes: []volumeConfig{
 				{
-					"69e84267-ed01-4738-b15f-b47de06b62e7",
-					true,
+					UUID:     "69e84267-ed01-4738-b15f-b47de06b62e7",
+					Bootable: true,
 				},
 			},
 		},
 	},
+	{
+		`
+start:
+  requirements:
+    vcpus: 2
+    mem_mb: 370
+  instance_uuid: d7d86208-b46c-4465-9018-ee14087d415f
+  tenant_uuid: 67d86208-000-4465-9018-fe14087d415f
+  fw_type: legacy
+  vm_type: qemu
+  networking:
+    vnic_mac: 02:00:e6:f5:af:f9
+    vnic_uuid: 67d86208-b46c-0000-9018-fe14087d415f
+    concentrator_ip: 192.168.42.21
+    concentrator_uuid: 67d86208-b46c-4465-0000-fe14087d415f
+    subnet: 192.168.8.0/21
+    private_ip: 192.168.8.2
+  annotations:
+    billing: team-a
+    trace_id: 4bf92f3577b34da6
+`,
+		&vmConfig{
+			Cpus:       2,
+			Mem:        370,
+			Instance:   "d7d86208-b46c-4465-9018-ee14087d415f",
+			Legacy:     true,
+			VnicMAC:    "02:00:e6:f5:af:f9",
+			VnicIP:     "192.168.8.2",
+			ConcIP:     "192.168.42.21",
+			SubnetIP:   "192.168.8.0/21",
+			TenantUUID: "67d86208-000-4465-9018-fe14087d415f",
+			ConcUUID:   "67d86208-b46c-4465-0000-fe14087d415f",
+			VnicUUID:   "67d86208-b46c-0000-9018-fe14087d415f",
+			SSHPort:    35050,
+			Annotations: map[string]string{
+				"billing":  "team-a",
+				"trace_id": "4bf92f3577b34da6",
+			},
+		},
+	},
+	{
+		`
+start:
+  requirements:
+    vcpus: 2
+    mem_mb: 370
+    watchdog: poweroff
+  instance_uuid: d7d86208-b46c-4465-9018-ee14087d415f
+  tenant_uuid: 67d86208-000-4465-9018-fe14087d415f
+  fw_type: legacy
+  vm_type: qemu
+  networking:
+    vnic_mac: 02:00:e6:f5:af:f9
+    vnic_uuid: 67d86208-b46c-0000-9018-fe14087d415f
+    concentrator_ip: 192.168.42.21
+    concentrator_uuid: 67d86208-b46c-4465-0000-fe14087d415f
+    subnet: 192.168.8.0/21
+    private_ip: 192.168.8.2
+`,
+		&vmConfig{
+			Cpus:       2,
+			Mem:        370,
+			Instance:   "d7d86208-b46c-4465-9018-ee14087d415f",
+			Legacy:     true,
+			VnicMAC:    "02:00:e6:f5:af:f9",
+			VnicIP:     "192.168.8.2",
+			ConcIP:     "192.168.42.21",
+			SubnetIP:   "192.168.8.0/21",
+			TenantUUID: "67d86208-000-4465-9018-fe14087d415f",
+			ConcUUID:   "67d86208-b46c-4465-0000-fe14087d415f",
+			VnicUUID:   "67d86208-b46c-0000-9018-fe14087d415f",
+			SSHPort:    35050,
+			Watchdog:   payloads.WatchdogPowerOff,
+		},
+	},
 	{
 		"start",
 		nil,
@@ -141,6 +219,127 @@ start:
   storage:
      - id: 69e84267-ed01-4738-b15f-b47de06b62e7
        boot: true
+`,
+		nil,
+	},
+	{
+		`
+start:
+  requirements:
+    vcpus: 2
+    mem_mb: 370
+  instance_uuid: d7d86208-b46c-4465-9018-ee14087d415f
+  vm_type: docker
+  docker_image: ubuntu
+  tenant_uuid: 67d86208-000-4465-9018-fe14087d415f
+  networking:
+    vnic_mac: 02:00:e6:f5:af:f9
+    vnic_uuid: 67d86208-b46c-0000-9018-fe14087d415f
+    concentrator_ip: 192.168.42.21
+    concentrator_uuid: 67d86208-b46c-4465-0000-fe14087d415f
+    subnet: 192.168.8.0/21
+    private_ip: 192.168.8.2
+  storage:
+     - id: 69e84267-ed01-4738-b15f-b47de06b62e7
+       cdrom: true
+`,
+		nil,
+	},
+	{
+		`
+start:
+  requirements:
+    vcpus: 2
+    mem_mb: 370
+    watchdog: explode
+  instance_uuid: d7d86208-b46c-4465-9018-ee14087d415f
+  tenant_uuid: 67d86208-000-4465-9018-fe14087d415f
+  fw_type: legacy
+  vm_type: qemu
+  networking:
+    vnic_mac: 02:00:e6:f5:af:f9
+    vnic_uuid: 67d86208-b46c-0000-9018-fe14087d415f
+    concentrator_ip: 192.168.42.21
+    concentrator_uuid: 67d86208-b46c-4465-0000-fe14087d415f
+    subnet: 192.168.8.0/21
+    private_ip: 192.168.8.2
+`,
+		nil,
+	},
+	{
+		`
+start:
+  requirements:
+    vcpus: 2
+    mem_mb: 370
+    console: true
+  instance_uuid: d7d86208-b46c-4465-9018-ee14087d415f
+  tenant_uuid: 67d86208-000-4465-9018-fe14087d415f
+  fw_type: legacy
+  vm_type: qemu
+  networking:
+    vnic_mac: 02:00:e6:f5:af:f9
+    vnic_uuid: 67d86208-b46c-0000-9018-fe14087d415f
+    concentrator_ip: 192.168.42.21
+    concentrator_uuid: 67d86208-b46c-4465-0000-fe14087d415f
+    subnet: 192.168.8.0/21
+    private_ip: 192.168.8.2
+`,
+		nil,
+	},
+	{
+		`
+start:
+  requirements:
+    vcpus: 2
+    mem_mb: 370
+  instance_uuid: d7d86208-b46c-4465-9018-ee14087d415f
+  tenant_uuid: 67d86208-000-4465-9018-fe14087d415f
+  fw_type: legacy
+  vm_type: qemu
+  migration_source: 4cb19522-1e18-439a-883a-f9b2a3a95f5e
+  networking:
+    vnic_mac: 02:00:e6:f5:af:f9
+    vnic_uuid: 67d86208-b46c-0000-9018-fe14087d415f
+    concentrator_ip: 192.168.42.21
+    concentrator_uuid: 67d86208-b46c-4465-0000-fe14087d415f
+    subnet: 192.168.8.0/21
+    private_ip: 192.168.8.2
+`,
+		&vmConfig{
+			Cpus:            2,
+			Mem:             370,
+			Instance:        "d7d86208-b46c-4465-9018-ee14087d415f",
+			Legacy:          true,
+			VnicMAC:         "02:00:e6:f5:af:f9",
+			VnicIP:          "192.168.8.2",
+			ConcIP:          "192.168.42.21",
+			SubnetIP:        "192.168.8.0/21",
+			TenantUUID:      "67d86208-000-4465-9018-fe14087d415f",
+			ConcUUID:        "67d86208-b46c-4465-0000-fe14087d415f",
+			VnicUUID:        "67d86208-b46c-0000-9018-fe14087d415f",
+			SSHPort:         35050,
+			migrationSource: "4cb19522-1e18-439a-883a-f9b2a3a95f5e",
+		},
+	},
+	{
+		`
+start:
+  requirements:
+    vcpus: 2
+    mem_mb: 370
+  instance_uuid: d7d86208-b46c-4465-9018-ee14087d415f
+  vm_type: docker
+  docker_image: ubuntu
+  tenant_uuid: 67d86208-000-4465-9018-fe14087d415f
+  migration_source: 4cb19522-1e18-439a-883a-f9b2a3a95f5e
+  networking:
+    vnic_mac: 02:00:e6:f5:af:f9
+    vnic_uuid: 67d86208-b46c-0000-9018-fe14087d415f
+    concentrator_ip: 192.168.42.21
+    concentrator_uuid: 67d86208-b46c-4465-0000-fe14087d415f
+    subnet: 192.168.8.0/21
+    private_ip: 192.168.8.2
 `,
 		nil,
 	},
@@ -148,18 +347,35 @@ start:
 
 // Verify the parseAttachVolumePayload function.
 //
-// The function is passed one valid payload and two invalid payloads.
+// The function is passed three valid payloads and three invalid payloads.
 //
-// No error should be returned for the valid payload and the returned instance
-// and volume UUIDs should match what is in the payload.  Errors should be
-// returned for the invalid payloads.
+// No error should be returned for the valid payloads and the returned instance
+// and volume UUIDs, pool and key should match what is in the payload.  Errors
+// should be returned for the invalid payloads.
 func TestParseAttachVolumePayload(t *testing.T) {
 	instance, volume, err := parseAttachVolumePayload([]byte(testutil.AttachVolumeYaml))
 	if err != nil {
 		t.Fatalf("parseAttachVolumePayload failed: %v", err)
 	}
-	if instance != testutil.InstanceUUID || volume != testutil.VolumeUUID {
-		t.Fatalf("VolumeUUID or InstanceUUID is invalid")
+	if instance != testutil.InstanceUUID || volume.UUID != testutil.VolumeUUID ||
+		volume.Pool != "" || volume.Encrypted {
+		t.Fatalf("VolumeUUID, InstanceUUID, pool or encryption is invalid")
+	}
+
+	_, volume, err = parseAttachVolumePayload([]byte(testutil.AttachVolumeYaml + "  pool: ssd\n"))
+	if err != nil {
+		t.Fatalf("parseAttachVolumePayload failed: %v", err)
+	}
+	if volume.Pool != "ssd" {
+		t.Fatalf("Expected pool ssd, got %s", volume.Pool)
+	}
+
+	_, volume, err = parseAttachVolumePayload([]byte(testutil.AttachVolumeYaml + "  key: secret\n"))
+	if err != nil {
+		t.Fatalf("parseAttachVolumePayload failed: %v", err)
+	}
+	if !volume.Encrypted || volume.key != "secret" {
+		t.Fatalf("Expected encrypted volume with key secret")
 	}
 
 	_, _, err = parseAttachVolumePayload([]byte("  -"))
@@ -171,6 +387,11 @@ func TestParseAttachVolumePayload(t *testing.T) {
 	if err == nil || err.code != payloads.AttachVolumeInvalidData {
 		t.Fatalf("AttachVolumeInvalidData error expected")
 	}
+
+	_, _, err = parseAttachVolumePayload([]byte(testutil.AttachVolumeYaml + "  pool: ssd/../rbd\n"))
+	if err == nil || err.code != payloads.AttachVolumeInvalidData {
+		t.Fatalf("AttachVolumeInvalidData error expected for invalid pool")
+	}
 }
 
 // Verify the parseStartPayload function.
@@ -267,6 +488,68 @@ func TestGenerateNetEventPayload(t *testing.T) {
 	}
 }
 
+// Check that generateStartError reports the diagnostics of a start failure.
+//
+// Generate start failure payloads for an instance which was not launched,
+// one which failed to launch with a long error output and one which is
+// already running.
+//
+// The first payload should only contain the error, the second should also
+// contain the command line, the log and the tail of the error output and
+// the third should contain no diagnostics.
+func TestGenerateStartError(t *testing.T) {
+	stderr := strings.Repeat("x", maxDiagnosticOutput) + "qemu failed"
+
+	tests := []struct {
+		se          *startError
+		diagnostics *payloads.StartFailureDiagnostics
+	}{
+		{
+			&startError{errors.New("no image"), payloads.ImageFailure, false},
+			&payloads.StartFailureDiagnostics{Error: "no image"},
+		},
+		{
+			&startError{&launchFailure{
+				err:         errors.New("exit status 1"),
+				commandLine: []string{"-m", "370"},
+				stderr:      stderr,
+				log:         []string{"launching qemu with: [-m 370]"},
+			}, payloads.LaunchFailure, true},
+			&payloads.StartFailureDiagnostics{
+				Error:       "exit status 1",
+				CommandLine: []string{"-m", "370"},
+				Stderr:      stderr[len(stderr)-maxDiagnosticOutput:],
+				Log:         []string{"launching qemu with: [-m 370]"},
+			},
+		},
+		{
+			&startError{nil, payloads.AlreadyRunning, false},
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		pl, err := generateStartError(testutil.AgentUUID, testutil.InstanceUUID, test.se)
+		if err != nil {
+			t.Fatalf("Failed to generate payload : %v", err)
+		}
+
+		var failure payloads.ErrorStartFailure
+		err = yaml.Unmarshal(pl, &failure)
+		if err != nil {
+			t.Fatalf("Unable to unmarshall start failure : %v", err)
+		}
+
+		if failure.Reason != test.se.code || failure.Restart != test.se.restart {
+			t.Errorf("Unexpected start failure %+v", failure)
+		}
+
+		if !reflect.DeepEqual(failure.Diagnostics, test.diagnostics) {
+			t.Errorf("Expected diagnostics %+v, got %+v", test.diagnostics, failure.Diagnostics)
+		}
+	}
+}
+
 // Check that parseDeletePayload works correctly.
 //
 // Parse a valid delete payload.
@@ -286,3 +569,290 @@ func TestParseDeletePayload(t *testing.T) {
 		t.Errorf("Expected stop to be false")
 	}
 }
+
+// Check that parsePausePayload works correctly.
+//
+// Parse valid pause and unpause payloads and then parse a pause payload
+// as an unpause one.
+//
+// The first two payloads should parse without any error and the instance UUID
+// should be as expected.  The last one should fail as it contains no
+// unpause instance UUID.
+func TestParsePausePayload(t *testing.T) {
+	instance, err := parsePausePayload([]byte(testutil.PauseYaml), true)
+	if err != nil {
+		t.Fatalf("Failed to parse pause payload : %v", err)
+	}
+	if instance != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID.  Expected %s found %s",
+			testutil.InstanceUUID, instance)
+	}
+
+	instance, err = parsePausePayload([]byte(testutil.UnpauseYaml), false)
+	if err != nil {
+		t.Fatalf("Failed to parse unpause payload : %v", err)
+	}
+	if instance != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID.  Expected %s found %s",
+			testutil.InstanceUUID, instance)
+	}
+
+	_, err = parsePausePayload([]byte(testutil.PauseYaml), false)
+	if err == nil {
+		t.Errorf("Parsing a pause payload as unpause should fail")
+	}
+}
+
+// Check that parseRescuePayload and parseUnrescuePayload work correctly.
+//
+// Parse valid rescue and unrescue payloads and then parse an unrescue
+// payload as a rescue one.
+//
+// The first two payloads should parse without any error, the instance UUID
+// should be as expected and the rescue volume should be bootable.  The last
+// one should fail as it contains no rescue instance UUID.
+func TestParseRescuePayload(t *testing.T) {
+	instance, vol, err := parseRescuePayload([]byte(testutil.RescueYaml))
+	if err != nil {
+		t.Fatalf("Failed to parse rescue payload : %v", err)
+	}
+	if instance != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID.  Expected %s found %s",
+			testutil.InstanceUUID, instance)
+	}
+	if vol.UUID != testutil.VolumeUUID || !vol.Bootable || !vol.Rescue {
+		t.Errorf("Unexpected rescue volume %+v", vol)
+	}
+
+	instance, err = parseUnrescuePayload([]byte(testutil.UnrescueYaml))
+	if err != nil {
+		t.Fatalf("Failed to parse unrescue payload : %v", err)
+	}
+	if instance != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID.  Expected %s found %s",
+			testutil.InstanceUUID, instance)
+	}
+
+	_, _, err = parseRescuePayload([]byte(testutil.UnrescueYaml))
+	if err == nil {
+		t.Errorf("Parsing an unrescue payload as rescue should fail")
+	}
+}
+
+// Check that parseRebootPayload works correctly.
+//
+// Parse a valid reboot payload, then a reboot payload with an invalid type
+// and finally an unrescue payload as a reboot one.
+//
+// The first payload should parse without any error, the instance UUID
+// should be as expected and the reboot should be hard.  The other two
+// should fail.
+func TestParseRebootPayload(t *testing.T) {
+	instance, cmd, err := parseRebootPayload([]byte(testutil.RebootYaml))
+	if err != nil {
+		t.Fatalf("Failed to parse reboot payload : %v", err)
+	}
+	if instance != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID.  Expected %s found %s",
+			testutil.InstanceUUID, instance)
+	}
+	if !cmd.hard {
+		t.Errorf("Expected a hard reboot")
+	}
+
+	payload := strings.Replace(testutil.RebootYaml, "type: hard", "type: warm", 1)
+	_, _, err = parseRebootPayload([]byte(payload))
+	if err == nil {
+		t.Errorf("Parsing a reboot payload with an invalid type should fail")
+	}
+
+	_, _, err = parseRebootPayload([]byte(testutil.UnrescueYaml))
+	if err == nil {
+		t.Errorf("Parsing an unrescue payload as reboot should fail")
+	}
+}
+
+// Check that parseUpdateMetadataPayload works correctly.
+//
+// Parse a valid UpdateMetadata payload, then one with invalid meta data
+// and finally an unrescue payload as an UpdateMetadata one.
+//
+// The first payload should parse without any error, the instance UUID,
+// user data, meta data and reboot flag should be as expected.  The other
+// two should fail.
+func TestParseUpdateMetadataPayload(t *testing.T) {
+	instance, cmd, err := parseUpdateMetadataPayload([]byte(testutil.UpdateMetadataYaml))
+	if err != nil {
+		t.Fatalf("Failed to parse UpdateMetadata payload : %v", err)
+	}
+	if instance != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID.  Expected %s found %s",
+			testutil.InstanceUUID, instance)
+	}
+	if string(cmd.userData) != testutil.UpdatedUserData ||
+		string(cmd.metaData) != testutil.UpdatedMetaData || !cmd.reboot {
+		t.Errorf("Unexpected UpdateMetadata command %+v", cmd)
+	}
+
+	payload := strings.Replace(testutil.UpdateMetadataYaml, `"hostname"`, `hostname`, 1)
+	_, _, err = parseUpdateMetadataPayload([]byte(payload))
+	if err == nil {
+		t.Errorf("Parsing an UpdateMetadata payload with invalid meta data should fail")
+	}
+
+	_, _, err = parseUpdateMetadataPayload([]byte(testutil.UnrescueYaml))
+	if err == nil {
+		t.Errorf("Parsing an unrescue payload as UpdateMetadata should fail")
+	}
+}
+
+// Check that parseConsolePayload works correctly.
+//
+// Parse a valid console payload, then an unrescue payload as a console one
+// and finally a console payload with too much input.
+//
+// The first payload should parse without any error and the instance UUID,
+// session UUID, action and input should be as expected.  The other two
+// should fail.
+func TestParseConsolePayload(t *testing.T) {
+	instance, cmd, err := parseConsolePayload([]byte(testutil.ConsoleYaml))
+	if err != nil {
+		t.Fatalf("Failed to parse console payload : %v", err)
+	}
+	if instance != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID.  Expected %s found %s",
+			testutil.InstanceUUID, instance)
+	}
+	if cmd.session != testutil.ConsoleSessionUUID ||
+		cmd.action != payloads.ConsoleInput || cmd.input != "root\n" {
+		t.Errorf("Unexpected console command %+v", cmd)
+	}
+
+	_, _, err = parseConsolePayload([]byte(testutil.UnrescueYaml))
+	if err == nil {
+		t.Errorf("Parsing an unrescue payload as console should fail")
+	}
+
+	input := strings.Repeat("x", consoleInputLimit+1)
+	payload := strings.Replace(testutil.ConsoleYaml, "|\n    root\n", input+"\n", 1)
+	_, _, err = parseConsolePayload([]byte(payload))
+	if err == nil {
+		t.Errorf("Parsing a console payload with too much input should fail")
+	}
+}
+
+// Check that parseGuestAgentPayload works correctly.
+//
+// Parse a valid guest agent payload, then an unrescue payload as a guest
+// agent one, a guest agent payload with an invalid operation and finally an
+// exec payload without any command.
+//
+// The first payload should parse without any error and the instance UUID,
+// request UUID, operation and command should be as expected.  The others
+// should fail.
+func TestParseGuestAgentPayload(t *testing.T) {
+	instance, cmd, err := parseGuestAgentPayload([]byte(testutil.GuestAgentYaml))
+	if err != nil {
+		t.Fatalf("Failed to parse guest agent payload : %v", err)
+	}
+	if instance != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID.  Expected %s found %s",
+			testutil.InstanceUUID, instance)
+	}
+	if cmd.request != testutil.GuestAgentRequestUUID ||
+		cmd.operation != payloads.GuestExec || cmd.path != "/bin/uname" ||
+		len(cmd.args) != 1 || cmd.args[0] != "-r" {
+		t.Errorf("Unexpected guest agent command %+v", cmd)
+	}
+
+	_, _, err = parseGuestAgentPayload([]byte(testutil.UnrescueYaml))
+	if err == nil {
+		t.Errorf("Parsing an unrescue payload as guest agent should fail")
+	}
+
+	payload := strings.Replace(testutil.GuestAgentYaml, "operation: exec", "operation: reboot", 1)
+	_, _, err = parseGuestAgentPayload([]byte(payload))
+	if err == nil {
+		t.Errorf("Parsing a guest agent payload with an invalid operation should fail")
+	}
+
+	payload = strings.Replace(testutil.GuestAgentYaml, "path: /bin/uname", "", 1)
+	_, _, err = parseGuestAgentPayload([]byte(payload))
+	if err == nil {
+		t.Errorf("Parsing an exec payload without a command should fail")
+	}
+}
+
+// Check that parsePacketCapturePayload works correctly.
+//
+// Parse a valid packet capture payload, then an unrescue payload as a
+// packet capture one, a packet capture payload with a size exceeding the
+// limit and finally one without any duration.
+//
+// The first payload should parse without any error and the instance UUID,
+// capture UUID, limits and filter should be as expected.  The others should
+// fail.
+func TestParsePacketCapturePayload(t *testing.T) {
+	instance, cmd, err := parsePacketCapturePayload([]byte(testutil.PacketCaptureYaml))
+	if err != nil {
+		t.Fatalf("Failed to parse packet capture payload : %v", err)
+	}
+	if instance != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID.  Expected %s found %s",
+			testutil.InstanceUUID, instance)
+	}
+	if cmd.capture != testutil.CaptureUUID || cmd.maxBytes != 1048576 ||
+		cmd.duration != time.Minute || cmd.filter != "icmp or arp" {
+		t.Errorf("Unexpected packet capture command %+v", cmd)
+	}
+
+	_, _, err = parsePacketCapturePayload([]byte(testutil.UnrescueYaml))
+	if err == nil {
+		t.Errorf("Parsing an unrescue payload as packet capture should fail")
+	}
+
+	payload := strings.Replace(testutil.PacketCaptureYaml, "max_bytes: 1048576", "max_bytes: 1073741824", 1)
+	_, _, err = parsePacketCapturePayload([]byte(payload))
+	if err == nil {
+		t.Errorf("Parsing a packet capture payload exceeding the size limit should fail")
+	}
+
+	payload = strings.Replace(testutil.PacketCaptureYaml, "max_seconds: 60", "", 1)
+	_, _, err = parsePacketCapturePayload([]byte(payload))
+	if err == nil {
+		t.Errorf("Parsing a packet capture payload without a duration should fail")
+	}
+}
+
+// Check that parseMigratePayload works correctly.
+//
+// Parse a valid migrate payload, then an unrescue payload as a migrate one
+// and finally a migrate payload without a port in its target address.
+//
+// The first payload should parse without any error and the instance UUID,
+// target node UUID and address should be as expected.  The others should
+// fail.
+func TestParseMigratePayload(t *testing.T) {
+	instance, cmd, err := parseMigratePayload([]byte(testutil.LiveMigrateYaml))
+	if err != nil {
+		t.Fatalf("Failed to parse migrate payload : %v", err)
+	}
+	if instance != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID.  Expected %s found %s",
+			testutil.InstanceUUID, instance)
+	}
+	if cmd.target != testutil.TargetAgentUUID || cmd.address != testutil.MigrationAddress {
+		t.Errorf("Unexpected migrate command %+v", cmd)
+	}
+
+	_, _, err = parseMigratePayload([]byte(testutil.UnrescueYaml))
+	if err == nil {
+		t.Errorf("Parsing an unrescue payload as migrate should fail")
+	}
+
+	payload := strings.Replace(testutil.LiveMigrateYaml, ":49152", "", 1)
+	_, _, err = parseMigratePayload([]byte(payload))
+	if err == nil {
+		t.Errorf("Parsing a migrate payload without a target port should fail")
+	}
+}
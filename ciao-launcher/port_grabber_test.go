@@ -68,3 +68,27 @@ func TestPortGrabber(t *testing.T) {
 		t.Error("Expected no ports to be allocated")
 	}
 }
+
+// Test the ports grabbed for incoming migrations
+//
+// Grab a migration port, release it along with a UI port.
+//
+// The migration port should be in the migration range and should be
+// released, the UI port should be rejected by the migration port grabber.
+func TestMigrationPortGrabber(t *testing.T) {
+	port := migrationPortGrabber.grabPort()
+	if port < migrationPortStart || port >= migrationPortMax {
+		t.Fatalf("Unexpected migration port %d", port)
+	}
+
+	if len(migrationPortGrabber.free) != migrationPortMax-migrationPortStart-1 {
+		t.Error("Expected one migration port to be allocated")
+	}
+
+	migrationPortGrabber.releasePort(portGrabberStart)
+	migrationPortGrabber.releasePort(port)
+
+	if len(migrationPortGrabber.free) != migrationPortMax-migrationPortStart {
+		t.Error("Expected no migration ports to be allocated")
+	}
+}
@@ -31,6 +31,8 @@ import (
 
 	"context"
 
+	storage "github.com/ciao-project/ciao/ciao-storage"
+	"github.com/ciao-project/ciao/payloads"
 	"github.com/golang/glog"
 	"github.com/intel/govmm/qemu"
 )
@@ -59,6 +61,33 @@ func (l qmpGlogLogger) Errorf(format string, v ...interface{}) {
 	glog.ErrorDepth(2, fmt.Sprintf(format, v...))
 }
 
+// qmpRecordingLogger is a qmpGlogLogger which also records the last
+// messages it logs, so that they can be reported if a launch fails.
+type qmpRecordingLogger struct {
+	qmpGlogLogger
+	lines []string
+}
+
+func (l *qmpRecordingLogger) record(msg string) string {
+	l.lines = append(l.lines, outputTail(msg))
+	if len(l.lines) > maxDiagnosticLogLines {
+		l.lines = l.lines[len(l.lines)-maxDiagnosticLogLines:]
+	}
+	return msg
+}
+
+func (l *qmpRecordingLogger) Infof(format string, v ...interface{}) {
+	glog.InfoDepth(2, l.record(fmt.Sprintf(format, v...)))
+}
+
+func (l *qmpRecordingLogger) Warningf(format string, v ...interface{}) {
+	glog.WarningDepth(2, l.record(fmt.Sprintf(format, v...)))
+}
+
+func (l *qmpRecordingLogger) Errorf(format string, v ...interface{}) {
+	glog.ErrorDepth(2, l.record(fmt.Sprintf(format, v...)))
+}
+
 var virtualSizeRegexp *regexp.Regexp
 var pssRegexp *regexp.Regexp
 
@@ -69,6 +98,7 @@ func init() {
 
 type qemuV struct {
 	cfg            *vmConfig
+	storageDriver  storage.BlockDriver
 	instanceDir    string
 	vcPort         int
 	pid            int
@@ -118,6 +148,20 @@ func (q *qemuV) createImage(bridge, gatewayIP string, userData, metaData []byte)
 	return nil
 }
 
+// updateMetadata replaces the config drive of the instance.  A running QEMU
+// keeps the previous image open, so the guest only sees the new one once
+// the instance is launched again, e.g., rebooted.
+func (q *qemuV) updateMetadata(userData, metaData []byte) error {
+	newPath := q.isoPath + ".new"
+	err := createCloudInitISO(q.instanceDir, newPath, q.cfg, userData, metaData)
+	if err != nil {
+		_ = os.Remove(newPath)
+		return err
+	}
+
+	return os.Rename(newPath, q.isoPath)
+}
+
 func (q *qemuV) deleteImage() error {
 	return nil
 }
@@ -341,14 +385,62 @@ func generateQEMULaunchParams(cfg *vmConfig, isoPath, instanceDir string,
 	// this if we want to be able to live detach these volumes.  The first drive qemu
 	// adds, i.e., the rootfs  is assigned a slot of 3 without spice and 4 with.
 
+	// All the volumes of an instance share a single throttling group so
+	// that the instance as a whole is limited to the IOPS it reserved.
+
+	throttling := ""
+	if cfg.DiskIOPS > 0 {
+		throttling = fmt.Sprintf(",throttling.iops-total=%d,throttling.group=%s",
+			cfg.DiskIOPS, cfg.Instance)
+	}
+
+	// Discard requests from the guest are passed down to ceph so that the
+	// space freed in the guest is released from the thin-provisioned
+	// volumes.  Volumes attached while the instance is running only get
+	// discard support once the instance is restarted.  Encrypted volumes
+	// are accessed through their decrypted device, opened by the launcher
+	// before qemu is started.
+	//
+	// CD-ROM volumes, such as installer media, are attached read-only to
+	// the IDE bus so that the firmware and the installers of the guest
+	// find them.  The bootable ones are tried first so that the instance
+	// boots from its installer or rescue media.
+	//
+	// An instance in rescue mode boots from its rescue volume, whatever
+	// its other volumes are.
+
+	rescue := cfg.rescueVolume() != nil
+
 	for _, v := range cfg.Volumes {
+		pool := v.Pool
+		if pool == "" {
+			pool = "rbd"
+		}
+		file := fmt.Sprintf("rbd:%s/%s:id=%s", pool, v.UUID, cephID)
+		if v.Encrypted {
+			file = storage.EncryptedDevicePath(v.UUID)
+		}
 		blockdevID := fmt.Sprintf("drive_%s", v.UUID)
-		volDriveStr := fmt.Sprintf("file=rbd:rbd/%s:id=%s,if=none,id=%s,format=raw",
-			v.UUID, cephID, blockdevID)
+		if v.CDROM {
+			params = append(params, "-drive",
+				fmt.Sprintf("file=%s,if=none,id=%s,format=raw,media=cdrom,readonly=on",
+					file, blockdevID))
+			cdDeviceStr := fmt.Sprintf("ide-cd,id=device_%s,drive=%s", v.UUID, blockdevID)
+			if v.Bootable && !rescue {
+				cdDeviceStr += ",bootindex=0"
+			}
+			params = append(params, "-device", cdDeviceStr)
+			continue
+		}
+		volDriveStr := fmt.Sprintf("file=%s,if=none,id=%s,format=raw,discard=unmap,detect-zeroes=unmap%s",
+			file, blockdevID, throttling)
 		params = append(params, "-drive", volDriveStr)
 		volDeviceStr :=
 			fmt.Sprintf("virtio-blk-pci,scsi=off,bus=pci.0,addr=0x%x,id=device_%s,drive=%s",
 				addr, v.UUID, blockdevID)
+		if v.Rescue {
+			volDeviceStr += ",bootindex=0"
+		}
 		params = append(params, "-device", volDeviceStr)
 		addr++
 	}
@@ -358,6 +450,21 @@ func generateQEMULaunchParams(cfg *vmConfig, isoPath, instanceDir string,
 
 	params = append(params, networkParams...)
 
+	// The balloon device allows the overseer to reclaim memory from idle
+	// instances when the node comes under memory pressure.
+
+	if cfg.Balloon {
+		params = append(params, "-device", "virtio-balloon-pci")
+	}
+
+	// The watchdog device lets QEMU apply the action chosen by the
+	// workload when the guest stops servicing it.
+
+	if cfg.Watchdog != "" {
+		params = append(params, "-device", "i6300esb")
+		params = append(params, "-watchdog-action", string(cfg.Watchdog))
+	}
+
 	useKvm := true
 
 	switch qemuVirtualisation {
@@ -382,6 +489,22 @@ func generateQEMULaunchParams(cfg *vmConfig, isoPath, instanceDir string,
 	qmpSocket := path.Join(instanceDir, "socket")
 	qmpParam := fmt.Sprintf("unix:%s,server,nowait", qmpSocket)
 	params = append(params, "-qmp", qmpParam)
+	params = append(params, "-qmp", fmt.Sprintf("unix:%s,server,nowait", qmpControlSocketPath(instanceDir)))
+
+	// The guest agent channel lets the launcher detect when the guest has
+	// booted.
+
+	qgaSocket := qgaSocketPath(instanceDir)
+	params = append(params, "-chardev", fmt.Sprintf("socket,path=%s,server,nowait,id=qga0", qgaSocket))
+	params = append(params, "-device", "virtio-serial")
+	params = append(params, "-device", "virtserialport,chardev=qga0,name=org.qemu.guest_agent.0")
+
+	// Instances being migrated to the node wait for their state from
+	// the source node rather than booting.
+
+	if cfg.migrationPort != 0 {
+		params = append(params, "-incoming", fmt.Sprintf("tcp:0:%d", cfg.migrationPort))
+	}
 
 	if cfg.Mem > 0 {
 		memoryParam := fmt.Sprintf("%d", cfg.Mem)
@@ -426,19 +549,32 @@ func (q *qemuV) startVM(vnicName, ipAddress, cephID string, fds []*os.File) erro
 			}
 			networkParams = append(networkParams, tapParam...)
 			defer cleanupFds(toClose, len(toClose))
+
+			if q.cfg.NetMbps > 0 {
+				err = limitVnicBandwidth(vnicName, q.cfg.NetMbps)
+				if err != nil {
+					return err
+				}
+			}
 		}
 	} else {
 		networkParams = append(networkParams, "-net", "nic,model=virtio")
 		networkParams = append(networkParams, "-net", "user")
 	}
 
-	params := generateQEMULaunchParams(q.cfg, q.isoPath, q.instanceDir, networkParams, cephID)
+	err := q.openEncryptedVolumes()
+	if err != nil {
+		return err
+	}
 
-	var err error
+	params := generateQEMULaunchParams(q.cfg, q.isoPath, q.instanceDir, networkParams, cephID)
 
+	var stderr string
+	logger := &qmpRecordingLogger{}
 	if !launchWithUI.Enabled() {
 		params = append(params, "-display", "none", "-vga", "none")
-		_, err = qemu.LaunchCustomQemu(context.Background(), "", params, fds, childProcessKVMCreds, qmpGlogLogger{})
+		params = append(params, consoleParams(q.instanceDir)...)
+		stderr, err = qemu.LaunchCustomQemu(context.Background(), "", params, fds, childProcessKVMCreds, logger)
 	} else if launchWithUI.String() == "spice" {
 		var port int
 		port, err = launchQemuWithSpice(params, fds, ipAddress)
@@ -454,7 +590,13 @@ func (q *qemuV) startVM(vnicName, ipAddress, cephID string, fds []*os.File) erro
 	}
 
 	if err != nil {
-		return err
+		q.closeEncryptedVolumes(q.cfg.Volumes)
+		return &launchFailure{
+			err:         err,
+			commandLine: params,
+			stderr:      stderr,
+			log:         logger.lines,
+		}
 	}
 
 	glog.Info("Launched VM")
@@ -462,6 +604,35 @@ func (q *qemuV) startVM(vnicName, ipAddress, cephID string, fds []*os.File) erro
 	return nil
 }
 
+// openEncryptedVolumes maps the encrypted volumes of the instance to the
+// node and opens their decrypted devices.
+func (q *qemuV) openEncryptedVolumes() error {
+	for i, v := range q.cfg.Volumes {
+		if !v.Encrypted {
+			continue
+		}
+
+		if _, err := mapVolume(q.storageDriver, v); err != nil {
+			q.closeEncryptedVolumes(q.cfg.Volumes[:i])
+			return fmt.Errorf("Unable to open encrypted volume (%s) %v", v.UUID, err)
+		}
+	}
+
+	return nil
+}
+
+func (q *qemuV) closeEncryptedVolumes(vols []volumeConfig) {
+	for _, v := range vols {
+		if !v.Encrypted {
+			continue
+		}
+
+		if err := unmapVolume(q.storageDriver, v); err != nil {
+			glog.Warningf("Unable to unmap %s: %v", v.UUID, err)
+		}
+	}
+}
+
 func (q *qemuV) lostVM() {
 	if launchWithUI.Enabled() {
 		glog.Infof("Releasing VC Port %d", q.vcPort)
@@ -501,8 +672,116 @@ func qmpAttach(cmd virtualizerAttachCmd, q *qemu.QMP) {
 	cmd.responseCh <- err
 }
 
+func qmpPause(cmd virtualizerPauseCmd, q *qemu.QMP) {
+	var err error
+
+	ctx, cancelFN := context.WithTimeout(context.Background(), time.Second*10)
+	if cmd.pause {
+		err = q.ExecuteStop(ctx)
+	} else {
+		err = q.ExecuteCont(ctx)
+	}
+	cancelFN()
+
+	cmd.responseCh <- err
+}
+
+func qmpBalloon(cmd virtualizerBalloonCmd, instanceDir string) {
+	args := map[string]interface{}{
+		"value": uint64(cmd.sizeMB) * 1024 * 1024,
+	}
+	cmd.responseCh <- qmpControlExecute(instanceDir, "balloon", args, nil)
+}
+
+// qmpMigrate starts sending the state of an instance to cmd.uri.  There is
+// nothing to start for instances being migrated to the node, as qemu was
+// told to wait for their state when it was launched.
+func qmpMigrate(cmd virtualizerMigrateCmd, instanceDir string) error {
+	if cmd.uri == "" {
+		return nil
+	}
+
+	args := map[string]interface{}{
+		"uri": cmd.uri,
+	}
+	return qmpControlExecute(instanceDir, "migrate", args, nil)
+}
+
+// qmpMigrationDone returns true once the migration started for cmd has
+// completed, or an error if it has failed.
+func qmpMigrationDone(cmd virtualizerMigrateCmd, instanceDir string) (bool, error) {
+	if cmd.uri == "" {
+		var status qmpStatusInfo
+		err := qmpControlExecute(instanceDir, "query-status", nil, &status)
+		if err != nil {
+			return false, err
+		}
+		switch status.Status {
+		case "inmigrate":
+			return false, nil
+		case "running":
+			return true, nil
+		}
+		return false, fmt.Errorf("Incoming migration failed, instance %s", status.Status)
+	}
+
+	var status qmpMigrationStatus
+	err := qmpControlExecute(instanceDir, "query-migrate", nil, &status)
+	if err != nil {
+		return false, err
+	}
+	switch status.Status {
+	case "completed":
+		return true, nil
+	case "failed", "cancelled":
+		if status.ErrorDesc != "" {
+			return false, fmt.Errorf("Migration %s: %s", status.Status, status.ErrorDesc)
+		}
+		return false, fmt.Errorf("Migration %s", status.Status)
+	}
+	glog.Infof("Migration %s: %d of %d bytes remaining", status.Status,
+		status.RAM.Remaining, status.RAM.Total)
+	return false, nil
+}
+
+// qmpMigrationRollback cancels a failed migration from the node and makes
+// sure that the instance keeps running.
+func qmpMigrationRollback(cmd virtualizerMigrateCmd, q *qemu.QMP, instanceDir string) {
+	if cmd.uri == "" {
+		return
+	}
+
+	if err := qmpControlExecute(instanceDir, "migrate_cancel", nil, nil); err != nil {
+		glog.Warningf("Failed to cancel migration: %v", err)
+	}
+
+	ctx, cancelFN := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancelFN()
+	if err := q.ExecuteCont(ctx); err != nil {
+		glog.Warningf("Failed to resume instance: %v", err)
+	}
+}
+
+// qmpEvents reports the watchdog events of an instance to the overseer until
+// the QMP connection is closed.  The events need to be read continuously as
+// QMP commands cannot complete while an event is pending.
+func qmpEvents(eventCh <-chan qemu.QMPEvent, instance string, ovsCh chan<- interface{},
+	wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for ev := range eventCh {
+		if ev.Name != "WATCHDOG" {
+			continue
+		}
+
+		action, _ := ev.Data["action"].(string)
+		glog.Warningf("Watchdog of %s fired, action: %s", instance, action)
+		ovsCh <- &ovsWatchdogFired{instance, payloads.WatchdogAction(action)}
+	}
+}
+
 func qmpConnect(qmpChannel chan interface{}, instance, instanceDir string, closedCh chan struct{},
-	connectedCh chan struct{}, wg *sync.WaitGroup, boot bool) {
+	connectedCh chan struct{}, ovsCh chan<- interface{}, wg *sync.WaitGroup, boot bool) {
 
 	var q *qemu.QMP
 	defer func() {
@@ -514,13 +793,17 @@ func qmpConnect(qmpChannel chan interface{}, instance, instanceDir string, close
 	}()
 
 	socket := path.Join(instanceDir, "socket")
-	cfg := qemu.QMPConfig{Logger: qmpGlogLogger{}}
+	eventCh := make(chan qemu.QMPEvent)
+	cfg := qemu.QMPConfig{Logger: qmpGlogLogger{}, EventCh: eventCh}
 	q, ver, err := qemu.QMPStart(context.Background(), socket, cfg, closedCh)
 	if err != nil {
 		glog.Warningf("Failed to connect to QEMU instance %s: %v", instance, err)
 		return
 	}
 
+	wg.Add(1)
+	go qmpEvents(eventCh, instance, ovsCh, wg)
+
 	glog.Infof("Connected to %s.", instance)
 	glog.Infof("QMP version %d.%d.%d", ver.Major, ver.Minor, ver.Micro)
 	glog.Infof("QMP capabilities %s", ver.Capabilities)
@@ -533,12 +816,40 @@ func qmpConnect(qmpChannel chan interface{}, instance, instanceDir string, close
 
 	close(connectedCh)
 
+	// The progress of a migration is polled while other commands keep
+	// being processed.
+
+	var migration virtualizerMigrateCmd
+	var migrationPoll <-chan time.Time
+	var migrationDeadline time.Time
+
 DONE:
 	for {
-		cmd, ok := <-qmpChannel
-		if !ok {
-			break DONE
+		var cmd interface{}
+		var ok bool
+
+		select {
+		case cmd, ok = <-qmpChannel:
+			if !ok {
+				break DONE
+			}
+		case <-migrationPoll:
+			done, err := qmpMigrationDone(migration, instanceDir)
+			if err == nil && !done {
+				if time.Now().Before(migrationDeadline) {
+					migrationPoll = time.After(migrationPollInterval)
+					continue
+				}
+				err = fmt.Errorf("Migration did not complete within %v", migrationTimeout)
+			}
+			if err != nil {
+				qmpMigrationRollback(migration, q, instanceDir)
+			}
+			migration.resultCh <- err
+			migrationPoll = nil
+			continue
 		}
+
 		switch cmd := cmd.(type) {
 		case virtualizerStopCmd:
 			ctx, cancelFN := context.WithTimeout(context.Background(), time.Second*10)
@@ -551,8 +862,25 @@ DONE:
 					glog.Warningf("Failed to execute quit instance: %v", err)
 				}
 			}
+		case virtualizerKillCmd:
+			err = q.ExecuteQuit(context.Background())
+			if err != nil {
+				glog.Warningf("Failed to execute quit instance: %v", err)
+			}
 		case virtualizerAttachCmd:
 			qmpAttach(cmd, q)
+		case virtualizerPauseCmd:
+			qmpPause(cmd, q)
+		case virtualizerBalloonCmd:
+			qmpBalloon(cmd, instanceDir)
+		case virtualizerMigrateCmd:
+			if err := qmpMigrate(cmd, instanceDir); err != nil {
+				cmd.resultCh <- err
+				break
+			}
+			migration = cmd
+			migrationDeadline = time.Now().Add(migrationTimeout)
+			migrationPoll = time.After(migrationPollInterval)
 		}
 	}
 }
@@ -565,11 +893,20 @@ DONE:
    VM instance is running.
 */
 
-func (q *qemuV) monitorVM(closedCh chan struct{}, connectedCh chan struct{},
-	wg *sync.WaitGroup, boot bool) chan interface{} {
+func (q *qemuV) monitorVM(closedCh chan struct{}, connectedCh chan struct{}, readyCh chan struct{},
+	ovsCh chan<- interface{}, wg *sync.WaitGroup, boot bool) chan interface{} {
 	qmpChannel := make(chan interface{})
 	wg.Add(1)
-	go qmpConnect(qmpChannel, q.cfg.Instance, q.instanceDir, closedCh, connectedCh, wg, boot)
+	go qmpConnect(qmpChannel, q.cfg.Instance, q.instanceDir, closedCh, connectedCh, ovsCh, wg, boot)
+
+	// The guests of the instances we reconnect to when the launcher
+	// starts have already booted.
+
+	if !boot {
+		wg.Add(1)
+		go qgaWaitReady(q.cfg.Instance, qgaSocketPath(q.instanceDir), closedCh, readyCh,
+			wg, qgaPollInterval, qgaTimeout)
+	}
 	return qmpChannel
 }
 
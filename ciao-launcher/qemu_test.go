@@ -26,6 +26,8 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/ciao-project/ciao/payloads"
 )
 
 func genQEMUParams(networkParams []string) []string {
@@ -35,7 +37,11 @@ func genQEMUParams(networkParams []string) []string {
 	}
 	baseParams = append(baseParams, networkParams...)
 	baseParams = append(baseParams, "-enable-kvm", "-cpu", "host", "-daemonize",
-		"-qmp", "unix:/var/lib/ciao/instance/1/socket,server,nowait")
+		"-qmp", "unix:/var/lib/ciao/instance/1/socket,server,nowait",
+		"-qmp", "unix:/var/lib/ciao/instance/1/control,server,nowait",
+		"-chardev", "socket,path=/var/lib/ciao/instance/1/qga,server,nowait,id=qga0",
+		"-device", "virtio-serial",
+		"-device", "virtserialport,chardev=qga0,name=org.qemu.guest_agent.0")
 
 	return baseParams
 }
@@ -76,6 +82,100 @@ func TestGenerateQEMULaunchParams(t *testing.T) {
 		t.Fatalf("%s and %s do not match", params, genParams)
 	}
 
+	cfg.Cpus = 0
+	cfg.Instance = "1"
+	cfg.DiskIOPS = 500
+	cfg.Volumes = []volumeConfig{{UUID: "vol1", Bootable: true}}
+	params = []string{
+		"-drive",
+		"file=rbd:rbd/vol1:id=ciao,if=none,id=drive_vol1,format=raw,discard=unmap,detect-zeroes=unmap,throttling.iops-total=500,throttling.group=1",
+		"-device",
+		"virtio-blk-pci,scsi=off,bus=pci.0,addr=0x3,id=device_vol1,drive=drive_vol1",
+	}
+	params = append(params, genQEMUParams(nil)...)
+	genParams = generateQEMULaunchParams(&cfg, "/var/lib/ciao/instance/1/seed.iso",
+		"/var/lib/ciao/instance/1", nil, "ciao")
+	if !reflect.DeepEqual(params, genParams) {
+		t.Fatalf("%s and %s do not match", params, genParams)
+	}
+	cfg.DiskIOPS = 0
+
+	cfg.Volumes = []volumeConfig{{UUID: "vol1", Pool: "ssd"}}
+	params = []string{
+		"-drive",
+		"file=rbd:ssd/vol1:id=ciao,if=none,id=drive_vol1,format=raw,discard=unmap,detect-zeroes=unmap",
+		"-device",
+		"virtio-blk-pci,scsi=off,bus=pci.0,addr=0x3,id=device_vol1,drive=drive_vol1",
+	}
+	params = append(params, genQEMUParams(nil)...)
+	genParams = generateQEMULaunchParams(&cfg, "/var/lib/ciao/instance/1/seed.iso",
+		"/var/lib/ciao/instance/1", nil, "ciao")
+	if !reflect.DeepEqual(params, genParams) {
+		t.Fatalf("%s and %s do not match", params, genParams)
+	}
+
+	cfg.Volumes = []volumeConfig{
+		{UUID: "vol1", Bootable: true, CDROM: true},
+		{UUID: "vol2"},
+	}
+	params = []string{
+		"-drive",
+		"file=rbd:rbd/vol1:id=ciao,if=none,id=drive_vol1,format=raw,media=cdrom,readonly=on",
+		"-device",
+		"ide-cd,id=device_vol1,drive=drive_vol1,bootindex=0",
+		"-drive",
+		"file=rbd:rbd/vol2:id=ciao,if=none,id=drive_vol2,format=raw,discard=unmap,detect-zeroes=unmap",
+		"-device",
+		"virtio-blk-pci,scsi=off,bus=pci.0,addr=0x3,id=device_vol2,drive=drive_vol2",
+	}
+	params = append(params, genQEMUParams(nil)...)
+	genParams = generateQEMULaunchParams(&cfg, "/var/lib/ciao/instance/1/seed.iso",
+		"/var/lib/ciao/instance/1", nil, "ciao")
+	if !reflect.DeepEqual(params, genParams) {
+		t.Fatalf("%s and %s do not match", params, genParams)
+	}
+
+	cfg.Volumes = []volumeConfig{
+		{UUID: "rescue", Bootable: true, Rescue: true},
+		{UUID: "vol1", Bootable: true, CDROM: true},
+		{UUID: "vol2", Bootable: true},
+	}
+	params = []string{
+		"-drive",
+		"file=rbd:rbd/rescue:id=ciao,if=none,id=drive_rescue,format=raw,discard=unmap,detect-zeroes=unmap",
+		"-device",
+		"virtio-blk-pci,scsi=off,bus=pci.0,addr=0x3,id=device_rescue,drive=drive_rescue,bootindex=0",
+		"-drive",
+		"file=rbd:rbd/vol1:id=ciao,if=none,id=drive_vol1,format=raw,media=cdrom,readonly=on",
+		"-device",
+		"ide-cd,id=device_vol1,drive=drive_vol1",
+		"-drive",
+		"file=rbd:rbd/vol2:id=ciao,if=none,id=drive_vol2,format=raw,discard=unmap,detect-zeroes=unmap",
+		"-device",
+		"virtio-blk-pci,scsi=off,bus=pci.0,addr=0x4,id=device_vol2,drive=drive_vol2",
+	}
+	params = append(params, genQEMUParams(nil)...)
+	genParams = generateQEMULaunchParams(&cfg, "/var/lib/ciao/instance/1/seed.iso",
+		"/var/lib/ciao/instance/1", nil, "ciao")
+	if !reflect.DeepEqual(params, genParams) {
+		t.Fatalf("%s and %s do not match", params, genParams)
+	}
+
+	cfg.Volumes = []volumeConfig{{UUID: "vol1", Encrypted: true}}
+	params = []string{
+		"-drive",
+		"file=/dev/mapper/ciao-vol1,if=none,id=drive_vol1,format=raw,discard=unmap,detect-zeroes=unmap",
+		"-device",
+		"virtio-blk-pci,scsi=off,bus=pci.0,addr=0x3,id=device_vol1,drive=drive_vol1",
+	}
+	params = append(params, genQEMUParams(nil)...)
+	genParams = generateQEMULaunchParams(&cfg, "/var/lib/ciao/instance/1/seed.iso",
+		"/var/lib/ciao/instance/1", nil, "ciao")
+	if !reflect.DeepEqual(params, genParams) {
+		t.Fatalf("%s and %s do not match", params, genParams)
+	}
+	cfg.Volumes = nil
+
 	netParams := []string{"-net", "nic,model=virtio", "-net", "user"}
 	params = genQEMUParams(netParams)
 	cfg.Mem = 0
@@ -86,6 +186,27 @@ func TestGenerateQEMULaunchParams(t *testing.T) {
 	if !reflect.DeepEqual(params, genParams) {
 		t.Fatalf("%s and %s do not match", params, genParams)
 	}
+
+	cfg.Watchdog = payloads.WatchdogReset
+	cfg.Balloon = true
+	params = []string{
+		"-drive",
+		"file=/var/lib/ciao/instance/1/seed.iso,if=virtio,media=cdrom",
+		"-device", "virtio-balloon-pci",
+		"-device", "i6300esb",
+		"-watchdog-action", "reset",
+		"-enable-kvm", "-cpu", "host", "-daemonize",
+		"-qmp", "unix:/var/lib/ciao/instance/1/socket,server,nowait",
+		"-qmp", "unix:/var/lib/ciao/instance/1/control,server,nowait",
+		"-chardev", "socket,path=/var/lib/ciao/instance/1/qga,server,nowait,id=qga0",
+		"-device", "virtio-serial",
+		"-device", "virtserialport,chardev=qga0,name=org.qemu.guest_agent.0",
+	}
+	genParams = generateQEMULaunchParams(&cfg, "/var/lib/ciao/instance/1/seed.iso",
+		"/var/lib/ciao/instance/1", nil, "ciao")
+	if !reflect.DeepEqual(params, genParams) {
+		t.Fatalf("%s and %s do not match", params, genParams)
+	}
 }
 
 func TestQmpConnectBadSocket(t *testing.T) {
@@ -95,9 +216,10 @@ func TestQmpConnectBadSocket(t *testing.T) {
 	connectedCh := make(chan struct{})
 	instance := "testInstance"
 	instanceDir := path.Join("/tmp", instance)
+	var ovsCh chan<- interface{}
 
 	wg.Add(1)
-	go qmpConnect(qmpChannel, instance, instanceDir, closedCh, connectedCh, &wg, false)
+	go qmpConnect(qmpChannel, instance, instanceDir, closedCh, connectedCh, ovsCh, &wg, false)
 	wg.Wait()
 	select {
 	case <-closedCh:
@@ -106,7 +228,8 @@ func TestQmpConnectBadSocket(t *testing.T) {
 	}
 }
 
-func setupQmpSocket(t *testing.T, runTest func(net.Conn, *bufio.Scanner, chan interface{}, *testing.T) bool) {
+func setupQmpSocket(t *testing.T, ovsCh chan<- interface{},
+	runTest func(net.Conn, *bufio.Scanner, chan interface{}, *testing.T) bool) {
 	var wg sync.WaitGroup
 	qmpChannel := make(chan interface{})
 	closedCh := make(chan struct{})
@@ -129,7 +252,7 @@ func setupQmpSocket(t *testing.T, runTest func(net.Conn, *bufio.Scanner, chan in
 	}
 	defer ln.Close()
 	wg.Add(1)
-	go qmpConnect(qmpChannel, instance, instanceDir, closedCh, connectedCh, &wg, false)
+	go qmpConnect(qmpChannel, instance, instanceDir, closedCh, connectedCh, ovsCh, &wg, false)
 	fd, err := ln.Accept()
 	if err != nil {
 		t.Fatalf("Unable to accept client %v", err)
@@ -175,13 +298,13 @@ func setupQmpSocket(t *testing.T, runTest func(net.Conn, *bufio.Scanner, chan in
 }
 
 func TestQmpConnect(t *testing.T) {
-	setupQmpSocket(t, func(fd net.Conn, sc *bufio.Scanner, qmpChannel chan interface{}, t *testing.T) bool {
+	setupQmpSocket(t, nil, func(fd net.Conn, sc *bufio.Scanner, qmpChannel chan interface{}, t *testing.T) bool {
 		return true
 	})
 }
 
 func TestQmpShutdown(t *testing.T) {
-	setupQmpSocket(t, func(fd net.Conn, sc *bufio.Scanner, qmpChannel chan interface{}, t *testing.T) bool {
+	setupQmpSocket(t, nil, func(fd net.Conn, sc *bufio.Scanner, qmpChannel chan interface{}, t *testing.T) bool {
 		qmpChannel <- virtualizerStopCmd{}
 		if !sc.Scan() {
 			t.Fatalf("power down command expected")
@@ -198,7 +321,7 @@ func TestQmpShutdown(t *testing.T) {
 }
 
 func TestQmpLost(t *testing.T) {
-	setupQmpSocket(t, func(fd net.Conn, sc *bufio.Scanner, qmpChannel chan interface{}, t *testing.T) bool {
+	setupQmpSocket(t, nil, func(fd net.Conn, sc *bufio.Scanner, qmpChannel chan interface{}, t *testing.T) bool {
 		qmpChannel <- virtualizerStopCmd{}
 		if !sc.Scan() {
 			t.Fatalf("power down command expected")
@@ -208,3 +331,50 @@ func TestQmpLost(t *testing.T) {
 		return false
 	})
 }
+
+func TestQmpWatchdog(t *testing.T) {
+	ovsCh := make(chan interface{}, 1)
+	setupQmpSocket(t, ovsCh, func(fd net.Conn, sc *bufio.Scanner, qmpChannel chan interface{}, t *testing.T) bool {
+		_, err := fmt.Fprintln(fd, `{"timestamp": {"seconds": 1487084520, "microseconds": 332329}, "event": "WATCHDOG", "data": {"action": "reset"}}`)
+		if err != nil {
+			t.Fatalf("Unable to write to domain socket: %v", err)
+		}
+
+		select {
+		case cmd := <-ovsCh:
+			fired, ok := cmd.(*ovsWatchdogFired)
+			if !ok || fired.instance != "testInstance" || fired.action != payloads.WatchdogReset {
+				t.Errorf("Unexpected overseer command %+v", cmd)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Timed out waiting for watchdog event")
+		}
+
+		return true
+	})
+}
+
+// Check that qmpRecordingLogger records the last messages it logs.
+//
+// Log more than maxDiagnosticLogLines messages at different levels.
+//
+// Only the last maxDiagnosticLogLines messages should be recorded, in the
+// order in which they were logged.
+func TestQmpRecordingLogger(t *testing.T) {
+	var l qmpRecordingLogger
+
+	for i := 0; i < maxDiagnosticLogLines; i++ {
+		l.Infof("message %d", i)
+	}
+	l.Warningf("warning")
+	l.Errorf("error %s", "exit status 1")
+
+	if len(l.lines) != maxDiagnosticLogLines {
+		t.Fatalf("Expected %d messages, got %d", maxDiagnosticLogLines, len(l.lines))
+	}
+
+	if l.lines[0] != "message 2" || l.lines[maxDiagnosticLogLines-2] != "warning" ||
+		l.lines[maxDiagnosticLogLines-1] != "error exit status 1" {
+		t.Errorf("Unexpected messages %v", l.lines)
+	}
+}
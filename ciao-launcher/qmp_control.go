@@ -0,0 +1,162 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path"
+	"time"
+)
+
+// The QMP commands which are not supported by the vendored qemu package,
+// i.e., the ballooning and migration commands, are sent through a second
+// QMP monitor, the control socket.  The monitor of the qemu package cannot
+// be shared as it reads all the replies sent through its connection.
+
+const (
+	qmpControlSocketName = "control"
+	qmpControlTimeout    = 10 * time.Second
+)
+
+func qmpControlSocketPath(instanceDir string) string {
+	return path.Join(instanceDir, qmpControlSocketName)
+}
+
+type qmpStatusInfo struct {
+	Running    bool   `json:"running"`
+	SingleStep bool   `json:"singlestep"`
+	Status     string `json:"status"`
+}
+
+type qmpMigrationRAM struct {
+	Total       int64 `json:"total"`
+	Remaining   int64 `json:"remaining"`
+	Transferred int64 `json:"transferred"`
+}
+
+type qmpMigrationStatus struct {
+	Status    string          `json:"status"`
+	ErrorDesc string          `json:"error-desc,omitempty"`
+	RAM       qmpMigrationRAM `json:"ram,omitempty"`
+}
+
+type qmpReply struct {
+	Event  string           `json:"event"`
+	Return *json.RawMessage `json:"return"`
+	Error  *qgaError        `json:"error"`
+}
+
+// qmpControlClient is a connection to the control socket of an instance.
+type qmpControlClient struct {
+	conn net.Conn
+	dec  *json.Decoder
+}
+
+// qmpControlDial connects to the QMP monitor listening on socket and
+// negotiates its capabilities.  All the commands executed on the connection
+// must complete within qmpControlTimeout.
+func qmpControlDial(socket string) (*qmpControlClient, error) {
+	conn, err := net.DialTimeout("unix", socket, qmpControlTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.SetDeadline(time.Now().Add(qmpControlTimeout))
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	c := &qmpControlClient{conn, json.NewDecoder(conn)}
+
+	var greeting struct {
+		QMP *json.RawMessage `json:"QMP"`
+	}
+	err = c.dec.Decode(&greeting)
+	if err == nil && greeting.QMP == nil {
+		err = fmt.Errorf("Unexpected QMP greeting")
+	}
+	if err == nil {
+		err = c.execute("qmp_capabilities", nil, nil)
+	}
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *qmpControlClient) close() {
+	_ = c.conn.Close()
+}
+
+// execute runs a QMP command and stores its return value in ret.  The
+// events received before the reply are discarded, they are reported to the
+// monitor of the qemu package.
+func (c *qmpControlClient) execute(command string, args interface{}, ret interface{}) error {
+	cmd := struct {
+		Execute   string      `json:"execute"`
+		Arguments interface{} `json:"arguments,omitempty"`
+	}{command, args}
+
+	err := json.NewEncoder(c.conn).Encode(&cmd)
+	if err != nil {
+		return err
+	}
+
+	var reply qmpReply
+	for {
+		reply = qmpReply{}
+		err = c.dec.Decode(&reply)
+		if err != nil {
+			return err
+		}
+
+		if reply.Event == "" {
+			break
+		}
+	}
+
+	if reply.Error != nil {
+		return fmt.Errorf("%s failed, %s: %s", command, reply.Error.Class, reply.Error.Desc)
+	}
+
+	if reply.Return == nil {
+		return fmt.Errorf("Unexpected reply to %s", command)
+	}
+
+	if ret == nil {
+		return nil
+	}
+
+	return json.Unmarshal(*reply.Return, ret)
+}
+
+// qmpControlExecute runs a single QMP command through the control socket of
+// the instance.
+func qmpControlExecute(instanceDir, command string, args interface{}, ret interface{}) error {
+	c, err := qmpControlDial(qmpControlSocketPath(instanceDir))
+	if err != nil {
+		return fmt.Errorf("Unable to connect to QMP control socket: %v", err)
+	}
+	defer c.close()
+
+	return c.execute(command, args, ret)
+}
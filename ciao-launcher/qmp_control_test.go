@@ -0,0 +1,124 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runQmpControlServer serves a single connection to the control socket of
+// an instance in instanceDir, checking that the commands received after the
+// capabilities negotiation contain the expected JSON fragments and sending
+// the matching replies.
+func runQmpControlServer(t *testing.T, instanceDir string, commands []string,
+	replies []string) chan error {
+	ln, err := net.Listen("unix", qmpControlSocketPath(instanceDir))
+	if err != nil {
+		t.Fatalf("Unable to open domain socket: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		defer ln.Close()
+
+		conn, err := ln.Accept()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		const qmpHello = `{ "QMP": { "version": { "qemu": { "micro": 0, "minor": 5, "major": 2}, "package": ""}, "capabilities": []}}`
+		commands = append([]string{`"execute":"qmp_capabilities"`}, commands...)
+		replies = append([]string{`{ "return": {}}`}, replies...)
+
+		_, err = fmt.Fprintln(conn, qmpHello)
+		sc := bufio.NewScanner(conn)
+		for i := 0; err == nil && i < len(commands); i++ {
+			if !sc.Scan() {
+				err = fmt.Errorf("%s not received", commands[i])
+				break
+			}
+			if !strings.Contains(sc.Text(), commands[i]) {
+				err = fmt.Errorf("Expected %s, received %s", commands[i], sc.Text())
+				break
+			}
+			_, err = fmt.Fprintln(conn, replies[i])
+		}
+		errCh <- err
+	}()
+
+	return errCh
+}
+
+// Check that commands are sent through the QMP control socket
+//
+// Sends a balloon command to a fake control socket, then queries the
+// migration status of the instance while events are pending, and runs a
+// command which fails.
+//
+// The balloon command should be received with the balloon size in bytes,
+// the events should be skipped, the migration status should be parsed and
+// the failure of the last command should be reported.
+func TestQmpControlExecute(t *testing.T) {
+	instanceDir, err := ioutil.TempDir("", "qmp-control")
+	if err != nil {
+		t.Fatalf("Unable to create instance directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(instanceDir) }()
+
+	errCh := runQmpControlServer(t, instanceDir, []string{`"execute":"balloon","arguments":{"value":536870912}`}, []string{`{ "return": {}}`})
+	responseCh := make(chan error, 1)
+	qmpBalloon(virtualizerBalloonCmd{sizeMB: 512, responseCh: responseCh}, instanceDir)
+	if err := <-responseCh; err != nil {
+		t.Errorf("Balloon command failed: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Errorf("Unexpected balloon command: %v", err)
+	}
+
+	errCh = runQmpControlServer(t, instanceDir, []string{`"execute":"query-migrate"`}, []string{
+		`{ "event": "STOP", "timestamp": { "seconds": 1, "microseconds": 0}}` + "\n" +
+			`{ "return": { "status": "active", "ram": { "total": 100, "remaining": 40, "transferred": 60}}}`,
+	})
+	done, err := qmpMigrationDone(virtualizerMigrateCmd{uri: "tcp:192.168.0.1:5000"}, instanceDir)
+	if done || err != nil {
+		t.Errorf("Expected active migration, got %t, %v", done, err)
+	}
+	if err := <-errCh; err != nil {
+		t.Errorf("Unexpected migration query: %v", err)
+	}
+
+	errCh = runQmpControlServer(t, instanceDir, []string{`"execute":"migrate","arguments":{"uri":"tcp:192.168.0.1:5000"}`}, []string{
+		`{ "error": { "class": "GenericError", "desc": "migration already in progress"}}`,
+	})
+	err = qmpMigrate(virtualizerMigrateCmd{uri: "tcp:192.168.0.1:5000"}, instanceDir)
+	if err == nil || !strings.Contains(err.Error(), "migration already in progress") {
+		t.Errorf("Expected migration failure, got %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Errorf("Unexpected migrate command: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+
+	"github.com/ciao-project/ciao/networking/libsnnet"
+	"github.com/golang/glog"
+)
+
+// processRelaunch boots the VM of an instance, which must have been powered
+// off, with the volumes of cfg.  The vnic of the instance is recreated as
+// the tap file descriptors it provides are needed to launch the VM again.
+// Containers keep their docker network endpoint and are simply started
+// again.  cfg is saved in the instance directory so that the instance keeps
+// its volumes across launcher restarts.
+func processRelaunch(vm virtualizer, instanceDir string, cfg *vmConfig, conn serverConn) error {
+	var vnicName string
+	var vnicCfg *libsnnet.VnicConfig
+	var fds []*os.File
+	var err error
+
+	if networking && !cfg.Container {
+		vnicCfg, err = createVnicCfg(cfg)
+		if err != nil {
+			return err
+		}
+
+		err = destroyVnic(conn, vnicCfg)
+		if err != nil {
+			glog.Warningf("Unable to destroy vnic: %s", err)
+		}
+
+		vnicName, _, _, fds, err = createVnic(conn, vnicCfg)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			for _, f := range fds {
+				_ = f.Close()
+			}
+		}()
+	}
+
+	err = cfg.save(instanceDir)
+	if err != nil {
+		return err
+	}
+
+	vm.init(cfg, instanceDir)
+
+	return vm.startVM(vnicName, getNodeIPAddress(), cephID, fds)
+}
@@ -0,0 +1,60 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import "sync"
+
+// commandSequences holds the sequence of the last command accepted for each
+// instance.  The scheduler may deliver commands sent in quick succession for
+// the same instance, e.g., STOP and DELETE, out of order, in which case the
+// command sent first is stale once it arrives and must be ignored.
+// The SSNTP client calls CommandNotify from a new goroutine for each frame,
+// so commandSequences must be safe for concurrent use.
+type commandSequences struct {
+	sync.Mutex
+	last map[string]uint64
+}
+
+// accept returns false if a command is stale, i.e., if its sequence is not
+// greater than the one of the last command accepted for the instance.
+// Unsequenced commands are always accepted.
+func (cs *commandSequences) accept(instance string, sequence uint64) bool {
+	if sequence == 0 {
+		return true
+	}
+
+	cs.Lock()
+	defer cs.Unlock()
+
+	if cs.last == nil {
+		cs.last = make(map[string]uint64)
+	}
+
+	if sequence <= cs.last[instance] {
+		return false
+	}
+
+	cs.last[instance] = sequence
+	return true
+}
+
+// forget drops the sequence of a deleted instance.
+func (cs *commandSequences) forget(instance string) {
+	cs.Lock()
+	delete(cs.last, instance)
+	cs.Unlock()
+}
@@ -0,0 +1,85 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Test that command sequences can be checked concurrently
+//
+// Accepts the sequences of the commands of several instances from
+// concurrent goroutines, as CommandNotify does, while forgetting another
+// instance.
+//
+// Each sequence should be accepted at most once per instance, the highest
+// sequence of each instance should always be accepted and should be the
+// last sequence recorded for the instance.  The test should pass under
+// -race.
+func TestCommandSequencesConcurrent(t *testing.T) {
+	const instances = 4
+	const sequences = 100
+
+	var cs commandSequences
+	var accepted [instances][sequences + 1]int32
+
+	var wg sync.WaitGroup
+	for i := 0; i < instances; i++ {
+		for s := 1; s <= sequences; s++ {
+			wg.Add(1)
+			go func(i, s int) {
+				defer wg.Done()
+				if cs.accept(fmt.Sprintf("instance-%d", i), uint64(s)) {
+					atomic.AddInt32(&accepted[i][s], 1)
+				}
+			}(i, s)
+		}
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for s := 1; s <= sequences; s++ {
+			cs.accept("deleted", uint64(s))
+			cs.forget("deleted")
+		}
+	}()
+	wg.Wait()
+
+	for i := 0; i < instances; i++ {
+		for s := 1; s <= sequences; s++ {
+			if accepted[i][s] > 1 {
+				t.Errorf("Sequence %d of instance %d accepted %d times", s, i, accepted[i][s])
+			}
+		}
+
+		if accepted[i][sequences] != 1 {
+			t.Errorf("Last sequence of instance %d not accepted", i)
+		}
+
+		if last := cs.last[fmt.Sprintf("instance-%d", i)]; last != sequences {
+			t.Errorf("Expected last sequence %d for instance %d, found %d", sequences, i, last)
+		}
+	}
+
+	if _, ok := cs.last["deleted"]; ok {
+		t.Errorf("Sequence of deleted instance not forgotten")
+	}
+}
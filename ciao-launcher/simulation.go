@@ -30,6 +30,7 @@ type simulation struct {
 
 	closedCh    chan struct{}
 	connectedCh chan struct{}
+	readyCh     chan struct{}
 	killCh      chan struct{}
 	monitorCh   chan interface{}
 	wg          *sync.WaitGroup
@@ -79,11 +80,24 @@ VM:
 			if _, stopCmd := cmd.(virtualizerStopCmd); stopCmd {
 				break VM
 			}
+			if _, killCmd := cmd.(virtualizerKillCmd); killCmd {
+				break VM
+			}
+			if pauseCmd, ok := cmd.(virtualizerPauseCmd); ok {
+				pauseCmd.responseCh <- nil
+			}
+			if balloonCmd, ok := cmd.(virtualizerBalloonCmd); ok {
+				balloonCmd.responseCh <- nil
+			}
+			if migrateCmd, ok := cmd.(virtualizerMigrateCmd); ok {
+				migrateCmd.resultCh <- nil
+			}
 		case <-s.killCh:
 			break VM
 		case <-ticker.C:
 			ticker.Stop()
 			close(s.connectedCh)
+			close(s.readyCh)
 			ticker.C = nil
 		}
 	}
@@ -102,10 +116,11 @@ func (s *simulation) startVM(vnicName, ipAddress, cephID string, fds []*os.File)
 	return nil
 }
 
-func (s *simulation) monitorVM(closedCh chan struct{}, connectedCh chan struct{}, wg *sync.WaitGroup, boot bool) chan interface{} {
+func (s *simulation) monitorVM(closedCh chan struct{}, connectedCh chan struct{}, readyCh chan struct{}, ovsCh chan<- interface{}, wg *sync.WaitGroup, boot bool) chan interface{} {
 	glog.Infof("monitorVM\n")
 	s.closedCh = closedCh
 	s.connectedCh = connectedCh
+	s.readyCh = readyCh
 	s.wg = wg
 
 	s.monitorCh = make(chan interface{})
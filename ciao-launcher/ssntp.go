@@ -17,6 +17,7 @@
 package main
 
 import (
+	"io"
 	"sync"
 	"time"
 
@@ -39,6 +40,7 @@ type restoreCmd struct{}
 type serverConn interface {
 	SendError(error ssntp.Error, payload []byte) (int, error)
 	SendEvent(event ssntp.Event, payload []byte) (int, error)
+	SendEventStream(event ssntp.Event, payload io.Reader) (int, error)
 	Dial(config *ssntp.Config, ntf ssntp.ClientNotifier) error
 	SendStatus(status ssntp.Status, payload []byte) (int, error)
 	SendCommand(cmd ssntp.Command, payload []byte) (int, error)
@@ -75,8 +77,9 @@ func (s *ssntpConn) setStatus(status bool) {
 // server.  It also implements the ssntp.ClientNotifier interface and so
 // can be passed to serverConn.Dial.
 type agentClient struct {
-	conn  serverConn
-	cmdCh chan *cmdWrapper
+	conn      serverConn
+	cmdCh     chan *cmdWrapper
+	sequences commandSequences
 }
 
 func (client *agentClient) DisconnectNotify() {
@@ -94,6 +97,18 @@ func (client *agentClient) StatusNotify(status ssntp.Status, frame *ssntp.Frame)
 	glog.Infof("STATUS %s", status)
 }
 
+// staleCommand returns true if an instance command was sent before the last
+// command accepted for the instance, in which case it is ignored.
+func (client *agentClient) staleCommand(cmd ssntp.Command, instance string, payload []byte) bool {
+	sequence := parseCommandSequence(payload)
+	if client.sequences.accept(instance, sequence) {
+		return false
+	}
+
+	glog.Warningf("Ignoring stale %s command %d for instance %s", cmd, sequence, instance)
+	return true
+}
+
 func (client *agentClient) CommandNotify(cmd ssntp.Command, frame *ssntp.Frame) {
 	payload := frame.Payload
 
@@ -123,6 +138,12 @@ func (client *agentClient) CommandNotify(cmd ssntp.Command, frame *ssntp.Frame)
 			glog.Errorf("Unable to parse YAML: %s", payloadErr.err)
 			return
 		}
+		if client.staleCommand(cmd, instance, payload) {
+			return
+		}
+		if !stop {
+			client.sequences.forget(instance)
+		}
 		client.cmdCh <- &cmdWrapper{instance, &insDeleteCmd{stop: stop}}
 	case ssntp.AttachVolume:
 		instance, volume, payloadErr := parseAttachVolumePayload(payload)
@@ -136,6 +157,85 @@ func (client *agentClient) CommandNotify(cmd ssntp.Command, frame *ssntp.Frame)
 			return
 		}
 		client.cmdCh <- &cmdWrapper{instance, &insAttachVolumeCmd{volume}}
+	case ssntp.PAUSE, ssntp.UNPAUSE:
+		pause := cmd == ssntp.PAUSE
+		instance, err := parsePausePayload(payload, pause)
+		if err != nil {
+			glog.Errorf("Unable to parse %s YAML: %v", cmd, err)
+			return
+		}
+		if client.staleCommand(cmd, instance, payload) {
+			return
+		}
+		client.cmdCh <- &cmdWrapper{instance, &insPauseCmd{pause}}
+	case ssntp.RESCUE:
+		instance, volume, err := parseRescuePayload(payload)
+		if err != nil {
+			glog.Errorf("Unable to parse %s YAML: %v", cmd, err)
+			return
+		}
+		if client.staleCommand(cmd, instance, payload) {
+			return
+		}
+		client.cmdCh <- &cmdWrapper{instance, &insRescueCmd{volume}}
+	case ssntp.UNRESCUE:
+		instance, err := parseUnrescuePayload(payload)
+		if err != nil {
+			glog.Errorf("Unable to parse %s YAML: %v", cmd, err)
+			return
+		}
+		if client.staleCommand(cmd, instance, payload) {
+			return
+		}
+		client.cmdCh <- &cmdWrapper{instance, &insRescueCmd{nil}}
+	case ssntp.REBOOT:
+		instance, reboot, err := parseRebootPayload(payload)
+		if err != nil {
+			glog.Errorf("Unable to parse %s YAML: %v", cmd, err)
+			return
+		}
+		if client.staleCommand(cmd, instance, payload) {
+			return
+		}
+		client.cmdCh <- &cmdWrapper{instance, reboot}
+	case ssntp.UpdateMetadata:
+		instance, update, err := parseUpdateMetadataPayload(payload)
+		if err != nil {
+			glog.Errorf("Unable to parse %s YAML: %v", cmd, err)
+			return
+		}
+		if client.staleCommand(cmd, instance, payload) {
+			return
+		}
+		client.cmdCh <- &cmdWrapper{instance, update}
+	case ssntp.CONSOLE:
+		instance, console, err := parseConsolePayload(payload)
+		if err != nil {
+			glog.Errorf("Unable to parse %s YAML: %v", cmd, err)
+			return
+		}
+		client.cmdCh <- &cmdWrapper{instance, console}
+	case ssntp.GUESTAGENT:
+		instance, guestAgent, err := parseGuestAgentPayload(payload)
+		if err != nil {
+			glog.Errorf("Unable to parse %s YAML: %v", cmd, err)
+			return
+		}
+		client.cmdCh <- &cmdWrapper{instance, guestAgent}
+	case ssntp.CAPTURE:
+		instance, capture, err := parsePacketCapturePayload(payload)
+		if err != nil {
+			glog.Errorf("Unable to parse %s YAML: %v", cmd, err)
+			return
+		}
+		client.cmdCh <- &cmdWrapper{instance, capture}
+	case ssntp.MIGRATE:
+		instance, migrate, err := parseMigratePayload(payload)
+		if err != nil {
+			glog.Errorf("Unable to parse %s YAML: %v", cmd, err)
+			return
+		}
+		client.cmdCh <- &cmdWrapper{instance, migrate}
 	case ssntp.EVACUATE:
 		client.cmdCh <- &cmdWrapper{"", &evacuateCmd{}}
 	case ssntp.Restore:
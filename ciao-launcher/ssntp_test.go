@@ -17,6 +17,7 @@
 package main
 
 import (
+	"io"
 	"sync"
 	"testing"
 	"time"
@@ -42,6 +43,10 @@ func (v *ssntpTestState) SendEvent(event ssntp.Event, payload []byte) (int, erro
 	return 0, nil
 }
 
+func (v *ssntpTestState) SendEventStream(event ssntp.Event, payload io.Reader) (int, error) {
+	return 0, nil
+}
+
 func (v *ssntpTestState) Dial(config *ssntp.Config, ntf ssntp.ClientNotifier) error {
 	return nil
 }
@@ -242,6 +247,35 @@ func TestAgentClientDelete(t *testing.T) {
 	checkErrorPayload(t, &ac, state, ssntp.DELETE, ssntp.DeleteFailure)
 }
 
+// Verify that the agentClient ignores stale instance commands
+//
+// Send a sequenced STOP command, a PAUSE command with a lower sequence and
+// an unsequenced PAUSE command to the agent client.
+//
+// The STOP command and the unsequenced PAUSE command should be received on
+// the agent's cmdCh.  The stale PAUSE command should be ignored.
+func TestAgentClientStaleCommand(t *testing.T) {
+	state := &ssntpTestState{}
+	cmdCh := make(chan *cmdWrapper, 3)
+	ac := agentClient{conn: state, cmdCh: cmdCh}
+
+	ac.CommandNotify(ssntp.DELETE, &ssntp.Frame{Payload: []byte(testutil.SequencedStopYaml)})
+	ac.CommandNotify(ssntp.PAUSE, &ssntp.Frame{Payload: []byte(testutil.SequencedPauseYaml)})
+	ac.CommandNotify(ssntp.PAUSE, &ssntp.Frame{Payload: []byte(testutil.PauseYaml)})
+
+	if len(cmdCh) != 2 {
+		t.Fatalf("Expected 2 commands, found %d", len(cmdCh))
+	}
+
+	if del, ok := (<-cmdCh).cmd.(*insDeleteCmd); !ok || !del.stop {
+		t.Errorf("Unexpected command received.  Expected stop command")
+	}
+
+	if _, ok := (<-cmdCh).cmd.(*insPauseCmd); !ok {
+		t.Errorf("Unexpected command received.  Expected pauseCmd")
+	}
+}
+
 // Verify that the agentClient correctly processes ssntp.AttachVolume
 //
 // Send the ssntp.AttachVolume command to the agent client with a valid payload,
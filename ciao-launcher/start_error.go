@@ -22,12 +22,65 @@ import (
 	"github.com/golang/glog"
 )
 
+const (
+	// maxDiagnosticOutput is the number of bytes of the error output
+	// of a failed launch, and of each log message, reported in start
+	// failures.
+	maxDiagnosticOutput = 4096
+
+	// maxDiagnosticLogLines is the number of log messages reported in
+	// start failures.
+	maxDiagnosticLogLines = 20
+)
+
 type startError struct {
 	err     error
 	code    payloads.StartFailureReason
 	restart bool
 }
 
+// launchFailure is returned by the virtualizers which fail to launch an
+// instance, along with what they know about the failure.
+type launchFailure struct {
+	err         error
+	commandLine []string
+	stderr      string
+	log         []string
+}
+
+func (lf *launchFailure) Error() string {
+	return lf.err.Error()
+}
+
+// diagnostics returns the details of the start failure reported to the
+// controller, or nil if there are none.
+func (se *startError) diagnostics() *payloads.StartFailureDiagnostics {
+	if se.err == nil {
+		return nil
+	}
+
+	d := &payloads.StartFailureDiagnostics{
+		Error: se.err.Error(),
+	}
+
+	if lf, ok := se.err.(*launchFailure); ok {
+		d.CommandLine = lf.commandLine
+		d.Stderr = outputTail(lf.stderr)
+		d.Log = lf.log
+	}
+
+	return d
+}
+
+// outputTail returns the last maxDiagnosticOutput bytes of output.
+func outputTail(output string) string {
+	if len(output) <= maxDiagnosticOutput {
+		return output
+	}
+
+	return output[len(output)-maxDiagnosticOutput:]
+}
+
 func (se *startError) send(conn serverConn, instance string) {
 	if !conn.isConnected() {
 		return
@@ -0,0 +1,137 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// When systemd does not supervise the launcher with a watchdog, the
+// connection status of the launcher is still reported every
+// sdStatusPeriod.
+const sdStatusPeriod = 10 * time.Second
+
+// sdNotify sends a state notification, e.g., READY=1, to systemd.  It
+// does nothing when the launcher is not started by a Type=notify service.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	// abstract socket names start with a NUL byte.
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdWatchdogInterval returns the watchdog timeout systemd enforces on the
+// launcher, or 0 if the watchdog is disabled.
+func sdWatchdogInterval() time.Duration {
+	pid := os.Getenv("WATCHDOG_PID")
+	if pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+// sdCheckConnection decides whether the watchdog should be pinged given
+// the time the launcher was last connected to the scheduler.  Launchers
+// disconnected for longer than disconnectTimeout are degraded and stop
+// pinging the watchdog, so that systemd restarts them.
+func sdCheckConnection(connected bool, lastConnected time.Time, now time.Time,
+	disconnectTimeout time.Duration) (ping bool, status string) {
+	if connected {
+		return true, "Connected to scheduler"
+	}
+
+	if disconnectTimeout > 0 && now.Sub(lastConnected) > disconnectTimeout {
+		return false, fmt.Sprintf("Degraded: disconnected from scheduler since %s",
+			lastConnected.Format(time.RFC3339))
+	}
+
+	return true, "Disconnected from scheduler"
+}
+
+// sdMonitor pings the systemd watchdog and reports the connection status
+// of the launcher to systemd until doneCh is closed.
+func sdMonitor(doneCh chan struct{}, conn serverConn) {
+	period := sdStatusPeriod
+	watchdog := sdWatchdogInterval()
+	if watchdog > 0 {
+		period = watchdog / 2
+		glog.Infof("systemd watchdog enabled, timeout %v", watchdog)
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	lastConnected := time.Now()
+	lastStatus := ""
+	for {
+		select {
+		case <-doneCh:
+			return
+		case now := <-ticker.C:
+			connected := conn.isConnected()
+			if connected {
+				lastConnected = now
+			}
+
+			ping, status := sdCheckConnection(connected, lastConnected, now, disconnectTimeout)
+			state := ""
+			if status != lastStatus {
+				if !ping {
+					glog.Errorf("%s, no longer pinging the systemd watchdog", status)
+				}
+				state = "STATUS=" + status + "\n"
+				lastStatus = status
+			}
+			if ping && watchdog > 0 {
+				state += "WATCHDOG=1\n"
+			}
+			if state == "" {
+				continue
+			}
+
+			if err := sdNotify(state); err != nil {
+				glog.Warningf("Unable to notify systemd: %v", err)
+			}
+		}
+	}
+}
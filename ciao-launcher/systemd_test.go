@@ -0,0 +1,118 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Checks that sdNotify sends its state to the socket named by
+// NOTIFY_SOCKET.
+//
+// The state written should be read from the socket.
+func TestSdNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemd_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	socket := path.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	defer func() { _ = os.Unsetenv("NOTIFY_SOCKET") }()
+	if err := os.Setenv("NOTIFY_SOCKET", socket); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sdNotify("READY=1"); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 64)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf[:n]) != "READY=1" {
+		t.Errorf("Unexpected notification %q", string(buf[:n]))
+	}
+}
+
+// Checks that the watchdog interval is read from the environment.
+//
+// The interval should be 0 without WATCHDOG_USEC or when WATCHDOG_PID
+// names another process.
+func TestSdWatchdogInterval(t *testing.T) {
+	defer func() {
+		_ = os.Unsetenv("WATCHDOG_USEC")
+		_ = os.Unsetenv("WATCHDOG_PID")
+	}()
+
+	if i := sdWatchdogInterval(); i != 0 {
+		t.Errorf("Unexpected watchdog interval %v", i)
+	}
+
+	_ = os.Setenv("WATCHDOG_USEC", "30000000")
+	_ = os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if i := sdWatchdogInterval(); i != 30*time.Second {
+		t.Errorf("Expected 30s watchdog interval, got %v", i)
+	}
+
+	_ = os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if i := sdWatchdogInterval(); i != 0 {
+		t.Errorf("Unexpected watchdog interval %v for another process", i)
+	}
+}
+
+// Checks that launchers disconnected for too long stop pinging the
+// watchdog.
+//
+// The watchdog should be pinged while connected and while disconnected
+// for less than the timeout, and not afterwards.
+func TestSdCheckConnection(t *testing.T) {
+	now := time.Now()
+	timeout := 5 * time.Minute
+
+	if ping, _ := sdCheckConnection(true, now.Add(-time.Hour), now, timeout); !ping {
+		t.Error("Connected launcher should ping the watchdog")
+	}
+
+	if ping, _ := sdCheckConnection(false, now.Add(-time.Minute), now, timeout); !ping {
+		t.Error("Recently disconnected launcher should ping the watchdog")
+	}
+
+	if ping, _ := sdCheckConnection(false, now.Add(-time.Hour), now, timeout); ping {
+		t.Error("Degraded launcher should not ping the watchdog")
+	}
+
+	if ping, _ := sdCheckConnection(false, now.Add(-time.Hour), now, 0); !ping {
+		t.Error("Launcher should ping the watchdog without disconnect timeout")
+	}
+}
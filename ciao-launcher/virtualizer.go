@@ -23,6 +23,25 @@ import (
 )
 
 type virtualizerStopCmd struct{}
+type virtualizerKillCmd struct{}
+type virtualizerPauseCmd struct {
+	responseCh chan error
+	pause      bool
+}
+type virtualizerBalloonCmd struct {
+	responseCh chan error
+	sizeMB     int
+}
+type virtualizerMigrateCmd struct {
+	// Receives the outcome of the migration once it has completed or
+	// failed.  It is buffered as the instance may have stopped waiting.
+	resultCh chan error
+
+	// The URI to which the state of the instance is sent, or an empty
+	// string to wait for the state of an instance being migrated to the
+	// node.
+	uri string
+}
 type virtualizerAttachCmd struct {
 	responseCh chan error
 	volumeUUID string
@@ -79,6 +98,11 @@ type virtualizer interface {
 	// connectedCh: Should be closed by this method, or a go routine that it spawns,
 	// when it is determined that the VM or container that is being monitored is
 	// running.
+	// readyCh: Should be closed by this method, or a go routine that it spawns,
+	// when the guest of the VM or container is ready, i.e., has booted.  It may
+	// never be closed if this cannot be determined.
+	// ovsCh: channel on which the go routines started by this method can report
+	// events about the instance, e.g., watchdog events, to the overseer.
 	// wg: wg.Add should be called before any go routines started by this method
 	// are launched.  wg.Done should be called by these go routines before they
 	// exit.  The instance go routine will use this wg to wait until all go routines
@@ -91,8 +115,8 @@ type virtualizer interface {
 	// 1. It sends commands down the channel, e.g., stop VM.
 	// 2. It closes the channel when it is itself asked to shutdown.  When the channel is
 	//    closed, any go routines returned by monitor vm should shutdown.
-	monitorVM(closedCh chan struct{}, connectedCh chan struct{},
-		wg *sync.WaitGroup, boot bool) chan interface{}
+	monitorVM(closedCh chan struct{}, connectedCh chan struct{}, readyCh chan struct{},
+		ovsCh chan<- interface{}, wg *sync.WaitGroup, boot bool) chan interface{}
 
 	// Returns current statistics for the instance.
 	// disk: Size of the VM/container rootfs in GB or -1 if not known.
@@ -21,18 +21,30 @@ import (
 	"os"
 	"path"
 
+	"github.com/ciao-project/ciao/payloads"
 	"github.com/golang/glog"
 )
 
 type volumeConfig struct {
-	UUID     string
-	Bootable bool
+	UUID      string
+	Bootable  bool
+	Pool      string
+	Encrypted bool
+	CDROM     bool
+	Rescue    bool
+
+	// key is the LUKS passphrase of an encrypted volume.  It is not
+	// stored with the instance state as controller provides it whenever
+	// the volume is attached or the instance is started.
+	key string
 }
 
 type vmConfig struct {
 	Cpus        int
 	Mem         int
 	Disk        int
+	DiskIOPS    int
+	NetMbps     int
 	Instance    string
 	DockerImage string
 	Legacy      bool
@@ -49,6 +61,20 @@ type vmConfig struct {
 	Volumes     []volumeConfig
 	Restart     bool
 	Privileged  bool
+	Console     bool
+	Annotations map[string]string
+	Watchdog    payloads.WatchdogAction
+
+	// Balloon is set for the VMs started while ballooning was enabled,
+	// which are launched with a balloon device.
+	Balloon bool
+
+	// migrationSource is the node from which the instance is live
+	// migrated when it is started and migrationPort the port on which
+	// qemu then waits for its state.  They are not stored with the
+	// instance state as they only apply to the START command.
+	migrationSource string
+	migrationPort   int
 }
 
 func loadVMConfig(instanceDir string) (*vmConfig, error) {
@@ -119,3 +145,30 @@ func (cfg *vmConfig) removeVolume(UUID string) {
 		}
 	}
 }
+
+func (cfg *vmConfig) rescueVolume() *volumeConfig {
+	for i := range cfg.Volumes {
+		if cfg.Volumes[i].Rescue {
+			return &cfg.Volumes[i]
+		}
+	}
+	return nil
+}
+
+// rescueConfig returns a copy of the configuration of the instance in which
+// the rescue volume, if any, is replaced by vol.  The rescue volume comes
+// first so that it gets the first disk of the instance.  A nil vol returns
+// the configuration used to boot the instance normally.
+func (cfg *vmConfig) rescueConfig(vol *volumeConfig) *vmConfig {
+	rescueCfg := *cfg
+	rescueCfg.Volumes = make([]volumeConfig, 0, len(cfg.Volumes)+1)
+	if vol != nil {
+		rescueCfg.Volumes = append(rescueCfg.Volumes, *vol)
+	}
+	for _, v := range cfg.Volumes {
+		if !v.Rescue {
+			rescueCfg.Volumes = append(rescueCfg.Volumes, v)
+		}
+	}
+	return &rescueCfg
+}
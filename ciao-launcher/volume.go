@@ -0,0 +1,72 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	storage "github.com/ciao-project/ciao/ciao-storage"
+	"github.com/golang/glog"
+)
+
+// openEncryptedVolume sets up dm-crypt on the device an encrypted volume
+// is mapped to and returns the decrypted device.
+func openEncryptedVolume(devName string, vol volumeConfig) (string, error) {
+	if vol.key == "" {
+		return "", fmt.Errorf("No key provided for encrypted volume %s", vol.UUID)
+	}
+
+	return storage.OpenEncryptedDevice(devName, vol.UUID, vol.key)
+}
+
+// mapVolume maps a volume to the node and returns the device through which
+// the instance accesses it, which is the decrypted device of encrypted
+// volumes.
+func mapVolume(storageDriver storage.BlockDriver, vol volumeConfig) (string, error) {
+	driver := storageDriver.WithPool(vol.Pool)
+	devName, err := driver.MapVolumeToNode(vol.UUID)
+	if err != nil || !vol.Encrypted {
+		return devName, err
+	}
+
+	devName, err = openEncryptedVolume(devName, vol)
+	if err != nil {
+		if unmapErr := driver.UnmapVolumeFromNode(vol.UUID); unmapErr != nil {
+			glog.Warningf("Unable to unmap %s: %v", vol.UUID, unmapErr)
+		}
+		return "", err
+	}
+
+	return devName, nil
+}
+
+// unmapVolume unmaps a volume from the node, closing its decrypted device
+// first if it is encrypted.
+func unmapVolume(storageDriver storage.BlockDriver, vol volumeConfig) error {
+	if vol.Encrypted {
+		_, err := os.Stat(storage.EncryptedDevicePath(vol.UUID))
+		if err == nil {
+			err = storage.CloseEncryptedDevice(vol.UUID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return storageDriver.WithPool(vol.Pool).UnmapVolumeFromNode(vol.UUID)
+}
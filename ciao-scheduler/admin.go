@@ -0,0 +1,199 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+var adminAddr = flag.String("admin-address", "",
+	"Address of the HTTP endpoint listing the connected SSNTP clients, e.g. localhost:8890, disabled if empty")
+var listClients = flag.Bool("list-clients", false,
+	"List the SSNTP clients connected to the scheduler serving -admin-address and exit")
+var drainTo = flag.String("drain-to", "",
+	"Drain the scheduler serving -admin-address, redirecting its SSNTP clients to this scheduler address, and exit")
+var drainTimeout = flag.Duration("drain-timeout", 30*time.Second,
+	"Maximum time to wait for the SSNTP clients to disconnect from a draining scheduler")
+
+// adminClient describes a connected SSNTP client in the responses of the
+// admin endpoint.
+type adminClient struct {
+	UUID           string    `json:"uuid"`
+	Role           string    `json:"role"`
+	RemoteAddr     string    `json:"remote_address"`
+	ConnectTime    time.Time `json:"connect_time"`
+	LastActivity   time.Time `json:"last_activity"`
+	FramesSent     uint64    `json:"frames_sent"`
+	FramesReceived uint64    `json:"frames_received"`
+	BytesSent      uint64    `json:"bytes_sent"`
+	BytesReceived  uint64    `json:"bytes_received"`
+}
+
+func (sched *ssntpSchedulerServer) adminClients() []adminClient {
+	clients := []adminClient{}
+
+	for _, c := range sched.ssntp.Clients() {
+		role := c.Role
+		clients = append(clients, adminClient{
+			UUID:           c.UUID,
+			Role:           role.String(),
+			RemoteAddr:     c.RemoteAddr,
+			ConnectTime:    c.ConnectTime,
+			LastActivity:   c.LastActivity,
+			FramesSent:     c.FramesSent,
+			FramesReceived: c.FramesReceived,
+			BytesSent:      c.BytesSent,
+			BytesReceived:  c.BytesReceived,
+		})
+	}
+
+	return clients
+}
+
+func (sched *ssntpSchedulerServer) clientsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(sched.adminClients()); err != nil {
+		glog.Warningf("Error encoding clients: %v", err)
+	}
+}
+
+func (sched *ssntpSchedulerServer) drainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	uri := r.FormValue("uri")
+	if uri == "" {
+		http.Error(w, "Missing uri", http.StatusBadRequest)
+		return
+	}
+
+	timeout, err := time.ParseDuration(r.FormValue("timeout"))
+	if err != nil || timeout <= 0 {
+		http.Error(w, "Invalid timeout", http.StatusBadRequest)
+		return
+	}
+
+	glog.Infof("Draining SSNTP clients to %s", uri)
+	if err := sched.ssntp.Drain(uri, timeout); err != nil {
+		glog.Warningf("Error draining SSNTP clients: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveAdmin serves the admin endpoint.  It has no authentication and must
+// only be reachable by the cluster operators.
+func serveAdmin(sched *ssntpSchedulerServer, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/clients", sched.clientsHandler)
+	mux.HandleFunc("/drain", sched.drainHandler)
+
+	glog.Infof("Serving admin endpoint on %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		glog.Errorf("Admin endpoint failed: %v", err)
+	}
+}
+
+func formatTime(t time.Time, now time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return fmt.Sprintf("%s ago", (now.Sub(t)/time.Second)*time.Second)
+}
+
+// printClients writes the clients listed by the admin endpoint served on
+// addr as a table.
+func printClients(addr string, out io.Writer) error {
+	if addr == "" {
+		return errors.New("No -admin-address given")
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/clients", addr))
+	if err != nil {
+		return errors.Wrap(err, "Error querying admin endpoint")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected response from admin endpoint: %s", resp.Status)
+	}
+
+	var clients []adminClient
+	if err := json.NewDecoder(resp.Body).Decode(&clients); err != nil {
+		return errors.Wrap(err, "Error decoding clients")
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(out, 0, 8, 1, ' ', 0)
+	fmt.Fprintln(w, "UUID\tRole\tAddress\tConnected\tLast Activity\tFrames Sent/Received\tBytes Sent/Received")
+	for _, c := range clients {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d/%d\t%d/%d\n", c.UUID, c.Role, c.RemoteAddr,
+			formatTime(c.ConnectTime, now), formatTime(c.LastActivity, now),
+			c.FramesSent, c.FramesReceived, c.BytesSent, c.BytesReceived)
+	}
+
+	return w.Flush()
+}
+
+// requestDrain asks the scheduler serving the admin endpoint on addr to
+// redirect its SSNTP clients to the scheduler at uri.  It returns once the
+// clients disconnected or timeout expired.
+func requestDrain(addr string, uri string, timeout time.Duration) error {
+	if addr == "" {
+		return errors.New("No -admin-address given")
+	}
+
+	values := url.Values{}
+	values.Set("uri", uri)
+	values.Set("timeout", timeout.String())
+
+	resp, err := http.PostForm(fmt.Sprintf("http://%s/drain", addr), values)
+	if err != nil {
+		return errors.Wrap(err, "Error querying admin endpoint")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusNoContent {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Error draining scheduler: %s: %s", resp.Status,
+			strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
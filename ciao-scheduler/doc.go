@@ -137,5 +137,132 @@ Ciao-scheduler currently implements an extremely trivial algorithm to
 prefer not using the most-recently-used compute node.  This is inexpensive
 and leads to sufficient spread of new workloads across a cluster.
 
+Node Policies
+
+Admins can set a scheduling weight and an instance limit for each node
+through ciao-controller, which sends the policies of all nodes to
+ciao-scheduler in a NodePolicy command whenever they change and when it
+connects.  Among the nodes a workload fits on, ciao-scheduler picks one
+with the highest weight, so that nodes with a lower weight only receive
+workloads once the others are full.  Nodes without a policy have the
+highest weight.  Nodes which reached their instance limit are treated as
+full.
+
+Dedicated Hosts
+
+The policy of a compute node can also dedicate it to a tenant.
+ciao-scheduler then only starts the workloads of that tenant on the node,
+and only starts the workloads of a tenant with dedicated nodes on those
+nodes, even when they are full or disconnected.  CNCIs are not affected
+by dedicated hosts.
+
+CNCI Placement
+
+On nodes which are both compute and network nodes, the workloads of a
+tenant would compete with the CNCI of that tenant for the network of the
+node, degrading the traffic of all the instances of the tenant.
+ciao-scheduler notes the node on which it starts each CNCI and never
+starts the workloads of a tenant on the nodes running one of its CNCIs,
+unless the policy of the node allows CNCI co-location.  Running
+ciao-scheduler with the -allow-cnci-colocation flag allows it on all
+nodes, as required by single node clusters.  As with preemption, only the
+CNCIs started since ciao-scheduler was last started are known to it.
+
+Preemption
+
+Workloads belong to a priority class, low, normal or high, normal being
+the default.  When started with the -preemption flag, ciao-scheduler
+does not immediately return a "cloud full" status when no node has
+capacity for a workload.  Instead it looks for the node on which
+stopping the fewest instances of a lower priority, lowest priority
+instances first, would make room for the workload.  It then sends an
+InstancesPreempted event to ciao-controller, which stops those instances
+and records their preemption, and dispatches the workload to that node
+once they are all reported as stopped or deleted.  If they do not stop
+within the -preemption-timeout duration, the workload fails to start.
+
+Only the instances started since ciao-scheduler was last started can be
+preempted, as it does not persist any state.
+
+Connected Clients
+
+When started with the -admin-address flag, ciao-scheduler serves on that
+address an HTTP endpoint, /clients, which lists the SSNTP clients
+currently connected to it as JSON.  Each client is described by its UUID,
+role, remote address, connection time, time of its last frame and the
+number of frames and bytes exchanged with it.  The endpoint is not
+authenticated and should only listen on a loopback or management address.
+Running ciao-scheduler with -list-clients and the -admin-address of a
+running scheduler prints these clients as a table.
+
+Draining
+
+Before taking a scheduler down for maintenance, it can be drained by
+running ciao-scheduler with -drain-to, giving the address of another
+scheduler, and the -admin-address of the scheduler to drain.  The drained
+scheduler stops accepting connections and sends a REDIRECT status to its
+SSNTP clients, which reconnect to the other scheduler.  It keeps processing
+the frames they sent until they disconnect, for at most -drain-timeout,
+and does not report the nodes reconnecting to the other scheduler as
+disconnected to ciao-controller.
+
+TLS Session Resumption
+
+SSNTP clients resume their TLS sessions when reconnecting to
+ciao-scheduler, sparing it full handshakes when all the nodes reconnect
+at once.  The keys encrypting the session tickets are rotated every hour
+and stored in the file given by -session-ticket-keys,
+/etc/pki/ciao/session-ticket-keys-Scheduler by default, so that sessions
+can also be resumed after ciao-scheduler restarts.  The file must only be
+readable by ciao-scheduler.  With an empty -session-ticket-keys the keys
+are only kept in memory and clients do full handshakes after a restart.
+
+Hot Standby
+
+A second ciao-scheduler can be run as a hot standby by listing both
+schedulers, primary first, in the uris list of the scheduler section of
+the cluster configuration and giving them a lease_path on storage they
+share, e.g. over NFS:
+
+	scheduler:
+	  uris:
+	  - scheduler1
+	  - scheduler2
+	  lease_path: /var/lib/ciao/shared/scheduler.lease
+	  lease_timeout: 15
+
+SSNTP clients try the listed schedulers in order whenever they connect.
+Only the scheduler holding the lease serves them, renewing it every third
+of lease_timeout seconds.  The other one stays passive, without listening
+for connections, until the lease expires, i.e. until the active scheduler
+is gone.  It then takes the lease and the clients fail over to it.  A
+scheduler which loses its lease, or cannot renew it before it expires,
+stops.  Lease expiry times are absolute, so the clocks of the schedulers
+must be synchronized.
+
+Each renewal or takeover of the lease exclusively creates the file of its
+next generation, e.g. scheduler.lease.42, next to lease_path, so that
+only one of two schedulers doing so at the same time gets the lease.  The
+storage must thus support hard links, as NFS does.
+
+Forwarding Rules
+
+Besides its hard-coded forwarding rules, ciao-scheduler forwards frames
+as declared in the forward_rules list of the scheduler section of the
+cluster configuration.  Each rule gives a frame type (command, status,
+event or error), an operand value and a comma separated list of
+destination roles, e.g.:
+
+	scheduler:
+	  forward_rules:
+	  - type: event
+	    operand: 32
+	    dest: controller,cnciagent
+
+This lets new payload types be routed without rebuilding ciao-scheduler.
+The rules are read at startup and replaced by the ones of every CONFIGURE
+command.  Rules for operands which already have a hard-coded rule are
+ignored.
+
 */
 package main
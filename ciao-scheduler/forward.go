@@ -0,0 +1,93 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/ciao-project/ciao/configuration"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/ssntp"
+	"github.com/golang/glog"
+)
+
+// configuredForwardRules converts the forwarding rules from the scheduler
+// cluster configuration into SSNTP forwarding rules.
+func configuredForwardRules(conf payloads.ConfigureScheduler) ([]ssntp.FrameForwardRule, error) {
+	var rules []ssntp.FrameForwardRule
+
+	for _, r := range conf.ForwardRules {
+		var operand interface{}
+
+		switch r.Type {
+		case "command":
+			operand = ssntp.Command(r.Operand)
+		case "status":
+			operand = ssntp.Status(r.Operand)
+		case "event":
+			operand = ssntp.Event(r.Operand)
+		case "error":
+			operand = ssntp.Error(r.Operand)
+		default:
+			return nil, fmt.Errorf("Invalid frame type %q for operand %d", r.Type, r.Operand)
+		}
+
+		var dest ssntp.Role
+		if err := dest.Set(r.Dest); err != nil || dest == ssntp.UNKNOWN {
+			return nil, fmt.Errorf("Invalid destination %q for %s operand %d", r.Dest, r.Type, r.Operand)
+		}
+
+		rules = append(rules, ssntp.FrameForwardRule{
+			Operand: operand,
+			Dest:    dest,
+		})
+	}
+
+	return rules, nil
+}
+
+// updateForwardRules replaces the forwarding rules added on top of the
+// hard-coded ones with the ones from a cluster configuration payload.
+func (sched *ssntpSchedulerServer) updateForwardRules(payload []byte) {
+	conf, err := configuration.Payload(payload)
+	if err != nil {
+		glog.Errorf("Bad configuration: %v\n", err)
+		return
+	}
+
+	rules, err := configuredForwardRules(conf.Configure.Scheduler)
+	if err != nil {
+		glog.Errorf("Invalid forwarding rules: %v\n", err)
+		return
+	}
+
+	if err := sched.ssntp.SetForwardRules(rules); err != nil {
+		glog.Warningf("Ignoring configured forwarding rules: %v\n", err)
+	}
+}
+
+// loadForwardRules sets the forwarding rules from the cluster configuration
+// the scheduler starts with.
+func loadForwardRules(sched *ssntpSchedulerServer) {
+	payload, err := configuration.ExtractBlob(sched.config.ConfigURI)
+	if err != nil {
+		// The SSNTP server reports missing configurations.
+		return
+	}
+
+	sched.updateForwardRules(payload)
+}
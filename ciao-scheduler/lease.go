@@ -0,0 +1,272 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ciao-project/ciao/configuration"
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+const defaultLeaseTimeout = 15 * time.Second
+
+// leaseRecord is the content of a lease generation file.
+type leaseRecord struct {
+	Holder  string    `yaml:"holder"`
+	Expires time.Time `yaml:"expires"`
+}
+
+// schedulerLease is a lease on files in storage shared by the schedulers
+// of a cluster. Only the scheduler holding it serves SSNTP clients, the
+// others wait for it to expire.
+//
+// Each acquisition or renewal of the lease creates the file of its next
+// generation, <path>.<generation>, which fails if the file already
+// exists.  Of the schedulers trying to take or renew a lease, only one
+// thus creates its next generation and holds it.  The latest generation
+// is the one with the highest number and the older ones are removed by
+// the holder.
+type schedulerLease struct {
+	path       string
+	holder     string
+	timeout    time.Duration
+	generation uint64
+}
+
+func newSchedulerLease(path string, timeout time.Duration) (*schedulerLease, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	if timeout <= 0 {
+		timeout = defaultLeaseTimeout
+	}
+
+	return &schedulerLease{
+		path:    path,
+		holder:  fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		timeout: timeout,
+	}, nil
+}
+
+func (l *schedulerLease) generationPath(generation uint64) string {
+	return fmt.Sprintf("%s.%d", l.path, generation)
+}
+
+// generations returns the generations of the lease found in storage.
+func (l *schedulerLease) generations() ([]uint64, error) {
+	matches, err := filepath.Glob(l.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	var generations []uint64
+	for _, m := range matches {
+		g, err := strconv.ParseUint(strings.TrimPrefix(m, l.path+"."), 10, 64)
+		if err == nil {
+			generations = append(generations, g)
+		}
+	}
+
+	return generations, nil
+}
+
+// read returns the latest generation of the lease and its record, or 0
+// and an empty record if the lease has never been taken.
+func (l *schedulerLease) read() (uint64, leaseRecord, error) {
+	var record leaseRecord
+
+	generations, err := l.generations()
+	if err != nil {
+		return 0, record, err
+	}
+
+	var latest uint64
+	for _, g := range generations {
+		if g > latest {
+			latest = g
+		}
+	}
+
+	if latest == 0 {
+		return 0, record, nil
+	}
+
+	// a generation removed by a newer holder has expired.
+	data, err := ioutil.ReadFile(l.generationPath(latest))
+	if os.IsNotExist(err) {
+		return latest, record, nil
+	} else if err != nil {
+		return latest, record, err
+	}
+
+	err = yaml.Unmarshal(data, &record)
+	return latest, record, err
+}
+
+// create creates the file of a generation of the lease, failing with an
+// error satisfying os.IsExist if it already exists.  The record is written
+// to a temporary file first and then linked, so that the generation never
+// appears without its record.
+func (l *schedulerLease) create(generation uint64, record leaseRecord) error {
+	data, err := yaml.Marshal(&record)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(l.path), ".lease")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Link(f.Name(), l.generationPath(generation))
+}
+
+// prune removes the generations of the lease older than the one held.
+func (l *schedulerLease) prune() {
+	generations, err := l.generations()
+	if err != nil {
+		return
+	}
+
+	for _, g := range generations {
+		if g < l.generation {
+			_ = os.Remove(l.generationPath(g))
+		}
+	}
+}
+
+// tryAcquire takes or renews the lease unless another scheduler holds it
+// and it has not expired, by creating its next generation.  When several
+// schedulers try to do so at the same time, e.g. the holder renewing the
+// lease and a standby taking it as it expires, only one of them succeeds.
+func (l *schedulerLease) tryAcquire(now time.Time) (bool, error) {
+	generation, record, err := l.read()
+	if err != nil {
+		return false, err
+	}
+
+	held := generation == l.generation && record.Holder == l.holder
+	if !held && now.Before(record.Expires) {
+		l.generation = 0
+		return false, nil
+	}
+
+	err = l.create(generation+1, leaseRecord{Holder: l.holder, Expires: now.Add(l.timeout)})
+	if os.IsExist(err) {
+		l.generation = 0
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	l.generation = generation + 1
+	l.prune()
+
+	return true, nil
+}
+
+// acquire blocks until the lease is acquired.
+func (l *schedulerLease) acquire() {
+	for {
+		held, err := l.tryAcquire(time.Now())
+		if err != nil {
+			glog.Errorf("Unable to acquire lease %s: %v", l.path, err)
+		} else if held {
+			glog.Infof("Acquired lease %s", l.path)
+			return
+		}
+
+		time.Sleep(l.timeout / 3)
+	}
+}
+
+// renew renews the lease until it is lost, either because another
+// scheduler took it or because it could not be renewed before it expired,
+// and then calls lost.  lost is called before the lease expires when it
+// cannot be renewed, so that the scheduler stops before a standby may take
+// the lease, provided the clocks of the schedulers are synchronized.
+func (l *schedulerLease) renew(lost func()) {
+	expires := time.Now().Add(l.timeout)
+	period := l.timeout / 3
+
+	for {
+		time.Sleep(period)
+
+		now := time.Now()
+		held, err := l.tryAcquire(now)
+		if err == nil && held {
+			expires = now.Add(l.timeout)
+			continue
+		}
+
+		if err != nil && now.Add(period).Before(expires) {
+			glog.Warningf("Unable to renew lease %s: %v", l.path, err)
+			continue
+		}
+
+		glog.Errorf("Lost lease %s", l.path)
+		lost()
+		return
+	}
+}
+
+// waitForLease keeps the scheduler passive until it holds the lease
+// configured in the cluster configuration, if any, and stops it when it
+// loses the lease, for the SSNTP clients to fail over to the scheduler
+// which took it.
+func waitForLease(sched *ssntpSchedulerServer) {
+	payload, err := configuration.ExtractBlob(sched.config.ConfigURI)
+	if err != nil {
+		return
+	}
+
+	conf, err := configuration.Payload(payload)
+	if err != nil || conf.Configure.Scheduler.LeasePath == "" {
+		return
+	}
+
+	timeout := time.Duration(conf.Configure.Scheduler.LeaseTimeout) * time.Second
+	lease, err := newSchedulerLease(conf.Configure.Scheduler.LeasePath, timeout)
+	if err != nil {
+		glog.Errorf("Unable to create lease: %v", err)
+		return
+	}
+
+	glog.Infof("Waiting for lease %s", lease.path)
+	lease.acquire()
+
+	go lease.renew(sched.ssntp.Stop)
+}
@@ -23,6 +23,7 @@ import (
 	"log"
 	"os"
 	"runtime/pprof"
+	"sort"
 	"sync"
 	"syscall"
 	"time"
@@ -38,17 +39,27 @@ import (
 
 var cert = flag.String("cert", "/etc/pki/ciao/cert-Scheduler-localhost.pem", "Server certificate")
 var cacert = flag.String("cacert", "/etc/pki/ciao/CAcert-server-localhost.pem", "CA certificate")
+var ticketKeys = flag.String("session-ticket-keys", "/etc/pki/ciao/session-ticket-keys-Scheduler",
+	"File storing the TLS session ticket keys, empty to not store them")
 var cpuprofile = flag.String("cpuprofile", "", "Write cpu profile to file")
 var heartbeat = flag.Bool("heartbeat", false, "Emit status heartbeat text")
 var prepare = flag.Bool("osprepare", false, "Install dependencies")
 var logDir = "/var/lib/ciao/logs/scheduler"
 var configURI = flag.String("configuration-uri", "file:///etc/ciao/configuration.yaml",
 	"Cluster configuration URI")
+var preemption = flag.Bool("preemption", false, "Preempt lower priority instances when the cluster is full")
+var preemptionTimeout = flag.Duration("preemption-timeout", 5*time.Minute,
+	"Time to wait for preempted instances to stop before failing the instance that preempted them")
+var allowCNCIColocation = flag.Bool("allow-cnci-colocation", false,
+	"Allow tenant workloads to run on the nodes hosting the CNCIs of their tenant, regardless of node policies")
 
 type ssntpSchedulerServer struct {
 	// user config overrides ------------------------------------------
-	heartbeat  bool
-	cpuprofile string
+	heartbeat           bool
+	cpuprofile          string
+	preemption          bool
+	preemptionTimeout   time.Duration
+	allowCNCIColocation bool
 
 	// ssntp ----------------------------------------------------------
 	config *ssntp.Config
@@ -75,6 +86,19 @@ type ssntpSchedulerServer struct {
 	nnMutex    sync.RWMutex // Rlock traversing map, Lock modifying map
 	nnMRU      *nodeStat
 	nnMRUIndex int
+
+	// Instances started by the scheduler, which can be preempted, and
+	// instances waiting for preempted instances to stop.
+	// Lock after cnMutex or nnMutex, and before any nodeStat mutex.
+	instMap    map[string]*instanceStat
+	preemptMap map[string]*preemptionStat
+	instMutex  sync.Mutex
+
+	// Scheduling policies set by the controller for compute and network
+	// nodes, including the nodes which are not connected yet.
+	// Lock after any nodeStat mutex.
+	nodePolicies    map[string]payloads.NodePolicy
+	nodePolicyMutex sync.Mutex
 }
 
 func newSsntpSchedulerServer() *ssntpSchedulerServer {
@@ -84,6 +108,9 @@ func newSsntpSchedulerServer() *ssntpSchedulerServer {
 		cnMRUIndex:    -1,
 		nnMap:         make(map[string]*nodeStat),
 		nnMRUIndex:    -1,
+		instMap:       make(map[string]*instanceStat),
+		preemptMap:    make(map[string]*preemptionStat),
+		nodePolicies:  make(map[string]payloads.NodePolicy),
 	}
 }
 
@@ -95,11 +122,42 @@ type nodeStat struct {
 	memAvailMB  int
 	diskTotalMB int
 	diskAvailMB int
+	iopsTotal   int
+	iopsAvail   int
+	mbpsTotal   int
+	mbpsAvail   int
+	instances   int
 	load        int
 	cpus        int
 	isNetNode   bool
 	networks    []payloads.NetworkStat
 	hostname    string
+	policy      *payloads.NodePolicy
+}
+
+// The scheduling weight of the referenced, locked nodeStat object
+func (node *nodeStat) weight() int {
+	if node.policy == nil {
+		return payloads.MaxNodeWeight
+	}
+	return node.policy.Weight
+}
+
+type instanceStat struct {
+	uuid      string
+	nodeUUID  string
+	priority  int
+	workload  workResources
+	preempted bool
+}
+
+type preemptionStat struct {
+	controllerUUID string
+	nodeUUID       string
+	workload       workResources
+	restart        bool
+	victims        map[string]bool
+	timer          *time.Timer
 }
 
 type controllerStatus uint8
@@ -185,6 +243,12 @@ func (sched *ssntpSchedulerServer) sendDirectedNodeConnectionEvents(ctlUUID stri
 // The ssntp server implementation is expected to generate ssntp client
 // connect/disconnect events. This function sends them to all controllers.
 func (sched *ssntpSchedulerServer) sendNodeConnectionEvents(nodeUUID string, nodeType payloads.Resource, connected bool) {
+	// Nodes disconnecting from a draining scheduler are reconnecting
+	// to the scheduler they were redirected to.
+	if !connected && sched.ssntp.Draining() {
+		return
+	}
+
 	b, err := prepareNodeConnectionEvent(nodeUUID, nodeType, connected)
 	if err != nil {
 		errors.Wrap(err, "Node connection event lost")
@@ -293,6 +357,7 @@ func connectComputeNode(sched *ssntpSchedulerServer, uuid string) {
 	node.status = ssntp.CONNECTED
 	node.uuid = uuid
 	node.isNetNode = false
+	node.policy = sched.getNodePolicy(uuid)
 	sched.cnList = append(sched.cnList, &node)
 	sched.cnMap[uuid] = &node
 
@@ -327,6 +392,7 @@ func disconnectComputeNode(sched *ssntpSchedulerServer, uuid string) {
 		sched.cnMRUIndex = -1
 	}
 
+	go sched.removeNodeInstances(uuid)
 	go sched.sendNodeConnectionEvents(uuid, payloads.ComputeNode, false)
 }
 
@@ -345,6 +411,7 @@ func connectNetworkNode(sched *ssntpSchedulerServer, uuid string) {
 	node.status = ssntp.CONNECTED
 	node.uuid = uuid
 	node.isNetNode = true
+	node.policy = sched.getNodePolicy(uuid)
 	sched.nnList = append(sched.nnList, &node)
 	sched.nnMap[uuid] = &node
 
@@ -379,6 +446,7 @@ func disconnectNetworkNode(sched *ssntpSchedulerServer, uuid string) {
 		sched.nnMRUIndex = -1
 	}
 
+	go sched.removeNodeInstances(uuid)
 	go sched.sendNodeConnectionEvents(uuid, payloads.NetworkNode, false)
 }
 
@@ -430,6 +498,11 @@ func (sched *ssntpSchedulerServer) updateNodeStat(node *nodeStat, status ssntp.S
 		node.memAvailMB = stats.MemAvailableMB
 		node.diskTotalMB = stats.DiskTotalMB
 		node.diskAvailMB = stats.DiskAvailableMB
+		node.iopsTotal = stats.DiskIOPSTotal
+		node.iopsAvail = stats.DiskIOPSAvailable
+		node.mbpsTotal = stats.NetMbpsTotal
+		node.mbpsAvail = stats.NetMbpsAvailable
+		node.instances = stats.Instances
 		node.load = stats.Load
 		node.cpus = stats.CpusOnline
 		node.networks = stats.Networks
@@ -474,8 +547,25 @@ func (sched *ssntpSchedulerServer) StatusNotify(uuid string, status ssntp.Status
 
 type workResources struct {
 	instanceUUID string
+	tenantUUID   string
 	diskReqMB    int
 	requirements payloads.WorkloadRequirements
+
+	// Nodes running a CNCI of the tenant of the workload, which the
+	// workload is kept away from unless their policy allows it.
+	cnciNodes map[string]bool
+
+	// Whether compute nodes are dedicated to the tenant of the
+	// workload, which then only runs on them.
+	dedicated bool
+
+	// START command payload, kept for workloads waiting for preempted
+	// instances to stop.
+	payload []byte
+
+	// Node from which the instance of the workload is live migrated,
+	// which is never picked to run it.
+	migrationSource string
 }
 
 func (sched *ssntpSchedulerServer) getWorkloadResources(work *payloads.Start) (workload workResources, err error) {
@@ -488,33 +578,95 @@ func (sched *ssntpSchedulerServer) getWorkloadResources(work *payloads.Start) (w
 
 	workload.requirements = work.Start.Requirements
 
-	// note the uuid
+	// note the uuids
 	workload.instanceUUID = work.Start.InstanceUUID
+	workload.tenantUUID = work.Start.TenantUUID
+	workload.migrationSource = work.Start.MigrationSource
 
 	return workload, nil
 }
 
-// Check resource demands are satisfiable by the referenced, locked nodeStat object
-func (sched *ssntpSchedulerServer) workloadFits(node *nodeStat, workload *workResources) bool {
-	// simple scheduling policy == first fit
-	if node.memAvailMB >= workload.requirements.MemMB &&
+// Check the disk IOPS and network bandwidth demands are satisfiable by the
+// referenced, locked nodeStat object.  Nodes which do not report their disk
+// IOPS or network bandwidth do not constrain them.
+func ioFits(node *nodeStat, workload *workResources) bool {
+	if node.iopsTotal > 0 && node.iopsAvail < workload.requirements.DiskIOPS {
+		return false
+	}
+
+	if node.mbpsTotal > 0 && node.mbpsAvail < workload.requirements.NetMbps {
+		return false
+	}
+
+	return true
+}
+
+// Check the referenced, locked nodeStat object can run one more instance
+// without exceeding the instance limit of its policy, if any.
+func instancesFit(node *nodeStat) bool {
+	if node.policy == nil || node.policy.MaxInstances == 0 {
+		return true
+	}
+
+	return node.instances < node.policy.MaxInstances
+}
+
+// Check resource demands are satisfiable by the referenced, locked nodeStat object,
+// regardless of its status
+func resourcesFit(node *nodeStat, workload *workResources) bool {
+	return node.memAvailMB >= workload.requirements.MemMB &&
 		node.diskAvailMB >= workload.diskReqMB &&
-		node.status == ssntp.READY &&
-		node.isNetNode == workload.requirements.NetworkNode {
+		ioFits(node, workload) &&
+		instancesFit(node)
+}
 
-		if workload.requirements.Hostname != "" &&
-			workload.requirements.Hostname != node.hostname {
-			return false
+// Check the referenced, locked nodeStat object is of the type and identity
+// the workload requires
+func nodeMatches(node *nodeStat, workload *workResources) bool {
+	if node.isNetNode != workload.requirements.NetworkNode {
+		return false
+	}
+
+	if workload.requirements.Hostname != "" &&
+		workload.requirements.Hostname != node.hostname {
+		return false
+	}
+
+	if workload.requirements.NodeID != "" &&
+		workload.requirements.NodeID != node.uuid {
+		return false
+	}
+
+	if workload.migrationSource == node.uuid {
+		return false
+	}
+
+	if workload.cnciNodes[node.uuid] &&
+		(node.policy == nil || !node.policy.AllowCNCIColocation) {
+		return false
+	}
+
+	if !node.isNetNode {
+		dedicatedTenant := ""
+		if node.policy != nil {
+			dedicatedTenant = node.policy.DedicatedTenant
 		}
 
-		if workload.requirements.NodeID != "" &&
-			workload.requirements.NodeID != node.uuid {
+		if (workload.dedicated || dedicatedTenant != "") &&
+			dedicatedTenant != workload.tenantUUID {
 			return false
 		}
-
-		return true
 	}
-	return false
+
+	return true
+}
+
+// Check resource demands are satisfiable by the referenced, locked nodeStat object
+func (sched *ssntpSchedulerServer) workloadFits(node *nodeStat, workload *workResources) bool {
+	// simple scheduling policy == first fit
+	return node.status == ssntp.READY &&
+		resourcesFit(node, workload) &&
+		nodeMatches(node, workload)
 }
 
 func (sched *ssntpSchedulerServer) sendStartFailureError(clientUUID string, instanceUUID string, reason payloads.StartFailureReason, restart bool) {
@@ -622,6 +774,46 @@ func getWorkloadAgentUUID(sched *ssntpSchedulerServer, command ssntp.Command, pa
 		var cmd payloads.AttachVolume
 		err := yaml.Unmarshal(payload, &cmd)
 		return cmd.Attach.InstanceUUID, cmd.Attach.WorkloadAgentUUID, err
+	case ssntp.PAUSE:
+		var cmd payloads.Pause
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Pause.InstanceUUID, cmd.Pause.WorkloadAgentUUID, err
+	case ssntp.UNPAUSE:
+		var cmd payloads.Unpause
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Unpause.InstanceUUID, cmd.Unpause.WorkloadAgentUUID, err
+	case ssntp.RESCUE:
+		var cmd payloads.Rescue
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Rescue.InstanceUUID, cmd.Rescue.WorkloadAgentUUID, err
+	case ssntp.UNRESCUE:
+		var cmd payloads.Unrescue
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Unrescue.InstanceUUID, cmd.Unrescue.WorkloadAgentUUID, err
+	case ssntp.CONSOLE:
+		var cmd payloads.Console
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Console.InstanceUUID, cmd.Console.WorkloadAgentUUID, err
+	case ssntp.REBOOT:
+		var cmd payloads.Reboot
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Reboot.InstanceUUID, cmd.Reboot.WorkloadAgentUUID, err
+	case ssntp.GUESTAGENT:
+		var cmd payloads.GuestAgent
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.GuestAgent.InstanceUUID, cmd.GuestAgent.WorkloadAgentUUID, err
+	case ssntp.CAPTURE:
+		var cmd payloads.PacketCapture
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.PacketCapture.InstanceUUID, cmd.PacketCapture.WorkloadAgentUUID, err
+	case ssntp.MIGRATE:
+		var cmd payloads.Migrate
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Migrate.InstanceUUID, cmd.Migrate.WorkloadAgentUUID, err
+	case ssntp.UpdateMetadata:
+		var cmd payloads.UpdateMetadata
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.UpdateMetadata.InstanceUUID, cmd.UpdateMetadata.WorkloadAgentUUID, err
 	}
 }
 
@@ -644,47 +836,318 @@ func (sched *ssntpSchedulerServer) fwdCmdToComputeNode(command ssntp.Command, pa
 // Decrement resource claims for the referenced locked nodeStat object
 func (sched *ssntpSchedulerServer) decrementResourceUsage(node *nodeStat, workload *workResources) {
 	node.memAvailMB -= workload.requirements.MemMB
+	node.iopsAvail -= workload.requirements.DiskIOPS
+	node.mbpsAvail -= workload.requirements.NetMbps
+	node.instances++
 }
 
-// Find suitable compute node, returning referenced to a locked nodeStat if found
-func pickComputeNode(sched *ssntpSchedulerServer, controllerUUID string, workload *workResources, restart bool) (node *nodeStat) {
-	sched.cnMutex.RLock()
-	defer sched.cnMutex.RUnlock()
+// Increment resource claims for the referenced locked nodeStat object, undoing
+// decrementResourceUsage
+func (sched *ssntpSchedulerServer) incrementResourceUsage(node *nodeStat, workload *workResources) {
+	node.memAvailMB += workload.requirements.MemMB
+	node.iopsAvail += workload.requirements.DiskIOPS
+	node.mbpsAvail += workload.requirements.NetMbps
+	node.instances--
+}
 
-	if len(sched.cnList) == 0 {
-		glog.Errorf("No compute nodes connected, unable to start workload")
-		sched.sendStartFailureError(controllerUUID, workload.instanceUUID, payloads.NoComputeNodes, restart)
+// Record an instance started on a node, so that it can later be preempted
+func (sched *ssntpSchedulerServer) addInstance(nodeUUID string, workload *workResources) {
+	sched.instMutex.Lock()
+	defer sched.instMutex.Unlock()
+
+	sched.instMap[workload.instanceUUID] = &instanceStat{
+		uuid:     workload.instanceUUID,
+		nodeUUID: nodeUUID,
+		priority: workload.requirements.Priority.Level(),
+		workload: *workload,
+	}
+}
+
+// Get the nodes running the CNCIs of a tenant.  CNCIs are the only workloads
+// started on network nodes.
+func (sched *ssntpSchedulerServer) getCNCINodes(tenantUUID string) map[string]bool {
+	sched.instMutex.Lock()
+	defer sched.instMutex.Unlock()
+
+	nodes := make(map[string]bool)
+	for _, inst := range sched.instMap {
+		if inst.workload.requirements.NetworkNode && inst.workload.tenantUUID == tenantUUID {
+			nodes[inst.nodeUUID] = true
+		}
+	}
+
+	return nodes
+}
+
+// Forget about an instance which is no longer running, and start the
+// workloads which were only waiting for it to stop
+func (sched *ssntpSchedulerServer) removeInstance(instanceUUID string) {
+	var ready []*preemptionStat
+
+	sched.instMutex.Lock()
+	delete(sched.instMap, instanceUUID)
+	for uuid, p := range sched.preemptMap {
+		if !p.victims[instanceUUID] {
+			continue
+		}
+
+		delete(p.victims, instanceUUID)
+		if len(p.victims) == 0 {
+			p.timer.Stop()
+			delete(sched.preemptMap, uuid)
+			ready = append(ready, p)
+		}
+	}
+	sched.instMutex.Unlock()
+
+	for _, p := range ready {
+		sched.startPreemptingWorkload(p)
+	}
+}
+
+// Forget about all the instances running on a disconnected node
+func (sched *ssntpSchedulerServer) removeNodeInstances(nodeUUID string) {
+	var instances []string
+
+	sched.instMutex.Lock()
+	for _, inst := range sched.instMap {
+		if inst.nodeUUID == nodeUUID {
+			instances = append(instances, inst.uuid)
+		}
+	}
+	sched.instMutex.Unlock()
+
+	for _, instanceUUID := range instances {
+		sched.removeInstance(instanceUUID)
+	}
+}
+
+// Pick the instances to stop on the referenced, locked nodeStat object for the
+// workload to fit on it.  Only instances of a lower priority than the workload
+// can be picked, lowest priority and largest instances first.
+// Must be called with instMutex held.
+func (sched *ssntpSchedulerServer) pickVictims(node *nodeStat, workload *workResources) []*instanceStat {
+	if node.status != ssntp.READY && node.status != ssntp.FULL {
 		return nil
 	}
 
-	/* First try nodes after the MRU */
-	if sched.cnMRUIndex != -1 && sched.cnMRUIndex < len(sched.cnList)-1 {
-		for i, node := range sched.cnList[sched.cnMRUIndex+1:] {
+	if !nodeMatches(node, workload) {
+		return nil
+	}
+
+	priority := workload.requirements.Priority.Level()
+	var candidates []*instanceStat
+	for _, inst := range sched.instMap {
+		if inst.nodeUUID == node.uuid && !inst.preempted && inst.priority < priority {
+			candidates = append(candidates, inst)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].priority != candidates[j].priority {
+			return candidates[i].priority < candidates[j].priority
+		}
+		if candidates[i].workload.requirements.MemMB != candidates[j].workload.requirements.MemMB {
+			return candidates[i].workload.requirements.MemMB > candidates[j].workload.requirements.MemMB
+		}
+		return candidates[i].uuid < candidates[j].uuid
+	})
+
+	var victims []*instanceStat
+	fits := false
+	for _, inst := range candidates {
+		sched.incrementResourceUsage(node, &inst.workload)
+		victims = append(victims, inst)
+		if resourcesFit(node, workload) {
+			fits = true
+			break
+		}
+	}
+
+	for _, inst := range victims {
+		sched.decrementResourceUsage(node, &inst.workload)
+	}
+
+	if !fits {
+		return nil
+	}
+
+	return victims
+}
+
+// When preemption is enabled, look for the node on which the fewest lower
+// priority instances need to be stopped for the workload to fit.  If there
+// is one, the controller is asked to stop them and the workload is started
+// once they are all gone.
+func (sched *ssntpSchedulerServer) preemptInstances(nodes []*nodeStat, controllerUUID string, workload *workResources, restart bool) bool {
+	if !sched.preemption {
+		return false
+	}
+
+	sched.instMutex.Lock()
+
+	var target *nodeStat
+	var victims []*instanceStat
+	for _, node := range nodes {
+		node.mutex.Lock()
+		v := sched.pickVictims(node, workload)
+		node.mutex.Unlock()
+
+		if v != nil && (victims == nil || len(v) < len(victims)) {
+			target = node
+			victims = v
+		}
+	}
+
+	if target == nil {
+		sched.instMutex.Unlock()
+		return false
+	}
+
+	p := &preemptionStat{
+		controllerUUID: controllerUUID,
+		nodeUUID:       target.uuid,
+		workload:       *workload,
+		restart:        restart,
+		victims:        make(map[string]bool),
+	}
+
+	var preempted []string
+	for _, inst := range victims {
+		inst.preempted = true
+		p.victims[inst.uuid] = true
+		preempted = append(preempted, inst.uuid)
+	}
+
+	instanceUUID := workload.instanceUUID
+	sched.preemptMap[instanceUUID] = p
+	p.timer = time.AfterFunc(sched.preemptionTimeout, func() {
+		sched.preemptionTimedOut(instanceUUID, p)
+	})
+	sched.instMutex.Unlock()
+
+	sched.sendInstancesPreemptedEvent(controllerUUID, instanceUUID, p.nodeUUID, preempted)
+
+	return true
+}
+
+// Give up on a workload whose preempted instances did not stop in time
+func (sched *ssntpSchedulerServer) preemptionTimedOut(instanceUUID string, p *preemptionStat) {
+	sched.instMutex.Lock()
+	if sched.preemptMap[instanceUUID] != p {
+		sched.instMutex.Unlock()
+		return
+	}
+
+	delete(sched.preemptMap, instanceUUID)
+	for victim := range p.victims {
+		if inst := sched.instMap[victim]; inst != nil {
+			inst.preempted = false
+		}
+	}
+	sched.instMutex.Unlock()
+
+	glog.Warningf("Instances preempted for %s did not stop within %v", instanceUUID, sched.preemptionTimeout)
+	sched.sendStartFailureError(p.controllerUUID, instanceUUID, payloads.FullCloud, p.restart)
+}
+
+// Start a workload once the instances preempted for it have stopped
+func (sched *ssntpSchedulerServer) startPreemptingWorkload(p *preemptionStat) {
+	instanceUUID := p.workload.instanceUUID
+
+	glog.V(2).Infof("Starting %s on %s after preemption\n", instanceUUID, p.nodeUUID)
+
+	_, err := sched.ssntp.SendCommand(p.nodeUUID, ssntp.START, p.workload.payload)
+	if err != nil {
+		glog.Errorf("Unable to start %s after preemption: %v", instanceUUID, err)
+		sched.sendStartFailureError(p.controllerUUID, instanceUUID, payloads.FullCloud, p.restart)
+		return
+	}
+
+	sched.addInstance(p.nodeUUID, &p.workload)
+}
+
+func (sched *ssntpSchedulerServer) sendInstancesPreemptedEvent(controllerUUID string, instanceUUID string, nodeUUID string, preempted []string) {
+	event := payloads.EventInstancesPreempted{
+		InstancesPreempted: payloads.InstancesPreemptedEvent{
+			InstanceUUID: instanceUUID,
+			NodeUUID:     nodeUUID,
+			Preempted:    preempted,
+		},
+	}
+
+	payload, err := yaml.Marshal(&event)
+	if err != nil {
+		glog.Errorf("Unable to Marshall InstancesPreempted %v", err)
+		return
+	}
+
+	glog.Infof("Preempting %v on %s for %s\n", preempted, nodeUUID, instanceUUID)
+	sched.ssntp.SendEvent(controllerUUID, ssntp.InstancesPreempted, payload)
+}
+
+// Scoring pass over nodes, starting after the MRU node so that instances are
+// spread over the nodes of a same weight.  Returns the first node the workload
+// fits on with the highest scheduling weight, locked, along with its index,
+// or -1 and nil if the workload does not fit on any node.
+func (sched *ssntpSchedulerServer) scoreNodes(nodes []*nodeStat, mruIndex int, workload *workResources) (int, *nodeStat) {
+	for {
+		best, bestWeight := -1, -1
+		for i := range nodes {
+			index := (mruIndex + 1 + i) % len(nodes)
+			node := nodes[index]
 			node.mutex.Lock()
-			if node == sched.cnMRU {
+			if !sched.workloadFits(node, workload) || node.weight() <= bestWeight {
 				node.mutex.Unlock()
 				continue
 			}
 
-			if sched.workloadFits(node, workload) == true {
-				sched.cnMRUIndex = sched.cnMRUIndex + 1 + i
-				sched.cnMRU = node
-				return node // locked nodeStat
+			// no other node can score higher
+			if node.weight() >= payloads.MaxNodeWeight {
+				return index, node // locked nodeStat
 			}
+
+			best, bestWeight = index, node.weight()
 			node.mutex.Unlock()
 		}
-	}
 
-	/* Then try the whole list, including the MRU */
-	for i, node := range sched.cnList {
+		if best == -1 {
+			return -1, nil
+		}
+
+		// the node may have been claimed since it was scored
+		node := nodes[best]
 		node.mutex.Lock()
-		if sched.workloadFits(node, workload) == true {
-			sched.cnMRUIndex = i
-			sched.cnMRU = node
-			return node // locked nodeStat
+		if sched.workloadFits(node, workload) {
+			return best, node // locked nodeStat
 		}
 		node.mutex.Unlock()
 	}
+}
+
+// Find suitable compute node, returning referenced to a locked nodeStat if found
+func pickComputeNode(sched *ssntpSchedulerServer, controllerUUID string, workload *workResources, restart bool) (node *nodeStat) {
+	sched.cnMutex.RLock()
+	defer sched.cnMutex.RUnlock()
+
+	if len(sched.cnList) == 0 {
+		glog.Errorf("No compute nodes connected, unable to start workload")
+		sched.sendStartFailureError(controllerUUID, workload.instanceUUID, payloads.NoComputeNodes, restart)
+		return nil
+	}
+
+	if i, node := sched.scoreNodes(sched.cnList, sched.cnMRUIndex, workload); node != nil {
+		sched.cnMRUIndex = i
+		sched.cnMRU = node
+		return node // locked nodeStat
+	}
+
+	// Instances are not preempted to make room for a live migration,
+	// which keeps the instance running where it is when it fails.
+	if workload.migrationSource == "" &&
+		sched.preemptInstances(sched.cnList, controllerUUID, workload, restart) {
+		return nil
+	}
 
 	sched.sendStartFailureError(controllerUUID, workload.instanceUUID, payloads.FullCloud, restart)
 	return nil
@@ -701,33 +1164,14 @@ func pickNetworkNode(sched *ssntpSchedulerServer, controllerUUID string, workloa
 		return nil
 	}
 
-	/* First try nodes after the MRU */
-	if sched.nnMRUIndex != -1 && sched.nnMRUIndex < len(sched.nnList)-1 {
-		for i, node := range sched.nnList[sched.nnMRUIndex+1:] {
-			node.mutex.Lock()
-			if node == sched.nnMRU {
-				node.mutex.Unlock()
-				continue
-			}
-
-			if sched.workloadFits(node, workload) == true {
-				sched.nnMRUIndex = sched.nnMRUIndex + 1 + i
-				sched.nnMRU = node
-				return node // locked nodeStat
-			}
-			node.mutex.Unlock()
-		}
+	if i, node := sched.scoreNodes(sched.nnList, sched.nnMRUIndex, workload); node != nil {
+		sched.nnMRUIndex = i
+		sched.nnMRU = node
+		return node // locked nodeStat
 	}
 
-	/* Then try the whole list, including the MRU */
-	for i, node := range sched.nnList {
-		node.mutex.Lock()
-		if sched.workloadFits(node, workload) == true {
-			sched.nnMRUIndex = i
-			sched.nnMRU = node
-			return node // locked nodeStat
-		}
-		node.mutex.Unlock()
+	if sched.preemptInstances(sched.nnList, controllerUUID, workload, restart) {
+		return nil
 	}
 
 	sched.sendStartFailureError(controllerUUID, workload.instanceUUID, payloads.NoNetworkNodes, restart)
@@ -751,12 +1195,19 @@ func startWorkload(sched *ssntpSchedulerServer, controllerUUID string, payload [
 	}
 
 	instanceUUID = workload.instanceUUID
+	workload.payload = payload
 
 	var targetNode *nodeStat
 
 	if workload.requirements.NetworkNode {
 		targetNode = pickNetworkNode(sched, controllerUUID, &workload, work.Start.Restart)
 	} else { //workload.network_node == false
+		// tenant workloads compete with the CNCI of their tenant for
+		// the network of a node running both.
+		if !sched.allowCNCIColocation && workload.tenantUUID != "" {
+			workload.cnciNodes = sched.getCNCINodes(workload.tenantUUID)
+		}
+		workload.dedicated = sched.hasDedicatedNodes(workload.tenantUUID)
 		targetNode = pickComputeNode(sched, controllerUUID, &workload, work.Start.Restart)
 	}
 
@@ -770,6 +1221,8 @@ func startWorkload(sched *ssntpSchedulerServer, controllerUUID string, payload [
 
 		dest.AddRecipient(targetNode.uuid)
 		targetNode.mutex.Unlock()
+
+		sched.addInstance(targetNode.uuid, &workload)
 	} else {
 		// TODO Queue the frame ?
 		dest.SetDecision(ssntp.Discard)
@@ -809,6 +1262,26 @@ func (sched *ssntpSchedulerServer) CommandForward(controllerUUID string, command
 		dest, instanceUUID = startWorkload(sched, controllerUUID, payload)
 	case ssntp.DELETE:
 		fallthrough
+	case ssntp.PAUSE:
+		fallthrough
+	case ssntp.UNPAUSE:
+		fallthrough
+	case ssntp.RESCUE:
+		fallthrough
+	case ssntp.UNRESCUE:
+		fallthrough
+	case ssntp.CONSOLE:
+		fallthrough
+	case ssntp.REBOOT:
+		fallthrough
+	case ssntp.GUESTAGENT:
+		fallthrough
+	case ssntp.CAPTURE:
+		fallthrough
+	case ssntp.MIGRATE:
+		fallthrough
+	case ssntp.UpdateMetadata:
+		fallthrough
 	case ssntp.AttachVolume:
 		fallthrough
 	case ssntp.EVACUATE:
@@ -832,9 +1305,83 @@ func (sched *ssntpSchedulerServer) CommandForward(controllerUUID string, command
 }
 
 func (sched *ssntpSchedulerServer) CommandNotify(uuid string, command ssntp.Command, frame *ssntp.Frame) {
-	// Currently all commands are handled by CommandForward, the SSNTP command forwader,
-	// or directly by role defined forwarding rules.
+	// Apart from NodePolicy and CONFIGURE, all commands are handled by
+	// CommandForward, the SSNTP command forwader, or directly by role
+	// defined forwarding rules.
 	glog.V(2).Infof("COMMAND %v from %s\n", command, uuid)
+
+	switch command {
+	case ssntp.NodePolicy:
+		sched.updateNodePolicies(uuid, frame.Payload)
+	case ssntp.CONFIGURE:
+		sched.updateForwardRules(frame.Payload)
+	}
+}
+
+// Get the scheduling policy set for a node, nil if there is none
+func (sched *ssntpSchedulerServer) getNodePolicy(nodeUUID string) *payloads.NodePolicy {
+	sched.nodePolicyMutex.Lock()
+	defer sched.nodePolicyMutex.Unlock()
+
+	policy, ok := sched.nodePolicies[nodeUUID]
+	if !ok {
+		return nil
+	}
+	return &policy
+}
+
+// Check whether compute nodes are dedicated to a tenant, connected or not
+func (sched *ssntpSchedulerServer) hasDedicatedNodes(tenantUUID string) bool {
+	if tenantUUID == "" {
+		return false
+	}
+
+	sched.nodePolicyMutex.Lock()
+	defer sched.nodePolicyMutex.Unlock()
+
+	for _, policy := range sched.nodePolicies {
+		if policy.DedicatedTenant == tenantUUID {
+			return true
+		}
+	}
+	return false
+}
+
+// Replace the node scheduling policies with the ones sent by a controller
+// and apply them to the connected nodes
+func (sched *ssntpSchedulerServer) updateNodePolicies(controllerUUID string, payload []byte) {
+	var policies payloads.NodePolicies
+	if err := yaml.Unmarshal(payload, &policies); err != nil {
+		glog.Errorf("Bad NodePolicy yaml from Controller %s: %s\n", controllerUUID, err)
+		return
+	}
+
+	nodePolicies := make(map[string]payloads.NodePolicy)
+	for _, policy := range policies.Policies {
+		nodePolicies[policy.NodeUUID] = policy
+	}
+
+	sched.nodePolicyMutex.Lock()
+	sched.nodePolicies = nodePolicies
+	sched.nodePolicyMutex.Unlock()
+
+	glog.Infof("%d node policies set by Controller %s\n", len(nodePolicies), controllerUUID)
+
+	sched.cnMutex.RLock()
+	for _, node := range sched.cnList {
+		node.mutex.Lock()
+		node.policy = sched.getNodePolicy(node.uuid)
+		node.mutex.Unlock()
+	}
+	sched.cnMutex.RUnlock()
+
+	sched.nnMutex.RLock()
+	for _, node := range sched.nnList {
+		node.mutex.Lock()
+		node.policy = sched.getNodePolicy(node.uuid)
+		node.mutex.Unlock()
+	}
+	sched.nnMutex.RUnlock()
 }
 
 func (sched *ssntpSchedulerServer) EventForward(uuid string, event ssntp.Event, frame *ssntp.Frame) (dest ssntp.ForwardDestination) {
@@ -856,13 +1403,45 @@ func (sched *ssntpSchedulerServer) EventForward(uuid string, event ssntp.Event,
 }
 
 func (sched *ssntpSchedulerServer) EventNotify(uuid string, event ssntp.Event, frame *ssntp.Frame) {
-	// Currently all events are handled by EventForward, the SSNTP command forwader,
-	// or directly by role defined forwarding rules.
+	// Events are forwarded by EventForward, the SSNTP command forwader,
+	// or directly by role defined forwarding rules.  The scheduler only
+	// keeps track of the instances which are no longer running.
 	glog.V(2).Infof("EVENT %v from %s\n", event, uuid)
+
+	switch event {
+	case ssntp.InstanceDeleted:
+		var ev payloads.EventInstanceDeleted
+		if err := yaml.Unmarshal(frame.Payload, &ev); err != nil {
+			glog.Errorf("Bad InstanceDeleted yaml from %s\n", uuid)
+			return
+		}
+		sched.removeInstance(ev.InstanceDeleted.InstanceUUID)
+	case ssntp.InstanceStopped:
+		var ev payloads.EventInstanceStopped
+		if err := yaml.Unmarshal(frame.Payload, &ev); err != nil {
+			glog.Errorf("Bad InstanceStopped yaml from %s\n", uuid)
+			return
+		}
+		sched.removeInstance(ev.InstanceStopped.InstanceUUID)
+	}
 }
 
 func (sched *ssntpSchedulerServer) ErrorNotify(uuid string, error ssntp.Error, frame *ssntp.Frame) {
 	glog.V(2).Infof("ERROR %v from %s\n", error, uuid)
+
+	if error != ssntp.StartFailure {
+		return
+	}
+
+	var failure payloads.ErrorStartFailure
+	if err := yaml.Unmarshal(frame.Payload, &failure); err != nil {
+		glog.Errorf("Bad StartFailure yaml from %s\n", uuid)
+		return
+	}
+
+	if failure.Reason.IsFatal() {
+		sched.removeInstance(failure.InstanceUUID)
+	}
 }
 
 func setLimits() {
@@ -1063,6 +1642,30 @@ func setSSNTPForwardRules(sched *ssntpSchedulerServer) {
 			Operand: ssntp.InstanceStopped,
 			Dest:    ssntp.Controller,
 		},
+		{ // all DiskUsageAlert events go to all Controllers
+			Operand: ssntp.DiskUsageAlert,
+			Dest:    ssntp.Controller,
+		},
+		{ // all WatchdogFired events go to all Controllers
+			Operand: ssntp.WatchdogFired,
+			Dest:    ssntp.Controller,
+		},
+		{ // all ConsoleOutput events go to all Controllers
+			Operand: ssntp.ConsoleOutput,
+			Dest:    ssntp.Controller,
+		},
+		{ // all GuestAgentResult events go to all Controllers
+			Operand: ssntp.GuestAgentResult,
+			Dest:    ssntp.Controller,
+		},
+		{ // all PacketCaptureResult events go to all Controllers
+			Operand: ssntp.PacketCaptureResult,
+			Dest:    ssntp.Controller,
+		},
+		{ // all MigrationStatus events go to all Controllers
+			Operand: ssntp.MigrationStatus,
+			Dest:    ssntp.Controller,
+		},
 		{ // all ConcentratorInstanceAdded events go to all Controllers
 			Operand: ssntp.ConcentratorInstanceAdded,
 			Dest:    ssntp.Controller,
@@ -1091,6 +1694,10 @@ func setSSNTPForwardRules(sched *ssntpSchedulerServer) {
 			Operand: ssntp.UnassignPublicIPFailure,
 			Dest:    ssntp.Controller,
 		},
+		{ // all InstanceReachability events go to all Controllers
+			Operand: ssntp.InstanceReachability,
+			Dest:    ssntp.Controller,
+		},
 		{ // all START command are processed by the Command forwarder
 			Operand:        ssntp.START,
 			CommandForward: sched,
@@ -1099,6 +1706,46 @@ func setSSNTPForwardRules(sched *ssntpSchedulerServer) {
 			Operand:        ssntp.DELETE,
 			CommandForward: sched,
 		},
+		{ // all PAUSE command are processed by the Command forwarder
+			Operand:        ssntp.PAUSE,
+			CommandForward: sched,
+		},
+		{ // all UNPAUSE command are processed by the Command forwarder
+			Operand:        ssntp.UNPAUSE,
+			CommandForward: sched,
+		},
+		{ // all RESCUE command are processed by the Command forwarder
+			Operand:        ssntp.RESCUE,
+			CommandForward: sched,
+		},
+		{ // all UNRESCUE command are processed by the Command forwarder
+			Operand:        ssntp.UNRESCUE,
+			CommandForward: sched,
+		},
+		{ // all CONSOLE command are processed by the Command forwarder
+			Operand:        ssntp.CONSOLE,
+			CommandForward: sched,
+		},
+		{ // all REBOOT command are processed by the Command forwarder
+			Operand:        ssntp.REBOOT,
+			CommandForward: sched,
+		},
+		{ // all GUESTAGENT command are processed by the Command forwarder
+			Operand:        ssntp.GUESTAGENT,
+			CommandForward: sched,
+		},
+		{ // all CAPTURE command are processed by the Command forwarder
+			Operand:        ssntp.CAPTURE,
+			CommandForward: sched,
+		},
+		{ // all MIGRATE command are processed by the Command forwarder
+			Operand:        ssntp.MIGRATE,
+			CommandForward: sched,
+		},
+		{ // all UpdateMetadata command are processed by the Command forwarder
+			Operand:        ssntp.UpdateMetadata,
+			CommandForward: sched,
+		},
 		{ // all EVACUATE command are processed by the Command forwarder
 			Operand:        ssntp.EVACUATE,
 			CommandForward: sched,
@@ -1138,6 +1785,51 @@ func setSSNTPForwardRules(sched *ssntpSchedulerServer) {
 	}
 }
 
+func setSSNTPAuthorizationRules(sched *ssntpSchedulerServer) {
+	controllerCommands := []ssntp.Command{
+		ssntp.START,
+		ssntp.DELETE,
+		ssntp.PAUSE,
+		ssntp.UNPAUSE,
+		ssntp.RESCUE,
+		ssntp.UNRESCUE,
+		ssntp.CONSOLE,
+		ssntp.REBOOT,
+		ssntp.GUESTAGENT,
+		ssntp.CAPTURE,
+		ssntp.MIGRATE,
+		ssntp.UpdateMetadata,
+		ssntp.EVACUATE,
+		ssntp.Restore,
+		ssntp.AttachVolume,
+		ssntp.AssignPublicIP,
+		ssntp.ReleasePublicIP,
+		ssntp.CONFIGURE,
+		ssntp.RefreshCNCI,
+		ssntp.NodePolicy,
+	}
+
+	// Cluster wide commands can only come from Controllers
+	for _, cmd := range controllerCommands {
+		sched.config.AuthorizationRules = append(sched.config.AuthorizationRules,
+			ssntp.FrameAuthorizationRule{
+				Operand: cmd,
+				Roles:   ssntp.Controller,
+			})
+	}
+
+	// Console output, guest agent results, packet captures and migration
+	// statuses can only come from the agents running instances
+	for _, event := range []ssntp.Event{ssntp.ConsoleOutput, ssntp.GuestAgentResult,
+		ssntp.PacketCaptureResult, ssntp.MigrationStatus} {
+		sched.config.AuthorizationRules = append(sched.config.AuthorizationRules,
+			ssntp.FrameAuthorizationRule{
+				Operand: event,
+				Roles:   ssntp.AGENT | ssntp.NETAGENT,
+			})
+	}
+}
+
 func initLogger() error {
 	if *prepare {
 		logToStderr := flag.Lookup("logtostderr")
@@ -1168,17 +1860,23 @@ func configSchedulerServer() (sched *ssntpSchedulerServer) {
 	sched = newSsntpSchedulerServer()
 	sched.cpuprofile = *cpuprofile
 	sched.heartbeat = *heartbeat
+	sched.preemption = *preemption
+	sched.preemptionTimeout = *preemptionTimeout
+	sched.allowCNCIColocation = *allowCNCIColocation
 
 	toggleDebug(sched)
 
 	sched.config = &ssntp.Config{
-		CAcert:    *cacert,
-		Cert:      *cert,
-		ConfigURI: *configURI,
-		Log:       ssntp.Log,
+		CAcert:               *cacert,
+		Cert:                 *cert,
+		ConfigURI:            *configURI,
+		Log:                  ssntp.Log,
+		SessionTicketKeyFile: *ticketKeys,
 	}
 
 	setSSNTPForwardRules(sched)
+	setSSNTPAuthorizationRules(sched)
+	loadForwardRules(sched)
 
 	return sched
 }
@@ -1186,6 +1884,22 @@ func configSchedulerServer() (sched *ssntpSchedulerServer) {
 func main() {
 	flag.Parse()
 
+	if *listClients {
+		if err := printClients(*adminAddr, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *drainTo != "" {
+		if err := requestDrain(*adminAddr, *drainTo, *drainTimeout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if err := initLogger(); err != nil {
 		fmt.Printf("Unable to initialise logs: %v", err)
 		return
@@ -1206,5 +1920,11 @@ func main() {
 		return
 	}
 
+	if *adminAddr != "" {
+		go serveAdmin(sched, *adminAddr)
+	}
+
+	waitForLease(sched)
+
 	sched.ssntp.Serve(sched.config, sched)
 }
@@ -19,9 +19,13 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	yaml "gopkg.in/yaml.v2"
 
@@ -208,6 +212,89 @@ func TestPickComputeNode(t *testing.T) {
 	}
 }
 
+func TestPickComputeNodePolicy(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	var work = createStartWorkload(2, 256, 10000)
+	resources, err := sched.getWorkloadResources(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		spinUpComputeNodeLarge(sched, i)
+	}
+	sched.cnMap["00000001"].policy = &payloads.NodePolicy{NodeUUID: "00000001", Weight: 10}
+	sched.cnMap["00000002"].policy = &payloads.NodePolicy{NodeUUID: "00000002", Weight: 50, MaxInstances: 1}
+	sched.cnMap["00000003"].policy = &payloads.NodePolicy{NodeUUID: "00000003", Weight: 0}
+
+	// the highest weight node is picked until it reaches its instance limit
+	node := PickComputeNode(sched, "", &resources, false)
+	if node == nil || node.uuid != "00000002" {
+		t.Fatalf("expected node 00000002, got %v", node)
+	}
+	node.instances++
+	node.mutex.Unlock()
+
+	node = PickComputeNode(sched, "", &resources, false)
+	if node == nil || node.uuid != "00000001" {
+		t.Fatalf("expected node 00000001, got %v", node)
+	}
+	node.mutex.Unlock()
+
+	// nodes with no policy have the maximum weight
+	spinUpComputeNodeLarge(sched, 4)
+	node = PickComputeNode(sched, "", &resources, false)
+	if node == nil || node.uuid != "00000004" {
+		t.Fatalf("expected node 00000004, got %v", node)
+	}
+	node.mutex.Unlock()
+}
+
+func TestPickComputeNodeIO(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	var work = createStartWorkload(2, 256, 10000)
+	work.Start.Requirements.DiskIOPS = 500
+	work.Start.Requirements.NetMbps = 100
+	resources, err := sched.getWorkloadResources(work)
+	if err != nil {
+		t.Fatal("bad workload resources")
+	}
+
+	// compute node without enough IOPS left
+	spinUpComputeNodeLarge(sched, 1)
+	sched.cnMap["00000001"].iopsTotal = 1000
+	sched.cnMap["00000001"].iopsAvail = 100
+	node := PickComputeNode(sched, "", &resources, false)
+	if node != nil {
+		t.Error("found compute fit without enough disk IOPS")
+	}
+
+	// compute node without enough bandwidth left
+	spinUpComputeNodeLarge(sched, 2)
+	sched.cnMap["00000002"].mbpsTotal = 1000
+	sched.cnMap["00000002"].mbpsAvail = 10
+	node = PickComputeNode(sched, "", &resources, false)
+	if node != nil {
+		t.Error("found compute fit without enough network bandwidth")
+	}
+
+	// compute node not tracking I/O resources
+	spinUpComputeNodeLarge(sched, 3)
+	node = PickComputeNode(sched, "", &resources, false)
+	if node == nil || node.uuid != "00000003" {
+		t.Fatal("found no compute fit when one should exist")
+	}
+	node.mutex.Unlock()
+}
+
 func benchmarkPickComputeNode(b *testing.B, nodecount int) {
 	sched = configSchedulerServer()
 	if sched == nil {
@@ -731,6 +818,275 @@ func TestStartWorkload(t *testing.T) {
 	}
 }
 
+func startTenantWorkload(t *testing.T, instanceUUID string, tenantUUID string, networkNode bool) []string {
+	work := createStartWorkload(2, 256, 0)
+	work.Start.InstanceUUID = instanceUUID
+	work.Start.TenantUUID = tenantUUID
+	work.Start.Requirements.NetworkNode = networkNode
+
+	payload, err := yaml.Marshal(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fwd, _ := startWorkload(sched, "", payload)
+	return fwd.Recipients()
+}
+
+func TestCNCIColocation(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	// node 1 is both a compute and a network node
+	spinUpComputeNodeLarge(sched, 1)
+	spinUpComputeNodeLarge(sched, 2)
+	spinUpNetworkNodeLarge(sched, 1, nil)
+
+	dest := startTenantWorkload(t, "cnci-a", "tenant-a", true)
+	if len(dest) != 1 || dest[0] != "00000001" {
+		t.Fatalf("expected CNCI on node 00000001, got %v", dest)
+	}
+
+	// workloads of the tenant are kept away from its CNCI
+	for i := 0; i < 3; i++ {
+		dest = startTenantWorkload(t, fmt.Sprintf("a-%d", i), "tenant-a", false)
+		if len(dest) != 1 || dest[0] != "00000002" {
+			t.Fatalf("expected tenant workload on node 00000002, got %v", dest)
+		}
+	}
+
+	// but not the workloads of other tenants
+	dest = startTenantWorkload(t, "b-0", "tenant-b", false)
+	if len(dest) != 1 || dest[0] != "00000001" {
+		t.Fatalf("expected other tenant workload on node 00000001, got %v", dest)
+	}
+
+	// nor when the policy of the node allows it
+	sched.cnMap["00000001"].policy = &payloads.NodePolicy{
+		NodeUUID:            "00000001",
+		Weight:              payloads.MaxNodeWeight,
+		AllowCNCIColocation: true,
+	}
+	sched.cnMap["00000002"].policy = &payloads.NodePolicy{NodeUUID: "00000002", Weight: 0}
+
+	dest = startTenantWorkload(t, "a-3", "tenant-a", false)
+	if len(dest) != 1 || dest[0] != "00000001" {
+		t.Fatalf("expected tenant workload on node 00000001, got %v", dest)
+	}
+
+	// the workload does not fit anywhere else
+	sched.cnMap["00000001"].policy = nil
+	sched.cnMap["00000002"].status = ssntp.FULL
+
+	dest = startTenantWorkload(t, "a-4", "tenant-a", false)
+	if len(dest) != 0 {
+		t.Fatalf("expected tenant workload not to start, got %v", dest)
+	}
+
+	sched.allowCNCIColocation = true
+	dest = startTenantWorkload(t, "a-5", "tenant-a", false)
+	if len(dest) != 1 || dest[0] != "00000001" {
+		t.Fatalf("expected tenant workload on node 00000001, got %v", dest)
+	}
+	sched.allowCNCIColocation = false
+
+	// the CNCI is no longer known once deleted
+	sched.removeInstance("cnci-a")
+
+	dest = startTenantWorkload(t, "a-6", "tenant-a", false)
+	if len(dest) != 1 || dest[0] != "00000001" {
+		t.Fatalf("expected tenant workload on node 00000001, got %v", dest)
+	}
+}
+
+func setNodePolicies(t *testing.T, policies ...payloads.NodePolicy) {
+	payload, err := yaml.Marshal(payloads.NodePolicies{Policies: policies})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sched.updateNodePolicies("", payload)
+}
+
+func TestDedicatedHosts(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	spinUpComputeNodeLarge(sched, 1)
+	spinUpComputeNodeLarge(sched, 2)
+	spinUpNetworkNodeLarge(sched, 2, nil)
+
+	setNodePolicies(t, payloads.NodePolicy{
+		NodeUUID:        "00000002",
+		Weight:          payloads.MaxNodeWeight,
+		DedicatedTenant: "tenant-a",
+	})
+
+	// the workloads of the tenant only run on its dedicated node
+	for i := 0; i < 3; i++ {
+		dest := startTenantWorkload(t, fmt.Sprintf("a-%d", i), "tenant-a", false)
+		if len(dest) != 1 || dest[0] != "00000002" {
+			t.Fatalf("expected tenant workload on node 00000002, got %v", dest)
+		}
+	}
+
+	// and other tenants never run on it
+	for i := 0; i < 3; i++ {
+		dest := startTenantWorkload(t, fmt.Sprintf("b-%d", i), "tenant-b", false)
+		if len(dest) != 1 || dest[0] != "00000001" {
+			t.Fatalf("expected other tenant workload on node 00000001, got %v", dest)
+		}
+	}
+
+	// CNCIs are not affected by dedicated hosts
+	dest := startTenantWorkload(t, "cnci-b", "tenant-b", true)
+	if len(dest) != 1 || dest[0] != "00000002" {
+		t.Fatalf("expected CNCI on node 00000002, got %v", dest)
+	}
+
+	// the tenant does not fall back on shared nodes
+	sched.cnMap["00000002"].status = ssntp.FULL
+	dest = startTenantWorkload(t, "a-3", "tenant-a", false)
+	if len(dest) != 0 {
+		t.Fatalf("expected tenant workload not to start, got %v", dest)
+	}
+	sched.cnMap["00000002"].status = ssntp.READY
+
+	// nor once its dedicated node is disconnected
+	setNodePolicies(t, payloads.NodePolicy{
+		NodeUUID:        "00000003",
+		Weight:          payloads.MaxNodeWeight,
+		DedicatedTenant: "tenant-a",
+	})
+	dest = startTenantWorkload(t, "a-4", "tenant-a", false)
+	if len(dest) != 0 {
+		t.Fatalf("expected tenant workload not to start, got %v", dest)
+	}
+
+	// the node is shared again once the policies are reset
+	setNodePolicies(t)
+	dest = startTenantWorkload(t, "a-5", "tenant-a", false)
+	if len(dest) != 1 {
+		t.Fatalf("expected tenant workload to start, got %v", dest)
+	}
+}
+
+func startPriorityWorkload(t *testing.T, instanceUUID string, memMB int, priority payloads.Priority) ssntp.ForwardDecision {
+	work := createStartWorkload(2, memMB, 0)
+	work.Start.InstanceUUID = instanceUUID
+	work.Start.Requirements.Priority = priority
+
+	payload, err := yaml.Marshal(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fwd, _ := startWorkload(sched, "", payload)
+	return fwd.Decision()
+}
+
+func pendingPreemption(instanceUUID string) *preemptionStat {
+	sched.instMutex.Lock()
+	defer sched.instMutex.Unlock()
+
+	return sched.preemptMap[instanceUUID]
+}
+
+func TestPreemption(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+	sched.preemptionTimeout = time.Hour
+
+	spinUpComputeNode(sched, 1, 1024)
+
+	// fill the node with low priority instances
+	for _, uuid := range []string{"low-1", "low-2"} {
+		if startPriorityWorkload(t, uuid, 512, payloads.LowPriority) != ssntp.Forward {
+			t.Fatalf("unable to start %s", uuid)
+		}
+	}
+
+	// preemption is disabled by default
+	if startPriorityWorkload(t, "high", 768, payloads.HighPriority) != ssntp.Discard ||
+		pendingPreemption("high") != nil {
+		t.Fatal("instances preempted with preemption disabled")
+	}
+
+	sched.preemption = true
+
+	if startPriorityWorkload(t, "high", 768, payloads.HighPriority) != ssntp.Discard {
+		t.Fatal("high priority workload started on a full node")
+	}
+
+	p := pendingPreemption("high")
+	if p == nil || p.nodeUUID != "00000001" || len(p.victims) != 2 ||
+		!p.victims["low-1"] || !p.victims["low-2"] {
+		t.Fatalf("expected low-1 and low-2 to be preempted, got %+v", p)
+	}
+
+	// already preempted instances are not preempted again, and instances
+	// do not preempt instances of the same priority
+	if startPriorityWorkload(t, "normal", 256, payloads.NormalPriority) != ssntp.Discard ||
+		pendingPreemption("normal") != nil {
+		t.Fatal("instances preempted twice")
+	}
+
+	if startPriorityWorkload(t, "low-3", 256, payloads.LowPriority) != ssntp.Discard ||
+		pendingPreemption("low-3") != nil {
+		t.Fatal("instances preempted by an instance of the same priority")
+	}
+
+	sched.removeInstance("low-1")
+	if pendingPreemption("high") == nil {
+		t.Fatal("preemption completed before all instances stopped")
+	}
+
+	sched.removeInstance("low-2")
+	if pendingPreemption("high") != nil {
+		t.Fatal("preemption not completed after all instances stopped")
+	}
+}
+
+func TestPreemptionTimeout(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+	sched.preemption = true
+	sched.preemptionTimeout = 10 * time.Millisecond
+
+	spinUpComputeNode(sched, 1, 512)
+
+	if startPriorityWorkload(t, "low", 512, payloads.LowPriority) != ssntp.Forward {
+		t.Fatal("unable to start low priority workload")
+	}
+
+	if startPriorityWorkload(t, "normal", 512, payloads.NormalPriority) != ssntp.Discard ||
+		pendingPreemption("normal") == nil {
+		t.Fatal("low priority instance not preempted")
+	}
+
+	for i := 0; pendingPreemption("normal") != nil; i++ {
+		if i == 100 {
+			t.Fatal("preemption did not time out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	sched.instMutex.Lock()
+	preempted := sched.instMap["low"].preempted
+	sched.instMutex.Unlock()
+	if preempted {
+		t.Fatal("instance still preempted after timeout")
+	}
+}
+
 func TestGetWorkloadAgentUUID(t *testing.T) {
 	sched = configSchedulerServer()
 	if sched == nil {
@@ -758,3 +1114,152 @@ func TestGetWorkloadAgentUUID(t *testing.T) {
 		}
 	}
 }
+
+func TestConfiguredForwardRules(t *testing.T) {
+	conf := payloads.ConfigureScheduler{
+		ForwardRules: []payloads.ForwardRule{
+			{Type: "event", Operand: 32, Dest: "controller,cnciagent"},
+			{Type: "command", Operand: 33, Dest: "agent"},
+		},
+	}
+
+	rules, err := configuredForwardRules(conf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(rules) != 2 {
+		t.Fatalf("Expected 2 rules, got %d", len(rules))
+	}
+
+	if rules[0].Operand != ssntp.Event(32) || rules[0].Dest != ssntp.Controller|ssntp.CNCIAGENT {
+		t.Errorf("Wrong event rule %v", rules[0])
+	}
+
+	if rules[1].Operand != ssntp.Command(33) || rules[1].Dest != ssntp.AGENT {
+		t.Errorf("Wrong command rule %v", rules[1])
+	}
+
+	for _, r := range []payloads.ForwardRule{
+		{Type: "frame", Operand: 32, Dest: "controller"},
+		{Type: "event", Operand: 32, Dest: "compute"},
+		{Type: "event", Operand: 32},
+	} {
+		conf.ForwardRules = []payloads.ForwardRule{r}
+		if _, err := configuredForwardRules(conf); err == nil {
+			t.Errorf("Expected an error for rule %v", r)
+		}
+	}
+}
+
+func TestSchedulerLease(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scheduler-lease")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "lease")
+	primary := &schedulerLease{path: path, holder: "primary", timeout: time.Minute}
+	standby := &schedulerLease{path: path, holder: "standby", timeout: time.Minute}
+
+	now := time.Now()
+
+	if held, err := primary.tryAcquire(now); err != nil || !held {
+		t.Fatalf("Primary did not acquire free lease: %v", err)
+	}
+
+	if held, err := standby.tryAcquire(now.Add(30 * time.Second)); err != nil || held {
+		t.Fatalf("Standby acquired lease held by primary: %v", err)
+	}
+
+	if held, err := primary.tryAcquire(now.Add(30 * time.Second)); err != nil || !held {
+		t.Fatalf("Primary did not renew its lease: %v", err)
+	}
+
+	if held, err := standby.tryAcquire(now.Add(time.Minute)); err != nil || held {
+		t.Fatalf("Standby acquired renewed lease: %v", err)
+	}
+
+	if held, err := standby.tryAcquire(now.Add(2 * time.Minute)); err != nil || !held {
+		t.Fatalf("Standby did not acquire expired lease: %v", err)
+	}
+
+	if held, err := primary.tryAcquire(now.Add(2 * time.Minute)); err != nil || held {
+		t.Fatalf("Primary acquired lease taken by standby: %v", err)
+	}
+
+	_, record, err := primary.read()
+	if err != nil || record.Holder != "standby" {
+		t.Errorf("Wrong lease holder %s: %v", record.Holder, err)
+	}
+}
+
+func TestSchedulerLeaseLost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scheduler-lease")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "lease")
+	lease := &schedulerLease{path: path, holder: "primary", timeout: 300 * time.Millisecond}
+	lease.acquire()
+
+	// a standby whose clock is ahead takes the lease
+	standby := &schedulerLease{path: path, holder: "standby", timeout: time.Hour}
+	if held, err := standby.tryAcquire(time.Now().Add(time.Minute)); err != nil || !held {
+		t.Fatalf("Standby did not acquire lease: %v", err)
+	}
+
+	lost := make(chan struct{})
+	go lease.renew(func() { close(lost) })
+
+	select {
+	case <-lost:
+	case <-time.After(time.Second):
+		t.Fatal("Lease loss not detected")
+	}
+}
+
+// Test that only one of the schedulers trying to take a lease at the same
+// time holds it.
+func TestSchedulerLeaseRace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scheduler-lease")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "lease")
+	now := time.Now()
+
+	for round := 0; round < 3; round++ {
+		var wg sync.WaitGroup
+		var holders int32
+
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				lease := &schedulerLease{path: path, holder: fmt.Sprintf("scheduler-%d-%d", round, i), timeout: time.Minute}
+				held, err := lease.tryAcquire(now)
+				if err != nil {
+					t.Errorf("Unable to acquire lease: %v", err)
+				} else if held {
+					atomic.AddInt32(&holders, 1)
+				}
+			}(i)
+		}
+
+		wg.Wait()
+
+		if holders != 1 {
+			t.Fatalf("Round %d: %d schedulers acquired the lease", round, holders)
+		}
+
+		// let the lease expire for the next round
+		now = now.Add(2 * time.Minute)
+	}
+}
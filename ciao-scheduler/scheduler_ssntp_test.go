@@ -17,8 +17,13 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -453,6 +458,272 @@ func TestPublicIPUnassigned(t *testing.T) {
 	}
 }
 
+func TestInstanceReachability(t *testing.T) {
+	controllerCh := controller.AddEventChan(ssntp.InstanceReachability)
+
+	go cnciAgent.SendInstanceReachabilityEvent()
+
+	_, err := controller.GetEventChanResult(controllerCh, ssntp.InstanceReachability)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiskUsageAlert(t *testing.T) {
+	agentCh := agent.AddEventChan(ssntp.DiskUsageAlert)
+	controllerCh := controller.AddEventChan(ssntp.DiskUsageAlert)
+
+	go agent.SendDiskUsageAlertEvent(testutil.InstanceUUID, 850, 1000, 80)
+
+	_, err := agent.GetEventChanResult(agentCh, ssntp.DiskUsageAlert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = controller.GetEventChanResult(controllerCh, ssntp.DiskUsageAlert)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchdogFired(t *testing.T) {
+	agentCh := agent.AddEventChan(ssntp.WatchdogFired)
+	controllerCh := controller.AddEventChan(ssntp.WatchdogFired)
+
+	go agent.SendWatchdogFiredEvent(testutil.InstanceUUID, payloads.WatchdogReset)
+
+	_, err := agent.GetEventChanResult(agentCh, ssntp.WatchdogFired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = controller.GetEventChanResult(controllerCh, ssntp.WatchdogFired)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConsole(t *testing.T) {
+	agentCh := agent.AddCmdChan(ssntp.CONSOLE)
+
+	go controller.Ssntp.SendCommand(ssntp.CONSOLE, []byte(testutil.ConsoleYaml))
+
+	_, err := agent.GetCmdChanResult(agentCh, ssntp.CONSOLE)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReboot(t *testing.T) {
+	agentCh := agent.AddCmdChan(ssntp.REBOOT)
+
+	go controller.Ssntp.SendCommand(ssntp.REBOOT, []byte(testutil.RebootYaml))
+
+	_, err := agent.GetCmdChanResult(agentCh, ssntp.REBOOT)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGuestAgent(t *testing.T) {
+	agentCh := agent.AddCmdChan(ssntp.GUESTAGENT)
+
+	go controller.Ssntp.SendCommand(ssntp.GUESTAGENT, []byte(testutil.GuestAgentYaml))
+
+	result, err := agent.GetCmdChanResult(agentCh, ssntp.GUESTAGENT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.InstanceUUID != testutil.InstanceUUID {
+		t.Fatalf("Wrong instance UUID %s", result.InstanceUUID)
+	}
+}
+
+func TestGuestAgentResult(t *testing.T) {
+	agentCh := agent.AddEventChan(ssntp.GuestAgentResult)
+	controllerCh := controller.AddEventChan(ssntp.GuestAgentResult)
+
+	go agent.SendGuestAgentResultEvent(testutil.InstanceUUID, testutil.GuestAgentRequestUUID)
+
+	_, err := agent.GetEventChanResult(agentCh, ssntp.GuestAgentResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = controller.GetEventChanResult(controllerCh, ssntp.GuestAgentResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPacketCapture(t *testing.T) {
+	agentCh := agent.AddCmdChan(ssntp.CAPTURE)
+
+	go controller.Ssntp.SendCommand(ssntp.CAPTURE, []byte(testutil.PacketCaptureYaml))
+
+	result, err := agent.GetCmdChanResult(agentCh, ssntp.CAPTURE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.InstanceUUID != testutil.InstanceUUID {
+		t.Fatalf("Wrong instance UUID %s", result.InstanceUUID)
+	}
+}
+
+func TestPacketCaptureResult(t *testing.T) {
+	agentCh := agent.AddEventChan(ssntp.PacketCaptureResult)
+	controllerCh := controller.AddEventChan(ssntp.PacketCaptureResult)
+
+	go agent.SendPacketCaptureResultEvent(testutil.InstanceUUID, testutil.CaptureUUID)
+
+	_, err := agent.GetEventChanResult(agentCh, ssntp.PacketCaptureResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = controller.GetEventChanResult(controllerCh, ssntp.PacketCaptureResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	agentCh := agent.AddCmdChan(ssntp.MIGRATE)
+
+	go controller.Ssntp.SendCommand(ssntp.MIGRATE, []byte(testutil.LiveMigrateYaml))
+
+	result, err := agent.GetCmdChanResult(agentCh, ssntp.MIGRATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.InstanceUUID != testutil.InstanceUUID {
+		t.Fatalf("Wrong instance UUID %s", result.InstanceUUID)
+	}
+}
+
+func TestUpdateMetadata(t *testing.T) {
+	agentCh := agent.AddCmdChan(ssntp.UpdateMetadata)
+
+	go controller.Ssntp.SendCommand(ssntp.UpdateMetadata, []byte(testutil.UpdateMetadataYaml))
+
+	result, err := agent.GetCmdChanResult(agentCh, ssntp.UpdateMetadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.InstanceUUID != testutil.InstanceUUID {
+		t.Fatalf("Wrong instance UUID %s", result.InstanceUUID)
+	}
+}
+
+func TestMigrationStatus(t *testing.T) {
+	agentCh := agent.AddEventChan(ssntp.MigrationStatus)
+	controllerCh := controller.AddEventChan(ssntp.MigrationStatus)
+
+	go agent.SendMigrationStatusEvent(testutil.InstanceUUID, payloads.MigrationIncoming, testutil.MigrationAddress)
+
+	_, err := agent.GetEventChanResult(agentCh, ssntp.MigrationStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = controller.GetEventChanResult(controllerCh, ssntp.MigrationStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConsoleOutput(t *testing.T) {
+	agentCh := agent.AddEventChan(ssntp.ConsoleOutput)
+	controllerCh := controller.AddEventChan(ssntp.ConsoleOutput)
+
+	go agent.SendConsoleOutputEvent(testutil.InstanceUUID, testutil.ConsoleSessionUUID, "login: ", false)
+
+	_, err := agent.GetEventChanResult(agentCh, ssntp.ConsoleOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = controller.GetEventChanResult(controllerCh, ssntp.ConsoleOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAdminClients(t *testing.T) {
+	admin := httptest.NewServer(http.HandlerFunc(server.clientsHandler))
+	defer admin.Close()
+
+	resp, err := http.Get(admin.URL + "/clients")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var clients []adminClient
+	err = json.NewDecoder(resp.Body).Decode(&clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roles := make(map[string]string)
+	for _, c := range clients {
+		if c.RemoteAddr == "" || c.ConnectTime.IsZero() {
+			t.Errorf("Wrong client information %+v", c)
+		}
+		roles[c.UUID] = c.Role
+	}
+
+	if roles[testutil.AgentUUID] != "CNAgent" || roles[testutil.NetAgentUUID] != "NetworkingAgent" ||
+		roles[testutil.CNCIUUID] != "CNCIAgent" || roles[controller.UUID] != "Controller" {
+		t.Fatalf("Wrong connected clients %v", roles)
+	}
+
+	var out bytes.Buffer
+	err = printClients(strings.TrimPrefix(admin.URL, "http://"), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), testutil.AgentUUID) {
+		t.Fatalf("Agent missing from clients table:\n%s", out.String())
+	}
+}
+
+func TestAdminDrainInvalid(t *testing.T) {
+	admin := httptest.NewServer(http.HandlerFunc(server.drainHandler))
+	defer admin.Close()
+
+	resp, err := http.Get(admin.URL + "/drain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+
+	addr := strings.TrimPrefix(admin.URL, "http://")
+
+	err = requestDrain(addr, "", time.Second)
+	if err == nil || !strings.Contains(err.Error(), "Missing uri") {
+		t.Fatalf("Expected missing uri error, got %v", err)
+	}
+
+	err = requestDrain(addr, testutil.RedirectURI, 0)
+	if err == nil || !strings.Contains(err.Error(), "Invalid timeout") {
+		t.Fatalf("Expected invalid timeout error, got %v", err)
+	}
+
+	if server.ssntp.Draining() {
+		t.Fatal("Scheduler drained by an invalid request")
+	}
+}
+
 func waitForController(uuid string) {
 	for {
 		server.controllerMutex.Lock()
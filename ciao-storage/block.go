@@ -16,6 +16,7 @@ package storage
 
 import (
 	"errors"
+	"io"
 )
 
 var (
@@ -26,17 +27,25 @@ var (
 // BlockDriver is the interface that all block drivers must implement.
 type BlockDriver interface {
 	CreateBlockDevice(volumeUUID string, image string, sizeGB int) (BlockDevice, error)
-	CreateBlockDeviceFromSnapshot(volumeUUID string, snapshotID string) (BlockDevice, error)
+	CreateBlockDeviceFromStream(volumeUUID string, data io.Reader) (BlockDevice, error)
+	CreateBlockDeviceFromSnapshot(volumeUUID string, snapshotID string, cloneUUID string) (BlockDevice, error)
 	CreateBlockDeviceSnapshot(volumeUUID string, snapshotID string) error
 	DeleteBlockDevice(string) error
 	DeleteBlockDeviceSnapshot(volumeUUID string, snapshotID string) error
 	MapVolumeToNode(volumeUUID string) (string, error)
 	UnmapVolumeFromNode(volumeUUID string) error
 	GetVolumeMapping() (map[string][]string, error)
-	CopyBlockDevice(string) (BlockDevice, error)
+	ListBlockDevices() ([]string, error)
+	CopyBlockDevice(volumeUUID string, copyUUID string) (BlockDevice, error)
+	CloneBlockDevice(volumeUUID string, snapshotID string, cloneUUID string) (BlockDevice, error)
+	FlattenBlockDevice(volumeUUID string) error
+	ExportBlockDevice(volumeUUID string, path string) error
+	ReadBlockDevice(volumeUUID string, data io.Writer) error
 	GetBlockDeviceSize(volumeUUID string) (uint64, error)
+	GetBlockDeviceUsage(volumeUUID string) (uint64, error)
 	IsValidSnapshotUUID(string) error
 	Resize(volumeUUID string, sizeGiB int) (int, error)
+	WithPool(pool string) BlockDriver
 }
 
 // BlockDevice contains information about a block device
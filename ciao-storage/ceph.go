@@ -19,6 +19,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os/exec"
 	"strconv"
 	"strings"
@@ -26,10 +27,75 @@ import (
 	"github.com/ciao-project/ciao/uuid"
 )
 
+// newDeviceUUID validates the requested block device UUID, or generates
+// a random one if none is requested.
+func newDeviceUUID(requested string) (string, error) {
+	if requested == "" {
+		return uuid.Generate().String(), nil
+	}
+
+	_, err := uuid.Parse(requested)
+	if err != nil {
+		return "", fmt.Errorf("invalid UUID supplied for volume ID")
+	}
+
+	return requested, nil
+}
+
+// defaultPool is the pool rbd images are created in when no pool is given.
+const defaultPool = "rbd"
+
 // CephDriver maintains context for the ceph driver interface.
 type CephDriver struct {
 	// ID is the cephx user ID to use
 	ID string
+
+	// Pool is the pool holding the rbd images, or the default pool
+	// of the cluster if empty
+	Pool string
+
+	// ImagePool is the pool holding the image snapshots block devices
+	// are cloned from, or Pool if empty
+	ImagePool string
+}
+
+// WithPool returns a driver managing the rbd images of another pool.  Block
+// devices are still cloned from the image snapshots of the original pool.
+func (d CephDriver) WithPool(pool string) BlockDriver {
+	imagePool := d.ImagePool
+	if imagePool == "" {
+		imagePool = d.Pool
+	}
+	if imagePool == "" {
+		imagePool = defaultPool
+	}
+
+	return CephDriver{
+		ID:        d.ID,
+		Pool:      pool,
+		ImagePool: imagePool,
+	}
+}
+
+func poolSpec(pool string, name string) string {
+	if pool == "" {
+		return name
+	}
+	return pool + "/" + name
+}
+
+// spec returns the rbd image spec of a block device of the pool.
+func (d CephDriver) spec(volumeUUID string) string {
+	return poolSpec(d.Pool, volumeUUID)
+}
+
+// imageSpec returns the rbd image spec of an image block devices are
+// cloned from.
+func (d CephDriver) imageSpec(volumeUUID string) string {
+	if d.ImagePool == "" {
+		return d.spec(volumeUUID)
+	}
+	return poolSpec(d.ImagePool, volumeUUID)
 }
 
 func (d CephDriver) getBlockDeviceSizeGiB(volumeUUID string) (int, error) {
@@ -65,11 +131,15 @@ func (d CephDriver) CreateBlockDevice(volumeUUID string, imagePath string, size
 	// Currently the kernel rdb client only supports layering but in the future more feaures
 	// should be added as they are enabled in the kernel.
 	if imagePath != "" {
-		rbdStr := fmt.Sprintf("rbd:rbd/%s:id=%s", volumeUUID, d.ID)
+		pool := d.Pool
+		if pool == "" {
+			pool = defaultPool
+		}
+		rbdStr := fmt.Sprintf("rbd:%s/%s:id=%s", pool, volumeUUID, d.ID)
 		cmd = exec.Command("qemu-img", "convert", "-O", "rbd", imagePath, rbdStr)
 	} else {
 		// create an empty volume
-		cmd = exec.Command("rbd", "--id", d.ID, "--image-feature", "layering", "create", "--size", strconv.Itoa(size)+"G", volumeUUID)
+		cmd = exec.Command("rbd", "--id", d.ID, "--image-feature", "layering", "create", "--size", strconv.Itoa(size)+"G", d.spec(volumeUUID))
 	}
 
 	out, err := cmd.CombinedOutput()
@@ -80,22 +150,57 @@ func (d CephDriver) CreateBlockDevice(volumeUUID string, imagePath string, size
 	return BlockDevice{ID: volumeUUID, Size: size}, nil
 }
 
+// CreateBlockDeviceFromStream will create a rbd image in the ceph cluster and
+// fill it with raw data read from the provided stream. The data is piped into
+// the cluster without being staged on the local filesystem.
+func (d CephDriver) CreateBlockDeviceFromStream(volumeUUID string, data io.Reader) (BlockDevice, error) {
+	if volumeUUID == "" {
+		volumeUUID = uuid.Generate().String()
+	} else {
+		_, err := uuid.Parse(volumeUUID)
+		if err != nil {
+			return BlockDevice{}, fmt.Errorf("invalid UUID supplied for volume ID")
+		}
+	}
+
+	args := append(d.getCredentials(), "--image-feature", "layering", "--no-progress", "import", "-", d.spec(volumeUUID))
+	cmd := exec.Command("rbd", args...)
+	cmd.Stdin = data
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return BlockDevice{}, fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, out)
+	}
+
+	size, err := d.getBlockDeviceSizeGiB(volumeUUID)
+	if err != nil {
+		_ = d.DeleteBlockDevice(volumeUUID)
+		return BlockDevice{}, fmt.Errorf("Error when querying block device size: %v", err)
+	}
+
+	return BlockDevice{ID: volumeUUID, Size: size}, nil
+}
+
 // CreateBlockDeviceFromSnapshot will create a block device derived from the previously created snapshot.
-func (d CephDriver) CreateBlockDeviceFromSnapshot(volumeUUID string, snapshotID string) (BlockDevice, error) {
-	ID := uuid.Generate().String()
+// The new block device UUID is cloneUUID, or a random one if cloneUUID is empty.
+func (d CephDriver) CreateBlockDeviceFromSnapshot(volumeUUID string, snapshotID string, cloneUUID string) (BlockDevice, error) {
+	ID, err := newDeviceUUID(cloneUUID)
+	if err != nil {
+		return BlockDevice{}, err
+	}
 
 	var cmd *exec.Cmd
 
-	cmd = exec.Command("rbd", "--id", d.ID, "clone", volumeUUID+"@"+snapshotID, ID)
+	cmd = exec.Command("rbd", "--id", d.ID, "clone", d.imageSpec(volumeUUID)+"@"+snapshotID, d.spec(ID))
 
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return BlockDevice{}, fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, out)
 	}
 
-	size, err := d.getBlockDeviceSizeGiB(volumeUUID)
+	size, err := d.getBlockDeviceSizeGiB(ID)
 	if err != nil {
-		d.DeleteBlockDevice(volumeUUID)
+		d.DeleteBlockDevice(ID)
 		return BlockDevice{}, fmt.Errorf("Error when querying block device size: %v", err)
 	}
 
@@ -105,14 +210,14 @@ func (d CephDriver) CreateBlockDeviceFromSnapshot(volumeUUID string, snapshotID
 // CreateBlockDeviceSnapshot creates and protects the snapshot with the provided name
 func (d CephDriver) CreateBlockDeviceSnapshot(volumeUUID string, snapshotID string) error {
 	var cmd *exec.Cmd
-	cmd = exec.Command("rbd", "--id", d.ID, "snap", "create", volumeUUID+"@"+snapshotID)
+	cmd = exec.Command("rbd", "--id", d.ID, "snap", "create", d.spec(volumeUUID)+"@"+snapshotID)
 
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, out)
 	}
 
-	cmd = exec.Command("rbd", "--id", d.ID, "snap", "protect", volumeUUID+"@"+snapshotID)
+	cmd = exec.Command("rbd", "--id", d.ID, "snap", "protect", d.spec(volumeUUID)+"@"+snapshotID)
 
 	out, err = cmd.CombinedOutput()
 	if err != nil {
@@ -123,30 +228,107 @@ func (d CephDriver) CreateBlockDeviceSnapshot(volumeUUID string, snapshotID stri
 }
 
 // CopyBlockDevice will copy an existing volume
-func (d CephDriver) CopyBlockDevice(volumeUUID string) (BlockDevice, error) {
-	ID := uuid.Generate().String()
+// The copy UUID is copyUUID, or a random one if copyUUID is empty.
+func (d CephDriver) CopyBlockDevice(volumeUUID string, copyUUID string) (BlockDevice, error) {
+	ID, err := newDeviceUUID(copyUUID)
+	if err != nil {
+		return BlockDevice{}, err
+	}
 
 	var cmd *exec.Cmd
 
-	cmd = exec.Command("rbd", "--id", d.ID, "cp", volumeUUID, ID)
+	cmd = exec.Command("rbd", "--id", d.ID, "cp", d.spec(volumeUUID), d.spec(ID))
 
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return BlockDevice{}, fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, out)
 	}
 
-	size, err := d.getBlockDeviceSizeGiB(volumeUUID)
+	size, err := d.getBlockDeviceSizeGiB(ID)
 	if err != nil {
-		d.DeleteBlockDevice(volumeUUID)
+		d.DeleteBlockDevice(ID)
+		return BlockDevice{}, fmt.Errorf("Error when querying block device size: %v", err)
+	}
+
+	return BlockDevice{ID: ID, Size: size}, nil
+}
+
+// CloneBlockDevice will create a copy-on-write clone of a snapshot of a
+// block device of the pool.  The clone UUID is cloneUUID, or a random one
+// if cloneUUID is empty.
+func (d CephDriver) CloneBlockDevice(volumeUUID string, snapshotID string, cloneUUID string) (BlockDevice, error) {
+	ID, err := newDeviceUUID(cloneUUID)
+	if err != nil {
+		return BlockDevice{}, err
+	}
+
+	cmd := exec.Command("rbd", "--id", d.ID, "clone", d.spec(volumeUUID)+"@"+snapshotID, d.spec(ID))
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return BlockDevice{}, fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, out)
+	}
+
+	size, err := d.getBlockDeviceSizeGiB(ID)
+	if err != nil {
+		d.DeleteBlockDevice(ID)
 		return BlockDevice{}, fmt.Errorf("Error when querying block device size: %v", err)
 	}
 
 	return BlockDevice{ID: ID, Size: size}, nil
 }
 
+// FlattenBlockDevice will copy the data a clone shares with its parent
+// snapshot into the clone, so that the snapshot can be deleted.
+func (d CephDriver) FlattenBlockDevice(volumeUUID string) error {
+	cmd := exec.Command("rbd", "--id", d.ID, "flatten", d.spec(volumeUUID))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, out)
+	}
+	return nil
+}
+
+// ExportBlockDevice will convert a rbd image into a qcow2 image file
+// created at path.
+func (d CephDriver) ExportBlockDevice(volumeUUID string, path string) error {
+	pool := d.Pool
+	if pool == "" {
+		pool = defaultPool
+	}
+	rbdStr := fmt.Sprintf("rbd:%s/%s:id=%s", pool, volumeUUID, d.ID)
+
+	cmd := exec.Command("qemu-img", "convert", "-O", "qcow2", rbdStr, path)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, out)
+	}
+
+	return nil
+}
+
+// ReadBlockDevice will write the raw content of a rbd image to the
+// provided stream. The data is piped out of the cluster without being
+// staged on the local filesystem.
+func (d CephDriver) ReadBlockDevice(volumeUUID string, data io.Writer) error {
+	var stderr bytes.Buffer
+
+	args := append(d.getCredentials(), "--no-progress", "export", d.spec(volumeUUID), "-")
+	cmd := exec.Command("rbd", args...)
+	cmd.Stdout = data
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, stderr.Bytes())
+	}
+
+	return nil
+}
+
 // DeleteBlockDevice will remove a rbd image from the ceph cluster.
 func (d CephDriver) DeleteBlockDevice(volumeUUID string) error {
-	cmd := exec.Command("rbd", "--id", d.ID, "rm", volumeUUID)
+	cmd := exec.Command("rbd", "--id", d.ID, "rm", d.spec(volumeUUID))
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, out)
@@ -158,13 +340,13 @@ func (d CephDriver) DeleteBlockDevice(volumeUUID string) error {
 func (d CephDriver) DeleteBlockDeviceSnapshot(volumeUUID string, snapshotID string) error {
 	var cmd *exec.Cmd
 
-	cmd = exec.Command("rbd", "--id", d.ID, "snap", "unprotect", volumeUUID+"@"+snapshotID)
+	cmd = exec.Command("rbd", "--id", d.ID, "snap", "unprotect", d.spec(volumeUUID)+"@"+snapshotID)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, out)
 	}
 
-	cmd = exec.Command("rbd", "--id", d.ID, "snap", "rm", volumeUUID+"@"+snapshotID)
+	cmd = exec.Command("rbd", "--id", d.ID, "snap", "rm", d.spec(volumeUUID)+"@"+snapshotID)
 	out, err = cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, out)
@@ -174,7 +356,7 @@ func (d CephDriver) DeleteBlockDeviceSnapshot(volumeUUID string, snapshotID stri
 
 // GetBlockDeviceSize returns the number of bytes used by the block device
 func (d CephDriver) GetBlockDeviceSize(volumeUUID string) (uint64, error) {
-	args := append(d.getCredentials(), "info", "--format", "json", volumeUUID)
+	args := append(d.getCredentials(), "info", "--format", "json", d.spec(volumeUUID))
 	cmd := exec.Command("rbd", args...)
 	data, err := cmd.Output()
 	if err != nil {
@@ -195,6 +377,44 @@ func (d CephDriver) GetBlockDeviceSize(volumeUUID string) (uint64, error) {
 	return infoData.Size, nil
 }
 
+// GetBlockDeviceUsage returns the number of bytes actually allocated to the
+// thin-provisioned block device, excluding its snapshots.
+func (d CephDriver) GetBlockDeviceUsage(volumeUUID string) (uint64, error) {
+	args := append(d.getCredentials(), "du", "--format", "json", d.spec(volumeUUID))
+	cmd := exec.Command("rbd", args...)
+	data, err := cmd.Output()
+	if err != nil {
+		if err, ok := err.(*exec.ExitError); ok {
+			return 0, fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, err.Stderr)
+		}
+		return 0, fmt.Errorf("Error when running: %v: %v", cmd.Args, err)
+	}
+
+	return parseDiskUsage(volumeUUID, data)
+}
+
+func parseDiskUsage(volumeUUID string, data []byte) (uint64, error) {
+	duData := struct {
+		Images []struct {
+			Name     string `json:"name"`
+			Snapshot string `json:"snapshot"`
+			UsedSize uint64 `json:"used_size"`
+		} `json:"images"`
+	}{}
+	err := json.Unmarshal(data, &duData)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to parse output from rbd du: %v", err)
+	}
+
+	for _, image := range duData.Images {
+		if image.Name == volumeUUID && image.Snapshot == "" {
+			return image.UsedSize, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Unable to find %s in output from rbd du", volumeUUID)
+}
+
 func (d CephDriver) getCredentials() []string {
 	args := make([]string, 0, 8)
 	if d.ID != "" {
@@ -206,7 +426,7 @@ func (d CephDriver) getCredentials() []string {
 // MapVolumeToNode maps a ceph volume to a rbd device on a node.  The
 // path to the new device is returned if the mapping succeeds.
 func (d CephDriver) MapVolumeToNode(volumeUUID string) (string, error) {
-	args := append(d.getCredentials(), "map", volumeUUID)
+	args := append(d.getCredentials(), "map", d.spec(volumeUUID))
 	cmd := exec.Command("rbd", args...)
 	data, err := cmd.Output()
 	if err != nil {
@@ -221,7 +441,7 @@ func (d CephDriver) MapVolumeToNode(volumeUUID string) (string, error) {
 
 // UnmapVolumeFromNode unmaps a ceph volume from a local device on a node.
 func (d CephDriver) UnmapVolumeFromNode(volumeUUID string) error {
-	args := append(d.getCredentials(), "unmap", volumeUUID)
+	args := append(d.getCredentials(), "unmap", d.spec(volumeUUID))
 	cmd := exec.Command("rbd", args...)
 
 	out, err := cmd.CombinedOutput()
@@ -261,6 +481,30 @@ func (d CephDriver) GetVolumeMapping() (map[string][]string, error) {
 	return volumeDevMap, nil
 }
 
+// ListBlockDevices returns the IDs of all the rbd images in the ceph cluster.
+func (d CephDriver) ListBlockDevices() ([]string, error) {
+	args := append(d.getCredentials(), "ls", "--format", "json")
+	if d.Pool != "" {
+		args = append(args, d.Pool)
+	}
+	cmd := exec.Command("rbd", args...)
+	data, err := cmd.Output()
+	if err != nil {
+		if err, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, err.Stderr)
+		}
+		return nil, fmt.Errorf("Error when running: %v: %v", cmd.Args, err)
+	}
+
+	var devices []string
+	err = json.Unmarshal(data, &devices)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse output from rbd ls: %v", err)
+	}
+
+	return devices, nil
+}
+
 // IsValidSnapshotUUID returns true if the uuid matches the ciao/ceph expected
 // form of {UUID}@{UUID}
 func (d CephDriver) IsValidSnapshotUUID(snapshotUUID string) error {
@@ -279,7 +523,7 @@ func (d CephDriver) IsValidSnapshotUUID(snapshotUUID string) error {
 
 // Resize the underlying rbd image. Only extending is permitted. Returns the new size in GiB.
 func (d CephDriver) Resize(volumeUUID string, sizeGiB int) (int, error) {
-	args := append(d.getCredentials(), "resize", volumeUUID, "--no-progress", "-s", fmt.Sprintf("%dG", sizeGiB))
+	args := append(d.getCredentials(), "resize", d.spec(volumeUUID), "--no-progress", "-s", fmt.Sprintf("%dG", sizeGiB))
 	cmd := exec.Command("rbd", args...)
 
 	out, err := cmd.CombinedOutput()
@@ -15,6 +15,10 @@
 package storage_test
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/ciao-project/ciao/ciao-storage"
@@ -45,3 +49,143 @@ func TestCephIsValidSnapshotUUID(t *testing.T) {
 		t.Errorf("expected nil, got \"%s\"", err)
 	}
 }
+
+// fakeRBD installs an rbd script printing output in the PATH of the test.
+// The arguments of the rbd commands run are written to the returned file.
+func fakeRBD(t *testing.T, output string) (string, func()) {
+	dir, err := ioutil.TempDir("", "ceph-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	argsPath := filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$@\" >> " + argsPath + "\ncat <<EOF\n" + output + "\nEOF\n"
+	err = ioutil.WriteFile(filepath.Join(dir, "rbd"), []byte(script), 0755)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	path := os.Getenv("PATH")
+	_ = os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+
+	return argsPath, func() {
+		_ = os.Setenv("PATH", path)
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestCephGetBlockDeviceUsage(t *testing.T) {
+	volumeUUID := "dc1d3e23-e32a-49f5-8c59-402c13031d49"
+	_, cleanup := fakeRBD(t, `{"images":[`+
+		`{"name":"`+volumeUUID+`","snapshot":"e1f4834b-af32-46d9-8ec3-e4cea3de78cb","provisioned_size":10737418240,"used_size":4194304},`+
+		`{"name":"`+volumeUUID+`","provisioned_size":10737418240,"used_size":12582912}],`+
+		`"total_provisioned_size":10737418240,"total_used_size":16777216}`)
+	defer cleanup()
+
+	used, err := cephDriver.GetBlockDeviceUsage(volumeUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if used != 12582912 {
+		t.Errorf("expected 12582912 bytes used, got %d", used)
+	}
+
+	_, err = cephDriver.GetBlockDeviceUsage("a2dec44c-e1b5-40c0-a2b1-bc700d12cfde")
+	if err == nil {
+		t.Errorf("expected error for unknown volume")
+	}
+}
+
+func TestCephWithPool(t *testing.T) {
+	imageUUID := "dc1d3e23-e32a-49f5-8c59-402c13031d49"
+	cloneUUID := "a2dec44c-e1b5-40c0-a2b1-bc700d12cfde"
+	argsPath, cleanup := fakeRBD(t, `{"size":1073741824}`)
+	defer cleanup()
+
+	driver := cephDriver.WithPool("ssd")
+
+	_, err := driver.CreateBlockDeviceFromSnapshot(imageUUID, "ciao-image", cloneUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = driver.DeleteBlockDevice(cloneUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args, err := ioutil.ReadFile(argsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "--id unittest clone rbd/" + imageUUID + "@ciao-image ssd/" + cloneUUID + "\n" +
+		"--id unittest info --format json ssd/" + cloneUUID + "\n" +
+		"--id unittest rm ssd/" + cloneUUID + "\n"
+	if string(args) != expected {
+		t.Errorf("expected rbd commands\n%s\ngot\n%s", expected, args)
+	}
+}
+
+func TestCephCloneBlockDevice(t *testing.T) {
+	volumeUUID := "dc1d3e23-e32a-49f5-8c59-402c13031d49"
+	cloneUUID := "a2dec44c-e1b5-40c0-a2b1-bc700d12cfde"
+	argsPath, cleanup := fakeRBD(t, `{"size":1073741824}`)
+	defer cleanup()
+
+	driver := cephDriver.WithPool("ssd")
+
+	bd, err := driver.CloneBlockDevice(volumeUUID, "ciao-clone", cloneUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bd.ID != cloneUUID || bd.Size != 1 {
+		t.Errorf("unexpected clone %+v", bd)
+	}
+
+	err = driver.FlattenBlockDevice(cloneUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args, err := ioutil.ReadFile(argsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "--id unittest clone ssd/" + volumeUUID + "@ciao-clone ssd/" + cloneUUID + "\n" +
+		"--id unittest info --format json ssd/" + cloneUUID + "\n" +
+		"--id unittest flatten ssd/" + cloneUUID + "\n"
+	if string(args) != expected {
+		t.Errorf("expected rbd commands\n%s\ngot\n%s", expected, args)
+	}
+}
+
+func TestCephReadBlockDevice(t *testing.T) {
+	volumeUUID := "dc1d3e23-e32a-49f5-8c59-402c13031d49"
+	argsPath, cleanup := fakeRBD(t, "image data")
+	defer cleanup()
+
+	var data bytes.Buffer
+	err := cephDriver.ReadBlockDevice(volumeUUID, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data.String() != "image data\n" {
+		t.Errorf("expected \"image data\\n\", got %q", data.String())
+	}
+
+	args, err := ioutil.ReadFile(argsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "--id unittest --no-progress export " + volumeUUID + " -\n"
+	if string(args) != expected {
+		t.Errorf("expected rbd commands\n%s\ngot\n%s", expected, args)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+func encryptedDeviceName(volumeUUID string) string {
+	return "ciao-" + volumeUUID
+}
+
+// EncryptedDevicePath returns the path of the decrypted device of an
+// encrypted volume opened with OpenEncryptedDevice.
+func EncryptedDevicePath(volumeUUID string) string {
+	return "/dev/mapper/" + encryptedDeviceName(volumeUUID)
+}
+
+func cryptsetup(key string, args ...string) error {
+	cmd := exec.Command("cryptsetup", args...)
+	if key != "" {
+		cmd.Stdin = strings.NewReader(key)
+	}
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, out)
+	}
+	return nil
+}
+
+// OpenEncryptedDevice opens the LUKS container of an encrypted volume
+// mapped to device with the passphrase key, and returns the path of the
+// decrypted device.  Volumes are created empty so the container is
+// formatted the first time the volume is opened.  Discards are passed
+// through so that thin-provisioned volumes can release freed space.
+func OpenEncryptedDevice(device string, volumeUUID string, key string) (string, error) {
+	path := EncryptedDevicePath(volumeUUID)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	if err := cryptsetup("", "isLuks", device); err != nil {
+		err = cryptsetup(key, "luksFormat", "--batch-mode", "--key-file=-", device)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err := cryptsetup(key, "luksOpen", "--allow-discards", "--key-file=-", device, encryptedDeviceName(volumeUUID))
+	if err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// CloseEncryptedDevice closes the decrypted device of an encrypted volume.
+func CloseEncryptedDevice(volumeUUID string) error {
+	return cryptsetup("", "luksClose", encryptedDeviceName(volumeUUID))
+}
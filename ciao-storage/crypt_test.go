@@ -0,0 +1,121 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ciao-project/ciao/ciao-storage"
+)
+
+// fakeCryptsetup installs a cryptsetup script in the PATH of the test,
+// reporting whether the device is a LUKS container.  The arguments of the
+// cryptsetup commands run, followed by the key they are given, are written
+// to the returned file.
+func fakeCryptsetup(t *testing.T, luks bool) (string, func()) {
+	dir, err := ioutil.TempDir("", "crypt-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	isLuks := 1
+	if luks {
+		isLuks = 0
+	}
+
+	argsPath := filepath.Join(dir, "args")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$@\" >> %s\n"+
+		"if [ \"$1\" = isLuks ]; then exit %d; fi\n"+
+		"if [ \"$1\" != luksClose ]; then cat >> %s; echo >> %s; fi\n",
+		argsPath, isLuks, argsPath, argsPath)
+	err = ioutil.WriteFile(filepath.Join(dir, "cryptsetup"), []byte(script), 0755)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	path := os.Getenv("PATH")
+	_ = os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+
+	return argsPath, func() {
+		_ = os.Setenv("PATH", path)
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func checkCryptsetupArgs(t *testing.T, argsPath string, expected []string) {
+	data, err := ioutil.ReadFile(argsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if strings.Join(args, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected cryptsetup calls %v, got %v", expected, args)
+	}
+}
+
+func TestOpenEncryptedDevice(t *testing.T) {
+	volumeUUID := "dc1d3e23-e32a-49f5-8c59-402c13031d49"
+
+	argsPath, cleanup := fakeCryptsetup(t, false)
+	defer cleanup()
+
+	path, err := storage.OpenEncryptedDevice("/dev/rbd0", volumeUUID, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if path != storage.EncryptedDevicePath(volumeUUID) {
+		t.Errorf("unexpected decrypted device %s", path)
+	}
+
+	checkCryptsetupArgs(t, argsPath, []string{
+		"isLuks /dev/rbd0",
+		"luksFormat --batch-mode --key-file=- /dev/rbd0",
+		"secret",
+		"luksOpen --allow-discards --key-file=- /dev/rbd0 ciao-" + volumeUUID,
+		"secret",
+	})
+}
+
+func TestOpenFormattedEncryptedDevice(t *testing.T) {
+	volumeUUID := "dc1d3e23-e32a-49f5-8c59-402c13031d49"
+
+	argsPath, cleanup := fakeCryptsetup(t, true)
+	defer cleanup()
+
+	_, err := storage.OpenEncryptedDevice("/dev/rbd0", volumeUUID, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.CloseEncryptedDevice(volumeUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkCryptsetupArgs(t, argsPath, []string{
+		"isLuks /dev/rbd0",
+		"luksOpen --allow-discards --key-file=- /dev/rbd0 ciao-" + volumeUUID,
+		"secret",
+		"luksClose ciao-" + volumeUUID,
+	})
+}
@@ -16,6 +16,8 @@ package storage
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"strings"
 	"sync/atomic"
 
@@ -32,8 +34,23 @@ func (d *NoopDriver) CreateBlockDevice(volumeUUID string, image string, size int
 	return BlockDevice{ID: uuid.Generate().String(), Size: size}, nil
 }
 
+// CreateBlockDeviceFromStream pretends to create a block device from a stream.
+// The stream is fully consumed.
+func (d *NoopDriver) CreateBlockDeviceFromStream(volumeUUID string, data io.Reader) (BlockDevice, error) {
+	_, err := io.Copy(ioutil.Discard, data)
+	if err != nil {
+		return BlockDevice{}, err
+	}
+
+	return BlockDevice{ID: uuid.Generate().String()}, nil
+}
+
 // CreateBlockDeviceFromSnapshot pretends to create a block device snapshot
-func (d *NoopDriver) CreateBlockDeviceFromSnapshot(volumeUUID string, snapshotID string) (BlockDevice, error) {
+func (d *NoopDriver) CreateBlockDeviceFromSnapshot(volumeUUID string, snapshotID string, cloneUUID string) (BlockDevice, error) {
+	if cloneUUID != "" {
+		return BlockDevice{ID: cloneUUID}, nil
+	}
+
 	return BlockDevice{ID: uuid.Generate().String() + "@" + uuid.Generate().String()}, nil
 }
 
@@ -43,10 +60,39 @@ func (d *NoopDriver) CreateBlockDeviceSnapshot(volumeUUID string, snapshotID str
 }
 
 // CopyBlockDevice pretends to copy an existing block device
-func (d *NoopDriver) CopyBlockDevice(string) (BlockDevice, error) {
+func (d *NoopDriver) CopyBlockDevice(volumeUUID string, copyUUID string) (BlockDevice, error) {
+	if copyUUID != "" {
+		return BlockDevice{ID: copyUUID}, nil
+	}
+
 	return BlockDevice{ID: uuid.Generate().String()}, nil
 }
 
+// CloneBlockDevice pretends to clone a snapshot of a block device
+func (d *NoopDriver) CloneBlockDevice(volumeUUID string, snapshotID string, cloneUUID string) (BlockDevice, error) {
+	if cloneUUID != "" {
+		return BlockDevice{ID: cloneUUID}, nil
+	}
+
+	return BlockDevice{ID: uuid.Generate().String()}, nil
+}
+
+// FlattenBlockDevice pretends to flatten a block device clone
+func (d *NoopDriver) FlattenBlockDevice(volumeUUID string) error {
+	return nil
+}
+
+// ExportBlockDevice pretends to export a block device by creating an empty
+// file at path.
+func (d *NoopDriver) ExportBlockDevice(volumeUUID string, path string) error {
+	return ioutil.WriteFile(path, nil, 0600)
+}
+
+// ReadBlockDevice pretends to read a block device, which is empty.
+func (d *NoopDriver) ReadBlockDevice(volumeUUID string, data io.Writer) error {
+	return nil
+}
+
 // DeleteBlockDevice pretends to delete a block device.
 func (d *NoopDriver) DeleteBlockDevice(string) error {
 	return nil
@@ -62,6 +108,11 @@ func (d *NoopDriver) GetBlockDeviceSize(volumeUUID string) (uint64, error) {
 	return 0, nil
 }
 
+// GetBlockDeviceUsage pretends to return the number of bytes allocated to the block device
+func (d *NoopDriver) GetBlockDeviceUsage(volumeUUID string) (uint64, error) {
+	return 0, nil
+}
+
 // MapVolumeToNode pretends to map a volume to a local device on a node.
 func (d *NoopDriver) MapVolumeToNode(volumeUUID string) (string, error) {
 	dNum := atomic.AddInt64(&d.deviceNum, 1)
@@ -79,6 +130,11 @@ func (d *NoopDriver) GetVolumeMapping() (map[string][]string, error) {
 	return nil, nil
 }
 
+// ListBlockDevices returns an empty slice, as no devices are ever created.
+func (d *NoopDriver) ListBlockDevices() ([]string, error) {
+	return nil, nil
+}
+
 // IsValidSnapshotUUID checks for the Ciao standard snapshot uuid form of
 // {UUID}@{UUID}
 func (d *NoopDriver) IsValidSnapshotUUID(snapshotUUID string) error {
@@ -99,3 +155,8 @@ func (d *NoopDriver) IsValidSnapshotUUID(snapshotUUID string) error {
 func (d *NoopDriver) Resize(volumeUUID string, sizeGiB int) (int, error) {
 	return sizeGiB, nil
 }
+
+// WithPool returns the driver itself as it does not manage any pool.
+func (d *NoopDriver) WithPool(pool string) BlockDriver {
+	return d
+}
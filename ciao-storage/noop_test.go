@@ -15,6 +15,8 @@
 package storage_test
 
 import (
+	"bytes"
+	"io/ioutil"
 	"os"
 	"testing"
 
@@ -46,6 +48,29 @@ func TestNoopCreateBlockDevice(t *testing.T) {
 	}
 }
 
+// Check creating a block device from a stream works
+//
+// TestNoopCreateBlockDeviceFromStream creates a block device from an
+// in memory stream, checks for errors and that the stream has been
+// consumed, and then deletes it.
+func TestNoopCreateBlockDeviceFromStream(t *testing.T) {
+	data := bytes.NewReader(make([]byte, 1024))
+
+	device, err := noopDriver.CreateBlockDeviceFromStream("", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data.Len() != 0 {
+		t.Fatalf("Stream not consumed: %d bytes left", data.Len())
+	}
+
+	err = noopDriver.DeleteBlockDevice(device.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Check copying a ceph backed block device works
 //
 // TestCopyBlockDevice creates a block device containing some random data,
@@ -63,7 +88,7 @@ func TestNoopCopyBlockDevice(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	copy, err := noopDriver.CopyBlockDevice(device.ID)
+	copy, err := noopDriver.CopyBlockDevice(device.ID, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -79,6 +104,46 @@ func TestNoopCopyBlockDevice(t *testing.T) {
 	}
 }
 
+func TestNoopExportBlockDevice(t *testing.T) {
+	device, err := noopDriver.CreateBlockDevice("", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ioutil.TempFile("", "noop-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	defer os.Remove(f.Name())
+
+	err = noopDriver.ExportBlockDevice(device.ID, f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNoopReadBlockDevice(t *testing.T) {
+	device, err := noopDriver.CreateBlockDevice("", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data bytes.Buffer
+	err = noopDriver.ReadBlockDevice(device.ID, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data.Len() != 0 {
+		t.Fatalf("Expected an empty device, read %d bytes", data.Len())
+	}
+}
+
 func TestNoopMappings(t *testing.T) {
 	s, err := noopDriver.MapVolumeToNode("")
 	if err != nil || s != "/dev/blk1" {
@@ -107,7 +172,7 @@ func TestNoopSnapshots(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	bd, err := noopDriver.CreateBlockDeviceFromSnapshot("", "")
+	bd, err := noopDriver.CreateBlockDeviceFromSnapshot("", "", "")
 	if err != nil || bd.ID == "" {
 		t.Fatal(err)
 	}
@@ -117,6 +182,16 @@ func TestNoopSnapshots(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	bd, err = noopDriver.CloneBlockDevice("", "", "")
+	if err != nil || bd.ID == "" {
+		t.Fatal(err)
+	}
+
+	err = noopDriver.FlattenBlockDevice(bd.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	err = noopDriver.DeleteBlockDeviceSnapshot("", "")
 	if err != nil {
 		t.Fatal(err)
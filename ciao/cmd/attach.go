@@ -35,7 +35,17 @@ var attachIPCmd = &cobra.Command{
 	Long:  `Attach an external IP from a given pool to an instance.`,
 	Args:  cobra.ExactArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return errors.Wrap(c.MapExternalIP(args[0], args[1]), "Error mapping external IP")
+		pool, err := c.ResolvePool(args[0])
+		if err != nil {
+			return err
+		}
+
+		instance, err := c.ResolveInstance(args[1])
+		if err != nil {
+			return err
+		}
+
+		return errors.Wrap(c.MapExternalIP(pool, instance), "Error mapping external IP")
 	},
 }
 
@@ -44,7 +54,17 @@ var attachVolCmd = &cobra.Command{
 	Short: `Attach a volume to an instance`,
 	Args:  cobra.ExactArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return errors.Wrap(c.AttachVolume(args[0], args[1], volAttachFlags.mountpoint, volAttachFlags.mode),
+		volume, err := c.ResolveVolume(args[0])
+		if err != nil {
+			return err
+		}
+
+		instance, err := c.ResolveInstance(args[1])
+		if err != nil {
+			return err
+		}
+
+		return errors.Wrap(c.AttachVolume(volume, instance, volAttachFlags.mountpoint, volAttachFlags.mode),
 			"Error attaching volume")
 	},
 }
@@ -0,0 +1,174 @@
+// Copyright © 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/pkg/errors"
+)
+
+// Output formats of the listing commands producing capacity reports.
+const (
+	formatTable = "table"
+	formatCSV   = "csv"
+)
+
+func checkFormat(format string) error {
+	if format != formatTable && format != formatCSV {
+		return errors.Errorf("Unknown output format %s, expected %s or %s", format, formatTable, formatCSV)
+	}
+
+	return nil
+}
+
+// capacity is the total, allocated and free amount of a resource.  A
+// negative total means the amount of the resource is unlimited.
+type capacity struct {
+	total     int
+	allocated int
+}
+
+func (c capacity) unlimited() bool {
+	return c.total < 0
+}
+
+func (c capacity) add(o capacity) capacity {
+	sum := capacity{
+		total:     c.total + o.total,
+		allocated: c.allocated + o.allocated,
+	}
+
+	if c.unlimited() || o.unlimited() {
+		sum.total = -1
+	}
+
+	return sum
+}
+
+func (c capacity) fields() []string {
+	if c.unlimited() {
+		return []string{"unlimited", strconv.Itoa(c.allocated), "unlimited"}
+	}
+
+	return []string{strconv.Itoa(c.total), strconv.Itoa(c.allocated), strconv.Itoa(c.total - c.allocated)}
+}
+
+func capacityHeader(resources ...string) []string {
+	var header []string
+	for _, r := range resources {
+		header = append(header, r+" Total", r+" Allocated", r+" Free")
+	}
+
+	return header
+}
+
+// writeCapacityCSV writes a capacity report as CSV, one record per row
+// followed by a record with the totals of the capacities of all the rows.
+func writeCapacityCSV(w io.Writer, header []string, labels [][]string, rows [][]capacity) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	var totals []capacity
+	for i, row := range rows {
+		record := labels[i]
+		for j, c := range row {
+			record = append(record, c.fields()...)
+
+			if j == len(totals) {
+				totals = append(totals, c)
+			} else {
+				totals[j] = totals[j].add(c)
+			}
+		}
+
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	if len(rows) > 0 {
+		record := make([]string, len(labels[0]))
+		record[0] = "Total"
+		for _, c := range totals {
+			record = append(record, c.fields()...)
+		}
+
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// writeNodesCSV writes the CPU, memory and disk capacity of nodes as CSV.
+func writeNodesCSV(w io.Writer, nodes []types.CiaoNode) error {
+	header := append([]string{"ID", "Hostname", "Status"},
+		capacityHeader("CPUs", "Memory (MB)", "Disk (MB)")...)
+
+	var labels [][]string
+	var rows [][]capacity
+	for _, n := range nodes {
+		labels = append(labels, []string{n.ID, n.Hostname, n.Status})
+		rows = append(rows, []capacity{
+			{n.OnlineCPUs, n.VCPUsAllocated},
+			{n.MemTotal, n.MemTotal - n.MemAvailable},
+			{n.DiskTotal, n.DiskTotal - n.DiskAvailable},
+		})
+	}
+
+	return writeCapacityCSV(w, header, labels, rows)
+}
+
+// quotaCapacity returns the capacity of a tenant defined by a quota.
+func quotaCapacity(quotas []types.QuotaDetails, name string) capacity {
+	for _, q := range quotas {
+		if q.Name == name {
+			return capacity{q.Value, q.Usage}
+		}
+	}
+
+	return capacity{-1, 0}
+}
+
+// writeTenantsCSV writes the CPU, memory and disk quota utilization of
+// tenants as CSV.
+func writeTenantsCSV(w io.Writer, tenants []types.TenantSummary, quotas map[string][]types.QuotaDetails) error {
+	header := append([]string{"ID", "Name"},
+		capacityHeader("CPUs", "Memory (MB)", "Disk (GB)")...)
+
+	var labels [][]string
+	var rows [][]capacity
+	for _, t := range tenants {
+		q := quotas[t.ID]
+		labels = append(labels, []string{t.ID, t.Name})
+		rows = append(rows, []capacity{
+			quotaCapacity(q, "tenant-vcpu-quota"),
+			quotaCapacity(q, "tenant-mem-quota"),
+			quotaCapacity(q, "tenant-storage-quota"),
+		})
+	}
+
+	return writeCapacityCSV(w, header, labels, rows)
+}
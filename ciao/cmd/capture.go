@@ -0,0 +1,85 @@
+// Copyright © 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var captureFlags = struct {
+	file     string
+	maxBytes int
+	duration time.Duration
+	filter   string
+}{}
+
+var captureCmd = &cobra.Command{
+	Use:   "capture INSTANCE",
+	Short: "Capture the network traffic of an instance",
+	Long: `Capture the network traffic of a running instance on its compute node, e.g.,
+to debug the connectivity of a tenant. The capture stops once it reaches
+its maximum size or duration, at most 32MB and 5 minutes, and the packets
+captured are written as a pcap file to INSTANCE.pcap unless a file is
+given. The capture can be restricted with a pcap filter expression, e.g.,
+"icmp or arp".`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		instance, err := c.ResolveInstance(args[0])
+		if err != nil {
+			return err
+		}
+
+		result, err := c.CaptureInstance(instance, types.PacketCaptureRequest{
+			MaxBytes:   captureFlags.maxBytes,
+			MaxSeconds: int(captureFlags.duration / time.Second),
+			Filter:     captureFlags.filter,
+		})
+		if err != nil {
+			return errors.Wrap(err, "Error capturing instance traffic")
+		}
+
+		path := captureFlags.file
+		if path == "" {
+			path = instance + ".pcap"
+		}
+
+		err = ioutil.WriteFile(path, result.Pcap, 0600)
+		if err != nil {
+			return errors.Wrap(err, "Error writing capture file")
+		}
+
+		fmt.Printf("Captured %d bytes of traffic of instance %s on node %s to %s\n",
+			len(result.Pcap), instance, result.NodeID, path)
+		if result.Truncated {
+			fmt.Println("The capture was stopped as it reached its maximum size")
+		}
+		return nil
+	},
+}
+
+func init() {
+	captureCmd.Flags().StringVar(&captureFlags.file, "file", "", "File to write the pcap file to")
+	captureCmd.Flags().IntVar(&captureFlags.maxBytes, "max-bytes", 0, "Size after which the capture stops (default 4MB)")
+	captureCmd.Flags().DurationVar(&captureFlags.duration, "duration", 0, "Time after which the capture stops (default 1m)")
+	captureCmd.Flags().StringVar(&captureFlags.filter, "filter", "", "pcap filter expression selecting the packets to capture")
+
+	rootCmd.AddCommand(captureCmd)
+}
@@ -0,0 +1,70 @@
+// Copyright © 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/intel/tfortools"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var cloneFlags = struct {
+	instances int
+	name      string
+}{}
+
+var cloneInstanceCmd = &cobra.Command{
+	Use:   "instance INSTANCE",
+	Short: "Create copies of a stopped instance",
+	Long: `Create copies of a stopped instance, booting from copy-on-write clones of
+its boot volume. The copies are instances of the same workload with new IDs,
+addresses and host names.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if cloneFlags.instances < 1 {
+			return errors.New("A number of instances of 1 or more is required")
+		}
+
+		instance, err := c.ResolveInstance(args[0])
+		if err != nil {
+			return err
+		}
+
+		servers, err := c.CloneInstance(instance, cloneFlags.instances, cloneFlags.name)
+		if err != nil {
+			return errors.Wrap(err, "Error cloning instance")
+		}
+
+		return render(cmd, servers.Servers)
+	},
+	Annotations: map[string]string{
+		"default_template": `{{ table (cols . "Name" "ID" "Status") }}`,
+		"template_usage":   tfortools.GenerateUsageUndecorated([]api.ServerDetails{}),
+	},
+}
+
+var cloneCmd = &cobra.Command{
+	Use:   "clone",
+	Short: "Clone an object in the cluster",
+}
+
+func init() {
+	cloneInstanceCmd.Flags().IntVar(&cloneFlags.instances, "instances", 1, "Number of copies to create")
+	cloneInstanceCmd.Flags().StringVar(&cloneFlags.name, "name", "", "Name of the copies, suffixed by their index when there are several")
+
+	cloneCmd.AddCommand(cloneInstanceCmd)
+	rootCmd.AddCommand(cloneCmd)
+}
@@ -0,0 +1,118 @@
+// Copyright © 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var consolePollInterval time.Duration
+
+var consoleInstanceCmd = &cobra.Command{
+	Use:   "instance INSTANCE",
+	Short: "Open an emergency console session with an instance",
+	Long: `Relay the serial console of a VM instance, or the terminal of a container
+whose workload asks for a console, through the scheduler and the launcher of
+its node, for when the network of the instance is broken. Input
+is sent line by line. The session ends at the end of the input, when
+interrupted or when it is closed by the cluster.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if consolePollInterval <= 0 {
+			return errors.New("The poll interval must be positive")
+		}
+
+		instance, err := c.ResolveInstance(args[0])
+		if err != nil {
+			return err
+		}
+
+		session, err := c.OpenConsole(instance)
+		if err != nil {
+			return errors.Wrap(err, "Error opening console")
+		}
+
+		fmt.Fprintf(os.Stderr, "Console session %s opened, expires at %s\n",
+			session.ID, session.ExpireTime.Format(time.RFC3339))
+
+		return runConsole(session.ID, os.Stdin, os.Stdout)
+	},
+}
+
+// runConsole relays the lines read from in to a console session and the
+// output of the console to out, polling for output between lines.
+func runConsole(sessionID string, in io.Reader, out io.Writer) error {
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			lines <- scanner.Text() + "\n"
+		}
+		close(lines)
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	defer signal.Stop(sigCh)
+
+	ticker := time.NewTicker(consolePollInterval)
+	defer ticker.Stop()
+
+	for {
+		input := ""
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return errors.Wrap(c.CloseConsole(sessionID), "Error closing console")
+			}
+			input = line
+		case <-sigCh:
+			return errors.Wrap(c.CloseConsole(sessionID), "Error closing console")
+		case <-ticker.C:
+		}
+
+		session, err := c.WriteConsole(sessionID, input)
+		if err != nil {
+			return errors.Wrap(err, "Error using console")
+		}
+
+		_, _ = io.WriteString(out, session.Output)
+
+		if session.Closed {
+			fmt.Fprintln(os.Stderr, "Console session closed")
+			return nil
+		}
+	}
+}
+
+var consoleCmd = &cobra.Command{
+	Use:   "console",
+	Short: "Open a console session with an object in the cluster",
+}
+
+func init() {
+	consoleInstanceCmd.Flags().DurationVar(&consolePollInterval, "poll", 500*time.Millisecond, "Interval between polls for console output")
+
+	consoleCmd.AddCommand(consoleInstanceCmd)
+	rootCmd.AddCommand(consoleCmd)
+}
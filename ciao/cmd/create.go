@@ -15,14 +15,18 @@
 package cmd
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"regexp"
+	"strings"
+	"time"
 
 	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/ciao-project/ciao/payloads"
 	"github.com/ciao-project/ciao/uuid"
+	"github.com/intel/tfortools"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -35,25 +39,68 @@ var createCmd = &cobra.Command{
 }
 
 var imgFlags = struct {
+	checksum   string
 	id         string
+	url        string
 	visibility string
 }{}
 
 var instanceFlags = struct {
-	instances int
-	label     string
-	name      string
-	workload  string
+	instances   int
+	ip          string
+	group       string
+	label       string
+	name        string
+	preemptible bool
+	workload    string
+}{}
+
+var peerFlags = struct {
+	caCert    string
+	tenantID  string
+	token     string
+	url       string
+	workloads []string
+}{}
+
+var secretFlags = struct {
+	file string
+}{}
+
+var snapshotFlags = struct {
+	name              string
+	allowInconsistent bool
+}{}
+
+var tokenFlags = struct {
+	expiry   string
+	readOnly bool
 }{}
 
 var tenantFlags = struct {
 	cidrPrefixSize             int
 	name                       string
 	createPrivilegedContainers bool
+	guestAgent                 bool
+	cnciFlavor                 types.CNCIFlavor
+}{}
+
+var workloadFlags = struct {
+	cloudInit   string
+	cpus        int
+	description string
+	disk        int
+	dryRun      bool
+	fwType      string
+	image       string
+	mem         int
+	watchdog    string
 }{}
 
 var volFlags = struct {
+	class       string
 	description string
+	encrypted   bool
 	name        string
 	size        int
 	source      string
@@ -61,18 +108,21 @@ var volFlags = struct {
 }{}
 
 var imageCreateCmd = &cobra.Command{
-	Use:   "image NAME FILE",
+	Use:   "image NAME [FILE]",
 	Short: `Add an image to the cluster`,
-	Args:  cobra.ExactArgs(2),
+	Long: `Add an image to the cluster, uploading its data from FILE or, when
+--url is provided, letting the cluster fetch it from an HTTP(S) URL.`,
+	Args: cobra.RangeArgs(1, 2),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		name := args[0]
-		file := args[1]
 
-		f, err := os.Open(file)
-		if err != nil {
-			return errors.Wrap(err, "Error opening image file")
+		if (len(args) == 2) == (imgFlags.url != "") {
+			return errors.New("Either an image FILE or an --url must be provided")
+		}
+
+		if imgFlags.checksum != "" && imgFlags.url == "" {
+			return errors.New("--checksum can only be used with --url")
 		}
-		defer func() { _ = f.Close() }()
 
 		imageVisibility := types.Private
 		if imgFlags.visibility != "" {
@@ -84,9 +134,15 @@ var imageCreateCmd = &cobra.Command{
 			}
 		}
 
-		id, err := c.CreateImage(name, imageVisibility, imgFlags.id, f)
+		var id string
+		var err error
+		if imgFlags.url != "" {
+			id, err = importImage(name, imageVisibility)
+		} else {
+			id, err = uploadImage(name, imageVisibility, args[1])
+		}
 		if err != nil {
-			return errors.Wrap(err, "Error creating image")
+			return err
 		}
 
 		image, err := c.GetImage(id)
@@ -99,6 +155,46 @@ var imageCreateCmd = &cobra.Command{
 	Annotations: imageShowCmd.Annotations,
 }
 
+func uploadImage(name string, visibility types.Visibility, file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", errors.Wrap(err, "Error opening image file")
+	}
+	defer func() { _ = f.Close() }()
+
+	id, err := c.CreateImage(name, visibility, imgFlags.id, f)
+	if err != nil {
+		return "", errors.Wrap(err, "Error creating image")
+	}
+
+	return id, nil
+}
+
+// importImage has the cluster fetch the image data and waits for the
+// import to complete.
+func importImage(name string, visibility types.Visibility) (string, error) {
+	id, err := c.ImportImage(name, visibility, imgFlags.id, imgFlags.url, imgFlags.checksum)
+	if err != nil {
+		return "", errors.Wrap(err, "Error creating image")
+	}
+
+	for {
+		image, err := c.GetImage(id)
+		if err != nil {
+			return "", errors.Wrap(err, "Error getting image")
+		}
+
+		switch image.State {
+		case types.Active:
+			return id, nil
+		case types.Killed:
+			return "", errors.Errorf("Error importing image %s", id)
+		}
+
+		time.Sleep(time.Second)
+	}
+}
+
 func validateCreateCommandArgs() error {
 	if instanceFlags.instances < 1 {
 		return errors.New("Invalid instance count")
@@ -111,6 +207,17 @@ func validateCreateCommandArgs() error {
 		}
 	}
 
+	if instanceFlags.ip != "" && instanceFlags.instances != 1 {
+		return errors.New("A static IP address can only be assigned to a single instance")
+	}
+
+	if instanceFlags.group != "" {
+		r := regexp.MustCompile("^[a-z0-9-]{1,64}$")
+		if !r.MatchString(instanceFlags.group) {
+			return errors.New("Group name must be between 1 and 64 lowercase letters, numbers and hyphens")
+		}
+	}
+
 	return nil
 }
 
@@ -123,6 +230,9 @@ func populateCreateServerRequest(server *api.CreateServerRequest) {
 	server.Server.MaxInstances = instanceFlags.instances
 	server.Server.MinInstances = 1
 	server.Server.Name = instanceFlags.name
+	server.Server.IPAddress = instanceFlags.ip
+	server.Server.Preemptible = instanceFlags.preemptible
+	server.Server.Group = instanceFlags.group
 }
 
 var instanceCreateCmd = &cobra.Command{
@@ -136,7 +246,11 @@ var instanceCreateCmd = &cobra.Command{
 
 		var server api.CreateServerRequest
 
-		server.Server.WorkloadID = args[0]
+		workload, err := c.ResolveWorkload(args[0])
+		if err != nil {
+			return err
+		}
+		server.Server.WorkloadID = workload
 
 		populateCreateServerRequest(&server)
 
@@ -159,6 +273,141 @@ var poolCreateCmd = &cobra.Command{
 	},
 }
 
+var peerCreateCmd = &cobra.Command{
+	Use:   "peer NAME",
+	Short: "Register a federation peer",
+	Long: `Register a peer ciao cluster instances are launched in when the cluster is
+full. Only the workloads mapped with --workload LOCAL=PEER, where LOCAL and
+PEER are workload IDs in the cluster and in the peer, are launched in the
+peer, as instances of the peer tenant the API token was issued to.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if peerFlags.url == "" || peerFlags.tenantID == "" || peerFlags.token == "" {
+			return errors.New("--url, --tenant-id and --token must be supplied")
+		}
+
+		workloads := make(map[string]string)
+		for _, mapping := range peerFlags.workloads {
+			ids := strings.SplitN(mapping, "=", 2)
+			if len(ids) != 2 || ids[0] == "" || ids[1] == "" {
+				return fmt.Errorf("Invalid workload mapping %s, expected LOCAL=PEER", mapping)
+			}
+			workloads[ids[0]] = ids[1]
+		}
+
+		if len(workloads) == 0 {
+			return errors.New("At least one --workload must be supplied")
+		}
+
+		peer, err := c.AddFederationPeer(types.FederationPeerRequest{
+			Name:       args[0],
+			URL:        peerFlags.url,
+			TenantID:   peerFlags.tenantID,
+			APIToken:   peerFlags.token,
+			CACertFile: peerFlags.caCert,
+			Workloads:  workloads,
+		})
+		if err != nil {
+			return errors.Wrap(err, "Error registering federation peer")
+		}
+
+		return render(cmd, peer)
+	},
+	Annotations: map[string]string{
+		"default_template": "Registered federation peer {{ .ID }}\n",
+		"template_usage":   tfortools.GenerateUsageUndecorated(types.FederationPeer{}),
+	},
+}
+
+var secretCreateCmd = &cobra.Command{
+	Use:   "secret NAME [VALUE]",
+	Short: "Set the value of a secret",
+	Long: `Set the value of a secret, creating it if needed. The value is read
+from VALUE or, when --file is given, from a file. Secrets are injected in the
+meta-data of the instances of the workloads which reference them.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if (len(args) == 2) == (secretFlags.file != "") {
+			return errors.New("Either a VALUE or --file must be supplied")
+		}
+
+		var value string
+		if secretFlags.file != "" {
+			b, err := ioutil.ReadFile(secretFlags.file)
+			if err != nil {
+				return errors.Wrap(err, "Error reading secret file")
+			}
+			value = string(b)
+		} else {
+			value = args[1]
+		}
+
+		return errors.Wrap(c.SetSecret(args[0], value), "Error setting secret")
+	},
+}
+
+var snapshotCreateCmd = &cobra.Command{
+	Use:   "snapshot INSTANCE",
+	Short: "Snapshot all the volumes of an instance",
+	Long: `Snapshot all the volumes of an instance at the same time. The filesystems
+of running instances are frozen through their guest agent while the snapshots
+are taken, which requires the guest agent permission unless
+--allow-inconsistent is given. The snapshot set can be restored as a new
+instance once it is available.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if snapshotFlags.name == "" {
+			return errors.New("A snapshot set name is required")
+		}
+
+		instance, err := c.ResolveInstance(args[0])
+		if err != nil {
+			return err
+		}
+
+		set, err := c.CreateSnapshotSet(instance, types.SnapshotSetRequest{
+			Name:              snapshotFlags.name,
+			AllowInconsistent: snapshotFlags.allowInconsistent,
+		})
+		if err != nil {
+			return errors.Wrap(err, "Error creating snapshot set")
+		}
+
+		return render(cmd, set)
+	},
+	Annotations: map[string]string{
+		"default_template": "Creating snapshot set {{ .ID }}{{ if not .Consistent }} (inconsistent){{ end }}\n",
+		"template_usage":   tfortools.GenerateUsageUndecorated(types.SnapshotSet{}),
+	},
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:   "token NAME",
+	Short: "Issue an API token",
+	Long: `Issue an API token authenticating requests on behalf of the tenant, for
+clients such as CI systems which cannot manage client certificates. The token
+is only displayed once. Clients use it by setting CIAO_API_TOKEN and
+CIAO_TENANT_ID instead of CIAO_CLIENT_CERT_FILE.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		scope := types.APITokenFull
+		if tokenFlags.readOnly {
+			scope = types.APITokenReadOnly
+		}
+
+		token, err := c.CreateAPIToken(args[0], scope, tokenFlags.expiry)
+		if err != nil {
+			return errors.Wrap(err, "Error issuing API token")
+		}
+
+		return render(cmd, token)
+	},
+	Annotations: map[string]string{
+		"default_template": "Issued {{ .Scope }} API token {{ .ID }} expiring {{ .ExpiryTime }}\n{{ .Token }}\n",
+		"template_usage":   tfortools.GenerateUsageUndecorated(types.APIToken{}),
+	},
+}
+
 var tenantCreateCmd = &cobra.Command{
 	Use:   "tenant ID",
 	Short: "Create a new tenant in the cluster",
@@ -185,6 +434,8 @@ var tenantCreateCmd = &cobra.Command{
 			SubnetBits: tenantFlags.cidrPrefixSize,
 		}
 		config.Permissions.PrivilegedContainers = tenantFlags.createPrivilegedContainers
+		config.Permissions.GuestAgent = tenantFlags.guestAgent
+		config.CNCIFlavor = tenantFlags.cnciFlavor
 
 		summary, err := c.CreateTenantConfig(tuuid.String(), config)
 		if err != nil {
@@ -201,15 +452,25 @@ var volumeCreateCmd = &cobra.Command{
 	Short: "Create a volume in the cluster",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		createReq := api.RequestedVolume{
+			Class:       volFlags.class,
 			Description: volFlags.description,
+			Encrypted:   volFlags.encrypted,
 			Name:        volFlags.name,
 			Size:        volFlags.size,
 		}
 
-		if volFlags.sourcetype == "image" {
-			createReq.ImageRef = volFlags.source
-		} else if volFlags.sourcetype == "volume" {
-			createReq.SourceVolID = volFlags.source
+		if volFlags.sourcetype == "image" && volFlags.source != "" {
+			image, err := c.ResolveImage(volFlags.source)
+			if err != nil {
+				return err
+			}
+			createReq.ImageRef = image
+		} else if volFlags.sourcetype == "volume" && volFlags.source != "" {
+			volume, err := c.ResolveVolume(volFlags.source)
+			if err != nil {
+				return err
+			}
+			createReq.SourceVolID = volume
 		}
 
 		vol, err := c.CreateVolume(createReq)
@@ -233,14 +494,22 @@ type disk struct {
 	Bootable  bool    `yaml:"bootable"`
 	Source    source  `yaml:"source"`
 	Ephemeral bool    `yaml:"ephemeral"`
+	Class     string  `yaml:"class,omitempty"`
+	CDROM     bool    `yaml:"cdrom,omitempty"`
 }
 
 type workloadRequirements struct {
 	VCPUs      int    `yaml:"vcpus"`
 	MemMB      int    `yaml:"mem_mb"`
+	DiskMB     int    `yaml:"disk_mb,omitempty"`
+	DiskIOPS   int    `yaml:"disk_iops,omitempty"`
+	NetMbps    int    `yaml:"net_mbps,omitempty"`
 	NodeID     string `yaml:"node_id,omitempty"`
 	Hostname   string `yaml:"hostname,omitempty"`
 	Privileged bool   `yaml:"privileged,omitempty"`
+	Console    bool   `yaml:"console,omitempty"`
+	Priority   string `yaml:"priority,omitempty"`
+	Watchdog   string `yaml:"watchdog,omitempty"`
 }
 
 type workloadOptions struct {
@@ -251,6 +520,7 @@ type workloadOptions struct {
 	Requirements    workloadRequirements `yaml:"requirements"`
 	CloudConfigFile string               `yaml:"cloud_init,omitempty"`
 	Disks           []disk               `yaml:"disks,omitempty"`
+	Secrets         []string             `yaml:"secrets,omitempty"`
 }
 
 func optToReqStorage(opt workloadOptions) ([]types.StorageResource, error) {
@@ -261,6 +531,8 @@ func optToReqStorage(opt workloadOptions) ([]types.StorageResource, error) {
 			Size:      disk.Size,
 			Bootable:  disk.Bootable,
 			Ephemeral: disk.Ephemeral,
+			Class:     disk.Class,
+			CDROM:     disk.CDROM,
 		}
 
 		// Use existing volume
@@ -292,6 +564,10 @@ func optToReqStorage(opt workloadOptions) ([]types.StorageResource, error) {
 			}
 		}
 
+		if disk.CDROM && disk.Source.Type != types.ImageService {
+			return nil, errors.New("Invalid workload yaml: CD-ROMs must be created from an image")
+		}
+
 		if disk.Bootable {
 			bootableCount++
 		}
@@ -307,20 +583,26 @@ func optToReqStorage(opt workloadOptions) ([]types.StorageResource, error) {
 }
 
 func optToReq(opt workloadOptions, req *types.Workload) error {
-	b, err := ioutil.ReadFile(opt.CloudConfigFile)
-	if err != nil {
-		return err
-	}
+	var config string
 
-	config := string(b)
+	if opt.CloudConfigFile != "" {
+		b, err := ioutil.ReadFile(opt.CloudConfigFile)
+		if err != nil {
+			return err
+		}
+
+		config = string(b)
+	}
 
 	// this is where you'd validate that the options make
 	// sense.
+	var err error
 	req.Description = opt.Description
 	req.VMType = payloads.Hypervisor(opt.VMType)
 	req.FWType = opt.FWType
 	req.ImageName = opt.ImageName
 	req.Config = config
+	req.Secrets = opt.Secrets
 	req.Storage, err = optToReqStorage(opt)
 
 	if err != nil {
@@ -329,31 +611,113 @@ func optToReq(opt workloadOptions, req *types.Workload) error {
 
 	req.Requirements.MemMB = opt.Requirements.MemMB
 	req.Requirements.VCPUs = opt.Requirements.VCPUs
+	req.Requirements.DiskMB = opt.Requirements.DiskMB
+	req.Requirements.DiskIOPS = opt.Requirements.DiskIOPS
+	req.Requirements.NetMbps = opt.Requirements.NetMbps
 	req.Requirements.Hostname = opt.Requirements.Hostname
 	req.Requirements.NodeID = opt.Requirements.NodeID
 	req.Requirements.Privileged = opt.Requirements.Privileged
+	req.Requirements.Console = opt.Requirements.Console
+	req.Requirements.Priority = payloads.Priority(opt.Requirements.Priority)
+	req.Requirements.Watchdog = payloads.WatchdogAction(opt.Requirements.Watchdog)
 
 	return nil
 }
 
+// imageWorkloadOptions synthesizes the options of a VM workload booting
+// from a copy of an image, as described by the workload command flags.
+func imageWorkloadOptions() (workloadOptions, error) {
+	if workloadFlags.cpus < 1 {
+		return workloadOptions{}, errors.New("Invalid number of CPUs")
+	}
+
+	if workloadFlags.mem < 1 {
+		return workloadOptions{}, errors.New("Invalid amount of memory")
+	}
+
+	if workloadFlags.disk < 0 {
+		return workloadOptions{}, errors.New("Invalid disk size")
+	}
+
+	description := workloadFlags.description
+	if description == "" {
+		description = "Workload booting from image " + workloadFlags.image
+	}
+
+	return workloadOptions{
+		Description: description,
+		VMType:      string(payloads.QEMU),
+		FWType:      workloadFlags.fwType,
+		Requirements: workloadRequirements{
+			VCPUs:    workloadFlags.cpus,
+			MemMB:    workloadFlags.mem,
+			Watchdog: workloadFlags.watchdog,
+		},
+		CloudConfigFile: workloadFlags.cloudInit,
+		Disks: []disk{
+			{
+				Size:      workloadFlags.disk,
+				Bootable:  true,
+				Ephemeral: true,
+				Source: source{
+					Type:   types.ImageService,
+					Source: workloadFlags.image,
+				},
+			},
+		},
+	}, nil
+}
+
+func getWorkloadOptions(args []string) (workloadOptions, error) {
+	var opt workloadOptions
+
+	if len(args) == 0 {
+		if workloadFlags.image == "" {
+			return opt, errors.New("Either a workload FILE or an --image must be provided")
+		}
+
+		return imageWorkloadOptions()
+	}
+
+	if workloadFlags.image != "" {
+		return opt, errors.New("--image cannot be used with a workload FILE")
+	}
+
+	f, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return opt, errors.Wrap(err, "Error reading config file")
+	}
+
+	err = yaml.Unmarshal(f, &opt)
+	if err != nil {
+		return opt, errors.Wrap(err, "Error unmarshalling file")
+	}
+
+	return opt, nil
+}
+
 var workloadCreateCmd = &cobra.Command{
-	Use:   "workload FILE",
+	Use:   "workload [FILE]",
 	Short: `Create a new workload`,
-	Args:  cobra.ExactArgs(1),
+	Long: `Create a new workload, either from a workload definition FILE or,
+when --image is provided, from a definition generated from the command flags.`,
+	Args: cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		var opt workloadOptions
 		var req types.Workload
 
-		config := args[0]
-
-		f, err := ioutil.ReadFile(config)
+		opt, err := getWorkloadOptions(args)
 		if err != nil {
-			return errors.Wrap(err, "Error reading config file")
+			return err
 		}
 
-		err = yaml.Unmarshal(f, &opt)
-		if err != nil {
-			return errors.Wrap(err, "Error unmarshalling file")
+		if workloadFlags.dryRun {
+			y, err := yaml.Marshal(&opt)
+			if err != nil {
+				return errors.Wrap(err, "Error marshalling workload definition")
+			}
+
+			_, err = cmd.OutOrStdout().Write(y)
+			return err
 		}
 
 		err = optToReq(opt, &req)
@@ -371,7 +735,7 @@ var workloadCreateCmd = &cobra.Command{
 	Annotations: workloadShowCmd.Annotations,
 }
 
-var createCmds = []*cobra.Command{imageCreateCmd, instanceCreateCmd, poolCreateCmd, volumeCreateCmd, workloadCreateCmd, tenantCreateCmd}
+var createCmds = []*cobra.Command{imageCreateCmd, instanceCreateCmd, peerCreateCmd, poolCreateCmd, secretCreateCmd, snapshotCreateCmd, tokenCreateCmd, volumeCreateCmd, workloadCreateCmd, tenantCreateCmd}
 
 func init() {
 	for _, cmd := range createCmds {
@@ -379,21 +743,56 @@ func init() {
 	}
 	rootCmd.AddCommand(createCmd)
 
+	imageCreateCmd.Flags().StringVar(&imgFlags.checksum, "checksum", "", "Checksum the data fetched from --url must match, e.g. sha256:HEX")
 	imageCreateCmd.Flags().StringVar(&imgFlags.id, "id", "", "Image ID")
+	imageCreateCmd.Flags().StringVar(&imgFlags.url, "url", "", "HTTP(S) URL the cluster fetches the image data from")
 	imageCreateCmd.Flags().StringVar(&imgFlags.visibility, "visibility", "private", "Image visibility (internal,public,private)")
 
 	instanceCreateCmd.Flags().IntVar(&instanceFlags.instances, "instances", 1, "Number of instances to create")
+	instanceCreateCmd.Flags().StringVar(&instanceFlags.ip, "ip", "", "Static IP address from the tenant network to assign to the instance")
+	instanceCreateCmd.Flags().StringVar(&instanceFlags.group, "group", "", "Name of the instance group the instances belong to")
 	instanceCreateCmd.Flags().StringVar(&instanceFlags.label, "label", "", "Set a frame label. This will trigger frame tracing")
 	instanceCreateCmd.Flags().StringVar(&instanceFlags.name, "name", "", "Name for this instance. When multiple instances are requested this is used as a prefix")
+	instanceCreateCmd.Flags().BoolVar(&instanceFlags.preemptible, "preemptible", false, "Create preemptible instances, which use less quota but may be reclaimed at any time")
 	instanceCreateCmd.Flags().StringVar(&instanceFlags.workload, "workload", "", "Workload UUID")
 
+	volumeCreateCmd.Flags().StringVar(&volFlags.class, "class", "", "Storage class of the volume, e.g. ssd (defaults to the default storage pool)")
 	volumeCreateCmd.Flags().StringVar(&volFlags.description, "description", "", "Volume description")
+	volumeCreateCmd.Flags().BoolVar(&volFlags.encrypted, "encrypted", false, "Encrypt the volume with LUKS. Copies of encrypted volumes are always encrypted")
 	volumeCreateCmd.Flags().StringVar(&volFlags.name, "name", "", "Volume name")
 	volumeCreateCmd.Flags().IntVar(&volFlags.size, "size", 1, "Size of the volume in GiB")
-	volumeCreateCmd.Flags().StringVar(&volFlags.source, "source", "", "ID of image or volume to clone from")
+	volumeCreateCmd.Flags().StringVar(&volFlags.source, "source", "", "ID or name of image or volume to clone from")
 	volumeCreateCmd.Flags().StringVar(&volFlags.sourcetype, "source-type", "image", "The type of the source to clone from")
 
+	workloadCreateCmd.Flags().StringVar(&workloadFlags.cloudInit, "cloud-init", "", "Path to a cloud-init file for the generated workload")
+	workloadCreateCmd.Flags().IntVar(&workloadFlags.cpus, "cpus", 1, "Number of CPUs for the generated workload")
+	workloadCreateCmd.Flags().StringVar(&workloadFlags.description, "description", "", "Description of the generated workload")
+	workloadCreateCmd.Flags().IntVar(&workloadFlags.disk, "disk", 0, "Size of the generated workload boot disk in GiB (defaults to the image size)")
+	workloadCreateCmd.Flags().BoolVar(&workloadFlags.dryRun, "dry-run", false, "Print the workload definition instead of creating the workload")
+	workloadCreateCmd.Flags().StringVar(&workloadFlags.fwType, "fw-type", payloads.Legacy, "Firmware type of the generated workload (legacy,efi)")
+	workloadCreateCmd.Flags().StringVar(&workloadFlags.image, "image", "", "ID or name of the image the generated workload boots from")
+	workloadCreateCmd.Flags().IntVar(&workloadFlags.mem, "mem", 1024, "Memory of the generated workload in MiB")
+	workloadCreateCmd.Flags().StringVar(&workloadFlags.watchdog, "watchdog", "", "Action taken when the watchdog of the generated workload fires (reset,poweroff,none), no watchdog by default")
+
+	peerCreateCmd.Flags().StringVar(&peerFlags.caCert, "ca-cert", "", "Path to the CA certificate of the peer controller, read by the controller")
+	peerCreateCmd.Flags().StringVar(&peerFlags.tenantID, "tenant-id", "", "Peer tenant the instances are launched in")
+	peerCreateCmd.Flags().StringVar(&peerFlags.token, "token", "", "API token issued to the peer tenant")
+	peerCreateCmd.Flags().StringVar(&peerFlags.url, "url", "", "URL of the peer controller")
+	peerCreateCmd.Flags().StringSliceVar(&peerFlags.workloads, "workload", nil, "Workload launched in the peer, as LOCAL=PEER workload IDs (repeatable)")
+
+	secretCreateCmd.Flags().StringVar(&secretFlags.file, "file", "", "Path to a file containing the value of the secret")
+
+	snapshotCreateCmd.Flags().StringVar(&snapshotFlags.name, "name", "", "Name of the snapshot set")
+	snapshotCreateCmd.Flags().BoolVar(&snapshotFlags.allowInconsistent, "allow-inconsistent", false, "Snapshot running instances whose filesystems cannot be frozen")
+
+	tokenCreateCmd.Flags().StringVar(&tokenFlags.expiry, "expiry", "", "Lifetime of the token, e.g. 720h (defaults to the controller default)")
+	tokenCreateCmd.Flags().BoolVar(&tokenFlags.readOnly, "read-only", false, "Only allow the token to read resources")
+
 	tenantCreateCmd.Flags().IntVar(&tenantFlags.cidrPrefixSize, "cidr-prefix-size", 0, "Number of bits in network mask (12-30)")
 	tenantCreateCmd.Flags().BoolVar(&tenantFlags.createPrivilegedContainers, "create-privileged-containers", false, "Whether this tenant can create privileged containers")
+	tenantCreateCmd.Flags().BoolVar(&tenantFlags.guestAgent, "guest-agent", false, "Whether this tenant can use the guest agent of its instances")
 	tenantCreateCmd.Flags().StringVar(&tenantFlags.name, "name", "", "Tenant name")
+	tenantCreateCmd.Flags().IntVar(&tenantFlags.cnciFlavor.VCPUs, "cnci-vcpus", 0, "Number of vCPUs of the CNCIs of the tenant (0 for the cluster default)")
+	tenantCreateCmd.Flags().IntVar(&tenantFlags.cnciFlavor.MemMB, "cnci-mem", 0, "Memory of the CNCIs of the tenant in MiB (0 for the cluster default)")
+	tenantCreateCmd.Flags().IntVar(&tenantFlags.cnciFlavor.DiskGiB, "cnci-disk", 0, "Disk size of the CNCIs of the tenant in GiB (0 for the image size)")
 }
@@ -15,6 +15,7 @@
 package cmd
 
 import (
+	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
@@ -33,11 +34,16 @@ var eventsDelCmd = &cobra.Command{
 }
 
 var imageDelCmd = &cobra.Command{
-	Use:   "image ID",
+	Use:   "image IMAGE",
 	Short: "Delete an image",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return errors.Wrap(c.DeleteImage(args[0]), "Error deleting image")
+		image, err := c.ResolveImage(args[0])
+		if err != nil {
+			return err
+		}
+
+		return errors.Wrap(c.DeleteImage(image), "Error deleting image")
 	},
 }
 
@@ -46,7 +52,7 @@ var deleteInstanceFlags = struct {
 }{}
 
 var instanceDelCmd = &cobra.Command{
-	Use:   "instance ID",
+	Use:   "instance INSTANCE",
 	Short: "Delete instance from cluster",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if deleteInstanceFlags.all {
@@ -54,10 +60,43 @@ var instanceDelCmd = &cobra.Command{
 		}
 
 		if len(args) < 1 {
-			return errors.New("Instance ID required")
+			return errors.New("Instance ID or name required")
+		}
+
+		instance, err := c.ResolveInstance(args[0])
+		if err != nil {
+			return err
 		}
 
-		return errors.Wrap(c.DeleteInstance(args[0]), "Error deleting instance")
+		return errors.Wrap(c.DeleteInstance(instance), "Error deleting instance")
+	},
+}
+
+var groupDelCmd = &cobra.Command{
+	Use:         "group GROUP",
+	Short:       "Delete all the instances of an instance group",
+	Args:        cobra.ExactArgs(1),
+	Annotations: groupActionAnnotations,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runGroupAction(cmd, args[0], api.GroupDelete, 0)
+	},
+}
+
+var nodePolicyDelCmd = &cobra.Command{
+	Use:   "node-policy ID",
+	Short: "Remove the scheduling policy of a node",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.Wrap(c.DeleteNodePolicy(args[0]), "Error deleting node policy")
+	},
+}
+
+var peerDelCmd = &cobra.Command{
+	Use:   "peer ID",
+	Short: "Remove a federation peer",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.Wrap(c.DeleteFederationPeer(args[0]), "Error removing federation peer")
 	},
 }
 
@@ -70,12 +109,81 @@ var poolDelCmd = &cobra.Command{
 	},
 }
 
+var deleteTraceFlags = struct {
+	all bool
+}{}
+
+var secretDelCmd = &cobra.Command{
+	Use:   "secret NAME",
+	Short: "Delete a secret",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.Wrap(c.DeleteSecret(args[0]), "Error deleting secret")
+	},
+}
+
+var snapshotDelCmd = &cobra.Command{
+	Use:   "snapshot SNAPSHOT",
+	Short: "Delete a snapshot set and its volumes",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ID, err := c.ResolveSnapshotSet(args[0])
+		if err != nil {
+			return err
+		}
+
+		return errors.Wrap(c.DeleteSnapshotSet(ID), "Error deleting snapshot set")
+	},
+}
+
+var tokenDelCmd = &cobra.Command{
+	Use:   "token ID",
+	Short: "Revoke an API token",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.Wrap(c.DeleteAPIToken(args[0]), "Error revoking API token")
+	},
+}
+
+var traceDelCmd = &cobra.Command{
+	Use:   "trace LABEL",
+	Short: "Delete the trace data for a label",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if deleteTraceFlags.all {
+			traces, err := c.ListTraceLabels()
+			if err != nil {
+				return errors.Wrap(err, "Error getting trace labels")
+			}
+
+			for _, s := range traces.Summaries {
+				err := c.DeleteTraceData(s.Label)
+				if err != nil {
+					return errors.Wrapf(err, "Error deleting trace data for %s", s.Label)
+				}
+			}
+
+			return nil
+		}
+
+		if len(args) < 1 {
+			return errors.New("Trace label required")
+		}
+
+		return errors.Wrap(c.DeleteTraceData(args[0]), "Error deleting trace data")
+	},
+}
+
 var volumeDelCmd = &cobra.Command{
-	Use:   "volume ID",
+	Use:   "volume VOLUME",
 	Short: "Delete a volume",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return errors.Wrap(c.DeleteVolume(args[0]), "Error deleting volume")
+		volume, err := c.ResolveVolume(args[0])
+		if err != nil {
+			return err
+		}
+
+		return errors.Wrap(c.DeleteVolume(volume), "Error deleting volume")
 	},
 }
 
@@ -89,15 +197,20 @@ var tenantDelCmd = &cobra.Command{
 }
 
 var workloadDelCmd = &cobra.Command{
-	Use:   "workload ID",
+	Use:   "workload WORKLOAD",
 	Short: "Delete a workload",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return errors.Wrap(c.DeleteWorkload(args[0]), "Error deleting workload")
+		workload, err := c.ResolveWorkload(args[0])
+		if err != nil {
+			return err
+		}
+
+		return errors.Wrap(c.DeleteWorkload(workload), "Error deleting workload")
 	},
 }
 
-var delCmds = []*cobra.Command{eventsDelCmd, imageDelCmd, instanceDelCmd, poolDelCmd, volumeDelCmd, workloadDelCmd, tenantDelCmd}
+var delCmds = []*cobra.Command{eventsDelCmd, groupDelCmd, imageDelCmd, instanceDelCmd, nodePolicyDelCmd, peerDelCmd, poolDelCmd, secretDelCmd, snapshotDelCmd, tokenDelCmd, traceDelCmd, volumeDelCmd, workloadDelCmd, tenantDelCmd}
 
 func init() {
 	for _, cmd := range delCmds {
@@ -105,6 +218,7 @@ func init() {
 	}
 
 	instanceDelCmd.Flags().BoolVar(&deleteInstanceFlags.all, "all", false, "Delete all instances")
+	traceDelCmd.Flags().BoolVar(&deleteTraceFlags.all, "all", false, "Delete the trace data for all labels")
 
 	rootCmd.AddCommand(deleteCmd)
 }
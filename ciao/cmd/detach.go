@@ -38,7 +38,12 @@ var detachVolCmd = &cobra.Command{
 	Short: "Detach a volume from an instance",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return errors.Wrap(c.DetachVolume(args[0]), "Error detaching volume")
+		volume, err := c.ResolveVolume(args[0])
+		if err != nil {
+			return err
+		}
+
+		return errors.Wrap(c.DetachVolume(volume), "Error detaching volume")
 	},
 }
 
@@ -0,0 +1,114 @@
+// Copyright © 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var exportFile string
+
+var exportInstanceCmd = &cobra.Command{
+	Use:   "instance INSTANCE",
+	Short: "Export a stopped instance",
+	Long: `Export a stopped VM instance as a tar bundle holding its boot disk as a
+qcow2 image, the cloud-init user data of its workload and a metadata.json
+file describing them. The bundle is written to INSTANCE.tar unless a file
+is given.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		instance, err := c.ResolveInstance(args[0])
+		if err != nil {
+			return err
+		}
+
+		path := exportFile
+		if path == "" {
+			path = instance + ".tar"
+		}
+
+		f, err := os.Create(path)
+		if err != nil {
+			return errors.Wrap(err, "Error creating export file")
+		}
+
+		err = c.ExportInstance(instance, f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = os.Remove(path)
+			return errors.Wrap(err, "Error exporting instance")
+		}
+
+		fmt.Printf("Exported instance %s to %s\n", instance, path)
+		return nil
+	},
+}
+
+var exportImageCmd = &cobra.Command{
+	Use:   "image IMAGE",
+	Short: "Export the data of an image",
+	Long: `Export the raw data of an active image, as it was uploaded. The data is
+written to IMAGE.img unless a file is given.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		image, err := c.ResolveImage(args[0])
+		if err != nil {
+			return err
+		}
+
+		path := exportFile
+		if path == "" {
+			path = image + ".img"
+		}
+
+		f, err := os.Create(path)
+		if err != nil {
+			return errors.Wrap(err, "Error creating export file")
+		}
+
+		err = c.DownloadImage(image, f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = os.Remove(path)
+			return errors.Wrap(err, "Error exporting image")
+		}
+
+		fmt.Printf("Exported image %s to %s\n", image, path)
+		return nil
+	},
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export an object from the cluster",
+}
+
+func init() {
+	exportInstanceCmd.Flags().StringVar(&exportFile, "file", "", "File to write the export bundle to")
+
+	exportImageCmd.Flags().StringVar(&exportFile, "file", "", "File to write the image data to")
+
+	exportCmd.AddCommand(exportInstanceCmd)
+	exportCmd.AddCommand(exportImageCmd)
+	rootCmd.AddCommand(exportCmd)
+}
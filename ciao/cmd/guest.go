@@ -0,0 +1,132 @@
+// Copyright © 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func runGuestAgent(instanceArg string, request types.GuestAgentRequest) (types.GuestAgentResult, error) {
+	instance, err := c.ResolveInstance(instanceArg)
+	if err != nil {
+		return types.GuestAgentResult{}, err
+	}
+
+	result, err := c.GuestAgentInstance(instance, request)
+	if err != nil {
+		return result, errors.Wrapf(err, "Error running guest agent %s", request.Operation)
+	}
+
+	return result, nil
+}
+
+var guestExecCmd = &cobra.Command{
+	Use:   "exec INSTANCE COMMAND [ARG...]",
+	Short: "Run a command in the guest of an instance",
+	Long: `Run a command in the guest of a running VM instance through its QEMU guest
+agent and print its output. The command is not run in a shell and must
+complete within 30 seconds. Its exit code is printed when it fails.`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		result, err := runGuestAgent(args[0], types.GuestAgentRequest{
+			Operation: types.GuestAgentExec,
+			Path:      args[1],
+			Args:      args[2:],
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Print(result.Stdout)
+		fmt.Fprint(os.Stderr, result.Stderr)
+
+		if result.ExitCode != 0 {
+			return fmt.Errorf("Command exited with code %d", result.ExitCode)
+		}
+		return nil
+	},
+}
+
+var guestFreezeCmd = &cobra.Command{
+	Use:   "freeze INSTANCE",
+	Short: "Freeze the filesystems of the guest of an instance",
+	Long: `Freeze the filesystems of the guest of a running VM instance, e.g., before
+snapshotting its volumes. The filesystems remain frozen until thawed.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		result, err := runGuestAgent(args[0], types.GuestAgentRequest{Operation: types.GuestAgentFreeze})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Froze %d filesystems\n", result.Filesystems)
+		return nil
+	},
+}
+
+var guestThawCmd = &cobra.Command{
+	Use:   "thaw INSTANCE",
+	Short: "Thaw the frozen filesystems of the guest of an instance",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		result, err := runGuestAgent(args[0], types.GuestAgentRequest{Operation: types.GuestAgentThaw})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Thawed %d filesystems\n", result.Filesystems)
+		return nil
+	},
+}
+
+var guestNetworkCmd = &cobra.Command{
+	Use:   "network INSTANCE",
+	Short: "List the network interfaces of the guest of an instance",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		result, err := runGuestAgent(args[0], types.GuestAgentRequest{Operation: types.GuestAgentNetworkInfo})
+		if err != nil {
+			return err
+		}
+
+		for _, iface := range result.Interfaces {
+			fmt.Printf("%s\t%s\t%s\n", iface.Name, iface.HardwareAddress,
+				strings.Join(iface.IPAddresses, " "))
+		}
+		return nil
+	},
+}
+
+var guestCmd = &cobra.Command{
+	Use:   "guest",
+	Short: "Use the guest agent of an instance",
+	Long: `Run operations in the guest of a VM instance through its QEMU guest agent.
+The guest must run qemu-guest-agent and the tenant must be granted the
+guest agent permission.`,
+}
+
+func init() {
+	guestCmd.AddCommand(guestExecCmd)
+	guestCmd.AddCommand(guestFreezeCmd)
+	guestCmd.AddCommand(guestThawCmd)
+	guestCmd.AddCommand(guestNetworkCmd)
+	rootCmd.AddCommand(guestCmd)
+}
@@ -15,6 +15,10 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+	"time"
+
 	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/intel/tfortools"
@@ -96,12 +100,83 @@ var externalipListCmd = &cobra.Command{
 	},
 }
 
+var groupListCmd = &cobra.Command{
+	Use:  "groups",
+	Long: `List instance groups.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		groups, err := c.ListInstanceGroups()
+		if err != nil {
+			return errors.Wrap(err, "Error listing instance groups")
+		}
+
+		return render(cmd, groups)
+	},
+	Annotations: map[string]string{
+		"default_template": "{{ table .}}",
+		"template_usage":   tfortools.GenerateUsageUndecorated([]api.InstanceGroup{}),
+	},
+}
+
+var imageListFlags = struct {
+	name         string
+	visibility   string
+	tenant       string
+	minSize      uint64
+	maxSize      uint64
+	createdSince string
+	limit        int
+	marker       string
+}{}
+
+func imageListFilter() (api.ImageFilter, error) {
+	filter := api.ImageFilter{
+		Name:       imageListFlags.name,
+		Visibility: types.Visibility(imageListFlags.visibility),
+		TenantID:   imageListFlags.tenant,
+		MinSize:    imageListFlags.minSize,
+		MaxSize:    imageListFlags.maxSize,
+		Limit:      imageListFlags.limit,
+		Marker:     imageListFlags.marker,
+	}
+
+	switch filter.Visibility {
+	case "", types.Public, types.Private, types.Internal:
+	default:
+		return filter, errors.New("Invalid image visibility")
+	}
+
+	if filter.Limit < 0 {
+		return filter, errors.New("A limit of 0 or more is required")
+	}
+
+	if imageListFlags.createdSince != "" {
+		d, err := time.ParseDuration(imageListFlags.createdSince)
+		if err == nil {
+			filter.CreatedSince = time.Now().Add(-d)
+		} else {
+			filter.CreatedSince, err = time.Parse(time.RFC3339, imageListFlags.createdSince)
+			if err != nil {
+				return filter, errors.New("--created-since must be a duration or an RFC3339 time")
+			}
+		}
+	}
+
+	return filter, nil
+}
+
 var imageListCmd = &cobra.Command{
-	Use:  "images",
-	Long: `List images.`,
+	Use: "images",
+	Long: `List images, oldest first. Use --limit to list a page of images and
+--marker with the ID of the last image listed to list the next one.`,
 	Args: cobra.ExactArgs(0),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		images, err := c.ListImages()
+		filter, err := imageListFilter()
+		if err != nil {
+			return err
+		}
+
+		images, err := c.ListImagesWithFilter(filter)
 		if err != nil {
 			return errors.Wrap(err, "Error getting list of images")
 		}
@@ -116,12 +191,16 @@ var imageListCmd = &cobra.Command{
 
 var instanceListCmd = &cobra.Command{
 	Use:  "instances [WORKLOAD]",
-	Long: `List instances. If the optional workload ID is provided then only show instances matching that ID.`,
+	Long: `List instances. If the optional workload ID or name is provided then only show instances of that workload.`,
 	Args: cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		workloadID := ""
 		if len(args) == 1 {
-			workloadID = args[0]
+			var err error
+			workloadID, err = c.ResolveWorkload(args[0])
+			if err != nil {
+				return err
+			}
 		}
 
 		servers, err := c.ListInstancesByWorkload(c.TenantID, workloadID)
@@ -137,9 +216,33 @@ var instanceListCmd = &cobra.Command{
 	},
 }
 
+var instanceActionListCmd = &cobra.Command{
+	Use:  "instance-actions INSTANCE",
+	Long: `List the state transitions of an instance along with who initiated them and why.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		instance, err := c.ResolveInstance(args[0])
+		if err != nil {
+			return err
+		}
+
+		actions, err := c.ListInstanceActions(instance)
+		if err != nil {
+			return errors.Wrap(err, "Error listing instance actions")
+		}
+
+		return render(cmd, actions)
+	},
+	Annotations: map[string]string{
+		"default_template": `{{ table (cols . "Timestamp" "PreviousState" "State" "Initiator" "Reason") }}`,
+		"template_usage":   tfortools.GenerateUsageUndecorated([]types.InstanceAction{}),
+	},
+}
+
 var nodeListFlags = struct {
 	computeNodesOnly bool
 	networkNodesOnly bool
+	format           string
 }{}
 
 var nodeListCmd = &cobra.Command{
@@ -151,6 +254,10 @@ var nodeListCmd = &cobra.Command{
 			return errors.New("Listing nodes is limited to privileged users")
 		}
 
+		if err := checkFormat(nodeListFlags.format); err != nil {
+			return err
+		}
+
 		var n types.CiaoNodes
 		var err error
 		if nodeListFlags.computeNodesOnly {
@@ -165,6 +272,10 @@ var nodeListCmd = &cobra.Command{
 			return errors.Wrap(err, "Error getting nodes")
 		}
 
+		if nodeListFlags.format == formatCSV {
+			return writeNodesCSV(os.Stdout, n.Nodes)
+		}
+
 		return render(cmd, n.Nodes)
 	},
 	Annotations: map[string]string{
@@ -173,6 +284,46 @@ var nodeListCmd = &cobra.Command{
 	},
 }
 
+var nodePolicyListCmd = &cobra.Command{
+	Use:  "node-policies",
+	Long: `Lists the scheduling policies set on nodes.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !c.IsPrivileged() {
+			return errors.New("Listing node policies is limited to privileged users")
+		}
+
+		policies, err := c.ListNodePolicies()
+		if err != nil {
+			return errors.Wrap(err, "Error getting node policies")
+		}
+
+		return render(cmd, policies.Policies)
+	},
+	Annotations: map[string]string{
+		"default_template": `{{ table (cols . "NodeID" "Weight" "MaxInstances" "AllowCNCIColocation" "DedicatedTenant")}}`,
+		"template_usage":   tfortools.GenerateUsageUndecorated([]types.NodePolicy{}),
+	},
+}
+
+var peerListCmd = &cobra.Command{
+	Use:  "peers",
+	Long: `List the federation peers instances are launched in when the cluster is full.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		peers, err := c.ListFederationPeers()
+		if err != nil {
+			return errors.Wrap(err, "Error listing federation peers")
+		}
+
+		return render(cmd, peers)
+	},
+	Annotations: map[string]string{
+		"default_template": `{{ table (cols . "ID" "Name" "URL" "TenantID") }}`,
+		"template_usage":   tfortools.GenerateUsageUndecorated([]types.FederationPeer{}),
+	},
+}
+
 var poolListCmd = &cobra.Command{
 	Use:  "pools",
 	Long: `List external IP pools.`,
@@ -217,11 +368,109 @@ var quotasListCmd = &cobra.Command{
 	},
 }
 
+var secretListCmd = &cobra.Command{
+	Use:  "secrets",
+	Long: `List the secrets of the tenant, without their values.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		secrets, err := c.ListSecrets()
+		if err != nil {
+			return errors.Wrap(err, "Error listing secrets")
+		}
+
+		return render(cmd, secrets)
+	},
+	Annotations: map[string]string{
+		"default_template": `{{ table (cols . "Name" "CreateTime") }}`,
+		"template_usage":   tfortools.GenerateUsageUndecorated([]types.Secret{}),
+	},
+}
+
+var signedRequestListCmd = &cobra.Command{
+	Use:  "signed-requests",
+	Long: `List the signed requests submitted to the cluster.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		records, err := c.ListSignedRequests()
+		if err != nil {
+			return errors.Wrap(err, "Error listing signed requests")
+		}
+
+		return render(cmd, records)
+	},
+	Annotations: map[string]string{
+		"default_template": `{{ table (cols . "Nonce" "Method" "Path" "Signer" "Created" "SubmitTime") }}`,
+		"template_usage":   tfortools.GenerateUsageUndecorated([]types.SignedRequestRecord{}),
+	},
+}
+
+var deletedListCmd = &cobra.Command{
+	Use:  "deleted",
+	Long: `List the instances and volumes of the tenant in the recycle bin.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		resources, err := c.ListDeletedResources()
+		if err != nil {
+			return errors.Wrap(err, "Error listing deleted resources")
+		}
+
+		return render(cmd, resources)
+	},
+	Annotations: map[string]string{
+		"default_template": `{{ table (cols . "ID" "Type" "Name" "DeleteTime" "PurgeTime") }}`,
+		"template_usage":   tfortools.GenerateUsageUndecorated([]types.DeletedResource{}),
+	},
+}
+
+var snapshotListCmd = &cobra.Command{
+	Use:  "snapshots",
+	Long: `List the snapshot sets of the tenant.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sets, err := c.ListSnapshotSets()
+		if err != nil {
+			return errors.Wrap(err, "Error listing snapshot sets")
+		}
+
+		return render(cmd, sets)
+	},
+	Annotations: map[string]string{
+		"default_template": `{{ table (cols . "ID" "Name" "InstanceID" "State" "Consistent" "CreateTime") }}`,
+		"template_usage":   tfortools.GenerateUsageUndecorated([]types.SnapshotSet{}),
+	},
+}
+
+var tokenListCmd = &cobra.Command{
+	Use:  "tokens",
+	Long: `List the API tokens issued to the tenant.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tokens, err := c.ListAPITokens()
+		if err != nil {
+			return errors.Wrap(err, "Error listing API tokens")
+		}
+
+		return render(cmd, tokens)
+	},
+	Annotations: map[string]string{
+		"default_template": `{{ table (cols . "ID" "Name" "Scope" "ExpiryTime") }}`,
+		"template_usage":   tfortools.GenerateUsageUndecorated([]types.APIToken{}),
+	},
+}
+
+var tenantListFlags = struct {
+	format string
+}{}
+
 var tenantListCmd = &cobra.Command{
 	Use:  "tenants",
 	Long: `List tenants available to the user or if privileged those on the cluster.`,
 	Args: cobra.ExactArgs(0),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkFormat(tenantListFlags.format); err != nil {
+			return err
+		}
+
 		var tenants []types.TenantSummary
 		if c.IsPrivileged() {
 			t, err := c.ListTenants()
@@ -237,6 +486,19 @@ var tenantListCmd = &cobra.Command{
 			}
 		}
 
+		if tenantListFlags.format == formatCSV {
+			quotas := make(map[string][]types.QuotaDetails)
+			for _, t := range tenants {
+				q, err := c.ListQuotas(t.ID)
+				if err != nil {
+					return errors.Wrapf(err, "Error getting quotas of tenant %s", t.ID)
+				}
+				quotas[t.ID] = q
+			}
+
+			return writeTenantsCSV(os.Stdout, tenants, quotas)
+		}
+
 		return render(cmd, tenants)
 	},
 	Annotations: map[string]string{
@@ -255,6 +517,10 @@ var traceListCmd = &cobra.Command{
 			return errors.Wrap(err, "Error getting trace labels")
 		}
 
+		if template == "" && t.Active != "" {
+			fmt.Printf("Tracing instance launches with label %s\n", t.Active)
+		}
+
 		return render(cmd, t.Summaries)
 	},
 	Annotations: map[string]string{
@@ -321,14 +587,23 @@ var workloadListCmd = &cobra.Command{
 
 var listCmds = []*cobra.Command{
 	cnciListCmd,
+	deletedListCmd,
 	eventListCmd,
 	externalipListCmd,
+	groupListCmd,
 	imageListCmd,
 	instanceListCmd,
+	instanceActionListCmd,
 	nodeListCmd,
+	nodePolicyListCmd,
+	peerListCmd,
 	poolListCmd,
 	quotasListCmd,
+	secretListCmd,
+	signedRequestListCmd,
+	snapshotListCmd,
 	tenantListCmd,
+	tokenListCmd,
 	traceListCmd,
 	volumeListCmd,
 	workloadListCmd,
@@ -342,6 +617,18 @@ func init() {
 
 	nodeListCmd.Flags().BoolVar(&nodeListFlags.computeNodesOnly, "compute-nodes", false, "Only show compute nodes")
 	nodeListCmd.Flags().BoolVar(&nodeListFlags.networkNodesOnly, "network-nodes", false, "Only show network nodes")
+	nodeListCmd.Flags().StringVar(&nodeListFlags.format, "format", formatTable, "Output format (table,csv), csv reporting the capacity of the nodes and its totals")
+
+	tenantListCmd.Flags().StringVar(&tenantListFlags.format, "format", formatTable, "Output format (table,csv), csv reporting the quota utilization of the tenants and its totals")
+
+	imageListCmd.Flags().StringVar(&imageListFlags.name, "name", "", "Only show images whose name contains this string")
+	imageListCmd.Flags().StringVar(&imageListFlags.visibility, "visibility", "", "Only show images with this visibility (internal,public,private)")
+	imageListCmd.Flags().StringVar(&imageListFlags.tenant, "tenant", "", "Only show images of this tenant")
+	imageListCmd.Flags().Uint64Var(&imageListFlags.minSize, "min-size", 0, "Only show images of at least this size in bytes")
+	imageListCmd.Flags().Uint64Var(&imageListFlags.maxSize, "max-size", 0, "Only show images of at most this size in bytes")
+	imageListCmd.Flags().StringVar(&imageListFlags.createdSince, "created-since", "", "Only show images created since this RFC3339 time or for this duration, e.g. 24h")
+	imageListCmd.Flags().IntVar(&imageListFlags.limit, "limit", 0, "Maximum number of images to show, 0 for all")
+	imageListCmd.Flags().StringVar(&imageListFlags.marker, "marker", "", "Only show the images following the image with this ID")
 
 	rootCmd.AddCommand(listCmd)
 }
@@ -0,0 +1,59 @@
+// Copyright © 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func purgeDeleted(resourceType types.DeletedResourceType, resource string) error {
+	ID, err := c.ResolveDeleted(resourceType, resource)
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrapf(c.PurgeDeletedResource(ID), "Error purging %s", resourceType)
+}
+
+var purgeInstanceCmd = &cobra.Command{
+	Use:   "instance INSTANCE",
+	Short: "Permanently delete an instance in the recycle bin",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return purgeDeleted(types.DeletedInstance, args[0])
+	},
+}
+
+var purgeVolumeCmd = &cobra.Command{
+	Use:   "volume VOLUME",
+	Short: "Permanently delete a volume in the recycle bin",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return purgeDeleted(types.DeletedVolume, args[0])
+	},
+}
+
+var purgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Permanently delete an object in the recycle bin",
+}
+
+func init() {
+	purgeCmd.AddCommand(purgeInstanceCmd)
+	purgeCmd.AddCommand(purgeVolumeCmd)
+	rootCmd.AddCommand(purgeCmd)
+}
@@ -0,0 +1,51 @@
+// Copyright © 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var rebootHard bool
+
+var rebootInstanceCmd = &cobra.Command{
+	Use:   "instance INSTANCE",
+	Short: "Reboot an instance",
+	Long: `Reboot a running instance on the node it is running on. The instance is
+asked to shut down and is killed if it does not shut down in time, unless
+--hard is given, in which case it is killed straight away.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		instance, err := c.ResolveInstance(args[0])
+		if err != nil {
+			return err
+		}
+
+		return errors.Wrap(c.RebootInstance(instance, rebootHard), "Error rebooting instance")
+	},
+}
+
+var rebootCmd = &cobra.Command{
+	Use:   "reboot",
+	Short: "Reboot an object in the cluster",
+}
+
+func init() {
+	rebootInstanceCmd.Flags().BoolVar(&rebootHard, "hard", false, "Kill the instance rather than ask it to shut down")
+
+	rebootCmd.AddCommand(rebootInstanceCmd)
+	rootCmd.AddCommand(rebootCmd)
+}
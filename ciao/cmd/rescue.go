@@ -0,0 +1,80 @@
+// Copyright © 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var rescueImageID string
+
+var rescueInstanceCmd = &cobra.Command{
+	Use:   "instance INSTANCE",
+	Short: "Reboot an instance from a rescue image",
+	Long: `Reboot an instance from a volume created from a rescue image. The
+volumes of the instance remain attached so that they can be repaired. The
+rescue image of the controller is used if no image is given.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		instance, err := c.ResolveInstance(args[0])
+		if err != nil {
+			return err
+		}
+
+		image := rescueImageID
+		if image != "" {
+			image, err = c.ResolveImage(image)
+			if err != nil {
+				return err
+			}
+		}
+
+		return errors.Wrap(c.RescueInstance(instance, image), "Error rescuing instance")
+	},
+}
+
+var rescueCmd = &cobra.Command{
+	Use:   "rescue",
+	Short: "Rescue an object in the cluster",
+}
+
+var unrescueInstanceCmd = &cobra.Command{
+	Use:   "instance INSTANCE",
+	Short: "Reboot a rescued instance from its own volumes",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		instance, err := c.ResolveInstance(args[0])
+		if err != nil {
+			return err
+		}
+
+		return errors.Wrap(c.UnrescueInstance(instance), "Error unrescuing instance")
+	},
+}
+
+var unrescueCmd = &cobra.Command{
+	Use:   "unrescue",
+	Short: "Unrescue an object in the cluster",
+}
+
+func init() {
+	rescueInstanceCmd.Flags().StringVar(&rescueImageID, "image", "", "Image to boot the instance from")
+
+	rescueCmd.AddCommand(rescueInstanceCmd)
+	unrescueCmd.AddCommand(unrescueInstanceCmd)
+	rootCmd.AddCommand(rescueCmd)
+	rootCmd.AddCommand(unrescueCmd)
+}
@@ -15,16 +15,32 @@
 package cmd
 
 import (
+	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
 var restartInstanceCmd = &cobra.Command{
-	Use:   "instance ID",
+	Use:   "instance INSTANCE",
 	Short: "Restart an instance",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return errors.Wrap(c.StartInstance(args[0]), "Error starting instance")
+		instance, err := c.ResolveInstance(args[0])
+		if err != nil {
+			return err
+		}
+
+		return errors.Wrap(c.StartInstance(instance), "Error starting instance")
+	},
+}
+
+var restartGroupCmd = &cobra.Command{
+	Use:         "group GROUP",
+	Short:       "Restart the exited instances of an instance group",
+	Args:        cobra.ExactArgs(1),
+	Annotations: groupActionAnnotations,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runGroupAction(cmd, args[0], api.GroupRestart, 0)
 	},
 }
 
@@ -35,5 +51,6 @@ var restartCmd = &cobra.Command{
 
 func init() {
 	restartCmd.AddCommand(restartInstanceCmd)
+	restartCmd.AddCommand(restartGroupCmd)
 	rootCmd.AddCommand(restartCmd)
 }
@@ -18,8 +18,11 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/intel/tfortools"
 
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
@@ -32,9 +35,65 @@ func restoreNode(args []string) int {
 	return 0
 }
 
+func restoreDeleted(resourceType types.DeletedResourceType, resource string) error {
+	ID, err := c.ResolveDeleted(resourceType, resource)
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrapf(c.RestoreDeletedResource(ID), "Error restoring %s", resourceType)
+}
+
+var restoreInstanceCmd = &cobra.Command{
+	Use:   "instance INSTANCE",
+	Short: "Restore an instance from the recycle bin",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return restoreDeleted(types.DeletedInstance, args[0])
+	},
+}
+
+var restoreVolumeCmd = &cobra.Command{
+	Use:   "volume VOLUME",
+	Short: "Restore a volume from the recycle bin",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return restoreDeleted(types.DeletedVolume, args[0])
+	},
+}
+
+var restoreSnapshotFlags = struct {
+	name string
+}{}
+
+var restoreSnapshotCmd = &cobra.Command{
+	Use:   "snapshot SNAPSHOT",
+	Short: "Create an instance from a snapshot set",
+	Long: `Create an instance of the workload of the instance a snapshot set was
+taken of, using copy-on-write clones of the volumes of the set.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ID, err := c.ResolveSnapshotSet(args[0])
+		if err != nil {
+			return err
+		}
+
+		servers, err := c.RestoreSnapshotSet(ID, restoreSnapshotFlags.name)
+		if err != nil {
+			return errors.Wrap(err, "Error restoring snapshot set")
+		}
+
+		return render(cmd, servers.Servers)
+	},
+	Annotations: map[string]string{
+		"default_template": `{{ table (cols . "Name" "ID" "Status") }}`,
+		"template_usage":   tfortools.GenerateUsageUndecorated([]api.ServerDetails{}),
+	},
+}
+
 var restoreCmd = &cobra.Command{
 	Use:   "restore [NODE]",
-	Short: "Restore a node",
+	Short: "Restore a node, an instance or volume from the recycle bin, or a snapshot set",
 	Args:  cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		os.Exit(restoreNode(args))
@@ -42,5 +101,10 @@ var restoreCmd = &cobra.Command{
 }
 
 func init() {
+	restoreCmd.AddCommand(restoreInstanceCmd)
+	restoreCmd.AddCommand(restoreVolumeCmd)
+	restoreCmd.AddCommand(restoreSnapshotCmd)
+
+	restoreSnapshotCmd.Flags().StringVar(&restoreSnapshotFlags.name, "name", "", "Name of the restored instance")
 	rootCmd.AddCommand(restoreCmd)
 }
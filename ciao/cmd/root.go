@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/client"
 	"github.com/intel/tfortools"
 	"github.com/pkg/errors"
@@ -30,6 +31,9 @@ var c client.Client
 var template string
 var rootUsageFunc (func(cmd *cobra.Command) error)
 
+// signTo is the file the requests are signed to instead of being sent.
+var signTo string
+
 func render(cmd *cobra.Command, data interface{}) error {
 	if template == "" && cmd.Annotations != nil {
 		template = cmd.Annotations["default_template"]
@@ -43,6 +47,40 @@ func render(cmd *cobra.Command, data interface{}) error {
 		"Error generating template output")
 }
 
+// groupActionAnnotations are the annotations of the commands acting on
+// instance groups, which render the result for each instance.
+var groupActionAnnotations = map[string]string{
+	"default_template": "{{ table .}}",
+	"template_usage":   tfortools.GenerateUsageUndecorated([]api.InstanceGroupMemberResult{}),
+}
+
+// runGroupAction applies an action to an instance group and renders its
+// result for each instance of the group.  An error is returned if the
+// action failed for any of them.
+func runGroupAction(cmd *cobra.Command, group string, action api.InstanceGroupAction, instances int) error {
+	result, err := c.InstanceGroupAction(group, action, instances)
+	if err != nil {
+		return errors.Wrapf(err, "Error applying %s to instance group", action)
+	}
+
+	if err := render(cmd, result.Results); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, r := range result.Results {
+		if r.Error != "" {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return errors.Errorf("%s failed for %d instance(s) of group %s", action, failed, group)
+	}
+
+	return nil
+}
+
 func templatedUsageFunc(cmd *cobra.Command) error {
 	err := rootUsageFunc(cmd)
 	if err != nil {
@@ -68,6 +106,7 @@ const (
 	ciaoCACertFileEnv     = "CIAO_CA_CERT_FILE"
 	ciaoClientCertFileEnv = "CIAO_CLIENT_CERT_FILE"
 	ciaoTenantIDEnv       = "CIAO_TENANT_ID"
+	ciaoAPITokenEnv       = "CIAO_API_TOKEN"
 )
 
 func getCiaoEnvVariables() {
@@ -75,6 +114,7 @@ func getCiaoEnvVariables() {
 	c.CACertFile = os.Getenv(ciaoCACertFileEnv)
 	c.ClientCertFile = os.Getenv(ciaoClientCertFileEnv)
 	c.TenantID = os.Getenv(ciaoTenantIDEnv)
+	c.APIToken = os.Getenv(ciaoAPITokenEnv)
 }
 
 var rootCmd = &cobra.Command{
@@ -89,10 +129,32 @@ The CIAO CLI sends HTTPS requests to the CIAO controller enabling one to control
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
+		if errors.Cause(err) == client.ErrRequestSigned {
+			fmt.Printf("Request signed to %s\n", signTo)
+			return
+		}
+
+		fmt.Fprintln(os.Stderr, "Error:", err)
 		os.Exit(1)
 	}
 }
 
+// openSignTo makes the client sign the requests to the file given with
+// --sign-to, to be submitted later by an admin.
+func openSignTo(cmd *cobra.Command, args []string) error {
+	if signTo == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(signTo, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Wrap(err, "Error opening signed requests file")
+	}
+	c.SignTo = f
+
+	return nil
+}
+
 func init() {
 	getCiaoEnvVariables()
 	if err := c.Init(); err != nil {
@@ -104,5 +166,8 @@ func init() {
 	rootCmd.SetUsageFunc(templatedUsageFunc)
 
 	rootCmd.PersistentFlags().StringVarP(&template, "template", "f", "", "Template used to format output")
+	rootCmd.PersistentFlags().StringVar(&signTo, "sign-to", "", "Sign the requests changing the cluster to this file for an admin to submit, instead of sending them")
+	rootCmd.PersistentPreRunE = openSignTo
 	rootCmd.SilenceUsage = true
+	rootCmd.SilenceErrors = true
 }
@@ -62,12 +62,52 @@ var cnciShowCmd = &cobra.Command{
 	},
 }
 
+const clusterShowTemplate = `Nodes:			{{ .Nodes }}
+Tenants:		{{ .Tenants }}
+Instances:		{{ .Instances }}
+Configuration version:	{{ .ConfigVersion }}
+Configuration loaded:	{{ .ConfigLoaded }}
+{{- if .ConfigReloadError }}
+Reload error:		{{ .ConfigReloadError }}
+{{- end }}
+`
+
+var clusterShowCmd = &cobra.Command{
+	Use:   "cluster",
+	Short: "Show summary information about the cluster",
+	Long: `Shows the number of nodes, tenants and instances of the cluster and the version
+of the cluster configuration the controller runs with, along with the error
+of the last failed configuration reload, if any.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !c.IsPrivileged() {
+			return errors.New("Cluster information is restricted to privileged users")
+		}
+
+		summary, err := c.ShowCluster()
+		if err != nil {
+			return errors.Wrap(err, "Error getting cluster summary")
+		}
+
+		return render(cmd, summary)
+	},
+	Annotations: map[string]string{
+		"default_template": clusterShowTemplate,
+		"template_usage":   tfortools.GenerateUsageUndecorated(types.ClusterSummary{}),
+	},
+}
+
 var imageShowCmd = &cobra.Command{
-	Use:   "image ID",
+	Use:   "image IMAGE",
 	Short: "Show information about an image",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		image, err := c.GetImage(args[0])
+		imageID, err := c.ResolveImage(args[0])
+		if err != nil {
+			return err
+		}
+
+		image, err := c.GetImage(imageID)
 		if err != nil {
 			return errors.Wrap(err, "Error getting image")
 		}
@@ -80,11 +120,16 @@ var imageShowCmd = &cobra.Command{
 }
 
 var instanceShowCmd = &cobra.Command{
-	Use:   "instance ID",
+	Use:   "instance INSTANCE",
 	Short: "Show information about an instance",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		server, err := c.GetInstance(args[0])
+		instance, err := c.ResolveInstance(args[0])
+		if err != nil {
+			return err
+		}
+
+		server, err := c.GetInstance(instance)
 		if err != nil {
 			return errors.Wrap(err, "Error getting instance")
 		}
@@ -129,6 +174,28 @@ var nodeShowCmd = &cobra.Command{
 	},
 }
 
+var snapshotShowCmd = &cobra.Command{
+	Use:   "snapshot SNAPSHOT",
+	Short: "Show information about a snapshot set",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ID, err := c.ResolveSnapshotSet(args[0])
+		if err != nil {
+			return err
+		}
+
+		set, err := c.GetSnapshotSet(ID)
+		if err != nil {
+			return errors.Wrap(err, "Error getting snapshot set")
+		}
+
+		return render(cmd, set)
+	},
+	Annotations: map[string]string{
+		"template_usage": tfortools.GenerateUsageUndecorated(types.SnapshotSet{}),
+	},
+}
+
 var tenantShowCmd = &cobra.Command{
 	Use:   "tenant ID",
 	Short: "Show tenant configuration",
@@ -151,6 +218,51 @@ var tenantShowCmd = &cobra.Command{
 	},
 }
 
+const usageShowTemplate = `Tenant:		{{ .Name }} ({{ .ID }})
+Instances:	{{ .Instances }}
+{{- range $state, $n := .InstanceStates }}
+  {{ $state }}:	{{ $n }}
+{{- end }}
+Volumes:	{{ .Volumes }} ({{ .VolumeSizeGB }} GB)
+Images:		{{ .Images }} ({{ .ImageSize }} bytes)
+External IPs:	{{ len .ExternalIPs }}
+{{- range .ExternalIPs }}
+  {{ .ExternalIP }} -> {{ .InternalIP }}
+{{- end }}
+Quotas:
+{{- range .Quotas }}
+  {{ .Name }}:	{{ .Usage }} / {{ if eq .Value -1 }}unlimited{{ else }}{{ .Value }}{{ end }}
+{{- end }}
+Recent events:
+{{- range .Events }}
+  {{ .Timestamp }}	{{ .EventType }}	{{ .Message }}
+{{- end }}
+`
+
+var usageShowCmd = &cobra.Command{
+	Use:   "usage TENANT",
+	Short: "Show the resource usage of a tenant",
+	Long: `Shows the instances, volumes, images and external IPs of a tenant, its quota
+utilization and its most recent events.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !c.IsPrivileged() {
+			return errors.New("Tenant usage is restricted to privileged users")
+		}
+
+		summary, err := c.GetTenantUsage(args[0])
+		if err != nil {
+			return errors.Wrap(err, "Error getting tenant usage")
+		}
+
+		return render(cmd, summary)
+	},
+	Annotations: map[string]string{
+		"default_template": usageShowTemplate,
+		"template_usage":   tfortools.GenerateUsageUndecorated(types.TenantUsageSummary{}),
+	},
+}
+
 var traceShowCmd = &cobra.Command{
 	Use:   "trace LABEL",
 	Short: "Show trace data for a label",
@@ -173,15 +285,26 @@ Name:		{{ .Name }}
 Description:	{{ .Description }}
 State:		{{ .State }}
 Size:		{{ .Size }}
+Class:		{{ .Class }}
+Encrypted:	{{ .Encrypted }}
+UsedMB:		{{ .UsedMB }}
 CreateTime:	{{ .CreateTime }}
+{{- with .Failure }}
+Failure:	{{ .Code }} ({{ .Operation }}: {{ .Reason }}) at {{ .Time }}
+{{- end }}
 `
 
 var volumeShowCmd = &cobra.Command{
-	Use:   "volume ID",
+	Use:   "volume VOLUME",
 	Short: "Show volume information",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		volume, err := c.GetVolume(args[0])
+		volumeID, err := c.ResolveVolume(args[0])
+		if err != nil {
+			return err
+		}
+
+		volume, err := c.GetVolume(volumeID)
 		if err != nil {
 			return errors.Wrap(err, "Error getting volume")
 		}
@@ -205,26 +328,56 @@ Visibility:		{{ .Visibility }}
 Requirements:
 	MemMB:		{{ .Requirements.MemMB }}
 	VCPUs:		{{ .Requirements.VCPUs }}
+{{- if .Requirements.DiskMB }}
+	DiskMB:		{{ .Requirements.DiskMB }}
+{{- end }}
+{{- if .Requirements.DiskIOPS }}
+	DiskIOPS:	{{ .Requirements.DiskIOPS }}
+{{- end }}
+{{- if .Requirements.NetMbps }}
+	NetMbps:	{{ .Requirements.NetMbps }}
+{{- end }}
 	NodeID:		{{ .Requirements.NodeID }}
 	Hostname	{{ .Requirements.Hostname }}
 	NetworkNode	{{ .Requirements.NetworkNode }}
 	Privileged	{{ .Requirements.Privileged }}
+{{- if .Requirements.Console }}
+	Console:	{{ .Requirements.Console }}
+{{- end }}
+{{- if .Requirements.Priority }}
+	Priority:	{{ .Requirements.Priority }}
+{{- end }}
+{{- if .Requirements.Watchdog }}
+	Watchdog:	{{ .Requirements.Watchdog }}
+{{- end }}
+{{- if .Secrets }}
+Secrets:
+{{- range .Secrets }}
+	{{ . }}
+{{- end }}
+{{- end }}
 Storage:
 {{- range .Storage }}
 	ID:		{{ .ID }}
 	Size:		{{ .Size }}
 	Ephemeral:	{{ .Ephemeral }}
 	Bootable:	{{ .Bootable }}
+	CDROM:		{{ .CDROM }}
 	SourceType:	{{ .SourceType }}
 	Source:		{{ .Source }}
 {{ end }}`
 
 var workloadShowCmd = &cobra.Command{
-	Use:   "workload ID",
+	Use:   "workload WORKLOAD",
 	Short: "Show workload information",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		workload, err := c.GetWorkload(args[0])
+		workloadID, err := c.ResolveWorkload(args[0])
+		if err != nil {
+			return err
+		}
+
+		workload, err := c.GetWorkload(workloadID)
 		if err != nil {
 			return errors.Wrap(err, "Error getting workload")
 		}
@@ -238,12 +391,15 @@ var workloadShowCmd = &cobra.Command{
 }
 
 var showCmds = []*cobra.Command{
+	clusterShowCmd,
 	cnciShowCmd,
 	imageShowCmd,
 	instanceShowCmd,
 	nodeShowCmd,
+	snapshotShowCmd,
 	tenantShowCmd,
 	traceShowCmd,
+	usageShowCmd,
 	volumeShowCmd,
 	workloadShowCmd,
 }
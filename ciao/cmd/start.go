@@ -0,0 +1,42 @@
+// Copyright © 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var startTraceCmd = &cobra.Command{
+	Use:   "trace LABEL",
+	Short: "Trace subsequent instance launches with a label",
+	Long: `Trace all subsequent instance launches in the cluster with LABEL, until
+tracing is stopped. Launches which specify their own label are traced
+with that label instead.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.Wrap(c.StartTrace(args[0]), "Error starting trace")
+	},
+}
+
+var startCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Start an activity in the cluster",
+}
+
+func init() {
+	startCmd.AddCommand(startTraceCmd)
+	rootCmd.AddCommand(startCmd)
+}
@@ -15,25 +15,52 @@
 package cmd
 
 import (
+	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
 var stopInstanceCmd = &cobra.Command{
-	Use:   "instance ID",
+	Use:   "instance INSTANCE",
 	Short: "Stop an instance",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return errors.Wrap(c.StopInstance(args[0]), "Error stopping instance")
+		instance, err := c.ResolveInstance(args[0])
+		if err != nil {
+			return err
+		}
+
+		return errors.Wrap(c.StopInstance(instance), "Error stopping instance")
+	},
+}
+
+var stopGroupCmd = &cobra.Command{
+	Use:         "group GROUP",
+	Short:       "Stop the running instances of an instance group",
+	Args:        cobra.ExactArgs(1),
+	Annotations: groupActionAnnotations,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runGroupAction(cmd, args[0], api.GroupStop, 0)
+	},
+}
+
+var stopTraceCmd = &cobra.Command{
+	Use:   "trace",
+	Short: "Stop tracing instance launches",
+	Args:  cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.Wrap(c.StopTrace(), "Error stopping trace")
 	},
 }
 
 var stopCmd = &cobra.Command{
 	Use:   "stop",
-	Short: "Stop an object in the cluster",
+	Short: "Stop an object or activity in the cluster",
 }
 
 func init() {
 	stopCmd.AddCommand(stopInstanceCmd)
+	stopCmd.AddCommand(stopGroupCmd)
+	stopCmd.AddCommand(stopTraceCmd)
 	rootCmd.AddCommand(stopCmd)
 }
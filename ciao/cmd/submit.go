@@ -0,0 +1,100 @@
+// Copyright © 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/intel/tfortools"
+	"github.com/pkg/errors"
+
+	"github.com/spf13/cobra"
+)
+
+type submittedRequest struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Body       string
+}
+
+var submitCmd = &cobra.Command{
+	Use:   "submit FILE",
+	Short: "Submit signed requests",
+	Long: `Submit the requests signed to FILE with --sign-to.
+
+The controller runs each request as if it had been sent by its author, once
+and only while it is fresh.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return errors.Wrap(err, "Error opening signed requests file")
+		}
+		defer func() { _ = f.Close() }()
+
+		var results []submittedRequest
+		failed := 0
+
+		dec := json.NewDecoder(f)
+		for {
+			var req types.SignedRequest
+			err := dec.Decode(&req)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return errors.Wrap(err, "Error reading signed request")
+			}
+
+			result, err := c.SubmitSignedRequest(req)
+			if err != nil {
+				return errors.Wrapf(err, "Error submitting signed request %s %s", req.Method, req.Path)
+			}
+
+			if result.StatusCode >= http.StatusBadRequest {
+				failed++
+			}
+
+			results = append(results, submittedRequest{
+				Method:     req.Method,
+				Path:       req.Path,
+				StatusCode: result.StatusCode,
+				Body:       result.Body,
+			})
+		}
+
+		if err := render(cmd, results); err != nil {
+			return err
+		}
+
+		if failed > 0 {
+			return errors.Errorf("%d signed request(s) failed", failed)
+		}
+
+		return nil
+	},
+	Annotations: map[string]string{
+		"default_template": `{{ table (cols . "Method" "Path" "StatusCode") }}`,
+		"template_usage":   tfortools.GenerateUsageUndecorated([]submittedRequest{}),
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(submitCmd)
+}
@@ -0,0 +1,44 @@
+// Copyright © 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var unpauseInstanceCmd = &cobra.Command{
+	Use:   "instance INSTANCE",
+	Short: "Unpause an instance",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		instance, err := c.ResolveInstance(args[0])
+		if err != nil {
+			return err
+		}
+
+		return errors.Wrap(c.UnpauseInstance(instance), "Error unpausing instance")
+	},
+}
+
+var unpauseCmd = &cobra.Command{
+	Use:   "unpause",
+	Short: "Unpause an object in the cluster",
+}
+
+func init() {
+	unpauseCmd.AddCommand(unpauseInstanceCmd)
+	rootCmd.AddCommand(unpauseCmd)
+}
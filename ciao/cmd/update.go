@@ -15,9 +15,14 @@
 package cmd
 
 import (
+	"encoding/json"
+	"fmt"
+	"sort"
 	"strconv"
 
+	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
 	"github.com/ciao-project/ciao/uuid"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -31,8 +36,9 @@ var updateCmd = &cobra.Command{
 var updateQuotasCmd = &cobra.Command{
 	Use:   "quota TENANT NAME VALUE",
 	Short: "Update tenant quotas",
-	Long:  "Updates the quota entry for the supplied tenant with the value or limit",
-	Args:  cobra.ExactArgs(3),
+	Long: `Updates the quota entry for the supplied tenant with the value or limit and
+prints the change. Lowering a quota or limit requires --yes.`,
+	Args: cobra.ExactArgs(3),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if !c.IsPrivileged() {
 			return errors.New("Updating quotas is restricted to privileged users")
@@ -53,19 +59,202 @@ var updateQuotasCmd = &cobra.Command{
 			}
 		}
 
+		previous, err := c.ListQuotas(tenant)
+		if err != nil {
+			return errors.Wrap(err, "Error listing quotas")
+		}
+
 		quotas := []types.QuotaDetails{{
 			Name:  name,
 			Value: v,
 		}}
 
-		return errors.Wrap(c.UpdateQuotas(tenant, quotas), "Error updating quotas")
+		var warnings []string
+		for _, qd := range previous {
+			if qd.Name == name && quotaLowered(qd.Value, v) {
+				warnings = append(warnings, fmt.Sprintf("%s is lowered", name))
+			}
+		}
+
+		if len(warnings) > 0 && !updateFlags.yes {
+			printDiff(quotaValues(previous), quotaValues(mergeQuotas(previous, quotas)))
+			return confirmationRequired(warnings)
+		}
+
+		result, err := c.UpdateQuotas(tenant, quotas)
+		if err != nil {
+			return errors.Wrap(err, "Error updating quotas")
+		}
+
+		printDiff(quotaValues(result.Previous), quotaValues(result.Quotas))
+
+		return nil
 	},
 }
 
+var updateFlags = struct {
+	yes bool
+}{}
+
+// quotaLowered returns whether changing a quota from before to after
+// lowers it, -1 meaning unlimited.
+func quotaLowered(before int, after int) bool {
+	return after != -1 && (before == -1 || after < before)
+}
+
+// mergeQuotas returns the quotas a quota update results in.
+func mergeQuotas(quotas []types.QuotaDetails, update []types.QuotaDetails) []types.QuotaDetails {
+	merged := append([]types.QuotaDetails{}, quotas...)
+
+	for _, u := range update {
+		found := false
+		for i := range merged {
+			if merged[i].Name == u.Name {
+				merged[i].Value = u.Value
+				found = true
+			}
+		}
+
+		if !found {
+			merged = append(merged, u)
+		}
+	}
+
+	return merged
+}
+
+func quotaValues(quotas []types.QuotaDetails) map[string]string {
+	values := make(map[string]string)
+
+	for _, qd := range quotas {
+		if qd.Value == -1 {
+			values[qd.Name] = "unlimited"
+		} else {
+			values[qd.Name] = strconv.Itoa(qd.Value)
+		}
+	}
+
+	return values
+}
+
+// tenantConfigValues flattens a tenant configuration into a map of its
+// JSON fields, nested fields being named after their parents, e.g.
+// cnci_flavor.vcpus.
+func tenantConfigValues(config types.TenantConfig) (map[string]string, error) {
+	b, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]interface{}
+	err = json.Unmarshal(b, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	flattenValues(values, "", fields)
+
+	return values, nil
+}
+
+func flattenValues(values map[string]string, prefix string, fields map[string]interface{}) {
+	for k, v := range fields {
+		if m, ok := v.(map[string]interface{}); ok {
+			flattenValues(values, prefix+k+".", m)
+			continue
+		}
+
+		values[prefix+k] = fmt.Sprint(v)
+	}
+}
+
+// printDiff prints the values which differ between before and after, in
+// the style of a unified diff.
+func printDiff(before map[string]string, after map[string]string) {
+	keys := make(map[string]bool)
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+
+	var sorted []string
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	changed := false
+	for _, k := range sorted {
+		b, inBefore := before[k]
+		a, inAfter := after[k]
+		if inBefore && inAfter && a == b {
+			continue
+		}
+
+		if inBefore {
+			fmt.Printf("- %s: %s\n", k, b)
+		}
+		if inAfter {
+			fmt.Printf("+ %s: %s\n", k, a)
+		}
+		changed = true
+	}
+
+	if !changed {
+		fmt.Println("No changes")
+	}
+}
+
+func confirmationRequired(warnings []string) error {
+	for _, w := range warnings {
+		fmt.Printf("Warning: %s\n", w)
+	}
+
+	return errors.New("Destructive change, use --yes to apply it")
+}
+
+// tenantConfigWarnings describes the destructive changes of a tenant
+// configuration update.
+func tenantConfigWarnings(before types.TenantConfig, after types.TenantConfig) []string {
+	var warnings []string
+
+	if before.Permissions.PrivilegedContainers && !after.Permissions.PrivilegedContainers {
+		warnings = append(warnings, "privileged containers are no longer allowed")
+	}
+
+	if before.CNCIFlavor.VCPUs != after.CNCIFlavor.VCPUs || before.CNCIFlavor.MemMB != after.CNCIFlavor.MemMB {
+		warnings = append(warnings, "the running CNCIs of the tenant are restarted, interrupting its traffic")
+	}
+
+	return warnings
+}
+
+func printTenantConfigDiff(before types.TenantConfig, after types.TenantConfig) error {
+	b, err := tenantConfigValues(before)
+	if err != nil {
+		return err
+	}
+
+	a, err := tenantConfigValues(after)
+	if err != nil {
+		return err
+	}
+
+	printDiff(b, a)
+
+	return nil
+}
+
 var tenantUpdateCmd = &cobra.Command{
 	Use:   "tenant ID",
 	Short: "Update tenant configuration",
-	Args:  cobra.ExactArgs(1),
+	Long: `Updates the configuration of a tenant and prints the change. Revoking the
+permission to create privileged containers and changing the CNCI vCPUs or
+memory, which restarts the running CNCIs of the tenant, require --yes.`,
+	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if !c.IsPrivileged() {
 			return errors.New("Updating tenants is restricted to privileged users")
@@ -83,24 +272,163 @@ var tenantUpdateCmd = &cobra.Command{
 			return errors.New("Tenant ID must be a UUID")
 		}
 
+		if tenantRenumberFlags.renumber {
+			return renumberTenant(tuuid.String())
+		}
+
 		config := types.TenantConfig{
 			Name:       tenantFlags.name,
 			SubnetBits: tenantFlags.cidrPrefixSize,
 		}
 		config.Permissions.PrivilegedContainers = tenantFlags.createPrivilegedContainers
+		config.Permissions.GuestAgent = tenantFlags.guestAgent
+		config.CNCIFlavor = tenantFlags.cnciFlavor
+
+		preview, err := c.PreviewTenantConfig(tuuid.String(), config)
+		if err != nil {
+			return errors.Wrap(err, "Error getting tenant config")
+		}
+
+		warnings := tenantConfigWarnings(preview.Previous, preview.Config)
+		if len(warnings) > 0 && !updateFlags.yes {
+			err = printTenantConfigDiff(preview.Previous, preview.Config)
+			if err != nil {
+				return err
+			}
+			return confirmationRequired(warnings)
+		}
+
+		result, err := c.UpdateTenantConfig(tuuid.String(), config)
+		if err != nil {
+			return errors.Wrap(err, "Error updating tenant config")
+		}
+
+		return printTenantConfigDiff(result.Previous, result.Config)
+	},
+}
+
+var tenantRenumberFlags = struct {
+	renumber bool
+	dryRun   bool
+}{}
+
+// renumberTenant changes the subnet size of a tenant which has instances
+// and lists the addresses which do not fit the new subnet size.
+func renumberTenant(tenantID string) error {
+	if tenantFlags.cidrPrefixSize == 0 {
+		return errors.New("Renumbering requires a new cidr-prefix-size")
+	}
+
+	result, err := c.RenumberTenant(tenantID, tenantFlags.cidrPrefixSize, tenantRenumberFlags.dryRun)
+	if err != nil {
+		return errors.Wrap(err, "Error renumbering tenant")
+	}
 
-		return errors.Wrap(c.UpdateTenantConfig(tuuid.String(), config),
-			"Error updating tenant config")
+	if result.DryRun {
+		fmt.Printf("Subnet size would change from /%d to /%d\n", result.OldSubnetBits, result.SubnetBits)
+	} else {
+		fmt.Printf("Subnet size changed from /%d to /%d\n", result.OldSubnetBits, result.SubnetBits)
+	}
+
+	for _, conflict := range result.Conflicts {
+		owner := "reserved"
+		if conflict.InstanceID != "" {
+			owner = "instance " + conflict.InstanceID
+		}
+		fmt.Printf("Conflict: %s in %s (%s)\n", conflict.IPAddress, conflict.Subnet, owner)
+	}
+
+	return nil
+}
+
+var nodePolicyFlags = struct {
+	weight              int
+	maxInstances        int
+	allowCNCIColocation bool
+	dedicatedTenant     string
+}{}
+
+var groupUpdateFlags = struct {
+	instances int
+}{}
+
+var groupUpdateCmd = &cobra.Command{
+	Use:   "group GROUP",
+	Short: "Scale an instance group",
+	Long: `Launches or deletes instances of an instance group until it has the requested
+number of instances. The most recently created instances are deleted first.`,
+	Args:        cobra.ExactArgs(1),
+	Annotations: groupActionAnnotations,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if groupUpdateFlags.instances < 0 {
+			return errors.New("A number of instances of 0 or more is required")
+		}
+
+		return runGroupAction(cmd, args[0], api.GroupScale, groupUpdateFlags.instances)
+	},
+}
+
+var nodeUpdateCmd = &cobra.Command{
+	Use:   "node ID",
+	Short: "Update node scheduling policy",
+	Long: `Sets the scheduling weight and the maximum number of instances of a node.
+Nodes with a lower weight are only chosen by the scheduler when no node with a
+higher weight can run an instance. A maximum of 0 instances means unlimited.
+The workloads of a tenant are only started on a node running a CNCI of the
+tenant when CNCI co-location is allowed. A compute node dedicated to a tenant
+only runs the instances of that tenant, which then only run on its dedicated
+nodes.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !c.IsPrivileged() {
+			return errors.New("Updating nodes is restricted to privileged users")
+		}
+
+		if nodePolicyFlags.weight < 0 || nodePolicyFlags.weight > payloads.MaxNodeWeight {
+			return errors.Errorf("Weight must be 0-%d", payloads.MaxNodeWeight)
+		}
+
+		if nodePolicyFlags.maxInstances < 0 {
+			return errors.New("Maximum instances must not be negative")
+		}
+
+		policy := types.NodePolicy{
+			NodeID:              args[0],
+			Weight:              nodePolicyFlags.weight,
+			MaxInstances:        nodePolicyFlags.maxInstances,
+			AllowCNCIColocation: nodePolicyFlags.allowCNCIColocation,
+			DedicatedTenant:     nodePolicyFlags.dedicatedTenant,
+		}
+
+		return errors.Wrap(c.SetNodePolicy(policy), "Error updating node policy")
 	},
 }
 
 func init() {
 	updateCmd.AddCommand(updateQuotasCmd)
 	updateCmd.AddCommand(tenantUpdateCmd)
+	updateCmd.AddCommand(nodeUpdateCmd)
+	updateCmd.AddCommand(groupUpdateCmd)
 
 	tenantUpdateCmd.Flags().IntVar(&tenantFlags.cidrPrefixSize, "cidr-prefix-size", 0, "Number of bits in network mask (12-30)")
 	tenantUpdateCmd.Flags().BoolVar(&tenantFlags.createPrivilegedContainers, "create-privileged-containers", false, "Whether this tenant can create privileged containers")
+	tenantUpdateCmd.Flags().BoolVar(&tenantFlags.guestAgent, "guest-agent", false, "Whether this tenant can use the guest agent of its instances")
 	tenantUpdateCmd.Flags().StringVar(&tenantFlags.name, "name", "", "Tenant name")
+	tenantUpdateCmd.Flags().IntVar(&tenantFlags.cnciFlavor.VCPUs, "cnci-vcpus", 0, "Number of vCPUs of the CNCIs of the tenant, running CNCIs are restarted")
+	tenantUpdateCmd.Flags().IntVar(&tenantFlags.cnciFlavor.MemMB, "cnci-mem", 0, "Memory of the CNCIs of the tenant in MiB, running CNCIs are restarted")
+	tenantUpdateCmd.Flags().IntVar(&tenantFlags.cnciFlavor.DiskGiB, "cnci-disk", 0, "Disk size of new CNCIs of the tenant in GiB")
+	tenantUpdateCmd.Flags().BoolVar(&tenantRenumberFlags.renumber, "renumber", false, "Change the subnet size of a tenant which has instances")
+	tenantUpdateCmd.Flags().BoolVar(&tenantRenumberFlags.dryRun, "dry-run", false, "Report the conflicts of a renumbering without applying it")
+	tenantUpdateCmd.Flags().BoolVarP(&updateFlags.yes, "yes", "y", false, "Apply destructive changes")
+
+	updateQuotasCmd.Flags().BoolVarP(&updateFlags.yes, "yes", "y", false, "Apply destructive changes")
+
+	groupUpdateCmd.Flags().IntVar(&groupUpdateFlags.instances, "instances", -1, "Number of instances to scale the group to")
+
+	nodeUpdateCmd.Flags().IntVar(&nodePolicyFlags.weight, "weight", payloads.MaxNodeWeight, "Scheduling weight of the node")
+	nodeUpdateCmd.Flags().IntVar(&nodePolicyFlags.maxInstances, "max-instances", 0, "Maximum number of instances on the node, 0 for unlimited")
+	nodeUpdateCmd.Flags().BoolVar(&nodePolicyFlags.allowCNCIColocation, "allow-cnci-colocation", false, "Whether tenant workloads may run on the node while it runs a CNCI of their tenant")
+	nodeUpdateCmd.Flags().StringVar(&nodePolicyFlags.dedicatedTenant, "dedicated-tenant", "", "ID of the tenant the node is dedicated to, empty to share it with all tenants")
 
 	rootCmd.AddCommand(updateCmd)
 }
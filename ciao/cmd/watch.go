@@ -0,0 +1,195 @@
+// Copyright © 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/intel/tfortools"
+	"github.com/pkg/errors"
+
+	"github.com/spf13/cobra"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Follow changes to cluster objects",
+}
+
+var eventWatchFlags = struct {
+	eventType string
+	json      bool
+	tenant    string
+}{}
+
+var eventWatchCmd = &cobra.Command{
+	Use: "events",
+	Long: `Follow the events logged from now on until interrupted. If no tenant is
+specified and the user is privileged the events of all tenants are shown,
+otherwise the events of the current tenant.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tenantID := eventWatchFlags.tenant
+		if !c.IsPrivileged() && tenantID == "" {
+			tenantID = c.TenantID
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		err := c.WatchEvents(tenantID, eventWatchFlags.eventType, func(event types.CiaoEvent) error {
+			if eventWatchFlags.json {
+				return enc.Encode(event)
+			}
+
+			return render(cmd, event)
+		})
+
+		return errors.Wrap(err, "Error watching events")
+	},
+	Annotations: map[string]string{
+		"default_template": "{{ .Timestamp }} {{ .TenantID }} {{ .EventType }}: {{ .Message }}\n",
+		"template_usage":   tfortools.GenerateUsageUndecorated(types.CiaoEvent{}),
+	},
+}
+
+// instanceStat is the latest resource usage of an instance.
+type instanceStat struct {
+	ID          string
+	Name        string
+	NodeID      string
+	Status      string
+	CPUUsage    int
+	MemUsageMB  int
+	DiskUsageMB int
+	Updated     time.Time
+}
+
+var instanceWatchFlags = struct {
+	interval time.Duration
+	tenant   string
+}{}
+
+// getInstanceStats returns the latest resource usage of the instances of a
+// tenant.
+func getInstanceStats(tenantID string) ([]instanceStat, error) {
+	stats, err := c.ListInstanceStats(tenantID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting instance statistics")
+	}
+
+	servers, err := c.ListInstancesByWorkload(tenantID, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "Error listing instances")
+	}
+
+	names := make(map[string]string)
+	for _, s := range servers.Servers {
+		names[s.ID] = s.Name
+	}
+
+	instances := make([]instanceStat, 0, len(stats.Servers))
+	for _, s := range stats.Servers {
+		instances = append(instances, instanceStat{
+			ID:          s.ID,
+			Name:        names[s.ID],
+			NodeID:      s.NodeID,
+			Status:      s.Status,
+			CPUUsage:    s.VCPUUsage,
+			MemUsageMB:  s.MemUsage,
+			DiskUsageMB: s.DiskUsage,
+			Updated:     s.Timestamp,
+		})
+	}
+
+	return instances, nil
+}
+
+// isTerminal returns true if f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+var instanceWatchCmd = &cobra.Command{
+	Use: "instances",
+	Long: `Show the CPU, memory and disk usage of the instances, as last reported by
+their compute nodes, refreshing it until interrupted. If no tenant is
+specified the instances of the current tenant are shown.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tenantID := instanceWatchFlags.tenant
+		if tenantID == "" {
+			tenantID = c.TenantID
+		}
+
+		if instanceWatchFlags.interval <= 0 {
+			return errors.New("The refresh interval must be positive")
+		}
+
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, os.Interrupt)
+		defer signal.Stop(sigCh)
+
+		ticker := time.NewTicker(instanceWatchFlags.interval)
+		defer ticker.Stop()
+
+		clearScreen := isTerminal(os.Stdout)
+		for {
+			instances, err := getInstanceStats(tenantID)
+			if err != nil {
+				return err
+			}
+
+			if clearScreen {
+				fmt.Print("\033[H\033[2J")
+			}
+
+			if err := render(cmd, instances); err != nil {
+				return err
+			}
+
+			select {
+			case <-sigCh:
+				return nil
+			case <-ticker.C:
+			}
+		}
+	},
+	Annotations: map[string]string{
+		"default_template": `{{ table (cols . "ID" "Name" "Status" "CPUUsage" "MemUsageMB" "DiskUsageMB") }}`,
+		"template_usage":   tfortools.GenerateUsageUndecorated([]instanceStat{}),
+	},
+}
+
+var watchCmds = []*cobra.Command{eventWatchCmd, instanceWatchCmd}
+
+func init() {
+	for _, cmd := range watchCmds {
+		watchCmd.AddCommand(cmd)
+	}
+
+	eventWatchCmd.Flags().StringVar(&eventWatchFlags.eventType, "type", "", "Only show events of this type (info,error)")
+	eventWatchCmd.Flags().BoolVar(&eventWatchFlags.json, "json", false, "Output each event as a line of JSON")
+	eventWatchCmd.Flags().StringVar(&eventWatchFlags.tenant, "tenant", "", "Only show the events of this tenant")
+
+	instanceWatchCmd.Flags().DurationVar(&instanceWatchFlags.interval, "interval", 2*time.Second, "Interval between refreshes")
+	instanceWatchCmd.Flags().StringVar(&instanceWatchFlags.tenant, "tenant", "", "Show the instances of this tenant")
+
+	rootCmd.AddCommand(watchCmd)
+}
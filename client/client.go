@@ -28,21 +28,46 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/pkg/errors"
 )
 
+// maxIdleConns is the number of idle connections to the controller a
+// client keeps open for reuse.
+const maxIdleConns = 16
+
 // Client represents a client for accessing ciao controller
+//
+// A Client must be initialised with Init before it is used. Once Init has
+// returned, a Client is safe for concurrent use by multiple goroutines and
+// its exported fields must no longer be modified. Connections to the
+// controller, and their TLS sessions, are reused across requests, so a
+// single Client should be shared rather than created for each goroutine.
 type Client struct {
 	ControllerURL  string
 	TenantID       string
 	CACertFile     string
 	ClientCertFile string
 
+	// APIToken authenticates the client when no ClientCertFile is
+	// given. Tokens are scoped to a tenant so TenantID must be set.
+	APIToken string
+
+	// SignTo, when set, makes the client render the requests which would
+	// modify the cluster as signed requests, written to SignTo as JSON,
+	// instead of sending them.  They are signed with the private key of
+	// ClientCertFile and can be submitted later by an admin with
+	// SubmitSignedRequest.  The calls making them return ErrRequestSigned.
+	SignTo io.Writer
+
 	caCertPool *x509.CertPool
 	clientCert *tls.Certificate
+	httpClient *http.Client
+	signLock   sync.Mutex
 
 	Tenants []string
 }
@@ -134,8 +159,8 @@ func (client *Client) Init() error {
 		return errors.New("Controller URL must be specified")
 	}
 
-	if client.ClientCertFile == "" {
-		return errors.New("Client certificate file must be specified")
+	if client.ClientCertFile == "" && client.APIToken == "" {
+		return errors.New("Client certificate file or API token must be specified")
 	}
 
 	if !strings.HasPrefix(client.ControllerURL, "https://") {
@@ -146,13 +171,54 @@ func (client *Client) Init() error {
 		return err
 	}
 
-	if err := client.prepareClientCert(); err != nil {
-		return err
+	if client.ClientCertFile != "" {
+		if err := client.prepareClientCert(); err != nil {
+			return err
+		}
+	} else {
+		if client.TenantID == "" {
+			return errors.New("A tenant ID must be specified with an API token")
+		}
+		client.Tenants = []string{client.TenantID}
+	}
+
+	if client.SignTo != nil && client.clientCert == nil {
+		return errors.New("Requests can only be signed with a client certificate")
 	}
 
+	client.httpClient = client.newHTTPClient()
+
 	return nil
 }
 
+func (client *Client) newHTTPClient() *http.Client {
+	tlsConfig := &tls.Config{
+		RootCAs:            client.caCertPool,
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+	}
+
+	if client.clientCert != nil {
+		tlsConfig.Certificates = []tls.Certificate{*client.clientCert}
+	}
+
+	transport := &http.Transport{
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConns:        maxIdleConns,
+		MaxIdleConnsPerHost: maxIdleConns,
+		IdleConnTimeout:     90 * time.Second,
+	}
+
+	return &http.Client{Transport: transport}
+}
+
+// closeResponse drains and closes the body of a response so that its
+// connection can be reused.
+func closeResponse(resp *http.Response) {
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+}
+
 func (client *Client) buildComputeURL(format string, args ...interface{}) string {
 	prefix := fmt.Sprintf("%s/v2.1/", client.ControllerURL)
 	return fmt.Sprintf(prefix+format, args...)
@@ -188,34 +254,32 @@ func (client *Client) sendHTTPRequest(method string, url string, values []queryV
 		req.Header.Set("Accept", "application/json")
 	}
 
-	tlsConfig := &tls.Config{}
-
-	if client.caCertPool != nil {
-		tlsConfig.RootCAs = client.caCertPool
+	if client.SignTo != nil && method != "GET" {
+		return nil, client.signRequest(req, content)
 	}
 
-	if client.clientCert != nil {
-		tlsConfig.Certificates = []tls.Certificate{*client.clientCert}
-		tlsConfig.BuildNameToCertificate()
+	if client.clientCert == nil && client.APIToken != "" {
+		req.Header.Set("Authorization", "Bearer "+client.APIToken)
 	}
 
-	transport := &http.Transport{
-		TLSClientConfig: tlsConfig,
+	if client.httpClient == nil {
+		return nil, errors.New("Client not initialised")
 	}
 
-	c := &http.Client{Transport: transport}
-	resp, err := c.Do(req)
+	resp, err := client.httpClient.Do(req)
 	if err != nil {
 		return nil, errors.Wrap(err, "Could not send HTTP request")
 	}
 
 	if resp.StatusCode >= http.StatusBadRequest {
+		defer closeResponse(resp)
+
 		respBody, errBody := ioutil.ReadAll(resp.Body)
 		if errBody != nil {
-			return resp, fmt.Errorf("HTTP Error: %s", resp.Status)
+			return nil, fmt.Errorf("HTTP Error: %s", resp.Status)
 		}
 
-		return resp, fmt.Errorf("HTTP Error [%d] for [%s %s]: %s", resp.StatusCode, method, url, respBody)
+		return nil, fmt.Errorf("HTTP Error [%d] for [%s %s]: %s", resp.StatusCode, method, url, respBody)
 	}
 
 	return resp, err
@@ -254,6 +318,12 @@ func (client *Client) getCiaoResource(name string, minVersion string) (string, e
 		url = client.buildCiaoURL(fmt.Sprintf("%s", client.TenantID))
 	}
 
+	// requests may be signed on machines which cannot reach the
+	// controller, the resources are where the controller serves them.
+	if client.SignTo != nil {
+		return strings.TrimSuffix(url, "/") + "/" + name, nil
+	}
+
 	err := client.getResource(url, "", nil, &resources)
 	if err != nil {
 		return "", err
@@ -284,7 +354,7 @@ func (client *Client) getResource(url string, content string, query []queryValue
 	if err != nil {
 		return errors.Wrapf(err, "Error making HTTP request to %s", url)
 	}
-	defer resp.Body.Close()
+	defer closeResponse(resp)
 
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("HTTP response code from %s not as expected: %d", url, resp.StatusCode)
@@ -304,7 +374,7 @@ func (client *Client) deleteResource(url string, content string) error {
 	if err != nil {
 		return errors.Wrapf(err, "Error making HTTP request to %s", url)
 	}
-	defer resp.Body.Close()
+	defer closeResponse(resp)
 
 	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNoContent {
 		return fmt.Errorf("HTTP response code from %s not as expected: %s", url, resp.Status)
@@ -313,7 +383,7 @@ func (client *Client) deleteResource(url string, content string) error {
 	return nil
 }
 
-func (client *Client) putResource(url string, content string, request interface{}) error {
+func (client *Client) putResource(url string, content string, request interface{}, result interface{}) error {
 	b, err := json.Marshal(request)
 	if err != nil {
 		return errors.Wrap(err, "Error marshalling JSON")
@@ -323,12 +393,20 @@ func (client *Client) putResource(url string, content string, request interface{
 	if err != nil {
 		return errors.Wrapf(err, "Error making HTTP request to %s", url)
 	}
-	defer resp.Body.Close()
+	defer closeResponse(resp)
 
 	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
 		return fmt.Errorf("HTTP response code from %s not as expected: %s", url, resp.Status)
 	}
 
+	if result != nil && resp.StatusCode != http.StatusNoContent {
+		err = client.unmarshalHTTPResponse(resp, result)
+		if err != nil {
+			data, _ := ioutil.ReadAll(resp.Body)
+			return errors.Wrapf(err, "Error parsing HTTP response: %s", data)
+		}
+	}
+
 	return nil
 }
 
@@ -342,7 +420,7 @@ func (client *Client) postResource(url string, content string, request interface
 	if err != nil {
 		return errors.Wrapf(err, "Error making HTTP request to %s", url)
 	}
-	defer resp.Body.Close()
+	defer closeResponse(resp)
 
 	if result != nil && resp.StatusCode != http.StatusNoContent {
 		err = client.unmarshalHTTPResponse(resp, result)
@@ -0,0 +1,378 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/pkg/errors"
+)
+
+func writeClientCert(t *testing.T, dir string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Organization: []string{"admin"},
+		},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().Add(time.Hour),
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	cert, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})...)
+
+	path := filepath.Join(dir, "client.pem")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// startTestController starts a TLS server serving an empty image list and
+// counting the connections made to it.
+func startTestController(t *testing.T, dir string, conns *int32) (*httptest.Server, string) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/images" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("[]"))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(conns, 1)
+		}
+	}
+	ts.StartTLS()
+
+	caPath := filepath.Join(dir, "ca.pem")
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := ioutil.WriteFile(caPath, caCert, 0600); err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+
+	return ts, caPath
+}
+
+// Test that a client can be shared by several goroutines and reuses its
+// connections to the controller, including after HTTP errors.
+func TestClientConcurrentRequests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ciao-client-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	var conns int32
+	ts, caPath := startTestController(t, dir, &conns)
+	defer ts.Close()
+
+	client := Client{
+		ControllerURL:  ts.URL,
+		CACertFile:     caPath,
+		ClientCertFile: writeClientCert(t, dir),
+	}
+
+	err = client.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if client.TenantID != "admin" || !client.IsPrivileged() {
+		t.Fatalf("Expected an admin client, got tenant %s", client.TenantID)
+	}
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, maxIdleConns*10)
+	for i := 0; i < maxIdleConns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if _, err := client.ListImages(); err != nil {
+					errCh <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		t.Fatalf("Concurrent request failed: %v", err)
+	}
+
+	atomic.StoreInt32(&conns, 0)
+
+	if _, err := client.GetImage("missing"); err == nil {
+		t.Fatal("Expected an error getting a missing image")
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, err := client.ListImages(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := atomic.LoadInt32(&conns); n != 0 {
+		t.Fatalf("Expected connections to be reused, got %d new connections", n)
+	}
+}
+
+// Test that a client without a certificate authenticates with its API token.
+func TestClientAPIToken(t *testing.T) {
+	const tenantID = "093ae09b-f653-464e-9ae6-5ae28bd03a22"
+	const token = "5d4f2c1e-8b3a-4f6d-9e2b-7c1a0f3e6d52.secret"
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) != 0 || r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Path != "/"+tenantID+"/tokens" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("[]"))
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "ciao-client-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	caPath := filepath.Join(dir, "ca.pem")
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := ioutil.WriteFile(caPath, caCert, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	client := Client{
+		ControllerURL: ts.URL,
+		CACertFile:    caPath,
+		APIToken:      token,
+	}
+
+	if err := client.Init(); err == nil {
+		t.Fatal("Expected an error initialising an API token client without a tenant")
+	}
+
+	client.TenantID = tenantID
+	if err := client.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if client.IsPrivileged() {
+		t.Fatal("API token client should not be privileged")
+	}
+
+	if _, err := client.ListAPITokens(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Test that a client renders the requests modifying the cluster as signed
+// requests which can be verified with its certificate.
+func TestClientSignRequests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ciao-client-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	var signed bytes.Buffer
+	client := Client{
+		ControllerURL:  "https://controller.invalid:8889",
+		ClientCertFile: writeClientCert(t, dir),
+		SignTo:         &signed,
+	}
+
+	if err := client.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.CreateAPIToken("ci <nightly>", types.APITokenReadOnly, "")
+	if errors.Cause(err) != ErrRequestSigned {
+		t.Fatalf("Expected %v, got %v", ErrRequestSigned, err)
+	}
+
+	err = client.DeleteAPIToken("5d4f2c1e-8b3a-4f6d-9e2b-7c1a0f3e6d52")
+	if errors.Cause(err) != ErrRequestSigned {
+		t.Fatalf("Expected %v, got %v", ErrRequestSigned, err)
+	}
+
+	dec := json.NewDecoder(&signed)
+	for _, expected := range []string{"POST /admin/tokens", "DELETE /admin/tokens/5d4f2c1e-8b3a-4f6d-9e2b-7c1a0f3e6d52"} {
+		var req types.SignedRequest
+		if err := dec.Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+
+		if req.Method+" "+req.Path != expected || req.Nonce == "" {
+			t.Fatalf("Expected %s request, got %+v", expected, req)
+		}
+
+		block, _ := pem.Decode([]byte(req.Certificate))
+		if block == nil {
+			t.Fatalf("No certificate in signed request %+v", req)
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signature, err := base64.StdEncoding.DecodeString(req.Signature)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := req.SigningData()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := cert.CheckSignature(x509.ECDSAWithSHA256, data, signature); err != nil {
+			t.Fatalf("Invalid signature for %+v: %v", req, err)
+		}
+
+		req.Path += "?force=true"
+		data, err = req.SigningData()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := cert.CheckSignature(x509.ECDSAWithSHA256, data, signature); err == nil {
+			t.Fatalf("Signature of %+v not invalidated by tampering", req)
+		}
+	}
+
+	tokenClient := Client{
+		ControllerURL: "https://controller.invalid:8889",
+		TenantID:      "admin",
+		APIToken:      "5d4f2c1e-8b3a-4f6d-9e2b-7c1a0f3e6d52.secret",
+		SignTo:        &signed,
+	}
+
+	if err := tokenClient.Init(); err == nil {
+		t.Fatal("Expected an error initialising a signing client without a certificate")
+	}
+}
+
+// Test that a client follows the events streamed by the controller.
+func TestClientWatchEvents(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2.1/events/watch" || r.URL.Query().Get("type") != "error" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(": keep-alive\n\n" +
+			`data: {"tenant_id":"t1","type":"error","message":"first"}` + "\n\n" +
+			`data: {"tenant_id":"t2","type":"error","message":"second"}` + "\n\n"))
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "ciao-client-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	caPath := filepath.Join(dir, "ca.pem")
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := ioutil.WriteFile(caPath, caCert, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	client := Client{
+		ControllerURL:  ts.URL,
+		CACertFile:     caPath,
+		ClientCertFile: writeClientCert(t, dir),
+	}
+
+	if err := client.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	var messages []string
+	err = client.WatchEvents("", "error", func(event types.CiaoEvent) error {
+		messages = append(messages, event.Message)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(messages) != 2 || messages[0] != "first" || messages[1] != "second" {
+		t.Fatalf("Unexpected events %v", messages)
+	}
+
+	stop := errors.New("stop")
+	messages = nil
+	err = client.WatchEvents("", "error", func(event types.CiaoEvent) error {
+		messages = append(messages, event.Message)
+		return stop
+	})
+	if err != stop || len(messages) != 1 {
+		t.Fatalf("Expected watch to stop after the first event, got %v %v", err, messages)
+	}
+}
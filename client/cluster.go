@@ -0,0 +1,38 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/pkg/errors"
+)
+
+// ShowCluster returns summary information about the cluster, including the
+// version of the cluster configuration the controller runs with
+func (client *Client) ShowCluster() (types.ClusterSummary, error) {
+	var summary types.ClusterSummary
+
+	if !client.IsPrivileged() {
+		return summary, errors.New("This command is only available to admins")
+	}
+
+	url := client.buildCiaoURL("cluster")
+	err := client.getResource(url, api.ClusterV1, nil, &summary)
+
+	return summary, err
+}
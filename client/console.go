@@ -0,0 +1,66 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/pkg/errors"
+)
+
+// OpenConsole opens an emergency serial console session with an instance
+func (client *Client) OpenConsole(instanceID string) (types.ConsoleSession, error) {
+	var session types.ConsoleSession
+
+	if !client.IsPrivileged() {
+		return session, errors.New("This command is only available to admins")
+	}
+
+	req := types.ConsoleRequest{InstanceID: instanceID}
+
+	url := client.buildCiaoURL("consoles")
+	err := client.postResource(url, api.ConsolesV1, &req, &session)
+
+	return session, err
+}
+
+// WriteConsole writes input to the console of a session, which may be
+// empty, and returns the console output read since the previous call
+func (client *Client) WriteConsole(sessionID string, input string) (types.ConsoleSession, error) {
+	var session types.ConsoleSession
+
+	if !client.IsPrivileged() {
+		return session, errors.New("This command is only available to admins")
+	}
+
+	req := types.ConsoleInputRequest{Input: input}
+
+	url := client.buildCiaoURL("consoles/%s", sessionID)
+	err := client.postResource(url, api.ConsolesV1, &req, &session)
+
+	return session, err
+}
+
+// CloseConsole closes a console session
+func (client *Client) CloseConsole(sessionID string) error {
+	if !client.IsPrivileged() {
+		return errors.New("This command is only available to admins")
+	}
+
+	url := client.buildCiaoURL("consoles/%s", sessionID)
+	return client.deleteResource(url, api.ConsolesV1)
+}
@@ -0,0 +1,62 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/pkg/errors"
+)
+
+// ListFederationPeers lists the peer clusters instances are burst to
+func (client *Client) ListFederationPeers() ([]types.FederationPeer, error) {
+	var peers []types.FederationPeer
+
+	if !client.IsPrivileged() {
+		return peers, errors.New("This command is only available to admins")
+	}
+
+	url := client.buildCiaoURL("federation/peers")
+	err := client.getResource(url, api.FederationV1, nil, &peers)
+
+	return peers, err
+}
+
+// AddFederationPeer registers a peer cluster instances are burst to when
+// the cluster is full
+func (client *Client) AddFederationPeer(req types.FederationPeerRequest) (types.FederationPeer, error) {
+	var peer types.FederationPeer
+
+	if !client.IsPrivileged() {
+		return peer, errors.New("This command is only available to admins")
+	}
+
+	url := client.buildCiaoURL("federation/peers")
+	err := client.postResource(url, api.FederationV1, &req, &peer)
+
+	return peer, err
+}
+
+// DeleteFederationPeer removes a peer cluster
+func (client *Client) DeleteFederationPeer(peerID string) error {
+	if !client.IsPrivileged() {
+		return errors.New("This command is only available to admins")
+	}
+
+	url := client.buildCiaoURL("federation/peers/%s", peerID)
+	return client.deleteResource(url, api.FederationV1)
+}
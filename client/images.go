@@ -42,6 +42,25 @@ func (client *Client) GetImage(imageID string) (types.Image, error) {
 	return i, err
 }
 
+// DownloadImage writes the raw data of an image to w
+func (client *Client) DownloadImage(imageID string, w io.Writer) error {
+	var url string
+	if client.IsPrivileged() && client.TenantID == "admin" {
+		url = client.buildCiaoURL("images/%s/file", imageID)
+	} else {
+		url = client.buildCiaoURL("%s/images/%s/file", client.TenantID, imageID)
+	}
+
+	resp, err := client.sendHTTPRequest("GET", url, nil, nil, "")
+	if err != nil {
+		return err
+	}
+	defer closeResponse(resp)
+
+	_, err = io.Copy(w, resp.Body)
+	return errors.Wrap(err, "Error reading image data")
+}
+
 func (client *Client) uploadTenantImage(tenant, image string, data io.Reader) error {
 	var url string
 	if client.IsPrivileged() && client.TenantID == "admin" {
@@ -51,13 +70,16 @@ func (client *Client) uploadTenantImage(tenant, image string, data io.Reader) er
 	}
 
 	resp, err := client.sendHTTPRequest("PUT", url, nil, data, fmt.Sprintf("%s/octet-stream", api.ImagesV1))
-	defer func() { _ = resp.Body.Close() }()
+	if err != nil {
+		return err
+	}
+	defer closeResponse(resp)
 
 	if resp.StatusCode != http.StatusNoContent {
 		return fmt.Errorf("Unexpected HTTP response code (%d): %s", resp.StatusCode, resp.Status)
 	}
 
-	return err
+	return nil
 }
 
 // CreateImage creates and uploads a new image
@@ -89,8 +111,44 @@ func (client *Client) CreateImage(name string, visibility types.Visibility, ID s
 	return image.ID, nil
 }
 
+// ImportImage creates a new image whose data is fetched by the controller
+// from url, and verified against checksum, of the form sha256:HEX, if it is
+// not empty. The import completes asynchronously, once the image leaves
+// the saving state.
+func (client *Client) ImportImage(name string, visibility types.Visibility, ID string, url string, checksum string) (string, error) {
+	opts := api.CreateImageRequest{
+		Name:       name,
+		ID:         ID,
+		Visibility: visibility,
+		URL:        url,
+		Checksum:   checksum,
+	}
+
+	var imagesURL string
+	if client.IsPrivileged() && client.TenantID == "admin" {
+		imagesURL = client.buildCiaoURL("images")
+	} else {
+		imagesURL = client.buildCiaoURL("%s/images", client.TenantID)
+	}
+
+	var image types.Image
+	err := client.postResource(imagesURL, api.ImagesV1, &opts, &image)
+	if err != nil {
+		return "", errors.Wrap(err, "Error importing image")
+	}
+
+	return image.ID, nil
+}
+
 // ListImages retrieves the set of available images
 func (client *Client) ListImages() ([]types.Image, error) {
+	return client.ListImagesWithFilter(api.ImageFilter{})
+}
+
+// ListImagesWithFilter retrieves the available images selected by filter,
+// oldest first. The next page of images is retrieved by setting the filter
+// marker to the ID of the last image returned.
+func (client *Client) ListImagesWithFilter(filter api.ImageFilter) ([]types.Image, error) {
 	var images []types.Image
 
 	var url string
@@ -100,7 +158,12 @@ func (client *Client) ListImages() ([]types.Image, error) {
 		url = client.buildCiaoURL("%s/images", client.TenantID)
 	}
 
-	err := client.getResource(url, api.ImagesV1, nil, &images)
+	var query []queryValue
+	for name, values := range filter.Values() {
+		query = append(query, queryValue{name: name, value: values[0]})
+	}
+
+	err := client.getResource(url, api.ImagesV1, query, &images)
 
 	return images, err
 }
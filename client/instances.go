@@ -18,10 +18,14 @@ package client
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 
 	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/pkg/errors"
 )
 
@@ -50,7 +54,7 @@ func (client *Client) instanceAction(instanceID string, action string) error {
 	if err != nil {
 		return errors.Wrap(err, "Error making HTTP request")
 	}
-	defer resp.Body.Close()
+	defer closeResponse(resp)
 
 	if resp.StatusCode != http.StatusAccepted {
 		return fmt.Errorf("HTTP response code from %s not as expected: %d", url, resp.StatusCode)
@@ -63,6 +67,123 @@ func (client *Client) StopInstance(instanceID string) error {
 	return client.instanceAction(instanceID, "os-stop")
 }
 
+// PauseInstance pauses the given instance
+func (client *Client) PauseInstance(instanceID string) error {
+	return client.instanceAction(instanceID, "pause")
+}
+
+// UnpauseInstance unpauses the given instance
+func (client *Client) UnpauseInstance(instanceID string) error {
+	return client.instanceAction(instanceID, "unpause")
+}
+
+func (client *Client) postInstanceRequest(instanceID string, action string, request interface{}) error {
+	b, err := json.Marshal(request)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling JSON")
+	}
+
+	url := client.buildCiaoURL("%s/instances/%s/%s", client.TenantID, instanceID, action)
+
+	resp, err := client.sendHTTPRequest("POST", url, nil, bytes.NewReader(b), api.InstancesV1)
+	if err != nil {
+		return errors.Wrap(err, "Error making HTTP request")
+	}
+	defer closeResponse(resp)
+
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("HTTP response code from %s not as expected: %d", url, resp.StatusCode)
+	}
+	return nil
+}
+
+// RescueInstance reboots the given instance from a volume created from
+// imageID, or from the rescue image of the controller if imageID is empty
+func (client *Client) RescueInstance(instanceID string, imageID string) error {
+	request := api.RescueServerRequest{
+		ImageID: imageID,
+	}
+
+	return client.postInstanceRequest(instanceID, "rescue", &request)
+}
+
+// UnrescueInstance reboots the given rescued instance from its own volumes
+func (client *Client) UnrescueInstance(instanceID string) error {
+	return client.postInstanceRequest(instanceID, "unrescue", struct{}{})
+}
+
+// RebootInstance reboots the given instance in place.  The instance is
+// killed straight away if hard is set rather than asked to shut down
+func (client *Client) RebootInstance(instanceID string, hard bool) error {
+	request := api.RebootServerRequest{
+		Type: api.RebootSoft,
+	}
+
+	if hard {
+		request.Type = api.RebootHard
+	}
+
+	return client.postInstanceRequest(instanceID, "reboot", &request)
+}
+
+// CloneInstance creates num copies of the given stopped instance, booting
+// from copy-on-write clones of its boot volume
+func (client *Client) CloneInstance(instanceID string, num int, name string) (api.Servers, error) {
+	var servers api.Servers
+
+	request := api.CloneServerRequest{
+		Instances: num,
+		Name:      name,
+	}
+
+	url := client.buildCiaoURL("%s/instances/%s/clone", client.TenantID, instanceID)
+	err := client.postResource(url, api.InstancesV1, &request, &servers)
+
+	return servers, err
+}
+
+// GuestAgentInstance runs an operation through the QEMU guest agent of the
+// given running VM instance and returns its result
+func (client *Client) GuestAgentInstance(instanceID string, request types.GuestAgentRequest) (types.GuestAgentResult, error) {
+	var result types.GuestAgentResult
+
+	url := client.buildCiaoURL("%s/instances/%s/guest-agent", client.TenantID, instanceID)
+	err := client.postResource(url, api.InstancesV1, &request, &result)
+
+	return result, err
+}
+
+// CaptureInstance captures the network traffic of the given running
+// instance on its compute node and returns the captured packets.  Only
+// admins may capture the traffic of instances.
+func (client *Client) CaptureInstance(instanceID string, request types.PacketCaptureRequest) (types.PacketCaptureResult, error) {
+	var result types.PacketCaptureResult
+
+	if !client.IsPrivileged() {
+		return result, errors.New("This command is only available to admins")
+	}
+
+	url := client.buildCiaoURL("%s/instances/%s/capture", client.TenantID, instanceID)
+	err := client.postResource(url, api.InstancesV1, &request, &result)
+
+	return result, err
+}
+
+// ExportInstance writes the export bundle of the given stopped instance,
+// a tar archive holding its boot disk and its metadata, to w
+func (client *Client) ExportInstance(instanceID string, w io.Writer) error {
+	url := client.buildComputeURL("%s/servers/%s/export", client.TenantID, instanceID)
+
+	resp, err := client.sendHTTPRequest("GET", url, nil, nil, "")
+	if err != nil {
+		return err
+	}
+	defer closeResponse(resp)
+
+	_, err = io.Copy(w, resp.Body)
+	return errors.Wrap(err, "Error reading export bundle")
+}
+
 // StartInstance stops the given instance
 func (client *Client) StartInstance(instanceID string) error {
 	return client.instanceAction(instanceID, "os-start")
@@ -102,3 +223,61 @@ func (client *Client) GetInstance(instanceID string) (api.Server, error) {
 
 	return server, err
 }
+
+// GetInstanceByName gets the details of the instance with the given name
+func (client *Client) GetInstanceByName(name string) (api.Server, error) {
+	var server api.Server
+
+	url := client.buildCiaoURL("%s/instances/by-name/%s", client.TenantID, url.PathEscape(name))
+	err := client.getResource(url, api.InstancesV1, nil, &server)
+
+	return server, err
+}
+
+// ListInstanceActions gets the state transitions of an instance along with
+// who initiated them and why
+func (client *Client) ListInstanceActions(instanceID string) ([]types.InstanceAction, error) {
+	var actions []types.InstanceAction
+
+	url := client.buildCiaoURL("%s/instances/%s/actions", client.TenantID, instanceID)
+	err := client.getResource(url, api.InstancesV1, nil, &actions)
+
+	return actions, err
+}
+
+// ListInstanceGroups gets the instance groups of the tenant
+func (client *Client) ListInstanceGroups() ([]api.InstanceGroup, error) {
+	var groups api.InstanceGroups
+
+	url := client.buildCiaoURL("%s/instances/groups", client.TenantID)
+	err := client.getResource(url, api.InstancesV1, nil, &groups)
+
+	return groups.Groups, err
+}
+
+// GetInstanceGroup gets the instances of the given instance group
+func (client *Client) GetInstanceGroup(group string) (api.InstanceGroup, error) {
+	var result api.InstanceGroup
+
+	url := client.buildCiaoURL("%s/instances/groups/%s", client.TenantID, url.PathEscape(group))
+	err := client.getResource(url, api.InstancesV1, nil, &result)
+
+	return result, err
+}
+
+// InstanceGroupAction applies an action to all the instances of the given
+// group and returns its result for each of them. The number of instances
+// is only used when scaling the group
+func (client *Client) InstanceGroupAction(group string, action api.InstanceGroupAction, instances int) (api.InstanceGroupActionResult, error) {
+	var result api.InstanceGroupActionResult
+
+	request := api.InstanceGroupActionRequest{
+		Action:    action,
+		Instances: instances,
+	}
+
+	url := client.buildCiaoURL("%s/instances/groups/%s/action", client.TenantID, url.PathEscape(group))
+	err := client.postResource(url, api.InstancesV1, &request, &result)
+
+	return result, err
+}
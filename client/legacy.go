@@ -17,9 +17,13 @@
 package client
 
 import (
+	"bufio"
+	"encoding/json"
+	"strings"
 	"time"
 
 	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/pkg/errors"
 )
 
 // ListEvents retrieves the events for either all or the desired tenant
@@ -38,6 +42,53 @@ func (client *Client) ListEvents(tenantID string) (types.CiaoEvents, error) {
 	return events, err
 }
 
+// WatchEvents follows the events logged from now on for either all or the
+// desired tenant, optionally restricted to a type of event, and calls fn
+// for each of them. It returns when fn returns an error or the controller
+// closes the stream.
+func (client *Client) WatchEvents(tenantID string, eventType string, fn func(types.CiaoEvent) error) error {
+	var url string
+	var query []queryValue
+
+	if tenantID == "" {
+		url = client.buildComputeURL("events/watch")
+	} else {
+		url = client.buildComputeURL("%s/events/watch", tenantID)
+	}
+
+	if eventType != "" {
+		query = append(query, queryValue{name: "type", value: eventType})
+	}
+
+	resp, err := client.sendHTTPRequest("GET", url, query, nil, "")
+	if err != nil {
+		return errors.Wrapf(err, "Error making HTTP request to %s", url)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	// events are sent as server-sent events with JSON data.
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		var event types.CiaoEvent
+		err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &event)
+		if err != nil {
+			return errors.Wrap(err, "Error parsing event")
+		}
+
+		err = fn(event)
+		if err != nil {
+			return err
+		}
+	}
+
+	return errors.Wrap(scanner.Err(), "Error reading events")
+}
+
 // DeleteEvents deletes all events
 func (client *Client) DeleteEvents() error {
 	url := client.buildComputeURL("events")
@@ -55,6 +106,16 @@ func (client *Client) ListInstancesByNode(nodeID string) (types.CiaoServersStats
 	return servers, err
 }
 
+// ListInstanceStats gets the latest statistics of the instances of a tenant
+func (client *Client) ListInstanceStats(tenantID string) (types.CiaoServersStats, error) {
+	var servers types.CiaoServersStats
+
+	url := client.buildComputeURL("%s/servers/stats", tenantID)
+	err := client.getResource(url, "", nil, &servers)
+
+	return servers, err
+}
+
 // DeleteAllInstances deletes all the instances
 func (client *Client) DeleteAllInstances() error {
 	var action types.CiaoServersAction
@@ -166,3 +227,29 @@ func (client *Client) GetTraceData(label string) (types.CiaoTraceData, error) {
 
 	return data, err
 }
+
+// StartTrace traces all subsequent instance launches in the cluster which
+// do not specify a trace label with label
+func (client *Client) StartTrace(label string) error {
+	req := types.CiaoTraceRequest{
+		Label: label,
+	}
+
+	url := client.buildComputeURL("traces/active")
+
+	return client.putResource(url, "", req, nil)
+}
+
+// StopTrace stops tracing the instance launches in the cluster
+func (client *Client) StopTrace() error {
+	url := client.buildComputeURL("traces/active")
+
+	return client.deleteResource(url, "")
+}
+
+// DeleteTraceData deletes the trace data collected for a trace label
+func (client *Client) DeleteTraceData(label string) error {
+	url := client.buildComputeURL("traces/%s", label)
+
+	return client.deleteResource(url, "")
+}
@@ -39,7 +39,62 @@ func (client *Client) ChangeNodeStatus(nodeID string, status types.NodeStatusTyp
 
 	url = fmt.Sprintf("%s/%s", url, nodeID)
 
-	err = client.putResource(url, api.NodeV1, &nodeStatus)
+	err = client.putResource(url, api.NodeV1, &nodeStatus, nil)
 
 	return err
 }
+
+// ListNodePolicies returns the scheduling policies set on nodes
+func (client *Client) ListNodePolicies() (types.NodePolicies, error) {
+	var policies types.NodePolicies
+
+	if !client.IsPrivileged() {
+		return policies, errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoResource("node", api.NodeV1)
+	if err != nil {
+		return policies, errors.Wrap(err, "Error getting node resource")
+	}
+
+	url = fmt.Sprintf("%s/policies", url)
+
+	err = client.getResource(url, api.NodeV1, nil, &policies)
+
+	return policies, err
+}
+
+// SetNodePolicy sets the scheduling weight and maximum number of instances
+// of a node
+func (client *Client) SetNodePolicy(policy types.NodePolicy) error {
+	if !client.IsPrivileged() {
+		return errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoResource("node", api.NodeV1)
+	if err != nil {
+		return errors.Wrap(err, "Error getting node resource")
+	}
+
+	url = fmt.Sprintf("%s/%s/policy", url, policy.NodeID)
+
+	err = client.putResource(url, api.NodeV1, &policy, nil)
+
+	return err
+}
+
+// DeleteNodePolicy removes the scheduling policy of a node
+func (client *Client) DeleteNodePolicy(nodeID string) error {
+	if !client.IsPrivileged() {
+		return errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoResource("node", api.NodeV1)
+	if err != nil {
+		return errors.Wrap(err, "Error getting node resource")
+	}
+
+	url = fmt.Sprintf("%s/%s/policy", url, nodeID)
+
+	return client.deleteResource(url, api.NodeV1)
+}
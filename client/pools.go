@@ -32,6 +32,11 @@ func (client *Client) getCiaoPoolsResource() (string, error) {
 func (client *Client) getCiaoPoolRef(name string) (string, error) {
 	var pools types.ListPoolsResponse
 
+	name, err := client.ResolvePool(name)
+	if err != nil {
+		return "", err
+	}
+
 	query := queryValue{
 		name:  "name",
 		value: name,
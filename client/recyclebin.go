@@ -0,0 +1,70 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/pkg/errors"
+)
+
+// ListDeletedResources lists the instances and volumes of the tenant which
+// are in the recycle bin
+func (client *Client) ListDeletedResources() ([]types.DeletedResource, error) {
+	var resources []types.DeletedResource
+
+	url := client.buildCiaoURL("%s/recycle-bin", client.TenantID)
+	err := client.getResource(url, api.RecycleBinV1, nil, &resources)
+
+	return resources, err
+}
+
+// RestoreDeletedResource takes an instance or a volume out of the recycle
+// bin
+func (client *Client) RestoreDeletedResource(ID string) error {
+	url := client.buildCiaoURL("%s/recycle-bin/%s/restore", client.TenantID, ID)
+	return client.postResource(url, api.RecycleBinV1, nil, nil)
+}
+
+// PurgeDeletedResource permanently deletes an instance or a volume in the
+// recycle bin
+func (client *Client) PurgeDeletedResource(ID string) error {
+	url := client.buildCiaoURL("%s/recycle-bin/%s", client.TenantID, ID)
+	return client.deleteResource(url, api.RecycleBinV1)
+}
+
+// ResolveDeleted returns the ID of the instance or volume in the recycle bin
+// identified by an ID or by its name.
+func (client *Client) ResolveDeleted(resourceType types.DeletedResourceType, resource string) (string, error) {
+	if isUUID(resource) {
+		return resource, nil
+	}
+
+	deleted, err := client.ListDeletedResources()
+	if err != nil {
+		return "", errors.Wrap(err, "Error listing deleted resources")
+	}
+
+	var resources []namedResource
+	for _, r := range deleted {
+		if r.Type == resourceType {
+			resources = append(resources, namedResource{ID: r.ID, Name: r.Name})
+		}
+	}
+
+	return matchName("deleted "+string(resourceType), resource, resources)
+}
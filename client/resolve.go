@@ -0,0 +1,167 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/pkg/errors"
+)
+
+// namedResource is the ID and name of a resource names are resolved
+// against.
+type namedResource struct {
+	ID   string
+	Name string
+}
+
+func isUUID(s string) bool {
+	_, err := uuid.Parse(s)
+	return err == nil
+}
+
+// matchName returns the ID of the only resource called name.  The error
+// lists the IDs of the candidates when the name is ambiguous.
+func matchName(kind string, name string, resources []namedResource) (string, error) {
+	var IDs []string
+	for _, r := range resources {
+		if r.Name == name {
+			IDs = append(IDs, r.ID)
+		}
+	}
+
+	switch len(IDs) {
+	case 0:
+		return "", fmt.Errorf("No %s named %s found", kind, name)
+	case 1:
+		return IDs[0], nil
+	default:
+		return "", fmt.Errorf("Several %ss are named %s, use one of their IDs: %s",
+			kind, name, strings.Join(IDs, ", "))
+	}
+}
+
+// ResolveInstance returns the ID of the instance identified by an ID or by
+// its name.
+func (client *Client) ResolveInstance(instance string) (string, error) {
+	if isUUID(instance) {
+		return instance, nil
+	}
+
+	servers, err := client.ListInstances()
+	if err != nil {
+		return "", errors.Wrap(err, "Error listing instances")
+	}
+
+	resources := make([]namedResource, 0, len(servers.Servers))
+	for _, s := range servers.Servers {
+		resources = append(resources, namedResource{ID: s.ID, Name: s.Name})
+	}
+
+	return matchName("instance", instance, resources)
+}
+
+// ResolveVolume returns the ID of the volume identified by an ID or by its
+// name.
+func (client *Client) ResolveVolume(volume string) (string, error) {
+	if isUUID(volume) {
+		return volume, nil
+	}
+
+	vols, err := client.ListVolumes()
+	if err != nil {
+		return "", errors.Wrap(err, "Error listing volumes")
+	}
+
+	resources := make([]namedResource, 0, len(vols))
+	for _, vol := range vols {
+		resources = append(resources, namedResource{ID: vol.ID, Name: vol.Name})
+	}
+
+	return matchName("volume", volume, resources)
+}
+
+// ResolveImage returns the ID of the image identified by an ID or by its
+// name.
+func (client *Client) ResolveImage(image string) (string, error) {
+	if isUUID(image) {
+		return image, nil
+	}
+
+	images, err := client.ListImages()
+	if err != nil {
+		return "", errors.Wrap(err, "Error listing images")
+	}
+
+	resources := make([]namedResource, 0, len(images))
+	for _, i := range images {
+		resources = append(resources, namedResource{ID: i.ID, Name: i.Name})
+	}
+
+	return matchName("image", image, resources)
+}
+
+// ResolveWorkload returns the ID of the workload identified by an ID or by
+// its name, which is the description of the workload.
+func (client *Client) ResolveWorkload(workload string) (string, error) {
+	if isUUID(workload) {
+		return workload, nil
+	}
+
+	wls, err := client.ListWorkloads()
+	if err != nil {
+		return "", errors.Wrap(err, "Error listing workloads")
+	}
+
+	resources := make([]namedResource, 0, len(wls))
+	for _, wl := range wls {
+		resources = append(resources, namedResource{ID: wl.ID, Name: wl.Description})
+	}
+
+	return matchName("workload", workload, resources)
+}
+
+// ResolvePool returns the name of the external IP pool identified by an ID
+// or by its name, as pools are addressed by name.
+func (client *Client) ResolvePool(pool string) (string, error) {
+	if !isUUID(pool) {
+		return pool, nil
+	}
+
+	url, err := client.getCiaoPoolsResource()
+	if err != nil {
+		return "", err
+	}
+
+	var pools types.ListPoolsResponse
+	err = client.getResource(url, api.PoolsV1, nil, &pools)
+	if err != nil {
+		return "", errors.Wrap(err, "Error listing pools")
+	}
+
+	for _, p := range pools.Pools {
+		if p.ID == pool {
+			return p.Name, nil
+		}
+	}
+
+	return pool, nil
+}
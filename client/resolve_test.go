@@ -0,0 +1,64 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchName(t *testing.T) {
+	resources := []namedResource{
+		{ID: "a2b2a0f3-7dc5-4a4c-8a9e-0f2b5a9cdb11", Name: "web"},
+		{ID: "0c8d1f6e-3b4b-4d0e-9d2a-6f1e7a2c9b22", Name: "db"},
+		{ID: "5e9b3c1a-2f4d-4e8b-a1c7-3d6f9b0e4a33", Name: "db"},
+	}
+
+	ID, err := matchName("instance", "web", resources)
+	if err != nil || ID != resources[0].ID {
+		t.Errorf("Expected %s, got %s (%v)", resources[0].ID, ID, err)
+	}
+
+	_, err = matchName("instance", "cache", resources)
+	if err == nil {
+		t.Error("Expected an error for an unknown name")
+	}
+
+	_, err = matchName("instance", "db", resources)
+	if err == nil || !strings.Contains(err.Error(), resources[1].ID) ||
+		!strings.Contains(err.Error(), resources[2].ID) {
+		t.Errorf("Expected an error listing the matching IDs, got %v", err)
+	}
+}
+
+func TestResolveUUID(t *testing.T) {
+	// IDs are returned as is, without querying the controller.
+	var client Client
+
+	ID := "a2b2a0f3-7dc5-4a4c-8a9e-0f2b5a9cdb11"
+	for _, resolve := range []func(string) (string, error){
+		client.ResolveInstance,
+		client.ResolveVolume,
+		client.ResolveImage,
+		client.ResolveWorkload,
+	} {
+		resolved, err := resolve(ID)
+		if err != nil || resolved != ID {
+			t.Errorf("Expected %s, got %s (%v)", ID, resolved, err)
+		}
+	}
+}
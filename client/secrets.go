@@ -0,0 +1,48 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+)
+
+// ListSecrets lists the secrets of the tenant, without their values
+func (client *Client) ListSecrets() ([]types.Secret, error) {
+	var secrets []types.Secret
+
+	url := client.buildCiaoURL("%s/secrets", client.TenantID)
+	err := client.getResource(url, api.SecretsV1, nil, &secrets)
+
+	return secrets, err
+}
+
+// SetSecret sets the value of a secret, creating it if needed
+func (client *Client) SetSecret(name string, value string) error {
+	req := types.SecretRequest{
+		Value: value,
+	}
+
+	url := client.buildCiaoURL("%s/secrets/%s", client.TenantID, name)
+	return client.putResource(url, api.SecretsV1, &req, nil)
+}
+
+// DeleteSecret deletes a secret
+func (client *Client) DeleteSecret(name string) error {
+	url := client.buildCiaoURL("%s/secrets/%s", client.TenantID, name)
+	return client.deleteResource(url, api.SecretsV1)
+}
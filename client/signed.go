@@ -0,0 +1,130 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/pkg/errors"
+)
+
+// ErrRequestSigned is returned by the calls of a client with SignTo set
+// once the request they would have sent has been signed.
+var ErrRequestSigned = errors.New("Request signed")
+
+// signRequest renders an HTTP request as a signed request and writes it to
+// client.SignTo.  Only requests with a JSON body can be signed.
+func (client *Client) signRequest(req *http.Request, content string) error {
+	if client.clientCert == nil {
+		return errors.New("Requests can only be signed with a client certificate")
+	}
+
+	signer, ok := client.clientCert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return errors.New("Private key of client certificate cannot sign requests")
+	}
+
+	sreq := types.SignedRequest{
+		Method:      req.Method,
+		Path:        req.URL.RequestURI(),
+		ContentType: content,
+		Created:     time.Now().UTC(),
+		Nonce:       uuid.Generate().String(),
+		Certificate: string(pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: client.clientCert.Certificate[0],
+		})),
+	}
+
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return errors.Wrap(err, "Error reading request body")
+		}
+
+		if len(body) > 0 {
+			var v interface{}
+			if json.Unmarshal(body, &v) != nil {
+				return errors.New("Only requests with a JSON body can be signed")
+			}
+			sreq.Body = body
+		}
+	}
+
+	data, err := sreq.SigningData()
+	if err != nil {
+		return errors.Wrap(err, "Error preparing request for signing")
+	}
+
+	digest := sha256.Sum256(data)
+	signature, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return errors.Wrap(err, "Error signing request")
+	}
+	sreq.Signature = base64.StdEncoding.EncodeToString(signature)
+
+	client.signLock.Lock()
+	defer client.signLock.Unlock()
+
+	err = json.NewEncoder(client.SignTo).Encode(&sreq)
+	if err != nil {
+		return errors.Wrap(err, "Error writing signed request")
+	}
+
+	return ErrRequestSigned
+}
+
+// SubmitSignedRequest submits a request signed offline.  The controller
+// runs it as if it had been sent by the owner of the certificate it was
+// signed with and returns its response.
+func (client *Client) SubmitSignedRequest(req types.SignedRequest) (types.SignedRequestResult, error) {
+	var result types.SignedRequestResult
+
+	if !client.IsPrivileged() {
+		return result, errors.New("This command is only available to admins")
+	}
+
+	url := client.buildCiaoURL("signed-requests")
+	err := client.postResource(url, api.SignedRequestsV1, &req, &result)
+
+	return result, err
+}
+
+// ListSignedRequests lists the signed requests submitted to the controller.
+func (client *Client) ListSignedRequests() ([]types.SignedRequestRecord, error) {
+	var records []types.SignedRequestRecord
+
+	if !client.IsPrivileged() {
+		return records, errors.New("This command is only available to admins")
+	}
+
+	url := client.buildCiaoURL("signed-requests")
+	err := client.getResource(url, api.SignedRequestsV1, nil, &records)
+
+	return records, err
+}
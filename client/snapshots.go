@@ -0,0 +1,91 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/pkg/errors"
+)
+
+// CreateSnapshotSet snapshots all the volumes of an instance, freezing its
+// filesystems through the guest agent if it is running.
+func (client *Client) CreateSnapshotSet(instanceID string, request types.SnapshotSetRequest) (types.SnapshotSet, error) {
+	var set types.SnapshotSet
+
+	url := client.buildCiaoURL("%s/instances/%s/snapshots", client.TenantID, instanceID)
+	err := client.postResource(url, api.SnapshotsV1, &request, &set)
+
+	return set, err
+}
+
+// ListSnapshotSets lists the snapshot sets of the tenant
+func (client *Client) ListSnapshotSets() ([]types.SnapshotSet, error) {
+	var sets []types.SnapshotSet
+
+	url := client.buildCiaoURL("%s/snapshots", client.TenantID)
+	err := client.getResource(url, api.SnapshotsV1, nil, &sets)
+
+	return sets, err
+}
+
+// GetSnapshotSet gets the details of a snapshot set
+func (client *Client) GetSnapshotSet(ID string) (types.SnapshotSet, error) {
+	var set types.SnapshotSet
+
+	url := client.buildCiaoURL("%s/snapshots/%s", client.TenantID, ID)
+	err := client.getResource(url, api.SnapshotsV1, nil, &set)
+
+	return set, err
+}
+
+// DeleteSnapshotSet deletes a snapshot set and its volumes
+func (client *Client) DeleteSnapshotSet(ID string) error {
+	url := client.buildCiaoURL("%s/snapshots/%s", client.TenantID, ID)
+	return client.deleteResource(url, api.SnapshotsV1)
+}
+
+// RestoreSnapshotSet creates an instance from a snapshot set
+func (client *Client) RestoreSnapshotSet(ID string, name string) (api.Servers, error) {
+	var servers api.Servers
+
+	request := types.SnapshotSetRestoreRequest{Name: name}
+	url := client.buildCiaoURL("%s/snapshots/%s/restore", client.TenantID, ID)
+	err := client.postResource(url, api.SnapshotsV1, &request, &servers)
+
+	return servers, err
+}
+
+// ResolveSnapshotSet returns the ID of the snapshot set identified by an ID
+// or by its name.
+func (client *Client) ResolveSnapshotSet(set string) (string, error) {
+	if isUUID(set) {
+		return set, nil
+	}
+
+	sets, err := client.ListSnapshotSets()
+	if err != nil {
+		return "", errors.Wrap(err, "Error listing snapshot sets")
+	}
+
+	resources := make([]namedResource, 0, len(sets))
+	for _, s := range sets {
+		resources = append(resources, namedResource{ID: s.ID, Name: s.Name})
+	}
+
+	return matchName("snapshot set", set, resources)
+}
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"net/http"
 
 	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/ciao-controller/types"
@@ -31,22 +32,25 @@ func (client *Client) getCiaoQuotasResource() (string, error) {
 	return client.getCiaoResource("tenants", api.TenantsV1)
 }
 
-// UpdateQuotas updates the quotas for a given tenant
-func (client *Client) UpdateQuotas(tenantID string, quotas []types.QuotaDetails) error {
+// UpdateQuotas updates the quotas for a given tenant and returns the
+// quotas of the tenant before and after the update
+func (client *Client) UpdateQuotas(tenantID string, quotas []types.QuotaDetails) (types.QuotaUpdateResponse, error) {
+	var result types.QuotaUpdateResponse
+
 	if !client.IsPrivileged() {
-		return errors.New("This command is only available to admins")
+		return result, errors.New("This command is only available to admins")
 	}
 
 	url, err := client.getCiaoQuotasResource()
 	if err != nil {
-		return errors.Wrap(err, "Error getting quotas resource")
+		return result, errors.Wrap(err, "Error getting quotas resource")
 	}
 
 	url = fmt.Sprintf("%s/%s/quotas", url, tenantID)
 	req := types.QuotaUpdateRequest{Quotas: quotas}
-	err = client.putResource(url, api.TenantsV1, &req)
+	err = client.putResource(url, api.TenantsV1, &req, &result)
 
-	return err
+	return result, err
 }
 
 // ListQuotas lists the quotas for the specified tenant
@@ -69,6 +73,26 @@ func (client *Client) ListQuotas(tenantID string) ([]types.QuotaDetails, error)
 	return result.Quotas, err
 }
 
+// GetTenantUsage returns the usage summary of the specified tenant: its
+// resources, quota utilization and most recent events
+func (client *Client) GetTenantUsage(tenantID string) (types.TenantUsageSummary, error) {
+	var summary types.TenantUsageSummary
+
+	if !client.IsPrivileged() {
+		return summary, errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoTenantsResource()
+	if err != nil {
+		return summary, errors.Wrap(err, "Error getting tenants resource")
+	}
+
+	url = fmt.Sprintf("%s/%s/summary", url, tenantID)
+	err = client.getResource(url, api.TenantsV1, nil, &summary)
+
+	return summary, err
+}
+
 func (client *Client) getCiaoTenantsResource() (string, error) {
 	url, err := client.getCiaoResource("tenants", api.TenantsV1)
 	return url, err
@@ -123,48 +147,104 @@ func (client *Client) GetTenantConfig(ID string) (types.TenantConfig, error) {
 	return config, err
 }
 
-// UpdateTenantConfig updates the tenant configuration
-func (client *Client) UpdateTenantConfig(ID string, config types.TenantConfig) error {
+// mergeTenantConfig returns the configuration a tenant configuration update
+// results in, the fields of the update left at their zero value keeping
+// their current value.
+func mergeTenantConfig(oldconfig types.TenantConfig, config types.TenantConfig) types.TenantConfig {
+	if config.Name == "" {
+		config.Name = oldconfig.Name
+	}
+
+	if config.SubnetBits == 0 {
+		config.SubnetBits = oldconfig.SubnetBits
+	}
+
+	if config.CNCIFlavor.VCPUs == 0 {
+		config.CNCIFlavor.VCPUs = oldconfig.CNCIFlavor.VCPUs
+	}
+
+	if config.CNCIFlavor.MemMB == 0 {
+		config.CNCIFlavor.MemMB = oldconfig.CNCIFlavor.MemMB
+	}
+
+	if config.CNCIFlavor.DiskGiB == 0 {
+		config.CNCIFlavor.DiskGiB = oldconfig.CNCIFlavor.DiskGiB
+	}
+
+	return config
+}
+
+// PreviewTenantConfig returns the current configuration of a tenant and
+// the configuration updating it with config would result in, without
+// updating it
+func (client *Client) PreviewTenantConfig(ID string, config types.TenantConfig) (types.TenantUpdateResponse, error) {
+	var result types.TenantUpdateResponse
+
+	oldconfig, err := client.GetTenantConfig(ID)
+	if err != nil {
+		return result, err
+	}
+
+	result.Previous = oldconfig
+	result.Config = mergeTenantConfig(oldconfig, config)
+
+	return result, nil
+}
+
+// UpdateTenantConfig updates the tenant configuration and returns the
+// configuration of the tenant before and after the update
+func (client *Client) UpdateTenantConfig(ID string, config types.TenantConfig) (types.TenantUpdateResponse, error) {
+	var result types.TenantUpdateResponse
+
 	url, err := client.getCiaoTenantRef(ID)
 	if err != nil {
-		return err
+		return result, err
 	}
 
 	var oldconfig types.TenantConfig
 	err = client.getResource(url, api.TenantsV1, nil, &oldconfig)
 	if err != nil {
-		return err
+		return result, err
 	}
 
 	a, err := json.Marshal(oldconfig)
 	if err != nil {
-		return err
-	}
-
-	if config.Name == "" {
-		config.Name = oldconfig.Name
+		return result, err
 	}
 
-	if config.SubnetBits == 0 {
-		config.SubnetBits = oldconfig.SubnetBits
-	}
+	config = mergeTenantConfig(oldconfig, config)
 
 	b, err := json.Marshal(config)
 	if err != nil {
-		return err
+		return result, err
 	}
 
 	merge, err := jsonpatch.CreateMergePatch(a, b)
 	if err != nil {
-		return err
+		return result, err
 	}
 
 	body := bytes.NewReader(merge)
 
 	resp, err := client.sendHTTPRequest("PATCH", url, nil, body, "merge-patch+json")
-	defer resp.Body.Close()
+	if err != nil {
+		return result, err
+	}
+	defer closeResponse(resp)
 
-	return err
+	// Controllers predating the update response reply with no content.
+	if resp.StatusCode == http.StatusNoContent {
+		result.Previous = oldconfig
+		result.Config = config
+		return result, nil
+	}
+
+	err = client.unmarshalHTTPResponse(resp, &result)
+	if err != nil {
+		return result, errors.Wrap(err, "Error parsing HTTP response")
+	}
+
+	return result, nil
 }
 
 // CreateTenantConfig creates a new tenant configuration
@@ -189,6 +269,31 @@ func (client *Client) CreateTenantConfig(tenantID string, config types.TenantCon
 	return summary, err
 }
 
+// RenumberTenant changes the size of the subnets of a tenant which has
+// instances.  Existing instances keep their addresses; the addresses which
+// do not fit the new subnet size are returned as conflicts.
+func (client *Client) RenumberTenant(tenantID string, subnetBits int, dryRun bool) (types.TenantRenumbering, error) {
+	var result types.TenantRenumbering
+
+	if !client.IsPrivileged() {
+		return result, errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoTenantRef(tenantID)
+	if err != nil {
+		return result, err
+	}
+
+	req := types.TenantRenumberRequest{
+		SubnetBits: subnetBits,
+		DryRun:     dryRun,
+	}
+
+	err = client.postResource(url+"/renumber", api.TenantsV1, &req, &result)
+
+	return result, err
+}
+
 // DeleteTenant deletes the given tenant
 func (client *Client) DeleteTenant(tenantID string) error {
 	if !client.IsPrivileged() {
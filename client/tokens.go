@@ -0,0 +1,56 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+)
+
+// ListAPITokens lists the API tokens issued to the tenant
+func (client *Client) ListAPITokens() ([]types.APIToken, error) {
+	var tokens []types.APIToken
+
+	url := client.buildCiaoURL("%s/tokens", client.TenantID)
+	err := client.getResource(url, api.TokensV1, nil, &tokens)
+
+	return tokens, err
+}
+
+// CreateAPIToken issues an API token to the tenant. The secret of the token
+// is only returned by this call. An empty expiry selects the controller
+// default.
+func (client *Client) CreateAPIToken(name string, scope types.APITokenScope, expiry string) (types.APIToken, error) {
+	var token types.APIToken
+
+	req := types.APITokenRequest{
+		Name:   name,
+		Scope:  scope,
+		Expiry: expiry,
+	}
+
+	url := client.buildCiaoURL("%s/tokens", client.TenantID)
+	err := client.postResource(url, api.TokensV1, &req, &token)
+
+	return token, err
+}
+
+// DeleteAPIToken revokes an API token
+func (client *Client) DeleteAPIToken(tokenID string) error {
+	url := client.buildCiaoURL("%s/tokens/%s", client.TenantID, tokenID)
+	return client.deleteResource(url, api.TokensV1)
+}
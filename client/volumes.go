@@ -17,6 +17,8 @@
 package client
 
 import (
+	"net/url"
+
 	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/ciao-controller/types"
 )
@@ -51,6 +53,16 @@ func (client *Client) GetVolume(volumeID string) (types.Volume, error) {
 	return volume, err
 }
 
+// GetVolumeByName gets the details of the volume with the given name
+func (client *Client) GetVolumeByName(name string) (types.Volume, error) {
+	var volume types.Volume
+
+	url := client.buildCiaoURL("%s/volumes/by-name/%s", client.TenantID, url.PathEscape(name))
+	err := client.getResource(url, api.VolumesV1, nil, &volume)
+
+	return volume, err
+}
+
 // DeleteVolume deletes a volume
 func (client *Client) DeleteVolume(volumeID string) error {
 	url := client.buildCiaoURL("%s/volumes/%s", client.TenantID, volumeID)
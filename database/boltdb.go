@@ -226,3 +226,117 @@ func (db *BoltDB) DbGetAll(table string, dbTable DbTable) (elements []interface{
 
 	return elements, err
 }
+
+// seek positions a cursor on the first key starting with prefix which
+// comes after the key after
+func seek(c *bolt.Cursor, prefix string, after string) ([]byte, []byte) {
+	if after < prefix {
+		return c.Seek([]byte(prefix))
+	}
+
+	k, v := c.Seek([]byte(after))
+	if k != nil && string(k) == after {
+		return c.Next()
+	}
+
+	return k, v
+}
+
+// DbIterate calls fn, in key order, with the elements of a table whose key
+// starts with prefix, starting after the key after if not empty
+func (db *BoltDB) DbIterate(table string, prefix string, after string, dbTable DbTable, fn DbIterator) error {
+	return db.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(table))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		for k, v := seek(c, prefix, after); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = c.Next() {
+			// nested buckets have no value
+			if v == nil {
+				continue
+			}
+
+			elem := dbTable.NewElement()
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(elem); err != nil {
+				return fmt.Errorf("Decode Error: %v %v", string(k), err)
+			}
+
+			more, err := fn(string(k), elem)
+			if err != nil || !more {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// DbGetPrefix gets the elements of a table whose key starts with prefix
+func (db *BoltDB) DbGetPrefix(table string, prefix string, dbTable DbTable) (elements []interface{}, err error) {
+	err = db.DbIterate(table, prefix, "", dbTable, func(key string, value interface{}) (bool, error) {
+		elements = append(elements, value)
+		return true, nil
+	})
+
+	return elements, err
+}
+
+// DbGetPage gets at most limit elements of a table whose key starts with
+// prefix, starting after the key after if not empty, and returns the key
+// the next page starts after, empty if there are no more elements. A limit
+// of 0 or less gets all the elements.
+func (db *BoltDB) DbGetPage(table string, prefix string, after string, limit int, dbTable DbTable) (elements []interface{}, next string, err error) {
+	var last string
+
+	err = db.DbIterate(table, prefix, after, dbTable, func(key string, value interface{}) (bool, error) {
+		if limit > 0 && len(elements) == limit {
+			next = last
+			return false, nil
+		}
+
+		elements = append(elements, value)
+		last = key
+		return true, nil
+	})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return elements, next, nil
+}
+
+// DbBatch adds and deletes elements of a table in a single transaction,
+// either all the writes are applied or none is. Deleting a key which is
+// not in the table is not an error.
+func (db *BoltDB) DbBatch(table string, writes []DbWrite) error {
+	return db.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(table))
+		if err != nil {
+			return fmt.Errorf("Bucket %v creation failed", table)
+		}
+
+		for _, w := range writes {
+			if w.Value == nil {
+				if err := bucket.Delete([]byte(w.Key)); err != nil {
+					return fmt.Errorf("Key Delete error: %v %v ", w.Key, err)
+				}
+				continue
+			}
+
+			var v bytes.Buffer
+			if err := gob.NewEncoder(&v).Encode(w.Value); err != nil {
+				Logger.Errorf("Encode Error: %v %v", err, w.Value)
+				return err
+			}
+
+			if err := bucket.Put([]byte(w.Key), v.Bytes()); err != nil {
+				return fmt.Errorf("Key Store error: %v %v %v %v", table, w.Key, w.Value, err)
+			}
+		}
+
+		return nil
+	})
+}
@@ -28,6 +28,16 @@ type DbTable interface {
 	Add(k string, v interface{}) error
 }
 
+// DbIterator is called with the key and value of each element visited by a
+// table iteration. The iteration stops when it returns false or an error.
+type DbIterator func(key string, value interface{}) (bool, error)
+
+// DbWrite is a single write of a batch, deleting the key when Value is nil
+type DbWrite struct {
+	Key   string
+	Value interface{}
+}
+
 // DbProvider represents a persistent database provider
 type DbProvider interface {
 	// Initializes the Database
@@ -46,4 +56,18 @@ type DbProvider interface {
 	DbGet(table string, key string, dbTable DbTable) (interface{}, error)
 	//Retrieves all values from a table
 	DbGetAll(table string, dbTable DbTable) ([]interface{}, error)
+	// Iterates in key order over the elements of the table whose key
+	// starts with prefix, starting after the key after if not empty,
+	// without loading the table in memory. The iterator must not write
+	// to the database.
+	DbIterate(table string, prefix string, after string, dbTable DbTable, fn DbIterator) error
+	// Retrieves the values of the table whose key starts with prefix
+	DbGetPrefix(table string, prefix string, dbTable DbTable) ([]interface{}, error)
+	// Retrieves at most limit values, in key order, of the table whose
+	// key starts with prefix, starting after the key after if not empty,
+	// along with the key to pass as after to get the next page, empty on
+	// the last page
+	DbGetPage(table string, prefix string, after string, limit int, dbTable DbTable) ([]interface{}, string, error)
+	// Adds and deletes key/value pairs of the table in a single transaction
+	DbBatch(table string, writes []DbWrite) error
 }
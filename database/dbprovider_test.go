@@ -218,6 +218,140 @@ func testDbGetAll(t *testing.T, provider Provider) {
 	}
 }
 
+func testDbBatch(t *testing.T, provider Provider) {
+	defer closeDb(&provider)
+
+	err := provider.Db.DbInit(provider.DbDir, provider.DbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = provider.Db.DbTablesInit(provider.DbTables)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writes := []DbWrite{
+		{Key: "image-1", Value: TestData{ID: "image-1"}},
+		{Key: "image-2", Value: TestData{ID: "image-2"}},
+		{Key: "image-3", Value: TestData{ID: "image-3"}},
+		{Key: "volume-1", Value: TestData{ID: "volume-1"}},
+	}
+
+	err = provider.Db.DbBatch(provider.DbTables[0], writes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = provider.Db.DbBatch(provider.DbTables[0], []DbWrite{{Key: "image-2"}, {Key: "missing"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	elements, err := provider.Db.DbGetAll(provider.DbTables[0], &TestMap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(elements) != 3 {
+		t.Fatalf("Expected 3 elements, got %d", len(elements))
+	}
+}
+
+func testDbGetPrefix(t *testing.T, provider Provider) {
+	defer closeDb(&provider)
+
+	err := provider.Db.DbInit(provider.DbDir, provider.DbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = provider.Db.DbTablesInit(provider.DbTables)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"image-1", "image-2", "volume-1"} {
+		err = provider.Db.DbAdd(provider.DbTables[0], key, TestData{ID: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	elements, err := provider.Db.DbGetPrefix(provider.DbTables[0], "image-", &TestMap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(elements) != 2 || elements[0].(*TestData).ID != "image-1" || elements[1].(*TestData).ID != "image-2" {
+		t.Fatalf("Unexpected elements %v", elements)
+	}
+
+	var keys []string
+	err = provider.Db.DbIterate(provider.DbTables[0], "", "image-1", &TestMap{}, func(key string, value interface{}) (bool, error) {
+		keys = append(keys, key)
+		return len(keys) < 1, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 1 || keys[0] != "image-2" {
+		t.Fatalf("Unexpected iteration %v", keys)
+	}
+}
+
+func testDbGetPage(t *testing.T, provider Provider) {
+	defer closeDb(&provider)
+
+	err := provider.Db.DbInit(provider.DbDir, provider.DbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = provider.Db.DbTablesInit(provider.DbTables)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("image-%d", i)
+		err = provider.Db.DbAdd(provider.DbTables[0], key, TestData{ID: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = provider.Db.DbAdd(provider.DbTables[0], "volume-1", TestData{ID: "volume-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	next := ""
+	pages := 0
+	for {
+		elements, after, err := provider.Db.DbGetPage(provider.DbTables[0], "image-", next, 2, &TestMap{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, e := range elements {
+			ids = append(ids, e.(*TestData).ID)
+		}
+
+		pages++
+		if after == "" {
+			break
+		}
+		next = after
+	}
+
+	if pages != 3 || len(ids) != 5 || ids[0] != "image-0" || ids[4] != "image-4" {
+		t.Fatalf("Unexpected pages %d of %v", pages, ids)
+	}
+}
+
 // Test for BoltDb Provider
 
 func TestBoltDbInit(t *testing.T) {
@@ -261,3 +395,21 @@ func TestBoltDbGetAll(t *testing.T) {
 	testDbGetAll(t, provider)
 	_ = os.Remove(path.Join(dbDir, dbFile))
 }
+
+func TestBoltDbBatch(t *testing.T) {
+	provider := initProvider(NewBoltDBProvider())
+	testDbBatch(t, provider)
+	_ = os.Remove(path.Join(dbDir, dbFile))
+}
+
+func TestBoltDbGetPrefix(t *testing.T) {
+	provider := initProvider(NewBoltDBProvider())
+	testDbGetPrefix(t, provider)
+	_ = os.Remove(path.Join(dbDir, dbFile))
+}
+
+func TestBoltDbGetPage(t *testing.T) {
+	provider := initProvider(NewBoltDBProvider())
+	testDbGetPage(t, provider)
+	_ = os.Remove(path.Join(dbDir, dbFile))
+}
@@ -399,6 +399,8 @@ func connectToServer(db *cnciDatabase, doneCh chan struct{}, statusCh chan struc
 		dialCh <- err
 	}()
 
+	go instanceProber(&client.ssntpConn, doneCh)
+
 	dialing := true
 
 DONE:
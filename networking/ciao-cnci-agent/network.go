@@ -272,6 +272,19 @@ func publicIPUnassignedMarshal(cmd *payloads.PublicIPCommand) ([]byte, error) {
 	return yaml.Marshal(&publicIPUnassigned)
 }
 
+func instanceReachabilityMarshal(agentUUID string, ips []payloads.IPReachability) ([]byte, error) {
+	var instanceReachability payloads.EventInstanceReachability
+	evt := &instanceReachability.InstanceReachability
+
+	evt.ConcentratorUUID = agentUUID
+	evt.TenantUUID = gCnci.Tenant
+	evt.IPs = ips
+
+	glog.Infoln("instanceReachabilityMarshal Event ", instanceReachability)
+
+	return yaml.Marshal(&instanceReachability)
+}
+
 func publicIPFailureMarshal(reason payloads.PublicIPFailureReason, cmd *payloads.PublicIPCommand) ([]byte, error) {
 	var failure payloads.ErrorPublicIPFailure
 
@@ -366,6 +379,13 @@ func generateNetEventPayload(eventType ssntp.Event, eventInfo interface{}, agent
 			return nil, errors.Errorf("invalid eventInfo [%T] %v", eventInfo, eventInfo)
 		}
 		return publicIPUnassignedMarshal(cmd)
+	case ssntp.InstanceReachability:
+		glog.Infof("generating instance Reachability Event Payload %v", eventInfo)
+		ips, ok := eventInfo.([]payloads.IPReachability)
+		if !ok {
+			return nil, errors.Errorf("invalid eventInfo [%T] %v", eventInfo, eventInfo)
+		}
+		return instanceReachabilityMarshal(agentUUID, ips)
 	default:
 		return nil, errors.Errorf("unsupported ssntpEventInfo type: %v", eventType)
 	}
@@ -0,0 +1,103 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"flag"
+	"net"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/ssntp"
+)
+
+var probeInterval time.Duration
+
+func init() {
+	flag.DurationVar(&probeInterval, "probe-interval", time.Minute, "Interval between reachability probes of the instances, 0 disables them")
+}
+
+// pingIP returns true if ip answers a single ICMP echo request within a second
+func pingIP(ip net.IP) bool {
+	return exec.Command("ping", "-c", "1", "-W", "1", ip.String()).Run() == nil
+}
+
+// probeIPs pings all the IPs concurrently and returns the result for each of them
+func probeIPs(ips []net.IP) []payloads.IPReachability {
+	results := make([]payloads.IPReachability, len(ips))
+
+	var wg sync.WaitGroup
+	for i, ip := range ips {
+		wg.Add(1)
+		go func(i int, ip net.IP) {
+			defer wg.Done()
+			results[i] = payloads.IPReachability{
+				IP:        ip.String(),
+				Reachable: pingIP(ip),
+			}
+		}(i, ip)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// Probe the instances holding a DHCP lease from the CNCI and report
+// which of them are reachable
+func probeInstances(client *ssntpConn) error {
+	if !enableNetwork {
+		return nil
+	}
+
+	ips, err := gCnci.LeasedIPs()
+	if err != nil {
+		return errors.Wrapf(err, "probe instances")
+	}
+
+	results := probeIPs(ips)
+	return sendNetworkEvent(client, ssntp.InstanceReachability, results)
+}
+
+// Periodically probe the instances until doneCh is closed
+func instanceProber(client *ssntpConn, doneCh chan struct{}) {
+	if probeInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(probeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-doneCh:
+			return
+		case <-ticker.C:
+			if !client.isConnected() {
+				continue
+			}
+
+			if err := probeInstances(client); err != nil {
+				glog.Errorf("Unable to probe instances: %+v", err)
+			}
+		}
+	}
+}
@@ -1107,6 +1107,36 @@ func (cn *ComputeNode) DestroyVnic(cfg *VnicConfig) (*SsntpEventInfo, *Container
 	return s, cInfo, err
 }
 
+// VnicLinkName returns the name of the host side link of the VNIC described
+// by cfg, e.g., the tap interface of a VM or the host end of the veth pair
+// of a container.  It can be used to monitor the traffic of an instance.
+func (cn *ComputeNode) VnicLinkName(cfg *VnicConfig) (string, error) {
+	if cfg == nil || cn.cnTopology == nil {
+		return "", NewAPIError("invalid vnic or configuration")
+	}
+
+	if err := checkCnVnicCfg(cfg); err != nil {
+		return "", NewAPIError(err.Error())
+	}
+
+	alias := genCnVnicAliases(cfg)
+
+	cn.cnTopology.Lock()
+	vLink, present := cn.linkMap[alias.vnic]
+	cn.cnTopology.Unlock()
+
+	if !present {
+		return "", NewAPIError("vnic does not exist " + cfg.VnicID)
+	}
+
+	name, _, err := waitForDeviceReady(vLink, cn.APITimeout)
+	if err != nil {
+		return "", NewAPIError(err.Error())
+	}
+
+	return name, nil
+}
+
 //Note: Can only be called when holding the topology lock cn.cnTopology.Lock()
 func (cn *ComputeNode) deleteVnicInternal(vnic *Vnic, vLink *linkInfo) (err error) {
 	vnic.LinkName, vnic.Link.Attrs().Index, err = waitForDeviceReady(vLink, cn.APITimeout)
@@ -793,6 +793,29 @@ func (cnci *Cnci) DelRemoteSubnet(subnet net.IPNet, subnetKey int, cnIP net.IP)
 	return err
 }
 
+// LeasedIPs returns the IPs the DHCP servers of the CNCI currently lease to
+// instances on the subnets it serves
+func (cnci *Cnci) LeasedIPs() ([]net.IP, error) {
+	var ips []net.IP
+
+	cnci.topology.Lock()
+	defer cnci.topology.Unlock()
+
+	for _, b := range cnci.topology.bridgeMap {
+		if b.Dnsmasq == nil {
+			continue
+		}
+
+		leased, err := b.Dnsmasq.leases()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read leases of %s %v", b.Dnsmasq.SubnetID, err)
+		}
+		ips = append(ips, leased...)
+	}
+
+	return ips, nil
+}
+
 //Shutdown stops all DHCP Servers. Tears down all links and tunnels
 //It will continue even on encountering an error and perform as much
 //cleanup as possible
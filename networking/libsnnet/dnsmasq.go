@@ -17,9 +17,11 @@
 package libsnnet
 
 import (
+	"bufio"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
@@ -27,6 +29,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 //Various configuration options
@@ -220,6 +223,53 @@ func (d *Dnsmasq) reload() error {
 	return nil
 }
 
+// parseLeases returns the IPs of the leases of a dnsmasq lease file which
+// have not expired at time now.  Each lease is a line made of the expiry
+// time of the lease, in seconds since the epoch or 0 if the lease never
+// expires, followed by the MAC and the IP the lease binds.
+func parseLeases(r io.Reader, now time.Time) ([]net.IP, error) {
+	var ips []net.IP
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lease expiry %s", fields[0])
+		}
+
+		if expiry != 0 && !now.Before(time.Unix(expiry, 0)) {
+			continue
+		}
+
+		ip := net.ParseIP(fields[2])
+		if ip == nil {
+			return nil, fmt.Errorf("invalid lease IP %s", fields[2])
+		}
+		ips = append(ips, ip)
+	}
+
+	return ips, scanner.Err()
+}
+
+// leases returns the IPs currently leased by the dnsmasq service
+func (d *Dnsmasq) leases() ([]net.IP, error) {
+	file, err := os.Open(d.leaseFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	return parseLeases(file, time.Now())
+}
+
 // AddDhcpEntry adds/updates a DHCP mapping. Typically invoked when a new
 // instance is added to the subnet served by this dnsmasq service.
 // Reload() has to be invoked to activate this entry is the service is already
@@ -20,7 +20,9 @@ import (
 	"io/ioutil"
 	"net"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -150,3 +152,27 @@ func TestDnsmasq_Negative(t *testing.T) {
 		assert.Nil(d.stop())
 	}
 }
+
+//Test parsing of the dnsmasq lease file
+//
+//This test checks that only the IPs of the leases which have
+//not expired are returned and that malformed leases are rejected
+//
+//Test is expected to pass
+func TestDnsmasq_Leases(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1500000000, 0)
+	leases := "1500000060 02:00:c0:a8:00:02 192.168.0.2 * *\n" +
+		"1499999940 02:00:c0:a8:00:03 192.168.0.3 * *\n" +
+		"0 02:00:c0:a8:00:04 192.168.0.4 host *\n"
+
+	ips, err := parseLeases(strings.NewReader(leases), now)
+	if assert.Nil(err) && assert.Equal(2, len(ips)) {
+		assert.True(ips[0].Equal(net.ParseIP("192.168.0.2")))
+		assert.True(ips[1].Equal(net.ParseIP("192.168.0.4")))
+	}
+
+	_, err = parseLeases(strings.NewReader("never 02:00:c0:a8:00:02 192.168.0.2 * *\n"), now)
+	assert.NotNil(err)
+}
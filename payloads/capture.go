@@ -0,0 +1,90 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+const (
+	// MaxPacketCaptureBytes is the maximum size of the pcap file of a
+	// packet capture.
+	MaxPacketCaptureBytes = 32 * 1024 * 1024
+
+	// MaxPacketCaptureSeconds is the maximum duration of a packet
+	// capture.
+	MaxPacketCaptureSeconds = 300
+)
+
+// PacketCaptureCmd contains the information needed to capture the network
+// traffic of an instance.
+type PacketCaptureCmd struct {
+	// InstanceUUID is the UUID of the instance whose traffic is captured
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// WorkloadAgentUUID identifies the node on which the instance is
+	// running.  This information is needed by the scheduler to route
+	// the command to the correct CN.
+	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+
+	// CaptureUUID identifies the capture.  It is copied into the
+	// PacketCaptureResult event carrying the captured packets.
+	CaptureUUID string `yaml:"capture_uuid"`
+
+	// MaxBytes is the size of the pcap file after which the capture
+	// stops.
+	MaxBytes int `yaml:"max_bytes"`
+
+	// MaxSeconds is the number of seconds after which the capture stops.
+	MaxSeconds int `yaml:"max_seconds"`
+
+	// Filter is an optional pcap filter expression, e.g., "icmp or arp".
+	Filter string `yaml:"filter,omitempty"`
+}
+
+// PacketCapture represents the unmarshalled version of the contents of a
+// SSNTP CAPTURE payload.
+type PacketCapture struct {
+	// PacketCapture contains information about the requested capture.
+	PacketCapture PacketCaptureCmd `yaml:"packet_capture"`
+}
+
+// PacketCaptureResultEvent contains the packets captured on the network
+// interface of an instance.
+type PacketCaptureResultEvent struct {
+	// InstanceUUID is the UUID of the instance.
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// CaptureUUID identifies the capture the packets are for.
+	CaptureUUID string `yaml:"capture_uuid"`
+
+	// Error describes why the capture failed.  It is empty if the
+	// capture succeeded.
+	Error string `yaml:"error,omitempty"`
+
+	// Truncated is set when the capture was stopped because it
+	// reached its maximum size.
+	Truncated bool `yaml:"truncated,omitempty"`
+
+	// Pcap is the base64 encoded pcap file of the captured packets.
+	Pcap string `yaml:"pcap,omitempty"`
+}
+
+// EventPacketCaptureResult represents the unmarshalled version of the
+// contents of an SSNTP ssntp.PacketCaptureResult event.  This event is sent
+// by ciao-launcher when a capture requested by a CAPTURE command completes
+// or fails.  Its payload is usually streamed as it exceeds the maximum
+// frame size of large captures.
+type EventPacketCaptureResult struct {
+	PacketCaptureResult PacketCaptureResultEvent `yaml:"packet_capture_result"`
+}
@@ -0,0 +1,111 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+const testPcapHeader = "1MOyoQIABAAAAAAAAAAAAP//AAABAAAA"
+
+func TestPacketCaptureUnmarshal(t *testing.T) {
+	var capture PacketCapture
+	err := yaml.Unmarshal([]byte(testutil.PacketCaptureYaml), &capture)
+	if err != nil {
+		t.Error(err)
+	}
+
+	cmd := capture.PacketCapture
+	if cmd.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", cmd.InstanceUUID)
+	}
+
+	if cmd.WorkloadAgentUUID != testutil.AgentUUID {
+		t.Errorf("Wrong Agent UUID field [%s]", cmd.WorkloadAgentUUID)
+	}
+
+	if cmd.CaptureUUID != testutil.CaptureUUID {
+		t.Errorf("Wrong capture UUID field [%s]", cmd.CaptureUUID)
+	}
+
+	if cmd.MaxBytes != 1048576 || cmd.MaxSeconds != 60 || cmd.Filter != "icmp or arp" {
+		t.Errorf("Wrong packet capture limits [%d] [%d] [%s]", cmd.MaxBytes, cmd.MaxSeconds, cmd.Filter)
+	}
+}
+
+func TestPacketCaptureMarshal(t *testing.T) {
+	var capture PacketCapture
+
+	capture.PacketCapture.InstanceUUID = testutil.InstanceUUID
+	capture.PacketCapture.WorkloadAgentUUID = testutil.AgentUUID
+	capture.PacketCapture.CaptureUUID = testutil.CaptureUUID
+	capture.PacketCapture.MaxBytes = 1048576
+	capture.PacketCapture.MaxSeconds = 60
+	capture.PacketCapture.Filter = "icmp or arp"
+
+	y, err := yaml.Marshal(&capture)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.PacketCaptureYaml {
+		t.Errorf("CAPTURE marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.PacketCaptureYaml)
+	}
+}
+
+func TestPacketCaptureResultUnmarshal(t *testing.T) {
+	var result EventPacketCaptureResult
+	err := yaml.Unmarshal([]byte(testutil.PacketCaptureResultYaml), &result)
+	if err != nil {
+		t.Error(err)
+	}
+
+	event := result.PacketCaptureResult
+	if event.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", event.InstanceUUID)
+	}
+
+	if event.CaptureUUID != testutil.CaptureUUID {
+		t.Errorf("Wrong capture UUID field [%s]", event.CaptureUUID)
+	}
+
+	if event.Error != "" || !event.Truncated || event.Pcap != testPcapHeader {
+		t.Errorf("Wrong packet capture result fields %+v", event)
+	}
+}
+
+func TestPacketCaptureResultMarshal(t *testing.T) {
+	var result EventPacketCaptureResult
+
+	result.PacketCaptureResult.InstanceUUID = testutil.InstanceUUID
+	result.PacketCaptureResult.CaptureUUID = testutil.CaptureUUID
+	result.PacketCaptureResult.Truncated = true
+	result.PacketCaptureResult.Pcap = testPcapHeader
+
+	y, err := yaml.Marshal(&result)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.PacketCaptureResultYaml {
+		t.Errorf("PacketCaptureResult marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.PacketCaptureResultYaml)
+	}
+}
@@ -34,10 +34,38 @@ func (s StorageType) String() string {
 	return ""
 }
 
+// ForwardRule makes the scheduler forward the SSNTP frames of a given type
+// and operand to all the SSNTP clients playing the destination roles.
+type ForwardRule struct {
+	// Type is the SSNTP frame type: command, status, event or error.
+	Type string `yaml:"type"`
+
+	// Operand is the SSNTP frame operand value.
+	Operand uint8 `yaml:"operand"`
+
+	// Dest is a comma separated list of SSNTP roles, e.g. controller,agent.
+	Dest string `yaml:"dest"`
+}
+
 // ConfigureScheduler contains the unmarshalled configurations for the
 // scheduler service.
 type ConfigureScheduler struct {
-	ConfigStorageURI string `yaml:"storage_uri"`
+	ConfigStorageURI string        `yaml:"storage_uri"`
+	ForwardRules     []ForwardRule `yaml:"forward_rules,omitempty"`
+
+	// URIs lists the schedulers of the cluster, primary first. SSNTP
+	// clients try them in order when connecting.
+	URIs []string `yaml:"uris,omitempty"`
+
+	// LeasePath is the path, on storage shared by the schedulers, of
+	// the lease held by the active scheduler. The generations of the
+	// lease are stored in files named after it, suffixed with their
+	// number. Schedulers stay passive until they get the lease.
+	LeasePath string `yaml:"lease_path,omitempty"`
+
+	// LeaseTimeout is the number of seconds after which a lease that
+	// is not renewed expires.
+	LeaseTimeout int `yaml:"lease_timeout,omitempty"`
 }
 
 // ConfigureController contains the unmarshalled configurations for the
@@ -52,6 +80,51 @@ type ConfigureController struct {
 	AdminSSHKey          string `yaml:"admin_ssh_key"`
 	ClientAuthCACertPath string `yaml:"client_auth_ca_cert_path"`
 	CNCINet              string `yaml:"cnci_net"`
+
+	// HTTP server timeouts of the ciao API, in seconds. 0 means no
+	// timeout. Event streams are closed by the write timeout.
+	HTTPReadTimeout  int `yaml:"http_read_timeout,omitempty"`
+	HTTPWriteTimeout int `yaml:"http_write_timeout,omitempty"`
+	HTTPIdleTimeout  int `yaml:"http_idle_timeout,omitempty"`
+
+	// TLSMinVersion is the minimum TLS version accepted by the ciao
+	// API, e.g., 1.2.
+	TLSMinVersion string `yaml:"tls_min_version,omitempty"`
+
+	// TLSCipherSuites restricts the cipher suites of TLS 1.2 and
+	// earlier to those named, e.g., TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.
+	TLSCipherSuites []string `yaml:"tls_cipher_suites,omitempty"`
+
+	// HSTSMaxAge makes the ciao API send a Strict-Transport-Security
+	// header with this max-age, in seconds, when not 0.
+	HSTSMaxAge int `yaml:"hsts_max_age,omitempty"`
+
+	// DisableImages runs the controller without its image service,
+	// for deployments only using pre-provisioned Ceph images.
+	DisableImages bool `yaml:"disable_images,omitempty"`
+
+	// EventSinks mirror the cluster events to external event
+	// collectors, in addition to the datastore.
+	EventSinks []EventSink `yaml:"event_sinks,omitempty"`
+}
+
+// EventSink describes an external collector the cluster events are
+// mirrored to.
+type EventSink struct {
+	// Type is the type of the collector: syslog, journald or kafka.
+	Type string `yaml:"type"`
+
+	// Address is the address of the collector.  For syslog, a
+	// network address such as udp://loghost:514, the local syslog
+	// daemon when empty.  For kafka, the URL of a Kafka REST proxy.
+	Address string `yaml:"address,omitempty"`
+
+	// Tag identifies the events of ciao in syslog and journald,
+	// ciao-controller by default.
+	Tag string `yaml:"tag,omitempty"`
+
+	// Topic is the Kafka topic the events are produced to.
+	Topic string `yaml:"topic,omitempty"`
 }
 
 // ConfigureLauncher contains the unmarshalled configurations for the
@@ -62,12 +135,21 @@ type ConfigureLauncher struct {
 	DiskLimit         bool     `yaml:"disk_limit"`
 	MemoryLimit       bool     `yaml:"mem_limit"`
 	ChildUser         string   `yaml:"child_user"`
+	InstancesDir      string   `yaml:"instances_dir,omitempty"`
+}
+
+// StorageClass maps a named class of volumes, e.g., ssd, to the Ceph pool
+// holding them.
+type StorageClass struct {
+	Name string `yaml:"name"`
+	Pool string `yaml:"pool"`
 }
 
 // ConfigureStorage contains the unmarshalled configurations for the
 // Ceph storage driver.
 type ConfigureStorage struct {
-	CephID string `yaml:"ceph_id"`
+	CephID  string         `yaml:"ceph_id"`
+	Classes []StorageClass `yaml:"classes,omitempty"`
 }
 
 // ConfigurePayload is a wrapper to read and unmarshall all posible
@@ -47,6 +47,113 @@ func TestConfigureUnmarshal(t *testing.T) {
 	}
 }
 
+func TestConfigureForwardRulesUnmarshal(t *testing.T) {
+	var cfg Configure
+
+	y := `configure:
+  scheduler:
+    storage_uri: /etc/ciao/configuration.yaml
+    forward_rules:
+    - type: event
+      operand: 32
+      dest: controller,cnciagent
+`
+	err := yaml.Unmarshal([]byte(y), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ForwardRule{{Type: "event", Operand: 32, Dest: "controller,cnciagent"}}
+	rules := cfg.Configure.Scheduler.ForwardRules
+	if len(rules) != 1 || rules[0] != expected[0] {
+		t.Errorf("Wrong forward rules %v, expected %v", rules, expected)
+	}
+}
+
+func TestConfigureSchedulerFailoverUnmarshal(t *testing.T) {
+	var cfg Configure
+
+	y := `configure:
+  scheduler:
+    storage_uri: /etc/ciao/configuration.yaml
+    uris:
+    - scheduler1
+    - scheduler2:8888
+    lease_path: /var/lib/ciao/shared/scheduler.lease
+    lease_timeout: 15
+`
+	err := yaml.Unmarshal([]byte(y), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := cfg.Configure.Scheduler
+	if len(s.URIs) != 2 || s.URIs[0] != "scheduler1" || s.URIs[1] != "scheduler2:8888" {
+		t.Errorf("Wrong scheduler URIs %v", s.URIs)
+	}
+
+	if s.LeasePath != "/var/lib/ciao/shared/scheduler.lease" {
+		t.Errorf("Wrong lease path %s", s.LeasePath)
+	}
+
+	if s.LeaseTimeout != 15 {
+		t.Errorf("Wrong lease timeout %d", s.LeaseTimeout)
+	}
+}
+
+func TestConfigureLauncherInstancesDirUnmarshal(t *testing.T) {
+	var cfg Configure
+
+	y := `configure:
+  launcher:
+    instances_dir: /data/ciao/instances
+`
+	err := yaml.Unmarshal([]byte(y), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Configure.Launcher.InstancesDir != "/data/ciao/instances" {
+		t.Errorf("Wrong instances directory %s", cfg.Configure.Launcher.InstancesDir)
+	}
+}
+
+func TestConfigureHTTPHardeningUnmarshal(t *testing.T) {
+	var cfg Configure
+
+	y := `configure:
+  controller:
+    http_read_timeout: 10
+    http_write_timeout: 20
+    http_idle_timeout: 30
+    tls_min_version: "1.2"
+    tls_cipher_suites:
+    - TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+    hsts_max_age: 31536000
+`
+	err := yaml.Unmarshal([]byte(y), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := cfg.Configure.Controller
+	if c.HTTPReadTimeout != 10 || c.HTTPWriteTimeout != 20 || c.HTTPIdleTimeout != 30 {
+		t.Errorf("Wrong HTTP timeouts %d %d %d", c.HTTPReadTimeout, c.HTTPWriteTimeout, c.HTTPIdleTimeout)
+	}
+
+	if c.TLSMinVersion != "1.2" {
+		t.Errorf("Wrong TLS minimum version %s", c.TLSMinVersion)
+	}
+
+	if len(c.TLSCipherSuites) != 1 || c.TLSCipherSuites[0] != "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256" {
+		t.Errorf("Wrong TLS cipher suites %v", c.TLSCipherSuites)
+	}
+
+	if c.HSTSMaxAge != 31536000 {
+		t.Errorf("Wrong HSTS max-age %d", c.HSTSMaxAge)
+	}
+}
+
 func TestConfigureMarshal(t *testing.T) {
 	var cfg Configure
 
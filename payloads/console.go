@@ -0,0 +1,85 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// ConsoleAction is the action requested by a CONSOLE command.
+type ConsoleAction string
+
+const (
+	// ConsoleOpen opens a serial console session with an instance.
+	ConsoleOpen ConsoleAction = "open"
+
+	// ConsoleInput writes input to the serial console of an instance.
+	ConsoleInput ConsoleAction = "input"
+
+	// ConsoleClose closes a serial console session.
+	ConsoleClose ConsoleAction = "close"
+)
+
+// ConsoleCmd contains the information needed to open, write to or close an
+// emergency serial console session with an instance.
+type ConsoleCmd struct {
+	// InstanceUUID is the UUID of the instance whose console is used
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// WorkloadAgentUUID identifies the node on which the instance is
+	// running.  This information is needed by the scheduler to route
+	// the command to the correct CN.
+	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+
+	// SessionUUID identifies the console session.
+	SessionUUID string `yaml:"session_uuid"`
+
+	// Action is the requested action.
+	Action ConsoleAction `yaml:"action"`
+
+	// Input is written to the serial console of the instance.  Only
+	// used with ConsoleInput.
+	Input string `yaml:"input,omitempty"`
+}
+
+// Console represents the unmarshalled version of the contents of a SSNTP
+// CONSOLE payload.
+type Console struct {
+	// Console contains information about the console session.
+	Console ConsoleCmd `yaml:"console"`
+}
+
+// ConsoleOutputEvent contains output read from the serial console of an
+// instance during a console session.
+type ConsoleOutputEvent struct {
+	// InstanceUUID is the UUID of the instance.
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// SessionUUID identifies the console session.
+	SessionUUID string `yaml:"session_uuid"`
+
+	// Output is the output read from the serial console.
+	Output string `yaml:"output,omitempty"`
+
+	// Closed is set when the session has been closed by the agent, in
+	// which case no more output will be sent for it.
+	Closed bool `yaml:"closed,omitempty"`
+}
+
+// EventConsoleOutput represents the unmarshalled version of the contents of
+// an SSNTP ssntp.ConsoleOutput event.  This event is sent by ciao-launcher
+// when it reads output from the serial console of an instance or closes a
+// console session.
+type EventConsoleOutput struct {
+	ConsoleOutput ConsoleOutputEvent `yaml:"console_output"`
+}
@@ -0,0 +1,129 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestConsoleUnmarshal(t *testing.T) {
+	var console Console
+	err := yaml.Unmarshal([]byte(testutil.ConsoleYaml), &console)
+	if err != nil {
+		t.Error(err)
+	}
+
+	cmd := console.Console
+	if cmd.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", cmd.InstanceUUID)
+	}
+
+	if cmd.WorkloadAgentUUID != testutil.AgentUUID {
+		t.Errorf("Wrong Agent UUID field [%s]", cmd.WorkloadAgentUUID)
+	}
+
+	if cmd.SessionUUID != testutil.ConsoleSessionUUID {
+		t.Errorf("Wrong session UUID field [%s]", cmd.SessionUUID)
+	}
+
+	if cmd.Action != ConsoleInput || cmd.Input != "root\n" {
+		t.Errorf("Wrong console input fields [%s] [%q]", cmd.Action, cmd.Input)
+	}
+}
+
+func TestConsoleMarshal(t *testing.T) {
+	var console Console
+
+	console.Console.InstanceUUID = testutil.InstanceUUID
+	console.Console.WorkloadAgentUUID = testutil.AgentUUID
+	console.Console.SessionUUID = testutil.ConsoleSessionUUID
+	console.Console.Action = ConsoleInput
+	console.Console.Input = "root\n"
+
+	y, err := yaml.Marshal(&console)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.ConsoleYaml {
+		t.Errorf("CONSOLE marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.ConsoleYaml)
+	}
+}
+
+func TestConsoleOutputUnmarshal(t *testing.T) {
+	var output EventConsoleOutput
+	err := yaml.Unmarshal([]byte(testutil.ConsoleOutputYaml), &output)
+	if err != nil {
+		t.Error(err)
+	}
+
+	event := output.ConsoleOutput
+	if event.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", event.InstanceUUID)
+	}
+
+	if event.SessionUUID != testutil.ConsoleSessionUUID {
+		t.Errorf("Wrong session UUID field [%s]", event.SessionUUID)
+	}
+
+	if event.Output != "login: " || event.Closed {
+		t.Errorf("Wrong console output fields [%q] [%t]", event.Output, event.Closed)
+	}
+}
+
+func TestConsoleOutputMarshal(t *testing.T) {
+	var output EventConsoleOutput
+
+	output.ConsoleOutput.InstanceUUID = testutil.InstanceUUID
+	output.ConsoleOutput.SessionUUID = testutil.ConsoleSessionUUID
+	output.ConsoleOutput.Output = "login: "
+
+	y, err := yaml.Marshal(&output)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.ConsoleOutputYaml {
+		t.Errorf("ConsoleOutput marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.ConsoleOutputYaml)
+	}
+}
+
+// Serial consoles can output bytes that are not valid UTF-8.
+func TestConsoleOutputBinary(t *testing.T) {
+	var output EventConsoleOutput
+
+	output.ConsoleOutput.Output = "\xff\x1b[2J"
+
+	y, err := yaml.Marshal(&output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded EventConsoleOutput
+	err = yaml.Unmarshal(y, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.ConsoleOutput.Output != output.ConsoleOutput.Output {
+		t.Errorf("Wrong console output [%q]", decoded.ConsoleOutput.Output)
+	}
+}
@@ -0,0 +1,43 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// DiskUsageAlertEvent contains the disk usage of an instance that has
+// crossed a threshold of the disk size requested by its workload.
+type DiskUsageAlertEvent struct {
+	// InstanceUUID is the UUID of the instance.
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// NodeUUID is the UUID of the node running the instance.
+	NodeUUID string `yaml:"node_uuid"`
+
+	// UsageMB is the ephemeral disk space used by the instance in MiB.
+	UsageMB int `yaml:"usage_mb"`
+
+	// LimitMB is the disk size requested by the workload in MiB.
+	LimitMB int `yaml:"limit_mb"`
+
+	// Threshold is the crossed threshold, as a percentage of LimitMB.
+	Threshold int `yaml:"threshold"`
+}
+
+// EventDiskUsageAlert represents the unmarshalled version of the contents of
+// an SSNTP ssntp.DiskUsageAlert event.  This event is sent by ciao-launcher
+// when the disk usage of an instance crosses a threshold.
+type EventDiskUsageAlert struct {
+	DiskUsageAlert DiskUsageAlertEvent `yaml:"disk_usage_alert"`
+}
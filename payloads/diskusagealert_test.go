@@ -0,0 +1,65 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestDiskUsageAlertUnmarshal(t *testing.T) {
+	var alert EventDiskUsageAlert
+	err := yaml.Unmarshal([]byte(testutil.DiskUsageAlertYaml), &alert)
+	if err != nil {
+		t.Error(err)
+	}
+
+	event := alert.DiskUsageAlert
+	if event.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", event.InstanceUUID)
+	}
+
+	if event.NodeUUID != testutil.AgentUUID {
+		t.Errorf("Wrong node UUID field [%s]", event.NodeUUID)
+	}
+
+	if event.UsageMB != 850 || event.LimitMB != 1000 || event.Threshold != 80 {
+		t.Errorf("Wrong disk usage fields %+v", event)
+	}
+}
+
+func TestDiskUsageAlertMarshal(t *testing.T) {
+	var alert EventDiskUsageAlert
+
+	alert.DiskUsageAlert.InstanceUUID = testutil.InstanceUUID
+	alert.DiskUsageAlert.NodeUUID = testutil.AgentUUID
+	alert.DiskUsageAlert.UsageMB = 850
+	alert.DiskUsageAlert.LimitMB = 1000
+	alert.DiskUsageAlert.Threshold = 80
+
+	y, err := yaml.Marshal(&alert)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.DiskUsageAlertYaml {
+		t.Errorf("DiskUsageAlert marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.DiskUsageAlertYaml)
+	}
+}
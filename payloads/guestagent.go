@@ -0,0 +1,120 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// GuestAgentOperation is the operation requested by a GUESTAGENT command.
+type GuestAgentOperation string
+
+const (
+	// GuestExec runs a command in the guest of an instance.
+	GuestExec GuestAgentOperation = "exec"
+
+	// GuestFreeze freezes the filesystems of the guest of an instance,
+	// e.g., before taking a consistent snapshot of its volumes.
+	GuestFreeze GuestAgentOperation = "fsfreeze"
+
+	// GuestThaw thaws the filesystems frozen by GuestFreeze.
+	GuestThaw GuestAgentOperation = "fsthaw"
+
+	// GuestNetworkInfo retrieves the network interfaces of the guest of
+	// an instance.
+	GuestNetworkInfo GuestAgentOperation = "network-info"
+)
+
+// GuestAgentCmd contains the information needed to run an operation
+// through the QEMU guest agent of an instance.
+type GuestAgentCmd struct {
+	// InstanceUUID is the UUID of the instance the operation is run on
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// WorkloadAgentUUID identifies the node on which the instance is
+	// running.  This information is needed by the scheduler to route
+	// the command to the correct CN.
+	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+
+	// RequestUUID identifies the request.  It is copied into the
+	// GuestAgentResult event reporting the result of the operation.
+	RequestUUID string `yaml:"request_uuid"`
+
+	// Operation is the requested operation.
+	Operation GuestAgentOperation `yaml:"operation"`
+
+	// Path of the command to run in the guest.  Only used with GuestExec.
+	Path string `yaml:"path,omitempty"`
+
+	// Args are the arguments of the command to run in the guest.  Only
+	// used with GuestExec.
+	Args []string `yaml:"args,omitempty"`
+}
+
+// GuestAgent represents the unmarshalled version of the contents of a SSNTP
+// GUESTAGENT payload.
+type GuestAgent struct {
+	// GuestAgent contains information about the requested operation.
+	GuestAgent GuestAgentCmd `yaml:"guest_agent"`
+}
+
+// GuestInterface describes a network interface of the guest of an
+// instance.
+type GuestInterface struct {
+	// Name of the interface in the guest
+	Name string `yaml:"name"`
+
+	// HardwareAddress is the MAC address of the interface.
+	HardwareAddress string `yaml:"hardware_address,omitempty"`
+
+	// IPAddresses are the addresses of the interface in CIDR notation.
+	IPAddresses []string `yaml:"ip_addresses,omitempty"`
+}
+
+// GuestAgentResultEvent contains the result of an operation run through
+// the QEMU guest agent of an instance.
+type GuestAgentResultEvent struct {
+	// InstanceUUID is the UUID of the instance.
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// RequestUUID identifies the request the result is for.
+	RequestUUID string `yaml:"request_uuid"`
+
+	// Error describes why the operation failed.  It is empty if the
+	// operation succeeded.
+	Error string `yaml:"error,omitempty"`
+
+	// ExitCode is the exit code of the command run by GuestExec.
+	ExitCode int `yaml:"exit_code,omitempty"`
+
+	// Stdout and Stderr contain the output of the command run by
+	// GuestExec.
+	Stdout string `yaml:"stdout,omitempty"`
+	Stderr string `yaml:"stderr,omitempty"`
+
+	// Filesystems is the number of filesystems frozen by GuestFreeze or
+	// thawed by GuestThaw.
+	Filesystems int `yaml:"filesystems,omitempty"`
+
+	// Interfaces are the network interfaces retrieved by
+	// GuestNetworkInfo.
+	Interfaces []GuestInterface `yaml:"interfaces,omitempty"`
+}
+
+// EventGuestAgentResult represents the unmarshalled version of the contents
+// of an SSNTP ssntp.GuestAgentResult event.  This event is sent by
+// ciao-launcher when an operation requested by a GUESTAGENT command
+// completes or fails.
+type EventGuestAgentResult struct {
+	GuestAgentResult GuestAgentResultEvent `yaml:"guest_agent_result"`
+}
@@ -0,0 +1,113 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"reflect"
+	"testing"
+
+	. "github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestGuestAgentUnmarshal(t *testing.T) {
+	var guestAgent GuestAgent
+	err := yaml.Unmarshal([]byte(testutil.GuestAgentYaml), &guestAgent)
+	if err != nil {
+		t.Error(err)
+	}
+
+	cmd := guestAgent.GuestAgent
+	if cmd.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", cmd.InstanceUUID)
+	}
+
+	if cmd.WorkloadAgentUUID != testutil.AgentUUID {
+		t.Errorf("Wrong Agent UUID field [%s]", cmd.WorkloadAgentUUID)
+	}
+
+	if cmd.RequestUUID != testutil.GuestAgentRequestUUID {
+		t.Errorf("Wrong request UUID field [%s]", cmd.RequestUUID)
+	}
+
+	if cmd.Operation != GuestExec || cmd.Path != "/bin/uname" ||
+		!reflect.DeepEqual(cmd.Args, []string{"-r"}) {
+		t.Errorf("Wrong guest agent exec fields [%s] [%s] %v", cmd.Operation, cmd.Path, cmd.Args)
+	}
+}
+
+func TestGuestAgentMarshal(t *testing.T) {
+	var guestAgent GuestAgent
+
+	guestAgent.GuestAgent.InstanceUUID = testutil.InstanceUUID
+	guestAgent.GuestAgent.WorkloadAgentUUID = testutil.AgentUUID
+	guestAgent.GuestAgent.RequestUUID = testutil.GuestAgentRequestUUID
+	guestAgent.GuestAgent.Operation = GuestExec
+	guestAgent.GuestAgent.Path = "/bin/uname"
+	guestAgent.GuestAgent.Args = []string{"-r"}
+
+	y, err := yaml.Marshal(&guestAgent)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.GuestAgentYaml {
+		t.Errorf("GUESTAGENT marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.GuestAgentYaml)
+	}
+}
+
+func TestGuestAgentResultUnmarshal(t *testing.T) {
+	var result EventGuestAgentResult
+	err := yaml.Unmarshal([]byte(testutil.GuestAgentResultYaml), &result)
+	if err != nil {
+		t.Error(err)
+	}
+
+	event := result.GuestAgentResult
+	if event.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", event.InstanceUUID)
+	}
+
+	if event.RequestUUID != testutil.GuestAgentRequestUUID {
+		t.Errorf("Wrong request UUID field [%s]", event.RequestUUID)
+	}
+
+	if event.Error != "" || event.ExitCode != 1 || event.Stdout != "4.13.0\n" ||
+		event.Stderr != "warning\n" {
+		t.Errorf("Wrong guest agent result fields %+v", event)
+	}
+}
+
+func TestGuestAgentResultMarshal(t *testing.T) {
+	var result EventGuestAgentResult
+
+	result.GuestAgentResult.InstanceUUID = testutil.InstanceUUID
+	result.GuestAgentResult.RequestUUID = testutil.GuestAgentRequestUUID
+	result.GuestAgentResult.ExitCode = 1
+	result.GuestAgentResult.Stdout = "4.13.0\n"
+	result.GuestAgentResult.Stderr = "warning\n"
+
+	y, err := yaml.Marshal(&result)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.GuestAgentResultYaml {
+		t.Errorf("GuestAgentResult marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.GuestAgentResultYaml)
+	}
+}
@@ -0,0 +1,40 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// InstancesPreemptedEvent contains the instances that the scheduler has
+// chosen to preempt in order to start a higher priority instance.
+type InstancesPreemptedEvent struct {
+	// InstanceUUID is the UUID of the instance waiting for the preempted
+	// instances to stop.
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// NodeUUID is the UUID of the node running the preempted instances,
+	// on which the waiting instance will be started.
+	NodeUUID string `yaml:"node_uuid"`
+
+	// Preempted contains the UUIDs of the instances to stop.
+	Preempted []string `yaml:"preempted"`
+}
+
+// EventInstancesPreempted represents the unmarshalled version of the contents
+// of an SSNTP ssntp.InstancesPreempted event.  This event is sent by the
+// scheduler to the controller when the cluster is full and lower priority
+// instances must be stopped to make room for a new instance.
+type EventInstancesPreempted struct {
+	InstancesPreempted InstancesPreemptedEvent `yaml:"instances_preempted"`
+}
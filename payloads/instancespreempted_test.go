@@ -0,0 +1,63 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestInstancesPreemptedUnmarshal(t *testing.T) {
+	var insPreempted EventInstancesPreempted
+	err := yaml.Unmarshal([]byte(testutil.InsPreemptedYaml), &insPreempted)
+	if err != nil {
+		t.Error(err)
+	}
+
+	event := insPreempted.InstancesPreempted
+	if event.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", event.InstanceUUID)
+	}
+
+	if event.NodeUUID != testutil.AgentUUID {
+		t.Errorf("Wrong node UUID field [%s]", event.NodeUUID)
+	}
+
+	if len(event.Preempted) != 1 || event.Preempted[0] != testutil.CNCIInstanceUUID {
+		t.Errorf("Wrong preempted field %v", event.Preempted)
+	}
+}
+
+func TestInstancesPreemptedMarshal(t *testing.T) {
+	var insPreempted EventInstancesPreempted
+
+	insPreempted.InstancesPreempted.InstanceUUID = testutil.InstanceUUID
+	insPreempted.InstancesPreempted.NodeUUID = testutil.AgentUUID
+	insPreempted.InstancesPreempted.Preempted = []string{testutil.CNCIInstanceUUID}
+
+	y, err := yaml.Marshal(&insPreempted)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.InsPreemptedYaml {
+		t.Errorf("InstancesPreempted marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.InsPreemptedYaml)
+	}
+}
@@ -16,10 +16,24 @@
 
 package payloads
 
+// StopMethod describes how the guest of an instance was stopped.
+type StopMethod string
+
+const (
+	// StopGraceful indicates that the guest shut down cleanly, e.g. after
+	// an ACPI powerdown request.
+	StopGraceful StopMethod = "graceful"
+
+	// StopForced indicates that the instance was killed as its guest did
+	// not shut down within the shutdown grace period of the launcher.
+	StopForced StopMethod = "forced"
+)
+
 // InstanceStoppedEvent contains the UUID of an instance that has just been
-// deleted from a node for the purposes of migration.
+// deleted from a node for the purposes of migration, and how it was stopped.
 type InstanceStoppedEvent struct {
-	InstanceUUID string `yaml:"instance_uuid"`
+	InstanceUUID string     `yaml:"instance_uuid"`
+	StopMethod   StopMethod `yaml:"stop_method,omitempty"`
 }
 
 // EventInstanceStopped represents the unmarshalled version of the contents of
@@ -50,3 +50,25 @@ func TestInstanceStoppedMarshal(t *testing.T) {
 		t.Errorf("InstanceStopped marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.InsStopYaml)
 	}
 }
+
+func TestInstanceStoppedMethod(t *testing.T) {
+	var insStop EventInstanceStopped
+
+	insStop.InstanceStopped.InstanceUUID = testutil.InstanceUUID
+	insStop.InstanceStopped.StopMethod = StopForced
+
+	y, err := yaml.Marshal(&insStop)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var insStop2 EventInstanceStopped
+	err = yaml.Unmarshal(y, &insStop2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if insStop2.InstanceStopped.StopMethod != StopForced {
+		t.Errorf("Wrong stop method field [%s]", insStop2.InstanceStopped.StopMethod)
+	}
+}
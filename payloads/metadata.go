@@ -0,0 +1,52 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// UpdateMetadataCmd contains the information needed to replace the
+// cloud-init user data and meta data of an instance after it was launched.
+type UpdateMetadataCmd struct {
+	// InstanceUUID is the UUID of the instance whose metadata is updated
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// WorkloadAgentUUID identifies the node on which the instance is
+	// running.  This information is needed by the scheduler to route
+	// the command to the correct CN.
+	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+
+	// UserData is the new cloud-init user data of the instance.
+	UserData string `yaml:"user_data"`
+
+	// MetaData is the new cloud-init meta data of the instance, in JSON.
+	// The default meta data of the instance is used if it is empty.
+	MetaData string `yaml:"meta_data"`
+
+	// Reboot is true if the instance should be rebooted once its
+	// metadata is updated, so that the guest reads the new metadata.
+	Reboot bool `yaml:"reboot"`
+
+	// Sequence orders the commands sent for the instance.  A command whose
+	// sequence is not greater than the one of the last command received
+	// for the instance is stale and ignored.  Zero if not sequenced.
+	Sequence uint64 `yaml:"sequence,omitempty"`
+}
+
+// UpdateMetadata represents the unmarshalled version of the contents of a
+// SSNTP UpdateMetadata payload.
+type UpdateMetadata struct {
+	// UpdateMetadata contains the new metadata of the instance.
+	UpdateMetadata UpdateMetadataCmd `yaml:"update_metadata"`
+}
@@ -0,0 +1,71 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestUpdateMetadataUnmarshal(t *testing.T) {
+	var update UpdateMetadata
+	err := yaml.Unmarshal([]byte(testutil.UpdateMetadataYaml), &update)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if update.UpdateMetadata.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", update.UpdateMetadata.InstanceUUID)
+	}
+
+	if update.UpdateMetadata.WorkloadAgentUUID != testutil.AgentUUID {
+		t.Errorf("Wrong Agent UUID field [%s]", update.UpdateMetadata.WorkloadAgentUUID)
+	}
+
+	if update.UpdateMetadata.UserData != testutil.UpdatedUserData {
+		t.Errorf("Wrong user data field [%s]", update.UpdateMetadata.UserData)
+	}
+
+	if update.UpdateMetadata.MetaData != testutil.UpdatedMetaData {
+		t.Errorf("Wrong meta data field [%s]", update.UpdateMetadata.MetaData)
+	}
+
+	if !update.UpdateMetadata.Reboot {
+		t.Errorf("Wrong reboot field [%v]", update.UpdateMetadata.Reboot)
+	}
+}
+
+func TestUpdateMetadataMarshal(t *testing.T) {
+	var update UpdateMetadata
+	update.UpdateMetadata.InstanceUUID = testutil.InstanceUUID
+	update.UpdateMetadata.WorkloadAgentUUID = testutil.AgentUUID
+	update.UpdateMetadata.UserData = testutil.UpdatedUserData
+	update.UpdateMetadata.MetaData = testutil.UpdatedMetaData
+	update.UpdateMetadata.Reboot = true
+
+	y, err := yaml.Marshal(&update)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.UpdateMetadataYaml {
+		t.Errorf("UpdateMetadata marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.UpdateMetadataYaml)
+	}
+}
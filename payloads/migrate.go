@@ -0,0 +1,93 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// MigrationState is the state of the live migration of an instance
+// reported in a MigrationStatus event.
+type MigrationState string
+
+const (
+	// MigrationIncoming is reported by the target node once the copy of
+	// the instance is waiting for its state.
+	MigrationIncoming MigrationState = "incoming"
+
+	// MigrationCompleted is reported by the source node once the
+	// instance runs on the target node.  The instance no longer exists
+	// on the source node.
+	MigrationCompleted MigrationState = "completed"
+
+	// MigrationFailed is reported by either node when the migration
+	// fails.  The instance keeps running on the source node.
+	MigrationFailed MigrationState = "failed"
+)
+
+// MigrateCmd contains the information needed to live migrate an instance
+// to another node.
+type MigrateCmd struct {
+	// InstanceUUID is the UUID of the instance to migrate
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// WorkloadAgentUUID identifies the node on which the instance is
+	// running.  This information is needed by the scheduler to route
+	// the command to the correct CN.
+	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+
+	// TargetAgentUUID identifies the node to which the instance is
+	// migrated.
+	TargetAgentUUID string `yaml:"target_agent_uuid"`
+
+	// TargetAddress is the host:port address on which the target node
+	// waits for the state of the instance.
+	TargetAddress string `yaml:"target_address"`
+}
+
+// Migrate represents the unmarshalled version of the contents of a SSNTP
+// MIGRATE payload.
+type Migrate struct {
+	// Migrate contains information about the requested migration.
+	Migrate MigrateCmd `yaml:"migrate"`
+}
+
+// MigrationStatusEvent contains the progress of the live migration of an
+// instance.
+type MigrationStatusEvent struct {
+	// InstanceUUID is the UUID of the instance being migrated.
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// NodeUUID is the UUID of the node reporting the status.
+	NodeUUID string `yaml:"node_uuid"`
+
+	// State is the state of the migration.
+	State MigrationState `yaml:"state"`
+
+	// Address is the host:port address on which the target node waits
+	// for the state of the instance.  It is only set for
+	// MigrationIncoming.
+	Address string `yaml:"address,omitempty"`
+
+	// Error describes why the migration failed.  It is only set for
+	// MigrationFailed.
+	Error string `yaml:"error,omitempty"`
+}
+
+// EventMigrationStatus represents the unmarshalled version of the contents
+// of an SSNTP ssntp.MigrationStatus event.  This event is sent by
+// ciao-launcher while it migrates an instance for a MIGRATE command, or
+// receives one for a START command naming a migration source.
+type EventMigrationStatus struct {
+	MigrationStatus MigrationStatusEvent `yaml:"migration_status"`
+}
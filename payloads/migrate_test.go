@@ -0,0 +1,108 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestMigrateUnmarshal(t *testing.T) {
+	var migrate Migrate
+	err := yaml.Unmarshal([]byte(testutil.LiveMigrateYaml), &migrate)
+	if err != nil {
+		t.Error(err)
+	}
+
+	cmd := migrate.Migrate
+	if cmd.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", cmd.InstanceUUID)
+	}
+
+	if cmd.WorkloadAgentUUID != testutil.AgentUUID {
+		t.Errorf("Wrong Agent UUID field [%s]", cmd.WorkloadAgentUUID)
+	}
+
+	if cmd.TargetAgentUUID != testutil.TargetAgentUUID {
+		t.Errorf("Wrong target Agent UUID field [%s]", cmd.TargetAgentUUID)
+	}
+
+	if cmd.TargetAddress != testutil.MigrationAddress {
+		t.Errorf("Wrong target address field [%s]", cmd.TargetAddress)
+	}
+}
+
+func TestMigrateMarshal(t *testing.T) {
+	var migrate Migrate
+
+	migrate.Migrate.InstanceUUID = testutil.InstanceUUID
+	migrate.Migrate.WorkloadAgentUUID = testutil.AgentUUID
+	migrate.Migrate.TargetAgentUUID = testutil.TargetAgentUUID
+	migrate.Migrate.TargetAddress = testutil.MigrationAddress
+
+	y, err := yaml.Marshal(&migrate)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.LiveMigrateYaml {
+		t.Errorf("MIGRATE marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.LiveMigrateYaml)
+	}
+}
+
+func TestMigrationStatusUnmarshal(t *testing.T) {
+	var status EventMigrationStatus
+	err := yaml.Unmarshal([]byte(testutil.MigrationStatusYaml), &status)
+	if err != nil {
+		t.Error(err)
+	}
+
+	event := status.MigrationStatus
+	if event.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", event.InstanceUUID)
+	}
+
+	if event.NodeUUID != testutil.TargetAgentUUID {
+		t.Errorf("Wrong node UUID field [%s]", event.NodeUUID)
+	}
+
+	if event.State != MigrationIncoming || event.Address != testutil.MigrationAddress ||
+		event.Error != "" {
+		t.Errorf("Wrong migration status fields %+v", event)
+	}
+}
+
+func TestMigrationStatusMarshal(t *testing.T) {
+	var status EventMigrationStatus
+
+	status.MigrationStatus.InstanceUUID = testutil.InstanceUUID
+	status.MigrationStatus.NodeUUID = testutil.TargetAgentUUID
+	status.MigrationStatus.State = MigrationIncoming
+	status.MigrationStatus.Address = testutil.MigrationAddress
+
+	y, err := yaml.Marshal(&status)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.MigrationStatusYaml {
+		t.Errorf("MigrationStatus marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.MigrationStatusYaml)
+	}
+}
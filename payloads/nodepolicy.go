@@ -0,0 +1,56 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// MaxNodeWeight is the highest scheduling weight a node can have.  It is
+// the weight of the nodes for which no policy has been set.
+const MaxNodeWeight = 100
+
+// NodePolicy contains the scheduling overrides set by admins for a node.
+type NodePolicy struct {
+	// NodeUUID is the UUID of the node.
+	NodeUUID string `yaml:"node_uuid"`
+
+	// Weight is the scheduling weight of the node, between 0 and
+	// MaxNodeWeight.  Instances are started on the nodes with the highest
+	// weight they fit on, so that nodes with a lower weight are only used
+	// once the others are full.
+	Weight int `yaml:"weight"`
+
+	// MaxInstances is the maximum number of instances the node may
+	// run.  0 means the node has no instance limit.
+	MaxInstances int `yaml:"max_instances,omitempty"`
+
+	// AllowCNCIColocation lets the scheduler start the workloads of a
+	// tenant on the node running the CNCI of that tenant.  Tenant
+	// workloads are kept away from the CNCIs of their tenant by default,
+	// as they compete with them for the network of the node.
+	AllowCNCIColocation bool `yaml:"allow_cnci_colocation,omitempty"`
+
+	// DedicatedTenant is the UUID of the tenant the compute node is
+	// reserved for.  The scheduler only starts the workloads of that
+	// tenant on the node, and only on the nodes dedicated to it.
+	DedicatedTenant string `yaml:"dedicated_tenant,omitempty"`
+}
+
+// NodePolicies represents the unmarshalled version of the contents of an
+// SSNTP ssntp.NodePolicy command.  This command is sent by the controller to
+// the scheduler and contains the policies of all the nodes for which one
+// has been set.
+type NodePolicies struct {
+	Policies []NodePolicy `yaml:"node_policies"`
+}
@@ -0,0 +1,74 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestNodePolicyUnmarshal(t *testing.T) {
+	var policies NodePolicies
+	err := yaml.Unmarshal([]byte(testutil.NodePolicyYaml), &policies)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(policies.Policies) != 2 {
+		t.Fatalf("Expected 2 policies, got %d", len(policies.Policies))
+	}
+
+	policy := policies.Policies[0]
+	if policy.NodeUUID != testutil.AgentUUID || policy.Weight != 50 || policy.MaxInstances != 10 {
+		t.Errorf("Wrong policy fields %+v", policy)
+	}
+
+	policy = policies.Policies[1]
+	if policy.NodeUUID != testutil.NetAgentUUID || policy.Weight != MaxNodeWeight || policy.MaxInstances != 0 ||
+		!policy.AllowCNCIColocation {
+		t.Errorf("Wrong policy fields %+v", policy)
+	}
+}
+
+func TestNodePolicyMarshal(t *testing.T) {
+	policies := NodePolicies{
+		Policies: []NodePolicy{
+			{
+				NodeUUID:     testutil.AgentUUID,
+				Weight:       50,
+				MaxInstances: 10,
+			},
+			{
+				NodeUUID:            testutil.NetAgentUUID,
+				Weight:              MaxNodeWeight,
+				AllowCNCIColocation: true,
+			},
+		},
+	}
+
+	y, err := yaml.Marshal(&policies)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.NodePolicyYaml {
+		t.Errorf("NodePolicy marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.NodePolicyYaml)
+	}
+}
@@ -0,0 +1,49 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// PauseCmd contains the information needed to pause or unpause an instance.
+type PauseCmd struct {
+	// InstanceUUID is the UUID of the instance to pause or unpause
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// WorkloadAgentUUID identifies the node on which the instance is
+	// running.  This information is needed by the scheduler to route
+	// the command to the correct CN.
+	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+
+	// Sequence orders the commands sent for the instance.  A command whose
+	// sequence is not greater than the one of the last command received
+	// for the instance is stale and ignored.  Zero if not sequenced.
+	Sequence uint64 `yaml:"sequence,omitempty"`
+}
+
+// Pause represents the unmarshalled version of the contents of a SSNTP
+// PAUSE payload.  The structure contains enough information to freeze a
+// running CN instance without losing its memory state.
+type Pause struct {
+	// Pause contains information about the instance to pause.
+	Pause PauseCmd `yaml:"pause"`
+}
+
+// Unpause represents the unmarshalled version of the contents of a SSNTP
+// UNPAUSE payload.  The structure contains enough information to resume
+// a paused CN instance.
+type Unpause struct {
+	// Unpause contains information about the instance to unpause.
+	Unpause PauseCmd `yaml:"unpause"`
+}
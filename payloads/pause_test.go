@@ -0,0 +1,99 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestPauseUnmarshal(t *testing.T) {
+	var pause Pause
+	err := yaml.Unmarshal([]byte(testutil.PauseYaml), &pause)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if pause.Pause.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", pause.Pause.InstanceUUID)
+	}
+
+	if pause.Pause.WorkloadAgentUUID != testutil.AgentUUID {
+		t.Errorf("Wrong Agent UUID field [%s]", pause.Pause.WorkloadAgentUUID)
+	}
+}
+
+func TestPauseSequenceUnmarshal(t *testing.T) {
+	var pause Pause
+	err := yaml.Unmarshal([]byte(testutil.SequencedPauseYaml), &pause)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if pause.Pause.Sequence != 1 {
+		t.Errorf("Wrong sequence field [%d]", pause.Pause.Sequence)
+	}
+}
+
+func TestUnpauseUnmarshal(t *testing.T) {
+	var unpause Unpause
+	err := yaml.Unmarshal([]byte(testutil.UnpauseYaml), &unpause)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if unpause.Unpause.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", unpause.Unpause.InstanceUUID)
+	}
+
+	if unpause.Unpause.WorkloadAgentUUID != testutil.AgentUUID {
+		t.Errorf("Wrong Agent UUID field [%s]", unpause.Unpause.WorkloadAgentUUID)
+	}
+}
+
+func TestPauseMarshal(t *testing.T) {
+	var pause Pause
+	pause.Pause.InstanceUUID = testutil.InstanceUUID
+	pause.Pause.WorkloadAgentUUID = testutil.AgentUUID
+
+	y, err := yaml.Marshal(&pause)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.PauseYaml {
+		t.Errorf("PAUSE marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.PauseYaml)
+	}
+}
+
+func TestUnpauseMarshal(t *testing.T) {
+	var unpause Unpause
+	unpause.Unpause.InstanceUUID = testutil.InstanceUUID
+	unpause.Unpause.WorkloadAgentUUID = testutil.AgentUUID
+
+	y, err := yaml.Marshal(&unpause)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.UnpauseYaml {
+		t.Errorf("UNPAUSE marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.UnpauseYaml)
+	}
+}
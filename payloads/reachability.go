@@ -0,0 +1,46 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// IPReachability contains the result of the probe of an instance IP.
+type IPReachability struct {
+	// IP is the private IP address of the instance.
+	IP string `yaml:"ip"`
+
+	// Reachable is set if the instance answered the probe.
+	Reachable bool `yaml:"reachable"`
+}
+
+// InstanceReachabilityEvent contains the results of the reachability probe
+// of the instances on the subnets served by a CNCI.
+type InstanceReachabilityEvent struct {
+	// ConcentratorUUID is the UUID of the CNCI which probed the instances.
+	ConcentratorUUID string `yaml:"concentrator_uuid"`
+
+	// TenantUUID is the UUID of the tenant owning the CNCI.
+	TenantUUID string `yaml:"tenant_uuid"`
+
+	// IPs are the results of the probe of each instance IP.
+	IPs []IPReachability `yaml:"ips"`
+}
+
+// EventInstanceReachability represents the unmarshalled version of the
+// contents of an SSNTP ssntp.InstanceReachability event.  This event is
+// sent periodically by CNCIs.
+type EventInstanceReachability struct {
+	InstanceReachability InstanceReachabilityEvent `yaml:"instance_reachability"`
+}
@@ -0,0 +1,69 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestInstanceReachabilityUnmarshal(t *testing.T) {
+	var reachability EventInstanceReachability
+	err := yaml.Unmarshal([]byte(testutil.InstanceReachabilityYaml), &reachability)
+	if err != nil {
+		t.Error(err)
+	}
+
+	event := reachability.InstanceReachability
+	if event.ConcentratorUUID != testutil.CNCIUUID {
+		t.Errorf("Wrong concentrator UUID field [%s]", event.ConcentratorUUID)
+	}
+
+	if event.TenantUUID != testutil.TenantUUID {
+		t.Errorf("Wrong tenant UUID field [%s]", event.TenantUUID)
+	}
+
+	if len(event.IPs) != 1 || event.IPs[0].IP != testutil.InstancePrivateIP ||
+		!event.IPs[0].Reachable {
+		t.Errorf("Wrong IPs field %+v", event.IPs)
+	}
+}
+
+func TestInstanceReachabilityMarshal(t *testing.T) {
+	var reachability EventInstanceReachability
+
+	reachability.InstanceReachability.ConcentratorUUID = testutil.CNCIUUID
+	reachability.InstanceReachability.TenantUUID = testutil.TenantUUID
+	reachability.InstanceReachability.IPs = []IPReachability{
+		{
+			IP:        testutil.InstancePrivateIP,
+			Reachable: true,
+		},
+	}
+
+	y, err := yaml.Marshal(&reachability)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.InstanceReachabilityYaml {
+		t.Errorf("InstanceReachability marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.InstanceReachabilityYaml)
+	}
+}
@@ -37,6 +37,23 @@ type Ready struct {
 	// MBs available in the RootFS of the CN/NN
 	DiskAvailableMB int `yaml:"disk_available_mb"`
 
+	// Disk I/O operations per second the CN/NN can provide to instances.
+	// 0 if the node does not track disk I/O.
+	DiskIOPSTotal int `yaml:"disk_iops_total,omitempty"`
+
+	// Disk I/O operations per second not yet reserved by instances
+	DiskIOPSAvailable int `yaml:"disk_iops_available,omitempty"`
+
+	// Network bandwidth in Mbps the CN/NN can provide to instances.
+	// 0 if the node does not track network bandwidth.
+	NetMbpsTotal int `yaml:"net_mbps_total,omitempty"`
+
+	// Network bandwidth in Mbps not yet reserved by instances
+	NetMbpsAvailable int `yaml:"net_mbps_available,omitempty"`
+
+	// Number of instances running on the CN/NN
+	Instances int `yaml:"instances,omitempty"`
+
 	// Load of CN/NN, taken from /proc/loadavg (Average over last minute
 	// reported).
 	Load int `yaml:"load"`
@@ -0,0 +1,58 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// RebootType is the type of reboot requested by a REBOOT command.
+type RebootType string
+
+const (
+	// RebootSoft asks the guest of the instance to shut down before it
+	// is booted again.  The instance is killed if it does not shut down
+	// in time.
+	RebootSoft RebootType = "soft"
+
+	// RebootHard kills the instance straight away before booting it
+	// again.
+	RebootHard RebootType = "hard"
+)
+
+// RebootCmd contains the information needed to reboot an instance in place.
+type RebootCmd struct {
+	// InstanceUUID is the UUID of the instance to reboot
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// WorkloadAgentUUID identifies the node on which the instance is
+	// running.  This information is needed by the scheduler to route
+	// the command to the correct CN.
+	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+
+	// Type is the type of reboot, soft or hard.
+	Type RebootType `yaml:"type"`
+
+	// Sequence orders the commands sent for the instance.  A command whose
+	// sequence is not greater than the one of the last command received
+	// for the instance is stale and ignored.  Zero if not sequenced.
+	Sequence uint64 `yaml:"sequence,omitempty"`
+}
+
+// Reboot represents the unmarshalled version of the contents of a SSNTP
+// REBOOT payload.  The structure contains enough information to reboot a
+// running CN instance on the node it is running on.
+type Reboot struct {
+	// Reboot contains information about the instance to reboot.
+	Reboot RebootCmd `yaml:"reboot"`
+}
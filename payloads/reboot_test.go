@@ -0,0 +1,61 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestRebootUnmarshal(t *testing.T) {
+	var reboot Reboot
+	err := yaml.Unmarshal([]byte(testutil.RebootYaml), &reboot)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if reboot.Reboot.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", reboot.Reboot.InstanceUUID)
+	}
+
+	if reboot.Reboot.WorkloadAgentUUID != testutil.AgentUUID {
+		t.Errorf("Wrong Agent UUID field [%s]", reboot.Reboot.WorkloadAgentUUID)
+	}
+
+	if reboot.Reboot.Type != RebootHard {
+		t.Errorf("Wrong reboot type field [%s]", reboot.Reboot.Type)
+	}
+}
+
+func TestRebootMarshal(t *testing.T) {
+	var reboot Reboot
+	reboot.Reboot.InstanceUUID = testutil.InstanceUUID
+	reboot.Reboot.WorkloadAgentUUID = testutil.AgentUUID
+	reboot.Reboot.Type = RebootHard
+
+	y, err := yaml.Marshal(&reboot)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.RebootYaml {
+		t.Errorf("REBOOT marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.RebootYaml)
+	}
+}
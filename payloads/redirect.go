@@ -0,0 +1,26 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package payloads
+
+// Redirect represents the unmarshalled version of the contents of an SSNTP
+// ssntp.REDIRECT status payload.  This status is sent by a draining SSNTP
+// server to its clients to ask them to reconnect to another server.
+type Redirect struct {
+	// URI is the address of the server to reconnect to, either host:port
+	// or host, in which case the client's configured port is used.
+	URI string `yaml:"uri"`
+}
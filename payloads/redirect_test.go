@@ -0,0 +1,53 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestRedirectUnmarshal(t *testing.T) {
+	var redirect Redirect
+
+	err := yaml.Unmarshal([]byte(testutil.RedirectYaml), &redirect)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if redirect.URI != testutil.RedirectURI {
+		t.Errorf("Wrong URI field [%s]", redirect.URI)
+	}
+}
+
+func TestRedirectMarshal(t *testing.T) {
+	redirect := Redirect{
+		URI: testutil.RedirectURI,
+	}
+
+	y, err := yaml.Marshal(&redirect)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.RedirectYaml {
+		t.Errorf("Redirect marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.RedirectYaml)
+	}
+}
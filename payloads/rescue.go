@@ -0,0 +1,71 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// RescueCmd contains the information needed to reboot an instance from a
+// rescue volume.
+type RescueCmd struct {
+	// InstanceUUID is the UUID of the instance to rescue
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// WorkloadAgentUUID identifies the node on which the instance is
+	// running.  This information is needed by the scheduler to route
+	// the command to the correct CN.
+	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+
+	// Volume is the rescue volume the instance boots from.
+	Volume StorageResource `yaml:"volume"`
+
+	// Sequence orders the commands sent for the instance.  A command whose
+	// sequence is not greater than the one of the last command received
+	// for the instance is stale and ignored.  Zero if not sequenced.
+	Sequence uint64 `yaml:"sequence,omitempty"`
+}
+
+// UnrescueCmd contains the information needed to reboot a rescued instance
+// from its original boot volume.
+type UnrescueCmd struct {
+	// InstanceUUID is the UUID of the instance to unrescue
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// WorkloadAgentUUID identifies the node on which the instance is
+	// running.  This information is needed by the scheduler to route
+	// the command to the correct CN.
+	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+
+	// Sequence orders the commands sent for the instance.  A command whose
+	// sequence is not greater than the one of the last command received
+	// for the instance is stale and ignored.  Zero if not sequenced.
+	Sequence uint64 `yaml:"sequence,omitempty"`
+}
+
+// Rescue represents the unmarshalled version of the contents of a SSNTP
+// RESCUE payload.  The structure contains enough information to reboot a
+// running CN instance from a rescue volume, with its original volumes
+// attached as secondary devices.
+type Rescue struct {
+	// Rescue contains information about the instance to rescue.
+	Rescue RescueCmd `yaml:"rescue"`
+}
+
+// Unrescue represents the unmarshalled version of the contents of a SSNTP
+// UNRESCUE payload.  The structure contains enough information to reboot
+// a rescued CN instance from its original boot volume.
+type Unrescue struct {
+	// Unrescue contains information about the instance to unrescue.
+	Unrescue UnrescueCmd `yaml:"unrescue"`
+}
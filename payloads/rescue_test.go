@@ -0,0 +1,97 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestRescueUnmarshal(t *testing.T) {
+	var rescue Rescue
+	err := yaml.Unmarshal([]byte(testutil.RescueYaml), &rescue)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if rescue.Rescue.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", rescue.Rescue.InstanceUUID)
+	}
+
+	if rescue.Rescue.WorkloadAgentUUID != testutil.AgentUUID {
+		t.Errorf("Wrong Agent UUID field [%s]", rescue.Rescue.WorkloadAgentUUID)
+	}
+
+	vol := rescue.Rescue.Volume
+	if vol.ID != testutil.VolumeUUID || !vol.Bootable || !vol.Rescue {
+		t.Errorf("Wrong rescue volume field [%+v]", vol)
+	}
+}
+
+func TestUnrescueUnmarshal(t *testing.T) {
+	var unrescue Unrescue
+	err := yaml.Unmarshal([]byte(testutil.UnrescueYaml), &unrescue)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if unrescue.Unrescue.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", unrescue.Unrescue.InstanceUUID)
+	}
+
+	if unrescue.Unrescue.WorkloadAgentUUID != testutil.AgentUUID {
+		t.Errorf("Wrong Agent UUID field [%s]", unrescue.Unrescue.WorkloadAgentUUID)
+	}
+}
+
+func TestRescueMarshal(t *testing.T) {
+	var rescue Rescue
+	rescue.Rescue.InstanceUUID = testutil.InstanceUUID
+	rescue.Rescue.WorkloadAgentUUID = testutil.AgentUUID
+	rescue.Rescue.Volume = StorageResource{
+		ID:       testutil.VolumeUUID,
+		Bootable: true,
+		Rescue:   true,
+	}
+
+	y, err := yaml.Marshal(&rescue)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.RescueYaml {
+		t.Errorf("RESCUE marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.RescueYaml)
+	}
+}
+
+func TestUnrescueMarshal(t *testing.T) {
+	var unrescue Unrescue
+	unrescue.Unrescue.InstanceUUID = testutil.InstanceUUID
+	unrescue.Unrescue.WorkloadAgentUUID = testutil.AgentUUID
+
+	y, err := yaml.Marshal(&unrescue)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.UnrescueYaml {
+		t.Errorf("UNRESCUE marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.UnrescueYaml)
+	}
+}
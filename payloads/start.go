@@ -31,6 +31,15 @@ type Resource string
 // Hypervisor indicates the type of hypervisor used to run a given instance
 type Hypervisor string
 
+// Priority represents the priority class of a workload.  When the cluster is
+// full, the scheduler may preempt instances of lower priority workloads to
+// make room for instances of higher priority ones.
+type Priority string
+
+// WatchdogAction represents the action taken when the watchdog device of a
+// VM fires because the guest stopped servicing it.
+type WatchdogAction string
+
 const (
 	// All used to indicate all persistent scenario, in this case it
 	// indicates to act in all instances.
@@ -68,6 +77,14 @@ const (
 	// command in which it is embedded applies to a network node.
 	NetworkNode = "network_node"
 
+	// DiskIOPS indicates that a resource struct specifies a number of
+	// disk I/O operations per second
+	DiskIOPS = "disk_iops"
+
+	// NetMbps indicates that a resource struct specifies a network
+	// bandwidth in megabits per second
+	NetMbps = "net_mbps"
+
 	// ComputeNode indicates that a resource struct specifies whether the
 	// command in which it is embedded applies to a compute node.
 	ComputeNode = "compute_node"
@@ -88,8 +105,18 @@ const (
 	// SharedDiskGiB is used for shared storage across the cluster used for
 	// storing volume and images. (Measured in GiB)
 	SharedDiskGiB = "shared_disk_gib"
+
+	// ArchiveDiskGiB is used for the archive storage holding the volumes
+	// of archived instances. (Measured in GiB)
+	ArchiveDiskGiB = "archive_disk_gib"
 )
 
+// StorageClassDiskGiB returns the resource used for the shared storage of
+// the volumes of a storage class. (Measured in GiB)
+func StorageClassDiskGiB(class string) Resource {
+	return Resource(SharedDiskGiB + ":" + class)
+}
+
 const (
 	// QEMU specifies that an instance is to be booted on QEMU KVM VM.
 	QEMU Hypervisor = "qemu"
@@ -99,6 +126,59 @@ const (
 	Docker = "docker"
 )
 
+const (
+	// LowPriority workloads are the first to be preempted when the
+	// cluster is full.  They never preempt other instances.
+	LowPriority Priority = "low"
+
+	// NormalPriority is the priority class of workloads that do not
+	// specify one.
+	NormalPriority Priority = "normal"
+
+	// HighPriority workloads may preempt both low and normal priority
+	// instances.
+	HighPriority Priority = "high"
+)
+
+// Level returns the rank of a priority class, higher ranks preempting
+// lower ones.  An empty priority class is ranked as NormalPriority and -1
+// is returned for unknown priority classes.
+func (p Priority) Level() int {
+	switch p {
+	case LowPriority:
+		return 0
+	case NormalPriority, "":
+		return 1
+	case HighPriority:
+		return 2
+	}
+
+	return -1
+}
+
+const (
+	// WatchdogReset indicates that a VM is reset when its watchdog fires.
+	WatchdogReset WatchdogAction = "reset"
+
+	// WatchdogPowerOff indicates that a VM is powered off when its
+	// watchdog fires.
+	WatchdogPowerOff WatchdogAction = "poweroff"
+
+	// WatchdogNone indicates that nothing is done to a VM when its
+	// watchdog fires, the event only being reported.
+	WatchdogNone WatchdogAction = "none"
+)
+
+// Valid returns true if a is a known watchdog action.
+func (a WatchdogAction) Valid() bool {
+	switch a {
+	case WatchdogReset, WatchdogPowerOff, WatchdogNone:
+		return true
+	}
+
+	return false
+}
+
 // StorageResource represents a requested storage resource for a workload.
 type StorageResource struct {
 	// ID is passed to the Block Driver to operate on the resource
@@ -128,6 +208,23 @@ type StorageResource struct {
 
 	// Size is the requested size for an auto-created storage resource
 	Size int `yaml:"size,omitempty"`
+
+	// Pool is the Ceph pool holding the storage resource.  It is empty
+	// for resources held by the default pool.
+	Pool string `yaml:"pool,omitempty"`
+
+	// Key is the LUKS passphrase of the storage resource.  It is empty
+	// for resources which are not encrypted.
+	Key string `yaml:"key,omitempty"`
+
+	// CDROM indicates that the storage resource should be presented to
+	// the instance as a read-only CD-ROM drive rather than as a disk.
+	CDROM bool `yaml:"cdrom,omitempty"`
+
+	// Rescue indicates that the storage resource is the rescue volume of
+	// an instance in rescue mode.  The instance boots from it and its
+	// other storage resources are attached as secondary devices.
+	Rescue bool `yaml:"rescue,omitempty"`
 }
 
 // RequestedResource is used to specify an individual resource contained within
@@ -193,6 +290,22 @@ type WorkloadRequirements struct {
 	// VCPUs specifies the required number of CPUs for the workload
 	VCPUs int `yaml:"vcpus"`
 
+	// DiskIOPS specifies the disk I/O operations per second the workload
+	// requires.  Instances of the workload are limited to that rate.
+	// 0 means the workload has no disk I/O requirement.
+	DiskIOPS int `yaml:"disk_iops,omitempty"`
+
+	// DiskMB specifies the ephemeral disk space in MiB the workload
+	// requires.  Instances of the workload whose disk usage approaches
+	// this size are reported to the tenant.  0 means the workload has no
+	// disk space requirement.
+	DiskMB int `yaml:"disk_mb,omitempty"`
+
+	// NetMbps specifies the network bandwidth in Mbps the workload
+	// requires.  Instances of the workload are limited to that bandwidth.
+	// 0 means the workload has no network bandwidth requirement.
+	NetMbps int `yaml:"net_mbps,omitempty"`
+
 	// NodeID specifies the node that the instance must be scheduled on
 	NodeID string `yaml:"node_id,omitempty"`
 
@@ -205,6 +318,20 @@ type WorkloadRequirements struct {
 	// Privileged indicates that this container workload should be run with increased
 	// permissions
 	Privileged bool `yaml:"privileged,omitempty"`
+
+	// Console indicates that this container workload should be run with
+	// a terminal and an open stdin, so that console sessions can be
+	// opened on its instances.
+	Console bool `yaml:"console,omitempty"`
+
+	// Priority specifies the priority class of this workload.  The
+	// default is NormalPriority.
+	Priority Priority `yaml:"priority,omitempty"`
+
+	// Watchdog specifies the action taken when the watchdog device of a
+	// VM instance fires.  VM instances are only given a watchdog device
+	// when an action is specified.
+	Watchdog WatchdogAction `yaml:"watchdog,omitempty"`
 }
 
 // StartCmd contains the information needed to start a new instance.
@@ -246,6 +373,17 @@ type StartCmd struct {
 	// Restart is set to true if the payload represents a request to
 	// restart an existing instance on a new node.
 	Restart bool
+
+	// MigrationSource is the UUID of the node from which a running
+	// instance is being live migrated.  When set, the instance is
+	// started waiting for its state from that node, which the scheduler
+	// never picks to run it.
+	MigrationSource string `yaml:"migration_source,omitempty"`
+
+	// Annotations are arbitrary key-value pairs, e.g. billing tags or
+	// trace IDs, that are opaque to the scheduler and stored by the
+	// launcher with the instance.
+	Annotations map[string]string `yaml:"annotations,omitempty"`
 }
 
 // Start represents the unmarshalled version of the contents of a SSNTP START
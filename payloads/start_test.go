@@ -79,3 +79,57 @@ func TestStartUnmarshalPartial(t *testing.T) {
 		t.Error("Unexpected values in Start")
 	}
 }
+
+// make sure the disk and network requirements of a workload can be
+// unmarshaled into the Start struct
+func TestStartUnmarshalIORequirements(t *testing.T) {
+	var cmd Start
+	err := yaml.Unmarshal([]byte(testutil.PartialStartYaml+"    disk_iops: 500\n    net_mbps: 100\n"), &cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cmd.Start.Requirements.DiskIOPS != 500 ||
+		cmd.Start.Requirements.NetMbps != 100 {
+		t.Errorf("Unexpected requirements in Start: %+v", cmd.Start.Requirements)
+	}
+}
+
+func TestStartUnmarshalPriority(t *testing.T) {
+	var cmd Start
+	err := yaml.Unmarshal([]byte(testutil.PartialStartYaml+"    priority: high\n"), &cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cmd.Start.Requirements.Priority != HighPriority {
+		t.Errorf("Unexpected priority in Start: %s", cmd.Start.Requirements.Priority)
+	}
+}
+
+func TestStartUnmarshalAnnotations(t *testing.T) {
+	var cmd Start
+	err := yaml.Unmarshal([]byte(testutil.PartialStartYaml+"  annotations:\n    billing: team-a\n"), &cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cmd.Start.Annotations["billing"] != "team-a" {
+		t.Errorf("Unexpected annotations in Start: %v", cmd.Start.Annotations)
+	}
+}
+
+func TestPriorityLevel(t *testing.T) {
+	if !(LowPriority.Level() < NormalPriority.Level() &&
+		NormalPriority.Level() < HighPriority.Level()) {
+		t.Errorf("Priority classes are not ordered")
+	}
+
+	if Priority("").Level() != NormalPriority.Level() {
+		t.Errorf("Empty priority class is not normal")
+	}
+
+	if Priority("urgent").Level() != -1 {
+		t.Errorf("Unknown priority class accepted")
+	}
+}
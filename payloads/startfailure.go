@@ -40,6 +40,10 @@ const (
 	// mode.
 	NodeInMaintenance = "node_maintenance"
 
+	// NodeDraining indicates that the node to which the START command
+	// was sent cannot host the instance as it is shutting down.
+	NodeDraining = "node_draining"
+
 	// NoComputeNodes is returned by the scheduler if no compute nodes are
 	// running in the cluster upon which the instance can be started.
 	NoComputeNodes = "no_cn"
@@ -82,6 +86,11 @@ const (
 	// NetworkFailure indicates that it was not possible to initialise
 	// networking for the instance.
 	NetworkFailure = "network_failure"
+
+	// LaunchTimeout is used by the controller when an instance remains
+	// pending for longer than the instance scheduling timeout, e.g.,
+	// because the launcher crashed while starting it.
+	LaunchTimeout = "launch_timeout"
 )
 
 // ErrorStartFailure represents the unmarshalled version of the contents of a
@@ -100,6 +109,30 @@ type ErrorStartFailure struct {
 	// Restart is true if the failed start command was attempting to
 	// restart an existing instance.
 	Restart bool
+
+	// Diagnostics contains the details of the failure collected by
+	// ciao-launcher.  It is nil if none were collected.
+	Diagnostics *StartFailureDiagnostics `yaml:"diagnostics,omitempty"`
+}
+
+// StartFailureDiagnostics contains the details collected by ciao-launcher
+// about an instance which failed to start.
+type StartFailureDiagnostics struct {
+	// Error is the error which prevented the instance from starting.
+	Error string `yaml:"error,omitempty"`
+
+	// CommandLine is the command line of the hypervisor, or the image
+	// and command of the container, used to launch the instance.  It is
+	// only set if the instance failed to launch.
+	CommandLine []string `yaml:"command_line,omitempty"`
+
+	// Stderr is the tail of the error output of the hypervisor or of the
+	// container runtime.
+	Stderr string `yaml:"stderr,omitempty"`
+
+	// Log contains the last messages logged by ciao-launcher while
+	// launching the instance.
+	Log []string `yaml:"log,omitempty"`
 }
 
 func (r StartFailureReason) String() string {
@@ -110,6 +143,8 @@ func (r StartFailureReason) String() string {
 		return "Compute node is full"
 	case NodeInMaintenance:
 		return "Node is undergoing maintenance"
+	case NodeDraining:
+		return "Node is shutting down"
 	case NoComputeNodes:
 		return "No compute node available"
 	case NoNetworkNodes:
@@ -128,6 +163,8 @@ func (r StartFailureReason) String() string {
 		return "Failed to launch instance"
 	case NetworkFailure:
 		return "Failed to create VNIC for instance"
+	case LaunchTimeout:
+		return "Timed out waiting for instance to start"
 	}
 
 	return ""
@@ -140,13 +177,15 @@ func (r StartFailureReason) IsFatal() bool {
 	case FullCloud,
 		FullComputeNode,
 		NodeInMaintenance,
+		NodeDraining,
 		NoComputeNodes,
 		NoNetworkNodes,
 		InvalidPayload,
 		InvalidData,
 		ImageFailure,
 		LaunchFailure,
-		NetworkFailure:
+		NetworkFailure,
+		LaunchTimeout:
 		return true
 
 	case AlreadyRunning,
@@ -61,6 +61,42 @@ func TestStartFailureMarshal(t *testing.T) {
 	}
 }
 
+func TestStartFailureDiagnostics(t *testing.T) {
+	error := ErrorStartFailure{
+		NodeUUID:     testutil.AgentUUID,
+		InstanceUUID: testutil.InstanceUUID,
+		Reason:       LaunchFailure,
+		Diagnostics: &StartFailureDiagnostics{
+			Error:       "exit status 1",
+			CommandLine: []string{"-m", "128", "-smp", "cpus=1"},
+			Stderr:      "qemu-system-x86_64: Could not access KVM kernel module",
+			Log:         []string{"launching qemu", "Unable to launch qemu: exit status 1"},
+		},
+	}
+
+	y, err := yaml.Marshal(&error)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var failure ErrorStartFailure
+	err = yaml.Unmarshal(y, &failure)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := failure.Diagnostics
+	if d == nil {
+		t.Fatal("Diagnostics missing")
+	}
+
+	if d.Error != error.Diagnostics.Error || d.Stderr != error.Diagnostics.Stderr ||
+		len(d.CommandLine) != 4 || d.CommandLine[3] != "cpus=1" ||
+		len(d.Log) != 2 || d.Log[1] != error.Diagnostics.Log[1] {
+		t.Errorf("Diagnostics not preserved: %+v", d)
+	}
+}
+
 func TestStartFailureString(t *testing.T) {
 	var stringTests = []struct {
 		r        StartFailureReason
@@ -69,6 +105,7 @@ func TestStartFailureString(t *testing.T) {
 		{FullCloud, "Cloud is full"},
 		{FullComputeNode, "Compute node is full"},
 		{NodeInMaintenance, "Node is undergoing maintenance"},
+		{NodeDraining, "Node is shutting down"},
 		{NoComputeNodes, "No compute node available"},
 		{NoNetworkNodes, "No network node available"},
 		{InvalidPayload, "YAML payload is corrupt"},
@@ -78,6 +115,7 @@ func TestStartFailureString(t *testing.T) {
 		{ImageFailure, "Failed to create instance image"},
 		{LaunchFailure, "Failed to launch instance"},
 		{NetworkFailure, "Failed to create VNIC for instance"},
+		{LaunchTimeout, "Timed out waiting for instance to start"},
 	}
 	error := ErrorStartFailure{
 		InstanceUUID: testutil.InstanceUUID,
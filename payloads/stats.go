@@ -50,8 +50,34 @@ type InstanceStat struct {
 	// 100% means all your VCPUs are maxed out.
 	CPUUsage int `yaml:"cpu_usage"`
 
+	// Memory in MB reclaimed from the instance by ballooning.  Will
+	// be 0 if no memory has been reclaimed.
+	MemoryReclaimedMB int `yaml:"memory_reclaimed_mb,omitempty"`
+
 	// List of volumes attached to the instance.
 	Volumes []string `yaml:"volumes"`
+
+	// Space actually allocated to the volumes attached to the instance.
+	// Volumes are thin-provisioned so this is usually less than their
+	// size.  Volumes whose usage is not known are omitted.
+	VolumeUsage []VolumeStat `yaml:"volume_usage,omitempty"`
+
+	// Time in milliseconds the instance took to boot, from the receipt
+	// of its START command to its guest being ready, i.e., its guest
+	// agent answering for VMs and its process running for containers.
+	// Will be 0 if not known, e.g., while the instance is booting or if
+	// its guest does not run the QEMU guest agent.
+	BootTimeMS int `yaml:"boot_time_ms,omitempty"`
+}
+
+// VolumeStat contains the storage consumed by a volume attached to an
+// instance.
+type VolumeStat struct {
+	// UUID of the volume
+	VolumeUUID string `yaml:"volume_uuid"`
+
+	// MBs allocated to the volume in the storage cluster
+	UsedMB int `yaml:"used_mb"`
 }
 
 // NetworkStat contains information about a single network interface present on
@@ -78,12 +104,30 @@ type Stat struct {
 	// proc/meminfo:MemFree + Active(file) + Inactive(file)
 	MemAvailableMB int `yaml:"mem_available_mb"`
 
+	// Total memory in MB reclaimed from the instances running on a CN
+	// by ballooning
+	MemReclaimedMB int `yaml:"mem_reclaimed_mb,omitempty"`
+
 	// Size of the CN/NN RootFS in MB
 	DiskTotalMB int `yaml:"disk_total_mb"`
 
 	// MBs available in the RootFS of the CN/NN
 	DiskAvailableMB int `yaml:"disk_available_mb"`
 
+	// Disk I/O operations per second the CN/NN can provide to instances.
+	// 0 if the node does not track disk I/O.
+	DiskIOPSTotal int `yaml:"disk_iops_total,omitempty"`
+
+	// Disk I/O operations per second not yet reserved by instances
+	DiskIOPSAvailable int `yaml:"disk_iops_available,omitempty"`
+
+	// Network bandwidth in Mbps the CN/NN can provide to instances.
+	// 0 if the node does not track network bandwidth.
+	NetMbpsTotal int `yaml:"net_mbps_total,omitempty"`
+
+	// Network bandwidth in Mbps not yet reserved by instances
+	NetMbpsAvailable int `yaml:"net_mbps_available,omitempty"`
+
 	// Load of CN/NN, taken from /proc/loadavg (Average over last minute
 	// reported
 	Load int `yaml:"load"`
@@ -143,6 +187,15 @@ const (
 	// ExitPaused is not currently used
 	ExitPaused = "exit_paused"
 
+	// Paused indicates that an instance has been frozen by a PAUSE
+	// command.  Its memory state is preserved until it is unpaused.
+	Paused = "paused"
+
+	// Migrating indicates that an instance is being live migrated by a
+	// MIGRATE command, or is waiting for its state on the target node of
+	// the migration.
+	Migrating = "migrating"
+
 	// Deleted indicates that an instance has been successfully deleted.
 	Deleted = "deleted"
 
@@ -30,6 +30,11 @@ type StopCmd struct {
 	// In this case the delete command should only delete the instance from
 	// the node to which it is sent and not the entire cluster.
 	Stop bool
+
+	// Sequence orders the commands sent for the instance.  A command whose
+	// sequence is not greater than the one of the last command received
+	// for the instance is stale and ignored.  Zero if not sequenced.
+	Sequence uint64 `yaml:"sequence,omitempty"`
 }
 
 // Stop represents the unmarshalled version of the contents of a SSNTP STOP
@@ -85,3 +85,20 @@ func TestDeleteMarshal(t *testing.T) {
 		t.Errorf("DELETE marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.DeleteYaml)
 	}
 }
+
+func TestDeleteSequenceMarshal(t *testing.T) {
+	var delete Delete
+	delete.Delete.InstanceUUID = testutil.InstanceUUID
+	delete.Delete.WorkloadAgentUUID = testutil.AgentUUID
+	delete.Delete.Stop = true
+	delete.Delete.Sequence = 2
+
+	y, err := yaml.Marshal(&delete)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.SequencedStopYaml {
+		t.Errorf("DELETE marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.SequencedStopYaml)
+	}
+}
@@ -26,6 +26,14 @@ type VolumeCmd struct {
 	// VolumeUUID is the UUID of the volume to attach.
 	VolumeUUID string `yaml:"volume_uuid"`
 
+	// Pool is the Ceph pool holding the volume.  It is empty for volumes
+	// held by the default pool.
+	Pool string `yaml:"pool,omitempty"`
+
+	// Key is the LUKS passphrase of the volume.  It is empty for volumes
+	// which are not encrypted.
+	Key string `yaml:"key,omitempty"`
+
 	// WorkloadAgentUUID identifies the node on which the instance is
 	// running.  This information is needed by the scheduler to route
 	// the command to the correct CN/NN.
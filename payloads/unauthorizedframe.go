@@ -0,0 +1,55 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package payloads
+
+// UnauthorizedFrameReason denotes the underlying reason why an SSNTP
+// server refused to process a frame.
+type UnauthorizedFrameReason string
+
+const (
+	// UnauthorizedRole indicates that none of the sender's SSNTP roles
+	// is allowed to send the rejected frame.
+	UnauthorizedRole UnauthorizedFrameReason = "unauthorized_role"
+)
+
+// ErrorUnauthorizedFrame represents the unmarshalled version of the contents
+// of a SSNTP ERROR frame whose type is set to ssntp.UnauthorizedFrame.
+type ErrorUnauthorizedFrame struct {
+	// NodeUUID is the UUID of the SSNTP client that sent the rejected frame.
+	NodeUUID string `yaml:"node_uuid"`
+
+	// Role is the SSNTP role of the client that sent the rejected frame.
+	Role string `yaml:"role"`
+
+	// Type is the SSNTP type of the rejected frame, e.g., COMMAND.
+	Type string `yaml:"type"`
+
+	// Operand is the SSNTP operand of the rejected frame, e.g., START.
+	Operand string `yaml:"operand"`
+
+	// Reason provides the reason why the frame was rejected.
+	Reason UnauthorizedFrameReason `yaml:"reason"`
+}
+
+func (r UnauthorizedFrameReason) String() string {
+	switch r {
+	case UnauthorizedRole:
+		return "Sender role is not authorized"
+	}
+
+	return ""
+}
@@ -0,0 +1,83 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/testutil"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestUnauthorizedFrameUnmarshal(t *testing.T) {
+	var error ErrorUnauthorizedFrame
+	err := yaml.Unmarshal([]byte(testutil.UnauthorizedFrameYaml), &error)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if error.NodeUUID != testutil.AgentUUID {
+		t.Error("Wrong Node UUID field")
+	}
+
+	if error.Role != "CNAgent" {
+		t.Error("Wrong Role field")
+	}
+
+	if error.Type != "COMMAND" {
+		t.Error("Wrong Type field")
+	}
+
+	if error.Operand != "EVACUATE" {
+		t.Error("Wrong Operand field")
+	}
+
+	if error.Reason != UnauthorizedRole {
+		t.Error("Wrong Error field")
+	}
+}
+
+func TestUnauthorizedFrameMarshal(t *testing.T) {
+	error := ErrorUnauthorizedFrame{
+		NodeUUID: testutil.AgentUUID,
+		Role:     "CNAgent",
+		Type:     "COMMAND",
+		Operand:  "EVACUATE",
+		Reason:   UnauthorizedRole,
+	}
+
+	y, err := yaml.Marshal(&error)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.UnauthorizedFrameYaml {
+		t.Errorf("UnauthorizedFrame marshalling failed\n[%s]\n vs\n[%s]",
+			string(y), testutil.UnauthorizedFrameYaml)
+	}
+}
+
+func TestUnauthorizedFrameString(t *testing.T) {
+	error := ErrorUnauthorizedFrame{
+		Reason: UnauthorizedRole,
+	}
+
+	if error.Reason.String() != "Sender role is not authorized" {
+		t.Errorf("Unexpected reason string \"%s\"", error.Reason.String())
+	}
+}
@@ -0,0 +1,37 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// WatchdogFiredEvent identifies an instance whose watchdog device fired and
+// the action that was taken as a result.
+type WatchdogFiredEvent struct {
+	// InstanceUUID is the UUID of the instance.
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// NodeUUID is the UUID of the node running the instance.
+	NodeUUID string `yaml:"node_uuid"`
+
+	// Action is the action applied to the instance.
+	Action WatchdogAction `yaml:"action"`
+}
+
+// EventWatchdogFired represents the unmarshalled version of the contents of
+// an SSNTP ssntp.WatchdogFired event.  This event is sent by ciao-launcher
+// when the guest of an instance stops servicing its watchdog device.
+type EventWatchdogFired struct {
+	WatchdogFired WatchdogFiredEvent `yaml:"watchdog_fired"`
+}
@@ -0,0 +1,77 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestWatchdogFiredUnmarshal(t *testing.T) {
+	var fired EventWatchdogFired
+	err := yaml.Unmarshal([]byte(testutil.WatchdogFiredYaml), &fired)
+	if err != nil {
+		t.Error(err)
+	}
+
+	event := fired.WatchdogFired
+	if event.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", event.InstanceUUID)
+	}
+
+	if event.NodeUUID != testutil.AgentUUID {
+		t.Errorf("Wrong node UUID field [%s]", event.NodeUUID)
+	}
+
+	if event.Action != WatchdogReset {
+		t.Errorf("Wrong action field [%s]", event.Action)
+	}
+}
+
+func TestWatchdogFiredMarshal(t *testing.T) {
+	var fired EventWatchdogFired
+
+	fired.WatchdogFired.InstanceUUID = testutil.InstanceUUID
+	fired.WatchdogFired.NodeUUID = testutil.AgentUUID
+	fired.WatchdogFired.Action = WatchdogReset
+
+	y, err := yaml.Marshal(&fired)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.WatchdogFiredYaml {
+		t.Errorf("WatchdogFired marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.WatchdogFiredYaml)
+	}
+}
+
+func TestWatchdogActionValid(t *testing.T) {
+	for _, a := range []WatchdogAction{WatchdogReset, WatchdogPowerOff, WatchdogNone} {
+		if !a.Valid() {
+			t.Errorf("Watchdog action %s should be valid", a)
+		}
+	}
+
+	for _, a := range []WatchdogAction{"", "shutdown"} {
+		if a.Valid() {
+			t.Errorf("Watchdog action %q should not be valid", a)
+		}
+	}
+}
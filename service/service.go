@@ -29,6 +29,10 @@ const PrivKey key = 0
 // tenant id which is being used in the API call
 const TenantIDKey key = 1
 
+// APITokenKey is the index of the context map which holds the ID of the
+// API token authenticating the API call, if any.
+const APITokenKey key = 2
+
 // GetPrivilege returns the value of PrivKey
 func GetPrivilege(ctx context.Context) bool {
 	privilege, ok := ctx.Value(PrivKey).(bool)
@@ -53,3 +57,15 @@ func GetTenantID(ctx context.Context) (string, error) {
 func SetTenantID(ctx context.Context, tenantID string) context.Context {
 	return context.WithValue(ctx, TenantIDKey, tenantID)
 }
+
+// GetAPIToken returns the value of APITokenKey and whether the API call
+// was authenticated with an API token
+func GetAPIToken(ctx context.Context) (string, bool) {
+	tokenID, ok := ctx.Value(APITokenKey).(string)
+	return tokenID, ok
+}
+
+// SetAPIToken sets the value of APITokenKey
+func SetAPIToken(ctx context.Context, tokenID string) context.Context {
+	return context.WithValue(ctx, APITokenKey, tokenID)
+}
@@ -0,0 +1,131 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"sync"
+
+	"github.com/ciao-project/ciao/payloads"
+	"gopkg.in/yaml.v2"
+)
+
+// FrameAuthorizationRule defines which SSNTP roles are allowed to send
+// a given SSNTP frame to the server.
+// An SSNTP server enforces authorization rules on every frame it receives,
+// on top of the certificate based role verification done at connection time.
+// Frames which operand is not covered by any rule are always authorized.
+type FrameAuthorizationRule struct {
+	// Operand is the SSNTP frame operand to which this rule applies.
+	Operand interface{}
+
+	// Roles is the bitmask of SSNTP roles allowed to send Operand.
+	// A client is authorized if it plays at least one of those roles.
+	Roles Role
+}
+
+type frameAuthorization struct {
+	sync.RWMutex
+	commandRoles map[Command]Role
+	statusRoles  map[Status]Role
+	errorRoles   map[Error]Role
+	eventRoles   map[Event]Role
+}
+
+func (a *frameAuthorization) init(rules []FrameAuthorizationRule) {
+	a.Lock()
+	defer a.Unlock()
+
+	a.commandRoles = make(map[Command]Role)
+	a.statusRoles = make(map[Status]Role)
+	a.errorRoles = make(map[Error]Role)
+	a.eventRoles = make(map[Event]Role)
+
+	for _, r := range rules {
+		switch op := r.Operand.(type) {
+		case Command:
+			a.commandRoles[op] |= r.Roles
+		case Status:
+			a.statusRoles[op] |= r.Roles
+		case Error:
+			a.errorRoles[op] |= r.Roles
+		case Event:
+			a.eventRoles[op] |= r.Roles
+		}
+	}
+}
+
+func (a *frameAuthorization) authorized(role Role, operand interface{}) bool {
+	var roles Role
+	var found bool
+
+	a.RLock()
+	defer a.RUnlock()
+
+	switch op := operand.(type) {
+	case Command:
+		roles, found = a.commandRoles[op]
+	case Status:
+		roles, found = a.statusRoles[op]
+	case Error:
+		roles, found = a.errorRoles[op]
+	case Event:
+		roles, found = a.eventRoles[op]
+	}
+
+	if found == false {
+		return true
+	}
+
+	return role&roles != UNKNOWN
+}
+
+func operandString(operand interface{}) string {
+	switch op := operand.(type) {
+	case Command:
+		return op.String()
+	case Status:
+		return op.String()
+	case Error:
+		return op.String()
+	case Event:
+		return op.String()
+	}
+
+	return ""
+}
+
+func (server *Server) rejectFrame(session *session, operand interface{}, frame *Frame) {
+	uuid := session.dest.String()
+	role := session.destRole
+
+	server.log.Errorf("AUDIT: Unauthorized %s %s frame from %s (%s)\n",
+		operandString(operand), frame.Type, uuid, role.String())
+
+	payload, err := yaml.Marshal(&payloads.ErrorUnauthorizedFrame{
+		NodeUUID: uuid,
+		Role:     role.String(),
+		Type:     frame.Type.String(),
+		Operand:  operandString(operand),
+		Reason:   payloads.UnauthorizedRole,
+	})
+	if err != nil {
+		server.log.Errorf("Could not marshal unauthorized frame payload: %s\n", err)
+		payload = nil
+	}
+
+	server.sendError(uuid, UnauthorizedFrame, payload, server.trace)
+}
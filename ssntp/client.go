@@ -19,7 +19,9 @@ package ssntp
 import (
 	"crypto/tls"
 	"fmt"
+	"io"
 	"math/rand"
+	"net"
 	"sync"
 	"time"
 
@@ -84,6 +86,14 @@ type Client struct {
 	trace *TraceConfig
 
 	configuration clusterConfiguration
+
+	reconnectJitter time.Duration
+
+	maxFrameSize int
+
+	features Feature
+
+	clock Clock
 }
 
 func (client *Client) processSSNTPFrame(frame *Frame) {
@@ -92,11 +102,14 @@ func (client *Client) processSSNTPFrame(frame *Frame) {
 	switch (Type)(frame.Type) {
 	case COMMAND:
 		if (Command)(frame.Operand) == CONFIGURE {
-			client.configuration.setConfiguration(frame.Payload)
+			client.setConfiguration(frame.Payload)
 		}
 		client.ntf.CommandNotify((Command)(frame.Operand), frame)
 	case STATUS:
 		client.ntf.StatusNotify((Status)(frame.Operand), frame)
+		if (Status)(frame.Operand) == REDIRECT {
+			client.redirect(frame.Payload)
+		}
 	case EVENT:
 		client.ntf.EventNotify((Event)(frame.Operand), frame)
 	case ERROR:
@@ -106,6 +119,83 @@ func (client *Client) processSSNTPFrame(frame *Frame) {
 	}
 }
 
+// redirect disconnects the client from a draining server for it to
+// reconnect to the server the REDIRECT payload points at. That server is
+// tried first on all subsequent reconnections.
+func (client *Client) redirect(payload []byte) {
+	var redirect payloads.Redirect
+
+	err := yaml.Unmarshal(payload, &redirect)
+	if err != nil || redirect.URI == "" {
+		client.log.Errorf("Invalid REDIRECT payload\n")
+		return
+	}
+
+	uri := client.serverURI(redirect.URI)
+
+	client.status.Lock()
+	defer client.status.Unlock()
+
+	if client.status.status == ssntpClosed {
+		return
+	}
+
+	client.preferURIs([]string{uri})
+
+	client.log.Infof("Redirected to %s\n", uri)
+	client.session.conn.Close()
+}
+
+// setConfiguration stores the latest cluster configuration payload. The
+// schedulers it lists are tried first, in order, on all subsequent
+// reconnections so that clients fail over to a standby scheduler.
+func (client *Client) setConfiguration(payload []byte) {
+	client.configuration.setConfiguration(payload)
+
+	var conf payloads.Configure
+	err := yaml.Unmarshal(payload, &conf)
+	if err != nil || len(conf.Configure.Scheduler.URIs) == 0 {
+		return
+	}
+
+	var uris []string
+	for _, uri := range conf.Configure.Scheduler.URIs {
+		uris = append(uris, client.serverURI(uri))
+	}
+
+	client.status.Lock()
+	client.preferURIs(uris)
+	client.status.Unlock()
+}
+
+// serverURI adds the client port to server URIs which do not have one.
+func (client *Client) serverURI(uri string) string {
+	if _, _, err := net.SplitHostPort(uri); err != nil {
+		return fmt.Sprintf("%s:%d", uri, client.port)
+	}
+
+	return uri
+}
+
+// preferURIs moves the preferred URIs, in order, to the front of the list
+// of server URIs the client tries when connecting. It must be called with
+// the status lock held.
+func (client *Client) preferURIs(preferred []string) {
+	seen := make(map[string]bool)
+	uris := make([]string, 0, len(preferred)+len(client.uris))
+
+	for _, list := range [][]string{preferred, client.uris} {
+		for _, u := range list {
+			if !seen[u] {
+				seen[u] = true
+				uris = append(uris, u)
+			}
+		}
+	}
+
+	client.uris = uris
+}
+
 func (client *Client) handleSSNTPServer() {
 	defer client.Close()
 
@@ -142,6 +232,10 @@ func (client *Client) handleSSNTPServer() {
 			go client.processSSNTPFrame(&frame)
 		}
 
+		if client.waitReconnectJitter() == false {
+			return
+		}
+
 		err := client.attemptDial()
 		if err != nil {
 			client.log.Errorf("%s", err)
@@ -150,6 +244,25 @@ func (client *Client) handleSSNTPServer() {
 	}
 }
 
+// waitReconnectJitter waits for a random delay before reconnecting, so that
+// all clients of a restarting server do not hit it at the same time.
+// It returns false if the client got closed while waiting.
+func (client *Client) waitReconnectJitter() bool {
+	if client.reconnectJitter <= 0 {
+		return true
+	}
+
+	delay := time.Duration(rand.Int63n(int64(client.reconnectJitter)))
+	client.log.Infof("Reconnecting in %v\n", delay)
+
+	select {
+	case <-client.closed:
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
+
 func (client *Client) sendConnect() (bool, error) {
 	var connected ConnectedFrame
 	client.log.Infof("Sending CONNECT\n")
@@ -184,7 +297,13 @@ func (client *Client) sendConnect() (bool, error) {
 		return true, fmt.Errorf("SSNTP Client: Unknown frame type %d", connected.Type)
 	}
 
+	if connected.Major&majorMask != Major {
+		client.SendError(ConnectionFailure, nil)
+		return false, fmt.Errorf("SSNTP Client: Unsupported SSNTP major version %d", connected.Major&majorMask)
+	}
+
 	client.session.setDest(connected.Source[:16])
+	client.session.features = negotiateFeatures(connected.Features, client.features)
 
 	oidFound, err := verifyRole(client.session.conn, connected.Role)
 	if oidFound == false {
@@ -197,7 +316,7 @@ func (client *Client) sendConnect() (bool, error) {
 	client.status.status = ssntpConnected
 	client.status.Unlock()
 
-	client.configuration.setConfiguration(connected.Payload)
+	client.setConfiguration(connected.Payload)
 
 	client.log.Infof("Done with connection\n")
 
@@ -207,7 +326,11 @@ func (client *Client) sendConnect() (bool, error) {
 func (client *Client) attemptDial() error {
 	delays := []int64{5, 10, 20, 40}
 
-	if len(client.uris) == 0 {
+	client.status.Lock()
+	uris := client.uris
+	client.status.Unlock()
+
+	if len(uris) == 0 {
 		return fmt.Errorf("No servers to connect to")
 	}
 
@@ -221,7 +344,7 @@ func (client *Client) attemptDial() error {
 	for {
 	URILoop:
 		for d := 0; ; d++ {
-			for _, uri := range client.uris {
+			for _, uri := range uris {
 				client.log.Infof("%s connecting to %s\n", client.uuid, uri)
 				conn, err := tls.Dial(client.transport, uri, client.tls)
 
@@ -234,7 +357,8 @@ func (client *Client) attemptDial() error {
 
 				if err == nil {
 					client.log.Infof("Connected\n")
-					session := newSession(&client.uuid, client.role, 0, conn)
+					session := newSession(&client.uuid, client.role, 0, conn, client.maxFrameSize, client.clock)
+					session.features = client.features
 					client.session = session
 
 					break URILoop
@@ -243,15 +367,18 @@ func (client *Client) attemptDial() error {
 				client.log.Errorf("Could not connect to %s (%s)\n", uri, err)
 			}
 
-			delay := r.Int63n(delays[d%len(delays)])
-			delay++ // Avoid waiting for 0 seconds
-			client.log.Errorf("All server URIs failed - retrying in %d seconds\n", delay)
+			// Use a millisecond granularity so that clients
+			// retrying together do not end up in the same
+			// second wide bucket.
+			maxDelay := delays[d%len(delays)] * int64(time.Second/time.Millisecond)
+			delay := time.Duration(r.Int63n(maxDelay)+1000) * time.Millisecond
+			client.log.Errorf("All server URIs failed - retrying in %v\n", delay)
 
 			// Wait for delay before reconnecting or return if the client is closed
 			select {
 			case <-client.closed:
 				return fmt.Errorf("Connection closed")
-			case <-time.After(time.Duration(delay) * time.Second):
+			case <-time.After(delay):
 				break
 			}
 
@@ -326,6 +453,10 @@ func (client *Client) Dial(config *Config, ntf ClientNotifier) error {
 	client.lUUID, client.uuid = config.configUUID(client.role)
 	client.port = config.port()
 	client.transport = config.transport()
+	client.reconnectJitter = config.reconnectJitter()
+	client.maxFrameSize = config.maxFrameSize()
+	client.features = config.features()
+	client.clock = config.clock()
 	client.uris = config.ConfigURIs(client.uris, client.port)
 
 	client.trace = config.Trace
@@ -449,6 +580,47 @@ func (client *Client) SendEvent(event Event, payload []byte) (int, error) {
 	return client.sendEvent(event, payload, client.trace)
 }
 
+func (client *Client) sendStream(payload io.Reader, newFrame func(session *session, chunk []byte) *Frame) (int, error) {
+	client.status.Lock()
+	if client.status.status == ssntpClosed {
+		client.status.Unlock()
+		return -1, fmt.Errorf("sendStream: Client not connected")
+	}
+	client.status.Unlock()
+
+	session := client.session
+	return session.writeStream(payload, func(chunk []byte) *Frame {
+		return newFrame(session, chunk)
+	})
+}
+
+// SendCommandStream sends a specific command to the SSNTP server, with a
+// payload read from payload until EOF. The payload is split into as many
+// frames as needed for them not to exceed the maximum frame size, and can
+// be rebuilt by the receiver with a StreamAssembler.
+// It returns the number of payload bytes sent.
+func (client *Client) SendCommandStream(cmd Command, payload io.Reader) (int, error) {
+	return client.sendStream(payload, func(session *session, chunk []byte) *Frame {
+		return session.commandFrame(cmd, chunk, client.trace)
+	})
+}
+
+// SendStatusStream sends a specific status to the SSNTP server, with a
+// payload read from payload until EOF. See SendCommandStream.
+func (client *Client) SendStatusStream(status Status, payload io.Reader) (int, error) {
+	return client.sendStream(payload, func(session *session, chunk []byte) *Frame {
+		return session.statusFrame(status, chunk, client.trace)
+	})
+}
+
+// SendEventStream sends a specific event to the SSNTP server, with a
+// payload read from payload until EOF. See SendCommandStream.
+func (client *Client) SendEventStream(event Event, payload io.Reader) (int, error) {
+	return client.sendStream(payload, func(session *session, chunk []byte) *Frame {
+		return session.eventFrame(event, chunk, client.trace)
+	})
+}
+
 // SendError sends an error back to the SSNTP server.
 // This is just for notification purposes, to let e.g. the server know that
 // it sent an unexpected frame.
@@ -492,6 +664,17 @@ func (client *Client) UUID() string {
 	return client.uuid.String()
 }
 
+// Features returns the optional features negotiated with the SSNTP server
+// the client is connected to.
+func (client *Client) Features() Feature {
+	session := client.session
+	if session == nil {
+		return 0
+	}
+
+	return session.features
+}
+
 // ClusterConfiguration returns the latest cluster configuration
 // payload a client received. Clients should use that payload to
 // configure themselves based on the information provided to them
@@ -0,0 +1,84 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrFeatureNotSupported is returned when sending frames relying on an
+// optional SSNTP feature that was not negotiated with the peer.
+var ErrFeatureNotSupported = errors.New("SSNTP feature not supported by peer")
+
+// Feature is a bitmask of optional SSNTP protocol features.
+// Clients offer the features they support in their CONNECT frame and
+// servers reply with the features both sides support in their CONNECTED
+// frame. Optional features are only used when negotiated, so that peers
+// which do not know about a feature, e.g. peers running an older SSNTP
+// minor version, keep interoperating.
+type Feature uint32
+
+const (
+	// FeatureStreams is the support for payloads streamed as a
+	// sequence of chunk frames.
+	FeatureStreams Feature = 1 << iota
+)
+
+// SupportedFeatures is the set of optional features implemented by this
+// SSNTP package.
+const SupportedFeatures = FeatureStreams
+
+var featureNames = []struct {
+	feature Feature
+	name    string
+}{
+	{FeatureStreams, "streams"},
+}
+
+func (features Feature) String() string {
+	var names []string
+
+	for _, f := range featureNames {
+		if features&f.feature != 0 {
+			names = append(names, f.name)
+			features &^= f.feature
+		}
+	}
+
+	if features != 0 {
+		names = append(names, fmt.Sprintf("0x%x", uint32(features)))
+	}
+
+	if len(names) == 0 {
+		return "none"
+	}
+
+	return strings.Join(names, ",")
+}
+
+// Has tells if all the features of f are part of features.
+func (features Feature) Has(f Feature) bool {
+	return features&f == f
+}
+
+// negotiateFeatures returns the features both sides of a connection
+// support.
+func negotiateFeatures(offered Feature, supported Feature) Feature {
+	return offered & supported & SupportedFeatures
+}
@@ -17,6 +17,8 @@
 package ssntp
 
 import (
+	"fmt"
+	"strings"
 	"sync"
 )
 
@@ -126,6 +128,11 @@ type FrameForwardRule struct {
 }
 
 type frameForward struct {
+	// staticRules are the rules from the server configuration, while
+	// configuredRules are set at runtime and can not override them.
+	staticRules     []FrameForwardRule
+	configuredRules []FrameForwardRule
+
 	forwardRules       []FrameForwardRule
 	forwardMutex       sync.RWMutex
 	forwardCommandDest map[Command][]*session
@@ -137,10 +144,59 @@ type frameForward struct {
 	forwardStatusFunc  map[Status]StatusForwarder
 	forwardErrorFunc   map[Error]ErrorForwarder
 	forwardEventFunc   map[Event]EventForwarder
+
+	// sessions are all the sessions forwarding destinations are
+	// looked up from.
+	sessions []*session
+}
+
+func (f *frameForward) init(rules []FrameForwardRule) error {
+	f.forwardMutex.Lock()
+	defer f.forwardMutex.Unlock()
+
+	f.staticRules = rules
+
+	return f.setRules()
+}
+
+func (f *frameForward) setConfiguredRules(rules []FrameForwardRule) error {
+	f.forwardMutex.Lock()
+	defer f.forwardMutex.Unlock()
+
+	f.configuredRules = rules
+
+	return f.setRules()
+}
+
+func (f *frameForward) isStaticOperand(operand interface{}) bool {
+	for _, r := range f.staticRules {
+		if r.Operand == operand {
+			return true
+		}
+	}
+
+	return false
 }
 
-func (f *frameForward) init(rules []FrameForwardRule) {
+// setRules merges the static and the configured rules and rebuilds the
+// forwarding tables from them. Configured rules for an operand already
+// covered by a static rule are ignored.
+// The caller must hold forwardMutex.
+func (f *frameForward) setRules() error {
 	/* TODO Validate rules, e.g. look for duplicates */
+	var ignored []string
+
+	rules := append([]FrameForwardRule{}, f.staticRules...)
+	for _, r := range f.configuredRules {
+		if f.isStaticOperand(r.Operand) {
+			ignored = append(ignored, operandString(r.Operand))
+			continue
+		}
+
+		rules = append(rules, r)
+	}
+
+	f.forwardRules = rules
 	f.forwardCommandDest = make(map[Command][]*session)
 	f.forwardErrorDest = make(map[Error][]*session)
 	f.forwardEventDest = make(map[Event][]*session)
@@ -150,8 +206,6 @@ func (f *frameForward) init(rules []FrameForwardRule) {
 	f.forwardErrorFunc = make(map[Error]ErrorForwarder)
 	f.forwardEventFunc = make(map[Event]EventForwarder)
 
-	f.forwardMutex.Lock()
-
 	for _, r := range rules {
 		switch op := r.Operand.(type) {
 		case Command:
@@ -173,12 +227,29 @@ func (f *frameForward) init(rules []FrameForwardRule) {
 		}
 	}
 
-	f.forwardMutex.Unlock()
+	for _, s := range f.sessions {
+		f.addSessionDestinations(s)
+	}
+
+	if ignored != nil {
+		return fmt.Errorf("Forwarding rules already defined for %s",
+			strings.Join(ignored, ", "))
+	}
+
+	return nil
 }
 
 func (f *frameForward) addForwardDestination(session *session) {
 	f.forwardMutex.Lock()
 
+	f.sessions = append(f.sessions, session)
+	f.addSessionDestinations(session)
+
+	f.forwardMutex.Unlock()
+}
+
+// The caller must hold forwardMutex.
+func (f *frameForward) addSessionDestinations(session *session) {
 	for _, r := range f.forwardRules {
 		if r.Dest == UNKNOWN {
 			continue
@@ -203,8 +274,6 @@ func (f *frameForward) addForwardDestination(session *session) {
 			}
 		}
 	}
-
-	f.forwardMutex.Unlock()
 }
 
 func (f *frameForward) deleteForwardDestination(dest *session) {
@@ -212,6 +281,13 @@ func (f *frameForward) deleteForwardDestination(dest *session) {
 
 	f.forwardMutex.Lock()
 
+	for i, s := range f.sessions {
+		if s == dest {
+			f.sessions = append(f.sessions[:i], f.sessions[i+1:]...)
+			break
+		}
+	}
+
 	for _, r := range f.forwardRules {
 		switch op := r.Operand.(type) {
 		case Command:
@@ -17,7 +17,10 @@
 package ssntp
 
 import (
+	"bytes"
+	"encoding/gob"
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/ciao-project/ciao/payloads"
@@ -60,6 +63,21 @@ type FrameTrace struct {
 	Path           []Node
 }
 
+// FrameChunk identifies the part of a streamed payload carried by a frame.
+// Payloads too large to fit in a single frame are streamed as a sequence
+// of frames, each carrying a chunk of the payload.
+type FrameChunk struct {
+	// Stream identifies the stream amongst the ones sent by the
+	// frame origin.
+	Stream uint32
+
+	// Index is the position of the chunk in the stream, starting at 0.
+	Index uint32
+
+	// Last is set for the last chunk of the stream.
+	Last bool
+}
+
 // Frame represents an SSNTP frame structure.
 type Frame struct {
 	Major   uint8
@@ -76,6 +94,10 @@ type Frame struct {
 	PayloadLength uint32
 	Trace         *FrameTrace
 	Payload       []byte
+
+	// Chunk is only set for frames carrying a chunk of a streamed
+	// payload.
+	Chunk *FrameChunk
 }
 
 // ConnectFrame is the SSNTP connection frame structure.
@@ -87,6 +109,9 @@ type ConnectFrame struct {
 	Role        Role
 	Source      []byte
 	Destination []byte
+
+	// Features are the optional features the client supports.
+	Features Feature
 }
 
 // ConnectedFrame is the SSNTP connected frame structure.
@@ -100,6 +125,10 @@ type ConnectedFrame struct {
 	Destination   []byte
 	PayloadLength uint32
 	Payload       []byte
+
+	// Features are the optional features negotiated for the
+	// connection, i.e. supported by both the client and the server.
+	Features Feature
 }
 
 const majorMask = 0x7f
@@ -133,6 +162,21 @@ func (f Frame) GetMajor() uint8 {
 	return f.Major & majorMask
 }
 
+func (f Frame) operand() interface{} {
+	switch f.Type {
+	case COMMAND:
+		return (Command)(f.Operand)
+	case STATUS:
+		return (Status)(f.Operand)
+	case EVENT:
+		return (Event)(f.Operand)
+	case ERROR:
+		return (Error)(f.Operand)
+	}
+
+	return nil
+}
+
 func (f Frame) String() string {
 	var node uuid.UUID
 	var op string
@@ -191,8 +235,8 @@ func (f ConnectFrame) String() string {
 	copy(src[:], f.Source[:16])
 	copy(dest[:], f.Destination[:16])
 
-	return fmt.Sprintf("\tMajor %d\n\tMinor %d\n\tType %s\n\tOp %s\n\tRole %s\n\tSource %s\n\tDestination %s\n",
-		f.Major, f.Minor, (Type)(f.Type), op, &f.Role, src, dest)
+	return fmt.Sprintf("\tMajor %d\n\tMinor %d\n\tType %s\n\tOp %s\n\tRole %s\n\tSource %s\n\tDestination %s\n\tFeatures %s\n",
+		f.Major, f.Minor, (Type)(f.Type), op, &f.Role, src, dest, f.Features)
 }
 
 func (f ConnectedFrame) String() string {
@@ -212,8 +256,8 @@ func (f ConnectedFrame) String() string {
 	copy(src[:], f.Source[:16])
 	copy(dest[:], f.Destination[:16])
 
-	return fmt.Sprintf("\tMajor %d\n\tMinor %d\n\tType %s\n\tOp %s\n\tRole %s\n\tSource %s\n\tDestination %s\n",
-		f.Major, f.Minor, (Type)(f.Type), op, &f.Role, src, dest)
+	return fmt.Sprintf("\tMajor %d\n\tMinor %d\n\tType %s\n\tOp %s\n\tRole %s\n\tSource %s\n\tDestination %s\n\tFeatures %s\n",
+		f.Major, f.Minor, (Type)(f.Type), op, &f.Role, src, dest, f.Features)
 }
 
 func (f *Frame) addPathNode(session *session) {
@@ -245,11 +289,18 @@ func (f Frame) Duration() (time.Duration, error) {
 // last frame receiver. It provides information to build the
 // complete duration of the operation related to an SSNTP frame.
 func (f *Frame) SetEndStamp() {
+	f.SetEndStampAt(time.Now())
+}
+
+// SetEndStampAt adds t as the final timestamp of an SSNTP frame.
+// It is used instead of SetEndStamp by the SSNTP nodes that do
+// not timestamp frames with the system clock.
+func (f *Frame) SetEndStampAt(t time.Time) {
 	if f.PathTrace() != true {
 		return
 	}
 
-	f.Trace.EndTimestamp = time.Now()
+	f.Trace.EndTimestamp = t
 }
 
 // DumpTrace builds SSNTP frame tracing data into a FrameTrace
@@ -299,3 +350,97 @@ func (f Frame) DumpTrace() (*payloads.FrameTrace, error) {
 
 	return &s, nil
 }
+
+func checkFrameType(frame interface{}) error {
+	switch frame.(type) {
+	case *Frame, *ConnectFrame, *ConnectedFrame:
+		return nil
+	}
+
+	return fmt.Errorf("Unsupported frame type %T", frame)
+}
+
+func checkNodeUUID(name string, id []byte) error {
+	if len(id) != len(uuid.UUID{}) {
+		return fmt.Errorf("Invalid %s UUID length %d", name, len(id))
+	}
+
+	return nil
+}
+
+// validateFrame checks that a received frame can be safely processed.
+func validateFrame(frame interface{}) error {
+	switch f := frame.(type) {
+	case *Frame:
+		if f.Trace == nil {
+			return nil
+		}
+
+		if int(f.Trace.PathLength) != len(f.Trace.Path) {
+			return fmt.Errorf("Invalid trace path length %d for %d nodes",
+				f.Trace.PathLength, len(f.Trace.Path))
+		}
+
+		// receivers add themselves to the path.
+		if f.Trace.PathLength == math.MaxUint8 {
+			return fmt.Errorf("Trace path too long")
+		}
+
+		for _, n := range f.Trace.Path {
+			if err := checkNodeUUID("trace node", n.UUID); err != nil {
+				return err
+			}
+		}
+	case *ConnectFrame:
+		if err := checkNodeUUID("source", f.Source); err != nil {
+			return err
+		}
+		return checkNodeUUID("destination", f.Destination)
+	case *ConnectedFrame:
+		if err := checkNodeUUID("source", f.Source); err != nil {
+			return err
+		}
+		return checkNodeUUID("destination", f.Destination)
+	}
+
+	return nil
+}
+
+// EncodeFrame returns the SSNTP wire format of a frame, which must be a
+// *Frame, a *ConnectFrame or a *ConnectedFrame.
+// SSNTP frames are gob encoded and a session only describes each frame type
+// once, before the first frame of that type.  The encoding returned is the one
+// of such a first frame and thus includes the frame type description.
+func EncodeFrame(frame interface{}) ([]byte, error) {
+	err := checkFrameType(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DecodeFrame decodes the SSNTP wire format of a frame, as returned by
+// EncodeFrame, into frame which must be a *Frame, a *ConnectFrame or
+// a *ConnectedFrame.
+// An error is returned if data can not be decoded or if the decoded frame
+// is malformed.
+func DecodeFrame(data []byte, frame interface{}) error {
+	err := checkFrameType(frame)
+	if err != nil {
+		return err
+	}
+
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(frame)
+	if err != nil {
+		return err
+	}
+
+	return validateFrame(frame)
+}
@@ -0,0 +1,363 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp_test
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+	"time"
+
+	. "github.com/ciao-project/ciao/ssntp"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+var (
+	vectorOrigin = uuid.UUID{0x3b, 0x1f, 0x66, 0x4d, 0x2e, 0x2b, 0x4a, 0x0c,
+		0x9c, 0x1e, 0x5d, 0x8a, 0x7f, 0x44, 0x61, 0x0b}
+	vectorSource      = vectorOrigin[:]
+	vectorDestination = make([]byte, 16)
+	vectorStart       = time.Date(2017, time.March, 14, 15, 9, 26, 535897932, time.UTC)
+)
+
+// frameVector is a frame along with its wire format, as sent by a SSNTP
+// session.  The wire format must not change unless the SSNTP major
+// version is bumped, as peers running different ciao versions would no
+// longer understand each other.
+//
+// Frames are gob encoded and gob assigns type identifiers per process, so
+// an encoding may legitimately differ from the vector.  The vectors are
+// thus only checked by decoding them.
+type frameVector struct {
+	name  string
+	frame interface{}
+	wire  string
+}
+
+var frameVectors = []frameVector{
+	{
+		name: "command",
+		frame: &Frame{
+			Major:         Major,
+			Minor:         1,
+			Type:          COMMAND,
+			Operand:       uint8(START),
+			Origin:        vectorOrigin,
+			PayloadLength: 6,
+			Payload:       []byte("start:"),
+		},
+		wire: "707f030101054672616d6501ff8000010801054d616a6f7201060001054d696e" +
+			"6f7201060001045479706501060001074f706572616e6401060001064f726967" +
+			"696e01ff8200010d5061796c6f61644c656e6774680106000105547261636501" +
+			"ff840001075061796c6f6164010a00000014ff81010101045555494401ff8200" +
+			"01060120000061ff830301010a4672616d65547261636501ff8400010501054c" +
+			"6162656c010a00010e537461727454696d657374616d7001ff8600010c456e64" +
+			"54696d657374616d7001ff8600010a506174684c656e67746801060001045061" +
+			"746801ff8a00000010ff850501010454696d6501ff860000001bff890201010c" +
+			"5b5d73736e74702e4e6f646501ff8a0001ff88000046ff87030101044e6f6465" +
+			"01ff88000104010455554944010a000104526f6c65010600010b547854696d65" +
+			"7374616d7001ff8600010b527854696d657374616d7001ff8600000025ff8002" +
+			"01020101103b1f664d2e2b4a0cff9c1e5dff8a7f44610b010602067374617274" +
+			"3a00",
+	},
+	{
+		name: "traced event",
+		frame: &Frame{
+			Major:   Major | 1<<7,
+			Minor:   1,
+			Type:    EVENT,
+			Operand: uint8(InstanceDeleted),
+			Origin:  vectorOrigin,
+			Trace: &FrameTrace{
+				Label:          []byte("vector"),
+				StartTimestamp: vectorStart,
+				PathLength:     1,
+				Path: []Node{
+					{
+						UUID:        vectorSource,
+						Role:        AGENT,
+						TxTimestamp: vectorStart.Add(time.Second),
+					},
+				},
+			},
+		},
+		wire: "707f030101054672616d6501ff8000010801054d616a6f7201060001054d696e" +
+			"6f7201060001045479706501060001074f706572616e6401060001064f726967" +
+			"696e01ff8200010d5061796c6f61644c656e6774680106000105547261636501" +
+			"ff840001075061796c6f6164010a00000014ff81010101045555494401ff8200" +
+			"01060120000061ff830301010a4672616d65547261636501ff8400010501054c" +
+			"6162656c010a00010e537461727454696d657374616d7001ff8600010c456e64" +
+			"54696d657374616d7001ff8600010a506174684c656e67746801060001045061" +
+			"746801ff8a00000010ff850501010454696d6501ff860000001bff890201010c" +
+			"5b5d73736e74702e4e6f646501ff8a0001ff88000046ff87030101044e6f6465" +
+			"01ff88000104010455554944010a000104526f6c65010600010b547854696d65" +
+			"7374616d7001ff8600010b527854696d657374616d7001ff8600000065ff8001" +
+			"ff8001010103010201103b1f664d2e2b4a0cff9c1e5dff8a7f44610b02010676" +
+			"6563746f72010f010000000ed059fea61ff1274cffff0201010101103b1f664d" +
+			"2e2b4a0c9c1e5d8a7f44610b0104010f010000000ed059fea71ff1274cffff00" +
+			"0000",
+	},
+	{
+		name: "stream chunk",
+		frame: &Frame{
+			Major:         Major,
+			Minor:         1,
+			Type:          STATUS,
+			Operand:       uint8(READY),
+			Origin:        vectorOrigin,
+			PayloadLength: 4,
+			Payload:       []byte("log:"),
+			Chunk: &FrameChunk{
+				Stream: 7,
+				Index:  2,
+				Last:   true,
+			},
+		},
+		wire: "7b7f030101054672616d6501ff8000010901054d616a6f7201060001054d696e" +
+			"6f7201060001045479706501060001074f706572616e6401060001064f726967" +
+			"696e01ff8200010d5061796c6f61644c656e6774680106000105547261636501" +
+			"ff840001075061796c6f6164010a0001054368756e6b01ff8c00000014ff8101" +
+			"0101045555494401ff820001060120000061ff830301010a4672616d65547261" +
+			"636501ff8400010501054c6162656c010a00010e537461727454696d65737461" +
+			"6d7001ff8600010c456e6454696d657374616d7001ff8600010a506174684c65" +
+			"6e67746801060001045061746801ff8a00000010ff850501010454696d6501ff" +
+			"860000001bff890201010c5b5d73736e74702e4e6f646501ff8a0001ff880000" +
+			"46ff87030101044e6f646501ff88000104010455554944010a000104526f6c65" +
+			"010600010b547854696d657374616d7001ff8600010b527854696d657374616d" +
+			"7001ff8600000036ff8b0301010a4672616d654368756e6b01ff8c0001030106" +
+			"53747265616d0106000105496e64657801060001044c61737401020000002dff" +
+			"8002010101010101103b1f664d2e2b4a0cff9c1e5dff8a7f44610b010402046c" +
+			"6f673a010107010201010000",
+	},
+	{
+		name: "connect",
+		frame: &ConnectFrame{
+			Major:       Major,
+			Minor:       1,
+			Type:        COMMAND,
+			Operand:     uint8(CONNECT),
+			Role:        AGENT,
+			Source:      vectorSource,
+			Destination: vectorDestination,
+		},
+		wire: "67ff8b0301010c436f6e6e6563744672616d6501ff8c00010701054d616a6f72" +
+			"01060001054d696e6f7201060001045479706501060001074f706572616e6401" +
+			"06000104526f6c650106000106536f75726365010a00010b44657374696e6174" +
+			"696f6e010a0000002bff8c0201030401103b1f664d2e2b4a0c9c1e5d8a7f4461" +
+			"0b01100000000000000000000000000000000000",
+	},
+	{
+		name: "connected",
+		frame: &ConnectedFrame{
+			Major:         Major,
+			Minor:         1,
+			Type:          STATUS,
+			Operand:       uint8(CONNECTED),
+			Role:          SERVER,
+			Source:        vectorDestination,
+			Destination:   vectorSource,
+			PayloadLength: 10,
+			Payload:       []byte("configure:"),
+		},
+		wire: "ff87ff8d0301010e436f6e6e65637465644672616d6501ff8e00010901054d61" +
+			"6a6f7201060001054d696e6f7201060001045479706501060001074f70657261" +
+			"6e640106000104526f6c650106000106536f75726365010a00010b4465737469" +
+			"6e6174696f6e010a00010d5061796c6f61644c656e6774680106000107506179" +
+			"6c6f6164010a0000003bff8e0201010102010110000000000000000000000000" +
+			"0000000001103b1f664d2e2b4a0c9c1e5d8a7f44610b010a010a636f6e666967" +
+			"7572653a00",
+	},
+	{
+		name: "connect with features",
+		frame: &ConnectFrame{
+			Major:       Major,
+			Minor:       2,
+			Type:        COMMAND,
+			Operand:     uint8(CONNECT),
+			Role:        AGENT,
+			Source:      vectorSource,
+			Destination: vectorDestination,
+			Features:    FeatureStreams,
+		},
+		wire: "737f0301010c436f6e6e6563744672616d6501ff8000010801054d616a6f7201" +
+			"060001054d696e6f7201060001045479706501060001074f706572616e640106" +
+			"000104526f6c650106000106536f75726365010a00010b44657374696e617469" +
+			"6f6e010a000108466561747572657301060000002dff800202030401103b1f66" +
+			"4d2e2b4a0c9c1e5d8a7f44610b01100000000000000000000000000000000001" +
+			"0100",
+	},
+	{
+		name: "connected with features",
+		frame: &ConnectedFrame{
+			Major:         Major,
+			Minor:         2,
+			Type:          STATUS,
+			Operand:       uint8(CONNECTED),
+			Role:          SERVER,
+			Source:        vectorDestination,
+			Destination:   vectorSource,
+			PayloadLength: 10,
+			Payload:       []byte("configure:"),
+			Features:      FeatureStreams,
+		},
+		wire: "ff94ff810301010e436f6e6e65637465644672616d6501ff8200010a01054d61" +
+			"6a6f7201060001054d696e6f7201060001045479706501060001074f70657261" +
+			"6e640106000104526f6c650106000106536f75726365010a00010b4465737469" +
+			"6e6174696f6e010a00010d5061796c6f61644c656e6774680106000107506179" +
+			"6c6f6164010a000108466561747572657301060000003dff8202020101020101" +
+			"100000000000000000000000000000000001103b1f664d2e2b4a0c9c1e5d8a7f" +
+			"44610b010a010a636f6e6669677572653a010100",
+	},
+}
+
+func newFrameOf(frame interface{}) interface{} {
+	return reflect.New(reflect.TypeOf(frame).Elem()).Interface()
+}
+
+// Test that the wire format of SSNTP frames has not changed
+//
+// Decodes frames from their wire format as sent by SSNTP sessions and
+// checks that they match the expected frames.
+//
+// Test is expected to pass.
+func TestFrameVectors(t *testing.T) {
+	for _, v := range frameVectors {
+		data, err := hex.DecodeString(v.wire)
+		if err != nil {
+			t.Fatalf("%s: invalid vector: %v", v.name, err)
+		}
+
+		frame := newFrameOf(v.frame)
+		err = DecodeFrame(data, frame)
+		if err != nil {
+			t.Errorf("%s: unable to decode frame: %v", v.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(frame, v.frame) {
+			t.Errorf("%s: expected %+v, got %+v", v.name, v.frame, frame)
+		}
+	}
+}
+
+// Test SSNTP frames encoding and decoding
+//
+// Encodes frames with EncodeFrame, decodes them with DecodeFrame and
+// checks that the decoded frames match the original ones.
+//
+// Test is expected to pass.
+func TestFrameRoundTrip(t *testing.T) {
+	for _, v := range frameVectors {
+		data, err := EncodeFrame(v.frame)
+		if err != nil {
+			t.Errorf("%s: unable to encode frame: %v", v.name, err)
+			continue
+		}
+
+		frame := newFrameOf(v.frame)
+		err = DecodeFrame(data, frame)
+		if err != nil {
+			t.Errorf("%s: unable to decode frame: %v", v.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(frame, v.frame) {
+			t.Errorf("%s: expected %+v, got %+v", v.name, v.frame, frame)
+		}
+	}
+}
+
+// Test that malformed SSNTP frames are rejected
+//
+// Encodes frames that SSNTP sessions would not be able to process and
+// checks that they can not be decoded, as well as data that is not a frame.
+//
+// Test is expected to pass.
+func TestDecodeInvalidFrame(t *testing.T) {
+	invalidFrames := []struct {
+		name  string
+		frame interface{}
+	}{
+		{
+			name: "trace path length",
+			frame: &Frame{
+				Major: Major | 1<<7,
+				Type:  EVENT,
+				Trace: &FrameTrace{
+					PathLength: 2,
+					Path:       []Node{{UUID: vectorSource}},
+				},
+			},
+		},
+		{
+			name: "trace node UUID",
+			frame: &Frame{
+				Major: Major | 1<<7,
+				Type:  EVENT,
+				Trace: &FrameTrace{
+					PathLength: 1,
+					Path:       []Node{{UUID: vectorSource[:4]}},
+				},
+			},
+		},
+		{
+			name: "connect source",
+			frame: &ConnectFrame{
+				Type:        COMMAND,
+				Operand:     uint8(CONNECT),
+				Source:      vectorSource[:15],
+				Destination: vectorDestination,
+			},
+		},
+		{
+			name: "connected destination",
+			frame: &ConnectedFrame{
+				Type:    STATUS,
+				Operand: uint8(CONNECTED),
+				Source:  vectorDestination,
+			},
+		},
+	}
+
+	for _, f := range invalidFrames {
+		data, err := EncodeFrame(f.frame)
+		if err != nil {
+			t.Fatalf("%s: unable to encode frame: %v", f.name, err)
+		}
+
+		err = DecodeFrame(data, newFrameOf(f.frame))
+		if err == nil {
+			t.Errorf("%s: malformed frame decoded", f.name)
+		}
+	}
+
+	var frame Frame
+	err := DecodeFrame([]byte("not a frame"), &frame)
+	if err == nil {
+		t.Error("Garbage decoded as a frame")
+	}
+
+	_, err = EncodeFrame(Frame{})
+	if err == nil {
+		t.Error("Frame value encoded")
+	}
+
+	err = DecodeFrame(nil, &Node{})
+	if err == nil {
+		t.Error("Decoded into an unsupported type")
+	}
+}
@@ -0,0 +1,63 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// +build gofuzz
+
+package ssntp
+
+import (
+	"bytes"
+	"reflect"
+)
+
+// Fuzz is the go-fuzz entry point for the SSNTP frame decoder.  It checks
+// that decoding arbitrary data does not panic and that the frames which can
+// be decoded survive an encoding round trip.
+func Fuzz(data []byte) int {
+	ret := 0
+
+	for _, empty := range []interface{}{&Frame{}, &ConnectFrame{}, &ConnectedFrame{}} {
+		frameType := reflect.TypeOf(empty).Elem()
+		frame := reflect.New(frameType).Interface()
+		if DecodeFrame(data, frame) != nil {
+			continue
+		}
+
+		encoded, err := EncodeFrame(frame)
+		if err != nil {
+			panic(err)
+		}
+
+		decoded := reflect.New(frameType).Interface()
+		err = DecodeFrame(encoded, decoded)
+		if err != nil {
+			panic(err)
+		}
+
+		reencoded, err := EncodeFrame(decoded)
+		if err != nil {
+			panic(err)
+		}
+
+		if !bytes.Equal(encoded, reencoded) {
+			panic("frame changed by encoding round trip")
+		}
+
+		ret = 1
+	}
+
+	return ret
+}
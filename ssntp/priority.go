@@ -0,0 +1,127 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"sync"
+)
+
+// Priority is the priority class of an outbound frame. Frames waiting to be
+// sent over a session are sent in decreasing priority order, and in the
+// order they were queued within a priority class, so that bulk frames never
+// delay control commands.
+type Priority uint8
+
+const (
+	// PriorityBulk is the priority of STATS commands, TraceReport events
+	// and stream chunks.
+	PriorityBulk Priority = iota
+
+	// PriorityNormal is the priority of STATUS, EVENT and ERROR frames.
+	PriorityNormal
+
+	// PriorityControl is the priority of connection frames and of all
+	// commands but STATS, e.g. START, DELETE or EVACUATE.
+	PriorityControl
+
+	numPriorities
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityBulk:
+		return "bulk"
+	case PriorityNormal:
+		return "normal"
+	case PriorityControl:
+		return "control"
+	}
+
+	return ""
+}
+
+// Priority returns the priority class of a frame.
+func (f *Frame) Priority() Priority {
+	if f.Chunk != nil {
+		return PriorityBulk
+	}
+
+	switch f.Type {
+	case COMMAND:
+		if Command(f.Operand) == STATS {
+			return PriorityBulk
+		}
+		return PriorityControl
+	case EVENT:
+		if Event(f.Operand) == TraceReport {
+			return PriorityBulk
+		}
+	}
+
+	return PriorityNormal
+}
+
+func framePriority(frame interface{}) Priority {
+	if f, ok := frame.(*Frame); ok {
+		return f.Priority()
+	}
+
+	return PriorityControl
+}
+
+// sendQueue serializes the writers of a session. The writers waiting for
+// the session are granted it by priority class first, and then in the
+// order they started waiting.
+type sendQueue struct {
+	mutex   sync.Mutex
+	busy    bool
+	waiting [numPriorities][]chan struct{}
+}
+
+// acquire waits until the session can be written to by a writer of
+// priority p.
+func (q *sendQueue) acquire(p Priority) {
+	q.mutex.Lock()
+	if !q.busy {
+		q.busy = true
+		q.mutex.Unlock()
+		return
+	}
+
+	ready := make(chan struct{})
+	q.waiting[p] = append(q.waiting[p], ready)
+	q.mutex.Unlock()
+
+	<-ready
+}
+
+// release hands the session over to the next writer, if any.
+func (q *sendQueue) release() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for p := int(numPriorities) - 1; p >= 0; p-- {
+		if len(q.waiting[p]) > 0 {
+			ready := q.waiting[p][0]
+			q.waiting[p] = q.waiting[p][1:]
+			close(ready)
+			return
+		}
+	}
+
+	q.busy = false
+}
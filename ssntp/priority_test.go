@@ -0,0 +1,106 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"testing"
+	"time"
+)
+
+// Test the priority classes of frames.
+//
+// Test is expected to pass.
+func TestFramePriority(t *testing.T) {
+	var tests = []struct {
+		frame    Frame
+		priority Priority
+	}{
+		{Frame{Type: COMMAND, Operand: uint8(START)}, PriorityControl},
+		{Frame{Type: COMMAND, Operand: uint8(DELETE)}, PriorityControl},
+		{Frame{Type: COMMAND, Operand: uint8(EVACUATE)}, PriorityControl},
+		{Frame{Type: COMMAND, Operand: uint8(STATS)}, PriorityBulk},
+		{Frame{Type: STATUS, Operand: uint8(READY)}, PriorityNormal},
+		{Frame{Type: EVENT, Operand: uint8(InstanceDeleted)}, PriorityNormal},
+		{Frame{Type: EVENT, Operand: uint8(TraceReport)}, PriorityBulk},
+		{Frame{Type: ERROR, Operand: uint8(StartFailure)}, PriorityNormal},
+		{Frame{Type: COMMAND, Operand: uint8(START), Chunk: &FrameChunk{}}, PriorityBulk},
+	}
+
+	for _, test := range tests {
+		p := test.frame.Priority()
+		if p != test.priority {
+			t.Errorf("Expected %s priority for %s, got %s", test.priority, test.frame, p)
+		}
+	}
+
+	if framePriority(&ConnectFrame{}) != PriorityControl {
+		t.Errorf("Expected control priority for CONNECT")
+	}
+}
+
+func (q *sendQueue) queued() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	n := 0
+	for _, waiting := range q.waiting {
+		n += len(waiting)
+	}
+
+	return n
+}
+
+// Test that writers are granted a session by priority class.
+//
+// We acquire the send queue, queue two bulk writers, a normal one and a
+// control one, and then release the queue.
+//
+// Test is expected to pass if the control writer is granted the queue
+// first, then the normal one and then the bulk ones in the order they
+// were queued.
+func TestSendQueuePriority(t *testing.T) {
+	var q sendQueue
+	order := make(chan int, 4)
+	priorities := []Priority{PriorityBulk, PriorityBulk, PriorityNormal, PriorityControl}
+
+	q.acquire(PriorityBulk)
+
+	for i, p := range priorities {
+		go func(i int, p Priority) {
+			q.acquire(p)
+			order <- i
+			q.release()
+		}(i, p)
+
+		for q.queued() != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	q.release()
+
+	for _, expected := range []int{3, 2, 0, 1} {
+		select {
+		case i := <-order:
+			if i != expected {
+				t.Errorf("Expected writer %d, got %d", expected, i)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for writer")
+		}
+	}
+}
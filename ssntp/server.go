@@ -20,12 +20,17 @@ import (
 	"crypto/tls"
 	"encoding/gob"
 	"fmt"
+	"io"
 	"net"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ciao-project/ciao/configuration"
+	"github.com/ciao-project/ciao/payloads"
 	"github.com/ciao-project/ciao/uuid"
+	"gopkg.in/yaml.v2"
 )
 
 // ServerNotifier is the SSNTP server notification interface.
@@ -67,6 +72,7 @@ type Server struct {
 	ntf           ServerNotifier
 	sessionMutex  sync.RWMutex
 	sessions      map[string]*session
+	redirect      []byte
 	listenerMutex sync.Mutex
 	listener      net.Listener
 	stopped       boolFlag
@@ -76,11 +82,19 @@ type Server struct {
 
 	forwardRules frameForward
 
+	authorization frameAuthorization
+
 	log Logger
 
 	trace *TraceConfig
 
 	configuration clusterConfiguration
+
+	maxFrameSize int
+
+	features Feature
+
+	clock Clock
 }
 
 func sendConnectionFailure(conn net.Conn) *session {
@@ -106,12 +120,15 @@ func sendConnectionAborted(conn net.Conn) *session {
 func handleClientConnect(server *Server, conn net.Conn) *session {
 	var connect ConnectFrame
 
-	decoder := gob.NewDecoder(conn)
+	decoder := gob.NewDecoder(newFrameReader(conn, server.maxFrameSize))
 
 	server.log.Infof("Waiting for CONNECT\n")
 	setReadTimeout(conn)
 	readErr := decoder.Decode(&connect)
 	clearReadTimeout(conn)
+	if readErr == nil {
+		readErr = validateFrame(&connect)
+	}
 	if readErr != nil {
 		server.log.Errorf("Connect error: %s\n", readErr)
 		return sendConnectionFailure(conn)
@@ -133,8 +150,14 @@ func handleClientConnect(server *Server, conn net.Conn) *session {
 		return sendConnectionFailure(conn)
 	}
 
-	session := newSession(&server.uuid, server.role, connect.Role, conn)
+	if connect.Major&majorMask != Major {
+		server.log.Errorf("Unsupported SSNTP major version %d", connect.Major&majorMask)
+		return sendConnectionFailure(conn)
+	}
+
+	session := newSession(&server.uuid, server.role, connect.Role, conn, server.maxFrameSize, server.clock)
 	session.setDest(connect.Source[:16])
+	session.features = negotiateFeatures(connect.Features, server.features)
 
 	/* TODO Get the CONFIGURE payload from the config package */
 	server.configuration.RLock()
@@ -162,10 +185,16 @@ func handleSSNTPClient(server *Server, conn net.Conn) {
 	}
 
 	uuidString := session.dest.String()
-	server.addSession(session, uuidString)
+	redirect := server.addSession(session, uuidString)
 	server.forwardRules.addForwardDestination(session)
 	server.ntf.ConnectNotify(uuidString, session.destRole)
 
+	// Clients that connected while the server started draining
+	// were not redirected by Drain().
+	if redirect != nil {
+		server.redirectSession(session, redirect)
+	}
+
 	for {
 		var frame Frame
 		err := session.Read(&frame)
@@ -177,6 +206,12 @@ func handleSSNTPClient(server *Server, conn net.Conn) {
 			break
 		}
 
+		operand := frame.operand()
+		if server.authorization.authorized(session.destRole, operand) == false {
+			server.rejectFrame(session, operand, &frame)
+			continue
+		}
+
 		switch frame.Type {
 		case COMMAND:
 			if (Command)(frame.Operand) == CONFIGURE && session.destRole.IsController() {
@@ -203,10 +238,14 @@ func handleSSNTPClient(server *Server, conn net.Conn) {
 /*
  * SSNTP Server methods
  */
-func (server *Server) addSession(session *session, uuid string) {
+// addSession returns the REDIRECT payload of a draining server.
+func (server *Server) addSession(session *session, uuid string) []byte {
 	server.sessionMutex.Lock()
 	server.sessions[uuid] = session
+	redirect := server.redirect
 	server.sessionMutex.Unlock()
+
+	return redirect
 }
 
 func (server *Server) removeSession(uuid string) {
@@ -259,16 +298,32 @@ func (server *Server) Serve(config *Config, ntf ServerNotifier) error {
 
 	server.ntf = ntf
 	server.sessions = make(map[string]*session)
-	server.forwardRules.init(config.ForwardRules)
+	server.redirect = nil
+	if err := server.forwardRules.init(config.ForwardRules); err != nil {
+		server.log.Warningf("Ignoring configured forwarding rules: %s\n", err)
+	}
+	server.stoppedChan = make(chan struct{})
 	server.tls = prepareTLSConfig(config, true)
-	server.forwardRules.forwardRules = config.ForwardRules
+	if server.tls != nil {
+		err = server.rotateSessionTicketKeys(config.Rand, sessionTicketKeyLifetime,
+			config.SessionTicketKeyFile, server.stoppedChan)
+		if err != nil {
+			server.log.Errorf("Failed to generate session ticket key: %s\n", err)
+			config.pushToSyncChannel(err)
+			return err
+		}
+	}
+	server.authorization.init(config.AuthorizationRules)
 	server.trace = config.Trace
-	server.stoppedChan = make(chan struct{})
+	server.maxFrameSize = config.maxFrameSize()
+	server.features = config.features()
+	server.clock = config.clock()
 
 	service := fmt.Sprintf("%s:%d", uri, serverPort)
 	listener, err := tls.Listen(transport, service, server.tls)
 	if err != nil {
 		server.log.Errorf("Failed to start listener (err=%s) on %s\n", err, service)
+		close(server.stoppedChan)
 		config.pushToSyncChannel(err)
 		return err
 	}
@@ -356,6 +411,89 @@ func (server *Server) Stop() {
 	freeUUID(server.lUUID)
 }
 
+// Drain gracefully stops the server, e.g. before taking it down for
+// maintenance. It stops accepting new connections and sends a REDIRECT
+// status frame to all connected clients, asking them to reconnect to the
+// SSNTP server at uri. SSNTP clients close their connection when they
+// receive it and the server keeps notifying the frames they sent before.
+// Drain waits for all clients to disconnect for at most timeout, and returns
+// an error if some clients are still connected by then.
+// Stop must still be called to close any remaining connection and release
+// the server.
+func (server *Server) Drain(uri string, timeout time.Duration) error {
+	payload, err := yaml.Marshal(&payloads.Redirect{URI: uri})
+	if err != nil {
+		return err
+	}
+
+	deadline := time.After(timeout)
+
+	server.stopped.Lock()
+	server.stopped.flag = true
+	server.stopped.Unlock()
+
+	server.listenerMutex.Lock()
+	if server.listener != nil {
+		server.listener.Close()
+	}
+	server.listenerMutex.Unlock()
+
+	server.sessionMutex.Lock()
+	server.redirect = payload
+	sessions := make([]*session, 0, len(server.sessions))
+	for _, session := range server.sessions {
+		sessions = append(sessions, session)
+	}
+	server.sessionMutex.Unlock()
+
+	server.log.Infof("Draining %d clients to %s\n", len(sessions), uri)
+	for _, session := range sessions {
+		server.redirectSession(session, payload)
+	}
+
+	// No client connection is accepted once the main server thread
+	// is done, and clientWg can be waited for.
+	select {
+	case <-server.stoppedChan:
+		break
+	case <-deadline:
+		return fmt.Errorf("Timeout waiting for main server thread")
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		server.clientWg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-deadline:
+		return fmt.Errorf("%d clients still connected", len(server.Clients()))
+	}
+}
+
+// Draining returns true if the server is being or has been drained.
+func (server *Server) Draining() bool {
+	server.sessionMutex.RLock()
+	defer server.sessionMutex.RUnlock()
+
+	return server.redirect != nil
+}
+
+func (server *Server) redirectSession(session *session, payload []byte) {
+	uuid := session.dest.String()
+
+	frame := session.statusFrame(REDIRECT, payload, server.trace)
+	if _, err := session.Write(frame); err != nil {
+		server.log.Errorf("Could not redirect %s: %s\n", uuid, err)
+		return
+	}
+
+	server.log.Infof("Redirected %s\n", uuid)
+}
+
 func (server *Server) sendCommand(uuid string, cmd Command, payload []byte, trace *TraceConfig) (int, error) {
 	session := server.getSession(uuid)
 	if session == nil {
@@ -414,6 +552,45 @@ func (server *Server) SendEvent(uuid string, event Event, payload []byte) (int,
 	return server.sendEvent(uuid, event, payload, server.trace)
 }
 
+func (server *Server) sendStream(uuid string, payload io.Reader, newFrame func(session *session, chunk []byte) *Frame) (int, error) {
+	session := server.getSession(uuid)
+	if session == nil {
+		return -1, fmt.Errorf("Unknown UUID %s", uuid)
+	}
+
+	return session.writeStream(payload, func(chunk []byte) *Frame {
+		return newFrame(session, chunk)
+	})
+}
+
+// SendCommandStream sends a specific command to a client, with a payload
+// read from payload until EOF. The payload is split into as many frames as
+// needed for them not to exceed the maximum frame size, and can be rebuilt
+// by the client with a StreamAssembler.
+// The client is specified by its uuid.
+// It returns the number of payload bytes sent.
+func (server *Server) SendCommandStream(uuid string, cmd Command, payload io.Reader) (int, error) {
+	return server.sendStream(uuid, payload, func(session *session, chunk []byte) *Frame {
+		return session.commandFrame(cmd, chunk, server.trace)
+	})
+}
+
+// SendStatusStream sends a specific status to a client, with a payload
+// read from payload until EOF. See SendCommandStream.
+func (server *Server) SendStatusStream(uuid string, status Status, payload io.Reader) (int, error) {
+	return server.sendStream(uuid, payload, func(session *session, chunk []byte) *Frame {
+		return session.statusFrame(status, chunk, server.trace)
+	})
+}
+
+// SendEventStream sends a specific event to a client, with a payload
+// read from payload until EOF. See SendCommandStream.
+func (server *Server) SendEventStream(uuid string, event Event, payload io.Reader) (int, error) {
+	return server.sendStream(uuid, payload, func(session *session, chunk []byte) *Frame {
+		return session.eventFrame(event, chunk, server.trace)
+	})
+}
+
 // SendError sends an error back to a client.
 // The client is specified by its uuid
 func (server *Server) SendError(uuid string, error Error, payload []byte) (int, error) {
@@ -448,6 +625,17 @@ func (server *Server) SendTracedError(uuid string, error Error, payload []byte,
 	return server.sendError(uuid, error, payload, trace)
 }
 
+// SetForwardRules sets frame forwarding rules on top of the ones from
+// the server Config.ForwardRules, e.g. rules read from the cluster
+// configuration. They replace the rules from any previous call and
+// apply to the already connected clients as well.
+// SetForwardRules can be called before Serve. A rule for an operand
+// already covered by the server Config.ForwardRules is ignored, and an
+// error listing the ignored operands is returned.
+func (server *Server) SetForwardRules(rules []FrameForwardRule) error {
+	return server.forwardRules.setConfiguredRules(rules)
+}
+
 // UUID exports the SSNTP server Universally Unique ID.
 func (server *Server) UUID() string {
 	return server.uuid.String()
@@ -463,3 +651,77 @@ func (server *Server) ClientRole(uuid string) (Role, error) {
 	}
 	return session.destRole, nil
 }
+
+// ClientFeatures returns the optional features negotiated with the ssntp
+// session peer with the specified uuid.
+func (server *Server) ClientFeatures(uuid string) (Feature, error) {
+	session := server.getSession(uuid)
+	if session == nil {
+		return 0, fmt.Errorf("SSNTP session missing for uuid %s", uuid)
+	}
+	return session.features, nil
+}
+
+// ClientInfo describes an SSNTP client connected to a server.
+type ClientInfo struct {
+	// UUID is the UUID of the client.
+	UUID string
+
+	// Role is the role the client declared when connecting.
+	Role Role
+
+	// RemoteAddr is the network address of the client.
+	RemoteAddr string
+
+	// ConnectTime is the time at which the client connected.
+	ConnectTime time.Time
+
+	// LastActivity is the time of the last frame exchanged with the
+	// client. It is zero if no frame was exchanged since the connection.
+	LastActivity time.Time
+
+	// FramesSent and BytesSent count the frames sent to the client.
+	FramesSent uint64
+	BytesSent  uint64
+
+	// FramesReceived and BytesReceived count the frames received from
+	// the client.
+	FramesReceived uint64
+	BytesReceived  uint64
+
+	// Features are the optional features negotiated with the client.
+	Features Feature
+}
+
+// Clients returns the SSNTP clients currently connected to the server, in
+// the order they connected.
+func (server *Server) Clients() []ClientInfo {
+	server.sessionMutex.RLock()
+	clients := make([]ClientInfo, 0, len(server.sessions))
+	for uuid, session := range server.sessions {
+		client := ClientInfo{
+			UUID:           uuid,
+			Role:           session.destRole,
+			RemoteAddr:     session.conn.RemoteAddr().String(),
+			ConnectTime:    session.connectTime,
+			FramesSent:     atomic.LoadUint64(&session.stats.framesSent),
+			BytesSent:      atomic.LoadUint64(&session.stats.bytesSent),
+			FramesReceived: atomic.LoadUint64(&session.stats.framesReceived),
+			BytesReceived:  atomic.LoadUint64(&session.stats.bytesReceived),
+			Features:       session.features,
+		}
+
+		if lastActivity := atomic.LoadInt64(&session.stats.lastActivity); lastActivity != 0 {
+			client.LastActivity = time.Unix(0, lastActivity)
+		}
+
+		clients = append(clients, client)
+	}
+	server.sessionMutex.RUnlock()
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ConnectTime.Before(clients[j].ConnectTime)
+	})
+
+	return clients
+}
@@ -17,8 +17,14 @@
 package ssntp
 
 import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
+	"io"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/ciao-project/ciao/uuid"
@@ -40,7 +46,137 @@ func clearWriteTimeout(conn net.Conn) {
 	conn.SetWriteDeadline(time.Time{})
 }
 
+// ErrFrameTooLarge is returned when sending a frame larger than the
+// maximum frame size, and when such a frame is received.
+var ErrFrameTooLarge = errors.New("SSNTP frame too large")
+
+// frameWriter rejects gob messages larger than the maximum frame size.
+// gob encoders issue a single Write per message, so a rejected frame is
+// never partially written and the connection remains usable.
+type frameWriter struct {
+	w     io.Writer
+	max   int
+	bytes *uint64
+}
+
+func (fw *frameWriter) Write(p []byte) (int, error) {
+	if len(p) > fw.max {
+		return 0, ErrFrameTooLarge
+	}
+
+	n, err := fw.w.Write(p)
+	if fw.bytes != nil {
+		atomic.AddUint64(fw.bytes, uint64(n))
+	}
+
+	return n, err
+}
+
+// frameReader checks the length of each gob message before letting the
+// gob decoder read it, as the decoder would otherwise allocate as much
+// memory as the length the peer claims.
+type frameReader struct {
+	r         *bufio.Reader
+	max       int
+	remaining int
+	bytes     *uint64
+}
+
+func newFrameReader(r io.Reader, max int) *frameReader {
+	return &frameReader{
+		r:   bufio.NewReader(r),
+		max: max,
+	}
+}
+
+// messageLength parses the gob encoded length prefix of the next message
+// and returns the length of the message including its prefix.
+func (fr *frameReader) messageLength() (int, error) {
+	b, err := fr.r.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+
+	if b[0] < 0x80 {
+		return 1 + int(b[0]), nil
+	}
+
+	n := -int(int8(b[0]))
+	if n > 8 {
+		return 0, errors.New("SSNTP invalid frame length")
+	}
+
+	b, err = fr.r.Peek(1 + n)
+	if err != nil {
+		return 0, err
+	}
+
+	var length uint64
+	for _, c := range b[1:] {
+		length = length<<8 | uint64(c)
+	}
+
+	if length > uint64(fr.max) {
+		return 0, ErrFrameTooLarge
+	}
+
+	return 1 + n + int(length), nil
+}
+
+func (fr *frameReader) Read(p []byte) (int, error) {
+	if fr.remaining == 0 {
+		length, err := fr.messageLength()
+		if err != nil {
+			return 0, err
+		}
+
+		if length > fr.max {
+			return 0, ErrFrameTooLarge
+		}
+
+		fr.remaining = length
+	}
+
+	if len(p) > fr.remaining {
+		p = p[:fr.remaining]
+	}
+
+	n, err := fr.r.Read(p)
+	fr.remaining -= n
+	if fr.bytes != nil {
+		atomic.AddUint64(fr.bytes, uint64(n))
+	}
+
+	return n, err
+}
+
+// sessionStats counts the frames and bytes exchanged over a session.
+// The counters are updated atomically as they are read while the session
+// is in use, and are kept first in the session for 64-bit alignment.
+type sessionStats struct {
+	framesSent     uint64
+	framesReceived uint64
+	bytesSent      uint64
+	bytesReceived  uint64
+
+	// lastActivity is the time of the last frame sent or received, in
+	// nanoseconds since the epoch.
+	lastActivity int64
+}
+
+func (stats *sessionStats) frameSent() {
+	atomic.AddUint64(&stats.framesSent, 1)
+	atomic.StoreInt64(&stats.lastActivity, time.Now().UnixNano())
+}
+
+func (stats *sessionStats) frameReceived() {
+	atomic.AddUint64(&stats.framesReceived, 1)
+	atomic.StoreInt64(&stats.lastActivity, time.Now().UnixNano())
+}
+
 type session struct {
+	stats sessionStats
+
 	src      uuid.UUID
 	dest     uuid.UUID
 	srcRole  Role
@@ -49,12 +185,27 @@ type session struct {
 
 	encoder *gob.Encoder
 	decoder *gob.Decoder
+
+	maxFrameSize int
+	lastStream   uint32
+
+	// sendQueue orders concurrent writes by frame priority.
+	sendQueue sendQueue
+
+	// clock timestamps the traced frames sent and received.
+	clock Clock
+
+	connectTime time.Time
+
+	// features are the optional features offered by a client before
+	// connecting, and the ones negotiated with the peer once connected.
+	features Feature
 }
 
 /*
  * session methods
  */
-func newSession(src *uuid.UUID, srcRole Role, destRole Role, netConn net.Conn) *session {
+func newSession(src *uuid.UUID, srcRole Role, destRole Role, netConn net.Conn, maxFrameSize int, clock Clock) *session {
 	var session session
 
 	if src != nil {
@@ -65,12 +216,33 @@ func newSession(src *uuid.UUID, srcRole Role, destRole Role, netConn net.Conn) *
 	session.destRole = destRole
 
 	session.conn = netConn
-	session.encoder = gob.NewEncoder(netConn)
-	session.decoder = gob.NewDecoder(netConn)
+	session.maxFrameSize = maxFrameSize
+	session.clock = clock
+	session.connectTime = time.Now()
+	session.lastStream = randomStreamID()
+
+	fw := &frameWriter{w: netConn, max: maxFrameSize, bytes: &session.stats.bytesSent}
+	fr := newFrameReader(netConn, maxFrameSize)
+	fr.bytes = &session.stats.bytesReceived
+	session.encoder = gob.NewEncoder(fw)
+	session.decoder = gob.NewDecoder(fr)
 
 	return &session
 }
 
+// randomStreamID returns the random identifier from which the streams of a
+// session are numbered.  Stream identifiers would otherwise be reused when
+// a peer reconnects, and the chunks of its new streams mixed with the ones
+// of the streams it left incomplete.
+func randomStreamID() uint32 {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return uint32(time.Now().UnixNano())
+	}
+
+	return binary.LittleEndian.Uint32(b[:])
+}
+
 func (session *session) setDest(uuid []byte) {
 	copy(session.dest[:], uuid[:16])
 }
@@ -86,6 +258,7 @@ func (session *session) connectedFrame(serverRole Role, payload []byte) (f *Conn
 		Destination:   session.dest[:],
 		PayloadLength: (uint32)(len(payload)),
 		Payload:       payload,
+		Features:      session.features,
 	}
 
 	return
@@ -100,6 +273,7 @@ func (session *session) connectFrame() (f *ConnectFrame) {
 		Role:        session.srcRole,
 		Source:      session.src[:],
 		Destination: session.dest[:],
+		Features:    session.features,
 	}
 
 	return
@@ -174,24 +348,40 @@ func (session *session) errorFrame(error Error, payload []byte, trace *TraceConf
 }
 
 func (session *session) Write(frame interface{}) (int, error) {
+	session.sendQueue.acquire(framePriority(frame))
+	defer session.sendQueue.release()
+
 	switch f := frame.(type) {
 	case *Frame:
 		if f.PathTrace() == false {
 			break
 		}
 
-		f.Trace.Path[f.Trace.PathLength-1].TxTimestamp = time.Now()
+		f.Trace.Path[f.Trace.PathLength-1].TxTimestamp = session.clock.Now()
 	}
 
 	setWriteTimeout(session.conn)
 	err := session.encoder.Encode(frame)
 	clearWriteTimeout(session.conn)
+	if err == nil {
+		session.stats.frameSent()
+	}
 
 	return 0, err
 }
 
 func (session *session) Read(frame interface{}) error {
 	err := session.decoder.Decode(frame)
+	if err != nil {
+		return err
+	}
+
+	err = validateFrame(frame)
+	if err != nil {
+		return err
+	}
+
+	session.stats.frameReceived()
 
 	switch f := frame.(type) {
 	case *Frame:
@@ -202,7 +392,7 @@ func (session *session) Read(frame interface{}) error {
 		node := Node{
 			UUID:        session.src[:],
 			Role:        session.srcRole,
-			RxTimestamp: time.Now(),
+			RxTimestamp: session.clock.Now(),
 		}
 
 		f.Trace.Path = append(f.Trace.Path, node)
@@ -0,0 +1,42 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"net"
+	"testing"
+)
+
+// Test that the stream identifiers of sessions are not reused.
+//
+// Create two sessions on the same connection, as a client reconnecting
+// would, and check that their streams are not numbered from the same
+// identifier.
+//
+// Test is expected to pass.
+func TestSessionStreamIDs(t *testing.T) {
+	local, remote := net.Pipe()
+	defer func() { _ = local.Close() }()
+	defer func() { _ = remote.Close() }()
+
+	first := newSession(nil, AGENT, SERVER, local, DefaultMaxFrameSize, nil)
+	second := newSession(nil, AGENT, SERVER, local, DefaultMaxFrameSize, nil)
+
+	if first.lastStream == second.lastStream {
+		t.Fatalf("Sessions numbering streams from %d", first.lastStream)
+	}
+}
@@ -31,6 +31,7 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/ciao-project/ciao/uuid"
 	"github.com/golang/glog"
@@ -42,11 +43,13 @@ type Type uint8
 
 // Command is the SSNTP Command operand.
 // It can be CONNECT, START, STOP, STATS, EVACUATE, DELETE, RESTART,
-// AssignPublicIP, ReleasePublicIP, CONFIGURE, AttachVolume or RefreshCNCI.
+// AssignPublicIP, ReleasePublicIP, CONFIGURE, AttachVolume, RefreshCNCI,
+// PAUSE, UNPAUSE, NodePolicy, RESCUE, UNRESCUE, CONSOLE, REBOOT, GUESTAGENT,
+// CAPTURE, MIGRATE or UpdateMetadata.
 type Command uint8
 
 // Status is the SSNTP Status operand.
-// It can be CONNECTED, READY, FULL, OFFLINE or MAINTENANCE
+// It can be CONNECTED, READY, FULL, OFFLINE, MAINTENANCE, REDIRECT or DRAINING
 type Status uint8
 
 // Role describes the SSNTP role for the frame sender.
@@ -54,14 +57,16 @@ type Status uint8
 type Role uint32
 
 // Error is the SSNTP Error operand. It can be InvalidFrameType Error,
-// StartFailure, ConnectionFailure, DeleteFailure, ConnectionAborted or
-// InvalidConfiguration.
+// StartFailure, ConnectionFailure, DeleteFailure, ConnectionAborted,
+// InvalidConfiguration or UnauthorizedFrame.
 type Error uint8
 
 // Event is the SSNTP Event operand.
 // It can be TenantAdded, TenantRemoval, InstanceDeleted, InstanceStopped,
 // ConcentratorInstanceAdded, PublicIPAssigned, PublicIPUnassigned, TraceReport,
-// NodeConnected or NodeDisconnected
+// NodeConnected, NodeDisconnected, InstancesPreempted, DiskUsageAlert,
+// InstanceReachability, WatchdogFired, ConsoleOutput, GuestAgentResult,
+// PacketCaptureResult or MigrationStatus
 type Event uint8
 
 const (
@@ -95,10 +100,10 @@ const (
 	// frame:
 	//					   SSNTP CONNECT Command frame
 	//
-	//	+-------------------------------------------------------------+
-	//	| Major | Minor | Type  | Operand |          Role             |
-	//	|       |       | (0x0) |  (0x0)  | (bitmask of client roles) |
-	//	+-------------------------------------------------------------+
+	//	+----------------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |          Role             |      Features      |
+	//	|       |       | (0x0) |  (0x0)  | (bitmask of client roles) | (bitmask, 4 bytes) |
+	//	+----------------------------------------------------------------------------------+
 	CONNECT Command = iota
 
 	// START is a command that should reach CIAO agents for scheduling a new
@@ -233,6 +238,153 @@ const (
 	// tunnel information.
 	// The payload for this command contains the UIID of the CNCI to refresh.
 	RefreshCNCI
+
+	// PAUSE is a command sent to a CIAO CN Agent in order to freeze a running
+	// instance without losing its memory state. The PAUSE command payload
+	// contains an instance UUID and an agent UUID.
+	//
+	//                                         SSNTP PAUSE Command frame
+	//	+------------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload   |
+	//	|       |       | (0x0) |  (0xb)  |                 | instance and agent UUIDs |
+	//	+------------------------------------------------------------------------------+
+	PAUSE
+
+	// UNPAUSE is a command sent to a CIAO CN Agent in order to resume an
+	// instance that was previously frozen by a PAUSE command. The UNPAUSE
+	// command payload uses the same YAML schema as the PAUSE command one.
+	//
+	//                                         SSNTP UNPAUSE Command frame
+	//	+------------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload   |
+	//	|       |       | (0x0) |  (0xc)  |                 | instance and agent UUIDs |
+	//	+------------------------------------------------------------------------------+
+	UNPAUSE
+
+	// NodePolicy is a command sent by the Controller to the Scheduler with
+	// the scheduling weights and instance limits set by admins for compute
+	// and network nodes. The Scheduler applies them to the nodes when it
+	// picks the node an instance is started on.
+	//
+	// The NodePolicy command payload always includes the policies of all
+	// nodes and not only changes compared to the last NodePolicy command sent.
+	//
+	//                                         SSNTP NodePolicy Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0xd)  |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	NodePolicy
+
+	// RESCUE is a command sent to a CIAO CN Agent in order to reboot a running
+	// instance from a rescue volume. The original volumes of the instance stay
+	// attached so that they can be repaired from the rescue system. The RESCUE
+	// command payload contains an instance UUID, an agent UUID and the rescue
+	// volume.
+	//
+	//                                         SSNTP RESCUE Command frame
+	//	+------------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload   |
+	//	|       |       | (0x0) |  (0xe)  |                 | instance and agent UUIDs |
+	//	+------------------------------------------------------------------------------+
+	RESCUE
+
+	// UNRESCUE is a command sent to a CIAO CN Agent in order to reboot an
+	// instance that was previously rescued by a RESCUE command from its
+	// original boot volume. The UNRESCUE command payload uses the same YAML
+	// schema as the RESCUE command one, without the rescue volume.
+	//
+	//                                         SSNTP UNRESCUE Command frame
+	//	+------------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload   |
+	//	|       |       | (0x0) |  (0xf)  |                 | instance and agent UUIDs |
+	//	+------------------------------------------------------------------------------+
+	UNRESCUE
+
+	// CONSOLE is a command sent by the Controller to a CIAO CN Agent in
+	// order to open, write to or close an emergency serial console session
+	// with an instance. The output of the console is sent back to the
+	// Controller through ConsoleOutput events. The CONSOLE command payload
+	// contains an instance UUID, an agent UUID, a session UUID and the
+	// requested action.
+	//
+	//                                         SSNTP CONSOLE Command frame
+	//	+------------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload   |
+	//	|       |       | (0x0) |  (0x10) |                 | instance and agent UUIDs |
+	//	+------------------------------------------------------------------------------+
+	CONSOLE
+
+	// REBOOT is a command sent to a CIAO CN Agent in order to reboot a
+	// running instance in place, on the node it is running on. The REBOOT
+	// command payload contains an instance UUID, an agent UUID and the
+	// type of reboot, soft or hard.
+	//
+	//                                         SSNTP REBOOT Command frame
+	//	+------------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload   |
+	//	|       |       | (0x0) |  (0x11) |                 | instance and agent UUIDs |
+	//	+------------------------------------------------------------------------------+
+	REBOOT
+
+	// GUESTAGENT is a command sent by the Controller to a CIAO CN Agent in
+	// order to run an operation through the QEMU guest agent of an
+	// instance, e.g., run a command in its guest or freeze its filesystems.
+	// The result of the operation is sent back to the Controller through a
+	// GuestAgentResult event. The GUESTAGENT command payload contains an
+	// instance UUID, an agent UUID, a request UUID and the requested
+	// operation.
+	//
+	//                                         SSNTP GUESTAGENT Command frame
+	//	+------------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload   |
+	//	|       |       | (0x0) |  (0x12) |                 | instance and agent UUIDs |
+	//	+------------------------------------------------------------------------------+
+	GUESTAGENT
+
+	// CAPTURE is a command sent by the Controller to a CIAO CN Agent in
+	// order to capture the network traffic of an instance on its host
+	// network interface. The capture stops after a maximum size or
+	// duration and the captured packets are sent back to the Controller
+	// through a PacketCaptureResult event. The CAPTURE command payload
+	// contains an instance UUID, an agent UUID, a capture UUID and the
+	// limits of the capture.
+	//
+	//                                            SSNTP CAPTURE Command frame
+	//	+------------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload   |
+	//	|       |       | (0x0) |  (0x13) |                 | instance and agent UUIDs |
+	//	+------------------------------------------------------------------------------+
+	CAPTURE
+
+	// MIGRATE is a command sent by the Controller to the CIAO CN Agent
+	// running an instance in order to live migrate it to another node,
+	// on which a copy of the instance has been started to wait for its
+	// state. The progress of the migration is reported to the Controller
+	// through MigrationStatus events. The MIGRATE command payload contains
+	// an instance UUID, an agent UUID and the address on which the target
+	// node waits for the instance.
+	//
+	//                                            SSNTP MIGRATE Command frame
+	//	+------------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload   |
+	//	|       |       | (0x0) |  (0x14) |                 | instance and agent UUIDs |
+	//	+------------------------------------------------------------------------------+
+	MIGRATE
+
+	// UpdateMetadata is a command sent to a CIAO CN Agent in order to
+	// replace the cloud-init user data and meta data of a running
+	// instance. The CN Agent regenerates the config drive of the instance
+	// and optionally reboots it so that the guest reads the new data.
+	// The UpdateMetadata command payload contains an instance UUID, an
+	// agent UUID and the new user data and meta data.
+	//
+	//                                     SSNTP UpdateMetadata Command frame
+	//	+------------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload   |
+	//	|       |       | (0x0) |  (0x15) |                 | instance and agent UUIDs |
+	//	+------------------------------------------------------------------------------+
+	UpdateMetadata
 )
 
 const (
@@ -244,10 +396,10 @@ const (
 	//
 	//					 SSNTP CONNECTED Status frame
 	//
-	//	+--------------------------------------------------------------------------------------------------------------------+
-	//	| Major | Minor | Type  | Operand |         Role              | Server UUID | Client UUID | Payload | YAML formatted |
-	//	|       |       | (0x1) |  (0x0)  | (bitmask of server roles) |             |             |  Length |      payload   |
-	//	+--------------------------------------------------------------------------------------------------------------------+
+	//	+-----------------------------------------------------------------------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |         Role              | Server UUID | Client UUID | Payload | YAML formatted |      Features      |
+	//	|       |       | (0x1) |  (0x0)  | (bitmask of server roles) |             |             |  Length |      payload   | (bitmask, 4 bytes) |
+	//	+-----------------------------------------------------------------------------------------------------------------------------------------+
 	CONNECTED Status = iota
 
 	// READY is a status command CIAO agents send to the scheduler to notify them about
@@ -297,6 +449,31 @@ const (
 	//	|       |       | (0x1) |  (0x4)  |       (0x0)     |
 	//	+---------------------------------------------------+
 	MAINTENANCE
+
+	// REDIRECT is sent by a draining SSNTP server to its clients to ask them to
+	// disconnect and reconnect to another server. Its payload contains the URI of
+	// that server. Clients reconnect to it first from then on, falling back to their
+	// configured URIs.
+	//
+	//					 SSNTP REDIRECT Status frame
+	//
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x1) |  (0x5)  |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	REDIRECT
+
+	// DRAINING is used by agents to let the scheduler know that they are
+	// shutting down. A draining agent refuses new START commands and exits
+	// once the work it already accepted has completed.
+	//
+	//					 SSNTP DRAINING Status frame
+	//
+	//	+---------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length |
+	//	|       |       | (0x1) |  (0x6)  |       (0x0)     |
+	//	+---------------------------------------------------+
+	DRAINING
 )
 
 const (
@@ -447,6 +624,117 @@ const (
 	//	|       |       | (0x3) |  (0x2)  |                 | instance information  |
 	//	+---------------------------------------------------------------------------+
 	InstanceStopped
+
+	// InstancesPreempted events are sent by the Scheduler to notify the Controller
+	// that lower priority instances must be stopped to make room for a higher
+	// priority one. The Scheduler starts the higher priority instance once all
+	// preempted instances are reported as stopped or deleted.
+	//
+	//					 SSNTP InstancesPreempted Event frame
+	//
+	//	+----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload |
+	//	|       |       | (0x3) |  (0x9)  |                 |                        |
+	//	+----------------------------------------------------------------------------+
+	InstancesPreempted
+
+	// DiskUsageAlert events are sent by workload agents to notify the Controller
+	// that the ephemeral disk usage of an instance has crossed a threshold of
+	// the disk size requested by its workload.
+	//
+	//					 SSNTP DiskUsageAlert Event frame
+	//
+	//	+----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload |
+	//	|       |       | (0x3) |  (0xa)  |                 |                        |
+	//	+----------------------------------------------------------------------------+
+	DiskUsageAlert
+
+	// InstanceReachability events are sent by networking concentrator
+	// instances (CNCI) to report whether the instances on the subnets they
+	// serve answered their last reachability probe.
+	//
+	// The Scheduler must forward those events to the Controller.
+	//
+	//					 SSNTP InstanceReachability Event frame
+	//
+	//	+----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload |
+	//	|       |       | (0x3) |  (0xb)  |                 |                        |
+	//	+----------------------------------------------------------------------------+
+	InstanceReachability
+
+	// WatchdogFired events are sent by workload agents to notify the
+	// Controller that the watchdog device of an instance fired, i.e. that
+	// its guest stopped servicing it, and which action was applied.
+	//
+	//					 SSNTP WatchdogFired Event frame
+	//
+	//	+----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload |
+	//	|       |       | (0x3) |  (0xc)  |                 |                        |
+	//	+----------------------------------------------------------------------------+
+	WatchdogFired
+
+	// ConsoleOutput events are sent by workload agents to forward the
+	// output of the serial console of an instance to the Controller that
+	// opened a console session with it, and to notify it that the session
+	// was closed.
+	//
+	// The Scheduler must forward those events to the Controller.
+	//
+	//					 SSNTP ConsoleOutput Event frame
+	//
+	//	+----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload |
+	//	|       |       | (0x3) |  (0xd)  |                 |                        |
+	//	+----------------------------------------------------------------------------+
+	ConsoleOutput
+
+	// GuestAgentResult events are sent by workload agents to report the
+	// result of an operation requested by a GUESTAGENT command to the
+	// Controller.
+	//
+	// The Scheduler must forward those events to the Controller.
+	//
+	//					 SSNTP GuestAgentResult Event frame
+	//
+	//	+----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload |
+	//	|       |       | (0x3) |  (0xe)  |                 |                        |
+	//	+----------------------------------------------------------------------------+
+	GuestAgentResult
+
+	// PacketCaptureResult events are sent by workload agents to return
+	// the packets captured for a CAPTURE command to the Controller. As
+	// captures can exceed the maximum frame size, their payload is
+	// streamed when the peers support streams.
+	//
+	// The Scheduler must forward those events to the Controller.
+	//
+	//					 SSNTP PacketCaptureResult Event frame
+	//
+	//	+----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload |
+	//	|       |       | (0x3) |  (0xf)  |                 |                        |
+	//	+----------------------------------------------------------------------------+
+	PacketCaptureResult
+
+	// MigrationStatus events are sent by workload agents to report the
+	// progress of the live migration of an instance to the Controller:
+	// the target node sends one when it is ready to receive the instance
+	// and the source node sends one when the migration has completed or
+	// failed.
+	//
+	// The Scheduler must forward those events to the Controller.
+	//
+	//					 SSNTP MigrationStatus Event frame
+	//
+	//	+----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload |
+	//	|       |       | (0x3) |  (0x10) |                 |                        |
+	//	+----------------------------------------------------------------------------+
+	MigrationStatus
 )
 
 // SSNTP clients and servers can have one or several roles and are expected to declare their
@@ -532,14 +820,28 @@ const (
 	// UnassignPublicIPFailure is sent by the CNCI when a an external IP
 	// cannot be unassigned.
 	UnassignPublicIPFailure
+
+	// UnauthorizedFrame is sent by SSNTP servers when receiving a frame
+	// that the sender's role is not allowed to send, as defined by the
+	// server authorization rules.
+	UnauthorizedFrame
 )
 
 // Major is the SSNTP protocol major version
 const Major = 0
-const minor = 1
+const minor = 2
 const defaultURL = "localhost"
 const port = 8888
 const readTimeout = 30
+const sessionCacheSize = 16
+const defaultReconnectJitter = 2 * time.Second
+
+// DefaultMaxFrameSize is the default maximum size of SSNTP frames.
+const DefaultMaxFrameSize = 8 * 1024 * 1024
+
+// minFrameSize is the smallest supported maximum frame size, so that
+// frame headers and gob type descriptors always fit.
+const minFrameSize = 4096
 const writeTimeout = 30
 
 // UUIDPrefix is the default storage path for persistent UUIDs
@@ -585,6 +887,28 @@ func (command Command) String() string {
 		return "Restore"
 	case RefreshCNCI:
 		return "Refresh CNCI List"
+	case PAUSE:
+		return "PAUSE"
+	case UNPAUSE:
+		return "UNPAUSE"
+	case NodePolicy:
+		return "Node Policy"
+	case RESCUE:
+		return "RESCUE"
+	case UNRESCUE:
+		return "UNRESCUE"
+	case CONSOLE:
+		return "CONSOLE"
+	case REBOOT:
+		return "REBOOT"
+	case GUESTAGENT:
+		return "GUESTAGENT"
+	case CAPTURE:
+		return "CAPTURE"
+	case MIGRATE:
+		return "MIGRATE"
+	case UpdateMetadata:
+		return "UpdateMetadata"
 	}
 
 	return ""
@@ -602,6 +926,10 @@ func (status Status) String() string {
 		return "OFFLINE"
 	case MAINTENANCE:
 		return "MAINTENANCE"
+	case REDIRECT:
+		return "REDIRECT"
+	case DRAINING:
+		return "DRAINING"
 	}
 
 	return ""
@@ -629,6 +957,22 @@ func (status Event) String() string {
 		return "Node Connected"
 	case NodeDisconnected:
 		return "Node Disconnected"
+	case InstancesPreempted:
+		return "Instances Preempted"
+	case DiskUsageAlert:
+		return "Disk Usage Alert"
+	case InstanceReachability:
+		return "Instance Reachability"
+	case WatchdogFired:
+		return "Watchdog Fired"
+	case ConsoleOutput:
+		return "Console Output"
+	case GuestAgentResult:
+		return "Guest Agent Result"
+	case PacketCaptureResult:
+		return "Packet Capture Result"
+	case MigrationStatus:
+		return "Migration Status"
 	}
 
 	return ""
@@ -648,6 +992,8 @@ func (error Error) String() string {
 		return "SSNTP Connection aborted"
 	case InvalidConfiguration:
 		return "Cluster configuration is invalid"
+	case UnauthorizedFrame:
+		return "Unauthorized SSNTP frame"
 	}
 
 	return ""
@@ -836,6 +1182,11 @@ type Config struct {
 	// ForwardRules is optional and contains a list of frame forwarding rules.
 	ForwardRules []FrameForwardRule
 
+	// AuthorizationRules is optional and contains the role/operand
+	// authorization matrix SSNTP servers enforce on received frames.
+	// When not set, all frames are authorized.
+	AuthorizationRules []FrameAuthorizationRule
+
 	// Log is the SSNTP logging interface.
 	// If not set, only error messages will be logged.
 	// The SSNTP Log implementation provides a default logger.
@@ -866,6 +1217,37 @@ type Config struct {
 	// used by the underlying TLS session.  If Rand is nil, the default
 	// random number generator for the TLS package will be used.
 	Rand io.Reader
+
+	// ReconnectJitter is optional and only used by SSNTP clients.
+	// It is the maximum random delay a client waits for before trying
+	// to reconnect to a server it got disconnected from. This spreads
+	// reconnections over time when a server with many clients restarts.
+	// The default is 2 seconds. A negative value disables the delay.
+	ReconnectJitter time.Duration
+
+	// SessionTicketKeyFile is optional and only used by SSNTP servers.
+	// It is the path of the file the keys encrypting the TLS session
+	// tickets are stored in, so that clients can resume their TLS
+	// sessions after a server restart. The file must only be readable
+	// by the server. When not set, the keys are only kept in memory.
+	SessionTicketKeyFile string
+
+	// MaxFrameSize is optional and is the maximum size, in bytes, of
+	// the frames sent and received over SSNTP connections. Sending a
+	// larger frame fails with ErrFrameTooLarge and receiving one
+	// closes the connection. Larger payloads must be sent as streams.
+	// The default is DefaultMaxFrameSize.
+	MaxFrameSize int
+
+	// DisabledFeatures is optional and lists the supported optional
+	// features that the SSNTP client or server will not negotiate,
+	// e.g. to interoperate with a peer with a broken implementation
+	// of a feature.
+	DisabledFeatures Feature
+
+	// Clock is optional and is the source of the timestamps added to
+	// traced frames.  The default is SystemClock.
+	Clock Clock
 }
 
 // Logger is an interface for SSNTP users to define their own
@@ -984,9 +1366,10 @@ func prepareTLS(caPEM, certPEM []byte, server bool, rand io.Reader) *tls.Config
 	}
 
 	return &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		RootCAs:      certPool,
-		Rand:         rand,
+		Certificates:       []tls.Certificate{cert},
+		RootCAs:            certPool,
+		Rand:               rand,
+		ClientSessionCache: tls.NewLRUClientSessionCache(sessionCacheSize),
 	}
 }
 
@@ -1192,6 +1575,42 @@ func (config *Config) role() (Role, error) {
 	return role, nil
 }
 
+func (config *Config) reconnectJitter() time.Duration {
+	if config.ReconnectJitter == 0 {
+		return defaultReconnectJitter
+	}
+
+	if config.ReconnectJitter < 0 {
+		return 0
+	}
+
+	return config.ReconnectJitter
+}
+
+func (config *Config) maxFrameSize() int {
+	if config.MaxFrameSize <= 0 {
+		return DefaultMaxFrameSize
+	}
+
+	if config.MaxFrameSize < minFrameSize {
+		return minFrameSize
+	}
+
+	return config.MaxFrameSize
+}
+
+func (config *Config) features() Feature {
+	return SupportedFeatures &^ config.DisabledFeatures
+}
+
+func (config *Config) clock() Clock {
+	if config.Clock == nil {
+		return SystemClock
+	}
+
+	return config.Clock
+}
+
 func (config *Config) port() uint32 {
 	if config.Port != 0 {
 		return config.Port
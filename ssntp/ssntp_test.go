@@ -189,6 +189,8 @@ type ssntpClient struct {
 	cmdTracedChannel   chan string
 	cmdDurationChannel chan time.Duration
 	cmdDumpChannel     chan struct{}
+
+	unauthorizedChannel chan struct{}
 }
 
 func (client *ssntpClient) ConnectNotify() {
@@ -272,6 +274,10 @@ func (client *ssntpClient) ErrorNotify(error Error, frame *Frame) {
 	if client.errChannel != nil && bytes.Equal(frame.Payload, client.payload) == true {
 		client.errChannel <- error.String()
 	}
+
+	if client.unauthorizedChannel != nil && error == UnauthorizedFrame {
+		close(client.unauthorizedChannel)
+	}
 }
 
 func buildTestConfig(role Role) (*Config, error) {
@@ -1166,6 +1172,77 @@ func TestClientReconnect(t *testing.T) {
 	server.ssntp.Stop()
 }
 
+// Test SSNTP client reconnection jitter.
+//
+// Test that an SSNTP client configured with a reconnection jitter
+// eventually reconnects to a SSNTP server that restarts. As the
+// session ticket keys of the server are not persisted, the client
+// cannot resume its previous TLS session and goes through a full
+// handshake.
+//
+// Test is expected to pass.
+func TestClientReconnectJitter(t *testing.T) {
+	var server ssntpEchoServer
+	var client ssntpClient
+
+	server.t = t
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	client.t = t
+	clientConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	clientConfig.ReconnectJitter = 500 * time.Millisecond
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	client.connected = make(chan struct{})
+	client.disconnected = make(chan struct{})
+	err = client.ssntp.Dial(clientConfig, &client)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	select {
+	case <-client.connected:
+		break
+	case <-time.After(time.Second):
+		t.Fatalf("Did not receive the 1st connection notification")
+	}
+
+	server.ssntp.Stop()
+
+	select {
+	case <-client.disconnected:
+		break
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Did not receive the disconnection notification")
+	}
+
+	client.connected = make(chan struct{})
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	select {
+	case <-client.connected:
+		break
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Did not receive the 2nd connection notification")
+	}
+
+	client.ssntp.Close()
+	server.ssntp.Stop()
+}
+
 // Test SSNTP server Stop()
 //
 // Test that an SSNTP client properly receives its disconnection
@@ -1221,6 +1298,201 @@ func TestServerStop(t *testing.T) {
 	time.Sleep(500 * time.Millisecond)
 }
 
+// Test SSNTP server Drain()
+//
+// Test that an SSNTP client connected to a draining server is
+// redirected to another server, and that Drain() returns once
+// the client disconnected.
+//
+// Test is expected to pass.
+func TestServerDrain(t *testing.T) {
+	var server, standby ssntpEchoServer
+	var client ssntpClient
+	var role Role = AGENT
+
+	server.t = t
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	standby.t = t
+	standby.roleConnectChannel = make(chan string, 1)
+	standbyConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	standbyConfig.Port = 9998
+
+	client.t = t
+	clientConfig, err := buildTestConfig(role)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	clientConfig.ReconnectJitter = -1
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	err = standby.ssntp.ServeThreadSync(standbyConfig, &standby)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	client.connected = make(chan struct{})
+	err = client.ssntp.Dial(clientConfig, &client)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	select {
+	case <-client.connected:
+		break
+	case <-time.After(time.Second):
+		t.Fatalf("Did not receive the 1st connection notification")
+	}
+
+	client.connected = make(chan struct{})
+	client.disconnected = make(chan struct{})
+
+	err = server.ssntp.Drain(fmt.Sprintf("localhost:%d", standbyConfig.Port), 5*time.Second)
+	if err != nil {
+		t.Fatalf("Drain failed: %s", err)
+	}
+
+	if server.ssntp.Draining() == false || len(server.ssntp.Clients()) != 0 {
+		t.Fatalf("Server not drained")
+	}
+
+	select {
+	case <-client.disconnected:
+		break
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Did not receive the disconnection notification")
+	}
+
+	select {
+	case <-client.connected:
+		break
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not receive the 2nd connection notification")
+	}
+
+	select {
+	case clientRole := <-standby.roleConnectChannel:
+		if clientRole != role.String() {
+			t.Fatalf("Wrong client role %s connected to standby server", clientRole)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Client not redirected to standby server")
+	}
+
+	client.ssntp.Close()
+	standby.ssntp.Stop()
+	server.ssntp.Stop()
+}
+
+const failoverConfiguration = `configure:
+  scheduler:
+    storage_uri: /etc/ciao/configuration.yaml
+    uris:
+    - localhost:9997
+  storage:
+    ceph_id: ciao
+  controller:
+    compute_ca: /etc/pki/ciao/compute_ca.pem
+    compute_cert: /etc/pki/ciao/compute_key.pem
+    client_auth_ca_cert_path: /etc/pki/ciao/auth-CA.pem
+`
+
+// Test SSNTP client failover
+//
+// Test that an SSNTP client connects to the first scheduler listed
+// in the cluster configuration when its server goes away.
+//
+// Test is expected to pass.
+func TestClientFailover(t *testing.T) {
+	var server, standby ssntpEchoServer
+	var client ssntpClient
+	var role Role = AGENT
+
+	configPath := path.Join(tempCertPath, "failover.yaml")
+	err := ioutil.WriteFile(configPath, []byte(failoverConfiguration), 0644)
+	if err != nil {
+		t.Fatalf("Could not write configuration: %s", err)
+	}
+	defer os.Remove(configPath)
+
+	server.t = t
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	serverConfig.ConfigURI = "file://" + configPath
+
+	standby.t = t
+	standby.roleConnectChannel = make(chan string, 1)
+	standbyConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	standbyConfig.Port = 9997
+
+	client.t = t
+	clientConfig, err := buildTestConfig(role)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	clientConfig.ReconnectJitter = -1
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	client.connected = make(chan struct{})
+	err = client.ssntp.Dial(clientConfig, &client)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	select {
+	case <-client.connected:
+		break
+	case <-time.After(time.Second):
+		t.Fatalf("Did not receive the 1st connection notification")
+	}
+
+	err = standby.ssntp.ServeThreadSync(standbyConfig, &standby)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	client.connected = make(chan struct{})
+	server.ssntp.Stop()
+
+	select {
+	case <-client.connected:
+		break
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not receive the 2nd connection notification")
+	}
+
+	select {
+	case clientRole := <-standby.roleConnectChannel:
+		if clientRole != role.String() {
+			t.Fatalf("Wrong client role %s connected to standby server", clientRole)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Client did not fail over to standby server")
+	}
+
+	client.ssntp.Close()
+	standby.ssntp.Stop()
+}
+
 // Test SSNTP Command frame
 //
 // Test that an SSNTP client can send a Command frame to an echo
@@ -1283,6 +1555,86 @@ func TestCommand(t *testing.T) {
 	}
 }
 
+// Test SSNTP server clients listing
+//
+// Test that an SSNTP server lists a connected client along with
+// the frames it exchanged with it once a command has been echoed.
+//
+// Test is expected to pass.
+func TestServerClients(t *testing.T) {
+	var server ssntpEchoServer
+	var client ssntpClient
+
+	server.t = t
+	client.t = t
+	client.cmdChannel = make(chan string)
+	client.typeChannel = make(chan string)
+
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	clientConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	err = client.ssntp.Dial(clientConfig, &client)
+	if err != nil {
+		t.Fatalf("Failed to connect")
+	}
+
+	defer func() {
+		client.ssntp.Close()
+		server.ssntp.Stop()
+	}()
+
+	client.payload = []byte{'Y', 'A', 'M', 'L'}
+	client.ssntp.SendCommand(START, client.payload)
+
+	select {
+	case <-client.typeChannel:
+	case <-time.After(time.Second):
+		t.Fatalf("Did not receive the command notification")
+	}
+
+	select {
+	case <-client.cmdChannel:
+	case <-time.After(time.Second):
+		t.Fatalf("Did not receive the command notification")
+	}
+
+	clients := server.ssntp.Clients()
+	if len(clients) != 1 {
+		t.Fatalf("Expected 1 client, got %d", len(clients))
+	}
+
+	c := clients[0]
+	if c.UUID != client.ssntp.UUID() || c.Role != AGENT || c.RemoteAddr == "" {
+		t.Fatalf("Wrong client information %+v", c)
+	}
+
+	// the server sent the CONNECTED frame and the echoed command.
+	if c.FramesReceived != 1 || c.FramesSent != 2 ||
+		c.BytesReceived == 0 || c.BytesSent == 0 {
+		t.Fatalf("Wrong client counters %+v", c)
+	}
+
+	if c.ConnectTime.IsZero() || c.LastActivity.Before(c.ConnectTime) {
+		t.Fatalf("Wrong client timestamps %+v", c)
+	}
+
+	if c.Features != SupportedFeatures || client.ssntp.Features() != SupportedFeatures {
+		t.Fatalf("Expected features %s, got %s and %s", SupportedFeatures, c.Features, client.ssntp.Features())
+	}
+}
+
 // Test SSNTP Command traced frame label
 //
 // Test that an SSNTP client can send a traced Command frame to an echo
@@ -1472,14 +1824,7 @@ func TestDumpTracedCommand(t *testing.T) {
 	}
 }
 
-// Test SSNTP Command traced frame duration
-//
-// Test that an SSNTP client can send a traced Command frame to an echo
-// server and then receives it back consistently.
-// We test that the frame duration is not zero.
-//
-// Test is expected to pass.
-func TestCommandDuration(t *testing.T) {
+func tracedCommandDuration(t *testing.T, serverClock, clientClock Clock) (string, time.Duration) {
 	var server ssntpEchoFwderServer
 	var client ssntpClient
 
@@ -1491,11 +1836,13 @@ func TestCommandDuration(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Could not build a test config")
 	}
+	serverConfig.Clock = serverClock
 
 	clientConfig, err := buildTestConfig(AGENT)
 	if err != nil {
 		t.Fatalf("Could not build a test config")
 	}
+	clientConfig.Clock = clientClock
 
 	serverConfig.ForwardRules = []FrameForwardRule{
 		{
@@ -1532,14 +1879,47 @@ func TestCommandDuration(t *testing.T) {
 	client.ssntp.Close()
 	server.ssntp.Stop()
 
+	return check, duration
+}
+
+// Test SSNTP Command traced frame duration
+//
+// Test that an SSNTP client can send a traced Command frame to an echo
+// server and then receives it back consistently.
+// We test that the frame duration is one clock tick per frame
+// transmission and reception.
+//
+// Test is expected to pass.
+func TestCommandDuration(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now(), time.Millisecond)
+
+	check, duration := tracedCommandDuration(t, clock, clock)
+
 	/* We should get 3 nodes */
 	if check != string(3) {
 		t.Fatalf("Wrong number of nodes %s", check)
 	}
 
-	/* We should get a non zero duration */
-	if duration == 0 {
-		t.Fatalf("Zero duration")
+	/* client Tx, server Rx, server Tx and client Rx */
+	if duration != 3*time.Millisecond {
+		t.Fatalf("Wrong duration %v", duration)
+	}
+}
+
+// Test SSNTP Command traced frame duration with clock skew
+//
+// Test that the duration of a traced Command frame echoed back to
+// its sender does not depend on the clock of the server.
+//
+// Test is expected to pass.
+func TestCommandDurationSkew(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now(), time.Millisecond)
+	serverClock := testutil.NewSkewedClock(clock, -time.Hour)
+
+	_, duration := tracedCommandDuration(t, serverClock, clock)
+
+	if duration != 3*time.Millisecond {
+		t.Fatalf("Wrong duration %v", duration)
 	}
 }
 
@@ -1891,6 +2271,200 @@ func TestCmdFwd(t *testing.T) {
 	}
 }
 
+// Test SSNTP forwarding rules set at runtime
+//
+// Start an SSNTP server with a set of forwarding rules, an SSNTP
+// agent and an SSNTP Controller.
+// Then set additional forwarding rules and verify that the Controller
+// receives the frames sent by the agent as specified by the new rules,
+// and that a new rule can not override a server configuration one.
+//
+// Test is expected to pass.
+func TestSetForwardRules(t *testing.T) {
+	var server ssntpServer
+	var controller, agent ssntpClient
+	command := DELETE
+
+	server.t = t
+	serverConfig, err := buildTestConfig(SCHEDULER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	serverConfig.ForwardRules = []FrameForwardRule{
+		{
+			Operand: START,
+			Dest:    Controller,
+		},
+	}
+
+	controller.t = t
+	controller.cmdChannel = make(chan string)
+	controllerConfig, err := buildTestConfig(Controller)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	agent.t = t
+	agentConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	err = controller.ssntp.Dial(controllerConfig, &controller)
+	if err != nil {
+		t.Fatalf("Controller failed to connect")
+	}
+
+	err = agent.ssntp.Dial(agentConfig, &agent)
+	if err != nil {
+		t.Fatalf("Agent failed to connect")
+	}
+
+	err = server.ssntp.SetForwardRules([]FrameForwardRule{
+		{
+			Operand: START,
+			Dest:    AGENT,
+		},
+		{
+			Operand: command,
+			Dest:    Controller,
+		},
+	})
+	if err == nil {
+		t.Errorf("Expected an error for the START forwarding rule")
+	}
+
+	payload := []byte{'S', 'T', 'A', 'T', 'S'}
+	controller.payload = payload
+	agent.payload = payload
+	agent.ssntp.SendCommand(command, agent.payload)
+
+	check := <-controller.cmdChannel
+
+	agent.ssntp.Close()
+	controller.ssntp.Close()
+	server.ssntp.Stop()
+
+	if check != command.String() {
+		t.Fatalf("Did not receive the forwarded DELETE")
+	}
+}
+
+// Test SSNTP frame authorization
+//
+// Start an SSNTP server with an authorization rule only allowing
+// Controllers to send EVACUATE commands, and an SSNTP agent.
+// Then verify that the agent gets an UnauthorizedFrame error back
+// when sending an EVACUATE command.
+//
+// Test is expected to pass.
+func TestUnauthorizedCommand(t *testing.T) {
+	var server ssntpEchoServer
+	var agent ssntpClient
+
+	server.t = t
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	serverConfig.AuthorizationRules = []FrameAuthorizationRule{
+		{
+			Operand: EVACUATE,
+			Roles:   Controller,
+		},
+	}
+
+	agent.t = t
+	agent.unauthorizedChannel = make(chan struct{})
+	agentConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	err = agent.ssntp.Dial(agentConfig, &agent)
+	if err != nil {
+		t.Fatalf("Agent failed to connect")
+	}
+
+	agent.ssntp.SendCommand(EVACUATE, []byte{'E', 'V', 'A', 'C'})
+
+	select {
+	case <-agent.unauthorizedChannel:
+	case <-time.After(time.Second):
+		t.Errorf("Did not receive an UnauthorizedFrame error")
+	}
+
+	agent.ssntp.Close()
+	server.ssntp.Stop()
+}
+
+// Test SSNTP frame authorization
+//
+// Start an SSNTP server with an authorization rule only allowing
+// Controllers to send EVACUATE commands, and an SSNTP Controller.
+// Then verify that the EVACUATE command sent by the Controller is
+// processed by the server.
+//
+// Test is expected to pass.
+func TestAuthorizedCommand(t *testing.T) {
+	var server ssntpEchoServer
+	var controller ssntpClient
+
+	server.t = t
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	serverConfig.AuthorizationRules = []FrameAuthorizationRule{
+		{
+			Operand: EVACUATE,
+			Roles:   Controller,
+		},
+	}
+
+	controller.t = t
+	controller.cmdChannel = make(chan string)
+	controllerConfig, err := buildTestConfig(Controller)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	err = controller.ssntp.Dial(controllerConfig, &controller)
+	if err != nil {
+		t.Fatalf("Controller failed to connect")
+	}
+
+	controller.payload = []byte{'E', 'V', 'A', 'C'}
+	controller.ssntp.SendCommand(EVACUATE, controller.payload)
+
+	check := <-controller.cmdChannel
+
+	controller.ssntp.Close()
+	server.ssntp.Stop()
+
+	if check != EVACUATE.String() {
+		t.Fatalf("Did not receive the echoed EVACUATE")
+	}
+}
+
 const controllerUUID = "3390740c-dce9-48d6-b83a-a717417072ce"
 const agentUUID = "4481631c-dce9-48d6-b83a-a717417072ce"
 
@@ -2497,6 +3071,8 @@ func TestStatusStringer(t *testing.T) {
 		{FULL, "FULL"},
 		{OFFLINE, "OFFLINE"},
 		{MAINTENANCE, "MAINTENANCE"},
+		{REDIRECT, "REDIRECT"},
+		{DRAINING, "DRAINING"},
 	}
 
 	for _, test := range stringTests {
@@ -2541,6 +3117,7 @@ func TestErrorStringer(t *testing.T) {
 		{DeleteFailure, "Could not delete instance"},
 		{ConnectionAborted, "SSNTP Connection aborted"},
 		{InvalidConfiguration, "Cluster configuration is invalid"},
+		{UnauthorizedFrame, "Unauthorized SSNTP frame"},
 	}
 
 	for _, test := range stringTests {
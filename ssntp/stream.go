@@ -0,0 +1,197 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ciao-project/ciao/uuid"
+)
+
+// ErrStreamTooLarge is returned by StreamAssembler when buffering a
+// chunk would exceed its maximum size.
+var ErrStreamTooLarge = errors.New("SSNTP stream too large")
+
+// writeStream reads payload and sends it as a stream of frames built by
+// newFrame.  Each frame carries a chunk of at most half the maximum frame
+// size, leaving room for the frame header and trace.
+// Streams can only be sent to peers which negotiated FeatureStreams.
+func (session *session) writeStream(payload io.Reader, newFrame func(chunk []byte) *Frame) (int, error) {
+	if !session.features.Has(FeatureStreams) {
+		return 0, ErrFeatureNotSupported
+	}
+
+	size := session.maxFrameSize / 2
+	chunk := make([]byte, size)
+	next := make([]byte, size)
+	stream := atomic.AddUint32(&session.lastStream, 1)
+	sent := 0
+
+	n, err := io.ReadFull(payload, chunk)
+	for index := uint32(0); ; index++ {
+		var nextN int
+		last := true
+
+		switch err {
+		case nil:
+			nextN, err = io.ReadFull(payload, next)
+			last = err == io.EOF
+		case io.EOF, io.ErrUnexpectedEOF:
+		default:
+			return sent, err
+		}
+
+		frame := newFrame(chunk[:n])
+		frame.Chunk = &FrameChunk{
+			Stream: stream,
+			Index:  index,
+			Last:   last,
+		}
+
+		_, writeErr := session.Write(frame)
+		if writeErr != nil {
+			return sent, writeErr
+		}
+		sent += n
+
+		if last {
+			return sent, nil
+		}
+
+		chunk, next = next, chunk
+		n = nextN
+	}
+}
+
+type streamKey struct {
+	origin uuid.UUID
+	stream uint32
+}
+
+type streamChunks struct {
+	chunks map[uint32][]byte
+	size   int
+
+	// count is the number of chunks in the stream, or 0 until the
+	// last chunk is received.
+	count uint32
+
+	// end is one past the highest chunk index received.
+	end uint32
+}
+
+// StreamAssembler rebuilds the payloads sent with the SendCommandStream,
+// SendStatusStream and SendEventStream APIs from the frames carrying them.
+// Frames are not guaranteed to be notified in order, so chunks can be
+// added in any order and from several goroutines.
+type StreamAssembler struct {
+	lock    sync.Mutex
+	maxSize int
+	size    int
+	streams map[streamKey]*streamChunks
+}
+
+// NewStreamAssembler creates a StreamAssembler buffering at most maxSize
+// bytes of payload for the streams it is rebuilding.
+func NewStreamAssembler(maxSize int) *StreamAssembler {
+	return &StreamAssembler{
+		maxSize: maxSize,
+		streams: make(map[streamKey]*streamChunks),
+	}
+}
+
+func (a *StreamAssembler) drop(key streamKey, s *streamChunks) {
+	a.size -= s.size
+	delete(a.streams, key)
+}
+
+// Add adds the chunk carried by frame to its stream. Once all the chunks
+// of a stream have been added, the rebuilt payload is returned and
+// complete is true. The payload of a frame that is not part of a stream
+// is returned as is.
+// If the chunk is invalid or does not fit in the assembler, the whole
+// stream is dropped and an error is returned.
+func (a *StreamAssembler) Add(frame *Frame) (payload []byte, complete bool, err error) {
+	if frame.Chunk == nil {
+		return frame.Payload, true, nil
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	key := streamKey{origin: frame.Origin, stream: frame.Chunk.Stream}
+	s := a.streams[key]
+	if s == nil {
+		s = &streamChunks{chunks: make(map[uint32][]byte)}
+		a.streams[key] = s
+	}
+
+	index := frame.Chunk.Index
+	if _, ok := s.chunks[index]; ok {
+		a.drop(key, s)
+		return nil, false, fmt.Errorf("Duplicate chunk %d in stream %d", index, key.stream)
+	}
+
+	if (s.count != 0 && index >= s.count) || (frame.Chunk.Last && index+1 < s.end) {
+		a.drop(key, s)
+		return nil, false, fmt.Errorf("Chunk %d out of stream %d", index, key.stream)
+	}
+
+	if a.size+len(frame.Payload) > a.maxSize {
+		a.drop(key, s)
+		return nil, false, ErrStreamTooLarge
+	}
+
+	s.chunks[index] = frame.Payload
+	s.size += len(frame.Payload)
+	a.size += len(frame.Payload)
+	if index >= s.end {
+		s.end = index + 1
+	}
+	if frame.Chunk.Last {
+		s.count = index + 1
+	}
+
+	if s.count == 0 || uint32(len(s.chunks)) != s.count {
+		return nil, false, nil
+	}
+
+	payload = make([]byte, 0, s.size)
+	for i := uint32(0); i < s.count; i++ {
+		payload = append(payload, s.chunks[i]...)
+	}
+	a.drop(key, s)
+
+	return payload, true, nil
+}
+
+// Discard drops the incomplete streams sent by origin, e.g. when it
+// disconnects before completing them.
+func (a *StreamAssembler) Discard(origin uuid.UUID) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for key, s := range a.streams {
+		if key.origin == origin {
+			a.drop(key, s)
+		}
+	}
+}
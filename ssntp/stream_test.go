@@ -0,0 +1,450 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	. "github.com/ciao-project/ciao/ssntp"
+)
+
+const testMaxFrameSize = 4096
+
+// ssntpStreamServer rebuilds the command streams it receives and sends
+// them back as event streams.
+type ssntpStreamServer struct {
+	ssntp     Server
+	assembler *StreamAssembler
+	errors    chan error
+}
+
+func (server *ssntpStreamServer) ConnectNotify(uuid string, role Role) {
+}
+
+func (server *ssntpStreamServer) DisconnectNotify(uuid string, role Role) {
+}
+
+func (server *ssntpStreamServer) StatusNotify(uuid string, status Status, frame *Frame) {
+}
+
+func (server *ssntpStreamServer) CommandNotify(uuid string, command Command, frame *Frame) {
+	payload, complete, err := server.assembler.Add(frame)
+	if err != nil {
+		server.errors <- err
+		return
+	}
+
+	if complete {
+		_, err = server.ssntp.SendEventStream(uuid, TenantAdded, bytes.NewReader(payload))
+		if err != nil {
+			server.errors <- err
+		}
+	}
+}
+
+func (server *ssntpStreamServer) EventNotify(uuid string, event Event, frame *Frame) {
+}
+
+func (server *ssntpStreamServer) ErrorNotify(uuid string, error Error, frame *Frame) {
+}
+
+// ssntpStreamClient rebuilds the event streams it receives.
+type ssntpStreamClient struct {
+	ssntp        Client
+	assembler    *StreamAssembler
+	frames       chan *Frame
+	payloads     chan []byte
+	disconnected chan struct{}
+}
+
+func (client *ssntpStreamClient) ConnectNotify() {
+}
+
+func (client *ssntpStreamClient) DisconnectNotify() {
+	if client.disconnected != nil {
+		close(client.disconnected)
+		client.disconnected = nil
+	}
+}
+
+func (client *ssntpStreamClient) StatusNotify(status Status, frame *Frame) {
+}
+
+func (client *ssntpStreamClient) CommandNotify(command Command, frame *Frame) {
+	if client.frames != nil {
+		client.frames <- frame
+	}
+}
+
+func (client *ssntpStreamClient) EventNotify(event Event, frame *Frame) {
+	payload, complete, err := client.assembler.Add(frame)
+	if err == nil && complete {
+		client.payloads <- payload
+	}
+}
+
+func (client *ssntpStreamClient) ErrorNotify(error Error, frame *Frame) {
+}
+
+func testPayload(size int) []byte {
+	payload := make([]byte, size)
+	for i := range payload {
+		payload[i] = byte(i % 251)
+	}
+
+	return payload
+}
+
+// Test that frames larger than the maximum frame size are not sent
+//
+// Start an echo server and connect a client with a small maximum frame
+// size, send a command that does not fit in a frame and then a command
+// that does.
+//
+// Test is expected to pass.
+func TestSendFrameTooLarge(t *testing.T) {
+	var server ssntpEchoServer
+	var client ssntpClient
+
+	server.t = t
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	client.t = t
+	client.cmdChannel = make(chan string)
+	clientConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	clientConfig.MaxFrameSize = testMaxFrameSize
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer server.ssntp.Stop()
+
+	err = client.ssntp.Dial(clientConfig, &client)
+	if err != nil {
+		t.Fatalf("Client failed to connect")
+	}
+	defer client.ssntp.Close()
+
+	_, err = client.ssntp.SendCommand(START, testPayload(2*testMaxFrameSize))
+	if err != ErrFrameTooLarge {
+		t.Fatalf("Expected %v, got %v", ErrFrameTooLarge, err)
+	}
+
+	client.payload = testPayload(testMaxFrameSize / 2)
+	_, err = client.ssntp.SendCommand(START, client.payload)
+	if err != nil {
+		t.Fatalf("Could not send command: %v", err)
+	}
+
+	select {
+	case cmd := <-client.cmdChannel:
+		if cmd != START.String() {
+			t.Fatalf("Expected %s, got %s", START, cmd)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not receive the echoed command")
+	}
+}
+
+// Test that receiving a frame larger than the maximum frame size closes
+// the connection
+//
+// Start a server with a small maximum frame size and connect a client
+// with the default one, then send a command that does not fit in the
+// server frames.
+//
+// Test is expected to pass.
+func TestReceiveFrameTooLarge(t *testing.T) {
+	var server ssntpStreamServer
+	var client ssntpStreamClient
+
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	serverConfig.MaxFrameSize = testMaxFrameSize
+
+	client.frames = make(chan *Frame)
+	client.disconnected = make(chan struct{})
+	disconnected := client.disconnected
+	clientConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer server.ssntp.Stop()
+
+	err = client.ssntp.Dial(clientConfig, &client)
+	if err != nil {
+		t.Fatalf("Client failed to connect")
+	}
+	defer client.ssntp.Close()
+
+	_, err = client.ssntp.SendCommand(START, testPayload(2*testMaxFrameSize))
+	if err != nil {
+		t.Fatalf("Could not send command: %v", err)
+	}
+
+	select {
+	case <-disconnected:
+	case <-client.frames:
+		t.Fatalf("Oversized frame processed")
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Client not disconnected")
+	}
+}
+
+// Test payload streaming
+//
+// Start a server and connect a client with a small maximum frame size,
+// stream a command payload several times larger than the frames to the
+// server, which rebuilds it and streams it back to the client as an
+// event.
+//
+// Test is expected to pass.
+func TestStream(t *testing.T) {
+	var server ssntpStreamServer
+	var client ssntpStreamClient
+
+	server.assembler = NewStreamAssembler(DefaultMaxFrameSize)
+	server.errors = make(chan error, 1)
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	serverConfig.MaxFrameSize = testMaxFrameSize
+
+	client.assembler = NewStreamAssembler(DefaultMaxFrameSize)
+	client.payloads = make(chan []byte)
+	clientConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	clientConfig.MaxFrameSize = testMaxFrameSize
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer server.ssntp.Stop()
+
+	err = client.ssntp.Dial(clientConfig, &client)
+	if err != nil {
+		t.Fatalf("Client failed to connect")
+	}
+	defer client.ssntp.Close()
+
+	payload := testPayload(10*testMaxFrameSize + 1)
+	sent, err := client.ssntp.SendCommandStream(START, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("Could not stream command: %v", err)
+	}
+
+	if sent != len(payload) {
+		t.Fatalf("Expected %d bytes to be sent, got %d", len(payload), sent)
+	}
+
+	select {
+	case received := <-client.payloads:
+		if !bytes.Equal(received, payload) {
+			t.Fatalf("Streamed payload corrupted")
+		}
+	case err := <-server.errors:
+		t.Fatalf("Server could not rebuild stream: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not receive the streamed event")
+	}
+}
+
+// Test that streams are only sent when negotiated
+//
+// Start a server with streams disabled and connect a client, check that
+// streams were not negotiated and that the client can not stream a
+// command payload to the server.
+//
+// Test is expected to pass.
+func TestStreamNotNegotiated(t *testing.T) {
+	var server ssntpStreamServer
+	var client ssntpStreamClient
+
+	serverConfig, err := buildTestConfig(SERVER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	serverConfig.DisabledFeatures = FeatureStreams
+
+	clientConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer server.ssntp.Stop()
+
+	err = client.ssntp.Dial(clientConfig, &client)
+	if err != nil {
+		t.Fatalf("Client failed to connect")
+	}
+	defer client.ssntp.Close()
+
+	if client.ssntp.Features().Has(FeatureStreams) {
+		t.Fatalf("Streams negotiated: %s", client.ssntp.Features())
+	}
+
+	features, err := server.ssntp.ClientFeatures(client.ssntp.UUID())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if features.Has(FeatureStreams) {
+		t.Fatalf("Streams negotiated: %s", features)
+	}
+
+	_, err = client.ssntp.SendCommandStream(START, bytes.NewReader(testPayload(10)))
+	if err != ErrFeatureNotSupported {
+		t.Fatalf("Expected %v, got %v", ErrFeatureNotSupported, err)
+	}
+}
+
+func streamFrame(stream, index uint32, last bool, payload []byte) *Frame {
+	return &Frame{
+		Origin:  vectorOrigin,
+		Payload: payload,
+		Chunk: &FrameChunk{
+			Stream: stream,
+			Index:  index,
+			Last:   last,
+		},
+	}
+}
+
+// Test stream assembly
+//
+// Add the chunks of a stream out of order to a StreamAssembler and check
+// that the payload is rebuilt once all chunks are added, and that frames
+// which are not part of a stream are returned as is.
+//
+// Test is expected to pass.
+func TestStreamAssembler(t *testing.T) {
+	a := NewStreamAssembler(16)
+
+	frames := []*Frame{
+		streamFrame(1, 2, true, []byte("ef")),
+		streamFrame(1, 0, false, []byte("ab")),
+		streamFrame(2, 0, true, []byte("xyz")),
+		streamFrame(1, 1, false, []byte("cd")),
+	}
+	expected := []string{"", "", "xyz", "abcdef"}
+
+	for i, f := range frames {
+		payload, complete, err := a.Add(f)
+		if err != nil {
+			t.Fatalf("Could not add chunk %d: %v", i, err)
+		}
+
+		if complete != (expected[i] != "") || string(payload) != expected[i] {
+			t.Fatalf("Chunk %d: expected %q, got %q", i, expected[i], payload)
+		}
+	}
+
+	payload, complete, err := a.Add(&Frame{Payload: []byte("frame")})
+	if err != nil || !complete || string(payload) != "frame" {
+		t.Fatalf("Frame payload not returned as is")
+	}
+}
+
+// Test that invalid streams are dropped
+//
+// Add duplicate, out of stream and too large chunks to a StreamAssembler
+// and check that they are rejected, that their streams are dropped and
+// that the space they used is reclaimed.
+//
+// Test is expected to pass.
+func TestStreamAssemblerInvalid(t *testing.T) {
+	a := NewStreamAssembler(8)
+
+	invalidStreams := []struct {
+		name   string
+		frames []*Frame
+	}{
+		{
+			name: "duplicate",
+			frames: []*Frame{
+				streamFrame(1, 0, false, []byte("ab")),
+				streamFrame(1, 0, false, []byte("ab")),
+			},
+		},
+		{
+			name: "after last",
+			frames: []*Frame{
+				streamFrame(2, 1, true, []byte("ab")),
+				streamFrame(2, 2, false, []byte("ab")),
+			},
+		},
+		{
+			name: "last too early",
+			frames: []*Frame{
+				streamFrame(3, 3, false, []byte("ab")),
+				streamFrame(3, 1, true, []byte("ab")),
+			},
+		},
+		{
+			name: "too large",
+			frames: []*Frame{
+				streamFrame(4, 0, false, []byte("abcdef")),
+				streamFrame(4, 1, true, []byte("abcdef")),
+			},
+		},
+	}
+
+	for _, s := range invalidStreams {
+		last := len(s.frames) - 1
+		for _, f := range s.frames[:last] {
+			if _, _, err := a.Add(f); err != nil {
+				t.Fatalf("%s: could not add chunk: %v", s.name, err)
+			}
+		}
+
+		if _, _, err := a.Add(s.frames[last]); err == nil {
+			t.Fatalf("%s: invalid chunk added", s.name)
+		}
+	}
+
+	a.Add(streamFrame(5, 0, false, []byte("abcd")))
+	a.Discard(vectorOrigin)
+
+	payload, complete, err := a.Add(streamFrame(6, 0, true, []byte("abcdefgh")))
+	if err != nil || !complete || string(payload) != "abcdefgh" {
+		t.Fatalf("Dropped streams not reclaimed")
+	}
+}
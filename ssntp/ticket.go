@@ -0,0 +1,152 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// sessionTicketKeyLifetime is how long a server TLS session ticket key is
+// used before it is replaced.
+const sessionTicketKeyLifetime = time.Hour
+
+// nextSessionTicketKeys returns a new random session ticket key followed by
+// the current key, if any.  The new key is used to issue tickets while the
+// current one can still decrypt the tickets it issued.
+func nextSessionTicketKeys(keys [][32]byte, random io.Reader) ([][32]byte, error) {
+	if random == nil {
+		random = rand.Reader
+	}
+
+	var key [32]byte
+	if _, err := io.ReadFull(random, key[:]); err != nil {
+		return nil, err
+	}
+
+	next := [][32]byte{key}
+	if len(keys) > 0 {
+		next = append(next, keys[0])
+	}
+
+	return next, nil
+}
+
+// readSessionTicketKeys reads the session ticket keys stored in path.  No
+// keys are returned when path is empty or does not exist.
+func readSessionTicketKeys(path string) ([][32]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if len(data)%32 != 0 {
+		return nil, fmt.Errorf("%s is not a session ticket key file", path)
+	}
+
+	keys := make([][32]byte, len(data)/32)
+	for i := range keys {
+		copy(keys[i][:], data[i*32:])
+	}
+
+	return keys, nil
+}
+
+// writeSessionTicketKeys replaces the session ticket keys stored in path,
+// if any.  The keys are only readable by their owner.
+func writeSessionTicketKeys(path string, keys [][32]byte) error {
+	if path == "" {
+		return nil
+	}
+
+	data := make([]byte, 0, len(keys)*32)
+	for _, key := range keys {
+		data = append(data, key[:]...)
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// rotateSessionTicketKeys sets a random session ticket key on the TLS
+// configuration of the server and replaces it every lifetime until stop is
+// closed.  The tickets issued with a key remain valid for one more lifetime
+// after it is replaced, so that clients can resume their TLS sessions when
+// reconnecting to the same server.  When path is set the keys are stored
+// there on every rotation, and the key in use when the server stopped is
+// kept as the previous key on restart, so that clients can also resume
+// their sessions after a server restart.
+func (server *Server) rotateSessionTicketKeys(random io.Reader, lifetime time.Duration, path string, stop <-chan struct{}) error {
+	keys, err := readSessionTicketKeys(path)
+	if err != nil {
+		server.log.Warningf("Ignoring stored session ticket keys: %s\n", err)
+		keys = nil
+	}
+
+	keys, err = nextSessionTicketKeys(keys, random)
+	if err != nil {
+		return err
+	}
+	server.tls.SetSessionTicketKeys(keys)
+
+	if err := writeSessionTicketKeys(path, keys); err != nil {
+		server.log.Warningf("Failed to store session ticket keys: %s\n", err)
+	}
+
+	go func(config *tls.Config) {
+		ticker := time.NewTicker(lifetime)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			next, err := nextSessionTicketKeys(keys, random)
+			if err != nil {
+				server.log.Errorf("Failed to rotate session ticket key: %s\n", err)
+				continue
+			}
+
+			keys = next
+			config.SetSessionTicketKeys(keys)
+
+			if err := writeSessionTicketKeys(path, keys); err != nil {
+				server.log.Warningf("Failed to store session ticket keys: %s\n", err)
+			}
+		}
+	}(server.tls)
+
+	return nil
+}
@@ -0,0 +1,128 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Test the rotation of session ticket keys.
+//
+// Test that a new random key is put first and that only the previous key
+// is kept, and that the rotation fails when no random key can be read.
+//
+// Test is expected to pass.
+func TestNextSessionTicketKeys(t *testing.T) {
+	random := bytes.NewReader(bytes.Repeat([]byte{1, 2, 3}, 64))
+
+	keys, err := nextSessionTicketKeys(nil, random)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("Expected 1 key, got %d", len(keys))
+	}
+
+	first := keys[0]
+	for i := 0; i < 2; i++ {
+		previous := keys[0]
+
+		keys, err = nextSessionTicketKeys(keys, random)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 2 || keys[1] != previous || keys[0] == previous {
+			t.Fatalf("Unexpected keys after rotation %d: %v", i, keys)
+		}
+	}
+
+	if keys[0] == first || keys[1] == first {
+		t.Fatal("First key not dropped")
+	}
+
+	_, err = nextSessionTicketKeys(keys, bytes.NewReader(nil))
+	if err == nil {
+		t.Fatal("Expected rotation to fail without random data")
+	}
+}
+
+// Test the storage of session ticket keys.
+//
+// Test that no keys are read from a missing file, that the stored keys are
+// read back from a file only readable by its owner, and that a file which
+// does not hold whole keys is rejected.
+//
+// Test is expected to pass.
+func TestSessionTicketKeysFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssntp-ticket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "keys")
+
+	keys, err := readSessionTicketKeys(path)
+	if err != nil || keys != nil {
+		t.Fatalf("Expected no keys from missing file, got %v: %v", keys, err)
+	}
+
+	random := bytes.NewReader(bytes.Repeat([]byte{1, 2, 3}, 64))
+	keys, err = nextSessionTicketKeys(nil, random)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err = nextSessionTicketKeys(keys, random)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writeSessionTicketKeys(path, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("Expected key file mode 0600, got %v", fi.Mode().Perm())
+	}
+
+	stored, err := readSessionTicketKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stored, keys) {
+		t.Errorf("Expected stored keys %v, got %v", keys, stored)
+	}
+
+	err = ioutil.WriteFile(path, []byte("not a key"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = readSessionTicketKeys(path)
+	if err == nil {
+		t.Error("Expected truncated key file to be rejected")
+	}
+}
@@ -17,6 +17,7 @@
 package testutil
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
@@ -44,6 +45,7 @@ type SsntpTestClient struct {
 	AttachVolumeFailReason payloads.AttachVolumeFailureReason
 	traces                 []*ssntp.Frame
 	tracesLock             *sync.Mutex
+	clock                  ssntp.Clock
 
 	CmdChans        map[ssntp.Command]chan Result
 	CmdChansLock    *sync.Mutex
@@ -66,6 +68,14 @@ func (client *SsntpTestClient) Shutdown() {
 // field aides in debugging.  The role parameter is mandatory.  The uuid string
 // parameter allows tests to specify a known uuid for simpler tests.
 func NewSsntpTestClientConnection(name string, role ssntp.Role, uuid string) (*SsntpTestClient, error) {
+	return NewSsntpTestClientConnectionWithClock(name, role, uuid, nil)
+}
+
+// NewSsntpTestClientConnectionWithClock creates an SsntpTestClient timestamping
+// the traced frames it sends and receives with clock, and dials the server.
+// The system clock is used if clock is nil.
+func NewSsntpTestClientConnectionWithClock(name string, role ssntp.Role, uuid string,
+	clock ssntp.Clock) (*SsntpTestClient, error) {
 	if role == ssntp.UNKNOWN {
 		return nil, errors.New("no role specified")
 	}
@@ -85,12 +95,17 @@ func NewSsntpTestClientConnection(name string, role ssntp.Role, uuid string) (*S
 	openClientChans(client)
 	client.instancesLock = &sync.Mutex{}
 	client.tracesLock = &sync.Mutex{}
+	client.clock = clock
+	if client.clock == nil {
+		client.clock = ssntp.SystemClock
+	}
 
 	config := &ssntp.Config{
 		CAcert: ssntp.DefaultCACert,
 		Cert:   ssntp.RoleToDefaultCertName(role),
 		Log:    ssntp.Log,
 		UUID:   client.UUID,
+		Clock:  client.clock,
 	}
 
 	if err := client.Ssntp.Dial(config, client); err != nil {
@@ -341,7 +356,7 @@ func (client *SsntpTestClient) handleStart(payload []byte) Result {
 
 	if client.StartFail == true {
 		result.Err = errors.New(client.StartFailReason.String())
-		client.sendStartFailure(cmd.Start.InstanceUUID, client.StartFailReason)
+		client.sendStartFailure(cmd.Start.InstanceUUID, client.StartFailReason, cmd.Start.Restart)
 		go client.SendResultAndDelErrorChan(ssntp.StartFailure, result)
 		return result
 	}
@@ -390,6 +405,66 @@ func (client *SsntpTestClient) handleDelete(payload []byte) Result {
 	return result
 }
 
+func (client *SsntpTestClient) handleGuestAgent(payload []byte) Result {
+	var result Result
+	var cmd payloads.GuestAgent
+
+	err := yaml.Unmarshal(payload, &cmd)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.InstanceUUID = cmd.GuestAgent.InstanceUUID
+
+	return result
+}
+
+func (client *SsntpTestClient) handlePacketCapture(payload []byte) Result {
+	var result Result
+	var cmd payloads.PacketCapture
+
+	err := yaml.Unmarshal(payload, &cmd)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.InstanceUUID = cmd.PacketCapture.InstanceUUID
+
+	return result
+}
+
+func (client *SsntpTestClient) handleMigrate(payload []byte) Result {
+	var result Result
+	var cmd payloads.Migrate
+
+	err := yaml.Unmarshal(payload, &cmd)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.InstanceUUID = cmd.Migrate.InstanceUUID
+
+	return result
+}
+
+func (client *SsntpTestClient) handleUpdateMetadata(payload []byte) Result {
+	var result Result
+	var cmd payloads.UpdateMetadata
+
+	err := yaml.Unmarshal(payload, &cmd)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.InstanceUUID = cmd.UpdateMetadata.InstanceUUID
+
+	return result
+}
+
 func (client *SsntpTestClient) handleAttachVolume(payload []byte) Result {
 	var result Result
 	var cmd payloads.AttachVolume
@@ -424,9 +499,11 @@ func (client *SsntpTestClient) CommandNotify(command ssntp.Command, frame *ssntp
 	payload := frame.Payload
 
 	var result Result
+	var duration time.Duration
 
 	if frame.Trace != nil {
-		frame.SetEndStamp()
+		frame.SetEndStampAt(client.clock.Now())
+		duration, _ = frame.Duration()
 		client.tracesLock.Lock()
 		client.traces = append(client.traces, frame)
 		client.tracesLock.Unlock()
@@ -450,10 +527,23 @@ func (client *SsntpTestClient) CommandNotify(command ssntp.Command, frame *ssntp
 	case ssntp.AttachVolume:
 		result = client.handleAttachVolume(payload)
 
+	case ssntp.GUESTAGENT:
+		result = client.handleGuestAgent(payload)
+
+	case ssntp.CAPTURE:
+		result = client.handlePacketCapture(payload)
+
+	case ssntp.MIGRATE:
+		result = client.handleMigrate(payload)
+
+	case ssntp.UpdateMetadata:
+		result = client.handleUpdateMetadata(payload)
+
 	default:
 		fmt.Fprintf(os.Stderr, "client %s unhandled command %s\n", client.Role.String(), command.String())
 	}
 
+	result.Duration = duration
 	go client.SendResultAndDelCmdChan(command, result)
 }
 
@@ -616,6 +706,175 @@ func (client *SsntpTestClient) SendStoppedEvent(uuid string) {
 	go client.SendResultAndDelEventChan(ssntp.InstanceStopped, result)
 }
 
+// SendDiskUsageAlertEvent allows an SsntpTestClient to push an ssntp.DiskUsageAlert event frame
+func (client *SsntpTestClient) SendDiskUsageAlertEvent(uuid string, usageMB int, limitMB int, threshold int) {
+	var result Result
+
+	evt := payloads.DiskUsageAlertEvent{
+		InstanceUUID: uuid,
+		NodeUUID:     client.UUID,
+		UsageMB:      usageMB,
+		LimitMB:      limitMB,
+		Threshold:    threshold,
+	}
+
+	event := payloads.EventDiskUsageAlert{
+		DiskUsageAlert: evt,
+	}
+
+	y, err := yaml.Marshal(event)
+	if err != nil {
+		result.Err = err
+	} else {
+		_, err = client.Ssntp.SendEvent(ssntp.DiskUsageAlert, y)
+		if err != nil {
+			result.Err = err
+		}
+	}
+
+	go client.SendResultAndDelEventChan(ssntp.DiskUsageAlert, result)
+}
+
+// SendWatchdogFiredEvent allows an SsntpTestClient to push an ssntp.WatchdogFired event frame
+func (client *SsntpTestClient) SendWatchdogFiredEvent(uuid string, action payloads.WatchdogAction) {
+	var result Result
+
+	evt := payloads.WatchdogFiredEvent{
+		InstanceUUID: uuid,
+		NodeUUID:     client.UUID,
+		Action:       action,
+	}
+
+	event := payloads.EventWatchdogFired{
+		WatchdogFired: evt,
+	}
+
+	y, err := yaml.Marshal(event)
+	if err != nil {
+		result.Err = err
+	} else {
+		_, err = client.Ssntp.SendEvent(ssntp.WatchdogFired, y)
+		if err != nil {
+			result.Err = err
+		}
+	}
+
+	go client.SendResultAndDelEventChan(ssntp.WatchdogFired, result)
+}
+
+// SendConsoleOutputEvent allows an SsntpTestClient to push an ssntp.ConsoleOutput event frame
+func (client *SsntpTestClient) SendConsoleOutputEvent(uuid string, session string, output string, closed bool) {
+	var result Result
+
+	evt := payloads.ConsoleOutputEvent{
+		InstanceUUID: uuid,
+		SessionUUID:  session,
+		Output:       output,
+		Closed:       closed,
+	}
+
+	event := payloads.EventConsoleOutput{
+		ConsoleOutput: evt,
+	}
+
+	y, err := yaml.Marshal(event)
+	if err != nil {
+		result.Err = err
+	} else {
+		_, err = client.Ssntp.SendEvent(ssntp.ConsoleOutput, y)
+		if err != nil {
+			result.Err = err
+		}
+	}
+
+	go client.SendResultAndDelEventChan(ssntp.ConsoleOutput, result)
+}
+
+// SendGuestAgentResultEvent allows an SsntpTestClient to push an ssntp.GuestAgentResult event frame
+func (client *SsntpTestClient) SendGuestAgentResultEvent(uuid string, request string) {
+	var result Result
+
+	evt := payloads.GuestAgentResultEvent{
+		InstanceUUID: uuid,
+		RequestUUID:  request,
+		Stdout:       "4.13.0\n",
+	}
+
+	event := payloads.EventGuestAgentResult{
+		GuestAgentResult: evt,
+	}
+
+	y, err := yaml.Marshal(event)
+	if err != nil {
+		result.Err = err
+	} else {
+		_, err = client.Ssntp.SendEvent(ssntp.GuestAgentResult, y)
+		if err != nil {
+			result.Err = err
+		}
+	}
+
+	go client.SendResultAndDelEventChan(ssntp.GuestAgentResult, result)
+}
+
+// SendPacketCaptureResultEvent allows an SsntpTestClient to push an
+// ssntp.PacketCaptureResult event frame.  The event is streamed, as
+// launchers do.
+func (client *SsntpTestClient) SendPacketCaptureResultEvent(uuid string, capture string) {
+	var result Result
+
+	evt := payloads.PacketCaptureResultEvent{
+		InstanceUUID: uuid,
+		CaptureUUID:  capture,
+		Pcap:         "1MOyoQIABAAAAAAAAAAAAP//AAABAAAA",
+	}
+
+	event := payloads.EventPacketCaptureResult{
+		PacketCaptureResult: evt,
+	}
+
+	y, err := yaml.Marshal(event)
+	if err != nil {
+		result.Err = err
+	} else {
+		_, err = client.Ssntp.SendEventStream(ssntp.PacketCaptureResult, bytes.NewReader(y))
+		if err != nil {
+			result.Err = err
+		}
+	}
+
+	go client.SendResultAndDelEventChan(ssntp.PacketCaptureResult, result)
+}
+
+// SendMigrationStatusEvent allows an SsntpTestClient to push an
+// ssntp.MigrationStatus event frame
+func (client *SsntpTestClient) SendMigrationStatusEvent(uuid string, state payloads.MigrationState, address string) {
+	var result Result
+
+	evt := payloads.MigrationStatusEvent{
+		InstanceUUID: uuid,
+		NodeUUID:     client.UUID,
+		State:        state,
+		Address:      address,
+	}
+
+	event := payloads.EventMigrationStatus{
+		MigrationStatus: evt,
+	}
+
+	y, err := yaml.Marshal(event)
+	if err != nil {
+		result.Err = err
+	} else {
+		_, err = client.Ssntp.SendEvent(ssntp.MigrationStatus, y)
+		if err != nil {
+			result.Err = err
+		}
+	}
+
+	go client.SendResultAndDelEventChan(ssntp.MigrationStatus, result)
+}
+
 // SendTenantAddedEvent allows an SsntpTestClient to push an ssntp.TenantAdded event frame
 func (client *SsntpTestClient) SendTenantAddedEvent() {
 	var result Result
@@ -664,6 +923,18 @@ func (client *SsntpTestClient) SendPublicIPUnassignedEvent() {
 	go client.SendResultAndDelEventChan(ssntp.PublicIPUnassigned, result)
 }
 
+// SendInstanceReachabilityEvent allows an SsntpTestClient to push an ssntp.InstanceReachability event frame
+func (client *SsntpTestClient) SendInstanceReachabilityEvent() {
+	var result Result
+
+	_, err := client.Ssntp.SendEvent(ssntp.InstanceReachability, []byte(InstanceReachabilityYaml))
+	if err != nil {
+		result.Err = err
+	}
+
+	go client.SendResultAndDelEventChan(ssntp.InstanceReachability, result)
+}
+
 // SendConcentratorAddedEvent allows an SsntpTestClient to push an ssntp.ConcentratorInstanceAdded event frame
 func (client *SsntpTestClient) SendConcentratorAddedEvent(instanceUUID string, tenantUUID string, ip string, vnicMAC string) {
 	var result Result
@@ -693,10 +964,12 @@ func (client *SsntpTestClient) SendConcentratorAddedEvent(instanceUUID string, t
 	go client.SendResultAndDelEventChan(ssntp.ConcentratorInstanceAdded, result)
 }
 
-func (client *SsntpTestClient) sendStartFailure(instanceUUID string, reason payloads.StartFailureReason) {
+func (client *SsntpTestClient) sendStartFailure(instanceUUID string, reason payloads.StartFailureReason, restart bool) {
 	e := payloads.ErrorStartFailure{
+		NodeUUID:     client.UUID,
 		InstanceUUID: instanceUUID,
 		Reason:       reason,
+		Restart:      restart,
 	}
 
 	y, err := yaml.Marshal(e)
@@ -729,6 +1002,7 @@ func (client *SsntpTestClient) sendDeleteFailure(instanceUUID string, reason pay
 
 func (client *SsntpTestClient) sendAttachVolumeFailure(instanceUUID string, volumeUUID string, reason payloads.AttachVolumeFailureReason) {
 	e := payloads.ErrorAttachVolumeFailure{
+		NodeUUID:     client.UUID,
 		InstanceUUID: instanceUUID,
 		VolumeUUID:   volumeUUID,
 		Reason:       reason,
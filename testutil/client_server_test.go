@@ -36,6 +36,10 @@ var agent *SsntpTestClient
 var netAgent *SsntpTestClient
 var cnciAgent *SsntpTestClient
 
+// clock is shared by the test server and clients so that the durations of
+// traced frames do not depend on the load of the machine running the tests.
+var clock = NewFakeClock(time.Now(), time.Millisecond)
+
 func TestSendAgentStatus(t *testing.T) {
 	serverCh := server.AddStatusChan(ssntp.READY)
 
@@ -77,7 +81,7 @@ func TestCNCIStart(t *testing.T) {
 	controllerCh := controller.AddEventChan(ssntp.ConcentratorInstanceAdded)
 
 	// start CNCI agent
-	cnciAgent, err = NewSsntpTestClientConnection("CNCI Client", ssntp.CNCIAGENT, CNCIUUID)
+	cnciAgent, err = NewSsntpTestClientConnectionWithClock("CNCI Client", ssntp.CNCIAGENT, CNCIUUID, clock)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -182,7 +186,7 @@ func TestStartTraced(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	_, err = agent.GetCmdChanResult(agentCh, ssntp.START)
+	result, err := agent.GetCmdChanResult(agentCh, ssntp.START)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -190,6 +194,51 @@ func TestStartTraced(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+
+	// controller Tx, server Rx, server Tx and agent Rx each take a tick
+	if result.Duration != 3*time.Millisecond {
+		t.Fatalf("Wrong START duration %v, expected %v", result.Duration, 3*time.Millisecond)
+	}
+}
+
+func TestStartTracedSkew(t *testing.T) {
+	skew := time.Hour
+	skewedController, err := NewSsntpTestControllerConnectionWithClock("Skewed Controller Client",
+		uuid.Generate().String(), NewSkewedClock(clock, skew))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer skewedController.Shutdown()
+
+	agentCh := agent.AddCmdChan(ssntp.START)
+	serverCh := server.AddCmdChan(ssntp.START)
+
+	traceConfig := &ssntp.TraceConfig{
+		PathTrace: true,
+		Start:     time.Now(),
+		Label:     []byte("testutilSkewedTracedSTART"),
+	}
+
+	_, err = skewedController.Ssntp.SendTracedCommand(ssntp.START, []byte(StartYaml), traceConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := agent.GetCmdChanResult(agentCh, ssntp.START)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = server.GetCmdChanResult(serverCh, ssntp.START)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the controller clock being ahead, the START frame arrives before
+	// it was sent
+	expected := 3*time.Millisecond - skew
+	if result.Duration != expected {
+		t.Fatalf("Wrong START duration %v, expected %v", result.Duration, expected)
+	}
 }
 
 func TestSendTrace(t *testing.T) {
@@ -432,7 +481,7 @@ func restartServer() error {
 	agentCh := agent.AddEventChan(ssntp.NodeConnected)
 	cnciAgentCh := cnciAgent.AddEventChan(ssntp.NodeConnected)
 
-	server = StartTestServer()
+	server = StartTestServerWithClock(clock)
 
 	//MUST be after StartTestServer becase the channels are initialized on start
 	serverCh := server.AddEventChan(ssntp.NodeConnected)
@@ -524,23 +573,23 @@ func TestMain(m *testing.M) {
 	flag.Parse()
 
 	// start server
-	server = StartTestServer()
+	server = StartTestServerWithClock(clock)
 
 	// start controller
 	controllerUUID := uuid.Generate().String()
-	controller, err = NewSsntpTestControllerConnection("Controller Client", controllerUUID)
+	controller, err = NewSsntpTestControllerConnectionWithClock("Controller Client", controllerUUID, clock)
 	if err != nil {
 		os.Exit(1)
 	}
 
 	// start agent
-	agent, err = NewSsntpTestClientConnection("AGENT Client", ssntp.AGENT, AgentUUID)
+	agent, err = NewSsntpTestClientConnectionWithClock("AGENT Client", ssntp.AGENT, AgentUUID, clock)
 	if err != nil {
 		os.Exit(1)
 	}
 
 	// start netagent
-	netAgent, err = NewSsntpTestClientConnection("NETAGENT Client", ssntp.NETAGENT, NetAgentUUID)
+	netAgent, err = NewSsntpTestClientConnectionWithClock("NETAGENT Client", ssntp.NETAGENT, NetAgentUUID, clock)
 	if err != nil {
 		os.Exit(1)
 	}
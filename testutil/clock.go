@@ -0,0 +1,78 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package testutil
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ciao-project/ciao/ssntp"
+)
+
+// FakeClock is an ssntp.Clock whose time only moves forward by a fixed
+// step each time it is read, making the durations of traced SSNTP frames
+// independent of the load of the machine running the tests.
+type FakeClock struct {
+	sync.Mutex
+	now  time.Time
+	step time.Duration
+}
+
+// NewFakeClock creates a FakeClock starting at start and advancing by
+// step after each call to Now.
+func NewFakeClock(start time.Time, step time.Duration) *FakeClock {
+	return &FakeClock{
+		now:  start,
+		step: step,
+	}
+}
+
+// Now returns the current time of the clock and advances it by its step.
+func (c *FakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.now
+	c.now = c.now.Add(c.step)
+
+	return now
+}
+
+// Advance moves the clock forward by d.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Lock()
+	c.now = c.now.Add(d)
+	c.Unlock()
+}
+
+type skewedClock struct {
+	clock ssntp.Clock
+	skew  time.Duration
+}
+
+func (c skewedClock) Now() time.Time {
+	return c.clock.Now().Add(c.skew)
+}
+
+// NewSkewedClock returns an ssntp.Clock reading the time of clock offset
+// by skew.  It simulates SSNTP nodes whose clocks are not synchronized.
+func NewSkewedClock(clock ssntp.Clock, skew time.Duration) ssntp.Clock {
+	return skewedClock{
+		clock: clock,
+		skew:  skew,
+	}
+}
@@ -51,6 +51,14 @@ func (ctl *SsntpTestController) Shutdown() {
 // SsntpTestClient.Name field aides in debugging.  The uuid string
 // parameter allows tests to specify a known uuid for simpler tests.
 func NewSsntpTestControllerConnection(name string, uuid string) (*SsntpTestController, error) {
+	return NewSsntpTestControllerConnectionWithClock(name, uuid, nil)
+}
+
+// NewSsntpTestControllerConnectionWithClock creates an SsntpTestController
+// timestamping the traced frames it sends and receives with clock, and dials
+// the server.  The system clock is used if clock is nil.
+func NewSsntpTestControllerConnectionWithClock(name string, uuid string,
+	clock ssntp.Clock) (*SsntpTestController, error) {
 	if uuid == "" {
 		return nil, errors.New("no uuid specified")
 	}
@@ -72,6 +80,7 @@ func NewSsntpTestControllerConnection(name string, uuid string) (*SsntpTestContr
 		Cert:   ssntp.RoleToDefaultCertName(ssntp.Controller),
 		Log:    ssntp.Log,
 		UUID:   ctl.UUID,
+		Clock:  clock,
 	}
 
 	if err := ctl.Ssntp.Dial(config, ctl); err != nil {
@@ -323,6 +332,56 @@ func (ctl *SsntpTestController) EventNotify(event ssntp.Event, frame *ssntp.Fram
 		if err != nil {
 			result.Err = err
 		}
+	case ssntp.DiskUsageAlert:
+		var diskUsageAlertEvent payloads.EventDiskUsageAlert
+
+		err := yaml.Unmarshal(frame.Payload, &diskUsageAlertEvent)
+		if err != nil {
+			result.Err = err
+		}
+	case ssntp.WatchdogFired:
+		var watchdogFiredEvent payloads.EventWatchdogFired
+
+		err := yaml.Unmarshal(frame.Payload, &watchdogFiredEvent)
+		if err != nil {
+			result.Err = err
+		}
+	case ssntp.ConsoleOutput:
+		var consoleOutputEvent payloads.EventConsoleOutput
+
+		err := yaml.Unmarshal(frame.Payload, &consoleOutputEvent)
+		if err != nil {
+			result.Err = err
+		}
+	case ssntp.GuestAgentResult:
+		var guestAgentResultEvent payloads.EventGuestAgentResult
+
+		err := yaml.Unmarshal(frame.Payload, &guestAgentResultEvent)
+		if err != nil {
+			result.Err = err
+		}
+	case ssntp.PacketCaptureResult:
+		var packetCaptureResultEvent payloads.EventPacketCaptureResult
+
+		// test captures are small enough to be streamed in one chunk
+		err := yaml.Unmarshal(frame.Payload, &packetCaptureResultEvent)
+		if err != nil {
+			result.Err = err
+		}
+	case ssntp.MigrationStatus:
+		var migrationStatusEvent payloads.EventMigrationStatus
+
+		err := yaml.Unmarshal(frame.Payload, &migrationStatusEvent)
+		if err != nil {
+			result.Err = err
+		}
+	case ssntp.InstanceReachability:
+		var reachabilityEvent payloads.EventInstanceReachability
+
+		err := yaml.Unmarshal(frame.Payload, &reachabilityEvent)
+		if err != nil {
+			result.Err = err
+		}
 	default:
 		fmt.Fprintf(os.Stderr, "controller unhandled event: %s\n", event.String())
 	}
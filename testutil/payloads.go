@@ -104,6 +104,22 @@ const AgentUUID = "4cb19522-1e18-439a-883a-f9b2a3a95f5e"
 // VolumeUUID is a node UUID for storage tests
 const VolumeUUID = "67d86208-b46c-4465-9018-e14187d4010"
 
+// ConsoleSessionUUID is a console session UUID for console tests
+const ConsoleSessionUUID = "9d3a1c5e-6f2b-4e8a-b7d4-1c0e5f9a2b63"
+
+// GuestAgentRequestUUID is a request UUID for guest agent tests
+const GuestAgentRequestUUID = "5e0c2b7a-3d4f-4a1b-9c8e-2f6d7a1b0c94"
+
+// CaptureUUID is a capture UUID for packet capture tests
+const CaptureUUID = "8b1f4c2d-7e3a-4d5b-a6c9-0e2f1d3b5a78"
+
+// TargetAgentUUID is a node UUID for live migration tests
+const TargetAgentUUID = "d2a6f0e4-9b3c-4f1a-8e7d-5c4b3a291f06"
+
+// MigrationAddress is the address on which the target node of live
+// migration tests waits for the instance
+const MigrationAddress = "10.2.3.5:49152"
+
 // User is a user under which non-privileged ciao processes should run.
 const User = "ciao"
 
@@ -207,6 +223,110 @@ const StopYaml = `stop:
   stop: false
 `
 
+// PauseYaml is a sample workload PAUSE ssntp.Command payload for test cases
+const PauseYaml = `pause:
+  instance_uuid: ` + InstanceUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+`
+
+// SequencedPauseYaml is a sample workload PAUSE ssntp.Command payload for test
+// cases, sent before SequencedStopYaml
+const SequencedPauseYaml = `pause:
+  instance_uuid: ` + InstanceUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+  sequence: 1
+`
+
+// UnpauseYaml is a sample workload UNPAUSE ssntp.Command payload for test cases
+const UnpauseYaml = `unpause:
+  instance_uuid: ` + InstanceUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+`
+
+// RescueYaml is a sample workload RESCUE ssntp.Command payload for test cases
+const RescueYaml = `rescue:
+  instance_uuid: ` + InstanceUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+  volume:
+    id: ` + VolumeUUID + `
+    boot: true
+    rescue: true
+`
+
+// UnrescueYaml is a sample workload UNRESCUE ssntp.Command payload for test cases
+const UnrescueYaml = `unrescue:
+  instance_uuid: ` + InstanceUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+`
+
+// RebootYaml is a sample workload REBOOT ssntp.Command payload for test cases
+const RebootYaml = `reboot:
+  instance_uuid: ` + InstanceUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+  type: hard
+`
+
+// UpdatedUserData is the user data of UpdateMetadataYaml
+const UpdatedUserData = `#cloud-config
+ssh_authorized_keys:
+  - ssh-rsa AAAA rotated@ciao
+`
+
+// UpdatedMetaData is the meta data of UpdateMetadataYaml
+const UpdatedMetaData = `{"uuid": "` + InstanceUUID + `", "hostname": "ciao"}`
+
+// UpdateMetadataYaml is a sample workload UpdateMetadata ssntp.Command payload
+// for test cases
+const UpdateMetadataYaml = `update_metadata:
+  instance_uuid: ` + InstanceUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+  user_data: |
+    #cloud-config
+    ssh_authorized_keys:
+      - ssh-rsa AAAA rotated@ciao
+  meta_data: '` + UpdatedMetaData + `'
+  reboot: true
+`
+
+// ConsoleYaml is a sample workload CONSOLE ssntp.Command payload for test cases
+const ConsoleYaml = `console:
+  instance_uuid: ` + InstanceUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+  session_uuid: ` + ConsoleSessionUUID + `
+  action: input
+  input: |
+    root
+`
+
+// GuestAgentYaml is a sample workload GUESTAGENT ssntp.Command payload for test cases
+const GuestAgentYaml = `guest_agent:
+  instance_uuid: ` + InstanceUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+  request_uuid: ` + GuestAgentRequestUUID + `
+  operation: exec
+  path: /bin/uname
+  args:
+  - -r
+`
+
+// PacketCaptureYaml is a sample workload CAPTURE ssntp.Command payload for test cases
+const PacketCaptureYaml = `packet_capture:
+  instance_uuid: ` + InstanceUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+  capture_uuid: ` + CaptureUUID + `
+  max_bytes: 1048576
+  max_seconds: 60
+  filter: icmp or arp
+`
+
+// LiveMigrateYaml is a sample workload MIGRATE ssntp.Command payload for test cases
+const LiveMigrateYaml = `migrate:
+  instance_uuid: ` + InstanceUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+  target_agent_uuid: ` + TargetAgentUUID + `
+  target_address: ` + MigrationAddress + `
+`
+
 // DeleteYaml is a sample workload DELETE ssntp.Command payload for test cases
 const DeleteYaml = `delete:
   instance_uuid: ` + InstanceUUID + `
@@ -214,6 +334,15 @@ const DeleteYaml = `delete:
   stop: false
 `
 
+// SequencedStopYaml is a sample workload DELETE ssntp.Command payload for test
+// cases that stops an instance, sent after SequencedPauseYaml
+const SequencedStopYaml = `delete:
+  instance_uuid: ` + InstanceUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+  stop: true
+  sequence: 2
+`
+
 // MigrateYaml is a sample workload DELETE ssntp.Command payload for test cases
 // that indicates that an instance is to be migrated rather than deleted.
 const MigrateYaml = `delete:
@@ -293,6 +422,15 @@ const UnassignedIPYaml = `public_ip_unassigned:
   private_ip: ` + InstancePrivateIP + `
 `
 
+// InstanceReachabilityYaml is a sample InstanceReachability ssntp.Event payload for test cases
+const InstanceReachabilityYaml = `instance_reachability:
+  concentrator_uuid: ` + CNCIUUID + `
+  tenant_uuid: ` + TenantUUID + `
+  ips:
+  - ip: ` + InstancePrivateIP + `
+    reachable: true
+`
+
 // TenantAddedYaml is a sample TenantAdded ssntp.Event payload for test cases
 const TenantAddedYaml = `tenant_added:
   agent_uuid: ` + AgentUUID + `
@@ -361,6 +499,74 @@ const InsStopYaml = `instance_stopped:
   instance_uuid: ` + InstanceUUID + `
 `
 
+// InsPreemptedYaml is a sample InstancesPreempted ssntp.Event payload for test cases
+const InsPreemptedYaml = `instances_preempted:
+  instance_uuid: ` + InstanceUUID + `
+  node_uuid: ` + AgentUUID + `
+  preempted:
+  - ` + CNCIInstanceUUID + `
+`
+
+// NodePolicyYaml is a sample NodePolicy ssntp.Command payload for test cases
+const NodePolicyYaml = `node_policies:
+- node_uuid: ` + AgentUUID + `
+  weight: 50
+  max_instances: 10
+- node_uuid: ` + NetAgentUUID + `
+  weight: 100
+  allow_cnci_colocation: true
+`
+
+// DiskUsageAlertYaml is a sample DiskUsageAlert ssntp.Event payload for test cases
+const DiskUsageAlertYaml = `disk_usage_alert:
+  instance_uuid: ` + InstanceUUID + `
+  node_uuid: ` + AgentUUID + `
+  usage_mb: 850
+  limit_mb: 1000
+  threshold: 80
+`
+
+// ConsoleOutputYaml is a sample ConsoleOutput ssntp.Event payload for test cases
+const ConsoleOutputYaml = `console_output:
+  instance_uuid: ` + InstanceUUID + `
+  session_uuid: ` + ConsoleSessionUUID + `
+  output: 'login: '
+`
+
+// GuestAgentResultYaml is a sample GuestAgentResult ssntp.Event payload for test cases
+const GuestAgentResultYaml = `guest_agent_result:
+  instance_uuid: ` + InstanceUUID + `
+  request_uuid: ` + GuestAgentRequestUUID + `
+  exit_code: 1
+  stdout: |
+    4.13.0
+  stderr: |
+    warning
+`
+
+// PacketCaptureResultYaml is a sample PacketCaptureResult ssntp.Event payload for test cases
+const PacketCaptureResultYaml = `packet_capture_result:
+  instance_uuid: ` + InstanceUUID + `
+  capture_uuid: ` + CaptureUUID + `
+  truncated: true
+  pcap: 1MOyoQIABAAAAAAAAAAAAP//AAABAAAA
+`
+
+// MigrationStatusYaml is a sample MigrationStatus ssntp.Event payload for test cases
+const MigrationStatusYaml = `migration_status:
+  instance_uuid: ` + InstanceUUID + `
+  node_uuid: ` + TargetAgentUUID + `
+  state: incoming
+  address: ` + MigrationAddress + `
+`
+
+// WatchdogFiredYaml is a sample WatchdogFired ssntp.Event payload for test cases
+const WatchdogFiredYaml = `watchdog_fired:
+  instance_uuid: ` + InstanceUUID + `
+  node_uuid: ` + AgentUUID + `
+  action: reset
+`
+
 // NodeConnectedYaml is a sample node NodeConnected ssntp.Event payload for test cases
 const NodeConnectedYaml = `node_connected:
   node_uuid: ` + AgentUUID + `
@@ -424,6 +630,7 @@ var InstanceStat002 = payloads.InstanceStat{
 	CPUUsage:      0,
 	SSHIP:         "172.168.2.2",
 	SSHPort:       8768,
+	BootTimeMS:    2500,
 }
 
 // InstanceStat003 is a sample payloads.InstanceStat
@@ -434,6 +641,12 @@ var InstanceStat003 = payloads.InstanceStat{
 	DiskUsageMB:   2,
 	CPUUsage:      -1,
 	Volumes:       []string{VolumeUUID},
+	VolumeUsage: []payloads.VolumeStat{
+		{
+			VolumeUUID: VolumeUUID,
+			UsedMB:     12,
+		},
+	},
 }
 
 // NetworkStat001 is a sample payloads.NetworkStat
@@ -503,6 +716,7 @@ instances:
   disk_usage_mb: 10
   cpu_usage: 0
   volumes: []
+  boot_time_ms: 2500
 - instance_uuid: 1f5b2fe6-4493-4561-904a-8f4e956218d9
   state: exited
   ssh_ip: ""
@@ -512,6 +726,9 @@ instances:
   cpu_usage: -1
   volumes:
   - 67d86208-b46c-4465-9018-e14187d4010
+  volume_usage:
+  - volume_uuid: 67d86208-b46c-4465-9018-e14187d4010
+    used_mb: 12
 `
 
 // NodeOnlyStatsYaml is a sample minimal node STATS ssntp.Command payload for test cases
@@ -553,3 +770,19 @@ instance_uuid: ` + InstanceUUID + `
 volume_uuid: ` + VolumeUUID + `
 reason: attach_failure
 `
+
+// UnauthorizedFrameYaml is a sample UnauthorizedFrame ssntp.Error payload for test cases
+const UnauthorizedFrameYaml = `node_uuid: ` + AgentUUID + `
+role: CNAgent
+type: COMMAND
+operand: EVACUATE
+reason: unauthorized_role
+`
+
+// RedirectURI is the URI of the server clients are redirected to in
+// RedirectYaml
+const RedirectURI = "192.168.0.2:8888"
+
+// RedirectYaml is a sample REDIRECT ssntp.Status payload for test cases
+const RedirectYaml = `uri: ` + RedirectURI + `
+`
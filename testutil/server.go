@@ -368,7 +368,6 @@ func (server *SsntpTestServer) CommandNotify(uuid string, command ssntp.Command,
 	case CONNECT:
 	case AssignPublicIP:
 	case ReleasePublicIP:
-	case CONFIGURE:
 	*/
 	case ssntp.START:
 		getStartResults(payload, &result)
@@ -383,12 +382,118 @@ func (server *SsntpTestServer) CommandNotify(uuid string, command ssntp.Command,
 			server.Ssntp.SendCommand(delCmd.Delete.WorkloadAgentUUID, command, frame.Payload)
 		}
 
+	case ssntp.PAUSE:
+		var pauseCmd payloads.Pause
+
+		err := yaml.Unmarshal(payload, &pauseCmd)
+		result.Err = err
+		if err == nil {
+			result.InstanceUUID = pauseCmd.Pause.InstanceUUID
+		}
+
+	case ssntp.UNPAUSE:
+		var unpauseCmd payloads.Unpause
+
+		err := yaml.Unmarshal(payload, &unpauseCmd)
+		result.Err = err
+		if err == nil {
+			result.InstanceUUID = unpauseCmd.Unpause.InstanceUUID
+		}
+
+	case ssntp.RESCUE:
+		var rescueCmd payloads.Rescue
+
+		err := yaml.Unmarshal(payload, &rescueCmd)
+		result.Err = err
+		if err == nil {
+			result.InstanceUUID = rescueCmd.Rescue.InstanceUUID
+		}
+
+	case ssntp.UNRESCUE:
+		var unrescueCmd payloads.Unrescue
+
+		err := yaml.Unmarshal(payload, &unrescueCmd)
+		result.Err = err
+		if err == nil {
+			result.InstanceUUID = unrescueCmd.Unrescue.InstanceUUID
+		}
+
+	case ssntp.REBOOT:
+		var rebootCmd payloads.Reboot
+
+		err := yaml.Unmarshal(payload, &rebootCmd)
+		result.Err = err
+		if err == nil {
+			result.InstanceUUID = rebootCmd.Reboot.InstanceUUID
+		}
+
+	case ssntp.CONSOLE:
+		var consoleCmd payloads.Console
+
+		err := yaml.Unmarshal(payload, &consoleCmd)
+		result.Err = err
+		if err == nil {
+			result.InstanceUUID = consoleCmd.Console.InstanceUUID
+		}
+
+	case ssntp.CAPTURE:
+		var captureCmd payloads.PacketCapture
+
+		err := yaml.Unmarshal(payload, &captureCmd)
+		result.Err = err
+		if err == nil {
+			result.InstanceUUID = captureCmd.PacketCapture.InstanceUUID
+		}
+
+	case ssntp.UpdateMetadata:
+		var updateCmd payloads.UpdateMetadata
+
+		err := yaml.Unmarshal(payload, &updateCmd)
+		result.Err = err
+		if err == nil {
+			result.InstanceUUID = updateCmd.UpdateMetadata.InstanceUUID
+		}
+
+	case ssntp.MIGRATE:
+		var migrateCmd payloads.Migrate
+
+		err := yaml.Unmarshal(payload, &migrateCmd)
+		result.Err = err
+		if err == nil {
+			result.InstanceUUID = migrateCmd.Migrate.InstanceUUID
+			result.NodeUUID = migrateCmd.Migrate.WorkloadAgentUUID
+		}
+
+	case ssntp.GUESTAGENT:
+		var guestAgentCmd payloads.GuestAgent
+
+		err := yaml.Unmarshal(payload, &guestAgentCmd)
+		result.Err = err
+		if err == nil {
+			result.InstanceUUID = guestAgentCmd.GuestAgent.InstanceUUID
+		}
+
 	case ssntp.EVACUATE:
 		getEvacuateResults(payload, &result)
 
 	case ssntp.Restore:
 		getRestoreResults(payload, &result)
 
+	case ssntp.NodePolicy:
+		var policies payloads.NodePolicies
+
+		err := yaml.Unmarshal(payload, &policies)
+		result.Err = err
+		if err == nil && len(policies.Policies) > 0 {
+			result.NodeUUID = policies.Policies[0].NodeUUID
+		}
+
+	case ssntp.CONFIGURE:
+		var conf payloads.Configure
+
+		err := yaml.Unmarshal(payload, &conf)
+		result.Err = err
+
 	case ssntp.STATS:
 		var statsCmd payloads.Stat
 
@@ -605,6 +710,13 @@ func (server *SsntpTestServer) Shutdown() {
 // StartTestServer starts a go routine for based on a
 // testutil.SsntpTestServer configuration with standard ssntp.FrameRorwardRules
 func StartTestServer() *SsntpTestServer {
+	return StartTestServerWithClock(nil)
+}
+
+// StartTestServerWithClock starts a go routine for a test server
+// timestamping the traced frames it forwards with clock.  The system
+// clock is used if clock is nil.
+func StartTestServerWithClock(clock ssntp.Clock) *SsntpTestServer {
 	server := new(SsntpTestServer)
 	server.clientsLock = &sync.Mutex{}
 	server.netClientsLock = &sync.Mutex{}
@@ -619,6 +731,7 @@ func StartTestServer() *SsntpTestServer {
 		CAcert: ssntp.DefaultCACert,
 		Cert:   ssntp.RoleToDefaultCertName(ssntp.SERVER),
 		Log:    ssntp.Log,
+		Clock:  clock,
 		ForwardRules: []ssntp.FrameForwardRule{
 			{ // all STATS commands go to all Controllers
 				Operand: ssntp.STATS,
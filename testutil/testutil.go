@@ -16,6 +16,8 @@
 
 package testutil
 
+import "time"
+
 // Result is a common result structure for tests spanning between
 // controller client, scheduler server, and the various (eg: Agent,
 // NetAgent, CNCIAgent) agent roles.
@@ -26,4 +28,5 @@ type Result struct {
 	TenantUUID   string
 	CNCI         bool
 	VolumeUUID   string
+	Duration     time.Duration
 }